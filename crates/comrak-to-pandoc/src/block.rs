@@ -5,18 +5,21 @@
  * Convert comrak block nodes to Pandoc blocks.
  */
 
+use crate::context::{ConvertContext, Dialect};
 use crate::inline::convert_children_to_inlines_with_source;
 use crate::source_location::SourceLocationContext;
 use crate::{empty_attr, empty_source_info};
 use comrak::arena_tree::Node;
 use comrak::nodes::{
-    Ast, ListDelimType, ListType, NodeCodeBlock, NodeHeading, NodeList, NodeValue,
+    Ast, ListDelimType, ListType, NodeCodeBlock, NodeHeading, NodeList, NodeTable, NodeValue,
+    TableAlignment,
 };
 use hashlink::LinkedHashMap;
 use quarto_pandoc_types::{
-    AttrSourceInfo, Block, BlockQuote, Blocks, BulletList, CodeBlock, ConfigValue, Header,
-    HorizontalRule, ListAttributes, ListNumberDelim, ListNumberStyle, OrderedList, Pandoc,
-    Paragraph, Plain,
+    Alignment, AttrSourceInfo, Block, BlockQuote, Blocks, BulletList, Caption, Cell, CodeBlock,
+    ColWidth, ConfigValue, Header, HorizontalRule, Inline, ListAttributes, ListNumberDelim,
+    ListNumberStyle, OrderedList, Pandoc, Paragraph, Plain, RawBlock, Row, Space, Str, Table,
+    TableBody, TableFoot, TableHead,
 };
 use quarto_source_map::SourceInfo;
 use std::cell::RefCell;
@@ -48,11 +51,31 @@ pub fn convert_document<'a>(root: &'a Node<'a, RefCell<Ast>>) -> Pandoc {
 pub fn convert_document_with_source<'a>(
     root: &'a Node<'a, RefCell<Ast>>,
     source_ctx: Option<&SourceLocationContext>,
+) -> Pandoc {
+    convert_document_with_dialect(root, source_ctx, Dialect::CommonMark)
+}
+
+/// Convert a comrak document parsed as `dialect` to a Pandoc document.
+///
+/// The AST should come from `parse_document` with `dialect.comrak_options()`.
+///
+/// # Panics
+/// With [`Dialect::CommonMark`], panics under the same conditions as
+/// [`convert_document_with_source`]. With [`Dialect::Gfm`], panics only on
+/// nodes produced by comrak extensions that GFM does not enable.
+pub fn convert_document_with_dialect<'a>(
+    root: &'a Node<'a, RefCell<Ast>>,
+    source_ctx: Option<&SourceLocationContext>,
+    dialect: Dialect,
 ) -> Pandoc {
     let ast = root.data.borrow();
     match &ast.value {
         NodeValue::Document => {
-            let blocks = convert_children_to_blocks(root, source_ctx);
+            let mut ctx = ConvertContext::new(source_ctx, dialect);
+            if ctx.is_gfm() {
+                ctx.collect_notes(root, convert_children_to_blocks);
+            }
+            let blocks = convert_children_to_blocks(root, &ctx);
             Pandoc {
                 meta: ConfigValue::default(),
                 blocks,
@@ -66,31 +89,28 @@ pub fn convert_document_with_source<'a>(
 /// Convert a comrak node's block children to Pandoc blocks.
 fn convert_children_to_blocks<'a>(
     node: &'a Node<'a, RefCell<Ast>>,
-    source_ctx: Option<&SourceLocationContext>,
+    ctx: &ConvertContext,
 ) -> Blocks {
     node.children()
-        .flat_map(|child| convert_block(child, source_ctx))
+        .flat_map(|child| convert_block(child, ctx))
         .collect()
 }
 
 /// Convert a comrak block node to Pandoc blocks.
 ///
 /// Returns a Vec because some nodes may expand to multiple blocks.
-fn convert_block<'a>(
-    node: &'a Node<'a, RefCell<Ast>>,
-    source_ctx: Option<&SourceLocationContext>,
-) -> Blocks {
+fn convert_block<'a>(node: &'a Node<'a, RefCell<Ast>>, ctx: &ConvertContext) -> Blocks {
     let ast = node.data.borrow();
-    let source_info = get_source_info(&ast, source_ctx);
+    let source_info = get_source_info(&ast, ctx.source);
 
     match &ast.value {
         NodeValue::Document => {
             // Document is handled at the top level
-            convert_children_to_blocks(node, source_ctx)
+            convert_children_to_blocks(node, ctx)
         }
 
         NodeValue::Paragraph => {
-            let inlines = convert_children_to_inlines_with_source(node, source_ctx);
+            let inlines = convert_children_to_inlines_with_source(node, ctx);
             vec![Block::Paragraph(Paragraph {
                 content: inlines,
                 source_info,
@@ -98,15 +118,15 @@ fn convert_block<'a>(
         }
 
         NodeValue::Heading(heading) => {
-            vec![convert_heading(node, heading, source_ctx)]
+            vec![convert_heading(node, heading, ctx)]
         }
 
         NodeValue::CodeBlock(code_block) => {
-            vec![convert_code_block(code_block, source_info)]
+            vec![convert_code_block(code_block, source_info, ctx)]
         }
 
         NodeValue::BlockQuote => {
-            let children = convert_children_to_blocks(node, source_ctx);
+            let children = convert_children_to_blocks(node, ctx);
             vec![Block::BlockQuote(BlockQuote {
                 content: children,
                 source_info,
@@ -114,7 +134,7 @@ fn convert_block<'a>(
         }
 
         NodeValue::List(list) => {
-            vec![convert_list(node, list, source_ctx)]
+            vec![convert_list(node, list, ctx)]
         }
 
         // Items are handled within convert_list; unreachable via normal traversal
@@ -131,6 +151,23 @@ fn convert_block<'a>(
             vec![]
         }
 
+        // GFM constructs
+        NodeValue::HtmlBlock(html) if ctx.is_gfm() => {
+            vec![Block::RawBlock(RawBlock {
+                format: "html".to_string(),
+                text: html.literal.clone(),
+                source_info,
+            })]
+        }
+        NodeValue::Table(table) if ctx.is_gfm() => {
+            vec![convert_table(node, table, source_info, ctx)]
+        }
+        NodeValue::FootnoteDefinition(_) if ctx.is_gfm() => {
+            // Definitions were collected up front and are inlined as Notes
+            // at their references.
+            vec![]
+        }
+
         // Unsupported block types - panic as they're outside CommonMark subset
         // These require enabling comrak extensions, which we don't support
         NodeValue::HtmlBlock(_) => {
@@ -186,15 +223,15 @@ fn convert_block<'a>(
 fn convert_heading<'a>(
     node: &'a Node<'a, RefCell<Ast>>,
     heading: &NodeHeading,
-    source_ctx: Option<&SourceLocationContext>,
+    ctx: &ConvertContext,
 ) -> Block {
-    if heading.setext {
+    if heading.setext && !ctx.is_gfm() {
         panic!("Setext headings not supported in CommonMark subset");
     }
 
     let ast = node.data.borrow();
-    let source_info = get_source_info(&ast, source_ctx);
-    let inlines = convert_children_to_inlines_with_source(node, source_ctx);
+    let source_info = get_source_info(&ast, ctx.source);
+    let inlines = convert_children_to_inlines_with_source(node, ctx);
     Block::Header(Header {
         level: heading.level as usize,
         attr: empty_attr(),
@@ -204,8 +241,12 @@ fn convert_heading<'a>(
     })
 }
 
-fn convert_code_block(code_block: &NodeCodeBlock, source_info: SourceInfo) -> Block {
-    if !code_block.fenced {
+fn convert_code_block(
+    code_block: &NodeCodeBlock,
+    source_info: SourceInfo,
+    ctx: &ConvertContext,
+) -> Block {
+    if !code_block.fenced && !ctx.is_gfm() {
         panic!("Indented code blocks not supported in CommonMark subset");
     }
 
@@ -235,14 +276,14 @@ fn convert_code_block(code_block: &NodeCodeBlock, source_info: SourceInfo) -> Bl
 fn convert_list<'a>(
     node: &'a Node<'a, RefCell<Ast>>,
     list: &NodeList,
-    source_ctx: Option<&SourceLocationContext>,
+    ctx: &ConvertContext,
 ) -> Block {
     let ast = node.data.borrow();
-    let source_info = get_source_info(&ast, source_ctx);
+    let source_info = get_source_info(&ast, ctx.source);
 
     let items: Vec<Blocks> = node
         .children()
-        .map(|child| convert_list_item(child, list.tight, source_ctx))
+        .map(|child| convert_list_item(child, list.tight, ctx))
         .collect();
 
     match list.list_type {
@@ -271,9 +312,16 @@ fn convert_list<'a>(
 fn convert_list_item<'a>(
     node: &'a Node<'a, RefCell<Ast>>,
     tight: bool,
-    source_ctx: Option<&SourceLocationContext>,
+    ctx: &ConvertContext,
 ) -> Blocks {
-    let children = convert_children_to_blocks(node, source_ctx);
+    let mut children = convert_children_to_blocks(node, ctx);
+
+    // Task list items start with a ballot box, as in Pandoc's gfm reader
+    let ast = node.data.borrow();
+    if let NodeValue::TaskItem(task) = &ast.value {
+        let marker = if task.symbol.is_some() { "☒" } else { "☐" };
+        prepend_task_marker(&mut children, marker, get_source_info(&ast, ctx.source));
+    }
 
     if tight {
         // For tight lists, convert single Paragraph to Plain
@@ -295,6 +343,130 @@ fn convert_list_item<'a>(
     }
 }
 
+fn prepend_task_marker(blocks: &mut Blocks, marker: &str, source_info: SourceInfo) {
+    let marker = Inline::Str(Str {
+        text: marker.to_string(),
+        source_info: source_info.clone(),
+    });
+    match blocks.first_mut() {
+        Some(Block::Paragraph(Paragraph { content, .. }))
+        | Some(Block::Plain(Plain { content, .. })) => {
+            content.splice(
+                0..0,
+                [
+                    marker,
+                    Inline::Space(Space {
+                        source_info: source_info.clone(),
+                    }),
+                ],
+            );
+        }
+        _ => blocks.insert(
+            0,
+            Block::Plain(Plain {
+                content: vec![marker],
+                source_info,
+            }),
+        ),
+    }
+}
+
+fn convert_alignment(alignment: &TableAlignment) -> Alignment {
+    match alignment {
+        TableAlignment::None => Alignment::Default,
+        TableAlignment::Left => Alignment::Left,
+        TableAlignment::Center => Alignment::Center,
+        TableAlignment::Right => Alignment::Right,
+    }
+}
+
+fn convert_table<'a>(
+    node: &'a Node<'a, RefCell<Ast>>,
+    table: &NodeTable,
+    source_info: SourceInfo,
+    ctx: &ConvertContext,
+) -> Block {
+    let mut head_rows = Vec::new();
+    let mut body_rows = Vec::new();
+    for row_node in node.children() {
+        let row_ast = row_node.data.borrow();
+        let is_header = matches!(row_ast.value, NodeValue::TableRow(true));
+        let row = Row {
+            attr: empty_attr(),
+            cells: row_node
+                .children()
+                .map(|cell_node| convert_table_cell(cell_node, ctx))
+                .collect(),
+            source_info: get_source_info(&row_ast, ctx.source),
+            attr_source: AttrSourceInfo::empty(),
+        };
+        if is_header {
+            head_rows.push(row);
+        } else {
+            body_rows.push(row);
+        }
+    }
+
+    Block::Table(Table {
+        attr: empty_attr(),
+        caption: Caption {
+            short: None,
+            long: None,
+            source_info: empty_source_info(),
+        },
+        colspec: table
+            .alignments
+            .iter()
+            .map(|a| (convert_alignment(a), ColWidth::Default))
+            .collect(),
+        head: TableHead {
+            attr: empty_attr(),
+            rows: head_rows,
+            source_info: empty_source_info(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        bodies: vec![TableBody {
+            attr: empty_attr(),
+            rowhead_columns: 0,
+            head: vec![],
+            body: body_rows,
+            source_info: empty_source_info(),
+            attr_source: AttrSourceInfo::empty(),
+        }],
+        foot: TableFoot {
+            attr: empty_attr(),
+            rows: vec![],
+            source_info: empty_source_info(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+    })
+}
+
+fn convert_table_cell<'a>(node: &'a Node<'a, RefCell<Ast>>, ctx: &ConvertContext) -> Cell {
+    let ast = node.data.borrow();
+    let source_info = get_source_info(&ast, ctx.source);
+    let inlines = convert_children_to_inlines_with_source(node, ctx);
+    let content = if inlines.is_empty() {
+        vec![]
+    } else {
+        vec![Block::Plain(Plain {
+            content: inlines,
+            source_info: source_info.clone(),
+        })]
+    };
+    Cell {
+        attr: empty_attr(),
+        alignment: Alignment::Default,
+        row_span: 1,
+        col_span: 1,
+        content,
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -521,4 +693,99 @@ mod tests {
         // This is valid CommonMark but not supported in our subset
         parse("    code\n    more code\n");
     }
+
+    fn parse_gfm(markdown: &str) -> Pandoc {
+        let arena = Arena::new();
+        let options = Dialect::Gfm.comrak_options();
+        let root = parse_document(&arena, markdown, &options);
+        convert_document_with_dialect(root, None, Dialect::Gfm)
+    }
+
+    fn plain_text(inlines: &[Inline]) -> String {
+        inlines
+            .iter()
+            .map(|inline| match inline {
+                Inline::Str(s) => s.text.clone(),
+                Inline::Space(_) => " ".to_string(),
+                _ => String::new(),
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_gfm_table() {
+        let pandoc = parse_gfm("| a | b |\n|:--|--:|\n| 1 | 2 |\n| 3 |   |\n");
+        assert_eq!(pandoc.blocks.len(), 1);
+        match &pandoc.blocks[0] {
+            Block::Table(table) => {
+                assert_eq!(
+                    table.colspec,
+                    vec![
+                        (Alignment::Left, ColWidth::Default),
+                        (Alignment::Right, ColWidth::Default)
+                    ]
+                );
+                assert_eq!(table.head.rows.len(), 1);
+                assert_eq!(table.bodies[0].body.len(), 2);
+                // Empty cells have no content
+                assert!(table.bodies[0].body[1].cells[1].content.is_empty());
+            }
+            _ => panic!("Expected Table"),
+        }
+    }
+
+    #[test]
+    fn test_gfm_task_list() {
+        let pandoc = parse_gfm("- [ ] todo\n- [x] done\n");
+        match &pandoc.blocks[0] {
+            Block::BulletList(list) => match (&list.content[0][0], &list.content[1][0]) {
+                (Block::Plain(todo), Block::Plain(done)) => {
+                    assert_eq!(plain_text(&todo.content), "☐ todo");
+                    assert_eq!(plain_text(&done.content), "☒ done");
+                }
+                _ => panic!("Expected Plain items"),
+            },
+            _ => panic!("Expected BulletList"),
+        }
+    }
+
+    #[test]
+    fn test_gfm_footnote() {
+        let pandoc = parse_gfm("Text[^1].\n\n[^1]: The note.\n");
+        assert_eq!(pandoc.blocks.len(), 1);
+        match &pandoc.blocks[0] {
+            Block::Paragraph(p) => {
+                let note = p.content.iter().find_map(|inline| match inline {
+                    Inline::Note(note) => Some(note),
+                    _ => None,
+                });
+                match note.map(|n| &n.content[..]) {
+                    Some([Block::Paragraph(para)]) => {
+                        assert_eq!(plain_text(&para.content), "The note.");
+                    }
+                    _ => panic!("Expected Note with one paragraph"),
+                }
+            }
+            _ => panic!("Expected Paragraph"),
+        }
+    }
+
+    #[test]
+    fn test_gfm_html_block() {
+        let pandoc = parse_gfm("<div>\nhi\n</div>\n");
+        match &pandoc.blocks[0] {
+            Block::RawBlock(raw) => {
+                assert_eq!(raw.format, "html");
+                assert_eq!(raw.text, "<div>\nhi\n</div>\n");
+            }
+            _ => panic!("Expected RawBlock"),
+        }
+    }
+
+    #[test]
+    fn test_gfm_allows_setext_and_indented_code() {
+        let pandoc = parse_gfm("Heading\n=======\n\n    code\n");
+        assert!(matches!(&pandoc.blocks[0], Block::Header(h) if h.level == 1));
+        assert!(matches!(&pandoc.blocks[1], Block::CodeBlock(cb) if cb.text == "code\n"));
+    }
 }
@@ -0,0 +1,85 @@
+/*
+ * context.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * State threaded through the block and inline converters.
+ */
+
+use crate::source_location::SourceLocationContext;
+use comrak::Options;
+use comrak::arena_tree::Node;
+use comrak::nodes::{Ast, NodeValue};
+use quarto_pandoc_types::Blocks;
+use std::cell::RefCell;
+use std::collections::HashMap;
+
+/// The markdown dialect a comrak AST was parsed as.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Dialect {
+    /// The CommonMark subset shared with pampa's qmd reader. Constructs
+    /// outside it (setext headings, indented code, extension nodes) panic,
+    /// which keeps the differential tests honest.
+    #[default]
+    CommonMark,
+    /// GitHub Flavored Markdown: the full CommonMark syntax plus tables,
+    /// strikethrough, autolinks, task lists, footnotes and raw HTML.
+    Gfm,
+}
+
+impl Dialect {
+    /// Comrak parse options matching this dialect.
+    pub fn comrak_options(self) -> Options<'static> {
+        let mut options = Options::default();
+        if self == Dialect::Gfm {
+            options.extension.table = true;
+            options.extension.strikethrough = true;
+            options.extension.autolink = true;
+            options.extension.tasklist = true;
+            options.extension.tagfilter = true;
+            options.extension.footnotes = true;
+        }
+        options
+    }
+}
+
+pub(crate) struct ConvertContext<'c> {
+    pub source: Option<&'c SourceLocationContext>,
+    pub dialect: Dialect,
+    /// Converted footnote definitions, keyed by lowercased label.
+    pub notes: HashMap<String, Blocks>,
+}
+
+impl<'c> ConvertContext<'c> {
+    pub fn new(source: Option<&'c SourceLocationContext>, dialect: Dialect) -> Self {
+        Self {
+            source,
+            dialect,
+            notes: HashMap::new(),
+        }
+    }
+
+    pub fn is_gfm(&self) -> bool {
+        self.dialect == Dialect::Gfm
+    }
+
+    /// Collect the footnote definitions below `root` so references can be
+    /// replaced by the note's content wherever they appear.
+    ///
+    /// Definitions are converted before any notes are registered, so a
+    /// reference inside a footnote falls back to its literal `[^label]`.
+    pub fn collect_notes<'a>(
+        &mut self,
+        root: &'a Node<'a, RefCell<Ast>>,
+        convert: impl Fn(&'a Node<'a, RefCell<Ast>>, &ConvertContext) -> Blocks,
+    ) {
+        let mut notes = HashMap::new();
+        for node in root.descendants() {
+            if let NodeValue::FootnoteDefinition(def) = &node.data.borrow().value {
+                notes
+                    .entry(def.name.to_lowercase())
+                    .or_insert_with(|| convert(node, self));
+            }
+        }
+        self.notes = notes;
+    }
+}
@@ -5,6 +5,7 @@
  * Convert comrak inline nodes to Pandoc inlines.
  */
 
+use crate::context::ConvertContext;
 use crate::source_location::SourceLocationContext;
 use crate::text::{tokenize_text, tokenize_text_with_source};
 use crate::{empty_attr, empty_source_info};
@@ -12,8 +13,8 @@ use comrak::arena_tree::Node;
 use comrak::nodes::{Ast, NodeCode, NodeLink, NodeValue};
 use hashlink::LinkedHashMap;
 use quarto_pandoc_types::{
-    AttrSourceInfo, Code, Emph, Image, Inline, Inlines, LineBreak, Link, SoftBreak, Strong,
-    TargetSourceInfo,
+    AttrSourceInfo, Code, Emph, Image, Inline, Inlines, LineBreak, Link, Note, RawInline,
+    SoftBreak, Str, Strikeout, Strong, TargetSourceInfo,
 };
 use quarto_source_map::SourceInfo;
 use std::cell::RefCell;
@@ -28,28 +29,25 @@ fn get_source_info(ast: &Ast, source_ctx: Option<&SourceLocationContext>) -> Sou
 /// Convert a comrak node's inline children to Pandoc inlines with source tracking.
 pub fn convert_children_to_inlines_with_source<'a>(
     node: &'a Node<'a, RefCell<Ast>>,
-    source_ctx: Option<&SourceLocationContext>,
+    ctx: &ConvertContext,
 ) -> Inlines {
     node.children()
-        .flat_map(|child| convert_inline(child, source_ctx))
+        .flat_map(|child| convert_inline(child, ctx))
         .collect()
 }
 
 /// Convert a comrak inline node to Pandoc inlines.
 ///
 /// Returns a Vec because some nodes (like Text) expand to multiple inlines.
-fn convert_inline<'a>(
-    node: &'a Node<'a, RefCell<Ast>>,
-    source_ctx: Option<&SourceLocationContext>,
-) -> Inlines {
+fn convert_inline<'a>(node: &'a Node<'a, RefCell<Ast>>, ctx: &ConvertContext) -> Inlines {
     let ast = node.data.borrow();
-    let source_info = get_source_info(&ast, source_ctx);
+    let source_info = get_source_info(&ast, ctx.source);
 
     match &ast.value {
         NodeValue::Text(text) => {
-            if let Some(ctx) = source_ctx {
-                let base_offset = ctx.start_offset(&ast.sourcepos);
-                tokenize_text_with_source(text, base_offset, ctx.file_id())
+            if let Some(source_ctx) = ctx.source {
+                let base_offset = source_ctx.start_offset(&ast.sourcepos);
+                tokenize_text_with_source(text, base_offset, source_ctx.file_id())
             } else {
                 tokenize_text(text)
             }
@@ -68,7 +66,7 @@ fn convert_inline<'a>(
         }
 
         NodeValue::Emph => {
-            let children = convert_children_to_inlines_with_source(node, source_ctx);
+            let children = convert_children_to_inlines_with_source(node, ctx);
             vec![Inline::Emph(Emph {
                 content: children,
                 source_info,
@@ -76,7 +74,7 @@ fn convert_inline<'a>(
         }
 
         NodeValue::Strong => {
-            let children = convert_children_to_inlines_with_source(node, source_ctx);
+            let children = convert_children_to_inlines_with_source(node, ctx);
             vec![Inline::Strong(Strong {
                 content: children,
                 source_info,
@@ -84,19 +82,54 @@ fn convert_inline<'a>(
         }
 
         NodeValue::Link(link) => {
-            let children = convert_children_to_inlines_with_source(node, source_ctx);
-            vec![convert_link(link, children, source_info)]
+            let children = convert_children_to_inlines_with_source(node, ctx);
+            let label = ctx
+                .source
+                .and_then(|source_ctx| reference_label(source_ctx.text(&ast.sourcepos)));
+            vec![convert_link(link, children, source_info, label)]
         }
 
         NodeValue::Image(link) => {
-            let children = convert_children_to_inlines_with_source(node, source_ctx);
-            vec![convert_image(link, children, source_info)]
+            let children = convert_children_to_inlines_with_source(node, ctx);
+            let label = ctx
+                .source
+                .and_then(|source_ctx| reference_label(source_ctx.text(&ast.sourcepos)));
+            vec![convert_image(link, children, source_info, label)]
         }
 
         NodeValue::Escaped => {
             // Escaped characters just become the character itself
             // The actual character is in the children as Text
-            convert_children_to_inlines_with_source(node, source_ctx)
+            convert_children_to_inlines_with_source(node, ctx)
+        }
+
+        // GFM constructs
+        NodeValue::HtmlInline(html) if ctx.is_gfm() => {
+            vec![Inline::RawInline(RawInline {
+                format: "html".to_string(),
+                text: html.clone(),
+                source_info,
+            })]
+        }
+        NodeValue::Strikethrough if ctx.is_gfm() => {
+            let children = convert_children_to_inlines_with_source(node, ctx);
+            vec![Inline::Strikeout(Strikeout {
+                content: children,
+                source_info,
+            })]
+        }
+        NodeValue::FootnoteReference(reference) if ctx.is_gfm() => {
+            match ctx.notes.get(&reference.name.to_lowercase()) {
+                Some(content) => vec![Inline::Note(Note {
+                    id: Some(reference.name.clone()),
+                    content: content.clone(),
+                    source_info,
+                })],
+                None => vec![Inline::Str(Str {
+                    text: format!("[^{}]", reference.name),
+                    source_info,
+                })],
+            }
         }
 
         // Unsupported inline types - panic as they're outside CommonMark subset
@@ -156,7 +189,30 @@ fn convert_code(code: &NodeCode, source_info: SourceInfo) -> Inline {
     })
 }
 
-fn convert_link(link: &NodeLink, children: Inlines, source_info: SourceInfo) -> Inline {
+/// The label of a reference-style link or image, from its source text.
+///
+/// `[text][label]` gives `label`; the collapsed `[text][]` and shortcut
+/// `[text]` forms give `text`. Inline links and autolinks give `None`.
+fn reference_label(source: &str) -> Option<String> {
+    let source = source.strip_prefix('!').unwrap_or(source);
+    let inner = source.strip_prefix('[')?.strip_suffix(']')?;
+    if let Some(text) = inner.strip_suffix("][") {
+        return Some(text.to_string());
+    }
+    // A full reference: the label is after the last "][", since labels
+    // cannot contain unescaped brackets
+    match inner.rfind("][") {
+        Some(pos) if !inner[pos + 2..].contains(['[', ']']) => Some(inner[pos + 2..].to_string()),
+        _ => Some(inner.to_string()),
+    }
+}
+
+fn convert_link(
+    link: &NodeLink,
+    children: Inlines,
+    source_info: SourceInfo,
+    reference_label: Option<String>,
+) -> Inline {
     // Detect autolinks: content is just Str(url) matching the URL
     let is_autolink = match children.as_slice() {
         [Inline::Str(s)] => s.text == link.url,
@@ -176,11 +232,19 @@ fn convert_link(link: &NodeLink, children: Inlines, source_info: SourceInfo) ->
         target: (link.url.clone(), link.title.clone()),
         source_info,
         attr_source: AttrSourceInfo::empty(),
-        target_source: TargetSourceInfo::empty(),
+        target_source: TargetSourceInfo {
+            reference_label,
+            ..TargetSourceInfo::empty()
+        },
     })
 }
 
-fn convert_image(link: &NodeLink, children: Inlines, source_info: SourceInfo) -> Inline {
+fn convert_image(
+    link: &NodeLink,
+    children: Inlines,
+    source_info: SourceInfo,
+    reference_label: Option<String>,
+) -> Inline {
     // For images, children become alt text
     Inline::Image(Image {
         attr: empty_attr(),
@@ -188,13 +252,17 @@ fn convert_image(link: &NodeLink, children: Inlines, source_info: SourceInfo) ->
         target: (link.url.clone(), link.title.clone()),
         source_info,
         attr_source: AttrSourceInfo::empty(),
-        target_source: TargetSourceInfo::empty(),
+        target_source: TargetSourceInfo {
+            reference_label,
+            ..TargetSourceInfo::empty()
+        },
     })
 }
 
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::context::Dialect;
     use comrak::{Arena, Options, parse_document};
 
     fn get_first_para_inlines(markdown: &str) -> Inlines {
@@ -202,7 +270,10 @@ mod tests {
         let root = parse_document(&arena, markdown, &Options::default());
         // Get first child (should be paragraph)
         let para = root.first_child().expect("Expected a block");
-        convert_children_to_inlines_with_source(para, None)
+        convert_children_to_inlines_with_source(
+            para,
+            &ConvertContext::new(None, Dialect::CommonMark),
+        )
     }
 
     #[test]
@@ -388,6 +459,37 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_reference_label() {
+        assert_eq!(reference_label("[text][label]"), Some("label".to_string()));
+        assert_eq!(reference_label("[text][]"), Some("text".to_string()));
+        assert_eq!(reference_label("[text]"), Some("text".to_string()));
+        assert_eq!(reference_label("![alt][img]"), Some("img".to_string()));
+        assert_eq!(reference_label("[a [b] c]"), Some("a [b] c".to_string()));
+        assert_eq!(reference_label("[text](http://example.com)"), None);
+        assert_eq!(reference_label("<http://example.com>"), None);
+    }
+
+    #[test]
+    fn test_reference_link_keeps_label() {
+        use crate::source_location::SourceLocationContext;
+
+        let markdown = "[text][Label] and [inline](b)\n\n[label]: http://example.com\n";
+        let ctx = SourceLocationContext::new(markdown, quarto_source_map::FileId(0));
+        let arena = Arena::new();
+        let root = parse_document(&arena, markdown, &Options::default());
+        let para = root.first_child().expect("Expected a block");
+        let ctx = ConvertContext::new(Some(&ctx), Dialect::CommonMark);
+        let labels: Vec<_> = convert_children_to_inlines_with_source(para, &ctx)
+            .into_iter()
+            .filter_map(|inline| match inline {
+                Inline::Link(l) => Some(l.target_source.reference_label),
+                _ => None,
+            })
+            .collect();
+        assert_eq!(labels, vec![Some("Label".to_string()), None]);
+    }
+
     #[test]
     fn test_text_with_source_context() {
         use crate::source_location::SourceLocationContext;
@@ -397,7 +499,8 @@ mod tests {
         let arena = Arena::new();
         let root = parse_document(&arena, markdown, &Options::default());
         let para = root.first_child().expect("Expected a block");
-        let inlines = convert_children_to_inlines_with_source(para, Some(&ctx));
+        let ctx = ConvertContext::new(Some(&ctx), Dialect::CommonMark);
+        let inlines = convert_children_to_inlines_with_source(para, &ctx);
 
         assert_eq!(inlines.len(), 3);
         // With source context, source info should have non-zero values
@@ -409,4 +512,18 @@ mod tests {
             _ => panic!("Expected Str"),
         }
     }
+
+    #[test]
+    fn test_gfm_strikethrough_and_raw_html() {
+        let arena = Arena::new();
+        let options = Dialect::Gfm.comrak_options();
+        let root = parse_document(&arena, "~~gone~~ <b>bold</b>\n", &options);
+        let para = root.first_child().expect("Expected a block");
+        let inlines =
+            convert_children_to_inlines_with_source(para, &ConvertContext::new(None, Dialect::Gfm));
+        assert!(matches!(&inlines[0], Inline::Strikeout(s) if s.content.len() == 1));
+        assert!(
+            matches!(&inlines[2], Inline::RawInline(r) if r.format == "html" && r.text == "<b>")
+        );
+    }
 }
@@ -5,20 +5,24 @@
  * Convert comrak's CommonMark AST to quarto-pandoc-types AST.
  *
  * This crate provides direct conversion from comrak's arena-based AST
- * to our owned Pandoc AST structures. Only the CommonMark subset is
- * supported; GFM extensions will panic.
+ * to our owned Pandoc AST structures. By default only the CommonMark
+ * subset is supported and GFM extensions will panic; the GFM dialect
+ * additionally converts tables, strikethrough, task lists, footnotes
+ * and raw HTML.
  */
 
 mod block;
 mod compare;
+mod context;
 mod inline;
 pub mod source_location;
 mod text;
 
 pub mod normalize;
 
-pub use block::{convert_document, convert_document_with_source};
+pub use block::{convert_document, convert_document_with_dialect, convert_document_with_source};
 pub use compare::ast_eq_ignore_source;
+pub use context::Dialect;
 pub use normalize::normalize;
 pub use source_location::SourceLocationContext;
 
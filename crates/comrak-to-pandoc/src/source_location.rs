@@ -23,6 +23,8 @@ pub struct SourceLocationContext {
     line_offsets: Vec<usize>,
     /// File ID for the source file
     file_id: FileId,
+    /// The source text, for constructs whose AST loses how they were written
+    source: String,
 }
 
 impl SourceLocationContext {
@@ -39,6 +41,7 @@ impl SourceLocationContext {
         Self {
             line_offsets,
             file_id,
+            source: source.to_string(),
         }
     }
 
@@ -81,6 +84,13 @@ impl SourceLocationContext {
     pub fn file_id(&self) -> FileId {
         self.file_id
     }
+
+    /// The source text covered by a sourcepos
+    pub fn text(&self, sourcepos: &Sourcepos) -> &str {
+        let start = self.start_offset(sourcepos).min(self.source.len());
+        let end = self.end_offset(sourcepos).clamp(start, self.source.len());
+        self.source.get(start..end).unwrap_or("")
+    }
 }
 
 #[cfg(test)]
@@ -0,0 +1,201 @@
+// Package pampa calls pampa in-process through its C ABI
+// (include/pampa.h).
+//
+// The package links the static library, which must be built first with
+// `cargo build --release -p pampa-ffi` from the root of the repository.
+package pampa
+
+// #cgo CFLAGS: -std=c11 -I${SRCDIR}/../../include
+// #cgo LDFLAGS: ${SRCDIR}/../../../../target/release/libpampa_ffi.a -lm
+// #cgo linux LDFLAGS: -ldl -lpthread
+// #cgo darwin LDFLAGS: -framework CoreFoundation -framework Security
+// #include <stdlib.h>
+// #include "pampa.h"
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// Status codes, as PAMPA_* in pampa.h.
+const (
+	StatusOK            = C.PAMPA_OK
+	StatusError         = C.PAMPA_ERROR
+	StatusArgumentError = C.PAMPA_ERROR_ARGUMENT
+	StatusPanic         = C.PAMPA_ERROR_PANIC
+)
+
+// Diagnostic is a warning or error, as in `pampa --json-errors`. The
+// fields that vary by diagnostic are left as raw JSON.
+type Diagnostic struct {
+	Kind     string          `json:"kind"`
+	Title    string          `json:"title"`
+	Code     string          `json:"code,omitempty"`
+	Problem  json.RawMessage `json:"problem,omitempty"`
+	Location json.RawMessage `json:"location,omitempty"`
+}
+
+// Error is returned when pampa couldn't read or write a document.
+type Error struct {
+	Status      int
+	Diagnostics []Diagnostic
+}
+
+func (e *Error) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return fmt.Sprintf("pampa: status %d", e.Status)
+	}
+	return fmt.Sprintf("pampa: %s", e.Diagnostics[0].Title)
+}
+
+// Version returns the version of the linked library.
+func Version() string {
+	return C.GoString(C.pampa_version())
+}
+
+// ABIVersion returns the version of the ABI of the linked library.
+func ABIVersion() uint32 {
+	return uint32(C.pampa_abi_version())
+}
+
+// ParseQmd reads qmd and returns the document as Pandoc JSON, with the
+// warnings. With sourcepos, every node has its source location.
+func ParseQmd(src []byte, sourcepos bool) ([]byte, []Diagnostic, error) {
+	var flags C.uint32_t
+	if sourcepos {
+		flags |= C.PAMPA_SOURCEPOS
+	}
+	input := C.CBytes(src)
+	defer C.free(input)
+	return result(C.pampa_parse_qmd((*C.char)(input), C.size_t(len(src)), flags))
+}
+
+// Write writes a Pandoc JSON document in format: json, native, qmd,
+// markdown, html, latex, typst, ipynb or plain.
+func Write(doc []byte, format string) ([]byte, []Diagnostic, error) {
+	input := C.CBytes(doc)
+	defer C.free(input)
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+	return result(C.pampa_write((*C.char)(input), C.size_t(len(doc)), cformat))
+}
+
+// Lint lints a document template: unbalanced $if$ and $for$ directives,
+// unknown pipes and pipe arguments and, when schema isn't nil, variables
+// that the JSON Schema of the metadata doesn't define. Partials aren't
+// resolved. An invalid schema is reported as a diagnostic.
+func Lint(src, schema []byte) []Diagnostic {
+	input := C.CBytes(src)
+	defer C.free(input)
+	var cschema unsafe.Pointer
+	if schema != nil {
+		cschema = C.CBytes(schema)
+		defer C.free(cschema)
+	}
+	_, diagnostics, err := result(C.pampa_template_lint(
+		(*C.char)(input), C.size_t(len(src)), (*C.char)(cschema), C.size_t(len(schema))))
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.Diagnostics
+	}
+	if err != nil {
+		return []Diagnostic{{Kind: "error", Title: err.Error()}}
+	}
+	return diagnostics
+}
+
+// PartialGraph is the dependency graph of a template's partials. Nodes
+// are indexed from the root template, 0.
+type PartialGraph struct {
+	Nodes []PartialNode `json:"nodes"`
+	Edges []PartialEdge `json:"edges"`
+	// Cycles lists the sets of templates that include each other.
+	Cycles [][]int `json:"cycles"`
+}
+
+// PartialNode is a template in a PartialGraph. Status is "ok", "missing"
+// (Path is where the partial was looked for first) or "invalid" (Message
+// says why; its partials are unknown).
+type PartialNode struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// PartialEdge says that template From uses the partial Name, which is
+// template To. Cyclic edges close a cycle.
+type PartialEdge struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Name   string `json:"name"`
+	Cyclic bool   `json:"cyclic"`
+}
+
+// Partials follows the partials of the template at root, transitively.
+// Partials are looked for next to the template that uses them, then in
+// the directories of searchPath.
+func Partials(root string, searchPath []string) (*PartialGraph, error) {
+	croot := C.CString(root)
+	defer C.free(unsafe.Pointer(croot))
+	csearch := C.CString(strings.Join(searchPath, string(os.PathListSeparator)))
+	defer C.free(unsafe.Pointer(csearch))
+	output, _, err := result(C.pampa_template_partials(croot, csearch))
+	if err != nil {
+		return nil, err
+	}
+	var graph PartialGraph
+	if err := json.Unmarshal(output, &graph); err != nil {
+		return nil, fmt.Errorf("pampa: reading the partial graph: %w", err)
+	}
+	return &graph, nil
+}
+
+// Schema returns a JSON Schema of the metadata the template at root uses:
+// the variables it references, typed by their uses, with the ones it
+// expects set required. Partials are read from next to the template.
+func Schema(root string) ([]byte, error) {
+	croot := C.CString(root)
+	defer C.free(unsafe.Pointer(croot))
+	output, _, err := result(C.pampa_template_schema(croot))
+	return output, err
+}
+
+// Render renders the template at root, with its partials read from next
+// to it. variables is a JSON object of variables, or a Pandoc JSON
+// document (as returned by ParseQmd) whose metadata are the variables,
+// with inlines and blocks written in format: html, latex, typst or plain.
+// Undefined variables are warnings, or errors when strict.
+func Render(root string, variables []byte, format string, strict bool) ([]byte, []Diagnostic, error) {
+	croot := C.CString(root)
+	defer C.free(unsafe.Pointer(croot))
+	input := C.CBytes(variables)
+	defer C.free(input)
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+	var flags C.uint32_t
+	if strict {
+		flags |= C.PAMPA_STRICT
+	}
+	return result(C.pampa_template_render(
+		croot, (*C.char)(input), C.size_t(len(variables)), cformat, flags))
+}
+
+// result copies r into Go memory and frees it.
+func result(r *C.PampaResult) ([]byte, []Diagnostic, error) {
+	defer C.pampa_result_free(r)
+	output := C.GoBytes(unsafe.Pointer(r.output), C.int(r.output_len))
+	var diagnostics []Diagnostic
+	raw := C.GoBytes(unsafe.Pointer(r.diagnostics), C.int(r.diagnostics_len))
+	if err := json.Unmarshal(raw, &diagnostics); err != nil {
+		return nil, nil, fmt.Errorf("pampa: reading diagnostics: %w", err)
+	}
+	if r.status != C.PAMPA_OK {
+		return nil, nil, &Error{Status: int(r.status), Diagnostics: diagnostics}
+	}
+	return output, diagnostics, nil
+}
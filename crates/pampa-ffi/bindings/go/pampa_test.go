@@ -0,0 +1,173 @@
+package pampa_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	pampa "github.com/quarto-dev/q2/crates/pampa-ffi/bindings/go"
+)
+
+func TestVersion(t *testing.T) {
+	if pampa.Version() == "" {
+		t.Error("empty version")
+	}
+	if pampa.ABIVersion() < 1 {
+		t.Errorf("ABI version %d", pampa.ABIVersion())
+	}
+}
+
+func TestParseAndWrite(t *testing.T) {
+	doc, diagnostics, err := pampa.ParseQmd([]byte("# Title\n\nSome *text*.\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+	var ast struct {
+		Blocks []struct {
+			T string `json:"t"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal(doc, &ast); err != nil {
+		t.Fatal(err)
+	}
+	if len(ast.Blocks) != 2 || ast.Blocks[0].T != "Header" {
+		t.Errorf("unexpected blocks: %s", doc)
+	}
+
+	html, _, err := pampa.Write(doc, "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "<em>text</em>") {
+		t.Errorf("unexpected html: %s", html)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, _, err := pampa.ParseQmd([]byte("[}no]{.hello}\n"), false)
+	var perr *pampa.Error
+	if !errors.As(err, &perr) || perr.Status != pampa.StatusError || len(perr.Diagnostics) == 0 {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+
+	_, _, err = pampa.Write([]byte(`{}`), "docx")
+	if !errors.As(err, &perr) || perr.Status != pampa.StatusError {
+		t.Errorf("expected a JSON error, got %v", err)
+	}
+}
+
+func TestLint(t *testing.T) {
+	if diagnostics := pampa.Lint([]byte("$if(title)$$title$$endif$\n"), nil); len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	diagnostics := pampa.Lint([]byte("$for(a)$$a/shout$\n"), nil)
+	if len(diagnostics) != 2 || diagnostics[0].Code != "Q-10-8" || diagnostics[1].Code != "Q-10-6" {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	schema := []byte(`{"properties": {"title": {}}}`)
+	diagnostics = pampa.Lint([]byte("$title$ $date$\n"), schema)
+	if len(diagnostics) != 1 || diagnostics[0].Kind != "warning" || diagnostics[0].Code != "Q-10-9" {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+}
+
+func TestPartials(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"doc.html":        "$header()$",
+		"lib/header.html": "$nav()$",
+		"lib/nav.html":    "$header()$",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph, err := pampa.Partials(filepath.Join(dir, "doc.html"), []string{filepath.Join(dir, "lib")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 3 || graph.Nodes[1].Path != filepath.Join(dir, "lib", "header.html") {
+		t.Errorf("unexpected nodes: %+v", graph.Nodes)
+	}
+	if len(graph.Cycles) != 1 || len(graph.Cycles[0]) != 2 {
+		t.Errorf("expected a cycle, got %v", graph.Cycles)
+	}
+	if graph.Edges[0].Cyclic || !graph.Edges[1].Cyclic {
+		t.Errorf("unexpected edges: %+v", graph.Edges)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	// The Rust tests check the same fixture, so both sides agree
+	got, err := pampa.Schema(filepath.Join("testdata", "schema.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	var perr *pampa.Error
+	if _, err := pampa.Schema(filepath.Join("testdata", "missing.html")); !errors.As(err, &perr) || perr.Status != pampa.StatusError {
+		t.Errorf("expected a template error, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	// The Rust tests render the same fixture
+	root := filepath.Join("testdata", "render.html")
+	variables := []byte(`{"title": "Notes", "author": [{"name": "Ann"}, {"name": "Bo"}]}`)
+	out, diagnostics, err := pampa.Render(root, variables, "html", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<h1>NOTES</h1>\n<p>Ann, Bo</p>\n"; string(out) != want || len(diagnostics) != 0 {
+		t.Errorf("got %q, %v; want %q", out, diagnostics, want)
+	}
+
+	doc, _, err := pampa.ParseQmd([]byte("---\ntitle: Some *notes*\n---\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err = pampa.Render(root, doc, "plain", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<h1>SOME NOTES</h1>\n<p></p>\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if _, diagnostics, err := pampa.Render(root, []byte(`{}`), "html", false); err != nil || len(diagnostics) == 0 || diagnostics[0].Code != "Q-10-2" {
+		t.Errorf("expected an undefined variable warning, got %v, %v", diagnostics, err)
+	}
+	var perr *pampa.Error
+	if _, _, err := pampa.Render(root, []byte(`{}`), "html", true); !errors.As(err, &perr) || perr.Status != pampa.StatusError {
+		t.Errorf("expected an undefined variable error, got %v", err)
+	}
+}
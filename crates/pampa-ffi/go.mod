@@ -0,0 +1,5 @@
+module github.com/quarto-dev/q2/crates/pampa-ffi
+
+go 1.23
+
+toolchain go1.23.0
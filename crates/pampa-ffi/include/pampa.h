@@ -0,0 +1,72 @@
+/*
+ * pampa.h
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * The C ABI of pampa. See src/lib.rs for the contract: every call returns
+ * a PampaResult owning NUL-terminated output and diagnostics (a JSON
+ * array), to be freed with pampa_result_free.
+ */
+
+#ifndef PAMPA_H
+#define PAMPA_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+#define PAMPA_OK 0
+#define PAMPA_ERROR 1
+#define PAMPA_ERROR_ARGUMENT 2
+#define PAMPA_ERROR_PANIC 3
+
+#define PAMPA_SOURCEPOS 1u
+#define PAMPA_STRICT 2u
+
+#define PAMPA_ABI_VERSION 5u
+
+typedef struct PampaResult {
+  int32_t status;
+  char *output;
+  size_t output_len;
+  char *diagnostics;
+  size_t diagnostics_len;
+} PampaResult;
+
+const char *pampa_version(void);
+
+uint32_t pampa_abi_version(void);
+
+PampaResult *pampa_parse_qmd(const char *input, size_t input_len,
+                             uint32_t flags);
+
+PampaResult *pampa_write(const char *json, size_t json_len,
+                         const char *format);
+
+/* Since ABI version 2. schema may be NULL. */
+PampaResult *pampa_template_lint(const char *source, size_t source_len,
+                                 const char *schema, size_t schema_len);
+
+/* Since ABI version 3. search_path, a list in the form of PATH, may be
+ * NULL. */
+PampaResult *pampa_template_partials(const char *root,
+                                     const char *search_path);
+
+/* Since ABI version 4. */
+PampaResult *pampa_template_schema(const char *root);
+
+/* Since ABI version 5. variables is a JSON object or a Pandoc JSON
+ * document; format may be NULL. flags is 0 or PAMPA_STRICT. */
+PampaResult *pampa_template_render(const char *root, const char *variables,
+                                   size_t variables_len, const char *format,
+                                   uint32_t flags);
+
+void pampa_result_free(PampaResult *result);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* PAMPA_H */
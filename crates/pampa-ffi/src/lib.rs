@@ -0,0 +1,790 @@
+/*
+ * lib.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! A C ABI for pampa, so that programs in other languages can convert
+//! documents in-process instead of running the `pampa` binary. The
+//! declarations are in `include/pampa.h`, and `bindings/go` wraps them for
+//! Go.
+//!
+//! - `pampa_parse_qmd` reads qmd and returns the document as Pandoc JSON
+//! - `pampa_write` writes Pandoc JSON in an output format
+//! - `pampa_template_lint` lints a document template
+//! - `pampa_template_partials` returns the partial dependency graph of a
+//!   template
+//! - `pampa_template_schema` returns a JSON Schema of the metadata a
+//!   template uses
+//! - `pampa_template_render` renders a template with a set of variables
+//! - `pampa_version` and `pampa_abi_version` identify the library
+//! - `pampa_result_free` frees the result of the other calls
+//!
+//! Every call returns a `PampaResult` owning two buffers: the output, and
+//! the diagnostics as a JSON array in the form of `pampa --json-errors`.
+//! Warnings come with a successful result, errors with a failed one. Both
+//! buffers are NUL-terminated, and their lengths don't count the NUL.
+//!
+//! The ABI only grows: functions and status codes are added, never changed,
+//! and `pampa_abi_version` counts the additions. Panics never cross it;
+//! they come back as `PAMPA_ERROR_PANIC`.
+
+use pampa::template::MetaWriter;
+use pampa::template::context::metadata_to_context;
+use pampa::{readers, transforms, writers};
+use quarto_doctemplate::{LintOptions, LintSchema, TemplateContext, TemplateValue};
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use std::ffi::{CStr, c_char};
+use std::panic::{AssertUnwindSafe, catch_unwind};
+
+/// The document was read and written
+pub const PAMPA_OK: i32 = 0;
+/// The document couldn't be read or written; the diagnostics say why
+pub const PAMPA_ERROR: i32 = 1;
+/// An argument is invalid: a null pointer, an unknown format or input
+/// that isn't UTF-8
+pub const PAMPA_ERROR_ARGUMENT: i32 = 2;
+/// pampa panicked. This is a bug; the diagnostics hold the panic message.
+pub const PAMPA_ERROR_PANIC: i32 = 3;
+
+/// Flag for `pampa_parse_qmd`: resolve source locations to lines and
+/// columns on every node (the `l` field), as `pampa --sourcepos`
+pub const PAMPA_SOURCEPOS: u32 = 1;
+
+/// Flag for `pampa_template_render`: undefined variables are errors rather
+/// than warnings
+pub const PAMPA_STRICT: u32 = 2;
+
+/// Bumped each time a function or constant is added to the ABI
+pub const PAMPA_ABI_VERSION: u32 = 5;
+
+/// The result of a call, freed with `pampa_result_free`
+#[repr(C)]
+pub struct PampaResult {
+    pub status: i32,
+    pub output: *mut c_char,
+    pub output_len: usize,
+    /// A JSON array of diagnostics
+    pub diagnostics: *mut c_char,
+    pub diagnostics_len: usize,
+}
+
+/// What a call produced, before it is handed over as a `PampaResult`
+struct Outcome {
+    status: i32,
+    output: Vec<u8>,
+    diagnostics: Vec<DiagnosticMessage>,
+}
+
+impl Outcome {
+    fn ok(output: Vec<u8>, diagnostics: Vec<DiagnosticMessage>) -> Self {
+        Self {
+            status: PAMPA_OK,
+            output,
+            diagnostics,
+        }
+    }
+
+    fn failed(status: i32, diagnostics: Vec<DiagnosticMessage>) -> Self {
+        Self {
+            status,
+            output: Vec::new(),
+            diagnostics,
+        }
+    }
+
+    fn argument_error(problem: String) -> Self {
+        Self::failed(
+            PAMPA_ERROR_ARGUMENT,
+            vec![
+                DiagnosticMessageBuilder::error("Invalid argument")
+                    .problem(problem)
+                    .build(),
+            ],
+        )
+    }
+
+    fn into_raw(self) -> *mut PampaResult {
+        let diagnostics: Vec<serde_json::Value> =
+            self.diagnostics.iter().map(|d| d.to_json()).collect();
+        let diagnostics = serde_json::Value::Array(diagnostics).to_string();
+        let (output, output_len) = into_raw_buffer(self.output);
+        let (diagnostics, diagnostics_len) = into_raw_buffer(diagnostics.into_bytes());
+        Box::into_raw(Box::new(PampaResult {
+            status: self.status,
+            output,
+            output_len,
+            diagnostics,
+            diagnostics_len,
+        }))
+    }
+}
+
+/// A NUL-terminated copy of `bytes` owned by the caller, and its length
+/// without the NUL
+fn into_raw_buffer(mut bytes: Vec<u8>) -> (*mut c_char, usize) {
+    let len = bytes.len();
+    bytes.push(0);
+    let buffer = Box::into_raw(bytes.into_boxed_slice());
+    (buffer as *mut c_char, len)
+}
+
+/// # Safety
+///
+/// `ptr` and `len` come from `into_raw_buffer` and haven't been freed.
+unsafe fn free_raw_buffer(ptr: *mut c_char, len: usize) {
+    if !ptr.is_null() {
+        let slice = std::ptr::slice_from_raw_parts_mut(ptr as *mut u8, len + 1);
+        drop(unsafe { Box::from_raw(slice) });
+    }
+}
+
+/// Run a call, turning a panic into `PAMPA_ERROR_PANIC`
+fn guarded(f: impl FnOnce() -> Outcome) -> *mut PampaResult {
+    let outcome = catch_unwind(AssertUnwindSafe(f)).unwrap_or_else(|payload| {
+        let message = payload
+            .downcast_ref::<&str>()
+            .map(|message| message.to_string())
+            .or_else(|| payload.downcast_ref::<String>().cloned())
+            .unwrap_or_else(|| "unknown panic".to_string());
+        Outcome::failed(
+            PAMPA_ERROR_PANIC,
+            vec![
+                DiagnosticMessageBuilder::error("Internal Error")
+                    .with_code("Q-0-1")
+                    .problem(format!("pampa panicked: {}", message))
+                    .build(),
+            ],
+        )
+    });
+    outcome.into_raw()
+}
+
+/// # Safety
+///
+/// `ptr` is null or points to `len` readable bytes.
+unsafe fn input_bytes<'a>(ptr: *const c_char, len: usize, name: &str) -> Result<&'a [u8], Outcome> {
+    if ptr.is_null() {
+        return Err(Outcome::argument_error(format!("`{}` is null", name)));
+    }
+    Ok(unsafe { std::slice::from_raw_parts(ptr as *const u8, len) })
+}
+
+/// Read qmd like `pampa -t json`: footnotes are joined with their
+/// references.
+fn parse_qmd(input: &[u8], flags: u32) -> Outcome {
+    let result = readers::qmd::read(input, false, "<input>", &mut std::io::sink(), true, None);
+    let (mut doc, context, warnings) = match result {
+        Ok(result) => result,
+        Err(diagnostics) => return Outcome::failed(PAMPA_ERROR, diagnostics),
+    };
+    doc.blocks =
+        transforms::resolve_footnotes(std::mem::take(&mut doc.blocks), &context.source_context);
+
+    let config = writers::json::JsonConfig {
+        include_inline_locations: flags & PAMPA_SOURCEPOS != 0,
+        include_block_ids: false,
+    };
+    let mut buf = Vec::new();
+    match writers::json::write_with_config(&doc, &context, &mut buf, &config) {
+        Ok(()) => Outcome::ok(buf, warnings),
+        Err(diagnostics) => Outcome::failed(PAMPA_ERROR, diagnostics),
+    }
+}
+
+/// Write a Pandoc JSON document in `format`: json, native, qmd (or
+/// markdown), html, latex, typst, ipynb or plain.
+fn write_document(json: &[u8], format: &str) -> Outcome {
+    let (doc, context) = match readers::json::read(&mut &json[..]) {
+        Ok(result) => result,
+        Err(e) => {
+            return Outcome::failed(
+                PAMPA_ERROR,
+                vec![
+                    DiagnosticMessageBuilder::error("Invalid Pandoc JSON")
+                        .problem(format!("Error reading JSON: {}", e))
+                        .build(),
+                ],
+            );
+        }
+    };
+    let mut buf = Vec::new();
+    let result = match format {
+        "json" => writers::json::write(&doc, &context, &mut buf),
+        "native" => writers::native::write(&doc, &context, &mut buf),
+        "qmd" | "markdown" => writers::qmd::write(&doc, &mut buf),
+        "html" => io_result(writers::html::write(&doc, &context, &mut buf), format),
+        "latex" => io_result(writers::latex::write(&doc, &mut buf), format),
+        "typst" => io_result(writers::typst::write(&doc, &mut buf), format),
+        "ipynb" => writers::ipynb::write(&doc, &mut buf),
+        "plain" | "plaintext" => {
+            let (text, _diagnostics) = writers::plaintext::blocks_to_string(&doc.blocks);
+            buf.extend_from_slice(text.as_bytes());
+            Ok(())
+        }
+        _ => {
+            return Outcome::argument_error(format!("Unknown output format: {}", format));
+        }
+    };
+    match result {
+        Ok(()) => Outcome::ok(buf, Vec::new()),
+        Err(diagnostics) => Outcome::failed(PAMPA_ERROR, diagnostics),
+    }
+}
+
+/// Lint a template, with its variables checked against `schema` when there
+/// is one. There is no output; the lint fails when it finds errors.
+/// Partials aren't resolved.
+fn lint_template(source: &[u8], schema: Option<&[u8]>) -> Outcome {
+    let Ok(source) = std::str::from_utf8(source) else {
+        return Outcome::argument_error("`source` is not UTF-8".to_string());
+    };
+    let schema = match schema.map(serde_json::from_slice::<serde_json::Value>) {
+        None => None,
+        Some(Ok(schema)) => Some(LintSchema::new(schema)),
+        Some(Err(e)) => {
+            return Outcome::argument_error(format!("`schema` is not JSON: {}", e));
+        }
+    };
+    let options = LintOptions {
+        schema: schema.as_ref(),
+        partials: None,
+    };
+    let report = quarto_doctemplate::lint(source, std::path::Path::new("<template>"), &options);
+    if report.has_errors() {
+        Outcome::failed(PAMPA_ERROR, report.diagnostics)
+    } else {
+        Outcome::ok(Vec::new(), report.diagnostics)
+    }
+}
+
+/// The partial graph of the template at `root` as JSON, with partials also
+/// looked for in the directories of `search_path`.
+fn template_partials(root: &str, search_path: Option<&str>) -> Outcome {
+    let search_path: Vec<std::path::PathBuf> = search_path
+        .map(std::env::split_paths)
+        .into_iter()
+        .flatten()
+        .filter(|dir| !dir.as_os_str().is_empty())
+        .collect();
+    let graph = quarto_doctemplate::partial_graph(std::path::Path::new(root), &search_path);
+    match serde_json::to_vec(&graph) {
+        Ok(json) => Outcome::ok(json, Vec::new()),
+        Err(e) => Outcome::failed(
+            PAMPA_ERROR,
+            vec![
+                DiagnosticMessageBuilder::error("Internal Error")
+                    .with_code("Q-0-1")
+                    .problem(format!("Failed to write the partial graph: {}", e))
+                    .build(),
+            ],
+        ),
+    }
+}
+
+/// The JSON Schema of the metadata the template at `root` uses, with its
+/// partials read from next to it, as `pampa doctemplate schema`.
+fn template_schema(root: &str) -> Outcome {
+    match quarto_doctemplate::Template::compile_from_file(std::path::Path::new(root)) {
+        Ok(template) => {
+            let schema = quarto_doctemplate::template_variables(&template).json_schema();
+            Outcome::ok(schema.to_string().into_bytes(), Vec::new())
+        }
+        Err(e) => Outcome::failed(
+            PAMPA_ERROR,
+            vec![
+                DiagnosticMessageBuilder::error("Template error")
+                    .problem(format!("Failed to compile '{}': {}", root, e))
+                    .build(),
+            ],
+        ),
+    }
+}
+
+/// Render the template at `root`, with its partials read from next to it.
+/// `variables` is a JSON object of variables, or a Pandoc JSON document
+/// whose metadata are the variables, with inlines and blocks written in
+/// `format`.
+fn render_template(root: &str, variables: &[u8], format: &str, flags: u32) -> Outcome {
+    let writer = match format {
+        "html" => MetaWriter::Html,
+        "latex" => MetaWriter::Latex,
+        "typst" => MetaWriter::Typst,
+        "plain" | "plaintext" => MetaWriter::Plaintext,
+        _ => return Outcome::argument_error(format!("Unknown output format: {}", format)),
+    };
+    let json = match serde_json::from_slice::<serde_json::Value>(variables) {
+        Ok(json) => json,
+        Err(e) => return Outcome::argument_error(format!("`variables` is not JSON: {}", e)),
+    };
+    let (context, mut diagnostics) = if json.get("pandoc-api-version").is_some() {
+        match readers::json::read(&mut &variables[..]) {
+            Ok((doc, _context)) => metadata_to_context(&doc.meta, writer),
+            Err(e) => {
+                return Outcome::failed(
+                    PAMPA_ERROR,
+                    vec![
+                        DiagnosticMessageBuilder::error("Invalid Pandoc JSON")
+                            .problem(format!("Error reading JSON: {}", e))
+                            .build(),
+                    ],
+                );
+            }
+        }
+    } else if let serde_json::Value::Object(map) = &json {
+        let mut context = TemplateContext::new();
+        for (name, value) in map {
+            context.insert(name.clone(), TemplateValue::from(value));
+        }
+        (context, Vec::new())
+    } else {
+        return Outcome::argument_error("`variables` is not a JSON object".to_string());
+    };
+
+    let template = match quarto_doctemplate::Template::compile_from_file(std::path::Path::new(root))
+    {
+        Ok(template) => template,
+        Err(e) => {
+            return Outcome::failed(
+                PAMPA_ERROR,
+                vec![
+                    DiagnosticMessageBuilder::error("Template error")
+                        .problem(format!("Failed to compile '{}': {}", root, e))
+                        .build(),
+                ],
+            );
+        }
+    };
+    let (result, render_diagnostics) = if flags & PAMPA_STRICT != 0 {
+        template.render_strict(&context)
+    } else {
+        template.render_with_diagnostics(&context)
+    };
+    diagnostics.extend(render_diagnostics);
+    match result {
+        Ok(output) => Outcome::ok(output.into_bytes(), diagnostics),
+        Err(()) => Outcome::failed(PAMPA_ERROR, diagnostics),
+    }
+}
+
+/// # Safety
+///
+/// `ptr` is null or points to a NUL-terminated string.
+unsafe fn input_str<'a>(ptr: *const c_char, name: &str) -> Result<Option<&'a str>, Outcome> {
+    if ptr.is_null() {
+        return Ok(None);
+    }
+    unsafe { CStr::from_ptr(ptr) }
+        .to_str()
+        .map(Some)
+        .map_err(|_| Outcome::argument_error(format!("`{}` is not UTF-8", name)))
+}
+
+fn io_result(result: std::io::Result<()>, format: &str) -> Result<(), Vec<DiagnosticMessage>> {
+    result.map_err(|e| {
+        vec![
+            DiagnosticMessageBuilder::error("IO error during write")
+                .with_code("Q-3-1")
+                .problem(format!("Failed to write {} output: {}", format, e))
+                .build(),
+        ]
+    })
+}
+
+/// The version of the library, a static NUL-terminated string
+#[unsafe(no_mangle)]
+pub extern "C" fn pampa_version() -> *const c_char {
+    concat!(env!("CARGO_PKG_VERSION"), "\0").as_ptr() as *const c_char
+}
+
+/// The version of the ABI; see `PAMPA_ABI_VERSION`
+#[unsafe(no_mangle)]
+pub extern "C" fn pampa_abi_version() -> u32 {
+    PAMPA_ABI_VERSION
+}
+
+/// Read `input_len` bytes of qmd at `input` and return the document as
+/// Pandoc JSON. `flags` is 0 or `PAMPA_SOURCEPOS`.
+///
+/// # Safety
+///
+/// `input` points to `input_len` readable bytes.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_parse_qmd(
+    input: *const c_char,
+    input_len: usize,
+    flags: u32,
+) -> *mut PampaResult {
+    guarded(|| match unsafe { input_bytes(input, input_len, "input") } {
+        Ok(input) => parse_qmd(input, flags),
+        Err(outcome) => outcome,
+    })
+}
+
+/// Write the Pandoc JSON document of `json_len` bytes at `json` in the
+/// NUL-terminated `format`: json, native, qmd, markdown, html, latex,
+/// typst, ipynb or plain.
+///
+/// # Safety
+///
+/// `json` points to `json_len` readable bytes and `format` to a
+/// NUL-terminated string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_write(
+    json: *const c_char,
+    json_len: usize,
+    format: *const c_char,
+) -> *mut PampaResult {
+    guarded(|| {
+        let json = match unsafe { input_bytes(json, json_len, "json") } {
+            Ok(json) => json,
+            Err(outcome) => return outcome,
+        };
+        if format.is_null() {
+            return Outcome::argument_error("`format` is null".to_string());
+        }
+        match unsafe { CStr::from_ptr(format) }.to_str() {
+            Ok(format) => write_document(json, format),
+            Err(_) => Outcome::argument_error("`format` is not UTF-8".to_string()),
+        }
+    })
+}
+
+/// Lint the template of `source_len` bytes at `source`: unbalanced
+/// directives, unknown pipes and pipe arguments and, when `schema` isn't
+/// null, variables missing from the JSON Schema of `schema_len` bytes at
+/// `schema`. The status is `PAMPA_ERROR` when there are errors; the
+/// output is empty.
+///
+/// # Safety
+///
+/// `source` points to `source_len` readable bytes, and `schema` is null or
+/// points to `schema_len` readable bytes.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_template_lint(
+    source: *const c_char,
+    source_len: usize,
+    schema: *const c_char,
+    schema_len: usize,
+) -> *mut PampaResult {
+    guarded(|| {
+        let source = match unsafe { input_bytes(source, source_len, "source") } {
+            Ok(source) => source,
+            Err(outcome) => return outcome,
+        };
+        let schema = (!schema.is_null())
+            .then(|| unsafe { std::slice::from_raw_parts(schema as *const u8, schema_len) });
+        lint_template(source, schema)
+    })
+}
+
+/// Follow the partials of the template at the NUL-terminated path `root`,
+/// transitively, and return the dependency graph as JSON: `nodes` (each a
+/// `path` and a `status` of ok, missing or invalid), `edges` (`from`, `to`,
+/// `name` and `cyclic`) and `cycles`. Partials are looked for next to the
+/// template that uses them, then in the directories of `search_path`, a
+/// NUL-terminated list in the form of `PATH`, which may be null.
+///
+/// # Safety
+///
+/// `root` points to a NUL-terminated string, and `search_path` is null or
+/// points to one.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_template_partials(
+    root: *const c_char,
+    search_path: *const c_char,
+) -> *mut PampaResult {
+    guarded(|| {
+        let root = match unsafe { input_str(root, "root") } {
+            Ok(Some(root)) => root,
+            Ok(None) => return Outcome::argument_error("`root` is null".to_string()),
+            Err(outcome) => return outcome,
+        };
+        match unsafe { input_str(search_path, "search_path") } {
+            Ok(search_path) => template_partials(root, search_path),
+            Err(outcome) => outcome,
+        }
+    })
+}
+
+/// Return a JSON Schema of the metadata used by the template at the
+/// NUL-terminated path `root`: the variables it references, with types
+/// inferred from their uses and the ones it expects set in `required`.
+/// Partials are read from next to the template. The status is
+/// `PAMPA_ERROR` when the template can't be read or doesn't parse.
+///
+/// # Safety
+///
+/// `root` points to a NUL-terminated string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_template_schema(root: *const c_char) -> *mut PampaResult {
+    guarded(|| match unsafe { input_str(root, "root") } {
+        Ok(Some(root)) => template_schema(root),
+        Ok(None) => Outcome::argument_error("`root` is null".to_string()),
+        Err(outcome) => outcome,
+    })
+}
+
+/// Render the template at the NUL-terminated path `root` with the
+/// variables of the JSON of `variables_len` bytes at `variables`: an
+/// object of variables, or a Pandoc JSON document, whose metadata are used
+/// with inlines and blocks written in the NUL-terminated `format` (html,
+/// latex, typst or plain; html when null). Partials are read from next to
+/// the template. `flags` is 0 or `PAMPA_STRICT`. Undefined variables are
+/// warnings, or errors with `PAMPA_STRICT`; the status is `PAMPA_ERROR`
+/// when there are errors.
+///
+/// # Safety
+///
+/// `root` points to a NUL-terminated string, `variables` to
+/// `variables_len` readable bytes, and `format` is null or points to a
+/// NUL-terminated string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_template_render(
+    root: *const c_char,
+    variables: *const c_char,
+    variables_len: usize,
+    format: *const c_char,
+    flags: u32,
+) -> *mut PampaResult {
+    guarded(|| {
+        let root = match unsafe { input_str(root, "root") } {
+            Ok(Some(root)) => root,
+            Ok(None) => return Outcome::argument_error("`root` is null".to_string()),
+            Err(outcome) => return outcome,
+        };
+        let variables = match unsafe { input_bytes(variables, variables_len, "variables") } {
+            Ok(variables) => variables,
+            Err(outcome) => return outcome,
+        };
+        match unsafe { input_str(format, "format") } {
+            Ok(format) => render_template(root, variables, format.unwrap_or("html"), flags),
+            Err(outcome) => outcome,
+        }
+    })
+}
+
+/// Free a result and its buffers. Null is ignored.
+///
+/// # Safety
+///
+/// `result` comes from a pampa call and hasn't been freed.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn pampa_result_free(result: *mut PampaResult) {
+    if result.is_null() {
+        return;
+    }
+    let result = unsafe { Box::from_raw(result) };
+    unsafe {
+        free_raw_buffer(result.output, result.output_len);
+        free_raw_buffer(result.diagnostics, result.diagnostics_len);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn call(result: *mut PampaResult) -> (i32, String, serde_json::Value) {
+        let (status, output, diagnostics) = unsafe {
+            let r = &*result;
+            (
+                r.status,
+                CStr::from_ptr(r.output).to_str().unwrap().to_string(),
+                CStr::from_ptr(r.diagnostics).to_str().unwrap().to_string(),
+            )
+        };
+        unsafe { pampa_result_free(result) };
+        (status, output, serde_json::from_str(&diagnostics).unwrap())
+    }
+
+    fn parse(input: &str) -> (i32, String, serde_json::Value) {
+        call(unsafe { pampa_parse_qmd(input.as_ptr() as *const c_char, input.len(), 0) })
+    }
+
+    fn write_json(json: &str, format: &CStr) -> (i32, String, serde_json::Value) {
+        call(unsafe { pampa_write(json.as_ptr() as *const c_char, json.len(), format.as_ptr()) })
+    }
+
+    #[test]
+    fn test_parse_and_write() {
+        let (status, json, diagnostics) = parse("Some *text*.\n");
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(diagnostics, serde_json::json!([]));
+        let doc: serde_json::Value = serde_json::from_str(&json).unwrap();
+        assert_eq!(doc["blocks"][0]["t"], "Para");
+
+        let (status, html, _) = write_json(&json, c"html");
+        assert_eq!(status, PAMPA_OK);
+        assert!(html.contains("<em>text</em>"), "{}", html);
+        let (status, qmd, _) = write_json(&json, c"qmd");
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(qmd, "Some *text*.\n");
+    }
+
+    #[test]
+    fn test_parse_errors_are_diagnostics() {
+        let (status, output, diagnostics) = parse("[}no]{.hello}\n");
+        assert_eq!(status, PAMPA_ERROR);
+        assert!(output.is_empty());
+        assert_eq!(diagnostics[0]["kind"], "error");
+    }
+
+    #[test]
+    fn test_argument_errors() {
+        let result = call(unsafe { pampa_parse_qmd(std::ptr::null(), 0, 0) });
+        assert_eq!(result.0, PAMPA_ERROR_ARGUMENT);
+        let (status, _, _) = write_json("{}", c"json");
+        assert_eq!(status, PAMPA_ERROR);
+        let (_, doc, _) = parse("Text.\n");
+        let (status, _, diagnostics) = write_json(&doc, c"docx");
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+        assert!(
+            diagnostics[0]["problem"]["content"]
+                .as_str()
+                .unwrap()
+                .contains("docx")
+        );
+    }
+
+    fn lint(source: &str, schema: Option<&str>) -> (i32, serde_json::Value) {
+        let (schema, schema_len) = schema.map_or((std::ptr::null(), 0), |s| {
+            (s.as_ptr() as *const c_char, s.len())
+        });
+        let (status, _, diagnostics) = call(unsafe {
+            pampa_template_lint(
+                source.as_ptr() as *const c_char,
+                source.len(),
+                schema,
+                schema_len,
+            )
+        });
+        (status, diagnostics)
+    }
+
+    #[test]
+    fn test_template_lint() {
+        let (status, diagnostics) = lint("$if(title)$$title$$endif$\n", None);
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(diagnostics, serde_json::json!([]));
+
+        let (status, diagnostics) = lint("$for(a)$$a/shout$\n", None);
+        assert_eq!(status, PAMPA_ERROR);
+        assert_eq!(diagnostics[0]["code"], "Q-10-8");
+        assert_eq!(diagnostics[1]["code"], "Q-10-6");
+
+        let schema = r#"{"properties": {"title": {}}}"#;
+        let (status, diagnostics) = lint("$title$ $date$\n", Some(schema));
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(diagnostics[0]["code"], "Q-10-9");
+
+        let (status, _) = lint("$title$\n", Some("{"));
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+    }
+
+    #[test]
+    fn test_template_partials() {
+        let dir = std::env::temp_dir().join(format!("pampa-ffi-partials-{}", std::process::id()));
+        std::fs::create_dir_all(dir.join("lib")).unwrap();
+        std::fs::write(dir.join("doc.html"), "$header()$ $missing()$").unwrap();
+        std::fs::write(dir.join("lib/header.html"), "$title$").unwrap();
+        let root = std::ffi::CString::new(dir.join("doc.html").to_str().unwrap()).unwrap();
+        let search = std::ffi::CString::new(dir.join("lib").to_str().unwrap()).unwrap();
+
+        let (status, json, _) =
+            call(unsafe { pampa_template_partials(root.as_ptr(), search.as_ptr()) });
+        assert_eq!(status, PAMPA_OK);
+        let graph: serde_json::Value = serde_json::from_str(&json).unwrap();
+        assert_eq!(graph["nodes"][1]["status"], "ok");
+        assert_eq!(graph["nodes"][2]["status"], "missing");
+        assert_eq!(graph["edges"][0]["name"], "header");
+        std::fs::remove_dir_all(&dir).unwrap();
+
+        let (status, _, _) =
+            call(unsafe { pampa_template_partials(std::ptr::null(), std::ptr::null()) });
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+    }
+
+    #[test]
+    fn test_template_schema() {
+        // The Go bindings check the same fixture, so both sides agree
+        let testdata =
+            std::path::Path::new(env!("CARGO_MANIFEST_DIR")).join("bindings/go/testdata");
+        let root = std::ffi::CString::new(testdata.join("schema.html").to_str().unwrap()).unwrap();
+        let (status, json, _) = call(unsafe { pampa_template_schema(root.as_ptr()) });
+        assert_eq!(status, PAMPA_OK);
+        let expected = std::fs::read_to_string(testdata.join("schema.json")).unwrap();
+        assert_eq!(
+            serde_json::from_str::<serde_json::Value>(&json).unwrap(),
+            serde_json::from_str::<serde_json::Value>(&expected).unwrap()
+        );
+
+        let missing =
+            std::ffi::CString::new(testdata.join("missing.html").to_str().unwrap()).unwrap();
+        let (status, _, diagnostics) = call(unsafe { pampa_template_schema(missing.as_ptr()) });
+        assert_eq!(status, PAMPA_ERROR);
+        assert_eq!(diagnostics[0]["kind"], "error");
+
+        let (status, _, _) = call(unsafe { pampa_template_schema(std::ptr::null()) });
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+    }
+
+    fn render(
+        variables: &str,
+        format: Option<&CStr>,
+        flags: u32,
+    ) -> (i32, String, serde_json::Value) {
+        // The Go bindings render the same fixture
+        let testdata =
+            std::path::Path::new(env!("CARGO_MANIFEST_DIR")).join("bindings/go/testdata");
+        let root = std::ffi::CString::new(testdata.join("render.html").to_str().unwrap()).unwrap();
+        call(unsafe {
+            pampa_template_render(
+                root.as_ptr(),
+                variables.as_ptr() as *const c_char,
+                variables.len(),
+                format.map_or(std::ptr::null(), CStr::as_ptr),
+                flags,
+            )
+        })
+    }
+
+    #[test]
+    fn test_template_render() {
+        let variables = r#"{"title": "Notes", "author": [{"name": "Ann"}, {"name": "Bo"}]}"#;
+        let (status, output, diagnostics) = render(variables, None, 0);
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(output, "<h1>NOTES</h1>\n<p>Ann, Bo</p>\n");
+        assert_eq!(diagnostics, serde_json::json!([]));
+
+        // Undefined variables are warnings, or errors in strict mode
+        let (status, output, diagnostics) = render("{}", None, 0);
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(output, "<h1></h1>\n<p></p>\n");
+        assert_eq!(diagnostics[0]["code"], "Q-10-2");
+        let (status, _, _) = render("{}", None, PAMPA_STRICT);
+        assert_eq!(status, PAMPA_ERROR);
+
+        let (status, _, _) = render("[]", None, 0);
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+        let (status, _, _) = render("{}", Some(c"docx"), 0);
+        assert_eq!(status, PAMPA_ERROR_ARGUMENT);
+    }
+
+    #[test]
+    fn test_template_render_pandoc_metadata() {
+        let (_, doc, _) = parse("---\ntitle: Some *notes*\n---\n");
+        let (status, output, _) = render(&doc, None, 0);
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(output, "<h1>SOME <EM>NOTES</EM></h1>\n<p></p>\n");
+        let (status, output, _) = render(&doc, Some(c"plain"), 0);
+        assert_eq!(status, PAMPA_OK);
+        assert_eq!(output, "<h1>SOME NOTES</h1>\n<p></p>\n");
+    }
+
+    #[test]
+    fn test_versions() {
+        let version = unsafe { CStr::from_ptr(pampa_version()) };
+        assert_eq!(version.to_str().unwrap(), env!("CARGO_PKG_VERSION"));
+        assert_eq!(pampa_abi_version(), PAMPA_ABI_VERSION);
+    }
+}
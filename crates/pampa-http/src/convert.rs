@@ -0,0 +1,360 @@
+/*
+ * convert.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! The conversion of one document, with the readers and writers of `pampa`
+//! and the defaults of its command line.
+
+use pampa::pandoc::{ASTContext, Pandoc};
+use pampa::transforms::{self, SanitizeOptions};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::{readers, utils, writers};
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+
+/// The formats a document can be read from
+pub const INPUT_FORMATS: &[&str] = &[
+    "qmd",
+    "markdown",
+    "json",
+    "commonmark",
+    "gfm",
+    "ipynb",
+    "org",
+    "rst",
+];
+
+/// The formats a document can be written in
+pub const OUTPUT_FORMATS: &[&str] = &[
+    "html", "revealjs", "latex", "typst", "qmd", "markdown", "json", "native", "ipynb", "plain",
+    "docx", "epub",
+];
+
+/// How to convert a document
+#[derive(Debug, Clone)]
+pub struct ConvertOptions {
+    pub from: String,
+    pub to: String,
+    /// The name of the document in source locations
+    pub filename: String,
+    /// Keep source locations in json output, and source tracking
+    /// attributes in html, as the `sourcepos` extension does
+    pub sourcepos: bool,
+    /// Resolve cross-references, as `--crossref` does
+    pub crossref: bool,
+    /// Audit the document for accessibility, as `--a11y` does
+    pub a11y: bool,
+    /// Treat the document as untrusted, as `--sanitize` does
+    pub sanitize: Option<SanitizeOptions>,
+}
+
+impl ConvertOptions {
+    pub fn new(from: impl Into<String>, to: impl Into<String>) -> Self {
+        Self {
+            from: from.into(),
+            to: to.into(),
+            filename: "<input>".to_string(),
+            sourcepos: false,
+            crossref: false,
+            a11y: false,
+            sanitize: None,
+        }
+    }
+}
+
+/// A converted document, and the warnings of the conversion
+#[derive(Debug)]
+pub struct Converted {
+    pub output: Vec<u8>,
+    pub diagnostics: Vec<DiagnosticMessage>,
+}
+
+/// Why a document wasn't converted
+#[derive(Debug)]
+pub enum ConvertError {
+    /// The request itself is wrong: an unknown format, or a document that
+    /// isn't UTF-8
+    Invalid(String),
+    /// The document couldn't be read or written; the diagnostics say why
+    Failed(Vec<DiagnosticMessage>),
+}
+
+/// Whether the output of a format is binary rather than text
+pub fn is_binary(to: &str) -> bool {
+    matches!(to, "docx" | "epub")
+}
+
+/// The media type of the output of a format
+pub fn content_type(to: &str) -> &'static str {
+    match to {
+        "html" | "revealjs" => "text/html; charset=utf-8",
+        "latex" => "text/x-tex; charset=utf-8",
+        "qmd" | "markdown" => "text/markdown; charset=utf-8",
+        "json" => "application/json",
+        "ipynb" => "application/x-ipynb+json",
+        "docx" => "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+        "epub" => "application/epub+zip",
+        _ => "text/plain; charset=utf-8",
+    }
+}
+
+/// An error when a format of `options` is unknown
+pub fn check_formats(options: &ConvertOptions) -> Result<(), ConvertError> {
+    if !INPUT_FORMATS.contains(&options.from.as_str()) {
+        return Err(ConvertError::Invalid(format!(
+            "Unknown input format: {}",
+            options.from
+        )));
+    }
+    if !OUTPUT_FORMATS.contains(&options.to.as_str()) {
+        return Err(ConvertError::Invalid(format!(
+            "Unknown output format: {}",
+            options.to
+        )));
+    }
+    Ok(())
+}
+
+/// Read `input`, transform and write it as `options` say.
+pub fn convert(input: &[u8], options: &ConvertOptions) -> Result<Converted, ConvertError> {
+    check_formats(options)?;
+    let Ok(input) = std::str::from_utf8(input) else {
+        return Err(ConvertError::Invalid(
+            "The document is not UTF-8".to_string(),
+        ));
+    };
+    let input = utils::line_endings::normalize(input);
+
+    if let Some(sanitize) = &options.sanitize
+        && let Err(diagnostic) = transforms::check_input_size(input.as_bytes(), sanitize)
+    {
+        return Err(ConvertError::Failed(vec![diagnostic]));
+    }
+
+    let mut diagnostics = Vec::new();
+    let (mut doc, context) = read(&input, options, &mut diagnostics)?;
+    if let Some(sanitize) = &options.sanitize {
+        diagnostics.extend(transforms::sanitize(&mut doc, sanitize).diagnostics());
+    }
+    if options.crossref {
+        let mut collector = DiagnosticCollector::new();
+        doc.blocks = transforms::resolve_crossrefs(
+            std::mem::take(&mut doc.blocks),
+            &doc.meta,
+            &mut collector,
+        );
+        diagnostics.extend_from_slice(collector.diagnostics());
+    }
+    if options.a11y {
+        diagnostics.extend(transforms::audit_accessibility(&doc).diagnostics());
+    }
+
+    match write(&doc, &context, options) {
+        Ok(output) => Ok(Converted {
+            output,
+            diagnostics,
+        }),
+        Err(errors) => {
+            diagnostics.extend(errors);
+            Err(ConvertError::Failed(diagnostics))
+        }
+    }
+}
+
+/// Read the document, adding the warnings to `diagnostics`
+fn read(
+    input: &str,
+    options: &ConvertOptions,
+    diagnostics: &mut Vec<DiagnosticMessage>,
+) -> Result<(Pandoc, ASTContext), ConvertError> {
+    match options.from.as_str() {
+        "qmd" | "markdown" => {
+            let result = readers::qmd::read(
+                input.as_bytes(),
+                false,
+                &options.filename,
+                &mut std::io::sink(),
+                true,
+                None,
+            );
+            let (mut doc, context, warnings) = result.map_err(ConvertError::Failed)?;
+            diagnostics.extend(warnings);
+            // Footnote references are joined with their definitions, as
+            // the footnotes extension of the command line does
+            doc.blocks = transforms::resolve_footnotes(
+                std::mem::take(&mut doc.blocks),
+                &context.source_context,
+            );
+            Ok((doc, context))
+        }
+        "json" => readers::json::read(&mut input.as_bytes()).map_err(|e| {
+            ConvertError::Failed(vec![
+                DiagnosticMessageBuilder::error("Invalid Pandoc JSON")
+                    .problem(format!("Error reading JSON: {}", e))
+                    .build(),
+            ])
+        }),
+        "commonmark" => Ok(readers::commonmark::read(input, &options.filename)),
+        "gfm" => Ok(readers::commonmark::read_gfm(input, &options.filename)),
+        "ipynb" => match readers::ipynb::read(input, &options.filename) {
+            Ok((doc, context, warnings)) => {
+                diagnostics.extend(warnings);
+                Ok((doc, context))
+            }
+            Err(readers::ipynb::IpynbReadError::CellParse {
+                diagnostics: errors,
+                ..
+            }) => Err(ConvertError::Failed(errors)),
+            Err(e) => Err(ConvertError::Failed(vec![
+                DiagnosticMessageBuilder::error("Invalid notebook")
+                    .problem(format!("Error reading notebook: {}", e))
+                    .build(),
+            ])),
+        },
+        "org" | "rst" => {
+            let (doc, context, warnings) = if options.from == "org" {
+                readers::org::read(input, &options.filename)
+            } else {
+                readers::rst::read(input, &options.filename)
+            };
+            diagnostics.extend(warnings);
+            Ok((doc, context))
+        }
+        other => unreachable!("input format {} is checked by convert", other),
+    }
+}
+
+fn write(
+    doc: &Pandoc,
+    context: &ASTContext,
+    options: &ConvertOptions,
+) -> Result<Vec<u8>, Vec<DiagnosticMessage>> {
+    let format = options.to.as_str();
+    let mut buf = Vec::new();
+    match format {
+        "json" => {
+            let config = writers::json::JsonConfig {
+                include_inline_locations: options.sourcepos,
+                include_block_ids: false,
+            };
+            writers::json::write_with_config(doc, context, &mut buf, &config)
+        }
+        "native" => writers::native::write(doc, context, &mut buf),
+        "qmd" | "markdown" => writers::qmd::write(doc, &mut buf),
+        "ipynb" => writers::ipynb::write(doc, &mut buf),
+        "html" if options.sourcepos => io_result(
+            writers::html::write_with_source_tracking(doc, context, &mut buf),
+            format,
+        ),
+        "html" => io_result(writers::html::write(doc, context, &mut buf), format),
+        "revealjs" => io_result(writers::revealjs::write(doc, &mut buf), format),
+        "latex" => io_result(writers::latex::write(doc, &mut buf), format),
+        "typst" => io_result(writers::typst::write(doc, &mut buf), format),
+        "docx" => io_result(writers::docx::write(doc, &mut buf), format),
+        "epub" => io_result(writers::epub::write(doc, &mut buf), format),
+        "plain" => {
+            let (text, _diagnostics) = writers::plaintext::blocks_to_string(&doc.blocks);
+            buf.extend_from_slice(text.as_bytes());
+            Ok(())
+        }
+        other => unreachable!("output format {} is checked by convert", other),
+    }?;
+    Ok(buf)
+}
+
+fn io_result(result: std::io::Result<()>, format: &str) -> Result<(), Vec<DiagnosticMessage>> {
+    result.map_err(|e| {
+        vec![
+            DiagnosticMessageBuilder::error("IO error during write")
+                .with_code("Q-3-1")
+                .problem(format!("Failed to write {} output: {}", format, e))
+                .build(),
+        ]
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn convert_str(input: &str, options: &ConvertOptions) -> String {
+        let converted = convert(input.as_bytes(), options).unwrap();
+        String::from_utf8(converted.output).unwrap()
+    }
+
+    #[test]
+    fn test_qmd_to_html() {
+        let html = convert_str("Some *text*.\n", &ConvertOptions::new("qmd", "html"));
+        assert!(html.contains("<em>text</em>"), "{}", html);
+    }
+
+    #[test]
+    fn test_footnotes_are_joined() {
+        let json = convert_str(
+            "Text[^1].\n\n[^1]: The note.\n",
+            &ConvertOptions::new("qmd", "json"),
+        );
+        assert!(json.contains("\"Note\""), "{}", json);
+    }
+
+    #[test]
+    fn test_crossref() {
+        let mut options = ConvertOptions::new("qmd", "html");
+        options.crossref = true;
+        let html = convert_str("# Intro {#sec-intro}\n\nSee @sec-intro.\n", &options);
+        assert!(html.contains("href=\"#sec-intro\""), "{}", html);
+    }
+
+    #[test]
+    fn test_a11y() {
+        let mut options = ConvertOptions::new("qmd", "html");
+        options.a11y = true;
+        let converted = convert(b"![](cat.png)\n", &options).unwrap();
+        assert_eq!(converted.diagnostics.len(), 1);
+        assert_eq!(converted.diagnostics[0].code.as_deref(), Some("Q-2-46"));
+    }
+
+    #[test]
+    fn test_sanitize() {
+        let mut options = ConvertOptions::new("qmd", "html");
+        options.sanitize = Some(SanitizeOptions::default());
+        let converted = convert(b"```{=html}\n<script>alert(1)</script>\n```\n", &options).unwrap();
+        let html = String::from_utf8(converted.output).unwrap();
+        assert!(!html.contains("<script>"), "{}", html);
+        assert_eq!(converted.diagnostics[0].code.as_deref(), Some("Q-2-42"));
+
+        options.sanitize = Some(SanitizeOptions {
+            max_input_bytes: 4,
+            ..SanitizeOptions::default()
+        });
+        let Err(ConvertError::Failed(diagnostics)) = convert(b"Too long\n", &options) else {
+            panic!("a document over the limit is refused");
+        };
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-2-43"));
+    }
+
+    #[test]
+    fn test_errors() {
+        let options = ConvertOptions::new("qmd", "html");
+        assert!(matches!(
+            convert(b"[}no]{.hello}\n", &options),
+            Err(ConvertError::Failed(_))
+        ));
+        assert!(matches!(
+            convert(b"\xff\n", &options),
+            Err(ConvertError::Invalid(_))
+        ));
+        assert!(matches!(
+            convert(b"Text\n", &ConvertOptions::new("qmd", "pdf")),
+            Err(ConvertError::Invalid(_))
+        ));
+    }
+
+    #[test]
+    fn test_every_output_format() {
+        for to in OUTPUT_FORMATS {
+            let converted = convert(b"# Title\n\nText.\n", &ConvertOptions::new("qmd", *to));
+            assert!(converted.is_ok(), "{}: {:?}", to, converted);
+        }
+    }
+}
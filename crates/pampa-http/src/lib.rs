@@ -0,0 +1,25 @@
+/*
+ * lib.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! An HTTP service converting documents with pampa, for web clients that
+//! can't run the WASM build. It is meant to be deployed next to the hub.
+//!
+//! - `POST /convert?from=…&to=…` converts the document of the request body
+//!   and answers with the output, or with the output and its diagnostics
+//!   as JSON when `diagnostics=true`
+//! - `GET /formats` lists the input and output formats
+//! - `GET /health` reports that the service is up
+//! - `GET /openapi.json` is the OpenAPI description of all of these
+//!
+//! Conversions read and write as the `pampa` command line does. Documents
+//! are untrusted unless the service is started with `--trusted`: they are
+//! sanitized as with `pampa --sanitize`. Requests are limited in body size,
+//! in how many run at once and in how long they take.
+
+pub mod convert;
+pub mod server;
+
+pub use convert::{ConvertError, ConvertOptions, Converted, convert};
+pub use server::{ServiceConfig, build_router, run_server};
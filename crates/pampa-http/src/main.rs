@@ -0,0 +1,73 @@
+/*
+ * main.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! pampa-http binary - the conversion service
+
+use std::time::Duration;
+
+use clap::Parser;
+use tracing_subscriber::{layer::SubscriberExt, util::SubscriberInitExt};
+
+use pampa_http::ServiceConfig;
+
+#[derive(Parser, Debug)]
+#[command(name = "pampa-http")]
+#[command(about = "HTTP service converting documents with pampa")]
+struct Args {
+    /// Port to listen on
+    #[arg(short = 'P', long, default_value = "3100")]
+    port: u16,
+
+    /// Host to bind to
+    #[arg(short = 'H', long, default_value = "127.0.0.1")]
+    host: String,
+
+    /// Largest document accepted, in bytes. Default: 4 MiB.
+    #[arg(long, default_value = "4194304")]
+    max_body_bytes: usize,
+
+    /// Most conversions run at once; more are refused with 503.
+    /// Default: the number of CPUs.
+    #[arg(long, value_parser = clap::value_parser!(u32).range(1..))]
+    max_concurrent: Option<u32>,
+
+    /// Longest an upload, and then its conversion, are waited for, in
+    /// seconds.
+    /// Default: 30 seconds.
+    #[arg(long, default_value = "30")]
+    timeout: u64,
+
+    /// Convert documents as they are, with raw content, scripts and file
+    /// references. Only for services that convert their own documents.
+    #[arg(long)]
+    trusted: bool,
+}
+
+#[tokio::main]
+async fn main() -> anyhow::Result<()> {
+    // Initialize tracing
+    tracing_subscriber::registry()
+        .with(
+            tracing_subscriber::EnvFilter::try_from_default_env()
+                .unwrap_or_else(|_| "pampa_http=info,tower_http=debug".into()),
+        )
+        .with(tracing_subscriber::fmt::layer())
+        .init();
+
+    let args = Args::parse();
+    let defaults = ServiceConfig::default();
+    let config = ServiceConfig {
+        host: args.host,
+        port: args.port,
+        max_body_bytes: args.max_body_bytes,
+        max_concurrent: args
+            .max_concurrent
+            .map_or(defaults.max_concurrent, |n| n as usize),
+        timeout: Duration::from_secs(args.timeout),
+        trusted: args.trusted,
+    };
+
+    pampa_http::run_server(config).await
+}
@@ -0,0 +1,475 @@
+/*
+ * server.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! HTTP server setup and routing
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use axum::{
+    Json, Router,
+    body::Body,
+    extract::{Query, State},
+    http::{StatusCode, header},
+    response::{IntoResponse, Response},
+    routing::{get, post},
+};
+use base64::Engine;
+use http_body_util::{BodyExt, LengthLimitError, Limited};
+use pampa::transforms::SanitizeOptions;
+use quarto_error_reporting::DiagnosticMessage;
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use tokio::net::TcpListener;
+use tokio::sync::Semaphore;
+use tower_http::cors::CorsLayer;
+use tower_http::trace::TraceLayer;
+use tracing::info;
+
+use crate::convert::{self, ConvertError, ConvertOptions, INPUT_FORMATS, OUTPUT_FORMATS};
+
+/// The OpenAPI description of the service, served at `/openapi.json`
+pub const OPENAPI: &str = include_str!("../openapi.json");
+
+/// How the service runs, and the limits of its requests
+#[derive(Debug, Clone)]
+pub struct ServiceConfig {
+    pub host: String,
+    pub port: u16,
+    /// Largest document read, in bytes; larger ones are refused with 413
+    pub max_body_bytes: usize,
+    /// Most conversions run at once; more are refused with 503
+    pub max_concurrent: usize,
+    /// Longest a document's upload, and then its conversion, are waited
+    /// for. A slower upload is answered with 408. A slower conversion is
+    /// answered with 504, and keeps its place in `max_concurrent` until it
+    /// finishes.
+    pub timeout: Duration,
+    /// Convert documents as they are. Otherwise they are untrusted, and
+    /// sanitized as `pampa --sanitize` does.
+    pub trusted: bool,
+}
+
+impl Default for ServiceConfig {
+    fn default() -> Self {
+        Self {
+            host: "127.0.0.1".to_string(),
+            port: 3100,
+            max_body_bytes: SanitizeOptions::default().max_input_bytes,
+            max_concurrent: std::thread::available_parallelism().map_or(4, |n| n.get()),
+            timeout: Duration::from_secs(30),
+            trusted: false,
+        }
+    }
+}
+
+struct ServiceState {
+    config: ServiceConfig,
+    permits: Arc<Semaphore>,
+}
+
+type SharedState = Arc<ServiceState>;
+
+/// Health check response
+#[derive(Serialize)]
+struct HealthResponse {
+    status: &'static str,
+    version: &'static str,
+}
+
+/// The formats of `/convert`
+#[derive(Serialize)]
+struct FormatsResponse {
+    from: &'static [&'static str],
+    to: &'static [&'static str],
+}
+
+/// A conversion with its diagnostics, for `diagnostics=true`
+#[derive(Serialize)]
+struct ConvertResponse {
+    output: String,
+    /// `utf-8`, or `base64` for binary formats
+    output_encoding: &'static str,
+    diagnostics: Vec<Value>,
+}
+
+/// Error response
+#[derive(Serialize)]
+struct ErrorResponse {
+    error: String,
+    diagnostics: Vec<Value>,
+}
+
+/// The query of `/convert`
+#[derive(Deserialize)]
+struct ConvertQuery {
+    #[serde(default = "default_from")]
+    from: String,
+    to: Option<String>,
+    /// The name of the document in diagnostics
+    filename: Option<String>,
+    #[serde(default)]
+    sourcepos: bool,
+    #[serde(default)]
+    crossref: bool,
+    #[serde(default)]
+    a11y: bool,
+    /// Answer with the output and the diagnostics as JSON
+    #[serde(default)]
+    diagnostics: bool,
+}
+
+fn default_from() -> String {
+    "qmd".to_string()
+}
+
+fn diagnostics_json(diagnostics: &[DiagnosticMessage]) -> Vec<Value> {
+    diagnostics
+        .iter()
+        .map(|diagnostic| diagnostic.to_json())
+        .collect()
+}
+
+fn error_response(
+    status: StatusCode,
+    error: impl Into<String>,
+    diagnostics: &[DiagnosticMessage],
+) -> Response {
+    let body = ErrorResponse {
+        error: error.into(),
+        diagnostics: diagnostics_json(diagnostics),
+    };
+    (status, Json(body)).into_response()
+}
+
+/// Health check endpoint
+async fn health() -> impl IntoResponse {
+    Json(HealthResponse {
+        status: "ok",
+        version: env!("CARGO_PKG_VERSION"),
+    })
+}
+
+async fn formats() -> impl IntoResponse {
+    Json(FormatsResponse {
+        from: INPUT_FORMATS,
+        to: OUTPUT_FORMATS,
+    })
+}
+
+async fn openapi() -> impl IntoResponse {
+    ([(header::CONTENT_TYPE, "application/json")], OPENAPI)
+}
+
+/// Convert the document of the request body, read as it arrives
+async fn convert_document(
+    State(state): State<SharedState>,
+    Query(query): Query<ConvertQuery>,
+    body: Body,
+) -> Response {
+    let Some(to) = query.to else {
+        return error_response(StatusCode::BAD_REQUEST, "Missing output format `to`", &[]);
+    };
+    let mut options = ConvertOptions::new(query.from, to);
+    if let Some(filename) = query.filename {
+        options.filename = filename;
+    }
+    options.sourcepos = query.sourcepos;
+    options.crossref = query.crossref;
+    options.a11y = query.a11y;
+    if !state.config.trusted {
+        options.sanitize = Some(SanitizeOptions {
+            max_input_bytes: state.config.max_body_bytes,
+            ..SanitizeOptions::default()
+        });
+    }
+    if let Err(ConvertError::Invalid(message)) = convert::check_formats(&options) {
+        return error_response(StatusCode::BAD_REQUEST, message, &[]);
+    }
+
+    // The body is read before a conversion permit is taken, and in no more
+    // time than a conversion, so that slow uploads can't hold every permit
+    let max_body_bytes = state.config.max_body_bytes;
+    let read = tokio::time::timeout(
+        state.config.timeout,
+        Limited::new(body, max_body_bytes).collect(),
+    );
+    let input = match read.await {
+        Err(_) => {
+            return error_response(
+                StatusCode::REQUEST_TIMEOUT,
+                "The document took too long to arrive",
+                &[],
+            );
+        }
+        Ok(Ok(collected)) => collected.to_bytes(),
+        Ok(Err(e)) if e.downcast_ref::<LengthLimitError>().is_some() => {
+            return error_response(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                format!("The document is larger than {} bytes", max_body_bytes),
+                &[],
+            );
+        }
+        Ok(Err(e)) => {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                format!("Failed to read the document: {}", e),
+                &[],
+            );
+        }
+    };
+
+    let Ok(permit) = state.permits.clone().try_acquire_owned() else {
+        let mut response = error_response(
+            StatusCode::SERVICE_UNAVAILABLE,
+            "Too many conversions are running; try again later",
+            &[],
+        );
+        response
+            .headers_mut()
+            .insert(header::RETRY_AFTER, header::HeaderValue::from_static("1"));
+        return response;
+    };
+
+    let to = options.to.clone();
+    let task = tokio::task::spawn_blocking(move || {
+        let _permit = permit;
+        convert::convert(&input, &options)
+    });
+    let result = match tokio::time::timeout(state.config.timeout, task).await {
+        Ok(Ok(result)) => result,
+        Ok(Err(e)) => {
+            return error_response(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("The conversion panicked: {}", e),
+                &[],
+            );
+        }
+        Err(_) => {
+            return error_response(
+                StatusCode::GATEWAY_TIMEOUT,
+                "The conversion took too long",
+                &[],
+            );
+        }
+    };
+
+    match result {
+        Ok(converted) if query.diagnostics => {
+            let (output, output_encoding) = if convert::is_binary(&to) {
+                (
+                    base64::engine::general_purpose::STANDARD.encode(&converted.output),
+                    "base64",
+                )
+            } else {
+                (
+                    String::from_utf8_lossy(&converted.output).into_owned(),
+                    "utf-8",
+                )
+            };
+            Json(ConvertResponse {
+                output,
+                output_encoding,
+                diagnostics: diagnostics_json(&converted.diagnostics),
+            })
+            .into_response()
+        }
+        Ok(converted) => (
+            [(header::CONTENT_TYPE, convert::content_type(&to))],
+            converted.output,
+        )
+            .into_response(),
+        Err(ConvertError::Invalid(message)) => {
+            error_response(StatusCode::BAD_REQUEST, message, &[])
+        }
+        Err(ConvertError::Failed(diagnostics)) => error_response(
+            StatusCode::UNPROCESSABLE_ENTITY,
+            "The document could not be converted",
+            &diagnostics,
+        ),
+    }
+}
+
+async fn not_found() -> Response {
+    error_response(StatusCode::NOT_FOUND, "Not found", &[])
+}
+
+/// Build the axum router
+pub fn build_router(config: ServiceConfig) -> Router {
+    let state = Arc::new(ServiceState {
+        permits: Arc::new(Semaphore::new(config.max_concurrent)),
+        config,
+    });
+    Router::new()
+        .route("/health", get(health))
+        .route("/formats", get(formats))
+        .route("/openapi.json", get(openapi))
+        .route("/convert", post(convert_document))
+        .fallback(not_found)
+        .layer(TraceLayer::new_for_http())
+        // Web clients call the service from pages served elsewhere
+        .layer(CorsLayer::permissive())
+        .with_state(state)
+}
+
+/// Run the service until Ctrl-C.
+pub async fn run_server(config: ServiceConfig) -> anyhow::Result<()> {
+    let addr = format!("{}:{}", config.host, config.port);
+    let router = build_router(config);
+    let listener = TcpListener::bind(&addr).await?;
+    info!(%addr, "Conversion service listening");
+    axum::serve(listener, router)
+        .with_graceful_shutdown(async {
+            let _ = tokio::signal::ctrl_c().await;
+            info!("Conversion service shutting down...");
+        })
+        .await?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::Request;
+    use tower::ServiceExt;
+
+    async fn send(config: ServiceConfig, request: Request<Body>) -> (StatusCode, Vec<u8>) {
+        let response = build_router(config).oneshot(request).await.unwrap();
+        let status = response.status();
+        let body = response.into_body().collect().await.unwrap().to_bytes();
+        (status, body.to_vec())
+    }
+
+    async fn post_convert(config: ServiceConfig, query: &str, body: &str) -> (StatusCode, Value) {
+        let request = Request::post(format!("/convert?{}", query))
+            .body(Body::from(body.to_string()))
+            .unwrap();
+        let (status, body) = send(config, request).await;
+        (status, serde_json::from_slice(&body).unwrap_or(Value::Null))
+    }
+
+    #[tokio::test]
+    async fn test_convert_to_html() {
+        let request = Request::post("/convert?from=qmd&to=html")
+            .body(Body::from("Some *text*.\n"))
+            .unwrap();
+        let response = build_router(ServiceConfig::default())
+            .oneshot(request)
+            .await
+            .unwrap();
+        assert_eq!(response.status(), StatusCode::OK);
+        assert_eq!(
+            response.headers()[header::CONTENT_TYPE],
+            "text/html; charset=utf-8"
+        );
+        let body = response.into_body().collect().await.unwrap().to_bytes();
+        assert!(String::from_utf8_lossy(&body).contains("<em>text</em>"));
+    }
+
+    #[tokio::test]
+    async fn test_diagnostics_response() {
+        let (status, body) = post_convert(
+            ServiceConfig::default(),
+            "to=html&diagnostics=true",
+            "```{=html}\n<b>raw</b>\n```\n",
+        )
+        .await;
+        assert_eq!(status, StatusCode::OK);
+        assert_eq!(body["output_encoding"], "utf-8");
+        // Documents are untrusted unless the service is started trusted
+        assert_eq!(body["diagnostics"][0]["code"], "Q-2-42");
+
+        let (_, body) = post_convert(
+            ServiceConfig::default(),
+            "to=docx&diagnostics=true",
+            "Text.\n",
+        )
+        .await;
+        assert_eq!(body["output_encoding"], "base64");
+        let docx = base64::engine::general_purpose::STANDARD
+            .decode(body["output"].as_str().unwrap())
+            .unwrap();
+        assert!(docx.starts_with(b"PK"));
+    }
+
+    #[tokio::test]
+    async fn test_request_errors() {
+        let (status, body) = post_convert(ServiceConfig::default(), "from=qmd", "Text\n").await;
+        assert_eq!(status, StatusCode::BAD_REQUEST);
+        assert!(body["error"].is_string());
+
+        let (status, _) = post_convert(ServiceConfig::default(), "to=pdf", "Text\n").await;
+        assert_eq!(status, StatusCode::BAD_REQUEST);
+
+        let (status, body) =
+            post_convert(ServiceConfig::default(), "to=html", "[}no]{.hello}\n").await;
+        assert_eq!(status, StatusCode::UNPROCESSABLE_ENTITY);
+        assert_eq!(body["diagnostics"][0]["kind"], "error");
+    }
+
+    #[tokio::test]
+    async fn test_limits() {
+        let config = ServiceConfig {
+            max_body_bytes: 8,
+            ..ServiceConfig::default()
+        };
+        let (status, _) = post_convert(config, "to=html", "Longer than eight bytes\n").await;
+        assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+
+        // With no room for conversions, every one is refused
+        let config = ServiceConfig {
+            max_concurrent: 0,
+            ..ServiceConfig::default()
+        };
+        let (status, _) = post_convert(config, "to=html", "Text\n").await;
+        assert_eq!(status, StatusCode::SERVICE_UNAVAILABLE);
+    }
+
+    #[tokio::test]
+    async fn test_slow_uploads_time_out() {
+        let config = ServiceConfig {
+            timeout: Duration::from_millis(50),
+            ..ServiceConfig::default()
+        };
+        // A body that never ends
+        let body = Body::from_stream(futures::stream::pending::<Result<Vec<u8>, std::io::Error>>());
+        let request = Request::post("/convert?to=html").body(body).unwrap();
+        let (status, _) = send(config, request).await;
+        assert_eq!(status, StatusCode::REQUEST_TIMEOUT);
+    }
+
+    #[tokio::test]
+    async fn test_openapi_describes_the_service() {
+        let spec: Value = serde_json::from_str(OPENAPI).unwrap();
+        let mut paths: Vec<&str> = spec["paths"]
+            .as_object()
+            .unwrap()
+            .keys()
+            .map(String::as_str)
+            .collect();
+        paths.sort();
+        assert_eq!(paths, ["/convert", "/formats", "/health", "/openapi.json"]);
+        assert_eq!(spec["info"]["version"], env!("CARGO_PKG_VERSION"));
+
+        let parameters = spec["paths"]["/convert"]["post"]["parameters"]
+            .as_array()
+            .unwrap();
+        let formats = |name: &str| {
+            parameters
+                .iter()
+                .find(|parameter| parameter["name"] == name)
+                .unwrap()["schema"]["enum"]
+                .clone()
+        };
+        assert_eq!(formats("from"), serde_json::json!(INPUT_FORMATS));
+        assert_eq!(formats("to"), serde_json::json!(OUTPUT_FORMATS));
+
+        for path in ["/health", "/formats", "/openapi.json"] {
+            let request = Request::get(path).body(Body::empty()).unwrap();
+            let (status, _) = send(ServiceConfig::default(), request).await;
+            assert_eq!(status, StatusCode::OK, "{}", path);
+        }
+    }
+}
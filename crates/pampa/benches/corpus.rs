@@ -0,0 +1,148 @@
+//! Read, write and round-trip throughput over a corpus of real documents
+//!
+//! One benchmark per file and phase:
+//! - `read/<file>`: qmd to AST
+//! - `write/<file>`: AST to HTML
+//! - `roundtrip/<file>`: qmd to AST, back to qmd, and read again
+//!
+//! The corpus is the `.qmd` files under `PAMPA_BENCH_CORPUS`, by default
+//! `target/bench-corpus`, which `scripts/bench-corpus.py fetch` fills with
+//! the pinned sources. Without it, the round-trip test documents are used,
+//! which is enough to check the benchmarks run but not to compare numbers.
+//!
+//! Run with: cargo bench -p pampa --bench corpus
+//!
+//! Then `scripts/bench-corpus.py summarize` turns criterion's results into
+//! the JSON that `scripts/bench-corpus.py compare` checks against a baseline.
+
+use criterion::{Criterion, Throughput, criterion_group, criterion_main};
+use pampa::pandoc::{ASTContext, Pandoc};
+use pampa::readers;
+use pampa::utils::output::VerboseOutput;
+use pampa::writers;
+use std::hint::black_box;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+struct CorpusFile {
+    /// Path relative to the corpus root, used as the benchmark ID
+    name: String,
+    source: Vec<u8>,
+}
+
+fn corpus_root() -> PathBuf {
+    let manifest_dir = Path::new(env!("CARGO_MANIFEST_DIR"));
+    let root = std::env::var_os("PAMPA_BENCH_CORPUS")
+        .map(PathBuf::from)
+        .unwrap_or_else(|| manifest_dir.join("../../target/bench-corpus"));
+    if root.is_dir() {
+        root
+    } else {
+        eprintln!(
+            "No corpus at {}; benchmarking tests/roundtrip_tests instead \
+             (run scripts/bench-corpus.py fetch for the real corpus)",
+            root.display()
+        );
+        manifest_dir.join("tests/roundtrip_tests")
+    }
+}
+
+fn load_corpus(root: &Path) -> Vec<CorpusFile> {
+    let pattern = format!("{}/**/*.qmd", root.display());
+    glob::glob(&pattern)
+        .expect("corpus glob pattern is valid")
+        .filter_map(Result::ok)
+        .map(|path| CorpusFile {
+            name: path
+                .strip_prefix(root)
+                .unwrap_or(&path)
+                .to_string_lossy()
+                .replace('\\', "/"),
+            source: std::fs::read(&path).expect("corpus file is readable"),
+        })
+        .collect()
+}
+
+fn read(file: &CorpusFile) -> Option<(Pandoc, ASTContext)> {
+    read_bytes(&file.source, &file.name)
+}
+
+fn read_bytes(source: &[u8], name: &str) -> Option<(Pandoc, ASTContext)> {
+    let mut output = VerboseOutput::Sink(std::io::sink());
+    readers::qmd::read(source, false, name, &mut output, true, None)
+        .ok()
+        .map(|(pandoc, context, _warnings)| (pandoc, context))
+}
+
+fn write_qmd(pandoc: &Pandoc) -> Option<Vec<u8>> {
+    let mut buf = Vec::new();
+    writers::qmd::write(pandoc, &mut buf).ok().map(|_| buf)
+}
+
+fn bench_corpus(c: &mut Criterion) {
+    let corpus = load_corpus(&corpus_root());
+    // Files pampa can't read (or write back) yet are left out of the
+    // phases that need them, rather than failing the whole run.
+    let readable: Vec<(&CorpusFile, Pandoc, ASTContext)> = corpus
+        .iter()
+        .filter_map(|file| match read(file) {
+            Some((pandoc, context)) => Some((file, pandoc, context)),
+            None => {
+                eprintln!("Skipping {}: it doesn't read", file.name);
+                None
+            }
+        })
+        .collect();
+
+    let mut group = c.benchmark_group("read");
+    for (file, _, _) in &readable {
+        group.throughput(Throughput::Bytes(file.source.len() as u64));
+        group.bench_function(&file.name, |b| b.iter(|| read(black_box(file))));
+    }
+    group.finish();
+
+    let mut group = c.benchmark_group("write");
+    for (file, pandoc, context) in &readable {
+        group.throughput(Throughput::Bytes(file.source.len() as u64));
+        group.bench_function(&file.name, |b| {
+            b.iter(|| {
+                let mut buf = Vec::new();
+                writers::html::write(black_box(pandoc), context, &mut buf).unwrap();
+                buf
+            })
+        });
+    }
+    group.finish();
+
+    let mut group = c.benchmark_group("roundtrip");
+    for (file, pandoc, _) in &readable {
+        if write_qmd(pandoc)
+            .and_then(|qmd| read_bytes(&qmd, &file.name))
+            .is_none()
+        {
+            eprintln!("Skipping {} in roundtrip: it doesn't round-trip", file.name);
+            continue;
+        }
+        group.throughput(Throughput::Bytes(file.source.len() as u64));
+        group.bench_function(&file.name, |b| {
+            b.iter(|| {
+                let (pandoc, _) = read(black_box(file)).unwrap();
+                let qmd = write_qmd(&pandoc).unwrap();
+                read_bytes(&qmd, &file.name).unwrap()
+            })
+        });
+    }
+    group.finish();
+}
+
+// Few short samples per file: the corpus has hundreds of files, and the
+// regression gate compares totals as well as single files.
+criterion_group! {
+    name = benches;
+    config = Criterion::default()
+        .sample_size(10)
+        .warm_up_time(Duration::from_millis(500))
+        .measurement_time(Duration::from_secs(2));
+    targets = bench_corpus
+}
+criterion_main!(benches);
@@ -0,0 +1,37 @@
+/*
+ * qmd_roundtrip.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+#![no_main]
+#[macro_use]
+extern crate libfuzzer_sys;
+use pampa::readers;
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+
+fn read(input: &[u8]) -> Option<pampa::pandoc::Pandoc> {
+    readers::qmd::read(input, false, "<input>", &mut std::io::sink(), true, None)
+        .ok()
+        .map(|(pandoc, _, _)| pandoc)
+}
+
+// Any document the reader accepts must survive a trip through the qmd writer
+fuzz_target!(|data: &[u8]| {
+    let Ok(s) = std::str::from_utf8(data) else {
+        return;
+    };
+    let Some(original) = read(s.as_bytes()) else {
+        return;
+    };
+    let mut qmd = Vec::new();
+    if pampa::writers::qmd::write(&original, &mut qmd).is_err() {
+        return;
+    }
+    let reparsed = read(&qmd).unwrap_or_else(|| {
+        panic!(
+            "Writer output does not parse:\n{}",
+            String::from_utf8_lossy(&qmd)
+        )
+    });
+    expect_pd_ast_equal(&original, &reparsed);
+});
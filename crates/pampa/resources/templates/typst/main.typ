@@ -0,0 +1,73 @@
+// Based on Pandoc's default.typst template
+$if(title)$
+#set document(title: [$title$])
+$endif$
+#set page(
+  paper: "$if(papersize)$$papersize$$else$us-letter$endif$",
+$if(margin)$
+  margin: $margin$,
+$else$
+  margin: (x: 1.25in, y: 1.25in),
+$endif$
+  numbering: "1",
+)
+#set par(justify: true)
+#set text(
+  lang: "$lang$",
+$if(mainfont)$
+  font: ("$mainfont$",),
+$endif$
+$if(fontsize)$
+  size: $fontsize$,
+$endif$
+)
+$if(section-numbering)$
+#set heading(numbering: "$section-numbering$")
+$endif$
+#show figure.where(kind: table): set figure.caption(position: top)
+
+$for(header-includes)$
+$header-includes$
+
+$endfor$
+$if(title)$
+#align(center)[
+  #block(text(weight: "bold", 1.5em)[$title$])
+$if(subtitle)$
+  #block(text(1.2em)[$subtitle$])
+$endif$
+$for(author)$
+  #block[$author$]
+$endfor$
+$if(date)$
+  #block[$date$]
+$endif$
+]
+
+$endif$
+$if(abstract)$
+#block(inset: (x: 2em))[
+  #text(weight: "semibold")[Abstract] #h(1em) $abstract$
+]
+
+$endif$
+$for(include-before)$
+$include-before$
+
+$endfor$
+$if(toc)$
+#outline(
+  title: $if(toc-title)$[$toc-title$]$else$auto$endif$,
+  depth: $if(toc-depth)$$toc-depth$$else$3$endif$,
+)
+
+$endif$
+$body$
+$for(include-after)$
+
+$include-after$
+$endfor$
+$if(bibliography)$
+
+#bibliography(($for(bibliography)$"$bibliography$"$sep$, $endfor$)$if(csl)$, style: "$csl$"$endif$)
+$endif$
@@ -0,0 +1,460 @@
+/*
+ * ast_diff.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Structural diff of two documents, block by block.
+//!
+//! Text diffs of markdown are noisy: re-wrapping a paragraph changes every
+//! line of it without changing the document. This diff compares the ASTs
+//! instead, so that a review can say "paragraph 3 changed, the table is
+//! untouched".
+//!
+//! - Blocks are compared without source locations, and with soft breaks
+//!   counted as spaces, so moving text and re-wrapping it aren't changes
+//! - The block lists are aligned on their longest common subsequence of
+//!   equal blocks. Between two aligned blocks, a removed and an added block
+//!   of the same kind are one changed block; the rest are deletions and
+//!   insertions.
+//! - A changed Div, BlockQuote, Figure or fenced note is diffed in turn, so
+//!   the change is pinned to the blocks inside it
+//! - Metadata is compared as a whole
+//!
+//! [`locate`] gives the blocks of a diff by source location instead of by
+//! path, in the `data-loc` form of the HTML writer. A live preview uses it
+//! to patch the HTML of the old document into the new one in place.
+
+use crate::pandoc::{ASTContext, Block, Pandoc};
+use crate::utils::ast_equal::comparable_json;
+use crate::writers::html::ResolvedLocation;
+use crate::writers::incremental::block_source_info;
+use serde::Serialize;
+use serde_json::Value;
+use std::io::Write;
+
+/// What happened to a block
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum DiffOp {
+    Unchanged,
+    Changed,
+    Inserted,
+    Deleted,
+}
+
+/// The fate of one block. Paths are block indices from the top of the
+/// document, descending into containers.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BlockDiff {
+    pub op: DiffOp,
+    /// The Pandoc name of the block type, e.g. `Para`
+    pub kind: String,
+    /// Path of the block in the old document (`None` when inserted)
+    pub before: Option<Vec<usize>>,
+    /// Path of the block in the new document (`None` when deleted)
+    pub after: Option<Vec<usize>>,
+    /// For a changed container, the diff of its blocks
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<BlockDiff>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct DocumentDiff {
+    pub meta_changed: bool,
+    pub blocks: Vec<BlockDiff>,
+}
+
+impl DocumentDiff {
+    /// Whether the documents are the same
+    pub fn is_empty(&self) -> bool {
+        !self.meta_changed
+            && self
+                .blocks
+                .iter()
+                .all(|block| block.op == DiffOp::Unchanged)
+    }
+}
+
+/// Diff two documents.
+pub fn diff_documents(before: &Pandoc, after: &Pandoc) -> DocumentDiff {
+    let meta = |doc: &Pandoc| {
+        comparable_json(&Pandoc {
+            meta: doc.meta.clone(),
+            blocks: Vec::new(),
+        })
+    };
+    DocumentDiff {
+        meta_changed: meta(before) != meta(after),
+        blocks: diff_blocks(&before.blocks, &after.blocks),
+    }
+}
+
+/// Diff two lists of blocks.
+pub fn diff_blocks(before: &[Block], after: &[Block]) -> Vec<BlockDiff> {
+    diff_at(before, after, &[], &[])
+}
+
+/// A block as JSON without source locations, and its type name
+struct BlockKey {
+    kind: String,
+    json: Value,
+}
+
+fn block_key(block: &Block) -> BlockKey {
+    let mut json = comparable_json(&Pandoc {
+        meta: Default::default(),
+        blocks: vec![block.clone()],
+    });
+    let mut json = json["blocks"][0].take();
+    soft_breaks_to_spaces(&mut json);
+    BlockKey {
+        kind: json["t"].as_str().unwrap_or("Unknown").to_string(),
+        json,
+    }
+}
+
+fn soft_breaks_to_spaces(json: &mut Value) {
+    match json {
+        Value::Object(obj) => {
+            if obj.get("t").and_then(Value::as_str) == Some("SoftBreak") {
+                obj.insert("t".to_string(), Value::from("Space"));
+            }
+            obj.values_mut().for_each(soft_breaks_to_spaces);
+        }
+        Value::Array(items) => items.iter_mut().for_each(soft_breaks_to_spaces),
+        _ => {}
+    }
+}
+
+/// The blocks of a container whose changes are diffed block by block
+fn container_blocks(block: &Block) -> Option<&[Block]> {
+    match block {
+        Block::Div(div) => Some(&div.content),
+        Block::BlockQuote(quote) => Some(&quote.content),
+        Block::Figure(figure) => Some(&figure.content),
+        Block::NoteDefinitionFencedBlock(note) => Some(&note.content),
+        _ => None,
+    }
+}
+
+enum Edit {
+    Equal(usize, usize),
+    Delete(usize),
+    Insert(usize),
+}
+
+/// The edits turning `a` into `b`, from their longest common subsequence
+fn edits(a: &[BlockKey], b: &[BlockKey]) -> Vec<Edit> {
+    let equal = |i: usize, j: usize| a[i].json == b[j].json;
+    // Common ends are the usual case and keep the table small
+    let mut prefix = 0;
+    while prefix < a.len() && prefix < b.len() && equal(prefix, prefix) {
+        prefix += 1;
+    }
+    let mut suffix = 0;
+    while suffix < a.len() - prefix
+        && suffix < b.len() - prefix
+        && equal(a.len() - 1 - suffix, b.len() - 1 - suffix)
+    {
+        suffix += 1;
+    }
+    let (n, m) = (a.len() - prefix - suffix, b.len() - prefix - suffix);
+
+    // lcs[i][j]: length of the LCS of the middles of a[i..] and b[j..]
+    let mut lcs = vec![vec![0usize; m + 1]; n + 1];
+    for i in (0..n).rev() {
+        for j in (0..m).rev() {
+            lcs[i][j] = if equal(prefix + i, prefix + j) {
+                lcs[i + 1][j + 1] + 1
+            } else {
+                lcs[i + 1][j].max(lcs[i][j + 1])
+            };
+        }
+    }
+
+    let mut edits: Vec<Edit> = (0..prefix).map(|i| Edit::Equal(i, i)).collect();
+    let (mut i, mut j) = (0, 0);
+    while i < n || j < m {
+        if i < n && j < m && equal(prefix + i, prefix + j) {
+            edits.push(Edit::Equal(prefix + i, prefix + j));
+            i += 1;
+            j += 1;
+        } else if j < m && (i == n || lcs[i][j + 1] >= lcs[i + 1][j]) {
+            edits.push(Edit::Insert(prefix + j));
+            j += 1;
+        } else {
+            edits.push(Edit::Delete(prefix + i));
+            i += 1;
+        }
+    }
+    edits.extend((0..suffix).map(|k| Edit::Equal(prefix + n + k, prefix + m + k)));
+    edits
+}
+
+fn diff_at(
+    before: &[Block],
+    after: &[Block],
+    before_path: &[usize],
+    after_path: &[usize],
+) -> Vec<BlockDiff> {
+    let before_keys: Vec<BlockKey> = before.iter().map(block_key).collect();
+    let after_keys: Vec<BlockKey> = after.iter().map(block_key).collect();
+    let path = |parent: &[usize], index: usize| {
+        let mut path = parent.to_vec();
+        path.push(index);
+        path
+    };
+
+    let mut result = Vec::new();
+    let mut deleted: Vec<usize> = Vec::new();
+    let mut inserted: Vec<usize> = Vec::new();
+    let flush = |deleted: &mut Vec<usize>, inserted: &mut Vec<usize>, result: &mut Vec<_>| {
+        // Pair removed and added blocks of the same kind, in order
+        let (mut d, mut i) = (0, 0);
+        while d < deleted.len() || i < inserted.len() {
+            let old = deleted.get(d).copied();
+            let new = inserted.get(i).copied();
+            match (old, new) {
+                (Some(old), Some(new)) if before_keys[old].kind == after_keys[new].kind => {
+                    let children = match (
+                        container_blocks(&before[old]),
+                        container_blocks(&after[new]),
+                    ) {
+                        (Some(old_blocks), Some(new_blocks)) => diff_at(
+                            old_blocks,
+                            new_blocks,
+                            &path(before_path, old),
+                            &path(after_path, new),
+                        ),
+                        _ => Vec::new(),
+                    };
+                    result.push(BlockDiff {
+                        op: DiffOp::Changed,
+                        kind: after_keys[new].kind.clone(),
+                        before: Some(path(before_path, old)),
+                        after: Some(path(after_path, new)),
+                        children,
+                    });
+                    d += 1;
+                    i += 1;
+                }
+                // Keep the removed block for a later added block of its kind
+                (Some(old), Some(new))
+                    if inserted[i..]
+                        .iter()
+                        .any(|&later| after_keys[later].kind == before_keys[old].kind) =>
+                {
+                    result.push(BlockDiff {
+                        op: DiffOp::Inserted,
+                        kind: after_keys[new].kind.clone(),
+                        before: None,
+                        after: Some(path(after_path, new)),
+                        children: Vec::new(),
+                    });
+                    i += 1;
+                }
+                (Some(old), _) => {
+                    result.push(BlockDiff {
+                        op: DiffOp::Deleted,
+                        kind: before_keys[old].kind.clone(),
+                        before: Some(path(before_path, old)),
+                        after: None,
+                        children: Vec::new(),
+                    });
+                    d += 1;
+                }
+                (None, Some(new)) => {
+                    result.push(BlockDiff {
+                        op: DiffOp::Inserted,
+                        kind: after_keys[new].kind.clone(),
+                        before: None,
+                        after: Some(path(after_path, new)),
+                        children: Vec::new(),
+                    });
+                    i += 1;
+                }
+                (None, None) => unreachable!(),
+            }
+        }
+        deleted.clear();
+        inserted.clear();
+    };
+
+    for edit in edits(&before_keys, &after_keys) {
+        match edit {
+            Edit::Equal(old, new) => {
+                flush(&mut deleted, &mut inserted, &mut result);
+                result.push(BlockDiff {
+                    op: DiffOp::Unchanged,
+                    kind: after_keys[new].kind.clone(),
+                    before: Some(path(before_path, old)),
+                    after: Some(path(after_path, new)),
+                    children: Vec::new(),
+                });
+            }
+            Edit::Delete(old) => deleted.push(old),
+            Edit::Insert(new) => inserted.push(new),
+        }
+    }
+    flush(&mut deleted, &mut inserted, &mut result);
+    result
+}
+
+/// The block at a path, as given in a [`BlockDiff`]
+pub fn block_at<'a>(blocks: &'a [Block], path: &[usize]) -> Option<&'a Block> {
+    let (first, rest) = path.split_first()?;
+    let block = blocks.get(*first)?;
+    if rest.is_empty() {
+        Some(block)
+    } else {
+        block_at(container_blocks(block)?, rest)
+    }
+}
+
+/// A [`BlockDiff`] with its blocks given by source location, formatted as
+/// the HTML writer's `data-loc` attribute (`file:line:col-line:col`)
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct LocatedBlockDiff {
+    pub op: DiffOp,
+    pub kind: String,
+    /// Location of the block in the old document (`None` when inserted, or
+    /// when the block has no location)
+    pub before: Option<String>,
+    /// Location of the block in the new document
+    pub after: Option<String>,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<LocatedBlockDiff>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct LocatedDocumentDiff {
+    pub meta_changed: bool,
+    pub blocks: Vec<LocatedBlockDiff>,
+}
+
+/// Give the blocks of a diff by their source locations in the two
+/// documents, read with `before_context` and `after_context`.
+pub fn locate(
+    diff: &DocumentDiff,
+    before: &Pandoc,
+    before_context: &ASTContext,
+    after: &Pandoc,
+    after_context: &ASTContext,
+) -> LocatedDocumentDiff {
+    let sides = [(before, before_context), (after, after_context)];
+    LocatedDocumentDiff {
+        meta_changed: diff.meta_changed,
+        blocks: locate_blocks(&diff.blocks, &sides),
+    }
+}
+
+fn locate_blocks(
+    blocks: &[BlockDiff],
+    sides: &[(&Pandoc, &ASTContext); 2],
+) -> Vec<LocatedBlockDiff> {
+    let location = |path: &Option<Vec<usize>>, (doc, context): (&Pandoc, &ASTContext)| {
+        let block = block_at(&doc.blocks, path.as_deref()?)?;
+        ResolvedLocation::resolve(block_source_info(block), context).map(|loc| loc.to_data_loc())
+    };
+    blocks
+        .iter()
+        .map(|entry| LocatedBlockDiff {
+            op: entry.op,
+            kind: entry.kind.clone(),
+            before: location(&entry.before, sides[0]),
+            after: location(&entry.after, sides[1]),
+            children: locate_blocks(&entry.children, sides),
+        })
+        .collect()
+}
+
+/// Write the diff for people: one line per changed block, with the start
+/// of its text, and a count for each run of unchanged blocks.
+///
+/// ```text
+/// ~ 3    Para "The results show a…"
+/// + 4    Para "A new paragraph."
+///   (2 unchanged)
+/// - 4    Table (old)
+/// ```
+pub fn write_text(
+    diff: &DocumentDiff,
+    before: &Pandoc,
+    after: &Pandoc,
+    buf: &mut dyn Write,
+) -> std::io::Result<()> {
+    if diff.meta_changed {
+        writeln!(buf, "~ metadata")?;
+    }
+    write_text_blocks(&diff.blocks, before, after, 0, buf)
+}
+
+fn write_text_blocks(
+    blocks: &[BlockDiff],
+    before: &Pandoc,
+    after: &Pandoc,
+    depth: usize,
+    buf: &mut dyn Write,
+) -> std::io::Result<()> {
+    let indent = "  ".repeat(depth);
+    let mut unchanged = 0;
+    for entry in blocks {
+        if entry.op == DiffOp::Unchanged {
+            unchanged += 1;
+            continue;
+        }
+        if unchanged > 0 {
+            writeln!(buf, "  {}({} unchanged)", indent, unchanged)?;
+            unchanged = 0;
+        }
+        let (marker, path, doc) = match entry.op {
+            DiffOp::Deleted => ("-", entry.before.as_deref(), before),
+            DiffOp::Inserted => ("+", entry.after.as_deref(), after),
+            _ => ("~", entry.after.as_deref(), after),
+        };
+        let path = path.unwrap_or_default();
+        let number: Vec<String> = path.iter().map(|i| (i + 1).to_string()).collect();
+        let mut line = format!(
+            "{} {}{:<6} {}",
+            marker,
+            indent,
+            number.join("."),
+            entry.kind
+        );
+        if let Some(text) = block_at(&doc.blocks, path).map(summary)
+            && !text.is_empty()
+        {
+            line.push_str(&format!(" \"{}\"", text));
+        }
+        if entry.op == DiffOp::Deleted {
+            line.push_str(" (old)");
+        } else if entry.op == DiffOp::Changed && entry.before.as_deref() != Some(path) {
+            let was: Vec<String> = entry
+                .before
+                .iter()
+                .flatten()
+                .map(|i| (i + 1).to_string())
+                .collect();
+            line.push_str(&format!(" (was {})", was.join(".")));
+        }
+        writeln!(buf, "{}", line)?;
+        write_text_blocks(&entry.children, before, after, depth + 1, buf)?;
+    }
+    if unchanged > 0 && unchanged < blocks.len() {
+        writeln!(buf, "  {}({} unchanged)", indent, unchanged)?;
+    }
+    Ok(())
+}
+
+/// The start of a block's text
+fn summary(block: &Block) -> String {
+    const MAX_CHARS: usize = 40;
+    let (text, _diagnostics) = crate::writers::plaintext::blocks_to_string(&[block.clone()]);
+    let text = text.split_whitespace().collect::<Vec<_>>().join(" ");
+    if text.chars().count() > MAX_CHARS {
+        let start: String = text.chars().take(MAX_CHARS).collect();
+        format!("{}…", start.trim_end())
+    } else {
+        text
+    }
+}
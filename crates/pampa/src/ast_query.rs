@@ -0,0 +1,746 @@
+/*
+ * ast_query.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! A small selector language over the Pandoc AST, for questions about a
+//! document that don't deserve a filter: which level-2 headings there are,
+//! which links go to `http:` URLs, which code blocks have no language.
+//!
+//! ```text
+//! Header[level=2] > Str    Strs directly in level-2 headings
+//! Div.callout-note Para    paragraphs anywhere in note callouts
+//! Link[target^=http:]      links to http URLs
+//! CodeBlock, Code          code blocks and inline code
+//! ```
+//!
+//! As in CSS, a selector is a sequence of compound selectors joined by
+//! combinators: a space for "anywhere inside", `>` for "directly inside".
+//! A compound selector is an element type, or `*` for any, followed by any
+//! number of conditions:
+//!
+//! - `.name`: has the class
+//! - `#name`: has the identifier
+//! - `[name]`: has the property, with a value that isn't empty
+//! - `[name=value]`, and `!=`, `^=` (starts with), `$=` (ends with) and
+//!   `*=` (contains); the value may be quoted with `"` or `'`
+//!
+//! The properties are `level` (headings), `id`, `class` (any of the
+//! classes), `target` and `title` (links and images), `format` (raw
+//! elements), `start` (ordered lists), `text` (the element's text) and the
+//! element's key-value attributes. Selectors separated by commas match what
+//! any of them matches.
+//!
+//! Queries run on the document as the JSON writer writes it, so every
+//! element type is covered and types have their Pandoc names. Quarto's own
+//! nodes are seen as the Divs and Spans they are written as. A match is
+//! given by its path: the index of each element on the way down from the
+//! top of the document, counting only elements ([`node_at`] follows one).
+
+use crate::pandoc::{ASTContext, Pandoc};
+use crate::utils::ast_equal::LOCATION_FIELDS;
+use crate::writers::json::{JsonConfig, write_pandoc};
+use quarto_error_reporting::DiagnosticMessage;
+use serde::Serialize;
+use serde_json::Value;
+use std::fmt;
+
+/// The Pandoc JSON names of the block types
+const BLOCK_TYPES: &[&str] = &[
+    "Plain",
+    "Para",
+    "LineBlock",
+    "CodeBlock",
+    "RawBlock",
+    "BlockQuote",
+    "OrderedList",
+    "BulletList",
+    "DefinitionList",
+    "Header",
+    "HorizontalRule",
+    "Table",
+    "Figure",
+    "Div",
+    "BlockMetadata",
+    "NoteDefinitionPara",
+    "NoteDefinitionFencedBlock",
+    "CaptionBlock",
+];
+
+/// The Pandoc JSON names of the inline types
+const INLINE_TYPES: &[&str] = &[
+    "Str",
+    "Emph",
+    "Underline",
+    "Strong",
+    "Strikeout",
+    "Superscript",
+    "Subscript",
+    "SmallCaps",
+    "Quoted",
+    "Cite",
+    "Code",
+    "Space",
+    "SoftBreak",
+    "LineBreak",
+    "Math",
+    "RawInline",
+    "Link",
+    "Image",
+    "Note",
+    "Span",
+];
+
+/// A selector that couldn't be parsed
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct QueryError {
+    pub message: String,
+    /// Character offset of the problem in the selector
+    pub offset: usize,
+}
+
+impl fmt::Display for QueryError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{} (at character {})", self.message, self.offset + 1)
+    }
+}
+
+impl std::error::Error for QueryError {}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Combinator {
+    /// Anywhere inside the element matched by the previous step
+    Descendant,
+    /// Directly inside it
+    Child,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Operator {
+    Equals,
+    NotEquals,
+    StartsWith,
+    EndsWith,
+    Contains,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Condition {
+    property: String,
+    /// `None` when the property only has to be there
+    test: Option<(Operator, String)>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Compound {
+    /// `None` for `*`, or when only conditions are given
+    kind: Option<String>,
+    conditions: Vec<Condition>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Step {
+    /// How the element relates to the one matched by the previous step
+    combinator: Combinator,
+    compound: Compound,
+}
+
+/// A parsed selector
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Selector {
+    /// The comma-separated selectors, each a sequence of steps
+    alternatives: Vec<Vec<Step>>,
+}
+
+struct Parser {
+    chars: Vec<char>,
+    pos: usize,
+}
+
+impl Parser {
+    fn peek(&self) -> Option<char> {
+        self.chars.get(self.pos).copied()
+    }
+
+    fn error(&self, message: impl Into<String>) -> QueryError {
+        QueryError {
+            message: message.into(),
+            offset: self.pos,
+        }
+    }
+
+    /// Skip whitespace, returning whether there was any
+    fn skip_spaces(&mut self) -> bool {
+        let start = self.pos;
+        while self.peek().is_some_and(char::is_whitespace) {
+            self.pos += 1;
+        }
+        self.pos > start
+    }
+
+    fn name(&mut self) -> String {
+        let start = self.pos;
+        while self
+            .peek()
+            .is_some_and(|c| c.is_alphanumeric() || matches!(c, '-' | '_' | ':'))
+        {
+            self.pos += 1;
+        }
+        self.chars[start..self.pos].iter().collect()
+    }
+
+    fn expect_name(&mut self, what: &str) -> Result<String, QueryError> {
+        let name = self.name();
+        if name.is_empty() {
+            return Err(self.error(format!("Expected {}", what)));
+        }
+        Ok(name)
+    }
+
+    fn selector(&mut self) -> Result<Selector, QueryError> {
+        let mut alternatives = vec![self.steps()?];
+        while self.peek() == Some(',') {
+            self.pos += 1;
+            alternatives.push(self.steps()?);
+        }
+        match self.peek() {
+            None => Ok(Selector { alternatives }),
+            Some(c) => Err(self.error(format!("Unexpected '{}'", c))),
+        }
+    }
+
+    fn steps(&mut self) -> Result<Vec<Step>, QueryError> {
+        self.skip_spaces();
+        let mut steps = vec![Step {
+            combinator: Combinator::Descendant,
+            compound: self.compound()?,
+        }];
+        loop {
+            let spaced = self.skip_spaces();
+            let combinator = match self.peek() {
+                None | Some(',') => return Ok(steps),
+                Some('>') => {
+                    self.pos += 1;
+                    self.skip_spaces();
+                    Combinator::Child
+                }
+                Some(_) if spaced => Combinator::Descendant,
+                Some(c) => return Err(self.error(format!("Unexpected '{}'", c))),
+            };
+            steps.push(Step {
+                combinator,
+                compound: self.compound()?,
+            });
+        }
+    }
+
+    fn compound(&mut self) -> Result<Compound, QueryError> {
+        let start = self.pos;
+        let kind = if self.peek() == Some('*') {
+            self.pos += 1;
+            None
+        } else {
+            let name = self.name();
+            if !name.is_empty()
+                && !BLOCK_TYPES.contains(&name.as_str())
+                && !INLINE_TYPES.contains(&name.as_str())
+            {
+                return Err(QueryError {
+                    message: format!("Unknown element type '{}'", name),
+                    offset: start,
+                });
+            }
+            (!name.is_empty()).then_some(name)
+        };
+
+        let mut conditions = Vec::new();
+        loop {
+            match self.peek() {
+                Some('.') => {
+                    self.pos += 1;
+                    let class = self.expect_name("a class name after '.'")?;
+                    conditions.push(Condition {
+                        property: "class".to_string(),
+                        test: Some((Operator::Equals, class)),
+                    });
+                }
+                Some('#') => {
+                    self.pos += 1;
+                    let id = self.expect_name("an identifier after '#'")?;
+                    conditions.push(Condition {
+                        property: "id".to_string(),
+                        test: Some((Operator::Equals, id)),
+                    });
+                }
+                Some('[') => {
+                    self.pos += 1;
+                    conditions.push(self.condition()?);
+                }
+                _ => break,
+            }
+        }
+
+        if self.pos == start {
+            return Err(match self.peek() {
+                None => self.error("Expected an element type"),
+                Some(c) => self.error(format!("Expected an element type, found '{}'", c)),
+            });
+        }
+        Ok(Compound { kind, conditions })
+    }
+
+    /// A `[...]` condition, after the `[`
+    fn condition(&mut self) -> Result<Condition, QueryError> {
+        self.skip_spaces();
+        let property = self.expect_name("a property name after '['")?;
+        self.skip_spaces();
+        if self.peek() == Some(']') {
+            self.pos += 1;
+            return Ok(Condition {
+                property,
+                test: None,
+            });
+        }
+
+        let operator = match self.peek() {
+            Some('=') => Operator::Equals,
+            Some('!') => Operator::NotEquals,
+            Some('^') => Operator::StartsWith,
+            Some('$') => Operator::EndsWith,
+            Some('*') => Operator::Contains,
+            _ => return Err(self.error("Expected ']' or an operator (=, !=, ^=, $=, *=)")),
+        };
+        self.pos += 1;
+        if operator != Operator::Equals {
+            if self.peek() != Some('=') {
+                return Err(self.error("Expected '='"));
+            }
+            self.pos += 1;
+        }
+        self.skip_spaces();
+
+        let value = match self.peek() {
+            Some(quote @ ('"' | '\'')) => {
+                self.pos += 1;
+                let start = self.pos;
+                while self.peek().is_some_and(|c| c != quote) {
+                    self.pos += 1;
+                }
+                if self.peek().is_none() {
+                    return Err(self.error(format!("Expected a closing {}", quote)));
+                }
+                let value: String = self.chars[start..self.pos].iter().collect();
+                self.pos += 1;
+                value
+            }
+            _ => {
+                let start = self.pos;
+                while self.peek().is_some_and(|c| c != ']' && !c.is_whitespace()) {
+                    self.pos += 1;
+                }
+                self.chars[start..self.pos].iter().collect()
+            }
+        };
+        self.skip_spaces();
+        if self.peek() != Some(']') {
+            return Err(self.error("Expected ']'"));
+        }
+        self.pos += 1;
+        Ok(Condition {
+            property,
+            test: Some((operator, value)),
+        })
+    }
+}
+
+impl Selector {
+    pub fn parse(source: &str) -> Result<Self, QueryError> {
+        let mut parser = Parser {
+            chars: source.chars().collect(),
+            pos: 0,
+        };
+        parser.selector()
+    }
+
+    /// Whether `node` matches, given its ancestors from the top down
+    fn matches(&self, ancestors: &[&Value], node: &Value) -> bool {
+        self.alternatives
+            .iter()
+            .any(|steps| steps_match(steps, ancestors, node))
+    }
+}
+
+impl std::str::FromStr for Selector {
+    type Err = QueryError;
+
+    fn from_str(source: &str) -> Result<Self, Self::Err> {
+        Self::parse(source)
+    }
+}
+
+fn steps_match(steps: &[Step], ancestors: &[&Value], node: &Value) -> bool {
+    let Some((last, rest)) = steps.split_last() else {
+        return true;
+    };
+    if !last.compound.matches(node) {
+        return false;
+    }
+    if rest.is_empty() {
+        return true;
+    }
+    match last.combinator {
+        Combinator::Child => ancestors
+            .split_last()
+            .is_some_and(|(parent, above)| steps_match(rest, above, parent)),
+        Combinator::Descendant => (0..ancestors.len())
+            .rev()
+            .any(|i| steps_match(rest, &ancestors[..i], ancestors[i])),
+    }
+}
+
+impl Compound {
+    fn matches(&self, node: &Value) -> bool {
+        self.kind
+            .as_deref()
+            .is_none_or(|kind| node_kind(node) == Some(kind))
+            && self
+                .conditions
+                .iter()
+                .all(|condition| condition.matches(node))
+    }
+}
+
+impl Condition {
+    fn matches(&self, node: &Value) -> bool {
+        let values = property_values(node, &self.property);
+        let Some((operator, expected)) = &self.test else {
+            return !values.is_empty();
+        };
+        let expected = expected.as_str();
+        match operator {
+            Operator::Equals => values.iter().any(|value| value == expected),
+            Operator::NotEquals => values.iter().all(|value| value != expected),
+            Operator::StartsWith => values.iter().any(|value| value.starts_with(expected)),
+            Operator::EndsWith => values.iter().any(|value| value.ends_with(expected)),
+            Operator::Contains => values.iter().any(|value| value.contains(expected)),
+        }
+    }
+}
+
+/// The type of an element, or `None` for JSON that isn't one (including
+/// the `{"t": ...}` objects of alignments, list styles and the like)
+fn node_kind(value: &Value) -> Option<&str> {
+    let kind = value.get("t")?.as_str()?;
+    (BLOCK_TYPES.contains(&kind) || INLINE_TYPES.contains(&kind)).then_some(kind)
+}
+
+/// The elements in `value`, not looking inside them
+fn collect_nodes<'a>(value: &'a Value, nodes: &mut Vec<&'a Value>) {
+    match value {
+        Value::Array(items) => items.iter().for_each(|item| collect_nodes(item, nodes)),
+        Value::Object(obj) => {
+            if node_kind(value).is_some() {
+                nodes.push(value);
+            } else {
+                obj.iter()
+                    .filter(|(key, _)| !LOCATION_FIELDS.contains(&key.as_str()))
+                    .for_each(|(_, item)| collect_nodes(item, nodes));
+            }
+        }
+        _ => {}
+    }
+}
+
+/// The elements directly inside an element
+fn children(node: &Value) -> Vec<&Value> {
+    let mut nodes = Vec::new();
+    if let Some(content) = node.get("c") {
+        collect_nodes(content, &mut nodes);
+    }
+    nodes
+}
+
+/// The top-level blocks of a document
+fn top_level(document: &Value) -> Vec<&Value> {
+    let mut nodes = Vec::new();
+    collect_nodes(&document["blocks"], &mut nodes);
+    nodes
+}
+
+/// The `[id, classes, attributes]` of an element that has them
+fn attr(node: &Value) -> Option<&Value> {
+    match node_kind(node)? {
+        "Header" => node["c"].get(1),
+        "CodeBlock" | "Div" | "Span" | "Code" | "Link" | "Image" | "Table" | "Figure" => {
+            node["c"].get(0)
+        }
+        _ => None,
+    }
+}
+
+/// The values of a property of an element, without empty ones
+fn property_values(node: &Value, property: &str) -> Vec<String> {
+    let string = |value: &Value| value.as_str().map(str::to_string);
+    let kind = node_kind(node).unwrap_or_default();
+    let attr = attr(node);
+    let values: Vec<String> = match property {
+        "level" if kind == "Header" => node["c"][0]
+            .as_u64()
+            .map(|n| n.to_string())
+            .into_iter()
+            .collect(),
+        "id" => attr.and_then(|attr| string(&attr[0])).into_iter().collect(),
+        "class" => attr
+            .and_then(|attr| attr[1].as_array())
+            .into_iter()
+            .flatten()
+            .filter_map(string)
+            .collect(),
+        "target" if matches!(kind, "Link" | "Image") => {
+            string(&node["c"][2][0]).into_iter().collect()
+        }
+        "title" if matches!(kind, "Link" | "Image") => {
+            string(&node["c"][2][1]).into_iter().collect()
+        }
+        "format" if matches!(kind, "RawBlock" | "RawInline") => {
+            string(&node["c"][0]).into_iter().collect()
+        }
+        "start" if kind == "OrderedList" => node["c"][0][0]
+            .as_i64()
+            .map(|n| n.to_string())
+            .into_iter()
+            .collect(),
+        "text" => vec![node_text(node)],
+        _ => attr
+            .and_then(|attr| attr[2].as_array())
+            .into_iter()
+            .flatten()
+            .filter(|pair| pair[0].as_str() == Some(property))
+            .filter_map(|pair| string(&pair[1]))
+            .collect(),
+    };
+    values
+        .into_iter()
+        .filter(|value| !value.is_empty())
+        .collect()
+}
+
+/// The text of an element, with runs of whitespace as single spaces
+fn node_text(node: &Value) -> String {
+    fn push_text(node: &Value, text: &mut String) {
+        match node_kind(node) {
+            Some("Str") => text.push_str(node["c"].as_str().unwrap_or_default()),
+            Some("Space" | "SoftBreak" | "LineBreak") => text.push(' '),
+            Some("Code" | "Math" | "CodeBlock") => {
+                text.push_str(node["c"][1].as_str().unwrap_or_default())
+            }
+            _ => {
+                for child in children(node) {
+                    push_text(child, text);
+                    // Blocks are separated like words
+                    if node_kind(child).is_some_and(|kind| BLOCK_TYPES.contains(&kind)) {
+                        text.push(' ');
+                    }
+                }
+            }
+        }
+    }
+    let mut text = String::new();
+    push_text(node, &mut text);
+    text.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+/// An element matched by a query
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct QueryMatch {
+    /// The index of each element on the way down from the top
+    pub path: Vec<usize>,
+    /// The Pandoc name of the element type, e.g. `Header`
+    pub kind: String,
+    /// The source location, as the HTML writer's `data-loc` attribute
+    /// (`file:line:col-line:col`), when the element has one
+    pub location: Option<String>,
+    /// The element's text
+    pub text: String,
+}
+
+/// The `data-loc` form of the `l` field the JSON writer gives an element
+fn location(node: &Value) -> Option<String> {
+    let l = node.get("l")?;
+    Some(format!(
+        "{}:{}:{}-{}:{}",
+        l["f"].as_u64()?,
+        l["b"]["l"].as_u64()?,
+        l["b"]["c"].as_u64()?,
+        l["e"]["l"].as_u64()?,
+        l["e"]["c"].as_u64()?
+    ))
+}
+
+/// The elements of a document that a selector matches, in document order.
+/// Fails only when the document can't be written as JSON.
+pub fn query(
+    pandoc: &Pandoc,
+    context: &ASTContext,
+    selector: &Selector,
+) -> Result<Vec<QueryMatch>, Vec<DiagnosticMessage>> {
+    let config = JsonConfig {
+        include_inline_locations: true,
+        include_block_ids: false,
+    };
+    let document = write_pandoc(pandoc, context, &config)?;
+    Ok(query_json(&document, selector))
+}
+
+/// [`query`] on a document already written as Pandoc JSON. Locations are
+/// given for elements with an `l` field (`JsonConfig::include_inline_locations`).
+pub fn query_json(document: &Value, selector: &Selector) -> Vec<QueryMatch> {
+    fn walk<'a>(
+        nodes: Vec<&'a Value>,
+        selector: &Selector,
+        ancestors: &mut Vec<&'a Value>,
+        path: &mut Vec<usize>,
+        matches: &mut Vec<QueryMatch>,
+    ) {
+        for (i, node) in nodes.into_iter().enumerate() {
+            path.push(i);
+            if selector.matches(ancestors, node) {
+                matches.push(QueryMatch {
+                    path: path.clone(),
+                    kind: node_kind(node).unwrap_or_default().to_string(),
+                    location: location(node),
+                    text: node_text(node),
+                });
+            }
+            ancestors.push(node);
+            walk(children(node), selector, ancestors, path, matches);
+            ancestors.pop();
+            path.pop();
+        }
+    }
+    let mut matches = Vec::new();
+    walk(
+        top_level(document),
+        selector,
+        &mut Vec::new(),
+        &mut Vec::new(),
+        &mut matches,
+    );
+    matches
+}
+
+/// The element at a path, as given in a [`QueryMatch`], in a document
+/// written as Pandoc JSON
+pub fn node_at<'a>(document: &'a Value, path: &[usize]) -> Option<&'a Value> {
+    let (first, rest) = path.split_first()?;
+    let mut node = *top_level(document).get(*first)?;
+    for index in rest {
+        node = *children(node).get(*index)?;
+    }
+    Some(node)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn parse_error(source: &str) -> String {
+        Selector::parse(source).unwrap_err().to_string()
+    }
+
+    #[test]
+    fn test_parse() {
+        let selector = Selector::parse("Header[level=2] > Str, Div.note  Para").unwrap();
+        assert_eq!(selector.alternatives.len(), 2);
+        assert_eq!(selector.alternatives[0][1].combinator, Combinator::Child);
+        assert_eq!(
+            selector.alternatives[1][1].combinator,
+            Combinator::Descendant
+        );
+        assert_eq!(
+            selector.alternatives[1][0].compound.conditions,
+            vec![Condition {
+                property: "class".to_string(),
+                test: Some((Operator::Equals, "note".to_string())),
+            }]
+        );
+        let selector = Selector::parse("Link[title *= 'a b']").unwrap();
+        assert_eq!(
+            selector.alternatives[0][0].compound.conditions[0].test,
+            Some((Operator::Contains, "a b".to_string()))
+        );
+        assert!(Selector::parse("*").is_ok());
+        assert!(Selector::parse("#intro").is_ok());
+    }
+
+    #[test]
+    fn test_parse_errors() {
+        assert_eq!(
+            parse_error("Heading"),
+            "Unknown element type 'Heading' (at character 1)"
+        );
+        assert_eq!(
+            parse_error("Para >"),
+            "Expected an element type (at character 7)"
+        );
+        assert_eq!(
+            parse_error("Para[level"),
+            "Expected ']' or an operator (=, !=, ^=, $=, *=) (at character 11)"
+        );
+        assert_eq!(
+            parse_error("Para[a=\"b]"),
+            "Expected a closing \" (at character 11)"
+        );
+        assert_eq!(
+            parse_error("Para."),
+            "Expected a class name after '.' (at character 6)"
+        );
+        assert_eq!(parse_error(""), "Expected an element type (at character 1)");
+    }
+
+    fn para(inlines: Value) -> Value {
+        serde_json::json!({"t": "Para", "c": inlines})
+    }
+
+    fn str_node(text: &str) -> Value {
+        serde_json::json!({"t": "Str", "c": text})
+    }
+
+    #[test]
+    fn test_query_json() {
+        let document = serde_json::json!({"blocks": [
+            {"t": "Header", "c": [2, ["intro", ["unnumbered"], []], [str_node("Intro")]],
+             "l": {"f": 0, "b": {"o": 0, "l": 1, "c": 1}, "e": {"o": 8, "l": 1, "c": 9}}},
+            {"t": "Div", "c": [["", ["note"], [["kind", "tip"]]], [
+                para(serde_json::json!([
+                    str_node("See"),
+                    {"t": "Space"},
+                    {"t": "Link", "c": [["", [], []], [str_node("this")], ["http://x.org", ""]]}
+                ]))
+            ]]}
+        ]});
+        let paths = |source: &str| {
+            query_json(&document, &Selector::parse(source).unwrap())
+                .into_iter()
+                .map(|m| m.path)
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(paths("Header[level=2] > Str"), vec![vec![0, 0]]);
+        assert_eq!(paths("Header[level=3]"), Vec::<Vec<usize>>::new());
+        assert_eq!(paths("Div.note Str"), vec![vec![1, 0, 0], vec![1, 0, 2, 0]]);
+        assert_eq!(paths("Div > Str"), Vec::<Vec<usize>>::new());
+        assert_eq!(paths("Link[target^=http:]"), vec![vec![1, 0, 2]]);
+        assert_eq!(paths("[kind=tip]"), vec![vec![1]]);
+        assert_eq!(
+            paths("#intro, Para[text*='See this']"),
+            vec![vec![0], vec![1, 0]]
+        );
+        assert_eq!(paths("Header.unnumbered[id!=other]"), vec![vec![0]]);
+
+        let matches = query_json(&document, &Selector::parse("Header").unwrap());
+        assert_eq!(matches[0].location.as_deref(), Some("0:1:1-1:9"));
+        assert_eq!(matches[0].text, "Intro");
+        assert_eq!(node_at(&document, &[1, 0, 2]).unwrap()["t"], "Link");
+        assert!(node_at(&document, &[1, 5]).is_none());
+    }
+}
@@ -0,0 +1,303 @@
+/*
+ * batch.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `--batch`: convert all the files of a directory in one process, on a
+//! pool of worker threads.
+//!
+//! Each file goes through the same `convert` as a single input. The
+//! messages of a file are buffered while it converts and printed together
+//! once all files are done, in path order, followed by a summary. A file
+//! whose conversion panics fails like any other, without stopping the
+//! batch. The exit code is 1 if any file failed.
+
+use super::{Args, ConversionFailed, Messages, Resources, convert, ensure_final_newline};
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// The extensions of the files read for a --from format
+fn input_extensions(from: &str) -> &'static [&'static str] {
+    match from {
+        "markdown" | "qmd" => &["qmd", "md"],
+        "commonmark" | "gfm" => &["md"],
+        "json" => &["json"],
+        "ipynb" => &["ipynb"],
+        "org" => &["org"],
+        "rst" => &["rst"],
+        _ => &[],
+    }
+}
+
+/// The extension of the files written for a --to format
+fn output_extension(to: &str) -> &'static str {
+    match to {
+        "json" => "json",
+        "native" => "native",
+        "markdown" => "md",
+        "qmd" => "qmd",
+        "ipynb" => "ipynb",
+        "html" | "revealjs" => "html",
+        "latex" => "tex",
+        "docx" => "docx",
+        "epub" => "epub",
+        "typst" => "typ",
+        _ => "txt",
+    }
+}
+
+/// The files under `dir` to convert, sorted
+fn find_inputs(dir: &Path, from: &str) -> Vec<PathBuf> {
+    let mut inputs: Vec<PathBuf> = input_extensions(from)
+        .iter()
+        .flat_map(|extension| {
+            let pattern = format!(
+                "{}/**/*.{}",
+                glob::Pattern::escape(&dir.to_string_lossy()),
+                extension
+            );
+            glob::glob(&pattern).into_iter().flatten().flatten()
+        })
+        .filter(|path| path.is_file())
+        .collect();
+    inputs.sort();
+    inputs.dedup();
+    inputs
+}
+
+/// The outcome of converting one file
+struct FileResult {
+    input: PathBuf,
+    ok: bool,
+    stdout: Vec<u8>,
+    stderr: Vec<u8>,
+}
+
+/// Convert the files under `dir` and return the exit code
+pub fn run(args: &Args, resources: &Resources, dir: &Path) -> i32 {
+    let Some(output_dir) = &args.output else {
+        eprintln!("--batch writes to a directory; specify it with -o");
+        return 1;
+    };
+    let output_dir = Path::new(output_dir);
+    if input_extensions(&args.from).is_empty() {
+        eprintln!("Unknown input format: {}", args.from);
+        return 1;
+    }
+    let inputs = find_inputs(dir, &args.from);
+    if inputs.is_empty() {
+        eprintln!(
+            "No {} files found in '{}'",
+            input_extensions(&args.from).join("/"),
+            dir.display()
+        );
+        return 1;
+    }
+
+    let jobs = args
+        .jobs
+        .unwrap_or_else(|| std::thread::available_parallelism().map_or(1, |n| n.get()))
+        .clamp(1, inputs.len());
+    let next = AtomicUsize::new(0);
+    let mut results: Vec<(usize, FileResult)> = std::thread::scope(|scope| {
+        let workers: Vec<_> = (0..jobs)
+            .map(|_| {
+                scope.spawn(|| {
+                    let mut done = Vec::new();
+                    loop {
+                        let index = next.fetch_add(1, Ordering::Relaxed);
+                        let Some(input) = inputs.get(index) else {
+                            return done;
+                        };
+                        let result = guarded(input, args.json_errors, || {
+                            convert_file(args, resources, input, dir, output_dir)
+                        });
+                        done.push((index, result));
+                    }
+                })
+            })
+            .collect();
+        workers
+            .into_iter()
+            .flat_map(|worker| worker.join().expect("batch worker panicked"))
+            .collect()
+    });
+    results.sort_by_key(|(index, _)| *index);
+
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut failed = Vec::new();
+    for (_, result) in &results {
+        let _ = stdout.write_all(&result.stdout);
+        let _ = stderr.write_all(&result.stderr);
+        if !result.ok {
+            failed.push(&result.input);
+        }
+    }
+    if failed.is_empty() {
+        eprintln!("Converted {} files", results.len());
+        0
+    } else {
+        eprintln!(
+            "Converted {} of {} files; {} failed:",
+            results.len() - failed.len(),
+            results.len(),
+            failed.len()
+        );
+        for input in failed {
+            eprintln!("  {}", input.display());
+        }
+        1
+    }
+}
+
+/// Run a conversion, turning a panic into a failed file
+fn guarded(input: &Path, json_errors: bool, f: impl FnOnce() -> FileResult) -> FileResult {
+    std::panic::catch_unwind(std::panic::AssertUnwindSafe(f)).unwrap_or_else(|payload| {
+        let message = payload
+            .downcast_ref::<&str>()
+            .map(|message| message.to_string())
+            .or_else(|| payload.downcast_ref::<String>().cloned())
+            .unwrap_or_else(|| "unknown panic".to_string());
+        let mut stdout = Vec::new();
+        let mut stderr = Vec::new();
+        Messages {
+            json_errors,
+            collected: None,
+            stdout: &mut stdout,
+            stderr: &mut stderr,
+        }
+        .error(format_args!(
+            "Failed to convert '{}': pampa panicked: {}",
+            input.display(),
+            message
+        ));
+        FileResult {
+            input: input.to_path_buf(),
+            ok: false,
+            stdout,
+            stderr,
+        }
+    })
+}
+
+fn convert_file(
+    args: &Args,
+    resources: &Resources,
+    input: &Path,
+    dir: &Path,
+    output_dir: &Path,
+) -> FileResult {
+    let mut stdout = Vec::new();
+    let mut stderr = Vec::new();
+    let mut tree_output = Vec::new();
+    let ok = {
+        let mut messages = Messages {
+            json_errors: args.json_errors,
+            collected: None,
+            stdout: &mut stdout,
+            stderr: &mut stderr,
+        };
+        let mut sink = std::io::sink();
+        let output_stream: &mut dyn Write = if args.verbose {
+            &mut tree_output
+        } else {
+            &mut sink
+        };
+        convert_file_to(
+            args,
+            resources,
+            input,
+            dir,
+            output_dir,
+            output_stream,
+            &mut messages,
+        )
+        .is_ok()
+    };
+    tree_output.append(&mut stderr);
+    FileResult {
+        input: input.to_path_buf(),
+        ok,
+        stdout,
+        stderr: tree_output,
+    }
+}
+
+fn convert_file_to(
+    args: &Args,
+    resources: &Resources,
+    input: &Path,
+    dir: &Path,
+    output_dir: &Path,
+    output_stream: &mut dyn Write,
+    messages: &mut Messages,
+) -> Result<(), ConversionFailed> {
+    let input_filename = input.to_string_lossy();
+    let mut text = std::fs::read_to_string(input).map_err(|e| {
+        messages.error(format_args!("Failed to read '{}': {}", input_filename, e));
+        ConversionFailed
+    })?;
+    ensure_final_newline(&mut text, &input_filename, messages);
+
+    let relative = input.strip_prefix(dir).unwrap_or(input);
+    let output = output_dir
+        .join(relative)
+        .with_extension(output_extension(&args.to));
+    if output.canonicalize().ok() == input.canonicalize().ok() {
+        messages.error(format_args!(
+            "Not converting '{}': the output would overwrite it",
+            input_filename
+        ));
+        return Err(ConversionFailed);
+    }
+
+    let buf = convert(
+        args,
+        resources,
+        &input_filename,
+        &text,
+        &mut crate::transforms::IncludeCache::new(),
+        output_stream,
+        messages,
+        &mut crate::trace::Tracer::disabled(),
+    )?;
+
+    let written = output
+        .parent()
+        .map_or(Ok(()), std::fs::create_dir_all)
+        .and_then(|_| std::fs::write(&output, &buf));
+    written.map_err(|e| {
+        messages.error(format_args!(
+            "Failed to write output to file '{}': {}",
+            output.display(),
+            e
+        ));
+        ConversionFailed
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_a_panic_fails_only_its_file() {
+        let input = Path::new("chapters/broken.qmd");
+        let result = guarded(input, false, || panic!("writer bug"));
+        assert!(!result.ok);
+        assert_eq!(result.input, input);
+        let stderr = String::from_utf8(result.stderr).unwrap();
+        assert!(stderr.contains("chapters/broken.qmd"), "{}", stderr);
+        assert!(stderr.contains("writer bug"), "{}", stderr);
+
+        let result = guarded(input, false, || FileResult {
+            input: input.to_path_buf(),
+            ok: true,
+            stdout: Vec::new(),
+            stderr: Vec::new(),
+        });
+        assert!(result.ok);
+    }
+}
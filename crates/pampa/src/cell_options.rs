@@ -0,0 +1,550 @@
+/*
+ * cell_options.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! The `#|` options of executable code blocks.
+//!
+//! An executable code block (```` ```{python} ````) can start with option
+//! lines: comments that start with `#|` (`//|`, `--|` or `%%|` in
+//! languages with other comments) and together hold YAML.
+//!
+//! ````markdown
+//! ```{r}
+//! #| label: fig-speed
+//! #| echo: false
+//! plot(cars)
+//! ```
+//! ````
+//!
+//! [`read`] reads them with the place of every key and value in the
+//! source, and [`validate`] checks them against the options Quarto knows,
+//! without running anything:
+//!
+//! - an option Quarto doesn't know is a Q-1-18 warning on its name, with
+//!   the option it probably means (`fig.cap` is `fig-cap`)
+//! - a value of the wrong type is a Q-1-11 or Q-1-12 error on the value
+//! - option lines that aren't YAML are a Q-1-1 error
+//!
+//! `!expr` values, which knitr evaluates, aren't checked.
+
+use crate::pandoc::{Block, CodeBlock, Pandoc};
+use once_cell::sync::Lazy;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks};
+use quarto_source_map::{SourceContext, SourceInfo};
+use quarto_yaml::YamlWithSourceInfo;
+use quarto_yaml_validation::{Schema, SchemaRegistry};
+use std::collections::HashMap;
+use yaml_rust2::Yaml;
+
+/// The schema of every option, in the schema syntax of
+/// `quarto-yaml-validation`
+const CELL_OPTIONS_SCHEMA: &str = r#"
+# Attributes
+label: string
+classes: { maybeArrayOf: string }
+renderings: { arrayOf: string }
+tags: { arrayOf: string }
+id: string
+filename: string
+
+# Execution and code
+eval: { anyOf: [boolean, { arrayOf: number }] }
+echo: { anyOf: [boolean, { enum: [fenced] }, { arrayOf: number }] }
+code-fold: { anyOf: [boolean, { enum: [show] }] }
+code-summary: string
+code-overflow: { enum: [scroll, wrap] }
+code-line-numbers: { anyOf: [boolean, string] }
+lst-label: string
+lst-cap: string
+tidy: { anyOf: [boolean, { enum: [styler, formatR] }] }
+tidy-opts: { arrayOf: string }
+collapse: boolean
+prompt: boolean
+highlight: boolean
+class-source: { maybeArrayOf: string }
+attr-source: { maybeArrayOf: string }
+context: string
+engine: string
+
+# Output
+output: { anyOf: [boolean, { enum: [asis, all] }] }
+include: boolean
+warning: boolean
+error: boolean
+message: boolean
+results: { anyOf: [boolean, { enum: [markup, asis, hold, hide] }] }
+comment: string
+strip-white: boolean
+panel: { enum: [tabset, input, sidebar, fill, center] }
+output-location: { enum: [default, fragment, slide, column, column-fragment] }
+class-output: { maybeArrayOf: string }
+attr-output: { maybeArrayOf: string }
+class-warning: { maybeArrayOf: string }
+attr-warning: { maybeArrayOf: string }
+class-message: { maybeArrayOf: string }
+attr-message: { maybeArrayOf: string }
+class-error: { maybeArrayOf: string }
+attr-error: { maybeArrayOf: string }
+
+# Figures
+fig-cap: { maybeArrayOf: string }
+fig-subcap: { anyOf: [boolean, { maybeArrayOf: string }] }
+fig-alt: { maybeArrayOf: string }
+fig-scap: { maybeArrayOf: string }
+fig-link: { maybeArrayOf: string }
+fig-width: number
+fig-height: number
+fig-asp: number
+fig-dpi: number
+fig-format: { enum: [retina, png, jpeg, svg, pdf] }
+fig-align: { maybeArrayOf: { enum: [default, left, right, center] } }
+fig-env: { maybeArrayOf: string }
+fig-pos: { anyOf: [boolean, { maybeArrayOf: string }] }
+fig-responsive: boolean
+fig-show: { enum: [asis, hold, animate, hide] }
+fig-keep: { anyOf: [{ enum: [high, none, all, first, last] }, { arrayOf: number }] }
+fig-process: string
+fig-path: string
+dev: { maybeArrayOf: string }
+dpi: number
+out-width: { anyOf: [string, number] }
+out-height: { anyOf: [string, number] }
+out-extra: string
+
+# Tables
+tbl-cap: { maybeArrayOf: string }
+tbl-subcap: { anyOf: [boolean, { maybeArrayOf: string }] }
+tbl-colwidths: { anyOf: [boolean, { enum: [auto] }, { arrayOf: number }] }
+html-table-processing: { enum: [none] }
+
+# Layout
+layout: { anyOf: [string, { arrayOf: any }] }
+layout-ncol: number
+layout-nrow: number
+layout-align: { enum: [default, left, center, right] }
+layout-valign: { enum: [default, top, center, bottom] }
+column: string
+fig-column: string
+tbl-column: string
+cap-location: { enum: [top, bottom, margin] }
+fig-cap-location: { enum: [top, bottom, margin] }
+tbl-cap-location: { enum: [top, bottom, margin] }
+
+# Dashboards
+title: string
+padding: { anyOf: [string, number] }
+expandable: boolean
+content: string
+
+# Caching and inclusion
+cache: { anyOf: [boolean, { enum: [refresh] }] }
+cache-path: string
+cache-vars: { maybeArrayOf: string }
+cache-globals: { maybeArrayOf: string }
+cache-lazy: boolean
+cache-rebuild: boolean
+cache-comments: boolean
+dependson: { maybeArrayOf: { anyOf: [string, number] } }
+autodep: boolean
+child: { maybeArrayOf: string }
+file: string
+code: { maybeArrayOf: string }
+purl: boolean
+"#;
+
+static CELL_OPTION_SCHEMAS: Lazy<HashMap<String, Schema>> = Lazy::new(|| {
+    let yaml =
+        quarto_yaml::parse(CELL_OPTIONS_SCHEMA).expect("the cell options schema is valid YAML");
+    yaml.as_hash()
+        .expect("the cell options schema is a mapping")
+        .iter()
+        .map(|entry| {
+            let name = entry.key.yaml.as_str().expect("option names are strings");
+            let schema = Schema::from_yaml(&entry.value)
+                .unwrap_or_else(|e| panic!("invalid schema for `{}`: {}", name, e));
+            (name.to_string(), schema)
+        })
+        .collect()
+});
+
+/// The `#|` options of a cell
+#[derive(Debug, Clone)]
+pub struct CellOptions {
+    /// The language of the cell, `python` for ```` ```{python} ````
+    pub language: String,
+    /// The options: a mapping, unless the option lines hold other YAML
+    pub yaml: YamlWithSourceInfo,
+    /// The code after the option lines
+    pub code: String,
+}
+
+impl CellOptions {
+    /// The value of an option
+    pub fn get(&self, key: &str) -> Option<&YamlWithSourceInfo> {
+        self.yaml.get_hash_value(key)
+    }
+}
+
+/// The language of an executable code block: `python` for
+/// ```` ```{python} ````
+pub fn language(block: &CodeBlock) -> Option<&str> {
+    let class = block.attr.1.first()?;
+    let inner = class.strip_prefix('{')?.strip_suffix('}')?;
+    let language = inner
+        .split(|c: char| c.is_whitespace() || c == ',')
+        .next()?;
+    language
+        .starts_with(|c: char| c.is_ascii_alphabetic())
+        .then_some(language)
+}
+
+/// The comment that starts an option line in a language
+pub fn comment_prefix(language: &str) -> &'static str {
+    match language.to_ascii_lowercase().as_str() {
+        "sql" => "--|",
+        "mermaid" => "%%|",
+        "rcpp" | "cpp" | "c" | "js" | "ojs" | "stan" | "d3" | "dot" => "//|",
+        _ => "#|",
+    }
+}
+
+/// Read the options of an executable code block. Returns `None` for other
+/// blocks and for cells without options, and a Q-1-1 error when the option
+/// lines aren't YAML.
+///
+/// The keys and values are placed in the source with the block's text from
+/// `source_context`; without it, they are placed at the block.
+pub fn read(
+    block: &CodeBlock,
+    source_context: &SourceContext,
+) -> Option<Result<CellOptions, DiagnosticMessage>> {
+    let language = language(block)?;
+    let prefix = comment_prefix(language);
+    let source = block_source(&block.source_info, source_context);
+
+    let mut yaml = String::new();
+    let mut pieces = Vec::new();
+    // Where to look for the next option line in the source: after the
+    // opening fence
+    let mut cursor = source.and_then(|s| s.find('\n')).map_or(0, |at| at + 1);
+    let mut rest = block.text.as_str();
+    while let Some(line) = rest.lines().next() {
+        let Some(option) = line.strip_prefix(prefix) else {
+            break;
+        };
+        let option = option.strip_prefix(' ').unwrap_or(option);
+        yaml.push_str(option);
+        yaml.push('\n');
+        // The option and its line break
+        let length = option.len() + 1;
+        match source.and_then(|s| s.get(cursor..)?.find(line)) {
+            Some(at) => {
+                let start = cursor + at + line.len() - option.len();
+                pieces.push((
+                    SourceInfo::substring(block.source_info.clone(), start, start + length),
+                    length,
+                ));
+                cursor = start + option.len();
+            }
+            None => pieces.push((block.source_info.clone(), length)),
+        }
+        rest = rest[line.len()..]
+            .strip_prefix("\r\n")
+            .or_else(|| rest[line.len()..].strip_prefix('\n'))
+            .unwrap_or("");
+    }
+    if yaml.trim().is_empty() {
+        return None;
+    }
+
+    let source_info = SourceInfo::concat(pieces);
+    let result = match quarto_yaml::parse_with_parent(&yaml, source_info.clone()) {
+        Ok(yaml) => Ok(CellOptions {
+            language: language.to_string(),
+            yaml,
+            code: rest.to_string(),
+        }),
+        Err(e) => Err(DiagnosticMessageBuilder::error("YAML Syntax Error")
+            .with_code("Q-1-1")
+            .with_location(source_info)
+            .problem(format!(
+                "The options of this `{}` cell are not valid YAML",
+                language
+            ))
+            .add_detail(e.to_string())
+            .add_hint(format!("Write each option as `{} key: value`", prefix))
+            .build()),
+    };
+    Some(result)
+}
+
+/// Check the options of a cell against the options Quarto knows
+pub fn validate(options: &CellOptions, source_context: &SourceContext) -> Vec<DiagnosticMessage> {
+    let Some(entries) = options.yaml.as_hash() else {
+        return vec![
+            DiagnosticMessageBuilder::error("Invalid cell options")
+                .with_code("Q-1-11")
+                .with_location(options.yaml.source_info.clone())
+                .problem(format!(
+                    "The options of this `{}` cell are not `key: value` pairs",
+                    options.language
+                ))
+                .build(),
+        ];
+    };
+
+    let registry = SchemaRegistry::new();
+    let mut diagnostics = Vec::new();
+    for entry in entries {
+        let key = match &entry.key.yaml {
+            Yaml::String(key) => key.as_str(),
+            _ => continue,
+        };
+        let Some(schema) = CELL_OPTION_SCHEMAS.get(key) else {
+            let mut builder = DiagnosticMessageBuilder::warning("Unknown cell option")
+                .with_code("Q-1-18")
+                .with_location(entry.key.source_info.clone())
+                .problem(format!("`{}` is not a cell option Quarto knows", key));
+            if let Some(suggestion) = suggestion(key) {
+                builder = builder.add_hint(format!("Did you mean `{}`?", suggestion));
+            }
+            diagnostics.push(builder.build());
+            continue;
+        };
+        if matches!(&entry.value.tag, Some((tag, _)) if tag == "expr") {
+            continue;
+        }
+        if let Err(error) =
+            quarto_yaml_validation::validate(&entry.value, schema, &registry, source_context)
+        {
+            let location = error
+                .yaml_node
+                .as_ref()
+                .map_or(&entry.value.source_info, |node| &node.source_info);
+            diagnostics.push(
+                DiagnosticMessageBuilder::error("Invalid cell option")
+                    .with_code(error.error_code())
+                    .with_location(location.clone())
+                    .problem(format!("The value of `{}` is not valid", key))
+                    .add_detail(error.message())
+                    .add_info(format!("`{}` takes {}", key, describe(schema)))
+                    .build(),
+            );
+        }
+    }
+    diagnostics
+}
+
+/// Read and check the options of every executable code block of a
+/// document
+pub fn validate_document(
+    pandoc: &Pandoc,
+    source_context: &SourceContext,
+) -> Vec<DiagnosticMessage> {
+    let mut diagnostics = Vec::new();
+    let _ = walk_blocks(&pandoc.blocks, Order::TopDown, |block| {
+        if let Block::CodeBlock(code_block) = block {
+            match read(code_block, source_context) {
+                Some(Ok(options)) => diagnostics.extend(validate(&options, source_context)),
+                Some(Err(diagnostic)) => diagnostics.push(diagnostic),
+                None => {}
+            }
+        }
+        Control::Continue
+    });
+    diagnostics
+}
+
+/// The text of the source a block was read from
+fn block_source<'a>(
+    source_info: &SourceInfo,
+    source_context: &'a SourceContext,
+) -> Option<&'a str> {
+    let (start, end) = source_info.map_range(0, source_info.length(), source_context)?;
+    if start.file_id != end.file_id {
+        return None;
+    }
+    let content = source_context.get_file(start.file_id)?.content.as_deref()?;
+    content.get(start.location.offset..end.location.offset)
+}
+
+/// The option a misspelled or knitr-style (`fig.cap`) name probably means
+fn suggestion(key: &str) -> Option<&'static str> {
+    let dashed = key.to_ascii_lowercase().replace(['.', '_'], "-");
+    CELL_OPTION_SCHEMAS
+        .keys()
+        .map(|name| (edit_distance(&dashed, name), name.as_str()))
+        .filter(|(distance, _)| *distance <= 2)
+        .min()
+        .map(|(_, name)| name)
+}
+
+/// The Levenshtein distance between two strings
+fn edit_distance(a: &str, b: &str) -> usize {
+    let b: Vec<char> = b.chars().collect();
+    let mut previous: Vec<usize> = (0..=b.len()).collect();
+    for (i, ca) in a.chars().enumerate() {
+        let mut current = vec![i + 1];
+        for (j, cb) in b.iter().enumerate() {
+            let substitution = previous[j] + usize::from(ca != *cb);
+            current.push(substitution.min(previous[j + 1] + 1).min(current[j] + 1));
+        }
+        previous = current;
+    }
+    previous[b.len()]
+}
+
+/// What values a schema takes, for people: "`true`, `false` or a number"
+fn describe(schema: &Schema) -> String {
+    let mut parts = Vec::new();
+    describe_parts(schema, &mut parts);
+    match parts.split_last() {
+        Some((last, [])) => last.clone(),
+        Some((last, rest)) => format!("{} or {}", rest.join(", "), last),
+        None => "any value".to_string(),
+    }
+}
+
+fn describe_parts(schema: &Schema, parts: &mut Vec<String>) {
+    match schema {
+        Schema::Boolean(_) => parts.extend(["`true`".to_string(), "`false`".to_string()]),
+        Schema::Number(_) => parts.push("a number".to_string()),
+        Schema::String(_) => parts.push("a string".to_string()),
+        Schema::Enum(s) => parts.extend(s.values.iter().map(|value| match value.as_str() {
+            Some(value) => format!("`{}`", value),
+            None => format!("`{}`", value),
+        })),
+        Schema::AnyOf(s) => {
+            for schema in &s.schemas {
+                describe_parts(schema, parts);
+            }
+        }
+        Schema::Array(s) => parts.push(match s.items.as_deref() {
+            Some(Schema::Number(_)) => "a list of numbers".to_string(),
+            Some(Schema::String(_)) => "a list of strings".to_string(),
+            Some(items @ (Schema::Enum(_) | Schema::AnyOf(_))) => {
+                format!("a list of {}", describe(items))
+            }
+            _ => "a list".to_string(),
+        }),
+        _ => parts.push("any value".to_string()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::attr::AttrSourceInfo;
+    use crate::readers;
+
+    fn validate_qmd(input: &str) -> Vec<DiagnosticMessage> {
+        let (pandoc, context, _warnings) = readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        validate_document(&pandoc, &context.source_context)
+    }
+
+    /// The source text a diagnostic points at
+    fn located<'a>(diagnostic: &DiagnosticMessage, input: &'a str) -> &'a str {
+        let location = diagnostic.location.as_ref().unwrap();
+        let mut context = SourceContext::new();
+        context.add_file("test.qmd".to_string(), Some(input.to_string()));
+        let (start, end) = location.map_range(0, location.length(), &context).unwrap();
+        &input[start.location.offset..end.location.offset]
+    }
+
+    #[test]
+    fn test_the_schema_parses() {
+        assert!(CELL_OPTION_SCHEMAS.contains_key("echo"));
+        assert!(CELL_OPTION_SCHEMAS.contains_key("fig-cap"));
+    }
+
+    #[test]
+    fn test_valid_options() {
+        let input = "```{python}\n#| label: fig-plot\n#| echo: fenced\n#| fig-cap: [A, B]\n\
+                     #| fig-width: 6\n#| layout-ncol: 2\nplot()\n```\n\n\
+                     ```{r}\n#| eval: !expr params$run\n#| echo: false\nx\n```\n";
+        assert_eq!(validate_qmd(input), vec![]);
+    }
+
+    #[test]
+    fn test_unknown_option_at_its_name() {
+        let input = "```{r}\n#| echo: false\n#| fig.cap: Cars\nplot(cars)\n```\n";
+        let diagnostics = validate_qmd(input);
+        assert_eq!(diagnostics.len(), 1);
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-1-18"));
+        assert_eq!(located(&diagnostics[0], input), "fig.cap");
+        let hint = diagnostics[0].hints[0].as_str();
+        assert!(hint.contains("`fig-cap`"), "{}", hint);
+    }
+
+    #[test]
+    fn test_invalid_value_at_the_value() {
+        let input = "> ```{python}\n> #| echo: false\n> #| fig-width: wide\n> x\n> ```\n";
+        let diagnostics = validate_qmd(input);
+        assert_eq!(diagnostics.len(), 1);
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-1-11"));
+        assert_eq!(located(&diagnostics[0], input), "wide");
+
+        let input = "```{python}\n#| code-overflow: hidden\nx\n```\n";
+        let diagnostics = validate_qmd(input);
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-1-12"));
+        assert_eq!(located(&diagnostics[0], input), "hidden");
+    }
+
+    #[test]
+    fn test_other_comment_prefixes() {
+        let input =
+            "```{ojs}\n//| echo: maybe\nx = 1\n```\n\n```{sql}\n--| output: no\nSELECT 1\n```\n";
+        let diagnostics = validate_qmd(input);
+        assert_eq!(diagnostics.len(), 2);
+        assert_eq!(located(&diagnostics[0], input), "maybe");
+        assert_eq!(located(&diagnostics[1], input), "no");
+    }
+
+    #[test]
+    fn test_invalid_yaml() {
+        let input = "```{python}\n#| echo: [false\nx\n```\n";
+        let diagnostics = validate_qmd(input);
+        assert_eq!(diagnostics.len(), 1);
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-1-1"));
+    }
+
+    #[test]
+    fn test_blocks_without_options() {
+        let input = "```python\n#| echo: maybe\n```\n\n```{python}\nx = 1\n#| echo: maybe\n```\n";
+        assert_eq!(validate_qmd(input), vec![]);
+    }
+
+    #[test]
+    fn test_read() {
+        let block = CodeBlock {
+            attr: (String::new(), vec!["{r}".to_string()], Default::default()),
+            text: "#| label: cars\n#|echo: false\nplot(cars)\n".to_string(),
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        };
+        let options = read(&block, &SourceContext::new()).unwrap().unwrap();
+        assert_eq!(options.language, "r");
+        assert_eq!(options.code, "plot(cars)\n");
+        assert_eq!(options.get("label").unwrap().yaml.as_str(), Some("cars"));
+        assert_eq!(options.get("echo").unwrap().yaml.as_bool(), Some(false));
+    }
+
+    #[test]
+    fn test_describe() {
+        assert_eq!(
+            describe(&CELL_OPTION_SCHEMAS["echo"]),
+            "`true`, `false`, `fenced` or a list of numbers"
+        );
+        assert_eq!(describe(&CELL_OPTION_SCHEMAS["fig-width"]), "a number");
+    }
+}
@@ -28,7 +28,7 @@ const DEFAULT_CSL_STYLE: &str = include_str!("../resources/csl/chicago-author-da
 pub struct CiteprocConfig {
     /// Path to CSL style file.
     pub csl: Option<String>,
-    /// Paths to bibliography files (CSL-JSON format).
+    /// Paths to bibliography files (BibTeX or CSL-JSON format).
     pub bibliography: Vec<String>,
     /// Inline references from document metadata.
     pub references: Vec<Reference>,
@@ -147,15 +147,27 @@ fn load_csl_style(config: &CiteprocConfig) -> Result<quarto_csl::Style, Citeproc
     })
 }
 
-/// Load bibliography references from a CSL-JSON file.
+/// Load bibliography references from a BibTeX (`.bib`, `.bibtex`) or
+/// CSL-JSON file.
 fn load_bibliography(path: &str) -> Result<Vec<Reference>, CiteprocFilterError> {
     let path = Path::new(path);
     let content = std::fs::read_to_string(path)
         .map_err(|e| CiteprocFilterError::BibliographyNotFound(path.to_owned(), e))?;
 
-    // Parse as JSON array of references
-    let references: Vec<Reference> = serde_json::from_str(&content)
-        .map_err(|e| CiteprocFilterError::BibliographyParseError(path.to_owned(), e.to_string()))?;
+    let is_bibtex = path
+        .extension()
+        .and_then(|ext| ext.to_str())
+        .is_some_and(|ext| ext.eq_ignore_ascii_case("bib") || ext.eq_ignore_ascii_case("bibtex"));
+    let references = if is_bibtex {
+        quarto_citeproc::parse_bibtex(&content).map_err(|e| {
+            CiteprocFilterError::BibliographyParseError(path.to_owned(), e.to_string())
+        })?
+    } else {
+        // Parse as JSON array of references
+        serde_json::from_str(&content).map_err(|e| {
+            CiteprocFilterError::BibliographyParseError(path.to_owned(), e.to_string())
+        })?
+    };
 
     Ok(references)
 }
@@ -292,29 +304,36 @@ fn collect_citations_from_inlines(
                 let items: Vec<CitationItem> = cite
                     .citations
                     .iter()
-                    .map(|c| CitationItem {
-                        id: c.id.clone(),
-                        locator: None, // TODO: Extract locator from suffix
-                        label: None,
-                        prefix: if c.prefix.is_empty() {
-                            None
-                        } else {
-                            Some(inlines_to_text(&c.prefix))
-                        },
-                        suffix: if c.suffix.is_empty() {
-                            None
-                        } else {
-                            Some(inlines_to_text(&c.suffix))
-                        },
-                        suppress_author: Some(matches!(
-                            c.mode,
-                            crate::pandoc::CitationMode::SuppressAuthor
-                        )),
-                        author_only: Some(matches!(
-                            c.mode,
-                            crate::pandoc::CitationMode::AuthorInText
-                        )),
-                        position: None,
+                    .map(|c| {
+                        let suffix = inlines_to_text(&c.suffix);
+                        let (label, locator, suffix) = match parse_locator(&suffix) {
+                            Some(parsed) => (Some(parsed.label), Some(parsed.value), parsed.rest),
+                            None => (None, None, suffix),
+                        };
+                        CitationItem {
+                            id: c.id.clone(),
+                            locator,
+                            label,
+                            prefix: if c.prefix.is_empty() {
+                                None
+                            } else {
+                                Some(inlines_to_text(&c.prefix))
+                            },
+                            suffix: if suffix.is_empty() {
+                                None
+                            } else {
+                                Some(suffix)
+                            },
+                            suppress_author: Some(matches!(
+                                c.mode,
+                                crate::pandoc::CitationMode::SuppressAuthor
+                            )),
+                            author_only: Some(matches!(
+                                c.mode,
+                                crate::pandoc::CitationMode::AuthorInText
+                            )),
+                            position: None,
+                        }
                     })
                     .collect();
 
@@ -927,6 +946,172 @@ fn extract_date(
     }
 }
 
+/// A locator at the start of a citation suffix, as in `[@doe, pp. 33-35]`
+#[derive(Debug, PartialEq)]
+struct ParsedLocator {
+    /// CSL locator type, e.g. `page`
+    label: String,
+    /// Locator value, e.g. `33-35`
+    value: String,
+    /// The rest of the suffix, with its leading comma if any
+    rest: String,
+}
+
+/// Locator terms Pandoc recognizes in citation suffixes, with the CSL
+/// locator type each stands for
+const LOCATOR_TERMS: &[(&str, &str)] = &[
+    ("book", "book"),
+    ("books", "book"),
+    ("bk.", "book"),
+    ("bks.", "book"),
+    ("chapter", "chapter"),
+    ("chapters", "chapter"),
+    ("chap.", "chapter"),
+    ("chaps.", "chapter"),
+    ("ch.", "chapter"),
+    ("column", "column"),
+    ("columns", "column"),
+    ("col.", "column"),
+    ("cols.", "column"),
+    ("figure", "figure"),
+    ("figures", "figure"),
+    ("fig.", "figure"),
+    ("figs.", "figure"),
+    ("folio", "folio"),
+    ("folios", "folio"),
+    ("fol.", "folio"),
+    ("fols.", "folio"),
+    ("number", "issue"),
+    ("numbers", "issue"),
+    ("no.", "issue"),
+    ("nos.", "issue"),
+    ("line", "line"),
+    ("lines", "line"),
+    ("l.", "line"),
+    ("ll.", "line"),
+    ("note", "note"),
+    ("notes", "note"),
+    ("n.", "note"),
+    ("nn.", "note"),
+    ("opus", "opus"),
+    ("opera", "opus"),
+    ("op.", "opus"),
+    ("opp.", "opus"),
+    ("page", "page"),
+    ("pages", "page"),
+    ("p.", "page"),
+    ("pp.", "page"),
+    ("paragraph", "paragraph"),
+    ("paragraphs", "paragraph"),
+    ("para.", "paragraph"),
+    ("paras.", "paragraph"),
+    ("¶¶", "paragraph"),
+    ("¶", "paragraph"),
+    ("part", "part"),
+    ("parts", "part"),
+    ("pt.", "part"),
+    ("pts.", "part"),
+    ("section", "section"),
+    ("sections", "section"),
+    ("sec.", "section"),
+    ("secs.", "section"),
+    ("§§", "section"),
+    ("§", "section"),
+    ("sub verbo", "sub-verbo"),
+    ("sub verbis", "sub-verbo"),
+    ("s.v.", "sub-verbo"),
+    ("s.vv.", "sub-verbo"),
+    ("verse", "verse"),
+    ("verses", "verse"),
+    ("v.", "verse"),
+    ("vv.", "verse"),
+    ("volume", "volume"),
+    ("volumes", "volume"),
+    ("vol.", "volume"),
+    ("vols.", "volume"),
+];
+
+/// Split a locator off the start of a citation suffix, the way Pandoc does.
+///
+/// The locator is an optional term (`p.`, `chap.`, `§`, ...) followed by a
+/// value: numbers, ranges and roman numerals joined by commas or `and`, or
+/// any text in braces. Without a term, a value starting with a digit is a
+/// page. Returns `None` when the suffix doesn't start with a locator.
+fn parse_locator(suffix: &str) -> Option<ParsedLocator> {
+    let text = suffix.trim_start();
+    let text = text.strip_prefix(',').unwrap_or(text).trim_start();
+
+    let (label, text) = match LOCATOR_TERMS.iter().find_map(|(term, label)| {
+        let rest = text
+            .get(..term.len())
+            .filter(|head| head.eq_ignore_ascii_case(term))
+            .map(|_| &text[term.len()..])?;
+        // The term has to end here: `p. 3`, `p.3`, `§3` but not `page3x`
+        match rest.chars().next() {
+            Some(c) if c.is_whitespace() || c.is_ascii_digit() || c == '{' => {
+                Some((label.to_string(), rest.trim_start()))
+            }
+            _ => None,
+        }
+    }) {
+        Some(found) => found,
+        None if text.starts_with(|c: char| c.is_ascii_digit()) => ("page".to_string(), text),
+        None => return None,
+    };
+
+    if let Some(braced) = text.strip_prefix('{') {
+        let end = braced.find('}')?;
+        return Some(ParsedLocator {
+            label,
+            value: braced[..end].to_string(),
+            rest: braced[end + 1..].to_string(),
+        });
+    }
+
+    let word_end = |s: &str| {
+        s.find(|c: char| c.is_whitespace() || c == ',')
+            .unwrap_or(s.len())
+    };
+    let is_value = |word: &str| {
+        !word.is_empty()
+            && (word.contains(|c: char| c.is_ascii_digit())
+                || word.chars().all(|c| "ivxlcdmIVXLCDM".contains(c)))
+    };
+
+    let first = &text[..word_end(text)];
+    if !is_value(first) {
+        return None;
+    }
+    let mut end = first.len();
+    loop {
+        let rest = &text[end..];
+        // A separator joins the value with one more value word
+        let after = if let Some(after) = rest.strip_prefix(',') {
+            after.trim_start()
+        } else {
+            let trimmed = rest.trim_start();
+            match trimmed
+                .strip_prefix("and ")
+                .or_else(|| trimmed.strip_prefix("& "))
+            {
+                Some(after) if trimmed.len() < rest.len() => after.trim_start(),
+                _ => break,
+            }
+        };
+        let word = &after[..word_end(after)];
+        if !is_value(word) {
+            break;
+        }
+        end = text.len() - after.len() + word.len();
+    }
+
+    Some(ParsedLocator {
+        label,
+        value: text[..end].to_string(),
+        rest: text[end..].to_string(),
+    })
+}
+
 /// Convert inlines to plain text (for metadata extraction).
 fn inlines_to_text(inlines: &[crate::pandoc::Inline]) -> String {
     use crate::pandoc::Inline;
@@ -2269,6 +2454,7 @@ mod tests {
     fn test_collect_citations_in_note() {
         let cite = make_cite("note2020");
         let note = Inline::Note(crate::pandoc::Note {
+            id: None,
             content: vec![Block::Paragraph(crate::pandoc::Paragraph {
                 content: vec![cite],
                 source_info: si(),
@@ -2313,7 +2499,49 @@ mod tests {
         let citations = collect_citations(&pandoc);
         assert_eq!(citations.len(), 1);
         assert_eq!(citations[0].items[0].prefix, Some("see ".to_string()));
-        assert_eq!(citations[0].items[0].suffix, Some(", p. 42".to_string()));
+        // The page number in the suffix is the locator
+        assert_eq!(citations[0].items[0].locator, Some("42".to_string()));
+        assert_eq!(citations[0].items[0].label, Some("page".to_string()));
+        assert_eq!(citations[0].items[0].suffix, None);
+    }
+
+    fn locator(suffix: &str) -> Option<(String, String, String)> {
+        parse_locator(suffix).map(|l| (l.label, l.value, l.rest))
+    }
+
+    fn parsed(label: &str, value: &str, rest: &str) -> Option<(String, String, String)> {
+        Some((label.to_string(), value.to_string(), rest.to_string()))
+    }
+
+    #[test]
+    fn test_parse_locator() {
+        assert_eq!(locator(", p. 42"), parsed("page", "42", ""));
+        assert_eq!(locator(", pp. 33-35"), parsed("page", "33-35", ""));
+        assert_eq!(locator(", chap. 1"), parsed("chapter", "1", ""));
+        assert_eq!(locator(" §3"), parsed("section", "3", ""));
+        assert_eq!(locator(", vol. iv"), parsed("volume", "iv", ""));
+        assert_eq!(locator(", 12"), parsed("page", "12", ""));
+        assert_eq!(
+            locator(", pp. 33-35, 38-39 and passim"),
+            parsed("page", "33-35, 38-39", " and passim")
+        );
+        assert_eq!(
+            locator(", p. 33, emphasis added"),
+            parsed("page", "33", ", emphasis added")
+        );
+        assert_eq!(locator(", Page 7 and 9"), parsed("page", "7 and 9", ""));
+        assert_eq!(
+            locator(", p. {33-35 passim} and more"),
+            parsed("page", "33-35 passim", " and more")
+        );
+    }
+
+    #[test]
+    fn test_parse_locator_without_locator() {
+        assert_eq!(locator(", emphasis added"), None);
+        assert_eq!(locator(" and others"), None);
+        assert_eq!(locator(", pages"), None);
+        assert_eq!(locator(""), None);
     }
 
     // Test citation modes
@@ -2776,6 +3004,7 @@ mod tests {
         use quarto_citeproc::Citation as CpCitation;
 
         let mut inlines = vec![Inline::Note(crate::pandoc::Note {
+            id: None,
             content: vec![Block::Paragraph(crate::pandoc::Paragraph {
                 content: vec![make_cite("note2020")],
                 source_info: si(),
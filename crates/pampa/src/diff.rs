@@ -0,0 +1,127 @@
+/*
+ * diff.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa diff OLD NEW`: the structural diff of two documents (see
+//! `ast_diff`), for people or, with `--json`, as a list of block changes.
+//!
+//! Each file is read by its extension: `.json` as Pandoc JSON, `.ipynb` as
+//! a notebook and anything else as qmd. Footnote definitions are joined
+//! with their references first, as for a conversion.
+
+use super::{Args, ConversionFailed, Messages};
+use crate::ast_diff;
+use crate::pandoc::{ASTContext, Pandoc};
+use crate::{readers, transforms};
+use std::io::Write;
+use std::path::Path;
+
+/// Diff the documents and return the exit code: 0 when they're the same,
+/// 1 when they differ, 2 when one can't be read
+pub fn run(args: &Args, before: &Path, after: &Path, json: bool) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+    let (Ok((old, _)), Ok((new, _))) = (
+        read_document(args, before, &mut messages),
+        read_document(args, after, &mut messages),
+    ) else {
+        return 2;
+    };
+
+    let diff = ast_diff::diff_documents(&old, &new);
+    let mut stdout = std::io::stdout();
+    let written = if json {
+        serde_json::to_writer_pretty(&mut stdout, &diff)
+            .map_err(std::io::Error::from)
+            .and_then(|_| writeln!(stdout))
+    } else {
+        ast_diff::write_text(&diff, &old, &new, &mut stdout)
+    };
+    if let Err(e) = written {
+        eprintln!("Failed to write the diff: {}", e);
+        return 2;
+    }
+    if diff.is_empty() { 0 } else { 1 }
+}
+
+/// Read a document for `diff` or `query`, by its extension
+pub fn read_document(
+    args: &Args,
+    path: &Path,
+    messages: &mut Messages,
+) -> Result<(Pandoc, ASTContext), ConversionFailed> {
+    let filename = path.to_string_lossy();
+    let input = std::fs::read_to_string(path).map_err(|e| {
+        messages.error(format_args!("Failed to read '{}': {}", filename, e));
+        ConversionFailed
+    })?;
+    let extension = path.extension().and_then(|extension| extension.to_str());
+    parse_document(args, &filename, input, extension, messages)
+}
+
+/// Read the text of a document: Pandoc JSON for `.json`, a notebook for
+/// `.ipynb` and qmd for anything else
+pub fn parse_document(
+    args: &Args,
+    filename: &str,
+    mut input: String,
+    extension: Option<&str>,
+    messages: &mut Messages,
+) -> Result<(Pandoc, ASTContext), ConversionFailed> {
+    match extension {
+        Some("json") => readers::json::read(&mut input.as_bytes()).map_err(|e| {
+            messages.error(format_args!("Error reading JSON '{}': {}", filename, e));
+            ConversionFailed
+        }),
+        Some("ipynb") => match readers::ipynb::read(&input, filename) {
+            Ok((pandoc, context, _warnings)) => Ok((pandoc, context)),
+            Err(readers::ipynb::IpynbReadError::CellParse {
+                diagnostics,
+                source_context,
+                ..
+            }) => {
+                messages.report_all(&diagnostics, &source_context);
+                Err(ConversionFailed)
+            }
+            Err(e) => {
+                messages.error(format_args!("Error reading notebook '{}': {}", filename, e));
+                Err(ConversionFailed)
+            }
+        },
+        _ => {
+            if !input.ends_with('\n') {
+                input.push('\n');
+            }
+            let result = readers::qmd::read(
+                input.as_bytes(),
+                args.loose,
+                filename,
+                &mut std::io::sink(),
+                true,
+                None,
+            );
+            match result {
+                Ok((mut pandoc, context, _warnings)) => {
+                    pandoc.blocks = transforms::resolve_footnotes(
+                        std::mem::take(&mut pandoc.blocks),
+                        &context.source_context,
+                    );
+                    Ok((pandoc, context))
+                }
+                Err(diagnostics) => {
+                    let mut source_context = quarto_source_map::SourceContext::new();
+                    source_context.add_file(filename.to_string(), Some(input.clone()));
+                    messages.report_all(&diagnostics, &source_context);
+                    Err(ConversionFailed)
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,182 @@
+/*
+ * doctemplate.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa doctemplate`: tools for Pandoc-style templates.
+//!
+//! `pampa doctemplate lint TEMPLATE` reports unbalanced directives, missing
+//! partials and suspicious pipes (see `quarto_doctemplate::lint`). With
+//! `--schema`, the variables the template uses are checked against a JSON
+//! Schema of the metadata. Partials are read from next to the template, as
+//! a render would.
+//!
+//! `pampa doctemplate schema TEMPLATE` prints a JSON Schema of the metadata
+//! the template uses (see `quarto_doctemplate::template_variables`), which
+//! `lint --schema` takes.
+//!
+//! `pampa doctemplate fmt [FILES]` indents the directives of templates (see
+//! `quarto_doctemplate::format`), like `pampa fmt` does for qmd.
+
+use super::{Args, Messages};
+use quarto_doctemplate::{FileSystemResolver, LintOptions, LintSchema, Template};
+use std::io::{Read, Write};
+use std::path::{Path, PathBuf};
+
+/// Lint a template and return the exit code: 0 when there are no errors
+/// (warnings are fine), 1 when there are, 2 when the template or the
+/// schema can't be read
+pub fn lint(args: &Args, template: &Path, schema: Option<&Path>) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: args.diagnostics.then(Vec::new),
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    let source = match std::fs::read_to_string(template) {
+        Ok(source) => source,
+        Err(e) => {
+            messages.error(format_args!(
+                "Failed to read '{}': {}",
+                template.display(),
+                e
+            ));
+            messages.write_collected();
+            return 2;
+        }
+    };
+    let schema = match schema.map(read_schema).transpose() {
+        Ok(schema) => schema,
+        Err(message) => {
+            messages.error(format_args!("{}", message));
+            messages.write_collected();
+            return 2;
+        }
+    };
+
+    let options = LintOptions {
+        schema: schema.as_ref(),
+        partials: Some(&FileSystemResolver),
+    };
+    let report = quarto_doctemplate::lint(&source, template, &options);
+    messages.report_all(&report.diagnostics, &report.source_context);
+    messages.write_collected();
+    if report.has_errors() { 1 } else { 0 }
+}
+
+/// Print the JSON Schema of the variables a template uses and return the
+/// exit code: 0, or 2 when the template can't be read or doesn't parse
+pub fn schema(args: &Args, template: &Path) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    let compiled = match Template::compile_from_file(template) {
+        Ok(compiled) => compiled,
+        Err(e) => {
+            messages.error(format_args!(
+                "Failed to compile '{}': {}",
+                template.display(),
+                e
+            ));
+            return 2;
+        }
+    };
+    let schema = quarto_doctemplate::template_variables(&compiled).json_schema();
+    let _ = writeln!(messages.stdout, "{:#}", schema);
+    0
+}
+
+fn read_schema(path: &Path) -> Result<LintSchema, String> {
+    let json = std::fs::read_to_string(path)
+        .map_err(|e| format!("Failed to read '{}': {}", path.display(), e))?;
+    LintSchema::from_json(&json)
+        .map_err(|e| format!("'{}' is not a JSON schema: {}", path.display(), e))
+}
+
+/// Format the templates (or stdin) and return the exit code: 0 when all
+/// went well, 1 when `check` found templates that aren't formatted, 2 on
+/// errors
+pub fn fmt(args: &Args, files: &[PathBuf], check: bool) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    if files.is_empty() {
+        let mut input = String::new();
+        if let Err(e) = std::io::stdin().read_to_string(&mut input) {
+            messages.error(format_args!("Failed to read from stdin: {}", e));
+            return 2;
+        }
+        let formatted = match quarto_doctemplate::format(&input) {
+            Ok(formatted) => formatted,
+            Err(e) => {
+                messages.error(format_args!("Not formatting <stdin>: {}", e));
+                return 2;
+            }
+        };
+        if check {
+            return if formatted == input { 0 } else { 1 };
+        }
+        let _ = messages.stdout.write_all(formatted.as_bytes());
+        return 0;
+    }
+
+    let mut failed = false;
+    let mut unformatted = 0;
+    for path in files {
+        match format_file(path, check) {
+            Ok(true) => {}
+            Ok(false) => {
+                unformatted += 1;
+                let _ = writeln!(messages.stdout, "{}", path.display());
+            }
+            Err(message) => {
+                messages.error(format_args!("{}", message));
+                failed = true;
+            }
+        }
+    }
+    if failed {
+        2
+    } else if unformatted > 0 {
+        messages.error(format_args!(
+            "{} of {} templates are not formatted",
+            unformatted,
+            files.len()
+        ));
+        1
+    } else {
+        0
+    }
+}
+
+/// Format a template in place, or with `check` only compare. Returns
+/// whether the template was already formatted.
+fn format_file(path: &Path, check: bool) -> Result<bool, String> {
+    let input = std::fs::read_to_string(path)
+        .map_err(|e| format!("Failed to read '{}': {}", path.display(), e))?;
+    let formatted = quarto_doctemplate::format(&input)
+        .map_err(|e| format!("Not formatting '{}': {}", path.display(), e))?;
+    if formatted == input {
+        return Ok(true);
+    }
+    if !check {
+        std::fs::write(path, &formatted)
+            .map_err(|e| format!("Failed to write '{}': {}", path.display(), e))?;
+    }
+    Ok(false)
+}
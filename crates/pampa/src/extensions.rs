@@ -0,0 +1,330 @@
+/*
+ * extensions.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Format extensions, in Pandoc's syntax: `-f qmd-smart+footnotes`.
+//!
+//! A format name can be followed by any number of `+name` and `-name`,
+//! each turning one extension on or off, in order. Each reader and writer
+//! supports a few extensions, with its own defaults; naming one that it
+//! doesn't support is an error rather than silently ignored.
+//!
+//! - `auto_identifiers`: identifiers for headings without one (qmd,
+//!   markdown and gfm readers, on; commonmark reader, off)
+//! - `gfm_auto_identifiers`: those identifiers made as GitHub makes them
+//!   (gfm reader, on; qmd, markdown and commonmark readers, off)
+//! - `ascii_identifiers`: those identifiers without accents or letters
+//!   outside ASCII (all markdown readers, off)
+//! - `footnotes`: `[^id]` references joined with their definitions (qmd
+//!   and markdown readers, on)
+//! - `smart`: smart punctuation (all markdown readers, the org and rst
+//!   readers, and the qmd and markdown writers, off)
+//! - `sourcepos`: source positions in the output (all markdown readers,
+//!   off)
+//! - `block_ids`: stable identifiers for blocks in JSON output (all
+//!   markdown readers, off)
+//! - `superscript` and `subscript`: `^sup^` and `~sub~` (qmd and markdown
+//!   readers, on)
+//! - `strikeout`: `~~strike~~` (qmd, markdown and gfm readers, on)
+//! - `mark`: `==highlighted==` text (qmd and markdown readers and writers,
+//!   off)
+//!
+//! `--smart` and `--sourcepos` turn on the extensions of the same name.
+
+use std::collections::BTreeSet;
+use std::fmt;
+
+/// A behavior of a reader or writer that can be turned on or off
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash)]
+pub enum Extension {
+    /// Give headings without an identifier one made from their text
+    AutoIdentifiers,
+    /// Make those identifiers as GitHub does rather than as Pandoc does
+    GfmAutoIdentifiers,
+    /// Make those identifiers of ASCII only, removing accents
+    AsciiIdentifiers,
+    /// Join `[^id]` references with their definitions into notes
+    Footnotes,
+    /// Read `---`, `--` and `...` as dashes and ellipses, and write them
+    /// back that way
+    Smart,
+    /// Attach source positions to every block and inline
+    Sourcepos,
+    /// Give every block in JSON output an identifier made from its content
+    BlockIds,
+    /// Read `^sup^` as superscript
+    Superscript,
+    /// Read `~sub~` as subscript
+    Subscript,
+    /// Read `~~strike~~` as strikeout
+    Strikeout,
+    /// Read `==highlighted==` text as a Span with the `mark` class, and
+    /// write such Spans back that way
+    Mark,
+}
+
+impl Extension {
+    pub const ALL: [Extension; 11] = [
+        Extension::AutoIdentifiers,
+        Extension::GfmAutoIdentifiers,
+        Extension::AsciiIdentifiers,
+        Extension::Footnotes,
+        Extension::Smart,
+        Extension::Sourcepos,
+        Extension::BlockIds,
+        Extension::Superscript,
+        Extension::Subscript,
+        Extension::Strikeout,
+        Extension::Mark,
+    ];
+
+    /// The name of the extension, as written after `+` or `-`
+    pub fn name(self) -> &'static str {
+        match self {
+            Extension::AutoIdentifiers => "auto_identifiers",
+            Extension::GfmAutoIdentifiers => "gfm_auto_identifiers",
+            Extension::AsciiIdentifiers => "ascii_identifiers",
+            Extension::Footnotes => "footnotes",
+            Extension::Smart => "smart",
+            Extension::Sourcepos => "sourcepos",
+            Extension::BlockIds => "block_ids",
+            Extension::Superscript => "superscript",
+            Extension::Subscript => "subscript",
+            Extension::Strikeout => "strikeout",
+            Extension::Mark => "mark",
+        }
+    }
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        Self::ALL
+            .into_iter()
+            .find(|extension| extension.name() == name)
+    }
+}
+
+impl fmt::Display for Extension {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.name())
+    }
+}
+
+/// Whether a format is read or written
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Direction {
+    Reader,
+    Writer,
+}
+
+impl fmt::Display for Direction {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Direction::Reader => "reader",
+            Direction::Writer => "writer",
+        })
+    }
+}
+
+/// The extensions a reader or writer supports, each with whether it is on
+/// by default
+pub fn supported(format: &str, direction: Direction) -> &'static [(Extension, bool)] {
+    use Extension::*;
+    match (direction, format) {
+        (Direction::Reader, "qmd" | "markdown") => &[
+            (AutoIdentifiers, true),
+            (GfmAutoIdentifiers, false),
+            (AsciiIdentifiers, false),
+            (Footnotes, true),
+            (Smart, false),
+            (Sourcepos, false),
+            (BlockIds, false),
+            (Superscript, true),
+            (Subscript, true),
+            (Strikeout, true),
+            (Mark, false),
+        ],
+        (Direction::Reader, "commonmark") => &[
+            (AutoIdentifiers, false),
+            (GfmAutoIdentifiers, false),
+            (AsciiIdentifiers, false),
+            (Smart, false),
+            (Sourcepos, false),
+            (BlockIds, false),
+        ],
+        (Direction::Reader, "gfm") => &[
+            (AutoIdentifiers, true),
+            (GfmAutoIdentifiers, true),
+            (AsciiIdentifiers, false),
+            (Smart, false),
+            (Sourcepos, false),
+            (BlockIds, false),
+            (Strikeout, true),
+        ],
+        (Direction::Reader, "org" | "rst") => &[(Smart, false)],
+        (Direction::Writer, "qmd" | "markdown") => &[(Smart, false), (Mark, false)],
+        _ => &[],
+    }
+}
+
+/// The extensions that are on
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Extensions {
+    enabled: BTreeSet<Extension>,
+}
+
+impl Extensions {
+    /// The default extensions of a reader or writer
+    pub fn defaults(format: &str, direction: Direction) -> Self {
+        Self {
+            enabled: supported(format, direction)
+                .iter()
+                .filter(|(_, on)| *on)
+                .map(|(extension, _)| *extension)
+                .collect(),
+        }
+    }
+
+    pub fn is_enabled(&self, extension: Extension) -> bool {
+        self.enabled.contains(&extension)
+    }
+
+    pub fn enable(&mut self, extension: Extension) {
+        self.enabled.insert(extension);
+    }
+
+    pub fn disable(&mut self, extension: Extension) {
+        self.enabled.remove(&extension);
+    }
+}
+
+/// The names of the extensions that are on, e.g. `smart,footnotes`
+impl fmt::Display for Extensions {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        for (i, extension) in self.enabled.iter().enumerate() {
+            if i > 0 {
+                f.write_str(",")?;
+            }
+            write!(f, "{}", extension)?;
+        }
+        Ok(())
+    }
+}
+
+/// A format name with its extensions, as given to `-f` or `-t`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FormatSpec {
+    pub name: String,
+    pub extensions: Extensions,
+}
+
+/// Parse `name(+ext|-ext)*`. The extensions start from the defaults of the
+/// reader or writer and are turned on and off from left to right.
+pub fn parse_format(spec: &str, direction: Direction) -> Result<FormatSpec, String> {
+    let end = spec.find(['+', '-']).unwrap_or(spec.len());
+    let name = &spec[..end];
+    let mut extensions = Extensions::defaults(name, direction);
+    let mut rest = &spec[end..];
+    while let Some(sign) = rest.chars().next() {
+        rest = &rest[1..];
+        let end = rest.find(['+', '-']).unwrap_or(rest.len());
+        let extension_name = &rest[..end];
+        rest = &rest[end..];
+
+        let extension = Extension::from_name(extension_name)
+            .filter(|extension| {
+                supported(name, direction)
+                    .iter()
+                    .any(|(supported, _)| supported == extension)
+            })
+            .ok_or_else(|| {
+                format!(
+                    "The {} {} has no extension '{}'; see --list-extensions={}",
+                    name, direction, extension_name, name
+                )
+            })?;
+        tracing::debug!(
+            format = name,
+            direction = %direction,
+            extension = %extension,
+            enabled = sign == '+',
+            "extension toggled in the format"
+        );
+        if sign == '+' {
+            extensions.enable(extension);
+        } else {
+            extensions.disable(extension);
+        }
+    }
+    Ok(FormatSpec {
+        name: name.to_string(),
+        extensions,
+    })
+}
+
+/// The lines of `--list-extensions=FORMAT`: `+name` for the extensions
+/// that are on by default and `-name` for the others, reader extensions
+/// first
+pub fn list(format: &str) -> Vec<String> {
+    let mut lines = Vec::new();
+    for direction in [Direction::Reader, Direction::Writer] {
+        for (extension, on) in supported(format, direction) {
+            lines.push(format!(
+                "{}{} ({})",
+                if *on { '+' } else { '-' },
+                extension,
+                direction
+            ));
+        }
+    }
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_format_toggles_in_order() {
+        let spec = parse_format("qmd-footnotes+smart", Direction::Reader).unwrap();
+        assert_eq!(spec.name, "qmd");
+        assert!(!spec.extensions.is_enabled(Extension::Footnotes));
+        assert!(spec.extensions.is_enabled(Extension::Smart));
+        assert!(spec.extensions.is_enabled(Extension::AutoIdentifiers));
+
+        let spec = parse_format("gfm+ascii_identifiers", Direction::Reader).unwrap();
+        assert!(spec.extensions.is_enabled(Extension::GfmAutoIdentifiers));
+        assert!(spec.extensions.is_enabled(Extension::AsciiIdentifiers));
+
+        let spec = parse_format("qmd+smart-smart", Direction::Reader).unwrap();
+        assert!(!spec.extensions.is_enabled(Extension::Smart));
+
+        let spec = parse_format("qmd-superscript+mark", Direction::Reader).unwrap();
+        assert!(!spec.extensions.is_enabled(Extension::Superscript));
+        assert!(spec.extensions.is_enabled(Extension::Subscript));
+        assert!(spec.extensions.is_enabled(Extension::Mark));
+    }
+
+    #[test]
+    fn test_display_lists_enabled_extensions() {
+        let mut extensions = Extensions::default();
+        assert_eq!(extensions.to_string(), "");
+        extensions.enable(Extension::Mark);
+        extensions.enable(Extension::Smart);
+        assert_eq!(extensions.to_string(), "smart,mark");
+    }
+
+    #[test]
+    fn test_parse_format_without_extensions() {
+        let spec = parse_format("html", Direction::Writer).unwrap();
+        assert_eq!(spec.name, "html");
+        assert_eq!(spec.extensions, Extensions::default());
+    }
+
+    #[test]
+    fn test_unsupported_extensions_are_errors() {
+        let error = parse_format("html+smart", Direction::Writer).unwrap_err();
+        assert!(error.contains("no extension 'smart'"), "{}", error);
+        assert!(parse_format("qmd+nonsense", Direction::Reader).is_err());
+        assert!(parse_format("qmd+footnotes", Direction::Writer).is_err());
+    }
+}
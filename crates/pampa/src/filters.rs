@@ -285,6 +285,7 @@ impl_inline_filterable_simple!(
 impl InlineFilterableStructure for pandoc::Note {
     fn filter_structure(self, filter: &mut Filter, ctx: &mut FilterContext) -> Inline {
         Inline::Note(pandoc::Note {
+            id: self.id,
             content: topdown_traverse_blocks(self.content, filter, ctx),
             source_info: self.source_info,
         })
@@ -847,6 +848,7 @@ fn traverse_inline_nonterminal(
             target_source: i.target_source,
         }),
         Inline::Note(note) => Inline::Note(crate::pandoc::Note {
+            id: note.id,
             content: topdown_traverse_blocks(note.content, filter, ctx),
             source_info: note.source_info,
         }),
@@ -1420,6 +1422,7 @@ mod tests {
         let mut filter = Filter::new();
         let mut ctx = FilterContext::new();
         let inline = Inline::Note(Note {
+            id: None,
             content: vec![Block::Paragraph(Paragraph {
                 content: vec![str_inline("note content")],
                 source_info: si(),
@@ -2099,6 +2102,7 @@ mod tests {
         let mut filter = Filter::new();
         let mut ctx = FilterContext::new();
         let note = Note {
+            id: None,
             content: vec![Block::Paragraph(Paragraph {
                 content: vec![str_inline("note")],
                 source_info: si(),
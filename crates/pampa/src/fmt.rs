@@ -0,0 +1,221 @@
+/*
+ * fmt.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa fmt`: rewrite qmd in canonical form.
+//!
+//! A file is read and written again with `writers::qmd::write_formatted`,
+//! which keeps the front matter when it is unchanged and writes everything
+//! else the one way the writer options say. Paragraphs are filled to
+//! `--columns` unless `--wrap` is given. Files keep their line endings and
+//! byte order mark unless `--line-endings` says otherwise.
+//!
+//! Before a file is replaced, its formatted text is read and formatted
+//! again, and must come out as the same document and the same text. A
+//! file for which that fails is left alone and reported: it's a bug in the
+//! reader or the writer, and formatting must never change a document or
+//! keep changing it.
+
+use super::{Args, ConversionFailed, Messages, line_endings, qmd_config};
+use crate::ast_diff::diff_documents;
+use crate::pandoc::Pandoc;
+use crate::utils::line_endings::{TextStyle, normalize, restore};
+use crate::writers::qmd::{QmdConfig, WrapMode, write_formatted};
+use crate::{readers, transforms};
+use std::io::{Read, Write};
+use std::path::{Path, PathBuf};
+
+/// Format the files (or stdin) and return the exit code: 0 when all went
+/// well, 1 when `check` found files that aren't formatted, 2 on errors
+pub fn run(args: &Args, files: &[PathBuf], check: bool) -> i32 {
+    let config = config(args);
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    if files.is_empty() {
+        let mut input = String::new();
+        if let Err(e) = std::io::stdin().read_to_string(&mut input) {
+            messages.error(format_args!("Failed to read from stdin: {}", e));
+            return 2;
+        }
+        let Ok(formatted) = format(args, &config, "<stdin>", &input, &mut messages) else {
+            return 2;
+        };
+        if check {
+            return if formatted == input { 0 } else { 1 };
+        }
+        let _ = messages.stdout.write_all(formatted.as_bytes());
+        return 0;
+    }
+
+    let mut failed = false;
+    let mut unformatted = 0;
+    for path in files {
+        match format_file(args, &config, path, check, &mut messages) {
+            Ok(true) => {}
+            Ok(false) => {
+                unformatted += 1;
+                let _ = writeln!(messages.stdout, "{}", path.display());
+            }
+            Err(ConversionFailed) => failed = true,
+        }
+    }
+    if failed {
+        2
+    } else if unformatted > 0 {
+        messages.error(format_args!(
+            "{} of {} files are not formatted",
+            unformatted,
+            files.len()
+        ));
+        1
+    } else {
+        0
+    }
+}
+
+/// The writer options of formatting: those of the arguments, with lines
+/// filled unless `--wrap` says otherwise
+pub fn config(args: &Args) -> QmdConfig {
+    let mut config = qmd_config(args);
+    if args.wrap.is_none() {
+        config.wrap = WrapMode::Auto;
+    }
+    config
+}
+
+/// Format a file in place, or with `check` only compare. Returns whether
+/// the file was already formatted.
+fn format_file(
+    args: &Args,
+    config: &QmdConfig,
+    path: &Path,
+    check: bool,
+    messages: &mut Messages,
+) -> Result<bool, ConversionFailed> {
+    let filename = path.to_string_lossy();
+    let input = std::fs::read_to_string(path).map_err(|e| {
+        messages.error(format_args!("Failed to read '{}': {}", filename, e));
+        ConversionFailed
+    })?;
+    let formatted = format(args, config, &filename, &input, messages)?;
+    if formatted == input {
+        return Ok(true);
+    }
+    if !check {
+        std::fs::write(path, &formatted).map_err(|e| {
+            messages.error(format_args!("Failed to write '{}': {}", filename, e));
+            ConversionFailed
+        })?;
+    }
+    Ok(false)
+}
+
+/// The canonical text of a document, checked to be the same document and
+/// to be formatted itself
+pub fn format(
+    args: &Args,
+    config: &QmdConfig,
+    filename: &str,
+    input: &str,
+    messages: &mut Messages,
+) -> Result<String, ConversionFailed> {
+    // Formatted with \n line endings and no BOM, then written back in the
+    // style of the file unless --line-endings says otherwise
+    let style = line_endings(args).output_style(TextStyle::detect(input), true);
+    let normalized_input = normalize(input);
+    let input: &str = &normalized_input;
+
+    let document = read(args, filename, input, true, messages)?;
+    let formatted = write(&document, config, input, filename, messages)?;
+
+    let reformatted = match read(args, filename, &formatted, false, messages) {
+        Ok(reread) if diff_documents(&document, &reread).is_empty() => {
+            write(&reread, config, &formatted, filename, messages)?
+        }
+        _ => {
+            messages.error(format_args!(
+                "Not formatting '{}': the formatted text reads as a different document",
+                filename
+            ));
+            return Err(ConversionFailed);
+        }
+    };
+    if reformatted != formatted {
+        messages.error(format_args!(
+            "Not formatting '{}': formatting it again changes it again",
+            filename
+        ));
+        return Err(ConversionFailed);
+    }
+    Ok(String::from_utf8(restore(formatted.into_bytes(), style))
+        .expect("restoring line endings keeps UTF-8"))
+}
+
+/// Read qmd, reporting warnings and errors if `report`
+fn read(
+    args: &Args,
+    filename: &str,
+    input: &str,
+    report: bool,
+    messages: &mut Messages,
+) -> Result<Pandoc, ConversionFailed> {
+    let mut input = input.to_string();
+    if !input.ends_with('\n') {
+        input.push('\n');
+    }
+    let result = readers::qmd::read(
+        input.as_bytes(),
+        args.loose,
+        filename,
+        &mut std::io::sink(),
+        true,
+        None,
+    );
+    match result {
+        Ok((mut pandoc, context, warnings)) => {
+            if report {
+                messages.report_all(&warnings, &context.source_context);
+            }
+            if args
+                .reader_extensions
+                .is_enabled(crate::extensions::Extension::Smart)
+            {
+                pandoc.blocks = transforms::smart_punctuation(std::mem::take(&mut pandoc.blocks));
+            }
+            Ok(pandoc)
+        }
+        Err(diagnostics) => {
+            if report {
+                let mut source_context = quarto_source_map::SourceContext::new();
+                source_context.add_file(filename.to_string(), Some(input));
+                messages.report_all(&diagnostics, &source_context);
+            }
+            Err(ConversionFailed)
+        }
+    }
+}
+
+fn write(
+    document: &Pandoc,
+    config: &QmdConfig,
+    source: &str,
+    filename: &str,
+    messages: &mut Messages,
+) -> Result<String, ConversionFailed> {
+    let mut buf = Vec::new();
+    if let Err(diagnostics) = write_formatted(document, config, source, &mut buf) {
+        let mut source_context = quarto_source_map::SourceContext::new();
+        source_context.add_file(filename.to_string(), Some(source.to_string()));
+        messages.report_all(&diagnostics, &source_context);
+        return Err(ConversionFailed);
+    }
+    Ok(String::from_utf8(buf).expect("qmd output is UTF-8"))
+}
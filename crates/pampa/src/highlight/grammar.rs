@@ -0,0 +1,102 @@
+/*
+ * highlight/grammar.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Highlighting with a tree-sitter grammar and its highlights query.
+//!
+//! Capture names of the query (`@keyword`, `@string.special`, ...) map to
+//! token kinds by their first component, as in editors that use tree-sitter.
+//! Where captures overlap, the first one found for a byte wins.
+
+use super::{Highlighter, LanguageHighlighter, Lines, TokenKind, tokens_from_kinds};
+use tree_sitter::{Language, Parser, Query, QueryCursor, QueryError, StreamingIterator};
+
+/// A [`LanguageHighlighter`] for a tree-sitter grammar.
+pub struct GrammarHighlighter {
+    language: Language,
+    query: Query,
+    kinds: Vec<Option<TokenKind>>,
+}
+
+impl GrammarHighlighter {
+    /// Build a highlighter from a grammar and its highlights query.
+    pub fn new(language: Language, highlights_query: &str) -> Result<Self, QueryError> {
+        let query = Query::new(&language, highlights_query)?;
+        let kinds = query
+            .capture_names()
+            .iter()
+            .map(|name| capture_kind(name))
+            .collect();
+        Ok(Self {
+            language,
+            query,
+            kinds,
+        })
+    }
+}
+
+impl LanguageHighlighter for GrammarHighlighter {
+    fn highlight(&self, code: &str) -> Lines {
+        let mut kinds: Vec<Option<TokenKind>> = vec![None; code.len()];
+
+        let mut parser = Parser::new();
+        let tree = parser
+            .set_language(&self.language)
+            .ok()
+            .and_then(|()| parser.parse(code, None));
+        if let Some(tree) = tree {
+            let mut cursor = QueryCursor::new();
+            let mut captures = cursor.captures(&self.query, tree.root_node(), code.as_bytes());
+            while let Some((query_match, index)) = captures.next() {
+                let capture = query_match.captures[*index];
+                let Some(kind) = self.kinds[capture.index as usize] else {
+                    continue;
+                };
+                for slot in &mut kinds[capture.node.byte_range()] {
+                    slot.get_or_insert(kind);
+                }
+            }
+        }
+
+        let kinds: Vec<TokenKind> = kinds
+            .into_iter()
+            .map(|kind| kind.unwrap_or(TokenKind::Normal))
+            .collect();
+        tokens_from_kinds(code, &kinds)
+    }
+}
+
+/// The token kind of a highlights query capture, or `None` for captures
+/// that don't style text (such as `@none` and `@spell`).
+fn capture_kind(name: &str) -> Option<TokenKind> {
+    let kind = match name.split('.').next().unwrap_or(name) {
+        "keyword" | "conditional" | "repeat" | "include" | "exception" => TokenKind::Keyword,
+        "type" => TokenKind::DataType,
+        "number" => TokenKind::DecVal,
+        "float" => TokenKind::Float,
+        "string" | "character" => TokenKind::String,
+        "text" if name == "text.literal" => TokenKind::VerbatimString,
+        "punctuation" | "escape" => TokenKind::SpecialChar,
+        "comment" => TokenKind::Comment,
+        "function" | "method" | "constructor" => TokenKind::Function,
+        "operator" => TokenKind::Operator,
+        "constant" | "boolean" => TokenKind::Constant,
+        "variable" | "property" | "parameter" | "field" => TokenKind::Variable,
+        "attribute" | "tag" | "label" => TokenKind::Attribute,
+        "text" | "markup" => TokenKind::Other,
+        _ => return None,
+    };
+    Some(kind)
+}
+
+/// Register the grammars of this repo: the qmd grammar, for `markdown`,
+/// `md` and `qmd` code blocks.
+pub fn register_builtin_grammars(highlighter: &mut Highlighter) {
+    if let Ok(qmd) = GrammarHighlighter::new(
+        tree_sitter_qmd::LANGUAGE.into(),
+        tree_sitter_qmd::HIGHLIGHT_QUERY,
+    ) {
+        highlighter.register(&["markdown", "md", "qmd"], qmd);
+    }
+}
@@ -0,0 +1,474 @@
+/*
+ * highlight/lexer.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! A table-driven lexer for languages without a tree-sitter grammar here.
+//!
+//! Each language is described by a [`Syntax`]: its keyword, type, constant
+//! and builtin names, comment markers and string quotes. The lexer finds
+//! comments, strings, numbers, operators and names, and classifies a name
+//! by the tables, or as a function when a `(` follows it. It doesn't parse,
+//! so it can be wrong in places a grammar wouldn't be, but it never fails.
+
+use super::{Highlighter, LanguageHighlighter, Lines, TokenKind, tokens_from_kinds};
+
+/// The lexical rules of one language.
+#[derive(Debug, Clone, Copy)]
+pub struct Syntax {
+    pub keywords: &'static [&'static str],
+    pub types: &'static [&'static str],
+    pub constants: &'static [&'static str],
+    pub builtins: &'static [&'static str],
+    /// Markers that start a comment running to the end of the line
+    pub line_comments: &'static [&'static str],
+    /// Start and end markers of a block comment
+    pub block_comment: Option<(&'static str, &'static str)>,
+    /// Characters that open and close a string; strings may span lines
+    pub quotes: &'static [char],
+    /// Characters besides letters, digits and `_` allowed in names
+    pub name_chars: &'static [char],
+    /// Whether keywords are matched case-insensitively (SQL)
+    pub case_insensitive: bool,
+}
+
+const EMPTY: Syntax = Syntax {
+    keywords: &[],
+    types: &[],
+    constants: &[],
+    builtins: &[],
+    line_comments: &[],
+    block_comment: None,
+    quotes: &['"', '\''],
+    name_chars: &[],
+    case_insensitive: false,
+};
+
+/// A [`LanguageHighlighter`] for a [`Syntax`].
+#[derive(Debug, Clone, Copy)]
+pub struct Lexer {
+    syntax: Syntax,
+}
+
+impl Lexer {
+    pub fn new(syntax: Syntax) -> Self {
+        Self { syntax }
+    }
+
+    fn classify(&self, name: &str, followed_by_paren: bool) -> TokenKind {
+        let syntax = &self.syntax;
+        let lowered;
+        let name = if syntax.case_insensitive {
+            lowered = name.to_lowercase();
+            lowered.as_str()
+        } else {
+            name
+        };
+        if syntax.keywords.contains(&name) {
+            TokenKind::Keyword
+        } else if syntax.types.contains(&name) {
+            TokenKind::DataType
+        } else if syntax.constants.contains(&name) {
+            TokenKind::Constant
+        } else if syntax.builtins.contains(&name) {
+            TokenKind::BuiltIn
+        } else if followed_by_paren {
+            TokenKind::Function
+        } else {
+            TokenKind::Normal
+        }
+    }
+}
+
+impl LanguageHighlighter for Lexer {
+    fn highlight(&self, code: &str) -> Lines {
+        let syntax = &self.syntax;
+        let mut kinds = vec![TokenKind::Normal; code.len()];
+        let mut pos = 0;
+
+        while pos < code.len() {
+            let rest = &code[pos..];
+            let c = rest.chars().next().unwrap_or_default();
+
+            // Block comments first, since Lua's `--[[` starts with `--`
+            let (kind, len) = if let Some((open, close)) = syntax
+                .block_comment
+                .filter(|(open, _)| rest.starts_with(open))
+            {
+                let len = rest[open.len()..]
+                    .find(close)
+                    .map_or(rest.len(), |end| open.len() + end + close.len());
+                (TokenKind::Comment, len)
+            } else if syntax.line_comments.iter().any(|m| rest.starts_with(m)) {
+                (TokenKind::Comment, rest.find('\n').unwrap_or(rest.len()))
+            } else if syntax.quotes.contains(&c) {
+                (TokenKind::String, string_len(rest, c))
+            } else if c.is_ascii_digit() {
+                let len = rest
+                    .find(|c: char| !(c.is_ascii_alphanumeric() || c == '_' || c == '.'))
+                    .unwrap_or(rest.len());
+                let number = &rest[..len];
+                let is_float = number.contains('.')
+                    || (!number.starts_with("0x") && number.contains(['e', 'E']));
+                let kind = if is_float {
+                    TokenKind::Float
+                } else {
+                    TokenKind::DecVal
+                };
+                (kind, len)
+            } else if c.is_alphabetic() || c == '_' {
+                let len = rest
+                    .find(|c: char| {
+                        !(c.is_alphanumeric() || c == '_' || syntax.name_chars.contains(&c))
+                    })
+                    .unwrap_or(rest.len());
+                let followed_by_paren = rest[len..].starts_with('(');
+                (self.classify(&rest[..len], followed_by_paren), len)
+            } else if OPERATOR_CHARS.contains(c) {
+                let len = rest
+                    .find(|c: char| !OPERATOR_CHARS.contains(c))
+                    .unwrap_or(rest.len());
+                (TokenKind::Operator, len)
+            } else {
+                (TokenKind::Normal, c.len_utf8())
+            };
+
+            kinds[pos..pos + len].fill(kind);
+            pos += len;
+        }
+
+        tokens_from_kinds(code, &kinds)
+    }
+}
+
+const OPERATOR_CHARS: &str = "+-*/%=<>!&|^~?@$";
+
+/// Byte length of the string starting at the opening `quote` of `text`,
+/// including the closing quote. Backslash escapes are skipped.
+fn string_len(text: &str, quote: char) -> usize {
+    let mut chars = text.char_indices().skip(1);
+    while let Some((i, c)) = chars.next() {
+        if c == '\\' {
+            chars.next();
+        } else if c == quote {
+            return i + c.len_utf8();
+        }
+    }
+    text.len()
+}
+
+const PYTHON: Syntax = Syntax {
+    keywords: &[
+        "and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del",
+        "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is",
+        "lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with",
+        "yield", "match", "case",
+    ],
+    types: &[
+        "int", "float", "str", "bool", "list", "dict", "set", "tuple", "bytes", "object",
+    ],
+    constants: &["True", "False", "None"],
+    builtins: &[
+        "print",
+        "len",
+        "range",
+        "open",
+        "enumerate",
+        "zip",
+        "map",
+        "filter",
+        "sorted",
+        "sum",
+        "min",
+        "max",
+        "abs",
+        "isinstance",
+        "super",
+        "self",
+    ],
+    line_comments: &["#"],
+    ..EMPTY
+};
+
+const R: Syntax = Syntax {
+    keywords: &[
+        "if", "else", "repeat", "while", "function", "for", "in", "next", "break", "return",
+    ],
+    constants: &[
+        "TRUE",
+        "FALSE",
+        "NULL",
+        "NA",
+        "NaN",
+        "Inf",
+        "NA_integer_",
+        "NA_real_",
+        "NA_character_",
+        "T",
+        "F",
+    ],
+    builtins: &[
+        "library",
+        "require",
+        "c",
+        "list",
+        "print",
+        "paste",
+        "paste0",
+        "cat",
+        "data.frame",
+        "matrix",
+        "vector",
+        "length",
+        "sum",
+        "mean",
+    ],
+    line_comments: &["#"],
+    name_chars: &['.'],
+    ..EMPTY
+};
+
+const JULIA: Syntax = Syntax {
+    keywords: &[
+        "function", "end", "if", "elseif", "else", "for", "while", "return", "begin", "let",
+        "local", "global", "const", "module", "using", "import", "export", "struct", "mutable",
+        "abstract", "type", "macro", "quote", "try", "catch", "finally", "do", "break", "continue",
+        "in",
+    ],
+    types: &[
+        "Int", "Int64", "Float64", "String", "Bool", "Vector", "Array", "Dict", "Any", "Nothing",
+    ],
+    constants: &["true", "false", "nothing", "missing", "NaN", "Inf"],
+    builtins: &["println", "print", "length", "map", "collect", "size"],
+    line_comments: &["#"],
+    block_comment: Some(("#=", "=#")),
+    quotes: &['"'],
+    ..EMPTY
+};
+
+const JAVASCRIPT: Syntax = Syntax {
+    keywords: &[
+        "async",
+        "await",
+        "break",
+        "case",
+        "catch",
+        "class",
+        "const",
+        "continue",
+        "default",
+        "delete",
+        "do",
+        "else",
+        "export",
+        "extends",
+        "finally",
+        "for",
+        "from",
+        "function",
+        "if",
+        "import",
+        "in",
+        "instanceof",
+        "interface",
+        "let",
+        "new",
+        "of",
+        "return",
+        "switch",
+        "throw",
+        "try",
+        "type",
+        "typeof",
+        "var",
+        "void",
+        "while",
+        "yield",
+    ],
+    types: &[
+        "number", "string", "boolean", "any", "unknown", "never", "object",
+    ],
+    constants: &[
+        "true",
+        "false",
+        "null",
+        "undefined",
+        "NaN",
+        "Infinity",
+        "this",
+    ],
+    builtins: &[
+        "console", "document", "window", "Math", "JSON", "Promise", "Array", "Object", "require",
+    ],
+    line_comments: &["//"],
+    block_comment: Some(("/*", "*/")),
+    quotes: &['"', '\'', '`'],
+    name_chars: &['$'],
+    ..EMPTY
+};
+
+const RUST: Syntax = Syntax {
+    keywords: &[
+        "as", "async", "await", "break", "const", "continue", "crate", "dyn", "else", "enum",
+        "extern", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut",
+        "pub", "ref", "return", "static", "struct", "super", "trait", "type", "unsafe", "use",
+        "where", "while",
+    ],
+    types: &[
+        "bool", "char", "str", "u8", "u16", "u32", "u64", "u128", "usize", "i8", "i16", "i32",
+        "i64", "i128", "isize", "f32", "f64", "String", "Vec", "Option", "Result", "Box", "Self",
+    ],
+    constants: &["true", "false", "None", "Some", "Ok", "Err", "self"],
+    line_comments: &["//"],
+    block_comment: Some(("/*", "*/")),
+    quotes: &['"'],
+    ..EMPTY
+};
+
+const BASH: Syntax = Syntax {
+    keywords: &[
+        "if", "then", "else", "elif", "fi", "case", "esac", "for", "while", "until", "do", "done",
+        "in", "function", "select", "return", "local", "export",
+    ],
+    builtins: &[
+        "echo", "cd", "printf", "read", "set", "unset", "source", "exit", "test", "eval", "exec",
+    ],
+    line_comments: &["#"],
+    ..EMPTY
+};
+
+const C: Syntax = Syntax {
+    keywords: &[
+        "break",
+        "case",
+        "class",
+        "const",
+        "continue",
+        "default",
+        "delete",
+        "do",
+        "else",
+        "enum",
+        "extern",
+        "for",
+        "goto",
+        "if",
+        "namespace",
+        "new",
+        "private",
+        "protected",
+        "public",
+        "return",
+        "sizeof",
+        "static",
+        "struct",
+        "switch",
+        "template",
+        "typedef",
+        "typename",
+        "union",
+        "using",
+        "virtual",
+        "volatile",
+        "while",
+    ],
+    types: &[
+        "void", "char", "short", "int", "long", "float", "double", "signed", "unsigned", "bool",
+        "size_t", "auto",
+    ],
+    constants: &["true", "false", "NULL", "nullptr", "this"],
+    line_comments: &["//"],
+    block_comment: Some(("/*", "*/")),
+    ..EMPTY
+};
+
+const GO: Syntax = Syntax {
+    keywords: &[
+        "break",
+        "case",
+        "chan",
+        "const",
+        "continue",
+        "default",
+        "defer",
+        "else",
+        "fallthrough",
+        "for",
+        "func",
+        "go",
+        "goto",
+        "if",
+        "import",
+        "interface",
+        "map",
+        "package",
+        "range",
+        "return",
+        "select",
+        "struct",
+        "switch",
+        "type",
+        "var",
+    ],
+    types: &[
+        "bool", "byte", "error", "float32", "float64", "int", "int32", "int64", "rune", "string",
+        "uint", "uint8", "uint32", "uint64",
+    ],
+    constants: &["true", "false", "nil", "iota"],
+    builtins: &[
+        "append", "cap", "len", "make", "new", "panic", "recover", "print", "println",
+    ],
+    line_comments: &["//"],
+    block_comment: Some(("/*", "*/")),
+    quotes: &['"', '\'', '`'],
+    ..EMPTY
+};
+
+const SQL: Syntax = Syntax {
+    keywords: &[
+        "select", "from", "where", "and", "or", "not", "insert", "into", "values", "update", "set",
+        "delete", "create", "table", "drop", "alter", "join", "left", "right", "inner", "outer",
+        "on", "group", "by", "order", "having", "limit", "as", "distinct", "union", "with", "case",
+        "when", "then", "else", "end", "is", "in", "like",
+    ],
+    types: &[
+        "int", "integer", "text", "varchar", "char", "date", "boolean", "float", "real",
+    ],
+    constants: &["null", "true", "false"],
+    builtins: &["count", "sum", "avg", "min", "max", "coalesce"],
+    line_comments: &["--"],
+    block_comment: Some(("/*", "*/")),
+    case_insensitive: true,
+    ..EMPTY
+};
+
+const LUA: Syntax = Syntax {
+    keywords: &[
+        "and", "break", "do", "else", "elseif", "end", "for", "function", "goto", "if", "in",
+        "local", "not", "or", "repeat", "return", "then", "until", "while",
+    ],
+    constants: &["true", "false", "nil"],
+    builtins: &[
+        "print", "pairs", "ipairs", "require", "type", "tostring", "tonumber",
+    ],
+    line_comments: &["--"],
+    block_comment: Some(("--[[", "]]")),
+    ..EMPTY
+};
+
+/// Register the built-in lexers.
+pub fn register_builtin_lexers(highlighter: &mut Highlighter) {
+    highlighter.register(&["python", "py", "python3"], Lexer::new(PYTHON));
+    highlighter.register(&["r"], Lexer::new(R));
+    highlighter.register(&["julia", "jl"], Lexer::new(JULIA));
+    highlighter.register(
+        &["javascript", "js", "typescript", "ts", "ojs"],
+        Lexer::new(JAVASCRIPT),
+    );
+    highlighter.register(&["rust", "rs"], Lexer::new(RUST));
+    highlighter.register(&["bash", "sh", "shell", "zsh"], Lexer::new(BASH));
+    highlighter.register(&["c", "cpp", "c++", "h"], Lexer::new(C));
+    highlighter.register(&["go"], Lexer::new(GO));
+    highlighter.register(&["sql"], Lexer::new(SQL));
+    highlighter.register(&["lua"], Lexer::new(LUA));
+}
@@ -0,0 +1,297 @@
+/*
+ * highlight/mod.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Syntax highlighting for code blocks.
+//!
+//! Code is split into lines of [`Token`]s. Token kinds follow Pandoc's
+//! (skylighting's) token types, so the HTML writer uses the same classes
+//! (`kw`, `st`, ...) and the LaTeX writer the same macros (`\KeywordTok`, ...)
+//! as Pandoc, and Pandoc's themes apply to the output.
+//!
+//! A [`Highlighter`] maps language names to a [`LanguageHighlighter`]:
+//!
+//! - [`grammar::GrammarHighlighter`] runs a tree-sitter grammar and its
+//!   highlights query. Markdown and qmd use the grammar of this repo; other
+//!   grammars can be registered by embedders.
+//! - [`lexer::Lexer`] is a small table-driven lexer, used for the common
+//!   languages that have no grammar here (Python, R, Julia, JavaScript, ...).
+//!
+//! Highlighting is on by default in the HTML and LaTeX writers and is set by
+//! the `highlight-style` metadata key: a built-in [`Theme`] name, a theme in
+//! Pandoc's JSON theme format, or `none` to turn it off.
+
+pub mod grammar;
+pub mod lexer;
+pub mod theme;
+
+pub use theme::{BUILTIN_THEMES, Color, Theme, TokenStyle};
+
+use crate::pandoc::Attr;
+use quarto_pandoc_types::ConfigValue;
+use std::collections::HashMap;
+use std::sync::{Arc, OnceLock};
+
+/// The kind of a highlighted token, named after Pandoc's token types.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum TokenKind {
+    Normal,
+    Keyword,
+    DataType,
+    DecVal,
+    Float,
+    String,
+    VerbatimString,
+    SpecialChar,
+    Comment,
+    Function,
+    Operator,
+    BuiltIn,
+    Constant,
+    Variable,
+    Attribute,
+    Other,
+}
+
+impl TokenKind {
+    /// Every token kind, in the order themes are written.
+    pub const ALL: &'static [TokenKind] = &[
+        TokenKind::Normal,
+        TokenKind::Keyword,
+        TokenKind::DataType,
+        TokenKind::DecVal,
+        TokenKind::Float,
+        TokenKind::String,
+        TokenKind::VerbatimString,
+        TokenKind::SpecialChar,
+        TokenKind::Comment,
+        TokenKind::Function,
+        TokenKind::Operator,
+        TokenKind::BuiltIn,
+        TokenKind::Constant,
+        TokenKind::Variable,
+        TokenKind::Attribute,
+        TokenKind::Other,
+    ];
+
+    /// The name of the token type in Pandoc themes, e.g. `Keyword`.
+    pub fn name(self) -> &'static str {
+        match self {
+            TokenKind::Normal => "Normal",
+            TokenKind::Keyword => "Keyword",
+            TokenKind::DataType => "DataType",
+            TokenKind::DecVal => "DecVal",
+            TokenKind::Float => "Float",
+            TokenKind::String => "String",
+            TokenKind::VerbatimString => "VerbatimString",
+            TokenKind::SpecialChar => "SpecialChar",
+            TokenKind::Comment => "Comment",
+            TokenKind::Function => "Function",
+            TokenKind::Operator => "Operator",
+            TokenKind::BuiltIn => "BuiltIn",
+            TokenKind::Constant => "Constant",
+            TokenKind::Variable => "Variable",
+            TokenKind::Attribute => "Attribute",
+            TokenKind::Other => "Other",
+        }
+    }
+
+    /// The HTML class of the token type, or `None` for normal text.
+    pub fn class(self) -> Option<&'static str> {
+        match self {
+            TokenKind::Normal => None,
+            TokenKind::Keyword => Some("kw"),
+            TokenKind::DataType => Some("dt"),
+            TokenKind::DecVal => Some("dv"),
+            TokenKind::Float => Some("fl"),
+            TokenKind::String => Some("st"),
+            TokenKind::VerbatimString => Some("vs"),
+            TokenKind::SpecialChar => Some("sc"),
+            TokenKind::Comment => Some("co"),
+            TokenKind::Function => Some("fu"),
+            TokenKind::Operator => Some("op"),
+            TokenKind::BuiltIn => Some("bu"),
+            TokenKind::Constant => Some("cn"),
+            TokenKind::Variable => Some("va"),
+            TokenKind::Attribute => Some("at"),
+            TokenKind::Other => Some("ot"),
+        }
+    }
+
+    /// The LaTeX macro of the token type, e.g. `KeywordTok`.
+    pub fn latex_macro(self) -> String {
+        format!("{}Tok", self.name())
+    }
+}
+
+/// A run of code of one token kind. Tokens never contain a newline.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Token {
+    pub kind: TokenKind,
+    pub text: String,
+}
+
+/// Highlighted code: one vector of tokens per source line.
+pub type Lines = Vec<Vec<Token>>;
+
+/// Highlights code in one language.
+pub trait LanguageHighlighter: Send + Sync {
+    fn highlight(&self, code: &str) -> Lines;
+}
+
+/// Language names and their highlighters.
+#[derive(Default)]
+pub struct Highlighter {
+    languages: HashMap<String, Arc<dyn LanguageHighlighter>>,
+}
+
+impl std::fmt::Debug for Highlighter {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let mut names: Vec<&String> = self.languages.keys().collect();
+        names.sort();
+        f.debug_struct("Highlighter")
+            .field("languages", &names)
+            .finish()
+    }
+}
+
+impl Highlighter {
+    /// A highlighter with no languages.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// A highlighter with the built-in grammars and lexers.
+    pub fn with_builtin_languages() -> Self {
+        let mut highlighter = Self::new();
+        lexer::register_builtin_lexers(&mut highlighter);
+        grammar::register_builtin_grammars(&mut highlighter);
+        highlighter
+    }
+
+    /// The shared highlighter with the built-in languages.
+    pub fn builtin() -> Arc<Highlighter> {
+        static BUILTIN: OnceLock<Arc<Highlighter>> = OnceLock::new();
+        BUILTIN
+            .get_or_init(|| Arc::new(Self::with_builtin_languages()))
+            .clone()
+    }
+
+    /// Register a highlighter under one or more language names. Names are
+    /// matched case-insensitively; a later registration replaces an earlier
+    /// one with the same name.
+    pub fn register(&mut self, names: &[&str], highlighter: impl LanguageHighlighter + 'static) {
+        let highlighter: Arc<dyn LanguageHighlighter> = Arc::new(highlighter);
+        for name in names {
+            self.languages
+                .insert(name.to_lowercase(), highlighter.clone());
+        }
+    }
+
+    /// Whether `language` can be highlighted.
+    pub fn supports(&self, language: &str) -> bool {
+        self.languages.contains_key(&language.to_lowercase())
+    }
+
+    /// Highlight `code` as `language`, or `None` for an unknown language.
+    pub fn highlight(&self, language: &str, code: &str) -> Option<Lines> {
+        self.languages
+            .get(&language.to_lowercase())
+            .map(|highlighter| highlighter.highlight(code))
+    }
+}
+
+/// Highlighting settings of a writer.
+#[derive(Debug, Clone)]
+pub struct HighlightConfig {
+    pub highlighter: Arc<Highlighter>,
+    pub theme: Theme,
+}
+
+impl Default for HighlightConfig {
+    fn default() -> Self {
+        Self {
+            highlighter: Highlighter::builtin(),
+            theme: Theme::default(),
+        }
+    }
+}
+
+impl HighlightConfig {
+    /// Read the highlighting settings of a document from `highlight-style`.
+    ///
+    /// Returns `None` when highlighting is turned off with `none`. A theme
+    /// name that isn't built in falls back to the default theme.
+    pub fn from_metadata(meta: &ConfigValue) -> Option<Self> {
+        let Some(style) = meta.get("highlight-style") else {
+            return Some(Self::default());
+        };
+        if style.is_map() {
+            return Some(Self {
+                theme: Theme::from_config(style),
+                ..Self::default()
+            });
+        }
+        match style.as_plain_text().as_deref() {
+            Some("none") => None,
+            _ if style.as_bool() == Some(false) => None,
+            Some(name) => Some(Self {
+                theme: Theme::builtin(name).unwrap_or_default(),
+                ..Self::default()
+            }),
+            None => Some(Self::default()),
+        }
+    }
+
+    /// Highlight a code block, or `None` if its language is unknown.
+    pub fn highlight_code_block(&self, attr: &Attr, code: &str) -> Option<(String, Lines)> {
+        let language = code_block_language(attr)?;
+        let lines = self.highlighter.highlight(&language, code)?;
+        Some((language, lines))
+    }
+}
+
+/// The language of a code block: its first class, without the braces of
+/// an executable cell (`{python}`).
+pub fn code_block_language(attr: &Attr) -> Option<String> {
+    let class = attr.1.first()?;
+    let language = class
+        .strip_prefix('{')
+        .and_then(|class| class.strip_suffix('}'))
+        .unwrap_or(class);
+    (!language.is_empty()).then(|| language.to_string())
+}
+
+/// Split code into lines of tokens, given the token kind of each byte.
+///
+/// Adjacent bytes of the same kind form one token. `kinds` must have one
+/// entry per byte of `code`; bytes inside a character share its kind.
+pub fn tokens_from_kinds(code: &str, kinds: &[TokenKind]) -> Lines {
+    let mut lines = Vec::new();
+    for (start, line) in line_ranges(code) {
+        let mut tokens: Vec<Token> = Vec::new();
+        for (offset, c) in line.char_indices() {
+            let kind = kinds[start + offset];
+            match tokens.last_mut() {
+                Some(token) if token.kind == kind => token.text.push(c),
+                _ => tokens.push(Token {
+                    kind,
+                    text: c.to_string(),
+                }),
+            }
+        }
+        lines.push(tokens);
+    }
+    lines
+}
+
+/// The lines of `code` with their byte offsets, without line endings.
+fn line_ranges(code: &str) -> impl Iterator<Item = (usize, &str)> {
+    let mut start = 0;
+    code.split('\n').map(move |line| {
+        let range = (start, line.strip_suffix('\r').unwrap_or(line));
+        start += line.len() + 1;
+        range
+    })
+}
@@ -0,0 +1,335 @@
+/*
+ * highlight/theme.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Highlighting themes and their CSS and LaTeX forms.
+//!
+//! The built-in themes are Pandoc's `pygments` (the default), `tango` and
+//! `monochrome`. Other themes can be given in Pandoc's JSON theme format
+//! (the format of `pandoc --print-highlight-style`), read from metadata.
+
+use super::TokenKind;
+use quarto_pandoc_types::ConfigValue;
+use std::collections::HashMap;
+
+/// Names of the built-in themes.
+pub const BUILTIN_THEMES: &[&str] = &["pygments", "tango", "monochrome"];
+
+/// An RGB color.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Color(pub u8, pub u8, pub u8);
+
+impl Color {
+    /// Parse a `#rrggbb` color.
+    pub fn parse(text: &str) -> Option<Color> {
+        let hex = text.strip_prefix('#')?;
+        if hex.len() != 6 || !hex.is_ascii() {
+            return None;
+        }
+        let channel = |i: usize| u8::from_str_radix(&hex[i..i + 2], 16).ok();
+        Some(Color(channel(0)?, channel(2)?, channel(4)?))
+    }
+
+    /// The color as CSS, e.g. `#007020`.
+    pub fn css(self) -> String {
+        format!("#{:02x}{:02x}{:02x}", self.0, self.1, self.2)
+    }
+
+    /// The color as an `xcolor` `rgb` value, e.g. `0.00,0.44,0.13`.
+    fn latex_rgb(self) -> String {
+        format!(
+            "{:.2},{:.2},{:.2}",
+            self.0 as f64 / 255.0,
+            self.1 as f64 / 255.0,
+            self.2 as f64 / 255.0
+        )
+    }
+}
+
+/// How one token kind is drawn.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct TokenStyle {
+    pub color: Option<Color>,
+    pub background: Option<Color>,
+    pub bold: bool,
+    pub italic: bool,
+    pub underline: bool,
+}
+
+/// A highlighting theme.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Theme {
+    pub text_color: Option<Color>,
+    pub background_color: Option<Color>,
+    pub styles: HashMap<TokenKind, TokenStyle>,
+}
+
+impl Theme {
+    /// A built-in theme by name.
+    pub fn builtin(name: &str) -> Option<Theme> {
+        match name {
+            "pygments" => Some(Theme::default()),
+            "tango" => Some(tango()),
+            "monochrome" => Some(monochrome()),
+            _ => None,
+        }
+    }
+
+    /// Read a theme in Pandoc's JSON theme format:
+    ///
+    /// ```yaml
+    /// text-color: null
+    /// background-color: "#f8f8f8"
+    /// text-styles:
+    ///   Keyword:
+    ///     text-color: "#204a87"
+    ///     bold: true
+    /// ```
+    ///
+    /// Unknown token types and malformed colors are ignored.
+    pub fn from_config(config: &ConfigValue) -> Theme {
+        let color = |value: &ConfigValue, key: &str| {
+            value
+                .get(key)
+                .and_then(|c| c.as_plain_text())
+                .and_then(|c| Color::parse(&c))
+        };
+        let flag = |value: &ConfigValue, key: &str| {
+            value.get(key).and_then(|f| f.as_bool()).unwrap_or(false)
+        };
+
+        let mut styles = HashMap::new();
+        if let Some(entries) = config.get("text-styles").and_then(|s| s.as_map_entries()) {
+            for entry in entries {
+                let Some(kind) = TokenKind::ALL.iter().find(|k| k.name() == entry.key) else {
+                    continue;
+                };
+                styles.insert(
+                    *kind,
+                    TokenStyle {
+                        color: color(&entry.value, "text-color"),
+                        background: color(&entry.value, "background-color"),
+                        bold: flag(&entry.value, "bold"),
+                        italic: flag(&entry.value, "italic"),
+                        underline: flag(&entry.value, "underline"),
+                    },
+                );
+            }
+        }
+
+        Theme {
+            text_color: color(config, "text-color"),
+            background_color: color(config, "background-color"),
+            styles,
+        }
+    }
+
+    /// The style of a token kind.
+    pub fn style(&self, kind: TokenKind) -> TokenStyle {
+        self.styles.get(&kind).copied().unwrap_or_default()
+    }
+
+    /// CSS for the HTML writer's highlighted code blocks.
+    pub fn css(&self) -> String {
+        let mut css = String::from(BASE_CSS);
+        let mut block = Vec::new();
+        if let Some(color) = self.text_color {
+            block.push(format!("color: {};", color.css()));
+        }
+        if let Some(color) = self.background_color {
+            block.push(format!("background-color: {};", color.css()));
+        }
+        if !block.is_empty() {
+            css.push_str(&format!("div.sourceCode {{ {} }}\n", block.join(" ")));
+        }
+        for kind in TokenKind::ALL {
+            let Some(class) = kind.class() else {
+                continue;
+            };
+            let style = self.style(*kind);
+            let mut rules = Vec::new();
+            if let Some(color) = style.color {
+                rules.push(format!("color: {};", color.css()));
+            }
+            if let Some(color) = style.background {
+                rules.push(format!("background-color: {};", color.css()));
+            }
+            if style.bold {
+                rules.push("font-weight: bold;".to_string());
+            }
+            if style.italic {
+                rules.push("font-style: italic;".to_string());
+            }
+            if style.underline {
+                rules.push("text-decoration: underline;".to_string());
+            }
+            if !rules.is_empty() {
+                css.push_str(&format!(
+                    "code span.{} {{ {} }} /* {} */\n",
+                    class,
+                    rules.join(" "),
+                    kind.name()
+                ));
+            }
+        }
+        css
+    }
+
+    /// LaTeX definitions of the `Shaded` and `Highlighting` environments and
+    /// the token macros used by the LaTeX writer's highlighted code blocks.
+    pub fn latex_macros(&self) -> String {
+        let mut macros = String::from(BASE_LATEX);
+        match self.background_color {
+            Some(color) => {
+                macros.push_str("\\usepackage{framed}\n");
+                macros.push_str(&format!(
+                    "\\definecolor{{shadecolor}}{{RGB}}{{{},{},{}}}\n",
+                    color.0, color.1, color.2
+                ));
+                macros.push_str("\\newenvironment{Shaded}{\\begin{snugshade}}{\\end{snugshade}}\n");
+            }
+            None => macros.push_str("\\newenvironment{Shaded}{}{}\n"),
+        }
+        for kind in TokenKind::ALL {
+            let style = if *kind == TokenKind::Normal {
+                TokenStyle {
+                    color: self.text_color,
+                    ..self.style(*kind)
+                }
+            } else {
+                self.style(*kind)
+            };
+            let mut body = "#1".to_string();
+            if style.underline {
+                body = format!("\\underline{{{}}}", body);
+            }
+            if style.italic {
+                body = format!("\\textit{{{}}}", body);
+            }
+            if style.bold {
+                body = format!("\\textbf{{{}}}", body);
+            }
+            if let Some(color) = style.background {
+                body = format!("\\colorbox[rgb]{{{}}}{{{}}}", color.latex_rgb(), body);
+            }
+            if let Some(color) = style.color {
+                body = format!("\\textcolor[rgb]{{{}}}{{{}}}", color.latex_rgb(), body);
+            }
+            macros.push_str(&format!(
+                "\\newcommand{{\\{}}}[1]{{{}}}\n",
+                kind.latex_macro(),
+                body
+            ));
+        }
+        macros
+    }
+}
+
+const BASE_CSS: &str = "pre > code.sourceCode { white-space: pre; position: relative; }
+pre > code.sourceCode > span { line-height: 1.25; }
+pre > code.sourceCode > span:empty { height: 1.2em; }
+.sourceCode { overflow: visible; }
+code.sourceCode > span { color: inherit; text-decoration: inherit; }
+div.sourceCode { margin: 1em 0; }
+pre.sourceCode { margin: 0; }
+@media screen {
+div.sourceCode { overflow: auto; }
+}
+@media print {
+pre > code.sourceCode { white-space: pre-wrap; }
+}
+";
+
+const BASE_LATEX: &str = "\\usepackage{xcolor}
+\\usepackage{fancyvrb}
+\\newcommand{\\VerbBar}{|}
+\\newcommand{\\VERB}{\\Verb[commandchars=\\\\\\{\\}]}
+\\DefineVerbatimEnvironment{Highlighting}{Verbatim}{commandchars=\\\\\\{\\}}
+";
+
+fn style(color: Option<&str>, bold: bool, italic: bool) -> TokenStyle {
+    TokenStyle {
+        color: color.and_then(Color::parse),
+        bold,
+        italic,
+        ..TokenStyle::default()
+    }
+}
+
+/// Pandoc's `pygments` theme, the default.
+impl Default for Theme {
+    fn default() -> Self {
+        Theme {
+            text_color: None,
+            background_color: None,
+            styles: HashMap::from([
+                (TokenKind::Keyword, style(Some("#007020"), true, false)),
+                (TokenKind::DataType, style(Some("#902000"), false, false)),
+                (TokenKind::DecVal, style(Some("#40a070"), false, false)),
+                (TokenKind::Float, style(Some("#40a070"), false, false)),
+                (TokenKind::String, style(Some("#4070a0"), false, false)),
+                (
+                    TokenKind::VerbatimString,
+                    style(Some("#4070a0"), false, false),
+                ),
+                (TokenKind::SpecialChar, style(Some("#4070a0"), false, false)),
+                (TokenKind::Comment, style(Some("#60a0b0"), false, true)),
+                (TokenKind::Function, style(Some("#06287e"), false, false)),
+                (TokenKind::Operator, style(Some("#666666"), false, false)),
+                (TokenKind::BuiltIn, style(Some("#008000"), false, false)),
+                (TokenKind::Constant, style(Some("#880000"), false, false)),
+                (TokenKind::Variable, style(Some("#19177c"), false, false)),
+                (TokenKind::Attribute, style(Some("#7d9029"), false, false)),
+                (TokenKind::Other, style(Some("#007020"), false, false)),
+            ]),
+        }
+    }
+}
+
+/// Pandoc's `tango` theme.
+fn tango() -> Theme {
+    Theme {
+        text_color: None,
+        background_color: Color::parse("#f8f8f8"),
+        styles: HashMap::from([
+            (TokenKind::Keyword, style(Some("#204a87"), true, false)),
+            (TokenKind::DataType, style(Some("#204a87"), false, false)),
+            (TokenKind::DecVal, style(Some("#0000cf"), false, false)),
+            (TokenKind::Float, style(Some("#0000cf"), false, false)),
+            (TokenKind::String, style(Some("#4e9a06"), false, false)),
+            (
+                TokenKind::VerbatimString,
+                style(Some("#4e9a06"), false, false),
+            ),
+            (TokenKind::SpecialChar, style(Some("#ce5c00"), true, false)),
+            (TokenKind::Comment, style(Some("#8f5902"), false, true)),
+            (TokenKind::Function, style(Some("#204a87"), true, false)),
+            (TokenKind::Operator, style(Some("#ce5c00"), true, false)),
+            (TokenKind::BuiltIn, style(Some("#204a87"), false, false)),
+            (TokenKind::Constant, style(Some("#8f5902"), false, false)),
+            (TokenKind::Variable, style(Some("#000000"), false, false)),
+            (TokenKind::Attribute, style(Some("#c4a000"), false, false)),
+            (TokenKind::Other, style(Some("#8f5902"), false, false)),
+        ]),
+    }
+}
+
+/// Pandoc's `monochrome` theme.
+fn monochrome() -> Theme {
+    Theme {
+        text_color: None,
+        background_color: None,
+        styles: HashMap::from([
+            (TokenKind::Keyword, style(None, true, false)),
+            (
+                TokenKind::DataType,
+                TokenStyle {
+                    underline: true,
+                    ..TokenStyle::default()
+                },
+            ),
+            (TokenKind::Comment, style(None, false, true)),
+        ]),
+    }
+}
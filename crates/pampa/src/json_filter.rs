@@ -76,11 +76,65 @@ impl std::fmt::Display for JsonFilterError {
 
 impl std::error::Error for JsonFilterError {}
 
+/// Interpreters used to run filters that are not executable, by file extension.
+/// These follow pandoc, except that Python filters run with `python3` outside
+/// Windows, where a bare `python` is often missing or Python 2.
+const FILTER_INTERPRETERS: &[(&str, &str)] = &[
+    #[cfg(windows)]
+    ("py", "python"),
+    #[cfg(not(windows))]
+    ("py", "python3"),
+    ("hs", "runhaskell"),
+    ("pl", "perl"),
+    ("rb", "ruby"),
+    ("php", "php"),
+    ("js", "node"),
+    ("r", "Rscript"),
+];
+
+/// Build the command that runs the filter at `filter_path`.
+///
+/// Like pandoc, an executable filter is run directly, while a filter that is
+/// not executable is run with an interpreter chosen by its extension, so that
+/// `--filter my-filter.py` works without `chmod +x`.
+fn filter_command(filter_path: &Path) -> Command {
+    if !is_executable(filter_path) {
+        let extension = filter_path
+            .extension()
+            .and_then(|e| e.to_str())
+            .map(|e| e.to_ascii_lowercase());
+        if let Some(extension) = extension
+            && let Some((_, interpreter)) = FILTER_INTERPRETERS
+                .iter()
+                .find(|(ext, _)| *ext == extension)
+        {
+            let mut command = Command::new(interpreter);
+            command.arg(filter_path);
+            return command;
+        }
+    }
+    Command::new(filter_path)
+}
+
+#[cfg(unix)]
+fn is_executable(path: &Path) -> bool {
+    use std::os::unix::fs::PermissionsExt;
+    std::fs::metadata(path).is_ok_and(|m| m.permissions().mode() & 0o111 != 0)
+}
+
+#[cfg(not(unix))]
+fn is_executable(path: &Path) -> bool {
+    path.extension()
+        .and_then(|e| e.to_str())
+        .is_some_and(|e| e.eq_ignore_ascii_case("exe"))
+}
+
 /// Apply a JSON filter to a Pandoc document.
 ///
 /// The filter receives the document as JSON on stdin and produces the modified
 /// document as JSON on stdout. The filter is invoked as a subprocess with the
-/// target format as the first argument.
+/// target format as the first argument; see [`filter_command`] for how
+/// non-executable scripts are run.
 ///
 /// # Arguments
 ///
@@ -106,6 +160,7 @@ pub fn apply_json_filter(
     let mut json_buf = Vec::new();
     let json_config = writers::json::JsonConfig {
         include_inline_locations: true,
+        include_block_ids: false,
     };
     writers::json::write_with_config(pandoc, context, &mut json_buf, &json_config).map_err(
         |diags| {
@@ -115,7 +170,7 @@ pub fn apply_json_filter(
     )?;
 
     // 2. Spawn the filter subprocess
-    let mut child = Command::new(filter_path)
+    let mut child = filter_command(filter_path)
         .arg(target_format)
         .stdin(Stdio::piped())
         .stdout(Stdio::piped())
@@ -291,6 +346,46 @@ sys.exit(1)
         }
     }
 
+    #[test]
+    fn test_filter_command_uses_interpreter_for_scripts() {
+        let dir = TempDir::new().unwrap();
+        let script = dir.path().join("filter.PY");
+        fs::write(&script, "").unwrap();
+
+        let command = filter_command(&script);
+        let expected = if cfg!(windows) { "python" } else { "python3" };
+        assert_eq!(command.get_program(), expected);
+        assert_eq!(
+            command.get_args().collect::<Vec<_>>(),
+            vec![script.as_os_str()]
+        );
+
+        // Executable filters and unknown extensions run directly.
+        let filter_path = create_identity_filter(&dir);
+        assert_eq!(
+            filter_command(&filter_path).get_program(),
+            filter_path.as_os_str()
+        );
+        let unknown = dir.path().join("filter.xyz");
+        assert_eq!(filter_command(&unknown).get_program(), unknown.as_os_str());
+    }
+
+    #[test]
+    fn test_non_executable_python_filter() {
+        let dir = TempDir::new().unwrap();
+        let filter_path = create_identity_filter(&dir);
+        let mut perms = fs::metadata(&filter_path).unwrap().permissions();
+        perms.set_mode(0o644);
+        fs::set_permissions(&filter_path, perms).unwrap();
+
+        let pandoc = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks: vec![],
+        };
+        let result = apply_json_filter(&pandoc, &ASTContext::new(), &filter_path, "html");
+        assert!(result.is_ok(), "{:?}", result.err());
+    }
+
     #[test]
     fn test_uppercase_filter() {
         let dir = TempDir::new().unwrap();
@@ -7,16 +7,25 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
+pub mod ast_diff;
+pub mod ast_query;
+pub mod cell_options;
 pub mod errors;
+pub mod extensions;
 pub mod filter_context;
 pub mod filters;
+pub mod highlight;
 #[cfg(feature = "lua-filter")]
 pub mod lua;
+pub mod metadata;
 pub mod options;
 pub mod pandoc;
+pub mod plugins;
 pub mod readers;
+pub mod rmarkdown;
 pub mod template;
 pub mod toc;
+pub mod trace;
 pub mod transforms;
 pub mod traversals;
 pub mod utils;
@@ -0,0 +1,94 @@
+/*
+ * lint.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa lint`: check qmd files without rendering them.
+//!
+//! A file is read, and what the reader warns about is reported together
+//! with the problems of the `#|` options of its executable code blocks
+//! (see [`crate::cell_options`]): options Quarto doesn't know, values of
+//! the wrong type and option lines that aren't YAML, each located in the
+//! file. Nothing is run. With `--diagnostics`, the report is one JSON
+//! array, for editors and CI.
+
+use super::{Args, ConversionFailed, Messages};
+use crate::{cell_options, readers};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+
+/// Check the files (or stdin) and return the exit code: 0 when nothing was
+/// found, 1 when something was, 2 when a file can't be read
+pub fn run(args: &Args, files: &[PathBuf]) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: args.diagnostics.then(Vec::new),
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    let mut failed = false;
+    let mut found = 0;
+    if files.is_empty() {
+        let mut input = String::new();
+        match std::io::stdin().read_to_string(&mut input) {
+            Ok(_) => found += lint(args, "<stdin>", &input, &mut messages),
+            Err(e) => {
+                messages.error(format_args!("Failed to read from stdin: {}", e));
+                failed = true;
+            }
+        }
+    }
+    for path in files {
+        match lint_file(args, path, &mut messages) {
+            Ok(count) => found += count,
+            Err(ConversionFailed) => failed = true,
+        }
+    }
+    messages.write_collected();
+    if failed {
+        2
+    } else if found > 0 {
+        1
+    } else {
+        0
+    }
+}
+
+fn lint_file(args: &Args, path: &Path, messages: &mut Messages) -> Result<usize, ConversionFailed> {
+    let filename = path.to_string_lossy();
+    let input = std::fs::read_to_string(path).map_err(|e| {
+        messages.error(format_args!("Failed to read '{}': {}", filename, e));
+        ConversionFailed
+    })?;
+    Ok(lint(args, &filename, &input, messages))
+}
+
+/// Report what is wrong with a document. Returns how many problems were
+/// reported.
+fn lint(args: &Args, filename: &str, input: &str, messages: &mut Messages) -> usize {
+    let result = readers::qmd::read(
+        input.as_bytes(),
+        args.loose,
+        filename,
+        &mut std::io::sink(),
+        true,
+        None,
+    );
+    match result {
+        Ok((pandoc, context, warnings)) => {
+            let diagnostics = cell_options::validate_document(&pandoc, &context.source_context);
+            messages.report_all(&warnings, &context.source_context);
+            messages.report_all(&diagnostics, &context.source_context);
+            warnings.len() + diagnostics.len()
+        }
+        Err(diagnostics) => {
+            let mut source_context = quarto_source_map::SourceContext::new();
+            source_context.add_file(filename.to_string(), Some(input.to_string()));
+            messages.report_all(&diagnostics, &source_context);
+            diagnostics.len().max(1)
+        }
+    }
+}
@@ -0,0 +1,143 @@
+/*
+ * logging.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Log events of a conversion on stderr.
+//!
+//! pampa reports what it decides along the way as `tracing` events: the
+//! extensions a reader or writer ends up with (debug), the time each phase
+//! took (with `--timing`, on the `pampa::timing` target) and content a
+//! writer had to drop (warn). Nothing is logged unless asked for:
+//!
+//! - `PAMPA_LOG` filters events with `tracing` directives, e.g.
+//!   `PAMPA_LOG=pampa::writers=debug`
+//! - `--verbose` logs all debug events
+//! - `--log-format` alone logs info events
+//! - `--timing` adds the timing events to any of these
+//!
+//! With `--log-format json`, each event is one line of JSON with its
+//! `level`, `target`, `message` and fields, so logs can be read by tools.
+
+use std::io::Write;
+
+use serde_json::{Map, Value};
+use tracing::field::{Field, Visit};
+use tracing::{Event, Subscriber};
+use tracing_subscriber::EnvFilter;
+use tracing_subscriber::fmt::MakeWriter;
+use tracing_subscriber::layer::{Context, Layer, SubscriberExt};
+use tracing_subscriber::util::SubscriberInitExt;
+
+/// The environment variable with the filter directives
+pub const LOG_ENV: &str = "PAMPA_LOG";
+
+/// The target of the timing events of [`crate::trace::Tracer`]
+pub const TIMING_TARGET: &str = "pampa::timing";
+
+/// Install the subscriber for the process. `format` is the value of
+/// `--log-format`, `plain` when not given.
+pub fn init(format: Option<&str>, verbose: bool, timing: bool) {
+    let env = std::env::var(LOG_ENV).ok();
+    let filter = EnvFilter::builder().parse_lossy(directives(
+        env.as_deref(),
+        verbose,
+        format.is_some(),
+        timing,
+    ));
+    let registry = tracing_subscriber::registry().with(filter);
+    if format == Some("json") {
+        registry.with(JsonLayer::new(std::io::stderr)).init();
+    } else {
+        registry
+            .with(
+                tracing_subscriber::fmt::layer()
+                    .with_writer(std::io::stderr)
+                    .without_time(),
+            )
+            .init();
+    }
+}
+
+/// The filter directives for the options, `env` taking the place of the
+/// defaults when set
+fn directives(env: Option<&str>, verbose: bool, explicit_format: bool, timing: bool) -> String {
+    let mut directives = match env {
+        Some(env) if !env.trim().is_empty() => env.to_string(),
+        _ if verbose => "pampa=debug".to_string(),
+        _ if explicit_format => "pampa=info".to_string(),
+        _ => "off".to_string(),
+    };
+    if timing {
+        directives.push_str(&format!(",{}=info", TIMING_TARGET));
+    }
+    directives
+}
+
+/// Writes each event as a line of JSON
+pub struct JsonLayer<W> {
+    make_writer: W,
+}
+
+impl<W> JsonLayer<W>
+where
+    W: for<'a> MakeWriter<'a> + 'static,
+{
+    pub fn new(make_writer: W) -> Self {
+        JsonLayer { make_writer }
+    }
+}
+
+impl<S, W> Layer<S> for JsonLayer<W>
+where
+    S: Subscriber,
+    W: for<'a> MakeWriter<'a> + 'static,
+{
+    fn on_event(&self, event: &Event<'_>, _ctx: Context<'_, S>) {
+        let metadata = event.metadata();
+        let mut line = Map::new();
+        line.insert(
+            "level".to_string(),
+            Value::from(metadata.level().as_str().to_ascii_lowercase()),
+        );
+        line.insert("target".to_string(), Value::from(metadata.target()));
+        event.record(&mut JsonFields(&mut line));
+
+        let mut json = Value::Object(line).to_string();
+        json.push('\n');
+        // A log line that can't be written is not worth failing over
+        let _ = self.make_writer.make_writer().write_all(json.as_bytes());
+    }
+}
+
+/// Collects the fields of an event into a JSON object
+struct JsonFields<'a>(&'a mut Map<String, Value>);
+
+impl Visit for JsonFields<'_> {
+    fn record_debug(&mut self, field: &Field, value: &dyn std::fmt::Debug) {
+        self.0.insert(
+            field.name().to_string(),
+            Value::from(format!("{:?}", value)),
+        );
+    }
+
+    fn record_str(&mut self, field: &Field, value: &str) {
+        self.0.insert(field.name().to_string(), Value::from(value));
+    }
+
+    fn record_bool(&mut self, field: &Field, value: bool) {
+        self.0.insert(field.name().to_string(), Value::from(value));
+    }
+
+    fn record_i64(&mut self, field: &Field, value: i64) {
+        self.0.insert(field.name().to_string(), Value::from(value));
+    }
+
+    fn record_u64(&mut self, field: &Field, value: u64) {
+        self.0.insert(field.name().to_string(), Value::from(value));
+    }
+
+    fn record_f64(&mut self, field: &Field, value: f64) {
+        self.0.insert(field.name().to_string(), Value::from(value));
+    }
+}
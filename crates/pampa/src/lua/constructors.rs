@@ -34,6 +34,7 @@ use super::list::{
 };
 use super::types::{
     LuaAttr, LuaBlock, LuaInline, filter_source_info, lua_table_to_blocks, lua_table_to_inlines,
+    sorted_pairs,
 };
 use mlua::UserData;
 
@@ -517,6 +518,7 @@ fn register_inline_constructors(lua: &Lua, pandoc: &LuaTable) -> Result<()> {
         lua.create_function(|lua, content: Value| {
             let blocks = lua_table_to_blocks(lua, content)?;
             lua.create_userdata(LuaInline(Inline::Note(Note {
+                id: None,
                 content: blocks,
                 source_info: filter_source_info(lua),
             })))
@@ -777,7 +779,12 @@ fn parse_attr(_lua: &Lua, attr: Option<Value>) -> Result<crate::pandoc::Attr> {
                 .unwrap_or_default();
             let attributes: LinkedHashMap<String, String> = table
                 .get::<Option<LuaTable>>("attributes")?
-                .map(|t| t.pairs::<String, String>().filter_map(|r| r.ok()).collect())
+                .map(|t| {
+                    let mut pairs: Vec<(String, String)> =
+                        t.pairs().filter_map(|r| r.ok()).collect();
+                    pairs.sort_by(|(a, _), (b, _)| a.cmp(b));
+                    pairs.into_iter().collect()
+                })
                 .unwrap_or_default();
             Ok((identifier, classes, attributes))
         }
@@ -1283,12 +1290,7 @@ fn register_attr_constructor(lua: &Lua, pandoc: &LuaTable) -> Result<()> {
                 };
                 let attrs = match attributes {
                     Some(Value::Table(table)) => {
-                        let mut result = LinkedHashMap::new();
-                        for pair in table.pairs::<String, String>() {
-                            let (k, v) = pair?;
-                            result.insert(k, v);
-                        }
-                        result
+                        sorted_pairs::<String>(&table)?.into_iter().collect()
                     }
                     Some(_) => return Err(Error::runtime("attributes must be a table")),
                     None => LinkedHashMap::new(),
@@ -1161,6 +1161,7 @@ mod tests {
             end_offset: 190,
         };
         let note = Inline::Note(Note {
+            id: None,
             content: vec![],
             source_info: source_info.clone(),
         });
@@ -19,7 +19,10 @@ use crate::pandoc::{Block, Inline, Pandoc};
 
 use super::constructors::register_pandoc_namespace;
 use super::mediabag::create_shared_mediabag;
-use super::readwrite::{create_reader_options_table, create_writer_options_table};
+use super::readwrite::{
+    config_value_to_lua, create_reader_options_table, create_writer_options_table,
+    lua_pandoc_to_rust, lua_to_config_value, rust_pandoc_to_lua_table,
+};
 use super::runtime::{NativeRuntime, SystemRuntime};
 use super::types::{LuaBlock, LuaInline, blocks_to_lua_table, inlines_to_lua_table};
 
@@ -155,32 +158,22 @@ pub fn apply_lua_filter(
     let writer_options = create_writer_options_table(&lua, None)?;
     lua.globals().set("PANDOC_WRITER_OPTIONS", writer_options)?;
 
-    // Load and execute filter script
-    lua.load(&filter_source)
+    // Load and execute filter script. The script may define its filter
+    // functions as globals or return them (see get_filter_tables).
+    let returned: Value = lua
+        .load(&filter_source)
         .set_name(filter_path.to_string_lossy())
-        .exec()?;
+        .eval()?;
 
-    // Get filter functions from globals or return value
-    let filter_table = get_filter_table(&lua)?;
-
-    // Determine traversal mode
-    let walking_order = get_walking_order(&filter_table)?;
-
-    // Apply the filter using the appropriate traversal
-    let filtered_blocks = match walking_order {
-        WalkingOrder::Typewise => apply_typewise_filter(&lua, &filter_table, &pandoc.blocks)?,
-        WalkingOrder::Topdown => apply_topdown_filter(&lua, &filter_table, &pandoc.blocks)?,
-    };
+    let mut filtered_pandoc = pandoc.clone();
+    for filter_table in get_filter_tables(&lua, returned)? {
+        filtered_pandoc = apply_filter_table(&lua, &filter_table, filtered_pandoc)?;
+    }
 
     // Extract any diagnostics emitted by the filter
     let diagnostics = super::diagnostics::extract_lua_diagnostics(&lua)?;
 
     // Return filtered document with diagnostics
-    let filtered_pandoc = Pandoc {
-        meta: pandoc.meta.clone(),
-        blocks: filtered_blocks,
-    };
-
     Ok((filtered_pandoc, context.clone(), diagnostics))
 }
 
@@ -212,7 +205,78 @@ pub fn apply_lua_filters(
     Ok((current_pandoc, current_context, all_diagnostics))
 }
 
-/// Get the filter table from Lua (either from return value or globals)
+/// Apply a single filter table to a document.
+///
+/// As in Pandoc, typewise traversal visits the elements first and then the
+/// document's `Meta` and the `Pandoc` document itself, while topdown traversal
+/// visits them in the reverse order. `Meta` and `Pandoc` filters see the
+/// metadata without source information, so the document is only converted
+/// for them when the filter defines them.
+fn apply_filter_table(lua: &Lua, filter_table: &Table, pandoc: Pandoc) -> Result<Pandoc> {
+    match get_walking_order(filter_table)? {
+        WalkingOrder::Typewise => {
+            let blocks = apply_typewise_filter(lua, filter_table, &pandoc.blocks)?;
+            let pandoc = Pandoc { blocks, ..pandoc };
+            let pandoc = apply_meta_filter(lua, filter_table, pandoc)?;
+            apply_pandoc_filter(lua, filter_table, pandoc)
+        }
+        WalkingOrder::Topdown => {
+            let pandoc = apply_pandoc_filter(lua, filter_table, pandoc)?;
+            let pandoc = apply_meta_filter(lua, filter_table, pandoc)?;
+            let blocks = apply_topdown_filter(lua, filter_table, &pandoc.blocks)?;
+            Ok(Pandoc { blocks, ..pandoc })
+        }
+    }
+}
+
+/// Apply the filter's `Meta` function, if any, to the document metadata.
+fn apply_meta_filter(lua: &Lua, filter_table: &Table, mut pandoc: Pandoc) -> Result<Pandoc> {
+    if let Ok(func) = filter_table.get::<Function>("Meta") {
+        let ret: Value = func.call(config_value_to_lua(lua, &pandoc.meta)?)?;
+        if !ret.is_nil() {
+            pandoc.meta = lua_to_config_value(lua, ret)?;
+        }
+    }
+    Ok(pandoc)
+}
+
+/// Apply the filter's `Pandoc` (or legacy `Doc`) function, if any, to the
+/// whole document.
+fn apply_pandoc_filter(lua: &Lua, filter_table: &Table, pandoc: Pandoc) -> Result<Pandoc> {
+    let func = filter_table
+        .get::<Function>("Pandoc")
+        .or_else(|_| filter_table.get::<Function>("Doc"));
+    if let Ok(func) = func {
+        let ret: Value = func.call(rust_pandoc_to_lua_table(lua, &pandoc)?)?;
+        if !ret.is_nil() {
+            return lua_pandoc_to_rust(lua, ret);
+        }
+    }
+    Ok(pandoc)
+}
+
+/// Get the filters defined by a script.
+///
+/// Like Pandoc, a script can return a single filter table or a list of filter
+/// tables, which are applied in order. If it returns nothing, its global
+/// filter functions are used.
+fn get_filter_tables(lua: &Lua, returned: Value) -> Result<Vec<Table>> {
+    match returned {
+        Value::Table(table) => {
+            if let Ok(Value::Table(_)) = table.raw_get::<Value>(1) {
+                table.sequence_values::<Table>().collect()
+            } else {
+                Ok(vec![table])
+            }
+        }
+        Value::Nil => Ok(vec![get_filter_table(lua)?]),
+        _ => Err(mlua::Error::runtime(
+            "Lua filter must return a filter table, a list of filter tables, or nothing",
+        )),
+    }
+}
+
+/// Get the filter table from the filter functions defined as globals
 fn get_filter_table(lua: &Lua) -> Result<Table> {
     // Pandoc filters can either:
     // 1. Return a table with filter functions
@@ -274,6 +338,7 @@ fn get_filter_table(lua: &Lua) -> Result<Table> {
         "Block",
         "Blocks",
         // Document-level
+        "Meta",
         "Pandoc",
         "Doc",
     ];
@@ -2034,6 +2099,7 @@ mod unit_tests {
         );
         assert_eq!(
             inline_tag(&Inline::Note(Note {
+                id: None,
                 content: vec![],
                 source_info: source_info.clone()
             })),
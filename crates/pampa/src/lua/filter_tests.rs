@@ -2233,6 +2233,7 @@ end
         meta: quarto_pandoc_types::ConfigValue::default(),
         blocks: vec![Block::Paragraph(crate::pandoc::Paragraph {
             content: vec![Inline::Note(crate::pandoc::Note {
+                id: None,
                 content: vec![Block::Paragraph(crate::pandoc::Paragraph {
                     content: vec![Inline::Str(crate::pandoc::Str {
                         text: "footnote content".to_string(),
@@ -2991,6 +2992,7 @@ end
         meta: quarto_pandoc_types::ConfigValue::default(),
         blocks: vec![Block::Paragraph(crate::pandoc::Paragraph {
             content: vec![Inline::Note(crate::pandoc::Note {
+                id: None,
                 content: vec![Block::Paragraph(crate::pandoc::Paragraph {
                     content: vec![Inline::Str(crate::pandoc::Str {
                         text: "old_note".to_string(),
@@ -5461,3 +5463,173 @@ end
         _ => panic!("Expected two Paragraphs"),
     }
 }
+
+fn hello_document() -> Pandoc {
+    Pandoc {
+        meta: quarto_pandoc_types::ConfigValue::new_map(
+            vec![quarto_pandoc_types::ConfigMapEntry {
+                key: "title".to_string(),
+                key_source: quarto_source_map::SourceInfo::default(),
+                value: quarto_pandoc_types::ConfigValue::new_inlines(
+                    vec![Inline::Str(crate::pandoc::Str {
+                        text: "Title".to_string(),
+                        source_info: quarto_source_map::SourceInfo::default(),
+                    })],
+                    quarto_source_map::SourceInfo::default(),
+                ),
+            }],
+            quarto_source_map::SourceInfo::default(),
+        ),
+        blocks: vec![Block::Paragraph(crate::pandoc::Paragraph {
+            content: vec![Inline::Str(crate::pandoc::Str {
+                text: "Hello".to_string(),
+                source_info: quarto_source_map::SourceInfo::default(),
+            })],
+            source_info: quarto_source_map::SourceInfo::default(),
+        })],
+    }
+}
+
+fn paragraph_texts(pandoc: &Pandoc) -> Vec<String> {
+    pandoc
+        .blocks
+        .iter()
+        .map(|block| match block {
+            Block::Paragraph(p) => match &p.content[0] {
+                Inline::Str(s) => s.text.clone(),
+                _ => panic!("Expected Str inline"),
+            },
+            _ => panic!("Expected Paragraph block"),
+        })
+        .collect()
+}
+
+#[test]
+fn test_filter_returns_list_of_filters() {
+    let dir = TempDir::new().unwrap();
+    let filter_path = dir.path().join("returned.lua");
+    fs::write(
+        &filter_path,
+        r#"
+return {
+    {Str = function(s) return pandoc.Str(s.text .. "1") end},
+    {Str = function(s) return pandoc.Str(s.text .. "2") end},
+}
+"#,
+    )
+    .unwrap();
+
+    let (filtered, _, _) =
+        apply_lua_filter(&hello_document(), &ASTContext::new(), &filter_path, "html").unwrap();
+    assert_eq!(paragraph_texts(&filtered), vec!["Hello12"]);
+}
+
+#[test]
+fn test_filter_returns_single_filter() {
+    let dir = TempDir::new().unwrap();
+    let filter_path = dir.path().join("returned.lua");
+    fs::write(
+        &filter_path,
+        r#"
+return {Str = function(s) return pandoc.Str(s.text:upper()) end}
+"#,
+    )
+    .unwrap();
+
+    let (filtered, _, _) =
+        apply_lua_filter(&hello_document(), &ASTContext::new(), &filter_path, "html").unwrap();
+    assert_eq!(paragraph_texts(&filtered), vec!["HELLO"]);
+}
+
+#[test]
+fn test_meta_filter() {
+    let dir = TempDir::new().unwrap();
+    let filter_path = dir.path().join("meta.lua");
+    fs::write(
+        &filter_path,
+        r#"
+function Meta(meta)
+    meta.subtitle = "added"
+    return meta
+end
+"#,
+    )
+    .unwrap();
+
+    let (filtered, _, _) =
+        apply_lua_filter(&hello_document(), &ASTContext::new(), &filter_path, "html").unwrap();
+    assert_eq!(
+        filtered.meta.get("subtitle").and_then(|v| v.as_str()),
+        Some("added")
+    );
+    // Inline content survives the round trip through Lua
+    assert_eq!(
+        filtered.meta.get("title").and_then(|v| v.as_plain_text()),
+        Some("Title".to_string())
+    );
+}
+
+#[test]
+fn test_pandoc_filter() {
+    let dir = TempDir::new().unwrap();
+    let filter_path = dir.path().join("pandoc.lua");
+    fs::write(
+        &filter_path,
+        r#"
+function Str(s)
+    return pandoc.Str(s.text .. "!")
+end
+
+function Pandoc(doc)
+    local blocks = doc.blocks
+    blocks[#blocks + 1] = pandoc.Para({pandoc.Str("appended")})
+    return pandoc.Pandoc(blocks, doc.meta)
+end
+"#,
+    )
+    .unwrap();
+
+    let (filtered, _, _) =
+        apply_lua_filter(&hello_document(), &ASTContext::new(), &filter_path, "html").unwrap();
+    // Element filters run before the Pandoc filter in typewise traversal
+    assert_eq!(paragraph_texts(&filtered), vec!["Hello!", "appended"]);
+    assert!(filtered.meta.get("title").is_some());
+}
+
+#[test]
+fn test_maps_from_tables_are_sorted() {
+    let dir = TempDir::new().unwrap();
+    let filter_path = dir.path().join("tables.lua");
+    fs::write(
+        &filter_path,
+        r#"
+function Para(para)
+    local attr = pandoc.Attr("", {}, {zeta = "1", alpha = "2", mid = "3", beta = "4"})
+    return pandoc.Div({para}, attr)
+end
+
+function Meta(meta)
+    return {zeta = "1", alpha = "2", mid = "3", beta = "4"}
+end
+"#,
+    )
+    .unwrap();
+
+    let (filtered, _, _) =
+        apply_lua_filter(&hello_document(), &ASTContext::new(), &filter_path, "html").unwrap();
+    // Lua visits the keys of a table in no fixed order
+    let Block::Div(div) = &filtered.blocks[0] else {
+        panic!("Expected Div block");
+    };
+    assert_eq!(
+        div.attr.2.keys().collect::<Vec<_>>(),
+        ["alpha", "beta", "mid", "zeta"]
+    );
+    let quarto_pandoc_types::ConfigValueKind::Map(entries) = &filtered.meta.value else {
+        panic!("Expected metadata map");
+    };
+    assert_eq!(
+        entries.iter().map(|e| e.key.as_str()).collect::<Vec<_>>(),
+        ["alpha", "beta", "mid", "zeta"]
+    );
+}
@@ -25,6 +25,7 @@ use std::path::Path;
 use std::sync::Arc;
 
 use super::runtime::SystemRuntime;
+use crate::utils::mime::guess_mime_type;
 
 /// A single entry in the mediabag
 #[derive(Debug, Clone)]
@@ -399,67 +400,6 @@ pub fn register_pandoc_mediabag(
     Ok(())
 }
 
-/// Guess MIME type from file extension
-fn guess_mime_type(filepath: &str) -> String {
-    let path = Path::new(filepath);
-    let ext = path
-        .extension()
-        .and_then(|e| e.to_str())
-        .map(|e| e.to_lowercase());
-
-    match ext.as_deref() {
-        // Images
-        Some("png") => "image/png",
-        Some("jpg" | "jpeg") => "image/jpeg",
-        Some("gif") => "image/gif",
-        Some("svg") => "image/svg+xml",
-        Some("webp") => "image/webp",
-        Some("bmp") => "image/bmp",
-        Some("ico") => "image/x-icon",
-        Some("tiff" | "tif") => "image/tiff",
-
-        // Documents
-        Some("pdf") => "application/pdf",
-        Some("html" | "htm") => "text/html",
-        Some("css") => "text/css",
-        Some("js") => "application/javascript",
-        Some("json") => "application/json",
-        Some("xml") => "application/xml",
-        Some("txt") => "text/plain",
-        Some("md" | "markdown") => "text/markdown",
-        Some("tex") => "application/x-tex",
-        Some("csv") => "text/csv",
-
-        // Fonts
-        Some("woff") => "font/woff",
-        Some("woff2") => "font/woff2",
-        Some("ttf") => "font/ttf",
-        Some("otf") => "font/otf",
-        Some("eot") => "application/vnd.ms-fontobject",
-
-        // Audio
-        Some("mp3") => "audio/mpeg",
-        Some("wav") => "audio/wav",
-        Some("ogg") => "audio/ogg",
-        Some("flac") => "audio/flac",
-
-        // Video
-        Some("mp4") => "video/mp4",
-        Some("webm") => "video/webm",
-        Some("avi") => "video/x-msvideo",
-        Some("mov") => "video/quicktime",
-
-        // Archives
-        Some("zip") => "application/zip",
-        Some("tar") => "application/x-tar",
-        Some("gz") => "application/gzip",
-
-        // Default
-        _ => "application/octet-stream",
-    }
-    .to_string()
-}
-
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -19,7 +19,9 @@ use crate::options::{
 use crate::pandoc::Pandoc;
 use quarto_pandoc_types::{ConfigMapEntry, ConfigValue, ConfigValueKind};
 
-use super::types::{blocks_to_lua_table, lua_table_to_blocks, meta_value_to_lua};
+use super::types::{
+    blocks_to_lua_table, lua_table_to_blocks, lua_table_to_inlines, meta_value_to_lua, sorted_pairs,
+};
 
 /// Register pandoc.read, pandoc.write, and option constructors on the pandoc table.
 pub fn register_pandoc_readwrite(lua: &Lua, pandoc: &Table) -> Result<()> {
@@ -41,6 +43,18 @@ pub fn register_pandoc_readwrite(lua: &Lua, pandoc: &Table) -> Result<()> {
         lua.create_function(|lua, args: mlua::MultiValue| pandoc_write(lua, args))?,
     )?;
 
+    // Register pandoc.Pandoc(blocks, meta?)
+    pandoc.set(
+        "Pandoc",
+        lua.create_function(|lua, (blocks, meta): (Value, Option<Value>)| {
+            let doc = Pandoc {
+                blocks: lua_table_to_blocks(lua, blocks)?,
+                meta: lua_to_config_value(lua, meta.unwrap_or(Value::Nil))?,
+            };
+            rust_pandoc_to_lua_table(lua, &doc)
+        })?,
+    )?;
+
     Ok(())
 }
 
@@ -197,7 +211,7 @@ fn parse_format_spec_from_lua(_lua: &Lua, value: Value) -> Result<ParsedFormat>
 }
 
 /// Phase 5: Convert ConfigValue to a Lua table (loses source info).
-fn config_value_to_lua(lua: &Lua, config: &ConfigValue) -> Result<Value> {
+pub(super) fn config_value_to_lua(lua: &Lua, config: &ConfigValue) -> Result<Value> {
     match &config.value {
         ConfigValueKind::Scalar(yaml) => match yaml {
             yaml_rust2::Yaml::String(s) => Ok(Value::String(lua.create_string(s)?)),
@@ -240,7 +254,7 @@ fn config_value_to_lua(lua: &Lua, config: &ConfigValue) -> Result<Value> {
 }
 
 /// Phase 5: Convert a Lua table to ConfigValue.
-fn lua_to_config_value(lua: &Lua, val: Value) -> Result<ConfigValue> {
+pub(super) fn lua_to_config_value(lua: &Lua, val: Value) -> Result<ConfigValue> {
     use quarto_pandoc_types::MergeOp;
     use quarto_source_map::SourceInfo;
 
@@ -274,6 +288,35 @@ fn lua_to_config_value(lua: &Lua, val: Value) -> Result<ConfigValue> {
             merge_op,
         }),
         Value::Table(t) => {
+            // Tagged meta values, as produced by config_value_to_lua for inline
+            // and block content, or by filters that build MetaValues by hand
+            if let Ok(tag) = t.get::<String>("t") {
+                let value = match tag.as_str() {
+                    "MetaInlines" => Some(ConfigValueKind::PandocInlines(lua_table_to_inlines(
+                        lua,
+                        t.get("content")?,
+                    )?)),
+                    "MetaBlocks" => Some(ConfigValueKind::PandocBlocks(lua_table_to_blocks(
+                        lua,
+                        t.get("content")?,
+                    )?)),
+                    "MetaString" => Some(ConfigValueKind::Scalar(yaml_rust2::Yaml::String(
+                        t.get("text")?,
+                    ))),
+                    "MetaBool" => Some(ConfigValueKind::Scalar(yaml_rust2::Yaml::Boolean(
+                        t.get("value")?,
+                    ))),
+                    _ => None,
+                };
+                if let Some(value) = value {
+                    return Ok(ConfigValue {
+                        value,
+                        source_info,
+                        merge_op,
+                    });
+                }
+            }
+
             // Check if it's a sequence (array) or a map
             let len = t.raw_len();
             if len > 0 {
@@ -291,8 +334,7 @@ fn lua_to_config_value(lua: &Lua, val: Value) -> Result<ConfigValue> {
             } else {
                 // It's a map
                 let mut entries = Vec::new();
-                for pair in t.pairs::<String, Value>() {
-                    let (k, v) = pair?;
+                for (k, v) in sorted_pairs::<Value>(&t)? {
                     entries.push(ConfigMapEntry {
                         key: k,
                         key_source: SourceInfo::default(),
@@ -313,7 +355,7 @@ fn lua_to_config_value(lua: &Lua, val: Value) -> Result<ConfigValue> {
 /// Convert a Rust Pandoc document to a Lua table.
 ///
 /// Returns a table with `blocks`, `meta`, and `pandoc-api-version` fields.
-fn rust_pandoc_to_lua_table(lua: &Lua, pandoc: &Pandoc) -> Result<Value> {
+pub(super) fn rust_pandoc_to_lua_table(lua: &Lua, pandoc: &Pandoc) -> Result<Value> {
     let doc = lua.create_table()?;
 
     // Convert blocks
@@ -339,7 +381,7 @@ fn rust_pandoc_to_lua_table(lua: &Lua, pandoc: &Pandoc) -> Result<Value> {
 }
 
 /// Convert a Lua Pandoc table to a Rust Pandoc struct.
-fn lua_pandoc_to_rust(lua: &Lua, val: Value) -> Result<Pandoc> {
+pub(super) fn lua_pandoc_to_rust(lua: &Lua, val: Value) -> Result<Pandoc> {
     match val {
         Value::Table(t) => {
             // Get blocks
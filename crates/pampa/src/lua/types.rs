@@ -9,8 +9,8 @@
  */
 
 use mlua::{
-    Error, IntoLua, Lua, MetaMethod, Result, Table, UserData, UserDataFields, UserDataMethods,
-    UserDataRef, Value, Variadic,
+    Error, FromLua, IntoLua, Lua, MetaMethod, Result, Table, UserData, UserDataFields,
+    UserDataMethods, UserDataRef, Value, Variadic,
 };
 use quarto_source_map::SourceInfo;
 
@@ -1109,14 +1109,7 @@ fn lua_value_to_attr(val: Value, _lua: &Lua) -> Result<crate::pandoc::Attr> {
                 _ => Vec::new(),
             };
             let attrs = match attributes {
-                Some(Value::Table(t)) => {
-                    let mut result = hashlink::LinkedHashMap::new();
-                    for pair in t.pairs::<String, String>() {
-                        let (k, v) = pair?;
-                        result.insert(k, v);
-                    }
-                    result
-                }
+                Some(Value::Table(t)) => sorted_pairs::<String>(&t)?.into_iter().collect(),
                 _ => hashlink::LinkedHashMap::new(),
             };
             Ok((id, cls, attrs))
@@ -1271,8 +1264,7 @@ pub fn lua_to_meta_value(lua: &Lua, val: Value) -> Result<crate::pandoc::MetaVal
                     }
                     "MetaMap" => {
                         let mut map = hashlink::LinkedHashMap::new();
-                        for pair in table.pairs::<String, Value>() {
-                            let (k, v) = pair?;
+                        for (k, v) in sorted_pairs::<Value>(&table)? {
                             if k != "t" && k != "tag" {
                                 map.insert(k, lua_to_meta_value(lua, v)?);
                             }
@@ -1282,8 +1274,7 @@ pub fn lua_to_meta_value(lua: &Lua, val: Value) -> Result<crate::pandoc::MetaVal
                     _ => {
                         // Unknown tag, treat as a map
                         let mut map = hashlink::LinkedHashMap::new();
-                        for pair in table.pairs::<String, Value>() {
-                            let (k, v) = pair?;
+                        for (k, v) in sorted_pairs::<Value>(&table)? {
                             map.insert(k, lua_to_meta_value(lua, v)?);
                         }
                         Ok(MetaValue::MetaMap(map))
@@ -1302,8 +1293,7 @@ pub fn lua_to_meta_value(lua: &Lua, val: Value) -> Result<crate::pandoc::MetaVal
                 } else {
                     // It's a map
                     let mut map = hashlink::LinkedHashMap::new();
-                    for pair in table.pairs::<String, Value>() {
-                        let (k, v) = pair?;
+                    for (k, v) in sorted_pairs::<Value>(&table)? {
                         map.insert(k, lua_to_meta_value(lua, v)?);
                     }
                     Ok(MetaValue::MetaMap(map))
@@ -1329,8 +1319,7 @@ pub fn lua_table_to_meta(lua: &Lua, val: Value) -> Result<crate::pandoc::Meta> {
     match val {
         Value::Table(table) => {
             let mut meta = hashlink::LinkedHashMap::new();
-            for pair in table.pairs::<String, Value>() {
-                let (k, v) = pair?;
+            for (k, v) in sorted_pairs::<Value>(&table)? {
                 meta.insert(k, lua_to_meta_value(lua, v)?);
             }
             Ok(meta)
@@ -1613,18 +1602,20 @@ fn lua_table_to_string_map(
     val: Value,
 ) -> Result<hashlink::LinkedHashMap<String, String>> {
     match val {
-        Value::Table(table) => {
-            let mut result = hashlink::LinkedHashMap::new();
-            for pair in table.pairs::<String, String>() {
-                let (k, v) = pair?;
-                result.insert(k, v);
-            }
-            Ok(result)
-        }
+        Value::Table(table) => Ok(sorted_pairs::<String>(&table)?.into_iter().collect()),
         _ => Err(Error::runtime("expected table of key-value pairs")),
     }
 }
 
+/// The entries of a Lua table, sorted by key. `pairs` visits a table in an
+/// order that changes from run to run, so the maps made from tables are
+/// sorted for the output to stay the same.
+pub fn sorted_pairs<V: FromLua>(table: &Table) -> Result<Vec<(String, V)>> {
+    let mut pairs = table.pairs::<String, V>().collect::<Result<Vec<_>>>()?;
+    pairs.sort_by(|(a, _), (b, _)| a.cmp(b));
+    Ok(pairs)
+}
+
 /// Convert Attr to LuaAttr userdata
 pub fn attr_to_lua_userdata(lua: &Lua, attr: &crate::pandoc::Attr) -> Result<Value> {
     let lua_attr = LuaAttr::new(attr.clone());
@@ -1938,6 +1929,7 @@ mod tests {
     #[test]
     fn test_lua_inline_tag_name_note() {
         let inline = Inline::Note(Note {
+            id: None,
             content: vec![],
             source_info: si(),
         });
@@ -2172,6 +2164,7 @@ mod tests {
     #[test]
     fn test_lua_inline_field_names_note() {
         let inline = Inline::Note(Note {
+            id: None,
             content: vec![],
             source_info: si(),
         });
@@ -6,35 +6,58 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
-use clap::Parser;
+use clap::{Parser, Subcommand};
 use quarto_error_reporting::DiagnosticMessageBuilder;
 use std::io::{self, Read, Write};
 
+mod ast_diff;
+mod ast_query;
+mod batch;
+mod cell_options;
 mod citeproc_filter;
+mod diff;
+mod doctemplate;
 mod errors;
+mod extensions;
 mod filter_context;
 mod filters;
+mod fmt;
+mod highlight;
 #[cfg(feature = "json-filter")]
 mod json_filter;
+mod lint;
+mod logging;
 #[cfg(feature = "lua-filter")]
 mod lua;
+mod metadata;
+mod migrate;
 mod options;
 mod pandoc;
+mod plugins;
+mod query;
 mod readers;
+mod rmarkdown;
+mod serve;
 mod template;
+mod trace;
 mod transforms;
 mod traversals;
 mod unified_filter;
 mod utils;
+#[cfg(feature = "watch")]
+mod watch;
 mod writers;
+use extensions::{Direction, Extension, Extensions};
 use template::{
     TemplateBundle,
     builtin::{BUILTIN_TEMPLATE_NAMES, get_builtin_template, is_builtin_template},
+    config_merge::apply_variables,
     render::{BodyFormat, render_with_bundle},
 };
+use utils::autoid::{IdentifierOptions, IdentifierStyle};
 use utils::output::VerboseOutput;
 
-#[derive(Parser, Debug)]
+#[derive(Parser, Debug, Clone)]
 #[command(name = "pampa")]
 #[command(about = "Convert Quarto markdown to various output formats")]
 struct Args {
@@ -44,9 +67,20 @@ struct Args {
     #[arg(short = 't', long = "to", default_value = "native")]
     to: String,
 
+    /// Write the parser's progress and debug log events to stderr
     #[arg(short = 'v', long = "verbose")]
     verbose: bool,
 
+    /// Format of the log events on stderr: `plain` lines or one JSON
+    /// object per line. Giving it logs info events; PAMPA_LOG filters
+    /// them instead, e.g. PAMPA_LOG=pampa::writers=debug
+    #[arg(long = "log-format", value_parser = ["plain", "json"])]
+    log_format: Option<String>,
+
+    /// Log how long reading, each transform, each filter and writing took
+    #[arg(long = "timing", conflicts_with = "batch")]
+    timing: bool,
+
     #[arg(short = 'i', long = "input", default_value = "-")]
     input: String,
 
@@ -56,15 +90,52 @@ struct Args {
     #[arg(long = "json-errors")]
     json_errors: bool,
 
+    /// Read malformed qmd as far as it goes instead of stopping at the
+    /// first error, write the output for what could be read, and write
+    /// all diagnostics, with their severity and line and column spans, as
+    /// one JSON array on stderr. Exits with 1 if any is an error.
+    #[arg(long = "diagnostics", conflicts_with = "batch")]
+    diagnostics: bool,
+
     #[arg(long = "no-prune-errors")]
     no_prune_errors: bool,
 
     #[arg(long = "json-source-location", value_parser = ["full"])]
     json_source_location: Option<String>,
 
+    /// Attach source positions (file, line and column spans) to every block
+    /// and inline: the `l` field in JSON output, `data-loc` in HTML output
+    #[arg(long = "sourcepos")]
+    sourcepos: bool,
+
     #[arg(short = 'o', long = "output")]
     output: Option<String>,
 
+    /// Convert every file in DIR and its subdirectories whose extension
+    /// matches --from, writing each result to the -o directory under the
+    /// same relative path. Files are converted in parallel.
+    #[arg(long = "batch", value_name = "DIR", conflicts_with = "input")]
+    batch: Option<std::path::PathBuf>,
+
+    /// Number of files --batch converts at once (default: the number of
+    /// CPUs)
+    #[arg(short = 'j', long = "jobs", value_name = "N", requires = "batch")]
+    jobs: Option<usize>,
+
+    /// Convert the input again whenever it or a file it uses (includes,
+    /// metadata files, bibliographies, Lua filters) changes, writing the
+    /// -o file and printing one JSON event line on stdout per build
+    #[cfg(feature = "watch")]
+    #[arg(long = "watch", conflicts_with = "batch")]
+    watch: bool,
+
+    /// Write a trace of the conversion to FILE as JSON: how long reading,
+    /// each transform, each filter and writing took, with the source
+    /// locations of the blocks each filter changed (see
+    /// resources/trace.schema.json)
+    #[arg(long = "trace", value_name = "FILE", conflicts_with = "batch")]
+    trace: Option<std::path::PathBuf>,
+
     #[arg(
         long = "_internal-report-error-state",
         hide = true,
@@ -80,6 +151,69 @@ struct Args {
     #[arg(short = 'F', long = "filter", action = clap::ArgAction::Append)]
     filters: Vec<String>,
 
+    /// Process citations with the built-in citation processor, after any
+    /// --filter (same as a final `-F citeproc`)
+    #[arg(short = 'C', long = "citeproc")]
+    citeproc: bool,
+
+    /// Load a WebAssembly plugin (can be specified multiple times). Its
+    /// readers and writers can be chosen with --from and --to, and its
+    /// transforms run after any --filter. Needs the `wasm-plugins` feature.
+    #[arg(long = "plugin", value_name = "FILE", action = clap::ArgAction::Append)]
+    plugins: Vec<std::path::PathBuf>,
+
+    /// Set an option of a plugin (can be specified multiple times). Only
+    /// the plugin NAME is given it.
+    #[arg(long = "plugin-option", value_name = "NAME.KEY=VALUE", action = clap::ArgAction::Append)]
+    plugin_options: Vec<String>,
+
+    /// Bibliography file for citation processing, in BibTeX (.bib) or
+    /// CSL-JSON format (can be specified multiple times). Replaces the
+    /// document's `bibliography` metadata.
+    #[arg(long = "bibliography", value_name = "FILE", action = clap::ArgAction::Append)]
+    bibliography: Vec<String>,
+
+    /// CSL style for citation processing. Replaces the document's `csl`
+    /// metadata.
+    #[arg(long = "csl", value_name = "FILE")]
+    csl: Option<String>,
+
+    /// Expand `{{< include >}}` shortcodes into the blocks of the included
+    /// files (qmd input only). Paths are relative to the including file.
+    #[arg(long = "resolve-includes")]
+    resolve_includes: bool,
+
+    /// Treat the input as untrusted: refuse input over 4 MiB, and remove
+    /// raw content, scripts, shortcodes, metadata not known to be safe and
+    /// content nested too deeply, reporting each removal as a warning
+    #[arg(long = "sanitize", conflicts_with = "resolve_includes")]
+    sanitize: bool,
+
+    /// Audit the document for accessibility before it is written: images
+    /// without alternative text, skipped heading levels, tables without
+    /// headers and link text such as "click here", reporting each finding
+    /// as a warning
+    #[arg(long = "a11y")]
+    a11y: bool,
+
+    /// Fail the conversion, writing nothing, when a check finds anything.
+    /// `a11y` runs the accessibility audit of `--a11y`, for CI gates.
+    #[arg(long = "fail-on", value_name = "CHECK", value_parser = ["a11y"])]
+    fail_on: Option<String>,
+
+    /// Write output that is byte-identical across runs and platforms, for
+    /// build systems that cache on output hashes: paths in source locations
+    /// use `/`, the ansi writer ignores the terminal, and writers that embed
+    /// a date use SOURCE_DATE_EPOCH, or the Unix epoch when it isn't set
+    #[arg(long = "deterministic")]
+    deterministic: bool,
+
+    /// Number labelled figures, tables, sections and equations, and turn
+    /// `@fig-id` style references into links, as Quarto's crossref
+    /// processing does. Runs before any --filter.
+    #[arg(long = "crossref")]
+    crossref: bool,
+
     /// Use a template (built-in name like 'html5' or file path)
     #[cfg(feature = "template-fs")]
     #[arg(long = "template")]
@@ -92,6 +226,253 @@ struct Args {
     /// Export a built-in template as JSON and exit
     #[arg(long = "export-template", value_name = "NAME")]
     export_template: Option<String>,
+
+    /// Set a template variable (can be specified multiple times).
+    /// Variables override document metadata of the same name; a bare KEY
+    /// sets the variable to true. Only used when rendering with a template.
+    #[arg(short = 'V', long = "variable", value_name = "KEY[=VALUE]", action = clap::ArgAction::Append)]
+    variables: Vec<String>,
+
+    /// Set a metadata field (can be specified multiple times), replacing
+    /// the document's value. `true` and `false` are booleans, other values
+    /// strings; a bare KEY sets the field to true.
+    #[arg(short = 'M', long = "metadata", value_name = "KEY[=VALUE]", action = clap::ArgAction::Append)]
+    metadata: Vec<String>,
+
+    /// Read metadata from a YAML or JSON file (can be specified multiple
+    /// times). The document's own metadata takes priority, and maps are
+    /// merged key by key.
+    #[arg(long = "metadata-file", value_name = "FILE", action = clap::ArgAction::Append)]
+    metadata_files: Vec<std::path::PathBuf>,
+
+    /// Highlighting theme for code blocks in html and latex output: a
+    /// built-in theme (pygments, tango, monochrome) or a Pandoc JSON theme
+    /// file. Sets the `highlight-style` metadata field.
+    #[arg(long = "highlight-style", value_name = "STYLE|FILE")]
+    highlight_style: Option<String>,
+
+    /// Don't highlight code blocks (same as `-M highlight-style=none`)
+    #[arg(long = "no-highlight", conflicts_with = "highlight_style")]
+    no_highlight: bool,
+
+    /// Take paragraph and character styles for docx output from this .docx
+    #[arg(long = "reference-doc", value_name = "FILE")]
+    reference_doc: Option<std::path::PathBuf>,
+
+    /// Line wrapping for markdown/qmd output: fill lines to --columns
+    /// (auto), never wrap (none), or keep the source's line breaks
+    /// (preserve, the default)
+    #[arg(long = "wrap", value_parser = ["auto", "none", "preserve"])]
+    wrap: Option<String>,
+
+    /// Line width for --wrap=auto
+    #[arg(long = "columns", default_value_t = 72)]
+    columns: usize,
+
+    /// Bullet list marker for markdown/qmd output
+    #[arg(long = "bullet-marker", value_parser = ["*", "-", "+"], default_value = "*")]
+    bullet_marker: String,
+
+    /// Ordered list delimiter for markdown/qmd output. Lists keep their
+    /// own delimiter when this is not given.
+    #[arg(long = "ordered-list-delimiter", value_parser = ["period", "paren"])]
+    ordered_list_delimiter: Option<String>,
+
+    /// Preferred emphasis delimiter for markdown/qmd output
+    #[arg(long = "emphasis", value_parser = ["*", "_"], default_value = "*")]
+    emphasis: String,
+
+    /// Heading style for markdown/qmd output. Setext applies to levels 1
+    /// and 2 only.
+    #[arg(long = "heading-style", value_parser = ["atx", "setext"], default_value = "atx")]
+    heading_style: String,
+
+    /// Code block style for markdown/qmd output. Blocks with attributes
+    /// are always fenced.
+    #[arg(long = "code-block-style", value_parser = ["fenced", "indented"], default_value = "fenced")]
+    code_block_style: String,
+
+    /// Line endings of the output: those of the input (preserve, which
+    /// also keeps a byte order mark when writing qmd), \n (lf) or \r\n
+    /// (crlf)
+    #[arg(long = "line-endings", value_parser = ["preserve", "lf", "crlf"], default_value = "preserve")]
+    line_endings: String,
+
+    /// Make HTML output self-contained: images, stylesheets, scripts and
+    /// fonts it refers to by a local path are put in the file itself.
+    /// Paths are relative to the input file; remote URLs are kept.
+    #[arg(long = "embed-resources")]
+    embed_resources: bool,
+
+    /// Write links and images in markdown/qmd output as references, with
+    /// the definitions at the end of the document
+    #[arg(long = "reference-links")]
+    reference_links: bool,
+
+    /// Write the cells of pipe tables in markdown/qmd output as they are,
+    /// without padding them to the width of their column
+    #[arg(long = "no-table-padding")]
+    no_table_padding: bool,
+
+    /// Smart punctuation: read `---`, `--` and `...` anywhere in text as
+    /// em dashes, en dashes and ellipses (qmd input), and write them back
+    /// that way (markdown/qmd output). The same as `+smart` on both
+    /// formats.
+    #[arg(long = "smart")]
+    smart: bool,
+
+    /// List the extensions of a format (default: qmd), `+name` for those
+    /// that are on by default and `-name` for the others, and exit.
+    /// Extensions are turned on and off after the format name, as in
+    /// `-f qmd-footnotes+smart`.
+    #[arg(long = "list-extensions", value_name = "FORMAT", num_args = 0..=1, default_missing_value = "qmd")]
+    list_extensions: Option<String>,
+
+    /// The extensions of --from, set by `resolve_formats`
+    #[arg(skip)]
+    reader_extensions: Extensions,
+
+    /// The extensions of --to, set by `resolve_formats`
+    #[arg(skip)]
+    writer_extensions: Extensions,
+
+    #[command(subcommand)]
+    command: Option<Command>,
+}
+
+#[derive(Subcommand, Debug, Clone)]
+enum Command {
+    /// Compare two documents block by block: which blocks changed, were
+    /// inserted or were deleted. Exits with 0 when the documents are the
+    /// same, 1 when they differ and 2 on errors.
+    Diff {
+        /// The old document (.qmd, .md, .json or .ipynb)
+        before: std::path::PathBuf,
+
+        /// The new document
+        after: std::path::PathBuf,
+
+        /// Write the diff as JSON
+        #[arg(long = "json")]
+        json: bool,
+    },
+
+    /// Find the elements of documents that match a selector, like
+    /// `Header[level=2] > Str` or `Div.callout-note Code`, and list them
+    /// with where they are in the source. Without files, reads qmd from
+    /// stdin. Exits with 0 when something matches, 1 when nothing does and
+    /// 2 on errors.
+    Query {
+        /// The selector: element types, `.class`, `#id` and `[attribute]`
+        /// conditions, joined by ` ` (inside) and `>` (directly inside)
+        selector: String,
+
+        /// The documents to search (.qmd, .md, .json or .ipynb)
+        files: Vec<std::path::PathBuf>,
+
+        /// Write the matches as JSON
+        #[arg(long = "json")]
+        json: bool,
+    },
+
+    /// Rewrite qmd files in canonical form: lines filled to --columns
+    /// (unless --wrap says otherwise), and list markers, emphasis,
+    /// headings, code blocks and attributes written one way. The other
+    /// markdown output options apply. Without files, formats stdin to
+    /// stdout.
+    Fmt {
+        /// The files to format in place
+        files: Vec<std::path::PathBuf>,
+
+        /// Don't write anything; list the files that aren't formatted and
+        /// exit with 1 if there are any
+        #[arg(long = "check")]
+        check: bool,
+    },
+
+    /// Convert R Markdown files to Quarto, each to the .qmd file next to
+    /// it: chunk headers to `#|` options, the setup chunk's
+    /// `opts_chunk$set` to `execute` options and `output` to `format`.
+    /// What can't be translated is reported with where it is. Without
+    /// files, converts stdin to stdout. Exits with 0 when everything was
+    /// translated, 1 when something wasn't and 2 on errors.
+    Migrate {
+        /// The .Rmd files to convert
+        files: Vec<std::path::PathBuf>,
+
+        /// Don't write anything; only report what isn't translated
+        #[arg(long = "dry-run")]
+        dry_run: bool,
+
+        /// Replace .qmd files that already exist
+        #[arg(long = "force")]
+        force: bool,
+    },
+
+    /// Check qmd files without rendering them: report what the reader
+    /// warns about and the `#|` options of executable code blocks that
+    /// aren't options Quarto knows or have values of the wrong type, each
+    /// with where it is. Without files, checks stdin. Exits with 0 when
+    /// nothing is found, 1 when something is and 2 when a file can't be
+    /// read.
+    Lint {
+        /// The files to check
+        files: Vec<std::path::PathBuf>,
+    },
+
+    /// Answer requests on stdin with responses on stdout, one JSON-RPC 2.0
+    /// message per line, until stdin closes or a `shutdown` request:
+    /// parse text to the JSON AST, write, transform and format. The
+    /// options before `serve` apply to every request. The protocol is
+    /// described by resources/serve.schema.json.
+    Serve {
+        /// Speak JSON-RPC 2.0, the only protocol for now
+        #[arg(long = "jsonrpc", required = true)]
+        jsonrpc: bool,
+    },
+
+    /// Work with Pandoc-style document templates
+    Doctemplate {
+        #[command(subcommand)]
+        command: DoctemplateCommand,
+    },
+}
+
+#[derive(Subcommand, Debug, Clone)]
+enum DoctemplateCommand {
+    /// Report unbalanced $if$/$for$ directives, partials that don't exist,
+    /// suspicious pipes and, with --schema, variables the metadata doesn't
+    /// define. Exits with 1 when there are errors and 2 when the template
+    /// can't be read.
+    Lint {
+        /// The template to lint; its partials are read from next to it
+        template: std::path::PathBuf,
+
+        /// A JSON Schema of the metadata the template is rendered with
+        #[arg(long = "schema")]
+        schema: Option<std::path::PathBuf>,
+    },
+
+    /// Print a JSON Schema of the metadata a template uses: each variable,
+    /// whether it is a scalar, a list or a map, and whether the template
+    /// requires it. Partials are read from next to the template.
+    Schema {
+        /// The template to describe
+        template: std::path::PathBuf,
+    },
+
+    /// Indent the directives of templates by how deeply they are nested,
+    /// inside their delimiters (`$  endfor$`) so that the output doesn't
+    /// change. Without files, formats stdin to stdout.
+    Fmt {
+        /// The templates to format in place
+        files: Vec<std::path::PathBuf>,
+
+        /// Don't write anything; list the templates that aren't formatted
+        /// and exit with 1 if there are any
+        #[arg(long = "check")]
+        check: bool,
+    },
 }
 
 fn print_whole_tree<T: Write>(cursor: &mut tree_sitter_qmd::MarkdownCursor, buf: &mut T) {
@@ -148,8 +529,259 @@ fn should_sectionize(meta: &quarto_pandoc_types::ConfigValue) -> bool {
     section_divs_entry.value.as_bool().unwrap_or(false)
 }
 
+/// Where the messages of a conversion go: stdout and stderr for a single
+/// file, and buffers with `--batch`, so that the messages of each file
+/// stay together
+struct Messages<'a> {
+    json_errors: bool,
+    /// With `--diagnostics`, messages are kept here instead, to be written
+    /// as one JSON array at the end
+    collected: Option<Vec<serde_json::Value>>,
+    stdout: &'a mut dyn Write,
+    stderr: &'a mut dyn Write,
+}
+
+impl Messages<'_> {
+    fn report(
+        &mut self,
+        diagnostic: &quarto_error_reporting::DiagnosticMessage,
+        source_context: &quarto_source_map::SourceContext,
+    ) {
+        if let Some(collected) = &mut self.collected {
+            let mut json = diagnostic.to_json();
+            if let Some(range) = diagnostic
+                .location
+                .as_ref()
+                .and_then(|location| diagnostic_range(location, source_context))
+            {
+                json["range"] = range;
+            }
+            collected.push(json);
+            return;
+        }
+        let _ = if self.json_errors {
+            writeln!(self.stderr, "{}", diagnostic.to_json())
+        } else {
+            writeln!(self.stderr, "{}", diagnostic.to_text(Some(source_context)))
+        };
+    }
+
+    fn report_all<'d>(
+        &mut self,
+        diagnostics: impl IntoIterator<Item = &'d quarto_error_reporting::DiagnosticMessage>,
+        source_context: &quarto_source_map::SourceContext,
+    ) {
+        for diagnostic in diagnostics {
+            self.report(diagnostic, source_context);
+        }
+    }
+
+    fn error(&mut self, message: std::fmt::Arguments) {
+        if let Some(collected) = &mut self.collected {
+            collected.push(serde_json::json!({"kind": "error", "title": message.to_string()}));
+            return;
+        }
+        let _ = writeln!(self.stderr, "{}", message);
+    }
+
+    /// Write the messages kept for `--diagnostics`. Returns whether any is
+    /// an error.
+    fn write_collected(&mut self) -> bool {
+        let Some(collected) = self.collected.take() else {
+            return false;
+        };
+        let _ = writeln!(
+            self.stderr,
+            "{}",
+            serde_json::Value::Array(collected.clone())
+        );
+        collected.iter().any(|message| message["kind"] == "error")
+    }
+}
+
+/// Where a diagnostic is in its file, for `--diagnostics`: the file name
+/// and the 1-based lines and columns of the start and end
+fn diagnostic_range(
+    location: &quarto_source_map::SourceInfo,
+    source_context: &quarto_source_map::SourceContext,
+) -> Option<serde_json::Value> {
+    let (start, end) = location.map_range(0, location.length(), source_context)?;
+    let point = |mapped: &quarto_source_map::MappedLocation| {
+        serde_json::json!({
+            "offset": mapped.location.offset,
+            "line": mapped.location.row + 1,
+            "column": mapped.location.column + 1,
+        })
+    };
+    Some(serde_json::json!({
+        "file": source_context.get_file(start.file_id)?.path,
+        "start": point(&start),
+        "end": point(&end),
+    }))
+}
+
+/// A conversion stopped. Its messages have been reported.
+struct ConversionFailed;
+
+/// What is read once and shared by all conversions
+struct Resources {
+    /// The template, and its name for error reporting
+    template: Option<(TemplateBundle, String)>,
+    reference_doc: Option<Vec<u8>>,
+    /// The plugins given with --plugin, and their options
+    plugins: plugins::Registry,
+}
+
+fn load_resources(args: &Args) -> Resources {
+    // Load template if specified, tracking both the bundle and its name for error reporting
+    let template = if let Some(bundle_path) = &args.template_bundle {
+        let bundle_json = std::fs::read_to_string(bundle_path).unwrap_or_else(|e| {
+            eprintln!(
+                "Failed to read template bundle '{}': {}",
+                bundle_path.display(),
+                e
+            );
+            std::process::exit(1);
+        });
+        match TemplateBundle::from_json(&bundle_json) {
+            Ok(bundle) => Some((bundle, bundle_path.display().to_string())),
+            Err(e) => {
+                eprintln!("Failed to parse template bundle: {}", e);
+                std::process::exit(1);
+            }
+        }
+    } else {
+        template_from_arg(args)
+    };
+
+    let reference_doc = args.reference_doc.as_ref().map(|path| {
+        std::fs::read(path).unwrap_or_else(|e| {
+            eprintln!("Failed to read reference doc '{}': {}", path.display(), e);
+            std::process::exit(1);
+        })
+    });
+
+    Resources {
+        template,
+        reference_doc,
+        plugins: load_plugins(args),
+    }
+}
+
+fn load_plugins(args: &Args) -> plugins::Registry {
+    let mut registry = plugins::Registry::new();
+    for path in &args.plugins {
+        #[cfg(feature = "wasm-plugins")]
+        if let Err(diagnostic) = plugins::wasm::load(path, &mut registry) {
+            eprintln!("{}", diagnostic.to_text(None));
+            std::process::exit(1);
+        }
+        #[cfg(not(feature = "wasm-plugins"))]
+        {
+            eprintln!(
+                "Cannot load plugin '{}': pampa was built without the `wasm-plugins` feature",
+                path.display()
+            );
+            std::process::exit(1);
+        }
+    }
+    for option in &args.plugin_options {
+        if let Err(e) = registry.set_option(option) {
+            eprintln!("{}", e);
+            std::process::exit(1);
+        }
+    }
+    registry
+}
+
+#[cfg(feature = "template-fs")]
+fn template_from_arg(args: &Args) -> Option<(TemplateBundle, String)> {
+    let template_arg = args.template.as_ref()?;
+    if is_builtin_template(template_arg) {
+        get_builtin_template(template_arg)
+            .map(|b| (b, format!("<builtin-template:{}>", template_arg)))
+    } else {
+        let template_path = std::path::Path::new(template_arg);
+        let template_source = std::fs::read_to_string(template_path).unwrap_or_else(|e| {
+            eprintln!("Failed to read template file '{}': {}", template_arg, e);
+            std::process::exit(1);
+        });
+        Some((TemplateBundle::new(template_source), template_arg.clone()))
+    }
+}
+
+#[cfg(not(feature = "template-fs"))]
+fn template_from_arg(_args: &Args) -> Option<(TemplateBundle, String)> {
+    None
+}
+
+/// Add the newline that the input is missing, with a warning
+fn ensure_final_newline(input: &mut String, input_filename: &str, messages: &mut Messages) {
+    if input.ends_with('\n') {
+        return;
+    }
+    let warning = DiagnosticMessageBuilder::warning("Missing Newline at End of File")
+        .with_code("Q-7-1")
+        .problem(format!(
+            "File `{}` does not end with a newline",
+            input_filename
+        ))
+        .add_info("A newline will be added automatically")
+        .build();
+    let _ = if let Some(collected) = &mut messages.collected {
+        collected.push(warning.to_json());
+        Ok(())
+    } else if messages.json_errors {
+        writeln!(messages.stderr, "{}", warning.to_json())
+    } else {
+        writeln!(messages.stderr, "{}", warning.to_text(None))
+    };
+    input.push('\n'); // ensure the input ends with a newline
+}
+
+/// Split --from and --to into format names and extensions. `--smart` and
+/// `--sourcepos` turn on the extensions of the same name.
+fn resolve_formats(args: &mut Args) -> Result<(), String> {
+    let from = extensions::parse_format(&args.from, Direction::Reader)?;
+    let to = extensions::parse_format(&args.to, Direction::Writer)?;
+    args.from = from.name;
+    args.reader_extensions = from.extensions;
+    args.to = to.name;
+    args.writer_extensions = to.extensions;
+    if args.smart {
+        args.reader_extensions.enable(Extension::Smart);
+        args.writer_extensions.enable(Extension::Smart);
+    }
+    if args.sourcepos {
+        args.reader_extensions.enable(Extension::Sourcepos);
+    }
+    tracing::debug!(
+        reader = %args.from,
+        extensions = %args.reader_extensions,
+        "reader extensions"
+    );
+    tracing::debug!(
+        writer = %args.to,
+        extensions = %args.writer_extensions,
+        "writer extensions"
+    );
+    Ok(())
+}
+
 fn main() {
-    let args = Args::parse();
+    let mut args = Args::parse();
+    logging::init(args.log_format.as_deref(), args.verbose, args.timing);
+
+    if let Some(format) = &args.list_extensions {
+        for line in extensions::list(format) {
+            println!("{}", line);
+        }
+        return;
+    }
+    if let Err(e) = resolve_formats(&mut args) {
+        eprintln!("{}", e);
+        std::process::exit(1);
+    }
 
     // Handle --export-template early (like --help)
     if let Some(template_name) = &args.export_template {
@@ -175,6 +807,51 @@ fn main() {
         }
     }
 
+    match &args.command {
+        Some(Command::Diff {
+            before,
+            after,
+            json,
+        }) => std::process::exit(diff::run(&args, before, after, *json)),
+        Some(Command::Query {
+            selector,
+            files,
+            json,
+        }) => std::process::exit(query::run(&args, selector, files, *json)),
+        Some(Command::Fmt { files, check }) => std::process::exit(fmt::run(&args, files, *check)),
+        Some(Command::Migrate {
+            files,
+            dry_run,
+            force,
+        }) => std::process::exit(migrate::run(&args, files, *dry_run, *force)),
+        Some(Command::Lint { files }) => std::process::exit(lint::run(&args, files)),
+        Some(Command::Serve { .. }) => {
+            let resources = load_resources(&args);
+            std::process::exit(serve::run(&args, &resources))
+        }
+        Some(Command::Doctemplate {
+            command: DoctemplateCommand::Lint { template, schema },
+        }) => std::process::exit(doctemplate::lint(&args, template, schema.as_deref())),
+        Some(Command::Doctemplate {
+            command: DoctemplateCommand::Schema { template },
+        }) => std::process::exit(doctemplate::schema(&args, template)),
+        Some(Command::Doctemplate {
+            command: DoctemplateCommand::Fmt { files, check },
+        }) => std::process::exit(doctemplate::fmt(&args, files, *check)),
+        None => {}
+    }
+
+    if let Some(batch_dir) = &args.batch {
+        let resources = load_resources(&args);
+        std::process::exit(batch::run(&args, &resources, batch_dir));
+    }
+
+    #[cfg(feature = "watch")]
+    if args.watch {
+        let resources = load_resources(&args);
+        std::process::exit(watch::run(&args, &resources));
+    }
+
     let mut input_filename = "<stdin>";
     let mut input = String::new();
     let mut output_stream = if args.verbose {
@@ -196,23 +873,15 @@ fn main() {
             .expect("Failed to read input file");
     }
 
-    if !input.ends_with('\n') {
-        let warning = DiagnosticMessageBuilder::warning("Missing Newline at End of File")
-            .with_code("Q-7-1")
-            .problem(format!(
-                "File `{}` does not end with a newline",
-                input_filename
-            ))
-            .add_info("A newline will be added automatically")
-            .build();
-
-        if args.json_errors {
-            eprintln!("{}", warning.to_json());
-        } else {
-            eprintln!("{}", warning.to_text(None));
-        }
-        input.push('\n'); // ensure the input ends with a newline
-    }
+    let mut stdout = io::stdout();
+    let mut stderr = io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: args.diagnostics.then(Vec::new),
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+    ensure_final_newline(&mut input, input_filename, &mut messages);
 
     if args._internal_report_error_state {
         let error_messages = readers::qmd::read_bad_qmd_for_error_message(input.as_bytes());
@@ -222,30 +891,218 @@ fn main() {
         return;
     }
 
-    let (pandoc, context) = match args.from.as_str() {
+    if matches!(args.to.as_str(), "docx" | "epub") && args.output.is_none() {
+        eprintln!(
+            "{} output is binary; specify an output file with -o",
+            args.to
+        );
+        std::process::exit(1);
+    }
+
+    let resources = load_resources(&args);
+    let mut tracer = if args.trace.is_some() || args.timing {
+        trace::Tracer::new()
+    } else {
+        trace::Tracer::disabled()
+    };
+    let result = convert(
+        &args,
+        &resources,
+        input_filename,
+        &input,
+        &mut transforms::IncludeCache::new(),
+        &mut output_stream,
+        &mut messages,
+        &mut tracer,
+    );
+    if let Some(trace_path) = &args.trace {
+        let trace = tracer.finish(input_filename);
+        let written = serde_json::to_vec_pretty(&trace)
+            .map_err(|e| e.to_string())
+            .and_then(|json| std::fs::write(trace_path, json).map_err(|e| e.to_string()));
+        if let Err(e) = written {
+            messages.error(format_args!(
+                "Failed to write trace to file '{}': {}",
+                trace_path.display(),
+                e
+            ));
+        }
+    }
+    let had_errors = messages.write_collected();
+    let Ok(buf) = result else {
+        std::process::exit(1);
+    };
+
+    // Write output to file or stdout
+    if let Some(output_path) = args.output {
+        std::fs::write(&output_path, &buf)
+            .unwrap_or_else(|_| panic!("Failed to write output to file: {}", output_path));
+    } else {
+        let output = String::from_utf8(buf).expect("Invalid UTF-8 in output");
+        print!("{}", output);
+    }
+    if had_errors {
+        std::process::exit(1);
+    }
+}
+
+/// The markdown/qmd writer options given on the command line
+fn qmd_config(args: &Args) -> writers::qmd::QmdConfig {
+    writers::qmd::QmdConfig {
+        wrap: args
+            .wrap
+            .as_deref()
+            .and_then(|wrap| wrap.parse().ok())
+            .unwrap_or_default(),
+        columns: args.columns,
+        bullet_marker: args.bullet_marker.chars().next().unwrap_or('*'),
+        ordered_list_delimiter: args.ordered_list_delimiter.as_deref().map(|delimiter| {
+            match delimiter {
+                "paren" => crate::pandoc::list::ListNumberDelim::OneParen,
+                _ => crate::pandoc::list::ListNumberDelim::Period,
+            }
+        }),
+        emphasis: args.emphasis.parse().unwrap_or_default(),
+        heading_style: args.heading_style.parse().unwrap_or_default(),
+        code_block_style: args.code_block_style.parse().unwrap_or_default(),
+        reference_links: args.reference_links,
+        smart: args.writer_extensions.is_enabled(Extension::Smart),
+        mark: args.writer_extensions.is_enabled(Extension::Mark),
+        pad_tables: !args.no_table_padding,
+    }
+}
+
+/// The marks the qmd reader reads that `-f qmd-superscript` and the like
+/// turn back into text
+fn disabled_marks(args: &Args) -> Vec<transforms::MarkKind> {
+    [
+        (Extension::Superscript, transforms::MarkKind::Superscript),
+        (Extension::Subscript, transforms::MarkKind::Subscript),
+        (Extension::Strikeout, transforms::MarkKind::Strikeout),
+    ]
+    .into_iter()
+    .filter(|(extension, _)| !args.reader_extensions.is_enabled(*extension))
+    .map(|(_, kind)| kind)
+    .collect()
+}
+
+/// The name of the input in source locations. With `--deterministic`, it is
+/// the same on every platform.
+fn source_filename<'a>(args: &Args, input_filename: &'a str) -> std::borrow::Cow<'a, str> {
+    if args.deterministic && std::path::MAIN_SEPARATOR == '\\' {
+        input_filename.replace('\\', "/").into()
+    } else {
+        input_filename.into()
+    }
+}
+
+/// Read, transform and write one document, returning the output
+#[allow(clippy::too_many_arguments)]
+fn convert(
+    args: &Args,
+    resources: &Resources,
+    input_filename: &str,
+    input: &str,
+    include_cache: &mut transforms::IncludeCache,
+    mut output_stream: &mut dyn Write,
+    messages: &mut Messages,
+    tracer: &mut trace::Tracer,
+) -> Result<Vec<u8>, ConversionFailed> {
+    let input_filename: &str = &source_filename(args, input_filename);
+
+    // Read and write with \n line endings and no BOM, and write the output
+    // back in the style of the input
+    let input_style = utils::line_endings::TextStyle::detect(input);
+    let normalized_input = utils::line_endings::normalize(input);
+    let input: &str = &normalized_input;
+
+    let sanitize_options = transforms::SanitizeOptions::default();
+    if args.sanitize
+        && let Err(diagnostic) = transforms::check_input_size(input.as_bytes(), &sanitize_options)
+    {
+        messages.report(&diagnostic, &quarto_source_map::SourceContext::new());
+        return Err(ConversionFailed);
+    }
+
+    let read_start = tracer.begin();
+    let (pandoc, mut context) = match args.from.as_str() {
         "markdown" | "qmd" => {
-            let result = readers::qmd::read(
-                input.as_bytes(),
-                args.loose,
-                input_filename,
-                &mut output_stream,
-                !args.no_prune_errors, // prune_errors = !no_prune_errors
-                None,
-            );
+            let result = if args.diagnostics {
+                Ok(readers::qmd::read_recovering(
+                    input.as_bytes(),
+                    args.loose,
+                    input_filename,
+                    !args.no_prune_errors,
+                ))
+            } else {
+                readers::qmd::read(
+                    input.as_bytes(),
+                    args.loose,
+                    input_filename,
+                    &mut output_stream,
+                    !args.no_prune_errors, // prune_errors = !no_prune_errors
+                    None,
+                )
+            };
             match result {
-                Ok((pandoc, context, warnings)) => {
-                    // Output warnings to stderr
-                    if args.json_errors {
-                        // JSON format
-                        for warning in warnings {
-                            eprintln!("{}", warning.to_json());
-                        }
-                    } else {
-                        // Text format (default) - pass source_context for Ariadne rendering
-                        for warning in warnings {
-                            eprintln!("{}", warning.to_text(Some(&context.source_context)));
+                Ok((mut pandoc, mut context, warnings)) => {
+                    // Output warnings, passing source_context for Ariadne rendering
+                    messages.report_all(&warnings, &context.source_context);
+                    if args.resolve_includes {
+                        let input_path = std::path::Path::new(input_filename);
+                        let root_dir = input_path.parent().unwrap_or(std::path::Path::new(""));
+                        let mut diagnostics =
+                            utils::diagnostic_collector::DiagnosticCollector::new();
+                        pandoc.blocks = transforms::resolve_includes_cached(
+                            std::mem::take(&mut pandoc.blocks),
+                            input_path,
+                            root_dir,
+                            &mut context.source_context,
+                            &mut diagnostics,
+                            include_cache,
+                        );
+                        messages.report_all(diagnostics.diagnostics(), &context.source_context);
+                        if diagnostics.has_errors() {
+                            return Err(ConversionFailed);
                         }
                     }
+                    // Join [^id] references with their definitions, so
+                    // filters and writers see Note inlines
+                    if args.reader_extensions.is_enabled(Extension::Footnotes) {
+                        pandoc.blocks = transforms::resolve_footnotes(
+                            std::mem::take(&mut pandoc.blocks),
+                            &context.source_context,
+                        );
+                    }
+                    let identifiers = identifier_options(args);
+                    if !args
+                        .reader_extensions
+                        .is_enabled(Extension::AutoIdentifiers)
+                    {
+                        pandoc.blocks =
+                            transforms::remove_auto_identifiers(std::mem::take(&mut pandoc.blocks));
+                    } else if identifiers != IdentifierOptions::default() {
+                        // The reader made them by Pandoc's rules
+                        pandoc.blocks = transforms::assign_auto_identifiers(
+                            std::mem::take(&mut pandoc.blocks),
+                            identifiers,
+                        );
+                    }
+                    if args.reader_extensions.is_enabled(Extension::Smart) {
+                        pandoc.blocks =
+                            transforms::smart_punctuation(std::mem::take(&mut pandoc.blocks));
+                    }
+                    let kinds = disabled_marks(args);
+                    if !kinds.is_empty() {
+                        pandoc.blocks =
+                            transforms::marks_to_text(std::mem::take(&mut pandoc.blocks), &kinds);
+                    }
+                    if args.reader_extensions.is_enabled(Extension::Mark) {
+                        pandoc.blocks = transforms::read_highlights(
+                            std::mem::take(&mut pandoc.blocks),
+                            &context.source_context,
+                        );
+                    }
                     (pandoc, context)
                 }
                 Err(diagnostics) => {
@@ -253,18 +1110,16 @@ fn main() {
                     if args.json_errors {
                         // For JSON errors, print to stdout as a JSON array
                         for diagnostic in diagnostics {
-                            println!("{}", diagnostic.to_json());
+                            let _ = writeln!(messages.stdout, "{}", diagnostic.to_json());
                         }
                     } else {
                         // Build a minimal source context for Ariadne rendering
                         let mut source_context = quarto_source_map::SourceContext::new();
-                        source_context.add_file(input_filename.to_string(), Some(input.clone()));
-
-                        for diagnostic in diagnostics {
-                            eprintln!("{}", diagnostic.to_text(Some(&source_context)));
-                        }
+                        source_context
+                            .add_file(input_filename.to_string(), Some(input.to_string()));
+                        messages.report_all(&diagnostics, &source_context);
                     }
-                    std::process::exit(1);
+                    return Err(ConversionFailed);
                 }
             }
         }
@@ -283,49 +1138,221 @@ fn main() {
                     (pandoc, context)
                 }
                 Err(e) => {
-                    eprintln!("Error reading JSON: {}", e);
-                    std::process::exit(1);
+                    messages.error(format_args!("Error reading JSON: {}", e));
+                    return Err(ConversionFailed);
                 }
             }
         }
-        "commonmark" => {
-            // Use comrak-based CommonMark reader
-            readers::commonmark::read(&input, input_filename)
+        "commonmark" | "gfm" => {
+            // Use comrak-based CommonMark reader; gfm is GitHub Flavored
+            // Markdown, without qmd extensions
+            let (mut pandoc, context) = if args.from == "gfm" {
+                readers::commonmark::read_gfm(input, input_filename)
+            } else {
+                readers::commonmark::read(input, input_filename)
+            };
+            if args
+                .reader_extensions
+                .is_enabled(Extension::AutoIdentifiers)
+            {
+                pandoc.blocks = transforms::assign_auto_identifiers(
+                    std::mem::take(&mut pandoc.blocks),
+                    identifier_options(args),
+                );
+            }
+            if args.reader_extensions.is_enabled(Extension::Smart) {
+                pandoc.blocks = transforms::smart_punctuation(std::mem::take(&mut pandoc.blocks));
+            }
+            if args.from == "gfm" && !args.reader_extensions.is_enabled(Extension::Strikeout) {
+                pandoc.blocks = transforms::marks_to_text(
+                    std::mem::take(&mut pandoc.blocks),
+                    &[transforms::MarkKind::Strikeout],
+                );
+            }
+            (pandoc, context)
         }
-        _ => {
-            eprintln!("Unknown input format: {}", args.from);
-            std::process::exit(1);
+        "org" | "rst" => {
+            // Legacy formats, read for migration: what has no translation
+            // is kept as a raw block, with a warning
+            let (mut pandoc, context, warnings) = if args.from == "org" {
+                readers::org::read(input, input_filename)
+            } else {
+                readers::rst::read(input, input_filename)
+            };
+            messages.report_all(&warnings, &context.source_context);
+            if args.reader_extensions.is_enabled(Extension::Smart) {
+                pandoc.blocks = transforms::smart_punctuation(std::mem::take(&mut pandoc.blocks));
+            }
+            (pandoc, context)
+        }
+        "ipynb" => match readers::ipynb::read(input, input_filename) {
+            Ok((pandoc, context, warnings)) => {
+                messages.report_all(&warnings, &context.source_context);
+                (pandoc, context)
+            }
+            Err(readers::ipynb::IpynbReadError::CellParse {
+                diagnostics,
+                source_context,
+                ..
+            }) => {
+                for diagnostic in diagnostics {
+                    if args.json_errors {
+                        let _ = writeln!(messages.stdout, "{}", diagnostic.to_json());
+                    } else {
+                        messages.report(&diagnostic, &source_context);
+                    }
+                }
+                return Err(ConversionFailed);
+            }
+            Err(e) => {
+                messages.error(format_args!("Error reading notebook: {}", e));
+                return Err(ConversionFailed);
+            }
+        },
+        _ => match resources
+            .plugins
+            .read(&args.from, input, input_filename, &args.to)
+        {
+            Some(Ok(((pandoc, context), diagnostics))) => {
+                messages.report_all(&diagnostics, &context.source_context);
+                (pandoc, context)
+            }
+            Some(Err(diagnostics)) => {
+                messages.report_all(&diagnostics, &quarto_source_map::SourceContext::new());
+                return Err(ConversionFailed);
+            }
+            None => {
+                messages.error(format_args!("Unknown input format: {}", args.from));
+                return Err(ConversionFailed);
+            }
+        },
+    };
+
+    tracer.record(read_start, trace::Phase::Read, &args.from, Vec::new());
+
+    let mut pandoc = pandoc;
+    if args.sanitize {
+        let sanitize_start = tracer.begin();
+        let report = transforms::sanitize(&mut pandoc, &sanitize_options);
+        messages.report_all(&report.diagnostics(), &context.source_context);
+        tracer.record(
+            sanitize_start,
+            trace::Phase::Transform,
+            "sanitize",
+            Vec::new(),
+        );
+    }
+
+    // --metadata-file layers go under the document's metadata, --metadata over it
+    let metadata_start = tracer.begin();
+    let mut metadata_files = Vec::new();
+    for path in &args.metadata_files {
+        match metadata::read_metadata_file(path, &mut context.source_context) {
+            Ok(layer) => metadata_files.push(layer),
+            Err(diagnostics) => {
+                messages.report_all(&diagnostics, &context.source_context);
+                return Err(ConversionFailed);
+            }
+        }
+    }
+    match metadata::apply_metadata(&pandoc.meta, &metadata_files, &args.metadata) {
+        Ok(meta) => pandoc.meta = meta,
+        Err(diagnostic) => {
+            messages.report(&diagnostic, &context.source_context);
+            return Err(ConversionFailed);
         }
+    }
+
+    // --highlight-style and --no-highlight set `highlight-style`, which the
+    // html and latex writers read
+    let highlight_layer = if args.no_highlight {
+        Some(metadata::metadata_args_to_config(&[
+            "highlight-style=none".to_string()
+        ]))
+    } else if let Some(style) = &args.highlight_style {
+        if highlight::Theme::builtin(style).is_some() {
+            Some(metadata::metadata_args_to_config(&[format!(
+                "highlight-style={}",
+                style
+            )]))
+        } else {
+            let path = std::path::Path::new(style);
+            match metadata::read_metadata_file(path, &mut context.source_context) {
+                Ok(theme) => Some(metadata::override_layer("highlight-style", theme)),
+                Err(diagnostics) => {
+                    messages.report_all(&diagnostics, &context.source_context);
+                    return Err(ConversionFailed);
+                }
+            }
+        }
+    } else {
+        None
     };
+    if let Some(layer) = highlight_layer {
+        match metadata::merge_metadata(&[&pandoc.meta, &layer]) {
+            Ok(meta) => pandoc.meta = meta,
+            Err(diagnostic) => {
+                messages.report(&diagnostic, &context.source_context);
+                return Err(ConversionFailed);
+            }
+        }
+    }
+
+    // --bibliography and --csl are metadata for the citation processor
+    let citeproc_variables: Vec<String> = args
+        .bibliography
+        .iter()
+        .map(|path| format!("bibliography={}", path))
+        .chain(args.csl.iter().map(|path| format!("csl={}", path)))
+        .collect();
+    if !citeproc_variables.is_empty() {
+        let (meta, diagnostics) = apply_variables(&pandoc.meta, &citeproc_variables);
+        pandoc.meta = meta;
+        messages.report_all(&diagnostics, &context.source_context);
+    }
+    tracer.record(
+        metadata_start,
+        trace::Phase::Transform,
+        "metadata",
+        Vec::new(),
+    );
+
+    if args.crossref {
+        let crossref_start = tracer.begin();
+        let mut diagnostics = utils::diagnostic_collector::DiagnosticCollector::new();
+        pandoc.blocks = transforms::resolve_crossrefs(
+            std::mem::take(&mut pandoc.blocks),
+            &pandoc.meta,
+            &mut diagnostics,
+        );
+        messages.report_all(diagnostics.diagnostics(), &context.source_context);
+        tracer.record(
+            crossref_start,
+            trace::Phase::Transform,
+            "crossref",
+            Vec::new(),
+        );
+    }
+
+    let mut filters = args.filters.clone();
+    if args.citeproc {
+        filters.push("citeproc".to_string());
+    }
 
     // Apply filters in order
-    let (pandoc, mut context) = if args.filters.is_empty() {
+    let (mut pandoc, mut context) = if filters.is_empty() {
         (pandoc, context)
     } else {
         // Parse filter specifications
-        let filter_specs: Vec<unified_filter::FilterSpec> = args
-            .filters
+        let filter_specs: Vec<unified_filter::FilterSpec> = filters
             .iter()
             .map(|s| unified_filter::FilterSpec::parse(s))
             .collect();
 
-        match unified_filter::apply_filters(pandoc, context, &filter_specs, &args.to) {
+        match apply_traced_filters(pandoc, context, &filter_specs, &args.to, tracer) {
             Ok((filtered_pandoc, filtered_context, diagnostics)) => {
                 // Output any diagnostics from filters
-                if !diagnostics.is_empty() {
-                    if args.json_errors {
-                        for diagnostic in &diagnostics {
-                            eprintln!("{}", diagnostic.to_json());
-                        }
-                    } else {
-                        for diagnostic in &diagnostics {
-                            eprintln!(
-                                "{}",
-                                diagnostic.to_text(Some(&filtered_context.source_context))
-                            );
-                        }
-                    }
-                }
+                messages.report_all(&diagnostics, &filtered_context.source_context);
                 (filtered_pandoc, filtered_context)
             }
             Err(e) => {
@@ -334,104 +1361,74 @@ fn main() {
                         "title": "Filter Error",
                         "message": e.to_string()
                     });
-                    eprintln!("{}", error_json);
+                    messages.error(format_args!("{}", error_json));
                 } else {
-                    eprintln!("Filter error: {}", e);
+                    messages.error(format_args!("Filter error: {}", e));
                 }
-                std::process::exit(1);
+                return Err(ConversionFailed);
             }
         }
     };
 
-    // Load template if specified, tracking both the bundle and its name for error reporting
-    #[cfg(feature = "template-fs")]
-    let template_info: Option<(TemplateBundle, String)> = {
-        if let Some(bundle_path) = &args.template_bundle {
-            let bundle_json = std::fs::read_to_string(bundle_path).unwrap_or_else(|e| {
-                eprintln!(
-                    "Failed to read template bundle '{}': {}",
-                    bundle_path.display(),
-                    e
-                );
-                std::process::exit(1);
-            });
-            match TemplateBundle::from_json(&bundle_json) {
-                Ok(bundle) => Some((bundle, bundle_path.display().to_string())),
-                Err(e) => {
-                    eprintln!("Failed to parse template bundle: {}", e);
-                    std::process::exit(1);
-                }
+    // Then the plugins' transforms, in the order they were loaded
+    let transform_names: Vec<&str> = resources.plugins.transform_names().collect();
+    if !transform_names.is_empty() {
+        let transform_start = tracer.begin();
+        match resources.plugins.transform(&mut pandoc, &context, &args.to) {
+            Ok(((), diagnostics)) => messages.report_all(&diagnostics, &context.source_context),
+            Err(diagnostics) => {
+                messages.report_all(&diagnostics, &context.source_context);
+                return Err(ConversionFailed);
             }
-        } else if let Some(template_arg) = &args.template {
-            if is_builtin_template(template_arg) {
-                get_builtin_template(template_arg)
-                    .map(|b| (b, format!("<builtin-template:{}>", template_arg)))
-            } else {
-                let template_path = std::path::Path::new(template_arg);
-                let template_source = std::fs::read_to_string(template_path).unwrap_or_else(|e| {
-                    eprintln!("Failed to read template file '{}': {}", template_arg, e);
-                    std::process::exit(1);
-                });
-                Some((TemplateBundle::new(template_source), template_arg.clone()))
-            }
-        } else {
-            None
         }
-    };
+        tracer.record(
+            transform_start,
+            trace::Phase::Transform,
+            &transform_names.join(","),
+            Vec::new(),
+        );
+    }
 
-    #[cfg(not(feature = "template-fs"))]
-    let template_info: Option<(TemplateBundle, String)> = {
-        if let Some(bundle_path) = &args.template_bundle {
-            let bundle_json = std::fs::read_to_string(bundle_path).unwrap_or_else(|e| {
-                eprintln!(
-                    "Failed to read template bundle '{}': {}",
-                    bundle_path.display(),
-                    e
-                );
-                std::process::exit(1);
-            });
-            match TemplateBundle::from_json(&bundle_json) {
-                Ok(bundle) => Some((bundle, bundle_path.display().to_string())),
-                Err(e) => {
-                    eprintln!("Failed to parse template bundle: {}", e);
-                    std::process::exit(1);
-                }
-            }
-        } else {
-            None
+    let fail_on_a11y = args.fail_on.as_deref() == Some("a11y");
+    if args.a11y || fail_on_a11y {
+        let a11y_start = tracer.begin();
+        let report = transforms::audit_accessibility(&pandoc);
+        messages.report_all(&report.diagnostics(), &context.source_context);
+        tracer.record(a11y_start, trace::Phase::Transform, "a11y", Vec::new());
+        if fail_on_a11y && !report.is_empty() {
+            return Err(ConversionFailed);
         }
-    };
+    }
 
+    let write_start = tracer.begin();
     let mut buf = Vec::new();
-    let writer_result = if let Some((bundle, template_name)) = template_info {
+    let writer_result = if let Some((bundle, template_name)) = &resources.template {
         // Determine body format from --to
         let body_format = match args.to.as_str() {
             "html" => BodyFormat::Html,
+            "latex" => BodyFormat::Latex,
+            "typst" => BodyFormat::Typst,
+            "revealjs" => BodyFormat::Revealjs,
             "plaintext" | "plain" => BodyFormat::Plaintext,
             other => {
-                eprintln!(
-                    "Template rendering requires --to html or --to plaintext, got '{}'",
+                messages.error(format_args!(
+                    "Template rendering requires --to html, revealjs, latex, typst or plaintext, got '{}'",
                     other
-                );
-                std::process::exit(1);
+                ));
+                return Err(ConversionFailed);
             }
         };
 
-        match render_with_bundle(&pandoc, &mut context, &bundle, &template_name, body_format) {
-            Ok((output, diagnostics)) => {
+        // --variable values are layered over the document metadata
+        let (meta, mut diagnostics) = apply_variables(&pandoc.meta, &args.variables);
+        pandoc.meta = meta;
+
+        match render_with_bundle(&pandoc, &mut context, bundle, template_name, body_format) {
+            Ok((output, render_diagnostics)) => {
+                diagnostics.extend(render_diagnostics);
                 buf.extend_from_slice(output.as_bytes());
                 // Output any diagnostics (warnings)
-                if !diagnostics.is_empty() {
-                    if args.json_errors {
-                        for diagnostic in &diagnostics {
-                            eprintln!("{}", diagnostic.to_json());
-                        }
-                    } else {
-                        for diagnostic in &diagnostics {
-                            eprintln!("{}", diagnostic.to_text(Some(&context.source_context)));
-                        }
-                    }
-                }
+                messages.report_all(&diagnostics, &context.source_context);
                 Ok(())
             }
             Err(e) => Err(vec![
@@ -447,14 +1444,28 @@ fn main() {
             "json" => {
                 let json_config = writers::json::JsonConfig {
                     include_inline_locations: args
-                        .json_source_location
-                        .as_ref()
-                        .is_some_and(|s| s == "full"),
+                        .reader_extensions
+                        .is_enabled(Extension::Sourcepos)
+                        || args
+                            .json_source_location
+                            .as_ref()
+                            .is_some_and(|s| s == "full"),
+                    include_block_ids: args.reader_extensions.is_enabled(Extension::BlockIds),
                 };
                 writers::json::write_with_config(&pandoc, &context, &mut buf, &json_config)
             }
             "native" => writers::native::write(&pandoc, &context, &mut buf),
-            "markdown" | "qmd" => writers::qmd::write(&pandoc, &mut buf),
+            "markdown" | "qmd" => {
+                let config = qmd_config(args);
+                // Unchanged front matter and attributes are copied from qmd input
+                match args.from.as_str() {
+                    "markdown" | "qmd" => {
+                        writers::qmd::write_with_source(&pandoc, &config, input, &mut buf)
+                    }
+                    _ => writers::qmd::write_with_config(&pandoc, &config, &mut buf),
+                }
+            }
+            "ipynb" => writers::ipynb::write(&pandoc, &mut buf),
             "html" => {
                 // Check for section-divs: true in format.html.section-divs
                 let section_divs_enabled = should_sectionize(&pandoc.meta);
@@ -466,7 +1477,12 @@ fn main() {
                 } else {
                     pandoc.clone()
                 };
-                writers::html::write(&pandoc_to_write, &context, &mut buf).map_err(|e| {
+                let result = if args.reader_extensions.is_enabled(Extension::Sourcepos) {
+                    writers::html::write_with_source_tracking(&pandoc_to_write, &context, &mut buf)
+                } else {
+                    writers::html::write(&pandoc_to_write, &context, &mut buf)
+                };
+                result.map_err(|e| {
                     vec![
                         quarto_error_reporting::DiagnosticMessageBuilder::error(
                             "IO error during write",
@@ -477,53 +1493,226 @@ fn main() {
                     ]
                 })
             }
+            "revealjs" => writers::revealjs::write(&pandoc, &mut buf).map_err(|e| {
+                vec![
+                    quarto_error_reporting::DiagnosticMessageBuilder::error(
+                        "IO error during write",
+                    )
+                    .with_code("Q-3-1")
+                    .problem(format!("Failed to write reveal.js output: {}", e))
+                    .build(),
+                ]
+            }),
+            "latex" => writers::latex::write(&pandoc, &mut buf).map_err(|e| {
+                vec![
+                    quarto_error_reporting::DiagnosticMessageBuilder::error(
+                        "IO error during write",
+                    )
+                    .with_code("Q-3-1")
+                    .problem(format!("Failed to write LaTeX output: {}", e))
+                    .build(),
+                ]
+            }),
+            "docx" => {
+                let config = writers::docx::DocxConfig {
+                    reference_doc: resources.reference_doc.clone(),
+                    // Relative image paths are relative to the input file
+                    resource_dir: std::path::Path::new(input_filename)
+                        .parent()
+                        .map(|dir| dir.to_path_buf()),
+                };
+                writers::docx::write_with_config(&pandoc, &config, &mut buf).map_err(|e| {
+                    vec![
+                        quarto_error_reporting::DiagnosticMessageBuilder::error(
+                            "IO error during write",
+                        )
+                        .with_code("Q-3-1")
+                        .problem(format!("Failed to write DOCX output: {}", e))
+                        .build(),
+                    ]
+                })
+            }
+            "epub" => {
+                let config = writers::epub::EpubConfig {
+                    // Relative image, font and cover paths are relative to
+                    // the input file
+                    resource_dir: std::path::Path::new(input_filename)
+                        .parent()
+                        .map(|dir| dir.to_path_buf()),
+                    modified: utils::timestamp::build_time(args.deterministic),
+                };
+                writers::epub::write_with_config(&pandoc, &config, &mut buf).map_err(|e| {
+                    vec![
+                        quarto_error_reporting::DiagnosticMessageBuilder::error(
+                            "IO error during write",
+                        )
+                        .with_code("Q-3-1")
+                        .problem(format!("Failed to write EPUB output: {}", e))
+                        .build(),
+                    ]
+                })
+            }
+            "typst" => writers::typst::write(&pandoc, &mut buf).map_err(|e| {
+                vec![
+                    quarto_error_reporting::DiagnosticMessageBuilder::error(
+                        "IO error during write",
+                    )
+                    .with_code("Q-3-1")
+                    .problem(format!("Failed to write Typst output: {}", e))
+                    .build(),
+                ]
+            }),
             "plaintext" | "plain" => {
                 let (output, diagnostics) = writers::plaintext::blocks_to_string(&pandoc.blocks);
                 buf.extend_from_slice(output.as_bytes());
                 // Plaintext diagnostics are warnings (dropped nodes), not errors
                 // Output them but don't fail
-                if !diagnostics.is_empty() {
-                    if args.json_errors {
-                        for diagnostic in &diagnostics {
-                            eprintln!("{}", diagnostic.to_json());
-                        }
-                    } else {
-                        for diagnostic in &diagnostics {
-                            eprintln!("{}", diagnostic.to_text(Some(&context.source_context)));
-                        }
-                    }
-                }
+                messages.report_all(&diagnostics, &context.source_context);
                 Ok(())
             }
             #[cfg(feature = "terminal-support")]
+            "ansi" if args.deterministic => writers::ansi::write_with_config(
+                &pandoc,
+                &mut buf,
+                &writers::ansi::AnsiConfig::deterministic(),
+            ),
             "ansi" => writers::ansi::write(&pandoc, &mut buf),
-            _ => {
-                eprintln!("Unknown output format: {}", args.to);
-                std::process::exit(1);
-            }
+            _ => match resources.plugins.write(&args.to, &pandoc, &context) {
+                Some(Ok((output, diagnostics))) => {
+                    buf.extend_from_slice(&output);
+                    messages.report_all(&diagnostics, &context.source_context);
+                    Ok(())
+                }
+                Some(Err(diagnostics)) => Err(diagnostics),
+                None => {
+                    messages.error(format_args!("Unknown output format: {}", args.to));
+                    return Err(ConversionFailed);
+                }
+            },
         }
     };
 
+    tracer.record(write_start, trace::Phase::Write, &args.to, Vec::new());
+
     if let Err(diagnostics) = writer_result {
         // Format and output writer errors
-        if args.json_errors {
-            for diagnostic in diagnostics {
-                eprintln!("{}", diagnostic.to_json());
+        messages.report_all(&diagnostics, &context.source_context);
+        return Err(ConversionFailed);
+    }
+
+    if matches!(args.to.as_str(), "docx" | "epub") {
+        return Ok(buf);
+    }
+    if args.embed_resources {
+        if !matches!(args.to.as_str(), "html" | "revealjs") {
+            messages.error(format_args!(
+                "--embed-resources requires --to html or revealjs, got '{}'",
+                args.to
+            ));
+            return Err(ConversionFailed);
+        }
+        buf = embed_html_resources(buf, input_filename, &context.source_context, messages);
+    }
+    let same_format = matches!(args.from.as_str(), "markdown" | "qmd")
+        && matches!(args.to.as_str(), "markdown" | "qmd");
+    let output_style = line_endings(args).output_style(input_style, same_format);
+    Ok(utils::line_endings::restore(buf, output_style))
+}
+
+/// `--embed-resources`: `html` with the local files it refers to put in it,
+/// and a warning for each one that can't be read
+fn embed_html_resources(
+    html: Vec<u8>,
+    input_filename: &str,
+    source_context: &quarto_source_map::SourceContext,
+    messages: &mut Messages,
+) -> Vec<u8> {
+    let resource_dir = std::path::Path::new(input_filename)
+        .parent()
+        .unwrap_or(std::path::Path::new(""));
+    let embedded =
+        writers::html_embed::embed_resources(&String::from_utf8_lossy(&html), &mut |path| {
+            let path = std::path::Path::new(path);
+            if path.is_absolute() && path.is_file() {
+                return std::fs::read(path).ok();
             }
+            let relative = path.strip_prefix("/").unwrap_or(path);
+            std::fs::read(resource_dir.join(relative)).ok()
+        });
+    for url in &embedded.missing {
+        let warning = DiagnosticMessageBuilder::warning("Resource Not Embedded")
+            .with_code("Q-3-56")
+            .problem(format!(
+                "Could not read `{}` to embed it in the output",
+                url
+            ))
+            .add_info("The output refers to it by its URL instead")
+            .build();
+        messages.report(&warning, source_context);
+    }
+    embedded.html.into_bytes()
+}
+
+/// How the reader's extensions ask for heading identifiers to be made
+fn identifier_options(args: &Args) -> IdentifierOptions {
+    IdentifierOptions {
+        style: if args
+            .reader_extensions
+            .is_enabled(Extension::GfmAutoIdentifiers)
+        {
+            IdentifierStyle::Gfm
         } else {
-            for diagnostic in diagnostics {
-                eprintln!("{}", diagnostic.to_text(Some(&context.source_context)));
-            }
-        }
-        std::process::exit(1);
+            IdentifierStyle::Pandoc
+        },
+        ascii: args
+            .reader_extensions
+            .is_enabled(Extension::AsciiIdentifiers),
     }
+}
 
-    // Write output to file or stdout
-    if let Some(output_path) = args.output {
-        std::fs::write(&output_path, &buf)
-            .unwrap_or_else(|_| panic!("Failed to write output to file: {}", output_path));
-    } else {
-        let output = String::from_utf8(buf).expect("Invalid UTF-8 in output");
-        print!("{}", output);
+/// The `--line-endings` option
+fn line_endings(args: &Args) -> utils::line_endings::OutputLineEndings {
+    args.line_endings.parse().unwrap_or_default()
+}
+
+/// Apply filters in order, as `unified_filter::apply_filters` does, with a
+/// span for each filter when tracing
+fn apply_traced_filters(
+    pandoc: pandoc::Pandoc,
+    context: pandoc::ast_context::ASTContext,
+    filters: &[unified_filter::FilterSpec],
+    target_format: &str,
+    tracer: &mut trace::Tracer,
+) -> Result<
+    (
+        pandoc::Pandoc,
+        pandoc::ast_context::ASTContext,
+        Vec<quarto_error_reporting::DiagnosticMessage>,
+    ),
+    unified_filter::FilterError,
+> {
+    if !tracer.is_enabled() {
+        return unified_filter::apply_filters(pandoc, context, filters, target_format);
+    }
+
+    let mut current = (pandoc, context);
+    let mut all_diagnostics = Vec::new();
+    for filter in filters {
+        let before = current.clone();
+        let start = tracer.begin();
+        let (new_pandoc, new_context, diagnostics) =
+            unified_filter::apply_filter(current.0, current.1, filter, target_format)?;
+        let name = match filter {
+            unified_filter::FilterSpec::Citeproc => "citeproc".to_string(),
+            unified_filter::FilterSpec::Lua(path) | unified_filter::FilterSpec::Json(path) => {
+                path.display().to_string()
+            }
+        };
+        tracer.record_with(start, trace::Phase::Filter, &name, || {
+            trace::changed_block_locs(&before.0, &before.1, &new_pandoc, &new_context)
+        });
+        current = (new_pandoc, new_context);
+        all_diagnostics.extend(diagnostics);
     }
+    Ok((current.0, current.1, all_diagnostics))
 }
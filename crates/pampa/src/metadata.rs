@@ -0,0 +1,180 @@
+/*
+ * metadata.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Document metadata from outside the document.
+//!
+//! Metadata is merged in layers with Quarto's semantics: later layers win,
+//! maps merge key by key, and arrays concatenate unless a value is tagged
+//! `!prefer`. For a single document the layers are, lowest first:
+//!
+//! 1. Metadata files (`--metadata-file`), in the order given. Project and
+//!    profile metadata are layered the same way, before the document.
+//! 2. The document's own front matter
+//! 3. Metadata arguments (`--metadata KEY=VALUE`), which replace the
+//!    document's values instead of merging with them
+//!
+//! [`lookup`] reads a value by its dotted path, as in `format.html.toc`.
+
+use crate::pandoc::meta::yaml_to_config_value;
+use crate::utils::diagnostic_collector::DiagnosticCollector;
+use quarto_config::{
+    ConfigMapEntry, ConfigValue, ConfigValueKind, InterpretationContext, MergeOp, MergedConfig,
+};
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_source_map::{SourceContext, SourceInfo};
+use std::path::Path;
+
+/// Build a metadata layer from `--metadata KEY[=VALUE]` arguments.
+///
+/// As in Pandoc, `KEY:VALUE` works too, `true` and `false` become booleans,
+/// other values are literal strings, and a bare `KEY` is `true`. Repeating
+/// a key collects its values into a list. Every value is marked `!prefer`.
+pub fn metadata_args_to_config(args: &[String]) -> ConfigValue {
+    let mut entries: Vec<ConfigMapEntry> = Vec::new();
+
+    for arg in args {
+        let (key, value) = match arg.find(['=', ':']) {
+            Some(index) => {
+                let value = match &arg[index + 1..] {
+                    "true" => ConfigValue::new_bool(true, SourceInfo::default()),
+                    "false" => ConfigValue::new_bool(false, SourceInfo::default()),
+                    value => ConfigValue::new_string(value, SourceInfo::default()),
+                };
+                (&arg[..index], value)
+            }
+            None => (
+                arg.as_str(),
+                ConfigValue::new_bool(true, SourceInfo::default()),
+            ),
+        };
+
+        match entries.iter_mut().find(|e| e.key == key) {
+            Some(entry) => match &mut entry.value.value {
+                ConfigValueKind::Array(items) => items.push(value),
+                _ => {
+                    let first = entry.value.clone().with_merge_op(MergeOp::Concat);
+                    entry.value = ConfigValue::new_array(vec![first, value], SourceInfo::default())
+                        .with_merge_op(MergeOp::Prefer);
+                }
+            },
+            None => entries.push(ConfigMapEntry {
+                key: key.to_string(),
+                key_source: SourceInfo::default(),
+                value: value.with_merge_op(MergeOp::Prefer),
+            }),
+        }
+    }
+
+    ConfigValue::new_map(entries, SourceInfo::default())
+}
+
+/// A metadata layer that sets `key` to `value`, replacing the document's
+/// value instead of merging with it.
+pub fn override_layer(key: &str, value: ConfigValue) -> ConfigValue {
+    ConfigValue::new_map(
+        vec![ConfigMapEntry {
+            key: key.to_string(),
+            key_source: SourceInfo::default(),
+            value: value.with_merge_op(MergeOp::Prefer),
+        }],
+        SourceInfo::default(),
+    )
+}
+
+/// Read a YAML or JSON metadata file into a metadata layer.
+///
+/// The file is added to `source_context`, so diagnostics about its values
+/// point into it. Strings are read as markdown, as in front matter.
+pub fn read_metadata_file(
+    path: &Path,
+    source_context: &mut SourceContext,
+) -> Result<ConfigValue, Vec<DiagnosticMessage>> {
+    let content = std::fs::read_to_string(path).map_err(|err| {
+        vec![
+            DiagnosticMessageBuilder::error("Metadata File Error")
+                .with_code("Q-1-29")
+                .problem(format!("Could not read `{}`: {}", path.display(), err))
+                .build(),
+        ]
+    })?;
+
+    let file_id =
+        source_context.add_file(path.to_string_lossy().to_string(), Some(content.clone()));
+    let file_source = SourceInfo::original(file_id, 0, content.len());
+    let yaml = quarto_yaml::parse_with_parent(&content, file_source.clone()).map_err(|err| {
+        vec![
+            DiagnosticMessageBuilder::error("Metadata File Error")
+                .with_code("Q-1-29")
+                .with_location(file_source.clone())
+                .problem(format!("Could not parse `{}`: {}", path.display(), err))
+                .build(),
+        ]
+    })?;
+
+    let mut diagnostics = DiagnosticCollector::new();
+    let config = yaml_to_config_value(
+        yaml,
+        InterpretationContext::DocumentMetadata,
+        &mut diagnostics,
+    );
+    if diagnostics.has_errors() {
+        return Err(diagnostics.into_diagnostics());
+    }
+    match config.value {
+        ConfigValueKind::Map(_) => Ok(config),
+        // An empty file has no metadata
+        ConfigValueKind::Scalar(yaml_rust2::Yaml::Null) => {
+            Ok(ConfigValue::new_map(Vec::new(), file_source))
+        }
+        _ => Err(vec![
+            DiagnosticMessageBuilder::error("Metadata File Error")
+                .with_code("Q-1-29")
+                .with_location(config.source_info)
+                .problem(format!(
+                    "`{}` does not hold a map of metadata",
+                    path.display()
+                ))
+                .add_hint("Metadata files hold key-value pairs, like front matter")
+                .build(),
+        ]),
+    }
+}
+
+/// Merge metadata layers, later layers taking priority.
+pub fn merge_metadata(layers: &[&ConfigValue]) -> Result<ConfigValue, DiagnosticMessage> {
+    MergedConfig::new(layers.to_vec())
+        .materialize()
+        .map_err(|e| {
+            DiagnosticMessageBuilder::error("Config merge failed")
+                .with_code("Q-1-30")
+                .problem(format!("Failed to merge metadata: {}", e))
+                .build()
+        })
+}
+
+/// Layer metadata files under and `--metadata` arguments over document
+/// metadata. Without either, the document metadata is returned as is.
+pub fn apply_metadata(
+    meta: &ConfigValue,
+    files: &[ConfigValue],
+    args: &[String],
+) -> Result<ConfigValue, DiagnosticMessage> {
+    if files.is_empty() && args.is_empty() {
+        return Ok(meta.clone());
+    }
+    let overrides = metadata_args_to_config(args);
+    let layers: Vec<&ConfigValue> = files.iter().chain([meta, &overrides]).collect();
+    merge_metadata(&layers)
+}
+
+/// Look up a metadata value by dotted path, such as `format.html.toc`.
+/// Numeric segments index into lists, as in `author.0`.
+pub fn lookup<'a>(meta: &'a ConfigValue, path: &str) -> Option<&'a ConfigValue> {
+    path.split('.')
+        .try_fold(meta, |value, segment| match &value.value {
+            ConfigValueKind::Array(items) => items.get(segment.parse::<usize>().ok()?),
+            _ => value.get(segment),
+        })
+}
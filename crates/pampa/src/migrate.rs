@@ -0,0 +1,124 @@
+/*
+ * migrate.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa migrate`: convert R Markdown files to Quarto.
+//!
+//! Each `.Rmd` file is converted with `rmarkdown::migrate` and written next
+//! to it as a `.qmd` file, which is never replaced unless `--force` is
+//! given. Everything the conversion couldn't translate is reported as a
+//! Q-7-2 warning located in the `.Rmd` file, so that with `--json-errors`
+//! the report of a migration can be kept and reviewed. Files keep their
+//! line endings and byte order mark unless `--line-endings` says otherwise.
+
+use super::{Args, ConversionFailed, Messages, line_endings};
+use crate::rmarkdown;
+use crate::utils::line_endings::{TextStyle, normalize, restore};
+use std::io::{Read, Write};
+use std::path::{Path, PathBuf};
+
+/// Convert the files (or stdin) and return the exit code: 0 when
+/// everything was translated, 1 when something wasn't, 2 on errors
+pub fn run(args: &Args, files: &[PathBuf], dry_run: bool, force: bool) -> i32 {
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    if files.is_empty() {
+        let mut input = String::new();
+        if let Err(e) = std::io::stdin().read_to_string(&mut input) {
+            messages.error(format_args!("Failed to read from stdin: {}", e));
+            return 2;
+        }
+        let (text, complete) = migrate(args, "<stdin>", &input, &mut messages);
+        if !dry_run {
+            let _ = messages.stdout.write_all(text.as_bytes());
+        }
+        return if complete { 0 } else { 1 };
+    }
+
+    let mut failed = false;
+    let mut incomplete = 0;
+    for path in files {
+        match migrate_file(args, path, dry_run, force, &mut messages) {
+            Ok(true) => {}
+            Ok(false) => incomplete += 1,
+            Err(ConversionFailed) => failed = true,
+        }
+    }
+    if failed {
+        2
+    } else if incomplete > 0 {
+        messages.error(format_args!(
+            "{} of {} files have R Markdown that was not translated",
+            incomplete,
+            files.len()
+        ));
+        1
+    } else {
+        0
+    }
+}
+
+/// Convert a file to the `.qmd` file next to it, or with `dry_run` only
+/// report. Returns whether everything was translated.
+fn migrate_file(
+    args: &Args,
+    path: &Path,
+    dry_run: bool,
+    force: bool,
+    messages: &mut Messages,
+) -> Result<bool, ConversionFailed> {
+    let filename = path.to_string_lossy();
+    let target = path.with_extension("qmd");
+    if target == path {
+        messages.error(format_args!("'{}' is already a .qmd file", filename));
+        return Err(ConversionFailed);
+    }
+    if !dry_run && !force && target.exists() {
+        messages.error(format_args!(
+            "Not replacing '{}'; use --force to replace it",
+            target.display()
+        ));
+        return Err(ConversionFailed);
+    }
+    let input = std::fs::read_to_string(path).map_err(|e| {
+        messages.error(format_args!("Failed to read '{}': {}", filename, e));
+        ConversionFailed
+    })?;
+    let (text, complete) = migrate(args, &filename, &input, messages);
+    if !dry_run {
+        std::fs::write(&target, text).map_err(|e| {
+            messages.error(format_args!(
+                "Failed to write '{}': {}",
+                target.display(),
+                e
+            ));
+            ConversionFailed
+        })?;
+    }
+    let _ = writeln!(messages.stdout, "{} -> {}", filename, target.display());
+    Ok(complete)
+}
+
+/// The Quarto text of R Markdown source, and whether everything was
+/// translated. What wasn't is reported.
+fn migrate(args: &Args, filename: &str, input: &str, messages: &mut Messages) -> (String, bool) {
+    let style = line_endings(args).output_style(TextStyle::detect(input), true);
+    let normalized_input = normalize(input);
+    let migration = rmarkdown::migrate(&normalized_input);
+
+    let mut source_context = quarto_source_map::SourceContext::new();
+    let file_id = source_context.add_file(filename.to_string(), Some(normalized_input.to_string()));
+    messages.report_all(&migration.diagnostics(file_id), &source_context);
+
+    let text = String::from_utf8(restore(migration.text.into_bytes(), style))
+        .expect("restoring line endings keeps UTF-8");
+    (text, migration.untranslated.is_empty())
+}
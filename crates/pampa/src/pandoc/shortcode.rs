@@ -10,6 +10,7 @@
 use crate::pandoc::location::empty_source_info;
 use hashlink::LinkedHashMap;
 use quarto_pandoc_types::{AttrSourceInfo, Inline, Inlines, Shortcode, ShortcodeArg, Span};
+use std::collections::HashMap;
 
 fn shortcode_value_span(str: String) -> Inline {
     let mut attr_hash = LinkedHashMap::new();
@@ -53,8 +54,25 @@ fn shortcode_key_value_span(key: String, value: String) -> Inline {
     })
 }
 
+/// Keyword arguments sorted by key, so that every rendering of a shortcode
+/// lists them in the same order.
+fn sorted_args(args: HashMap<String, ShortcodeArg>) -> Vec<(String, ShortcodeArg)> {
+    let mut args: Vec<(String, ShortcodeArg)> = args.into_iter().collect();
+    args.sort_by(|(a, _), (b, _)| a.cmp(b));
+    args
+}
+
+/// Convert a shortcode to the span encoding used in native and JSON output.
+///
+/// The outer span has class `quarto-shortcode__` and carries the shortcode
+/// source in `data-raw`; each parameter is a `quarto-shortcode__-param` span
+/// whose first entry is the name. [`span_to_shortcode`] reverses this.
 pub fn shortcode_to_span(shortcode: Shortcode) -> Span {
     let mut attr_hash = LinkedHashMap::new();
+    attr_hash.insert("data-raw".to_string(), shortcode_to_qmd(&shortcode));
+    if shortcode.is_escaped {
+        attr_hash.insert("data-is-escaped".to_string(), "1".to_string());
+    }
     let mut content: Inlines = vec![shortcode_value_span(shortcode.name)];
     for arg in shortcode.positional_args {
         match arg {
@@ -75,7 +93,7 @@ pub fn shortcode_to_span(shortcode: Shortcode) -> Span {
                 content.push(Inline::Span(shortcode_to_span(inner_shortcode)));
             }
             ShortcodeArg::KeyValue(spec) => {
-                for (key, value) in spec {
+                for (key, value) in sorted_args(spec) {
                     match value {
                         ShortcodeArg::String(text) => {
                             content.push(shortcode_key_value_span(key, text));
@@ -106,7 +124,7 @@ pub fn shortcode_to_span(shortcode: Shortcode) -> Span {
         }
     }
     // Process keyword arguments from the keyword_args HashMap
-    for (key, value) in shortcode.keyword_args {
+    for (key, value) in sorted_args(shortcode.keyword_args) {
         match value {
             ShortcodeArg::String(text) => {
                 content.push(shortcode_key_value_span(key, text));
@@ -147,6 +165,107 @@ pub fn shortcode_to_span(shortcode: Shortcode) -> Span {
     }
 }
 
+/// Rebuild a shortcode from the span encoding produced by [`shortcode_to_span`].
+///
+/// Returns `None` if the span is not a shortcode span. The encoding stores
+/// every value as a string, so numbers and booleans come back as
+/// `ShortcodeArg::String`.
+pub fn span_to_shortcode(span: &Span) -> Option<Shortcode> {
+    if !span
+        .attr
+        .1
+        .iter()
+        .any(|class| class == "quarto-shortcode__")
+    {
+        return None;
+    }
+    let mut params = span.content.iter();
+    let name = match params.next()? {
+        Inline::Span(param) => param.attr.2.get("data-value")?.clone(),
+        _ => return None,
+    };
+    let mut positional_args = Vec::new();
+    let mut keyword_args = HashMap::new();
+    for param in params {
+        let Inline::Span(param) = param else {
+            return None;
+        };
+        if let Some(inner) = span_to_shortcode(param) {
+            positional_args.push(ShortcodeArg::Shortcode(inner));
+            continue;
+        }
+        let value = ShortcodeArg::String(param.attr.2.get("data-value")?.clone());
+        match param.attr.2.get("data-key") {
+            Some(key) => {
+                keyword_args.insert(key.clone(), value);
+            }
+            None => positional_args.push(value),
+        }
+    }
+    Some(Shortcode {
+        is_escaped: span.attr.2.get("data-is-escaped").is_some_and(|v| v == "1"),
+        name,
+        positional_args,
+        keyword_args,
+        source_info: span.source_info.clone(),
+    })
+}
+
+/// Render a shortcode in qmd syntax, e.g. `{{< video src="a b.mp4" >}}`.
+pub fn shortcode_to_qmd(shortcode: &Shortcode) -> String {
+    let (open, close) = if shortcode.is_escaped {
+        ("{{{<", ">}}}")
+    } else {
+        ("{{<", ">}}")
+    };
+    let mut parts = vec![shortcode.name.clone()];
+    for arg in &shortcode.positional_args {
+        parts.push(shortcode_arg_to_qmd(arg));
+    }
+    for (key, value) in sorted_args(shortcode.keyword_args.clone()) {
+        parts.push(keyword_arg_to_qmd(&key, &value));
+    }
+    format!("{} {} {}", open, parts.join(" "), close)
+}
+
+fn shortcode_arg_to_qmd(arg: &ShortcodeArg) -> String {
+    match arg {
+        ShortcodeArg::String(text) => quote_shortcode_string(text, true),
+        ShortcodeArg::Number(num) => num.to_string(),
+        ShortcodeArg::Boolean(b) => b.to_string(),
+        ShortcodeArg::Shortcode(inner) => shortcode_to_qmd(inner),
+        ShortcodeArg::KeyValue(spec) => sorted_args(spec.clone())
+            .iter()
+            .map(|(key, value)| keyword_arg_to_qmd(key, value))
+            .collect::<Vec<_>>()
+            .join(" "),
+    }
+}
+
+fn keyword_arg_to_qmd(key: &str, value: &ShortcodeArg) -> String {
+    match value {
+        // Keyword values are always read as strings, so numbers can stay bare
+        ShortcodeArg::String(text) => format!("{}={}", key, quote_shortcode_string(text, false)),
+        _ => format!("{}={}", key, shortcode_arg_to_qmd(value)),
+    }
+}
+
+/// Strings are written bare when the grammar would read them back as the
+/// same naked string, and double-quoted otherwise. Positional number-like
+/// strings are quoted so they don't come back as numbers.
+fn quote_shortcode_string(text: &str, quote_numbers: bool) -> String {
+    let is_naked = !text.is_empty()
+        && text
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || "_.~:/?#[]@!$%&()+,;-".contains(c))
+        && !(quote_numbers && text.parse::<f64>().is_ok());
+    if is_naked {
+        text.to_string()
+    } else {
+        format!("\"{}\"", text.replace('"', "\\\""))
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -17,9 +17,11 @@ use crate::pandoc::treesitter_utils::editorial_marks::{
 };
 use crate::pandoc::treesitter_utils::fenced_code_block::process_fenced_code_block;
 use crate::pandoc::treesitter_utils::fenced_div_block::process_fenced_div_block;
+use crate::pandoc::treesitter_utils::grid_table::transform_grid_tables;
 use crate::pandoc::treesitter_utils::info_string::process_info_string;
 use crate::pandoc::treesitter_utils::language_specifier::process_language_specifier;
 use crate::pandoc::treesitter_utils::list_marker::process_list_marker;
+use crate::pandoc::treesitter_utils::multiline_table::transform_multiline_tables;
 use crate::pandoc::treesitter_utils::note_definition_fenced_block::process_note_definition_fenced_block;
 use crate::pandoc::treesitter_utils::note_definition_para::process_note_definition_para;
 use crate::pandoc::treesitter_utils::numeric_character_reference::process_numeric_character_reference;
@@ -28,7 +30,9 @@ use crate::pandoc::treesitter_utils::pipe_table::{
     process_pipe_table, process_pipe_table_cell, process_pipe_table_delimiter_cell,
     process_pipe_table_delimiter_row, process_pipe_table_header_or_row,
 };
-use crate::pandoc::treesitter_utils::postprocess::{merge_strs, postprocess};
+use crate::pandoc::treesitter_utils::postprocess::{
+    merge_strs, postprocess, transform_definition_list_captions,
+};
 use crate::pandoc::treesitter_utils::quote_helpers::process_quoted;
 use crate::pandoc::treesitter_utils::section::process_section;
 use crate::pandoc::treesitter_utils::shortcode::{
@@ -43,6 +47,7 @@ use crate::pandoc::treesitter_utils::thematic_break::process_thematic_break;
 use crate::pandoc::treesitter_utils::uri_autolink::process_uri_autolink;
 use quarto_error_reporting::DiagnosticMessageBuilder;
 
+use crate::pandoc::Annotation;
 use crate::pandoc::ast_context::ASTContext;
 use crate::pandoc::attr::AttrSourceInfo;
 use crate::pandoc::block::{Block, Blocks, BulletList, OrderedList, Paragraph, Plain, RawBlock};
@@ -55,6 +60,7 @@ use crate::pandoc::location::{node_location, node_source_info_with_context};
 use crate::pandoc::pandoc::Pandoc;
 use core::panic;
 use once_cell::sync::Lazy;
+use quarto_source_map::SourceInfo;
 use regex::Regex;
 use std::io::Write;
 
@@ -517,11 +523,22 @@ fn native_visitor<T: Write>(
             let full_text = node.utf8_text(input_bytes).unwrap();
             let content = &full_text[1..full_text.len() - 1]; // Strip leading and trailing $
 
-            PandocNativeIntermediate::IntermediateInline(Inline::Math(Math {
-                math_type: MathType::InlineMath,
-                text: content.to_string(),
-                source_info: node_source_info_with_context(node, context),
-            }))
+            // As in Pandoc's tex_math_dollars, a closing `$` followed by a
+            // digit doesn't end math, so prices like `$5/$10` stay text
+            if input_bytes
+                .get(node.end_byte())
+                .is_some_and(u8::is_ascii_digit)
+            {
+                PandocNativeIntermediate::IntermediateInlines(literal_dollar_inlines(
+                    node, full_text, context,
+                ))
+            } else {
+                PandocNativeIntermediate::IntermediateInline(Inline::Math(Math {
+                    math_type: MathType::InlineMath,
+                    text: content.to_string(),
+                    source_info: node_source_info_with_context(node, context),
+                }))
+            }
         }
         "pandoc_display_math" => {
             // Extract display math content (text between $$ delimiters)
@@ -538,10 +555,15 @@ fn native_visitor<T: Write>(
         }
         "pandoc_str" => {
             let text = node.utf8_text(input_bytes).unwrap().to_string();
-            // Process backslash escapes first, then apply smart quotes
-            let text = process_backslash_escapes(text);
+            // An escape is a token of its own, and an escaped `\'` stays a
+            // straight apostrophe, as in Pandoc
+            let text = if text.starts_with('\\') {
+                process_backslash_escapes(text)
+            } else {
+                apply_smart_quotes(text)
+            };
             PandocNativeIntermediate::IntermediateInline(Inline::Str(Str {
-                text: apply_smart_quotes(text),
+                text,
                 source_info: node_source_info_with_context(node, context),
             }))
         }
@@ -756,6 +778,7 @@ fn native_visitor<T: Write>(
 
             // Wrap inlines in a Paragraph block, then wrap in Note inline
             PandocNativeIntermediate::IntermediateInline(Inline::Note(Note {
+                id: None,
                 content: vec![Block::Paragraph(Paragraph {
                     content: inlines,
                     source_info: node_source_info_with_context(node, context),
@@ -1069,14 +1092,14 @@ fn native_visitor<T: Write>(
             result
         }
         "pandoc_div" => process_fenced_div_block(node, children, context),
-        "pipe_table_delimiter_cell" => process_pipe_table_delimiter_cell(children, context),
+        "pipe_table_delimiter_cell" => process_pipe_table_delimiter_cell(node, children, context),
         "pipe_table_header" | "pipe_table_row" => {
             process_pipe_table_header_or_row(node, children, context)
         }
         "pipe_table_delimiter_row" => process_pipe_table_delimiter_row(children, context),
         "pipe_table_cell" => process_pipe_table_cell(node, children, context),
         "caption" => process_caption(node, children, context),
-        "pipe_table" => process_pipe_table(node, children, context),
+        "pipe_table" => process_pipe_table(node, children, input_bytes, context),
         "comment" => {
             // HTML comments (<!-- ... -->) are preserved as RawInline(html)
             // so they survive round-tripping through the AST and are not lost
@@ -1087,11 +1110,42 @@ fn native_visitor<T: Write>(
             // maintain correct spacing (e.g., "Hello <!-- comment --> world"
             // — the space before <!-- is part of the comment node).
             let text = node.utf8_text(input_bytes).unwrap().to_string();
-            PandocNativeIntermediate::IntermediateInline(Inline::RawInline(RawInline {
-                format: "html".to_string(),
-                text,
-                source_info: node_source_info_with_context(node, context),
-            }))
+            let source_info = node_source_info_with_context(node, context);
+            if let Some(annotation) = Annotation::parse_comment(&text) {
+                // Editor annotations (`<!-- #cmt: ... -->`) become structured
+                // nodes. The whitespace around the comment is kept as a Space
+                // or SoftBreak.
+                let start = text.len() - text.trim_start().len();
+                let end = text.trim_end().len();
+                let whitespace = |range: std::ops::Range<usize>| {
+                    let source_info =
+                        SourceInfo::substring(source_info.clone(), range.start, range.end);
+                    if text[range].contains('\n') {
+                        Inline::SoftBreak(SoftBreak { source_info })
+                    } else {
+                        Inline::Space(Space { source_info })
+                    }
+                };
+                let mut result = Vec::new();
+                if start > 0 {
+                    result.push(whitespace(0..start));
+                }
+                result.push(Inline::Custom(annotation.to_custom(SourceInfo::substring(
+                    source_info.clone(),
+                    start,
+                    end,
+                ))));
+                if end < text.len() {
+                    result.push(whitespace(end..text.len()));
+                }
+                PandocNativeIntermediate::IntermediateInlines(result)
+            } else {
+                PandocNativeIntermediate::IntermediateInline(Inline::RawInline(RawInline {
+                    format: "html".to_string(),
+                    text,
+                    source_info,
+                }))
+            }
         }
         "html_element" => {
             // Extract the text from the HTML element node
@@ -1246,6 +1300,11 @@ pub fn treesitter_to_pandoc<T: Write>(
         );
         return Err(vec![diagnostic]);
     };
+    // Before postprocess, which attaches captions to tables
+    let pandoc = transform_grid_tables(pandoc, input_bytes, context, error_collector);
+    let pandoc = transform_multiline_tables(pandoc, input_bytes, context, error_collector);
+    // Before postprocess, which takes the remaining captions for tables
+    let pandoc = transform_definition_list_captions(pandoc, input_bytes);
     let result = match postprocess(pandoc, error_collector) {
         Ok(doc) => doc,
         Err(()) => {
@@ -7,6 +7,7 @@
  */
 
 use super::pandocnativeintermediate::PandocNativeIntermediate;
+use crate::pandoc::InlineCell;
 use crate::pandoc::ast_context::ASTContext;
 use crate::pandoc::attr::{Attr, AttrSourceInfo, empty_attr};
 use crate::pandoc::inline::{Code, Inline, Space};
@@ -84,8 +85,24 @@ pub fn process_pandoc_code_span(
         trimmed_code_text = format!("{} {}", lang, trimmed_code_text);
     }
 
+    // An inline code cell (`{python} x`, or `x`{python} with the language
+    // after the span) is a custom node
+    let cell = match attr.1.first() {
+        _ if raw_format.is_some() => None,
+        Some(class) if class.starts_with('{') && class.ends_with('}') => {
+            InlineCell::parse_code(&format!("{} {}", class, trimmed_code_text)).map(|cell| {
+                let mut cell_attr = attr.clone();
+                cell_attr.1.remove(0);
+                (cell, cell_attr)
+            })
+        }
+        _ => InlineCell::parse_code(&trimmed_code_text).map(|cell| (cell, attr.clone())),
+    };
+
     // Create Code or RawInline based on presence of raw format
-    let code = if let Some(format) = raw_format {
+    let code = if let Some((cell, cell_attr)) = cell {
+        Inline::Custom(cell.to_custom(cell_attr, node_source_info_with_context(node, context)))
+    } else if let Some(format) = raw_format {
         Inline::RawInline(crate::pandoc::inline::RawInline {
             format,
             text: trimmed_code_text,
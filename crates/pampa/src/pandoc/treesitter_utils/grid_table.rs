@@ -0,0 +1,557 @@
+/*
+ * grid_table.rs
+ *
+ * Functions for reading Pandoc grid tables.
+ *
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use crate::filter_context::FilterContext;
+use crate::filters::{
+    Filter, FilterReturn::FilterResult, FilterReturn::Unchanged, topdown_traverse,
+};
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::attr::{AttrSourceInfo, empty_attr};
+use crate::pandoc::block::{Block, Blocks, Paragraph, Plain};
+use crate::pandoc::caption::Caption;
+use crate::pandoc::table::{
+    Alignment, Cell, ColSpec, ColWidth, Row, Table, TableBody, TableFoot, TableHead,
+};
+use crate::readers;
+use crate::utils::diagnostic_collector::DiagnosticCollector;
+use quarto_source_map::SourceInfo;
+
+/// Page width that grid table column widths are relative to, as in Pandoc
+/// (its default `--columns`)
+pub const GRID_TABLE_COLUMNS: usize = 72;
+
+/// Read Pandoc grid tables.
+///
+/// The block grammar has no grid tables: a table such as
+///
+/// ```markdown
+/// +-------+-----------------+
+/// | Fruit | Notes           |
+/// +=======+=================+
+/// | Apple | - crisp         |
+/// |       | - sweet         |
+/// +-------+-----------------+
+/// ```
+///
+/// has no blank lines, so it reads as one paragraph. This pass reads the
+/// source of each paragraph that starts with a `+-` border line again as a
+/// grid table, and replaces the paragraph with the Table when it is one:
+///
+/// - Cells are traced along their borders, so a cell can span several
+///   rows or columns by leaving out the borders inside it
+/// - A border of `=` separates the header rows from the body. A second one,
+///   with a `=` bottom border, separates the body from footer rows
+/// - Colons in the header separator (or the top border, when there is no
+///   header) set column alignments, as in pipe tables
+/// - As in Pandoc, column widths are their source widths relative to a
+///   72-column page, or to the table when it is wider
+/// - Each cell's text is read as qmd, so cells hold block content. A cell
+///   that reads as a single paragraph holds a Plain, as in pipe tables
+///
+/// Cells are read with the paragraph as their parent source, starting at
+/// their first line, so locations are exact for one-line cells only.
+/// Errors reading a cell are errors of the document.
+///
+/// INCREMENTAL WRITER COUPLING: the incremental writer always fully rewrites
+/// Table blocks, so the writer's choice of table syntax is free.
+pub fn transform_grid_tables(
+    doc: Pandoc,
+    input_bytes: &[u8],
+    context: &ASTContext,
+    error_collector: &mut DiagnosticCollector,
+) -> Pandoc {
+    let mut filter = Filter::new().with_blocks(|blocks, _ctx| {
+        if !blocks
+            .iter()
+            .any(|block| starts_grid_table(block, input_bytes, context))
+        {
+            return Unchanged(blocks);
+        }
+        let blocks = blocks
+            .into_iter()
+            .map(|block| match block {
+                Block::Paragraph(para) if starts_grid_table_text(&para, input_bytes, context) => {
+                    match read_grid_table(&para, input_bytes, context, error_collector) {
+                        Some(table) => Block::Table(table),
+                        None => Block::Paragraph(para),
+                    }
+                }
+                block => block,
+            })
+            .collect();
+        FilterResult(blocks, true)
+    });
+    let mut ctx = FilterContext::new();
+    topdown_traverse(doc, &mut filter, &mut ctx)
+}
+
+fn starts_grid_table(block: &Block, input_bytes: &[u8], context: &ASTContext) -> bool {
+    matches!(block, Block::Paragraph(para) if starts_grid_table_text(para, input_bytes, context))
+}
+
+fn starts_grid_table_text(para: &Paragraph, input_bytes: &[u8], context: &ASTContext) -> bool {
+    if !is_from_input(&para.source_info, context) {
+        return false;
+    }
+    let start = para.source_info.start_offset().min(input_bytes.len());
+    input_bytes[start..].starts_with(b"+-") || input_bytes[start..].starts_with(b"+:")
+}
+
+/// Whether a block's source is in `input_bytes`, the input being read.
+/// Cells of tables read here were read on their own, from other input.
+pub(super) fn is_from_input(source_info: &SourceInfo, context: &ASTContext) -> bool {
+    match (source_info, &context.parent_source_info) {
+        (SourceInfo::Original { .. }, None) => true,
+        (SourceInfo::Substring { parent, .. }, Some(context_parent)) => **parent == *context_parent,
+        _ => false,
+    }
+}
+
+/// A line of the table, without the prefix before the table's first column
+struct GridLine {
+    text: Vec<char>,
+    /// Byte offset of each character in the input, and of the line's end
+    offsets: Vec<usize>,
+}
+
+impl GridLine {
+    fn char_at(&self, column: usize) -> char {
+        self.text.get(column).copied().unwrap_or(' ')
+    }
+
+    /// Byte offset of a column, or of the line's end past it
+    fn offset_at(&self, column: usize) -> usize {
+        self.offsets
+            .get(column)
+            .or(self.offsets.last())
+            .copied()
+            .unwrap_or(0)
+    }
+
+    /// Whether the line is a border across the whole table made of `=`,
+    /// which separates the header and the footer from the body
+    fn is_part_separator(&self) -> bool {
+        let text = self.text.iter().collect::<String>();
+        let text = text.trim_end();
+        text.starts_with('+')
+            && text.ends_with('+')
+            && text.contains('=')
+            && text.chars().all(|c| matches!(c, '+' | '=' | ':'))
+    }
+
+    /// Alignment of the column between two boundaries of a border line
+    fn alignment(&self, start: usize, end: usize) -> Alignment {
+        match (self.char_at(start + 1) == ':', self.char_at(end - 1) == ':') {
+            (true, true) => Alignment::Center,
+            (true, false) => Alignment::Left,
+            (false, true) => Alignment::Right,
+            (false, false) => Alignment::Default,
+        }
+    }
+}
+
+/// Split a paragraph's source into lines, dropping from every line the
+/// prefix (indentation, block quote markers) before the paragraph's first
+/// column
+fn grid_lines(para: &Paragraph, input_bytes: &[u8]) -> Option<Vec<GridLine>> {
+    let start = para.source_info.start_offset();
+    let end = para.source_info.end_offset().min(input_bytes.len());
+    let text = std::str::from_utf8(input_bytes.get(start..end)?).ok()?;
+    let line_start = input_bytes[..start]
+        .iter()
+        .rposition(|&byte| byte == b'\n')
+        .map_or(0, |pos| pos + 1);
+    let prefix_chars = std::str::from_utf8(&input_bytes[line_start..start])
+        .ok()?
+        .chars()
+        .count();
+
+    let mut lines = Vec::new();
+    let mut offset = start;
+    for (i, line) in text.split('\n').enumerate() {
+        let line_offset = offset;
+        offset += line.len() + 1;
+        let line = line.strip_suffix('\r').unwrap_or(line);
+        if line.trim().is_empty() {
+            continue;
+        }
+        let skip = if i == 0 { 0 } else { prefix_chars };
+        let mut chars = Vec::new();
+        let mut offsets = Vec::new();
+        for (byte_index, c) in line.char_indices().skip(skip) {
+            chars.push(c);
+            offsets.push(line_offset + byte_index);
+        }
+        offsets.push(line_offset + line.len());
+        lines.push(GridLine {
+            text: chars,
+            offsets,
+        });
+    }
+    Some(lines)
+}
+
+/// A cell's rectangle: the lines of its top and bottom borders, and the
+/// columns of its left and right borders
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct GridCell {
+    top: usize,
+    bottom: usize,
+    left: usize,
+    right: usize,
+}
+
+fn is_horizontal_border(c: char) -> bool {
+    matches!(c, '-' | '=' | ':' | '+')
+}
+
+fn is_vertical_border(c: char) -> bool {
+    matches!(c, '|' | '+')
+}
+
+/// Trace the cell whose top left corner is at (`top`, `left`): along its
+/// top border to the first corner with a border below it, then down to the
+/// first corner with a border to its left
+fn trace_cell(lines: &[GridLine], top: usize, left: usize) -> Option<GridCell> {
+    let top_line = &lines[top];
+    let below = lines.get(top + 1)?;
+    let mut right = left + 1;
+    loop {
+        let c = top_line.char_at(right);
+        if !is_horizontal_border(c) {
+            return None;
+        }
+        if c == '+' && is_vertical_border(below.char_at(right)) {
+            break;
+        }
+        right += 1;
+    }
+    let mut bottom = top + 1;
+    loop {
+        let line = lines.get(bottom)?;
+        let c = line.char_at(right);
+        if !is_vertical_border(c) {
+            return None;
+        }
+        if c == '+' && is_horizontal_border(line.char_at(right - 1)) {
+            break;
+        }
+        bottom += 1;
+    }
+    if bottom == top + 1 {
+        // No content lines
+        return None;
+    }
+    let left_edge = (top..=bottom).all(|i| is_vertical_border(lines[i].char_at(left)));
+    let bottom_edge = (left..=right).all(|j| is_horizontal_border(lines[bottom].char_at(j)));
+    (left_edge && bottom_edge).then_some(GridCell {
+        top,
+        bottom,
+        left,
+        right,
+    })
+}
+
+/// The structure of a grid table's lines
+struct GridLayout {
+    /// Columns of the column boundaries
+    column_boundaries: Vec<usize>,
+    /// Lines of the row boundaries
+    row_boundaries: Vec<usize>,
+    /// Cells in reading order
+    cells: Vec<GridCell>,
+    alignments: Vec<Alignment>,
+    /// Lines of the `=` borders ending the header and starting the footer
+    header_end: Option<usize>,
+    footer_start: Option<usize>,
+}
+
+/// Find the cells of a grid table, starting from the top left corner and
+/// following each cell's top right and bottom left corners. Cells may span
+/// several rows or columns; a layout where the cells don't tile the table
+/// is not a grid table.
+fn grid_layout(lines: &[GridLine]) -> Option<GridLayout> {
+    let top = lines.first()?;
+    if lines.len() < 3 || top.char_at(0) != '+' {
+        return None;
+    }
+    let width = top.text.iter().rposition(|c| *c == '+')?;
+    let bottom = lines.len() - 1;
+
+    let mut cells = Vec::new();
+    let mut corners = vec![(0, 0)];
+    let mut visited = std::collections::HashSet::new();
+    while let Some((top, left)) = corners.pop() {
+        if top >= bottom || left >= width || !visited.insert((top, left)) {
+            continue;
+        }
+        // Corners on the border of a cell spanning them start no cell
+        let Some(cell) = trace_cell(lines, top, left) else {
+            continue;
+        };
+        if cell.right > width {
+            return None;
+        }
+        corners.push((cell.bottom, cell.left));
+        corners.push((cell.top, cell.right));
+        cells.push(cell);
+    }
+
+    let mut column_boundaries: Vec<usize> = cells.iter().flat_map(|c| [c.left, c.right]).collect();
+    column_boundaries.sort_unstable();
+    column_boundaries.dedup();
+    let mut row_boundaries: Vec<usize> = cells.iter().flat_map(|c| [c.top, c.bottom]).collect();
+    row_boundaries.sort_unstable();
+    row_boundaries.dedup();
+    if column_boundaries.first() != Some(&0)
+        || column_boundaries.last() != Some(&width)
+        || row_boundaries.first() != Some(&0)
+        || row_boundaries.last() != Some(&bottom)
+    {
+        return None;
+    }
+
+    // Every slot of the grid must belong to exactly one cell
+    let columns = column_boundaries.len() - 1;
+    let rows = row_boundaries.len() - 1;
+    let mut covered = vec![false; rows * columns];
+    for cell in &cells {
+        let (row, row_end) = span(&row_boundaries, cell.top, cell.bottom)?;
+        let (column, column_end) = span(&column_boundaries, cell.left, cell.right)?;
+        for i in row..row_end {
+            for j in column..column_end {
+                if std::mem::replace(&mut covered[i * columns + j], true) {
+                    return None;
+                }
+            }
+        }
+    }
+    if covered.contains(&false) {
+        return None;
+    }
+    cells.sort_by_key(|cell| (cell.top, cell.left));
+
+    let separators: Vec<usize> = row_boundaries
+        .iter()
+        .copied()
+        .filter(|&i| lines[i].is_part_separator())
+        .collect();
+    let header_end = separators.first().copied().filter(|&i| i != bottom);
+    // A footer needs a header, and ends with a `=` border
+    let footer_start = match separators[..] {
+        [first, .., footer, last] if last == bottom && footer != first => Some(footer),
+        _ => None,
+    };
+    let part_boundaries = [header_end, footer_start];
+    if cells.iter().any(|cell| {
+        part_boundaries
+            .iter()
+            .flatten()
+            .any(|&line| cell.top < line && line < cell.bottom)
+    }) {
+        return None;
+    }
+
+    let alignment_line = &lines[header_end.unwrap_or(0)];
+    let alignments = column_boundaries
+        .windows(2)
+        .map(|pair| alignment_line.alignment(pair[0], pair[1]))
+        .collect();
+    Some(GridLayout {
+        column_boundaries,
+        row_boundaries,
+        cells,
+        alignments,
+        header_end,
+        footer_start,
+    })
+}
+
+/// The index of the boundaries a cell starts and ends at
+fn span(boundaries: &[usize], start: usize, end: usize) -> Option<(usize, usize)> {
+    Some((
+        boundaries.binary_search(&start).ok()?,
+        boundaries.binary_search(&end).ok()?,
+    ))
+}
+
+fn read_grid_table(
+    para: &Paragraph,
+    input_bytes: &[u8],
+    context: &ASTContext,
+    error_collector: &mut DiagnosticCollector,
+) -> Option<Table> {
+    let lines = grid_lines(para, input_bytes)?;
+    let layout = grid_layout(&lines)?;
+    let para_start = para.source_info.start_offset();
+    let source_info = |start: usize, end: usize| {
+        SourceInfo::substring(
+            para.source_info.clone(),
+            start - para_start,
+            end - para_start,
+        )
+    };
+
+    // One Row per row boundary, holding the cells that start in it
+    let mut rows: Vec<Row> = layout.row_boundaries[..layout.row_boundaries.len() - 1]
+        .iter()
+        .zip(&layout.row_boundaries[1..])
+        .map(|(&top, &bottom)| Row {
+            attr: empty_attr(),
+            cells: Vec::new(),
+            source_info: source_info(
+                lines[top].offset_at(0),
+                lines[bottom].offsets.last().copied().unwrap_or(0),
+            ),
+            attr_source: AttrSourceInfo::empty(),
+        })
+        .collect();
+    for grid_cell in &layout.cells {
+        let (row, row_end) = span(&layout.row_boundaries, grid_cell.top, grid_cell.bottom)?;
+        let (column, column_end) =
+            span(&layout.column_boundaries, grid_cell.left, grid_cell.right)?;
+        let content_lines = &lines[grid_cell.top + 1..grid_cell.bottom];
+        let text = cell_text(content_lines.iter().map(|line| {
+            let end = grid_cell.right.min(line.text.len());
+            let start = (grid_cell.left + 1).min(end);
+            &line.text[start..end]
+        }));
+        let cell_source_info = source_info(
+            content_lines[0].offset_at(grid_cell.left + 1),
+            content_lines[content_lines.len() - 1].offset_at(grid_cell.right),
+        );
+        let content = read_cell(&text, &cell_source_info, context, error_collector)?;
+        rows[row].cells.push(Cell {
+            attr: empty_attr(),
+            alignment: Alignment::Default,
+            row_span: row_end - row,
+            col_span: column_end - column,
+            content,
+            source_info: cell_source_info,
+            attr_source: AttrSourceInfo::empty(),
+        });
+    }
+
+    let row_index =
+        |line: Option<usize>| line.and_then(|line| layout.row_boundaries.binary_search(&line).ok());
+    let foot_rows = match row_index(layout.footer_start) {
+        Some(index) => rows.split_off(index),
+        None => vec![],
+    };
+    let body_rows = rows.split_off(row_index(layout.header_end).unwrap_or(0));
+
+    // As in Pandoc, columns are as wide relative to the page as in the
+    // source, or relative to the table when it is wider than the page
+    let table_width = layout.column_boundaries[layout.column_boundaries.len() - 1];
+    let page_width = table_width.max(GRID_TABLE_COLUMNS);
+    let colspec: Vec<ColSpec> = layout
+        .alignments
+        .into_iter()
+        .zip(layout.column_boundaries.windows(2))
+        .map(|(alignment, pair)| {
+            let width = (pair[1] - pair[0]) as f64 / page_width as f64;
+            (alignment, ColWidth::Percentage(width))
+        })
+        .collect();
+    Some(Table {
+        attr: empty_attr(),
+        caption: Caption {
+            short: None,
+            long: None,
+            source_info: para.source_info.clone(),
+        },
+        colspec,
+        head: TableHead {
+            attr: empty_attr(),
+            rows,
+            source_info: para.source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        bodies: vec![TableBody {
+            attr: empty_attr(),
+            rowhead_columns: 0,
+            head: vec![],
+            body: body_rows,
+            source_info: para.source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }],
+        foot: TableFoot {
+            attr: empty_attr(),
+            rows: foot_rows,
+            source_info: para.source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        source_info: para.source_info.clone(),
+        attr_source: AttrSourceInfo::empty(),
+    })
+}
+
+/// The text of a cell from its slice of each line: trailing whitespace is
+/// dropped, and so is one leading space when every line has one, as Pandoc
+/// does
+fn cell_text<'a>(slices: impl Iterator<Item = &'a [char]>) -> String {
+    let lines: Vec<String> = slices
+        .map(|slice| slice.iter().collect::<String>().trim_end().to_string())
+        .collect();
+    let all_indented = lines
+        .iter()
+        .all(|line| line.is_empty() || line.starts_with(' '));
+    let lines: Vec<&str> = lines
+        .iter()
+        .map(|line| match line.strip_prefix(' ') {
+            Some(rest) if all_indented => rest,
+            _ => line.as_str(),
+        })
+        .collect();
+    lines.join("\n").trim_matches('\n').to_string()
+}
+
+/// Read a cell's text as qmd
+pub(super) fn read_cell(
+    text: &str,
+    source_info: &SourceInfo,
+    context: &ASTContext,
+    error_collector: &mut DiagnosticCollector,
+) -> Option<Blocks> {
+    if text.trim().is_empty() {
+        return Some(vec![]);
+    }
+    let result = readers::qmd::read(
+        text.as_bytes(),
+        false,
+        &context.primary_filename().cloned().unwrap_or_default(),
+        &mut std::io::sink(),
+        true,
+        Some(source_info.clone()),
+    );
+    match result {
+        Ok((doc, _context, warnings)) => {
+            for warning in warnings {
+                error_collector.add(warning);
+            }
+            let mut blocks = doc.blocks;
+            if let [Block::Paragraph(_)] = blocks[..] {
+                let Some(Block::Paragraph(para)) = blocks.pop() else {
+                    unreachable!()
+                };
+                blocks.push(Block::Plain(Plain {
+                    content: para.content,
+                    source_info: para.source_info,
+                }));
+            }
+            Some(blocks)
+        }
+        Err(diagnostics) => {
+            for diagnostic in diagnostics {
+                error_collector.add(diagnostic);
+            }
+            None
+        }
+    }
+}
@@ -14,9 +14,11 @@ pub mod document;
 pub mod editorial_marks;
 pub mod fenced_code_block;
 pub mod fenced_div_block;
+pub mod grid_table;
 pub mod info_string;
 pub mod language_specifier;
 pub mod list_marker;
+pub mod multiline_table;
 pub mod note_definition_fenced_block;
 pub mod note_definition_para;
 pub mod numeric_character_reference;
@@ -0,0 +1,475 @@
+/*
+ * multiline_table.rs
+ *
+ * Functions for reading Pandoc multiline tables.
+ *
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use crate::filter_context::FilterContext;
+use crate::filters::{
+    Filter, FilterReturn::FilterResult, FilterReturn::Unchanged, topdown_traverse,
+};
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::attr::{AttrSourceInfo, empty_attr};
+use crate::pandoc::block::Block;
+use crate::pandoc::caption::Caption;
+use crate::pandoc::table::{
+    Alignment, Cell, ColSpec, ColWidth, Row, Table, TableBody, TableFoot, TableHead,
+};
+use crate::pandoc::treesitter_utils::grid_table::{GRID_TABLE_COLUMNS, is_from_input, read_cell};
+use crate::utils::diagnostic_collector::DiagnosticCollector;
+use crate::writers::incremental::block_source_info;
+use quarto_source_map::SourceInfo;
+
+/// Read Pandoc multiline tables.
+///
+/// The block grammar has no multiline tables: in a table such as
+///
+/// ```markdown
+/// ----------------------------------
+///  Fruit    Notes
+/// -------- -------------------------
+///  Apple    Crisp, and sweet when
+///           it is ripe.
+///
+///  Lime     Sour.
+/// ----------------------------------
+/// ```
+///
+/// the dashed lines read as horizontal rules, and the lines between them
+/// as paragraphs. This pass reads the source again from each horizontal
+/// rule, and replaces the blocks of a multiline table with the Table:
+///
+/// - A table with a header starts with a line of dashes. The header lines
+///   end at a line of dashes with a run for each column
+/// - A table without a header starts with that line of runs, and then its
+///   rows must be separated by blank lines; without them it would be a
+///   Pandoc simple table, which isn't read
+/// - Rows are separated by blank lines, and the table ends with a line of
+///   dashes followed by a blank line
+/// - A column takes the text from the start of its run to the start of the
+///   next one. Where the header text sits over the run sets the column's
+///   alignment, as in Pandoc: flush left is left aligned, flush right is
+///   right aligned, neither is centered and both is the default
+/// - As in Pandoc, column widths are their source widths relative to a
+///   72-column page, or to the table when it is wider
+/// - Each cell's lines are trimmed and read as qmd. A cell that reads as a
+///   single paragraph holds a Plain, as in grid tables
+///
+/// Text that isn't a whole multiline table keeps its blocks.
+///
+/// INCREMENTAL WRITER COUPLING: the incremental writer always fully rewrites
+/// Table blocks, so the writer's choice of table syntax is free.
+pub fn transform_multiline_tables(
+    doc: Pandoc,
+    input_bytes: &[u8],
+    context: &ASTContext,
+    error_collector: &mut DiagnosticCollector,
+) -> Pandoc {
+    let mut filter = Filter::new().with_blocks(|blocks, _ctx| {
+        if !blocks
+            .iter()
+            .any(|block| starts_multiline_table(block, input_bytes, context))
+        {
+            return Unchanged(blocks);
+        }
+        // Each table found, with the index of its first block and the
+        // number of blocks it replaces
+        let mut tables = Vec::new();
+        let mut i = 0;
+        while i < blocks.len() {
+            if starts_multiline_table(&blocks[i], input_bytes, context)
+                && let Some((table, count)) =
+                    read_multiline_table(&blocks[i..], input_bytes, context, error_collector)
+            {
+                tables.push((i, count, table));
+                i += count;
+            } else {
+                i += 1;
+            }
+        }
+        if tables.is_empty() {
+            return Unchanged(blocks);
+        }
+        let mut result = Vec::with_capacity(blocks.len());
+        let mut tables = tables.into_iter().peekable();
+        let mut skip = 0;
+        for (i, block) in blocks.into_iter().enumerate() {
+            if tables.peek().is_some_and(|(start, _, _)| *start == i) {
+                let (_, count, table) = tables.next().unwrap();
+                result.push(Block::Table(table));
+                skip = count;
+            }
+            if skip > 0 {
+                skip -= 1;
+                continue;
+            }
+            result.push(block);
+        }
+        FilterResult(result, true)
+    });
+    let mut ctx = FilterContext::new();
+    topdown_traverse(doc, &mut filter, &mut ctx)
+}
+
+fn starts_multiline_table(block: &Block, input_bytes: &[u8], context: &ASTContext) -> bool {
+    let Block::HorizontalRule(rule) = block else {
+        return false;
+    };
+    let start = rule.source_info.start_offset();
+    is_from_input(&rule.source_info, context) && input_bytes.get(start) == Some(&b'-')
+}
+
+/// A line of the table, without the prefix before the table's first column
+struct TableLine {
+    /// Each character, with its byte offset in the input
+    chars: Vec<(usize, char)>,
+    /// Byte offset of the line's end, before its newline
+    end: usize,
+}
+
+impl TableLine {
+    fn is_blank(&self) -> bool {
+        self.chars.iter().all(|(_, c)| c.is_whitespace())
+    }
+
+    /// The start and end columns of each run of dashes, when the line has
+    /// nothing else but spaces and starts with a dash
+    fn dash_runs(&self) -> Option<Vec<(usize, usize)>> {
+        if self.chars.first().map(|(_, c)| *c) != Some('-') {
+            return None;
+        }
+        let mut runs: Vec<(usize, usize)> = Vec::new();
+        for (column, &(_, c)) in self.chars.iter().enumerate() {
+            match c {
+                '-' => match runs.last_mut() {
+                    Some((_, end)) if *end == column => *end += 1,
+                    _ => runs.push((column, column + 1)),
+                },
+                ' ' | '\t' => {}
+                _ => return None,
+            }
+        }
+        Some(runs)
+    }
+
+    /// The text between two columns, to the line's end without an end
+    fn slice(&self, start: usize, end: Option<usize>) -> String {
+        let end = end.unwrap_or(self.chars.len()).min(self.chars.len());
+        let start = start.min(end);
+        self.chars[start..end].iter().map(|(_, c)| c).collect()
+    }
+
+    /// Byte offset of a column, or of the line's end past it
+    fn offset_at(&self, column: usize) -> usize {
+        self.chars
+            .get(column)
+            .map_or(self.end, |(offset, _)| *offset)
+    }
+}
+
+/// The lines of the input from `start` on, dropping from every line but
+/// the first the prefix (indentation, block quote markers) before the
+/// first line's start
+fn table_lines(input_bytes: &[u8], start: usize) -> Option<impl Iterator<Item = TableLine> + '_> {
+    let line_start = input_bytes[..start]
+        .iter()
+        .rposition(|&byte| byte == b'\n')
+        .map_or(0, |pos| pos + 1);
+    let prefix_chars = std::str::from_utf8(&input_bytes[line_start..start])
+        .ok()?
+        .chars()
+        .count();
+    let text = std::str::from_utf8(&input_bytes[start..]).ok()?;
+    let mut offset = start;
+    Some(text.split('\n').enumerate().map(move |(i, line)| {
+        let line_offset = offset;
+        offset += line.len() + 1;
+        let line = line.strip_suffix('\r').unwrap_or(line);
+        let skip = if i == 0 { 0 } else { prefix_chars };
+        TableLine {
+            chars: line
+                .char_indices()
+                .skip(skip)
+                .map(|(byte_index, c)| (line_offset + byte_index, c))
+                .collect(),
+            end: line_offset + line.len(),
+        }
+    }))
+}
+
+/// The lines of a multiline table
+struct MultilineLayout {
+    /// The first column of each run of the column separator line
+    column_starts: Vec<usize>,
+    /// The number of dashes in each run
+    dash_lengths: Vec<usize>,
+    /// The width of the column separator line
+    width: usize,
+    /// The header lines, when the table has a header
+    header: Option<Vec<TableLine>>,
+    /// The lines of each row
+    rows: Vec<Vec<TableLine>>,
+    /// The line ending the table
+    last: TableLine,
+    /// Byte offset of the table's first character
+    start: usize,
+}
+
+fn multiline_layout(input_bytes: &[u8], start: usize) -> Option<MultilineLayout> {
+    let mut lines = table_lines(input_bytes, start)?;
+    let first = lines.next()?;
+    let mut runs = first.dash_runs()?;
+    let mut width = first.chars.len();
+    let mut header_lines = Vec::new();
+    // A single run of dashes starts a header, and a line of several runs
+    // starts a table without one
+    let header = if runs.len() == 1 {
+        loop {
+            let line = lines.next()?;
+            if line.is_blank() {
+                return None;
+            }
+            if let Some(separator_runs) = line.dash_runs() {
+                runs = separator_runs;
+                width = line.chars.len();
+                break;
+            }
+            header_lines.push(line);
+        }
+        if header_lines.is_empty() {
+            return None;
+        }
+        Some(header_lines)
+    } else {
+        None
+    };
+
+    let mut rows = Vec::new();
+    let mut row = Vec::new();
+    let mut separated = false;
+    let last = loop {
+        let line = lines.next()?;
+        if line.dash_runs().is_some() {
+            break line;
+        }
+        if line.is_blank() {
+            if !row.is_empty() {
+                rows.push(std::mem::take(&mut row));
+            }
+            separated = true;
+        } else {
+            row.push(line);
+        }
+    };
+    if !row.is_empty() {
+        rows.push(row);
+    }
+    if rows.is_empty() || (header.is_none() && !separated) {
+        return None;
+    }
+    if lines.next().is_some_and(|line| !line.is_blank()) {
+        return None;
+    }
+
+    Some(MultilineLayout {
+        column_starts: runs.iter().map(|(start, _)| *start).collect(),
+        dash_lengths: runs.iter().map(|(start, end)| end - start).collect(),
+        width,
+        header,
+        rows,
+        last,
+        start,
+    })
+}
+
+impl MultilineLayout {
+    /// The trimmed text of each column of `lines`, one line of text for
+    /// each line
+    fn cell_texts(&self, lines: &[TableLine]) -> Vec<String> {
+        (0..self.column_starts.len())
+            .map(|column| {
+                let (start, end) = self.column_range(column);
+                let text: Vec<String> = lines
+                    .iter()
+                    .map(|line| line.slice(start, end).trim().to_string())
+                    .collect();
+                text.join("\n").trim_matches('\n').to_string()
+            })
+            .collect()
+    }
+
+    fn column_range(&self, column: usize) -> (usize, Option<usize>) {
+        (
+            self.column_starts[column],
+            self.column_starts.get(column + 1).copied(),
+        )
+    }
+
+    /// The alignment of each column, from where the text of the header (or
+    /// of the first row, without a header) sits over its run of dashes.
+    /// As in Pandoc, the shortest line of text decides.
+    fn alignments(&self) -> Vec<Alignment> {
+        let lines = match &self.header {
+            Some(header) => &header[..],
+            None => &self.rows[0][..1],
+        };
+        (0..self.column_starts.len())
+            .map(|column| {
+                let (start, end) = self.column_range(column);
+                let shortest = lines
+                    .iter()
+                    .map(|line| line.slice(start, end).trim_end().to_string())
+                    .filter(|text| !text.is_empty())
+                    .min_by_key(|text| text.chars().count());
+                let Some(text) = shortest else {
+                    return Alignment::Default;
+                };
+                let left_space = text.starts_with([' ', '\t']);
+                let right_space = text.chars().count() < self.dash_lengths[column];
+                match (left_space, right_space) {
+                    (true, false) => Alignment::Right,
+                    (false, true) => Alignment::Left,
+                    (true, true) => Alignment::Center,
+                    (false, false) => Alignment::Default,
+                }
+            })
+            .collect()
+    }
+
+    /// As in Pandoc, each column is as wide as its run and the spaces after
+    /// it, relative to the page or to the table when it is wider
+    fn widths(&self) -> Vec<f64> {
+        let mut widths: Vec<usize> = self
+            .column_starts
+            .windows(2)
+            .map(|pair| pair[1] - pair[0])
+            .collect();
+        let last_start = self.column_starts[self.column_starts.len() - 1];
+        let mut last = self.width.saturating_sub(last_start) + 1;
+        // The last column has no spaces after it
+        if let Some(&before) = widths.last()
+            && last < before
+            && before - last <= 2
+        {
+            last = before;
+        }
+        widths.push(last);
+        let total: usize = widths.iter().sum();
+        let page_width = total.max(GRID_TABLE_COLUMNS) as f64;
+        widths
+            .into_iter()
+            .map(|width| width as f64 / page_width)
+            .collect()
+    }
+}
+
+/// Read the multiline table starting at the horizontal rule `blocks[0]`,
+/// and return it with the number of blocks it replaces
+fn read_multiline_table(
+    blocks: &[Block],
+    input_bytes: &[u8],
+    context: &ASTContext,
+    error_collector: &mut DiagnosticCollector,
+) -> Option<(Table, usize)> {
+    let start = block_source_info(&blocks[0]).start_offset();
+    let layout = multiline_layout(input_bytes, start)?;
+
+    // The table's blocks end with the one holding its last line, and the
+    // next block starts after it
+    let end = layout.last.end;
+    let count = blocks
+        .iter()
+        .take_while(|block| block_source_info(block).start_offset() <= end)
+        .count();
+    if block_source_info(&blocks[count - 1]).end_offset() > end + 2 {
+        return None;
+    }
+
+    let source_info = |start: usize, end: usize| match &context.parent_source_info {
+        Some(parent) => SourceInfo::substring(parent.clone(), start, end),
+        None => SourceInfo::original(context.current_file_id(), start, end),
+    };
+    let mut read_row = |lines: &[TableLine]| -> Option<Row> {
+        let mut cells = Vec::new();
+        for (column, text) in layout.cell_texts(lines).into_iter().enumerate() {
+            let (start, end) = layout.column_range(column);
+            let last = &lines[lines.len() - 1];
+            let cell_source_info = source_info(
+                lines[0].offset_at(start),
+                end.map_or(last.end, |end| last.offset_at(end)),
+            );
+            let content = read_cell(&text, &cell_source_info, context, error_collector)?;
+            cells.push(Cell {
+                attr: empty_attr(),
+                alignment: Alignment::Default,
+                row_span: 1,
+                col_span: 1,
+                content,
+                source_info: cell_source_info,
+                attr_source: AttrSourceInfo::empty(),
+            });
+        }
+        Some(Row {
+            attr: empty_attr(),
+            cells,
+            source_info: source_info(lines[0].offset_at(0), lines[lines.len() - 1].end),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    };
+
+    let head_rows = match &layout.header {
+        // A header without text is no header, as in Pandoc
+        Some(header) if !layout.cell_texts(header).iter().all(String::is_empty) => {
+            vec![read_row(header)?]
+        }
+        _ => vec![],
+    };
+    let body_rows = layout
+        .rows
+        .iter()
+        .map(|row| read_row(row))
+        .collect::<Option<Vec<Row>>>()?;
+
+    let table_source_info = source_info(layout.start, end);
+    let colspec: Vec<ColSpec> = layout
+        .alignments()
+        .into_iter()
+        .zip(layout.widths())
+        .map(|(alignment, width)| (alignment, ColWidth::Percentage(width)))
+        .collect();
+    let table = Table {
+        attr: empty_attr(),
+        caption: Caption {
+            short: None,
+            long: None,
+            source_info: table_source_info.clone(),
+        },
+        colspec,
+        head: TableHead {
+            attr: empty_attr(),
+            rows: head_rows,
+            source_info: table_source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        bodies: vec![TableBody {
+            attr: empty_attr(),
+            rowhead_columns: 0,
+            head: vec![],
+            body: body_rows,
+            source_info: table_source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }],
+        foot: TableFoot {
+            attr: empty_attr(),
+            rows: vec![],
+            source_info: table_source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        source_info: table_source_info,
+        attr_source: AttrSourceInfo::empty(),
+    };
+    Some((table, count))
+}
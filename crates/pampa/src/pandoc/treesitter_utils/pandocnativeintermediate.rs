@@ -33,7 +33,8 @@ pub enum PandocNativeIntermediate {
     IntermediateMetadataString(String, Range),
     IntermediateCell(Cell),
     IntermediateRow(Row),
-    IntermediatePipeTableDelimiterCell(Alignment),
-    IntermediatePipeTableDelimiterRow(Vec<Alignment>),
+    /// (alignment, length of the delimiter cell)
+    IntermediatePipeTableDelimiterCell(Alignment, usize),
+    IntermediatePipeTableDelimiterRow(Vec<(Alignment, usize)>),
     IntermediateSetextHeadingLevel(usize),
 }
@@ -20,7 +20,12 @@ use hashlink::LinkedHashMap;
 use super::pandocnativeintermediate::PandocNativeIntermediate;
 use super::postprocess::trim_inlines;
 
+/// Line width above which a pipe table's columns get relative widths from
+/// its delimiter row, as in Pandoc (its default `--columns`)
+pub const PIPE_TABLE_COLUMNS: usize = 72;
+
 pub fn process_pipe_table_delimiter_cell(
+    node: &tree_sitter::Node,
     children: Vec<(String, PandocNativeIntermediate)>,
     _context: &ASTContext,
 ) -> PandocNativeIntermediate {
@@ -37,14 +42,15 @@ pub fn process_pipe_table_delimiter_cell(
             panic!("Unexpected node in pipe_table_delimiter_cell: {}", node);
         }
     }
-    PandocNativeIntermediate::IntermediatePipeTableDelimiterCell(
-        match (has_starter_colon, has_ending_colon) {
-            (true, true) => Alignment::Center,
-            (true, false) => Alignment::Left,
-            (false, true) => Alignment::Right,
-            (false, false) => Alignment::Default,
-        },
-    )
+    let alignment = match (has_starter_colon, has_ending_colon) {
+        (true, true) => Alignment::Center,
+        (true, false) => Alignment::Left,
+        (false, true) => Alignment::Right,
+        (false, false) => Alignment::Default,
+    };
+    // Delimiter cells are ASCII, so bytes are characters
+    let length = node.end_byte() - node.start_byte();
+    PandocNativeIntermediate::IntermediatePipeTableDelimiterCell(alignment, length)
 }
 
 pub fn process_pipe_table_header_or_row(
@@ -89,8 +95,8 @@ pub fn process_pipe_table_delimiter_row(
             .into_iter()
             .filter(|(node, _)| node != "|") // skip the marker nodes
             .map(|(node, child)| match child {
-                PandocNativeIntermediate::IntermediatePipeTableDelimiterCell(alignment) => {
-                    alignment
+                PandocNativeIntermediate::IntermediatePipeTableDelimiterCell(alignment, length) => {
+                    (alignment, length)
                 }
                 _ => panic!(
                     "Unexpected node in pipe_table_delimiter_row: {} {:?}",
@@ -143,12 +149,14 @@ pub fn process_pipe_table_cell(
 pub fn process_pipe_table(
     node: &tree_sitter::Node,
     children: Vec<(String, PandocNativeIntermediate)>,
+    input_bytes: &[u8],
     context: &ASTContext,
 ) -> PandocNativeIntermediate {
     let mut attr = empty_attr();
     let mut attr_source = crate::pandoc::attr::AttrSourceInfo::empty();
     let mut header: Option<Row> = None;
     let mut colspec: Vec<ColSpec> = Vec::new();
+    let mut delimiter_lengths: Vec<usize> = Vec::new();
     let mut rows: Vec<Row> = Vec::new();
     let mut caption_inlines: Option<Inlines> = None;
     let mut caption_source_info: Option<quarto_source_map::SourceInfo> = None;
@@ -165,8 +173,9 @@ pub fn process_pipe_table(
         } else if node == "pipe_table_delimiter_row" {
             match child {
                 PandocNativeIntermediate::IntermediatePipeTableDelimiterRow(row) => {
-                    for alignment in row {
+                    for (alignment, length) in row {
                         colspec.push((alignment, ColWidth::Default));
+                        delimiter_lengths.push(length);
                     }
                 }
                 _ => panic!(
@@ -210,6 +219,21 @@ pub fn process_pipe_table(
         }
     }
 
+    // As in Pandoc, a table with a line wider than the page gets relative
+    // column widths from the lengths of its delimiter cells
+    let widest_line = header
+        .iter()
+        .chain(rows.iter())
+        .map(|row| source_width(&row.source_info, input_bytes))
+        .max()
+        .unwrap_or(0);
+    let total_length: usize = delimiter_lengths.iter().sum();
+    if widest_line > PIPE_TABLE_COLUMNS && total_length > 0 {
+        for ((_, width), length) in colspec.iter_mut().zip(&delimiter_lengths) {
+            *width = ColWidth::Percentage(*length as f64 / total_length as f64);
+        }
+    }
+
     // Check if header row has all empty cells
     let header_is_empty = header.as_ref().is_some_and(|h| {
         h.cells.iter().all(|cell| {
@@ -315,3 +339,13 @@ pub fn process_pipe_table(
         attr_source,
     }))
 }
+
+/// Width in characters of the source a row was read from
+fn source_width(source_info: &quarto_source_map::SourceInfo, input_bytes: &[u8]) -> usize {
+    let end = source_info.end_offset().min(input_bytes.len());
+    let start = source_info.start_offset().min(end);
+    String::from_utf8_lossy(&input_bytes[start..end])
+        .trim_end()
+        .chars()
+        .count()
+}
@@ -22,9 +22,24 @@
 //                    | DefinitionList                      | syntax                               | rewritten regardless)
 //                    | transform_definition_list_div()     | write_definitionlist()               |
 // -------------------|-------------------------------------|--------------------------------------|------------------------
+// definition-list    | Paragraph + CaptionBlock(s) →       | DefinitionList → term/definition     | Yes (continuation blocks
+// (Pandoc syntax)    | DefinitionList                      | syntax                               | are indented; always
+//                    | transform_definition_list_captions()| write_definitionlist()               | fully rewritten)
+// -------------------|-------------------------------------|--------------------------------------|------------------------
+// figure-div         | Div(#fig-*) → Figure, caption from  | Figure → Div(#fig-*), caption as     | No (always fully
+//                    | fig-cap or the last paragraph       | the last paragraph                   | rewritten)
+//                    | transform_figure_div()              | write_figure()                       |
+// -------------------|-------------------------------------|--------------------------------------|------------------------
+// task-list          | Span [] / Span [x] at the start of  | Str ☐ / ☒ at the start of a list     | Yes (inside the list
+//                    | a list item → Str ☐ / ☒             | item → [ ] / [x]                     | item)
+//                    | task_lists::read_task_marker()      | write_list_item_blocks()             |
+// -------------------|-------------------------------------|--------------------------------------|------------------------
+// line-block         | Paragraph of `| ` lines → LineBlock | LineBlock → `| ` lines               | Yes (continuation lines
+//                    | read_line_block()                   | write_lineblock()                    | are indented)
+// -------------------|-------------------------------------|--------------------------------------|------------------------
 //
 // INCREMENTAL WRITER COUPLING:
-// Both transforms' sugared forms are always fully rewritten by the incremental writer
+// All transforms' sugared forms are always fully rewritten by the incremental writer
 // (never incrementally spliced), so Option A reconciliation (post-desugared ASTs) is safe.
 // If adding a new sugar/desugar transform, document whether the sugared form uses
 // indentation boundaries. If it does NOT, evaluate whether Option A reconciliation can
@@ -38,9 +53,10 @@ use crate::filters::{
 };
 use crate::pandoc::location::empty_source_info;
 use crate::pandoc::{
-    Attr, Block, Blocks, Caption, DefinitionList, Div, Figure, Inline, Inlines, Pandoc, Plain,
-    Space, Span, Str, Superscript, is_empty_attr,
+    Attr, Block, Blocks, Caption, DefinitionList, Div, Figure, Inline, Inlines, LineBlock, Pandoc,
+    Paragraph, Plain, Space, Span, Str, Superscript, is_empty_attr,
 };
+use crate::transforms::task_lists;
 use crate::utils::autoid;
 use crate::utils::diagnostic_collector::DiagnosticCollector;
 use hashlink::LinkedHashMap;
@@ -51,7 +67,7 @@ use quarto_pandoc_types::table::{
 };
 use quarto_source_map::SourceInfo;
 use std::cell::RefCell;
-use std::collections::HashMap;
+use std::collections::HashSet;
 
 /// Result of validating a list-table div
 #[derive(Debug)]
@@ -567,6 +583,84 @@ pub fn convert_trailing_linebreak_to_str(inlines: &mut Inlines) -> bool {
     }
 }
 
+/// Read a paragraph whose lines start with `| ` as a Pandoc line block, keeping
+/// its line breaks. Returns the lines of the block, or None when the paragraph
+/// isn't one.
+///
+/// A line of just `|` is an empty line, and spaces after the first one
+/// following the `|` are kept as non-breaking spaces. An escaped `\|` doesn't
+/// start a line. A line that doesn't start with `|` continues the line before
+/// it, as an indented continuation line does in Pandoc.
+pub fn read_line_block(inlines: &[Inline]) -> Option<Vec<Inlines>> {
+    fn line_break_source_info(inline: &Inline) -> Option<&SourceInfo> {
+        match inline {
+            Inline::SoftBreak(b) => Some(&b.source_info),
+            Inline::LineBreak(b) => Some(&b.source_info),
+            _ => None,
+        }
+    }
+    // An escaped `\|` reads as the same text, but is two characters long
+    let is_bar = |inline: &Inline| match inline {
+        Inline::Str(s) => s.text == "|" && s.source_info.length() == 1,
+        _ => false,
+    };
+    if !inlines.first().is_some_and(is_bar) {
+        return None;
+    }
+    let line_breaks: Vec<&SourceInfo> = inlines.iter().filter_map(line_break_source_info).collect();
+    let mut lines: Vec<Inlines> = Vec::new();
+    for (i, line) in inlines
+        .split(|inline| line_break_source_info(inline).is_some())
+        .enumerate()
+    {
+        match line {
+            [bar] if is_bar(bar) => lines.push(Vec::new()),
+            [bar, text @ ..] if is_bar(bar) => {
+                let spaces: Vec<&SourceInfo> = text
+                    .iter()
+                    .map_while(|inline| match inline {
+                        Inline::Space(space) => Some(&space.source_info),
+                        _ => None,
+                    })
+                    .collect();
+                // `|foo` isn't a line of a line block
+                let first_space = spaces.first()?;
+                let indent = spaces
+                    .iter()
+                    .map(|space| space.length())
+                    .sum::<usize>()
+                    .saturating_sub(1);
+                let mut content = text[spaces.len()..].to_vec();
+                if indent > 0 {
+                    let nbsp = "\u{a0}".repeat(indent);
+                    match content.first_mut() {
+                        Some(Inline::Str(s)) => s.text.insert_str(0, &nbsp),
+                        _ => content.insert(
+                            0,
+                            Inline::Str(Str {
+                                text: nbsp,
+                                source_info: (*first_space).clone(),
+                            }),
+                        ),
+                    }
+                }
+                lines.push(content);
+            }
+            [] => {}
+            _ => {
+                let previous = lines.last_mut()?;
+                if !previous.is_empty() {
+                    previous.push(Inline::Space(Space {
+                        source_info: line_breaks[i - 1].clone(),
+                    }));
+                }
+                previous.extend(line.iter().cloned());
+            }
+        }
+    }
+    Some(lines)
+}
+
 /// List of known abbreviations
 const ABBREVIATIONS: &[&str] = &[
     "Mr.", "Mrs.", "Ms.", "Capt.", "Dr.", "Prof.", "Gen.", "Gov.", "e.g.", "i.e.", "Sgt.", "St.",
@@ -778,6 +872,107 @@ fn transform_definition_list_div(div: Div) -> Block {
     })
 }
 
+/// Whether a div is a Quarto figure div: its id has the `fig-` prefix.
+fn is_figure_div(div: &Div) -> bool {
+    div.attr.0.starts_with("fig-")
+}
+
+/// Transform a figure div into a Figure block.
+///
+/// The caption is, in order of preference:
+/// - the `fig-cap` attribute, which is removed from the attributes
+/// - the alt text of the image, when the div holds only an image, as an
+///   implicit figure would
+/// - the last paragraph, when there is other content before it
+///
+/// The div's id, classes and other attributes (`fig-align`, `fig-alt`, ...)
+/// are the Figure's.
+fn transform_figure_div(div: Div) -> Block {
+    let (id, classes, mut attrs) = div.attr;
+    let mut attr_source = div.attr_source;
+    let mut content = div.content;
+
+    let fig_cap = attrs.keys().position(|key| key == "fig-cap").map(|index| {
+        let source = if index < attr_source.attributes.len() {
+            attr_source.attributes.remove(index).1
+        } else {
+            None
+        };
+        let text = attrs.remove("fig-cap").unwrap_or_default();
+        (text, source.unwrap_or_else(|| div.source_info.clone()))
+    });
+
+    let caption_blocks = match fig_cap {
+        Some((text, source_info)) => Some(vec![Block::Plain(Plain {
+            content: text_to_inlines(&text, &source_info),
+            source_info,
+        })]),
+        None if is_image_paragraph(&content) => match content.pop() {
+            Some(Block::Paragraph(para)) => {
+                let Some(Inline::Image(image)) = para.content.first() else {
+                    unreachable!("checked by is_image_paragraph");
+                };
+                let caption = Block::Plain(Plain {
+                    content: image.content.clone(),
+                    source_info: image.source_info.clone(),
+                });
+                content.push(Block::Plain(Plain {
+                    content: para.content,
+                    source_info: para.source_info,
+                }));
+                Some(vec![caption])
+            }
+            _ => unreachable!("checked by is_image_paragraph"),
+        },
+        None if content.len() > 1 && matches!(content.last(), Some(Block::Paragraph(_))) => {
+            match content.pop() {
+                Some(Block::Paragraph(para)) => Some(vec![Block::Plain(Plain {
+                    content: para.content,
+                    source_info: para.source_info,
+                })]),
+                _ => unreachable!("checked above"),
+            }
+        }
+        None => None,
+    };
+
+    Block::Figure(Figure {
+        attr: (id, classes, attrs),
+        caption: Caption {
+            short: None,
+            long: caption_blocks,
+            source_info: div.source_info.clone(),
+        },
+        content,
+        source_info: div.source_info,
+        attr_source,
+    })
+}
+
+/// Whether blocks are a single paragraph of one image with alt text.
+fn is_image_paragraph(blocks: &[Block]) -> bool {
+    matches!(blocks, [Block::Paragraph(para)]
+        if para.content.len() == 1
+            && matches!(&para.content[0], Inline::Image(image) if !image.content.is_empty()))
+}
+
+/// Split plain text into Str and Space inlines.
+fn text_to_inlines(text: &str, source_info: &SourceInfo) -> Inlines {
+    let mut inlines = Vec::new();
+    for word in text.split_whitespace() {
+        if !inlines.is_empty() {
+            inlines.push(Inline::Space(Space {
+                source_info: source_info.clone(),
+            }));
+        }
+        inlines.push(Inline::Str(Str {
+            text: word.to_string(),
+            source_info: source_info.clone(),
+        }));
+    }
+    inlines
+}
+
 /// Transform special divs (definition-list, list-table) into their proper AST representations.
 ///
 /// This function applies div transforms that convert divs with specific classes into
@@ -787,6 +982,7 @@ fn transform_definition_list_div(div: Div) -> Block {
 /// Transforms applied:
 /// - `definition-list` class divs → DefinitionList blocks
 /// - `list-table` class divs → Table blocks
+/// - `#fig-` divs → Figure blocks
 ///
 /// INCREMENTAL WRITER COUPLING: This is the desugar entry point. The incremental writer
 /// reconciles post-desugared ASTs (Option A), relying on the fact that all sugared forms
@@ -815,6 +1011,8 @@ pub fn transform_divs(doc: Pandoc, error_collector: &mut DiagnosticCollector) ->
                 // Not a list-table, check for definition-list
                 if is_valid_definition_list_div(&div) {
                     FilterResult(vec![transform_definition_list_div(div)], false)
+                } else if is_figure_div(&div) {
+                    FilterResult(vec![transform_figure_div(div)], true)
                 } else {
                     Unchanged(div)
                 }
@@ -826,14 +1024,209 @@ pub fn transform_divs(doc: Pandoc, error_collector: &mut DiagnosticCollector) ->
     topdown_traverse(doc, &mut filter, &mut ctx)
 }
 
+/// Read Pandoc's definition list syntax.
+///
+/// A definition line starts with `:`, which the block grammar reads as a
+/// caption block, so
+///
+/// ```markdown
+/// Term
+/// :   Definition
+/// ```
+///
+/// reads as a paragraph followed by a CaptionBlock. This runs before
+/// `postprocess()` attaches captions to tables, and joins a one-line
+/// paragraph with the captions right after it into a definition list item:
+///
+/// - Each caption is a definition: tight (Plain) when it directly follows
+///   the line above, loose (Paragraph) after a blank line
+/// - Blocks indented four or more columns past a definition's `:` continue
+///   that definition
+/// - Consecutive items form one DefinitionList
+///
+/// Captions with attributes are left for tables. `input_bytes` is the source
+/// of the blocks, used to find blank lines and indentation.
+///
+/// INCREMENTAL WRITER COUPLING: like the definition-list div, the
+/// DefinitionList this produces is always fully rewritten. Its source info
+/// spans every block it was read from.
+pub fn transform_definition_list_captions(doc: Pandoc, input_bytes: &[u8]) -> Pandoc {
+    let mut filter = Filter::new().with_blocks(|blocks, _ctx| {
+        if !blocks.iter().any(is_definition) {
+            return Unchanged(blocks);
+        }
+        FilterResult(collect_definition_lists(blocks, input_bytes), true)
+    });
+    let mut ctx = FilterContext::new();
+    topdown_traverse(doc, &mut filter, &mut ctx)
+}
+
+fn collect_definition_lists(blocks: Blocks, input_bytes: &[u8]) -> Blocks {
+    let mut result: Blocks = Vec::new();
+    // Whether the last block of `result` is a DefinitionList read here, which
+    // an item right after it joins
+    let mut in_list = false;
+    let mut blocks = blocks.into_iter().peekable();
+    while let Some(block) = blocks.next() {
+        let term = match block {
+            Block::Paragraph(para)
+                if is_term(&para) && blocks.peek().is_some_and(is_definition) =>
+            {
+                para
+            }
+            block => {
+                in_list = false;
+                result.push(block);
+                continue;
+            }
+        };
+        let mut source_info = term.source_info;
+        let mut definitions: Vec<Blocks> = Vec::new();
+        // Column of the last definition's `:`
+        let mut marker_column: Option<usize> = None;
+        while let Some(next) = blocks.next_if(|next| {
+            is_definition(next) || continues_definition(marker_column, next, input_bytes)
+        }) {
+            source_info = original_span(&source_info, &get_block_source_info(&next));
+            match next {
+                Block::CaptionBlock(caption) => {
+                    marker_column = source_column(&caption.source_info, input_bytes);
+                    let block = if follows_blank_line(&caption.source_info, input_bytes) {
+                        Block::Paragraph(Paragraph {
+                            content: caption.content,
+                            source_info: caption.source_info,
+                        })
+                    } else {
+                        Block::Plain(Plain {
+                            content: caption.content,
+                            source_info: caption.source_info,
+                        })
+                    };
+                    definitions.push(vec![block]);
+                }
+                // Continuing blocks follow a definition
+                next => definitions.last_mut().unwrap().push(next),
+            }
+        }
+
+        let entry = (term.content, definitions);
+        match result.last_mut() {
+            Some(Block::DefinitionList(list)) if in_list => {
+                list.content.push(entry);
+                list.source_info = original_span(&list.source_info, &source_info);
+            }
+            _ => {
+                result.push(Block::DefinitionList(DefinitionList {
+                    content: vec![entry],
+                    source_info,
+                }));
+                in_list = true;
+            }
+        }
+    }
+    result
+}
+
+/// Whether a paragraph can be a definition list term: a single line
+fn is_term(para: &Paragraph) -> bool {
+    !para.content.is_empty()
+        && !para
+            .content
+            .iter()
+            .any(|inline| matches!(inline, Inline::SoftBreak(_)))
+}
+
+/// Whether a block is a `: definition` line
+fn is_definition(block: &Block) -> bool {
+    let Block::CaptionBlock(caption) = block else {
+        return false;
+    };
+    !caption
+        .content
+        .iter()
+        .any(|inline| matches!(inline, Inline::Attr(..)))
+}
+
+/// Whether a block is indented four or more columns past a definition's `:`
+fn continues_definition(marker_column: Option<usize>, block: &Block, input_bytes: &[u8]) -> bool {
+    let Some(marker_column) = marker_column else {
+        return false;
+    };
+    source_column(&get_block_source_info(block), input_bytes)
+        .is_some_and(|column| column >= marker_column + 4)
+}
+
+/// Whether the line before the one a source range starts on is blank
+fn follows_blank_line(source_info: &SourceInfo, input_bytes: &[u8]) -> bool {
+    let SourceInfo::Original { start_offset, .. } = source_info else {
+        return false;
+    };
+    let start = (*start_offset).min(input_bytes.len());
+    let Some(line_end) = input_bytes[..start].iter().rposition(|&byte| byte == b'\n') else {
+        return false;
+    };
+    let previous_start = input_bytes[..line_end]
+        .iter()
+        .rposition(|&byte| byte == b'\n')
+        .map_or(0, |pos| pos + 1);
+    input_bytes[previous_start..line_end]
+        .iter()
+        .all(u8::is_ascii_whitespace)
+}
+
+/// The column a source range starts at, counting a tab as four columns and
+/// skipping whitespace at the start of the range
+fn source_column(source_info: &SourceInfo, input_bytes: &[u8]) -> Option<usize> {
+    let SourceInfo::Original { start_offset, .. } = source_info else {
+        return None;
+    };
+    let start = (*start_offset).min(input_bytes.len());
+    let line_start = input_bytes[..start]
+        .iter()
+        .rposition(|&byte| byte == b'\n')
+        .map_or(0, |pos| pos + 1);
+    let mut column = 0;
+    for (offset, &byte) in input_bytes.iter().enumerate().skip(line_start) {
+        match byte {
+            b' ' => column += 1,
+            b'\t' => column += 4,
+            _ if offset >= start => break,
+            // Count characters, not UTF-8 continuation bytes
+            _ if byte & 0xC0 != 0x80 => column += 1,
+            _ => {}
+        }
+    }
+    Some(column)
+}
+
+/// The source range from the start of `start` to the end of `end`, when both
+/// are ranges of the same file
+fn original_span(start: &SourceInfo, end: &SourceInfo) -> SourceInfo {
+    match (start, end) {
+        (
+            SourceInfo::Original {
+                file_id,
+                start_offset,
+                ..
+            },
+            SourceInfo::Original {
+                file_id: end_file_id,
+                end_offset,
+                ..
+            },
+        ) if file_id == end_file_id => SourceInfo::original(*file_id, *start_offset, *end_offset),
+        _ => start.clone(),
+    }
+}
+
 /// Apply post-processing transformations to the Pandoc AST
 pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Result<Pandoc, ()> {
     let result = {
         // Wrap error_collector in RefCell for interior mutability across multiple closures
         let error_collector_ref = RefCell::new(error_collector);
 
-        // Track seen header IDs to avoid duplicates
-        let mut seen_ids: HashMap<String, usize> = HashMap::new();
+        // Header IDs used so far, written or made up, to avoid duplicates
+        let mut used_ids: HashSet<String> = HashSet::new();
         // Track citation count for numbering
         let mut citation_counter: usize = 0;
 
@@ -873,16 +1266,12 @@ pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Re
                 if !is_last_attr {
                     let mut attr = header.attr.clone();
                     if attr.0.is_empty() {
-                        let base_id = autoid::auto_generated_id(&header.content);
-
                         // Deduplicate the ID by appending -1, -2, etc. for duplicates
-                        let final_id = if let Some(count) = seen_ids.get_mut(&base_id) {
-                            *count += 1;
-                            format!("{}-{}", base_id, count)
-                        } else {
-                            seen_ids.insert(base_id.clone(), 0);
-                            base_id
-                        };
+                        let final_id = autoid::unique_id(
+                            &autoid::auto_generated_id(&header.content),
+                            &used_ids,
+                        );
+                        used_ids.insert(final_id.clone());
 
                         attr.0 = final_id;
                         if !is_empty_attr(&attr) || trailing_lb_converted {
@@ -891,24 +1280,42 @@ pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Re
                         } else {
                             Unchanged(header)
                         }
-                    } else if trailing_lb_converted {
-                        FilterResult(vec![Block::Header(header)], true)
                     } else {
-                        Unchanged(header)
+                        used_ids.insert(attr.0);
+                        if trailing_lb_converted {
+                            FilterResult(vec![Block::Header(header)], true)
+                        } else {
+                            Unchanged(header)
+                        }
                     }
                 } else {
                     let Some(Inline::Attr(attr, attr_source)) = header.content.pop() else {
                         panic!("shouldn't happen, header should have an attribute at this point");
                     };
+                    if !attr.0.is_empty() {
+                        used_ids.insert(attr.0.clone());
+                    }
                     header.attr = attr;
                     header.attr_source = attr_source;
                     header.content = trim_inlines(header.content).0;
                     FilterResult(vec![Block::Header(header)], true)
                 }
             })
-            // attempt to desugar single-image paragraphs into figures
+            // attempt to desugar single-image paragraphs into figures, and `|` lines
+            // into line blocks
             // also convert trailing LineBreak to literal backslash (CommonMark spec)
             .with_paragraph(|mut para, _ctx| {
+                // `| verse` lines are a line block
+                if let Some(lines) = read_line_block(&para.content) {
+                    return FilterResult(
+                        vec![Block::LineBlock(LineBlock {
+                            content: lines,
+                            source_info: para.source_info,
+                        })],
+                        true,
+                    );
+                }
+
                 // Convert trailing LineBreak to literal backslash (CommonMark spec)
                 // Per spec, hard line breaks don't work at end of block elements
                 let trailing_lb_converted = convert_trailing_linebreak_to_str(&mut para.content);
@@ -974,6 +1381,16 @@ pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Re
             })
             // Convert trailing LineBreak in Plain blocks (used in tight lists)
             .with_plain(|mut plain, _ctx| {
+                if let Some(lines) = read_line_block(&plain.content) {
+                    return FilterResult(
+                        vec![Block::LineBlock(LineBlock {
+                            content: lines,
+                            source_info: plain.source_info,
+                        })],
+                        true,
+                    );
+                }
+
                 // Convert trailing LineBreak to literal backslash (CommonMark spec)
                 // Per spec, hard line breaks don't work at end of block elements
                 let trailing_lb_converted = convert_trailing_linebreak_to_str(&mut plain.content);
@@ -1008,6 +1425,8 @@ pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Re
                         // Not a list-table, check for definition-list
                         if is_valid_definition_list_div(&div) {
                             FilterResult(vec![transform_definition_list_div(div)], false)
+                        } else if is_figure_div(&div) {
+                            FilterResult(vec![transform_figure_div(div)], true)
                         } else {
                             Unchanged(div)
                         }
@@ -1053,12 +1472,28 @@ pub fn postprocess(doc: Pandoc, error_collector: &mut DiagnosticCollector) -> Re
                     }
                 }
 
+                // `- [ ] task` and `- [x] task` start with a checkbox
+                for item in &mut bullet_list.content {
+                    changed |= task_lists::read_task_marker(item);
+                }
+
                 if changed {
                     FilterResult(vec![Block::BulletList(bullet_list)], true)
                 } else {
                     Unchanged(bullet_list)
                 }
             })
+            .with_ordered_list(|mut ordered_list, _ctx| {
+                let mut changed = false;
+                for item in &mut ordered_list.content {
+                    changed |= task_lists::read_task_marker(item);
+                }
+                if changed {
+                    FilterResult(vec![Block::OrderedList(ordered_list)], true)
+                } else {
+                    Unchanged(ordered_list)
+                }
+            })
             // Fix table captions that were parsed as last row (no blank line before caption)
             .with_table(|mut table, _ctx| {
                 // Check if caption is empty
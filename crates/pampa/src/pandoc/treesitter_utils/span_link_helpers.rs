@@ -122,6 +122,7 @@ pub fn process_pandoc_span(
                         } else {
                             None
                         },
+                        reference_label: None,
                     };
                 }
             }
@@ -277,6 +278,7 @@ pub fn process_pandoc_image(
                         } else {
                             None
                         },
+                        reference_label: None,
                     };
                 }
             }
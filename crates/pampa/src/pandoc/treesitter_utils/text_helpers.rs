@@ -4,8 +4,8 @@
  */
 
 use crate::pandoc::ast_context::ASTContext;
-use crate::pandoc::inline::{Inline, LineBreak, SoftBreak, Space};
-use crate::pandoc::location::node_location;
+use crate::pandoc::inline::{Inline, LineBreak, SoftBreak, Space, Str};
+use crate::pandoc::location::{node_location, node_source_info_with_context};
 use crate::pandoc::treesitter_utils::pandocnativeintermediate::PandocNativeIntermediate;
 use once_cell::sync::Lazy;
 use regex::Regex;
@@ -160,6 +160,38 @@ pub fn process_backslash_escapes(text: String) -> String {
     result
 }
 
+/// Inlines for `$...$` source that isn't math after all: words become `Str`s
+/// (with backslash escapes processed) and runs of blanks become `Space`s.
+pub fn literal_dollar_inlines(
+    node: &tree_sitter::Node,
+    text: &str,
+    context: &ASTContext,
+) -> Vec<Inline> {
+    let node_info = node_source_info_with_context(node, context);
+    let is_blank = |c: char| c == ' ' || c == '\t';
+    let mut inlines = Vec::new();
+    let mut offset = 0;
+    while offset < text.len() {
+        let rest = &text[offset..];
+        let blank = rest.starts_with(is_blank);
+        let len = rest
+            .find(|c: char| is_blank(c) != blank)
+            .unwrap_or(rest.len());
+        let source_info =
+            quarto_source_map::SourceInfo::substring(node_info.clone(), offset, offset + len);
+        inlines.push(if blank {
+            Inline::Space(Space { source_info })
+        } else {
+            Inline::Str(Str {
+                text: process_backslash_escapes(rest[..len].to_string()),
+                source_info,
+            })
+        });
+        offset += len;
+    }
+    inlines
+}
+
 /// Check if a character is ASCII punctuation that can be escaped
 fn is_escapable_punctuation(ch: char) -> bool {
     matches!(
@@ -0,0 +1,573 @@
+/*
+ * mod.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Readers, writers and transforms from outside pampa.
+//!
+//! A plugin implements [`Reader`], [`Writer`] or [`Transform`] and is added
+//! to a [`Registry`]. `--from` and `--to` then accept the names of its
+//! readers and writers, and its transforms run after the filters, in the
+//! order they were registered. With the `wasm-plugins` feature,
+//! `--plugin FILE.wasm` loads plugins compiled to WebAssembly (see
+//! [`wasm`]), which run without access to anything but what they are
+//! given.
+//!
+//! A plugin sees and changes only what its [`Capabilities`] allow. Without
+//! `read_metadata` it is given the document without its metadata, and what
+//! it changes without `write_metadata` or `write_blocks` is undone. Its
+//! options are the `--plugin-option NAME.KEY=VALUE` given for its name,
+//! and no others.
+//!
+//! Built-in formats can't be replaced, and the names of formats can't
+//! contain `+` or `-`, which turn extensions on and off (`html-smart`).
+
+#[cfg(feature = "wasm-plugins")]
+pub mod wasm;
+
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use std::collections::{BTreeMap, HashMap};
+use std::io::Write;
+
+/// The formats of the built-in readers
+pub const BUILTIN_READERS: &[&str] = &[
+    "markdown",
+    "qmd",
+    "json",
+    "commonmark",
+    "gfm",
+    "ipynb",
+    "org",
+    "rst",
+];
+
+/// The formats of the built-in writers
+pub const BUILTIN_WRITERS: &[&str] = &[
+    "json",
+    "native",
+    "markdown",
+    "qmd",
+    "ipynb",
+    "html",
+    "revealjs",
+    "latex",
+    "docx",
+    "epub",
+    "typst",
+    "plaintext",
+    "plain",
+    "ansi",
+];
+
+/// What a plugin may see and change of a document
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Capabilities {
+    /// See the document's metadata
+    pub read_metadata: bool,
+    /// Change the metadata. Implies `read_metadata`.
+    pub write_metadata: bool,
+    /// Change the blocks. Writers never change the document.
+    pub write_blocks: bool,
+}
+
+impl Default for Capabilities {
+    /// See and change the blocks, and nothing else
+    fn default() -> Self {
+        Self {
+            read_metadata: false,
+            write_metadata: false,
+            write_blocks: true,
+        }
+    }
+}
+
+/// What a plugin is called with, besides the document
+#[derive(Debug)]
+pub struct PluginContext<'a> {
+    /// The output format, as given to filters
+    pub to: &'a str,
+    /// The `--plugin-option`s for the plugin's name
+    pub options: &'a BTreeMap<String, String>,
+    /// Warnings and other messages for the user
+    pub diagnostics: Vec<DiagnosticMessage>,
+}
+
+/// Reads an input format into a document
+pub trait Reader: Send + Sync {
+    /// The name `--from` selects it with
+    fn name(&self) -> &str;
+
+    /// Read `input`, from the file `filename`. The returned context holds
+    /// the files the document's source info refers to.
+    fn read(
+        &self,
+        input: &str,
+        filename: &str,
+        context: &mut PluginContext,
+    ) -> Result<(Pandoc, ASTContext), DiagnosticMessage>;
+}
+
+/// Writes a document in an output format
+pub trait Writer: Send + Sync {
+    /// The name `--to` selects it with
+    fn name(&self) -> &str;
+
+    fn capabilities(&self) -> Capabilities {
+        Capabilities::default()
+    }
+
+    fn write(
+        &self,
+        pandoc: &Pandoc,
+        ast_context: &ASTContext,
+        context: &mut PluginContext,
+        output: &mut dyn Write,
+    ) -> Result<(), DiagnosticMessage>;
+}
+
+/// Changes a document between reading and writing
+pub trait Transform: Send + Sync {
+    /// The name its options are given for
+    fn name(&self) -> &str;
+
+    fn capabilities(&self) -> Capabilities {
+        Capabilities::default()
+    }
+
+    fn transform(
+        &self,
+        pandoc: &mut Pandoc,
+        ast_context: &ASTContext,
+        context: &mut PluginContext,
+    ) -> Result<(), DiagnosticMessage>;
+}
+
+/// The result of a plugin: its value and what it reported, or what it
+/// reported and why it failed
+pub type PluginResult<T> = Result<(T, Vec<DiagnosticMessage>), Vec<DiagnosticMessage>>;
+
+/// The plugins of a conversion, and their options
+#[derive(Default)]
+pub struct Registry {
+    readers: Vec<Box<dyn Reader>>,
+    writers: Vec<Box<dyn Writer>>,
+    transforms: Vec<Box<dyn Transform>>,
+    options: HashMap<String, BTreeMap<String, String>>,
+}
+
+impl std::fmt::Debug for Registry {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let names = |plugins: Vec<&str>| plugins.join(", ");
+        f.debug_struct("Registry")
+            .field(
+                "readers",
+                &names(self.readers.iter().map(|r| r.name()).collect()),
+            )
+            .field(
+                "writers",
+                &names(self.writers.iter().map(|w| w.name()).collect()),
+            )
+            .field(
+                "transforms",
+                &names(self.transforms.iter().map(|t| t.name()).collect()),
+            )
+            .field("options", &self.options)
+            .finish()
+    }
+}
+
+impl Registry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Whether no plugins are registered
+    pub fn is_empty(&self) -> bool {
+        self.readers.is_empty() && self.writers.is_empty() && self.transforms.is_empty()
+    }
+
+    /// Add a reader. Fails when its name is a built-in format, is already
+    /// registered or isn't a format name.
+    pub fn register_reader(&mut self, reader: Box<dyn Reader>) -> Result<(), DiagnosticMessage> {
+        let taken = self.reader(reader.name()).is_some();
+        check_format_name(reader.name(), "reader", BUILTIN_READERS, taken)?;
+        self.readers.push(reader);
+        Ok(())
+    }
+
+    /// Add a writer. Fails when its name is a built-in format, is already
+    /// registered or isn't a format name.
+    pub fn register_writer(&mut self, writer: Box<dyn Writer>) -> Result<(), DiagnosticMessage> {
+        let taken = self.writer(writer.name()).is_some();
+        check_format_name(writer.name(), "writer", BUILTIN_WRITERS, taken)?;
+        self.writers.push(writer);
+        Ok(())
+    }
+
+    /// Add a transform, to run after those already registered
+    pub fn register_transform(&mut self, transform: Box<dyn Transform>) {
+        self.transforms.push(transform);
+    }
+
+    /// Set an option from a `NAME.KEY=VALUE` argument
+    pub fn set_option(&mut self, argument: &str) -> Result<(), String> {
+        let parsed = argument
+            .split_once('=')
+            .and_then(|(name_key, value)| Some((name_key.split_once('.')?, value)));
+        let Some(((name, key), value)) =
+            parsed.filter(|((name, key), _)| !name.is_empty() && !key.is_empty())
+        else {
+            return Err(format!(
+                "Invalid --plugin-option '{}': expected NAME.KEY=VALUE",
+                argument
+            ));
+        };
+        self.options
+            .entry(name.to_string())
+            .or_default()
+            .insert(key.to_string(), value.to_string());
+        Ok(())
+    }
+
+    pub fn reader(&self, name: &str) -> Option<&dyn Reader> {
+        self.readers
+            .iter()
+            .find(|reader| reader.name() == name)
+            .map(|reader| reader.as_ref())
+    }
+
+    pub fn writer(&self, name: &str) -> Option<&dyn Writer> {
+        self.writers
+            .iter()
+            .find(|writer| writer.name() == name)
+            .map(|writer| writer.as_ref())
+    }
+
+    /// The names of the registered transforms, in the order they run
+    pub fn transform_names(&self) -> impl Iterator<Item = &str> {
+        self.transforms.iter().map(|transform| transform.name())
+    }
+
+    /// Read `input` with the reader for `from`, if one is registered
+    pub fn read(
+        &self,
+        from: &str,
+        input: &str,
+        filename: &str,
+        to: &str,
+    ) -> Option<PluginResult<(Pandoc, ASTContext)>> {
+        let reader = self.reader(from)?;
+        let mut context = self.context(reader.name(), to);
+        let result = reader.read(input, filename, &mut context);
+        Some(finish(result, context.diagnostics))
+    }
+
+    /// Write the document with the writer for `to`, if one is registered
+    pub fn write(
+        &self,
+        to: &str,
+        pandoc: &Pandoc,
+        ast_context: &ASTContext,
+    ) -> Option<PluginResult<Vec<u8>>> {
+        let writer = self.writer(to)?;
+        let mut context = self.context(writer.name(), to);
+        let capabilities = writer.capabilities();
+        let without_metadata;
+        let visible = if capabilities.read_metadata || capabilities.write_metadata {
+            pandoc
+        } else {
+            without_metadata = Pandoc {
+                meta: Default::default(),
+                blocks: pandoc.blocks.clone(),
+            };
+            &without_metadata
+        };
+        let mut output = Vec::new();
+        let result = writer
+            .write(visible, ast_context, &mut context, &mut output)
+            .map(|()| output);
+        Some(finish(result, context.diagnostics))
+    }
+
+    /// Run the registered transforms in order, each on what the one before
+    /// it made. Stops at the first that fails.
+    pub fn transform(
+        &self,
+        pandoc: &mut Pandoc,
+        ast_context: &ASTContext,
+        to: &str,
+    ) -> PluginResult<()> {
+        let mut diagnostics = Vec::new();
+        for transform in &self.transforms {
+            let mut context = self.context(transform.name(), to);
+            let result = scoped(pandoc, transform.capabilities(), |pandoc| {
+                transform.transform(pandoc, ast_context, &mut context)
+            });
+            diagnostics.append(&mut context.diagnostics);
+            if let Err(diagnostic) = result {
+                diagnostics.push(diagnostic);
+                return Err(diagnostics);
+            }
+        }
+        Ok(((), diagnostics))
+    }
+
+    fn context<'a>(&'a self, name: &str, to: &'a str) -> PluginContext<'a> {
+        static NO_OPTIONS: BTreeMap<String, String> = BTreeMap::new();
+        PluginContext {
+            to,
+            options: self.options.get(name).unwrap_or(&NO_OPTIONS),
+            diagnostics: Vec::new(),
+        }
+    }
+}
+
+fn finish<T>(
+    result: Result<T, DiagnosticMessage>,
+    mut diagnostics: Vec<DiagnosticMessage>,
+) -> PluginResult<T> {
+    match result {
+        Ok(value) => Ok((value, diagnostics)),
+        Err(diagnostic) => {
+            diagnostics.push(diagnostic);
+            Err(diagnostics)
+        }
+    }
+}
+
+/// Run `f` on the document as far as `capabilities` let a plugin see it,
+/// and undo what they don't let it change
+fn scoped<T>(
+    pandoc: &mut Pandoc,
+    capabilities: Capabilities,
+    f: impl FnOnce(&mut Pandoc) -> T,
+) -> T {
+    let saved_meta = (!capabilities.write_metadata).then(|| {
+        if capabilities.read_metadata {
+            pandoc.meta.clone()
+        } else {
+            std::mem::take(&mut pandoc.meta)
+        }
+    });
+    let saved_blocks = (!capabilities.write_blocks).then(|| pandoc.blocks.clone());
+    let result = f(pandoc);
+    if let Some(meta) = saved_meta {
+        pandoc.meta = meta;
+    }
+    if let Some(blocks) = saved_blocks {
+        pandoc.blocks = blocks;
+    }
+    result
+}
+
+fn check_format_name(
+    name: &str,
+    kind: &str,
+    builtin: &[&str],
+    taken: bool,
+) -> Result<(), DiagnosticMessage> {
+    let problem = if name.is_empty() || name.contains(['+', '-']) {
+        format!(
+            "`{}` can't be the name of a {}: names can't be empty or contain `+` or `-`",
+            name, kind
+        )
+    } else if builtin.contains(&name) {
+        format!("The built-in {} for `{}` can't be replaced", kind, name)
+    } else if taken {
+        format!("A {} for `{}` is already registered", kind, name)
+    } else {
+        return Ok(());
+    };
+    Err(DiagnosticMessageBuilder::error("Invalid plugin")
+        .with_code("Q-6-1")
+        .problem(problem)
+        .build())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::{Block, Inline, Paragraph, Str};
+    use quarto_source_map::SourceInfo;
+
+    fn paragraph(text: &str) -> Block {
+        Block::Paragraph(Paragraph {
+            content: vec![Inline::Str(Str {
+                text: text.to_string(),
+                source_info: SourceInfo::default(),
+            })],
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn document() -> Pandoc {
+        let mut pandoc = crate::readers::qmd::read(
+            b"---\ntitle: Report\n---\n\nText.\n",
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap()
+        .0;
+        pandoc.blocks.push(paragraph("More."));
+        pandoc
+    }
+
+    /// Replaces every block with one paragraph saying whether it saw a
+    /// title, and adds `transformed` to the metadata
+    struct Marker(Capabilities);
+
+    impl Transform for Marker {
+        fn name(&self) -> &str {
+            "marker"
+        }
+
+        fn capabilities(&self) -> Capabilities {
+            self.0
+        }
+
+        fn transform(
+            &self,
+            pandoc: &mut Pandoc,
+            _ast_context: &ASTContext,
+            context: &mut PluginContext,
+        ) -> Result<(), DiagnosticMessage> {
+            let seen = if pandoc.meta.get("title").is_some() {
+                "title"
+            } else {
+                "no title"
+            };
+            let suffix = context.options.get("suffix").cloned().unwrap_or_default();
+            pandoc.blocks = vec![paragraph(&format!("{}{}", seen, suffix))];
+            pandoc.meta = Default::default();
+            context
+                .diagnostics
+                .push(DiagnosticMessage::warning("marked"));
+            Ok(())
+        }
+    }
+
+    /// Writes the count of blocks and whether there is a title
+    struct Counter;
+
+    impl Writer for Counter {
+        fn name(&self) -> &str {
+            "count"
+        }
+
+        fn write(
+            &self,
+            pandoc: &Pandoc,
+            _ast_context: &ASTContext,
+            _context: &mut PluginContext,
+            output: &mut dyn Write,
+        ) -> Result<(), DiagnosticMessage> {
+            let title = pandoc.meta.get("title").is_some();
+            write!(output, "{} {}", pandoc.blocks.len(), title)
+                .map_err(|e| DiagnosticMessage::error(e.to_string()))
+        }
+    }
+
+    fn text(pandoc: &Pandoc) -> String {
+        let (text, _) = crate::writers::plaintext::blocks_to_string(&pandoc.blocks);
+        text.trim().to_string()
+    }
+
+    #[test]
+    fn test_transforms_see_and_change_what_they_may() {
+        let cases = [
+            (Capabilities::default(), "no title", true),
+            (
+                Capabilities {
+                    read_metadata: true,
+                    ..Default::default()
+                },
+                "title",
+                true,
+            ),
+            (
+                Capabilities {
+                    read_metadata: true,
+                    write_metadata: true,
+                    write_blocks: false,
+                },
+                "Text.\n\nMore.",
+                false,
+            ),
+        ];
+        for (capabilities, expected, keeps_title) in cases {
+            let mut registry = Registry::new();
+            registry.register_transform(Box::new(Marker(capabilities)));
+            let mut pandoc = document();
+            let ((), diagnostics) = registry
+                .transform(&mut pandoc, &ASTContext::new(), "html")
+                .unwrap();
+            assert_eq!(text(&pandoc), expected, "{:?}", capabilities);
+            assert_eq!(pandoc.meta.get("title").is_some(), keeps_title);
+            assert_eq!(diagnostics.len(), 1);
+        }
+    }
+
+    #[test]
+    fn test_options_are_per_plugin() {
+        let mut registry = Registry::new();
+        registry.register_transform(Box::new(Marker(Capabilities::default())));
+        registry.set_option("marker.suffix=!").unwrap();
+        registry.set_option("other.suffix=?").unwrap();
+        let mut pandoc = document();
+        registry
+            .transform(&mut pandoc, &ASTContext::new(), "html")
+            .unwrap();
+        assert_eq!(text(&pandoc), "no title!");
+
+        assert!(registry.set_option("suffix=!").is_err());
+        assert!(registry.set_option("marker.suffix").is_err());
+    }
+
+    #[test]
+    fn test_writers() {
+        let mut registry = Registry::new();
+        registry.register_writer(Box::new(Counter)).unwrap();
+        let (output, _) = registry
+            .write("count", &document(), &ASTContext::new())
+            .unwrap()
+            .unwrap();
+        // Without `read_metadata`, the title isn't there
+        assert_eq!(output, b"2 false");
+        assert!(
+            registry
+                .write("org", &document(), &ASTContext::new())
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn test_format_names() {
+        struct Named(&'static str);
+        impl Writer for Named {
+            fn name(&self) -> &str {
+                self.0
+            }
+            fn write(
+                &self,
+                _pandoc: &Pandoc,
+                _ast_context: &ASTContext,
+                _context: &mut PluginContext,
+                _output: &mut dyn Write,
+            ) -> Result<(), DiagnosticMessage> {
+                Ok(())
+            }
+        }
+
+        let mut registry = Registry::new();
+        assert!(registry.register_writer(Box::new(Named("org"))).is_ok());
+        for name in ["org", "html", "typst-tufte", ""] {
+            let error = registry.register_writer(Box::new(Named(name))).unwrap_err();
+            assert_eq!(error.code.as_deref(), Some("Q-6-1"), "{}", name);
+        }
+    }
+}
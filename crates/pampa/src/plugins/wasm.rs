@@ -0,0 +1,376 @@
+/*
+ * wasm.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Plugins compiled to WebAssembly.
+//!
+//! A module is given no imports: it can't read files, open sockets or
+//! see the environment, and a module that imports anything is refused.
+//! Every call runs in a new instance, with at most [`MEMORY_LIMIT`] bytes
+//! of memory and [`FUEL`] units of fuel, so a plugin can't keep state
+//! between documents or hang a conversion.
+//!
+//! Everything crosses the boundary as JSON, with documents in pampa's JSON
+//! AST (what `--to json` writes). A module exports:
+//!
+//! - `memory`
+//! - `pampa_alloc(len: i32) -> i32`, which returns room for `len` bytes
+//! - `pampa_plugin() -> i64`, which returns its manifest:
+//!   `{"name": "...", "readers": [...], "writers": [...], "transforms": [...],
+//!   "capabilities": {"read-metadata": bool, "write-metadata": bool,
+//!   "write-blocks": bool}}`
+//!
+//! and, for what its manifest lists, `pampa_read`, `pampa_write` and
+//! `pampa_transform`, each `(ptr: i32, len: i32) -> i64`. Those are given
+//! a request written to memory from `pampa_alloc`:
+//!
+//! - read: `{"format", "input", "filename", "options"}`
+//! - write: `{"format", "document", "options"}`
+//! - transform: `{"name", "to", "document", "options"}`
+//!
+//! and return `{"document": ...}` (read and transform), `{"output": "..."}`
+//! (write) or `{"error": "..."}`, with an optional `"warnings": [...]` of
+//! strings. Results are returned as `ptr << 32 | len`.
+
+use super::{Capabilities, PluginContext, Reader, Registry, Transform, Writer};
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+use crate::{readers, writers};
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use serde_json::{Value, json};
+use std::io::Write;
+use std::path::Path;
+use std::sync::Arc;
+use wasmtime::{Config, Engine, Instance, Module, Store, StoreLimits, StoreLimitsBuilder};
+
+/// The most memory an instance may grow to
+pub const MEMORY_LIMIT: usize = 256 * 1024 * 1024;
+
+/// The fuel a call may use, roughly as many instructions
+pub const FUEL: u64 = 10_000_000_000;
+
+/// Load the plugin in `path` and register its readers, writers and
+/// transforms
+pub fn load(path: &Path, registry: &mut Registry) -> Result<(), DiagnosticMessage> {
+    let (plugin, manifest) = WasmPlugin::new(path)?;
+    let plugin = Arc::new(plugin);
+    for format in names(&manifest, "readers") {
+        registry.register_reader(Box::new(WasmReader {
+            plugin: plugin.clone(),
+            format,
+        }))?;
+    }
+    for format in names(&manifest, "writers") {
+        registry.register_writer(Box::new(WasmWriter {
+            plugin: plugin.clone(),
+            format,
+        }))?;
+    }
+    for name in names(&manifest, "transforms") {
+        registry.register_transform(Box::new(WasmTransform {
+            plugin: plugin.clone(),
+            name,
+        }));
+    }
+    Ok(())
+}
+
+fn names(manifest: &Value, key: &str) -> Vec<String> {
+    manifest[key]
+        .as_array()
+        .into_iter()
+        .flatten()
+        .filter_map(|name| name.as_str().map(str::to_string))
+        .collect()
+}
+
+struct WasmPlugin {
+    path: String,
+    engine: Engine,
+    module: Module,
+    capabilities: Capabilities,
+}
+
+impl WasmPlugin {
+    /// Compile the module in `path`, and return it with its manifest
+    fn new(path: &Path) -> Result<(Self, Value), DiagnosticMessage> {
+        let display = path.display().to_string();
+        let mut config = Config::new();
+        config.consume_fuel(true);
+        let engine = Engine::new(&config).map_err(|e| invalid(&display, e))?;
+        let module = Module::from_file(&engine, path).map_err(|e| invalid(&display, e))?;
+        if let Some(import) = module.imports().next() {
+            return Err(invalid(
+                &display,
+                format!(
+                    "it imports `{}::{}`, and plugins are given no imports",
+                    import.module(),
+                    import.name()
+                ),
+            ));
+        }
+        let mut plugin = Self {
+            path: display,
+            engine,
+            module,
+            capabilities: Capabilities::default(),
+        };
+        let manifest = plugin.manifest()?;
+        let allowed =
+            |key: &str, default: bool| manifest["capabilities"][key].as_bool().unwrap_or(default);
+        plugin.capabilities = Capabilities {
+            read_metadata: allowed("read-metadata", false),
+            write_metadata: allowed("write-metadata", false),
+            write_blocks: allowed("write-blocks", true),
+        };
+        Ok((plugin, manifest))
+    }
+
+    fn manifest(&self) -> Result<Value, DiagnosticMessage> {
+        let (mut store, instance) = self.instantiate()?;
+        let function = instance
+            .get_typed_func::<(), i64>(&mut store, "pampa_plugin")
+            .map_err(|e| invalid(&self.path, e))?;
+        let packed = function
+            .call(&mut store, ())
+            .map_err(|e| invalid(&self.path, e))?;
+        let manifest = self
+            .read_result(&mut store, &instance, packed)
+            .map_err(|e| invalid(&self.path, e))?;
+        if !manifest["name"].is_string() {
+            return Err(invalid(&self.path, "its manifest has no \"name\""));
+        }
+        Ok(manifest)
+    }
+
+    fn instantiate(&self) -> Result<(Store<StoreLimits>, Instance), DiagnosticMessage> {
+        let limits = StoreLimitsBuilder::new().memory_size(MEMORY_LIMIT).build();
+        let mut store = Store::new(&self.engine, limits);
+        store.limiter(|limits| limits);
+        store.set_fuel(FUEL).map_err(|e| invalid(&self.path, e))?;
+        let instance =
+            Instance::new(&mut store, &self.module, &[]).map_err(|e| invalid(&self.path, e))?;
+        Ok((store, instance))
+    }
+
+    /// Call `entry` with `request` and return its response, with the
+    /// warnings it gave added to `context`
+    fn call(
+        &self,
+        entry: &str,
+        request: Value,
+        context: &mut PluginContext,
+    ) -> Result<Value, DiagnosticMessage> {
+        let (mut store, instance) = self.instantiate()?;
+        let response = (|| -> Result<Value, String> {
+            let memory = instance
+                .get_memory(&mut store, "memory")
+                .ok_or("it exports no `memory`")?;
+            let alloc = instance
+                .get_typed_func::<i32, i32>(&mut store, "pampa_alloc")
+                .map_err(|e| e.to_string())?;
+            let function = instance
+                .get_typed_func::<(i32, i32), i64>(&mut store, entry)
+                .map_err(|e| e.to_string())?;
+            let bytes = serde_json::to_vec(&request).map_err(|e| e.to_string())?;
+            let len = i32::try_from(bytes.len()).map_err(|e| e.to_string())?;
+            let ptr = alloc.call(&mut store, len).map_err(|e| e.to_string())?;
+            memory
+                .write(&mut store, ptr as u32 as usize, &bytes)
+                .map_err(|e| e.to_string())?;
+            let packed = function
+                .call(&mut store, (ptr, len))
+                .map_err(|e| e.to_string())?;
+            self.read_result(&mut store, &instance, packed)
+        })()
+        .map_err(|e| failed(&self.path, entry, e))?;
+
+        for warning in response["warnings"].as_array().into_iter().flatten() {
+            if let Some(warning) = warning.as_str() {
+                context.diagnostics.push(
+                    DiagnosticMessageBuilder::warning(warning.to_string())
+                        .add_info(format!("Reported by the plugin `{}`", self.path))
+                        .build(),
+                );
+            }
+        }
+        if let Some(error) = response.get("error") {
+            let error = error
+                .as_str()
+                .map_or_else(|| error.to_string(), str::to_string);
+            return Err(failed(&self.path, entry, error));
+        }
+        Ok(response)
+    }
+
+    fn read_result(
+        &self,
+        store: &mut Store<StoreLimits>,
+        instance: &Instance,
+        packed: i64,
+    ) -> Result<Value, String> {
+        let memory = instance
+            .get_memory(&mut *store, "memory")
+            .ok_or("it exports no `memory`")?;
+        let ptr = (packed >> 32) as u32 as usize;
+        let len = packed as u32 as usize;
+        // Checked against the module's memory before anything is copied, so
+        // that a length the plugin makes up can't make the host allocate it
+        let bytes = ptr
+            .checked_add(len)
+            .filter(|&end| end <= memory.data_size(&*store))
+            .and_then(|end| memory.data(&*store).get(ptr..end))
+            .ok_or("it returned a result outside its memory")?;
+        serde_json::from_slice(bytes).map_err(|e| format!("it returned invalid JSON: {}", e))
+    }
+
+    fn options(context: &PluginContext) -> Value {
+        json!(context.options)
+    }
+}
+
+fn to_json(pandoc: &Pandoc, ast_context: &ASTContext) -> Result<Value, DiagnosticMessage> {
+    let mut buf = Vec::new();
+    let config = writers::json::JsonConfig {
+        include_inline_locations: true,
+        include_block_ids: false,
+    };
+    writers::json::write_with_config(pandoc, ast_context, &mut buf, &config).map_err(
+        |diagnostics| {
+            diagnostics
+                .into_iter()
+                .next()
+                .unwrap_or_else(|| DiagnosticMessage::error("Failed to write the document as JSON"))
+        },
+    )?;
+    serde_json::from_slice(&buf).map_err(|e| DiagnosticMessage::error(e.to_string()))
+}
+
+fn from_json(
+    plugin: &WasmPlugin,
+    entry: &str,
+    response: &Value,
+) -> Result<(Pandoc, ASTContext), DiagnosticMessage> {
+    let document = response
+        .get("document")
+        .ok_or_else(|| failed(&plugin.path, entry, "it returned no \"document\""))?;
+    let bytes = serde_json::to_vec(document).map_err(|e| failed(&plugin.path, entry, e))?;
+    readers::json::read(&mut bytes.as_slice()).map_err(|e| failed(&plugin.path, entry, e))
+}
+
+struct WasmReader {
+    plugin: Arc<WasmPlugin>,
+    format: String,
+}
+
+impl Reader for WasmReader {
+    fn name(&self) -> &str {
+        &self.format
+    }
+
+    fn read(
+        &self,
+        input: &str,
+        filename: &str,
+        context: &mut PluginContext,
+    ) -> Result<(Pandoc, ASTContext), DiagnosticMessage> {
+        let request = json!({
+            "format": self.format,
+            "input": input,
+            "filename": filename,
+            "options": WasmPlugin::options(context),
+        });
+        let response = self.plugin.call("pampa_read", request, context)?;
+        from_json(&self.plugin, "pampa_read", &response)
+    }
+}
+
+struct WasmWriter {
+    plugin: Arc<WasmPlugin>,
+    format: String,
+}
+
+impl Writer for WasmWriter {
+    fn name(&self) -> &str {
+        &self.format
+    }
+
+    fn capabilities(&self) -> Capabilities {
+        self.plugin.capabilities
+    }
+
+    fn write(
+        &self,
+        pandoc: &Pandoc,
+        ast_context: &ASTContext,
+        context: &mut PluginContext,
+        output: &mut dyn Write,
+    ) -> Result<(), DiagnosticMessage> {
+        let request = json!({
+            "format": self.format,
+            "document": to_json(pandoc, ast_context)?,
+            "options": WasmPlugin::options(context),
+        });
+        let response = self.plugin.call("pampa_write", request, context)?;
+        let text = response["output"].as_str().ok_or_else(|| {
+            failed(
+                &self.plugin.path,
+                "pampa_write",
+                "it returned no \"output\"",
+            )
+        })?;
+        output
+            .write_all(text.as_bytes())
+            .map_err(|e| failed(&self.plugin.path, "pampa_write", e))
+    }
+}
+
+struct WasmTransform {
+    plugin: Arc<WasmPlugin>,
+    name: String,
+}
+
+impl Transform for WasmTransform {
+    fn name(&self) -> &str {
+        &self.name
+    }
+
+    fn capabilities(&self) -> Capabilities {
+        self.plugin.capabilities
+    }
+
+    fn transform(
+        &self,
+        pandoc: &mut Pandoc,
+        ast_context: &ASTContext,
+        context: &mut PluginContext,
+    ) -> Result<(), DiagnosticMessage> {
+        let request = json!({
+            "name": self.name,
+            "to": context.to,
+            "document": to_json(pandoc, ast_context)?,
+            "options": WasmPlugin::options(context),
+        });
+        let response = self.plugin.call("pampa_transform", request, context)?;
+        let (transformed, _context) = from_json(&self.plugin, "pampa_transform", &response)?;
+        *pandoc = transformed;
+        Ok(())
+    }
+}
+
+fn invalid(path: &str, problem: impl std::fmt::Display) -> DiagnosticMessage {
+    DiagnosticMessageBuilder::error("Invalid plugin")
+        .with_code("Q-6-1")
+        .problem(format!("`{}` can't be loaded as a plugin", path))
+        .add_detail(problem.to_string())
+        .build()
+}
+
+fn failed(path: &str, entry: &str, problem: impl std::fmt::Display) -> DiagnosticMessage {
+    DiagnosticMessageBuilder::error("Plugin failed")
+        .with_code("Q-6-2")
+        .problem(format!("`{}` of the plugin `{}` failed", entry, path))
+        .add_detail(problem.to_string())
+        .build()
+}
@@ -0,0 +1,154 @@
+/*
+ * query.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa query SELECTOR [FILES]`: the elements of documents that a
+//! selector matches (see `ast_query`), one per line with where they are,
+//! or with `--json` as a list of matches.
+//!
+//! Files are read as for `pampa diff`; without files, qmd is read from
+//! stdin.
+
+use super::diff::{parse_document, read_document};
+use super::{Args, ConversionFailed, Messages};
+use crate::ast_query::{self, QueryMatch, Selector};
+use crate::pandoc::{ASTContext, Pandoc};
+use quarto_source_map::FileId;
+use std::io::{Read, Write};
+use std::path::PathBuf;
+
+/// Characters of an element's text shown on its line
+const MAX_TEXT_CHARS: usize = 40;
+
+/// Run the query and return the exit code: 0 when something matches, 1
+/// when nothing does, 2 when the selector is invalid or a file can't be
+/// read
+pub fn run(args: &Args, selector: &str, files: &[PathBuf], json: bool) -> i32 {
+    let selector = match Selector::parse(selector) {
+        Ok(selector) => selector,
+        Err(e) => {
+            eprintln!("Invalid selector '{}': {}", selector, e);
+            return 2;
+        }
+    };
+    let mut stdout = std::io::stdout();
+    let mut stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+
+    let documents = match read_documents(args, files, &mut messages) {
+        Ok(documents) => documents,
+        Err(ConversionFailed) => return 2,
+    };
+    let mut results = Vec::new();
+    for (filename, pandoc, context) in &documents {
+        match ast_query::query(pandoc, context, &selector) {
+            Ok(matches) => results.push((filename.as_str(), context, matches)),
+            Err(diagnostics) => {
+                messages.report_all(&diagnostics, &context.source_context);
+                return 2;
+            }
+        }
+    }
+
+    let mut stdout = std::io::stdout();
+    let written = if json {
+        let entries: Vec<serde_json::Value> = results
+            .iter()
+            .flat_map(|(filename, _, matches)| {
+                matches.iter().map(move |found| {
+                    let mut entry = serde_json::to_value(found).unwrap();
+                    entry["file"] = serde_json::json!(filename);
+                    entry
+                })
+            })
+            .collect();
+        serde_json::to_writer_pretty(&mut stdout, &entries)
+            .map_err(std::io::Error::from)
+            .and_then(|_| writeln!(stdout))
+    } else {
+        results
+            .iter()
+            .flat_map(|(filename, context, matches)| {
+                matches
+                    .iter()
+                    .map(move |found| match_line(filename, context, found))
+            })
+            .try_for_each(|line| writeln!(stdout, "{}", line))
+    };
+    if let Err(e) = written {
+        eprintln!("Failed to write the matches: {}", e);
+        return 2;
+    }
+    if results.iter().any(|(_, _, matches)| !matches.is_empty()) {
+        0
+    } else {
+        1
+    }
+}
+
+fn read_documents(
+    args: &Args,
+    files: &[PathBuf],
+    messages: &mut Messages,
+) -> Result<Vec<(String, Pandoc, ASTContext)>, ConversionFailed> {
+    if files.is_empty() {
+        let mut input = String::new();
+        if let Err(e) = std::io::stdin().read_to_string(&mut input) {
+            messages.error(format_args!("Failed to read stdin: {}", e));
+            return Err(ConversionFailed);
+        }
+        let (pandoc, context) = parse_document(args, "<stdin>", input, None, messages)?;
+        return Ok(vec![("<stdin>".to_string(), pandoc, context)]);
+    }
+    files
+        .iter()
+        .map(|path| {
+            let (pandoc, context) = read_document(args, path, messages)?;
+            Ok((path.to_string_lossy().to_string(), pandoc, context))
+        })
+        .collect()
+}
+
+/// A match for people, like a compiler message:
+///
+/// ```text
+/// doc.qmd:3:1: 2.1 Str "Introduction"
+/// ```
+///
+/// The path counts from 1, as in `pampa diff`.
+fn match_line(filename: &str, context: &ASTContext, found: &QueryMatch) -> String {
+    // The location is `file:line:col-line:col`, with the file by number
+    let start = found.location.as_deref().and_then(|location| {
+        let (file, range) = location.split_once(':')?;
+        let (start, _end) = range.split_once('-')?;
+        let file = FileId(file.parse::<usize>().ok()?);
+        let name = context
+            .source_context
+            .get_file(file)
+            .map_or(filename, |file| file.path.as_str());
+        Some(format!("{}:{}", name, start))
+    });
+    let path: Vec<String> = found.path.iter().map(|i| (i + 1).to_string()).collect();
+    let mut line = format!(
+        "{}: {} {}",
+        start.unwrap_or_else(|| filename.to_string()),
+        path.join("."),
+        found.kind
+    );
+    if !found.text.is_empty() {
+        let text = if found.text.chars().count() > MAX_TEXT_CHARS {
+            let start: String = found.text.chars().take(MAX_TEXT_CHARS).collect();
+            format!("{}…", start.trim_end())
+        } else {
+            found.text.clone()
+        };
+        line.push_str(&format!(" \"{}\"", text));
+    }
+    line
+}
@@ -9,10 +9,10 @@
  */
 
 use crate::pandoc::ast_context::ASTContext;
-use comrak::{Arena, Options, parse_document};
-use comrak_to_pandoc::{SourceLocationContext, convert_document_with_source};
+use comrak::{Arena, parse_document};
+use comrak_to_pandoc::{Dialect, SourceLocationContext, convert_document_with_dialect};
 use quarto_pandoc_types::Pandoc;
-use quarto_source_map::FileId;
+use quarto_source_map::SourceContext;
 
 /// Read CommonMark input and convert to Pandoc AST with source tracking.
 ///
@@ -23,26 +23,39 @@ use quarto_source_map::FileId;
 /// # Returns
 /// A tuple of (Pandoc document, ASTContext with source info)
 pub fn read(input: &str, filename: &str) -> (Pandoc, ASTContext) {
-    let arena = Arena::new();
+    read_dialect(input, filename, Dialect::CommonMark)
+}
+
+/// Read GitHub Flavored Markdown input and convert to Pandoc AST with
+/// source tracking.
+///
+/// Unlike the qmd reader, this knows nothing about shortcodes, div fences
+/// or editor comments; it accepts what GitHub renders in a README (tables,
+/// strikethrough, autolinks, task lists, footnotes and raw HTML).
+pub fn read_gfm(input: &str, filename: &str) -> (Pandoc, ASTContext) {
+    read_dialect(input, filename, Dialect::Gfm)
+}
 
-    // Use pure CommonMark options (no GFM extensions)
-    let options = Options::default();
+fn read_dialect(input: &str, filename: &str, dialect: Dialect) -> (Pandoc, ASTContext) {
+    let arena = Arena::new();
 
-    // Parse with comrak
+    // Parse with comrak, enabling only the extensions of the dialect
+    let options = dialect.comrak_options();
     let root = parse_document(&arena, input, &options);
 
-    // Set up source tracking
+    // Set up source tracking. The input must be FileId(0) with its content,
+    // so locations resolve even when it was read from stdin.
     let mut context = ASTContext::with_filename(filename.to_string());
-    context
+    context.source_context = SourceContext::new();
+    let file_id = context
         .source_context
         .add_file(filename.to_string(), Some(input.to_string()));
-    let file_id = FileId(0); // First file added gets ID 0
 
     // Create source location context for conversion
     let source_ctx = SourceLocationContext::new(input, file_id);
 
     // Convert to Pandoc AST with source tracking
-    let pandoc = convert_document_with_source(root, Some(&source_ctx));
+    let pandoc = convert_document_with_dialect(root, Some(&source_ctx), dialect);
 
     (pandoc, context)
 }
@@ -124,4 +137,27 @@ mod tests {
             _ => panic!("Expected Paragraph"),
         }
     }
+
+    #[test]
+    fn test_gfm_ignores_qmd_syntax() {
+        let (pandoc, _ctx) = read_gfm("::: note\n{{< meta title >}}\n:::\n", "README.md");
+        // Div fences and shortcodes are plain text in GFM
+        assert_eq!(pandoc.blocks.len(), 1);
+        assert!(matches!(pandoc.blocks[0], Block::Paragraph(_)));
+    }
+
+    #[test]
+    fn test_gfm_autolink_and_strikethrough() {
+        let (pandoc, _ctx) = read_gfm("~~old~~ https://example.com\n", "README.md");
+        match &pandoc.blocks[0] {
+            Block::Paragraph(p) => {
+                assert!(matches!(p.content[0], Inline::Strikeout(_)));
+                match &p.content[2] {
+                    Inline::Link(link) => assert_eq!(link.target.0, "https://example.com"),
+                    _ => panic!("Expected Link"),
+                }
+            }
+            _ => panic!("Expected Paragraph"),
+        }
+    }
 }
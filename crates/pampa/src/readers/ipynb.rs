@@ -0,0 +1,352 @@
+/*
+ * ipynb.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Jupyter notebook reader.
+ *
+ * Notebooks are mapped onto the same AST the qmd reader produces, so that
+ * `pampa -f ipynb -t qmd` behaves like `quarto convert`:
+ *
+ * - Markdown cells are parsed with the qmd reader and their blocks are
+ *   spliced into the document.
+ * - Code cells become executable code blocks (`{python}` style classes).
+ *   The cell id becomes the block id, and the execution count and cell
+ *   metadata become key-value attributes. Non-string metadata values are
+ *   stored as JSON text.
+ * - Cell outputs are kept verbatim as an `ipynb-outputs` raw block right
+ *   after their code block, holding the JSON array of outputs.
+ * - A raw cell holding YAML front matter becomes document metadata. Other
+ *   raw cells become raw blocks, with the format taken from the cell's
+ *   mimetype (`ipynb` if it has none).
+ * - The notebook metadata (kernelspec, language_info, ...) becomes the
+ *   `jupyter` metadata map.
+ */
+
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::{AttrSourceInfo, Block, CodeBlock, Pandoc, RawBlock};
+use hashlink::LinkedHashMap;
+use quarto_error_reporting::DiagnosticMessage;
+use quarto_pandoc_types::{ConfigMapEntry, ConfigValue, ConfigValueKind};
+use quarto_source_map::{SourceContext, SourceInfo};
+use serde_json::Value;
+use yaml_rust2::Yaml;
+
+/// Raw block format holding the JSON outputs of the preceding code cell
+pub const OUTPUTS_FORMAT: &str = "ipynb-outputs";
+
+/// Raw block format of raw cells without a mimetype
+pub const RAW_CELL_FORMAT: &str = "ipynb";
+
+/// Raw cell mimetypes and the raw block formats they correspond to
+pub const RAW_MIMETYPES: &[(&str, &str)] = &[
+    ("text/html", "html"),
+    ("text/latex", "latex"),
+    ("text/markdown", "markdown"),
+    ("text/restructuredtext", "rst"),
+    ("text/asciidoc", "asciidoc"),
+    ("text/x-typst", "typst"),
+];
+
+#[derive(Debug)]
+pub enum IpynbReadError {
+    InvalidJson(serde_json::Error),
+    InvalidNotebook(String),
+    UnsupportedVersion(u64),
+    /// A markdown or raw cell failed to parse as qmd
+    CellParse {
+        cell: usize,
+        diagnostics: Vec<DiagnosticMessage>,
+        /// Source context holding the cell's text, for rendering diagnostics
+        source_context: SourceContext,
+    },
+}
+
+impl std::fmt::Display for IpynbReadError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            IpynbReadError::InvalidJson(e) => write!(f, "Invalid JSON: {}", e),
+            IpynbReadError::InvalidNotebook(msg) => write!(f, "Invalid notebook: {}", msg),
+            IpynbReadError::UnsupportedVersion(version) => write!(
+                f,
+                "Unsupported nbformat {}: only version 4 notebooks are supported",
+                version
+            ),
+            IpynbReadError::CellParse { cell, .. } => {
+                write!(f, "Cell {} could not be parsed", cell + 1)
+            }
+        }
+    }
+}
+
+impl std::error::Error for IpynbReadError {}
+
+type Result<T> = std::result::Result<T, IpynbReadError>;
+
+/// Cell sources are either one string or a list of lines
+fn cell_source(cell: &Value) -> String {
+    match cell.get("source") {
+        Some(Value::String(text)) => text.clone(),
+        Some(Value::Array(lines)) => lines.iter().filter_map(Value::as_str).collect(),
+        _ => String::new(),
+    }
+}
+
+/// Convert a JSON value to a metadata value
+fn json_to_config_value(value: &Value) -> ConfigValue {
+    let source_info = SourceInfo::default();
+    match value {
+        Value::Null => ConfigValue::null(source_info),
+        Value::Bool(b) => ConfigValue::new_bool(*b, source_info),
+        Value::Number(n) => match n.as_i64() {
+            Some(i) => ConfigValue::new_scalar(Yaml::Integer(i), source_info),
+            None => ConfigValue::new_scalar(Yaml::Real(n.to_string()), source_info),
+        },
+        Value::String(s) => ConfigValue::new_string(s.clone(), source_info),
+        Value::Array(items) => ConfigValue::new_array(
+            items.iter().map(json_to_config_value).collect(),
+            source_info,
+        ),
+        Value::Object(map) => ConfigValue::new_map(
+            map.iter()
+                .map(|(key, value)| ConfigMapEntry {
+                    key: key.clone(),
+                    key_source: SourceInfo::default(),
+                    value: json_to_config_value(value),
+                })
+                .collect(),
+            source_info,
+        ),
+    }
+}
+
+/// Add the entries of `from` to `into`, keeping existing keys
+fn merge_meta(into: &mut Vec<ConfigMapEntry>, from: ConfigValue) {
+    if let ConfigValueKind::Map(entries) = from.value {
+        for entry in entries {
+            if !into.iter().any(|existing| existing.key == entry.key) {
+                into.push(entry);
+            }
+        }
+    }
+}
+
+/// Parse the text of a markdown or raw cell with the qmd reader.
+///
+/// The cell text is registered as its own file in `context`, so source
+/// locations in the resulting blocks point into the cell.
+fn parse_cell(
+    text: &str,
+    index: usize,
+    filename: &str,
+    context: &mut ASTContext,
+    warnings: &mut Vec<DiagnosticMessage>,
+) -> Result<Pandoc> {
+    let cell_name = format!("{} [cell {}]", filename, index + 1);
+    // Keep the file list and the source context aligned, so JSON file ids
+    // name the cell
+    context.add_filename(cell_name.clone());
+    let file_id = context
+        .source_context
+        .add_file(cell_name.clone(), Some(text.to_string()));
+    let parent = SourceInfo::original(file_id, 0, text.len());
+
+    match crate::readers::qmd::read(
+        text.as_bytes(),
+        false,
+        &cell_name,
+        &mut std::io::sink(),
+        true,
+        Some(parent),
+    ) {
+        Ok((pandoc, _, cell_warnings)) => {
+            warnings.extend(cell_warnings);
+            Ok(pandoc)
+        }
+        Err(diagnostics) => {
+            let mut source_context = SourceContext::new();
+            source_context.add_file(cell_name, Some(text.to_string()));
+            Err(IpynbReadError::CellParse {
+                cell: index,
+                diagnostics,
+                source_context,
+            })
+        }
+    }
+}
+
+fn read_code_cell(cell: &Value, language: &str) -> Vec<Block> {
+    let mut text = cell_source(cell);
+    if text.ends_with('\n') {
+        text.pop();
+    }
+
+    let mut attributes = LinkedHashMap::new();
+    if let Some(count) = cell.get("execution_count").and_then(Value::as_u64) {
+        attributes.insert("execution_count".to_string(), count.to_string());
+    }
+    if let Some(Value::Object(metadata)) = cell.get("metadata") {
+        for (key, value) in metadata {
+            let value = match value {
+                Value::String(s) => s.clone(),
+                other => other.to_string(),
+            };
+            attributes.insert(key.clone(), value);
+        }
+    }
+    let id = cell
+        .get("id")
+        .and_then(Value::as_str)
+        .unwrap_or_default()
+        .to_string();
+
+    let mut blocks = vec![Block::CodeBlock(CodeBlock {
+        attr: (id, vec![format!("{{{}}}", language)], attributes),
+        text,
+        source_info: SourceInfo::default(),
+        attr_source: AttrSourceInfo::empty(),
+    })];
+    if let Some(Value::Array(outputs)) = cell.get("outputs")
+        && !outputs.is_empty()
+    {
+        blocks.push(Block::RawBlock(RawBlock {
+            format: OUTPUTS_FORMAT.to_string(),
+            text: serde_json::to_string_pretty(outputs).unwrap_or_default(),
+            source_info: SourceInfo::default(),
+        }));
+    }
+    blocks
+}
+
+fn raw_cell_format(cell: &Value) -> String {
+    let metadata = cell.get("metadata");
+    let mimetype = metadata
+        .and_then(|m| m.get("raw_mimetype").or_else(|| m.get("format")))
+        .and_then(Value::as_str);
+    mimetype
+        .and_then(|mimetype| {
+            RAW_MIMETYPES
+                .iter()
+                .find(|(candidate, _)| *candidate == mimetype)
+                .map(|(_, format)| format.to_string())
+        })
+        .unwrap_or_else(|| RAW_CELL_FORMAT.to_string())
+}
+
+/// Read a Jupyter notebook (nbformat 4) into a Pandoc AST.
+///
+/// Returns the document, its AST context, and any warnings produced while
+/// parsing markdown cells.
+pub fn read(input: &str, filename: &str) -> Result<(Pandoc, ASTContext, Vec<DiagnosticMessage>)> {
+    let notebook: Value = serde_json::from_str(input).map_err(IpynbReadError::InvalidJson)?;
+
+    let version = notebook
+        .get("nbformat")
+        .and_then(Value::as_u64)
+        .ok_or_else(|| IpynbReadError::InvalidNotebook("missing nbformat".to_string()))?;
+    if version != 4 {
+        return Err(IpynbReadError::UnsupportedVersion(version));
+    }
+    let cells = notebook
+        .get("cells")
+        .and_then(Value::as_array)
+        .ok_or_else(|| IpynbReadError::InvalidNotebook("missing cells array".to_string()))?;
+
+    let metadata = notebook.get("metadata");
+    let language = metadata
+        .and_then(|m| {
+            m.pointer("/kernelspec/language")
+                .or_else(|| m.pointer("/language_info/name"))
+        })
+        .and_then(Value::as_str)
+        .unwrap_or("python");
+
+    let mut context = ASTContext::with_filename(filename.to_string());
+    let mut warnings = Vec::new();
+    let mut meta_entries: Vec<ConfigMapEntry> = Vec::new();
+    let mut blocks = Vec::new();
+
+    // The notebook's own metadata is authoritative for the kernel
+    if let Some(metadata) = metadata
+        && metadata.as_object().is_some_and(|map| !map.is_empty())
+    {
+        meta_entries.push(ConfigMapEntry {
+            key: "jupyter".to_string(),
+            key_source: SourceInfo::default(),
+            value: json_to_config_value(metadata),
+        });
+    }
+
+    for (index, cell) in cells.iter().enumerate() {
+        let cell_type = cell.get("cell_type").and_then(Value::as_str);
+        match cell_type {
+            Some("markdown") => {
+                let source = cell_source(cell);
+                let pandoc = parse_cell(&source, index, filename, &mut context, &mut warnings)?;
+                merge_meta(&mut meta_entries, pandoc.meta);
+                blocks.extend(pandoc.blocks);
+            }
+            Some("code") => blocks.extend(read_code_cell(cell, language)),
+            Some("raw") => {
+                let source = cell_source(cell);
+                if source.trim_start().starts_with("---") {
+                    // Quarto keeps the document's front matter in a raw cell
+                    let pandoc = parse_cell(&source, index, filename, &mut context, &mut warnings)?;
+                    merge_meta(&mut meta_entries, pandoc.meta);
+                    blocks.extend(pandoc.blocks);
+                } else {
+                    blocks.push(Block::RawBlock(RawBlock {
+                        format: raw_cell_format(cell),
+                        text: source,
+                        source_info: SourceInfo::default(),
+                    }));
+                }
+            }
+            Some(other) => {
+                return Err(IpynbReadError::InvalidNotebook(format!(
+                    "cell {} has unknown cell_type '{}'",
+                    index + 1,
+                    other
+                )));
+            }
+            None => {
+                return Err(IpynbReadError::InvalidNotebook(format!(
+                    "cell {} has no cell_type",
+                    index + 1
+                )));
+            }
+        }
+    }
+
+    let pandoc = Pandoc {
+        meta: ConfigValue::new_map(meta_entries, SourceInfo::default()),
+        blocks,
+    };
+    Ok((pandoc, context, warnings))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_cell_source_forms() {
+        let lines: Value = serde_json::json!({"source": ["a\n", "b"]});
+        assert_eq!(cell_source(&lines), "a\nb");
+        let text: Value = serde_json::json!({"source": "a\nb"});
+        assert_eq!(cell_source(&text), "a\nb");
+    }
+
+    #[test]
+    fn test_raw_cell_format() {
+        let html: Value = serde_json::json!({"metadata": {"raw_mimetype": "text/html"}});
+        assert_eq!(raw_cell_format(&html), "html");
+        let plain: Value = serde_json::json!({"metadata": {}});
+        assert_eq!(raw_cell_format(&plain), RAW_CELL_FORMAT);
+    }
+
+    #[test]
+    fn test_rejects_nbformat_3() {
+        let result = read(r#"{"nbformat": 3, "worksheets": []}"#, "old.ipynb");
+        assert!(matches!(result, Err(IpynbReadError::UnsupportedVersion(3))));
+    }
+}
@@ -30,6 +30,7 @@ pub enum JsonReadError {
     ExpectedSourceInfoRef,
     MalformedSourceInfoPool,
     CircularSourceInfoReference(usize),
+    IncompatibleApiVersion(Vec<u64>),
 }
 
 impl std::fmt::Display for JsonReadError {
@@ -57,6 +58,19 @@ impl std::fmt::Display for JsonReadError {
                     id
                 )
             }
+            JsonReadError::IncompatibleApiVersion(version) => {
+                let version: Vec<String> = version.iter().map(|v| v.to_string()).collect();
+                write!(
+                    f,
+                    "Incompatible pandoc-api-version {}: expected {}.x",
+                    version.join("."),
+                    PANDOC_API_VERSION_PREFIX
+                        .iter()
+                        .map(|v| v.to_string())
+                        .collect::<Vec<_>>()
+                        .join(".")
+                )
+            }
         }
     }
 }
@@ -883,12 +897,17 @@ fn read_inline(value: &Value, deserializer: &SourceInfoDeserializer) -> Result<I
 
             let content = read_inlines(&arr[1], deserializer)?;
             let attr_source = read_attr_source(obj.get("attrS"), deserializer)?;
-            Ok(Inline::Span(Span {
+            let span = Span {
                 attr,
                 content,
                 source_info,
                 attr_source,
-            }))
+            };
+            // Shortcodes are written as spans; read them back as shortcodes
+            match crate::pandoc::shortcode::span_to_shortcode(&span) {
+                Some(shortcode) => Ok(Inline::Shortcode(shortcode)),
+                None => Ok(Inline::Span(span)),
+            }
         }
         "Note" => {
             let c = obj
@@ -896,6 +915,7 @@ fn read_inline(value: &Value, deserializer: &SourceInfoDeserializer) -> Result<I
                 .ok_or_else(|| JsonReadError::MissingField("c".to_string()))?;
             let content = read_blocks(c, deserializer)?;
             Ok(Inline::Note(Note {
+                id: None,
                 content,
                 source_info,
             }))
@@ -1069,13 +1089,35 @@ pub fn read<R: std::io::Read>(reader: &mut R) -> Result<(Pandoc, ASTContext)> {
     read_pandoc(&json)
 }
 
+/// The major and minor pandoc-types API version this reader understands.
+const PANDOC_API_VERSION_PREFIX: [u64; 2] = [1, 23];
+
+fn check_api_version(value: &Value) -> Result<()> {
+    let version = value
+        .as_array()
+        .and_then(|parts| parts.iter().map(Value::as_u64).collect::<Option<Vec<_>>>())
+        .ok_or_else(|| {
+            JsonReadError::InvalidType(
+                "Expected array of integers for pandoc-api-version".to_string(),
+            )
+        })?;
+    if version.len() < 2 || version[..2] != PANDOC_API_VERSION_PREFIX {
+        return Err(JsonReadError::IncompatibleApiVersion(version));
+    }
+    Ok(())
+}
+
 fn read_pandoc(value: &Value) -> Result<(Pandoc, ASTContext)> {
     let obj = value
         .as_object()
         .ok_or_else(|| JsonReadError::InvalidType("Expected object for Pandoc".to_string()))?;
 
-    // We could validate the API version here if needed
-    // let _api_version = obj.get("pandoc-api-version");
+    // Like pandoc, accept any document whose API version matches ours in its
+    // first two components. Documents without a version are accepted for
+    // backward compatibility with earlier pampa output.
+    if let Some(version) = obj.get("pandoc-api-version") {
+        check_api_version(version)?;
+    }
 
     // Read astContext first (we need it for key sources and source info pool)
     let context = if let Some(ast_context_val) = obj.get("astContext") {
@@ -2475,6 +2517,34 @@ mod tests {
     use quarto_source_map::{FileId, SourceInfo};
     use serde_json::json;
 
+    #[test]
+    fn test_api_version_check() {
+        assert!(check_api_version(&json!([1, 23, 1])).is_ok());
+        assert!(check_api_version(&json!([1, 23])).is_ok());
+        assert!(matches!(
+            check_api_version(&json!([1, 22, 2, 1])),
+            Err(JsonReadError::IncompatibleApiVersion(_))
+        ));
+        assert!(matches!(
+            check_api_version(&json!([2])),
+            Err(JsonReadError::IncompatibleApiVersion(_))
+        ));
+        assert!(matches!(
+            check_api_version(&json!("1.23")),
+            Err(JsonReadError::InvalidType(_))
+        ));
+    }
+
+    #[test]
+    fn test_read_rejects_incompatible_api_version() {
+        let doc = json!({"pandoc-api-version": [1, 20], "meta": {}, "blocks": []});
+        let err = read_pandoc(&doc).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "Incompatible pandoc-api-version 1.20: expected 1.23.x"
+        );
+    }
+
     #[test]
     fn test_deserialize_source_info_pool_basic() {
         // Test deserializing a simple pool with an Original source
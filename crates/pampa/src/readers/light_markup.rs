@@ -0,0 +1,559 @@
+/*
+ * light_markup.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * What the hand-written readers of lightweight markup (org and rst)
+ * share: lines that remember where they are in the file, text split into
+ * inlines with their source spans, and references resolved once the
+ * whole document is read.
+ */
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::{
+    Alignment, AttrSourceInfo, Block, Blocks, Caption, Cell, ColWidth, Inline, Inlines, Link,
+    Plain, Row, SoftBreak, Space, Span, Str, Table, TableBody, TableFoot, TableHead,
+    TargetSourceInfo,
+};
+use crate::transforms::footnotes::NOTE_REFERENCE_CLASS;
+use crate::utils::autoid;
+use hashlink::LinkedHashMap;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::attr::{Attr, empty_attr};
+use quarto_source_map::{FileId, SourceInfo};
+use std::collections::HashMap;
+
+/// Columns a tab advances to a multiple of, as in docutils
+const TAB_WIDTH: usize = 8;
+
+/// A line without its line ending, and the offset of its first byte in the
+/// file. Lines of nested content are shortened from the left, so that
+/// `offset` stays the file offset of `text`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Line<'a> {
+    pub text: &'a str,
+    pub offset: usize,
+}
+
+/// Split `input`, which has `\n` line endings, into lines
+pub fn lines(input: &str) -> Vec<Line<'_>> {
+    let mut lines = Vec::new();
+    let mut offset = 0;
+    for text in input.split_inclusive('\n') {
+        lines.push(Line {
+            text: text.strip_suffix('\n').unwrap_or(text),
+            offset,
+        });
+        offset += text.len();
+    }
+    lines
+}
+
+impl<'a> Line<'a> {
+    pub fn is_blank(&self) -> bool {
+        self.text.trim().is_empty()
+    }
+
+    /// The column of the first character that isn't whitespace
+    pub fn indent(&self) -> usize {
+        let mut column = 0;
+        for c in self.text.chars() {
+            match c {
+                ' ' => column += 1,
+                '\t' => column += TAB_WIDTH - column % TAB_WIDTH,
+                _ => return column,
+            }
+        }
+        column
+    }
+
+    /// The line from byte `start` on
+    pub fn from(&self, start: usize) -> Line<'a> {
+        Line {
+            text: &self.text[start..],
+            offset: self.offset + start,
+        }
+    }
+
+    /// The line without up to `columns` columns of indentation
+    pub fn dedent(&self, columns: usize) -> Line<'a> {
+        let mut column = 0;
+        for (i, c) in self.text.char_indices() {
+            if column >= columns {
+                return self.from(i);
+            }
+            match c {
+                ' ' => column += 1,
+                '\t' => column += TAB_WIDTH - column % TAB_WIDTH,
+                _ => return self.from(i),
+            }
+        }
+        self.from(self.text.len())
+    }
+
+    pub fn trim(&self) -> Line<'a> {
+        let start = self.text.len() - self.text.trim_start().len();
+        Line {
+            text: self.text.trim(),
+            offset: self.offset + start,
+        }
+    }
+
+    pub fn end(&self) -> usize {
+        self.offset + self.text.len()
+    }
+}
+
+/// The offsets in `file_id` that `lines` span
+pub fn lines_source(file_id: FileId, lines: &[Line]) -> SourceInfo {
+    match (lines.first(), lines.last()) {
+        (Some(first), Some(last)) => SourceInfo::original(file_id, first.offset, last.end()),
+        _ => SourceInfo::default(),
+    }
+}
+
+/// The lines with the most indentation they share removed
+pub fn dedent_all<'a>(lines: &[Line<'a>]) -> Vec<Line<'a>> {
+    let indent = lines
+        .iter()
+        .filter(|line| !line.is_blank())
+        .map(Line::indent)
+        .min()
+        .unwrap_or(0);
+    lines.iter().map(|line| line.dedent(indent)).collect()
+}
+
+/// The text of lines without the blank lines that start and end them,
+/// joined with `\n`
+pub fn verbatim(lines: &[Line]) -> String {
+    let start = lines.iter().position(|line| !line.is_blank());
+    let end = lines.iter().rposition(|line| !line.is_blank());
+    match (start, end) {
+        (Some(start), Some(end)) => lines[start..=end]
+            .iter()
+            .map(|line| line.text.trim_end())
+            .collect::<Vec<_>>()
+            .join("\n"),
+        _ => String::new(),
+    }
+}
+
+/// Text joined from lines, with `\n` between them, that knows the file
+/// offset of each of its bytes
+#[derive(Debug, Clone)]
+pub struct Fragment {
+    pub text: String,
+    offsets: Vec<usize>,
+    file_id: FileId,
+}
+
+impl Fragment {
+    pub fn new(file_id: FileId, lines: &[Line]) -> Self {
+        let mut text = String::new();
+        let mut offsets = Vec::new();
+        for (i, line) in lines.iter().enumerate() {
+            if i > 0 {
+                text.push('\n');
+                offsets.push(lines[i - 1].end());
+            }
+            text.push_str(line.text);
+            offsets.extend(line.offset..line.end());
+        }
+        offsets.push(lines.last().map_or(0, Line::end));
+        Self {
+            text,
+            offsets,
+            file_id,
+        }
+    }
+
+    /// Where `start..end` of the text is in the file
+    pub fn source(&self, start: usize, end: usize) -> SourceInfo {
+        let offset = |i: usize| self.offsets[i.min(self.offsets.len() - 1)];
+        let end_offset = if end > start {
+            offset(end - 1) + 1
+        } else {
+            offset(start)
+        };
+        SourceInfo::original(self.file_id, offset(start), end_offset)
+    }
+}
+
+/// Add `text`, from `start` in `fragment`, as Str, Space and SoftBreak
+/// inlines. A Str right after another is joined with it.
+pub fn push_text(inlines: &mut Inlines, fragment: &Fragment, start: usize, text: &str) {
+    let mut word_start = None;
+    let mut pending_space: Option<(usize, usize, bool)> = None;
+    let flush_word = |inlines: &mut Inlines, from: usize, to: usize| {
+        let word = &text[from..to];
+        let source = fragment.source(start + from, start + to);
+        if let Some(Inline::Str(previous)) = inlines.last_mut() {
+            previous.text.push_str(word);
+            previous.source_info = span(&previous.source_info, &source);
+        } else {
+            inlines.push(Inline::Str(Str {
+                text: word.to_string(),
+                source_info: source,
+            }));
+        }
+    };
+    for (i, c) in text.char_indices() {
+        if c.is_whitespace() {
+            if let Some(from) = word_start.take() {
+                flush_word(inlines, from, i);
+            }
+            let newline = c == '\n';
+            pending_space = Some(match pending_space {
+                Some((from, _, was_newline)) => (from, i + c.len_utf8(), was_newline || newline),
+                None => (i, i + c.len_utf8(), newline),
+            });
+        } else if word_start.is_none() {
+            if let Some((from, to, newline)) = pending_space.take() {
+                push_space(inlines, fragment.source(start + from, start + to), newline);
+            }
+            word_start = Some(i);
+        }
+    }
+    if let Some(from) = word_start {
+        flush_word(inlines, from, text.len());
+    }
+    if let Some((from, to, newline)) = pending_space {
+        push_space(inlines, fragment.source(start + from, start + to), newline);
+    }
+}
+
+/// From the start of `first` to the end of `last`, when both are in the
+/// same file; otherwise `first`
+pub fn span(first: &SourceInfo, last: &SourceInfo) -> SourceInfo {
+    match (first, last) {
+        (
+            SourceInfo::Original {
+                file_id,
+                start_offset,
+                ..
+            },
+            SourceInfo::Original {
+                file_id: last_file_id,
+                end_offset,
+                ..
+            },
+        ) if file_id == last_file_id => SourceInfo::original(*file_id, *start_offset, *end_offset),
+        _ => first.clone(),
+    }
+}
+
+fn push_space(inlines: &mut Inlines, source_info: SourceInfo, newline: bool) {
+    if newline {
+        inlines.push(Inline::SoftBreak(SoftBreak { source_info }));
+    } else {
+        inlines.push(Inline::Space(Space { source_info }));
+    }
+}
+
+/// Remove the spaces and breaks that start and end inlines
+pub fn trim_inlines(mut inlines: Inlines) -> Inlines {
+    let is_space = |inline: &Inline| matches!(inline, Inline::Space(_) | Inline::SoftBreak(_));
+    while inlines.last().is_some_and(is_space) {
+        inlines.pop();
+    }
+    let start = inlines.iter().position(|inline| !is_space(inline));
+    inlines.drain(..start.unwrap_or(inlines.len()));
+    inlines
+}
+
+/// An attribute with only classes
+pub fn class_attr(classes: &[&str]) -> Attr {
+    (
+        String::new(),
+        classes.iter().map(|class| class.to_string()).collect(),
+        LinkedHashMap::new(),
+    )
+}
+
+/// The items of a list without blank lines between its items: their
+/// paragraphs become plain text
+pub fn tighten(items: Vec<Blocks>) -> Vec<Blocks> {
+    items
+        .into_iter()
+        .map(|item| {
+            item.into_iter()
+                .map(|block| match block {
+                    Block::Paragraph(para) => Block::Plain(Plain {
+                        content: para.content,
+                        source_info: para.source_info,
+                    }),
+                    block => block,
+                })
+                .collect()
+        })
+        .collect()
+}
+
+/// A reference to the note `id`, which [`crate::transforms::resolve_footnotes`]
+/// joins with its definition
+pub fn note_reference(id: &str, source_info: SourceInfo) -> Inline {
+    let mut attr = class_attr(&[NOTE_REFERENCE_CLASS]);
+    attr.2.insert("reference-id".to_string(), id.to_string());
+    Inline::Span(Span {
+        attr,
+        content: Vec::new(),
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+    })
+}
+
+/// The class of the Quarto callout for an admonition, for the kinds
+/// Quarto has
+pub fn callout_class(kind: &str) -> Option<&'static str> {
+    match kind.to_lowercase().as_str() {
+        "note" | "seealso" => Some("callout-note"),
+        "tip" | "hint" => Some("callout-tip"),
+        "warning" | "attention" => Some("callout-warning"),
+        "caution" | "danger" | "error" => Some("callout-caution"),
+        "important" => Some("callout-important"),
+        _ => None,
+    }
+}
+
+/// The rows of a table, each a list of cells
+#[derive(Debug, Default)]
+pub struct TableRows {
+    pub head: Vec<Vec<Blocks>>,
+    pub body: Vec<Vec<Blocks>>,
+    /// The alignment of each column; columns past its end have the default
+    pub alignments: Vec<Alignment>,
+    /// The widths of the columns, relative to each other
+    pub widths: Vec<f64>,
+}
+
+/// A table of `rows`, each row padded to the widest with empty cells
+pub fn table(
+    attr: Attr,
+    caption: Option<Inlines>,
+    rows: TableRows,
+    source_info: SourceInfo,
+) -> Block {
+    let columns = rows
+        .head
+        .iter()
+        .chain(&rows.body)
+        .map(Vec::len)
+        .max()
+        .unwrap_or(0);
+    let total: f64 = rows.widths.iter().sum();
+    let colspec = (0..columns)
+        .map(|column| {
+            let alignment = rows
+                .alignments
+                .get(column)
+                .cloned()
+                .unwrap_or(Alignment::Default);
+            let width = match rows.widths.get(column) {
+                Some(width) if rows.widths.len() == columns && total > 0.0 => {
+                    ColWidth::Percentage(width / total)
+                }
+                _ => ColWidth::Default,
+            };
+            (alignment, width)
+        })
+        .collect();
+    let make_rows = |rows: Vec<Vec<Blocks>>| -> Vec<Row> {
+        rows.into_iter()
+            .map(|cells| {
+                let padding = columns - cells.len();
+                Row {
+                    attr: empty_attr(),
+                    cells: cells
+                        .into_iter()
+                        .chain(std::iter::repeat_with(Vec::new).take(padding))
+                        .map(|content| Cell {
+                            attr: empty_attr(),
+                            alignment: Alignment::Default,
+                            row_span: 1,
+                            col_span: 1,
+                            content,
+                            source_info: source_info.clone(),
+                            attr_source: AttrSourceInfo::empty(),
+                        })
+                        .collect(),
+                    source_info: source_info.clone(),
+                    attr_source: AttrSourceInfo::empty(),
+                }
+            })
+            .collect()
+    };
+    let attr_source = AttrSourceInfo::empty();
+    Block::Table(Table {
+        attr,
+        caption: Caption {
+            short: None,
+            long: caption.map(|caption| {
+                vec![Block::Plain(Plain {
+                    content: caption,
+                    source_info: source_info.clone(),
+                })]
+            }),
+            source_info: source_info.clone(),
+        },
+        colspec,
+        head: TableHead {
+            attr: empty_attr(),
+            rows: make_rows(rows.head),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        bodies: vec![TableBody {
+            attr: empty_attr(),
+            rowhead_columns: 0,
+            head: Vec::new(),
+            body: make_rows(rows.body),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }],
+        foot: TableFoot {
+            attr: empty_attr(),
+            rows: Vec::new(),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        },
+        source_info,
+        attr_source,
+    })
+}
+
+/// A warning for markup the reader has no translation for, kept as a raw
+/// block of `format`
+pub fn untranslated(format: &str, problem: String, source_info: SourceInfo) -> DiagnosticMessage {
+    DiagnosticMessageBuilder::warning("Untranslated markup")
+        .with_code("Q-2-44")
+        .with_location(source_info)
+        .problem(problem)
+        .add_hint(format!(
+            "It is kept as a raw `{}` block, which other formats leave out",
+            format
+        ))
+        .build()
+}
+
+/// A warning for a link to a name nothing in the document has
+pub fn unknown_reference(name: &str, source_info: SourceInfo) -> DiagnosticMessage {
+    DiagnosticMessageBuilder::warning("Unknown reference")
+        .with_code("Q-2-45")
+        .with_location(source_info)
+        .problem(format!("Nothing in the document is named `{}`", name))
+        .add_hint("The link is kept as its text")
+        .build()
+}
+
+/// The attribute of a link to a name, until [`resolve_references`] gives
+/// it the target the name refers to
+const REFERENCE_NAME: &str = "reference-name";
+
+/// Names are matched without case and with runs of whitespace as one space
+pub fn normalize_name(name: &str) -> String {
+    name.split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+        .to_lowercase()
+}
+
+/// A link to what `name` refers to, found once the document is read
+pub fn reference_link(content: Inlines, name: &str, source_info: SourceInfo) -> Inline {
+    let mut attr = empty_attr();
+    attr.2
+        .insert(REFERENCE_NAME.to_string(), normalize_name(name));
+    Inline::Link(Link {
+        attr,
+        content,
+        target: (String::new(), String::new()),
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+        target_source: TargetSourceInfo::empty(),
+    })
+}
+
+/// The names the headings of `blocks` can be referred to by, their text,
+/// and the `#id` targets they refer to
+pub fn heading_targets(blocks: &Blocks, targets: &mut HashMap<String, String>) {
+    topdown_traverse_blocks(
+        blocks.clone(),
+        &mut Filter::new().with_header(|header, _ctx| {
+            if !header.attr.0.is_empty() {
+                targets
+                    .entry(normalize_name(&autoid::plain_text(&header.content)))
+                    .or_insert_with(|| format!("#{}", header.attr.0));
+            }
+            FilterReturn::Unchanged(header)
+        }),
+        &mut FilterContext::new(),
+    );
+}
+
+/// Give each link made by [`reference_link`] the target its name refers
+/// to in `targets`. A link to a name that isn't there becomes its content,
+/// and the name and where it was referred to are returned.
+pub fn resolve_references(
+    blocks: Blocks,
+    targets: &HashMap<String, String>,
+) -> (Blocks, Vec<(String, SourceInfo)>) {
+    let mut missing = Vec::new();
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_link(|mut link, _ctx| {
+            let Some(name) = link.attr.2.remove(REFERENCE_NAME) else {
+                return FilterReturn::Unchanged(link);
+            };
+            match targets.get(&name) {
+                Some(url) => {
+                    link.target.0 = url.clone();
+                    FilterReturn::FilterResult(vec![Inline::Link(link)], true)
+                }
+                None => {
+                    missing.push((name, link.source_info.clone()));
+                    FilterReturn::FilterResult(link.content, true)
+                }
+            }
+        }),
+        &mut FilterContext::new(),
+    );
+    (blocks, missing)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lines_keep_offsets() {
+        let input = "one\n  two\n";
+        let lines = lines(input);
+        assert_eq!(lines.len(), 2);
+        let dedented = lines[1].dedent(2);
+        assert_eq!(dedented.text, "two");
+        assert_eq!(&input[dedented.offset..dedented.end()], "two");
+        assert_eq!(
+            Line {
+                text: "\t x",
+                offset: 0
+            }
+            .indent(),
+            9
+        );
+    }
+
+    #[test]
+    fn test_fragment_sources() {
+        let input = "a *b*\n  c\n";
+        let lines = lines(input);
+        let fragment = Fragment::new(FileId(0), &[lines[0], lines[1].dedent(2)]);
+        assert_eq!(fragment.text, "a *b*\nc");
+        let mut inlines = Vec::new();
+        push_text(&mut inlines, &fragment, 0, &fragment.text);
+        assert_eq!(inlines.len(), 5);
+        assert!(matches!(&inlines[3], Inline::SoftBreak(_)));
+        let Inline::Str(c) = &inlines[4] else {
+            panic!("Expected a Str, got {:?}", inlines[4]);
+        };
+        assert_eq!(c.source_info, SourceInfo::original(FileId(0), 8, 9));
+    }
+}
@@ -4,7 +4,12 @@
  */
 
 pub mod commonmark;
+pub mod ipynb;
 pub mod json;
+pub mod light_markup;
+pub mod org;
 pub mod qmd;
 pub mod qmd_error_message_table;
 pub mod qmd_error_messages;
+pub mod qmd_stream;
+pub mod rst;
@@ -0,0 +1,2023 @@
+/*
+ * org.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Org-mode reader.
+ *
+ * Org documents are mapped onto the AST the qmd reader produces, so that
+ * `pampa -f org -t qmd` gives a Quarto document to start from:
+ *
+ * - `#+TITLE:`, `#+AUTHOR:`, `#+DATE:` and the other export keywords
+ *   become metadata, and `#+OPTIONS: toc:t num:t` becomes `toc` and
+ *   `number-sections`.
+ * - Headings keep their level. A TODO keyword becomes a Span with the
+ *   `todo` or `done` class, tags become the `tags` attribute, and the
+ *   properties of the property drawer become attributes (`CUSTOM_ID` is
+ *   the identifier). Planning lines and `LOGBOOK` drawers are left out, as
+ *   are subtrees marked `COMMENT` or `:noexport:`.
+ * - Other drawers become Divs with the `drawer` class.
+ * - Lists, checkboxes (as task list items) and `term :: definition`
+ *   lists, tables with their `#+CAPTION:` and `#+NAME:`, source, example,
+ *   quote, verse, center and export blocks map onto their Pandoc
+ *   counterparts. Special blocks become Divs, and callouts for those Quarto
+ *   has (`#+begin_note`).
+ * - `[fn:label]` footnotes become notes, `[cite:@key]` citations become
+ *   Cites, and links to headings (`[[*Heading]]`), custom identifiers and
+ *   `<<targets>>` become links within the document.
+ *
+ * `#+INCLUDE:`, `#+CALL:` and table.el tables have no translation. They
+ * are kept as raw `org` blocks, with a warning.
+ */
+
+use super::light_markup::{
+    Fragment, Line, TableRows, callout_class, class_attr, dedent_all, heading_targets, lines,
+    lines_source, normalize_name, note_reference, push_text, reference_link, resolve_references,
+    table, tighten, trim_inlines, unknown_reference, untranslated, verbatim,
+};
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::{
+    Alignment, AttrSourceInfo, Block, BlockQuote, Blocks, BulletList, Caption, Citation,
+    CitationMode, Cite, Code, CodeBlock, DefinitionList, Div, Emph, Figure, Header, HorizontalRule,
+    Image, Inline, Inlines, LineBlock, LineBreak, Link, ListNumberDelim, ListNumberStyle, Math,
+    MathType, Note, NoteDefinitionFencedBlock, OrderedList, Pandoc, Paragraph, Plain, RawBlock,
+    RawInline, Space, Span, Str, Strikeout, Strong, Subscript, Superscript, TargetSourceInfo,
+    Underline,
+};
+use crate::transforms::{self, task_lists};
+use crate::utils::autoid::IdentifierOptions;
+use quarto_error_reporting::DiagnosticMessage;
+use quarto_pandoc_types::attr::empty_attr;
+use quarto_pandoc_types::{ConfigMapEntry, ConfigValue};
+use quarto_source_map::{FileId, SourceContext, SourceInfo};
+use std::collections::HashMap;
+
+/// The extensions of links shown as images
+const IMAGE_EXTENSIONS: &[&str] = &[
+    "png", "jpg", "jpeg", "gif", "svg", "webp", "bmp", "tif", "tiff",
+];
+
+/// LaTeX environments read as display math rather than raw LaTeX
+const MATH_ENVIRONMENTS: &[&str] = &[
+    "equation",
+    "equation*",
+    "align",
+    "align*",
+    "gather",
+    "gather*",
+    "multline",
+    "multline*",
+    "eqnarray",
+    "eqnarray*",
+];
+
+/// Read an Org-mode document, which has `\n` line endings, into a document
+/// and the warnings about what couldn't be translated
+pub fn read(input: &str, filename: &str) -> (Pandoc, ASTContext, Vec<DiagnosticMessage>) {
+    let mut context = ASTContext::with_filename(filename.to_string());
+    context.source_context = SourceContext::new();
+    let file_id = context
+        .source_context
+        .add_file(filename.to_string(), Some(input.to_string()));
+
+    let mut reader = OrgReader {
+        input,
+        file_id,
+        meta: Vec::new(),
+        todo_keywords: vec![("TODO".to_string(), false), ("DONE".to_string(), true)],
+        targets: HashMap::new(),
+        warnings: Vec::new(),
+    };
+    let lines = lines(input);
+    let blocks = reader.blocks(&lines);
+
+    let blocks = transforms::resolve_footnotes(blocks, &context.source_context);
+    let blocks = transforms::assign_auto_identifiers(blocks, IdentifierOptions::default());
+    heading_targets(&blocks, &mut reader.targets);
+    let (blocks, missing) = resolve_references(blocks, &reader.targets);
+    for (name, source_info) in missing {
+        reader.warnings.push(unknown_reference(&name, source_info));
+    }
+
+    let pandoc = Pandoc {
+        meta: ConfigValue::new_map(reader.meta, SourceInfo::default()),
+        blocks,
+    };
+    (pandoc, context, reader.warnings)
+}
+
+struct OrgReader<'a> {
+    input: &'a str,
+    file_id: FileId,
+    meta: Vec<ConfigMapEntry>,
+    /// The TODO keywords, and whether each is a done state
+    todo_keywords: Vec<(String, bool)>,
+    /// What names in links refer to, by [`normalize_name`]
+    targets: HashMap<String, String>,
+    warnings: Vec<DiagnosticMessage>,
+}
+
+/// `#+CAPTION:`, `#+NAME:` and `#+ATTR_HTML:`, which belong to the element
+/// after them
+#[derive(Default)]
+struct Affiliated {
+    name: Option<(String, SourceInfo)>,
+    caption: Option<Inlines>,
+    /// `:width` and `:height` of `#+ATTR_HTML:`
+    attributes: Vec<(String, String)>,
+}
+
+impl Affiliated {
+    fn attr(&self) -> (quarto_pandoc_types::Attr, AttrSourceInfo) {
+        let mut attr = empty_attr();
+        let mut attr_source = AttrSourceInfo::empty();
+        if let Some((name, source_info)) = &self.name {
+            attr.0 = name.clone();
+            attr_source.id = Some(source_info.clone());
+        }
+        (attr, attr_source)
+    }
+}
+
+/// A heading line: `** TODO [#A] Title   :tag:`
+struct HeadingLine<'a> {
+    level: usize,
+    keyword: Option<(Line<'a>, bool)>,
+    title: Line<'a>,
+    tags: Vec<&'a str>,
+    /// `COMMENT` or `:noexport:`: the subtree is not exported
+    excluded: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum ListKind {
+    Bullet,
+    Ordered(ListNumberDelim),
+}
+
+/// The start of a list item: `- `, `+ `, ` * `, `1. ` or `1) `
+struct ItemStart {
+    indent: usize,
+    kind: ListKind,
+    number: Option<usize>,
+    /// Where the item's text starts in the line
+    content: usize,
+}
+
+fn item_start(line: &Line) -> Option<ItemStart> {
+    let indent = line.indent();
+    let text = line.text.trim_start();
+    let marker_start = line.text.len() - text.len();
+    let followed_by_space = |len: usize| text.len() == len || text[len..].starts_with([' ', '\t']);
+    let (kind, number, len) = match text.chars().next()? {
+        '-' | '+' if followed_by_space(1) => (ListKind::Bullet, None, 1),
+        '*' if indent > 0 && followed_by_space(1) => (ListKind::Bullet, None, 1),
+        c if c.is_ascii_digit() => {
+            let digits = text.len() - text.trim_start_matches(|c: char| c.is_ascii_digit()).len();
+            let delimiter = match text[digits..].chars().next()? {
+                '.' => ListNumberDelim::Period,
+                ')' => ListNumberDelim::OneParen,
+                _ => return None,
+            };
+            if !followed_by_space(digits + 1) {
+                return None;
+            }
+            (
+                ListKind::Ordered(delimiter),
+                text[..digits].parse().ok(),
+                digits + 1,
+            )
+        }
+        _ => return None,
+    };
+    let after = &text[len..];
+    let content = marker_start + len + (after.len() - after.trim_start().len()).min(1);
+    Some(ItemStart {
+        indent,
+        kind,
+        number,
+        content: if after.trim().is_empty() {
+            line.text.len()
+        } else {
+            content
+        },
+    })
+}
+
+/// `#+KEY: value`, with the key as written
+fn keyword<'a>(line: &Line<'a>) -> Option<(&'a str, Line<'a>)> {
+    let line = line.trim();
+    let rest = line.text.strip_prefix("#+")?;
+    let colon = rest.find(':')?;
+    let key = &rest[..colon];
+    if key.is_empty() || key.contains(char::is_whitespace) {
+        return None;
+    }
+    Some((key, line.from(2 + colon + 1).trim()))
+}
+
+/// `#+begin_NAME args`, with the name in lowercase
+fn block_start<'a>(line: &Line<'a>) -> Option<(String, Line<'a>)> {
+    let line = line.trim();
+    if !line.text.to_lowercase().starts_with("#+begin_") {
+        return None;
+    }
+    let rest = &line.text[8..];
+    let end = rest.find(char::is_whitespace).unwrap_or(rest.len());
+    if end == 0 {
+        return None;
+    }
+    Some((rest[..end].to_lowercase(), line.from(8 + end).trim()))
+}
+
+fn is_block_end(line: &Line, name: &str) -> bool {
+    line.text.trim().to_lowercase() == format!("#+end_{}", name)
+}
+
+/// `:NAME:` alone on a line, which starts a drawer
+fn drawer_start<'a>(line: &Line<'a>) -> Option<&'a str> {
+    let text = line.text.trim();
+    let name = text.strip_prefix(':')?.strip_suffix(':')?;
+    (!name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_alphanumeric() || c == '-' || c == '_'))
+    .then_some(name)
+}
+
+fn is_drawer_end(line: &Line) -> bool {
+    line.text.trim().eq_ignore_ascii_case(":end:")
+}
+
+fn is_comment(line: &Line) -> bool {
+    let text = line.text.trim_start();
+    text == "#" || text.starts_with("# ")
+}
+
+fn is_fixed_width(line: &Line) -> bool {
+    let text = line.text.trim_start();
+    text == ":" || text.starts_with(": ")
+}
+
+fn is_rule(line: &Line) -> bool {
+    let text = line.text.trim();
+    text.len() >= 5 && text.chars().all(|c| c == '-')
+}
+
+fn is_table_line(line: &Line) -> bool {
+    line.text.trim_start().starts_with('|')
+}
+
+fn is_table_el(line: &Line) -> bool {
+    line.text.trim_start().starts_with("+-")
+}
+
+/// `\begin{NAME}`
+fn environment_start(line: &Line) -> Option<String> {
+    let rest = line.text.trim().strip_prefix("\\begin{")?;
+    Some(rest[..rest.find('}')?].to_string())
+}
+
+/// `[fn:label] text` at the start of a line: the label and where the text
+/// starts
+fn footnote_definition(line: &Line) -> Option<(String, usize)> {
+    let rest = line.text.strip_prefix("[fn:")?;
+    let end = rest.find(']')?;
+    let label = &rest[..end];
+    if label.is_empty()
+        || !label
+            .chars()
+            .all(|c| c.is_alphanumeric() || c == '-' || c == '_')
+    {
+        return None;
+    }
+    Some((label.to_string(), 4 + end + 1))
+}
+
+impl<'a> OrgReader<'a> {
+    /// Whether `line` starts at the start of a line of the file, as
+    /// headings and footnote definitions do
+    fn at_line_start(&self, line: &Line) -> bool {
+        line.offset == 0 || self.input.as_bytes()[line.offset - 1] == b'\n'
+    }
+
+    fn heading_line(&self, line: &Line<'a>) -> Option<HeadingLine<'a>> {
+        if !self.at_line_start(line) {
+            return None;
+        }
+        let level = line.text.len() - line.text.trim_start_matches('*').len();
+        if level == 0 || !(line.text.len() == level || line.text[level..].starts_with(' ')) {
+            return None;
+        }
+        let mut title = line.from(level).trim();
+        let mut keyword = None;
+        let mut excluded = false;
+        if let Some((todo, done)) = self
+            .todo_keywords
+            .iter()
+            .find(|(todo, _)| first_word(&title) == todo.as_str())
+        {
+            keyword = Some((
+                Line {
+                    text: &title.text[..todo.len()],
+                    offset: title.offset,
+                },
+                *done,
+            ));
+            title = title.from(todo.len()).trim();
+        }
+        if title.text.starts_with("[#") && title.text[2..].find(']') == Some(1) {
+            title = title.from(4).trim();
+        }
+        if first_word(&title) == "COMMENT" {
+            excluded = true;
+            title = title.from(7.min(title.text.len())).trim();
+        }
+        let mut tags = Vec::new();
+        let tags_start = title.text.rfind([' ', '\t']).map_or(0, |i| i + 1);
+        if let Some(inner) = title.text[tags_start..]
+            .strip_prefix(':')
+            .and_then(|t| t.strip_suffix(':'))
+            && !inner.is_empty()
+            && inner.split(':').all(|tag| {
+                !tag.is_empty()
+                    && tag
+                        .chars()
+                        .all(|c| c.is_alphanumeric() || "_@#%".contains(c))
+            })
+        {
+            tags = inner.split(':').collect();
+            excluded |= tags.contains(&"noexport");
+            title = Line {
+                text: title.text[..tags_start].trim_end(),
+                offset: title.offset,
+            };
+        }
+        Some(HeadingLine {
+            level,
+            keyword,
+            title,
+            tags,
+            excluded,
+        })
+    }
+
+    /// Whether `line` starts an element other than a paragraph
+    fn starts_element(&self, line: &Line<'a>) -> bool {
+        self.heading_line(line).is_some()
+            || line.text.trim_start().starts_with("#+")
+            || is_comment(line)
+            || is_fixed_width(line)
+            || is_rule(line)
+            || is_table_line(line)
+            || item_start(line).is_some()
+            || (self.at_line_start(line) && footnote_definition(line).is_some())
+            || environment_start(line).is_some()
+    }
+
+    fn blocks(&mut self, lines: &[Line<'a>]) -> Blocks {
+        let mut blocks = Vec::new();
+        let mut affiliated = Affiliated::default();
+        let mut i = 0;
+        while i < lines.len() {
+            let line = lines[i];
+            if line.is_blank() {
+                i += 1;
+                continue;
+            }
+
+            if let Some(heading) = self.heading_line(&line) {
+                i = self.heading(heading, lines, i, &mut blocks);
+                affiliated = Affiliated::default();
+                continue;
+            }
+
+            if let Some((name, args)) = block_start(&line)
+                && let Some(end) = (i + 1..lines.len()).find(|&j| is_block_end(&lines[j], &name))
+            {
+                let block_lines = &lines[i..=end];
+                if let Some(block) =
+                    self.block(&name, args, &lines[i + 1..end], block_lines, &affiliated)
+                {
+                    blocks.push(block);
+                }
+                affiliated = Affiliated::default();
+                i = end + 1;
+                continue;
+            }
+
+            if let Some((key, value)) = keyword(&line) {
+                self.keyword(key, value, &line, &mut affiliated, &mut blocks);
+                i += 1;
+                continue;
+            }
+
+            if is_comment(&line) {
+                i += 1;
+                continue;
+            }
+
+            if let Some(name) = drawer_start(&line)
+                && let Some(end) = (i + 1..lines.len()).find(|&j| is_drawer_end(&lines[j]))
+            {
+                if !name.eq_ignore_ascii_case("logbook") && !name.eq_ignore_ascii_case("properties")
+                {
+                    let mut attr = class_attr(&["drawer"]);
+                    attr.2.insert("name".to_string(), name.to_string());
+                    blocks.push(Block::Div(Div {
+                        attr,
+                        content: self.blocks(&lines[i + 1..end]),
+                        source_info: lines_source(self.file_id, &lines[i..=end]),
+                        attr_source: AttrSourceInfo::empty(),
+                    }));
+                }
+                i = end + 1;
+                continue;
+            }
+
+            if is_fixed_width(&line) {
+                let end = end_of(lines, i, is_fixed_width);
+                let text = lines[i..end]
+                    .iter()
+                    .map(|line| {
+                        let text = line.text.trim_start();
+                        text.strip_prefix(": ")
+                            .unwrap_or(text.trim_start_matches(':'))
+                    })
+                    .collect::<Vec<_>>()
+                    .join("\n");
+                blocks.push(code_block(
+                    affiliated.attr(),
+                    vec!["example".to_string()],
+                    text,
+                    lines_source(self.file_id, &lines[i..end]),
+                ));
+                affiliated = Affiliated::default();
+                i = end;
+                continue;
+            }
+
+            if is_rule(&line) {
+                blocks.push(Block::HorizontalRule(HorizontalRule {
+                    source_info: lines_source(self.file_id, &lines[i..=i]),
+                }));
+                i += 1;
+                continue;
+            }
+
+            if is_table_el(&line) {
+                let end = end_of(lines, i, |line| {
+                    let text = line.text.trim_start();
+                    text.starts_with('+') || text.starts_with('|')
+                });
+                blocks.push(self.untranslated("A table.el table", &lines[i..end]));
+                i = end;
+                continue;
+            }
+
+            if is_table_line(&line) {
+                let end = end_of(lines, i, is_table_line);
+                let table_lines = &lines[i..end];
+                blocks.push(self.table(table_lines, std::mem::take(&mut affiliated)));
+                i = end;
+                continue;
+            }
+
+            if self.at_line_start(&line)
+                && let Some((label, content)) = footnote_definition(&line)
+            {
+                i = self.footnote_definition(label, content, lines, i, &mut blocks);
+                continue;
+            }
+
+            if let Some(name) = environment_start(&line) {
+                let end_marker = format!("\\end{{{}}}", name);
+                if let Some(end) = (i..lines.len()).find(|&j| lines[j].text.contains(&end_marker)) {
+                    let text = verbatim(&dedent_all(&lines[i..=end]));
+                    let source_info = lines_source(self.file_id, &lines[i..=end]);
+                    blocks.push(if MATH_ENVIRONMENTS.contains(&name.as_str()) {
+                        Block::Paragraph(Paragraph {
+                            content: vec![Inline::Math(Math {
+                                math_type: MathType::DisplayMath,
+                                text,
+                                source_info: source_info.clone(),
+                            })],
+                            source_info,
+                        })
+                    } else {
+                        Block::RawBlock(RawBlock {
+                            format: "latex".to_string(),
+                            text,
+                            source_info,
+                        })
+                    });
+                    i = end + 1;
+                    continue;
+                }
+            }
+
+            if let Some(start) = item_start(&line) {
+                i = self.list(start, lines, i, &mut blocks);
+                continue;
+            }
+
+            // A paragraph, to the next blank line or element
+            let mut end = i + 1;
+            while end < lines.len() && !lines[end].is_blank() && !self.starts_element(&lines[end]) {
+                end += 1;
+            }
+            blocks.push(self.paragraph(&lines[i..end], std::mem::take(&mut affiliated)));
+            i = end;
+        }
+        blocks
+    }
+
+    /// Read a heading and what belongs to it, and return the index of the
+    /// line after
+    fn heading(
+        &mut self,
+        heading: HeadingLine<'a>,
+        lines: &[Line<'a>],
+        i: usize,
+        blocks: &mut Blocks,
+    ) -> usize {
+        let mut next = i + 1;
+        if heading.excluded {
+            while next < lines.len()
+                && !self
+                    .heading_line(&lines[next])
+                    .is_some_and(|other| other.level <= heading.level)
+            {
+                next += 1;
+            }
+            return next;
+        }
+
+        let mut attr = empty_attr();
+        let mut attr_source = AttrSourceInfo::empty();
+        // Planning lines, then the property drawer
+        while next < lines.len() && is_planning(&lines[next]) {
+            next += 1;
+        }
+        if next < lines.len()
+            && drawer_start(&lines[next])
+                .is_some_and(|name| name.eq_ignore_ascii_case("properties"))
+            && let Some(end) = (next + 1..lines.len()).find(|&j| is_drawer_end(&lines[j]))
+        {
+            for property in &lines[next + 1..end] {
+                let Some((key, value)) = property_line(property) else {
+                    continue;
+                };
+                let value_source = SourceInfo::original(self.file_id, value.offset, value.end());
+                match key.to_uppercase().as_str() {
+                    "CUSTOM_ID" => {
+                        attr.0 = value.text.to_string();
+                        attr_source.id = Some(value_source);
+                    }
+                    "ID" => {}
+                    _ => {
+                        attr.2.insert(key.to_lowercase(), value.text.to_string());
+                        attr_source.attributes.push((None, Some(value_source)));
+                    }
+                }
+            }
+            next = end + 1;
+        }
+        if !heading.tags.is_empty() {
+            attr.2.insert("tags".to_string(), heading.tags.join(" "));
+            attr_source.attributes.push((None, None));
+        }
+
+        let mut content = Vec::new();
+        if let Some((keyword, done)) = heading.keyword {
+            let source_info = SourceInfo::original(self.file_id, keyword.offset, keyword.end());
+            let class = if done { "done" } else { "todo" };
+            content.push(Inline::Span(Span {
+                attr: class_attr(&[class, keyword.text]),
+                content: vec![Inline::Str(Str {
+                    text: keyword.text.to_string(),
+                    source_info: source_info.clone(),
+                })],
+                source_info,
+                attr_source: AttrSourceInfo::empty(),
+            }));
+            if !heading.title.text.is_empty() {
+                content.push(Inline::Space(Space {
+                    source_info: SourceInfo::default(),
+                }));
+            }
+        }
+        content.extend(self.line_inlines(&[heading.title]));
+        blocks.push(Block::Header(Header {
+            level: heading.level,
+            attr,
+            content,
+            source_info: lines_source(self.file_id, &lines[i..=i]),
+            attr_source,
+        }));
+        next
+    }
+
+    fn keyword(
+        &mut self,
+        key: &str,
+        value: Line<'a>,
+        line: &Line<'a>,
+        affiliated: &mut Affiliated,
+        blocks: &mut Blocks,
+    ) {
+        let key_source = SourceInfo::original(
+            self.file_id,
+            line.trim().offset + 2,
+            line.trim().offset + 2 + key.len(),
+        );
+        let value_source = SourceInfo::original(self.file_id, value.offset, value.end());
+        match key.to_uppercase().as_str() {
+            "TITLE" | "SUBTITLE" | "DATE" | "DESCRIPTION" => {
+                let inlines = self.line_inlines(&[value]);
+                self.set_meta(
+                    &key.to_lowercase(),
+                    ConfigValue::new_inlines(inlines, value_source),
+                    key_source,
+                );
+            }
+            "AUTHOR" => {
+                let authors: Vec<ConfigValue> = value
+                    .text
+                    .split(" and ")
+                    .filter(|author| !author.trim().is_empty())
+                    .map(|author| ConfigValue::new_string(author.trim(), value_source.clone()))
+                    .collect();
+                match authors.len() {
+                    0 => {}
+                    1 => self.set_meta("author", authors.into_iter().next().unwrap(), key_source),
+                    _ => self.set_meta(
+                        "author",
+                        ConfigValue::new_array(authors, value_source),
+                        key_source,
+                    ),
+                }
+            }
+            "KEYWORDS" => {
+                let keywords = value
+                    .text
+                    .split([',', ' '])
+                    .filter(|keyword| !keyword.is_empty())
+                    .map(|keyword| ConfigValue::new_string(keyword, value_source.clone()))
+                    .collect();
+                self.set_meta(
+                    "keywords",
+                    ConfigValue::new_array(keywords, value_source),
+                    key_source,
+                );
+            }
+            "LANGUAGE" => self.set_meta(
+                "lang",
+                ConfigValue::new_string(value.text, value_source),
+                key_source,
+            ),
+            "BIBLIOGRAPHY" => self.set_meta(
+                "bibliography",
+                ConfigValue::new_string(value.text, value_source),
+                key_source,
+            ),
+            "OPTIONS" => {
+                for option in value.text.split_whitespace() {
+                    let Some((name, setting)) = option.split_once(':') else {
+                        continue;
+                    };
+                    let key = match name {
+                        "toc" => "toc",
+                        "num" => "number-sections",
+                        _ => continue,
+                    };
+                    self.set_meta(
+                        key,
+                        ConfigValue::new_bool(setting != "nil", value_source.clone()),
+                        key_source.clone(),
+                    );
+                }
+            }
+            "TODO" | "SEQ_TODO" | "TYP_TODO" => {
+                let mut done = false;
+                for word in value.text.split_whitespace() {
+                    if word == "|" {
+                        done = true;
+                        continue;
+                    }
+                    let word = word.split('(').next().unwrap_or(word);
+                    self.todo_keywords.push((word.to_string(), done));
+                }
+                // Without `|`, the last keyword is the done state
+                if !done && let Some(last) = self.todo_keywords.last_mut() {
+                    last.1 = true;
+                }
+            }
+            "CAPTION" => affiliated.caption = Some(self.line_inlines(&[value])),
+            "NAME" => {
+                self.targets
+                    .insert(normalize_name(value.text), format!("#{}", value.text));
+                affiliated.name = Some((value.text.to_string(), value_source));
+            }
+            "ATTR_HTML" => {
+                let words: Vec<&str> = value.text.split_whitespace().collect();
+                for pair in words.windows(2) {
+                    if let [":width" | ":height", setting] = pair {
+                        affiliated
+                            .attributes
+                            .push((pair[0][1..].to_string(), setting.to_string()));
+                    }
+                }
+            }
+            "HTML" | "LATEX" | "BEAMER" | "ODT" | "TEXINFO" | "MAN" | "ASCII" => {
+                blocks.push(Block::RawBlock(RawBlock {
+                    format: key.to_lowercase(),
+                    text: value.text.to_string(),
+                    source_info: value_source,
+                }));
+            }
+            "INCLUDE" | "CALL" => {
+                let problem = format!("`#+{}:` isn't translated", key.to_uppercase());
+                blocks.push(self.untranslated_with(&problem, &[*line]));
+            }
+            // Export settings and keywords without a counterpart
+            _ => {}
+        }
+    }
+
+    fn set_meta(&mut self, key: &str, value: ConfigValue, key_source: SourceInfo) {
+        if self.meta.iter().any(|entry| entry.key == key) {
+            return;
+        }
+        self.meta.push(ConfigMapEntry {
+            key: key.to_string(),
+            key_source,
+            value,
+        });
+    }
+
+    /// A `#+begin_NAME` ... `#+end_NAME` block, with `body` the lines
+    /// between
+    fn block(
+        &mut self,
+        name: &str,
+        args: Line<'a>,
+        body: &[Line<'a>],
+        all: &[Line<'a>],
+        affiliated: &Affiliated,
+    ) -> Option<Block> {
+        let source_info = lines_source(self.file_id, all);
+        let block = match name {
+            "src" => {
+                let (classes, attributes) = src_arguments(args.text);
+                let ((id, _, _), attr_source) = affiliated.attr();
+                let mut attr = (id, classes, hashlink::LinkedHashMap::new());
+                for (key, value) in attributes {
+                    attr.2.insert(key, value);
+                }
+                if let Some(caption) = &affiliated.caption {
+                    attr.2.insert(
+                        "caption".to_string(),
+                        crate::utils::autoid::plain_text(caption).trim().to_string(),
+                    );
+                }
+                Block::CodeBlock(CodeBlock {
+                    attr,
+                    text: unescape_block(body),
+                    source_info,
+                    attr_source,
+                })
+            }
+            "example" => code_block(
+                affiliated.attr(),
+                vec!["example".to_string()],
+                unescape_block(body),
+                source_info,
+            ),
+            "export" => Block::RawBlock(RawBlock {
+                format: args
+                    .text
+                    .split_whitespace()
+                    .next()
+                    .unwrap_or("html")
+                    .to_lowercase(),
+                text: verbatim(body),
+                source_info,
+            }),
+            "comment" => return None,
+            "quote" => Block::BlockQuote(BlockQuote {
+                content: self.blocks(body),
+                source_info,
+            }),
+            "verse" => Block::LineBlock(LineBlock {
+                content: body
+                    .iter()
+                    .map(|line| self.line_inlines(&[line.trim()]))
+                    .collect(),
+                source_info,
+            }),
+            _ => {
+                let (mut attr, attr_source) = affiliated.attr();
+                attr.1 = vec![callout_class(name).unwrap_or(name).to_string()];
+                Block::Div(Div {
+                    attr,
+                    content: self.blocks(body),
+                    source_info,
+                    attr_source,
+                })
+            }
+        };
+        Some(block)
+    }
+
+    fn table(&mut self, lines: &[Line<'a>], affiliated: Affiliated) -> Block {
+        let source_info = lines_source(self.file_id, lines);
+        let mut groups: Vec<Vec<Vec<Blocks>>> = vec![Vec::new()];
+        let mut alignments = Vec::new();
+        for line in lines {
+            let text = line.text.trim();
+            if text.starts_with("|-") {
+                if !groups.last().is_some_and(Vec::is_empty) {
+                    groups.push(Vec::new());
+                }
+                continue;
+            }
+            let cells = table_cells(line);
+            if let Some(row_alignments) = alignment_row(&cells) {
+                alignments = row_alignments;
+                continue;
+            }
+            let row = cells
+                .iter()
+                .map(|cell| {
+                    let content = self.line_inlines(&[*cell]);
+                    if content.is_empty() {
+                        Vec::new()
+                    } else {
+                        vec![Block::Plain(Plain {
+                            content,
+                            source_info: SourceInfo::original(
+                                self.file_id,
+                                cell.offset,
+                                cell.end(),
+                            ),
+                        })]
+                    }
+                })
+                .collect();
+            groups.last_mut().unwrap().push(row);
+        }
+        groups.retain(|group| !group.is_empty());
+        let mut rows = TableRows {
+            alignments,
+            ..Default::default()
+        };
+        if groups.len() > 1 {
+            rows.head = groups.remove(0);
+        }
+        rows.body = groups.into_iter().flatten().collect();
+        let (attr, _) = affiliated.attr();
+        table(attr, affiliated.caption, rows, source_info)
+    }
+
+    fn footnote_definition(
+        &mut self,
+        label: String,
+        content: usize,
+        lines: &[Line<'a>],
+        i: usize,
+        blocks: &mut Blocks,
+    ) -> usize {
+        let mut end = i + 1;
+        while end < lines.len() {
+            let line = &lines[end];
+            if self.heading_line(line).is_some()
+                || (self.at_line_start(line) && footnote_definition(line).is_some())
+                || (line.is_blank() && lines.get(end + 1).is_none_or(Line::is_blank))
+            {
+                break;
+            }
+            end += 1;
+        }
+        let mut note_lines = vec![lines[i].from(content).trim()];
+        note_lines.extend_from_slice(&lines[i + 1..end]);
+        let content = self.blocks(&note_lines);
+        blocks.push(Block::NoteDefinitionFencedBlock(
+            NoteDefinitionFencedBlock {
+                id: label,
+                content,
+                source_info: lines_source(self.file_id, &lines[i..end]),
+            },
+        ));
+        end
+    }
+
+    /// Read a list starting at line `i`, and return the index of the line
+    /// after it
+    fn list(
+        &mut self,
+        first: ItemStart,
+        lines: &[Line<'a>],
+        i: usize,
+        blocks: &mut Blocks,
+    ) -> usize {
+        let mut items: Vec<(Option<Inlines>, Blocks)> = Vec::new();
+        let mut loose = false;
+        let mut definitions = false;
+        let mut start_number = first.number.unwrap_or(1);
+        let mut i = i;
+        let list_start = i;
+        while i < lines.len() {
+            let Some(start) = item_start(&lines[i]) else {
+                break;
+            };
+            if start.indent != first.indent || !same_kind(&start.kind, &first.kind) {
+                break;
+            }
+            // The item's lines: its first line, then those indented more
+            // than its marker. Two blank lines end the list.
+            let mut end = i + 1;
+            while end < lines.len() {
+                if lines[end].is_blank() {
+                    if lines.get(end + 1).is_none_or(Line::is_blank) {
+                        break;
+                    }
+                } else if lines[end].indent() <= first.indent {
+                    break;
+                }
+                end += 1;
+            }
+            let mut item_end = end;
+            while item_end > i + 1 && lines[item_end - 1].is_blank() {
+                item_end -= 1;
+            }
+            // Blank lines within an item, or between it and the next, make
+            // the list loose
+            loose |= lines[i + 1..item_end].iter().any(Line::is_blank)
+                || (item_end < end && end < lines.len() && item_continues(&lines[end], &first));
+
+            let mut first_line = lines[i].from(start.content);
+            let mut prefix = Vec::new();
+            if let Some(rest) = first_line.text.strip_prefix("[@")
+                && let Some(close) = rest.find(']')
+            {
+                if items.is_empty() {
+                    start_number = rest[..close].parse().unwrap_or(start_number);
+                }
+                first_line = first_line.from(2 + close + 1).trim();
+            }
+            for (checkbox, text) in [
+                ("[ ]", task_lists::UNCHECKED),
+                ("[-]", task_lists::UNCHECKED),
+                ("[X]", task_lists::CHECKED),
+                ("[x]", task_lists::CHECKED),
+            ] {
+                if first_line.text.starts_with(checkbox) {
+                    let source_info = SourceInfo::original(
+                        self.file_id,
+                        first_line.offset,
+                        first_line.offset + 3,
+                    );
+                    prefix.push(Inline::Str(Str {
+                        text: text.to_string(),
+                        source_info: source_info.clone(),
+                    }));
+                    prefix.push(Inline::Space(Space { source_info }));
+                    first_line = first_line.from(3).trim();
+                    break;
+                }
+            }
+            let mut term = None;
+            if first.kind == ListKind::Bullet
+                && let Some(separator) = first_line.text.find(" ::")
+                && first_line.text[separator + 3..]
+                    .chars()
+                    .next()
+                    .is_none_or(char::is_whitespace)
+                && (items.is_empty() || definitions)
+            {
+                definitions = true;
+                term = Some(self.line_inlines(&[Line {
+                    text: &first_line.text[..separator],
+                    offset: first_line.offset,
+                }]));
+                first_line = first_line.from(separator + 3).trim();
+            }
+
+            let content_column = lines[i].indent()
+                + (start.content - (lines[i].text.len() - lines[i].text.trim_start().len()));
+            let mut item_lines = Vec::new();
+            if !first_line.is_blank() {
+                item_lines.push(first_line);
+            }
+            item_lines.extend(
+                lines[i + 1..item_end]
+                    .iter()
+                    .map(|line| line.dedent(content_column)),
+            );
+            let mut content = self.blocks(&item_lines);
+            if !prefix.is_empty() {
+                match content.first_mut() {
+                    Some(Block::Paragraph(Paragraph { content, .. }))
+                    | Some(Block::Plain(Plain { content, .. })) => {
+                        prefix.append(content);
+                        *content = prefix;
+                    }
+                    _ => content.insert(
+                        0,
+                        Block::Plain(Plain {
+                            content: trim_inlines(prefix),
+                            source_info: SourceInfo::default(),
+                        }),
+                    ),
+                }
+            }
+            items.push((term.or(definitions.then(Vec::new)), content));
+            i = end;
+        }
+
+        let mut list_end = i;
+        while list_end > list_start && lines[list_end - 1].is_blank() {
+            list_end -= 1;
+        }
+        let source_info = lines_source(self.file_id, &lines[list_start..list_end]);
+        let (terms, contents): (Vec<Option<Inlines>>, Vec<Blocks>) = items.into_iter().unzip();
+        let contents = if loose { contents } else { tighten(contents) };
+        blocks.push(if definitions {
+            Block::DefinitionList(DefinitionList {
+                content: terms
+                    .into_iter()
+                    .zip(contents)
+                    .map(|(term, content)| (term.unwrap_or_default(), vec![content]))
+                    .collect(),
+                source_info,
+            })
+        } else {
+            match first.kind {
+                ListKind::Bullet => Block::BulletList(BulletList {
+                    content: contents,
+                    source_info,
+                }),
+                ListKind::Ordered(delimiter) => Block::OrderedList(OrderedList {
+                    attr: (start_number, ListNumberStyle::Decimal, delimiter),
+                    content: contents,
+                    source_info,
+                }),
+            }
+        });
+        i
+    }
+
+    fn paragraph(&mut self, lines: &[Line<'a>], affiliated: Affiliated) -> Block {
+        let source_info = lines_source(self.file_id, lines);
+        let mut content = self.line_inlines(lines);
+        if let [Inline::Image(image)] = content.as_mut_slice() {
+            for (key, value) in &affiliated.attributes {
+                image.attr.2.insert(key.clone(), value.clone());
+            }
+        }
+        if (affiliated.caption.is_some() || affiliated.name.is_some())
+            && matches!(content.as_slice(), [Inline::Image(_)])
+        {
+            let (attr, attr_source) = affiliated.attr();
+            return Block::Figure(Figure {
+                attr,
+                caption: Caption {
+                    short: None,
+                    long: affiliated.caption.map(|caption| {
+                        vec![Block::Plain(Plain {
+                            content: caption,
+                            source_info: source_info.clone(),
+                        })]
+                    }),
+                    source_info: source_info.clone(),
+                },
+                content: vec![Block::Plain(Plain {
+                    content,
+                    source_info: source_info.clone(),
+                })],
+                source_info,
+                attr_source,
+            });
+        }
+        Block::Paragraph(Paragraph {
+            content,
+            source_info,
+        })
+    }
+
+    fn untranslated(&mut self, what: &str, lines: &[Line<'a>]) -> Block {
+        self.untranslated_with(&format!("{} isn't translated", what), lines)
+    }
+
+    fn untranslated_with(&mut self, problem: &str, lines: &[Line<'a>]) -> Block {
+        let source_info = lines_source(self.file_id, lines);
+        self.warnings.push(untranslated(
+            "org",
+            problem.to_string(),
+            source_info.clone(),
+        ));
+        Block::RawBlock(RawBlock {
+            format: "org".to_string(),
+            text: verbatim(lines),
+            source_info,
+        })
+    }
+
+    fn line_inlines(&mut self, lines: &[Line<'a>]) -> Inlines {
+        let lines: Vec<Line> = lines.iter().map(Line::trim).collect();
+        let fragment = Fragment::new(self.file_id, &lines);
+        let inlines = self.inlines(&fragment, 0, fragment.text.len());
+        trim_inlines(inlines)
+    }
+
+    fn inlines(&mut self, fragment: &Fragment, start: usize, end: usize) -> Inlines {
+        let text = &fragment.text;
+        let mut inlines = Vec::new();
+        let mut plain = start;
+        let mut pos = start;
+        while pos < end {
+            let c = text[pos..].chars().next().unwrap();
+            if let Some((parsed, next)) = self.inline(fragment, start, pos, end, c) {
+                push_text(&mut inlines, fragment, plain, &text[plain..pos]);
+                inlines.extend(parsed);
+                pos = next;
+                plain = next;
+            } else {
+                pos += c.len_utf8();
+            }
+        }
+        push_text(&mut inlines, fragment, plain, &text[plain..end]);
+        inlines
+    }
+
+    /// The inline that starts with `c` at `pos`, if one does, and where it
+    /// ends
+    fn inline(
+        &mut self,
+        fragment: &Fragment,
+        start: usize,
+        pos: usize,
+        end: usize,
+        c: char,
+    ) -> Option<(Inlines, usize)> {
+        let text = &fragment.text[..end];
+        let rest = &text[pos..];
+        let previous = text[start..pos].chars().next_back();
+        let at_word_start = previous.is_none_or(|p| p.is_whitespace() || "-({'\"".contains(p));
+        match c {
+            '\\' if rest.starts_with("\\\\")
+                && rest[2..].chars().next().is_none_or(|c| c == '\n') =>
+            {
+                let next = (pos + 3).min(end);
+                Some((
+                    vec![Inline::LineBreak(LineBreak {
+                        source_info: fragment.source(pos, pos + 2),
+                    })],
+                    next,
+                ))
+            }
+            '\\' if rest.starts_with("\\(") => {
+                let close = rest.find("\\)")?;
+                Some((
+                    vec![math(
+                        fragment,
+                        MathType::InlineMath,
+                        (pos, pos + 2),
+                        (pos + close, pos + close + 2),
+                    )],
+                    pos + close + 2,
+                ))
+            }
+            '\\' if rest.starts_with("\\[") => {
+                let close = rest.find("\\]")?;
+                Some((
+                    vec![math(
+                        fragment,
+                        MathType::DisplayMath,
+                        (pos, pos + 2),
+                        (pos + close, pos + close + 2),
+                    )],
+                    pos + close + 2,
+                ))
+            }
+            '$' if rest.starts_with("$$") => {
+                let close = rest[2..].find("$$")? + 2;
+                Some((
+                    vec![math(
+                        fragment,
+                        MathType::DisplayMath,
+                        (pos, pos + 2),
+                        (pos + close, pos + close + 2),
+                    )],
+                    pos + close + 2,
+                ))
+            }
+            '$' if previous != Some('$') => {
+                let close = math_close(rest)?;
+                Some((
+                    vec![math(
+                        fragment,
+                        MathType::InlineMath,
+                        (pos, pos + 1),
+                        (pos + close, pos + close + 1),
+                    )],
+                    pos + close + 1,
+                ))
+            }
+            '[' if rest.starts_with("[[") => self.link(fragment, pos, end),
+            '[' if rest.starts_with("[fn:") => self.footnote(fragment, pos, end),
+            '[' if rest.starts_with("[cite") => self.citation(fragment, pos, end),
+            '<' if rest.starts_with("<<") && !rest.starts_with("<<<") => {
+                let close = rest.find(">>")?;
+                let name = &rest[2..close];
+                if name.is_empty()
+                    || name.starts_with(' ')
+                    || name.ends_with(' ')
+                    || name.contains('\n')
+                {
+                    return None;
+                }
+                self.targets
+                    .insert(normalize_name(name), format!("#{}", name));
+                Some((
+                    vec![Inline::Span(Span {
+                        attr: (name.to_string(), Vec::new(), hashlink::LinkedHashMap::new()),
+                        content: Vec::new(),
+                        source_info: fragment.source(pos, pos + close + 2),
+                        attr_source: AttrSourceInfo::empty(),
+                    })],
+                    pos + close + 2,
+                ))
+            }
+            's' if at_word_start && rest.starts_with("src_") => {
+                let language_end = 4 + rest[4..].find(['{', '['])?;
+                let language = &rest[4..language_end];
+                if language.is_empty() || language.contains(char::is_whitespace) {
+                    return None;
+                }
+                let mut open = language_end;
+                if rest[open..].starts_with('[') {
+                    open = matching(rest, open, '[', ']')? + 1;
+                }
+                if !rest[open..].starts_with('{') {
+                    return None;
+                }
+                let close = matching(rest, open, '{', '}')?;
+                Some((
+                    vec![Inline::Code(Code {
+                        attr: class_attr(&[language]),
+                        text: rest[open + 1..close].to_string(),
+                        source_info: fragment.source(pos, pos + close + 1),
+                        attr_source: AttrSourceInfo::empty(),
+                    })],
+                    pos + close + 1,
+                ))
+            }
+            '@' if rest.starts_with("@@") => {
+                let colon = rest[2..].find(':')? + 2;
+                let format = &rest[2..colon];
+                if format.is_empty() || !format.chars().all(|c| c.is_alphanumeric() || c == '-') {
+                    return None;
+                }
+                let close = rest[colon..].find("@@")? + colon;
+                Some((
+                    vec![Inline::RawInline(RawInline {
+                        format: format.to_string(),
+                        text: rest[colon + 1..close].to_string(),
+                        source_info: fragment.source(pos, pos + close + 2),
+                    })],
+                    pos + close + 2,
+                ))
+            }
+            '_' | '^'
+                if previous.is_some_and(|p| !p.is_whitespace()) && rest[1..].starts_with('{') =>
+            {
+                let close = matching(rest, 1, '{', '}')?;
+                let content = self.inlines(fragment, pos + 2, pos + close);
+                let source_info = fragment.source(pos, pos + close + 1);
+                let inline = if c == '_' {
+                    Inline::Subscript(Subscript {
+                        content,
+                        source_info,
+                    })
+                } else {
+                    Inline::Superscript(Superscript {
+                        content,
+                        source_info,
+                    })
+                };
+                Some((vec![inline], pos + close + 1))
+            }
+            '*' | '/' | '_' | '+' | '=' | '~' if at_word_start => {
+                let close = emphasis_close(rest, c)?;
+                let source_info = fragment.source(pos, pos + close + 1);
+                let inline = match c {
+                    '=' | '~' => Inline::Code(Code {
+                        attr: empty_attr(),
+                        text: rest[1..close].to_string(),
+                        source_info,
+                        attr_source: AttrSourceInfo::empty(),
+                    }),
+                    _ => {
+                        let content = self.inlines(fragment, pos + 1, pos + close);
+                        match c {
+                            '*' => Inline::Strong(Strong {
+                                content,
+                                source_info,
+                            }),
+                            '/' => Inline::Emph(Emph {
+                                content,
+                                source_info,
+                            }),
+                            '_' => Inline::Underline(Underline {
+                                content,
+                                source_info,
+                            }),
+                            _ => Inline::Strikeout(Strikeout {
+                                content,
+                                source_info,
+                            }),
+                        }
+                    }
+                };
+                Some((vec![inline], pos + close + 1))
+            }
+            'h' | 'f' | 'm'
+                if at_word_start
+                    && ["https://", "http://", "ftp://", "mailto:"]
+                        .iter()
+                        .any(|scheme| rest.starts_with(scheme)) =>
+            {
+                let len = rest
+                    .find(|c: char| c.is_whitespace() || "<>[]".contains(c))
+                    .unwrap_or(rest.len());
+                let url =
+                    rest[..len].trim_end_matches(['.', ',', ';', ':', '!', '?', ')', '\'', '"']);
+                let source_info = fragment.source(pos, pos + url.len());
+                Some((
+                    vec![Inline::Link(Link {
+                        attr: class_attr(&["uri"]),
+                        content: vec![Inline::Str(Str {
+                            text: url.to_string(),
+                            source_info: source_info.clone(),
+                        })],
+                        target: (url.to_string(), String::new()),
+                        source_info,
+                        attr_source: AttrSourceInfo::empty(),
+                        target_source: TargetSourceInfo::empty(),
+                    })],
+                    pos + url.len(),
+                ))
+            }
+            _ => None,
+        }
+    }
+
+    /// `[[target]]` or `[[target][description]]`
+    fn link(&mut self, fragment: &Fragment, pos: usize, end: usize) -> Option<(Inlines, usize)> {
+        let rest = &fragment.text[pos + 2..end];
+        let close = rest.find("]]")?;
+        let body = &rest[..close];
+        let next = pos + 2 + close + 2;
+        let source_info = fragment.source(pos, next);
+        let (target, description) = match body.find("][") {
+            Some(separator) => (
+                &body[..separator],
+                Some((pos + 2 + separator + 2, pos + 2 + close)),
+            ),
+            None => (body, None),
+        };
+        let target = target.replace('\n', " ");
+
+        let description_text = description.map(|(start, end)| &fragment.text[start..end]);
+        let content = match description_text {
+            Some(text) if is_image(text) => vec![image(url_of(text), source_info.clone())],
+            Some(_) => {
+                let (start, end) = description.unwrap();
+                self.inlines(fragment, start, end)
+            }
+            None if is_image(&target) => {
+                return Some((vec![image(url_of(&target), source_info)], next));
+            }
+            None => vec![Inline::Str(Str {
+                text: target.strip_prefix('*').unwrap_or(&target).to_string(),
+                source_info: fragment.source(pos + 2, pos + 2 + close),
+            })],
+        };
+
+        let link = |url: String| {
+            Inline::Link(Link {
+                attr: empty_attr(),
+                content: content.clone(),
+                target: (url, String::new()),
+                source_info: source_info.clone(),
+                attr_source: AttrSourceInfo::empty(),
+                target_source: TargetSourceInfo::empty(),
+            })
+        };
+        let inline = if let Some(heading) = target.strip_prefix('*') {
+            reference_link(content.clone(), heading, source_info.clone())
+        } else if target.starts_with('#') {
+            link(target.clone())
+        } else if let Some(doi) = target.strip_prefix("doi:") {
+            link(format!("https://doi.org/{}", doi))
+        } else if let Some(file) = target.strip_prefix("file:") {
+            link(file.split("::").next().unwrap_or(file).to_string())
+        } else if target.contains("://")
+            || target.starts_with("mailto:")
+            || target.starts_with('/')
+            || target.starts_with("./")
+            || target.starts_with("../")
+            || target.starts_with("~/")
+        {
+            link(target.clone())
+        } else {
+            reference_link(content.clone(), &target, source_info.clone())
+        };
+        Some((vec![inline], next))
+    }
+
+    /// `[fn:label]`, `[fn:label:definition]` or `[fn::definition]`
+    fn footnote(
+        &mut self,
+        fragment: &Fragment,
+        pos: usize,
+        end: usize,
+    ) -> Option<(Inlines, usize)> {
+        let text = &fragment.text[..end];
+        let close = matching(&text[pos..], 0, '[', ']')? + pos;
+        let body = &text[pos + 4..close];
+        let source_info = fragment.source(pos, close + 1);
+        match body.find(':') {
+            None => {
+                if body.is_empty()
+                    || !body
+                        .chars()
+                        .all(|c| c.is_alphanumeric() || c == '-' || c == '_')
+                {
+                    return None;
+                }
+                Some((vec![note_reference(body, source_info)], close + 1))
+            }
+            Some(colon) => {
+                let label = &body[..colon];
+                let definition_start = pos + 4 + colon + 1;
+                let content = trim_inlines(self.inlines(fragment, definition_start, close));
+                Some((
+                    vec![Inline::Note(Note {
+                        id: (!label.is_empty()).then(|| label.to_string()),
+                        content: vec![Block::Paragraph(Paragraph {
+                            content,
+                            source_info: fragment.source(definition_start, close),
+                        })],
+                        source_info,
+                    })],
+                    close + 1,
+                ))
+            }
+        }
+    }
+
+    /// `[cite:@key]`, `[cite/t:see @key p. 3; @other]`
+    fn citation(
+        &mut self,
+        fragment: &Fragment,
+        pos: usize,
+        end: usize,
+    ) -> Option<(Inlines, usize)> {
+        let text = &fragment.text[..end];
+        let close = matching(&text[pos..], 0, '[', ']')? + pos;
+        let body = &text[pos + 5..close];
+        let colon = body.find(':')?;
+        let style = body[..colon].strip_prefix('/').unwrap_or(&body[..colon]);
+        let mode = match style.split('/').next().unwrap_or("") {
+            "t" | "text" => CitationMode::AuthorInText,
+            "na" | "noauthor" => CitationMode::SuppressAuthor,
+            _ => CitationMode::NormalCitation,
+        };
+        let references_start = pos + 5 + colon + 1;
+        let mut citations = Vec::new();
+        let mut piece_start = references_start;
+        for piece in text[references_start..close].split(';') {
+            let piece_end = piece_start + piece.len();
+            if let Some(at) = piece.find('@') {
+                let key_len = piece[at + 1..]
+                    .find(|c: char| !(c.is_alphanumeric() || "_-.:/".contains(c)))
+                    .unwrap_or(piece.len() - at - 1);
+                let key = piece[at + 1..at + 1 + key_len].trim_end_matches(['.', ':']);
+                let key_start = piece_start + at + 1;
+                citations.push(Citation {
+                    id: key.to_string(),
+                    prefix: trim_inlines(self.inlines(fragment, piece_start, piece_start + at)),
+                    suffix: trim_inlines(self.inlines(fragment, key_start + key.len(), piece_end)),
+                    mode,
+                    note_num: 1,
+                    hash: 0,
+                    id_source: Some(fragment.source(key_start, key_start + key.len())),
+                });
+            }
+            piece_start = piece_end + 1;
+        }
+        if citations.is_empty() {
+            return None;
+        }
+        let source_info = fragment.source(pos, close + 1);
+        Some((
+            vec![Inline::Cite(Cite {
+                citations,
+                content: vec![Inline::Str(Str {
+                    text: text[pos..=close].to_string(),
+                    source_info: source_info.clone(),
+                })],
+                source_info,
+            })],
+            close + 1,
+        ))
+    }
+}
+
+/// The first word of a heading's title
+fn first_word<'a>(title: &Line<'a>) -> &'a str {
+    title.text.split(' ').next().unwrap_or("")
+}
+
+/// Math from `open` to `close` in `fragment`, with the text from `start` to
+/// `end`
+fn math(
+    fragment: &Fragment,
+    math_type: MathType,
+    (open, start): (usize, usize),
+    (end, close): (usize, usize),
+) -> Inline {
+    Inline::Math(Math {
+        math_type,
+        text: fragment.text[start..end].to_string(),
+        source_info: fragment.source(open, close),
+    })
+}
+
+fn is_planning(line: &Line) -> bool {
+    let text = line.text.trim_start();
+    ["SCHEDULED:", "DEADLINE:", "CLOSED:"]
+        .iter()
+        .any(|keyword| text.starts_with(keyword))
+}
+
+/// `:KEY: value` in a property drawer
+fn property_line<'a>(line: &Line<'a>) -> Option<(&'a str, Line<'a>)> {
+    let line = line.trim();
+    let rest = line.text.strip_prefix(':')?;
+    let colon = rest.find(':')?;
+    let key = rest[..colon].trim_end_matches('+');
+    (!key.is_empty()).then(|| (key, line.from(1 + colon + 1).trim()))
+}
+
+fn same_kind(a: &ListKind, b: &ListKind) -> bool {
+    matches!(
+        (a, b),
+        (ListKind::Bullet, ListKind::Bullet) | (ListKind::Ordered(_), ListKind::Ordered(_))
+    )
+}
+
+fn item_continues(line: &Line, first: &ItemStart) -> bool {
+    item_start(line)
+        .is_some_and(|next| next.indent == first.indent && same_kind(&next.kind, &first.kind))
+}
+
+/// The index of the first line from `i` on that isn't `keep`
+fn end_of(lines: &[Line], i: usize, keep: impl Fn(&Line) -> bool) -> usize {
+    (i..lines.len())
+        .find(|&j| !keep(&lines[j]))
+        .unwrap_or(lines.len())
+}
+
+fn code_block(
+    (attr, attr_source): (quarto_pandoc_types::Attr, AttrSourceInfo),
+    classes: Vec<String>,
+    text: String,
+    source_info: SourceInfo,
+) -> Block {
+    Block::CodeBlock(CodeBlock {
+        attr: (attr.0, classes, attr.2),
+        text,
+        source_info,
+        attr_source,
+    })
+}
+
+/// The text of a source or example block: without the indentation its
+/// lines share, and without the comma that escapes `*` and `#+` at the
+/// start of a line
+fn unescape_block(body: &[Line]) -> String {
+    let body = dedent_all(body);
+    let lines: Vec<String> = body
+        .iter()
+        .map(|line| {
+            let text = line.text;
+            match text.strip_prefix(',') {
+                Some(rest) if rest.starts_with('*') || rest.starts_with("#+") => rest.to_string(),
+                _ => text.to_string(),
+            }
+        })
+        .collect();
+    let lines: Vec<Line> = lines.iter().map(|text| Line { text, offset: 0 }).collect();
+    verbatim(&lines)
+}
+
+/// The classes and attributes of `#+begin_src LANGUAGE -n :exports both`
+fn src_arguments(args: &str) -> (Vec<String>, Vec<(String, String)>) {
+    let mut classes = Vec::new();
+    let mut attributes = Vec::new();
+    let mut words = args.split_whitespace().peekable();
+    if let Some(language) = words.next_if(|word| !word.starts_with(['-', '+', ':'])) {
+        classes.push(language.to_string());
+    }
+    while let Some(word) = words.next() {
+        match word {
+            "-n" | "+n" => {
+                classes.push("numberLines".to_string());
+                if let Some(start) = words.next_if(|word| word.parse::<usize>().is_ok()) {
+                    attributes.push(("startFrom".to_string(), start.to_string()));
+                }
+            }
+            "-l" => {
+                words.next();
+            }
+            key if key.starts_with(':') => {
+                let mut value = Vec::new();
+                while let Some(word) = words.next_if(|word| !word.starts_with(':')) {
+                    value.push(word);
+                }
+                attributes.push((key[1..].to_string(), value.join(" ")));
+            }
+            _ => {}
+        }
+    }
+    (classes, attributes)
+}
+
+/// The cells of `| a | b |`, without their surrounding spaces
+fn table_cells<'a>(line: &Line<'a>) -> Vec<Line<'a>> {
+    let line = line.trim();
+    let inner = line.from(1);
+    let text = inner.text.strip_suffix('|').unwrap_or(inner.text);
+    let mut cells = Vec::new();
+    let mut start = 0;
+    for (i, c) in text.char_indices() {
+        if c == '|' {
+            cells.push(inner_slice(&inner, start, i).trim());
+            start = i + 1;
+        }
+    }
+    cells.push(inner_slice(&inner, start, text.len()).trim());
+    cells
+}
+
+fn inner_slice<'a>(line: &Line<'a>, start: usize, end: usize) -> Line<'a> {
+    Line {
+        text: &line.text[start..end],
+        offset: line.offset + start,
+    }
+}
+
+/// The alignments of a row of `<l>`, `<c>` and `<r>` cookies, if it is one
+fn alignment_row(cells: &[Line]) -> Option<Vec<Alignment>> {
+    let mut any = false;
+    let alignments = cells
+        .iter()
+        .map(|cell| {
+            let text = cell.text;
+            if text.is_empty() {
+                return Some(Alignment::Default);
+            }
+            let inner = text.strip_prefix('<')?.strip_suffix('>')?;
+            let letter = inner.trim_end_matches(|c: char| c.is_ascii_digit());
+            any = true;
+            match letter {
+                "l" => Some(Alignment::Left),
+                "c" => Some(Alignment::Center),
+                "r" => Some(Alignment::Right),
+                "" => Some(Alignment::Default),
+                _ => None,
+            }
+        })
+        .collect::<Option<Vec<_>>>()?;
+    any.then_some(alignments)
+}
+
+/// The index in `text` of the bracket closing the one at `open`
+fn matching(text: &str, open: usize, open_char: char, close_char: char) -> Option<usize> {
+    let mut depth = 0;
+    for (i, c) in text[open..].char_indices() {
+        if c == open_char {
+            depth += 1;
+        } else if c == close_char {
+            depth -= 1;
+            if depth == 0 {
+                return Some(open + i);
+            }
+        }
+    }
+    None
+}
+
+/// Where the marker closing the emphasis that `rest` starts with is: after
+/// a character that isn't whitespace, and before whitespace, punctuation or
+/// the end, with at most one line break between
+fn emphasis_close(rest: &str, marker: char) -> Option<usize> {
+    let content = &rest[1..];
+    if content.starts_with(char::is_whitespace) || content.starts_with(marker) {
+        return None;
+    }
+    let mut newlines = 0;
+    let mut previous = marker;
+    for (i, c) in content.char_indices() {
+        if c == '\n' {
+            newlines += 1;
+            if newlines > 1 {
+                return None;
+            }
+        }
+        if c == marker && i > 0 && !previous.is_whitespace() {
+            let after = content[i + 1..].chars().next();
+            if after.is_none_or(|a| a.is_whitespace() || "-.,;:!?'\")}[\\".contains(a)) {
+                return Some(i + 1);
+            }
+        }
+        previous = c;
+    }
+    None
+}
+
+/// Where the `$` closing inline math that `rest` starts with is
+fn math_close(rest: &str) -> Option<usize> {
+    let content = &rest[1..];
+    let first = content.chars().next()?;
+    if first.is_whitespace() || ".,;$".contains(first) {
+        return None;
+    }
+    let close = content.find('$')? + 1;
+    let before = rest[..close].chars().next_back()?;
+    let after = rest[close + 1..].chars().next();
+    if (close > 2 && (before.is_whitespace() || ".,".contains(before)))
+        || after.is_some_and(|a| a.is_alphanumeric())
+    {
+        return None;
+    }
+    Some(close)
+}
+
+fn is_image(target: &str) -> bool {
+    let path = url_of(target);
+    !path.contains(char::is_whitespace)
+        && path.rsplit_once('.').is_some_and(|(_, extension)| {
+            IMAGE_EXTENSIONS.contains(&extension.to_lowercase().as_str())
+        })
+}
+
+fn url_of(target: &str) -> String {
+    target.strip_prefix("file:").unwrap_or(target).to_string()
+}
+
+fn image(url: String, source_info: SourceInfo) -> Inline {
+    Inline::Image(Image {
+        attr: empty_attr(),
+        content: Vec::new(),
+        target: (url, String::new()),
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+        target_source: TargetSourceInfo::empty(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn blocks(input: &str) -> Blocks {
+        read(input, "test.org").0.blocks
+    }
+
+    fn paragraph(input: &str) -> Inlines {
+        match blocks(input).into_iter().next() {
+            Some(Block::Paragraph(para)) => para.content,
+            other => panic!("Expected a paragraph, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_metadata() {
+        let (pandoc, _context, _) = read(
+            "#+TITLE: A /short/ report\n#+AUTHOR: Ada and Grace\n#+OPTIONS: toc:t num:nil\n\nText.\n",
+            "test.org",
+        );
+        assert!(pandoc.meta.get("title").is_some());
+        assert!(matches!(
+            &pandoc.meta.get("author").unwrap().value,
+            quarto_pandoc_types::ConfigValueKind::Array(authors) if authors.len() == 2
+        ));
+        assert_eq!(
+            pandoc.meta.get("toc").and_then(ConfigValue::as_bool),
+            Some(true)
+        );
+        assert_eq!(
+            pandoc
+                .meta
+                .get("number-sections")
+                .and_then(ConfigValue::as_bool),
+            Some(false)
+        );
+        assert_eq!(pandoc.blocks.len(), 1);
+    }
+
+    #[test]
+    fn test_headings() {
+        let blocks = blocks(
+            "* TODO Write it :draft:\n  SCHEDULED: <2025-01-01>\n  :PROPERTIES:\n  :CUSTOM_ID: sec-write\n  :EFFORT: 2h\n  :END:\n** Details\n* COMMENT Hidden\nGone.\n* Kept :noexport:\nGone too.\n* Last\n",
+        );
+        let headers: Vec<&Header> = blocks
+            .iter()
+            .filter_map(|block| match block {
+                Block::Header(header) => Some(header),
+                _ => None,
+            })
+            .collect();
+        assert_eq!(headers.len(), 3, "{:?}", blocks);
+        assert_eq!(blocks.len(), 3, "{:?}", blocks);
+        assert_eq!(headers[0].attr.0, "sec-write");
+        assert!(headers[0].attr_source.id.is_some());
+        assert_eq!(
+            headers[0].attr.2.get("effort").map(String::as_str),
+            Some("2h")
+        );
+        assert_eq!(
+            headers[0].attr.2.get("tags").map(String::as_str),
+            Some("draft")
+        );
+        assert!(
+            matches!(&headers[0].content[0], Inline::Span(span) if span.attr.1 == ["todo", "TODO"])
+        );
+        assert_eq!(headers[1].level, 2);
+        assert_eq!(headers[1].attr.0, "details");
+        assert!(headers[1].attr_source.id.is_none());
+    }
+
+    #[test]
+    fn test_inline_markup() {
+        let content = paragraph("*bold* /emph/ =code= +strike+ a*b*c $x^2$ H_{2}O\n");
+        assert!(matches!(&content[0], Inline::Strong(_)));
+        assert!(matches!(&content[2], Inline::Emph(_)));
+        assert!(matches!(&content[4], Inline::Code(code) if code.text == "code"));
+        assert!(matches!(&content[6], Inline::Strikeout(_)));
+        assert!(matches!(&content[8], Inline::Str(s) if s.text == "a*b*c"));
+        assert!(matches!(&content[10], Inline::Math(math) if math.text == "x^2"));
+        assert!(
+            matches!(&content[13], Inline::Subscript(_)),
+            "{:?}",
+            content
+        );
+    }
+
+    #[test]
+    fn test_links() {
+        let blocks = blocks(
+            "* Intro\n\nSee [[*Intro][the intro]], [[https://quarto.org][Quarto]], [[file:plot.png]] and https://example.com.\n",
+        );
+        let Block::Paragraph(para) = &blocks[1] else {
+            panic!("Expected a paragraph, got {:?}", blocks[1]);
+        };
+        let targets: Vec<&str> = para
+            .content
+            .iter()
+            .filter_map(|inline| match inline {
+                Inline::Link(link) => Some(link.target.0.as_str()),
+                Inline::Image(image) => Some(image.target.0.as_str()),
+                _ => None,
+            })
+            .collect();
+        assert_eq!(
+            targets,
+            [
+                "#intro",
+                "https://quarto.org",
+                "plot.png",
+                "https://example.com"
+            ]
+        );
+
+        let (pandoc, _context, warnings) = read("See [[Nowhere]].\n", "test.org");
+        assert!(
+            matches!(&pandoc.blocks[0], Block::Paragraph(para) if para.content.iter().all(|inline| !matches!(inline, Inline::Link(_))))
+        );
+        assert_eq!(warnings.len(), 1);
+        assert_eq!(warnings[0].code.as_deref(), Some("Q-2-45"));
+    }
+
+    #[test]
+    fn test_lists() {
+        let blocks = blocks(
+            "- one\n- [X] two\n  - nested\n- three\n\n1. first\n2. second\n\n- term :: definition\n",
+        );
+        assert_eq!(blocks.len(), 3, "{:?}", blocks);
+        let Block::BulletList(list) = &blocks[0] else {
+            panic!("Expected a bullet list, got {:?}", blocks[0]);
+        };
+        assert_eq!(list.content.len(), 3);
+        assert!(matches!(list.content[0][0], Block::Plain(_)));
+        assert!(matches!(list.content[1][1], Block::BulletList(_)));
+        let Block::Plain(plain) = &list.content[1][0] else {
+            panic!("Expected plain text, got {:?}", list.content[1][0]);
+        };
+        assert!(matches!(&plain.content[0], Inline::Str(s) if s.text == task_lists::CHECKED));
+        assert!(matches!(&blocks[1], Block::OrderedList(list) if list.content.len() == 2));
+        assert!(matches!(&blocks[2], Block::DefinitionList(list) if list.content.len() == 1));
+    }
+
+    #[test]
+    fn test_tables() {
+        let blocks = blocks(
+            "#+CAPTION: Results\n#+NAME: tbl-results\n| Name | Value |\n| <l>  |   <r> |\n|------+-------|\n| a    |     1 |\n| b    |     2 |\n",
+        );
+        let [Block::Table(table)] = blocks.as_slice() else {
+            panic!("Expected a table, got {:?}", blocks);
+        };
+        assert_eq!(table.attr.0, "tbl-results");
+        assert!(table.caption.long.is_some());
+        assert_eq!(table.head.rows.len(), 1);
+        assert_eq!(table.bodies[0].body.len(), 2);
+        assert_eq!(table.colspec[1].0, Alignment::Right);
+    }
+
+    #[test]
+    fn test_blocks() {
+        let blocks = blocks(concat!(
+            "#+BEGIN_SRC python :exports both\n,* not a heading\n#+END_SRC\n\n",
+            "#+begin_quote\nQuoted.\n#+end_quote\n\n",
+            "#+begin_note\nA note.\n#+end_note\n\n",
+            "#+begin_export html\n<b>raw</b>\n#+end_export\n\n",
+            ": fixed\n: width\n\n",
+            "-----\n",
+        ));
+        assert_eq!(blocks.len(), 6, "{:?}", blocks);
+        let Block::CodeBlock(code) = &blocks[0] else {
+            panic!("Expected a code block, got {:?}", blocks[0]);
+        };
+        assert_eq!(code.attr.1, ["python"]);
+        assert_eq!(code.attr.2.get("exports").map(String::as_str), Some("both"));
+        assert_eq!(code.text, "* not a heading");
+        assert!(matches!(&blocks[1], Block::BlockQuote(_)));
+        assert!(matches!(&blocks[2], Block::Div(div) if div.attr.1 == ["callout-note"]));
+        assert!(
+            matches!(&blocks[3], Block::RawBlock(raw) if raw.format == "html" && raw.text == "<b>raw</b>")
+        );
+        assert!(matches!(&blocks[4], Block::CodeBlock(code) if code.text == "fixed\nwidth"));
+        assert!(matches!(&blocks[5], Block::HorizontalRule(_)));
+    }
+
+    #[test]
+    fn test_footnotes_and_citations() {
+        let blocks = blocks("Text.[fn:1] As [cite/t:@knuth84] shows.\n\n[fn:1] The note.\n");
+        assert_eq!(blocks.len(), 1, "{:?}", blocks);
+        let Block::Paragraph(para) = &blocks[0] else {
+            panic!("Expected a paragraph, got {:?}", blocks[0]);
+        };
+        assert!(
+            para.content
+                .iter()
+                .any(|inline| matches!(inline, Inline::Note(_)))
+        );
+        assert!(para.content.iter().any(|inline| matches!(
+            inline,
+            Inline::Cite(cite) if cite.citations[0].id == "knuth84"
+                && cite.citations[0].mode == CitationMode::AuthorInText
+        )));
+    }
+
+    #[test]
+    fn test_untranslated() {
+        let (pandoc, _context, warnings) = read("#+INCLUDE: \"other.org\"\n", "test.org");
+        assert!(matches!(&pandoc.blocks[0], Block::RawBlock(raw) if raw.format == "org"));
+        assert_eq!(warnings[0].code.as_deref(), Some("Q-2-44"));
+    }
+
+    #[test]
+    fn test_source_spans() {
+        let input = "* Heading\n\nSome *bold* text.\n";
+        let blocks = blocks(input);
+        let Block::Paragraph(para) = &blocks[1] else {
+            panic!("Expected a paragraph, got {:?}", blocks[1]);
+        };
+        let Inline::Strong(strong) = &para.content[2] else {
+            panic!("Expected strong, got {:?}", para.content[2]);
+        };
+        let SourceInfo::Original {
+            start_offset,
+            end_offset,
+            ..
+        } = strong.source_info
+        else {
+            panic!("Expected an original source");
+        };
+        assert_eq!(&input[start_offset..end_offset], "*bold*");
+    }
+}
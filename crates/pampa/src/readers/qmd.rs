@@ -47,6 +47,13 @@ pub fn read_bad_qmd_for_error_message(input_bytes: &[u8]) -> Vec<String> {
     produce_error_message_json(&log_observer)
 }
 
+/// `input_bytes` without its UTF-8 byte order mark, if it has one
+fn strip_bom(input_bytes: &[u8]) -> &[u8] {
+    input_bytes
+        .strip_prefix(b"\xEF\xBB\xBF")
+        .unwrap_or(input_bytes)
+}
+
 pub fn read<T: Write>(
     input_bytes: &[u8],
     _loose: bool,
@@ -62,6 +69,9 @@ pub fn read<T: Write>(
     ),
     Vec<quarto_error_reporting::DiagnosticMessage>,
 > {
+    // A byte order mark isn't part of the document (and would hide front
+    // matter); source offsets are into the input without it
+    let input_bytes = strip_bom(input_bytes);
     let mut parser = MarkdownParser::default();
     let mut fast_log_observer = quarto_parse_errors::TreeSitterLogObserverFast::default();
     let mut log_observer = quarto_parse_errors::TreeSitterLogObserver::default();
@@ -249,3 +259,88 @@ pub fn read<T: Write>(
 
     Ok((result, context, warnings))
 }
+
+/// Read a document like [`read`], but always return one, for editors and
+/// other tools that need what can be read of a broken document. Errors are
+/// returned with the warnings.
+///
+/// When the document has errors, it is read again one top-level chunk at a
+/// time (see `qmd_stream`): the chunks that parse give the blocks of the
+/// document, and the ones that don't are left out, their diagnostics
+/// locating them. A panic while reading is an internal error diagnostic
+/// rather than the end of the process.
+pub fn read_recovering(
+    input_bytes: &[u8],
+    loose: bool,
+    filename: &str,
+    prune_errors: bool,
+) -> (
+    pandoc::Pandoc,
+    ASTContext,
+    Vec<quarto_error_reporting::DiagnosticMessage>,
+) {
+    let input_bytes = strip_bom(input_bytes);
+    let whole = catch_panic(|| {
+        read(
+            input_bytes,
+            loose,
+            filename,
+            &mut std::io::sink(),
+            prune_errors,
+            None,
+        )
+    });
+    let document_diagnostics = match whole {
+        Ok(result) => return result,
+        Err(diagnostics) => diagnostics,
+    };
+
+    let mut stream = crate::readers::qmd_stream::QmdBlockStream::new(input_bytes, filename);
+    let mut blocks = Vec::new();
+    let mut diagnostics = Vec::new();
+    // A chunk that panics has been taken from the stream, which goes on
+    // with the next one
+    while let Some(item) =
+        catch_panic(|| Ok(stream.next())).unwrap_or_else(|errors| Some(Err(errors)))
+    {
+        match item {
+            Ok(block) => blocks.push(block),
+            Err(errors) => diagnostics.extend(errors),
+        }
+    }
+    diagnostics.extend(stream.take_warnings());
+    // Some errors only show in the whole document
+    if !diagnostics
+        .iter()
+        .any(|diagnostic| diagnostic.kind == quarto_error_reporting::DiagnosticKind::Error)
+    {
+        diagnostics.extend(document_diagnostics);
+    }
+    let doc = pandoc::Pandoc {
+        meta: stream.meta().clone(),
+        blocks,
+    };
+    (doc, stream.context().clone(), diagnostics)
+}
+
+/// Run a reader, turning a panic into an internal error diagnostic
+fn catch_panic<R>(
+    f: impl FnOnce() -> Result<R, Vec<quarto_error_reporting::DiagnosticMessage>>,
+) -> Result<R, Vec<quarto_error_reporting::DiagnosticMessage>> {
+    std::panic::catch_unwind(std::panic::AssertUnwindSafe(f)).unwrap_or_else(|payload| {
+        let message = payload
+            .downcast_ref::<&str>()
+            .map(|message| message.to_string())
+            .or_else(|| payload.downcast_ref::<String>().cloned())
+            .unwrap_or_else(|| "unknown panic".to_string());
+        Err(vec![
+            quarto_error_reporting::DiagnosticMessageBuilder::error("Internal Error")
+                .with_code("Q-0-1")
+                .problem(format!("The qmd reader crashed: {}", message))
+                .add_hint(
+                    "This is a bug in pampa; please report it with the document that caused it.",
+                )
+                .build(),
+        ])
+    })
+}
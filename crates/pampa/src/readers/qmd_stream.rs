@@ -0,0 +1,342 @@
+/*
+ * qmd_stream.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Block-by-block reading of large qmd files.
+//!
+//! [`qmd::read`](super::qmd::read) builds the whole document before
+//! returning anything. [`QmdBlockStream`] instead splits the input into
+//! top-level chunks (runs of blocks that are separated by blank lines and
+//! can't affect each other's parse) and reads one chunk at a time, so a
+//! caller can process or write the first blocks of a huge file while the
+//! rest is still unread, and only holds the AST of one chunk at a time.
+//!
+//! Splitting is conservative: a blank line only ends a chunk when the next
+//! line starts a new top-level block outside any code fence, fenced div or
+//! YAML block, and isn't indented, a list item or a table caption, which
+//! could all continue what came before. When in doubt, chunks get bigger;
+//! the blocks read are the same as `qmd::read`'s.
+//!
+//! Known difference: `(@)` example list numbers restart in each chunk.
+
+use super::qmd;
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::{Block, ConfigValue, ConfigValueKind};
+use quarto_error_reporting::DiagnosticMessage;
+use quarto_source_map::{FileId, SourceInfo};
+use std::collections::VecDeque;
+use std::ops::Range;
+
+/// The byte ranges of the top-level chunks of `input`, in order. The ranges
+/// cover the whole input.
+pub fn top_level_chunks(input: &[u8]) -> TopLevelChunks<'_> {
+    TopLevelChunks { input, pos: 0 }
+}
+
+/// Iterator returned by [`top_level_chunks`]
+pub struct TopLevelChunks<'a> {
+    input: &'a [u8],
+    pos: usize,
+}
+
+impl Iterator for TopLevelChunks<'_> {
+    type Item = Range<usize>;
+
+    fn next(&mut self) -> Option<Range<usize>> {
+        let input = self.input;
+        if self.pos >= input.len() {
+            return None;
+        }
+        let start = self.pos;
+        let mut state = ChunkState::default();
+        let mut after_blank = true;
+        let mut line_start = start;
+        while line_start < input.len() {
+            let line_end = line_end(input, line_start);
+            let line = &input[line_start..line_end];
+            let blank = is_blank(line);
+            if line_start > start
+                && after_blank
+                && !blank
+                && state.is_top_level()
+                && starts_chunk(line)
+            {
+                self.pos = line_start;
+                return Some(start..line_start);
+            }
+            let next_line = &input[line_end..line_end(input, line_end)];
+            state.update(line, after_blank, next_line);
+            after_blank = blank;
+            line_start = line_end;
+        }
+        self.pos = input.len();
+        Some(start..input.len())
+    }
+}
+
+/// The offset just after the newline ending the line at `start`
+fn line_end(input: &[u8], start: usize) -> usize {
+    input[start..]
+        .iter()
+        .position(|&b| b == b'\n')
+        .map_or(input.len(), |i| start + i + 1)
+}
+
+fn is_blank(line: &[u8]) -> bool {
+    line.iter().all(|b| b.is_ascii_whitespace())
+}
+
+/// Whether a line after a blank line can start a chunk of its own
+fn starts_chunk(line: &[u8]) -> bool {
+    if line[0] == b' ' || line[0] == b'\t' {
+        return false;
+    }
+    if line[0] == b':' && !line.starts_with(b":::") {
+        // a table caption
+        return false;
+    }
+    !starts_list_item(line)
+}
+
+fn starts_list_item(line: &[u8]) -> bool {
+    let followed_by_space = |i: usize| line.get(i).is_none_or(|b| b.is_ascii_whitespace());
+    match line[0] {
+        b'-' | b'*' | b'+' => followed_by_space(1),
+        b'(' => line.get(1) == Some(&b'@'),
+        b'#' => line.get(1) == Some(&b'.') && followed_by_space(2),
+        b'0'..=b'9' => {
+            let digits = line.iter().take_while(|b| b.is_ascii_digit()).count();
+            matches!(line.get(digits), Some(b'.' | b')')) && followed_by_space(digits + 1)
+        }
+        b'a'..=b'z' | b'A'..=b'Z' => {
+            matches!(line.get(1), Some(b'.' | b')')) && followed_by_space(2)
+        }
+        _ => false,
+    }
+}
+
+#[derive(Default)]
+struct ChunkState {
+    /// Character and length of the open code fence
+    code_fence: Option<(u8, usize)>,
+    div_depth: usize,
+    in_yaml: bool,
+}
+
+impl ChunkState {
+    fn is_top_level(&self) -> bool {
+        self.code_fence.is_none() && self.div_depth == 0 && !self.in_yaml
+    }
+
+    fn update(&mut self, line: &[u8], after_blank: bool, next_line: &[u8]) {
+        let text = line.trim_ascii();
+        if self.in_yaml {
+            if text == b"---" || text == b"..." {
+                self.in_yaml = false;
+            }
+            return;
+        }
+        if let Some((fence_char, fence_len)) = self.code_fence {
+            let run = text.iter().take_while(|&&b| b == fence_char).count();
+            if run >= fence_len && run == text.len() {
+                self.code_fence = None;
+            }
+            return;
+        }
+        if let Some(fence) = code_fence(text) {
+            self.code_fence = Some(fence);
+        } else if text.starts_with(b":::") {
+            let colons = text.iter().take_while(|&&b| b == b':').count();
+            if !text[colons..].trim_ascii().is_empty() {
+                self.div_depth += 1;
+            } else if self.div_depth > 0 {
+                self.div_depth -= 1;
+            }
+        } else if text == b"---" && after_blank && !is_blank(next_line) {
+            self.in_yaml = true;
+        }
+    }
+}
+
+/// The character and length of the code fence `text` opens, if any
+fn code_fence(text: &[u8]) -> Option<(u8, usize)> {
+    let fence_char = *text.first()?;
+    if fence_char != b'`' && fence_char != b'~' {
+        return None;
+    }
+    let run = text.iter().take_while(|&&b| b == fence_char).count();
+    // backtick fences can't have backticks in their info string; that's
+    // inline code
+    if run < 3 || (fence_char == b'`' && text[run..].contains(&b'`')) {
+        return None;
+    }
+    Some((fence_char, run))
+}
+
+/// Reads a qmd document one top-level block at a time.
+///
+/// Each item is a block, or the diagnostics of a chunk that didn't parse.
+/// A chunk with errors doesn't stop the stream: the next item comes from
+/// the chunk after it. Source locations point into the whole input, which
+/// is the one file of [`context`](Self::context).
+pub struct QmdBlockStream<'a> {
+    input: &'a [u8],
+    filename: String,
+    chunks: TopLevelChunks<'a>,
+    pending: VecDeque<Block>,
+    meta: ConfigValue,
+    warnings: Vec<DiagnosticMessage>,
+    context: ASTContext,
+}
+
+impl<'a> QmdBlockStream<'a> {
+    pub fn new(input: &'a [u8], filename: &str) -> Self {
+        let mut context = ASTContext::with_filename(filename.to_string());
+        context.source_context = quarto_source_map::SourceContext::new();
+        context.source_context.add_file(
+            filename.to_string(),
+            Some(String::from_utf8_lossy(input).to_string()),
+        );
+        Self {
+            input,
+            filename: filename.to_string(),
+            chunks: top_level_chunks(input),
+            pending: VecDeque::new(),
+            meta: ConfigValue::default(),
+            warnings: Vec::new(),
+            context,
+        }
+    }
+
+    /// The document metadata read so far. Front matter comes first in a
+    /// document, so this is complete once the first block has been read,
+    /// unless later YAML blocks add to it.
+    pub fn meta(&self) -> &ConfigValue {
+        &self.meta
+    }
+
+    /// The context for the source locations of the blocks read
+    pub fn context(&self) -> &ASTContext {
+        &self.context
+    }
+
+    /// The warnings of the chunks read since the last call
+    pub fn take_warnings(&mut self) -> Vec<DiagnosticMessage> {
+        std::mem::take(&mut self.warnings)
+    }
+
+    /// Read the next chunk into `pending`
+    fn read_chunk(&mut self, range: Range<usize>) -> Result<(), Vec<DiagnosticMessage>> {
+        let chunk_source = SourceInfo::original(FileId(0), range.start, range.end);
+        let (doc, _context, warnings) = qmd::read(
+            &self.input[range],
+            false,
+            &self.filename,
+            &mut std::io::sink(),
+            true,
+            Some(chunk_source.clone()),
+        )
+        .map_err(|diagnostics| {
+            diagnostics
+                .into_iter()
+                .map(|diagnostic| relocate(diagnostic, &chunk_source))
+                .collect::<Vec<_>>()
+        })?;
+        self.warnings.extend(warnings);
+        if let ConfigValueKind::Map(entries) = doc.meta.value
+            && !entries.is_empty()
+            && let ConfigValueKind::Map(ref mut meta_entries) = self.meta.value
+        {
+            if meta_entries.is_empty() {
+                self.meta.source_info = doc.meta.source_info;
+            }
+            meta_entries.extend(entries);
+        }
+        self.pending.extend(doc.blocks);
+        Ok(())
+    }
+}
+
+impl Iterator for QmdBlockStream<'_> {
+    type Item = Result<Block, Vec<DiagnosticMessage>>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        loop {
+            if let Some(block) = self.pending.pop_front() {
+                return Some(Ok(block));
+            }
+            let range = self.chunks.next()?;
+            if let Err(diagnostics) = self.read_chunk(range) {
+                return Some(Err(diagnostics));
+            }
+        }
+    }
+}
+
+/// Move the locations of a diagnostic for a chunk, which are relative to
+/// the chunk, into the whole input
+fn relocate(mut diagnostic: DiagnosticMessage, chunk_source: &SourceInfo) -> DiagnosticMessage {
+    let relocate_location = |location: &mut Option<SourceInfo>| {
+        if let Some(SourceInfo::Original {
+            start_offset,
+            end_offset,
+            ..
+        }) = location
+        {
+            *location = Some(SourceInfo::substring(
+                chunk_source.clone(),
+                *start_offset,
+                *end_offset,
+            ));
+        }
+    };
+    relocate_location(&mut diagnostic.location);
+    for detail in &mut diagnostic.details {
+        relocate_location(&mut detail.location);
+    }
+    diagnostic
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn chunks(input: &str) -> Vec<&str> {
+        top_level_chunks(input.as_bytes())
+            .map(|range| &input[range])
+            .collect()
+    }
+
+    #[test]
+    fn test_splits_at_blank_lines() {
+        assert_eq!(
+            chunks("# Title\n\nSome text.\n\nMore.\n"),
+            vec!["# Title\n\n", "Some text.\n\n", "More.\n"]
+        );
+    }
+
+    #[test]
+    fn test_keeps_continuations_together() {
+        let list = "- a\n\n- b\n\n  more b\n";
+        assert_eq!(chunks(list), vec![list]);
+        let table = "| a |\n|---|\n| 1 |\n\n: Caption\n";
+        assert_eq!(chunks(table), vec![table]);
+    }
+
+    #[test]
+    fn test_keeps_fences_together() {
+        let code = "```\none\n\ntwo\n```\n";
+        let div = "::: {.note}\none\n\n::: inner\ntwo\n:::\n\nthree\n:::\n";
+        let yaml = "---\ntitle: x\n\nauthor: y\n---\n";
+        assert_eq!(
+            chunks(&format!("{}\n{}\n{}\nEnd.\n", code, div, yaml)),
+            vec![
+                &format!("{}\n", code)[..],
+                &format!("{}\n", div)[..],
+                &format!("{}\n", yaml)[..],
+                "End.\n"
+            ]
+        );
+    }
+}
@@ -0,0 +1,2629 @@
+/*
+ * rst.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * reStructuredText reader.
+ *
+ * Documents are mapped onto the AST the qmd reader produces, so that
+ * `pampa -f rst -t qmd` gives a Quarto document to start from:
+ *
+ * - Section titles get their levels in the order their underline styles
+ *   first appear. A title at the start of the document that no other
+ *   section shares a level with becomes the `title` metadata, and the
+ *   field list after it the rest of the metadata (`:author:`, `:date:`).
+ * - `.. _label:` gives the section or element after it an identifier, and
+ *   `label_` and `` :ref:`label` `` link to it, as `` `Section title`_ ``
+ *   links to a section.
+ * - Admonitions become callouts, `code-block`, `math`, `image`, `figure`,
+ *   `raw`, `list-table` and `csv-table` their Pandoc counterparts, and
+ *   `contents` the `toc` option.
+ * - Footnotes and citations (`[1]_`, `[#]_`, `[CIT2002]_`) become notes,
+ *   and the `cite` role Cites.
+ * - Interpreted text with a role there is no counterpart for is kept as
+ *   Code with the `interpreted-text` class and a `role` attribute.
+ *
+ * Directives with no translation, such as `include` and `toctree`, are kept
+ * as raw `rst` blocks, with a warning.
+ */
+
+use super::light_markup::{
+    Fragment, Line, TableRows, callout_class, class_attr, dedent_all, heading_targets, lines,
+    lines_source, normalize_name, note_reference, push_text, reference_link, resolve_references,
+    table, tighten, trim_inlines, unknown_reference, untranslated, verbatim,
+};
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::ast_context::ASTContext;
+use crate::pandoc::{
+    AttrSourceInfo, Block, BlockQuote, Blocks, BulletList, Caption, Citation, CitationMode, Cite,
+    Code, CodeBlock, DefinitionList, Div, Emph, Figure, Header, HorizontalRule, Image, Inline,
+    Inlines, LineBlock, Link, ListNumberDelim, ListNumberStyle, Math, MathType,
+    NoteDefinitionFencedBlock, OrderedList, Pandoc, Paragraph, Plain, RawBlock, Space, Span, Str,
+    Strong, Subscript, Superscript, TargetSourceInfo,
+};
+use crate::transforms;
+use crate::utils::autoid::IdentifierOptions;
+use quarto_error_reporting::DiagnosticMessage;
+use quarto_pandoc_types::attr::{Attr, empty_attr};
+use quarto_pandoc_types::{ConfigMapEntry, ConfigValue};
+use quarto_source_map::{FileId, SourceContext, SourceInfo};
+use std::collections::HashMap;
+
+/// The characters section titles can be underlined with
+const ADORNMENT_CHARS: &str = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~";
+
+/// What may come before inline markup
+const MARKUP_BEFORE: &str = "-:/'\"<([{";
+
+/// What may come after inline markup
+const MARKUP_AFTER: &str = "-.,:;!?\\/'\")]}>";
+
+/// How many times names and substitutions may refer on to others
+const MAX_INDIRECTION: usize = 8;
+
+/// Read a reStructuredText document, which has `\n` line endings, into a
+/// document and the warnings about what couldn't be translated
+pub fn read(input: &str, filename: &str) -> (Pandoc, ASTContext, Vec<DiagnosticMessage>) {
+    let mut context = ASTContext::with_filename(filename.to_string());
+    context.source_context = SourceContext::new();
+    let file_id = context
+        .source_context
+        .add_file(filename.to_string(), Some(input.to_string()));
+
+    let mut reader = RstReader {
+        file_id,
+        meta: Vec::new(),
+        styles: Vec::new(),
+        targets: HashMap::new(),
+        substitutions: HashMap::new(),
+        anonymous_targets: Vec::new(),
+        anonymous_used: 0,
+        auto_references: 0,
+        auto_definitions: 0,
+        symbol_references: 0,
+        symbol_definitions: 0,
+        pending_labels: Vec::new(),
+        default_language: None,
+        depth: 0,
+        substitution_depth: 0,
+        warnings: Vec::new(),
+    };
+    let lines = lines(input);
+    reader.collect(&lines);
+    let blocks = reader.blocks(&lines);
+    let blocks = reader.promote_title(blocks);
+
+    let blocks = transforms::resolve_footnotes(blocks, &context.source_context);
+    let blocks = transforms::assign_auto_identifiers(blocks, IdentifierOptions::default());
+    heading_targets(&blocks, &mut reader.targets);
+    reader.resolve_indirect_targets();
+    let (blocks, missing) = resolve_references(blocks, &reader.targets);
+    for (name, source_info) in missing {
+        reader.warnings.push(unknown_reference(&name, source_info));
+    }
+
+    let pandoc = Pandoc {
+        meta: ConfigValue::new_map(reader.meta, SourceInfo::default()),
+        blocks,
+    };
+    (pandoc, context, reader.warnings)
+}
+
+struct RstReader<'a> {
+    file_id: FileId,
+    meta: Vec<ConfigMapEntry>,
+    /// The adornments of section titles, in the order they first appear:
+    /// the character, and whether it is also above the title
+    styles: Vec<(char, bool)>,
+    /// What names in links refer to, by [`normalize_name`]. A name ending
+    /// in `_` refers to another name.
+    targets: HashMap<String, String>,
+    substitutions: HashMap<String, Substitution<'a>>,
+    /// The URLs of `.. __: url`, which `text__` links refer to in order
+    anonymous_targets: Vec<String>,
+    anonymous_used: usize,
+    /// How many `[#]_` references and `.. [#]` definitions there have been
+    auto_references: usize,
+    auto_definitions: usize,
+    /// The same for `[*]_` and `.. [*]`
+    symbol_references: usize,
+    symbol_definitions: usize,
+    /// `.. _label:` targets waiting for the element after them
+    pending_labels: Vec<(String, SourceInfo)>,
+    /// The language of literal blocks, from the `highlight` directive
+    default_language: Option<String>,
+    /// How deeply nested the blocks being read are
+    depth: usize,
+    substitution_depth: usize,
+    warnings: Vec<DiagnosticMessage>,
+}
+
+/// What a `|name|` substitution reference is replaced with
+#[derive(Debug, Clone)]
+enum Substitution<'a> {
+    /// `replace::`, read as inlines where it is used
+    Replace(Vec<Line<'a>>),
+    /// `image::`
+    Image {
+        url: String,
+        alt: String,
+        attributes: Vec<(String, String)>,
+    },
+    /// `unicode::`
+    Text(String),
+}
+
+/// A directive: `.. name:: arguments`, then its options and content
+struct Directive<'a> {
+    name: String,
+    arguments: Vec<Line<'a>>,
+    options: Vec<(String, String)>,
+    body: Vec<Line<'a>>,
+    source_info: SourceInfo,
+}
+
+impl Directive<'_> {
+    fn argument(&self) -> String {
+        self.arguments
+            .iter()
+            .map(|line| line.text.trim())
+            .collect::<Vec<_>>()
+            .join(" ")
+    }
+
+    fn option(&self, name: &str) -> Option<&str> {
+        self.options
+            .iter()
+            .find(|(key, _)| key == name)
+            .map(|(_, value)| value.as_str())
+    }
+
+    /// The identifier from the `:name:` option
+    fn attr(&self) -> Attr {
+        let mut attr = empty_attr();
+        if let Some(name) = self.option("name") {
+            attr.0 = label_id(name);
+        }
+        if let Some(classes) = self.option("class") {
+            attr.1 = classes.split_whitespace().map(str::to_string).collect();
+        }
+        attr
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum ListKind {
+    Bullet(char),
+    Enumerated(ListNumberStyle, ListNumberDelim),
+}
+
+/// The start of a list item: its kind, its number, and where its text
+/// starts in the line
+struct ItemStart {
+    kind: ListKind,
+    number: Option<usize>,
+    content: usize,
+}
+
+/// A line of one character repeated, as section titles are underlined
+/// with
+fn adornment(line: &Line) -> Option<char> {
+    let text = line.text.trim_end();
+    let c = text.chars().next()?;
+    (ADORNMENT_CHARS.contains(c) && text.chars().all(|other| other == c)).then_some(c)
+}
+
+fn item_start(line: &Line) -> Option<ItemStart> {
+    let text = line.text;
+    let first = text.chars().next()?;
+    let followed_by_space = |len: usize| text.len() == len || text[len..].starts_with([' ', '\t']);
+    let content_after = |len: usize| {
+        let after = &text[len..];
+        len + (after.len() - after.trim_start().len())
+    };
+    if "*+-•‣⁃".contains(first) && followed_by_space(first.len_utf8()) {
+        return Some(ItemStart {
+            kind: ListKind::Bullet(first),
+            number: None,
+            content: content_after(first.len_utf8()),
+        });
+    }
+    let (enumerator, delimiter, len) = if let Some(rest) = text.strip_prefix('(') {
+        let close = rest.find(')')?;
+        (&rest[..close], ListNumberDelim::TwoParens, close + 2)
+    } else {
+        let end = text.find(['.', ')'])?;
+        let delimiter = if text[end..].starts_with('.') {
+            ListNumberDelim::Period
+        } else {
+            ListNumberDelim::OneParen
+        };
+        (&text[..end], delimiter, end + 1)
+    };
+    if !followed_by_space(len) {
+        return None;
+    }
+    let (style, number) = enumerator_value(enumerator)?;
+    Some(ItemStart {
+        kind: ListKind::Enumerated(style, delimiter),
+        number,
+        content: content_after(len),
+    })
+}
+
+/// The style and value of an enumerator: `1`, `a`, `IV` or `#`
+fn enumerator_value(enumerator: &str) -> Option<(ListNumberStyle, Option<usize>)> {
+    if enumerator == "#" {
+        return Some((ListNumberStyle::Default, None));
+    }
+    if !enumerator.is_empty() && enumerator.chars().all(|c| c.is_ascii_digit()) {
+        return Some((ListNumberStyle::Decimal, enumerator.parse().ok()));
+    }
+    if let Some(value) = roman_value(enumerator) {
+        let style = if enumerator.chars().all(|c| c.is_ascii_lowercase()) {
+            ListNumberStyle::LowerRoman
+        } else {
+            ListNumberStyle::UpperRoman
+        };
+        return Some((style, Some(value)));
+    }
+    let mut chars = enumerator.chars();
+    match (chars.next(), chars.next()) {
+        (Some(c), None) if c.is_ascii_lowercase() => Some((
+            ListNumberStyle::LowerAlpha,
+            Some((c as u8 - b'a') as usize + 1),
+        )),
+        (Some(c), None) if c.is_ascii_uppercase() => Some((
+            ListNumberStyle::UpperAlpha,
+            Some((c as u8 - b'A') as usize + 1),
+        )),
+        _ => None,
+    }
+}
+
+/// The value of a Roman numeral of one case. A single letter other than
+/// `i` or `I` is taken to be alphabetic.
+fn roman_value(numeral: &str) -> Option<usize> {
+    let lower = numeral.to_lowercase();
+    if numeral.is_empty()
+        || !(numeral == lower || numeral == numeral.to_uppercase())
+        || (numeral.len() == 1 && lower != "i")
+    {
+        return None;
+    }
+    let digit = |c: char| match c {
+        'i' => Some(1),
+        'v' => Some(5),
+        'x' => Some(10),
+        'l' => Some(50),
+        'c' => Some(100),
+        'd' => Some(500),
+        'm' => Some(1000),
+        _ => None,
+    };
+    let digits = lower.chars().map(digit).collect::<Option<Vec<usize>>>()?;
+    let mut value = 0;
+    for (i, digit) in digits.iter().enumerate() {
+        if digits.get(i + 1).is_some_and(|next| next > digit) {
+            value -= *digit as isize;
+        } else {
+            value += *digit as isize;
+        }
+    }
+    usize::try_from(value).ok().filter(|value| *value > 0)
+}
+
+/// `:name: body` at the start of `text`: where the name ends and the body
+/// starts
+fn field_marker(text: &str) -> Option<(usize, usize)> {
+    let rest = text.strip_prefix(':')?;
+    if rest.starts_with([':', ' ', '\t']) || rest.is_empty() {
+        return None;
+    }
+    let mut escaped = false;
+    for (i, c) in rest.char_indices() {
+        if escaped {
+            escaped = false;
+            continue;
+        }
+        match c {
+            '\\' => escaped = true,
+            ':' => {
+                let after = &rest[i + 1..];
+                if rest[..i].ends_with(char::is_whitespace) {
+                    return None;
+                }
+                if after.is_empty() || after.starts_with([' ', '\t']) {
+                    let body = after.len() - after.trim_start().len();
+                    return Some((1 + i, 1 + i + 1 + body));
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// The index of the line after the indented lines that follow line `i`,
+/// and the blank lines between them
+fn indented_end(lines: &[Line], i: usize) -> usize {
+    let mut end = i + 1;
+    while end < lines.len() && (lines[end].is_blank() || lines[end].indent() > 0) {
+        end += 1;
+    }
+    while end > i + 1 && lines[end - 1].is_blank() {
+        end -= 1;
+    }
+    end
+}
+
+/// The identifier docutils gives a target: its name in lowercase, with
+/// runs of other characters than letters and digits as `-`
+fn label_id(name: &str) -> String {
+    let mut id = String::new();
+    for c in name.chars() {
+        if c.is_alphanumeric() {
+            id.extend(c.to_lowercase());
+        } else if !id.is_empty() && !id.ends_with('-') {
+            id.push('-');
+        }
+    }
+    id.trim_end_matches('-').to_string()
+}
+
+fn is_simple_table_border(line: &Line) -> bool {
+    let text = line.text.trim_end();
+    text.starts_with('=') && text.contains(' ') && text.chars().all(|c| c == '=' || c == ' ')
+        || adornment(line) == Some('=') && text.len() > 1
+}
+
+/// Whether `c` may come before inline markup
+fn markup_may_start(previous: Option<char>) -> bool {
+    previous.is_none_or(|p| p.is_whitespace() || MARKUP_BEFORE.contains(p))
+}
+
+fn markup_may_end(next: Option<char>) -> bool {
+    next.is_none_or(|n| n.is_whitespace() || MARKUP_AFTER.contains(n))
+}
+
+/// Where `end` closes inline markup whose content starts at `from` in
+/// `text`
+fn markup_end(text: &str, from: usize, end: &str) -> Option<usize> {
+    if text[from..].starts_with(char::is_whitespace) {
+        return None;
+    }
+    let mut search = from;
+    while let Some(found) = text[search..].find(end) {
+        let at = search + found;
+        let before = text[..at].chars().next_back();
+        let escaped = text[..at].ends_with('\\') && !end.starts_with('`');
+        if at > from
+            && before.is_some_and(|b| !b.is_whitespace())
+            && !escaped
+            && markup_may_end(text[at + end.len()..].chars().next())
+        {
+            return Some(at);
+        }
+        search = at + end.len().max(1);
+    }
+    None
+}
+
+/// Like [`markup_end`], for the backquote that closes interpreted text or a
+/// hyperlink reference, which may be followed by `_`, `__` or `:role:`
+fn interpreted_end(text: &str, from: usize) -> Option<usize> {
+    if text[from..].starts_with(char::is_whitespace) {
+        return None;
+    }
+    let mut search = from;
+    while let Some(found) = text[search..].find('`') {
+        let at = search + found;
+        let before = text[..at].chars().next_back();
+        let after = &text[at + 1..];
+        let suffix = after
+            .strip_prefix("__")
+            .or_else(|| after.strip_prefix('_'))
+            .or_else(|| role_suffix(after).map(|(_, len)| &after[len..]))
+            .unwrap_or(after);
+        if at > from
+            && before.is_some_and(|b| !b.is_whitespace())
+            && !text[..at].ends_with('\\')
+            && markup_may_end(suffix.chars().next())
+        {
+            return Some(at);
+        }
+        search = at + 1;
+    }
+    None
+}
+
+/// `:role:` at the start of `text`: the role and its length
+fn role_suffix(text: &str) -> Option<(&str, usize)> {
+    let rest = text.strip_prefix(':')?;
+    let end = rest
+        .find(|c: char| !(c.is_alphanumeric() || "-_.:+".contains(c)))
+        .unwrap_or(rest.len());
+    let name = rest[..end].strip_suffix(':')?;
+    (!name.is_empty()).then_some((name, name.len() + 2))
+}
+
+/// The text of `text` with backslash escapes removed
+fn unescape(text: &str) -> String {
+    let mut result = String::new();
+    let mut chars = text.chars();
+    while let Some(c) = chars.next() {
+        if c == '\\' {
+            match chars.next() {
+                Some(next) if !next.is_whitespace() => result.push(next),
+                _ => {}
+            }
+        } else {
+            result.push(c);
+        }
+    }
+    result
+}
+
+/// `text <target>`: the text and the target, if there is one
+fn embedded_target(text: &str) -> (&str, Option<&str>) {
+    if let Some(inner) = text.strip_suffix('>')
+        && let Some(open) = inner.rfind('<')
+        && (open == 0 || inner[..open].ends_with(char::is_whitespace))
+    {
+        return (inner[..open].trim(), Some(inner[open + 1..].trim()));
+    }
+    (text, None)
+}
+
+fn link(content: Inlines, url: String, source_info: SourceInfo) -> Inline {
+    Inline::Link(Link {
+        attr: empty_attr(),
+        content,
+        target: (url, String::new()),
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+        target_source: TargetSourceInfo::empty(),
+    })
+}
+
+impl<'a> RstReader<'a> {
+    /// Read the targets and substitution definitions of the whole
+    /// document, which may come after what refers to them
+    fn collect(&mut self, lines: &[Line<'a>]) {
+        for (i, line) in lines.iter().enumerate() {
+            let trimmed = line.trim();
+            let indent = line.indent();
+            let continuation = || {
+                lines[i + 1..]
+                    .iter()
+                    .take_while(|next| !next.is_blank() && next.indent() > indent)
+                    .map(Line::trim)
+                    .collect::<Vec<_>>()
+            };
+            if let Some(rest) = trimmed.text.strip_prefix("__ ") {
+                self.anonymous_targets.push(rest.trim().to_string());
+            } else if let Some(rest) = trimmed.text.strip_prefix(".. _") {
+                let (name, target) = if let Some(quoted) = rest.strip_prefix('`') {
+                    let Some(close) = quoted.find("`:") else {
+                        continue;
+                    };
+                    (&quoted[..close], &quoted[close + 2..])
+                } else {
+                    let Some(colon) = target_colon(rest) else {
+                        continue;
+                    };
+                    (&rest[..colon], &rest[colon + 1..])
+                };
+                let mut url = target.trim().to_string();
+                for next in continuation() {
+                    url.push_str(next.text);
+                }
+                if url.is_empty() {
+                    continue;
+                }
+                if name == "_" {
+                    self.anonymous_targets.push(url);
+                } else {
+                    self.targets.entry(normalize_name(name)).or_insert(url);
+                }
+            } else if let Some(rest) = trimmed.text.strip_prefix(".. |") {
+                let Some(close) = rest.find('|') else {
+                    continue;
+                };
+                let name = &rest[..close];
+                let definition = trimmed.from(4 + close + 1).trim();
+                let Some(kind_end) = definition.text.find("::") else {
+                    continue;
+                };
+                let kind = definition.text[..kind_end].trim();
+                let data = definition.from(kind_end + 2).trim();
+                let mut data_lines: Vec<Line<'a>> = vec![data];
+                data_lines.extend(continuation());
+                data_lines.retain(|line| !line.is_blank());
+                let substitution = match kind {
+                    "replace" => Substitution::Replace(data_lines),
+                    "image" => {
+                        let (url, options): (Vec<Line>, Vec<Line>) = data_lines
+                            .into_iter()
+                            .partition(|line| field_marker(line.text).is_none());
+                        let options: Vec<(String, String)> = options
+                            .iter()
+                            .filter_map(|line| option_line(line.text))
+                            .collect();
+                        let option = |name: &str| {
+                            options
+                                .iter()
+                                .find(|(key, _)| key == name)
+                                .map(|(_, value)| value.clone())
+                        };
+                        Substitution::Image {
+                            url: url.iter().map(|line| line.text).collect(),
+                            alt: option("alt").unwrap_or_else(|| name.to_string()),
+                            attributes: ["width", "height"]
+                                .into_iter()
+                                .filter_map(|key| option(key).map(|value| (key.to_string(), value)))
+                                .collect(),
+                        }
+                    }
+                    "unicode" => Substitution::Text(unicode_text(
+                        &data_lines
+                            .iter()
+                            .map(|line| line.text)
+                            .collect::<Vec<_>>()
+                            .join(" "),
+                    )),
+                    _ => continue,
+                };
+                self.substitutions
+                    .insert(normalize_name(name), substitution);
+            }
+        }
+    }
+
+    /// Give names that refer to other names, as `.. _alias: name_` does,
+    /// what those names refer to
+    fn resolve_indirect_targets(&mut self) {
+        for _ in 0..MAX_INDIRECTION {
+            let mut changed = false;
+            let names: Vec<String> = self.targets.keys().cloned().collect();
+            for name in names {
+                let target = &self.targets[&name];
+                if target.starts_with('#') || target.contains("://") {
+                    continue;
+                }
+                let Some(other) = target.strip_suffix('_') else {
+                    continue;
+                };
+                if let Some(resolved) = self.targets.get(&normalize_name(other)).cloned()
+                    && resolved != *target
+                {
+                    self.targets.insert(name, resolved);
+                    changed = true;
+                }
+            }
+            if !changed {
+                break;
+            }
+        }
+    }
+
+    fn set_meta(&mut self, key: &str, value: ConfigValue, key_source: SourceInfo) {
+        if self.meta.iter().any(|entry| entry.key == key) {
+            return;
+        }
+        self.meta.push(ConfigMapEntry {
+            key: key.to_string(),
+            key_source,
+            value,
+        });
+    }
+
+    /// Blocks nested in another element
+    fn body(&mut self, lines: &[Line<'a>]) -> Blocks {
+        self.depth += 1;
+        let blocks = self.blocks(lines);
+        self.depth -= 1;
+        blocks
+    }
+
+    /// Add `block`, giving it the labels of the `.. _label:` targets
+    /// before it
+    fn push(&mut self, blocks: &mut Blocks, block: Block) {
+        let labels = std::mem::take(&mut self.pending_labels);
+        let Some((first, first_source)) = labels.first().cloned() else {
+            blocks.push(block);
+            return;
+        };
+        let id = label_id(&first);
+        for (label, _) in &labels {
+            self.targets
+                .insert(normalize_name(label), format!("#{}", id));
+        }
+        match block {
+            Block::Header(mut header) => {
+                header.attr.0 = id;
+                header.attr_source.id = Some(first_source);
+                blocks.push(Block::Header(header));
+            }
+            block => {
+                let source_info = block_source(&block);
+                let mut attr_source = AttrSourceInfo::empty();
+                attr_source.id = Some(first_source);
+                blocks.push(Block::Div(Div {
+                    attr: (id, Vec::new(), hashlink::LinkedHashMap::new()),
+                    content: vec![block],
+                    source_info,
+                    attr_source,
+                }));
+            }
+        }
+    }
+
+    fn blocks(&mut self, lines: &[Line<'a>]) -> Blocks {
+        let mut blocks = Vec::new();
+        let mut i = 0;
+        while i < lines.len() {
+            let line = lines[i];
+            if line.is_blank() {
+                i += 1;
+                continue;
+            }
+
+            if line.indent() > 0 {
+                let mut quote_end = i + 1;
+                while quote_end < lines.len()
+                    && (lines[quote_end].is_blank() || lines[quote_end].indent() > 0)
+                {
+                    quote_end += 1;
+                }
+                let quoted = dedent_all(&lines[i..quote_end]);
+                let content = self.body(&quoted);
+                let block = Block::BlockQuote(BlockQuote {
+                    content,
+                    source_info: lines_source(self.file_id, trim_blank(&lines[i..quote_end])),
+                });
+                self.push(&mut blocks, block);
+                i = quote_end;
+                continue;
+            }
+
+            if let Some(next) = self.section(lines, i, &mut blocks) {
+                i = next;
+                continue;
+            }
+
+            if adornment(&line).is_some()
+                && line.text.trim_end().len() >= 4
+                && lines.get(i + 1).is_none_or(Line::is_blank)
+            {
+                let block = Block::HorizontalRule(HorizontalRule {
+                    source_info: lines_source(self.file_id, &lines[i..=i]),
+                });
+                self.push(&mut blocks, block);
+                i += 1;
+                continue;
+            }
+
+            if line.text.starts_with("+-") {
+                let end = (i..lines.len())
+                    .find(|&j| !lines[j].text.starts_with(['+', '|']))
+                    .unwrap_or(lines.len());
+                let block = match self.grid_table(&lines[i..end]) {
+                    Some(block) => block,
+                    None => self.untranslated("A grid table with cells that span", &lines[i..end]),
+                };
+                self.push(&mut blocks, block);
+                i = end;
+                continue;
+            }
+
+            if is_simple_table_border(&line)
+                && let Some((block, end)) = self.simple_table(lines, i)
+            {
+                self.push(&mut blocks, block);
+                i = end;
+                continue;
+            }
+
+            if line.text.starts_with("..")
+                && (line.text.len() == 2 || line.text[2..].starts_with(' '))
+            {
+                let end = indented_end(lines, i);
+                if let Some(block) = self.explicit_markup(&lines[i..end]) {
+                    self.push(&mut blocks, block);
+                }
+                i = end;
+                continue;
+            }
+
+            if line.text.starts_with("__ ") {
+                i = indented_end(lines, i);
+                continue;
+            }
+
+            if field_marker(line.text).is_some() {
+                i = self.field_list(lines, i, &mut blocks);
+                continue;
+            }
+
+            if let Some(start) = item_start(&line)
+                && (lines.get(i + 1).is_none_or(|next| {
+                    next.is_blank() || next.indent() > 0 || item_start(next).is_some()
+                }))
+            {
+                i = self.list(start, lines, i, &mut blocks);
+                continue;
+            }
+
+            if line.text.starts_with("| ") || line.text.trim_end() == "|" {
+                i = self.line_block(lines, i, &mut blocks);
+                continue;
+            }
+
+            if line.text.starts_with(">>>") {
+                let end = (i..lines.len())
+                    .find(|&j| lines[j].is_blank())
+                    .unwrap_or(lines.len());
+                let block = Block::CodeBlock(CodeBlock {
+                    attr: class_attr(&["doctest"]),
+                    text: verbatim(&lines[i..end]),
+                    source_info: lines_source(self.file_id, &lines[i..end]),
+                    attr_source: AttrSourceInfo::empty(),
+                });
+                self.push(&mut blocks, block);
+                i = end;
+                continue;
+            }
+
+            if lines
+                .get(i + 1)
+                .is_some_and(|next| !next.is_blank() && next.indent() > 0)
+            {
+                i = self.definition_list(lines, i, &mut blocks);
+                continue;
+            }
+
+            i = self.paragraph(lines, i, &mut blocks);
+        }
+        blocks
+    }
+
+    /// A section title at line `i`, if there is one: add it, and return the
+    /// index of the line after it
+    fn section(&mut self, lines: &[Line<'a>], i: usize, blocks: &mut Blocks) -> Option<usize> {
+        let line = &lines[i];
+        let (title, style, next) = match adornment(line) {
+            // An overline, the title, and an underline of the same
+            // character
+            Some(c)
+                if i + 2 < lines.len()
+                    && !lines[i + 1].is_blank()
+                    && adornment(&lines[i + 2]) == Some(c) =>
+            {
+                (lines[i + 1].trim(), (c, true), i + 3)
+            }
+            Some(_) => return None,
+            None => {
+                let underline = lines.get(i + 1)?;
+                let c = adornment(underline)?;
+                let width = line.text.trim_end().chars().count();
+                let length = underline.text.trim_end().chars().count();
+                if length < width && length < 4 {
+                    return None;
+                }
+                (line.trim(), (c, false), i + 2)
+            }
+        };
+        let level = match self.styles.iter().position(|known| *known == style) {
+            Some(index) => index + 1,
+            None => {
+                self.styles.push(style);
+                self.styles.len()
+            }
+        };
+        let content = self.line_inlines(&[title]);
+        let block = Block::Header(Header {
+            level,
+            attr: empty_attr(),
+            content,
+            source_info: lines_source(self.file_id, &lines[i..next]),
+            attr_source: AttrSourceInfo::empty(),
+        });
+        self.push(blocks, block);
+        Some(next)
+    }
+
+    /// `.. ` explicit markup: a directive, footnote, target, substitution
+    /// definition or comment
+    fn explicit_markup(&mut self, lines: &[Line<'a>]) -> Option<Block> {
+        let first = lines[0];
+        let rest = first.from(2.min(first.text.len())).trim();
+        let continuation = dedent_all(&lines[1..]);
+        let source_info = lines_source(self.file_id, lines);
+
+        // `.. [label] text`: a footnote or citation
+        if let Some(label_text) = rest.text.strip_prefix('[')
+            && let Some(close) = label_text.find(']')
+            && rest.text[close + 2..]
+                .chars()
+                .next()
+                .is_none_or(char::is_whitespace)
+        {
+            let id = self.note_id(&label_text[..close], false)?;
+            let mut body = vec![rest.from(close + 2).trim()];
+            body.extend(continuation);
+            let content = self.body(&body);
+            return Some(Block::NoteDefinitionFencedBlock(
+                NoteDefinitionFencedBlock {
+                    id,
+                    content,
+                    source_info,
+                },
+            ));
+        }
+
+        // `.. _label:`: the element after it gets its identifier.
+        // `.. _name: url` was read by `collect`.
+        if let Some(target) = rest.text.strip_prefix('_') {
+            let (name, url) = if let Some(quoted) = target.strip_prefix('`') {
+                let close = quoted.find("`:")?;
+                (&quoted[..close], quoted[close + 2..].trim())
+            } else {
+                let colon = target_colon(target)?;
+                (&target[..colon], target[colon + 1..].trim())
+            };
+            if url.is_empty() && continuation.is_empty() && name != "_" {
+                let name_start = rest.offset + 1;
+                self.pending_labels.push((
+                    name.to_string(),
+                    SourceInfo::original(self.file_id, name_start, name_start + name.len()),
+                ));
+            }
+            return None;
+        }
+
+        if rest.text.starts_with('|') {
+            return None;
+        }
+
+        let directive = self.directive(rest, &continuation, source_info.clone())?;
+        self.directive_block(directive, lines)
+    }
+
+    /// The directive `.. name:: arguments` starts, if it is one, with
+    /// `continuation` its indented lines
+    fn directive(
+        &self,
+        rest: Line<'a>,
+        continuation: &[Line<'a>],
+        source_info: SourceInfo,
+    ) -> Option<Directive<'a>> {
+        let marker = rest.text.find("::")?;
+        let name = &rest.text[..marker];
+        if name.is_empty()
+            || !name
+                .chars()
+                .all(|c| c.is_alphanumeric() || "-_.:+".contains(c))
+            || !rest.text[marker + 2..]
+                .chars()
+                .next()
+                .is_none_or(char::is_whitespace)
+        {
+            return None;
+        }
+        let mut arguments = Vec::new();
+        let first = rest.from(marker + 2).trim();
+        if !first.is_blank() {
+            arguments.push(first);
+        }
+        let mut k = 0;
+        while k < continuation.len()
+            && !continuation[k].is_blank()
+            && field_marker(continuation[k].text).is_none()
+        {
+            arguments.push(continuation[k].trim());
+            k += 1;
+        }
+        let mut options = Vec::new();
+        while k < continuation.len() && !continuation[k].is_blank() {
+            let Some((key, mut value)) = option_line(continuation[k].text) else {
+                break;
+            };
+            k += 1;
+            while k < continuation.len()
+                && !continuation[k].is_blank()
+                && continuation[k].indent() > 0
+            {
+                value.push(' ');
+                value.push_str(continuation[k].text.trim());
+                k += 1;
+            }
+            options.push((key, value));
+        }
+        let body = continuation[k..]
+            .iter()
+            .skip_while(|line| line.is_blank())
+            .copied()
+            .collect();
+        Some(Directive {
+            name: name.to_lowercase(),
+            arguments,
+            options,
+            body,
+            source_info,
+        })
+    }
+
+    fn directive_block(&mut self, directive: Directive<'a>, lines: &[Line<'a>]) -> Option<Block> {
+        let source_info = directive.source_info.clone();
+        let name = directive.name.as_str();
+        let block = match name {
+            "code" | "code-block" | "sourcecode" => {
+                let mut attr = directive.attr();
+                let language = Some(directive.argument())
+                    .filter(|language| !language.is_empty())
+                    .or_else(|| self.default_language.clone());
+                if let Some(language) = language {
+                    attr.1.insert(0, language);
+                }
+                if let Some(start) = directive
+                    .option("number-lines")
+                    .or(directive.option("linenos"))
+                {
+                    attr.1.push("numberLines".to_string());
+                    if start.parse::<usize>().is_ok() {
+                        attr.2.insert("startFrom".to_string(), start.to_string());
+                    }
+                }
+                if let Some(caption) = directive.option("caption") {
+                    // Sphinx shows the caption above the code, as Quarto
+                    // does the filename
+                    attr.2.insert("filename".to_string(), caption.to_string());
+                }
+                Block::CodeBlock(CodeBlock {
+                    attr,
+                    text: verbatim(&dedent_all(&directive.body)),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            "math" => {
+                let mut math = directive.arguments.clone();
+                math.extend(directive.body.iter().copied());
+                let equations: Vec<Inline> = math
+                    .split(Line::is_blank)
+                    .filter(|equation| !equation.is_empty())
+                    .map(|equation| {
+                        Inline::Math(Math {
+                            math_type: MathType::DisplayMath,
+                            text: verbatim(&dedent_all(equation)),
+                            source_info: lines_source(self.file_id, equation),
+                        })
+                    })
+                    .collect();
+                Block::Paragraph(Paragraph {
+                    content: equations,
+                    source_info,
+                })
+            }
+            "image" => {
+                let image = self.image(&directive);
+                Block::Paragraph(Paragraph {
+                    content: vec![image],
+                    source_info,
+                })
+            }
+            "figure" => {
+                let image = self.image(&directive);
+                let body = self.body(&directive.body);
+                let caption = body.into_iter().map(|block| match block {
+                    Block::Paragraph(para) => Block::Plain(Plain {
+                        content: para.content,
+                        source_info: para.source_info,
+                    }),
+                    block => block,
+                });
+                let caption: Blocks = caption.collect();
+                Block::Figure(Figure {
+                    attr: directive.attr(),
+                    caption: Caption {
+                        short: None,
+                        long: (!caption.is_empty()).then_some(caption),
+                        source_info: source_info.clone(),
+                    },
+                    content: vec![Block::Plain(Plain {
+                        content: vec![image],
+                        source_info: source_info.clone(),
+                    })],
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            "raw" => {
+                if directive.option("file").is_some() || directive.option("url").is_some() {
+                    return Some(self.untranslated("A `raw` directive with a file or URL", lines));
+                }
+                let format = directive.argument();
+                Block::RawBlock(RawBlock {
+                    format: format
+                        .split_whitespace()
+                        .next()
+                        .unwrap_or("html")
+                        .to_lowercase(),
+                    text: verbatim(&dedent_all(&directive.body)),
+                    source_info,
+                })
+            }
+            "admonition" => {
+                let mut attr = directive.attr();
+                attr.1.insert(0, "callout-note".to_string());
+                attr.2
+                    .insert("title".to_string(), unescape(&directive.argument()));
+                Block::Div(Div {
+                    attr,
+                    content: self.body(&directive.body),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            _ if callout_class(name).is_some() => {
+                let mut attr = directive.attr();
+                attr.1.insert(0, callout_class(name).unwrap().to_string());
+                // The content may start on the line of the directive
+                let mut body = directive.arguments.clone();
+                if !body.is_empty() && !directive.body.is_empty() {
+                    body.push(Line {
+                        text: "",
+                        offset: directive.body[0].offset,
+                    });
+                }
+                body.extend(directive.body.iter().copied());
+                Block::Div(Div {
+                    attr,
+                    content: self.body(&body),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            "topic" | "sidebar" => {
+                let mut attr = directive.attr();
+                attr.1.insert(0, name.to_string());
+                attr.2
+                    .insert("title".to_string(), unescape(&directive.argument()));
+                Block::Div(Div {
+                    attr,
+                    content: self.body(&directive.body),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            "container" | "compound" | "class" => {
+                if directive.body.is_empty() {
+                    return None;
+                }
+                let mut attr = directive.attr();
+                let classes = directive.argument();
+                if name == "compound" {
+                    attr.1.insert(0, name.to_string());
+                }
+                attr.1
+                    .extend(classes.split_whitespace().map(str::to_string));
+                Block::Div(Div {
+                    attr,
+                    content: self.body(&directive.body),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+            "rubric" => {
+                let content = self.line_inlines(&directive.arguments);
+                Block::Paragraph(Paragraph {
+                    content: vec![Inline::Strong(Strong {
+                        content,
+                        source_info: source_info.clone(),
+                    })],
+                    source_info,
+                })
+            }
+            "epigraph" | "highlights" | "pull-quote" => Block::BlockQuote(BlockQuote {
+                content: self.body(&directive.body),
+                source_info,
+            }),
+            "table" | "list-table" | "csv-table" => {
+                let table = match name {
+                    "table" => self.body(&directive.body).into_iter().next(),
+                    "list-table" => self.list_table(&directive),
+                    _ => self.csv_table(&directive),
+                };
+                let Some(Block::Table(mut table)) = table else {
+                    return Some(self.untranslated(&format!("A `{}` directive", name), lines));
+                };
+                if !directive.arguments.is_empty() {
+                    let caption = self.line_inlines(&directive.arguments);
+                    table.caption.long = Some(vec![Block::Plain(Plain {
+                        content: caption,
+                        source_info: source_info.clone(),
+                    })]);
+                }
+                table.attr = directive.attr();
+                table.source_info = source_info;
+                Block::Table(table)
+            }
+            "contents" => {
+                self.set_meta(
+                    "toc",
+                    ConfigValue::new_bool(true, source_info.clone()),
+                    source_info,
+                );
+                return None;
+            }
+            "sectnum" | "section-numbering" => {
+                self.set_meta(
+                    "number-sections",
+                    ConfigValue::new_bool(true, source_info.clone()),
+                    source_info,
+                );
+                return None;
+            }
+            "title" => {
+                let title = self.line_inlines(&directive.arguments);
+                self.set_meta(
+                    "title",
+                    ConfigValue::new_inlines(title, source_info.clone()),
+                    source_info,
+                );
+                return None;
+            }
+            "highlight" => {
+                let language = directive.argument();
+                self.default_language = (!language.is_empty()).then_some(language);
+                return None;
+            }
+            "default-role" | "role" | "meta" => return None,
+            _ => {
+                return Some(self.untranslated(&format!("The `{}` directive", name), lines));
+            }
+        };
+        Some(block)
+    }
+
+    fn image(&self, directive: &Directive<'a>) -> Inline {
+        let url: String = directive
+            .arguments
+            .iter()
+            .map(|line| line.text.trim())
+            .collect();
+        let mut attr = directive.attr();
+        if let Some(align) = directive.option("align") {
+            attr.1.push(format!("align-{}", align));
+        }
+        for key in ["width", "height"] {
+            if let Some(value) = directive.option(key) {
+                attr.2.insert(key.to_string(), value.to_string());
+            }
+        }
+        let source_info = directive.source_info.clone();
+        let image = Inline::Image(Image {
+            attr,
+            content: directive
+                .option("alt")
+                .map_or_else(Vec::new, |alt| words(alt, &source_info)),
+            target: (url, String::new()),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+            target_source: TargetSourceInfo::empty(),
+        });
+        match directive.option("target") {
+            Some(target) if target.ends_with('_') => {
+                reference_link(vec![image], target.trim_end_matches('_'), source_info)
+            }
+            Some(target) => link(vec![image], target.to_string(), source_info),
+            None => image,
+        }
+    }
+
+    /// A footnote or citation label's note id. Automatic numbers are
+    /// matched up in order.
+    fn note_id(&mut self, label: &str, reference: bool) -> Option<String> {
+        let counter = |count: &mut usize| {
+            *count += 1;
+            *count
+        };
+        Some(match label {
+            "#" if reference => format!("auto-{}", counter(&mut self.auto_references)),
+            "#" => format!("auto-{}", counter(&mut self.auto_definitions)),
+            "*" if reference => format!("symbol-{}", counter(&mut self.symbol_references)),
+            "*" => format!("symbol-{}", counter(&mut self.symbol_definitions)),
+            _ => {
+                let label = label.strip_prefix('#').unwrap_or(label);
+                if label.is_empty()
+                    || !label
+                        .chars()
+                        .all(|c| c.is_alphanumeric() || "-_.".contains(c))
+                {
+                    return None;
+                }
+                label.to_string()
+            }
+        })
+    }
+
+    /// A field list: metadata at the start of a document, and a definition
+    /// list elsewhere
+    fn field_list(&mut self, lines: &[Line<'a>], i: usize, blocks: &mut Blocks) -> usize {
+        let docinfo = self.depth == 0
+            && blocks.len() <= 2
+            && blocks.iter().all(|block| matches!(block, Block::Header(_)));
+        let start = i;
+        let mut i = i;
+        let mut items = Vec::new();
+        while i < lines.len() {
+            let line = lines[i];
+            let Some((name_end, body_start)) = field_marker(line.text) else {
+                break;
+            };
+            let end = indented_end(lines, i);
+            let name = line.from(1);
+            let name = Line {
+                text: &name.text[..name_end - 1],
+                offset: name.offset,
+            };
+            let mut body = Vec::new();
+            let first = line.from(body_start.min(line.text.len())).trim();
+            if !first.is_blank() {
+                body.push(first);
+            }
+            body.extend(dedent_all(&lines[i + 1..end]));
+
+            if docinfo {
+                self.docinfo_field(name, &body);
+            } else {
+                let term = self.line_inlines(&[name]);
+                let content = tighten(vec![self.body(&body)]).remove(0);
+                items.push((term, vec![content]));
+            }
+            i = end;
+            while i < lines.len() && lines[i].is_blank() {
+                i += 1;
+            }
+        }
+        if !docinfo && !items.is_empty() {
+            let block = Block::DefinitionList(DefinitionList {
+                content: items,
+                source_info: lines_source(self.file_id, trim_blank(&lines[start..i])),
+            });
+            self.push(blocks, block);
+        }
+        i
+    }
+
+    fn docinfo_field(&mut self, name: Line<'a>, body: &[Line<'a>]) {
+        let key = unescape(name.text).to_lowercase();
+        let key_source = SourceInfo::original(self.file_id, name.offset, name.end());
+        let value_source = lines_source(self.file_id, body);
+        match key.as_str() {
+            "authors" => {
+                let text: Vec<&str> = body.iter().map(|line| line.text.trim()).collect();
+                let text = text.join(" ");
+                let separator = if text.contains(';') { ';' } else { ',' };
+                let authors = text
+                    .split(separator)
+                    .map(str::trim)
+                    .filter(|author| !author.is_empty())
+                    .map(|author| ConfigValue::new_string(unescape(author), value_source.clone()))
+                    .collect();
+                self.set_meta(
+                    "author",
+                    ConfigValue::new_array(authors, value_source),
+                    key_source,
+                );
+            }
+            _ => {
+                // Paragraphs, as of an `:abstract:`, run together
+                let text: Vec<Line<'a>> = body
+                    .iter()
+                    .filter(|line| !line.is_blank())
+                    .copied()
+                    .collect();
+                let value = ConfigValue::new_inlines(self.line_inlines(&text), value_source);
+                self.set_meta(&key, value, key_source);
+            }
+        }
+    }
+
+    /// Read a bullet or enumerated list starting at line `i`, and return
+    /// the index of the line after it
+    fn list(
+        &mut self,
+        first: ItemStart,
+        lines: &[Line<'a>],
+        i: usize,
+        blocks: &mut Blocks,
+    ) -> usize {
+        let start_line = i;
+        let mut items = Vec::new();
+        let mut loose = false;
+        let mut i = i;
+        while i < lines.len() {
+            let Some(start) = item_start(&lines[i]) else {
+                break;
+            };
+            if start.kind != first.kind {
+                break;
+            }
+            let end = indented_end(lines, i);
+            let mut item_lines = Vec::new();
+            let first_line = lines[i].from(start.content.min(lines[i].text.len()));
+            if !first_line.is_blank() {
+                item_lines.push(first_line);
+            }
+            item_lines.extend(
+                lines[i + 1..end]
+                    .iter()
+                    .map(|line| line.dedent(start.content)),
+            );
+            loose |= item_lines.iter().any(Line::is_blank);
+            items.push(self.body(&item_lines));
+
+            i = end;
+            let blank = i < lines.len() && lines[i].is_blank();
+            while i < lines.len() && lines[i].is_blank() {
+                i += 1;
+            }
+            if blank
+                && i < lines.len()
+                && item_start(&lines[i]).is_some_and(|next| next.kind == first.kind)
+            {
+                loose = true;
+            }
+        }
+        let items = if loose { items } else { tighten(items) };
+        let source_info = lines_source(self.file_id, trim_blank(&lines[start_line..i]));
+        let block = match first.kind {
+            ListKind::Bullet(_) => Block::BulletList(BulletList {
+                content: items,
+                source_info,
+            }),
+            ListKind::Enumerated(style, delimiter) => Block::OrderedList(OrderedList {
+                attr: (first.number.unwrap_or(1), style, delimiter),
+                content: items,
+                source_info,
+            }),
+        };
+        self.push(blocks, block);
+        i
+    }
+
+    fn line_block(&mut self, lines: &[Line<'a>], i: usize, blocks: &mut Blocks) -> usize {
+        let start = i;
+        let mut i = i;
+        let mut content: Vec<Vec<Line<'a>>> = Vec::new();
+        while i < lines.len() && !lines[i].is_blank() {
+            let line = lines[i];
+            if let Some(text) = line.text.strip_prefix('|')
+                && (text.is_empty() || text.starts_with(' '))
+            {
+                content.push(vec![line.from(1.min(line.text.len())).trim()]);
+            } else if line.indent() > 0
+                && let Some(last) = content.last_mut()
+            {
+                last.push(line.trim());
+            } else {
+                break;
+            }
+            i += 1;
+        }
+        let content = content
+            .iter()
+            .map(|lines| self.line_inlines(lines))
+            .collect();
+        let block = Block::LineBlock(LineBlock {
+            content,
+            source_info: lines_source(self.file_id, &lines[start..i]),
+        });
+        self.push(blocks, block);
+        i
+    }
+
+    fn definition_list(&mut self, lines: &[Line<'a>], i: usize, blocks: &mut Blocks) -> usize {
+        let start = i;
+        let mut i = i;
+        let mut items = Vec::new();
+        let mut loose = false;
+        while i + 1 < lines.len()
+            && !lines[i].is_blank()
+            && lines[i].indent() == 0
+            && !lines[i + 1].is_blank()
+            && lines[i + 1].indent() > 0
+        {
+            // `term : classifier` keeps only the term
+            let term_line = lines[i];
+            let term_end = term_line.text.find(" : ").unwrap_or(term_line.text.len());
+            let term = self.line_inlines(&[Line {
+                text: &term_line.text[..term_end],
+                offset: term_line.offset,
+            }]);
+            let end = indented_end(lines, i);
+            let definition = dedent_all(&lines[i + 1..end]);
+            loose |= definition.iter().any(Line::is_blank);
+            items.push((term, self.body(&definition)));
+            i = end;
+            while i < lines.len() && lines[i].is_blank() {
+                i += 1;
+            }
+        }
+        let (terms, definitions): (Vec<Inlines>, Vec<Blocks>) = items.into_iter().unzip();
+        let definitions = if loose {
+            definitions
+        } else {
+            tighten(definitions)
+        };
+        let block = Block::DefinitionList(DefinitionList {
+            content: terms
+                .into_iter()
+                .zip(definitions)
+                .map(|(term, definition)| (term, vec![definition]))
+                .collect(),
+            source_info: lines_source(self.file_id, trim_blank(&lines[start..i])),
+        });
+        self.push(blocks, block);
+        i
+    }
+
+    /// A paragraph, and the literal block after it when it ends with `::`
+    fn paragraph(&mut self, lines: &[Line<'a>], i: usize, blocks: &mut Blocks) -> usize {
+        let mut end = i + 1;
+        while end < lines.len() && !lines[end].is_blank() {
+            end += 1;
+        }
+        let paragraph: Vec<Line<'a>> = lines[i..end].iter().map(Line::trim).collect();
+        let source_info = lines_source(self.file_id, &lines[i..end]);
+        let last = paragraph.last().map_or("", |line| line.text);
+        let literal = last.ends_with("::") && !last.ends_with("\\::");
+
+        let fragment = Fragment::new(self.file_id, &paragraph);
+        let text_end = if !literal {
+            fragment.text.len()
+        } else if fragment.text == "::" {
+            0
+        } else if fragment.text.ends_with(" ::") {
+            fragment.text.len() - 3
+        } else {
+            // `text::` keeps one colon
+            fragment.text.len() - 1
+        };
+        if text_end > 0 {
+            let content = trim_inlines(self.inlines(&fragment, 0, text_end));
+            self.push(
+                blocks,
+                Block::Paragraph(Paragraph {
+                    content,
+                    source_info,
+                }),
+            );
+        }
+        if !literal {
+            return end;
+        }
+
+        let mut start = end;
+        while start < lines.len() && lines[start].is_blank() {
+            start += 1;
+        }
+        if start == lines.len() || lines[start].indent() == 0 {
+            return end;
+        }
+        let literal_end = indented_end(lines, start);
+        let attr = match &self.default_language {
+            Some(language) => class_attr(&[language.as_str()]),
+            None => empty_attr(),
+        };
+        let block = Block::CodeBlock(CodeBlock {
+            attr,
+            text: verbatim(&dedent_all(&lines[start..literal_end])),
+            source_info: lines_source(self.file_id, &lines[start..literal_end]),
+            attr_source: AttrSourceInfo::empty(),
+        });
+        self.push(blocks, block);
+        literal_end
+    }
+
+    /// A grid table, unless it has cells that span rows or columns
+    fn grid_table(&mut self, lines: &[Line<'a>]) -> Option<Block> {
+        let border = lines[0].text.trim_end();
+        let columns: Vec<usize> = border.match_indices('+').map(|(i, _)| i).collect();
+        if columns.len() < 2 || !border.ends_with('+') {
+            return None;
+        }
+        let is_separator = |text: &str, fill: char| {
+            let text = text.trim_end();
+            text.len() == border.len()
+                && columns
+                    .iter()
+                    .all(|&column| text.as_bytes()[column] == b'+')
+                && columns.windows(2).all(|pair| {
+                    text.get(pair[0] + 1..pair[1])
+                        .is_some_and(|segment| segment.chars().all(|c| c == fill))
+                })
+        };
+
+        let mut head = Vec::new();
+        let mut rows: Vec<Vec<Vec<Line<'a>>>> = Vec::new();
+        let mut current: Vec<Vec<Line<'a>>> = vec![Vec::new(); columns.len() - 1];
+        for line in &lines[1..] {
+            let text = line.text.trim_end();
+            if text.starts_with('+') {
+                let header = is_separator(text, '=');
+                if !header && !is_separator(text, '-') {
+                    return None;
+                }
+                rows.push(std::mem::replace(
+                    &mut current,
+                    vec![Vec::new(); columns.len() - 1],
+                ));
+                if header {
+                    head = std::mem::take(&mut rows);
+                }
+                continue;
+            }
+            if text.len() != border.len()
+                || !columns
+                    .iter()
+                    .all(|&column| text.as_bytes()[column] == b'|')
+            {
+                return None;
+            }
+            for (cell, pair) in current.iter_mut().zip(columns.windows(2)) {
+                let text = line.text.get(pair[0] + 1..pair[1])?;
+                cell.push(Line {
+                    text,
+                    offset: line.offset + pair[0] + 1,
+                });
+            }
+        }
+
+        let mut cells = |rows: Vec<Vec<Vec<Line<'a>>>>| -> Vec<Vec<Blocks>> {
+            rows.into_iter()
+                .map(|row| {
+                    row.into_iter()
+                        .map(|cell| tighten(vec![self.body(&dedent_all(&cell))]).remove(0))
+                        .collect()
+                })
+                .collect()
+        };
+        let rows = TableRows {
+            head: cells(head),
+            body: cells(rows),
+            alignments: Vec::new(),
+            widths: columns
+                .windows(2)
+                .map(|pair| (pair[1] - pair[0] - 1) as f64)
+                .collect(),
+        };
+        Some(table(
+            empty_attr(),
+            None,
+            rows,
+            lines_source(self.file_id, lines),
+        ))
+    }
+
+    /// A simple table starting at line `i`, and the index of the line after
+    /// it
+    fn simple_table(&mut self, lines: &[Line<'a>], i: usize) -> Option<(Block, usize)> {
+        let border = lines[i].text.trim_end();
+        let mut columns: Vec<(usize, usize)> = Vec::new();
+        for (index, c) in border.char_indices() {
+            match (c, columns.last_mut()) {
+                ('=', Some((_, end))) if *end == index => *end = index + 1,
+                ('=', _) => columns.push((index, index + 1)),
+                _ => {}
+            }
+        }
+        // The borders, to the one with a blank line or the end after it
+        let mut borders = vec![i];
+        let mut end = None;
+        for j in i + 1..lines.len() {
+            if is_simple_table_border(&lines[j]) {
+                borders.push(j);
+                if lines.get(j + 1).is_none_or(Line::is_blank) && borders.len() >= 2 {
+                    end = Some(j + 1);
+                    break;
+                }
+            }
+        }
+        let end = end?;
+
+        let row_lines = |from: usize, to: usize| -> Vec<Vec<Vec<Line<'a>>>> {
+            let mut rows: Vec<Vec<Vec<Line<'a>>>> = Vec::new();
+            for line in &lines[from..to] {
+                let text = line.text.trim_end();
+                if line.is_blank() || text.chars().all(|c| c == '-' || c == ' ') {
+                    continue;
+                }
+                let cells: Vec<Line<'a>> = columns
+                    .iter()
+                    .enumerate()
+                    .map(|(k, (start, _))| {
+                        let cell_end = columns.get(k + 1).map_or(text.len(), |next| next.0);
+                        let start = (*start).min(text.len());
+                        let cell_end = cell_end.min(text.len()).max(start);
+                        Line {
+                            text: text.get(start..cell_end).unwrap_or(""),
+                            offset: line.offset + start,
+                        }
+                    })
+                    .collect();
+                // A row whose first column is blank continues the one
+                // before
+                if cells[0].is_blank()
+                    && let Some(previous) = rows.last_mut()
+                {
+                    for (cell, line) in previous.iter_mut().zip(cells) {
+                        if !line.is_blank() {
+                            cell.push(line);
+                        }
+                    }
+                    continue;
+                }
+                rows.push(cells.into_iter().map(|cell| vec![cell]).collect());
+            }
+            rows
+        };
+        let (head, body) = if borders.len() >= 3 {
+            (
+                row_lines(borders[0] + 1, borders[1]),
+                row_lines(borders[1] + 1, borders[borders.len() - 1]),
+            )
+        } else {
+            (Vec::new(), row_lines(borders[0] + 1, borders[1]))
+        };
+        let mut cells = |rows: Vec<Vec<Vec<Line<'a>>>>| -> Vec<Vec<Blocks>> {
+            rows.into_iter()
+                .map(|row| {
+                    row.into_iter()
+                        .map(|cell| {
+                            let content = self.line_inlines(&cell);
+                            if content.is_empty() {
+                                Vec::new()
+                            } else {
+                                vec![Block::Plain(Plain {
+                                    content,
+                                    source_info: lines_source(self.file_id, &cell),
+                                })]
+                            }
+                        })
+                        .collect()
+                })
+                .collect()
+        };
+        let rows = TableRows {
+            head: cells(head),
+            body: cells(body),
+            alignments: Vec::new(),
+            widths: Vec::new(),
+        };
+        let block = table(
+            empty_attr(),
+            None,
+            rows,
+            lines_source(self.file_id, &lines[i..end]),
+        );
+        Some((block, end))
+    }
+
+    /// `list-table`: a bullet list of rows, each a bullet list of cells
+    fn list_table(&mut self, directive: &Directive<'a>) -> Option<Block> {
+        let body = self.body(&directive.body);
+        let Some(Block::BulletList(list)) = body.into_iter().next() else {
+            return None;
+        };
+        let mut rows = Vec::new();
+        for item in list.content {
+            let Some(Block::BulletList(cells)) = item.into_iter().next() else {
+                return None;
+            };
+            rows.push(tighten(cells.content));
+        }
+        Some(self.directive_table(directive, rows))
+    }
+
+    /// `csv-table`: comma-separated values, and the `:header:` option
+    fn csv_table(&mut self, directive: &Directive<'a>) -> Option<Block> {
+        if directive.option("file").is_some() || directive.option("url").is_some() {
+            return None;
+        }
+        let delimiter = match directive.option("delim") {
+            Some("tab") => '\t',
+            Some("space") => ' ',
+            Some(delimiter) => delimiter.chars().next().unwrap_or(','),
+            None => ',',
+        };
+        let mut rows = Vec::new();
+        let mut records: Vec<(String, usize)> = Vec::new();
+        if let Some(header) = directive.option("header") {
+            records.push((
+                header.to_string(),
+                directive.body.first().map_or(0, |line| line.offset),
+            ));
+        }
+        let header_rows = records.len();
+        records.extend(
+            directive
+                .body
+                .iter()
+                .filter(|line| !line.is_blank())
+                .map(|line| (line.text.to_string(), line.offset)),
+        );
+        for (record, offset) in &records {
+            let row: Vec<Blocks> = csv_fields(record, delimiter)
+                .into_iter()
+                .map(|field| {
+                    let fragment = Fragment::new(
+                        self.file_id,
+                        &[Line {
+                            text: &field,
+                            offset: *offset,
+                        }],
+                    );
+                    let content = trim_inlines(self.inlines(&fragment, 0, fragment.text.len()));
+                    if content.is_empty() {
+                        Vec::new()
+                    } else {
+                        vec![Block::Plain(Plain {
+                            content,
+                            source_info: SourceInfo::original(
+                                self.file_id,
+                                *offset,
+                                *offset + record.len(),
+                            ),
+                        })]
+                    }
+                })
+                .collect();
+            rows.push(row);
+        }
+        let mut table = self.directive_table(directive, rows);
+        if header_rows > 0
+            && let Block::Table(table) = &mut table
+            && table.head.rows.is_empty()
+            && let Some(body) = table.bodies.first_mut()
+            && !body.body.is_empty()
+        {
+            table.head.rows.push(body.body.remove(0));
+        }
+        Some(table)
+    }
+
+    /// A table of `rows`, with the `:header-rows:` and `:widths:` of
+    /// `directive`
+    fn directive_table(&mut self, directive: &Directive<'a>, mut rows: Vec<Vec<Blocks>>) -> Block {
+        let header_rows = directive
+            .option("header-rows")
+            .and_then(|rows| rows.parse().ok())
+            .unwrap_or(0usize)
+            .min(rows.len());
+        let body = rows.split_off(header_rows);
+        let widths = directive
+            .option("widths")
+            .map(|widths| {
+                widths
+                    .split([',', ' '])
+                    .filter_map(|width| width.parse::<f64>().ok())
+                    .collect()
+            })
+            .unwrap_or_default();
+        table(
+            empty_attr(),
+            None,
+            TableRows {
+                head: rows,
+                body,
+                alignments: Vec::new(),
+                widths,
+            },
+            directive.source_info.clone(),
+        )
+    }
+
+    /// A document that starts with a section title no other section shares
+    /// a level with has it as its title, and the same for a subtitle after
+    /// it. The levels of the other sections move up.
+    fn promote_title(&mut self, mut blocks: Blocks) -> Blocks {
+        let mut promoted = 0;
+        for key in ["title", "subtitle"] {
+            let Some(Block::Header(first)) = blocks.first() else {
+                break;
+            };
+            let level = first.level;
+            let same_level = blocks
+                .iter()
+                .filter(|block| matches!(block, Block::Header(header) if header.level <= level))
+                .count();
+            if same_level != 1 || level != promoted + 1 {
+                break;
+            }
+            let Block::Header(header) = blocks.remove(0) else {
+                unreachable!();
+            };
+            self.set_meta(
+                key,
+                ConfigValue::new_inlines(header.content, header.source_info.clone()),
+                header.source_info,
+            );
+            promoted += 1;
+        }
+        if promoted == 0 {
+            return blocks;
+        }
+        topdown_traverse_blocks(
+            blocks,
+            &mut Filter::new().with_header(|mut header, _ctx| {
+                header.level = header.level.saturating_sub(promoted).max(1);
+                FilterReturn::FilterResult(vec![Block::Header(header)], true)
+            }),
+            &mut FilterContext::new(),
+        )
+    }
+
+    fn untranslated(&mut self, what: &str, lines: &[Line<'a>]) -> Block {
+        let source_info = lines_source(self.file_id, lines);
+        self.warnings.push(untranslated(
+            "rst",
+            format!("{} isn't translated", what),
+            source_info.clone(),
+        ));
+        Block::RawBlock(RawBlock {
+            format: "rst".to_string(),
+            text: verbatim(lines),
+            source_info,
+        })
+    }
+
+    fn line_inlines(&mut self, lines: &[Line]) -> Inlines {
+        let lines: Vec<Line> = lines.iter().map(Line::trim).collect();
+        let fragment = Fragment::new(self.file_id, &lines);
+        trim_inlines(self.inlines(&fragment, 0, fragment.text.len()))
+    }
+
+    fn inlines(&mut self, fragment: &Fragment, start: usize, end: usize) -> Inlines {
+        let text = &fragment.text;
+        let mut inlines = Vec::new();
+        let mut plain = start;
+        let mut pos = start;
+        while pos < end {
+            let c = text[pos..].chars().next().unwrap();
+            if c == '\\' {
+                // An escaped character is text, and escaped whitespace is
+                // nothing
+                push_text(&mut inlines, fragment, plain, &text[plain..pos]);
+                pos += 1;
+                if let Some(next) = text[pos..end].chars().next() {
+                    if !next.is_whitespace() {
+                        push_text(
+                            &mut inlines,
+                            fragment,
+                            pos,
+                            &text[pos..pos + next.len_utf8()],
+                        );
+                    }
+                    pos += next.len_utf8();
+                }
+                plain = pos;
+                continue;
+            }
+            let previous = text[start..pos].chars().next_back();
+            if markup_may_start(previous)
+                && let Some((parsed, next)) = self.inline(fragment, pos, end, c)
+            {
+                push_text(&mut inlines, fragment, plain, &text[plain..pos]);
+                inlines.extend(parsed);
+                pos = next;
+                plain = next;
+            } else {
+                pos += c.len_utf8();
+            }
+        }
+        push_text(&mut inlines, fragment, plain, &text[plain..end]);
+        inlines
+    }
+
+    /// Text without markup, but with backslash escapes
+    fn text(&self, fragment: &Fragment, start: usize, end: usize) -> Inlines {
+        let text = &fragment.text;
+        let mut inlines = Vec::new();
+        let mut plain = start;
+        let mut pos = start;
+        while let Some(found) = text[pos..end].find('\\') {
+            let at = pos + found;
+            push_text(&mut inlines, fragment, plain, &text[plain..at]);
+            pos = at + 1;
+            if let Some(next) = text[pos..end].chars().next() {
+                if !next.is_whitespace() {
+                    push_text(
+                        &mut inlines,
+                        fragment,
+                        pos,
+                        &text[pos..pos + next.len_utf8()],
+                    );
+                }
+                pos += next.len_utf8();
+            }
+            plain = pos;
+        }
+        push_text(&mut inlines, fragment, plain, &text[plain..end]);
+        inlines
+    }
+
+    /// The inline markup that starts with `c` at `pos`, if it does, and
+    /// where it ends
+    fn inline(
+        &mut self,
+        fragment: &Fragment,
+        pos: usize,
+        end: usize,
+        c: char,
+    ) -> Option<(Inlines, usize)> {
+        let text = &fragment.text[..end];
+        let rest = &text[pos..];
+        match c {
+            '*' if rest.starts_with("**") => {
+                let close = markup_end(text, pos + 2, "**")?;
+                let content = self.text(fragment, pos + 2, close);
+                Some((
+                    vec![Inline::Strong(Strong {
+                        content,
+                        source_info: fragment.source(pos, close + 2),
+                    })],
+                    close + 2,
+                ))
+            }
+            '*' => {
+                let close = markup_end(text, pos + 1, "*")?;
+                let content = self.text(fragment, pos + 1, close);
+                Some((
+                    vec![Inline::Emph(Emph {
+                        content,
+                        source_info: fragment.source(pos, close + 1),
+                    })],
+                    close + 1,
+                ))
+            }
+            '`' if rest.starts_with("``") => {
+                let close = markup_end(text, pos + 2, "``")?;
+                Some((
+                    vec![Inline::Code(Code {
+                        attr: empty_attr(),
+                        text: text[pos + 2..close].replace('\n', " "),
+                        source_info: fragment.source(pos, close + 2),
+                        attr_source: AttrSourceInfo::empty(),
+                    })],
+                    close + 2,
+                ))
+            }
+            '`' => {
+                let close = interpreted_end(text, pos + 1)?;
+                let after = &text[close + 1..];
+                if after.starts_with("__") || after.starts_with('_') {
+                    let anonymous = after.starts_with("__");
+                    let next = close + 1 + if anonymous { 2 } else { 1 };
+                    let source_info = fragment.source(pos, next);
+                    let inline = self.hyperlink(fragment, pos + 1, close, anonymous, source_info);
+                    return Some((inline, next));
+                }
+                if let Some((role, len)) = role_suffix(after) {
+                    let next = close + 1 + len;
+                    let source_info = fragment.source(pos, next);
+                    return Some((self.role(role, fragment, pos + 1, close, source_info), next));
+                }
+                let source_info = fragment.source(pos, close + 1);
+                Some((
+                    self.role("title-reference", fragment, pos + 1, close, source_info),
+                    close + 1,
+                ))
+            }
+            ':' => {
+                let (role, len) = role_suffix(rest)?;
+                if !rest[len..].starts_with('`') {
+                    return None;
+                }
+                let close = interpreted_end(text, pos + len + 1)?;
+                let source_info = fragment.source(pos, close + 1);
+                Some((
+                    self.role(role, fragment, pos + len + 1, close, source_info),
+                    close + 1,
+                ))
+            }
+            '|' => {
+                let close = markup_end(text, pos + 1, "|").or_else(|| {
+                    let close = pos + 1 + rest[1..].find('|')?;
+                    text[close + 1..].starts_with('_').then_some(close)
+                })?;
+                let name = &text[pos + 1..close];
+                let after = &text[close + 1..];
+                let suffix = if after.starts_with("__") {
+                    2
+                } else if after.starts_with('_') {
+                    1
+                } else {
+                    0
+                };
+                let next = close + 1 + suffix;
+                let source_info = fragment.source(pos, next);
+                let content = self.substitution(name, source_info.clone())?;
+                let inline = match suffix {
+                    0 => return Some((content, next)),
+                    1 => reference_link(content, name, source_info),
+                    _ => self.anonymous_link(content, source_info),
+                };
+                Some((vec![inline], next))
+            }
+            '[' => {
+                let close = pos + rest.find("]_")?;
+                if !markup_may_end(text[close + 2..].chars().next()) {
+                    return None;
+                }
+                let id = self.note_id(&text[pos + 1..close], true)?;
+                Some((
+                    vec![note_reference(&id, fragment.source(pos, close + 2))],
+                    close + 2,
+                ))
+            }
+            '_' if rest.starts_with("_`") => {
+                let close = markup_end(text, pos + 2, "`")?;
+                let name = unescape(&text[pos + 2..close]);
+                let id = label_id(&name);
+                self.targets
+                    .entry(normalize_name(&name))
+                    .or_insert_with(|| format!("#{}", id));
+                let content = self.text(fragment, pos + 2, close);
+                Some((
+                    vec![Inline::Span(Span {
+                        attr: (id, Vec::new(), hashlink::LinkedHashMap::new()),
+                        content,
+                        source_info: fragment.source(pos, close + 1),
+                        attr_source: AttrSourceInfo::empty(),
+                    })],
+                    close + 1,
+                ))
+            }
+            'h' | 'f' | 'm'
+                if ["https://", "http://", "ftp://", "mailto:"]
+                    .iter()
+                    .any(|scheme| rest.starts_with(*scheme)) =>
+            {
+                let len = rest
+                    .find(|c: char| c.is_whitespace() || "<>".contains(c))
+                    .unwrap_or(rest.len());
+                let url =
+                    rest[..len].trim_end_matches(['.', ',', ';', ':', '!', '?', ')', '\'', '"']);
+                let source_info = fragment.source(pos, pos + url.len());
+                Some((
+                    vec![Inline::Link(Link {
+                        attr: class_attr(&["uri"]),
+                        content: vec![Inline::Str(Str {
+                            text: url.to_string(),
+                            source_info: source_info.clone(),
+                        })],
+                        target: (url.to_string(), String::new()),
+                        source_info,
+                        attr_source: AttrSourceInfo::empty(),
+                        target_source: TargetSourceInfo::empty(),
+                    })],
+                    pos + url.len(),
+                ))
+            }
+            c if c.is_alphanumeric() => {
+                // A simple reference: `name_` or `name__`
+                let len = rest
+                    .find(|c: char| !(c.is_alphanumeric() || "-_.:+".contains(c)))
+                    .unwrap_or(rest.len());
+                let word = rest[..len].trim_end_matches(['.', ':', '+', '-']);
+                let anonymous = word.ends_with("__");
+                let name = word.strip_suffix("__").or_else(|| word.strip_suffix('_'))?;
+                if name.is_empty()
+                    || name.ends_with('_')
+                    || !markup_may_end(rest[word.len()..].chars().next())
+                {
+                    return None;
+                }
+                let next = pos + word.len();
+                let source_info = fragment.source(pos, next);
+                let content = self.text(fragment, pos, pos + name.len());
+                let inline = if anonymous {
+                    self.anonymous_link(content, source_info)
+                } else {
+                    reference_link(content, name, source_info)
+                };
+                Some((vec![inline], next))
+            }
+            _ => None,
+        }
+    }
+
+    /// `` `text <url>`_ ``, `` `text <name_>`_ `` or `` `name`_ ``, with the
+    /// text from `start` to `end`
+    fn hyperlink(
+        &mut self,
+        fragment: &Fragment,
+        start: usize,
+        end: usize,
+        anonymous: bool,
+        source_info: SourceInfo,
+    ) -> Inlines {
+        let raw = &fragment.text[start..end];
+        let (title, target) = embedded_target(raw);
+        let title_start = start + (title.as_ptr() as usize - raw.as_ptr() as usize);
+        let content = self.text(fragment, title_start, title_start + title.len());
+        let Some(target) = target else {
+            return if anonymous {
+                vec![self.anonymous_link(content, source_info)]
+            } else {
+                vec![reference_link(content, &unescape(raw), source_info)]
+            };
+        };
+        let target: String = target.split_whitespace().collect();
+        let content = if title.is_empty() {
+            vec![Inline::Str(Str {
+                text: target.clone(),
+                source_info: source_info.clone(),
+            })]
+        } else {
+            content
+        };
+        if let Some(name) = target.strip_suffix('_')
+            && !name.ends_with('\\')
+        {
+            return vec![reference_link(content, name, source_info)];
+        }
+        if !anonymous && !title.is_empty() {
+            self.targets
+                .entry(normalize_name(&unescape(title)))
+                .or_insert_with(|| target.clone());
+        }
+        vec![link(content, target, source_info)]
+    }
+
+    /// A link to the next `.. __: url` target
+    fn anonymous_link(&mut self, content: Inlines, source_info: SourceInfo) -> Inline {
+        match self.anonymous_targets.get(self.anonymous_used).cloned() {
+            Some(url) => {
+                self.anonymous_used += 1;
+                link(content, url, source_info)
+            }
+            None => {
+                self.warnings
+                    .push(unknown_reference("__", source_info.clone()));
+                Inline::Span(Span {
+                    attr: empty_attr(),
+                    content,
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+        }
+    }
+
+    /// What `|name|` is replaced with
+    fn substitution(&mut self, name: &str, source_info: SourceInfo) -> Option<Inlines> {
+        let substitution = self.substitutions.get(&normalize_name(name))?.clone();
+        Some(match substitution {
+            Substitution::Replace(lines) => {
+                if self.substitution_depth >= MAX_INDIRECTION {
+                    return None;
+                }
+                self.substitution_depth += 1;
+                let inlines = self.line_inlines(&lines);
+                self.substitution_depth -= 1;
+                inlines
+            }
+            Substitution::Image {
+                url,
+                alt,
+                attributes,
+            } => {
+                let mut attr = empty_attr();
+                for (key, value) in attributes {
+                    attr.2.insert(key, value);
+                }
+                vec![Inline::Image(Image {
+                    attr,
+                    content: words(&alt, &source_info),
+                    target: (url, String::new()),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                    target_source: TargetSourceInfo::empty(),
+                })]
+            }
+            Substitution::Text(text) => vec![Inline::Str(Str { text, source_info })],
+        })
+    }
+
+    /// Interpreted text with `role`, from `start` to `end` in `fragment`
+    fn role(
+        &mut self,
+        role: &str,
+        fragment: &Fragment,
+        start: usize,
+        end: usize,
+        source_info: SourceInfo,
+    ) -> Inlines {
+        let raw = &fragment.text[start..end];
+        let inline = match role {
+            "emphasis" | "title-reference" | "title" | "t" => Inline::Emph(Emph {
+                content: self.text(fragment, start, end),
+                source_info,
+            }),
+            "strong" => Inline::Strong(Strong {
+                content: self.text(fragment, start, end),
+                source_info,
+            }),
+            "literal" | "code" | "samp" | "file" => Inline::Code(Code {
+                attr: empty_attr(),
+                text: unescape(raw),
+                source_info,
+                attr_source: AttrSourceInfo::empty(),
+            }),
+            "math" => Inline::Math(Math {
+                math_type: MathType::InlineMath,
+                text: raw.to_string(),
+                source_info,
+            }),
+            "sub" | "subscript" => Inline::Subscript(Subscript {
+                content: self.text(fragment, start, end),
+                source_info,
+            }),
+            "sup" | "superscript" => Inline::Superscript(Superscript {
+                content: self.text(fragment, start, end),
+                source_info,
+            }),
+            "ref" | "doc" => {
+                let (title, target) = embedded_target(raw);
+                let target = unescape(target.unwrap_or(title));
+                let content = words(&unescape(title), &source_info);
+                if role == "ref" {
+                    reference_link(content, &target, source_info)
+                } else {
+                    link(content, format!("{}.qmd", target), source_info)
+                }
+            }
+            "pep" | "pep-reference" | "rfc" | "rfc-reference" => {
+                let number = raw.trim();
+                let url = if role.starts_with("pep") {
+                    format!("https://peps.python.org/pep-{:0>4}/", number)
+                } else {
+                    format!("https://www.rfc-editor.org/rfc/rfc{}", number)
+                };
+                let label = if role.starts_with("pep") {
+                    "PEP"
+                } else {
+                    "RFC"
+                };
+                let content = words(&format!("{} {}", label, number), &source_info);
+                link(content, url, source_info)
+            }
+            "cite" | "cite:p" | "citep" | "cite:t" | "citet" | "cite:ps" | "cite:ts" => {
+                let mode = if matches!(role, "cite:t" | "citet" | "cite:ts") {
+                    CitationMode::AuthorInText
+                } else {
+                    CitationMode::NormalCitation
+                };
+                let citations = raw
+                    .split(',')
+                    .map(str::trim)
+                    .filter(|key| !key.is_empty())
+                    .map(|key| Citation {
+                        id: key.to_string(),
+                        prefix: Vec::new(),
+                        suffix: Vec::new(),
+                        mode,
+                        note_num: 1,
+                        hash: 0,
+                        id_source: Some(source_info.clone()),
+                    })
+                    .collect();
+                Inline::Cite(Cite {
+                    citations,
+                    content: vec![Inline::Str(Str {
+                        text: raw.to_string(),
+                        source_info: source_info.clone(),
+                    })],
+                    source_info,
+                })
+            }
+            _ => {
+                let mut attr = class_attr(&["interpreted-text"]);
+                attr.2.insert("role".to_string(), role.to_string());
+                Inline::Code(Code {
+                    attr,
+                    text: unescape(raw),
+                    source_info,
+                    attr_source: AttrSourceInfo::empty(),
+                })
+            }
+        };
+        vec![inline]
+    }
+}
+
+/// `lines` without the blank lines at their end
+fn trim_blank<'b, 'a>(lines: &'b [Line<'a>]) -> &'b [Line<'a>] {
+    let end = lines
+        .iter()
+        .rposition(|line| !line.is_blank())
+        .map_or(0, |i| i + 1);
+    &lines[..end]
+}
+
+/// Where the colon ending a target's name is in `.. _name: url`, after
+/// `.. _`
+fn target_colon(text: &str) -> Option<usize> {
+    let mut escaped = false;
+    for (i, c) in text.char_indices() {
+        if escaped {
+            escaped = false;
+        } else if c == '\\' {
+            escaped = true;
+        } else if c == ':' && text[i + 1..].chars().next().is_none_or(char::is_whitespace) {
+            return Some(i);
+        }
+    }
+    None
+}
+
+/// `:name: value` in the options of a directive
+fn option_line(text: &str) -> Option<(String, String)> {
+    let text = text.trim();
+    let (name_end, body_start) = field_marker(text)?;
+    Some((
+        text[1..name_end].to_lowercase(),
+        text[body_start.min(text.len())..].trim().to_string(),
+    ))
+}
+
+/// The characters of `unicode::` codes: `U+2014`, `0x2014`, `&#x2014;` or
+/// `8212`. Other words are kept as they are.
+fn unicode_text(data: &str) -> String {
+    data.split_whitespace()
+        .take_while(|word| !word.starts_with(".."))
+        .map(|word| {
+            let hex = word
+                .strip_prefix("U+")
+                .or_else(|| word.strip_prefix("u+"))
+                .or_else(|| word.strip_prefix("0x"))
+                .or_else(|| word.strip_prefix("\\x"))
+                .or_else(|| word.strip_prefix("&#x").and_then(|w| w.strip_suffix(';')));
+            let code = match hex {
+                Some(hex) => u32::from_str_radix(hex, 16).ok(),
+                None => word.parse().ok(),
+            };
+            code.and_then(char::from_u32)
+                .map_or_else(|| word.to_string(), |c| c.to_string())
+        })
+        .collect()
+}
+
+/// The fields of a line of comma-separated values, with `"` quoting
+fn csv_fields(record: &str, delimiter: char) -> Vec<String> {
+    let mut fields = Vec::new();
+    let mut field = String::new();
+    let mut quoted = false;
+    let mut chars = record.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '"' if quoted && chars.peek() == Some(&'"') => {
+                field.push('"');
+                chars.next();
+            }
+            '"' if quoted => quoted = false,
+            '"' if field.trim().is_empty() => {
+                field.clear();
+                quoted = true;
+            }
+            c if c == delimiter && !quoted => {
+                fields.push(std::mem::take(&mut field).trim().to_string());
+            }
+            c => field.push(c),
+        }
+    }
+    fields.push(field.trim().to_string());
+    fields
+}
+
+/// The source of a block, for the Div a `.. _label:` wraps it in
+fn block_source(block: &Block) -> SourceInfo {
+    match block {
+        Block::Paragraph(b) => b.source_info.clone(),
+        Block::Plain(b) => b.source_info.clone(),
+        Block::CodeBlock(b) => b.source_info.clone(),
+        Block::RawBlock(b) => b.source_info.clone(),
+        Block::BlockQuote(b) => b.source_info.clone(),
+        Block::BulletList(b) => b.source_info.clone(),
+        Block::OrderedList(b) => b.source_info.clone(),
+        Block::DefinitionList(b) => b.source_info.clone(),
+        Block::Table(b) => b.source_info.clone(),
+        Block::Figure(b) => b.source_info.clone(),
+        Block::Div(b) => b.source_info.clone(),
+        Block::LineBlock(b) => b.source_info.clone(),
+        _ => SourceInfo::default(),
+    }
+}
+
+/// The words of option text, such as an image's `:alt:`, all with the
+/// source of their directive
+fn words(text: &str, source_info: &SourceInfo) -> Inlines {
+    let mut inlines = Vec::new();
+    for word in text.split_whitespace() {
+        if !inlines.is_empty() {
+            inlines.push(Inline::Space(Space {
+                source_info: source_info.clone(),
+            }));
+        }
+        inlines.push(Inline::Str(Str {
+            text: word.to_string(),
+            source_info: source_info.clone(),
+        }));
+    }
+    inlines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn blocks(input: &str) -> Blocks {
+        read(input, "test.rst").0.blocks
+    }
+
+    fn paragraph(input: &str) -> Inlines {
+        match blocks(input).into_iter().next() {
+            Some(Block::Paragraph(para)) => para.content,
+            other => panic!("Expected a paragraph, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_title_and_docinfo() {
+        let (pandoc, _context, _) = read(
+            concat!(
+                "=======\nReport\n=======\n\n:Author: Ada Lovelace\n:Date: 2025-01-01\n\n",
+                "Intro\n=====\n\nText.\n\nDetails\n-------\n\nUsage\n=====\n",
+            ),
+            "test.rst",
+        );
+        assert!(pandoc.meta.get("title").is_some());
+        assert!(pandoc.meta.get("author").is_some());
+        assert!(pandoc.meta.get("date").is_some());
+        let levels: Vec<usize> = pandoc
+            .blocks
+            .iter()
+            .filter_map(|block| match block {
+                Block::Header(header) => Some(header.level),
+                _ => None,
+            })
+            .collect();
+        assert_eq!(levels, [1, 2, 1]);
+    }
+
+    #[test]
+    fn test_inline_markup() {
+        let content = paragraph("*emph* **strong** ``code`` :math:`x^2` H\\ :sub:`2`\\ O 2*3*4\n");
+        assert!(matches!(&content[0], Inline::Emph(_)));
+        assert!(matches!(&content[2], Inline::Strong(_)));
+        assert!(matches!(&content[4], Inline::Code(code) if code.text == "code"));
+        assert!(matches!(&content[6], Inline::Math(math) if math.text == "x^2"));
+        assert!(matches!(&content[9], Inline::Subscript(_)), "{:?}", content);
+        assert!(matches!(content.last(), Some(Inline::Str(s)) if s.text == "2*3*4"));
+    }
+
+    #[test]
+    fn test_links() {
+        let blocks = blocks(concat!(
+            ".. _intro:\n\nIntroduction\n============\n\n",
+            "See intro_, `Introduction`_, `Quarto <https://quarto.org>`_, Python_ and ",
+            ":ref:`the intro <intro>`.\n\n",
+            ".. _Python: https://www.python.org\n\nUsage\n=====\n",
+        ));
+        assert!(matches!(&blocks[0], Block::Header(header) if header.attr.0 == "intro"));
+        let Block::Paragraph(para) = &blocks[1] else {
+            panic!("Expected a paragraph, got {:?}", blocks[1]);
+        };
+        let targets: Vec<&str> = para
+            .content
+            .iter()
+            .filter_map(|inline| match inline {
+                Inline::Link(link) => Some(link.target.0.as_str()),
+                _ => None,
+            })
+            .collect();
+        assert_eq!(
+            targets,
+            [
+                "#intro",
+                "#intro",
+                "https://quarto.org",
+                "https://www.python.org",
+                "#intro"
+            ]
+        );
+
+        let (_, _, warnings) = read("See nowhere_.\n", "test.rst");
+        assert_eq!(warnings.len(), 1);
+        assert_eq!(warnings[0].code.as_deref(), Some("Q-2-45"));
+    }
+
+    #[test]
+    fn test_lists() {
+        let blocks =
+            blocks("- one\n- two\n\n  - nested\n\n#. first\n#. second\n\nterm\n  Definition.\n");
+        assert_eq!(blocks.len(), 3, "{:?}", blocks);
+        let Block::BulletList(list) = &blocks[0] else {
+            panic!("Expected a bullet list, got {:?}", blocks[0]);
+        };
+        assert_eq!(list.content.len(), 2);
+        assert!(matches!(list.content[1][1], Block::BulletList(_)));
+        assert!(matches!(&blocks[1], Block::OrderedList(list) if list.content.len() == 2));
+        assert!(matches!(&blocks[2], Block::DefinitionList(list) if list.content.len() == 1));
+    }
+
+    #[test]
+    fn test_directives() {
+        let blocks = blocks(concat!(
+            ".. note:: Remember\n   this.\n\n",
+            ".. code-block:: python\n   :linenos:\n\n   print(1)\n\n",
+            ".. figure:: plot.png\n   :alt: A plot\n\n   The caption.\n\n",
+            ".. raw:: html\n\n   <b>raw</b>\n\n",
+            "Example::\n\n   literal text\n\n",
+            ".. toctree::\n\n   other\n",
+        ));
+        assert_eq!(blocks.len(), 7, "{:?}", blocks);
+        assert!(matches!(&blocks[0], Block::Div(div) if div.attr.1 == ["callout-note"]));
+        let Block::CodeBlock(code) = &blocks[1] else {
+            panic!("Expected a code block, got {:?}", blocks[1]);
+        };
+        assert_eq!(code.attr.1, ["python", "numberLines"]);
+        assert_eq!(code.text, "print(1)");
+        assert!(matches!(&blocks[2], Block::Figure(figure) if figure.caption.long.is_some()));
+        assert!(matches!(&blocks[3], Block::RawBlock(raw) if raw.format == "html"));
+        assert!(matches!(&blocks[4], Block::Paragraph(_)));
+        assert!(matches!(&blocks[5], Block::CodeBlock(code) if code.text == "literal text"));
+        assert!(matches!(&blocks[6], Block::RawBlock(raw) if raw.format == "rst"));
+    }
+
+    #[test]
+    fn test_tables() {
+        let blocks = blocks(concat!(
+            "+------+-------+\n| Name | Value |\n+======+=======+\n| a    | 1     |\n+------+-------+\n\n",
+            "=====  =====\nName   Value\n=====  =====\na      1\nb      2\n=====  =====\n",
+        ));
+        assert_eq!(blocks.len(), 2, "{:?}", blocks);
+        for block in &blocks {
+            let Block::Table(table) = block else {
+                panic!("Expected a table, got {:?}", block);
+            };
+            assert_eq!(table.head.rows.len(), 1);
+            assert!(!table.bodies[0].body.is_empty());
+        }
+    }
+
+    #[test]
+    fn test_footnotes_and_substitutions() {
+        let blocks = blocks(
+            "Text [#]_ with |name|.\n\n.. [#] The note.\n.. |name| replace:: *a substitution*\n",
+        );
+        assert_eq!(blocks.len(), 1, "{:?}", blocks);
+        let Block::Paragraph(para) = &blocks[0] else {
+            panic!("Expected a paragraph, got {:?}", blocks[0]);
+        };
+        assert!(
+            para.content
+                .iter()
+                .any(|inline| matches!(inline, Inline::Note(_)))
+        );
+        assert!(
+            para.content
+                .iter()
+                .any(|inline| matches!(inline, Inline::Emph(_)))
+        );
+    }
+}
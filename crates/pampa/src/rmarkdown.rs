@@ -0,0 +1,1197 @@
+/*
+ * rmarkdown.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Conversion of R Markdown (`.Rmd`) source to Quarto.
+//!
+//! The conversion works on the text, so everything it doesn't touch stays
+//! byte for byte as it was. It rewrites:
+//!
+//! - chunk headers: ```` ```{r label, echo=FALSE} ```` becomes
+//!   ```` ```{r} ```` followed by `#| label: label` and `#| echo: false`.
+//!   Option names lose their dots (`fig.cap` is `fig-cap`), R literals
+//!   become YAML, and other R expressions become `!expr` values that knitr
+//!   still evaluates.
+//! - the `knitr::opts_chunk$set(...)` call of the `setup` chunk, whose
+//!   options move to `execute` (the ones Quarto knows) and
+//!   `knitr: opts_chunk` in the front matter. A setup chunk left empty is
+//!   removed.
+//! - the `output` field, whose formats and options are mapped to `format`,
+//!   and `runtime: shiny`, which is `server: shiny`.
+//! - inline code, `` `r x` ``, which is `` `{r} x` ``.
+//!
+//! Whatever can't be translated is kept as it was, or dropped when Quarto
+//! would reject it, and reported with its place in the source, so that a
+//! migration can be reviewed.
+
+use crate::cell_options::comment_prefix;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_source_map::{FileId, SourceInfo};
+use yaml_rust2::yaml::Hash;
+use yaml_rust2::{Yaml, YamlEmitter, YamlLoader};
+
+/// An R Markdown document converted to Quarto
+#[derive(Debug, Clone, PartialEq)]
+pub struct Migration {
+    pub text: String,
+    /// What couldn't be translated, in source order
+    pub untranslated: Vec<Untranslated>,
+}
+
+/// Something the conversion couldn't translate, and where it is in the
+/// R Markdown source
+#[derive(Debug, Clone, PartialEq)]
+pub struct Untranslated {
+    /// Byte offsets in the source
+    pub start: usize,
+    pub end: usize,
+    pub problem: String,
+    pub hint: Option<&'static str>,
+}
+
+impl Migration {
+    /// A warning for each thing that wasn't translated, located in the file
+    /// `file_id` holding the R Markdown source
+    pub fn diagnostics(&self, file_id: FileId) -> Vec<DiagnosticMessage> {
+        self.untranslated
+            .iter()
+            .map(|untranslated| {
+                let mut builder = DiagnosticMessageBuilder::warning("Untranslated R Markdown")
+                    .with_code("Q-7-2")
+                    .with_location(SourceInfo::original(
+                        file_id,
+                        untranslated.start,
+                        untranslated.end,
+                    ))
+                    .problem(untranslated.problem.clone());
+                if let Some(hint) = untranslated.hint {
+                    builder = builder.add_hint(hint);
+                }
+                builder.build()
+            })
+            .collect()
+    }
+}
+
+/// Convert R Markdown source to Quarto. `input` has `\n` line endings.
+pub fn migrate(input: &str) -> Migration {
+    let mut migrator = Migrator::default();
+    let (front_matter, body_start) = split_front_matter(input);
+    let blocks = front_matter
+        .as_ref()
+        .map(|(start, text)| front_matter_blocks(text, *start))
+        .unwrap_or_default();
+    // Options of the setup chunk only move when the front matter has no
+    // execution options of its own to merge them with
+    migrator.move_setup_options = !blocks
+        .iter()
+        .any(|block| matches!(block.key.as_deref(), Some("execute" | "knitr")));
+
+    let body = migrator.body(&input[body_start..], body_start);
+    let mut front = String::new();
+    for block in &blocks {
+        migrator.front_matter_block(block, &mut front);
+    }
+    front.push_str(&migrator.setup_front_matter());
+
+    let mut text = String::with_capacity(input.len());
+    if front_matter.is_some() || !front.is_empty() {
+        text.push_str("---\n");
+        text.push_str(&front);
+        text.push_str("---\n");
+        if front_matter.is_none() {
+            text.push('\n');
+        }
+    }
+    text.push_str(&body);
+    migrator
+        .untranslated
+        .sort_by_key(|untranslated| untranslated.start);
+    Migration {
+        text,
+        untranslated: migrator.untranslated,
+    }
+}
+
+#[derive(Default)]
+struct Migrator {
+    untranslated: Vec<Untranslated>,
+    move_setup_options: bool,
+    /// Options of the setup chunk's `opts_chunk$set`, by their knitr name
+    setup_options: Vec<(String, Yaml)>,
+}
+
+impl Migrator {
+    fn report(&mut self, start: usize, end: usize, problem: String, hint: Option<&'static str>) {
+        self.untranslated.push(Untranslated {
+            start,
+            end,
+            problem,
+            hint,
+        });
+    }
+
+    // =========================================================================
+    // Body
+    // =========================================================================
+
+    fn body(&mut self, body: &str, offset: usize) -> String {
+        let lines = lines_with_offsets(body, offset);
+        let mut out = String::with_capacity(body.len());
+        let mut i = 0;
+        while i < lines.len() {
+            let (line_offset, line) = lines[i];
+            let Some(fence) = Fence::open(line) else {
+                out.push_str(&self.inline_code(line, line_offset));
+                i += 1;
+                continue;
+            };
+            let close = (i + 1..lines.len())
+                .find(|&j| fence.is_closed_by(lines[j].1))
+                .unwrap_or(lines.len());
+            let end = (close + 1).min(lines.len());
+            match parse_chunk_header(fence.info) {
+                Some(header) => {
+                    let written = self.chunk(&fence, header, &lines[i..end], &mut out);
+                    // A removed chunk takes the blank line after it along
+                    if !written
+                        && lines
+                            .get(end)
+                            .is_some_and(|(_, line)| line.trim().is_empty())
+                    {
+                        i = end + 1;
+                        continue;
+                    }
+                }
+                None => {
+                    for (_, line) in &lines[i..end] {
+                        out.push_str(line);
+                    }
+                }
+            }
+            i = end;
+        }
+        out
+    }
+
+    /// Write a chunk with its options as `#|` comments. `lines` are the
+    /// chunk's lines from its header to its closing fence. Returns `false`
+    /// when the chunk was removed.
+    fn chunk(
+        &mut self,
+        fence: &Fence,
+        header: ChunkHeader,
+        lines: &[(usize, &str)],
+        out: &mut String,
+    ) -> bool {
+        let (header_offset, header_line) = lines[0];
+        let header_end = header_offset + header_line.trim_end().len();
+        let ChunkHeader {
+            mut engine,
+            mut label,
+            options,
+            unnamed,
+        } = header;
+        for value in unnamed {
+            self.report(
+                header_offset,
+                header_end,
+                format!("Chunk option `{}` has no name", value),
+                Some("Only the first unnamed option of a chunk header is its label"),
+            );
+        }
+        let mut rest = Vec::new();
+        for (name, value) in options {
+            match name.as_str() {
+                "label" => label = Some(unquote(&value)),
+                "engine" => engine = unquote(&value),
+                _ => rest.push((name, r_value(&value))),
+            }
+        }
+
+        let body = &lines[1..];
+        let closing = body
+            .last()
+            .filter(|(_, line)| fence.is_closed_by(line))
+            .map(|(_, line)| *line);
+        let code_lines = match closing {
+            Some(_) => &body[..body.len() - 1],
+            None => body,
+        };
+        let mut code: String = code_lines.iter().map(|(_, line)| *line).collect();
+
+        if label.as_deref() == Some("setup") && engine == "r" {
+            let code_offset = code_lines
+                .first()
+                .map_or(header_offset + header_line.len(), |(offset, _)| *offset);
+            code = self.setup_chunk(&code, code_offset);
+            if code.trim().is_empty() && !self.setup_options.is_empty() {
+                // Nothing is left for the chunk to do
+                return false;
+            }
+        }
+
+        out.push_str(&format!("{}{}{{{}}}\n", fence.indent, fence.marker, engine));
+        let prefix = comment_prefix(&engine);
+        if let Some(label) = label {
+            out.push_str(&format!(
+                "{}{} label: {}\n",
+                fence.indent,
+                prefix,
+                yaml_string(&label)
+            ));
+        }
+        for (name, value) in rest {
+            let value = match value {
+                RValue::Literal(yaml) => yaml_inline(&yaml),
+                RValue::Expr(expr) => format!("!expr {}", yaml_string(&expr)),
+            };
+            out.push_str(&format!(
+                "{}{} {}: {}\n",
+                fence.indent,
+                prefix,
+                name.replace('.', "-"),
+                value
+            ));
+        }
+        out.push_str(&code);
+        if let Some(closing) = closing {
+            out.push_str(closing);
+        }
+        true
+    }
+
+    /// Take the options of `knitr::opts_chunk$set(...)` out of the setup
+    /// chunk's code, and return what is left of it
+    fn setup_chunk(&mut self, code: &str, offset: usize) -> String {
+        let Some(call_start) = code.find("opts_chunk$set(") else {
+            return code.to_string();
+        };
+        let start = code[..call_start]
+            .strip_suffix("knitr::")
+            .map_or(call_start, |prefix| prefix.len());
+        let arguments_start = call_start + "opts_chunk$set(".len();
+        let Some(arguments_len) = closing_paren(&code[arguments_start..]) else {
+            return code.to_string();
+        };
+        let end = arguments_start + arguments_len + 1;
+        let span = (offset + start, offset + end);
+
+        if !self.move_setup_options {
+            self.report(
+                span.0,
+                span.1,
+                "The options of `opts_chunk$set` were not moved to the front matter".to_string(),
+                Some(
+                    "The front matter already has `execute` or `knitr` options to merge them with",
+                ),
+            );
+            return code.to_string();
+        }
+        let mut options = Vec::new();
+        for argument in split_arguments(&code[arguments_start..end - 1]) {
+            let literal = top_level_equals(&argument).and_then(|at| {
+                let name = argument[..at].trim().to_string();
+                match r_value(&argument[at + 1..]) {
+                    RValue::Literal(yaml) => Some((name, yaml)),
+                    RValue::Expr(_) => None,
+                }
+            });
+            match literal {
+                Some(option) => options.push(option),
+                None => {
+                    self.report(
+                        span.0,
+                        span.1,
+                        format!(
+                            "`opts_chunk$set` option `{}` is not a literal value",
+                            argument
+                        ),
+                        Some("The setup chunk is kept as it is; move its options to `execute` by hand"),
+                    );
+                    return code.to_string();
+                }
+            }
+        }
+        self.setup_options.extend(options);
+
+        // The call goes, with the rest of its line when nothing else is on it
+        let mut rest = code[..start].to_string();
+        let after = &code[end..];
+        if rest.is_empty() || rest.ends_with('\n') {
+            rest.push_str(after.strip_prefix('\n').unwrap_or(after));
+        } else {
+            rest.push_str(after);
+        }
+        rest
+    }
+
+    /// Rewrite inline R code, `` `r x` ``, as `` `{r} x` ``
+    fn inline_code(&mut self, line: &str, offset: usize) -> String {
+        if let Some(at) = line.find("\\@ref(") {
+            let end = line[at..]
+                .find(')')
+                .map_or(line.len(), |close| at + close + 1);
+            self.report(
+                offset + at,
+                offset + end,
+                format!("Bookdown cross-reference `{}`", &line[at..end]),
+                Some("Quarto cross-references are written @fig-label, with the figure labelled fig-label"),
+            );
+        }
+        if !line.contains("`r ") {
+            return line.to_string();
+        }
+        let mut out = String::with_capacity(line.len() + 8);
+        let mut rest = line;
+        while let Some(start) = rest.find('`') {
+            out.push_str(&rest[..start]);
+            let ticks = rest[start..].len() - rest[start..].trim_start_matches('`').len();
+            let after = &rest[start + ticks..];
+            let Some(close) = find_tick_run(after, ticks) else {
+                out.push_str(&rest[start..]);
+                return out;
+            };
+            let content = &after[..close];
+            match content.strip_prefix("r ") {
+                Some(code) if ticks == 1 => out.push_str(&format!("`{{r}} {}`", code)),
+                _ => out.push_str(&rest[start..start + ticks * 2 + close]),
+            }
+            rest = &after[close + ticks..];
+        }
+        out.push_str(rest);
+        out
+    }
+
+    // =========================================================================
+    // Front matter
+    // =========================================================================
+
+    fn front_matter_block(&mut self, block: &FrontMatterBlock, out: &mut String) {
+        let end = block.start + block.text.trim_end().len();
+        match block.key.as_deref() {
+            Some("output") => match self.output(block) {
+                Some(format) => out.push_str(&format),
+                None => out.push_str(&block.text),
+            },
+            Some("runtime") => {
+                let value = block.text["runtime:".len()..].trim();
+                if matches!(value, "shiny" | "shiny_prerendered") {
+                    out.push_str("server: shiny\n");
+                } else {
+                    self.report(
+                        block.start,
+                        end,
+                        format!("`runtime: {}` has no Quarto equivalent", value),
+                        None,
+                    );
+                    out.push_str(&block.text);
+                }
+            }
+            Some(key @ ("knit" | "site")) => self.report(
+                block.start,
+                end,
+                format!("`{}` was removed", key),
+                Some("Quarto renders documents itself and doesn't use custom knit functions or site generators"),
+            ),
+            _ => out.push_str(&block.text),
+        }
+    }
+
+    /// The `format` field for an `output` field, or `None` when it can't
+    /// be read
+    fn output(&mut self, block: &FrontMatterBlock) -> Option<String> {
+        let end = block.start + block.text.trim_end().len();
+        let Some(output) = YamlLoader::load_from_str(&block.text)
+            .ok()
+            .and_then(|documents| documents.into_iter().next())
+            .map(|document| document["output"].clone())
+        else {
+            self.report(
+                block.start,
+                end,
+                "The `output` field could not be read".to_string(),
+                None,
+            );
+            return None;
+        };
+
+        let outputs: Vec<(String, Yaml)> = match output {
+            Yaml::String(name) => vec![(name, Yaml::Null)],
+            Yaml::Hash(formats) => formats
+                .into_iter()
+                .filter_map(|(name, options)| Some((name.as_str()?.to_string(), options)))
+                .collect(),
+            Yaml::Array(items) => items
+                .into_iter()
+                .filter_map(|item| match item {
+                    Yaml::String(name) => Some((name, Yaml::Null)),
+                    Yaml::Hash(formats) => formats
+                        .into_iter()
+                        .next()
+                        .and_then(|(name, options)| Some((name.as_str()?.to_string(), options))),
+                    _ => None,
+                })
+                .collect(),
+            _ => Vec::new(),
+        };
+
+        let mut formats = Hash::new();
+        for (name, options) in outputs {
+            let at = locate(&block.text, &name).map_or(block.start, |at| block.start + at);
+            let at_end = at + name.len();
+            let Some((format, approximation)) = output_format(&name) else {
+                self.report(
+                    at,
+                    at_end,
+                    format!("Output format `{}` has no Quarto equivalent", name),
+                    None,
+                );
+                continue;
+            };
+            if let Some(hint) = approximation {
+                self.report(
+                    at,
+                    at_end,
+                    format!("Output format `{}` is written as `{}`", name, format),
+                    Some(hint),
+                );
+            }
+            let mut translated = Hash::new();
+            if let Yaml::Hash(options) = options {
+                for (option, value) in options {
+                    let Some(option) = option.as_str() else {
+                        continue;
+                    };
+                    match format_option(option, &value) {
+                        Some(pairs) => {
+                            for (key, value) in pairs {
+                                translated.insert(Yaml::String(key), value);
+                            }
+                        }
+                        None => {
+                            let at = locate(&block.text, option)
+                                .map_or(block.start, |at| block.start + at);
+                            self.report(
+                                at,
+                                at + option.len(),
+                                format!("Option `{}` of `{}` was removed", option, name),
+                                Some("It has no Quarto equivalent"),
+                            );
+                        }
+                    }
+                }
+            }
+            let value = if translated.is_empty() {
+                Yaml::String("default".to_string())
+            } else {
+                Yaml::Hash(translated)
+            };
+            formats.insert(Yaml::String(format.to_string()), value);
+        }
+
+        let format = match formats.len() {
+            0 => return Some(String::new()),
+            1 if formats
+                .values()
+                .all(|value| value.as_str() == Some("default")) =>
+            {
+                formats.keys().next().unwrap().clone()
+            }
+            _ => Yaml::Hash(formats),
+        };
+        let mut document = Hash::new();
+        document.insert(Yaml::String("format".to_string()), format);
+        Some(emit(&Yaml::Hash(document)))
+    }
+
+    /// The `execute` and `knitr` fields holding the setup chunk's options
+    fn setup_front_matter(&self) -> String {
+        if self.setup_options.is_empty() {
+            return String::new();
+        }
+        let mut execute = Hash::new();
+        let mut opts_chunk = Hash::new();
+        for (name, value) in &self.setup_options {
+            if EXECUTE_OPTIONS.contains(&name.as_str()) {
+                execute.insert(Yaml::String(name.clone()), value.clone());
+            } else {
+                opts_chunk.insert(Yaml::String(name.clone()), value.clone());
+            }
+        }
+        let mut document = Hash::new();
+        if !execute.is_empty() {
+            document.insert(Yaml::String("execute".to_string()), Yaml::Hash(execute));
+        }
+        if !opts_chunk.is_empty() {
+            let mut knitr = Hash::new();
+            knitr.insert(
+                Yaml::String("opts_chunk".to_string()),
+                Yaml::Hash(opts_chunk),
+            );
+            document.insert(Yaml::String("knitr".to_string()), Yaml::Hash(knitr));
+        }
+        emit(&Yaml::Hash(document))
+    }
+}
+
+/// knitr options that Quarto has as execution options
+const EXECUTE_OPTIONS: &[&str] = &["echo", "eval", "include", "warning", "error", "cache"];
+
+/// The Quarto format for an R Markdown output format, and a hint when it
+/// is only an approximation
+fn output_format(name: &str) -> Option<(&'static str, Option<&'static str>)> {
+    let name = name
+        .strip_prefix("rmarkdown::")
+        .or_else(|| name.strip_prefix("bookdown::"))
+        .unwrap_or(name);
+    let format = match name {
+        "html_document" | "html_document2" | "html_notebook" | "gitbook" | "bs4_book" => {
+            return Some((
+                "html",
+                matches!(name, "html_notebook" | "gitbook" | "bs4_book")
+                    .then_some("Quarto has no notebook or book styles of this kind"),
+            ));
+        }
+        "pdf_document" | "pdf_document2" | "pdf_book" => "pdf",
+        "word_document" | "word_document2" => "docx",
+        "odt_document" => "odt",
+        "rtf_document" => "rtf",
+        "md_document" => "md",
+        "github_document" => "gfm",
+        "beamer_presentation" => "beamer",
+        "powerpoint_presentation" => "pptx",
+        "epub_book" | "epub_document" => "epub",
+        "ioslides_presentation" | "slidy_presentation" | "xaringan::moon_reader" => {
+            return Some((
+                "revealjs",
+                Some("Slides are made with reveal.js, which looks different"),
+            ));
+        }
+        "html_vignette" | "tufte::tufte_html" | "tufte_html" => {
+            return Some((
+                "html",
+                Some("The styles of this format are not available in Quarto"),
+            ));
+        }
+        _ => return None,
+    };
+    Some((format, None))
+}
+
+/// The Quarto options for an option of an R Markdown format, or `None`
+/// when it has no equivalent
+fn format_option(name: &str, value: &Yaml) -> Option<Vec<(String, Yaml)>> {
+    let renamed = |key: &str| Some(vec![(key.to_string(), value.clone())]);
+    match name {
+        "toc" | "theme" | "css" | "template" | "incremental" => renamed(name),
+        "toc_depth" | "number_sections" | "fig_width" | "fig_height" | "df_print" | "keep_md"
+        | "keep_tex" | "toc_title" | "slide_level" | "anchor_sections" => {
+            renamed(&name.replace('_', "-"))
+        }
+        "highlight" => renamed("highlight-style"),
+        "self_contained" => renamed("embed-resources"),
+        "latex_engine" => renamed("pdf-engine"),
+        "reference_docx" | "reference_doc" => renamed("reference-doc"),
+        "dev" => renamed("fig-format"),
+        "citation_package" => match value.as_str() {
+            Some("default") | Some("none") => Some(vec![(
+                "cite-method".to_string(),
+                Yaml::String("citeproc".to_string()),
+            )]),
+            _ => renamed("cite-method"),
+        },
+        "code_folding" => {
+            let folding = match value.as_str() {
+                Some("hide") => Yaml::Boolean(true),
+                Some("show") => Yaml::String("show".to_string()),
+                _ => Yaml::Boolean(false),
+            };
+            Some(vec![("code-fold".to_string(), folding)])
+        }
+        "includes" => {
+            let Yaml::Hash(includes) = value else {
+                return None;
+            };
+            includes
+                .iter()
+                .map(|(key, value)| {
+                    let key = match key.as_str()? {
+                        "in_header" => "include-in-header",
+                        "before_body" => "include-before-body",
+                        "after_body" => "include-after-body",
+                        _ => return None,
+                    };
+                    Some((key.to_string(), value.clone()))
+                })
+                .collect()
+        }
+        _ => None,
+    }
+}
+
+// =============================================================================
+// Source structure
+// =============================================================================
+
+/// The lines of `text` with their end of line, and the offset of each in
+/// the source
+fn lines_with_offsets(text: &str, offset: usize) -> Vec<(usize, &str)> {
+    let mut at = offset;
+    text.split_inclusive('\n')
+        .map(|line| {
+            let start = at;
+            at += line.len();
+            (start, line)
+        })
+        .collect()
+}
+
+/// The front matter's start offset and text (without its delimiters), and
+/// where the body starts
+fn split_front_matter(input: &str) -> (Option<(usize, &str)>, usize) {
+    let Some(first) = input.split_inclusive('\n').next() else {
+        return (None, 0);
+    };
+    if first.trim_end() != "---" {
+        return (None, 0);
+    }
+    let start = first.len();
+    let mut at = start;
+    for line in input[start..].split_inclusive('\n') {
+        if matches!(line.trim_end(), "---" | "...") {
+            return (Some((start, &input[start..at])), at + line.len());
+        }
+        at += line.len();
+    }
+    (None, 0)
+}
+
+/// A top-level field of the front matter, with the lines below it
+struct FrontMatterBlock {
+    /// `None` for comments and blank lines before the first field
+    key: Option<String>,
+    start: usize,
+    text: String,
+}
+
+fn front_matter_blocks(text: &str, offset: usize) -> Vec<FrontMatterBlock> {
+    let mut blocks: Vec<FrontMatterBlock> = Vec::new();
+    for (line_offset, line) in lines_with_offsets(text, offset) {
+        let starts_field = !line.starts_with([' ', '\t', '#', '-', '\n']) && line.contains(':');
+        match blocks.last_mut() {
+            Some(block) if !starts_field => block.text.push_str(line),
+            _ if !starts_field => blocks.push(FrontMatterBlock {
+                key: None,
+                start: line_offset,
+                text: line.to_string(),
+            }),
+            _ => blocks.push(FrontMatterBlock {
+                key: line.split(':').next().map(|key| key.trim().to_string()),
+                start: line_offset,
+                text: line.to_string(),
+            }),
+        }
+    }
+    blocks
+}
+
+/// The offset of `needle` in `text` as a whole word
+fn locate(text: &str, needle: &str) -> Option<usize> {
+    text.match_indices(needle).map(|(at, _)| at).find(|&at| {
+        let before = text[..at].chars().next_back();
+        let after = text[at + needle.len()..].chars().next();
+        let boundary = |c: Option<char>| !c.is_some_and(|c| c.is_alphanumeric() || c == '_');
+        boundary(before) && boundary(after)
+    })
+}
+
+/// An opening code fence
+struct Fence<'a> {
+    indent: &'a str,
+    /// The run of backticks or tildes
+    marker: &'a str,
+    info: &'a str,
+}
+
+impl<'a> Fence<'a> {
+    fn open(line: &'a str) -> Option<Self> {
+        let trimmed = line.trim_start_matches([' ', '\t']);
+        let indent = &line[..line.len() - trimmed.len()];
+        let fence_char = trimmed.chars().next().filter(|c| matches!(c, '`' | '~'))?;
+        let marker_len = trimmed.len() - trimmed.trim_start_matches(fence_char).len();
+        if marker_len < 3 {
+            return None;
+        }
+        Some(Fence {
+            indent,
+            marker: &trimmed[..marker_len],
+            info: trimmed[marker_len..].trim(),
+        })
+    }
+
+    fn is_closed_by(&self, line: &str) -> bool {
+        let trimmed = line.trim();
+        let fence_char = self.marker.as_bytes()[0] as char;
+        trimmed.len() >= self.marker.len() && trimmed.chars().all(|c| c == fence_char)
+    }
+}
+
+/// The parts of a knitr chunk header such as `{r label, echo=FALSE}`
+struct ChunkHeader {
+    engine: String,
+    label: Option<String>,
+    /// Named options, with their values as R source
+    options: Vec<(String, String)>,
+    /// Unnamed options after the label
+    unnamed: Vec<String>,
+}
+
+/// The chunk header in a fence's info string, or `None` when it isn't an
+/// executable chunk (plain code blocks, `{.class}` attributes, raw blocks)
+fn parse_chunk_header(info: &str) -> Option<ChunkHeader> {
+    let inner = info.strip_prefix('{')?.strip_suffix('}')?.trim();
+    let engine_len = inner
+        .find(|c: char| !(c.is_ascii_alphanumeric() || c == '_'))
+        .unwrap_or(inner.len());
+    if engine_len == 0 || !inner.starts_with(|c: char| c.is_ascii_alphabetic()) {
+        return None;
+    }
+    let engine = inner[..engine_len].to_string();
+    let rest = inner[engine_len..].trim_start_matches([',', ' ', '\t']);
+
+    let mut header = ChunkHeader {
+        engine,
+        label: None,
+        options: Vec::new(),
+        unnamed: Vec::new(),
+    };
+    for (i, argument) in split_arguments(rest).into_iter().enumerate() {
+        match top_level_equals(&argument) {
+            Some(at) => header.options.push((
+                argument[..at].trim().to_string(),
+                argument[at + 1..].trim().to_string(),
+            )),
+            None if i == 0 => header.label = Some(unquote(&argument)),
+            None => header.unnamed.push(argument),
+        }
+    }
+    Some(header)
+}
+
+// =============================================================================
+// R values
+// =============================================================================
+
+/// The offset of the `)` closing a call whose arguments `text` starts with
+fn closing_paren(text: &str) -> Option<usize> {
+    let mut depth = 0;
+    let mut quote = None;
+    let mut escaped = false;
+    for (at, c) in text.char_indices() {
+        if let Some(q) = quote {
+            match c {
+                _ if escaped => escaped = false,
+                '\\' => escaped = true,
+                _ if c == q => quote = None,
+                _ => {}
+            }
+            continue;
+        }
+        match c {
+            '"' | '\'' => quote = Some(c),
+            '(' | '[' | '{' => depth += 1,
+            ')' if depth == 0 => return Some(at),
+            ')' | ']' | '}' => depth -= 1,
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Split R arguments at the commas outside strings and brackets
+fn split_arguments(text: &str) -> Vec<String> {
+    let mut arguments = Vec::new();
+    let mut current = String::new();
+    let mut depth = 0;
+    let mut quote = None;
+    let mut escaped = false;
+    for c in text.chars() {
+        if let Some(q) = quote {
+            match c {
+                _ if escaped => escaped = false,
+                '\\' => escaped = true,
+                _ if c == q => quote = None,
+                _ => {}
+            }
+            current.push(c);
+            continue;
+        }
+        match c {
+            '"' | '\'' => quote = Some(c),
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            ',' if depth == 0 => {
+                arguments.push(std::mem::take(&mut current).trim().to_string());
+                continue;
+            }
+            _ => {}
+        }
+        current.push(c);
+    }
+    if !current.trim().is_empty() {
+        arguments.push(current.trim().to_string());
+    }
+    arguments.retain(|argument| !argument.is_empty());
+    arguments
+}
+
+/// The offset of the `=` naming an argument, outside strings and brackets
+fn top_level_equals(argument: &str) -> Option<usize> {
+    let bytes = argument.as_bytes();
+    let mut depth = 0;
+    let mut quote = None;
+    for (at, &b) in bytes.iter().enumerate() {
+        if let Some(q) = quote {
+            if b == q && (at == 0 || bytes[at - 1] != b'\\') {
+                quote = None;
+            }
+            continue;
+        }
+        match b {
+            b'"' | b'\'' => quote = Some(b),
+            b'(' | b'[' | b'{' => depth += 1,
+            b')' | b']' | b'}' => depth -= 1,
+            b'=' if depth == 0 => {
+                let before = at.checked_sub(1).map(|i| bytes[i]);
+                let after = bytes.get(at + 1).copied();
+                if !matches!(before, Some(b'=' | b'!' | b'<' | b'>')) && after != Some(b'=') {
+                    return Some(at);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// An R string literal's value, or the text itself when it isn't quoted
+fn unquote(text: &str) -> String {
+    let text = text.trim();
+    let quoted = text.len() >= 2
+        && ((text.starts_with('"') && text.ends_with('"'))
+            || (text.starts_with('\'') && text.ends_with('\'')));
+    if !quoted {
+        return text.to_string();
+    }
+    let mut out = String::new();
+    let mut chars = text[1..text.len() - 1].chars();
+    while let Some(c) = chars.next() {
+        match c {
+            '\\' => match chars.next() {
+                Some('n') => out.push('\n'),
+                Some('t') => out.push('\t'),
+                Some(c) => out.push(c),
+                None => {}
+            },
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+enum RValue {
+    Literal(Yaml),
+    /// R source that knitr has to evaluate
+    Expr(String),
+}
+
+fn r_value(text: &str) -> RValue {
+    let text = text.trim();
+    let literal = match text {
+        "TRUE" | "T" => Some(Yaml::Boolean(true)),
+        "FALSE" | "F" => Some(Yaml::Boolean(false)),
+        "NULL" => Some(Yaml::Null),
+        _ if text.len() >= 2 && (text.starts_with('"') || text.starts_with('\'')) => {
+            Some(Yaml::String(unquote(text)))
+        }
+        _ => r_number(text),
+    };
+    if let Some(literal) = literal {
+        return RValue::Literal(literal);
+    }
+    if let Some(items) = text
+        .strip_prefix("c(")
+        .and_then(|rest| rest.strip_suffix(')'))
+    {
+        let items: Option<Vec<Yaml>> = split_arguments(items)
+            .iter()
+            .map(|item| match r_value(item) {
+                RValue::Literal(Yaml::Array(_) | Yaml::Hash(_)) => None,
+                RValue::Literal(yaml) => Some(yaml),
+                RValue::Expr(_) => None,
+            })
+            .collect();
+        if let Some(items) = items {
+            return RValue::Literal(Yaml::Array(items));
+        }
+    }
+    RValue::Expr(text.to_string())
+}
+
+fn r_number(text: &str) -> Option<Yaml> {
+    if let Ok(n) = text.strip_suffix('L').unwrap_or(text).parse::<i64>() {
+        return Some(Yaml::Integer(n));
+    }
+    let numeric = !text.is_empty()
+        && text
+            .chars()
+            .all(|c| c.is_ascii_digit() || matches!(c, '.' | 'e' | 'E' | '-' | '+'))
+        && text.starts_with(|c: char| c.is_ascii_digit() || matches!(c, '.' | '-'));
+    (numeric && text.parse::<f64>().is_ok()).then(|| Yaml::Real(text.to_string()))
+}
+
+// =============================================================================
+// YAML output
+// =============================================================================
+
+/// A YAML string, quoted unless it reads back as the same plain string
+fn yaml_string(text: &str) -> String {
+    let plain = !text.is_empty()
+        && text.trim() == text
+        && text.starts_with(|c: char| c.is_alphanumeric() || matches!(c, '.' | '/' | '('))
+        && text
+            .chars()
+            .all(|c| c.is_alphanumeric() || " -_./%()+".contains(c))
+        && !matches!(
+            text.to_ascii_lowercase().as_str(),
+            "true" | "false" | "null" | "yes" | "no" | "on" | "off" | "y" | "n"
+        )
+        && r_number(text).is_none();
+    if plain {
+        return text.to_string();
+    }
+    let mut out = String::with_capacity(text.len() + 2);
+    out.push('"');
+    for c in text.chars() {
+        match c {
+            '"' => out.push_str("\\\""),
+            '\\' => out.push_str("\\\\"),
+            '\n' => out.push_str("\\n"),
+            '\t' => out.push_str("\\t"),
+            c => out.push(c),
+        }
+    }
+    out.push('"');
+    out
+}
+
+/// A value on one line, as in `#| fig-cap: [A, B]`
+fn yaml_inline(value: &Yaml) -> String {
+    match value {
+        Yaml::Boolean(b) => b.to_string(),
+        Yaml::Integer(n) => n.to_string(),
+        Yaml::Real(n) => n.clone(),
+        Yaml::String(s) => yaml_string(s),
+        Yaml::Array(items) => format!(
+            "[{}]",
+            items.iter().map(yaml_inline).collect::<Vec<_>>().join(", ")
+        ),
+        _ => "null".to_string(),
+    }
+}
+
+/// A YAML map as front matter lines
+fn emit(value: &Yaml) -> String {
+    let mut out = String::new();
+    if YamlEmitter::new(&mut out).dump(value).is_err() {
+        return String::new();
+    }
+    // The emitter starts a document with "---"
+    let mut text = out.strip_prefix("---\n").unwrap_or(&out).to_string();
+    text.push('\n');
+    text
+}
+
+/// The offset of the run of exactly `ticks` backticks closing a code span
+fn find_tick_run(text: &str, ticks: usize) -> Option<usize> {
+    let bytes = text.as_bytes();
+    let mut at = 0;
+    while at < bytes.len() {
+        if bytes[at] != b'`' {
+            at += 1;
+            continue;
+        }
+        let run = bytes[at..].iter().take_while(|&&b| b == b'`').count();
+        if run == ticks {
+            return Some(at);
+        }
+        at += run;
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_chunk_header() {
+        let migration = migrate(
+            "```{r cars, echo=FALSE, fig.cap=\"Speed, distance\", out.width='50%'}\nplot(cars)\n```\n",
+        );
+        assert_eq!(
+            migration.text,
+            "```{r}\n#| label: cars\n#| echo: false\n#| fig-cap: \"Speed, distance\"\n\
+             #| out-width: 50%\nplot(cars)\n```\n"
+        );
+        assert!(migration.untranslated.is_empty());
+    }
+
+    #[test]
+    fn test_option_values() {
+        let migration = migrate(
+            "```{r, fig.width=7L, fig.height = 3.5, results=\"hide\", fig.show=c('a', 'b'), \
+             dev=if (x) 'png' else 'pdf', message=F}\n1\n```\n",
+        );
+        assert!(
+            migration.text.contains("#| fig-width: 7\n"),
+            "{}",
+            migration.text
+        );
+        assert!(migration.text.contains("#| fig-height: 3.5\n"));
+        assert!(migration.text.contains("#| results: hide\n"));
+        assert!(migration.text.contains("#| fig-show: [a, b]\n"));
+        assert!(
+            migration
+                .text
+                .contains("#| dev: !expr \"if (x) 'png' else 'pdf'\"\n"),
+            "{}",
+            migration.text
+        );
+        assert!(migration.text.contains("#| message: false\n"));
+    }
+
+    #[test]
+    fn test_engines_and_plain_code() {
+        let migration = migrate(
+            "```{r query, engine='sql', connection=db}\nSELECT 1\n```\n\n\
+             ```python\nx = 1\n```\n\n```{.r}\nnot run\n```\n",
+        );
+        assert!(
+            migration
+                .text
+                .starts_with("```{sql}\n--| label: query\n--| connection: !expr db\n"),
+            "{}",
+            migration.text
+        );
+        assert!(migration.text.contains("```python\nx = 1\n```\n"));
+        assert!(migration.text.contains("```{.r}\nnot run\n```\n"));
+    }
+
+    #[test]
+    fn test_setup_chunk_moves_to_front_matter() {
+        let migration = migrate(
+            "---\ntitle: Report\n---\n\n\
+             ```{r setup, include=FALSE}\nknitr::opts_chunk$set(echo = FALSE, message = FALSE,\n  fig.width = 6)\n```\n\nText.\n",
+        );
+        assert_eq!(
+            migration.text,
+            "---\ntitle: Report\nexecute:\n  echo: false\nknitr:\n  opts_chunk:\n    \
+             message: false\n    fig.width: 6\n---\n\nText.\n"
+        );
+    }
+
+    #[test]
+    fn test_setup_chunk_keeps_other_code() {
+        let migration =
+            migrate("```{r setup}\nlibrary(ggplot2)\nknitr::opts_chunk$set(echo = TRUE)\n```\n");
+        assert_eq!(
+            migration.text,
+            "---\nexecute:\n  echo: true\n---\n\n```{r}\n#| label: setup\nlibrary(ggplot2)\n```\n"
+        );
+    }
+
+    #[test]
+    fn test_setup_chunk_with_expressions_is_kept() {
+        let input = "```{r setup}\nknitr::opts_chunk$set(echo = params$show)\n```\n";
+        let migration = migrate(input);
+        assert!(
+            migration
+                .text
+                .contains("opts_chunk$set(echo = params$show)")
+        );
+        assert_eq!(migration.untranslated.len(), 1);
+        let untranslated = &migration.untranslated[0];
+        assert_eq!(
+            &input[untranslated.start..untranslated.end],
+            "knitr::opts_chunk$set(echo = params$show)"
+        );
+    }
+
+    #[test]
+    fn test_output_formats() {
+        let migration = migrate(
+            "---\ntitle: A\noutput:\n  html_document:\n    toc: true\n    toc_float: true\n    \
+             code_folding: hide\n    number_sections: true\n  pdf_document: default\n\
+             date: today\n---\n\nText.\n",
+        );
+        assert_eq!(
+            migration.text,
+            "---\ntitle: A\nformat:\n  html:\n    toc: true\n    code-fold: true\n    \
+             number-sections: true\n  pdf: default\ndate: today\n---\n\nText.\n"
+        );
+        assert_eq!(migration.untranslated.len(), 1);
+        assert!(migration.untranslated[0].problem.contains("toc_float"));
+    }
+
+    #[test]
+    fn test_single_format_and_other_fields() {
+        let migration = migrate(
+            "---\noutput: rmarkdown::word_document\nruntime: shiny\nknit: my_knit\n---\n\nText.\n",
+        );
+        assert_eq!(
+            migration.text,
+            "---\nformat: docx\nserver: shiny\n---\n\nText.\n"
+        );
+        assert_eq!(migration.untranslated.len(), 1);
+        assert!(migration.untranslated[0].problem.contains("knit"));
+
+        let migration = migrate("---\noutput: flexdashboard::flex_dashboard\n---\n");
+        assert_eq!(migration.text, "---\n---\n");
+        assert!(migration.untranslated[0].problem.contains("flex_dashboard"));
+    }
+
+    #[test]
+    fn test_inline_code() {
+        let migration = migrate(
+            "The mean is `r mean(x)`, and `` `r x` `` is code.\n\n```{r}\n`r not inline`\n```\n",
+        );
+        assert_eq!(
+            migration.text,
+            "The mean is `{r} mean(x)`, and `` `r x` `` is code.\n\n```{r}\n`r not inline`\n```\n"
+        );
+    }
+
+    #[test]
+    fn test_bookdown_references_are_reported() {
+        let input = "See Figure \\@ref(fig:cars).\n";
+        let migration = migrate(input);
+        assert_eq!(migration.text, input);
+        let untranslated = &migration.untranslated[0];
+        assert_eq!(
+            &input[untranslated.start..untranslated.end],
+            "\\@ref(fig:cars)"
+        );
+    }
+
+    #[test]
+    fn test_yaml_string() {
+        assert_eq!(yaml_string("plain text"), "plain text");
+        assert_eq!(yaml_string("a: b"), "\"a: b\"");
+        assert_eq!(yaml_string("true"), "\"true\"");
+        assert_eq!(yaml_string("12"), "\"12\"");
+        assert_eq!(yaml_string("say \"hi\""), "\"say \\\"hi\\\"\"");
+    }
+}
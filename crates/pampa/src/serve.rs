@@ -0,0 +1,403 @@
+/*
+ * serve.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `pampa serve --jsonrpc`: answer conversion requests over stdio.
+//!
+//! Editors and the preview server convert documents many times; starting
+//! pampa for each costs more than the conversion. The server reads JSON-RPC
+//! 2.0 requests from stdin, one message per line, and writes one response
+//! line per request to stdout, until stdin closes or a `shutdown`
+//! request:
+//!
+//! - `initialize`: the protocol and server versions, and the methods
+//! - `parse`: text to the JSON AST, with its diagnostics
+//! - `write`: a JSON AST to a format
+//! - `transform`: crossrefs, citations, sanitizing and filters on a JSON AST
+//! - `format`: qmd in canonical form, as `pampa fmt` writes it
+//!
+//! The methods, their params and results are described by
+//! `resources/serve.schema.json`, from which clients generate their
+//! bindings; [`PROTOCOL_VERSION`] changes when the protocol does, and a
+//! client asking `initialize` for another version is refused.
+//!
+//! Options given before `serve` apply to every request: writer options,
+//! `--template`, `-M` metadata, `--sanitize`. What each request converts
+//! is set by its params. Templates and the reference doc are loaded once,
+//! included files are parsed again only when they change, and the last
+//! parse of each file is kept, so a file parsed again unchanged is not read
+//! again (unless includes are resolved, which may have changed). Lua
+//! filters given to `transform` must not print to stdout, which carries
+//! the responses.
+
+use super::{Args, ConversionFailed, Messages, Resources, convert, resolve_formats};
+use crate::transforms::IncludeCache;
+use serde_json::{Value, json};
+use std::collections::HashMap;
+use std::io::{BufRead, Write};
+
+/// The version of the protocol spoken
+pub const PROTOCOL_VERSION: u32 = 1;
+
+/// The methods the server answers
+pub const METHODS: &[&str] = &[
+    "initialize",
+    "parse",
+    "write",
+    "transform",
+    "format",
+    "shutdown",
+];
+
+// JSON-RPC 2.0 error codes, and those of the protocol (-32000 and up)
+const PARSE_ERROR: i64 = -32700;
+const INVALID_REQUEST: i64 = -32600;
+const METHOD_NOT_FOUND: i64 = -32601;
+const INVALID_PARAMS: i64 = -32602;
+const CONVERSION_FAILED: i64 = -32000;
+const UNSUPPORTED_PROTOCOL_VERSION: i64 = -32001;
+
+/// Serve requests until stdin closes or a `shutdown` request. Returns the
+/// exit code: 0, or 2 when stdin or stdout fail.
+pub fn run(args: &Args, resources: &Resources) -> i32 {
+    let mut server = Server::new(args, resources);
+    let mut stdout = std::io::stdout().lock();
+    for line in std::io::stdin().lock().lines() {
+        let Ok(line) = line else {
+            return 2;
+        };
+        if line.trim().is_empty() {
+            continue;
+        }
+        if let Some(response) = server.handle(&line) {
+            let written = writeln!(stdout, "{}", response).and_then(|_| stdout.flush());
+            if written.is_err() {
+                return 2;
+            }
+        }
+        if server.shut_down {
+            break;
+        }
+    }
+    0
+}
+
+/// An error response
+#[derive(Debug)]
+struct RpcError {
+    code: i64,
+    message: String,
+    data: Option<Value>,
+}
+
+impl RpcError {
+    fn new(code: i64, message: impl Into<String>) -> Self {
+        RpcError {
+            code,
+            message: message.into(),
+            data: None,
+        }
+    }
+
+    fn invalid_params(message: impl Into<String>) -> Self {
+        RpcError::new(INVALID_PARAMS, message)
+    }
+
+    fn to_json(&self) -> Value {
+        let mut error = json!({"code": self.code, "message": self.message});
+        if let Some(data) = &self.data {
+            error["data"] = data.clone();
+        }
+        error
+    }
+}
+
+/// The last parse of a file
+struct CachedParse {
+    from: String,
+    text: String,
+    result: Value,
+}
+
+struct Server<'a> {
+    args: &'a Args,
+    resources: &'a Resources,
+    include_cache: IncludeCache,
+    parses: HashMap<String, CachedParse>,
+    shut_down: bool,
+}
+
+impl<'a> Server<'a> {
+    fn new(args: &'a Args, resources: &'a Resources) -> Self {
+        Server {
+            args,
+            resources,
+            include_cache: IncludeCache::new(),
+            parses: HashMap::new(),
+            shut_down: false,
+        }
+    }
+
+    /// The response to a message, `None` for a notification
+    fn handle(&mut self, line: &str) -> Option<Value> {
+        let message: Value = match serde_json::from_str(line) {
+            Ok(message) => message,
+            Err(e) => {
+                let error = RpcError::new(PARSE_ERROR, format!("Invalid JSON: {}", e));
+                return Some(json!({"jsonrpc": "2.0", "id": null, "error": error.to_json()}));
+            }
+        };
+        let (id, result) = match message.get("method").and_then(Value::as_str) {
+            Some(method) if message["jsonrpc"] == "2.0" => {
+                let params = message.get("params").cloned().unwrap_or(json!({}));
+                // Notifications are handled, but not answered
+                let id = message.get("id").cloned();
+                (id?, self.dispatch(method, &params))
+            }
+            _ => (
+                message.get("id").cloned().unwrap_or(Value::Null),
+                Err(RpcError::new(
+                    INVALID_REQUEST,
+                    "A request needs \"jsonrpc\": \"2.0\" and a method",
+                )),
+            ),
+        };
+        Some(match result {
+            Ok(result) => json!({"jsonrpc": "2.0", "id": id, "result": result}),
+            Err(error) => json!({"jsonrpc": "2.0", "id": id, "error": error.to_json()}),
+        })
+    }
+
+    fn dispatch(&mut self, method: &str, params: &Value) -> Result<Value, RpcError> {
+        match method {
+            "initialize" => initialize(params),
+            "parse" => self.parse(params),
+            "write" => self.write(params),
+            "transform" => self.transform(params),
+            "format" => self.format(params),
+            "shutdown" => {
+                self.shut_down = true;
+                Ok(Value::Null)
+            }
+            _ => Err(RpcError::new(
+                METHOD_NOT_FOUND,
+                format!("Unknown method '{}'", method),
+            )),
+        }
+    }
+
+    fn parse(&mut self, params: &Value) -> Result<Value, RpcError> {
+        let text = string_param(params, "text")?;
+        let filename = optional_string_param(params, "filename")?.unwrap_or("<stdin>");
+        let from = optional_string_param(params, "from")?.unwrap_or("qmd");
+        if let Some(cached) = self.parses.get(filename)
+            && cached.from == from
+            && cached.text == text
+        {
+            return Ok(cached.result.clone());
+        }
+
+        let args = self.request_args(from, "json")?;
+        let (output, diagnostics) = self.convert(&args, filename, text)?;
+        let result = json!({"ast": json_output(&output)?, "diagnostics": diagnostics});
+        if args.resolve_includes {
+            return Ok(result);
+        }
+        self.parses.insert(
+            filename.to_string(),
+            CachedParse {
+                from: from.to_string(),
+                text: text.to_string(),
+                result: result.clone(),
+            },
+        );
+        Ok(result)
+    }
+
+    fn write(&mut self, params: &Value) -> Result<Value, RpcError> {
+        let ast = ast_param(params)?;
+        let to = string_param(params, "to")?;
+        let filename = optional_string_param(params, "filename")?.unwrap_or("<stdin>");
+        let args = self.request_args("json", to)?;
+        if matches!(args.to.as_str(), "docx" | "epub") {
+            return Err(RpcError::invalid_params(format!(
+                "{} output is binary and can't be written in a response",
+                args.to
+            )));
+        }
+        let (output, diagnostics) = self.convert(&args, filename, &ast.to_string())?;
+        let output = String::from_utf8(output)
+            .map_err(|_| RpcError::invalid_params(format!("{} output is not text", to)))?;
+        Ok(json!({"output": output, "diagnostics": diagnostics}))
+    }
+
+    fn transform(&mut self, params: &Value) -> Result<Value, RpcError> {
+        let ast = ast_param(params)?;
+        let filename = optional_string_param(params, "filename")?.unwrap_or("<stdin>");
+        let mut args = self.request_args("json", "json")?;
+        args.crossref = bool_param(params, "crossref")?;
+        args.citeproc = bool_param(params, "citeproc")?;
+        args.sanitize |= bool_param(params, "sanitize")?;
+        args.filters = match params.get("filters") {
+            None => Vec::new(),
+            Some(Value::Array(filters)) => filters
+                .iter()
+                .map(|filter| filter.as_str().map(str::to_string))
+                .collect::<Option<_>>()
+                .ok_or_else(|| RpcError::invalid_params("'filters' must be strings"))?,
+            Some(_) => return Err(RpcError::invalid_params("'filters' must be a list")),
+        };
+        let (output, diagnostics) = self.convert(&args, filename, &ast.to_string())?;
+        Ok(json!({"ast": json_output(&output)?, "diagnostics": diagnostics}))
+    }
+
+    fn format(&mut self, params: &Value) -> Result<Value, RpcError> {
+        let text = string_param(params, "text")?;
+        let filename = optional_string_param(params, "filename")?.unwrap_or("<stdin>");
+        let args = self.request_args("qmd", "qmd")?;
+        let config = crate::fmt::config(&args);
+        let (formatted, diagnostics) = collect_messages(|messages| {
+            crate::fmt::format(&args, &config, filename, text, messages)
+        });
+        match formatted {
+            Ok(formatted) => Ok(json!({
+                "text": formatted,
+                "changed": formatted != text,
+                "diagnostics": diagnostics,
+            })),
+            Err(ConversionFailed) => Err(conversion_failed(diagnostics)),
+        }
+    }
+
+    /// The options of the server with the formats of a request, and no
+    /// transforms: those are the business of `transform`
+    fn request_args(&self, from: &str, to: &str) -> Result<Args, RpcError> {
+        let mut args = self.args.clone();
+        args.from = from.to_string();
+        args.to = to.to_string();
+        args.json_errors = false;
+        args.filters = Vec::new();
+        args.citeproc = false;
+        args.crossref = false;
+        resolve_formats(&mut args).map_err(RpcError::invalid_params)?;
+        Ok(args)
+    }
+
+    /// Convert `input` with `args`, returning the output and the
+    /// diagnostics
+    fn convert(
+        &mut self,
+        args: &Args,
+        filename: &str,
+        input: &str,
+    ) -> Result<(Vec<u8>, Vec<Value>), RpcError> {
+        let resources = self.resources;
+        let include_cache = &mut self.include_cache;
+        let (output, diagnostics) = collect_messages(|messages| {
+            let mut input = input.to_string();
+            super::ensure_final_newline(&mut input, filename, messages);
+            convert(
+                args,
+                resources,
+                filename,
+                &input,
+                include_cache,
+                &mut std::io::sink(),
+                messages,
+                &mut crate::trace::Tracer::disabled(),
+            )
+        });
+        match output {
+            Ok(output) => Ok((output, diagnostics)),
+            Err(ConversionFailed) => Err(conversion_failed(diagnostics)),
+        }
+    }
+}
+
+/// Run `f` with messages that are collected rather than written, and
+/// return its result with them
+fn collect_messages<T>(f: impl FnOnce(&mut Messages) -> T) -> (T, Vec<Value>) {
+    let mut stdout = std::io::sink();
+    let mut stderr = std::io::sink();
+    let mut messages = Messages {
+        json_errors: false,
+        collected: Some(Vec::new()),
+        stdout: &mut stdout,
+        stderr: &mut stderr,
+    };
+    let result = f(&mut messages);
+    (result, messages.collected.take().unwrap_or_default())
+}
+
+fn conversion_failed(diagnostics: Vec<Value>) -> RpcError {
+    RpcError {
+        code: CONVERSION_FAILED,
+        message: "The conversion failed".to_string(),
+        data: Some(json!({"diagnostics": diagnostics})),
+    }
+}
+
+fn initialize(params: &Value) -> Result<Value, RpcError> {
+    match params.get("protocolVersion") {
+        None => {}
+        Some(version) if version.as_u64() == Some(u64::from(PROTOCOL_VERSION)) => {}
+        Some(version) => {
+            return Err(RpcError {
+                code: UNSUPPORTED_PROTOCOL_VERSION,
+                message: format!(
+                    "Protocol version {} is not supported; this server speaks {}",
+                    version, PROTOCOL_VERSION
+                ),
+                data: Some(json!({"protocolVersion": PROTOCOL_VERSION})),
+            });
+        }
+    }
+    Ok(json!({
+        "protocolVersion": PROTOCOL_VERSION,
+        "serverVersion": env!("CARGO_PKG_VERSION"),
+        "methods": METHODS,
+    }))
+}
+
+fn json_output(output: &[u8]) -> Result<Value, RpcError> {
+    serde_json::from_slice(output).map_err(|e| RpcError {
+        code: CONVERSION_FAILED,
+        message: format!("The JSON writer wrote invalid JSON: {}", e),
+        data: None,
+    })
+}
+
+fn ast_param(params: &Value) -> Result<&Value, RpcError> {
+    params
+        .get("ast")
+        .filter(|ast| ast.is_object())
+        .ok_or_else(|| RpcError::invalid_params("'ast' must be a JSON AST object"))
+}
+
+fn string_param<'p>(params: &'p Value, name: &str) -> Result<&'p str, RpcError> {
+    optional_string_param(params, name)?
+        .ok_or_else(|| RpcError::invalid_params(format!("'{}' is required", name)))
+}
+
+fn optional_string_param<'p>(params: &'p Value, name: &str) -> Result<Option<&'p str>, RpcError> {
+    match params.get(name) {
+        None | Some(Value::Null) => Ok(None),
+        Some(Value::String(value)) => Ok(Some(value)),
+        Some(_) => Err(RpcError::invalid_params(format!(
+            "'{}' must be a string",
+            name
+        ))),
+    }
+}
+
+fn bool_param(params: &Value, name: &str) -> Result<bool, RpcError> {
+    match params.get(name) {
+        None | Some(Value::Null) => Ok(false),
+        Some(Value::Bool(value)) => Ok(*value),
+        Some(_) => Err(RpcError::invalid_params(format!(
+            "'{}' must be a boolean",
+            name
+        ))),
+    }
+}
@@ -11,7 +11,7 @@
 use crate::template::bundle::TemplateBundle;
 
 /// List of available built-in template names.
-pub const BUILTIN_TEMPLATE_NAMES: &[&str] = &["html", "plain"];
+pub const BUILTIN_TEMPLATE_NAMES: &[&str] = &["html", "latex", "plain", "revealjs", "typst"];
 
 /// Get a built-in template bundle by name.
 ///
@@ -19,7 +19,10 @@ pub const BUILTIN_TEMPLATE_NAMES: &[&str] = &["html", "plain"];
 pub fn get_builtin_template(name: &str) -> Option<TemplateBundle> {
     match name {
         "html" => Some(html_bundle()),
+        "latex" => Some(latex_bundle()),
         "plain" => Some(plain_bundle()),
+        "revealjs" => Some(revealjs_bundle()),
+        "typst" => Some(typst_bundle()),
         _ => None,
     }
 }
@@ -38,6 +41,29 @@ fn html_bundle() -> TemplateBundle {
         .with_partial("styles.citations.html", STYLES_CITATIONS_HTML)
 }
 
+/// Create the latex template bundle.
+///
+/// Based on Pandoc's default.latex template, trimmed to the packages the
+/// LaTeX writer relies on.
+fn latex_bundle() -> TemplateBundle {
+    TemplateBundle::new(LATEX_TEMPLATE)
+}
+
+/// Create the typst template bundle.
+///
+/// Based on Pandoc's default.typst template.
+fn typst_bundle() -> TemplateBundle {
+    TemplateBundle::new(TYPST_TEMPLATE)
+}
+
+/// Create the revealjs template bundle.
+///
+/// Based on Pandoc's default.revealjs template, loading reveal.js from
+/// `revealjs-url` (a CDN by default).
+fn revealjs_bundle() -> TemplateBundle {
+    TemplateBundle::new(REVEALJS_TEMPLATE)
+}
+
 /// Create the plain template bundle.
 ///
 /// A minimal template that just outputs the body.
@@ -62,6 +88,18 @@ const STYLES_HTML: &str = include_str!("../../resources/templates/html/styles.ht
 const STYLES_CITATIONS_HTML: &str =
     include_str!("../../resources/templates/html/styles.citations.html");
 
+/// LaTeX template based on Pandoc's default.latex.
+/// Loaded from resources/templates/latex/main.tex
+const LATEX_TEMPLATE: &str = include_str!("../../resources/templates/latex/main.tex");
+
+/// Typst template based on Pandoc's default.typst.
+/// Loaded from resources/templates/typst/main.typ
+const TYPST_TEMPLATE: &str = include_str!("../../resources/templates/typst/main.typ");
+
+/// reveal.js template based on Pandoc's default.revealjs.
+/// Loaded from resources/templates/revealjs/main.html
+const REVEALJS_TEMPLATE: &str = include_str!("../../resources/templates/revealjs/main.html");
+
 /// A minimal plain template that just outputs the body.
 const PLAIN_TEMPLATE: &str = "$body$\n";
 
@@ -77,6 +115,28 @@ mod tests {
         assert!(bundle.partials.contains_key("styles.citations.html"));
     }
 
+    #[test]
+    fn test_get_builtin_template_latex() {
+        let bundle = get_builtin_template("latex").unwrap();
+        assert!(bundle.main.contains("\\documentclass"));
+        assert!(bundle.main.contains("$body$"));
+        assert!(bundle.partials.is_empty());
+    }
+
+    #[test]
+    fn test_get_builtin_template_typst() {
+        let bundle = get_builtin_template("typst").unwrap();
+        assert!(bundle.main.contains("#set page("));
+        assert!(bundle.main.contains("$body$"));
+    }
+
+    #[test]
+    fn test_get_builtin_template_revealjs() {
+        let bundle = get_builtin_template("revealjs").unwrap();
+        assert!(bundle.main.contains("Reveal.initialize("));
+        assert!(bundle.main.contains("$body$"));
+    }
+
     #[test]
     fn test_get_builtin_template_plain() {
         let bundle = get_builtin_template("plain").unwrap();
@@ -92,7 +152,10 @@ mod tests {
     #[test]
     fn test_is_builtin_template() {
         assert!(is_builtin_template("html"));
+        assert!(is_builtin_template("latex"));
         assert!(is_builtin_template("plain"));
+        assert!(is_builtin_template("revealjs"));
+        assert!(is_builtin_template("typst"));
         assert!(!is_builtin_template("unknown"));
     }
 
@@ -108,6 +171,28 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_latex_template_compiles() {
+        let bundle = latex_bundle();
+        let result = bundle.compile("latex.tex");
+        assert!(
+            result.is_ok(),
+            "latex template should compile: {:?}",
+            result.err()
+        );
+    }
+
+    #[test]
+    fn test_typst_template_compiles() {
+        let bundle = typst_bundle();
+        let result = bundle.compile("typst.typ");
+        assert!(
+            result.is_ok(),
+            "typst template should compile: {:?}",
+            result.err()
+        );
+    }
+
     #[test]
     fn test_plain_template_compiles() {
         let bundle = plain_bundle();
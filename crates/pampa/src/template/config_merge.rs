@@ -268,6 +268,83 @@ pub fn merged_metadata_to_context(
     (template_ctx, all_diagnostics)
 }
 
+// =============================================================================
+// Command-Line Variables
+// =============================================================================
+
+/// Build a metadata layer from `--variable KEY[=VALUE]` arguments.
+///
+/// Like Pandoc's `-V`, values are literal strings that are inserted into the
+/// template verbatim (they are not parsed as markdown), and a bare `KEY` sets
+/// the variable to `true`. Repeating a key collects its values into a list.
+///
+/// Every value is marked `!prefer` so that it replaces document metadata of
+/// the same name instead of merging with it.
+pub fn variables_to_config(variables: &[String]) -> ConfigValue {
+    let mut entries: Vec<ConfigMapEntry> = Vec::new();
+
+    for variable in variables {
+        let (key, value) = match variable.split_once('=') {
+            Some((key, value)) => (key, ConfigValue::new_string(value, SourceInfo::default())),
+            None => (
+                variable.as_str(),
+                ConfigValue::new_bool(true, SourceInfo::default()),
+            ),
+        };
+
+        match entries.iter_mut().find(|e| e.key == key) {
+            Some(entry) => match &mut entry.value.value {
+                ConfigValueKind::Array(items) => items.push(value),
+                _ => {
+                    let first = entry.value.clone().with_merge_op(MergeOp::Concat);
+                    entry.value = ConfigValue::new_array(vec![first, value], SourceInfo::default())
+                        .with_merge_op(MergeOp::Prefer);
+                }
+            },
+            None => entries.push(ConfigMapEntry {
+                key: key.to_string(),
+                key_source: SourceInfo::default(),
+                value: value.with_merge_op(MergeOp::Prefer),
+            }),
+        }
+    }
+
+    ConfigValue::new_map(entries, SourceInfo::default())
+}
+
+/// Layer `--variable` arguments over document metadata.
+///
+/// Templates remain pure functions of metadata plus body: variables are just
+/// the highest-priority metadata layer. See [`variables_to_config`].
+///
+/// # Returns
+///
+/// A tuple of (merged metadata, diagnostics). If merging fails, the document
+/// metadata is returned unchanged along with an error diagnostic.
+pub fn apply_variables(
+    meta: &ConfigValue,
+    variables: &[String],
+) -> (ConfigValue, Vec<DiagnosticMessage>) {
+    if variables.is_empty() {
+        return (meta.clone(), Vec::new());
+    }
+
+    let layer = variables_to_config(variables);
+    let merged = MergedConfig::new(vec![meta, &layer]);
+    match merged.materialize() {
+        Ok(config) => (config, Vec::new()),
+        Err(e) => (
+            meta.clone(),
+            vec![
+                quarto_error_reporting::DiagnosticMessageBuilder::error("Config merge failed")
+                    .with_code("Q-1-30")
+                    .problem(format!("Failed to apply template variables: {:?}", e))
+                    .build(),
+            ],
+        ),
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -518,4 +595,72 @@ mod tests {
         );
         assert_eq!(ctx.get("enabled"), Some(&TemplateValue::Bool(true)));
     }
+
+    #[test]
+    fn test_variables_to_config() {
+        let variables = vec![
+            "documentclass=report".to_string(),
+            "toc".to_string(),
+            "classoption=twocolumn".to_string(),
+            "classoption=landscape".to_string(),
+            "equation=a=b".to_string(),
+        ];
+        let config = variables_to_config(&variables);
+
+        assert!(
+            config
+                .get("documentclass")
+                .unwrap()
+                .is_string_value("report")
+        );
+        assert_eq!(config.get("toc").unwrap().as_bool(), Some(true));
+        assert!(config.get("equation").unwrap().is_string_value("a=b"));
+        match &config.get("classoption").unwrap().value {
+            ConfigValueKind::Array(items) => {
+                assert_eq!(items.len(), 2);
+                assert!(items[0].is_string_value("twocolumn"));
+                assert!(items[1].is_string_value("landscape"));
+            }
+            other => panic!("Expected array, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_variables_override_document_metadata() {
+        let meta = ConfigValue::new_map(
+            vec![
+                ConfigMapEntry {
+                    key: "title".to_string(),
+                    key_source: dummy_source_info(),
+                    value: ConfigValue::new_string("Doc", dummy_source_info()),
+                },
+                ConfigMapEntry {
+                    key: "classoption".to_string(),
+                    key_source: dummy_source_info(),
+                    value: ConfigValue::new_array(
+                        vec![ConfigValue::new_string("a4paper", dummy_source_info())],
+                        dummy_source_info(),
+                    ),
+                },
+            ],
+            dummy_source_info(),
+        );
+        let variables = vec![
+            "title=Override".to_string(),
+            "classoption=twocolumn".to_string(),
+        ];
+
+        let (merged, diags) = apply_variables(&meta, &variables);
+        assert!(diags.is_empty());
+
+        let (ctx, _diags) = config_to_template_context(&merged, MetaWriter::Latex);
+        assert_eq!(
+            ctx.get("title"),
+            Some(&TemplateValue::String("Override".to_string()))
+        );
+        assert_eq!(
+            ctx.get("classoption"),
+            Some(&TemplateValue::String("twocolumn".to_string()))
+        );
+    }
 }
@@ -11,7 +11,7 @@
 
 use crate::pandoc::block::Block;
 use crate::pandoc::inline::Inlines;
-use crate::writers::{html, plaintext};
+use crate::writers::{html, latex, plaintext, typst};
 use quarto_doctemplate::{TemplateContext, TemplateValue};
 use quarto_error_reporting::DiagnosticMessage;
 use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
@@ -29,6 +29,10 @@ pub enum MetaWriter {
     Html,
     /// Render as plain text (no markup).
     Plaintext,
+    /// Render as LaTeX.
+    Latex,
+    /// Render as Typst.
+    Typst,
 }
 
 impl MetaWriter {
@@ -49,6 +53,32 @@ impl MetaWriter {
                 (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
             }
             MetaWriter::Plaintext => plaintext::inlines_to_string(inlines),
+            MetaWriter::Latex => {
+                let mut buf = Vec::new();
+                let result = latex::write_inlines_to(inlines, &mut buf);
+                let diagnostics = if let Err(e) = result {
+                    vec![DiagnosticMessage::error(format!(
+                        "Failed to render inlines as LaTeX: {}",
+                        e
+                    ))]
+                } else {
+                    vec![]
+                };
+                (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
+            }
+            MetaWriter::Typst => {
+                let mut buf = Vec::new();
+                let result = typst::write_inlines_to(inlines, &mut buf);
+                let diagnostics = if let Err(e) = result {
+                    vec![DiagnosticMessage::error(format!(
+                        "Failed to render inlines as Typst: {}",
+                        e
+                    ))]
+                } else {
+                    vec![]
+                };
+                (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
+            }
         }
     }
 
@@ -69,6 +99,32 @@ impl MetaWriter {
                 (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
             }
             MetaWriter::Plaintext => plaintext::blocks_to_string(blocks),
+            MetaWriter::Latex => {
+                let mut buf = Vec::new();
+                let result = latex::write_blocks_to(blocks, &mut buf);
+                let diagnostics = if let Err(e) = result {
+                    vec![DiagnosticMessage::error(format!(
+                        "Failed to render blocks as LaTeX: {}",
+                        e
+                    ))]
+                } else {
+                    vec![]
+                };
+                (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
+            }
+            MetaWriter::Typst => {
+                let mut buf = Vec::new();
+                let result = typst::write_blocks_to(blocks, &mut buf);
+                let diagnostics = if let Err(e) = result {
+                    vec![DiagnosticMessage::error(format!(
+                        "Failed to render blocks as Typst: {}",
+                        e
+                    ))]
+                } else {
+                    vec![]
+                };
+                (String::from_utf8_lossy(&buf).into_owned(), diagnostics)
+            }
         }
     }
 }
@@ -8,13 +8,14 @@
 //! This module provides high-level functions for rendering Pandoc documents
 //! using templates.
 
+use crate::highlight::HighlightConfig;
 use crate::pandoc::Pandoc;
 use crate::pandoc::ast_context::ASTContext;
 use crate::template::bundle::{BundleError, TemplateBundle};
 use crate::template::config_merge::merged_metadata_to_context;
 use crate::template::context::MetaWriter;
-use crate::writers::{html, plaintext};
-use quarto_doctemplate::{PartialResolver, Template, TemplateError};
+use crate::writers::{html, latex, plaintext, revealjs, typst};
+use quarto_doctemplate::{PartialResolver, Template, TemplateError, TemplateValue};
 use quarto_error_reporting::DiagnosticMessage;
 use std::path::Path;
 
@@ -69,6 +70,12 @@ pub enum BodyFormat {
     Html,
     /// Render body as plain text.
     Plaintext,
+    /// Render body as LaTeX.
+    Latex,
+    /// Render body as Typst.
+    Typst,
+    /// Render body as reveal.js slides.
+    Revealjs,
 }
 
 impl BodyFormat {
@@ -79,23 +86,56 @@ impl BodyFormat {
         match self {
             BodyFormat::Html => MetaWriter::Html,
             BodyFormat::Plaintext => MetaWriter::Plaintext,
+            BodyFormat::Latex => MetaWriter::Latex,
+            BodyFormat::Typst => MetaWriter::Typst,
+            BodyFormat::Revealjs => MetaWriter::Html,
         }
     }
 }
 
+/// A rendered document body.
+struct RenderedBody {
+    body: String,
+    diagnostics: Vec<DiagnosticMessage>,
+    /// Template variables the body needs, such as `highlighting-css`
+    variables: Vec<(&'static str, String)>,
+}
+
 /// Render the document body to a string.
 fn render_body(
     pandoc: &Pandoc,
     _context: &ASTContext,
     format: BodyFormat,
-) -> Result<(String, Vec<DiagnosticMessage>), TemplateRenderError> {
+) -> Result<RenderedBody, TemplateRenderError> {
     let mut buf = Vec::new();
     let diagnostics;
+    let mut variables = Vec::new();
+    let highlight = HighlightConfig::from_metadata(&pandoc.meta);
 
     match format {
         BodyFormat::Html => {
-            html::write_blocks_to(&pandoc.blocks, &mut buf)
+            let config = html::HtmlConfig {
+                highlight: highlight.clone(),
+                ..Default::default()
+            };
+            let highlighted = html::write_blocks_with_config(&pandoc.blocks, &mut buf, config)
                 .map_err(|e| TemplateRenderError::BodyRender(e.to_string()))?;
+            if let (true, Some(highlight)) = (highlighted, &highlight) {
+                variables.push(("highlighting-css", highlight.theme.css()));
+            }
+            diagnostics = vec![];
+        }
+        BodyFormat::Revealjs => {
+            let mut config = revealjs::extract_config_from_metadata(&pandoc.meta);
+            config.html = html::HtmlConfig {
+                highlight: highlight.clone(),
+                ..Default::default()
+            };
+            let highlighted = revealjs::write_blocks_with_config(&pandoc.blocks, &mut buf, config)
+                .map_err(|e| TemplateRenderError::BodyRender(e.to_string()))?;
+            if let (true, Some(highlight)) = (highlighted, &highlight) {
+                variables.push(("highlighting-css", highlight.theme.css()));
+            }
             diagnostics = vec![];
         }
         BodyFormat::Plaintext => {
@@ -103,10 +143,30 @@ fn render_body(
             buf = text.into_bytes();
             diagnostics = diags;
         }
+        BodyFormat::Latex => {
+            let config = latex::LatexConfig {
+                highlight: highlight.clone(),
+            };
+            let highlighted = latex::write_blocks_with_config(&pandoc.blocks, &mut buf, config)
+                .map_err(|e| TemplateRenderError::BodyRender(e.to_string()))?;
+            if let (true, Some(highlight)) = (highlighted, &highlight) {
+                variables.push(("highlighting-macros", highlight.theme.latex_macros()));
+            }
+            diagnostics = vec![];
+        }
+        BodyFormat::Typst => {
+            typst::write_blocks_to(&pandoc.blocks, &mut buf)
+                .map_err(|e| TemplateRenderError::BodyRender(e.to_string()))?;
+            diagnostics = vec![];
+        }
     }
 
     let body = String::from_utf8_lossy(&buf).into_owned();
-    Ok((body, diagnostics))
+    Ok(RenderedBody {
+        body,
+        diagnostics,
+        variables,
+    })
 }
 
 /// Render a Pandoc document using a template bundle.
@@ -171,14 +231,18 @@ pub fn render_with_compiled_template<R: PartialResolver>(
     let mut all_diagnostics = Vec::new();
 
     // Render the body
-    let (body, body_diags) = render_body(pandoc, context, body_format)?;
-    all_diagnostics.extend(body_diags);
+    let rendered = render_body(pandoc, context, body_format)?;
+    all_diagnostics.extend(rendered.diagnostics);
 
     // Convert metadata to template context using the merged config system.
     // This merges template defaults (lang, pagetitle) with document metadata.
     let meta_writer = body_format.meta_writer();
-    let (template_ctx, meta_diags) = merged_metadata_to_context(&pandoc.meta, body, meta_writer);
+    let (mut template_ctx, meta_diags) =
+        merged_metadata_to_context(&pandoc.meta, rendered.body, meta_writer);
     all_diagnostics.extend(meta_diags);
+    for (name, value) in rendered.variables {
+        template_ctx.insert(name, TemplateValue::String(value));
+    }
 
     // Render the template
     let (result, template_diags) = template.render_with_diagnostics(&template_ctx);
@@ -0,0 +1,284 @@
+/*
+ * trace.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Render traces: how long each phase of a conversion took.
+//!
+//! With `--trace FILE`, pampa records a span for each phase of the
+//! conversion (reading, each transform, each filter, writing) and writes
+//! them to FILE as JSON. Spans that concern parts of the document carry the
+//! source locations of those blocks, formatted as the HTML writer's
+//! `data-loc` attribute (`file:line:col-line:col`), so a viewer can show
+//! them next to the source: a filter's span lists the blocks it changed.
+//!
+//! The format is described by `resources/trace.schema.json`, which other
+//! tools read traces with; [`TRACE_FORMAT_VERSION`] changes when the format
+//! does.
+//!
+//! `quarto render --trace` writes traces in this format too, with quarto's
+//! render stages, AST transforms and code execution as spans (see
+//! `quarto_core::stage::TraceObserver`).
+//!
+//! Each span is also logged as an info event on the `pampa::timing` target
+//! as it is recorded, which is what `--timing` shows.
+
+use std::time::Instant;
+
+use serde::Serialize;
+
+use crate::ast_diff::{DiffOp, LocatedBlockDiff, diff_documents, locate};
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+
+/// The version of the trace format written
+pub const TRACE_FORMAT_VERSION: u32 = 2;
+
+/// The phase of a conversion a span belongs to
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Phase {
+    /// Reading the input, with the transforms of the reader
+    Read,
+    /// A built-in transform (metadata, crossrefs and so on)
+    Transform,
+    /// Running code cells with an execution engine (`quarto render`
+    /// only: pampa doesn't execute cells)
+    Execute,
+    /// A filter given with `--filter` or `--citeproc`
+    Filter,
+    /// Writing the output, with its template
+    Write,
+}
+
+impl Phase {
+    /// The name of the phase, as in traces
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Phase::Read => "read",
+            Phase::Transform => "transform",
+            Phase::Execute => "execute",
+            Phase::Filter => "filter",
+            Phase::Write => "write",
+        }
+    }
+}
+
+/// One timed part of a conversion
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct TraceSpan {
+    pub phase: Phase,
+    /// What ran, e.g. `qmd` for reading, or the path of a filter
+    pub name: String,
+    /// When the span started, in microseconds since the trace started
+    pub start_us: u64,
+    pub duration_us: u64,
+    /// The source locations of the blocks the span concerns
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub locs: Vec<String>,
+}
+
+/// The trace of one conversion
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Trace {
+    pub version: u32,
+    /// The input file, `<stdin>` when read from stdin
+    pub input: String,
+    pub spans: Vec<TraceSpan>,
+}
+
+/// When a span started (see [`Tracer::record`])
+#[derive(Debug, Clone, Copy)]
+pub struct SpanStart(Instant);
+
+/// Records the spans of a conversion. A disabled tracer records nothing,
+/// so conversions can be traced or not through the same code.
+#[derive(Debug)]
+pub struct Tracer {
+    enabled: bool,
+    start: Instant,
+    spans: Vec<TraceSpan>,
+}
+
+impl Tracer {
+    pub fn new() -> Self {
+        Tracer {
+            enabled: true,
+            start: Instant::now(),
+            spans: Vec::new(),
+        }
+    }
+
+    pub fn disabled() -> Self {
+        Tracer {
+            enabled: false,
+            ..Tracer::new()
+        }
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        self.enabled
+    }
+
+    /// Start a span, to be recorded when it ends.
+    pub fn begin(&self) -> SpanStart {
+        SpanStart(Instant::now())
+    }
+
+    /// Record a span that started at `start` and ends now.
+    pub fn record(&mut self, start: SpanStart, phase: Phase, name: &str, locs: Vec<String>) {
+        self.record_with(start, phase, name, || locs);
+    }
+
+    /// Record a span that started at `start` and ends now, with locations
+    /// that take time to find: `locs` is only called when tracing, and
+    /// after the span has ended.
+    pub fn record_with(
+        &mut self,
+        start: SpanStart,
+        phase: Phase,
+        name: &str,
+        locs: impl FnOnce() -> Vec<String>,
+    ) {
+        if !self.enabled {
+            return;
+        }
+        let duration_us = micros(start.0.elapsed());
+        tracing::info!(
+            target: "pampa::timing",
+            phase = phase.as_str(),
+            name,
+            duration_us,
+            "{} {} took {} µs",
+            phase.as_str(),
+            name,
+            duration_us
+        );
+        self.spans.push(TraceSpan {
+            phase,
+            name: name.to_string(),
+            start_us: micros(start.0.duration_since(self.start)),
+            duration_us,
+            locs: locs(),
+        });
+    }
+
+    /// The recorded spans, as the trace of converting `input`.
+    pub fn finish(self, input: &str) -> Trace {
+        Trace {
+            version: TRACE_FORMAT_VERSION,
+            input: input.to_string(),
+            spans: self.spans,
+        }
+    }
+}
+
+impl Default for Tracer {
+    fn default() -> Self {
+        Tracer::new()
+    }
+}
+
+fn micros(duration: std::time::Duration) -> u64 {
+    u64::try_from(duration.as_micros()).unwrap_or(u64::MAX)
+}
+
+/// The source locations of the top-level blocks that differ between two
+/// versions of a document: where they were for changed and deleted blocks,
+/// and where they come from for inserted ones, when known.
+pub fn changed_block_locs(
+    before: &Pandoc,
+    before_context: &ASTContext,
+    after: &Pandoc,
+    after_context: &ASTContext,
+) -> Vec<String> {
+    let diff = diff_documents(before, after);
+    locate(&diff, before, before_context, after, after_context)
+        .blocks
+        .into_iter()
+        .filter(|block| block.op != DiffOp::Unchanged)
+        .filter_map(|LocatedBlockDiff { before, after, .. }| before.or(after))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn span(trace: &Trace, index: usize) -> serde_json::Value {
+        serde_json::to_value(&trace.spans[index]).unwrap()
+    }
+
+    #[test]
+    fn test_records_spans_in_order() {
+        let mut tracer = Tracer::new();
+        let read = tracer.begin();
+        tracer.record(read, Phase::Read, "qmd", Vec::new());
+        let filter = tracer.begin();
+        tracer.record(
+            filter,
+            Phase::Filter,
+            "filter.lua",
+            vec!["doc.qmd:3:1-4:1".to_string()],
+        );
+
+        let trace = tracer.finish("doc.qmd");
+        assert_eq!(trace.version, TRACE_FORMAT_VERSION);
+        assert_eq!(trace.input, "doc.qmd");
+        assert_eq!(trace.spans.len(), 2);
+        assert_eq!(trace.spans[0].phase, Phase::Read);
+        assert!(trace.spans[1].start_us >= trace.spans[0].start_us);
+        assert_eq!(span(&trace, 1)["phase"], "filter");
+        assert_eq!(span(&trace, 1)["locs"][0], "doc.qmd:3:1-4:1");
+        // Spans without locations leave them out
+        assert!(span(&trace, 0).get("locs").is_none());
+    }
+
+    #[test]
+    fn test_disabled_tracer_records_nothing() {
+        let mut tracer = Tracer::disabled();
+        let start = tracer.begin();
+        tracer.record(start, Phase::Write, "html", Vec::new());
+        assert!(tracer.finish("<stdin>").spans.is_empty());
+    }
+
+    /// The fields written are those `resources/trace.schema.json` describes.
+    #[test]
+    fn test_matches_schema() {
+        let schema: serde_json::Value =
+            serde_json::from_str(include_str!("../resources/trace.schema.json")).unwrap();
+        let keys = |value: &serde_json::Value| {
+            let mut keys: Vec<String> = value.as_object().unwrap().keys().cloned().collect();
+            keys.sort();
+            keys
+        };
+
+        let mut tracer = Tracer::new();
+        let start = tracer.begin();
+        tracer.record(start, Phase::Filter, "f.lua", vec!["a:1:1-2:1".to_string()]);
+        let trace = serde_json::to_value(tracer.finish("a")).unwrap();
+
+        assert_eq!(
+            schema["properties"]["version"]["const"],
+            TRACE_FORMAT_VERSION
+        );
+        assert_eq!(keys(&trace), keys(&schema["properties"]));
+        assert_eq!(
+            keys(&trace["spans"][0]),
+            keys(&schema["$defs"]["span"]["properties"])
+        );
+        let phases = schema["$defs"]["span"]["properties"]["phase"]["enum"]
+            .as_array()
+            .unwrap();
+        for phase in [
+            Phase::Read,
+            Phase::Transform,
+            Phase::Execute,
+            Phase::Filter,
+            Phase::Write,
+        ] {
+            assert!(phases.contains(&serde_json::to_value(phase).unwrap()));
+            assert_eq!(serde_json::to_value(phase).unwrap(), phase.as_str());
+        }
+    }
+}
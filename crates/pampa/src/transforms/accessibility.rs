@@ -0,0 +1,359 @@
+/*
+ * transforms/accessibility.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Accessibility audit: find what makes a document hard to read with
+ * assistive technology.
+ */
+
+//! Accessibility audit of a document, before it is written.
+//!
+//! [`audit_accessibility`] looks through the blocks of a document, leaving
+//! it as it is, for what screen readers and other assistive technology
+//! can't present well:
+//!
+//! - Images without alternative text: no description in `![...]`, and no
+//!   `alt` or `fig-alt` attribute. An image with `alt=""` is taken to be
+//!   decorative.
+//! - Headings more than one level deeper than the heading before them, as
+//!   a `###` straight after a `#`. The first heading may be at any level.
+//! - Tables without a header row, or with one whose cells are all empty
+//! - Links whose text says nothing of where they go, as "click here" or
+//!   "read more", and links without text. An `aria-label` attribute names
+//!   a link in place of its text.
+//!
+//! Everything found is listed in the [`AccessibilityReport`], with where it
+//! is in the source.
+
+use crate::pandoc::Pandoc;
+use crate::pandoc::block::Block;
+use crate::pandoc::inline::{Inline, Inlines};
+use crate::pandoc::table::Table;
+use crate::utils::autoid::plain_text;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::walk::{Control, Order, Visitor, Walkable};
+use quarto_source_map::SourceInfo;
+
+/// Link text that says nothing of where a link goes, after it is
+/// lowercased and trimmed of punctuation
+const VAGUE_LINK_TEXT: &[&str] = &[
+    "click",
+    "click here",
+    "go",
+    "here",
+    "learn more",
+    "link",
+    "more",
+    "more info",
+    "read more",
+    "see more",
+    "this",
+    "this link",
+    "this page",
+];
+
+/// What [`audit_accessibility`] found
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Finding {
+    /// An image of this URL without alternative text
+    MissingAltText(String),
+    /// A heading at level `to` after one at level `from`
+    SkippedHeadingLevel { from: usize, to: usize },
+    /// A table without a header row
+    TableWithoutHeader,
+    /// A link with this text, which says nothing of where it goes; empty
+    /// for a link without text
+    VagueLinkText(String),
+}
+
+impl Finding {
+    fn code(&self) -> &'static str {
+        match self {
+            Finding::MissingAltText(_) => "Q-2-46",
+            Finding::SkippedHeadingLevel { .. } => "Q-2-47",
+            Finding::TableWithoutHeader => "Q-2-48",
+            Finding::VagueLinkText(_) => "Q-2-49",
+        }
+    }
+
+    fn title(&self) -> &'static str {
+        match self {
+            Finding::MissingAltText(_) => "Image Without Alternative Text",
+            Finding::SkippedHeadingLevel { .. } => "Heading Level Skipped",
+            Finding::TableWithoutHeader => "Table Without Header",
+            Finding::VagueLinkText(_) => "Link Text Not Descriptive",
+        }
+    }
+
+    fn describe(&self) -> String {
+        match self {
+            Finding::MissingAltText(url) => {
+                format!("The image `{}` has no alternative text", url)
+            }
+            Finding::SkippedHeadingLevel { from, to } => {
+                format!("A level {} heading follows a level {} heading", to, from)
+            }
+            Finding::TableWithoutHeader => "The table has no header row".to_string(),
+            Finding::VagueLinkText(text) if text.is_empty() => "The link has no text".to_string(),
+            Finding::VagueLinkText(text) => {
+                format!("The link text `{}` doesn't say where the link goes", text)
+            }
+        }
+    }
+
+    fn hint(&self) -> &'static str {
+        match self {
+            Finding::MissingAltText(_) => {
+                "Describe the image in `![...]` or with `fig-alt`, or mark a decorative image with `alt=\"\"`"
+            }
+            Finding::SkippedHeadingLevel { .. } => {
+                "Screen reader users navigate by heading level; go down one level at a time"
+            }
+            Finding::TableWithoutHeader => {
+                "Give the table a header row, so that its columns can be told apart"
+            }
+            Finding::VagueLinkText(_) => {
+                "Use text that makes sense out of context, such as the title of what is linked"
+            }
+        }
+    }
+}
+
+/// Everything [`audit_accessibility`] found in a document, in document
+/// order
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct AccessibilityReport {
+    pub findings: Vec<(Finding, SourceInfo)>,
+}
+
+impl AccessibilityReport {
+    pub fn is_empty(&self) -> bool {
+        self.findings.is_empty()
+    }
+
+    /// A warning for each finding
+    pub fn diagnostics(&self) -> Vec<DiagnosticMessage> {
+        self.findings
+            .iter()
+            .map(|(finding, source_info)| {
+                DiagnosticMessageBuilder::warning(finding.title())
+                    .with_code(finding.code())
+                    .with_location(source_info.clone())
+                    .problem(finding.describe())
+                    .add_hint(finding.hint())
+                    .build()
+            })
+            .collect()
+    }
+
+    fn add(&mut self, finding: Finding, source_info: &SourceInfo) {
+        self.findings.push((finding, source_info.clone()));
+    }
+}
+
+/// Audit the blocks of `doc` for accessibility, and report what was found.
+pub fn audit_accessibility(doc: &Pandoc) -> AccessibilityReport {
+    let mut auditor = Auditor {
+        report: AccessibilityReport::default(),
+        last_heading_level: None,
+    };
+    let _ = doc.blocks.walk(Order::TopDown, &mut auditor);
+    auditor.report
+}
+
+struct Auditor {
+    report: AccessibilityReport,
+    /// Level of the heading before the current block
+    last_heading_level: Option<usize>,
+}
+
+impl Visitor for Auditor {
+    fn visit_block(&mut self, block: &Block) -> Control {
+        match block {
+            Block::Header(header) => {
+                if let Some(from) = self.last_heading_level
+                    && header.level > from + 1
+                {
+                    self.report.add(
+                        Finding::SkippedHeadingLevel {
+                            from,
+                            to: header.level,
+                        },
+                        &header.source_info,
+                    );
+                }
+                self.last_heading_level = Some(header.level);
+            }
+            Block::Table(table) if !has_header(table) => {
+                self.report
+                    .add(Finding::TableWithoutHeader, &table.source_info);
+            }
+            _ => {}
+        }
+        Control::Continue
+    }
+
+    fn visit_inline(&mut self, inline: &Inline) -> Control {
+        match inline {
+            Inline::Image(image) => {
+                let (_id, _classes, attrs) = &image.attr;
+                let described = !plain_text(&image.content).trim().is_empty()
+                    || attrs.contains_key("alt")
+                    || attrs
+                        .get("fig-alt")
+                        .is_some_and(|alt| !alt.trim().is_empty());
+                if !described {
+                    self.report.add(
+                        Finding::MissingAltText(image.target.0.clone()),
+                        &image.source_info,
+                    );
+                }
+            }
+            Inline::Link(link) => {
+                let (_id, _classes, attrs) = &link.attr;
+                if !attrs.contains_key("aria-label")
+                    && let Some(text) = vague_link_text(&link.content)
+                {
+                    self.report
+                        .add(Finding::VagueLinkText(text), &link.source_info);
+                }
+            }
+            _ => {}
+        }
+        Control::Continue
+    }
+}
+
+/// Whether a table has a header row with something in it. A table without
+/// rows at all has nothing to label.
+fn has_header(table: &Table) -> bool {
+    let has_rows = table.bodies.iter().any(|body| !body.body.is_empty());
+    !has_rows
+        || table
+            .head
+            .rows
+            .iter()
+            .flat_map(|row| &row.cells)
+            .any(|cell| !is_empty(&cell.content))
+}
+
+/// Whether cell content has nothing in it: no blocks, or blocks of no
+/// inlines, as readers give an empty cell
+fn is_empty(blocks: &[Block]) -> bool {
+    blocks.iter().all(|block| match block {
+        Block::Plain(plain) => plain.content.is_empty(),
+        Block::Paragraph(para) => para.content.is_empty(),
+        _ => false,
+    })
+}
+
+/// The text of a link, when it says nothing of where the link goes. A link
+/// around an image is named by the image, which is audited on its own.
+fn vague_link_text(content: &Inlines) -> Option<String> {
+    let text = plain_text(content);
+    let text = text.split_whitespace().collect::<Vec<_>>().join(" ");
+    if text.is_empty() {
+        let has_image = content
+            .iter()
+            .any(|inline| matches!(inline, Inline::Image(_)));
+        return (!has_image).then_some(text);
+    }
+    let normalized = text
+        .trim_matches(|c: char| c.is_ascii_punctuation())
+        .to_lowercase();
+    VAGUE_LINK_TEXT
+        .contains(&normalized.as_str())
+        .then_some(text)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::readers;
+
+    fn findings(input: &str) -> Vec<Finding> {
+        let (doc, _context, _warnings) = readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        audit_accessibility(&doc)
+            .findings
+            .into_iter()
+            .map(|(finding, _)| finding)
+            .collect()
+    }
+
+    #[test]
+    fn test_images_without_alt_text() {
+        assert_eq!(
+            findings(
+                "![](a.png) ![A cat](b.png) ![](c.png){fig-alt=\"A dog\"} ![](d.png){alt=\"\"}\n"
+            ),
+            vec![Finding::MissingAltText("a.png".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_skipped_heading_levels() {
+        assert_eq!(
+            findings("## Start\n\n#### Deep\n\n## Back\n\n### Down\n"),
+            vec![Finding::SkippedHeadingLevel { from: 2, to: 4 }]
+        );
+    }
+
+    #[test]
+    fn test_first_heading_may_be_at_any_level() {
+        assert!(findings("### Start\n\n#### Next\n").is_empty());
+    }
+
+    #[test]
+    fn test_tables_without_header() {
+        let headerless = "|   |   |\n|---|---|\n| 1 | 2 |\n";
+        let with_header = "| a | b |\n|---|---|\n| 1 | 2 |\n";
+        assert_eq!(findings(headerless), vec![Finding::TableWithoutHeader]);
+        assert!(findings(with_header).is_empty());
+    }
+
+    #[test]
+    fn test_vague_link_text() {
+        assert_eq!(
+            findings(
+                "[Click here](a.html). [Read more...](b.html) [The guide](c.html) [](d.html)\n"
+            ),
+            vec![
+                Finding::VagueLinkText("Click here".to_string()),
+                Finding::VagueLinkText("Read more...".to_string()),
+                Finding::VagueLinkText(String::new()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_labelled_and_image_links_are_not_vague() {
+        assert!(
+            findings("[here](a.html){aria-label=\"The guide\"} [![Logo](logo.png)](b.html)\n")
+                .is_empty()
+        );
+    }
+
+    #[test]
+    fn test_diagnostics_have_codes() {
+        let (doc, _context, _warnings) = readers::qmd::read(
+            b"![](a.png)\n",
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        let diagnostics = audit_accessibility(&doc).diagnostics();
+        assert_eq!(diagnostics.len(), 1);
+        assert_eq!(diagnostics[0].code.as_deref(), Some("Q-2-46"));
+    }
+}
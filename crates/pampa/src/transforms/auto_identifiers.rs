@@ -0,0 +1,136 @@
+/*
+ * transforms/auto_identifiers.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Make and remove the identifiers of headings that don't spell one out.
+ */
+
+//! Automatic heading identifiers, for the readers' `auto_identifiers`,
+//! `gfm_auto_identifiers` and `ascii_identifiers` extensions.
+//!
+//! The qmd reader gives every heading without an `#id` one made from its
+//! text, as Pandoc's markdown reader does. Those identifiers have no source
+//! span, unlike written ones, and that is how they are told apart here:
+//! [`remove_auto_identifiers`] keeps only identifiers written in the
+//! source, for `-f qmd-auto_identifiers`, and [`assign_auto_identifiers`]
+//! makes the others again by other rules, or for the CommonMark readers,
+//! which make none.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::Block;
+use crate::utils::autoid::{self, IdentifierOptions};
+use std::collections::HashSet;
+
+/// Remove the identifiers of headings that didn't spell one out.
+pub fn remove_auto_identifiers(blocks: Vec<Block>) -> Vec<Block> {
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_header(|mut header, _ctx| {
+            if header.attr.0.is_empty() || header.attr_source.id.is_some() {
+                return FilterReturn::Unchanged(header);
+            }
+            header.attr.0.clear();
+            FilterReturn::FilterResult(vec![Block::Header(header)], true)
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+/// Give every heading that didn't spell out an identifier one made from
+/// its text by `options`. Identifiers are unique: one already used, written
+/// or made, gets `-1`, `-2`, ... added.
+pub fn assign_auto_identifiers(blocks: Vec<Block>, options: IdentifierOptions) -> Vec<Block> {
+    let mut used_ids: HashSet<String> = HashSet::new();
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_header(|mut header, _ctx| {
+            if header.attr_source.id.is_some() {
+                used_ids.insert(header.attr.0.clone());
+                return FilterReturn::Unchanged(header);
+            }
+            let id = autoid::unique_id(&autoid::identifier(&header.content, options), &used_ids);
+            used_ids.insert(id.clone());
+            header.attr.0 = id;
+            FilterReturn::FilterResult(vec![Block::Header(header)], true)
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::autoid::IdentifierStyle;
+
+    fn read(input: &str) -> Vec<Block> {
+        let (doc, _context, _warnings) = crate::readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        doc.blocks
+    }
+
+    fn ids(blocks: &[Block]) -> Vec<String> {
+        blocks
+            .iter()
+            .filter_map(|block| match block {
+                Block::Header(header) => Some(header.attr.0.clone()),
+                _ => None,
+            })
+            .collect()
+    }
+
+    fn header_ids(input: &str) -> Vec<String> {
+        ids(&remove_auto_identifiers(read(input)))
+    }
+
+    #[test]
+    fn test_reader_identifiers_are_unique() {
+        assert_eq!(
+            ids(&read(
+                "# Intro {#intro-1}\n\n# Intro\n\n# Intro\n\n# Intro\n"
+            )),
+            vec!["intro-1", "intro", "intro-2", "intro-3"]
+        );
+        assert_eq!(ids(&read("# 2024\n")), vec!["section"]);
+    }
+
+    #[test]
+    fn test_assign_gfm_identifiers() {
+        let gfm = IdentifierOptions {
+            style: IdentifierStyle::Gfm,
+            ascii: false,
+        };
+        let blocks = assign_auto_identifiers(
+            read("# 1. Setup\n\n# Written {#sec-x}\n\n# 1. Setup\n"),
+            gfm,
+        );
+        assert_eq!(ids(&blocks), vec!["1-setup", "sec-x", "1-setup-1"]);
+    }
+
+    #[test]
+    fn test_assign_ascii_identifiers() {
+        let ascii = IdentifierOptions {
+            style: IdentifierStyle::Pandoc,
+            ascii: true,
+        };
+        assert_eq!(
+            ids(&assign_auto_identifiers(read("# Crème brûlée\n"), ascii)),
+            vec!["creme-brulee"]
+        );
+    }
+
+    #[test]
+    fn test_keeps_written_identifiers_only() {
+        assert_eq!(
+            header_ids("# Made Up\n\n# Written {#sec-written}\n\n# Classy {.c}\n"),
+            vec!["", "sec-written", ""]
+        );
+    }
+}
@@ -0,0 +1,672 @@
+/*
+ * transforms/crossref.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Crossref transform: number labelled elements and resolve references.
+ */
+
+//! Crossref transform for resolving `@fig-plot` style references.
+//!
+//! Elements are labelled with an identifier that starts with one of Quarto's
+//! crossref prefixes, and the qmd reader reads a reference to them as a
+//! citation. This transform runs in two passes:
+//!
+//! 1. [`number_crossrefs`] walks the blocks in document order and numbers
+//!    each labelled element, building a [`CrossrefIndex`]:
+//!    - Figures (`fig-`): a `Figure` block, or a Div whose last block is the
+//!      caption. The caption gets a `Figure 1: ` prefix.
+//!    - Tables (`tbl-`): a `Table` block, or a Div holding one. The caption
+//!      gets a `Table 1: ` prefix.
+//!    - Sections (`sec-`): headers, numbered hierarchically from the
+//!      shallowest header level. Headers with the `unnumbered` class are
+//!      skipped. With `number-sections: true` the number is also added to
+//!      the header, as a `header-section-number` Span.
+//!    - Equations (`eq-`): display math with an attribute. The number is
+//!      added to the math as `\tag{1}`.
+//! 2. [`link_crossrefs`] replaces each citation made only of crossref labels
+//!    with links to the labelled elements.
+//!
+//! ## Example
+//!
+//! Input:
+//! ```markdown
+//! ![A plot](plot.png){#fig-plot}
+//!
+//! See @fig-plot and [-@fig-plot].
+//! ```
+//!
+//! Output structure:
+//! ```text
+//! Figure(#fig-plot) caption: [Str "Figure", Space, Str "1:", Space, Str "A", Space, Str "plot"]
+//! Para [Str "See", Space, Link(#fig-plot) [Str "Figure 1"], ..., Link(#fig-plot) [Str "1"]]
+//! ```
+//!
+//! The words used come from the `crossref` metadata, with Quarto's keys and
+//! defaults: `fig-title` and `fig-prefix` ("Figure"), `tbl-title` and
+//! `tbl-prefix` ("Table"), `sec-prefix` ("Section"), `eq-prefix`
+//! ("Equation") and `title-delim` (":").
+//!
+//! In a book, [`CrossrefOptions::chapter`] holds the number of the chapter
+//! and numbers start with it, as in `Figure 2.1`.
+//!
+//! A reference to a label that is not defined becomes `?@label` in bold and
+//! produces a warning, as in Quarto. Citations that mix crossref labels and
+//! bibliography keys are left for the citation processor.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::{Block, Div, Figure, Header, Plain};
+use crate::pandoc::caption::Caption;
+use crate::pandoc::inline::{
+    Citation, CitationMode, Cite, Inline, Inlines, Link, MathType, Space, Span, Str, Strong,
+};
+use crate::pandoc::table::Table;
+use crate::utils::diagnostic_collector::DiagnosticCollector;
+use hashlink::LinkedHashMap;
+use quarto_error_reporting::DiagnosticMessageBuilder;
+use quarto_pandoc_types::ConfigValue;
+use quarto_pandoc_types::attr::{AttrSourceInfo, TargetSourceInfo};
+use quarto_source_map::SourceInfo;
+use std::cell::RefCell;
+use std::collections::HashMap;
+
+/// The class of the Span the qmd reader wraps math with attributes in
+const MATH_WITH_ATTRIBUTE_CLASS: &str = "quarto-math-with-attribute";
+
+/// The class of resolved crossref links, as in Quarto's HTML output
+pub const CROSSREF_LINK_CLASS: &str = "quarto-xref";
+
+/// The kinds of element a crossref label can name.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum CrossrefKind {
+    Figure,
+    Table,
+    Section,
+    Equation,
+}
+
+impl CrossrefKind {
+    /// Every kind, in the order of Quarto's documentation.
+    pub const ALL: &'static [CrossrefKind] = &[
+        CrossrefKind::Figure,
+        CrossrefKind::Table,
+        CrossrefKind::Section,
+        CrossrefKind::Equation,
+    ];
+
+    /// The label prefix of the kind, e.g. `fig-`.
+    pub fn label_prefix(self) -> &'static str {
+        match self {
+            CrossrefKind::Figure => "fig-",
+            CrossrefKind::Table => "tbl-",
+            CrossrefKind::Section => "sec-",
+            CrossrefKind::Equation => "eq-",
+        }
+    }
+
+    /// The kind a label names, if it has a crossref prefix.
+    pub fn of_label(label: &str) -> Option<CrossrefKind> {
+        CrossrefKind::ALL
+            .iter()
+            .copied()
+            .find(|kind| label.starts_with(kind.label_prefix()))
+    }
+}
+
+/// A numbered element.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CrossrefEntry {
+    pub kind: CrossrefKind,
+    /// The number of the element, e.g. `2` or `1.3` for a section
+    pub number: String,
+}
+
+/// The numbered elements of a document, by label, in document order.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct CrossrefIndex {
+    entries: LinkedHashMap<String, CrossrefEntry>,
+}
+
+impl CrossrefIndex {
+    /// The entry of a label.
+    pub fn get(&self, label: &str) -> Option<&CrossrefEntry> {
+        self.entries.get(label)
+    }
+
+    /// Labels and their entries, in document order.
+    pub fn iter(&self) -> impl Iterator<Item = (&str, &CrossrefEntry)> {
+        self.entries
+            .iter()
+            .map(|(label, entry)| (label.as_str(), entry))
+    }
+
+    pub fn len(&self) -> usize {
+        self.entries.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.entries.is_empty()
+    }
+}
+
+/// The words used for captions and references.
+#[derive(Debug, Clone, PartialEq)]
+pub struct CrossrefOptions {
+    /// Caption titles, e.g. `Figure` in `Figure 1: A plot`
+    pub titles: HashMap<CrossrefKind, String>,
+    /// Reference prefixes, e.g. `Figure` in `see Figure 1`
+    pub prefixes: HashMap<CrossrefKind, String>,
+    /// What follows the number in a caption
+    pub title_delim: String,
+    /// Whether headers show their section number
+    pub number_sections: bool,
+    /// The number of the chapter the document is, in a book. Numbers then
+    /// start with it: figures are `2.1`, `2.2`, ..., the chapter's title
+    /// (its shallowest header) is section `2` and its sections `2.1`, ...
+    pub chapter: Option<String>,
+}
+
+impl Default for CrossrefOptions {
+    fn default() -> Self {
+        let words = HashMap::from([
+            (CrossrefKind::Figure, "Figure".to_string()),
+            (CrossrefKind::Table, "Table".to_string()),
+            (CrossrefKind::Section, "Section".to_string()),
+            (CrossrefKind::Equation, "Equation".to_string()),
+        ]);
+        Self {
+            titles: words.clone(),
+            prefixes: words,
+            title_delim: ":".to_string(),
+            number_sections: false,
+            chapter: None,
+        }
+    }
+}
+
+impl CrossrefOptions {
+    /// Read the options from the `crossref` and `number-sections` metadata.
+    pub fn from_metadata(meta: &ConfigValue) -> Self {
+        let mut options = Self::default();
+        if let Some(crossref) = meta.get("crossref") {
+            let text = |key: &str| crossref.get(key).and_then(|value| value.as_plain_text());
+            for kind in CrossrefKind::ALL {
+                let key = kind.label_prefix().trim_end_matches('-');
+                if let Some(title) = text(&format!("{}-title", key)) {
+                    options.titles.insert(*kind, title);
+                }
+                if let Some(prefix) = text(&format!("{}-prefix", key)) {
+                    options.prefixes.insert(*kind, prefix);
+                }
+            }
+            if let Some(delim) = text("title-delim") {
+                options.title_delim = delim;
+            }
+        }
+        options.number_sections = meta
+            .get("number-sections")
+            .and_then(|value| value.as_bool())
+            .unwrap_or(false);
+        options
+    }
+}
+
+/// Number crossref targets and resolve references to them.
+///
+/// # Arguments
+///
+/// * `blocks` - The blocks to transform
+/// * `meta` - Document metadata, read for the `crossref` options
+/// * `diagnostics` - Collects warnings for duplicate labels and unresolved
+///   references
+///
+/// # Returns
+///
+/// The blocks with numbered captions, sections and equations, and references
+/// replaced by links.
+pub fn resolve_crossrefs(
+    blocks: Vec<Block>,
+    meta: &ConfigValue,
+    diagnostics: &mut DiagnosticCollector,
+) -> Vec<Block> {
+    let options = CrossrefOptions::from_metadata(meta);
+    let (blocks, index) = number_crossrefs(blocks, &options, diagnostics);
+    link_crossrefs(blocks, &index, &options, diagnostics)
+}
+
+/// Number the labelled figures, tables, sections and equations of `blocks`.
+///
+/// # Returns
+///
+/// The blocks with numbered captions, sections and equations, and the index
+/// of their numbers. When a label is used twice, the first element keeps it.
+pub fn number_crossrefs(
+    blocks: Vec<Block>,
+    options: &CrossrefOptions,
+    diagnostics: &mut DiagnosticCollector,
+) -> (Vec<Block>, CrossrefIndex) {
+    let (top_level, blocks) = shallowest_header_level(blocks);
+    let numberer = RefCell::new(Numberer {
+        options,
+        diagnostics,
+        index: CrossrefIndex::default(),
+        counters: HashMap::new(),
+        sections: Vec::new(),
+        top_level,
+        section_label: None,
+    });
+
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new()
+            .with_figure(|figure, _ctx| {
+                FilterReturn::Unchanged(numberer.borrow_mut().figure(figure))
+            })
+            .with_table(|table, _ctx| FilterReturn::Unchanged(numberer.borrow_mut().table(table)))
+            .with_div(|div, _ctx| FilterReturn::Unchanged(numberer.borrow_mut().div(div)))
+            .with_header(|header, _ctx| {
+                FilterReturn::Unchanged(numberer.borrow_mut().header(header))
+            })
+            .with_span(|span, _ctx| FilterReturn::Unchanged(numberer.borrow_mut().equation(span))),
+        &mut FilterContext::new(),
+    );
+    (blocks, numberer.into_inner().index)
+}
+
+/// Replace references to the labels of `index` with links.
+///
+/// A citation is a crossref reference when all of its keys have a crossref
+/// prefix. Keys are matched with their first letter lowercased, so
+/// `@Fig-plot` refers to `fig-plot`.
+pub fn link_crossrefs(
+    blocks: Vec<Block>,
+    index: &CrossrefIndex,
+    options: &CrossrefOptions,
+    diagnostics: &mut DiagnosticCollector,
+) -> Vec<Block> {
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_cite(|cite, _ctx| {
+            let is_crossref = cite
+                .citations
+                .iter()
+                .all(|citation| CrossrefKind::of_label(&label_of(&citation.id)).is_some());
+            if cite.citations.is_empty() || !is_crossref {
+                return FilterReturn::Unchanged(cite);
+            }
+            FilterReturn::FilterResult(reference_inlines(cite, index, options, diagnostics), false)
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+/// Numbering state of [`number_crossrefs`].
+struct Numberer<'a> {
+    options: &'a CrossrefOptions,
+    diagnostics: &'a mut DiagnosticCollector,
+    index: CrossrefIndex,
+    /// The last number of each kind other than sections
+    counters: HashMap<CrossrefKind, usize>,
+    /// The current section number, one entry per level below `top_level`
+    sections: Vec<usize>,
+    top_level: usize,
+    /// The label of a section Div (from the sectionize transform), which
+    /// belongs to the header it starts with
+    section_label: Option<String>,
+}
+
+impl Numberer<'_> {
+    fn figure(&mut self, mut figure: Figure) -> Figure {
+        if is_label_of(&figure.attr.0, CrossrefKind::Figure)
+            && let Some(number) =
+                self.add(&figure.attr.0, CrossrefKind::Figure, &figure.source_info)
+        {
+            self.prefix_caption(&mut figure.caption, CrossrefKind::Figure, &number);
+        }
+        figure
+    }
+
+    fn table(&mut self, mut table: Table) -> Table {
+        if is_label_of(&table.attr.0, CrossrefKind::Table)
+            && let Some(number) = self.add(&table.attr.0, CrossrefKind::Table, &table.source_info)
+        {
+            self.prefix_caption(&mut table.caption, CrossrefKind::Table, &number);
+        }
+        table
+    }
+
+    /// Number a figure or table Div, whose last block is the caption, and
+    /// remember the label of a section Div.
+    fn div(&mut self, mut div: Div) -> Div {
+        if div.attr.1.iter().any(|class| class == "section")
+            && is_label_of(&div.attr.0, CrossrefKind::Section)
+        {
+            self.section_label = Some(div.attr.0.clone());
+            return div;
+        }
+        let kind = [CrossrefKind::Figure, CrossrefKind::Table]
+            .into_iter()
+            .find(|kind| is_label_of(&div.attr.0, *kind));
+        if let Some(kind) = kind
+            && let Some(number) = self.add(&div.attr.0, kind, &div.source_info)
+            && let Some(last) = div.content.pop()
+        {
+            let last = self.prefix_caption_block(last, kind, &number);
+            div.content.push(last);
+        }
+        div
+    }
+
+    fn header(&mut self, mut header: Header) -> Header {
+        let label = match self.section_label.take() {
+            Some(label) if header.attr.0.is_empty() => label,
+            _ => header.attr.0.clone(),
+        };
+        if header.attr.1.iter().any(|class| class == "unnumbered") {
+            return header;
+        }
+        let number = self.next_section(header.level);
+        if is_label_of(&label, CrossrefKind::Section) {
+            self.add_entry(
+                &label,
+                CrossrefKind::Section,
+                number.clone(),
+                &header.source_info,
+            );
+        }
+        if self.options.number_sections {
+            let source_info = header.source_info.clone();
+            let mut content = vec![
+                Inline::Span(Span {
+                    attr: (
+                        String::new(),
+                        vec!["header-section-number".to_string()],
+                        LinkedHashMap::new(),
+                    ),
+                    content: vec![str_inline(&number, &source_info)],
+                    source_info: source_info.clone(),
+                    attr_source: AttrSourceInfo::empty(),
+                }),
+                space(&source_info),
+            ];
+            content.append(&mut header.content);
+            header.content = content;
+        }
+        header
+    }
+
+    /// Number display math in a Span with an equation label.
+    fn equation(&mut self, mut span: Span) -> Span {
+        let is_equation = span.attr.1.first().map(String::as_str)
+            == Some(MATH_WITH_ATTRIBUTE_CLASS)
+            && is_label_of(&span.attr.0, CrossrefKind::Equation);
+        let Some(Inline::Math(math)) = span.content.first() else {
+            return span;
+        };
+        if !is_equation || math.math_type != MathType::DisplayMath {
+            return span;
+        }
+        if let Some(number) = self.add(&span.attr.0, CrossrefKind::Equation, &span.source_info)
+            && let Some(Inline::Math(math)) = span.content.first_mut()
+        {
+            math.text = format!("{} \\tag{{{}}}", math.text.trim_end(), number);
+        }
+        span
+    }
+
+    /// Give `label` the next number of `kind`. Returns `None` for a label
+    /// that is already numbered.
+    fn add(&mut self, label: &str, kind: CrossrefKind, source_info: &SourceInfo) -> Option<String> {
+        if self.index.entries.contains_key(label) {
+            self.add_entry(label, kind, String::new(), source_info);
+            return None;
+        }
+        let counter = self.counters.entry(kind).or_insert(0);
+        *counter += 1;
+        let number = match &self.options.chapter {
+            Some(chapter) => format!("{}.{}", chapter, counter),
+            None => counter.to_string(),
+        };
+        self.add_entry(label, kind, number.clone(), source_info);
+        Some(number)
+    }
+
+    fn add_entry(
+        &mut self,
+        label: &str,
+        kind: CrossrefKind,
+        number: String,
+        source_info: &SourceInfo,
+    ) {
+        if self.index.entries.contains_key(label) {
+            self.diagnostics.add(
+                DiagnosticMessageBuilder::warning("Duplicate Cross-Reference Label")
+                    .with_code("Q-2-40")
+                    .with_location(source_info.clone())
+                    .problem(format!("The label `{}` is already used", label))
+                    .add_hint("References resolve to the first element with this label")
+                    .build(),
+            );
+            return;
+        }
+        self.index
+            .entries
+            .insert(label.to_string(), CrossrefEntry { kind, number });
+    }
+
+    /// The number of the next section at `level`, e.g. `2.1`.
+    fn next_section(&mut self, level: usize) -> String {
+        let depth = level.saturating_sub(self.top_level) + 1;
+        self.sections.resize(depth, 0);
+        self.sections[depth - 1] += 1;
+        let mut parts: Vec<String> = self.sections.iter().map(|n| n.to_string()).collect();
+        if let Some(chapter) = &self.options.chapter {
+            // The shallowest header is the chapter itself
+            parts[0] = chapter.clone();
+        }
+        parts.join(".")
+    }
+
+    /// Prefix the caption of a Div's last block.
+    fn prefix_caption_block(&self, block: Block, kind: CrossrefKind, number: &str) -> Block {
+        match block {
+            Block::Paragraph(mut para) => {
+                let prefix =
+                    self.caption_prefix(kind, number, !para.content.is_empty(), &para.source_info);
+                para.content.splice(0..0, prefix);
+                Block::Paragraph(para)
+            }
+            Block::Plain(mut plain) => {
+                let prefix = self.caption_prefix(
+                    kind,
+                    number,
+                    !plain.content.is_empty(),
+                    &plain.source_info,
+                );
+                plain.content.splice(0..0, prefix);
+                Block::Plain(plain)
+            }
+            Block::Figure(mut figure) => {
+                self.prefix_caption(&mut figure.caption, kind, number);
+                Block::Figure(figure)
+            }
+            Block::Table(mut table) => {
+                self.prefix_caption(&mut table.caption, kind, number);
+                Block::Table(table)
+            }
+            block => block,
+        }
+    }
+
+    fn prefix_caption(&self, caption: &mut Caption, kind: CrossrefKind, number: &str) {
+        let long = caption.long.get_or_insert_with(Vec::new);
+        if !matches!(long.first(), Some(Block::Plain(_) | Block::Paragraph(_))) {
+            long.insert(
+                0,
+                Block::Plain(Plain {
+                    content: Vec::new(),
+                    source_info: caption.source_info.clone(),
+                }),
+            );
+        }
+        let content = match long.first_mut() {
+            Some(Block::Plain(plain)) => &mut plain.content,
+            Some(Block::Paragraph(para)) => &mut para.content,
+            _ => unreachable!("the caption starts with a Plain or Paragraph"),
+        };
+        let prefix = self.caption_prefix(kind, number, !content.is_empty(), &caption.source_info);
+        content.splice(0..0, prefix);
+    }
+
+    /// `Figure 1: `, or `Figure 1` for an empty caption.
+    fn caption_prefix(
+        &self,
+        kind: CrossrefKind,
+        number: &str,
+        has_caption: bool,
+        source_info: &SourceInfo,
+    ) -> Inlines {
+        let mut prefix = Vec::new();
+        let title = &self.options.titles[&kind];
+        if !title.is_empty() {
+            prefix.push(str_inline(title, source_info));
+            prefix.push(space(source_info));
+        }
+        if has_caption {
+            prefix.push(str_inline(
+                &format!("{}{}", number, self.options.title_delim),
+                source_info,
+            ));
+            prefix.push(space(source_info));
+        } else {
+            prefix.push(str_inline(number, source_info));
+        }
+        prefix
+    }
+}
+
+/// The text of a crossref citation: one link per key, joined by commas, with
+/// the prefixes and suffixes of the citations.
+fn reference_inlines(
+    cite: Cite,
+    index: &CrossrefIndex,
+    options: &CrossrefOptions,
+    diagnostics: &mut DiagnosticCollector,
+) -> Inlines {
+    let mut result = Vec::new();
+    for (i, citation) in cite.citations.into_iter().enumerate() {
+        let source_info = citation
+            .id_source
+            .clone()
+            .unwrap_or_else(|| cite.source_info.clone());
+        if i > 0 {
+            result.push(str_inline(",", &source_info));
+            result.push(space(&source_info));
+        }
+        let Citation {
+            id,
+            mut prefix,
+            mut suffix,
+            mode,
+            ..
+        } = citation;
+        if !prefix.is_empty() {
+            let ends_with_space = matches!(prefix.last(), Some(Inline::Space(_)));
+            result.append(&mut prefix);
+            if !ends_with_space {
+                result.push(space(&source_info));
+            }
+        }
+
+        let label = label_of(&id);
+        match index.get(&label) {
+            Some(entry) if !entry.number.is_empty() => {
+                let reference_prefix = &options.prefixes[&entry.kind];
+                let text = if mode == CitationMode::SuppressAuthor || reference_prefix.is_empty() {
+                    entry.number.clone()
+                } else {
+                    format!("{}\u{a0}{}", reference_prefix, entry.number)
+                };
+                result.push(Inline::Link(Link {
+                    attr: (
+                        String::new(),
+                        vec![CROSSREF_LINK_CLASS.to_string()],
+                        LinkedHashMap::new(),
+                    ),
+                    content: vec![str_inline(&text, &source_info)],
+                    target: (format!("#{}", label), String::new()),
+                    source_info: source_info.clone(),
+                    attr_source: AttrSourceInfo::empty(),
+                    target_source: TargetSourceInfo::empty(),
+                }));
+            }
+            _ => {
+                diagnostics.add(
+                    DiagnosticMessageBuilder::warning("Unresolved Cross-Reference")
+                        .with_code("Q-2-39")
+                        .with_location(source_info.clone())
+                        .problem(format!("No element is labelled `{}`", label))
+                        .add_hint(format!(
+                            "Give the element an identifier, e.g. `{{#{}}}`",
+                            label
+                        ))
+                        .build(),
+                );
+                result.push(Inline::Strong(Strong {
+                    content: vec![str_inline(&format!("?@{}", id), &source_info)],
+                    source_info: source_info.clone(),
+                }));
+            }
+        }
+
+        if let Some(Inline::Str(first)) = suffix.first()
+            && first.text.starts_with(char::is_alphanumeric)
+        {
+            result.push(space(&source_info));
+        }
+        result.append(&mut suffix);
+    }
+    result
+}
+
+/// A citation key as a label: `@Fig-plot` refers to `fig-plot`.
+fn label_of(id: &str) -> String {
+    let mut chars = id.chars();
+    match chars.next() {
+        Some(first) => first.to_lowercase().chain(chars).collect(),
+        None => String::new(),
+    }
+}
+
+fn is_label_of(id: &str, kind: CrossrefKind) -> bool {
+    id.len() > kind.label_prefix().len() && id.starts_with(kind.label_prefix())
+}
+
+/// The level of the shallowest numbered header, and the blocks.
+fn shallowest_header_level(blocks: Vec<Block>) -> (usize, Vec<Block>) {
+    let mut level = usize::MAX;
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_header(|header, _ctx| {
+            if !header.attr.1.iter().any(|class| class == "unnumbered") {
+                level = level.min(header.level);
+            }
+            FilterReturn::Unchanged(header)
+        }),
+        &mut FilterContext::new(),
+    );
+    (if level == usize::MAX { 1 } else { level }, blocks)
+}
+
+fn str_inline(text: &str, source_info: &SourceInfo) -> Inline {
+    Inline::Str(Str {
+        text: text.to_string(),
+        source_info: source_info.clone(),
+    })
+}
+
+fn space(source_info: &SourceInfo) -> Inline {
+    Inline::Space(Space {
+        source_info: source_info.clone(),
+    })
+}
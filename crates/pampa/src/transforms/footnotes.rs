@@ -0,0 +1,263 @@
+/*
+ * transforms/footnotes.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Footnotes transform: resolve note references into Note inlines.
+ */
+
+//! Footnotes transform for resolving `[^id]` references.
+//!
+//! The qmd reader keeps footnote references and definitions where they were
+//! written: a reference becomes an empty `Span` with the `quarto-note-reference`
+//! class and a `reference-id` attribute, and a definition stays a
+//! `NoteDefinitionPara` (`[^id]: text`) or `NoteDefinitionFencedBlock`
+//! (`::: ^id`) block. That keeps the reader output close to the source, which
+//! the incremental writer relies on.
+//!
+//! This transform joins the two, as Pandoc's reader does:
+//!
+//! - Each referenced definition is removed from the blocks
+//! - Each reference to it becomes a `Note` inline holding the definition's
+//!   content, with the `id` kept so writers can emit `[^id]` again
+//! - Blocks indented by four or more columns right after a `[^id]: text`
+//!   definition are continuation paragraphs of that note
+//!
+//! ## Example
+//!
+//! Input:
+//! ```markdown
+//! Text.[^1]
+//!
+//! [^1]: First paragraph.
+//!
+//!     Second paragraph.
+//! ```
+//!
+//! Output structure:
+//! ```text
+//! Para [Str "Text.", Note(id: "1") [Para "First paragraph.", Para "Second paragraph."]]
+//! ```
+//!
+//! References without a definition are left as they are, and so are
+//! definitions that nothing references, so no content is lost. When an
+//! identifier is defined twice, the first definition wins.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::{Block, Blocks, Paragraph};
+use crate::pandoc::inline::{Inline, Note, Span};
+use quarto_source_map::{SourceContext, SourceInfo};
+use std::collections::{HashMap, HashSet};
+
+/// The class of the Span the qmd reader writes a `[^id]` reference as
+pub const NOTE_REFERENCE_CLASS: &str = "quarto-note-reference";
+
+/// Resolve note references into `Note` inlines.
+///
+/// # Arguments
+///
+/// * `blocks` - The blocks to transform
+/// * `source_context` - Source files of the blocks, used to find indented
+///   continuation paragraphs
+///
+/// # Returns
+///
+/// The blocks with referenced definitions removed and references replaced by
+/// `Note` inlines.
+pub fn resolve_footnotes(blocks: Vec<Block>, source_context: &SourceContext) -> Vec<Block> {
+    let mut referenced = HashSet::new();
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_span(|span, _ctx| {
+            if let Some(id) = note_reference_id(&span) {
+                referenced.insert(id.to_string());
+            }
+            FilterReturn::Unchanged(span)
+        }),
+        &mut FilterContext::new(),
+    );
+    if referenced.is_empty() {
+        return blocks;
+    }
+
+    let mut definitions: HashMap<String, Blocks> = HashMap::new();
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_blocks(|blocks, _ctx| {
+            FilterReturn::FilterResult(
+                take_definitions(blocks, &referenced, &mut definitions, source_context),
+                true,
+            )
+        }),
+        &mut FilterContext::new(),
+    );
+
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_span(|span, _ctx| {
+            let Some(content) = note_reference_id(&span).and_then(|id| definitions.get(id)) else {
+                return FilterReturn::Unchanged(span);
+            };
+            // Notes don't nest, so the content is not traversed again
+            FilterReturn::FilterResult(
+                vec![Inline::Note(Note {
+                    id: note_reference_id(&span).map(str::to_string),
+                    content: content.clone(),
+                    source_info: span.source_info,
+                })],
+                false,
+            )
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+/// The identifier of a `[^id]` reference Span
+pub fn note_reference_id(span: &Span) -> Option<&str> {
+    let (id, classes, keyvals) = &span.attr;
+    if !id.is_empty()
+        || classes.len() != 1
+        || classes[0] != NOTE_REFERENCE_CLASS
+        || !span.content.is_empty()
+    {
+        return None;
+    }
+    keyvals.get("reference-id").map(String::as_str)
+}
+
+/// Move the referenced definitions out of `blocks`, with their continuation
+/// blocks
+fn take_definitions(
+    blocks: Blocks,
+    referenced: &HashSet<String>,
+    definitions: &mut HashMap<String, Blocks>,
+    source_context: &SourceContext,
+) -> Blocks {
+    let mut result = Vec::with_capacity(blocks.len());
+    let mut blocks = blocks.into_iter().peekable();
+    while let Some(block) = blocks.next() {
+        let (id, mut content, continues) = match block {
+            Block::NoteDefinitionPara(def)
+                if referenced.contains(&def.id) && !definitions.contains_key(&def.id) =>
+            {
+                let para = Block::Paragraph(Paragraph {
+                    content: def.content,
+                    source_info: def.source_info,
+                });
+                (def.id, vec![para], true)
+            }
+            Block::NoteDefinitionFencedBlock(def)
+                if referenced.contains(&def.id) && !definitions.contains_key(&def.id) =>
+            {
+                (def.id, def.content, false)
+            }
+            block => {
+                result.push(block);
+                continue;
+            }
+        };
+        if continues {
+            while let Some(next) =
+                blocks.next_if(|next| is_indented(block_source_info(next), source_context))
+            {
+                content.push(next);
+            }
+        }
+        definitions.insert(id, content);
+    }
+    result
+}
+
+/// Whether the line a block starts on is indented by four or more columns,
+/// counting a tab as four
+fn is_indented(source_info: Option<&SourceInfo>, source_context: &SourceContext) -> bool {
+    let Some(mapped) = source_info.and_then(|info| info.map_offset(0, source_context)) else {
+        return false;
+    };
+    let Some(content) = source_context
+        .get_file(mapped.file_id)
+        .and_then(|file| file.content.as_deref())
+    else {
+        return false;
+    };
+    let offset = mapped.location.offset.min(content.len());
+    let line_start = content[..offset].rfind('\n').map_or(0, |pos| pos + 1);
+    let mut width = 0;
+    for c in content[line_start..].chars() {
+        match c {
+            ' ' => width += 1,
+            '\t' => width += 4,
+            _ => break,
+        }
+    }
+    width >= 4
+}
+
+fn block_source_info(block: &Block) -> Option<&SourceInfo> {
+    match block {
+        Block::Paragraph(b) => Some(&b.source_info),
+        Block::Plain(b) => Some(&b.source_info),
+        Block::CodeBlock(b) => Some(&b.source_info),
+        Block::BulletList(b) => Some(&b.source_info),
+        Block::OrderedList(b) => Some(&b.source_info),
+        Block::BlockQuote(b) => Some(&b.source_info),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::Pandoc;
+
+    fn read(input: &str) -> (Pandoc, SourceContext) {
+        let (doc, context, _warnings) = crate::readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        (doc, context.source_context)
+    }
+
+    fn first_note(blocks: &[Block]) -> &Note {
+        let Block::Paragraph(para) = &blocks[0] else {
+            panic!("Expected a paragraph, got {:?}", blocks[0]);
+        };
+        para.content
+            .iter()
+            .find_map(|inline| match inline {
+                Inline::Note(note) => Some(note),
+                _ => None,
+            })
+            .expect("Expected a note")
+    }
+
+    #[test]
+    fn test_reference_and_definition() {
+        let (doc, ctx) = read("Text.[^a]\n\n[^a]: The note.\n");
+        let blocks = resolve_footnotes(doc.blocks, &ctx);
+        assert_eq!(blocks.len(), 1);
+        let note = first_note(&blocks);
+        assert_eq!(note.id.as_deref(), Some("a"));
+        assert_eq!(note.content.len(), 1);
+    }
+
+    #[test]
+    fn test_indented_continuation() {
+        let (doc, ctx) = read("Text.[^a]\n\n[^a]: One.\n\n    Two.\n\nAfter.\n");
+        let blocks = resolve_footnotes(doc.blocks, &ctx);
+        assert_eq!(blocks.len(), 2);
+        assert_eq!(first_note(&blocks).content.len(), 2);
+    }
+
+    #[test]
+    fn test_unresolved_and_unused_are_kept() {
+        let (doc, ctx) = read("Text.[^missing]\n\n[^unused]: Nobody refers to me.\n");
+        let blocks = resolve_footnotes(doc.blocks.clone(), &ctx);
+        assert_eq!(blocks, doc.blocks);
+    }
+}
@@ -0,0 +1,368 @@
+/*
+ * transforms/includes.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Includes transform: expand include shortcodes into the included blocks.
+ */
+
+//! Includes transform for expanding `{{< include file.qmd >}}` shortcodes.
+//!
+//! An include shortcode that stands alone in a paragraph is replaced by the
+//! blocks of the file it names. Includes inside included files are expanded
+//! too, so a book chapter can pull in partials that pull in their own.
+//!
+//! - Relative paths resolve against the directory of the including file;
+//!   paths starting with `/` resolve against the root directory
+//! - Each included file is added to the `SourceContext` and read with a
+//!   parent `SourceInfo` in that file, so locations in the merged AST (and
+//!   in diagnostics) point into the included file
+//! - A file that includes itself, directly or through other files, is an
+//!   include cycle: the shortcode is left in place and an error is reported
+//!
+//! Only metadata from the main document is kept; front matter in an included
+//! file is dropped. Include shortcodes that share a paragraph with other
+//! content are not expanded and produce a warning.
+//!
+//! Callers that resolve the same document again and again, like
+//! `pampa --watch`, can keep the included files' blocks in an
+//! [`IncludeCache`] with [`resolve_includes_cached`], so that only files
+//! that changed are read again.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::Block;
+use crate::pandoc::inline::Inline;
+use crate::pandoc::{Shortcode, ShortcodeArg};
+use crate::readers;
+use crate::utils::diagnostic_collector::DiagnosticCollector;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_source_map::{FileId, SourceContext, SourceInfo};
+use std::collections::{HashMap, VecDeque};
+use std::path::{Path, PathBuf};
+
+/// Expand include shortcodes into the blocks of the files they name.
+///
+/// # Arguments
+///
+/// * `blocks` - The blocks of the main document, read from `FileId(0)`
+/// * `input_path` - Path of the main document
+/// * `root_dir` - Directory that `/`-prefixed include paths resolve against
+/// * `source_context` - Source files of the document; included files are
+///   added to it
+/// * `diagnostics` - Collects missing-file, cycle and read errors, and
+///   warnings from reading included files
+///
+/// # Returns
+///
+/// The blocks with every resolvable include replaced by the included blocks.
+pub fn resolve_includes(
+    blocks: Vec<Block>,
+    input_path: &Path,
+    root_dir: &Path,
+    source_context: &mut SourceContext,
+    diagnostics: &mut DiagnosticCollector,
+) -> Vec<Block> {
+    resolve_includes_cached(
+        blocks,
+        input_path,
+        root_dir,
+        source_context,
+        diagnostics,
+        &mut IncludeCache::new(),
+    )
+}
+
+/// The blocks of included files, kept between calls to
+/// [`resolve_includes_cached`].
+///
+/// A file is read again when its content changed, or when it gets a
+/// different `FileId` than last time (because the files before it changed),
+/// since the cached source locations point into the old one.
+#[derive(Default)]
+pub struct IncludeCache {
+    /// Keyed by canonical path and `FileId`, as a file included twice is
+    /// read twice
+    entries: HashMap<(PathBuf, FileId), CachedInclude>,
+    /// Canonical paths of the files the last resolve read
+    used: Vec<PathBuf>,
+}
+
+struct CachedInclude {
+    content: String,
+    blocks: Vec<Block>,
+    warnings: Vec<DiagnosticMessage>,
+}
+
+impl IncludeCache {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Canonical paths of the files included by the last resolve, including
+    /// ones that failed to parse
+    pub fn files(&self) -> &[PathBuf] {
+        &self.used
+    }
+}
+
+/// Expand include shortcodes like [`resolve_includes`], reusing the blocks
+/// of the files in `cache` that haven't changed and updating it.
+pub fn resolve_includes_cached(
+    blocks: Vec<Block>,
+    input_path: &Path,
+    root_dir: &Path,
+    source_context: &mut SourceContext,
+    diagnostics: &mut DiagnosticCollector,
+    cache: &mut IncludeCache,
+) -> Vec<Block> {
+    cache.used.clear();
+    let mut resolver = IncludeResolver {
+        root_dir: root_dir.to_path_buf(),
+        source_context,
+        diagnostics,
+        files: HashMap::new(),
+        cache,
+    };
+    let input_path = std::fs::canonicalize(input_path).unwrap_or_else(|_| input_path.to_path_buf());
+    resolver.files.insert(FileId(0), (input_path, None));
+
+    let blocks = topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_blocks(|blocks, _ctx| {
+            if !blocks
+                .iter()
+                .any(|block| include_paragraph(block).is_some())
+            {
+                resolver.warn_inline_includes(&blocks);
+                return FilterReturn::Unchanged(blocks);
+            }
+            // Recurse so includes nested in divs and lists get expanded too
+            FilterReturn::FilterResult(resolver.expand(blocks), true)
+        }),
+        &mut FilterContext::new(),
+    );
+    // Drop the files that are no longer included
+    let used = &resolver.cache.used;
+    resolver
+        .cache
+        .entries
+        .retain(|(path, _), _| used.contains(path));
+    blocks
+}
+
+struct IncludeResolver<'a> {
+    root_dir: PathBuf,
+    source_context: &'a mut SourceContext,
+    diagnostics: &'a mut DiagnosticCollector,
+    /// Every file read so far: its canonical path and the file including it
+    files: HashMap<FileId, (PathBuf, Option<FileId>)>,
+    cache: &'a mut IncludeCache,
+}
+
+impl IncludeResolver<'_> {
+    /// Replace the include paragraphs of one block list. Included blocks are
+    /// scanned in turn, so their own top-level includes are expanded here.
+    fn expand(&mut self, blocks: Vec<Block>) -> Vec<Block> {
+        let mut queue: VecDeque<Block> = blocks.into();
+        let mut result = Vec::new();
+        while let Some(block) = queue.pop_front() {
+            let Some(include) = include_paragraph(&block) else {
+                self.warn_inline_includes(std::slice::from_ref(&block));
+                result.push(block);
+                continue;
+            };
+            match self.read_include(include) {
+                Some(included) => {
+                    for block in included.into_iter().rev() {
+                        queue.push_front(block);
+                    }
+                }
+                None => result.push(block),
+            }
+        }
+        result
+    }
+
+    /// Read the blocks of an included file, or report why it can't be read.
+    fn read_include(&mut self, include: &Shortcode) -> Option<Vec<Block>> {
+        let Some(ShortcodeArg::String(target)) = include.positional_args.first() else {
+            self.diagnostics.add(
+                DiagnosticMessageBuilder::error("Include File Not Found")
+                    .with_code("Q-2-36")
+                    .with_location(include.source_info.clone())
+                    .problem("The include shortcode does not name a file")
+                    .add_hint("Name the file first, e.g. `{{< include _intro.qmd >}}`")
+                    .build(),
+            );
+            return None;
+        };
+
+        let including = root_file_id(&include.source_info)
+            .filter(|id| self.files.contains_key(id))
+            .unwrap_or(FileId(0));
+        let path = match target.strip_prefix('/') {
+            Some(rooted) => self.root_dir.join(rooted),
+            None => {
+                let including_path = &self.files[&including].0;
+                including_path
+                    .parent()
+                    .unwrap_or(Path::new(""))
+                    .join(target)
+            }
+        };
+
+        let file = std::fs::canonicalize(&path).and_then(|canonical| {
+            std::fs::read_to_string(&canonical).map(|content| (canonical, content))
+        });
+        let (canonical, mut content) = match file {
+            Ok(file) => file,
+            Err(err) => {
+                self.diagnostics.add(
+                    DiagnosticMessageBuilder::error("Include File Not Found")
+                        .with_code("Q-2-36")
+                        .with_location(include.source_info.clone())
+                        .problem(format!("Could not read `{}`: {}", path.display(), err))
+                        .add_hint("Include paths are relative to the including file")
+                        .build(),
+                );
+                return None;
+            }
+        };
+
+        let chain = self.chain(including);
+        if chain.contains(&canonical) {
+            let cycle: Vec<String> = chain
+                .iter()
+                .rev()
+                .chain(std::iter::once(&canonical))
+                .map(|path| format!("`{}`", path.display()))
+                .collect();
+            self.diagnostics.add(
+                DiagnosticMessageBuilder::error("Include Cycle")
+                    .with_code("Q-2-37")
+                    .with_location(include.source_info.clone())
+                    .problem(format!("`{}` includes itself", target))
+                    .add_detail(format!("Include chain: {}", cycle.join(" → ")))
+                    .build(),
+            );
+            return None;
+        }
+
+        if !content.ends_with('\n') {
+            content.push('\n');
+        }
+        let filename = path.to_string_lossy().to_string();
+        let file_id = self
+            .source_context
+            .add_file(filename.clone(), Some(content.clone()));
+        self.files
+            .insert(file_id, (canonical.clone(), Some(including)));
+        self.cache.used.push(canonical.clone());
+
+        let key = (canonical, file_id);
+        if let Some(cached) = self.cache.entries.get(&key)
+            && cached.content == content
+        {
+            for warning in &cached.warnings {
+                self.diagnostics.add(warning.clone());
+            }
+            return Some(cached.blocks.clone());
+        }
+
+        let parent = SourceInfo::original(file_id, 0, content.len());
+        match readers::qmd::read(
+            content.as_bytes(),
+            false,
+            &filename,
+            &mut std::io::sink(),
+            true,
+            Some(parent),
+        ) {
+            Ok((doc, _context, warnings)) => {
+                for warning in &warnings {
+                    self.diagnostics.add(warning.clone());
+                }
+                self.cache.entries.insert(
+                    key,
+                    CachedInclude {
+                        content,
+                        blocks: doc.blocks.clone(),
+                        warnings,
+                    },
+                );
+                Some(doc.blocks)
+            }
+            Err(errors) => {
+                for error in errors {
+                    self.diagnostics.add(error);
+                }
+                None
+            }
+        }
+    }
+
+    /// Canonical paths from `file` up to the main document.
+    fn chain(&self, file: FileId) -> Vec<PathBuf> {
+        let mut chain = Vec::new();
+        let mut current = Some(file);
+        while let Some((path, parent)) = current.and_then(|id| self.files.get(&id)) {
+            chain.push(path.clone());
+            current = *parent;
+        }
+        chain
+    }
+
+    fn warn_inline_includes(&mut self, blocks: &[Block]) {
+        for block in blocks {
+            let content = match block {
+                Block::Paragraph(para) => &para.content,
+                Block::Plain(plain) => &plain.content,
+                _ => continue,
+            };
+            for inline in content {
+                let Inline::Shortcode(shortcode) = inline else {
+                    continue;
+                };
+                if is_include(shortcode) {
+                    self.diagnostics.add(
+                        DiagnosticMessageBuilder::warning("Include Not Expanded")
+                            .with_code("Q-2-38")
+                            .with_location(shortcode.source_info.clone())
+                            .problem("Only an include shortcode on its own line is expanded")
+                            .add_hint("Put the include in a paragraph of its own")
+                            .build(),
+                    );
+                }
+            }
+        }
+    }
+}
+
+fn is_include(shortcode: &Shortcode) -> bool {
+    shortcode.name == "include" && !shortcode.is_escaped
+}
+
+/// The include shortcode of a paragraph that holds nothing else.
+fn include_paragraph(block: &Block) -> Option<&Shortcode> {
+    let content = match block {
+        Block::Paragraph(para) => &para.content,
+        Block::Plain(plain) => &plain.content,
+        _ => return None,
+    };
+    let mut inlines = content
+        .iter()
+        .filter(|inline| !matches!(inline, Inline::Space(_) | Inline::SoftBreak(_)));
+    match (inlines.next(), inlines.next()) {
+        (Some(Inline::Shortcode(shortcode)), None) if is_include(shortcode) => Some(shortcode),
+        _ => None,
+    }
+}
+
+/// The file a source location ultimately points into.
+fn root_file_id(source_info: &SourceInfo) -> Option<FileId> {
+    match source_info {
+        SourceInfo::Original { file_id, .. } => Some(*file_id),
+        SourceInfo::Substring { parent, .. } => root_file_id(parent),
+        _ => None,
+    }
+}
@@ -0,0 +1,403 @@
+/*
+ * transforms/marks.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Inline marks that readers can turn on and off: superscript, subscript,
+ * strikeout and highlighting.
+ */
+
+//! Inline marks, for the readers' `superscript`, `subscript`, `strikeout`
+//! and `mark` extensions.
+//!
+//! The qmd reader always reads `^sup^`, `~sub~` and `~~strike~~` as
+//! `Superscript`, `Subscript` and `Strikeout`. [`marks_to_text`] turns
+//! them back into the text they were written as, for
+//! `-f qmd-superscript` and the like.
+//!
+//! `==highlighted==` text is Pandoc's `mark` extension, off by default.
+//! [`read_highlights`] reads it as a Span with the `mark` class, which is
+//! how Pandoc represents it. An escaped `\=` is never a delimiter; the qmd
+//! writer's `mark` option escapes `==` in text that way.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::Block;
+use crate::pandoc::inline::{Inline, Inlines, Span, Str};
+use hashlink::LinkedHashMap;
+use quarto_pandoc_types::AttrSourceInfo;
+use quarto_source_map::{SourceContext, SourceInfo};
+
+/// The class of the Span that `==highlighted==` text is read as
+pub const MARK_CLASS: &str = "mark";
+
+/// An inline mark the qmd reader reads from its delimiters
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum MarkKind {
+    /// `^sup^`
+    Superscript,
+    /// `~sub~`
+    Subscript,
+    /// `~~strike~~`
+    Strikeout,
+}
+
+impl MarkKind {
+    /// The delimiter written on each side of the marked text
+    pub fn delimiter(self) -> &'static str {
+        match self {
+            MarkKind::Superscript => "^",
+            MarkKind::Subscript => "~",
+            MarkKind::Strikeout => "~~",
+        }
+    }
+}
+
+/// Turn the marks of `kinds` back into their delimiters and text, as a
+/// reader without those extensions reads them.
+pub fn marks_to_text(blocks: Vec<Block>, kinds: &[MarkKind]) -> Vec<Block> {
+    if kinds.is_empty() {
+        return blocks;
+    }
+    fn as_text(kind: MarkKind, content: Inlines, source_info: SourceInfo) -> Inlines {
+        let delimiter = || {
+            Inline::Str(Str {
+                text: kind.delimiter().to_string(),
+                source_info: source_info.clone(),
+            })
+        };
+        let mut inlines = vec![delimiter()];
+        inlines.extend(content);
+        inlines.push(delimiter());
+        inlines
+    }
+
+    let mut filter = Filter::new();
+    if kinds.contains(&MarkKind::Superscript) {
+        filter = filter.with_superscript(|sup, _ctx| {
+            FilterReturn::FilterResult(
+                as_text(MarkKind::Superscript, sup.content, sup.source_info),
+                true,
+            )
+        });
+    }
+    if kinds.contains(&MarkKind::Subscript) {
+        filter = filter.with_subscript(|sub, _ctx| {
+            FilterReturn::FilterResult(
+                as_text(MarkKind::Subscript, sub.content, sub.source_info),
+                true,
+            )
+        });
+    }
+    if kinds.contains(&MarkKind::Strikeout) {
+        filter = filter.with_strikeout(|strike, _ctx| {
+            FilterReturn::FilterResult(
+                as_text(MarkKind::Strikeout, strike.content, strike.source_info),
+                true,
+            )
+        });
+    }
+    let blocks = topdown_traverse_blocks(blocks, &mut filter, &mut FilterContext::new());
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_inlines(|inlines, _ctx| {
+            let (inlines, merged) = merge_strs(inlines);
+            if merged {
+                FilterReturn::FilterResult(inlines, true)
+            } else {
+                FilterReturn::Unchanged(inlines)
+            }
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+/// Read `==highlighted==` text as Spans with the `mark` class.
+///
+/// # Arguments
+///
+/// * `blocks` - The blocks to transform
+/// * `source_context` - Source files of the blocks, used to tell `\=` from
+///   `=`
+pub fn read_highlights(blocks: Vec<Block>, source_context: &SourceContext) -> Vec<Block> {
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_inlines(|inlines, _ctx| {
+            match highlight_inlines(&inlines, source_context) {
+                Some(inlines) => FilterReturn::FilterResult(inlines, true),
+                None => FilterReturn::Unchanged(inlines),
+            }
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+/// Text or a `==` between it, while pairing delimiters
+enum Piece {
+    Inline(Inline),
+    Delimiter(SourceInfo),
+}
+
+fn is_whitespace(piece: Option<&Piece>) -> bool {
+    matches!(
+        piece,
+        None | Some(Piece::Delimiter(_))
+            | Some(Piece::Inline(
+                Inline::Space(_) | Inline::SoftBreak(_) | Inline::LineBreak(_)
+            ))
+    )
+}
+
+/// `inlines` with their `==` pairs read as highlights, or None when they
+/// have none
+fn highlight_inlines(inlines: &[Inline], source_context: &SourceContext) -> Option<Inlines> {
+    let has_delimiter = inlines
+        .iter()
+        .any(|inline| matches!(inline, Inline::Str(s) if !delimiter_offsets(&s.text).is_empty()));
+    if !has_delimiter {
+        return None;
+    }
+
+    let mut pieces = Vec::new();
+    for inline in inlines {
+        match inline {
+            Inline::Str(s) if !has_escaped_equals(&s.source_info, source_context) => {
+                split_at_delimiters(s, &mut pieces)
+            }
+            _ => pieces.push(Piece::Inline(inline.clone())),
+        }
+    }
+
+    // A delimiter opens before text and closes after it, as `~~` does
+    let mut result: Vec<Piece> = Vec::new();
+    let mut open: Option<usize> = None;
+    let mut changed = false;
+    let mut pieces = pieces.into_iter().peekable();
+    while let Some(piece) = pieces.next() {
+        let Piece::Delimiter(source_info) = piece else {
+            result.push(piece);
+            continue;
+        };
+        let closes = !is_whitespace(result.last());
+        let opens = !is_whitespace(pieces.peek());
+        match open {
+            Some(start) if closes && result.len() > start + 1 => {
+                let content: Inlines = result.drain(start + 1..).map(piece_inline).collect();
+                let open_source_info = match result.pop() {
+                    Some(Piece::Delimiter(source_info)) => source_info,
+                    _ => unreachable!("the opening delimiter is where it was pushed"),
+                };
+                result.push(Piece::Inline(Inline::Span(Span {
+                    attr: (
+                        String::new(),
+                        vec![MARK_CLASS.to_string()],
+                        LinkedHashMap::new(),
+                    ),
+                    content: merge_strs(content).0,
+                    source_info: open_source_info.combine(&source_info),
+                    attr_source: AttrSourceInfo::empty(),
+                })));
+                open = None;
+                changed = true;
+            }
+            // Inside a highlight, a `==` that can't close it is text
+            None if opens => {
+                open = Some(result.len());
+                result.push(Piece::Delimiter(source_info));
+            }
+            _ => result.push(Piece::Inline(delimiter_str(source_info))),
+        }
+    }
+    if !changed {
+        return None;
+    }
+    Some(merge_strs(result.into_iter().map(piece_inline).collect()).0)
+}
+
+fn piece_inline(piece: Piece) -> Inline {
+    match piece {
+        Piece::Inline(inline) => inline,
+        Piece::Delimiter(source_info) => delimiter_str(source_info),
+    }
+}
+
+fn delimiter_str(source_info: SourceInfo) -> Inline {
+    Inline::Str(Str {
+        text: "==".to_string(),
+        source_info,
+    })
+}
+
+/// The byte offsets of the `==` in `text` that aren't part of a longer run
+/// of `=`
+fn delimiter_offsets(text: &str) -> Vec<usize> {
+    let bytes = text.as_bytes();
+    let mut offsets = Vec::new();
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] != b'=' {
+            i += 1;
+            continue;
+        }
+        let start = i;
+        while i < bytes.len() && bytes[i] == b'=' {
+            i += 1;
+        }
+        if i - start == 2 {
+            offsets.push(start);
+        }
+    }
+    offsets
+}
+
+/// Split a Str at its `==` delimiters into `pieces`
+fn split_at_delimiters(s: &Str, pieces: &mut Vec<Piece>) {
+    // Offsets into the text are offsets into the source when nothing was
+    // unescaped or replaced in between
+    let exact = s.text.len() == s.source_info.length();
+    let source_info = |start: usize, end: usize| {
+        if exact {
+            SourceInfo::substring(s.source_info.clone(), start, end)
+        } else {
+            s.source_info.clone()
+        }
+    };
+    let mut start = 0;
+    for offset in delimiter_offsets(&s.text) {
+        if offset > start {
+            pieces.push(Piece::Inline(Inline::Str(Str {
+                text: s.text[start..offset].to_string(),
+                source_info: source_info(start, offset),
+            })));
+        }
+        pieces.push(Piece::Delimiter(source_info(offset, offset + 2)));
+        start = offset + 2;
+    }
+    if start < s.text.len() {
+        pieces.push(Piece::Inline(Inline::Str(Str {
+            text: s.text[start..].to_string(),
+            source_info: source_info(start, s.text.len()),
+        })));
+    }
+}
+
+/// Whether the source of a Str has an escaped `\=`, which isn't a delimiter
+fn has_escaped_equals(source_info: &SourceInfo, source_context: &SourceContext) -> bool {
+    let Some(mapped) = source_info.map_offset(0, source_context) else {
+        return false;
+    };
+    let Some(content) = source_context
+        .get_file(mapped.file_id)
+        .and_then(|file| file.content.as_deref())
+    else {
+        return false;
+    };
+    let start = mapped.location.offset.min(content.len());
+    let end = (start + source_info.length()).min(content.len());
+    content
+        .get(start..end)
+        .is_some_and(|source| source.contains("\\="))
+}
+
+/// Merge adjacent Strs, and drop empty ones; whether anything changed
+fn merge_strs(inlines: Inlines) -> (Inlines, bool) {
+    let mut result: Inlines = Vec::with_capacity(inlines.len());
+    let mut changed = false;
+    for inline in inlines {
+        match (result.last_mut(), inline) {
+            (_, Inline::Str(s)) if s.text.is_empty() => changed = true,
+            (Some(Inline::Str(previous)), Inline::Str(s)) => {
+                previous.text.push_str(&s.text);
+                previous.source_info = previous.source_info.combine(&s.source_info);
+                changed = true;
+            }
+            (_, inline) => result.push(inline),
+        }
+    }
+    (result, changed)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::Pandoc;
+    use crate::pandoc::block::Paragraph;
+
+    fn read(input: &str) -> (Pandoc, SourceContext) {
+        let (doc, context, _warnings) = crate::readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        (doc, context.source_context)
+    }
+
+    fn paragraph(blocks: &[Block]) -> &Paragraph {
+        match &blocks[0] {
+            Block::Paragraph(para) => para,
+            other => panic!("Expected a paragraph, got {:?}", other),
+        }
+    }
+
+    fn text(inline: &Inline) -> &str {
+        match inline {
+            Inline::Str(s) => &s.text,
+            other => panic!("Expected a Str, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_delimiter_offsets() {
+        assert_eq!(delimiter_offsets("==a=="), vec![0, 3]);
+        assert_eq!(delimiter_offsets("a===b"), Vec::<usize>::new());
+        assert_eq!(delimiter_offsets("="), Vec::<usize>::new());
+    }
+
+    #[test]
+    fn test_highlights() {
+        let (doc, ctx) = read("Some ==marked text== here.\n");
+        let blocks = read_highlights(doc.blocks, &ctx);
+        let para = paragraph(&blocks);
+        let Inline::Span(span) = &para.content[2] else {
+            panic!("Expected a Span, got {:?}", para.content);
+        };
+        assert_eq!(span.attr.1, vec![MARK_CLASS.to_string()]);
+        assert_eq!(span.content.len(), 3);
+        assert_eq!(text(&span.content[0]), "marked");
+        assert_eq!(text(&span.content[2]), "text");
+        assert_eq!(text(&para.content[4]), "here.");
+    }
+
+    #[test]
+    fn test_text_that_is_not_highlighted() {
+        for input in ["a == b\n", "a === b===\n", "==a\n", "\\==a\\==\n"] {
+            let (doc, ctx) = read(input);
+            let blocks = read_highlights(doc.blocks.clone(), &ctx);
+            assert_eq!(blocks, doc.blocks, "{}", input);
+        }
+    }
+
+    #[test]
+    fn test_marks_to_text() {
+        let (doc, _) = read("x^2^ and H~2~O and ~~gone~~\n");
+        let blocks = marks_to_text(
+            doc.blocks,
+            &[
+                MarkKind::Superscript,
+                MarkKind::Subscript,
+                MarkKind::Strikeout,
+            ],
+        );
+        let para = paragraph(&blocks);
+        let words: Vec<&str> = para
+            .content
+            .iter()
+            .filter(|inline| !matches!(inline, Inline::Space(_)))
+            .map(text)
+            .collect();
+        assert_eq!(words, vec!["x^2^", "and", "H~2~O", "and", "~~gone~~"]);
+    }
+}
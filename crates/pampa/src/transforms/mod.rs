@@ -13,8 +13,37 @@
 //!
 //! ## Available Transforms
 //!
+//! - [`accessibility`] - Audit for images without alt text, skipped heading levels, tables without headers and vague link text
+//! - [`auto_identifiers`] - Make or remove identifiers for headings that have none
+//! - [`crossref`] - Number figures, tables, sections and equations, and resolve `@fig-id` references
+//! - [`footnotes`] - Resolve `[^id]` references into `Note` inlines
+//! - [`includes`] - Expand `{{< include >}}` shortcodes into the included blocks
+//! - [`marks`] - `==highlighted==` text, and superscript, subscript and strikeout as text (Pandoc's `mark`, `superscript`, `subscript` and `strikeout` extensions)
+//! - [`sanitize`] - Remove raw content, scripts, shortcodes and file references from untrusted documents
 //! - [`sectionize`] - Wrap headers in section Divs (analogous to Pandoc's `--section-divs`)
+//! - [`smart`] - Typographic dashes and ellipses (analogous to Pandoc's `smart` extension)
+//! - [`task_lists`] - List the tasks of `- [ ]` task lists, and check or uncheck them
 
+pub mod accessibility;
+pub mod auto_identifiers;
+pub mod crossref;
+pub mod footnotes;
+pub mod includes;
+pub mod marks;
+pub mod sanitize;
 pub mod sectionize;
+pub mod smart;
+pub mod task_lists;
 
+pub use accessibility::{AccessibilityReport, Finding, audit_accessibility};
+pub use auto_identifiers::{assign_auto_identifiers, remove_auto_identifiers};
+pub use crossref::resolve_crossrefs;
+pub use footnotes::resolve_footnotes;
+pub use includes::{IncludeCache, resolve_includes, resolve_includes_cached};
+pub use marks::{MarkKind, marks_to_text, read_highlights};
+pub use sanitize::{
+    SanitizeOptions, SanitizeReport, check_input_size, is_unsafe_metadata, sanitize,
+};
 pub use sectionize::sectionize_blocks;
+pub use smart::smart_punctuation;
+pub use task_lists::{TaskItem, set_task_checked, task_items};
@@ -0,0 +1,609 @@
+/*
+ * transforms/sanitize.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Sanitize transform: make documents from untrusted sources safe to render.
+ */
+
+//! Sanitize transform for documents from untrusted sources, such as those
+//! submitted to a hub.
+//!
+//! [`check_input_size`] refuses input that is too large before it is read.
+//! [`sanitize`] then removes from the document everything that would run
+//! code in the reader's browser or make the renderer touch the filesystem:
+//!
+//! - Raw blocks and inlines, of any format
+//! - Shortcodes, so that nothing is included or expanded
+//! - Event handler attributes (`onclick`, ...) and `srcdoc`
+//! - `javascript:`, `vbscript:` and `data:` URLs in links, images and
+//!   attributes; images may still use `data:image/` URLs. A link with an
+//!   unsafe URL is replaced by its text.
+//! - Metadata other than the fields known to be safe, such as `title`,
+//!   `author` and `toc`, at the top level and in each `format`. Fields that
+//!   name files to read (`include-in-header`, `theme`, `brand`, ...) or hold
+//!   raw output are removed, and so are fields this list doesn't know.
+//! - Content nested deeper than [`SanitizeOptions::max_depth`]
+//!
+//! Executable cells (` ```{python} `, ` ```{ojs} `) are kept as code that
+//! is only displayed.
+//!
+//! Everything removed is listed in the [`SanitizeReport`], with where it
+//! was, so that it can be reported to whoever submitted the document.
+
+use crate::pandoc::Pandoc;
+use crate::pandoc::attr::Attr;
+use crate::pandoc::block::Block;
+use crate::pandoc::inline::{Inline, Span};
+use crate::writers::incremental::{block_source_info, inline_source_info};
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::walk::{Control, Order, VisitorMut, Walkable};
+use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
+use quarto_source_map::SourceInfo;
+
+/// Metadata fields known to be safe: they neither name files to read nor
+/// hold raw output. `format` is safe at the top level, and its formats'
+/// fields are checked against this list in turn.
+const SAFE_METADATA: &[&str] = &[
+    "abstract",
+    "abstract-title",
+    "anchor-sections",
+    "author",
+    "author-title",
+    "authors",
+    "categories",
+    "code-fold",
+    "code-line-numbers",
+    "code-overflow",
+    "code-summary",
+    "copyright",
+    "crossref",
+    "date",
+    "date-format",
+    "date-modified",
+    "description",
+    "dir",
+    "draft",
+    "fig-align",
+    "fig-cap-location",
+    "format",
+    "keywords",
+    "lang",
+    "license",
+    "link-external-icon",
+    "link-external-newwindow",
+    "number-depth",
+    "number-offset",
+    "number-sections",
+    "page-layout",
+    "pagetitle",
+    "reference-location",
+    "section-divs",
+    "smooth-scroll",
+    "subject",
+    "subtitle",
+    "tbl-cap-location",
+    "title",
+    "title-prefix",
+    "toc",
+    "toc-depth",
+    "toc-expand",
+    "toc-location",
+    "toc-title",
+];
+
+/// Attributes whose value is a URL
+const URL_ATTRIBUTES: &[&str] = &[
+    "action",
+    "background",
+    "cite",
+    "data",
+    "formaction",
+    "href",
+    "poster",
+    "src",
+    "xlink:href",
+];
+
+/// Limits for [`check_input_size`] and [`sanitize`].
+///
+/// `max_depth` trims the document once it has been read: it doesn't
+/// protect the reader. The qmd reader protects itself, refusing input
+/// nested more than 100 levels deep before it builds the AST.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SanitizeOptions {
+    /// Largest input read, in bytes
+    pub max_input_bytes: usize,
+    /// Deepest nesting of blocks and inlines kept: a paragraph in a block
+    /// quote is at depth 2, and its text at depth 3
+    pub max_depth: usize,
+}
+
+impl Default for SanitizeOptions {
+    fn default() -> Self {
+        Self {
+            max_input_bytes: 4 * 1024 * 1024,
+            max_depth: 64,
+        }
+    }
+}
+
+/// What [`sanitize`] removed
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Removed {
+    /// A raw block of this format
+    RawBlock(String),
+    /// A raw inline of this format
+    RawInline(String),
+    /// A shortcode with this name
+    Shortcode(String),
+    /// An attribute with this name
+    Attribute(String),
+    /// A link or image to this URL
+    Url(String),
+    /// A metadata field with this name
+    Metadata(String),
+    /// Content nested deeper than the limit
+    NestedContent,
+    /// The code cell of this engine was made display-only
+    ExecutableCell(String),
+}
+
+impl Removed {
+    fn describe(&self) -> String {
+        match self {
+            Removed::RawBlock(format) => format!("A raw `{}` block was removed", format),
+            Removed::RawInline(format) => format!("Raw `{}` content was removed", format),
+            Removed::Shortcode(name) => format!("The `{}` shortcode was removed", name),
+            Removed::Attribute(name) => format!("The `{}` attribute was removed", name),
+            Removed::Url(url) => format!("The link to `{}` was removed", url),
+            Removed::Metadata(key) => format!("The `{}` metadata field was removed", key),
+            Removed::NestedContent => "Content nested too deeply was removed".to_string(),
+            Removed::ExecutableCell(engine) => {
+                format!("The `{}` cell is shown as code and not run", engine)
+            }
+        }
+    }
+}
+
+/// Everything [`sanitize`] removed from a document, in document order
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct SanitizeReport {
+    pub removed: Vec<(Removed, SourceInfo)>,
+}
+
+impl SanitizeReport {
+    pub fn is_empty(&self) -> bool {
+        self.removed.is_empty()
+    }
+
+    /// A warning for each removal
+    pub fn diagnostics(&self) -> Vec<DiagnosticMessage> {
+        self.removed
+            .iter()
+            .map(|(removed, source_info)| {
+                DiagnosticMessageBuilder::warning("Unsafe Content Removed")
+                    .with_code("Q-2-42")
+                    .with_location(source_info.clone())
+                    .problem(removed.describe())
+                    .add_hint("Documents from untrusted sources are rendered without it")
+                    .build()
+            })
+            .collect()
+    }
+
+    fn add(&mut self, removed: Removed, source_info: &SourceInfo) {
+        self.removed.push((removed, source_info.clone()));
+    }
+}
+
+/// An error when `input` is larger than the options allow.
+pub fn check_input_size(input: &[u8], options: &SanitizeOptions) -> Result<(), DiagnosticMessage> {
+    if input.len() <= options.max_input_bytes {
+        return Ok(());
+    }
+    Err(DiagnosticMessageBuilder::error("Input Too Large")
+        .with_code("Q-2-43")
+        .problem(format!(
+            "The document is {} bytes long, and at most {} bytes are accepted",
+            input.len(),
+            options.max_input_bytes
+        ))
+        .build())
+}
+
+/// Whether a metadata field, of the document or of one of its formats, is
+/// removed by [`sanitize`]. For callers that read metadata on their own.
+pub fn is_unsafe_metadata(key: &str) -> bool {
+    !SAFE_METADATA.contains(&key)
+}
+
+/// Remove everything unsafe from `doc`, and report what was removed.
+pub fn sanitize(doc: &mut Pandoc, options: &SanitizeOptions) -> SanitizeReport {
+    let mut report = SanitizeReport::default();
+    sanitize_metadata(&mut doc.meta, true, &mut report);
+    let mut sanitizer = Sanitizer {
+        options,
+        report: &mut report,
+        depth: 0,
+        entering: false,
+    };
+    let _ = doc.walk_mut(Order::TopDown, &mut sanitizer);
+    report
+}
+
+/// Remove the unsafe fields of document metadata, or of a format in its
+/// `format` field
+fn sanitize_metadata(meta: &mut ConfigValue, top_level: bool, report: &mut SanitizeReport) {
+    let ConfigValueKind::Map(entries) = &mut meta.value else {
+        return;
+    };
+    entries.retain(|entry| {
+        let unsafe_field = is_unsafe_metadata(&entry.key);
+        if unsafe_field {
+            report.add(Removed::Metadata(entry.key.clone()), &entry.key_source);
+        }
+        !unsafe_field
+    });
+    if top_level
+        && let Some(format) = entries.iter_mut().find(|entry| entry.key == "format")
+        && let ConfigValueKind::Map(formats) = &mut format.value.value
+    {
+        for format in formats {
+            sanitize_metadata(&mut format.value, false, report);
+        }
+    }
+}
+
+struct Sanitizer<'a> {
+    options: &'a SanitizeOptions,
+    report: &'a mut SanitizeReport,
+    /// Number of blocks and inlines around the current one
+    depth: usize,
+    /// Set while walking into the element just visited
+    entering: bool,
+}
+
+impl Sanitizer<'_> {
+    /// Walk into a visited element with one more level of depth. The walk
+    /// visits the element again first, which `entering` lets through.
+    fn enter<W: Walkable>(&mut self, element: &mut W) -> Control {
+        self.depth += 1;
+        self.entering = true;
+        let _ = element.walk_mut(Order::TopDown, self);
+        self.depth -= 1;
+        Control::Skip
+    }
+
+    /// Whether the elements of a list at the current depth are too deep,
+    /// which reports them
+    fn too_deep(&mut self, first: Option<&SourceInfo>) -> bool {
+        match first {
+            Some(source_info) if self.depth >= self.options.max_depth => {
+                self.report.add(Removed::NestedContent, source_info);
+                true
+            }
+            _ => false,
+        }
+    }
+
+    fn sanitize_attr(&mut self, attr: &mut Attr, source_info: &SourceInfo) {
+        let (_id, _classes, attrs) = attr;
+        let report = &mut *self.report;
+        attrs.retain(|key, value| {
+            let key_lower = key.to_ascii_lowercase();
+            let unsafe_attribute = key_lower.starts_with("on")
+                || key_lower == "srcdoc"
+                || (URL_ATTRIBUTES.contains(&key_lower.as_str()) && is_unsafe_url(value, false));
+            if unsafe_attribute {
+                report.add(Removed::Attribute(key.clone()), source_info);
+            }
+            !unsafe_attribute
+        });
+    }
+}
+
+impl VisitorMut for Sanitizer<'_> {
+    fn visit_block_list_mut(&mut self, blocks: &mut Vec<Block>) -> Control {
+        if self.too_deep(blocks.first().map(block_source_info)) {
+            blocks.clear();
+            return Control::Skip;
+        }
+        let report = &mut *self.report;
+        blocks.retain(|block| match block {
+            Block::RawBlock(raw) => {
+                report.add(Removed::RawBlock(raw.format.clone()), &raw.source_info);
+                false
+            }
+            _ => true,
+        });
+        Control::Continue
+    }
+
+    fn visit_inline_list_mut(&mut self, inlines: &mut Vec<Inline>) -> Control {
+        if self.too_deep(inlines.first().map(inline_source_info)) {
+            inlines.clear();
+            return Control::Skip;
+        }
+        let report = &mut *self.report;
+        inlines.retain(|inline| match inline {
+            Inline::RawInline(raw) => {
+                report.add(Removed::RawInline(raw.format.clone()), &raw.source_info);
+                false
+            }
+            Inline::Shortcode(shortcode) => {
+                report.add(
+                    Removed::Shortcode(shortcode.name.clone()),
+                    &shortcode.source_info,
+                );
+                false
+            }
+            _ => true,
+        });
+        Control::Continue
+    }
+
+    fn visit_block_mut(&mut self, block: &mut Block) -> Control {
+        if std::mem::take(&mut self.entering) {
+            return Control::Continue;
+        }
+        match block {
+            Block::CodeBlock(code) => {
+                let source_info = code.source_info.clone();
+                if let Some(class) = code.attr.1.first_mut()
+                    && let Some(engine) = class.strip_prefix('{').and_then(|c| c.strip_suffix('}'))
+                {
+                    let engine = engine.to_string();
+                    self.report
+                        .add(Removed::ExecutableCell(engine.clone()), &source_info);
+                    *class = engine;
+                }
+                self.sanitize_attr(&mut code.attr, &source_info);
+            }
+            Block::Header(header) => {
+                let source_info = header.source_info.clone();
+                self.sanitize_attr(&mut header.attr, &source_info);
+            }
+            Block::Div(div) => {
+                let source_info = div.source_info.clone();
+                self.sanitize_attr(&mut div.attr, &source_info);
+            }
+            Block::Figure(figure) => {
+                let source_info = figure.source_info.clone();
+                self.sanitize_attr(&mut figure.attr, &source_info);
+            }
+            Block::Table(table) => {
+                let source_info = table.source_info.clone();
+                self.sanitize_attr(&mut table.attr, &source_info);
+            }
+            _ => {}
+        }
+        self.enter(block)
+    }
+
+    fn visit_inline_mut(&mut self, inline: &mut Inline) -> Control {
+        if std::mem::take(&mut self.entering) {
+            return Control::Continue;
+        }
+        match inline {
+            Inline::Link(link) if is_unsafe_url(&link.target.0, false) => {
+                self.report
+                    .add(Removed::Url(link.target.0.clone()), &link.source_info);
+                *inline = Inline::Span(Span {
+                    attr: link.attr.clone(),
+                    content: std::mem::take(&mut link.content),
+                    source_info: link.source_info.clone(),
+                    attr_source: link.attr_source.clone(),
+                });
+                // Sanitize the Span in place of the link
+                return self.visit_inline_mut(inline);
+            }
+            Inline::Link(link) => {
+                let source_info = link.source_info.clone();
+                self.sanitize_attr(&mut link.attr, &source_info);
+            }
+            Inline::Image(image) => {
+                let source_info = image.source_info.clone();
+                if is_unsafe_url(&image.target.0, true) {
+                    self.report
+                        .add(Removed::Url(image.target.0.clone()), &source_info);
+                    image.target.0.clear();
+                }
+                self.sanitize_attr(&mut image.attr, &source_info);
+            }
+            Inline::Span(span) => {
+                let source_info = span.source_info.clone();
+                self.sanitize_attr(&mut span.attr, &source_info);
+            }
+            Inline::Code(code) => {
+                let source_info = code.source_info.clone();
+                self.sanitize_attr(&mut code.attr, &source_info);
+            }
+            _ => {}
+        }
+        self.enter(inline)
+    }
+}
+
+/// Whether a URL runs script or embeds a document. Browsers ignore ASCII
+/// whitespace and control characters in the scheme, so they are ignored
+/// here too. Images may use `data:image/` URLs.
+fn is_unsafe_url(url: &str, image: bool) -> bool {
+    let normalized: String = url
+        .chars()
+        .filter(|c| !c.is_ascii_whitespace() && !c.is_ascii_control())
+        .take(16)
+        .collect::<String>()
+        .to_ascii_lowercase();
+    if normalized.starts_with("data:") {
+        return !(image && normalized.starts_with("data:image/"));
+    }
+    normalized.starts_with("javascript:") || normalized.starts_with("vbscript:")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::readers;
+
+    fn read_qmd(input: &str) -> Pandoc {
+        let (doc, _context, _warnings) = readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        doc
+    }
+
+    fn sanitized(input: &str) -> (Pandoc, Vec<Removed>) {
+        let mut doc = read_qmd(input);
+        let report = sanitize(&mut doc, &SanitizeOptions::default());
+        let removed = report.removed.into_iter().map(|(r, _)| r).collect();
+        (doc, removed)
+    }
+
+    #[test]
+    fn test_raw_content_is_removed() {
+        let (doc, removed) =
+            sanitized("```{=html}\n<script>alert(1)</script>\n```\n\nText `<b>`{=html} here.\n");
+        assert_eq!(
+            removed,
+            vec![
+                Removed::RawBlock("html".to_string()),
+                Removed::RawInline("html".to_string())
+            ]
+        );
+        assert_eq!(doc.blocks.len(), 1);
+    }
+
+    #[test]
+    fn test_shortcodes_are_removed() {
+        let (_doc, removed) = sanitized("{{< include secrets.qmd >}}\n");
+        assert_eq!(removed, vec![Removed::Shortcode("include".to_string())]);
+    }
+
+    #[test]
+    fn test_unsafe_links_become_text() {
+        let (doc, removed) = sanitized("[click](javascript:alert(1)) [ok](https://quarto.org)\n");
+        assert_eq!(
+            removed,
+            vec![Removed::Url("javascript:alert(1)".to_string())]
+        );
+        let Block::Paragraph(para) = &doc.blocks[0] else {
+            panic!("Expected a paragraph");
+        };
+        assert!(matches!(para.content[0], Inline::Span(_)));
+        assert!(matches!(para.content[2], Inline::Link(_)));
+    }
+
+    #[test]
+    fn test_event_handlers_are_removed() {
+        let (doc, removed) = sanitized("::: {onclick=\"alert(1)\" data-x=\"1\"}\nText\n:::\n");
+        assert_eq!(removed, vec![Removed::Attribute("onclick".to_string())]);
+        let Block::Div(div) = &doc.blocks[0] else {
+            panic!("Expected a div");
+        };
+        assert_eq!(div.attr.2.keys().collect::<Vec<_>>(), ["data-x"]);
+    }
+
+    #[test]
+    fn test_executable_cells_are_display_only() {
+        let (doc, removed) = sanitized("```{ojs}\nx = 1\n```\n");
+        assert_eq!(removed, vec![Removed::ExecutableCell("ojs".to_string())]);
+        let Block::CodeBlock(code) = &doc.blocks[0] else {
+            panic!("Expected a code block");
+        };
+        assert_eq!(code.attr.1, vec!["ojs".to_string()]);
+    }
+
+    #[test]
+    fn test_unsafe_metadata_is_removed() {
+        let (doc, removed) = sanitized(
+            "---\ntitle: Safe\ninclude-in-header: /etc/passwd\nformat:\n  html:\n    css: style.css\n    toc: true\n---\n",
+        );
+        assert_eq!(
+            removed,
+            vec![
+                Removed::Metadata("include-in-header".to_string()),
+                Removed::Metadata("css".to_string())
+            ]
+        );
+        assert!(doc.meta.contains_key("title"));
+        assert!(doc.meta.contains_path(&["format", "html", "toc"]));
+    }
+
+    #[test]
+    fn test_unknown_metadata_is_removed() {
+        let (doc, removed) = sanitized(
+            "---\ntitle: Safe\ntheme: x.scss\nformat:\n  html:\n    theme: ../../x.scss\n    brand: _brand.yml\n---\n",
+        );
+        assert_eq!(
+            removed,
+            vec![
+                Removed::Metadata("theme".to_string()),
+                Removed::Metadata("theme".to_string()),
+                Removed::Metadata("brand".to_string())
+            ]
+        );
+        assert!(!doc.meta.contains_key("theme"));
+        assert!(doc.meta.contains_path(&["format", "html"]));
+    }
+
+    #[test]
+    fn test_deeply_nested_input_is_refused_by_the_reader() {
+        let input = format!("{}deep\n", "> ".repeat(10_000));
+        let result = readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "<test>",
+            &mut std::io::sink(),
+            true,
+            None,
+        );
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_deep_nesting_is_removed() {
+        let mut doc = read_qmd("> > > deep\n");
+        let options = SanitizeOptions {
+            max_depth: 2,
+            ..Default::default()
+        };
+        let report = sanitize(&mut doc, &options);
+        assert_eq!(report.removed.len(), 1);
+        assert_eq!(report.removed[0].0, Removed::NestedContent);
+        let Block::BlockQuote(outer) = &doc.blocks[0] else {
+            panic!("Expected a block quote");
+        };
+        let Block::BlockQuote(inner) = &outer.content[0] else {
+            panic!("Expected a block quote");
+        };
+        assert!(inner.content.is_empty());
+    }
+
+    #[test]
+    fn test_unsafe_urls() {
+        assert!(is_unsafe_url("JavaScript:alert(1)", false));
+        assert!(is_unsafe_url(" java\tscript:alert(1)", false));
+        assert!(is_unsafe_url("data:text/html,<script>", true));
+        assert!(!is_unsafe_url("data:image/png;base64,AAAA", true));
+        assert!(is_unsafe_url("data:image/png;base64,AAAA", false));
+        assert!(!is_unsafe_url("https://example.com/javascript:", false));
+        assert!(!is_unsafe_url("pages/data.html", false));
+    }
+
+    #[test]
+    fn test_input_size() {
+        let options = SanitizeOptions {
+            max_input_bytes: 4,
+            ..Default::default()
+        };
+        assert!(check_input_size(b"1234", &options).is_ok());
+        let error = check_input_size(b"12345", &options).unwrap_err();
+        assert_eq!(error.code.as_deref(), Some("Q-2-43"));
+    }
+}
@@ -0,0 +1,47 @@
+/*
+ * transforms/smart.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Smart punctuation transform: typographic dashes and ellipses.
+ */
+
+//! Smart punctuation transform, analogous to Pandoc's `smart` extension.
+//!
+//! The qmd reader always reads `'` as an apostrophe and quotes as `Quoted`,
+//! and turns a `--`, `---` or `...` that stands alone into a dash or an
+//! ellipsis. This transform applies the dash and ellipsis rules everywhere
+//! in text, so `1990--2000` and `wait...` come out typographic as well:
+//!
+//! - `---` becomes an em dash (`—`)
+//! - `--` becomes an en dash (`–`)
+//! - `...` becomes an ellipsis (`…`)
+//!
+//! Code, math and raw content are left alone. The qmd writer's `smart`
+//! option is the inverse.
+
+use crate::filter_context::FilterContext;
+use crate::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use crate::pandoc::block::Block;
+use crate::pandoc::inline::Inline;
+
+/// Apply smart punctuation to the text of `blocks`.
+pub fn smart_punctuation(blocks: Vec<Block>) -> Vec<Block> {
+    topdown_traverse_blocks(
+        blocks,
+        &mut Filter::new().with_str(|mut s, _ctx| {
+            let text = smarten(&s.text);
+            if text == s.text {
+                return FilterReturn::Unchanged(s);
+            }
+            s.text = text;
+            FilterReturn::FilterResult(vec![Inline::Str(s)], false)
+        }),
+        &mut FilterContext::new(),
+    )
+}
+
+fn smarten(text: &str) -> String {
+    text.replace("---", "\u{2014}")
+        .replace("--", "\u{2013}")
+        .replace("...", "\u{2026}")
+}
@@ -0,0 +1,375 @@
+/*
+ * transforms/task_lists.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Task lists: list items that start with a checkbox.
+ */
+
+//! Task lists, as Pandoc's `task_lists` extension reads them.
+//!
+//! A list item whose text starts with `[ ]` or `[x]` is a task:
+//!
+//! ```markdown
+//! - [ ] write the tests
+//! - [x] write the code
+//! ```
+//!
+//! Pandoc keeps the checkbox as the first word of the item: `☐` for an
+//! unchecked task and `☒` for a checked one, followed by a space. The qmd
+//! reader reads the checkbox that way, and the writers turn it back into
+//! `[ ]`/`[x]` (qmd), a checkbox (HTML) or a box (LaTeX).
+//!
+//! [`task_items`] lists the tasks of a document and [`set_task_checked`]
+//! checks or unchecks one, so a preview can toggle a checkbox in the AST and
+//! write the source back.
+
+use crate::pandoc::attr::is_empty_attr;
+use crate::pandoc::block::{Block, Blocks};
+use crate::pandoc::inline::{Inline, Inlines, Str};
+use crate::pandoc::{Slot, table::Row};
+use quarto_source_map::SourceInfo;
+
+/// The text of an unchecked task's checkbox
+pub const UNCHECKED: &str = "\u{2610}";
+
+/// The text of a checked task's checkbox
+pub const CHECKED: &str = "\u{2612}";
+
+/// A task list item
+#[derive(Debug, Clone, PartialEq)]
+pub struct TaskItem {
+    pub checked: bool,
+    /// Where the checkbox was read from
+    pub source_info: SourceInfo,
+}
+
+/// The inlines an item's text starts with, when the first block is text
+fn first_inlines(item: &[Block]) -> Option<&Inlines> {
+    match item.first()? {
+        Block::Plain(plain) => Some(&plain.content),
+        Block::Paragraph(para) => Some(&para.content),
+        _ => None,
+    }
+}
+
+fn first_inlines_mut(item: &mut [Block]) -> Option<&mut Inlines> {
+    match item.first_mut()? {
+        Block::Plain(plain) => Some(&mut plain.content),
+        Block::Paragraph(para) => Some(&mut para.content),
+        _ => None,
+    }
+}
+
+/// Whether `inlines` start with a checkbox: whether it is checked
+fn checkbox(inlines: &[Inline]) -> Option<bool> {
+    match inlines {
+        [Inline::Str(s), Inline::Space(_), ..] if s.text == UNCHECKED => Some(false),
+        [Inline::Str(s), Inline::Space(_), ..] if s.text == CHECKED => Some(true),
+        _ => None,
+    }
+}
+
+/// Whether a list item is a task, and if so whether it is checked
+pub fn task_state(item: &[Block]) -> Option<bool> {
+    checkbox(first_inlines(item)?)
+}
+
+/// For a task list item: whether it is checked, and the item without its
+/// checkbox and the space after it
+pub fn task_item_content(item: &[Block]) -> Option<(bool, Blocks)> {
+    let checked = task_state(item)?;
+    let mut content = item.to_vec();
+    first_inlines_mut(&mut content)?.drain(..2);
+    Some((checked, content))
+}
+
+/// Read the checkbox a qmd list item starts with. The reader reads `[ ]`
+/// and `[x]` as Spans without attributes, which become `☐` and `☒`; false
+/// when the item doesn't start with one.
+pub(crate) fn read_task_marker(item: &mut [Block]) -> bool {
+    let Some(inlines) = first_inlines_mut(item) else {
+        return false;
+    };
+    let checked = match inlines.as_slice() {
+        [Inline::Span(span), Inline::Space(_), ..] if is_empty_attr(&span.attr) => {
+            match span.content.as_slice() {
+                [] => false,
+                [Inline::Str(s)] if s.text == "x" || s.text == "X" => true,
+                _ => return false,
+            }
+        }
+        _ => return false,
+    };
+    let Inline::Span(span) = &inlines[0] else {
+        return false;
+    };
+    inlines[0] = Inline::Str(Str {
+        text: if checked { CHECKED } else { UNCHECKED }.to_string(),
+        source_info: span.source_info.clone(),
+    });
+    true
+}
+
+/// The tasks of `blocks`, in document order
+pub fn task_items(blocks: &[Block]) -> Vec<TaskItem> {
+    let mut items = Vec::new();
+    collect_tasks(blocks, &mut items);
+    items
+}
+
+/// Check or uncheck the task `index` of `blocks`, counting in document
+/// order as [`task_items`] does. False when there is no such task.
+pub fn set_task_checked(blocks: &mut [Block], index: usize, checked: bool) -> bool {
+    let mut remaining = index;
+    let mut found = false;
+    visit_tasks_mut(blocks, &mut |marker| {
+        if found {
+            return;
+        }
+        if remaining == 0 {
+            marker.text = if checked { CHECKED } else { UNCHECKED }.to_string();
+            found = true;
+        } else {
+            remaining -= 1;
+        }
+    });
+    found
+}
+
+fn collect_tasks(blocks: &[Block], items: &mut Vec<TaskItem>) {
+    for block in blocks {
+        let list_items = match block {
+            Block::BulletList(list) => &list.content,
+            Block::OrderedList(list) => &list.content,
+            _ => {
+                for child in child_blocks(block) {
+                    collect_tasks(child, items);
+                }
+                continue;
+            }
+        };
+        for item in list_items {
+            if let (Some(checked), Some(Inline::Str(marker))) = (
+                task_state(item),
+                first_inlines(item).and_then(|i| i.first()),
+            ) {
+                items.push(TaskItem {
+                    checked,
+                    source_info: marker.source_info.clone(),
+                });
+            }
+            collect_tasks(item, items);
+        }
+    }
+}
+
+fn visit_tasks_mut(blocks: &mut [Block], visit: &mut dyn FnMut(&mut Str)) {
+    for block in blocks {
+        let list_items = match block {
+            Block::BulletList(list) => &mut list.content,
+            Block::OrderedList(list) => &mut list.content,
+            _ => {
+                for child in child_blocks_mut(block) {
+                    visit_tasks_mut(child, visit);
+                }
+                continue;
+            }
+        };
+        for item in list_items {
+            if task_state(item).is_some()
+                && let Some(Inline::Str(marker)) =
+                    first_inlines_mut(item).and_then(|i| i.first_mut())
+            {
+                visit(marker);
+            }
+            visit_tasks_mut(item, visit);
+        }
+    }
+}
+
+/// The blocks inside `block`, other than the items of a list
+fn child_blocks(block: &Block) -> Vec<&[Block]> {
+    fn rows(rows: &[Row]) -> impl Iterator<Item = &[Block]> {
+        rows.iter()
+            .flat_map(|row| row.cells.iter().map(|cell| cell.content.as_slice()))
+    }
+    match block {
+        Block::BlockQuote(quote) => vec![quote.content.as_slice()],
+        Block::DefinitionList(list) => list
+            .content
+            .iter()
+            .flat_map(|(_, definitions)| definitions.iter().map(Vec::as_slice))
+            .collect(),
+        Block::Figure(figure) => vec![figure.content.as_slice()],
+        Block::Div(div) => vec![div.content.as_slice()],
+        Block::NoteDefinitionFencedBlock(note) => vec![note.content.as_slice()],
+        Block::Table(table) => rows(&table.head.rows)
+            .chain(
+                table
+                    .bodies
+                    .iter()
+                    .flat_map(|body| rows(&body.head).chain(rows(&body.body))),
+            )
+            .chain(rows(&table.foot.rows))
+            .collect(),
+        Block::Custom(custom) => custom
+            .slots
+            .values()
+            .filter_map(|slot| match slot {
+                Slot::Blocks(blocks) => Some(blocks.as_slice()),
+                Slot::Block(block) => Some(std::slice::from_ref(block.as_ref())),
+                Slot::Inline(_) | Slot::Inlines(_) => None,
+            })
+            .collect(),
+        _ => Vec::new(),
+    }
+}
+
+fn child_blocks_mut(block: &mut Block) -> Vec<&mut [Block]> {
+    fn rows(rows: &mut [Row]) -> impl Iterator<Item = &mut [Block]> {
+        rows.iter_mut()
+            .flat_map(|row| row.cells.iter_mut().map(|cell| cell.content.as_mut_slice()))
+    }
+    match block {
+        Block::BlockQuote(quote) => vec![quote.content.as_mut_slice()],
+        Block::DefinitionList(list) => list
+            .content
+            .iter_mut()
+            .flat_map(|(_, definitions)| definitions.iter_mut().map(Vec::as_mut_slice))
+            .collect(),
+        Block::Figure(figure) => vec![figure.content.as_mut_slice()],
+        Block::Div(div) => vec![div.content.as_mut_slice()],
+        Block::NoteDefinitionFencedBlock(note) => vec![note.content.as_mut_slice()],
+        Block::Table(table) => rows(&mut table.head.rows)
+            .chain(
+                table
+                    .bodies
+                    .iter_mut()
+                    .flat_map(|body| rows(&mut body.head).chain(rows(&mut body.body))),
+            )
+            .chain(rows(&mut table.foot.rows))
+            .collect(),
+        Block::Custom(custom) => custom
+            .slots
+            .values_mut()
+            .filter_map(|slot| match slot {
+                Slot::Blocks(blocks) => Some(blocks.as_mut_slice()),
+                Slot::Block(block) => Some(std::slice::from_mut(block.as_mut())),
+                Slot::Inline(_) | Slot::Inlines(_) => None,
+            })
+            .collect(),
+        _ => Vec::new(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::block::{BulletList, Plain};
+    use crate::pandoc::inline::{Space, Span};
+    use hashlink::LinkedHashMap;
+    use quarto_pandoc_types::AttrSourceInfo;
+
+    fn str_inline(text: &str) -> Inline {
+        Inline::Str(Str {
+            text: text.to_string(),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn space() -> Inline {
+        Inline::Space(Space {
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn plain(content: Inlines) -> Block {
+        Block::Plain(Plain {
+            content,
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn span(content: Inlines) -> Inline {
+        Inline::Span(Span {
+            attr: (String::new(), vec![], LinkedHashMap::new()),
+            content,
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn list(items: Vec<Blocks>) -> Block {
+        Block::BulletList(BulletList {
+            content: items,
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    #[test]
+    fn test_read_task_marker() {
+        let mut unchecked = vec![plain(vec![span(vec![]), space(), str_inline("todo")])];
+        assert!(read_task_marker(&mut unchecked));
+        assert_eq!(task_state(&unchecked), Some(false));
+
+        let mut checked = vec![plain(vec![
+            span(vec![str_inline("X")]),
+            space(),
+            str_inline("done"),
+        ])];
+        assert!(read_task_marker(&mut checked));
+        assert_eq!(task_state(&checked), Some(true));
+
+        // A span of other text, or one with nothing after it, isn't a checkbox
+        let mut other = vec![plain(vec![
+            span(vec![str_inline("y")]),
+            space(),
+            str_inline("no"),
+        ])];
+        assert!(!read_task_marker(&mut other));
+        let mut alone = vec![plain(vec![span(vec![])])];
+        assert!(!read_task_marker(&mut alone));
+        assert_eq!(task_state(&alone), None);
+    }
+
+    #[test]
+    fn test_task_item_content() {
+        let item = vec![plain(vec![
+            str_inline(CHECKED),
+            space(),
+            str_inline("done"),
+        ])];
+        let (checked, content) = task_item_content(&item).unwrap();
+        assert!(checked);
+        assert_eq!(content, vec![plain(vec![str_inline("done")])]);
+    }
+
+    #[test]
+    fn test_toggle_in_document_order() {
+        let nested = list(vec![vec![plain(vec![
+            str_inline(UNCHECKED),
+            space(),
+            str_inline("nested"),
+        ])]]);
+        let mut blocks = vec![list(vec![
+            vec![
+                plain(vec![str_inline(UNCHECKED), space(), str_inline("first")]),
+                nested,
+            ],
+            vec![plain(vec![
+                str_inline(CHECKED),
+                space(),
+                str_inline("last"),
+            ])],
+            vec![plain(vec![str_inline("not a task")])],
+        ])];
+        let states: Vec<bool> = task_items(&blocks).iter().map(|t| t.checked).collect();
+        assert_eq!(states, vec![false, false, true]);
+
+        assert!(set_task_checked(&mut blocks, 1, true));
+        assert!(set_task_checked(&mut blocks, 2, false));
+        assert!(!set_task_checked(&mut blocks, 3, true));
+        let states: Vec<bool> = task_items(&blocks).iter().map(|t| t.checked).collect();
+        assert_eq!(states, vec![false, true, false]);
+    }
+}
@@ -0,0 +1,133 @@
+/*
+ * ast_equal.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Structural AST equality that ignores source locations.
+//!
+//! This is the oracle for round-trip tests and fuzzing: two documents are
+//! equal when their JSON serializations agree once every source-tracking
+//! field is removed. Going through JSON means every node type is covered
+//! without a hand-written comparison per variant.
+
+use crate::pandoc::Pandoc;
+use crate::pandoc::ast_context::ASTContext;
+use crate::writers::json::{JsonConfig, write_pandoc};
+use serde_json::Value;
+
+/// JSON fields that only carry source locations
+pub(crate) const LOCATION_FIELDS: &[&str] =
+    &["s", "l", "astContext", "attrS", "targetS", "citationIdS"];
+
+fn remove_location_fields(json: &mut Value) {
+    match json {
+        Value::Object(obj) => {
+            for field in LOCATION_FIELDS {
+                obj.remove(*field);
+            }
+            obj.values_mut().for_each(remove_location_fields);
+        }
+        Value::Array(items) => items.iter_mut().for_each(remove_location_fields),
+        _ => {}
+    }
+}
+
+/// The document as JSON without source locations
+pub fn comparable_json(pandoc: &Pandoc) -> Value {
+    let config = JsonConfig {
+        include_inline_locations: false,
+        include_block_ids: false,
+    };
+    let mut json = write_pandoc(pandoc, &ASTContext::anonymous(), &config)
+        .expect("Failed to serialize AST to JSON");
+    remove_location_fields(&mut json);
+    json
+}
+
+/// Describe the first place where two JSON values differ
+fn first_difference(path: &str, expected: &Value, actual: &Value) -> Option<String> {
+    match (expected, actual) {
+        (Value::Object(a), Value::Object(b)) => {
+            for (key, value) in a {
+                let child = format!("{}.{}", path, key);
+                match b.get(key) {
+                    Some(other) => {
+                        if let Some(diff) = first_difference(&child, value, other) {
+                            return Some(diff);
+                        }
+                    }
+                    None => return Some(format!("{}: missing in actual", child)),
+                }
+            }
+            b.keys()
+                .find(|key| !a.contains_key(*key))
+                .map(|key| format!("{}.{}: unexpected in actual", path, key))
+        }
+        (Value::Array(a), Value::Array(b)) => {
+            for (i, (x, y)) in a.iter().zip(b).enumerate() {
+                if let Some(diff) = first_difference(&format!("{}[{}]", path, i), x, y) {
+                    return Some(diff);
+                }
+            }
+            (a.len() != b.len())
+                .then(|| format!("{}: expected {} elements, got {}", path, a.len(), b.len()))
+        }
+        _ => (expected != actual).then(|| {
+            format!(
+                "{}:\n  expected: {}\n  actual:   {}",
+                path, expected, actual
+            )
+        }),
+    }
+}
+
+/// Compare two documents ignoring source locations.
+///
+/// Returns `None` when they are equal, or a description of the first
+/// difference.
+pub fn ast_difference(expected: &Pandoc, actual: &Pandoc) -> Option<String> {
+    first_difference("$", &comparable_json(expected), &comparable_json(actual))
+}
+
+/// Panic with the first difference unless the documents are equal
+/// ignoring source locations.
+pub fn expect_pd_ast_equal(expected: &Pandoc, actual: &Pandoc) {
+    if let Some(diff) = ast_difference(expected, actual) {
+        panic!("ASTs differ at {}", diff);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::{Block, Inline, Paragraph, Str};
+    use quarto_source_map::{FileId, SourceInfo};
+
+    fn para(text: &str, source_info: SourceInfo) -> Pandoc {
+        Pandoc {
+            meta: Default::default(),
+            blocks: vec![Block::Paragraph(Paragraph {
+                content: vec![Inline::Str(Str {
+                    text: text.to_string(),
+                    source_info: source_info.clone(),
+                })],
+                source_info,
+            })],
+        }
+    }
+
+    #[test]
+    fn test_source_locations_are_ignored() {
+        let a = para("hello", SourceInfo::original(FileId(0), 0, 5));
+        let b = para("hello", SourceInfo::original(FileId(1), 10, 40));
+        assert_eq!(ast_difference(&a, &b), None);
+    }
+
+    #[test]
+    fn test_difference_names_the_path() {
+        let a = para("hello", SourceInfo::default());
+        let b = para("world", SourceInfo::default());
+        let diff = ast_difference(&a, &b).expect("Documents should differ");
+        assert!(diff.starts_with("$.blocks[0].c[0].c:"), "Got: {}", diff);
+    }
+}
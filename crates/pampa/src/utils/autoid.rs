@@ -3,56 +3,310 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
+//! Identifiers made from the text of headings, as Pandoc's
+//! `auto_identifiers` and `gfm_auto_identifiers` extensions make them.
+//!
+//! Pandoc's markdown identifiers:
+//!
+//! - all formatting, links and footnotes are removed, keeping their text
+//! - letters are lowercased
+//! - everything but letters, numbers, `_`, `-` and `.` is removed
+//! - spaces and newlines become `-`, one for each run of them
+//! - everything up to the first letter is removed
+//! - nothing left gives `section`
+//!
+//! GitHub's identifiers keep numbers at the start and the marks some
+//! scripts write vowels with, turn each space into a `-`, and don't keep
+//! `.`. With `ascii_identifiers`, accents are removed and other letters
+//! outside ASCII are dropped.
+
 use crate::pandoc::{Inline, Inlines};
-use std::fmt::Write;
+use std::collections::HashSet;
+use unicode_normalization::UnicodeNormalization;
+use unicode_normalization::char::is_combining_mark;
+
+/// Whose rules an identifier is made by
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum IdentifierStyle {
+    /// `auto_identifiers`
+    #[default]
+    Pandoc,
+    /// `gfm_auto_identifiers`
+    Gfm,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct IdentifierOptions {
+    pub style: IdentifierStyle,
+    /// Only ASCII characters, with accents removed (`ascii_identifiers`)
+    pub ascii: bool,
+}
+
+/// The identifier of a heading that has no content to make one from
+const EMPTY_IDENTIFIER: &str = "section";
 
+/// The text of `inlines`, as Pandoc's `stringify` has it: notes are left
+/// out, and breaks are spaces.
 fn collect_text(inlines: &Inlines, result: &mut String) {
     for inline in inlines {
         match inline {
-            Inline::Str(s) => {
-                write!(result, "{}", s.text).unwrap();
-            }
-            Inline::Space(_) => {
-                write!(result, " ").unwrap();
-            }
-            Inline::Emph(e) => {
-                collect_text(&e.content, result);
-            }
-            Inline::Strong(s) => {
-                collect_text(&s.content, result);
-            }
-            Inline::Code(c) => {
-                write!(result, "{}", c.text).unwrap();
-            }
+            Inline::Str(s) => result.push_str(&s.text),
+            Inline::Code(c) => result.push_str(&c.text),
+            Inline::Math(m) => result.push_str(&m.text),
+            Inline::Space(_) | Inline::SoftBreak(_) | Inline::LineBreak(_) => result.push(' '),
+            Inline::Emph(e) => collect_text(&e.content, result),
+            Inline::Strong(s) => collect_text(&s.content, result),
+            Inline::Underline(u) => collect_text(&u.content, result),
+            Inline::Strikeout(s) => collect_text(&s.content, result),
+            Inline::Superscript(s) => collect_text(&s.content, result),
+            Inline::Subscript(s) => collect_text(&s.content, result),
+            Inline::SmallCaps(s) => collect_text(&s.content, result),
+            Inline::Quoted(q) => collect_text(&q.content, result),
+            Inline::Cite(c) => collect_text(&c.content, result),
+            Inline::Link(l) => collect_text(&l.content, result),
+            Inline::Image(i) => collect_text(&i.content, result),
+            Inline::Span(s) => collect_text(&s.content, result),
+            Inline::Insert(i) => collect_text(&i.content, result),
+            Inline::Delete(d) => collect_text(&d.content, result),
+            Inline::Highlight(h) => collect_text(&h.content, result),
             _ => {
-                // Skip other inline types for ID generation
+                // Notes, raw inlines, comments and attributes have no
+                // text of the heading
             }
         }
     }
 }
 
-pub fn auto_generated_id(inlines: &Inlines) -> String {
-    let mut text = String::new();
-    collect_text(inlines, &mut text);
+/// Connector punctuation (Unicode category Pc), which GitHub keeps
+fn is_connector_punctuation(c: char) -> bool {
+    matches!(
+        c,
+        '_' | '\u{203f}'
+            | '\u{2040}'
+            | '\u{2054}'
+            | '\u{fe33}'
+            | '\u{fe34}'
+            | '\u{fe4d}'
+            | '\u{fe4e}'
+            | '\u{fe4f}'
+            | '\u{ff3f}'
+    )
+}
 
-    // Match Pandoc's behavior:
-    // - Keep alphanumeric (lowercased), periods, underscores, hyphens
-    // - Convert spaces to hyphens
-    // - Remove other characters
+/// A letter or a number. Rust's `is_alphanumeric` also counts the marks
+/// some scripts write vowels with, which Pandoc doesn't.
+fn is_letter_or_number(c: char) -> bool {
+    c.is_alphanumeric() && !is_combining_mark(c)
+}
+
+fn pandoc_identifier(text: &str) -> String {
+    let kept: String = text
+        .to_lowercase()
+        .chars()
+        .filter(|&c| is_letter_or_number(c) || c.is_whitespace() || matches!(c, '_' | '-' | '.'))
+        .collect();
+    kept.split_whitespace().collect::<Vec<_>>().join("-")
+}
+
+fn gfm_identifier(text: &str) -> String {
     text.to_lowercase()
         .chars()
         .filter_map(|c| {
-            if c.is_alphanumeric() || c == '.' || c == '_' || c == '-' {
-                Some(c)
-            } else if c.is_whitespace() {
+            if c.is_whitespace() {
                 Some('-')
+            } else if c.is_alphanumeric()
+                || is_combining_mark(c)
+                || is_connector_punctuation(c)
+                || c == '-'
+            {
+                Some(c)
             } else {
                 None
             }
         })
-        .collect::<String>()
-        .split('-')
-        .filter(|s| !s.is_empty())
-        .collect::<Vec<&str>>()
-        .join("-")
+        .collect()
+}
+
+/// `text` without accents, and without the characters that have no ASCII
+/// letter under them
+fn to_ascii(text: &str) -> String {
+    text.nfd().filter(char::is_ascii).collect()
+}
+
+/// The text identifiers are made from: that of the inlines, with a space
+/// for each space or break
+pub fn plain_text(inlines: &Inlines) -> String {
+    let mut text = String::new();
+    collect_text(inlines, &mut text);
+    text
+}
+
+/// The identifier made from `inlines`, before it is made unique: empty
+/// when there is no text to make one from.
+pub fn identifier(inlines: &Inlines, options: IdentifierOptions) -> String {
+    let text = plain_text(inlines);
+    let id = match options.style {
+        IdentifierStyle::Pandoc => pandoc_identifier(&text),
+        IdentifierStyle::Gfm => gfm_identifier(&text),
+    };
+    let id = if options.ascii { to_ascii(&id) } else { id };
+    match options.style {
+        IdentifierStyle::Pandoc => id
+            .trim_start_matches(|c: char| !c.is_alphabetic())
+            .to_string(),
+        IdentifierStyle::Gfm => id,
+    }
+}
+
+/// The identifier the qmd reader gives a heading with `inlines`, before it
+/// is made unique
+pub fn auto_generated_id(inlines: &Inlines) -> String {
+    let id = identifier(inlines, IdentifierOptions::default());
+    if id.is_empty() {
+        EMPTY_IDENTIFIER.to_string()
+    } else {
+        id
+    }
+}
+
+/// `base`, or when it is taken, the first of `base-1`, `base-2`, ... that
+/// isn't. An empty `base` is `section`.
+pub fn unique_id(base: &str, used: &HashSet<String>) -> String {
+    let base = if base.is_empty() {
+        EMPTY_IDENTIFIER
+    } else {
+        base
+    };
+    if !used.contains(base) {
+        return base.to_string();
+    }
+    (1..)
+        .map(|n| format!("{}-{}", base, n))
+        .find(|id| !used.contains(id))
+        .unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::{Emph, Link, Space, Str};
+    use hashlink::LinkedHashMap;
+    use quarto_pandoc_types::attr::{AttrSourceInfo, TargetSourceInfo};
+    use quarto_source_map::SourceInfo;
+
+    fn str_inline(text: &str) -> Inline {
+        Inline::Str(Str {
+            text: text.to_string(),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn words(text: &str) -> Inlines {
+        let mut inlines = Vec::new();
+        for (i, word) in text.split(' ').enumerate() {
+            if i > 0 {
+                inlines.push(Inline::Space(Space {
+                    source_info: SourceInfo::default(),
+                }));
+            }
+            inlines.push(str_inline(word));
+        }
+        inlines
+    }
+
+    const GFM: IdentifierOptions = IdentifierOptions {
+        style: IdentifierStyle::Gfm,
+        ascii: false,
+    };
+
+    #[test]
+    fn test_pandoc_identifiers() {
+        // The examples of Pandoc's manual
+        assert_eq!(
+            auto_generated_id(&words("Heading identifiers in HTML")),
+            "heading-identifiers-in-html"
+        );
+        assert_eq!(auto_generated_id(&words("Maître d'hôtel")), "maître-dhôtel");
+        assert_eq!(
+            auto_generated_id(&words("*Dogs*?--in my house?")),
+            "dogs--in-my-house"
+        );
+        assert_eq!(auto_generated_id(&words("3. Applications")), "applications");
+        assert_eq!(auto_generated_id(&words("33")), "section");
+        assert_eq!(auto_generated_id(&words("Release 1.2.3")), "release-1.2.3");
+        assert_eq!(auto_generated_id(&words("A - B")), "a---b");
+    }
+
+    #[test]
+    fn test_text_of_formatting_and_links() {
+        let inlines = vec![
+            Inline::Emph(Emph {
+                content: words("Reading"),
+                source_info: SourceInfo::default(),
+            }),
+            Inline::Space(Space {
+                source_info: SourceInfo::default(),
+            }),
+            Inline::Link(Link {
+                attr: (String::new(), vec![], LinkedHashMap::new()),
+                content: words("the manual"),
+                target: ("https://example.com".to_string(), String::new()),
+                source_info: SourceInfo::default(),
+                attr_source: AttrSourceInfo::empty(),
+                target_source: TargetSourceInfo::empty(),
+            }),
+        ];
+        assert_eq!(auto_generated_id(&inlines), "reading-the-manual");
+    }
+
+    #[test]
+    fn test_unicode_case_folding() {
+        assert_eq!(auto_generated_id(&words("ΣΟΦΙΑ Straße")), "σοφια-straße");
+        assert_eq!(auto_generated_id(&words("Ärger ÜBER Öl")), "ärger-über-öl");
+        assert_eq!(
+            auto_generated_id(&words("日本語の見出し")),
+            "日本語の見出し"
+        );
+    }
+
+    #[test]
+    fn test_gfm_identifiers() {
+        assert_eq!(identifier(&words("3. Applications"), GFM), "3-applications");
+        assert_eq!(identifier(&words("A - B"), GFM), "a---b");
+        assert_eq!(identifier(&words("Release 1.2.3"), GFM), "release-123");
+        assert_eq!(
+            identifier(&words("snake_case and C++"), GFM),
+            "snake_case-and-c"
+        );
+        // Pandoc drops the vowel signs of Devanagari, GitHub keeps them
+        assert_eq!(identifier(&words("हिन्दी"), GFM), "हिन्दी");
+        assert_eq!(auto_generated_id(&words("हिन्दी")), "हनद");
+    }
+
+    #[test]
+    fn test_ascii_identifiers() {
+        let ascii = IdentifierOptions {
+            style: IdentifierStyle::Pandoc,
+            ascii: true,
+        };
+        assert_eq!(identifier(&words("Maître d'hôtel"), ascii), "maitre-dhotel");
+        assert_eq!(identifier(&words("Ça va"), ascii), "ca-va");
+        assert_eq!(identifier(&words("日本 Notes"), ascii), "notes");
+        let gfm_ascii = IdentifierOptions { ascii: true, ..GFM };
+        assert_eq!(
+            identifier(&words("Crème brûlée"), gfm_ascii),
+            "creme-brulee"
+        );
+    }
+
+    #[test]
+    fn test_unique_id() {
+        let mut used = HashSet::new();
+        assert_eq!(unique_id("intro", &used), "intro");
+        used.insert("intro".to_string());
+        assert_eq!(unique_id("intro", &used), "intro-1");
+        used.insert("intro-1".to_string());
+        assert_eq!(unique_id("intro", &used), "intro-2");
+        assert_eq!(unique_id("", &used), "section");
+    }
 }
@@ -0,0 +1,199 @@
+/*
+ * block_ids.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Stable identifiers for blocks, for addressing them across re-parses.
+//!
+//! With the `block_ids` reader extension, the JSON writer gives every block
+//! a `blockId` field. The sync layer, comments and preview patching use
+//! these to find a block again after the document is edited and read again,
+//! when source offsets have moved.
+//!
+//! An ID is made from the block's content and its place among blocks with
+//! the same content, never from source locations: it is a hash of the
+//! block's JSON without location fields (what [`super::ast_equal`]
+//! compares), followed by `-2`, `-3`, ... for the second and later of
+//! identical blocks, in the order they are written. So:
+//!
+//! - reading a document twice gives the same IDs;
+//! - writing a document as qmd and reading it back gives the same IDs,
+//!   since the writer round-trips the AST;
+//! - editing a block changes its ID and those of the blocks that contain
+//!   it, and no others, unless the block had duplicates.
+
+use super::ast_equal::LOCATION_FIELDS;
+use serde_json::Value;
+use sha1::{Digest, Sha1};
+use std::collections::HashMap;
+
+/// The JSON field holding a block's ID
+pub const BLOCK_ID_FIELD: &str = "blockId";
+
+/// Hex digits of the content hash kept in an ID
+const ID_DIGITS: usize = 12;
+
+/// Assigns IDs to the blocks of one document, as they are written
+#[derive(Debug, Default)]
+pub struct BlockIds {
+    /// How many blocks with each content hash have had an ID
+    seen: HashMap<String, usize>,
+}
+
+impl BlockIds {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add a `blockId` to the JSON of a block. The blocks nested in it are
+    /// expected to have theirs already; they don't take part in its hash.
+    pub fn assign(&mut self, block: &mut Value) {
+        let hash = content_hash(block);
+        let count = self.seen.entry(hash.clone()).or_insert(0);
+        *count += 1;
+        let id = if *count == 1 {
+            hash
+        } else {
+            format!("{}-{}", hash, count)
+        };
+        if let Value::Object(obj) = block {
+            obj.insert(BLOCK_ID_FIELD.to_string(), Value::String(id));
+        }
+    }
+}
+
+/// The hash of a block's JSON, without locations or nested IDs
+fn content_hash(block: &Value) -> String {
+    let mut hasher = Sha1::new();
+    hash_value(block, &mut hasher);
+    hasher
+        .finalize()
+        .iter()
+        .map(|b| format!("{:02x}", b))
+        .collect::<String>()[..ID_DIGITS]
+        .to_string()
+}
+
+/// Feed `value` to the hasher, with sorted keys, so that the hash doesn't
+/// depend on how serde_json orders maps
+fn hash_value(value: &Value, hasher: &mut Sha1) {
+    match value {
+        Value::Object(obj) => {
+            let mut keys: Vec<&String> = obj
+                .keys()
+                .filter(|key| {
+                    key.as_str() != BLOCK_ID_FIELD && !LOCATION_FIELDS.contains(&key.as_str())
+                })
+                .collect();
+            keys.sort();
+            hasher.update(b"{");
+            for key in keys {
+                hash_value(&Value::String(key.clone()), hasher);
+                hasher.update(b":");
+                hash_value(&obj[key], hasher);
+                hasher.update(b",");
+            }
+            hasher.update(b"}");
+        }
+        Value::Array(items) => {
+            hasher.update(b"[");
+            for item in items {
+                hash_value(item, hasher);
+                hasher.update(b",");
+            }
+            hasher.update(b"]");
+        }
+        _ => hasher.update(value.to_string().as_bytes()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::writers::json::{JsonConfig, write_pandoc};
+
+    fn ids(qmd: &str) -> Vec<String> {
+        let (pandoc, context, _) = crate::readers::qmd::read(
+            qmd.as_bytes(),
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .expect("Failed to parse QMD");
+        let config = JsonConfig {
+            include_inline_locations: false,
+            include_block_ids: true,
+        };
+        let json = write_pandoc(&pandoc, &context, &config).expect("Failed to write JSON");
+        let mut ids = Vec::new();
+        collect_ids(&json["blocks"], &mut ids);
+        ids
+    }
+
+    /// Every `blockId` in `json`, with each block's after those of the
+    /// blocks in it
+    fn collect_ids(json: &Value, ids: &mut Vec<String>) {
+        match json {
+            Value::Object(obj) => {
+                obj.values().for_each(|value| collect_ids(value, ids));
+                if let Some(Value::String(id)) = obj.get(BLOCK_ID_FIELD) {
+                    ids.push(id.clone());
+                }
+            }
+            Value::Array(items) => items.iter().for_each(|item| collect_ids(item, ids)),
+            _ => {}
+        }
+    }
+
+    #[test]
+    fn test_ids_ignore_source_locations() {
+        let a = ids("Hello world.\n\nBye.\n");
+        let b = ids("\n\n\nHello    world.\n\n\n\nBye.\n");
+        assert_eq!(a, b);
+        assert_eq!(a.len(), 2);
+        assert_ne!(a[0], a[1]);
+    }
+
+    #[test]
+    fn test_identical_blocks_are_numbered() {
+        let ids = ids("Same.\n\nOther.\n\nSame.\n");
+        assert_eq!(ids[2], format!("{}-2", ids[0]));
+    }
+
+    #[test]
+    fn test_edits_change_only_the_edited_blocks() {
+        let before = ids("::: {.note}\nInside.\n:::\n\nOutside.\n");
+        let after = ids("::: {.note}\nInside, edited.\n:::\n\nOutside.\n");
+        // The paragraph in the div, and the div, change
+        assert_ne!(before[0], after[0]);
+        assert_ne!(before[1], after[1]);
+        assert_eq!(before[2], after[2]);
+    }
+
+    #[test]
+    fn test_ids_survive_a_qmd_round_trip() {
+        let input = "# Title\n\n* one\n* two\n\n> Quoted *text*.\n";
+        let (pandoc, _, _) = crate::readers::qmd::read(
+            input.as_bytes(),
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .expect("Failed to parse QMD");
+        let mut written = Vec::new();
+        crate::writers::qmd::write(&pandoc, &mut written).expect("Failed to write QMD");
+        assert_eq!(ids(input), ids(&String::from_utf8(written).unwrap()));
+    }
+
+    #[test]
+    fn test_assign_adds_the_field() {
+        let mut block = serde_json::json!({"t": "HorizontalRule", "s": 3});
+        BlockIds::new().assign(&mut block);
+        let id = block[BLOCK_ID_FIELD].as_str().unwrap();
+        assert_eq!(id.len(), ID_DIGITS);
+    }
+}
@@ -0,0 +1,200 @@
+/*
+ * line_endings.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Byte order marks and line endings.
+//!
+//! The reader and the writers work with `\n` line endings and no byte order
+//! mark: a BOM hides front matter from the reader, and a `\r` would end up
+//! in the text of code and raw blocks. Input is noted with
+//! [`TextStyle::detect`] and [`normalize`]d before it is read, and output
+//! is given a style back with [`restore`].
+
+use std::borrow::Cow;
+use std::str::FromStr;
+
+const BOM: &str = "\u{feff}";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum LineEnding {
+    #[default]
+    Lf,
+    CrLf,
+}
+
+/// How a text file is encoded around its content
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct TextStyle {
+    /// Whether it starts with a UTF-8 byte order mark
+    pub bom: bool,
+    pub line_ending: LineEnding,
+}
+
+impl TextStyle {
+    /// The style of `text`: its line ending is the one most of its lines
+    /// end with, `\n` when there are as many of each.
+    pub fn detect(text: &str) -> Self {
+        let line_breaks = text.matches('\n').count();
+        let crlf = text.matches("\r\n").count();
+        TextStyle {
+            bom: text.starts_with(BOM),
+            line_ending: if crlf * 2 > line_breaks {
+                LineEnding::CrLf
+            } else {
+                LineEnding::Lf
+            },
+        }
+    }
+}
+
+/// What `--line-endings` asks of output
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum OutputLineEndings {
+    /// The line ending of the input, and its BOM when writing qmd
+    #[default]
+    Preserve,
+    Lf,
+    CrLf,
+}
+
+impl FromStr for OutputLineEndings {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "preserve" => Ok(OutputLineEndings::Preserve),
+            "lf" => Ok(OutputLineEndings::Lf),
+            "crlf" => Ok(OutputLineEndings::CrLf),
+            _ => Err(format!(
+                "unknown line endings '{}' (expected preserve, lf or crlf)",
+                s
+            )),
+        }
+    }
+}
+
+impl OutputLineEndings {
+    /// The style to write output in, for input in `input`. A BOM is only
+    /// kept when `same_format` (qmd written from qmd): other formats have
+    /// no use for it, and JSON parsers reject it.
+    pub fn output_style(self, input: TextStyle, same_format: bool) -> TextStyle {
+        match self {
+            OutputLineEndings::Preserve => TextStyle {
+                bom: input.bom && same_format,
+                line_ending: input.line_ending,
+            },
+            OutputLineEndings::Lf => TextStyle::default(),
+            OutputLineEndings::CrLf => TextStyle {
+                bom: false,
+                line_ending: LineEnding::CrLf,
+            },
+        }
+    }
+}
+
+/// `text` without a BOM, and with `\r\n` line endings turned into `\n`.
+/// Lone `\r`s are kept.
+pub fn normalize(text: &str) -> Cow<'_, str> {
+    let text = text.strip_prefix(BOM).unwrap_or(text);
+    if text.contains("\r\n") {
+        Cow::Owned(text.replace("\r\n", "\n"))
+    } else {
+        Cow::Borrowed(text)
+    }
+}
+
+/// `text`, written with `\n` line endings and no BOM, in `style`
+pub fn restore(text: Vec<u8>, style: TextStyle) -> Vec<u8> {
+    if style == TextStyle::default() {
+        return text;
+    }
+    let mut restored = Vec::with_capacity(text.len() + text.len() / 32 + BOM.len());
+    if style.bom {
+        restored.extend_from_slice(BOM.as_bytes());
+    }
+    match style.line_ending {
+        LineEnding::Lf => restored.extend_from_slice(&text),
+        LineEnding::CrLf => {
+            for (i, &byte) in text.iter().enumerate() {
+                if byte == b'\n' && (i == 0 || text[i - 1] != b'\r') {
+                    restored.push(b'\r');
+                }
+                restored.push(byte);
+            }
+        }
+    }
+    restored
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_detect() {
+        assert_eq!(TextStyle::detect("a\nb\n"), TextStyle::default());
+        assert_eq!(
+            TextStyle::detect("\u{feff}a\r\nb\r\n"),
+            TextStyle {
+                bom: true,
+                line_ending: LineEnding::CrLf
+            }
+        );
+        // Mixed line endings go with the majority
+        assert_eq!(
+            TextStyle::detect("a\r\nb\r\nc\n").line_ending,
+            LineEnding::CrLf
+        );
+        assert_eq!(TextStyle::detect("a\r\nb\n").line_ending, LineEnding::Lf);
+    }
+
+    #[test]
+    fn test_normalize() {
+        assert!(matches!(normalize("a\nb\n"), Cow::Borrowed("a\nb\n")));
+        assert_eq!(
+            normalize("\u{feff}---\r\nx: 1\r\n---\r\n"),
+            "---\nx: 1\n---\n"
+        );
+        assert_eq!(normalize("a\rb\r\n"), "a\rb\n");
+    }
+
+    #[test]
+    fn test_restore() {
+        let crlf = TextStyle {
+            bom: false,
+            line_ending: LineEnding::CrLf,
+        };
+        assert_eq!(restore(b"a\nb\n".to_vec(), crlf), b"a\r\nb\r\n");
+        // Line endings already written as \r\n aren't doubled
+        assert_eq!(restore(b"a\r\nb\n".to_vec(), crlf), b"a\r\nb\r\n");
+        let bom = TextStyle {
+            bom: true,
+            line_ending: LineEnding::Lf,
+        };
+        assert_eq!(restore(b"a\n".to_vec(), bom), "\u{feff}a\n".as_bytes());
+    }
+
+    #[test]
+    fn test_round_trip() {
+        let input = "\u{feff}# Title\r\n\r\nText.\r\n";
+        let style = TextStyle::detect(input);
+        let output = restore(normalize(input).into_owned().into_bytes(), style);
+        assert_eq!(output, input.as_bytes());
+    }
+
+    #[test]
+    fn test_output_style() {
+        let input = TextStyle {
+            bom: true,
+            line_ending: LineEnding::CrLf,
+        };
+        assert_eq!(OutputLineEndings::Preserve.output_style(input, true), input);
+        assert!(!OutputLineEndings::Preserve.output_style(input, false).bom);
+        assert_eq!(
+            OutputLineEndings::Lf.output_style(input, true),
+            TextStyle::default()
+        );
+        assert_eq!("crlf".parse(), Ok(OutputLineEndings::CrLf));
+    }
+}
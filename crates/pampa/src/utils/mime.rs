@@ -0,0 +1,67 @@
+/*
+ * mime.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use std::path::Path;
+
+/// Guess MIME type from file extension
+pub fn guess_mime_type(filepath: &str) -> String {
+    let path = Path::new(filepath);
+    let ext = path
+        .extension()
+        .and_then(|e| e.to_str())
+        .map(|e| e.to_lowercase());
+
+    match ext.as_deref() {
+        // Images
+        Some("png") => "image/png",
+        Some("jpg" | "jpeg") => "image/jpeg",
+        Some("gif") => "image/gif",
+        Some("svg") => "image/svg+xml",
+        Some("webp") => "image/webp",
+        Some("bmp") => "image/bmp",
+        Some("ico") => "image/x-icon",
+        Some("tiff" | "tif") => "image/tiff",
+
+        // Documents
+        Some("pdf") => "application/pdf",
+        Some("html" | "htm") => "text/html",
+        Some("css") => "text/css",
+        Some("js") => "application/javascript",
+        Some("json") => "application/json",
+        Some("xml") => "application/xml",
+        Some("txt") => "text/plain",
+        Some("md" | "markdown") => "text/markdown",
+        Some("tex") => "application/x-tex",
+        Some("csv") => "text/csv",
+
+        // Fonts
+        Some("woff") => "font/woff",
+        Some("woff2") => "font/woff2",
+        Some("ttf") => "font/ttf",
+        Some("otf") => "font/otf",
+        Some("eot") => "application/vnd.ms-fontobject",
+
+        // Audio
+        Some("mp3") => "audio/mpeg",
+        Some("wav") => "audio/wav",
+        Some("ogg") => "audio/ogg",
+        Some("flac") => "audio/flac",
+
+        // Video
+        Some("mp4") => "video/mp4",
+        Some("webm") => "video/webm",
+        Some("avi") => "video/x-msvideo",
+        Some("mov") => "video/quicktime",
+
+        // Archives
+        Some("zip") => "application/zip",
+        Some("tar") => "application/x-tar",
+        Some("gz") => "application/gzip",
+
+        // Default
+        _ => "application/octet-stream",
+    }
+    .to_string()
+}
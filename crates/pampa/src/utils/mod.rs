@@ -3,12 +3,18 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
+pub mod ast_equal;
 pub mod autoid;
+pub mod block_ids;
 pub mod concrete_tree_depth;
 pub mod diagnostic_collector;
+pub mod line_endings;
+pub mod mime;
 pub mod output;
 pub mod text;
+pub mod timestamp;
 pub mod trim_source_location;
+pub mod zip;
 
 // Note: tree_sitter_log_observer functionality has been moved to quarto-parse-errors crate.
 // Import from quarto_parse_errors::{TreeSitterLogObserver, TreeSitterLogObserverTrait, ...} instead.
@@ -0,0 +1,94 @@
+/*
+ * timestamp.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! The time of a build, for writers that embed one in their output.
+//!
+//! `SOURCE_DATE_EPOCH` (seconds since the Unix epoch) is used when it is
+//! set, as reproducible builds expect. Otherwise the time is the Unix epoch
+//! in deterministic mode, and the current time when not.
+
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// The environment variable with the build time
+pub const SOURCE_DATE_EPOCH: &str = "SOURCE_DATE_EPOCH";
+
+/// The time of the build, in seconds since the Unix epoch
+pub fn build_time(deterministic: bool) -> u64 {
+    build_time_from(
+        std::env::var(SOURCE_DATE_EPOCH).ok().as_deref(),
+        deterministic,
+    )
+}
+
+fn build_time_from(source_date_epoch: Option<&str>, deterministic: bool) -> u64 {
+    if let Some(seconds) = source_date_epoch.and_then(|value| value.trim().parse().ok()) {
+        return seconds;
+    }
+    if deterministic {
+        return 0;
+    }
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |duration| duration.as_secs())
+}
+
+/// A time in seconds since the Unix epoch as an ISO 8601 UTC date and time,
+/// such as `2024-03-01T12:00:00Z`
+pub fn iso8601(seconds: u64) -> String {
+    let (year, month, day) = civil_from_days((seconds / 86400) as i64);
+    let time = seconds % 86400;
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}Z",
+        year,
+        month,
+        day,
+        time / 3600,
+        time % 3600 / 60,
+        time % 60
+    )
+}
+
+/// The year, month and day of a number of days since the Unix epoch, in the
+/// proleptic Gregorian calendar (Howard Hinnant's `civil_from_days`)
+fn civil_from_days(days: i64) -> (i64, u32, u32) {
+    let z = days + 719468;
+    let era = z.div_euclid(146097);
+    let day_of_era = z.rem_euclid(146097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1460 + day_of_era / 36524 - day_of_era / 146096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let shifted_month = (5 * day_of_year + 2) / 153;
+    let day = (day_of_year - (153 * shifted_month + 2) / 5 + 1) as u32;
+    let month = if shifted_month < 10 {
+        shifted_month + 3
+    } else {
+        shifted_month - 9
+    };
+    let year = year_of_era + era * 400 + i64::from(month <= 2);
+    (year, month as u32, day)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_build_time() {
+        assert_eq!(build_time_from(Some("1700000000"), false), 1700000000);
+        assert_eq!(build_time_from(Some("1700000000"), true), 1700000000);
+        assert_eq!(build_time_from(None, true), 0);
+        // An invalid value is ignored
+        assert_eq!(build_time_from(Some("yesterday"), true), 0);
+        assert!(build_time_from(None, false) > 1700000000);
+    }
+
+    #[test]
+    fn test_iso8601() {
+        assert_eq!(iso8601(0), "1970-01-01T00:00:00Z");
+        assert_eq!(iso8601(951782400), "2000-02-29T00:00:00Z");
+        assert_eq!(iso8601(1700000000), "2023-11-14T22:13:20Z");
+        assert_eq!(iso8601(1709294400), "2024-03-01T12:00:00Z");
+    }
+}
@@ -0,0 +1,279 @@
+/*
+ * zip.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Minimal ZIP archive support for OOXML and EPUB packages.
+//!
+//! Writing produces deflated (or, where a format requires it, stored)
+//! entries with a fixed timestamp, so the same input always yields
+//! byte-identical archives. Reading supports the stored and
+//! deflated entries that Word and LibreOffice produce; ZIP64, encryption and
+//! multi-disk archives are not supported.
+
+use flate2::Compression;
+use flate2::read::DeflateDecoder;
+use flate2::write::DeflateEncoder;
+use std::io::{self, Read, Write};
+
+const LOCAL_HEADER_SIGNATURE: u32 = 0x04034b50;
+const CENTRAL_HEADER_SIGNATURE: u32 = 0x02014b50;
+const END_OF_CENTRAL_DIRECTORY_SIGNATURE: u32 = 0x06054b50;
+
+const METHOD_STORED: u16 = 0;
+const METHOD_DEFLATED: u16 = 8;
+
+/// General purpose flag: file names are UTF-8
+const FLAG_UTF8: u16 = 0x0800;
+
+/// DOS date for 1980-01-01, the earliest representable date
+const DOS_DATE: u16 = (1 << 5) | 1;
+
+struct CentralEntry {
+    name: String,
+    method: u16,
+    crc: u32,
+    compressed_size: u32,
+    size: u32,
+    offset: u32,
+}
+
+/// Writes a ZIP archive entry by entry.
+pub struct ZipWriter<W: Write> {
+    writer: W,
+    offset: u32,
+    entries: Vec<CentralEntry>,
+}
+
+fn too_large() -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidInput, "ZIP archive exceeds 4 GiB")
+}
+
+impl<W: Write> ZipWriter<W> {
+    pub fn new(writer: W) -> Self {
+        Self {
+            writer,
+            offset: 0,
+            entries: Vec::new(),
+        }
+    }
+
+    /// Add a deflated file entry.
+    pub fn add_file(&mut self, name: &str, data: &[u8]) -> io::Result<()> {
+        let mut encoder = DeflateEncoder::new(Vec::new(), Compression::default());
+        encoder.write_all(data)?;
+        let compressed = encoder.finish()?;
+        self.add_entry(name, METHOD_DEFLATED, data, &compressed)
+    }
+
+    /// Add an uncompressed file entry, such as the `mimetype` that must
+    /// open an EPUB.
+    pub fn add_stored_file(&mut self, name: &str, data: &[u8]) -> io::Result<()> {
+        self.add_entry(name, METHOD_STORED, data, data)
+    }
+
+    fn add_entry(
+        &mut self,
+        name: &str,
+        method: u16,
+        data: &[u8],
+        compressed: &[u8],
+    ) -> io::Result<()> {
+        let entry = CentralEntry {
+            name: name.to_string(),
+            method,
+            crc: crc32fast::hash(data),
+            compressed_size: u32::try_from(compressed.len()).map_err(|_| too_large())?,
+            size: u32::try_from(data.len()).map_err(|_| too_large())?,
+            offset: self.offset,
+        };
+
+        let mut header = Vec::with_capacity(30 + name.len());
+        header.extend_from_slice(&LOCAL_HEADER_SIGNATURE.to_le_bytes());
+        header.extend_from_slice(&20u16.to_le_bytes()); // version needed
+        header.extend_from_slice(&FLAG_UTF8.to_le_bytes());
+        header.extend_from_slice(&method.to_le_bytes());
+        header.extend_from_slice(&0u16.to_le_bytes()); // time
+        header.extend_from_slice(&DOS_DATE.to_le_bytes());
+        header.extend_from_slice(&entry.crc.to_le_bytes());
+        header.extend_from_slice(&entry.compressed_size.to_le_bytes());
+        header.extend_from_slice(&entry.size.to_le_bytes());
+        header.extend_from_slice(&(name.len() as u16).to_le_bytes());
+        header.extend_from_slice(&0u16.to_le_bytes()); // extra field length
+        header.extend_from_slice(name.as_bytes());
+
+        self.writer.write_all(&header)?;
+        self.writer.write_all(compressed)?;
+        self.offset = (header.len() + compressed.len())
+            .checked_add(self.offset as usize)
+            .and_then(|n| u32::try_from(n).ok())
+            .ok_or_else(too_large)?;
+        self.entries.push(entry);
+        Ok(())
+    }
+
+    /// Write the central directory and return the underlying writer.
+    pub fn finish(mut self) -> io::Result<W> {
+        let directory_offset = self.offset;
+        let mut directory = Vec::new();
+        for entry in &self.entries {
+            directory.extend_from_slice(&CENTRAL_HEADER_SIGNATURE.to_le_bytes());
+            directory.extend_from_slice(&20u16.to_le_bytes()); // version made by
+            directory.extend_from_slice(&20u16.to_le_bytes()); // version needed
+            directory.extend_from_slice(&FLAG_UTF8.to_le_bytes());
+            directory.extend_from_slice(&entry.method.to_le_bytes());
+            directory.extend_from_slice(&0u16.to_le_bytes()); // time
+            directory.extend_from_slice(&DOS_DATE.to_le_bytes());
+            directory.extend_from_slice(&entry.crc.to_le_bytes());
+            directory.extend_from_slice(&entry.compressed_size.to_le_bytes());
+            directory.extend_from_slice(&entry.size.to_le_bytes());
+            directory.extend_from_slice(&(entry.name.len() as u16).to_le_bytes());
+            directory.extend_from_slice(&0u16.to_le_bytes()); // extra field length
+            directory.extend_from_slice(&0u16.to_le_bytes()); // comment length
+            directory.extend_from_slice(&0u16.to_le_bytes()); // disk number
+            directory.extend_from_slice(&0u16.to_le_bytes()); // internal attributes
+            directory.extend_from_slice(&0u32.to_le_bytes()); // external attributes
+            directory.extend_from_slice(&entry.offset.to_le_bytes());
+            directory.extend_from_slice(entry.name.as_bytes());
+        }
+        let directory_size = u32::try_from(directory.len()).map_err(|_| too_large())?;
+        let count = u16::try_from(self.entries.len()).map_err(|_| too_large())?;
+
+        directory.extend_from_slice(&END_OF_CENTRAL_DIRECTORY_SIGNATURE.to_le_bytes());
+        directory.extend_from_slice(&0u16.to_le_bytes()); // this disk
+        directory.extend_from_slice(&0u16.to_le_bytes()); // disk with directory
+        directory.extend_from_slice(&count.to_le_bytes());
+        directory.extend_from_slice(&count.to_le_bytes());
+        directory.extend_from_slice(&directory_size.to_le_bytes());
+        directory.extend_from_slice(&directory_offset.to_le_bytes());
+        directory.extend_from_slice(&0u16.to_le_bytes()); // comment length
+
+        self.writer.write_all(&directory)?;
+        Ok(self.writer)
+    }
+}
+
+fn invalid(message: &str) -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidData, message.to_string())
+}
+
+fn read_u16(data: &[u8], at: usize) -> io::Result<u16> {
+    data.get(at..at + 2)
+        .map(|b| u16::from_le_bytes([b[0], b[1]]))
+        .ok_or_else(|| invalid("truncated ZIP archive"))
+}
+
+fn read_u32(data: &[u8], at: usize) -> io::Result<u32> {
+    data.get(at..at + 4)
+        .map(|b| u32::from_le_bytes([b[0], b[1], b[2], b[3]]))
+        .ok_or_else(|| invalid("truncated ZIP archive"))
+}
+
+/// Read a single entry from a ZIP archive held in memory.
+///
+/// Returns `Ok(None)` if the archive has no entry with that name.
+pub fn read_entry(archive: &[u8], name: &str) -> io::Result<Option<Vec<u8>>> {
+    // The end-of-central-directory record is at least 22 bytes and may be
+    // followed by a comment of up to 65535 bytes.
+    let search_start = archive.len().saturating_sub(22 + 0xFFFF);
+    let eocd = (search_start..archive.len().saturating_sub(21))
+        .rev()
+        .find(|&i| read_u32(archive, i).ok() == Some(END_OF_CENTRAL_DIRECTORY_SIGNATURE))
+        .ok_or_else(|| invalid("not a ZIP archive"))?;
+
+    let count = read_u16(archive, eocd + 10)? as usize;
+    let mut at = read_u32(archive, eocd + 16)? as usize;
+    for _ in 0..count {
+        if read_u32(archive, at)? != CENTRAL_HEADER_SIGNATURE {
+            return Err(invalid("corrupt ZIP central directory"));
+        }
+        let method = read_u16(archive, at + 10)?;
+        let compressed_size = read_u32(archive, at + 20)? as usize;
+        let name_len = read_u16(archive, at + 28)? as usize;
+        let extra_len = read_u16(archive, at + 30)? as usize;
+        let comment_len = read_u16(archive, at + 32)? as usize;
+        let offset = read_u32(archive, at + 42)? as usize;
+        let entry_name = archive
+            .get(at + 46..at + 46 + name_len)
+            .ok_or_else(|| invalid("truncated ZIP archive"))?;
+        at += 46 + name_len + extra_len + comment_len;
+
+        if entry_name != name.as_bytes() {
+            continue;
+        }
+
+        if read_u32(archive, offset)? != LOCAL_HEADER_SIGNATURE {
+            return Err(invalid("corrupt ZIP local header"));
+        }
+        let data_start = offset
+            + 30
+            + read_u16(archive, offset + 26)? as usize
+            + read_u16(archive, offset + 28)? as usize;
+        let data = archive
+            .get(data_start..data_start + compressed_size)
+            .ok_or_else(|| invalid("truncated ZIP archive"))?;
+        return match method {
+            METHOD_STORED => Ok(Some(data.to_vec())),
+            METHOD_DEFLATED => {
+                let mut out = Vec::new();
+                DeflateDecoder::new(data).read_to_end(&mut out)?;
+                Ok(Some(out))
+            }
+            _ => Err(invalid("unsupported ZIP compression method")),
+        };
+    }
+    Ok(None)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_roundtrip() {
+        let mut zip = ZipWriter::new(Vec::new());
+        zip.add_file("a.txt", b"hello").unwrap();
+        zip.add_file("dir/b.xml", "<x>é</x>".repeat(100).as_bytes())
+            .unwrap();
+        let archive = zip.finish().unwrap();
+
+        assert_eq!(&archive[0..4], b"PK\x03\x04");
+        assert_eq!(read_entry(&archive, "a.txt").unwrap().unwrap(), b"hello");
+        assert_eq!(
+            read_entry(&archive, "dir/b.xml").unwrap().unwrap(),
+            "<x>é</x>".repeat(100).as_bytes()
+        );
+        assert!(read_entry(&archive, "missing").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_stored_entry() {
+        let mut zip = ZipWriter::new(Vec::new());
+        zip.add_stored_file("mimetype", b"application/epub+zip")
+            .unwrap();
+        let archive = zip.finish().unwrap();
+
+        // The name and content follow the 30-byte local header uncompressed
+        assert_eq!(&archive[30..38], b"mimetype");
+        assert_eq!(&archive[38..58], b"application/epub+zip");
+        assert_eq!(
+            read_entry(&archive, "mimetype").unwrap().unwrap(),
+            b"application/epub+zip"
+        );
+    }
+
+    #[test]
+    fn test_output_is_deterministic() {
+        let build = || {
+            let mut zip = ZipWriter::new(Vec::new());
+            zip.add_file("a.txt", b"same").unwrap();
+            zip.finish().unwrap()
+        };
+        assert_eq!(build(), build());
+    }
+
+    #[test]
+    fn test_not_a_zip() {
+        assert!(read_entry(b"plain text", "a.txt").is_err());
+    }
+}
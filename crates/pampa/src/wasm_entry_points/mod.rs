@@ -16,6 +16,7 @@ fn pandoc_to_json(
     let mut buf = Vec::new();
     let config = JsonConfig {
         include_inline_locations: include_resolved_locations,
+        include_block_ids: false,
     };
     match crate::writers::json::write_with_config(doc, context, &mut buf, &config) {
         Ok(_) => {
@@ -112,10 +113,13 @@ pub fn render_with_template_bundle_named(
     // Determine body format
     let format = match body_format {
         "html" => BodyFormat::Html,
+        "latex" => BodyFormat::Latex,
+        "typst" => BodyFormat::Typst,
+        "revealjs" => BodyFormat::Revealjs,
         "plaintext" | "plain" => BodyFormat::Plaintext,
         _ => {
             return serde_json::json!({
-                "error": format!("Unknown body format: '{}'. Use 'html' or 'plaintext'", body_format),
+                "error": format!("Unknown body format: '{}'. Use 'html', 'revealjs', 'latex', 'typst' or 'plaintext'", body_format),
                 "diagnostics": []
             })
             .to_string();
@@ -0,0 +1,207 @@
+/*
+ * watch.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! `--watch`: convert the input again whenever it or a file it uses
+//! changes.
+//!
+//! The files watched are the input, the files it includes (with
+//! `--resolve-includes`), metadata files, bibliographies, the CSL style, a
+//! highlighting theme file and Lua filters. Included files that haven't
+//! changed are not parsed again, thanks to an `IncludeCache` kept across
+//! builds. Templates and the reference doc are read once, at startup.
+//!
+//! Each build writes the output file (unless it failed, which keeps the
+//! previous output) and one JSON line on stdout:
+//!
+//! ```json
+//! {"event":"build","ok":true,"input":"doc.qmd","output":"doc.html","changed":["/abs/doc.qmd"],"elapsed_ms":12}
+//! ```
+//!
+//! `changed` is empty for the first build. Diagnostics go to stderr, so
+//! stdout only carries events.
+
+use super::{Args, Messages, Resources, convert, ensure_final_newline};
+use crate::transforms::IncludeCache;
+use crate::utils::output::VerboseOutput;
+use notify::RecursiveMode;
+use notify_debouncer_mini::new_debouncer;
+use std::collections::HashSet;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, Instant};
+
+/// Changes within this time are one rebuild (editors often write a file in
+/// several steps)
+const DEBOUNCE_MS: u64 = 100;
+
+/// Build, then rebuild on every change. Returns the exit code when watching
+/// stops.
+pub fn run(args: &Args, resources: &Resources) -> i32 {
+    if args.input == "-" {
+        eprintln!("--watch needs an input file; specify it with -i");
+        return 1;
+    }
+    let Some(output) = &args.output else {
+        eprintln!("--watch writes to a file; specify it with -o");
+        return 1;
+    };
+
+    let (tx, rx) = std::sync::mpsc::channel();
+    let mut debouncer = match new_debouncer(Duration::from_millis(DEBOUNCE_MS), tx) {
+        Ok(debouncer) => debouncer,
+        Err(e) => {
+            eprintln!("Failed to start watching: {}", e);
+            return 1;
+        }
+    };
+
+    let mut include_cache = IncludeCache::new();
+    let mut watched_dirs = HashSet::new();
+    let mut changed = Vec::new();
+    loop {
+        build(args, resources, output, &mut include_cache, &changed);
+
+        // Editors often replace files rather than write them, so watch the
+        // directories and pick out the files
+        let watched = watched_files(args, &include_cache);
+        for dir in watched.iter().filter_map(|path| path.parent()) {
+            if watched_dirs.insert(dir.to_path_buf())
+                && let Err(e) = debouncer.watcher().watch(dir, RecursiveMode::NonRecursive)
+            {
+                eprintln!("Failed to watch '{}': {}", dir.display(), e);
+            }
+        }
+
+        changed = loop {
+            let Ok(result) = rx.recv() else {
+                return 0;
+            };
+            match result {
+                Ok(events) => {
+                    let mut paths: Vec<PathBuf> = events
+                        .into_iter()
+                        .map(|event| normalize(&event.path))
+                        .filter(|path| watched.contains(path))
+                        .collect();
+                    paths.sort();
+                    paths.dedup();
+                    if !paths.is_empty() {
+                        break paths;
+                    }
+                }
+                Err(e) => eprintln!("Watch error: {}", e),
+            }
+        };
+    }
+}
+
+/// Convert the input, write the output and print the build event
+fn build(
+    args: &Args,
+    resources: &Resources,
+    output: &str,
+    include_cache: &mut IncludeCache,
+    changed: &[PathBuf],
+) {
+    let start = Instant::now();
+    // Messages that would go to stdout go to stderr, to keep stdout for
+    // events
+    let mut stderr = std::io::stderr();
+    let mut json_stderr = std::io::stderr();
+    let mut messages = Messages {
+        json_errors: args.json_errors,
+        collected: None,
+        stdout: &mut json_stderr,
+        stderr: &mut stderr,
+    };
+    let mut output_stream = if args.verbose {
+        VerboseOutput::Stderr(std::io::stderr())
+    } else {
+        VerboseOutput::Sink(std::io::sink())
+    };
+
+    let ok = match std::fs::read_to_string(&args.input) {
+        Ok(mut input) => {
+            ensure_final_newline(&mut input, &args.input, &mut messages);
+            convert(
+                args,
+                resources,
+                &args.input,
+                &input,
+                include_cache,
+                &mut output_stream,
+                &mut messages,
+                &mut crate::trace::Tracer::disabled(),
+            )
+            .ok()
+            .is_some_and(|buf| match std::fs::write(output, &buf) {
+                Ok(()) => true,
+                Err(e) => {
+                    messages.error(format_args!(
+                        "Failed to write output to file '{}': {}",
+                        output, e
+                    ));
+                    false
+                }
+            })
+        }
+        Err(e) => {
+            messages.error(format_args!("Failed to read '{}': {}", args.input, e));
+            false
+        }
+    };
+
+    let event = serde_json::json!({
+        "event": "build",
+        "ok": ok,
+        "input": args.input,
+        "output": output,
+        "changed": changed
+            .iter()
+            .map(|path| path.to_string_lossy())
+            .collect::<Vec<_>>(),
+        "elapsed_ms": start.elapsed().as_millis() as u64,
+    });
+    let mut stdout = std::io::stdout();
+    let _ = writeln!(stdout, "{}", event);
+    let _ = stdout.flush();
+}
+
+/// The files whose changes trigger a rebuild, normalized
+fn watched_files(args: &Args, include_cache: &IncludeCache) -> HashSet<PathBuf> {
+    let mut files: Vec<PathBuf> = vec![PathBuf::from(&args.input)];
+    files.extend(include_cache.files().iter().cloned());
+    files.extend(args.metadata_files.iter().cloned());
+    files.extend(args.bibliography.iter().map(PathBuf::from));
+    files.extend(args.csl.iter().map(PathBuf::from));
+    if let Some(style) = &args.highlight_style
+        && crate::highlight::Theme::builtin(style).is_none()
+    {
+        files.push(PathBuf::from(style));
+    }
+    files.extend(
+        args.filters
+            .iter()
+            .filter(|filter| filter.ends_with(".lua"))
+            .map(PathBuf::from),
+    );
+    files.iter().map(|path| normalize(path)).collect()
+}
+
+/// An absolute path without symlinks, for comparing watched files with the
+/// paths of events, also for files that don't exist (anymore)
+fn normalize(path: &Path) -> PathBuf {
+    if let Ok(canonical) = path.canonicalize() {
+        return canonical;
+    }
+    let parent = match path.parent() {
+        Some(parent) if !parent.as_os_str().is_empty() => parent,
+        _ => Path::new("."),
+    };
+    match (parent.canonicalize(), path.file_name()) {
+        (Ok(parent), Some(name)) => parent.join(name),
+        _ => path.to_path_buf(),
+    }
+}
@@ -177,6 +177,17 @@ impl Default for AnsiConfig {
 }
 
 impl AnsiConfig {
+    /// The configuration of `--deterministic`: the output doesn't depend on
+    /// the terminal or the environment
+    pub fn deterministic() -> Self {
+        Self {
+            colors: true,
+            width: 80,
+            indent: 2,
+            hyperlinks: false,
+        }
+    }
+
     /// Detect terminal width, defaulting to 80 if detection fails
     /// Can be overridden with QUARTO_TERMINAL_WIDTH environment variable
     fn detect_terminal_width() -> usize {
@@ -1369,8 +1380,12 @@ fn write_inline<T: Write + ?Sized>(
         Inline::EditComment(_) => {
             // Ignore edit comments in output
         }
-        Inline::Custom(_) => {
-            // Custom nodes are not rendered in ANSI output
+        Inline::Custom(custom) => {
+            // Inline cells show their output, or their code until they are
+            // evaluated; other custom nodes are not rendered in ANSI output
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                write_inlines(&cell.rendered(custom), buf, config, style_ctx)?;
+            }
         }
     }
     Ok(())
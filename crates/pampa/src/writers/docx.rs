@@ -0,0 +1,1551 @@
+/*
+ * docx.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! DOCX writer for Pandoc AST.
+//!
+//! Unlike the text writers, this produces a complete binary package: a ZIP
+//! archive holding the WordprocessingML document, styles, numbering,
+//! footnotes, comments and any embedded images. Templates are not involved;
+//! the look of the document comes from `styles.xml`, which is either the
+//! built-in default or taken from a reference document (`--reference-doc`).
+//!
+//! Style names follow Pandoc's docx writer (`Body Text`, `Compact`,
+//! `Source Code`, `Verbatim Char`, ...) so existing reference documents work.
+//! Editorial marks become Word revisions: insertions and deletions are
+//! tracked changes, highlights use Word highlighting, and `[>> ...]`
+//! comments are Word comments. Math is written as its TeX source.
+
+use crate::pandoc::inline::Image;
+use crate::pandoc::table::{Alignment, ColWidth, Row, Table};
+use crate::pandoc::{
+    Attr, Block, ConfigValue, ConfigValueKind, Inline, Inlines, ListNumberDelim, ListNumberStyle,
+    Pandoc,
+};
+use crate::utils::zip::{ZipWriter, read_entry};
+use crate::writers::plaintext::inlines_to_string;
+use base64::Engine;
+use std::io::{self, Write};
+use std::path::PathBuf;
+
+const DEFAULT_STYLES: &str = include_str!("../../resources/docx/styles.xml");
+
+/// Text width of a US Letter page with one inch margins, in twips
+const TEXT_WIDTH_TWIPS: u64 = 9360;
+/// Text width in EMUs (914400 per inch)
+const TEXT_WIDTH_EMU: u64 = 5943600;
+/// EMUs per CSS pixel at 96 dpi
+const EMU_PER_PX: f64 = 9525.0;
+/// Left indent per list level, in twips
+const LIST_INDENT: u64 = 720;
+
+const NS_W: &str = "http://schemas.openxmlformats.org/wordprocessingml/2006/main";
+const NS_R: &str = "http://schemas.openxmlformats.org/officeDocument/2006/relationships";
+const NS_WP: &str = "http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing";
+const NS_A: &str = "http://schemas.openxmlformats.org/drawingml/2006/main";
+const NS_PIC: &str = "http://schemas.openxmlformats.org/drawingml/2006/picture";
+
+const REL_OFFICE_DOCUMENT: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument";
+const REL_CORE_PROPERTIES: &str =
+    "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties";
+const REL_STYLES: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles";
+const REL_NUMBERING: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering";
+const REL_FOOTNOTES: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes";
+const REL_COMMENTS: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/comments";
+const REL_HYPERLINK: &str =
+    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink";
+const REL_IMAGE: &str = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image";
+
+// =============================================================================
+// Configuration
+// =============================================================================
+
+/// Options for the DOCX writer.
+#[derive(Debug, Clone, Default)]
+pub struct DocxConfig {
+    /// Contents of a `.docx` file whose `word/styles.xml` replaces the
+    /// built-in styles
+    pub reference_doc: Option<Vec<u8>>,
+    /// Directory that relative image paths are resolved against.
+    /// Images that cannot be read are replaced by their alt text.
+    pub resource_dir: Option<PathBuf>,
+}
+
+// =============================================================================
+// Context
+// =============================================================================
+
+/// The package part currently being written. Relationships are scoped to
+/// the part that uses them.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Part {
+    Document,
+    Footnotes,
+    Comments,
+}
+
+struct Relationship {
+    id: String,
+    rel_type: &'static str,
+    target: String,
+    external: bool,
+}
+
+/// Context threaded through DOCX writer functions.
+///
+/// Each part is built as a string; the context collects everything that
+/// lives outside `document.xml` (notes, comments, numbering, media and
+/// relationships) until the package is assembled.
+struct DocxWriterContext {
+    resource_dir: Option<PathBuf>,
+    part: Part,
+    document_rels: Vec<Relationship>,
+    footnote_rels: Vec<Relationship>,
+    comment_rels: Vec<Relationship>,
+    /// Embedded images as (file name under `word/media`, bytes)
+    media: Vec<(String, Vec<u8>)>,
+    /// `<w:footnote>` elements, numbered from 1
+    notes: Vec<String>,
+    /// `<w:comment>` elements
+    comments: Vec<String>,
+    /// `<w:abstractNum>` elements
+    abstract_nums: Vec<String>,
+    /// `w:num` id of the shared bullet numbering, once created
+    bullet_num: Option<usize>,
+    /// Run to insert at the start of the next paragraph (the footnote
+    /// mark at the start of a note)
+    pending_lead: Option<String>,
+    /// Counter for bookmark, revision and drawing ids
+    next_id: usize,
+}
+
+impl DocxWriterContext {
+    fn new(config: &DocxConfig) -> Self {
+        Self {
+            resource_dir: config.resource_dir.clone(),
+            part: Part::Document,
+            document_rels: Vec::new(),
+            footnote_rels: Vec::new(),
+            comment_rels: Vec::new(),
+            media: Vec::new(),
+            notes: Vec::new(),
+            comments: Vec::new(),
+            abstract_nums: Vec::new(),
+            bullet_num: None,
+            pending_lead: None,
+            next_id: 1,
+        }
+    }
+
+    fn fresh_id(&mut self) -> usize {
+        let id = self.next_id;
+        self.next_id += 1;
+        id
+    }
+
+    /// Add a relationship from the current part and return its id
+    fn add_relationship(
+        &mut self,
+        rel_type: &'static str,
+        target: String,
+        external: bool,
+    ) -> String {
+        let rels = match self.part {
+            Part::Document => &mut self.document_rels,
+            Part::Footnotes => &mut self.footnote_rels,
+            Part::Comments => &mut self.comment_rels,
+        };
+        if let Some(existing) = rels
+            .iter()
+            .find(|r| r.rel_type == rel_type && r.target == target)
+        {
+            return existing.id.clone();
+        }
+        let id = format!("rId{}", rels.len() + 1);
+        rels.push(Relationship {
+            id: id.clone(),
+            rel_type,
+            target,
+            external,
+        });
+        id
+    }
+
+    /// Create a `w:num` for a list and return its id
+    fn add_numbering(
+        &mut self,
+        list: Option<(usize, &ListNumberStyle, &ListNumberDelim)>,
+    ) -> usize {
+        if list.is_none()
+            && let Some(num) = self.bullet_num
+        {
+            return num;
+        }
+        let abstract_id = self.abstract_nums.len();
+        let mut xml = format!(
+            "<w:abstractNum w:abstractNumId=\"{}\"><w:multiLevelType w:val=\"multilevel\"/>",
+            abstract_id
+        );
+        for level in 0..9 {
+            let (start, format, text) = match list {
+                None => (
+                    1,
+                    "bullet",
+                    ["\u{2022}", "\u{25E6}", "\u{25AA}"][level % 3].to_string(),
+                ),
+                Some((start, style, delim)) => {
+                    let number = format!("%{}", level + 1);
+                    let text = match delim {
+                        ListNumberDelim::OneParen => format!("{})", number),
+                        ListNumberDelim::TwoParens => format!("({})", number),
+                        _ => format!("{}.", number),
+                    };
+                    (start, number_format(style), text)
+                }
+            };
+            xml.push_str(&format!(
+                "<w:lvl w:ilvl=\"{}\"><w:start w:val=\"{}\"/><w:numFmt w:val=\"{}\"/>\
+                 <w:lvlText w:val=\"{}\"/><w:lvlJc w:val=\"left\"/>\
+                 <w:pPr><w:ind w:left=\"{}\" w:hanging=\"360\"/></w:pPr></w:lvl>",
+                level,
+                start,
+                format,
+                text,
+                LIST_INDENT * (level as u64 + 1)
+            ));
+        }
+        xml.push_str("</w:abstractNum>");
+        self.abstract_nums.push(xml);
+        // w:num ids start at 1 and map one-to-one onto abstract numberings
+        let num = abstract_id + 1;
+        if list.is_none() {
+            self.bullet_num = Some(num);
+        }
+        num
+    }
+}
+
+fn number_format(style: &ListNumberStyle) -> &'static str {
+    match style {
+        ListNumberStyle::LowerRoman => "lowerRoman",
+        ListNumberStyle::UpperRoman => "upperRoman",
+        ListNumberStyle::LowerAlpha => "lowerLetter",
+        ListNumberStyle::UpperAlpha => "upperLetter",
+        ListNumberStyle::Decimal | ListNumberStyle::Example | ListNumberStyle::Default => "decimal",
+    }
+}
+
+/// Paragraph-level state inherited by nested blocks.
+#[derive(Debug, Clone, Default)]
+struct BlockState {
+    /// Style that replaces `Body Text`/`Compact` (block quotes, notes, ...)
+    style: Option<&'static str>,
+    /// Numbering to attach to the next paragraph as (`w:num` id, level)
+    list: Option<(usize, usize)>,
+    /// Current list nesting depth
+    depth: usize,
+    /// Paragraph alignment (table cells)
+    align: Option<&'static str>,
+}
+
+impl BlockState {
+    fn paragraph_style(&self, default: &'static str) -> &'static str {
+        self.style.unwrap_or(default)
+    }
+
+    /// State for blocks after the first in a list item: indented, unnumbered
+    fn continuation(&self) -> Self {
+        Self {
+            list: None,
+            ..self.clone()
+        }
+    }
+}
+
+/// Kind of tracked change a run belongs to.
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum RevisionKind {
+    Insert,
+    Delete,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Revision {
+    kind: RevisionKind,
+    author: String,
+    date: Option<String>,
+}
+
+/// Character formatting accumulated while descending into inlines.
+#[derive(Debug, Clone, Default)]
+struct RunProps {
+    style: Option<&'static str>,
+    bold: bool,
+    italic: bool,
+    small_caps: bool,
+    strike: bool,
+    highlight: bool,
+    underline: bool,
+    superscript: bool,
+    subscript: bool,
+    revision: Option<Revision>,
+}
+
+impl RunProps {
+    fn to_xml(&self) -> String {
+        let mut xml = String::new();
+        if let Some(style) = self.style {
+            xml.push_str(&format!("<w:rStyle w:val=\"{}\"/>", style));
+        }
+        for (on, element) in [
+            (self.bold, "<w:b/><w:bCs/>"),
+            (self.italic, "<w:i/><w:iCs/>"),
+            (self.small_caps, "<w:smallCaps/>"),
+            (self.strike, "<w:strike/>"),
+            (self.highlight, "<w:highlight w:val=\"yellow\"/>"),
+            (self.underline, "<w:u w:val=\"single\"/>"),
+            (self.superscript, "<w:vertAlign w:val=\"superscript\"/>"),
+            (
+                self.subscript && !self.superscript,
+                "<w:vertAlign w:val=\"subscript\"/>",
+            ),
+        ] {
+            if on {
+                xml.push_str(element);
+            }
+        }
+        if xml.is_empty() {
+            xml
+        } else {
+            format!("<w:rPr>{}</w:rPr>", xml)
+        }
+    }
+
+    fn with(&self, update: impl FnOnce(&mut RunProps)) -> RunProps {
+        let mut props = self.clone();
+        update(&mut props);
+        props
+    }
+}
+
+fn revision_from_attr(kind: RevisionKind, attr: &Attr) -> Revision {
+    Revision {
+        kind,
+        author: attr
+            .2
+            .get("author")
+            .cloned()
+            .unwrap_or_else(|| "Unknown".to_string()),
+        date: attr.2.get("date").cloned(),
+    }
+}
+
+// =============================================================================
+// Escaping
+// =============================================================================
+
+/// Escape text for XML element content and attribute values.
+fn escape_xml(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            // Control characters other than tab/newline are not allowed in XML
+            c if (c as u32) < 0x20 && c != '\t' && c != '\n' => {}
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+/// Turn an identifier into a bookmark name; Word bookmark names may not
+/// contain spaces.
+fn bookmark_name(id: &str) -> String {
+    id.chars()
+        .map(|c| if c.is_whitespace() { '_' } else { c })
+        .collect()
+}
+
+// =============================================================================
+// Runs and inlines
+// =============================================================================
+
+/// Write a run, wrapping it in `w:ins`/`w:del` if it belongs to a revision.
+fn write_run(content: &str, props: &RunProps, out: &mut String, ctx: &mut DocxWriterContext) {
+    let run = format!("<w:r>{}{}</w:r>", props.to_xml(), content);
+    match &props.revision {
+        None => out.push_str(&run),
+        Some(revision) => {
+            let element = match revision.kind {
+                RevisionKind::Insert => "w:ins",
+                RevisionKind::Delete => "w:del",
+            };
+            let date = revision
+                .date
+                .as_ref()
+                .map(|d| format!(" w:date=\"{}\"", escape_xml(d)))
+                .unwrap_or_default();
+            out.push_str(&format!(
+                "<{} w:id=\"{}\" w:author=\"{}\"{}>{}</{}>",
+                element,
+                ctx.fresh_id(),
+                escape_xml(&revision.author),
+                date,
+                run,
+                element
+            ));
+        }
+    }
+}
+
+/// Write a text run. Newlines become line breaks.
+fn write_text_run(text: &str, props: &RunProps, out: &mut String, ctx: &mut DocxWriterContext) {
+    if text.is_empty() {
+        return;
+    }
+    let element = match props.revision {
+        Some(Revision {
+            kind: RevisionKind::Delete,
+            ..
+        }) => "w:delText",
+        _ => "w:t",
+    };
+    let content = text
+        .split('\n')
+        .map(|line| {
+            format!(
+                "<{} xml:space=\"preserve\">{}</{}>",
+                element,
+                escape_xml(line),
+                element
+            )
+        })
+        .collect::<Vec<_>>()
+        .join("<w:br/>");
+    write_run(&content, props, out, ctx);
+}
+
+fn write_bookmarked(
+    id: &str,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+    body: impl FnOnce(&mut String, &mut DocxWriterContext),
+) {
+    if id.is_empty() {
+        body(out, ctx);
+        return;
+    }
+    let bookmark = ctx.fresh_id();
+    out.push_str(&format!(
+        "<w:bookmarkStart w:id=\"{}\" w:name=\"{}\"/>",
+        bookmark,
+        escape_xml(&bookmark_name(id))
+    ));
+    body(out, ctx);
+    out.push_str(&format!("<w:bookmarkEnd w:id=\"{}\"/>", bookmark));
+}
+
+/// Write a sequence of inlines, merging adjacent text into single runs.
+fn write_inlines(
+    inlines: &Inlines,
+    props: &RunProps,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    let mut text = String::new();
+    for inline in inlines {
+        match inline {
+            Inline::Str(s) => text.push_str(&s.text),
+            Inline::Space(_) | Inline::SoftBreak(_) => text.push(' '),
+            other => {
+                write_text_run(&text, props, out, ctx);
+                text.clear();
+                write_inline(other, props, out, ctx);
+            }
+        }
+    }
+    write_text_run(&text, props, out, ctx);
+}
+
+/// Write a single non-text inline element
+fn write_inline(inline: &Inline, props: &RunProps, out: &mut String, ctx: &mut DocxWriterContext) {
+    match inline {
+        Inline::Str(s) => write_text_run(&s.text, props, out, ctx),
+        Inline::Space(_) | Inline::SoftBreak(_) => write_text_run(" ", props, out, ctx),
+        Inline::LineBreak(_) => write_run("<w:br/>", props, out, ctx),
+        Inline::Emph(e) => {
+            write_inlines(&e.content, &props.with(|p| p.italic = !p.italic), out, ctx)
+        }
+        Inline::Strong(s) => write_inlines(&s.content, &props.with(|p| p.bold = true), out, ctx),
+        Inline::Underline(u) => {
+            write_inlines(&u.content, &props.with(|p| p.underline = true), out, ctx)
+        }
+        Inline::Strikeout(s) => {
+            write_inlines(&s.content, &props.with(|p| p.strike = true), out, ctx)
+        }
+        Inline::Superscript(s) => {
+            write_inlines(&s.content, &props.with(|p| p.superscript = true), out, ctx)
+        }
+        Inline::Subscript(s) => {
+            write_inlines(&s.content, &props.with(|p| p.subscript = true), out, ctx)
+        }
+        Inline::SmallCaps(s) => {
+            write_inlines(&s.content, &props.with(|p| p.small_caps = true), out, ctx)
+        }
+        Inline::Quoted(q) => {
+            let (open, close) = match q.quote_type {
+                crate::pandoc::QuoteType::SingleQuote => ("\u{2018}", "\u{2019}"),
+                crate::pandoc::QuoteType::DoubleQuote => ("\u{201C}", "\u{201D}"),
+            };
+            write_text_run(open, props, out, ctx);
+            write_inlines(&q.content, props, out, ctx);
+            write_text_run(close, props, out, ctx);
+        }
+        Inline::Code(c) => write_text_run(
+            &c.text,
+            &props.with(|p| p.style = Some("VerbatimChar")),
+            out,
+            ctx,
+        ),
+        Inline::Math(m) => {
+            let props = props.with(|p| p.style = Some("VerbatimChar"));
+            match m.math_type {
+                crate::pandoc::MathType::InlineMath => {
+                    write_text_run(&format!("${}$", m.text), &props, out, ctx)
+                }
+                crate::pandoc::MathType::DisplayMath => {
+                    write_text_run(&format!("$${}$$", m.text), &props, out, ctx)
+                }
+            }
+        }
+        Inline::RawInline(raw) => {
+            if raw.format == "openxml" {
+                out.push_str(&raw.text);
+            }
+        }
+        Inline::Link(link) => {
+            let url = &link.target.0;
+            let link_props = props.with(|p| p.style = Some("Hyperlink"));
+            if let Some(anchor) = url.strip_prefix('#') {
+                out.push_str(&format!(
+                    "<w:hyperlink w:anchor=\"{}\">",
+                    escape_xml(&bookmark_name(anchor))
+                ));
+            } else {
+                let id = ctx.add_relationship(REL_HYPERLINK, url.clone(), true);
+                out.push_str(&format!("<w:hyperlink r:id=\"{}\">", id));
+            }
+            write_inlines(&link.content, &link_props, out, ctx);
+            out.push_str("</w:hyperlink>");
+        }
+        Inline::Image(image) => write_image(image, props, out, ctx),
+        Inline::Note(note) => write_note(&note.content, props, out, ctx),
+        Inline::Span(span) => match EditorialMark::from_classes(&span.attr.1) {
+            // The reader desugars editorial marks into spans with these classes
+            Some(mark) => write_editorial_mark(mark, &span.attr, &span.content, props, out, ctx),
+            None => write_bookmarked(&span.attr.0, out, ctx, |out, ctx| {
+                write_inlines(&span.content, props, out, ctx)
+            }),
+        },
+        Inline::Cite(cite) => {
+            // Citeproc fills in the rendered citation; fall back to the keys
+            if !cite.content.is_empty() {
+                write_inlines(&cite.content, props, out, ctx);
+            } else {
+                let ids: Vec<String> = cite
+                    .citations
+                    .iter()
+                    .map(|c| format!("@{}", c.id))
+                    .collect();
+                write_text_run(&format!("[{}]", ids.join("; ")), props, out, ctx);
+            }
+        }
+        Inline::Insert(ins) => write_editorial_mark(
+            EditorialMark::Insert,
+            &ins.attr,
+            &ins.content,
+            props,
+            out,
+            ctx,
+        ),
+        Inline::Delete(del) => write_editorial_mark(
+            EditorialMark::Delete,
+            &del.attr,
+            &del.content,
+            props,
+            out,
+            ctx,
+        ),
+        Inline::Highlight(h) => write_editorial_mark(
+            EditorialMark::Highlight,
+            &h.attr,
+            &h.content,
+            props,
+            out,
+            ctx,
+        ),
+        Inline::EditComment(c) => {
+            write_editorial_mark(EditorialMark::Comment, &c.attr, &c.content, props, out, ctx)
+        }
+        Inline::Custom(custom) if custom.type_name == crate::pandoc::INLINE_CELL_TYPE_NAME => {
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                write_inlines(&cell.rendered(custom), props, out, ctx);
+            }
+        }
+        // Quarto extensions have no DOCX rendering
+        Inline::Shortcode(_)
+        | Inline::NoteReference(_)
+        | Inline::Attr(_, _)
+        | Inline::Custom(_) => {}
+    }
+}
+
+/// Write a footnote reference and collect the note's content.
+fn write_note(content: &[Block], props: &RunProps, out: &mut String, ctx: &mut DocxWriterContext) {
+    // Word has no notes inside notes or comments
+    if ctx.part != Part::Document {
+        return;
+    }
+    let id = ctx.notes.len() + 1;
+    ctx.part = Part::Footnotes;
+    ctx.pending_lead = Some(
+        "<w:r><w:rPr><w:rStyle w:val=\"FootnoteReference\"/></w:rPr><w:footnoteRef/></w:r>\
+         <w:r><w:t xml:space=\"preserve\"> </w:t></w:r>"
+            .to_string(),
+    );
+    let state = BlockState {
+        style: Some("FootnoteText"),
+        ..BlockState::default()
+    };
+    let mut body = String::new();
+    write_blocks(content, &state, &mut body, ctx);
+    if let Some(lead) = ctx.pending_lead.take() {
+        body.push_str(&format!(
+            "<w:p><w:pPr><w:pStyle w:val=\"FootnoteText\"/></w:pPr>{}</w:p>",
+            lead
+        ));
+    }
+    ctx.part = Part::Document;
+    ctx.notes
+        .push(format!("<w:footnote w:id=\"{}\">{}</w:footnote>", id, body));
+
+    let reference_props = props.with(|p| p.style = Some("FootnoteReference"));
+    write_run(
+        &format!("<w:footnoteReference w:id=\"{}\"/>", id),
+        &reference_props,
+        out,
+        ctx,
+    );
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum EditorialMark {
+    Insert,
+    Delete,
+    Highlight,
+    Comment,
+}
+
+impl EditorialMark {
+    fn from_classes(classes: &[String]) -> Option<Self> {
+        classes.iter().find_map(|class| match class.as_str() {
+            "quarto-insert" => Some(EditorialMark::Insert),
+            "quarto-delete" => Some(EditorialMark::Delete),
+            "quarto-highlight" => Some(EditorialMark::Highlight),
+            "quarto-edit-comment" => Some(EditorialMark::Comment),
+            _ => None,
+        })
+    }
+}
+
+/// Write an editorial mark: insertions and deletions as tracked changes,
+/// highlights as highlighted runs, and comments as Word comments anchored
+/// at this point.
+fn write_editorial_mark(
+    mark: EditorialMark,
+    attr: &Attr,
+    content: &Inlines,
+    props: &RunProps,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    match mark {
+        EditorialMark::Insert => {
+            let revision = revision_from_attr(RevisionKind::Insert, attr);
+            write_inlines(
+                content,
+                &props.with(|p| p.revision = Some(revision)),
+                out,
+                ctx,
+            )
+        }
+        EditorialMark::Delete => {
+            let revision = revision_from_attr(RevisionKind::Delete, attr);
+            write_inlines(
+                content,
+                &props.with(|p| p.revision = Some(revision)),
+                out,
+                ctx,
+            )
+        }
+        EditorialMark::Highlight => {
+            write_inlines(content, &props.with(|p| p.highlight = true), out, ctx)
+        }
+        EditorialMark::Comment => write_comment(attr, content, out, ctx),
+    }
+}
+
+fn write_comment(attr: &Attr, content: &Inlines, out: &mut String, ctx: &mut DocxWriterContext) {
+    let author = attr
+        .2
+        .get("author")
+        .cloned()
+        .unwrap_or_else(|| "Unknown".to_string());
+    let initials: String = author
+        .split_whitespace()
+        .filter_map(|word| word.chars().next())
+        .collect();
+    let date = attr
+        .2
+        .get("date")
+        .map(|d| format!(" w:date=\"{}\"", escape_xml(d)))
+        .unwrap_or_default();
+
+    let saved_part = ctx.part;
+    ctx.part = Part::Comments;
+    let mut text = String::new();
+    write_inlines(content, &RunProps::default(), &mut text, ctx);
+    ctx.part = saved_part;
+
+    // Taken after the content so comments nested in this one keep unique ids
+    let id = ctx.comments.len();
+    ctx.comments.push(format!(
+        "<w:comment w:id=\"{}\" w:author=\"{}\" w:initials=\"{}\"{}>\
+         <w:p><w:pPr><w:pStyle w:val=\"CommentText\"/></w:pPr>\
+         <w:r><w:rPr><w:rStyle w:val=\"CommentReference\"/></w:rPr><w:annotationRef/></w:r>\
+         {}</w:p></w:comment>",
+        id,
+        escape_xml(&author),
+        escape_xml(&initials),
+        date,
+        text
+    ));
+    out.push_str(&format!(
+        "<w:commentRangeStart w:id=\"{id}\"/><w:commentRangeEnd w:id=\"{id}\"/>\
+         <w:r><w:rPr><w:rStyle w:val=\"CommentReference\"/></w:rPr>\
+         <w:commentReference w:id=\"{id}\"/></w:r>"
+    ));
+}
+
+// =============================================================================
+// Images
+// =============================================================================
+
+/// Detect a supported image format, returning its extension and pixel
+/// dimensions.
+fn image_info(data: &[u8]) -> Option<(&'static str, u32, u32)> {
+    if data.starts_with(b"\x89PNG\r\n\x1a\n") && data.len() >= 24 {
+        let width = u32::from_be_bytes(data[16..20].try_into().ok()?);
+        let height = u32::from_be_bytes(data[20..24].try_into().ok()?);
+        return Some(("png", width, height));
+    }
+    if (data.starts_with(b"GIF87a") || data.starts_with(b"GIF89a")) && data.len() >= 10 {
+        let width = u16::from_le_bytes([data[6], data[7]]) as u32;
+        let height = u16::from_le_bytes([data[8], data[9]]) as u32;
+        return Some(("gif", width, height));
+    }
+    if data.starts_with(&[0xFF, 0xD8]) {
+        // Walk the JPEG segments until a start-of-frame marker
+        let mut at = 2;
+        while at + 9 < data.len() {
+            if data[at] != 0xFF {
+                return None;
+            }
+            let marker = data[at + 1];
+            let length = u16::from_be_bytes([data[at + 2], data[at + 3]]) as usize;
+            let is_frame = matches!(marker, 0xC0..=0xCF) && !matches!(marker, 0xC4 | 0xC8 | 0xCC);
+            if is_frame {
+                let height = u16::from_be_bytes([data[at + 5], data[at + 6]]) as u32;
+                let width = u16::from_be_bytes([data[at + 7], data[at + 8]]) as u32;
+                return Some(("jpeg", width, height));
+            }
+            at += 2 + length;
+        }
+    }
+    None
+}
+
+/// Load image bytes from a data URI or a local path
+fn load_image(url: &str, ctx: &DocxWriterContext) -> Option<Vec<u8>> {
+    if let Some(rest) = url.strip_prefix("data:") {
+        let (header, payload) = rest.split_once(',')?;
+        if !header.ends_with(";base64") {
+            return None;
+        }
+        return base64::engine::general_purpose::STANDARD
+            .decode(payload)
+            .ok();
+    }
+    if url.contains("://") {
+        return None;
+    }
+    let path = match &ctx.resource_dir {
+        Some(dir) => dir.join(url),
+        None => PathBuf::from(url),
+    };
+    std::fs::read(path).ok()
+}
+
+/// Convert a width or height attribute to EMUs
+fn dimension_to_emu(value: &str) -> Option<f64> {
+    let value = value.trim();
+    let split = value
+        .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+        .unwrap_or(value.len());
+    let (number, unit) = value.split_at(split);
+    let number: f64 = number.parse().ok()?;
+    let factor = match unit.trim() {
+        "" | "px" => EMU_PER_PX,
+        "in" => 914400.0,
+        "cm" => 360000.0,
+        "mm" => 36000.0,
+        "pt" => 12700.0,
+        "%" => TEXT_WIDTH_EMU as f64 / 100.0,
+        _ => return None,
+    };
+    Some(number * factor)
+}
+
+fn write_image(image: &Image, props: &RunProps, out: &mut String, ctx: &mut DocxWriterContext) {
+    let loaded = load_image(&image.target.0, ctx)
+        .and_then(|data| image_info(&data).map(|info| (data, info)));
+    let Some((data, (extension, px_width, px_height))) = loaded else {
+        // Unavailable or unsupported images degrade to their alt text
+        write_inlines(&image.content, props, out, ctx);
+        return;
+    };
+
+    // Size: explicit attributes first, then natural size at 96 dpi, scaled
+    // to preserve the aspect ratio and to fit the text width
+    let natural_width = px_width.max(1) as f64 * EMU_PER_PX;
+    let natural_height = px_height.max(1) as f64 * EMU_PER_PX;
+    let attr_width = image.attr.2.get("width").and_then(|v| dimension_to_emu(v));
+    let attr_height = image.attr.2.get("height").and_then(|v| dimension_to_emu(v));
+    let (mut width, mut height) = match (attr_width, attr_height) {
+        (Some(w), Some(h)) => (w, h),
+        (Some(w), None) => (w, w * natural_height / natural_width),
+        (None, Some(h)) => (h * natural_width / natural_height, h),
+        (None, None) => (natural_width, natural_height),
+    };
+    if width > TEXT_WIDTH_EMU as f64 {
+        height *= TEXT_WIDTH_EMU as f64 / width;
+        width = TEXT_WIDTH_EMU as f64;
+    }
+    let (cx, cy) = (width.round() as u64, height.round() as u64);
+
+    let name = format!("image{}.{}", ctx.media.len() + 1, extension);
+    ctx.media.push((name.clone(), data));
+    let rel = ctx.add_relationship(REL_IMAGE, format!("media/{}", name), false);
+    let doc_pr = ctx.fresh_id();
+
+    let (alt, _) = inlines_to_string(&image.content);
+    let drawing = format!(
+        "<w:drawing><wp:inline distT=\"0\" distB=\"0\" distL=\"0\" distR=\"0\">\
+         <wp:extent cx=\"{cx}\" cy=\"{cy}\"/><wp:effectExtent l=\"0\" t=\"0\" r=\"0\" b=\"0\"/>\
+         <wp:docPr id=\"{doc_pr}\" name=\"Picture {doc_pr}\" descr=\"{descr}\"/>\
+         <wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect=\"1\"/></wp:cNvGraphicFramePr>\
+         <a:graphic><a:graphicData uri=\"{NS_PIC}\"><pic:pic>\
+         <pic:nvPicPr><pic:cNvPr id=\"0\" name=\"{name}\"/><pic:cNvPicPr/></pic:nvPicPr>\
+         <pic:blipFill><a:blip r:embed=\"{rel}\"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>\
+         <pic:spPr><a:xfrm><a:off x=\"0\" y=\"0\"/><a:ext cx=\"{cx}\" cy=\"{cy}\"/></a:xfrm>\
+         <a:prstGeom prst=\"rect\"><a:avLst/></a:prstGeom></pic:spPr>\
+         </pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing>",
+        descr = escape_xml(alt.trim()),
+    );
+    write_run(&drawing, props, out, ctx);
+}
+
+// =============================================================================
+// Blocks
+// =============================================================================
+
+/// Write a paragraph whose runs are produced by `body`.
+fn write_paragraph(
+    style: &str,
+    state: &BlockState,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+    body: impl FnOnce(&mut String, &mut DocxWriterContext),
+) {
+    out.push_str("<w:p><w:pPr>");
+    out.push_str(&format!("<w:pStyle w:val=\"{}\"/>", style));
+    match state.list {
+        Some((num, level)) => out.push_str(&format!(
+            "<w:numPr><w:ilvl w:val=\"{}\"/><w:numId w:val=\"{}\"/></w:numPr>",
+            level, num
+        )),
+        None if state.depth > 0 => out.push_str(&format!(
+            "<w:ind w:left=\"{}\"/>",
+            LIST_INDENT * state.depth as u64
+        )),
+        None => {}
+    }
+    if let Some(align) = state.align {
+        out.push_str(&format!("<w:jc w:val=\"{}\"/>", align));
+    }
+    out.push_str("</w:pPr>");
+    if let Some(lead) = ctx.pending_lead.take() {
+        out.push_str(&lead);
+    }
+    body(out, ctx);
+    out.push_str("</w:p>");
+}
+
+fn write_inline_paragraph(
+    inlines: &Inlines,
+    style: &str,
+    state: &BlockState,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    write_paragraph(style, state, out, ctx, |out, ctx| {
+        write_inlines(inlines, &RunProps::default(), out, ctx)
+    });
+}
+
+/// Write a single block element
+fn write_block(block: &Block, state: &BlockState, out: &mut String, ctx: &mut DocxWriterContext) {
+    match block {
+        Block::Plain(plain) => write_inline_paragraph(
+            &plain.content,
+            state.paragraph_style("Compact"),
+            state,
+            out,
+            ctx,
+        ),
+        Block::Paragraph(para) => write_inline_paragraph(
+            &para.content,
+            state.paragraph_style("BodyText"),
+            state,
+            out,
+            ctx,
+        ),
+        Block::LineBlock(lines) => write_paragraph(
+            state.paragraph_style("BodyText"),
+            state,
+            out,
+            ctx,
+            |out, ctx| {
+                for (i, line) in lines.content.iter().enumerate() {
+                    if i > 0 {
+                        write_run("<w:br/>", &RunProps::default(), out, ctx);
+                    }
+                    write_inlines(line, &RunProps::default(), out, ctx);
+                }
+            },
+        ),
+        Block::CodeBlock(code) => write_paragraph("SourceCode", state, out, ctx, |out, ctx| {
+            let props = RunProps {
+                style: Some("VerbatimChar"),
+                ..RunProps::default()
+            };
+            write_text_run(code.text.trim_end_matches('\n'), &props, out, ctx);
+        }),
+        Block::RawBlock(raw) => {
+            if raw.format == "openxml" {
+                out.push_str(&raw.text);
+            }
+        }
+        Block::BlockQuote(quote) => {
+            let quoted = BlockState {
+                style: Some("BlockText"),
+                ..state.clone()
+            };
+            write_blocks(&quote.content, &quoted, out, ctx);
+        }
+        Block::OrderedList(list) => {
+            let (start, style, delim) = &list.attr;
+            let num = ctx.add_numbering(Some((*start, style, delim)));
+            write_list_items(&list.content, num, state, out, ctx);
+        }
+        Block::BulletList(list) => {
+            let num = ctx.add_numbering(None);
+            write_list_items(&list.content, num, state, out, ctx);
+        }
+        Block::DefinitionList(list) => {
+            for (term, definitions) in &list.content {
+                write_inline_paragraph(term, "DefinitionTerm", state, out, ctx);
+                let definition_state = BlockState {
+                    style: Some("Definition"),
+                    ..state.clone()
+                };
+                for definition in definitions {
+                    write_blocks(definition, &definition_state, out, ctx);
+                }
+            }
+        }
+        Block::Header(header) => {
+            let style = format!("Heading{}", header.level.clamp(1, 6));
+            write_paragraph(&style, state, out, ctx, |out, ctx| {
+                write_bookmarked(&header.attr.0, out, ctx, |out, ctx| {
+                    write_inlines(&header.content, &RunProps::default(), out, ctx)
+                })
+            });
+        }
+        Block::HorizontalRule(_) => {
+            out.push_str(
+                "<w:p><w:pPr><w:pBdr><w:bottom w:val=\"single\" w:sz=\"6\" w:space=\"1\" \
+                 w:color=\"auto\"/></w:pBdr></w:pPr></w:p>",
+            );
+        }
+        Block::Table(table) => write_table(table, state, out, ctx),
+        Block::Figure(figure) => {
+            let figure_state = BlockState {
+                style: Some("CaptionedFigure"),
+                ..state.clone()
+            };
+            write_bookmarked(&figure.attr.0, out, ctx, |out, ctx| {
+                write_blocks(&figure.content, &figure_state, out, ctx)
+            });
+            if let Some(caption) = &figure.caption.long {
+                let caption_state = BlockState {
+                    style: Some("ImageCaption"),
+                    ..state.clone()
+                };
+                write_blocks(caption, &caption_state, out, ctx);
+            }
+        }
+        Block::Div(div) => {
+            write_bookmarked(&div.attr.0, out, ctx, |out, ctx| {
+                write_blocks(&div.content, state, out, ctx)
+            });
+        }
+        Block::BlockMetadata(_) => {
+            // Metadata blocks don't render to DOCX
+        }
+        Block::NoteDefinitionPara(_) | Block::NoteDefinitionFencedBlock(_) => {
+            // Note definitions are rendered at their references
+        }
+        Block::CaptionBlock(caption) => {
+            write_inline_paragraph(&caption.content, "Caption", state, out, ctx)
+        }
+        Block::Custom(_) => {
+            // Custom block nodes are not rendered in DOCX output
+        }
+    }
+}
+
+fn write_list_items(
+    items: &[Vec<Block>],
+    num: usize,
+    state: &BlockState,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    let level = state.depth;
+    for item in items {
+        let item_state = BlockState {
+            list: Some((num, level.min(8))),
+            depth: level + 1,
+            ..state.clone()
+        };
+        for (i, block) in item.iter().enumerate() {
+            let block_state = if i == 0 {
+                item_state.clone()
+            } else {
+                item_state.continuation()
+            };
+            write_block(block, &block_state, out, ctx);
+        }
+    }
+}
+
+/// Write a sequence of blocks
+fn write_blocks(
+    blocks: &[Block],
+    state: &BlockState,
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    for block in blocks {
+        write_block(block, state, out, ctx);
+    }
+}
+
+// =============================================================================
+// Tables
+// =============================================================================
+
+fn alignment_value(alignment: &Alignment) -> Option<&'static str> {
+    match alignment {
+        Alignment::Left => Some("left"),
+        Alignment::Center => Some("center"),
+        Alignment::Right => Some("right"),
+        Alignment::Default => None,
+    }
+}
+
+fn write_table(table: &Table, state: &BlockState, out: &mut String, ctx: &mut DocxWriterContext) {
+    if let Some(caption) = &table.caption.long {
+        let caption_state = BlockState {
+            style: Some("TableCaption"),
+            list: None,
+            ..state.clone()
+        };
+        write_blocks(caption, &caption_state, out, ctx);
+    }
+
+    let columns = table.colspec.len().max(1);
+    let all_default = table
+        .colspec
+        .iter()
+        .all(|(_, width)| matches!(width, ColWidth::Default));
+    let widths: Vec<u64> = table
+        .colspec
+        .iter()
+        .map(|(_, width)| match width {
+            ColWidth::Percentage(p) if !all_default => (p * TEXT_WIDTH_TWIPS as f64) as u64,
+            _ => TEXT_WIDTH_TWIPS / columns as u64,
+        })
+        .collect();
+
+    let table_width = if all_default {
+        "<w:tblW w:w=\"0\" w:type=\"auto\"/>".to_string()
+    } else {
+        format!(
+            "<w:tblW w:w=\"{}\" w:type=\"dxa\"/><w:tblLayout w:type=\"fixed\"/>",
+            widths.iter().sum::<u64>()
+        )
+    };
+    out.push_str(&format!(
+        "<w:tbl><w:tblPr><w:tblStyle w:val=\"Table\"/>{}\
+         <w:tblLook w:val=\"0020\" w:firstRow=\"1\" w:lastRow=\"0\" w:firstColumn=\"0\" \
+         w:lastColumn=\"0\" w:noHBand=\"0\" w:noVBand=\"0\"/></w:tblPr><w:tblGrid>",
+        table_width
+    ));
+    for width in &widths {
+        out.push_str(&format!("<w:gridCol w:w=\"{}\"/>", width));
+    }
+    out.push_str("</w:tblGrid>");
+
+    // Remaining rows and column span of vertical merges started above, per column
+    let mut pending: Vec<(usize, usize)> = vec![(0, 1); columns];
+    let cell_state = BlockState {
+        style: Some("Compact"),
+        list: None,
+        depth: 0,
+        align: None,
+    };
+    for row in &table.head.rows {
+        write_table_row(row, table, true, &cell_state, &mut pending, out, ctx);
+    }
+    for body in &table.bodies {
+        for row in body.head.iter().chain(body.body.iter()) {
+            write_table_row(row, table, false, &cell_state, &mut pending, out, ctx);
+        }
+    }
+    for row in &table.foot.rows {
+        write_table_row(row, table, false, &cell_state, &mut pending, out, ctx);
+    }
+    out.push_str("</w:tbl>");
+}
+
+fn write_merge_continuation(
+    column: usize,
+    pending: &mut [(usize, usize)],
+    out: &mut String,
+) -> usize {
+    let (_, span) = pending[column];
+    pending[column].0 -= 1;
+    let grid_span = if span > 1 {
+        format!("<w:gridSpan w:val=\"{}\"/>", span)
+    } else {
+        String::new()
+    };
+    out.push_str(&format!(
+        "<w:tc><w:tcPr>{}<w:vMerge/></w:tcPr><w:p/></w:tc>",
+        grid_span
+    ));
+    column + span
+}
+
+fn write_table_row(
+    row: &Row,
+    table: &Table,
+    is_header: bool,
+    cell_state: &BlockState,
+    pending: &mut [(usize, usize)],
+    out: &mut String,
+    ctx: &mut DocxWriterContext,
+) {
+    let columns = pending.len();
+    out.push_str("<w:tr>");
+    if is_header {
+        out.push_str("<w:trPr><w:tblHeader/></w:trPr>");
+    }
+    let mut column = 0;
+    for cell in &row.cells {
+        while column < columns && pending[column].0 > 0 {
+            column = write_merge_continuation(column, pending, out);
+        }
+        if column >= columns {
+            break;
+        }
+
+        let span = cell.col_span.max(1).min(columns - column);
+        out.push_str("<w:tc><w:tcPr>");
+        if span > 1 {
+            out.push_str(&format!("<w:gridSpan w:val=\"{}\"/>", span));
+        }
+        if cell.row_span > 1 {
+            out.push_str("<w:vMerge w:val=\"restart\"/>");
+            pending[column] = (cell.row_span - 1, span);
+        }
+        out.push_str("</w:tcPr>");
+
+        let alignment = match cell.alignment {
+            Alignment::Default => table
+                .colspec
+                .get(column)
+                .map_or(&Alignment::Default, |(alignment, _)| alignment),
+            ref explicit => explicit,
+        };
+        let state = BlockState {
+            align: alignment_value(alignment),
+            ..cell_state.clone()
+        };
+        let before = out.len();
+        write_blocks(&cell.content, &state, out, ctx);
+        if out.len() == before {
+            // Every cell needs at least one paragraph
+            out.push_str("<w:p/>");
+        }
+        out.push_str("</w:tc>");
+        column += span;
+    }
+    while column < columns && pending[column].0 > 0 {
+        column = write_merge_continuation(column, pending, out);
+    }
+    out.push_str("</w:tr>");
+}
+
+// =============================================================================
+// Package
+// =============================================================================
+
+fn meta_inlines(value: &ConfigValue) -> Option<Inlines> {
+    match &value.value {
+        ConfigValueKind::PandocInlines(inlines) => Some(inlines.clone()),
+        _ => value.as_plain_text().map(|text| {
+            vec![Inline::Str(crate::pandoc::inline::Str {
+                text,
+                source_info: quarto_source_map::SourceInfo::default(),
+            })]
+        }),
+    }
+}
+
+/// Authors may be a single value, a list, or a list of maps with `name`
+fn meta_authors(meta: &ConfigValue) -> Vec<Inlines> {
+    let Some(author) = meta.get("author") else {
+        return Vec::new();
+    };
+    let entries: Vec<&ConfigValue> = match author.as_array() {
+        Some(items) => items.iter().collect(),
+        None => vec![author],
+    };
+    entries
+        .into_iter()
+        .filter_map(|entry| meta_inlines(entry.get("name").unwrap_or(entry)))
+        .collect()
+}
+
+fn write_title_block(pandoc: &Pandoc, out: &mut String, ctx: &mut DocxWriterContext) {
+    let state = BlockState::default();
+    for (key, style) in [("title", "Title"), ("subtitle", "Subtitle")] {
+        if let Some(inlines) = pandoc.meta.get(key).and_then(meta_inlines) {
+            write_inline_paragraph(&inlines, style, &state, out, ctx);
+        }
+    }
+    for author in meta_authors(&pandoc.meta) {
+        write_inline_paragraph(&author, "Author", &state, out, ctx);
+    }
+    if let Some(inlines) = pandoc.meta.get("date").and_then(meta_inlines) {
+        write_inline_paragraph(&inlines, "Date", &state, out, ctx);
+    }
+}
+
+fn relationships_xml(rels: &[Relationship]) -> String {
+    let mut xml = String::from(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n\
+         <Relationships xmlns=\"http://schemas.openxmlformats.org/package/2006/relationships\">",
+    );
+    for rel in rels {
+        xml.push_str(&format!(
+            "<Relationship Id=\"{}\" Type=\"{}\" Target=\"{}\"{}/>",
+            rel.id,
+            rel.rel_type,
+            escape_xml(&rel.target),
+            if rel.external {
+                " TargetMode=\"External\""
+            } else {
+                ""
+            }
+        ));
+    }
+    xml.push_str("</Relationships>");
+    xml
+}
+
+fn part_xml(root: &str, content: &str) -> String {
+    format!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n\
+         <w:{root} xmlns:w=\"{NS_W}\" xmlns:r=\"{NS_R}\" xmlns:wp=\"{NS_WP}\" \
+         xmlns:a=\"{NS_A}\" xmlns:pic=\"{NS_PIC}\">{content}</w:{root}>"
+    )
+}
+
+fn core_properties_xml(pandoc: &Pandoc) -> String {
+    let plain = |inlines: &Inlines| escape_xml(inlines_to_string(inlines).0.trim());
+    let title = pandoc
+        .meta
+        .get("title")
+        .and_then(meta_inlines)
+        .map(|t| format!("<dc:title>{}</dc:title>", plain(&t)))
+        .unwrap_or_default();
+    let authors: Vec<String> = meta_authors(&pandoc.meta).iter().map(plain).collect();
+    let creator = if authors.is_empty() {
+        String::new()
+    } else {
+        format!("<dc:creator>{}</dc:creator>", authors.join("; "))
+    };
+    format!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n\
+         <cp:coreProperties \
+         xmlns:cp=\"http://schemas.openxmlformats.org/package/2006/metadata/core-properties\" \
+         xmlns:dc=\"http://purl.org/dc/elements/1.1/\">{}{}</cp:coreProperties>",
+        title, creator
+    )
+}
+
+fn content_types_xml(ctx: &DocxWriterContext) -> String {
+    let mut xml = String::from(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n\
+         <Types xmlns=\"http://schemas.openxmlformats.org/package/2006/content-types\">\
+         <Default Extension=\"rels\" ContentType=\"application/vnd.openxmlformats-package.relationships+xml\"/>\
+         <Default Extension=\"xml\" ContentType=\"application/xml\"/>",
+    );
+    let mut extensions: Vec<&str> = ctx
+        .media
+        .iter()
+        .filter_map(|(name, _)| name.rsplit('.').next())
+        .collect();
+    extensions.sort();
+    extensions.dedup();
+    for extension in extensions {
+        xml.push_str(&format!(
+            "<Default Extension=\"{}\" ContentType=\"image/{}\"/>",
+            extension, extension
+        ));
+    }
+    let wml = "application/vnd.openxmlformats-officedocument.wordprocessingml";
+    xml.push_str(&format!(
+        "<Override PartName=\"/word/document.xml\" ContentType=\"{wml}.document.main+xml\"/>\
+         <Override PartName=\"/word/styles.xml\" ContentType=\"{wml}.styles+xml\"/>\
+         <Override PartName=\"/word/numbering.xml\" ContentType=\"{wml}.numbering+xml\"/>\
+         <Override PartName=\"/word/footnotes.xml\" ContentType=\"{wml}.footnotes+xml\"/>\
+         <Override PartName=\"/docProps/core.xml\" \
+         ContentType=\"application/vnd.openxmlformats-package.core-properties+xml\"/>"
+    ));
+    if !ctx.comments.is_empty() {
+        xml.push_str(&format!(
+            "<Override PartName=\"/word/comments.xml\" ContentType=\"{wml}.comments+xml\"/>"
+        ));
+    }
+    xml.push_str("</Types>");
+    xml
+}
+
+/// Extract `word/styles.xml` from a reference document
+fn reference_styles(reference_doc: &[u8]) -> io::Result<String> {
+    let styles = read_entry(reference_doc, "word/styles.xml")?.ok_or_else(|| {
+        io::Error::new(
+            io::ErrorKind::InvalidData,
+            "reference document has no word/styles.xml",
+        )
+    })?;
+    String::from_utf8(styles).map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))
+}
+
+// =============================================================================
+// Public API
+// =============================================================================
+
+/// Write a Pandoc document as a DOCX package using the built-in styles.
+pub fn write<W: Write>(pandoc: &Pandoc, writer: W) -> io::Result<()> {
+    write_with_config(pandoc, &DocxConfig::default(), writer)
+}
+
+/// Write a Pandoc document as a DOCX package.
+pub fn write_with_config<W: Write>(
+    pandoc: &Pandoc,
+    config: &DocxConfig,
+    writer: W,
+) -> io::Result<()> {
+    let styles = match &config.reference_doc {
+        Some(reference_doc) => reference_styles(reference_doc)?,
+        None => DEFAULT_STYLES.to_string(),
+    };
+
+    let mut ctx = DocxWriterContext::new(config);
+    let mut body = String::from("<w:body>");
+    write_title_block(pandoc, &mut body, &mut ctx);
+    write_blocks(&pandoc.blocks, &BlockState::default(), &mut body, &mut ctx);
+    body.push_str(
+        "<w:sectPr><w:pgSz w:w=\"12240\" w:h=\"15840\"/>\
+         <w:pgMar w:top=\"1440\" w:right=\"1440\" w:bottom=\"1440\" w:left=\"1440\" \
+         w:header=\"720\" w:footer=\"720\" w:gutter=\"0\"/></w:sectPr></w:body>",
+    );
+
+    // Relationships to the fixed parts
+    ctx.part = Part::Document;
+    ctx.add_relationship(REL_STYLES, "styles.xml".to_string(), false);
+    ctx.add_relationship(REL_NUMBERING, "numbering.xml".to_string(), false);
+    ctx.add_relationship(REL_FOOTNOTES, "footnotes.xml".to_string(), false);
+    if !ctx.comments.is_empty() {
+        ctx.add_relationship(REL_COMMENTS, "comments.xml".to_string(), false);
+    }
+
+    let numbering: String = ctx
+        .abstract_nums
+        .iter()
+        .cloned()
+        .chain((1..=ctx.abstract_nums.len()).map(|num| {
+            format!(
+                "<w:num w:numId=\"{}\"><w:abstractNumId w:val=\"{}\"/></w:num>",
+                num,
+                num - 1
+            )
+        }))
+        .collect();
+    let footnotes = format!(
+        "<w:footnote w:type=\"separator\" w:id=\"-1\"><w:p><w:pPr><w:spacing w:after=\"0\"/>\
+         </w:pPr><w:r><w:separator/></w:r></w:p></w:footnote>\
+         <w:footnote w:type=\"continuationSeparator\" w:id=\"0\"><w:p><w:pPr>\
+         <w:spacing w:after=\"0\"/></w:pPr><w:r><w:continuationSeparator/></w:r></w:p></w:footnote>\
+         {}",
+        ctx.notes.concat()
+    );
+
+    let mut zip = ZipWriter::new(writer);
+    zip.add_file("[Content_Types].xml", content_types_xml(&ctx).as_bytes())?;
+    zip.add_file(
+        "_rels/.rels",
+        relationships_xml(&[
+            Relationship {
+                id: "rId1".to_string(),
+                rel_type: REL_OFFICE_DOCUMENT,
+                target: "word/document.xml".to_string(),
+                external: false,
+            },
+            Relationship {
+                id: "rId2".to_string(),
+                rel_type: REL_CORE_PROPERTIES,
+                target: "docProps/core.xml".to_string(),
+                external: false,
+            },
+        ])
+        .as_bytes(),
+    )?;
+    zip.add_file("docProps/core.xml", core_properties_xml(pandoc).as_bytes())?;
+    zip.add_file("word/document.xml", part_xml("document", &body).as_bytes())?;
+    zip.add_file("word/styles.xml", styles.as_bytes())?;
+    zip.add_file(
+        "word/numbering.xml",
+        part_xml("numbering", &numbering).as_bytes(),
+    )?;
+    zip.add_file(
+        "word/footnotes.xml",
+        part_xml("footnotes", &footnotes).as_bytes(),
+    )?;
+    zip.add_file(
+        "word/_rels/document.xml.rels",
+        relationships_xml(&ctx.document_rels).as_bytes(),
+    )?;
+    if !ctx.footnote_rels.is_empty() {
+        zip.add_file(
+            "word/_rels/footnotes.xml.rels",
+            relationships_xml(&ctx.footnote_rels).as_bytes(),
+        )?;
+    }
+    if !ctx.comments.is_empty() {
+        zip.add_file(
+            "word/comments.xml",
+            part_xml("comments", &ctx.comments.concat()).as_bytes(),
+        )?;
+        if !ctx.comment_rels.is_empty() {
+            zip.add_file(
+                "word/_rels/comments.xml.rels",
+                relationships_xml(&ctx.comment_rels).as_bytes(),
+            )?;
+        }
+    }
+    for (name, data) in &ctx.media {
+        zip.add_file(&format!("word/media/{}", name), data)?;
+    }
+    zip.finish()?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_escape_xml() {
+        assert_eq!(escape_xml("a<b & \"c\">"), "a&lt;b &amp; &quot;c&quot;&gt;");
+        assert_eq!(escape_xml("bell\u{7}tab\t"), "belltab\t");
+    }
+
+    #[test]
+    fn test_image_info_png() {
+        let mut png = b"\x89PNG\r\n\x1a\n\0\0\0\rIHDR".to_vec();
+        png.extend_from_slice(&640u32.to_be_bytes());
+        png.extend_from_slice(&480u32.to_be_bytes());
+        assert_eq!(image_info(&png), Some(("png", 640, 480)));
+        assert_eq!(image_info(b"not an image"), None);
+    }
+
+    #[test]
+    fn test_dimension_to_emu() {
+        assert_eq!(dimension_to_emu("1in"), Some(914400.0));
+        assert_eq!(dimension_to_emu("100"), Some(952500.0));
+        assert_eq!(dimension_to_emu("50%"), Some(TEXT_WIDTH_EMU as f64 / 2.0));
+        assert_eq!(dimension_to_emu("wide"), None);
+    }
+
+    #[test]
+    fn test_run_properties_order() {
+        let props = RunProps {
+            bold: true,
+            underline: true,
+            superscript: true,
+            style: Some("VerbatimChar"),
+            ..RunProps::default()
+        };
+        assert_eq!(
+            props.to_xml(),
+            "<w:rPr><w:rStyle w:val=\"VerbatimChar\"/><w:b/><w:bCs/><w:u w:val=\"single\"/>\
+             <w:vertAlign w:val=\"superscript\"/></w:rPr>"
+        );
+    }
+}
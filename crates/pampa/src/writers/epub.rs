@@ -0,0 +1,971 @@
+/*
+ * epub.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! EPUB 3 writer for Pandoc AST.
+//!
+//! The book is split into chapters at level-1 headings (or at the levels
+//! set by `epub-chapter-level`), and each chapter is written by the HTML
+//! writer into its own XHTML file. Around them the writer builds the rest
+//! of the package: the OPF package document, `nav.xhtml` and an EPUB 2
+//! `toc.ncx` for older readers, a title page, an optional cover, the
+//! stylesheet, and the images and fonts the book uses.
+//!
+//! Metadata is looked up in the document first, then under `format.epub`
+//! and then under `book`, so a book's `_quarto.yml` given with
+//! `--metadata-file` supplies the title, authors, language, cover image and
+//! fonts. Links to an identifier in another chapter are rewritten to point
+//! into that chapter's file.
+//!
+//! The package has no timestamps other than `dcterms:modified`, taken from
+//! [`EpubConfig::modified`], and its identifier, when the metadata has none,
+//! is derived from the content, so the same input yields the same file.
+
+use crate::pandoc::{Block, ConfigValue, ConfigValueKind, Inline, Inlines, Pandoc};
+use crate::utils::timestamp::iso8601;
+use crate::utils::zip::ZipWriter;
+use crate::writers::html::{self, HtmlConfig};
+use crate::writers::plaintext::inlines_to_string;
+use base64::Engine;
+use sha1::{Digest, Sha1};
+use std::collections::HashMap;
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+
+const NS_XHTML: &str = "http://www.w3.org/1999/xhtml";
+const NS_OPS: &str = "http://www.idpf.org/2007/ops";
+
+const DEFAULT_CSS: &str = "\
+body { margin: 5%; text-align: justify; font-size: medium; }
+h1, h2, h3, h4, h5, h6 { text-align: left; }
+h1.title { text-align: center; }
+p.subtitle, p.author, p.date { text-align: center; }
+nav#toc ol, nav#landmarks ol { padding: 0; margin-left: 1em; }
+nav#toc ol li, nav#landmarks ol li { list-style-type: none; margin: 0; padding: 0; }
+a.footnote-ref { vertical-align: super; }
+em, em em em, em em em em em { font-style: italic; }
+em em, em em em em { font-style: normal; }
+code { white-space: pre-wrap; }
+span.smallcaps { font-variant: small-caps; }
+div.column { display: inline-block; vertical-align: top; width: 50%; }
+div.hanging-indent { margin-left: 1.5em; text-indent: -1.5em; }
+ul.task-list { list-style: none; }
+img.cover { max-width: 100%; max-height: 100%; }
+";
+
+// =============================================================================
+// Configuration
+// =============================================================================
+
+/// Options for the EPUB writer.
+#[derive(Debug, Clone, Default)]
+pub struct EpubConfig {
+    /// Directory that relative image, font, stylesheet and cover paths are
+    /// resolved against. Images that cannot be read keep their reference.
+    pub resource_dir: Option<PathBuf>,
+    /// The time written as `dcterms:modified`, in seconds since the Unix
+    /// epoch
+    pub modified: u64,
+}
+
+// =============================================================================
+// Metadata
+// =============================================================================
+
+/// Look up a metadata key in the document, then under `format.epub`, then
+/// under `book`
+fn lookup<'a>(meta: &'a ConfigValue, key: &str) -> Option<&'a ConfigValue> {
+    meta.get(key)
+        .or_else(|| meta.get_path(&["format", "epub", key]))
+        .or_else(|| meta.get_path(&["book", key]))
+}
+
+fn meta_inlines(value: &ConfigValue) -> Option<Inlines> {
+    match &value.value {
+        ConfigValueKind::PandocInlines(inlines) => Some(inlines.clone()),
+        _ => value.as_plain_text().map(|text| {
+            vec![Inline::Str(crate::pandoc::inline::Str {
+                text,
+                source_info: quarto_source_map::SourceInfo::default(),
+            })]
+        }),
+    }
+}
+
+fn meta_text(meta: &ConfigValue, key: &str) -> Option<String> {
+    let inlines = lookup(meta, key).and_then(meta_inlines)?;
+    let text = inlines_to_string(&inlines).0.trim().to_string();
+    (!text.is_empty()).then_some(text)
+}
+
+/// A value that may be a single string or a list of them
+fn meta_strings(meta: &ConfigValue, key: &str) -> Vec<String> {
+    let Some(value) = lookup(meta, key) else {
+        return Vec::new();
+    };
+    match value.as_array() {
+        Some(items) => items
+            .iter()
+            .filter_map(ConfigValue::as_plain_text)
+            .collect(),
+        None => value.as_plain_text().into_iter().collect(),
+    }
+}
+
+/// Authors may be a single value, a list, or a list of maps with `name`
+fn meta_authors(meta: &ConfigValue) -> Vec<String> {
+    let Some(author) = lookup(meta, "author") else {
+        return Vec::new();
+    };
+    let entries: Vec<&ConfigValue> = match author.as_array() {
+        Some(items) => items.iter().collect(),
+        None => vec![author],
+    };
+    entries
+        .into_iter()
+        .filter_map(|entry| meta_inlines(entry.get("name").unwrap_or(entry)))
+        .map(|inlines| inlines_to_string(&inlines).0.trim().to_string())
+        .filter(|name| !name.is_empty())
+        .collect()
+}
+
+fn meta_usize(meta: &ConfigValue, key: &str, default: usize) -> usize {
+    lookup(meta, key)
+        .and_then(|value| {
+            value
+                .as_int()
+                .or_else(|| value.as_plain_text()?.trim().parse().ok())
+        })
+        .and_then(|n| usize::try_from(n).ok())
+        .filter(|n| *n > 0)
+        .unwrap_or(default)
+}
+
+// =============================================================================
+// Package contents
+// =============================================================================
+
+/// A file of the package other than the documents, under `EPUB/`
+struct Resource {
+    /// Path relative to `EPUB/`
+    href: String,
+    media_type: &'static str,
+    data: Vec<u8>,
+}
+
+/// The resources of the book, each written once
+#[derive(Default)]
+struct Resources {
+    items: Vec<Resource>,
+    /// Package paths of the images already added, by their reference
+    images: HashMap<String, String>,
+}
+
+impl Resources {
+    fn add(&mut self, href: String, media_type: &'static str, data: Vec<u8>) {
+        self.items.push(Resource {
+            href,
+            media_type,
+            data,
+        });
+    }
+
+    /// Embed the image a document refers to and return its path relative to
+    /// `EPUB/`, or `None` when it can't be read
+    fn image(&mut self, reference: &str, resource_dir: Option<&Path>) -> Option<String> {
+        if let Some(href) = self.images.get(reference) {
+            return Some(href.clone());
+        }
+        let data = load(reference, resource_dir)?;
+        let (extension, media_type) = image_type(&data)?;
+        let href = format!("media/file{}.{}", self.images.len(), extension);
+        self.images.insert(reference.to_string(), href.clone());
+        self.add(href.clone(), media_type, data);
+        Some(href)
+    }
+}
+
+/// Load a data URI or a local file
+fn load(reference: &str, resource_dir: Option<&Path>) -> Option<Vec<u8>> {
+    if let Some(rest) = reference.strip_prefix("data:") {
+        let (header, payload) = rest.split_once(',')?;
+        if !header.ends_with(";base64") {
+            return None;
+        }
+        return base64::engine::general_purpose::STANDARD
+            .decode(payload)
+            .ok();
+    }
+    if reference.contains("://") {
+        return None;
+    }
+    let path = match resource_dir {
+        Some(dir) => dir.join(reference),
+        None => PathBuf::from(reference),
+    };
+    std::fs::read(path).ok()
+}
+
+/// Detect a supported image format, returning its extension and media type
+fn image_type(data: &[u8]) -> Option<(&'static str, &'static str)> {
+    if data.starts_with(b"\x89PNG\r\n\x1a\n") {
+        return Some(("png", "image/png"));
+    }
+    if data.starts_with(b"GIF87a") || data.starts_with(b"GIF89a") {
+        return Some(("gif", "image/gif"));
+    }
+    if data.starts_with(&[0xFF, 0xD8]) {
+        return Some(("jpg", "image/jpeg"));
+    }
+    if data.len() >= 12 && data.starts_with(b"RIFF") && &data[8..12] == b"WEBP" {
+        return Some(("webp", "image/webp"));
+    }
+    let head = String::from_utf8_lossy(&data[..data.len().min(1024)]);
+    if head.contains("<svg") {
+        return Some(("svg", "image/svg+xml"));
+    }
+    None
+}
+
+fn font_type(path: &str) -> Option<&'static str> {
+    let extension = Path::new(path).extension()?.to_str()?.to_ascii_lowercase();
+    match extension.as_str() {
+        "ttf" => Some("font/ttf"),
+        "otf" => Some("font/otf"),
+        "woff" => Some("font/woff"),
+        "woff2" => Some("font/woff2"),
+        _ => None,
+    }
+}
+
+fn escape_xml(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            // Control characters other than tab/newline are not allowed in XML
+            c if (c as u32) < 0x20 && c != '\t' && c != '\n' => {}
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+fn unescape_xml(text: &str) -> String {
+    text.replace("&quot;", "\"")
+        .replace("&lt;", "<")
+        .replace("&gt;", ">")
+        .replace("&amp;", "&")
+}
+
+/// The manifest id of a package path, e.g. `text_ch001_xhtml`
+fn item_id(href: &str) -> String {
+    href.chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '_' })
+        .collect()
+}
+
+// =============================================================================
+// Chapters
+// =============================================================================
+
+struct Chapter {
+    /// File name under `EPUB/text`
+    file: String,
+    title: Option<String>,
+    /// The chapter's XHTML body
+    body: String,
+    /// Headings for the table of contents, as (level, label, id)
+    headings: Vec<(usize, String, String)>,
+}
+
+/// Split the blocks before each heading of at most `level`
+fn split_chapters(blocks: &[Block], level: usize) -> Vec<&[Block]> {
+    let mut chapters = Vec::new();
+    let mut start = 0;
+    for (i, block) in blocks.iter().enumerate() {
+        if let Block::Header(header) = block
+            && header.level <= level
+            && i > start
+        {
+            chapters.push(&blocks[start..i]);
+            start = i;
+        }
+    }
+    if start < blocks.len() {
+        chapters.push(&blocks[start..]);
+    }
+    chapters
+}
+
+/// The values of the `attribute="..."` occurrences in written HTML, still
+/// escaped
+fn attribute_values<'a>(html: &'a str, attribute: &str) -> Vec<&'a str> {
+    let pattern = format!(" {}=\"", attribute);
+    let mut values = Vec::new();
+    let mut rest = html;
+    while let Some(at) = rest.find(&pattern) {
+        rest = &rest[at + pattern.len()..];
+        let Some(end) = rest.find('"') else {
+            break;
+        };
+        values.push(&rest[..end]);
+        rest = &rest[end..];
+    }
+    values
+}
+
+/// Replace the values of `attribute` for which `replace` returns a new one
+fn rewrite_attribute(
+    html: &str,
+    attribute: &str,
+    mut replace: impl FnMut(&str) -> Option<String>,
+) -> String {
+    let pattern = format!(" {}=\"", attribute);
+    let mut out = String::with_capacity(html.len());
+    let mut rest = html;
+    while let Some(at) = rest.find(&pattern) {
+        let start = at + pattern.len();
+        out.push_str(&rest[..start]);
+        rest = &rest[start..];
+        let end = rest.find('"').unwrap_or(rest.len());
+        match replace(&rest[..end]) {
+            Some(value) => out.push_str(&value),
+            None => out.push_str(&rest[..end]),
+        }
+        rest = &rest[end..];
+    }
+    out.push_str(rest);
+    out
+}
+
+fn write_chapters(
+    pandoc: &Pandoc,
+    html_config: &HtmlConfig,
+    resource_dir: Option<&Path>,
+    resources: &mut Resources,
+) -> io::Result<(Vec<Chapter>, bool)> {
+    let chapter_level = meta_usize(&pandoc.meta, "epub-chapter-level", 1);
+    let mut highlighted = false;
+    let mut chapters = Vec::new();
+    for (i, blocks) in split_chapters(&pandoc.blocks, chapter_level)
+        .into_iter()
+        .enumerate()
+    {
+        let mut buf = Vec::new();
+        highlighted |= html::write_blocks_with_config(blocks, &mut buf, html_config.clone())?;
+        let body = String::from_utf8(buf).map_err(io::Error::other)?;
+        let headings: Vec<(usize, String, String)> = blocks
+            .iter()
+            .filter_map(|block| match block {
+                Block::Header(header) => Some((
+                    header.level,
+                    inlines_to_string(&header.content).0.trim().to_string(),
+                    header.attr.0.clone(),
+                )),
+                _ => None,
+            })
+            .collect();
+        // Images are embedded, and their references point into the package
+        let body = rewrite_attribute(&body, "src", |reference| {
+            resources
+                .image(&unescape_xml(reference), resource_dir)
+                .map(|href| format!("../{}", href))
+        });
+        chapters.push(Chapter {
+            file: format!("ch{:03}.xhtml", i + 1),
+            title: headings.first().map(|(_, label, _)| label.clone()),
+            body,
+            headings,
+        });
+    }
+
+    // Links to an identifier of another chapter go to its file. Footnote
+    // ids repeat in every chapter, so the chapter's own ids come first.
+    let mut owners: HashMap<String, usize> = HashMap::new();
+    let ids: Vec<Vec<String>> = chapters
+        .iter()
+        .map(|chapter| {
+            attribute_values(&chapter.body, "id")
+                .into_iter()
+                .map(str::to_string)
+                .collect()
+        })
+        .collect();
+    for (i, chapter_ids) in ids.iter().enumerate() {
+        for id in chapter_ids {
+            owners.entry(id.clone()).or_insert(i);
+        }
+    }
+    for (i, chapter) in chapters.iter_mut().enumerate() {
+        chapter.body = rewrite_attribute(&chapter.body, "href", |href| {
+            let id = href.strip_prefix('#')?;
+            if ids[i].iter().any(|own| own == id) {
+                return None;
+            }
+            let owner = *owners.get(id)?;
+            Some(format!("ch{:03}.xhtml#{}", owner + 1, id))
+        });
+    }
+    Ok((chapters, highlighted))
+}
+
+// =============================================================================
+// Documents
+// =============================================================================
+
+/// An XHTML content document
+fn xhtml(lang: &str, title: &str, body_type: &str, body: &str, stylesheet: &str) -> String {
+    format!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n\
+         <!DOCTYPE html>\n\
+         <html xmlns=\"{NS_XHTML}\" xmlns:epub=\"{NS_OPS}\" xml:lang=\"{lang}\" lang=\"{lang}\">\n\
+         <head>\n\
+         <meta charset=\"utf-8\" />\n\
+         <title>{title}</title>\n\
+         <link rel=\"stylesheet\" type=\"text/css\" href=\"{stylesheet}\" />\n\
+         </head>\n\
+         <body epub:type=\"{body_type}\">\n\
+         {body}\
+         </body>\n\
+         </html>\n",
+        lang = escape_xml(lang),
+        title = escape_xml(title),
+    )
+}
+
+fn title_page_body(pandoc: &Pandoc) -> io::Result<String> {
+    let mut out = Vec::new();
+    writeln!(out, "<section epub:type=\"titlepage\" class=\"titlepage\">")?;
+    let written = |key: &str| -> io::Result<Option<String>> {
+        let Some(inlines) = lookup(&pandoc.meta, key).and_then(meta_inlines) else {
+            return Ok(None);
+        };
+        let mut buf = Vec::new();
+        html::write_inlines_to(&inlines, &mut buf)?;
+        Ok(Some(String::from_utf8_lossy(&buf).into_owned()))
+    };
+    if let Some(title) = written("title")? {
+        writeln!(out, "<h1 class=\"title\">{}</h1>", title)?;
+    }
+    if let Some(subtitle) = written("subtitle")? {
+        writeln!(out, "<p class=\"subtitle\">{}</p>", subtitle)?;
+    }
+    for author in meta_authors(&pandoc.meta) {
+        writeln!(out, "<p class=\"author\">{}</p>", escape_xml(&author))?;
+    }
+    if let Some(date) = written("date")? {
+        writeln!(out, "<p class=\"date\">{}</p>", date)?;
+    }
+    writeln!(out, "</section>")?;
+    Ok(String::from_utf8_lossy(&out).into_owned())
+}
+
+/// An entry of the table of contents, with the entries below it
+struct TocEntry {
+    label: String,
+    href: String,
+    children: Vec<TocEntry>,
+}
+
+/// Nest the headings from `items` of at least `level`
+fn nest_toc(
+    items: &mut std::iter::Peekable<impl Iterator<Item = (usize, String, String)>>,
+    level: usize,
+) -> Vec<TocEntry> {
+    let mut entries = Vec::new();
+    while let Some((item_level, _, _)) = items.peek() {
+        if *item_level < level {
+            break;
+        }
+        let (item_level, label, href) = items.next().unwrap();
+        let children = nest_toc(items, item_level + 1);
+        entries.push(TocEntry {
+            label,
+            href,
+            children,
+        });
+    }
+    entries
+}
+
+fn toc_entries(chapters: &[Chapter], depth: usize) -> Vec<TocEntry> {
+    let mut items = chapters
+        .iter()
+        .flat_map(|chapter| {
+            chapter
+                .headings
+                .iter()
+                .filter(|(level, _, _)| *level <= depth)
+                .map(|(level, label, id)| {
+                    let href = if id.is_empty() {
+                        format!("text/{}", chapter.file)
+                    } else {
+                        format!("text/{}#{}", chapter.file, id)
+                    };
+                    (*level, label.clone(), href)
+                })
+        })
+        .peekable();
+    nest_toc(&mut items, 1)
+}
+
+fn write_nav_entries(entries: &[TocEntry], out: &mut String) {
+    out.push_str("<ol class=\"toc\">\n");
+    for entry in entries {
+        out.push_str(&format!(
+            "<li><a href=\"{}\">{}</a>",
+            escape_xml(&entry.href),
+            escape_xml(&entry.label)
+        ));
+        if !entry.children.is_empty() {
+            out.push('\n');
+            write_nav_entries(&entry.children, out);
+        }
+        out.push_str("</li>\n");
+    }
+    out.push_str("</ol>\n");
+}
+
+fn nav_body(toc: &[TocEntry], toc_title: &str, landmarks: &[(&str, &str, String)]) -> String {
+    let mut out = format!(
+        "<nav epub:type=\"toc\" id=\"toc\">\n<h1 id=\"toc-title\">{}</h1>\n",
+        escape_xml(toc_title)
+    );
+    write_nav_entries(toc, &mut out);
+    out.push_str(
+        "</nav>\n<nav epub:type=\"landmarks\" id=\"landmarks\" hidden=\"hidden\">\n<ol>\n",
+    );
+    for (epub_type, label, href) in landmarks {
+        out.push_str(&format!(
+            "<li><a href=\"{}\" epub:type=\"{}\">{}</a></li>\n",
+            escape_xml(href),
+            epub_type,
+            escape_xml(label)
+        ));
+    }
+    out.push_str("</ol>\n</nav>\n");
+    out
+}
+
+fn write_nav_points(entries: &[TocEntry], play_order: &mut usize, out: &mut String) {
+    for entry in entries {
+        *play_order += 1;
+        out.push_str(&format!(
+            "<navPoint id=\"navPoint-{n}\" playOrder=\"{n}\">\n\
+             <navLabel><text>{}</text></navLabel>\n\
+             <content src=\"{}\" />\n",
+            escape_xml(&entry.label),
+            escape_xml(&entry.href),
+            n = play_order
+        ));
+        write_nav_points(&entry.children, play_order, out);
+        out.push_str("</navPoint>\n");
+    }
+}
+
+fn ncx(identifier: &str, title: &str, toc: &[TocEntry]) -> String {
+    let mut points = String::new();
+    write_nav_points(toc, &mut 0, &mut points);
+    format!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n\
+         <ncx version=\"2005-1\" xmlns=\"http://www.daisy.org/z3986/2005/ncx/\">\n\
+         <head>\n\
+         <meta name=\"dtb:uid\" content=\"{}\" />\n\
+         <meta name=\"dtb:depth\" content=\"1\" />\n\
+         <meta name=\"dtb:totalPageCount\" content=\"0\" />\n\
+         <meta name=\"dtb:maxPageNumber\" content=\"0\" />\n\
+         </head>\n\
+         <docTitle><text>{}</text></docTitle>\n\
+         <navMap>\n{}</navMap>\n\
+         </ncx>\n",
+        escape_xml(identifier),
+        escape_xml(title),
+        points
+    )
+}
+
+/// The book's identifier: `identifier` or `isbn` from the metadata, or a
+/// UUID derived from the content
+fn identifier(pandoc: &Pandoc, chapters: &[Chapter]) -> String {
+    if let Some(identifier) = meta_text(&pandoc.meta, "identifier") {
+        return identifier;
+    }
+    if let Some(isbn) = meta_text(&pandoc.meta, "isbn") {
+        return format!("urn:isbn:{}", isbn);
+    }
+    let mut hasher = Sha1::new();
+    hasher.update(meta_text(&pandoc.meta, "title").unwrap_or_default());
+    for author in meta_authors(&pandoc.meta) {
+        hasher.update(author);
+    }
+    for chapter in chapters {
+        hasher.update(&chapter.body);
+    }
+    let hash = hasher.finalize();
+    let hex: String = hash[..16].iter().map(|b| format!("{:02x}", b)).collect();
+    format!(
+        "urn:uuid:{}-{}-5{}-{}-{}",
+        &hex[0..8],
+        &hex[8..12],
+        &hex[13..16],
+        &hex[16..20],
+        &hex[20..32]
+    )
+}
+
+/// A spine entry: its manifest id and whether it is in the reading order
+struct SpineItem {
+    id: String,
+    linear: bool,
+}
+
+struct Package<'a> {
+    identifier: &'a str,
+    title: &'a str,
+    lang: &'a str,
+    modified: u64,
+    /// Manifest items as (href, media type, properties)
+    items: Vec<(String, &'static str, Option<&'static str>)>,
+    spine: Vec<SpineItem>,
+    cover_id: Option<String>,
+}
+
+fn opf(pandoc: &Pandoc, package: &Package) -> String {
+    let mut metadata = format!(
+        "<dc:identifier id=\"epub-id-1\">{}</dc:identifier>\n\
+         <dc:title id=\"epub-title-1\">{}</dc:title>\n",
+        escape_xml(package.identifier),
+        escape_xml(package.title)
+    );
+    for (i, author) in meta_authors(&pandoc.meta).iter().enumerate() {
+        metadata.push_str(&format!(
+            "<dc:creator id=\"epub-creator-{}\">{}</dc:creator>\n",
+            i + 1,
+            escape_xml(author)
+        ));
+    }
+    metadata.push_str(&format!(
+        "<dc:language>{}</dc:language>\n",
+        escape_xml(package.lang)
+    ));
+    // dc:date must be a W3C date; free-form dates stay on the title page
+    if let Some(date) = meta_text(&pandoc.meta, "date")
+        && date.len() >= 4
+        && date.as_bytes()[..4].iter().all(u8::is_ascii_digit)
+    {
+        metadata.push_str(&format!("<dc:date>{}</dc:date>\n", escape_xml(&date)));
+    }
+    for (key, element) in [
+        ("publisher", "dc:publisher"),
+        ("rights", "dc:rights"),
+        ("description", "dc:description"),
+    ] {
+        if let Some(text) = meta_text(&pandoc.meta, key) {
+            metadata.push_str(&format!("<{element}>{}</{element}>\n", escape_xml(&text)));
+        }
+    }
+    for subject in meta_strings(&pandoc.meta, "subject")
+        .into_iter()
+        .chain(meta_strings(&pandoc.meta, "keywords"))
+    {
+        metadata.push_str(&format!(
+            "<dc:subject>{}</dc:subject>\n",
+            escape_xml(&subject)
+        ));
+    }
+    metadata.push_str(&format!(
+        "<meta property=\"dcterms:modified\">{}</meta>\n",
+        iso8601(package.modified)
+    ));
+    if let Some(cover_id) = &package.cover_id {
+        metadata.push_str(&format!(
+            "<meta name=\"cover\" content=\"{}\" />\n",
+            cover_id
+        ));
+    }
+
+    let mut manifest = String::new();
+    for (href, media_type, properties) in &package.items {
+        manifest.push_str(&format!(
+            "<item id=\"{}\" href=\"{}\" media-type=\"{}\"",
+            item_id(href),
+            escape_xml(href),
+            media_type
+        ));
+        if let Some(properties) = properties {
+            manifest.push_str(&format!(" properties=\"{}\"", properties));
+        }
+        manifest.push_str(" />\n");
+    }
+    let spine: String = package
+        .spine
+        .iter()
+        .map(|item| {
+            format!(
+                "<itemref idref=\"{}\" linear=\"{}\" />\n",
+                item.id,
+                if item.linear { "yes" } else { "no" }
+            )
+        })
+        .collect();
+
+    format!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n\
+         <package version=\"3.0\" xmlns=\"http://www.idpf.org/2007/opf\" \
+         unique-identifier=\"epub-id-1\" xml:lang=\"{}\">\n\
+         <metadata xmlns:dc=\"http://purl.org/dc/elements/1.1/\" \
+         xmlns:opf=\"http://www.idpf.org/2007/opf\">\n{}</metadata>\n\
+         <manifest>\n{}</manifest>\n\
+         <spine toc=\"toc_ncx\">\n{}</spine>\n\
+         </package>\n",
+        escape_xml(package.lang),
+        metadata,
+        manifest,
+        spine
+    )
+}
+
+const CONTAINER_XML: &str = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n\
+<container version=\"1.0\" xmlns=\"urn:oasis:names:tc:opendocument:xmlns:container\">\n\
+<rootfiles>\n\
+<rootfile full-path=\"EPUB/content.opf\" media-type=\"application/oebps-package+xml\" />\n\
+</rootfiles>\n\
+</container>\n";
+
+// =============================================================================
+// Public API
+// =============================================================================
+
+/// Write a Pandoc document as an EPUB with the default configuration
+pub fn write<W: Write>(pandoc: &Pandoc, writer: W) -> io::Result<()> {
+    write_with_config(pandoc, &EpubConfig::default(), writer)
+}
+
+/// Write a Pandoc document as an EPUB
+pub fn write_with_config<W: Write>(
+    pandoc: &Pandoc,
+    config: &EpubConfig,
+    writer: W,
+) -> io::Result<()> {
+    let meta = &pandoc.meta;
+    let resource_dir = config.resource_dir.as_deref();
+    let mut html_config = html::extract_config_from_metadata(meta);
+    html_config.include_source_locations = false;
+
+    let mut resources = Resources::default();
+    let (chapters, highlighted) =
+        write_chapters(pandoc, &html_config, resource_dir, &mut resources)?;
+
+    let title = meta_text(meta, "title").unwrap_or_else(|| "Untitled".to_string());
+    let lang = meta_text(meta, "lang").unwrap_or_else(|| "en".to_string());
+    let identifier = identifier(pandoc, &chapters);
+
+    // Fonts are embedded with a @font-face rule named after the file
+    let mut css = String::from(DEFAULT_CSS);
+    for font in meta_strings(meta, "epub-fonts") {
+        let (Some(media_type), Some(data)) = (font_type(&font), load(&font, resource_dir)) else {
+            continue;
+        };
+        let path = Path::new(&font);
+        let name = path.file_name().and_then(|n| n.to_str()).unwrap_or("font");
+        let family = path.file_stem().and_then(|n| n.to_str()).unwrap_or(name);
+        css.push_str(&format!(
+            "@font-face {{ font-family: \"{}\"; src: url(\"../fonts/{}\"); }}\n",
+            family, name
+        ));
+        resources.add(format!("fonts/{}", name), media_type, data);
+    }
+    for stylesheet in meta_strings(meta, "css") {
+        if let Some(data) = load(&stylesheet, resource_dir) {
+            css.push_str(&String::from_utf8_lossy(&data));
+            css.push('\n');
+        }
+    }
+    if highlighted && let Some(highlight) = &html_config.highlight {
+        css.push_str(&highlight.theme.css());
+    }
+
+    let mut documents: Vec<(String, String)> = Vec::new();
+    let mut items: Vec<(String, &'static str, Option<&'static str>)> = vec![
+        ("toc.ncx".to_string(), "application/x-dtbncx+xml", None),
+        (
+            "nav.xhtml".to_string(),
+            "application/xhtml+xml",
+            Some("nav"),
+        ),
+        ("styles/stylesheet.css".to_string(), "text/css", None),
+    ];
+    let mut spine = Vec::new();
+    let mut landmarks = Vec::new();
+    let stylesheet = "../styles/stylesheet.css";
+
+    let mut cover_id = None;
+    if let Some(cover) = meta_text(meta, "cover-image")
+        && let Some(data) = load(&cover, resource_dir)
+        && let Some((extension, media_type)) = image_type(&data)
+    {
+        let href = format!("media/cover.{}", extension);
+        items.push((href.clone(), media_type, Some("cover-image")));
+        cover_id = Some(item_id(&href));
+        let body = format!(
+            "<section epub:type=\"cover\">\n<img class=\"cover\" src=\"../{}\" alt=\"{}\" />\n</section>\n",
+            href,
+            escape_xml(&title)
+        );
+        resources.add(href, media_type, data);
+        documents.push((
+            "text/cover.xhtml".to_string(),
+            xhtml(&lang, &title, "cover", &body, stylesheet),
+        ));
+        landmarks.push(("cover", "Cover", "text/cover.xhtml".to_string()));
+    }
+
+    let title_page = lookup(meta, "epub-title-page")
+        .and_then(ConfigValue::as_bool)
+        .unwrap_or(true);
+    if title_page && lookup(meta, "title").is_some() {
+        documents.push((
+            "text/title_page.xhtml".to_string(),
+            xhtml(
+                &lang,
+                &title,
+                "frontmatter",
+                &title_page_body(pandoc)?,
+                stylesheet,
+            ),
+        ));
+        landmarks.push((
+            "titlepage",
+            "Title Page",
+            "text/title_page.xhtml".to_string(),
+        ));
+    }
+
+    let toc = toc_entries(&chapters, meta_usize(meta, "toc-depth", 2));
+    let toc_in_spine = lookup(meta, "toc").and_then(ConfigValue::as_bool) == Some(true);
+    if let Some(first) = chapters.first() {
+        landmarks.push(("bodymatter", "Start", format!("text/{}", first.file)));
+    }
+    let toc_title = meta_text(meta, "toc-title").unwrap_or_else(|| "Table of Contents".to_string());
+    landmarks.push(("toc", toc_title.as_str(), "nav.xhtml".to_string()));
+
+    for (href, _) in &documents {
+        spine.push(SpineItem {
+            id: item_id(href),
+            linear: true,
+        });
+    }
+    spine.push(SpineItem {
+        id: item_id("nav.xhtml"),
+        linear: toc_in_spine,
+    });
+    for chapter in &chapters {
+        let body = format!(
+            "<section epub:type=\"chapter\">\n{}</section>\n",
+            chapter.body
+        );
+        let href = format!("text/{}", chapter.file);
+        spine.push(SpineItem {
+            id: item_id(&href),
+            linear: true,
+        });
+        let chapter_title = chapter.title.as_deref().unwrap_or(&title);
+        documents.push((
+            href,
+            xhtml(&lang, chapter_title, "bodymatter", &body, stylesheet),
+        ));
+    }
+    for (href, _) in &documents {
+        items.push((href.clone(), "application/xhtml+xml", None));
+    }
+    for resource in &resources.items {
+        if !items.iter().any(|(href, _, _)| *href == resource.href) {
+            items.push((resource.href.clone(), resource.media_type, None));
+        }
+    }
+
+    let package = Package {
+        identifier: &identifier,
+        title: &title,
+        lang: &lang,
+        modified: config.modified,
+        items,
+        spine,
+        cover_id,
+    };
+    let nav = xhtml(
+        &lang,
+        &toc_title,
+        "frontmatter",
+        &nav_body(&toc, &toc_title, &landmarks),
+        "styles/stylesheet.css",
+    );
+
+    let mut zip = ZipWriter::new(writer);
+    // The mimetype comes first and uncompressed, so readers can recognize
+    // the file from its first bytes
+    zip.add_stored_file("mimetype", b"application/epub+zip")?;
+    zip.add_file("META-INF/container.xml", CONTAINER_XML.as_bytes())?;
+    zip.add_file("EPUB/content.opf", opf(pandoc, &package).as_bytes())?;
+    zip.add_file("EPUB/nav.xhtml", nav.as_bytes())?;
+    zip.add_file("EPUB/toc.ncx", ncx(&identifier, &title, &toc).as_bytes())?;
+    zip.add_file("EPUB/styles/stylesheet.css", css.as_bytes())?;
+    for (href, content) in &documents {
+        zip.add_file(&format!("EPUB/{}", href), content.as_bytes())?;
+    }
+    for resource in &resources.items {
+        zip.add_file(&format!("EPUB/{}", resource.href), &resource.data)?;
+    }
+    zip.finish()?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rewrite_attribute() {
+        let html = "<a href=\"#a\">x</a><a href=\"#b\">y</a>";
+        let rewritten = rewrite_attribute(html, "href", |href| {
+            (href == "#b").then(|| "ch002.xhtml#b".to_string())
+        });
+        assert_eq!(
+            rewritten,
+            "<a href=\"#a\">x</a><a href=\"ch002.xhtml#b\">y</a>"
+        );
+        assert_eq!(attribute_values(&rewritten, "href").len(), 2);
+    }
+
+    #[test]
+    fn test_nest_toc() {
+        let items = vec![
+            (1, "One".to_string(), "a".to_string()),
+            (2, "One.One".to_string(), "b".to_string()),
+            (1, "Two".to_string(), "c".to_string()),
+        ];
+        let toc = nest_toc(&mut items.into_iter().peekable(), 1);
+        assert_eq!(toc.len(), 2);
+        assert_eq!(toc[0].children.len(), 1);
+        assert_eq!(toc[0].children[0].label, "One.One");
+        assert!(toc[1].children.is_empty());
+    }
+
+    #[test]
+    fn test_image_type() {
+        assert_eq!(
+            image_type(b"\x89PNG\r\n\x1a\n....").map(|t| t.0),
+            Some("png")
+        );
+        assert_eq!(
+            image_type(b"<svg xmlns=\"http://www.w3.org/2000/svg\"/>").map(|t| t.1),
+            Some("image/svg+xml")
+        );
+        assert_eq!(image_type(b"plain text"), None);
+    }
+}
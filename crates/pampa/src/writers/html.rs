@@ -3,7 +3,9 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
-use crate::pandoc::{ASTContext, Attr, Block, CitationMode, Inline, Inlines, Pandoc};
+use crate::highlight::{HighlightConfig, Lines};
+use crate::pandoc::{ASTContext, Attr, Block, Blocks, CitationMode, Inline, Inlines, Pandoc};
+use crate::transforms::task_lists;
 use crate::writers::html_source::build_source_map;
 use crate::writers::json::{self, JsonConfig};
 use quarto_pandoc_types::ConfigValue;
@@ -20,6 +22,8 @@ use std::marker::PhantomData;
 pub struct HtmlConfig {
     /// Include source location tracking (data-loc, data-sid attributes)
     pub include_source_locations: bool,
+    /// Highlight code blocks; `None` writes them as plain `<pre><code>`
+    pub highlight: Option<HighlightConfig>,
 }
 
 /// Extract HTML configuration from document metadata.
@@ -32,6 +36,8 @@ pub struct HtmlConfig {
 /// ```
 ///
 /// If `format.html.source-location` is set to "full", enables source location tracking.
+/// Code blocks are highlighted as set by `highlight-style` (see
+/// [`HighlightConfig::from_metadata`]).
 pub fn extract_config_from_metadata(meta: &ConfigValue) -> HtmlConfig {
     let include_source_locations = meta
         .get("format")
@@ -41,6 +47,7 @@ pub fn extract_config_from_metadata(meta: &ConfigValue) -> HtmlConfig {
 
     HtmlConfig {
         include_source_locations,
+        highlight: HighlightConfig::from_metadata(meta),
     }
 }
 
@@ -64,6 +71,24 @@ pub struct ResolvedLocation {
 }
 
 impl ResolvedLocation {
+    /// Resolve a node's source info to 1-based lines and columns, as the
+    /// JSON writer does for its `l` field. `None` for nodes that can't be
+    /// mapped to a file (e.g. synthetic nodes).
+    pub fn resolve(
+        source_info: &quarto_source_map::SourceInfo,
+        context: &ASTContext,
+    ) -> Option<Self> {
+        let (start, end) =
+            source_info.map_range(0, source_info.length(), &context.source_context)?;
+        Some(ResolvedLocation {
+            file_id: start.file_id.0,
+            start_line: start.location.row + 1,
+            start_col: start.location.column + 1,
+            end_line: end.location.row + 1,
+            end_col: end.location.column + 1,
+        })
+    }
+
     /// Format as data-loc attribute value: "file:line:col-line:col"
     pub fn to_data_loc(&self) -> String {
         format!(
@@ -84,6 +109,15 @@ pub struct HtmlWriterContext<'ast, W: Write> {
     source_map: HashMap<*const (), SourceNodeInfo>,
     /// Configuration
     config: HtmlConfig,
+    /// Footnote contents collected while writing, numbered by position
+    notes: Vec<Blocks>,
+    /// Number of highlighted code blocks written, for their `cbN` ids
+    pub(crate) highlighted_blocks: usize,
+    /// Writing slides: `.notes` divs are speaker notes, and `.incremental`
+    /// and `.nonincremental` divs set `fragments`
+    pub(crate) slides: bool,
+    /// Write list items as fragments, shown one at a time
+    pub(crate) fragments: bool,
     /// Lifetime marker
     _phantom: PhantomData<&'ast ()>,
 }
@@ -105,6 +139,10 @@ impl<'ast, W: Write> HtmlWriterContext<'ast, W> {
             writer,
             source_map: HashMap::new(),
             config: HtmlConfig::default(),
+            notes: Vec::new(),
+            highlighted_blocks: 0,
+            slides: false,
+            fragments: false,
             _phantom: PhantomData,
         }
     }
@@ -115,6 +153,10 @@ impl<'ast, W: Write> HtmlWriterContext<'ast, W> {
             writer,
             source_map: HashMap::new(),
             config,
+            notes: Vec::new(),
+            highlighted_blocks: 0,
+            slides: false,
+            fragments: false,
             _phantom: PhantomData,
         }
     }
@@ -436,7 +478,10 @@ fn escape_html(s: &str) -> String {
 ///
 /// Standard HTML5 attributes (style, title, dir, lang, etc.) and ARIA attributes
 /// are written as-is. Custom attributes are prefixed with `data-`.
-fn write_attr<W: Write>(attr: &Attr, ctx: &mut HtmlWriterContext<'_, W>) -> std::io::Result<()> {
+pub(crate) fn write_attr<W: Write>(
+    attr: &Attr,
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
     let (id, classes, attrs) = attr;
 
     if !id.is_empty() {
@@ -647,11 +692,32 @@ fn write_inline<W: Write>(
             write!(ctx, " />")?;
         }
         Inline::RawInline(raw) => {
-            // Only output raw HTML if format is "html"
-            if raw.format == "html" {
+            // Only output raw HTML if format is "html" (or "revealjs" in slides)
+            if raw.format == "html" || (ctx.slides && raw.format == "revealjs") {
                 write!(ctx, "{}", raw.text)?;
             }
         }
+        Inline::Span(span)
+            if span
+                .attr
+                .1
+                .iter()
+                .any(|c| c == crate::transforms::marks::MARK_CLASS) =>
+        {
+            // As Pandoc writes highlights: a mark element, without the class
+            let (id, classes, keyvals) = &span.attr;
+            let classes = classes
+                .iter()
+                .filter(|c| *c != crate::transforms::marks::MARK_CLASS)
+                .cloned()
+                .collect();
+            write!(ctx, "<mark")?;
+            write_attr(&(id.clone(), classes, keyvals.clone()), ctx)?;
+            write_inline_source_attrs(inline, ctx)?;
+            write!(ctx, ">")?;
+            write_inlines(&span.content, ctx)?;
+            write!(ctx, "</mark>")?;
+        }
         Inline::Span(span) => {
             write!(ctx, "<span")?;
             write_attr(&span.attr, ctx)?;
@@ -661,16 +727,14 @@ fn write_inline<W: Write>(
             write!(ctx, "</span>")?;
         }
         Inline::Note(note) => {
-            // Footnotes are rendered as superscript with a link
+            // The note body is collected and written by write_footnotes at the
+            // end of the document; only the numbered reference goes here.
+            ctx.notes.push(note.content.clone());
+            let n = ctx.notes.len();
             write!(
                 ctx,
-                "<sup class=\"footnote-ref\"><a href=\"#fn{}\">",
-                note.content.len()
+                "<a href=\"#fn{n}\" class=\"footnote-ref\" id=\"fnref{n}\" role=\"doc-noteref\"><sup>{n}</sup></a>"
             )?;
-            write!(ctx, "[{}]", note.content.len())?;
-            write!(ctx, "</a></sup>")?;
-            // Note: Proper footnote handling would require collecting all footnotes
-            // and rendering them at the end of the document
         }
         Inline::Cite(cite) => {
             // Collect all citation IDs for data-cites attribute
@@ -746,15 +810,23 @@ fn write_inline<W: Write>(
             write_inlines(&c.content, ctx)?;
             write!(ctx, "</span>")?;
         }
-        Inline::Custom(_) => {
-            // Custom inline nodes are not rendered in HTML output
+        Inline::Custom(custom) => {
+            // Annotations stay comments, as other HTML comments do, and
+            // inline cells show their output, or their code until they are
+            // evaluated; other custom inline nodes are not rendered in HTML
+            // output
+            if let Some(annotation) = crate::pandoc::Annotation::from_custom(custom) {
+                write!(ctx, "{}", annotation.to_comment())?;
+            } else if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                write_inlines(&cell.rendered(custom), ctx)?;
+            }
         }
     }
     Ok(())
 }
 
 /// Write a sequence of inlines
-fn write_inlines<W: Write>(
+pub(crate) fn write_inlines<W: Write>(
     inlines: &Inlines,
     ctx: &mut HtmlWriterContext<'_, W>,
 ) -> std::io::Result<()> {
@@ -818,6 +890,18 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             writeln!(ctx, "</div>")?;
         }
         Block::CodeBlock(codeblock) => {
+            let highlighted = ctx.config.highlight.as_ref().and_then(|highlight| {
+                highlight.highlight_code_block(&codeblock.attr, &codeblock.text)
+            });
+            if let Some((language, lines)) = highlighted {
+                return write_highlighted_code_block(
+                    block,
+                    &codeblock.attr,
+                    &language,
+                    &lines,
+                    ctx,
+                );
+            }
             write!(ctx, "<pre")?;
             write_attr(&codeblock.attr, ctx)?;
             write_block_source_attrs(block, ctx)?;
@@ -826,8 +910,8 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             writeln!(ctx, "</code></pre>")?;
         }
         Block::RawBlock(raw) => {
-            // Only output raw HTML if format is "html"
-            if raw.format == "html" {
+            // Only output raw HTML if format is "html" (or "revealjs" in slides)
+            if raw.format == "html" || (ctx.slides && raw.format == "revealjs") {
                 writeln!(ctx, "{}", raw.text)?;
             }
         }
@@ -857,20 +941,24 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             write_block_source_attrs(block, ctx)?;
             writeln!(ctx, ">")?;
             for item in &list.content {
-                write!(ctx, "<li>")?;
-                write_blocks_inline(item, ctx)?;
-                writeln!(ctx, "</li>")?;
+                write_list_item(item, ctx)?;
             }
             writeln!(ctx, "</ol>")?;
         }
         Block::BulletList(list) => {
             write!(ctx, "<ul")?;
+            if !list.content.is_empty()
+                && list
+                    .content
+                    .iter()
+                    .all(|item| task_lists::task_state(item).is_some())
+            {
+                write!(ctx, " class=\"task-list\"")?;
+            }
             write_block_source_attrs(block, ctx)?;
             writeln!(ctx, ">")?;
             for item in &list.content {
-                write!(ctx, "<li>")?;
-                write_blocks_inline(item, ctx)?;
-                writeln!(ctx, "</li>")?;
+                write_list_item(item, ctx)?;
             }
             writeln!(ctx, "</ul>")?;
         }
@@ -921,14 +1009,8 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             // Column group (for alignment)
             if !table.colspec.is_empty() {
                 writeln!(ctx, "<colgroup>")?;
-                for colspec in &table.colspec {
-                    let align = match colspec.0 {
-                        crate::pandoc::table::Alignment::Left => " align=\"left\"",
-                        crate::pandoc::table::Alignment::Right => " align=\"right\"",
-                        crate::pandoc::table::Alignment::Center => " align=\"center\"",
-                        crate::pandoc::table::Alignment::Default => "",
-                    };
-                    writeln!(ctx, "<col{} />", align)?;
+                for _ in &table.colspec {
+                    writeln!(ctx, "<col />")?;
                 }
                 writeln!(ctx, "</colgroup>")?;
             }
@@ -937,7 +1019,7 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             if !table.head.rows.is_empty() {
                 writeln!(ctx, "<thead>")?;
                 for row in &table.head.rows {
-                    write_table_row(row, ctx, true)?;
+                    write_table_row(row, &table.colspec, ctx, true)?;
                 }
                 writeln!(ctx, "</thead>")?;
             }
@@ -946,7 +1028,7 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             for body in &table.bodies {
                 writeln!(ctx, "<tbody>")?;
                 for row in &body.body {
-                    write_table_row(row, ctx, false)?;
+                    write_table_row(row, &table.colspec, ctx, false)?;
                 }
                 writeln!(ctx, "</tbody>")?;
             }
@@ -955,7 +1037,7 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             if !table.foot.rows.is_empty() {
                 writeln!(ctx, "<tfoot>")?;
                 for row in &table.foot.rows {
-                    write_table_row(row, ctx, false)?;
+                    write_table_row(row, &table.colspec, ctx, false)?;
                 }
                 writeln!(ctx, "</tfoot>")?;
             }
@@ -977,6 +1059,24 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
             }
             writeln!(ctx, "</figure>")?;
         }
+        Block::Div(div) if ctx.slides && div.attr.1.iter().any(|c| c == "notes") => {
+            writeln!(ctx, "<aside class=\"notes\">")?;
+            write_blocks(&div.content, ctx)?;
+            writeln!(ctx, "</aside>")?;
+        }
+        Block::Div(div)
+            if ctx.slides
+                && div
+                    .attr
+                    .1
+                    .iter()
+                    .any(|c| c == "incremental" || c == "nonincremental") =>
+        {
+            let fragments = ctx.fragments;
+            ctx.fragments = div.attr.1.iter().any(|c| c == "incremental");
+            write_blocks(&div.content, ctx)?;
+            ctx.fragments = fragments;
+        }
         Block::Div(div) => {
             // Use <section> tag for Divs with "section" class (from sectionize transform)
             let tag = if div.attr.1.contains(&"section".to_string()) {
@@ -1025,13 +1125,21 @@ fn write_block<W: Write>(block: &Block, ctx: &mut HtmlWriterContext<'_, W>) -> s
     Ok(())
 }
 
-/// Write a table row
+/// Write a table row.
+///
+/// Alignment is emitted as an inline `text-align` style, since the HTML5
+/// `align` attribute is obsolete. Cells with default alignment inherit the
+/// alignment of the column they start in.
 fn write_table_row<W: Write>(
     row: &crate::pandoc::table::Row,
+    colspecs: &[crate::pandoc::table::ColSpec],
     ctx: &mut HtmlWriterContext<'_, W>,
     is_header: bool,
 ) -> std::io::Result<()> {
+    use crate::pandoc::table::Alignment;
+
     writeln!(ctx, "<tr>")?;
+    let mut col = 0;
     for cell in &row.cells {
         let tag = if is_header { "th" } else { "td" };
         write!(ctx, "<{}", tag)?;
@@ -1044,13 +1152,25 @@ fn write_table_row<W: Write>(
             write!(ctx, " colspan=\"{}\"", cell.col_span)?;
         }
 
-        let align = match cell.alignment {
-            crate::pandoc::table::Alignment::Left => " align=\"left\"",
-            crate::pandoc::table::Alignment::Right => " align=\"right\"",
-            crate::pandoc::table::Alignment::Center => " align=\"center\"",
-            crate::pandoc::table::Alignment::Default => "",
+        let alignment = match cell.alignment {
+            Alignment::Default => colspecs
+                .get(col)
+                .map_or(&Alignment::Default, |colspec| &colspec.0),
+            ref alignment => alignment,
+        };
+        let style = match alignment {
+            Alignment::Left => " style=\"text-align: left;\"",
+            Alignment::Right => " style=\"text-align: right;\"",
+            Alignment::Center => " style=\"text-align: center;\"",
+            Alignment::Default => "",
         };
-        write!(ctx, "{}>", align)?;
+        write!(ctx, "{}", style)?;
+        // A cell of plain text has no element of its own for its location
+        if let [plain @ Block::Plain(_)] = cell.content.as_slice() {
+            write_block_source_attrs(plain, ctx)?;
+        }
+        write!(ctx, ">")?;
+        col += cell.col_span.max(1);
 
         write_blocks(&cell.content, ctx)?;
         writeln!(ctx, "</{}>", tag)?;
@@ -1060,7 +1180,7 @@ fn write_table_row<W: Write>(
 }
 
 /// Write a sequence of blocks (internal, uses context)
-fn write_blocks<W: Write>(
+pub(crate) fn write_blocks<W: Write>(
     blocks: &[Block],
     ctx: &mut HtmlWriterContext<'_, W>,
 ) -> std::io::Result<()> {
@@ -1070,6 +1190,61 @@ fn write_blocks<W: Write>(
     Ok(())
 }
 
+/// Write a list item. An item written inline carries the location of its
+/// paragraph, which has no element of its own.
+fn write_list_item<W: Write>(
+    item: &[Block],
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
+    write!(ctx, "<li")?;
+    if ctx.fragments {
+        write!(ctx, " class=\"fragment\"")?;
+    }
+    if let [block @ (Block::Paragraph(_) | Block::Plain(_))] = item {
+        write_block_source_attrs(block, ctx)?;
+    }
+    write!(ctx, ">")?;
+    match task_lists::task_item_content(item) {
+        Some((checked, content)) => write_task_item(checked, &content, ctx)?,
+        None => write_blocks_inline(item, ctx)?,
+    }
+    writeln!(ctx, "</li>")
+}
+
+/// Write the content of a task list item, as Pandoc does: its text in a
+/// label with a checkbox.
+fn write_task_item<W: Write>(
+    checked: bool,
+    content: &[Block],
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
+    let (text, paragraph) = match content.first() {
+        Some(Block::Plain(plain)) => (&plain.content, false),
+        Some(Block::Paragraph(para)) => (&para.content, true),
+        _ => return write_blocks_inline(content, ctx),
+    };
+    if paragraph {
+        write!(ctx, "<p>")?;
+    }
+    write!(ctx, "<label><input type=\"checkbox\"")?;
+    if checked {
+        write!(ctx, " checked=\"\"")?;
+    }
+    write!(ctx, " />")?;
+    write_inlines(text, ctx)?;
+    write!(ctx, "</label>")?;
+    if paragraph {
+        writeln!(ctx, "</p>")?;
+    }
+    if content.len() > 1 {
+        if !paragraph {
+            writeln!(ctx)?;
+        }
+        write_blocks(&content[1..], ctx)?;
+    }
+    Ok(())
+}
+
 /// Write blocks inline (for list items) - strips paragraph tags for simple cases
 fn write_blocks_inline<W: Write>(
     blocks: &[Block],
@@ -1091,6 +1266,105 @@ fn write_blocks_inline<W: Write>(
     Ok(())
 }
 
+/// Write the footnotes collected from `Inline::Note`s as an end-of-document
+/// section, in the same shape pandoc's HTML5 writer uses. Notes nested inside
+/// other notes are appended to the list as they are encountered.
+pub(crate) fn write_footnotes<W: Write>(ctx: &mut HtmlWriterContext<'_, W>) -> std::io::Result<()> {
+    if ctx.notes.is_empty() {
+        return Ok(());
+    }
+    writeln!(
+        ctx,
+        "<section id=\"footnotes\" class=\"footnotes footnotes-end-of-document\" role=\"doc-endnotes\">"
+    )?;
+    writeln!(ctx, "<hr />")?;
+    writeln!(ctx, "<ol>")?;
+    let mut i = 0;
+    while i < ctx.notes.len() {
+        let n = i + 1;
+        let mut content = ctx.notes[i].clone();
+        let backlink = Inline::RawInline(crate::pandoc::inline::RawInline {
+            format: "html".to_string(),
+            text: format!(
+                "<a href=\"#fnref{n}\" class=\"footnote-back\" role=\"doc-backlink\">↩︎</a>"
+            ),
+            source_info: quarto_source_map::SourceInfo::default(),
+        });
+        match content.last_mut() {
+            Some(Block::Paragraph(para)) => para.content.push(backlink),
+            Some(Block::Plain(plain)) => plain.content.push(backlink),
+            _ => content.push(Block::Plain(crate::pandoc::block::Plain {
+                content: vec![backlink],
+                source_info: quarto_source_map::SourceInfo::default(),
+            })),
+        }
+        writeln!(ctx, "<li id=\"fn{n}\">")?;
+        write_blocks(&content, ctx)?;
+        writeln!(ctx, "</li>")?;
+        i += 1;
+    }
+    writeln!(ctx, "</ol>")?;
+    writeln!(ctx, "</section>")?;
+    Ok(())
+}
+
+/// Write a highlighted code block the way Pandoc does: a `sourceCode` div
+/// holding the `pre`, with one span per line and token spans inside.
+fn write_highlighted_code_block<W: Write>(
+    block: &Block,
+    attr: &Attr,
+    language: &str,
+    lines: &Lines,
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
+    let (id, classes, attrs) = attr;
+    ctx.highlighted_blocks += 1;
+    let id = if id.is_empty() {
+        format!("cb{}", ctx.highlighted_blocks)
+    } else {
+        id.clone()
+    };
+
+    let mut pre_classes = vec!["sourceCode".to_string(), language.to_string()];
+    pre_classes.extend(classes.iter().skip(1).cloned());
+    let pre_attr: Attr = (String::new(), pre_classes, attrs.clone());
+
+    write!(ctx, "<div class=\"sourceCode\" id=\"{}\"", escape_html(&id))?;
+    write_block_source_attrs(block, ctx)?;
+    write!(ctx, "><pre")?;
+    write_attr(&pre_attr, ctx)?;
+    write!(
+        ctx,
+        "><code class=\"sourceCode {}\">",
+        escape_html(language)
+    )?;
+    for (i, line) in lines.iter().enumerate() {
+        if i > 0 {
+            writeln!(ctx)?;
+        }
+        let line_id = format!("{}-{}", escape_html(&id), i + 1);
+        write!(
+            ctx,
+            "<span id=\"{}\"><a href=\"#{}\" aria-hidden=\"true\" tabindex=\"-1\"></a>",
+            line_id, line_id
+        )?;
+        for token in line {
+            match token.kind.class() {
+                Some(class) => write!(
+                    ctx,
+                    "<span class=\"{}\">{}</span>",
+                    class,
+                    escape_html(&token.text)
+                )?,
+                None => write!(ctx, "{}", escape_html(&token.text))?,
+            }
+        }
+        write!(ctx, "</span>")?;
+    }
+    writeln!(ctx, "</code></pre></div>")?;
+    Ok(())
+}
+
 // =============================================================================
 // Public API
 // =============================================================================
@@ -1103,6 +1377,7 @@ pub fn write_with_config<W: Write>(
 ) -> std::io::Result<()> {
     let mut ctx = HtmlWriterContext::with_config(writer, config);
     write_blocks(&pandoc.blocks, &mut ctx)?;
+    write_footnotes(&mut ctx)?;
     Ok(())
 }
 
@@ -1129,12 +1404,14 @@ pub fn write_with_source_tracking<W: Write>(
 ) -> std::io::Result<()> {
     let config = HtmlConfig {
         include_source_locations: true,
+        highlight: HighlightConfig::from_metadata(&pandoc.meta),
     };
     let mut ctx = HtmlWriterContext::with_config(writer, config);
 
     // Generate JSON with source locations enabled
     let json_config = JsonConfig {
         include_inline_locations: true,
+        include_block_ids: false,
     };
 
     match json::write_pandoc(pandoc, ast_context, &json_config) {
@@ -1152,6 +1429,7 @@ pub fn write_with_source_tracking<W: Write>(
     }
 
     write_blocks(&pandoc.blocks, &mut ctx)?;
+    write_footnotes(&mut ctx)?;
     Ok(())
 }
 
@@ -1192,11 +1470,26 @@ pub fn write<W: Write>(
     }
 }
 
-/// Public wrapper to write blocks (for external callers)
+/// Public wrapper to write blocks (for external callers).
+///
+/// Any footnotes in `blocks` are written as a section after them.
 pub fn write_blocks_to<W: Write>(blocks: &[Block], writer: W) -> std::io::Result<()> {
-    let mut ctx = HtmlWriterContext::new(writer);
+    write_blocks_with_config(blocks, writer, HtmlConfig::default()).map(|_| ())
+}
+
+/// Write blocks with configuration, followed by their footnotes.
+///
+/// Returns whether any code block was highlighted, so that a template can
+/// include the highlighting CSS only when it is needed.
+pub fn write_blocks_with_config<W: Write>(
+    blocks: &[Block],
+    writer: W,
+    config: HtmlConfig,
+) -> std::io::Result<bool> {
+    let mut ctx = HtmlWriterContext::with_config(writer, config);
     write_blocks(blocks, &mut ctx)?;
-    Ok(())
+    write_footnotes(&mut ctx)?;
+    Ok(ctx.highlighted_blocks > 0)
 }
 
 /// Public wrapper to write inlines (for external callers)
@@ -1326,6 +1619,7 @@ mod tests {
     fn test_html_writer_context_include_source_locations() {
         let config = HtmlConfig {
             include_source_locations: true,
+            ..Default::default()
         };
         let ctx: HtmlWriterContext<'_, Vec<u8>> =
             HtmlWriterContext::with_config(Vec::new(), config);
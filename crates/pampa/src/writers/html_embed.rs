@@ -0,0 +1,659 @@
+/*
+ * html_embed.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Self-contained HTML, as Pandoc's `--embed-resources` makes it.
+//!
+//! [`embed_resources`] goes over written HTML and puts what it refers to
+//! by a local URL into the file itself:
+//!
+//! - images, media, icons and fonts become `data:` URIs
+//! - `<link rel="stylesheet">` becomes a `<style>` element, with the
+//!   `url()`s and `@import`s of the stylesheet embedded in turn
+//! - `<script src>` becomes a script with the file as its content
+//!
+//! URLs with a scheme (`https:`, `data:`, ...) are left as they are:
+//! nothing is fetched over the network. What a URL points to is up to the
+//! caller's loader; URLs in stylesheets are given to it resolved against
+//! the stylesheet's URL.
+
+use crate::utils::mime::guess_mime_type;
+use base64::Engine;
+
+/// How deep stylesheets may `@import` each other
+const MAX_IMPORT_DEPTH: usize = 8;
+
+/// HTML with its resources embedded
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct EmbeddedHtml {
+    pub html: String,
+    /// The local URLs the loader had nothing for, which are left as they
+    /// were, in order of appearance and without duplicates
+    pub missing: Vec<String>,
+}
+
+/// Embed the resources that `html` refers to by local URLs. `load` gives
+/// the content of a URL's path (without its query or fragment), or `None`
+/// when there is none.
+pub fn embed_resources(html: &str, load: &mut dyn FnMut(&str) -> Option<Vec<u8>>) -> EmbeddedHtml {
+    let mut embedder = Embedder {
+        load,
+        missing: Vec::new(),
+    };
+    let html = embedder.html(html);
+    EmbeddedHtml {
+        html,
+        missing: embedder.missing,
+    }
+}
+
+struct Embedder<'a> {
+    load: &'a mut dyn FnMut(&str) -> Option<Vec<u8>>,
+    missing: Vec<String>,
+}
+
+impl Embedder<'_> {
+    fn html(&mut self, html: &str) -> String {
+        let mut out = String::with_capacity(html.len());
+        let mut rest = html;
+        while let Some(start) = rest.find('<') {
+            out.push_str(&rest[..start]);
+            rest = &rest[start..];
+            if rest.starts_with("<!--") {
+                let end = rest.find("-->").map_or(rest.len(), |i| i + 3);
+                out.push_str(&rest[..end]);
+                rest = &rest[end..];
+                continue;
+            }
+            let Some(tag) = Tag::parse(rest) else {
+                out.push('<');
+                rest = &rest[1..];
+                continue;
+            };
+            let (source, after) = rest.split_at(tag.len);
+            rest = match tag.name.as_str() {
+                "script" => self.script(&tag, source, after, &mut out),
+                "style" => {
+                    let (css, after) = raw_text(after, "style");
+                    out.push_str(source);
+                    out.push_str(&self.css(css, "", 0));
+                    out.push_str("</style>");
+                    after
+                }
+                "link" => {
+                    out.push_str(&self.link(tag, source));
+                    after
+                }
+                _ => {
+                    out.push_str(&self.element(tag, source));
+                    after
+                }
+            };
+        }
+        out.push_str(rest);
+        out
+    }
+
+    /// A `<script>` element, whose content is copied as it is: a `<` in a
+    /// script isn't the start of a tag.
+    fn script<'h>(&mut self, tag: &Tag, source: &str, after: &'h str, out: &mut String) -> &'h str {
+        let (content, after) = raw_text(after, "script");
+        let embedded = tag
+            .attribute("src")
+            .filter(|src| is_local(src))
+            .and_then(|src| self.fetch(src));
+        match embedded {
+            Some(script) => {
+                let mut tag = tag.clone();
+                tag.remove("src");
+                out.push_str(&tag.render());
+                out.push_str(&escape_end_tag(&String::from_utf8_lossy(&script), "script"));
+            }
+            None => {
+                out.push_str(source);
+                out.push_str(content);
+            }
+        }
+        out.push_str("</script>");
+        after
+    }
+
+    fn link(&mut self, mut tag: Tag, source: &str) -> String {
+        let Some(href) = tag.attribute("href").filter(|href| is_local(href)) else {
+            return source.to_string();
+        };
+        let href = href.to_string();
+        let rel = tag.attribute("rel").unwrap_or("").to_ascii_lowercase();
+        if rel.split_whitespace().any(|r| r == "stylesheet") {
+            let Some(css) = self.fetch(&href) else {
+                return source.to_string();
+            };
+            let css = self.css(&String::from_utf8_lossy(&css), &href, 0);
+            let media = tag
+                .attribute("media")
+                .map(|media| format!(" media=\"{}\"", escape_attribute(media)))
+                .unwrap_or_default();
+            return format!("<style{}>{}</style>", media, escape_end_tag(&css, "style"));
+        }
+        if rel
+            .split_whitespace()
+            .any(|r| matches!(r, "icon" | "apple-touch-icon" | "preload" | "modulepreload"))
+        {
+            if let Some(uri) = self.data_uri(&href) {
+                tag.set("href", uri);
+                return tag.render();
+            }
+        }
+        source.to_string()
+    }
+
+    /// Any other element: its `src`, `poster`, `data` (of `<object>`) and
+    /// `style` attributes
+    fn element(&mut self, mut tag: Tag, source: &str) -> String {
+        let mut changed = false;
+        for name in ["src", "poster", "data"] {
+            if tag.name == "iframe" || (name == "data" && tag.name != "object") {
+                continue;
+            }
+            let Some(url) = tag.attribute(name).filter(|url| is_local(url)) else {
+                continue;
+            };
+            let url = url.to_string();
+            if let Some(uri) = self.data_uri(&url) {
+                tag.set(name, uri);
+                changed = true;
+            }
+        }
+        if let Some(style) = tag.attribute("style").filter(|s| s.contains("url(")) {
+            let style = self.css(&style.to_string(), "", MAX_IMPORT_DEPTH);
+            tag.set("style", style);
+            changed = true;
+        }
+        if changed {
+            tag.render()
+        } else {
+            source.to_string()
+        }
+    }
+
+    /// `css` with its `@import`s inlined and its `url()`s made `data:`
+    /// URIs. The URLs in it are relative to `base`.
+    fn css(&mut self, css: &str, base: &str, depth: usize) -> String {
+        let mut out = String::with_capacity(css.len());
+        let mut rest = css;
+        loop {
+            let next_url = rest.find("url(");
+            let next_import = rest.find("@import");
+            let (start, is_import) = match (next_url, next_import) {
+                (None, None) => break,
+                (Some(u), Some(i)) if i < u => (i, true),
+                (None, Some(i)) => (i, true),
+                (Some(u), _) => (u, false),
+            };
+            out.push_str(&rest[..start]);
+            rest = &rest[start..];
+            if is_import {
+                match self.import(rest, base, depth) {
+                    Some((inlined, len)) => {
+                        out.push_str(&inlined);
+                        rest = &rest[len..];
+                    }
+                    None => {
+                        out.push_str("@import");
+                        rest = &rest["@import".len()..];
+                    }
+                }
+                continue;
+            }
+            let Some((url, len)) = css_url(rest) else {
+                out.push_str("url(");
+                rest = &rest["url(".len()..];
+                continue;
+            };
+            match Some(url)
+                .filter(|url| is_local(url))
+                .and_then(|url| self.data_uri(&join_url(base, url)))
+            {
+                Some(uri) => out.push_str(&format!("url(\"{}\")", uri)),
+                None => out.push_str(&rest[..len]),
+            }
+            rest = &rest[len..];
+        }
+        out.push_str(rest);
+        out
+    }
+
+    /// The stylesheet an `@import` at the start of `css` brings in, and
+    /// the length of the rule
+    fn import(&mut self, css: &str, base: &str, depth: usize) -> Option<(String, usize)> {
+        if depth >= MAX_IMPORT_DEPTH {
+            return None;
+        }
+        let after_keyword = &css["@import".len()..];
+        let trimmed = after_keyword.trim_start();
+        let offset = css.len() - trimmed.len();
+        let (url, url_len) = css_url(trimmed).or_else(|| css_string(trimmed))?;
+        if !is_local(url) {
+            return None;
+        }
+        let end = trimmed[url_len..].find(';')? + url_len;
+        let media = trimmed[url_len..end].trim();
+        let url = join_url(base, url);
+        let imported = self.fetch(&url)?;
+        let imported = self.css(&String::from_utf8_lossy(&imported), &url, depth + 1);
+        let inlined = if media.is_empty() {
+            imported
+        } else {
+            format!("@media {} {{\n{}\n}}", media, imported)
+        };
+        Some((inlined, offset + end + 1))
+    }
+
+    fn data_uri(&mut self, url: &str) -> Option<String> {
+        let content = self.fetch(url)?;
+        let path = url_path(url);
+        Some(format!(
+            "data:{};base64,{}",
+            guess_mime_type(&path),
+            base64::engine::general_purpose::STANDARD.encode(content)
+        ))
+    }
+
+    fn fetch(&mut self, url: &str) -> Option<Vec<u8>> {
+        let path = url_path(url);
+        let content = (self.load)(&path);
+        if content.is_none() && !self.missing.iter().any(|m| m == url) {
+            self.missing.push(url.to_string());
+        }
+        content
+    }
+}
+
+/// A start tag
+#[derive(Debug, Clone)]
+struct Tag {
+    /// Lowercased
+    name: String,
+    /// Names (lowercased) and values, with entities of the values decoded
+    attributes: Vec<(String, Option<String>)>,
+    self_closing: bool,
+    /// The length of the tag in the source
+    len: usize,
+}
+
+impl Tag {
+    /// The start tag at the start of `source`, which starts with `<`
+    fn parse(source: &str) -> Option<Tag> {
+        let bytes = source.as_bytes();
+        let mut i = 1;
+        let name_end = source[i..]
+            .find(|c: char| c.is_ascii_whitespace() || c == '>' || c == '/')
+            .map_or(source.len(), |n| n + i);
+        let name = &source[i..name_end];
+        if name.is_empty() || !name.starts_with(|c: char| c.is_ascii_alphabetic()) {
+            return None;
+        }
+        i = name_end;
+        let mut attributes = Vec::new();
+        loop {
+            while i < bytes.len() && bytes[i].is_ascii_whitespace() {
+                i += 1;
+            }
+            match bytes.get(i)? {
+                b'>' => {
+                    return Some(Tag {
+                        name: name.to_ascii_lowercase(),
+                        attributes,
+                        self_closing: false,
+                        len: i + 1,
+                    });
+                }
+                b'/' if bytes.get(i + 1) == Some(&b'>') => {
+                    return Some(Tag {
+                        name: name.to_ascii_lowercase(),
+                        attributes,
+                        self_closing: true,
+                        len: i + 2,
+                    });
+                }
+                b'/' => {
+                    i += 1;
+                    continue;
+                }
+                _ => {}
+            }
+            let attr_end = source[i..]
+                .find(|c: char| c.is_ascii_whitespace() || matches!(c, '=' | '>' | '/'))
+                .map_or(source.len(), |n| n + i);
+            let attr_name = source[i..attr_end].to_ascii_lowercase();
+            i = attr_end;
+            while i < bytes.len() && bytes[i].is_ascii_whitespace() {
+                i += 1;
+            }
+            if bytes.get(i) != Some(&b'=') {
+                attributes.push((attr_name, None));
+                continue;
+            }
+            i += 1;
+            while i < bytes.len() && bytes[i].is_ascii_whitespace() {
+                i += 1;
+            }
+            let value = match bytes.get(i)? {
+                quote @ (b'"' | b'\'') => {
+                    let close = source[i + 1..].find(*quote as char)? + i + 1;
+                    let value = &source[i + 1..close];
+                    i = close + 1;
+                    value
+                }
+                _ => {
+                    let end = source[i..]
+                        .find(|c: char| c.is_ascii_whitespace() || c == '>')
+                        .map_or(source.len(), |n| n + i);
+                    let value = &source[i..end];
+                    i = end;
+                    value
+                }
+            };
+            attributes.push((attr_name, Some(unescape_attribute(value))));
+        }
+    }
+
+    fn attribute(&self, name: &str) -> Option<&str> {
+        self.attributes
+            .iter()
+            .find(|(n, _)| n == name)
+            .and_then(|(_, v)| v.as_deref())
+    }
+
+    fn set(&mut self, name: &str, value: String) {
+        if let Some(attribute) = self.attributes.iter_mut().find(|(n, _)| n == name) {
+            attribute.1 = Some(value);
+        }
+    }
+
+    fn remove(&mut self, name: &str) {
+        self.attributes.retain(|(n, _)| n != name);
+    }
+
+    fn render(&self) -> String {
+        let mut out = format!("<{}", self.name);
+        for (name, value) in &self.attributes {
+            out.push(' ');
+            out.push_str(name);
+            if let Some(value) = value {
+                out.push_str("=\"");
+                out.push_str(&escape_attribute(value));
+                out.push('"');
+            }
+        }
+        out.push_str(if self.self_closing { " />" } else { ">" });
+        out
+    }
+}
+
+/// The content of a raw text element (`<script>`, `<style>`) that starts
+/// `html`, and what comes after its end tag
+fn raw_text<'h>(html: &'h str, name: &str) -> (&'h str, &'h str) {
+    let end_tag = format!("</{}", name);
+    match find_ignore_case(html, &end_tag) {
+        Some(start) => {
+            let close = html[start..]
+                .find('>')
+                .map_or(html.len(), |i| start + i + 1);
+            (&html[..start], &html[close..])
+        }
+        None => (html, ""),
+    }
+}
+
+fn find_ignore_case(haystack: &str, needle: &str) -> Option<usize> {
+    haystack
+        .as_bytes()
+        .windows(needle.len())
+        .position(|window| window.eq_ignore_ascii_case(needle.as_bytes()))
+}
+
+/// `content` with `</name` written so it doesn't end the element it is
+/// put in
+fn escape_end_tag(content: &str, name: &str) -> String {
+    let end_tag = format!("</{}", name);
+    let mut out = String::with_capacity(content.len());
+    let mut rest = content;
+    while let Some(i) = find_ignore_case(rest, &end_tag) {
+        out.push_str(&rest[..i]);
+        out.push_str("<\\/");
+        rest = &rest[i + 2..];
+    }
+    out.push_str(rest);
+    out
+}
+
+/// The URL of a `url(...)` at the start of `css`, and its length
+fn css_url(css: &str) -> Option<(&str, usize)> {
+    let inner = css.strip_prefix("url(")?;
+    let close = inner.find(')')?;
+    let url = inner[..close].trim();
+    let url = url
+        .strip_prefix('"')
+        .and_then(|u| u.strip_suffix('"'))
+        .or_else(|| url.strip_prefix('\'').and_then(|u| u.strip_suffix('\'')))
+        .unwrap_or(url);
+    Some((url, "url(".len() + close + 1))
+}
+
+/// The content of a quoted string at the start of `css`, and its length
+fn css_string(css: &str) -> Option<(&str, usize)> {
+    let quote = css.chars().next().filter(|c| matches!(c, '"' | '\''))?;
+    let close = css[1..].find(quote)? + 1;
+    Some((&css[1..close], close + 1))
+}
+
+/// Whether `url` refers to a file next to the document: not one with a
+/// scheme, not a protocol-relative one, and not a fragment of the page
+fn is_local(url: &str) -> bool {
+    if url.is_empty() || url.starts_with('#') || url.starts_with("//") {
+        return false;
+    }
+    let scheme_end = url.find(':');
+    let path_start = url.find(['/', '?', '#']).unwrap_or(url.len());
+    match scheme_end {
+        Some(colon) if colon < path_start => {
+            // A Windows drive letter isn't a scheme
+            colon == 1 && url.as_bytes()[0].is_ascii_alphabetic()
+        }
+        _ => true,
+    }
+}
+
+/// `url` without its query and fragment, percent-decoded
+fn url_path(url: &str) -> String {
+    let end = url.find(['?', '#']).unwrap_or(url.len());
+    percent_decode(&url[..end])
+}
+
+fn percent_decode(s: &str) -> String {
+    let bytes = s.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%' {
+            if let Some(byte) = s
+                .get(i + 1..i + 3)
+                .and_then(|hex| u8::from_str_radix(hex, 16).ok())
+            {
+                out.push(byte);
+                i += 3;
+                continue;
+            }
+        }
+        out.push(bytes[i]);
+        i += 1;
+    }
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+/// `url` resolved against `base`, the URL it appears in. Both are local;
+/// `.` and `..` are removed where they can be.
+fn join_url(base: &str, url: &str) -> String {
+    if url.starts_with('/') {
+        return url.to_string();
+    }
+    let dir = base.rfind('/').map_or("", |i| &base[..=i]);
+    let mut segments: Vec<&str> = Vec::new();
+    let joined = format!("{}{}", dir, url);
+    let (path, suffix) = joined.split_at(joined.find(['?', '#']).unwrap_or(joined.len()));
+    for segment in path.split('/') {
+        match segment {
+            "." => {}
+            ".." if segments.last().is_some_and(|s| *s != "..") => {
+                segments.pop();
+            }
+            _ => segments.push(segment),
+        }
+    }
+    format!("{}{}", segments.join("/"), suffix)
+}
+
+fn unescape_attribute(value: &str) -> String {
+    if !value.contains('&') {
+        return value.to_string();
+    }
+    value
+        .replace("&quot;", "\"")
+        .replace("&#39;", "'")
+        .replace("&apos;", "'")
+        .replace("&lt;", "<")
+        .replace("&gt;", ">")
+        .replace("&amp;", "&")
+}
+
+fn escape_attribute(value: &str) -> String {
+    value
+        .replace('&', "&amp;")
+        .replace('"', "&quot;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn embed(html: &str, files: &[(&str, &str)]) -> EmbeddedHtml {
+        let files: HashMap<&str, &str> = files.iter().copied().collect();
+        embed_resources(html, &mut |path| {
+            files.get(path).map(|content| content.as_bytes().to_vec())
+        })
+    }
+
+    fn base64(content: &str) -> String {
+        base64::engine::general_purpose::STANDARD.encode(content)
+    }
+
+    #[test]
+    fn test_images_become_data_uris() {
+        let result = embed(
+            "<p><img src=\"images/a%20b.svg\" alt=\"A\" /></p>",
+            &[("images/a b.svg", "<svg/>")],
+        );
+        assert_eq!(
+            result.html,
+            format!(
+                "<p><img src=\"data:image/svg+xml;base64,{}\" alt=\"A\" /></p>",
+                base64("<svg/>")
+            )
+        );
+        assert!(result.missing.is_empty());
+    }
+
+    #[test]
+    fn test_remote_and_missing_urls_are_kept() {
+        let html = "<img src=\"https://example.com/a.png\"><img src=\"data:image/png;base64,AA\">\
+                    <img src=\"missing.png\"><a href=\"other.html\">x</a><img src=\"missing.png\">";
+        let result = embed(html, &[]);
+        assert_eq!(result.html, html);
+        assert_eq!(result.missing, vec!["missing.png"]);
+    }
+
+    #[test]
+    fn test_stylesheets_are_inlined() {
+        let result = embed(
+            "<link rel=\"stylesheet\" href=\"css/site.css\" media=\"print\">",
+            &[
+                (
+                    "css/site.css",
+                    "@import \"base.css\" screen;\nh1 { background: url(../img/bg.png) }",
+                ),
+                ("css/base.css", "body { margin: 0 }"),
+                ("img/bg.png", "png"),
+            ],
+        );
+        assert_eq!(
+            result.html,
+            format!(
+                "<style media=\"print\">@media screen {{\nbody {{ margin: 0 }}\n}}\n\
+                 h1 {{ background: url(\"data:image/png;base64,{}\") }}</style>",
+                base64("png")
+            )
+        );
+    }
+
+    #[test]
+    fn test_scripts_are_inlined() {
+        let result = embed(
+            "<script src=\"app.js\" type=\"module\"></script><script>if (a < b) {}</script>",
+            &[("app.js", "document.write('</script>');")],
+        );
+        assert_eq!(
+            result.html,
+            "<script type=\"module\">document.write('<\\/script>');</script>\
+             <script>if (a < b) {}</script>"
+        );
+    }
+
+    #[test]
+    fn test_style_elements_and_attributes() {
+        let result = embed(
+            "<style>p { background: url('dot.gif') }</style>\
+             <div style=\"background: url(&quot;dot.gif&quot;)\"></div>",
+            &[("dot.gif", "gif")],
+        );
+        let uri = format!("data:image/gif;base64,{}", base64("gif"));
+        assert_eq!(
+            result.html,
+            format!(
+                "<style>p {{ background: url(\"{uri}\") }}</style>\
+                 <div style=\"background: url(&quot;{uri}&quot;)\"></div>"
+            )
+        );
+    }
+
+    #[test]
+    fn test_comments_are_left_alone() {
+        let html = "<!-- <img src=\"a.png\"> --><p>1 < 2</p>";
+        assert_eq!(embed(html, &[("a.png", "png")]).html, html);
+    }
+
+    #[test]
+    fn test_join_url() {
+        assert_eq!(join_url("css/site.css", "../img/a.png"), "img/a.png");
+        assert_eq!(join_url("site.css", "./a.png"), "a.png");
+        assert_eq!(join_url("", "a.png?v=1"), "a.png?v=1");
+        assert_eq!(join_url("a.css", "../up.png"), "../up.png");
+        assert_eq!(join_url("css/a.css", "/root.png"), "/root.png");
+    }
+
+    #[test]
+    fn test_is_local() {
+        assert!(is_local("images/a.png"));
+        assert!(is_local("../a.png"));
+        assert!(is_local("C:/images/a.png"));
+        assert!(!is_local("https://example.com/a.png"));
+        assert!(!is_local("//cdn.example.com/a.js"));
+        assert!(!is_local("data:image/png;base64,AA"));
+        assert!(!is_local("#section"));
+        assert!(!is_local("mailto:someone@example.com"));
+    }
+}
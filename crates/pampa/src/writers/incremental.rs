@@ -19,7 +19,7 @@ use quarto_pandoc_types::config_value::{ConfigMapEntry, ConfigValue, ConfigValue
 use quarto_source_map::SourceInfo;
 use std::ops::Range;
 
-use super::qmd;
+use super::{qmd, qmd_escape};
 
 // =============================================================================
 // Types
@@ -264,7 +264,7 @@ fn assemble(
                 original_qmd[byte_range.clone()].to_string()
             }
             CoarsenedEntry::Rewrite { new_idx } => {
-                write_block_to_string(&new_ast.blocks[*new_idx])?
+                write_block_to_string(&new_ast.blocks[*new_idx], original_qmd)?
             }
             CoarsenedEntry::InlineSplice { block_text, .. } => block_text.clone(),
         };
@@ -452,7 +452,7 @@ fn block_source_span(block: &Block) -> Range<usize> {
 }
 
 /// Extract the SourceInfo from a Block.
-fn block_source_info(block: &Block) -> &SourceInfo {
+pub(crate) fn block_source_info(block: &Block) -> &SourceInfo {
     match block {
         Block::Paragraph(p) => &p.source_info,
         Block::Header(h) => &h.source_info,
@@ -477,11 +477,15 @@ fn block_source_info(block: &Block) -> &SourceInfo {
 }
 
 /// Write a single block to a string using the standard QMD writer.
+///
+/// Attributes that still match their spans in `original_qmd` are copied from
+/// it, so a rewritten block doesn't reorder or requote them.
 fn write_block_to_string(
     block: &Block,
+    original_qmd: &str,
 ) -> Result<String, Vec<quarto_error_reporting::DiagnosticMessage>> {
     let mut buf = Vec::new();
-    qmd::write_single_block(block, &mut buf)?;
+    qmd::write_single_block_with_source(block, original_qmd, &mut buf)?;
     String::from_utf8(buf).map_err(|e| {
         vec![
             quarto_error_reporting::DiagnosticMessageBuilder::error("UTF-8 error during write")
@@ -517,7 +521,7 @@ fn write_metadata_to_string(
 /// from QMD (with real source positions) against one deserialized from JSON
 /// (with default source positions). The derived PartialEq on ConfigValue
 /// includes source_info, which would incorrectly report them as different.
-fn metadata_content_eq(a: &ConfigValue, b: &ConfigValue) -> bool {
+pub(crate) fn metadata_content_eq(a: &ConfigValue, b: &ConfigValue) -> bool {
     config_value_content_eq(a, b)
 }
 
@@ -670,7 +674,8 @@ fn assemble_inline_content(
                 result.push_str(&original_qmd[span]);
             }
             InlineAlignment::UseAfter(after_idx) => {
-                let text = write_inline_to_string(&new_inlines[*after_idx])?;
+                let text =
+                    write_inline_to_string_from(&new_inlines[*after_idx], Some(original_qmd))?;
                 result.push_str(&text);
             }
             InlineAlignment::RecurseIntoContainer {
@@ -745,6 +750,12 @@ fn assemble_recursed_container(
 ///
 /// See: claude-notes/plans/2026-02-10-inline-splicing.md
 pub fn is_inline_splice_safe(new_inlines: &[Inline], plan: &InlineReconciliationPlan) -> bool {
+    splice_safe(new_inlines, plan, true)
+}
+
+/// `is_inline_splice_safe` for the inlines of a block (`top_level`) or of
+/// an inline container, whose content never starts a line
+fn splice_safe(new_inlines: &[Inline], plan: &InlineReconciliationPlan, top_level: bool) -> bool {
     for (result_idx, alignment) in plan.inline_alignments.iter().enumerate() {
         match alignment {
             InlineAlignment::KeepBefore(_) => {
@@ -758,13 +769,21 @@ pub fn is_inline_splice_safe(new_inlines: &[Inline], plan: &InlineReconciliation
                 if inline_subtree_has_break(&new_inlines[*after_idx]) {
                     return false;
                 }
+                // A Str written alone isn't escaped as the start of a line
+                if top_level
+                    && starts_line(new_inlines, *after_idx)
+                    && let Inline::Str(s) = &new_inlines[*after_idx]
+                    && qmd_escape::escapes_at_line_start(&s.text)
+                {
+                    return false;
+                }
             }
             InlineAlignment::RecurseIntoContainer { after_idx, .. } => {
                 // We'll recursively patch this container's children.
                 // Check the nested plan: any child we write must also be break-free.
                 if let Some(nested_plan) = plan.inline_container_plans.get(&result_idx) {
                     let children = inline_children(&new_inlines[*after_idx]);
-                    if !is_inline_splice_safe(children, nested_plan) {
+                    if !splice_safe(children, nested_plan, false) {
                         return false;
                     }
                 }
@@ -776,6 +795,15 @@ pub fn is_inline_splice_safe(new_inlines: &[Inline], plan: &InlineReconciliation
     true
 }
 
+/// Whether the inline at `index` of a block's inlines may start a line
+fn starts_line(inlines: &[Inline], index: usize) -> bool {
+    index == 0
+        || matches!(
+            inlines[index - 1],
+            Inline::SoftBreak(_) | Inline::LineBreak(_)
+        )
+}
+
 /// Returns true if the inline or any descendant is SoftBreak or LineBreak.
 pub fn inline_subtree_has_break(inline: &Inline) -> bool {
     matches!(inline, Inline::SoftBreak(_) | Inline::LineBreak(_))
@@ -880,9 +908,21 @@ pub fn inline_source_span(inline: &Inline) -> Range<usize> {
 /// `is_inline_splice_safe`).
 pub fn write_inline_to_string(
     inline: &Inline,
+) -> Result<String, Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_inline_to_string_from(inline, None)
+}
+
+/// Write a single inline like `write_inline_to_string`. Given `original_qmd`,
+/// attributes that still match their spans in it are copied from it.
+fn write_inline_to_string_from(
+    inline: &Inline,
+    original_qmd: Option<&str>,
 ) -> Result<String, Vec<quarto_error_reporting::DiagnosticMessage>> {
     let mut buf = Vec::new();
-    qmd::write_single_inline(inline, &mut buf)?;
+    match original_qmd {
+        Some(source) => qmd::write_single_inline_with_source(inline, source, &mut buf)?,
+        None => qmd::write_single_inline(inline, &mut buf)?,
+    }
     let result = String::from_utf8(buf).map_err(|e| {
         vec![
             quarto_error_reporting::DiagnosticMessageBuilder::error("UTF-8 error during write")
@@ -0,0 +1,333 @@
+/*
+ * ipynb.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Jupyter notebook writer.
+ *
+ * The inverse of the ipynb reader: executable code blocks become code
+ * cells, an `ipynb-outputs` raw block following one becomes its outputs,
+ * and everything in between is written with the qmd writer as markdown
+ * cells. Document metadata other than `jupyter` is kept as a front matter
+ * raw cell; `jupyter` becomes the notebook metadata.
+ */
+
+use crate::pandoc::{Block, CodeBlock, Pandoc};
+use crate::readers::ipynb::{OUTPUTS_FORMAT, RAW_CELL_FORMAT, RAW_MIMETYPES};
+use quarto_error_reporting::DiagnosticMessage;
+use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
+use serde::Serialize;
+use serde_json::{Map, Value, json};
+use std::collections::HashSet;
+use yaml_rust2::Yaml;
+
+/// The language of an executable code block, from its `{language}` class
+fn executable_language(code: &CodeBlock) -> Option<&str> {
+    code.attr
+        .1
+        .first()
+        .and_then(|class| class.strip_prefix('{'))
+        .and_then(|class| class.strip_suffix('}'))
+}
+
+/// Split text into notebook source lines, each keeping its newline
+fn source_lines(text: &str) -> Value {
+    Value::Array(
+        text.split_inclusive('\n')
+            .map(|line| Value::String(line.to_string()))
+            .collect(),
+    )
+}
+
+fn yaml_to_json(yaml: &Yaml) -> Value {
+    match yaml {
+        Yaml::String(s) => Value::String(s.clone()),
+        Yaml::Integer(i) => json!(i),
+        Yaml::Real(s) => s
+            .parse::<f64>()
+            .ok()
+            .and_then(serde_json::Number::from_f64)
+            .map_or_else(|| Value::String(s.clone()), Value::Number),
+        Yaml::Boolean(b) => Value::Bool(*b),
+        Yaml::Array(items) => Value::Array(items.iter().map(yaml_to_json).collect()),
+        Yaml::Hash(hash) => Value::Object(
+            hash.iter()
+                .filter_map(|(key, value)| {
+                    key.as_str()
+                        .map(|key| (key.to_string(), yaml_to_json(value)))
+                })
+                .collect(),
+        ),
+        _ => Value::Null,
+    }
+}
+
+/// Convert a metadata value to JSON. Markdown content becomes plain text.
+fn config_value_to_json(value: &ConfigValue) -> Value {
+    match &value.value {
+        ConfigValueKind::Scalar(yaml) => yaml_to_json(yaml),
+        ConfigValueKind::Array(items) => {
+            Value::Array(items.iter().map(config_value_to_json).collect())
+        }
+        ConfigValueKind::Map(entries) => Value::Object(
+            entries
+                .iter()
+                .map(|entry| (entry.key.clone(), config_value_to_json(&entry.value)))
+                .collect(),
+        ),
+        _ => value
+            .as_plain_text()
+            .map_or(Value::Null, |text| Value::String(text.trim().to_string())),
+    }
+}
+
+/// Cell metadata values are stored as JSON text unless they are strings
+fn attribute_to_json(value: &str) -> Value {
+    match serde_json::from_str::<Value>(value) {
+        Ok(Value::String(_)) | Err(_) => Value::String(value.to_string()),
+        Ok(parsed) => parsed,
+    }
+}
+
+/// Notebook metadata from the `jupyter` metadata value.
+///
+/// A map is used as-is. A string names the kernel, as in Quarto's
+/// `jupyter: python3`.
+fn notebook_metadata(jupyter: Option<&ConfigValue>, language: Option<&str>) -> Value {
+    let mut metadata = match jupyter.map(config_value_to_json) {
+        Some(Value::Object(map)) => map,
+        Some(Value::String(kernel)) => {
+            let mut kernelspec = Map::new();
+            kernelspec.insert("display_name".to_string(), json!(kernel));
+            if let Some(language) = language {
+                kernelspec.insert("language".to_string(), json!(language));
+            }
+            kernelspec.insert("name".to_string(), json!(kernel));
+            let mut map = Map::new();
+            map.insert("kernelspec".to_string(), Value::Object(kernelspec));
+            map
+        }
+        _ => Map::new(),
+    };
+    if !metadata.contains_key("language_info")
+        && let Some(language) = language
+    {
+        metadata.insert("language_info".to_string(), json!({ "name": language }));
+    }
+    Value::Object(metadata)
+}
+
+struct IpynbWriterContext {
+    cells: Vec<Value>,
+    /// Blocks of the markdown cell being accumulated
+    markdown: Vec<Block>,
+    used_ids: HashSet<String>,
+}
+
+impl IpynbWriterContext {
+    /// A cell id unique within the notebook, preferring `requested`
+    fn cell_id(&mut self, requested: &str) -> String {
+        let valid = !requested.is_empty()
+            && requested.len() <= 64
+            && requested
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_');
+        let mut id = if valid {
+            requested.to_string()
+        } else {
+            format!("cell-{}", self.cells.len() + 1)
+        };
+        let base = id.clone();
+        let mut suffix = 1;
+        while self.used_ids.contains(&id) {
+            suffix += 1;
+            id = format!("{}-{}", base, suffix);
+        }
+        self.used_ids.insert(id.clone());
+        id
+    }
+
+    fn push_raw_cell(&mut self, text: &str, mimetype: Option<&str>) {
+        let mut metadata = Map::new();
+        if let Some(mimetype) = mimetype {
+            metadata.insert("raw_mimetype".to_string(), json!(mimetype));
+        }
+        let id = self.cell_id("");
+        self.cells.push(json!({
+            "cell_type": "raw",
+            "id": id,
+            "metadata": metadata,
+            "source": source_lines(text),
+        }));
+    }
+
+    /// Write the accumulated blocks as one markdown cell
+    fn flush_markdown(&mut self) -> Result<(), Vec<DiagnosticMessage>> {
+        if self.markdown.is_empty() {
+            return Ok(());
+        }
+        let pandoc = Pandoc {
+            meta: ConfigValue::default(),
+            blocks: std::mem::take(&mut self.markdown),
+        };
+        let mut buf = Vec::new();
+        crate::writers::qmd::write(&pandoc, &mut buf)?;
+        let text = String::from_utf8_lossy(&buf);
+        let id = self.cell_id("");
+        self.cells.push(json!({
+            "cell_type": "markdown",
+            "id": id,
+            "metadata": {},
+            "source": source_lines(text.trim_end_matches('\n')),
+        }));
+        Ok(())
+    }
+
+    fn push_code_cell(&mut self, code: &CodeBlock, outputs: Option<Value>) {
+        let (id, _, attributes) = &code.attr;
+        let mut execution_count = Value::Null;
+        let mut metadata = Map::new();
+        for (key, value) in attributes {
+            if key == "execution_count" {
+                execution_count = value.parse::<u64>().map_or(Value::Null, |n| json!(n));
+            } else {
+                metadata.insert(key.clone(), attribute_to_json(value));
+            }
+        }
+        let id = self.cell_id(id);
+        self.cells.push(json!({
+            "cell_type": "code",
+            "execution_count": execution_count,
+            "id": id,
+            "metadata": metadata,
+            "outputs": outputs.unwrap_or_else(|| json!([])),
+            "source": source_lines(&code.text),
+        }));
+    }
+}
+
+fn io_error(e: std::io::Error) -> Vec<DiagnosticMessage> {
+    vec![
+        quarto_error_reporting::DiagnosticMessageBuilder::error("IO error during write")
+            .with_code("Q-3-1")
+            .problem(format!("Failed to write ipynb output: {}", e))
+            .build(),
+    ]
+}
+
+pub fn write<T: std::io::Write>(
+    pandoc: &Pandoc,
+    buf: &mut T,
+) -> Result<(), Vec<DiagnosticMessage>> {
+    let mut ctx = IpynbWriterContext {
+        cells: Vec::new(),
+        markdown: Vec::new(),
+        used_ids: HashSet::new(),
+    };
+
+    // Front matter other than `jupyter` goes into a leading raw cell
+    let front_matter: Vec<_> = pandoc
+        .meta
+        .as_map_entries()
+        .unwrap_or_default()
+        .iter()
+        .filter(|entry| entry.key != "jupyter")
+        .cloned()
+        .collect();
+    if !front_matter.is_empty() {
+        let meta_only = Pandoc {
+            meta: ConfigValue::new_map(front_matter, pandoc.meta.source_info.clone()),
+            blocks: Vec::new(),
+        };
+        let mut yaml = Vec::new();
+        crate::writers::qmd::write(&meta_only, &mut yaml)?;
+        ctx.push_raw_cell(String::from_utf8_lossy(&yaml).trim_end_matches('\n'), None);
+    }
+
+    let mut language = None;
+    let mut blocks = pandoc.blocks.iter().peekable();
+    while let Some(block) = blocks.next() {
+        match block {
+            Block::CodeBlock(code) if executable_language(code).is_some() => {
+                ctx.flush_markdown()?;
+                language = language.or(executable_language(code));
+                let outputs = match blocks.peek() {
+                    Some(Block::RawBlock(raw)) if raw.format == OUTPUTS_FORMAT => {
+                        blocks.next();
+                        serde_json::from_str(&raw.text).ok()
+                    }
+                    _ => None,
+                };
+                ctx.push_code_cell(code, outputs);
+            }
+            Block::RawBlock(raw) if raw.format == OUTPUTS_FORMAT => {
+                // Outputs without a code cell have nowhere to go
+            }
+            Block::RawBlock(raw) if raw.format == RAW_CELL_FORMAT => {
+                ctx.flush_markdown()?;
+                ctx.push_raw_cell(&raw.text, None);
+            }
+            Block::RawBlock(raw)
+                if RAW_MIMETYPES
+                    .iter()
+                    .any(|(_, format)| *format == raw.format) =>
+            {
+                ctx.flush_markdown()?;
+                let mimetype = RAW_MIMETYPES
+                    .iter()
+                    .find(|(_, format)| *format == raw.format)
+                    .map(|(mimetype, _)| *mimetype);
+                ctx.push_raw_cell(&raw.text, mimetype);
+            }
+            _ => ctx.markdown.push(block.clone()),
+        }
+    }
+    ctx.flush_markdown()?;
+
+    let notebook = json!({
+        "cells": ctx.cells,
+        "metadata": notebook_metadata(pandoc.meta.get("jupyter"), language),
+        "nbformat": 4,
+        "nbformat_minor": 5,
+    });
+
+    // Jupyter indents with a single space
+    let formatter = serde_json::ser::PrettyFormatter::with_indent(b" ");
+    let mut serializer = serde_json::Serializer::with_formatter(&mut *buf, formatter);
+    notebook
+        .serialize(&mut serializer)
+        .map_err(|e| io_error(e.into()))?;
+    writeln!(buf).map_err(io_error)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_source_lines() {
+        assert_eq!(source_lines("a\nb"), json!(["a\n", "b"]));
+        assert_eq!(source_lines(""), json!([]));
+    }
+
+    #[test]
+    fn test_attribute_to_json() {
+        assert_eq!(attribute_to_json("[\"a\"]"), json!(["a"]));
+        assert_eq!(attribute_to_json("true"), json!(true));
+        assert_eq!(attribute_to_json("plain text"), json!("plain text"));
+        // JSON string literals stay exactly as written
+        assert_eq!(attribute_to_json("\"quoted\""), json!("\"quoted\""));
+    }
+
+    #[test]
+    fn test_notebook_metadata_from_kernel_name() {
+        let jupyter = ConfigValue::new_string("python3", Default::default());
+        assert_eq!(
+            notebook_metadata(Some(&jupyter), Some("python")),
+            json!({
+                "kernelspec": {"display_name": "python3", "language": "python", "name": "python3"},
+                "language_info": {"name": "python"},
+            })
+        );
+    }
+}
@@ -8,6 +8,7 @@ use crate::pandoc::shortcode::shortcode_to_span;
 use crate::pandoc::{
     ASTContext, Attr, Block, Caption, CitationMode, Inline, Inlines, ListAttributes, Pandoc,
 };
+use crate::utils::block_ids::BlockIds;
 use hashlink::LinkedHashMap;
 use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
 use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
@@ -25,6 +26,10 @@ pub struct JsonConfig {
     /// - 'b': begin position {o: offset, l: line (1-based), c: column (1-based)}
     /// - 'e': end position {o: offset, l: line (1-based), c: column (1-based)}
     pub include_inline_locations: bool,
+    /// If true, give each block a 'blockId' field, made from its content
+    /// and its place among blocks with the same content (see
+    /// [`crate::utils::block_ids`]).
+    pub include_block_ids: bool,
 }
 
 // ============================================================================
@@ -344,6 +349,8 @@ struct JsonWriterContext<'a> {
     /// By pre-serializing during the precomputation phase, we ensure all SourceInfos from
     /// these variants are interned first, and we store the resulting JSON for later retrieval.
     precomputed_json: HashMap<*const ConfigValue, Value>,
+    /// Assigns block IDs when `config.include_block_ids` is set
+    block_ids: Option<BlockIds>,
 }
 
 impl<'a> JsonWriterContext<'a> {
@@ -352,6 +359,7 @@ impl<'a> JsonWriterContext<'a> {
             serializer: SourceInfoSerializer::new(ast_context, config),
             errors: Vec::new(),
             precomputed_json: HashMap::new(),
+            block_ids: config.include_block_ids.then(BlockIds::new),
         }
     }
 }
@@ -1258,6 +1266,15 @@ fn write_table_foot_source(
 }
 
 fn write_block(block: &Block, ctx: &mut JsonWriterContext) -> Value {
+    let mut value = write_block_node(block, ctx);
+    // Nested blocks are written first, so they have their IDs already
+    if let Some(block_ids) = &mut ctx.block_ids {
+        block_ids.assign(&mut value);
+    }
+    value
+}
+
+fn write_block_node(block: &Block, ctx: &mut JsonWriterContext) -> Value {
     match block {
         Block::Figure(figure) => {
             let mut obj = serde_json::Map::new();
@@ -0,0 +1,770 @@
+/*
+ * latex.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! LaTeX writer for Pandoc AST.
+//!
+//! This writer produces a LaTeX body fragment. A complete document is produced
+//! by rendering the fragment through a template (see the built-in `latex`
+//! template), the same way the HTML writer is combined with the `html` template.
+//!
+//! The output follows Pandoc's LaTeX writer where practical: tables are written
+//! as `longtable` with `booktabs` rules, figures as floating `figure`
+//! environments, and strikeout uses `\sout` from `ulem`. The packages used here
+//! are loaded by the built-in template.
+//!
+//! Highlighted code blocks are written as Pandoc writes them, in `Shaded` and
+//! `Highlighting` environments with token macros such as `\KeywordTok`. The
+//! template defines these from the theme's `highlighting-macros`.
+
+use crate::highlight::{HighlightConfig, Lines};
+use crate::pandoc::table::{Alignment, ColSpec, Row, Table};
+use crate::pandoc::{Attr, Block, Inline, Inlines, Pandoc};
+use crate::transforms::task_lists;
+use quarto_pandoc_types::ConfigValue;
+use std::io::Write;
+
+// =============================================================================
+// Configuration
+// =============================================================================
+
+/// Configuration for LaTeX output
+#[derive(Debug, Clone, Default)]
+pub struct LatexConfig {
+    /// Highlight code blocks; `None` writes them as `verbatim`
+    pub highlight: Option<HighlightConfig>,
+}
+
+/// Extract LaTeX configuration from document metadata.
+///
+/// Code blocks are highlighted as set by `highlight-style` (see
+/// [`HighlightConfig::from_metadata`]).
+pub fn extract_config_from_metadata(meta: &ConfigValue) -> LatexConfig {
+    LatexConfig {
+        highlight: HighlightConfig::from_metadata(meta),
+    }
+}
+
+// =============================================================================
+// Context
+// =============================================================================
+
+/// Context threaded through LaTeX writer functions.
+///
+/// Like the HTML writer context, this implements `Write` so `write!` and
+/// `writeln!` can be used directly on it.
+pub struct LatexWriterContext<W: Write> {
+    /// The underlying writer
+    writer: W,
+    /// Nesting depth of `enumerate` environments, used to pick the counter
+    /// (`enumi`, `enumii`, ...) when a list starts at a number other than 1
+    enumerate_depth: usize,
+    /// Configuration
+    config: LatexConfig,
+    /// Whether any code block was highlighted
+    highlighted: bool,
+}
+
+impl<W: Write> Write for LatexWriterContext<W> {
+    fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+        self.writer.write(buf)
+    }
+
+    fn flush(&mut self) -> std::io::Result<()> {
+        self.writer.flush()
+    }
+}
+
+impl<W: Write> LatexWriterContext<W> {
+    /// Create a new context
+    pub fn new(writer: W) -> Self {
+        Self::with_config(writer, LatexConfig::default())
+    }
+
+    /// Create a new context with config
+    pub fn with_config(writer: W, config: LatexConfig) -> Self {
+        Self {
+            writer,
+            enumerate_depth: 0,
+            config,
+            highlighted: false,
+        }
+    }
+}
+
+// =============================================================================
+// Escaping
+// =============================================================================
+
+/// Escape text for use in ordinary LaTeX text mode.
+fn escape_latex(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '\\' => out.push_str("\\textbackslash{}"),
+            '{' => out.push_str("\\{"),
+            '}' => out.push_str("\\}"),
+            '$' => out.push_str("\\$"),
+            '&' => out.push_str("\\&"),
+            '%' => out.push_str("\\%"),
+            '#' => out.push_str("\\#"),
+            '_' => out.push_str("\\_"),
+            '^' => out.push_str("\\^{}"),
+            '~' => out.push_str("\\textasciitilde{}"),
+            '<' => out.push_str("\\textless{}"),
+            '>' => out.push_str("\\textgreater{}"),
+            '|' => out.push_str("\\textbar{}"),
+            // Brackets are grouped so they can't be read as an optional argument
+            '[' => out.push_str("{[}"),
+            ']' => out.push_str("{]}"),
+            '\u{00A0}' => out.push('~'),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+/// Escape a URL for use in `\href` or `\includegraphics`.
+///
+/// Only the characters that would break the argument are escaped; hyperref
+/// reads the rest verbatim.
+fn escape_url(url: &str) -> String {
+    let mut out = String::with_capacity(url.len());
+    for c in url.chars() {
+        match c {
+            '\\' | '{' | '}' | '%' | '#' => {
+                out.push('\\');
+                out.push(c);
+            }
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+/// Convert a Pandoc dimension attribute (e.g. `50%`, `3in`) to a LaTeX length.
+fn latex_dimension(value: &str) -> String {
+    match value.strip_suffix('%').and_then(|p| p.parse::<f64>().ok()) {
+        Some(percent) => format!("{}\\linewidth", percent / 100.0),
+        None => match value.parse::<f64>() {
+            // Bare numbers are pixels; use 96 dpi as Pandoc does
+            Ok(px) => format!("{}in", px / 96.0),
+            Err(_) => value.to_string(),
+        },
+    }
+}
+
+/// Write `\label{id}` if the attribute has an identifier.
+fn write_label<W: Write>(attr: &Attr, ctx: &mut LatexWriterContext<W>) -> std::io::Result<()> {
+    if !attr.0.is_empty() {
+        write!(ctx, "\\label{{{}}}", attr.0)?;
+    }
+    Ok(())
+}
+
+// =============================================================================
+// Inlines
+// =============================================================================
+
+/// Write a single inline element
+fn write_inline<W: Write>(inline: &Inline, ctx: &mut LatexWriterContext<W>) -> std::io::Result<()> {
+    match inline {
+        Inline::Str(s) => write!(ctx, "{}", escape_latex(&s.text))?,
+        Inline::Space(_) => write!(ctx, " ")?,
+        Inline::SoftBreak(_) => writeln!(ctx)?,
+        Inline::LineBreak(_) => writeln!(ctx, "\\\\")?,
+        Inline::Emph(e) => write_command("emph", &e.content, ctx)?,
+        Inline::Strong(s) => write_command("textbf", &s.content, ctx)?,
+        Inline::Underline(u) => write_command("underline", &u.content, ctx)?,
+        Inline::Strikeout(s) => write_command("sout", &s.content, ctx)?,
+        Inline::Superscript(s) => write_command("textsuperscript", &s.content, ctx)?,
+        Inline::Subscript(s) => write_command("textsubscript", &s.content, ctx)?,
+        Inline::SmallCaps(s) => write_command("textsc", &s.content, ctx)?,
+        Inline::Quoted(q) => {
+            let (open, close) = match q.quote_type {
+                crate::pandoc::QuoteType::SingleQuote => ("`", "'"),
+                crate::pandoc::QuoteType::DoubleQuote => ("``", "''"),
+            };
+            write!(ctx, "{}", open)?;
+            write_inlines(&q.content, ctx)?;
+            write!(ctx, "{}", close)?;
+        }
+        Inline::Code(c) => write!(ctx, "\\texttt{{{}}}", escape_latex(&c.text))?,
+        Inline::Math(m) => match m.math_type {
+            crate::pandoc::MathType::InlineMath => write!(ctx, "\\({}\\)", m.text)?,
+            crate::pandoc::MathType::DisplayMath => write!(ctx, "\\[{}\\]", m.text)?,
+        },
+        Inline::RawInline(raw) => {
+            if raw.format == "latex" || raw.format == "tex" {
+                write!(ctx, "{}", raw.text)?;
+            }
+        }
+        Inline::Link(link) => {
+            let url = &link.target.0;
+            if let Some(id) = url.strip_prefix('#') {
+                write!(ctx, "\\hyperref[{}]{{", id)?;
+            } else {
+                write!(ctx, "\\href{{{}}}{{", escape_url(url))?;
+            }
+            write_inlines(&link.content, ctx)?;
+            write!(ctx, "}}")?;
+        }
+        Inline::Image(image) => write_image(image, ctx)?,
+        Inline::Note(note) => {
+            write!(ctx, "\\footnote{{")?;
+            write_blocks(&note.content, ctx)?;
+            write!(ctx, "}}")?;
+        }
+        Inline::Span(span) => {
+            if !span.attr.0.is_empty() {
+                write!(ctx, "\\phantomsection\\label{{{}}}", span.attr.0)?;
+            }
+            write_inlines(&span.content, ctx)?;
+        }
+        Inline::Cite(cite) => {
+            // Citeproc fills in the rendered citation; fall back to \cite otherwise
+            if !cite.content.is_empty() {
+                write_inlines(&cite.content, ctx)?;
+            } else {
+                let ids: Vec<&str> = cite.citations.iter().map(|c| c.id.as_str()).collect();
+                write!(ctx, "\\cite{{{}}}", ids.join(","))?;
+            }
+        }
+        Inline::Insert(ins) => write_inlines(&ins.content, ctx)?,
+        Inline::Delete(del) => write_command("sout", &del.content, ctx)?,
+        Inline::Highlight(h) => write_inlines(&h.content, ctx)?,
+        Inline::Custom(custom) if custom.type_name == crate::pandoc::INLINE_CELL_TYPE_NAME => {
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                write_inlines(&cell.rendered(custom), ctx)?;
+            }
+        }
+        // Quarto extensions and editor comments have no LaTeX rendering
+        Inline::Shortcode(_)
+        | Inline::NoteReference(_)
+        | Inline::Attr(_, _)
+        | Inline::EditComment(_)
+        | Inline::Custom(_) => {}
+    }
+    Ok(())
+}
+
+/// Write `\command{content}`
+fn write_command<W: Write>(
+    command: &str,
+    content: &Inlines,
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    write!(ctx, "\\{}{{", command)?;
+    write_inlines(content, ctx)?;
+    write!(ctx, "}}")?;
+    Ok(())
+}
+
+/// Write an image as `\includegraphics`, honoring width and height attributes
+fn write_image<W: Write>(
+    image: &crate::pandoc::inline::Image,
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    let options: Vec<String> = ["width", "height"]
+        .iter()
+        .filter_map(|key| {
+            image
+                .attr
+                .2
+                .get(*key)
+                .map(|value| format!("{}={}", key, latex_dimension(value)))
+        })
+        .collect();
+    if options.is_empty() {
+        write!(ctx, "\\includegraphics")?;
+    } else {
+        write!(ctx, "\\includegraphics[{}]", options.join(","))?;
+    }
+    write!(ctx, "{{{}}}", escape_url(&image.target.0))?;
+    Ok(())
+}
+
+/// Write a sequence of inlines
+fn write_inlines<W: Write>(
+    inlines: &Inlines,
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    for inline in inlines {
+        write_inline(inline, ctx)?;
+    }
+    Ok(())
+}
+
+// =============================================================================
+// Blocks
+// =============================================================================
+
+/// Write a single block element, followed by a newline
+fn write_block<W: Write>(block: &Block, ctx: &mut LatexWriterContext<W>) -> std::io::Result<()> {
+    match block {
+        Block::Plain(plain) => {
+            write_inlines(&plain.content, ctx)?;
+            writeln!(ctx)?;
+        }
+        Block::Paragraph(para) => {
+            write_inlines(&para.content, ctx)?;
+            writeln!(ctx)?;
+        }
+        Block::LineBlock(lineblock) => {
+            for (i, line) in lineblock.content.iter().enumerate() {
+                if i > 0 {
+                    writeln!(ctx, "\\\\")?;
+                }
+                write_inlines(line, ctx)?;
+            }
+            writeln!(ctx)?;
+        }
+        Block::CodeBlock(codeblock) => {
+            let highlighted = ctx.config.highlight.as_ref().and_then(|highlight| {
+                highlight.highlight_code_block(&codeblock.attr, &codeblock.text)
+            });
+            if let Some((_language, lines)) = highlighted {
+                return write_highlighted_code_block(&lines, ctx);
+            }
+            writeln!(ctx, "\\begin{{verbatim}}")?;
+            writeln!(ctx, "{}", codeblock.text)?;
+            writeln!(ctx, "\\end{{verbatim}}")?;
+        }
+        Block::RawBlock(raw) => {
+            if raw.format == "latex" || raw.format == "tex" {
+                writeln!(ctx, "{}", raw.text)?;
+            }
+        }
+        Block::BlockQuote(quote) => {
+            writeln!(ctx, "\\begin{{quote}}")?;
+            write_blocks(&quote.content, ctx)?;
+            writeln!(ctx, "\\end{{quote}}")?;
+        }
+        Block::OrderedList(list) => {
+            let (start, _style, _delim) = &list.attr;
+            ctx.enumerate_depth += 1;
+            writeln!(ctx, "\\begin{{enumerate}}")?;
+            if *start != 1 && ctx.enumerate_depth <= 4 {
+                let counter = ["enumi", "enumii", "enumiii", "enumiv"][ctx.enumerate_depth - 1];
+                writeln!(ctx, "\\setcounter{{{}}}{{{}}}", counter, *start as i64 - 1)?;
+            }
+            for item in &list.content {
+                write_item(None, item, ctx)?;
+            }
+            writeln!(ctx, "\\end{{enumerate}}")?;
+            ctx.enumerate_depth -= 1;
+        }
+        Block::BulletList(list) => {
+            writeln!(ctx, "\\begin{{itemize}}")?;
+            for item in &list.content {
+                write_item(None, item, ctx)?;
+            }
+            writeln!(ctx, "\\end{{itemize}}")?;
+        }
+        Block::DefinitionList(deflist) => {
+            writeln!(ctx, "\\begin{{description}}")?;
+            for (term, definitions) in &deflist.content {
+                let blocks: Vec<Block> = definitions.iter().flatten().cloned().collect();
+                write_item(Some(term), &blocks, ctx)?;
+            }
+            writeln!(ctx, "\\end{{description}}")?;
+        }
+        Block::Header(header) => {
+            let command = match header.level {
+                1 => "section",
+                2 => "subsection",
+                3 => "subsubsection",
+                4 => "paragraph",
+                _ => "subparagraph",
+            };
+            let star = if header.attr.1.iter().any(|c| c == "unnumbered") {
+                "*"
+            } else {
+                ""
+            };
+            write!(ctx, "\\{}{}{{", command, star)?;
+            write_inlines(&header.content, ctx)?;
+            write!(ctx, "}}")?;
+            write_label(&header.attr, ctx)?;
+            writeln!(ctx)?;
+        }
+        Block::HorizontalRule(_) => {
+            writeln!(
+                ctx,
+                "\\begin{{center}}\\rule{{0.5\\linewidth}}{{0.5pt}}\\end{{center}}"
+            )?;
+        }
+        Block::Table(table) => write_table(table, ctx)?,
+        Block::Figure(figure) => {
+            writeln!(ctx, "\\begin{{figure}}")?;
+            writeln!(ctx, "\\centering")?;
+            write_blocks(&figure.content, ctx)?;
+            if let Some(ref long_caption) = figure.caption.long
+                && !long_caption.is_empty()
+            {
+                write!(ctx, "\\caption{{")?;
+                write_inline_blocks(long_caption, ctx)?;
+                write!(ctx, "}}")?;
+                write_label(&figure.attr, ctx)?;
+                writeln!(ctx)?;
+            }
+            writeln!(ctx, "\\end{{figure}}")?;
+        }
+        Block::Div(div) => {
+            if !div.attr.0.is_empty() {
+                write!(ctx, "\\phantomsection")?;
+                write_label(&div.attr, ctx)?;
+                writeln!(ctx)?;
+            }
+            write_blocks(&div.content, ctx)?;
+        }
+        // Quarto extensions
+        Block::BlockMetadata(_) => {
+            // Metadata blocks don't render to LaTeX
+        }
+        Block::NoteDefinitionPara(_) | Block::NoteDefinitionFencedBlock(_) => {
+            // Note definitions are rendered at their references
+        }
+        Block::CaptionBlock(caption) => {
+            write_inlines(&caption.content, ctx)?;
+            writeln!(ctx)?;
+        }
+        Block::Custom(_) => {
+            // Custom block nodes are not rendered in LaTeX output
+        }
+    }
+    Ok(())
+}
+
+/// Write a list item, with an optional `\item[term]` label. A task's
+/// checkbox is the label, as a box.
+fn write_item<W: Write>(
+    term: Option<&Inlines>,
+    blocks: &[Block],
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    let task = match term {
+        Some(_) => None,
+        None => task_lists::task_item_content(blocks),
+    };
+    match (term, &task) {
+        (Some(term), _) => {
+            write!(ctx, "\\item[")?;
+            write_inlines(term, ctx)?;
+            write!(ctx, "]")?;
+        }
+        (None, Some((checked, _))) => {
+            let symbol = if *checked { "\\boxtimes" } else { "\\square" };
+            write!(ctx, "\\item[${}$]", symbol)?;
+        }
+        (None, None) => write!(ctx, "\\item")?,
+    }
+    let blocks = match &task {
+        Some((_, content)) => content.as_slice(),
+        None => blocks,
+    };
+    if blocks.is_empty() {
+        writeln!(ctx)?;
+    } else {
+        write!(ctx, " ")?;
+        write_blocks(blocks, ctx)?;
+    }
+    Ok(())
+}
+
+/// Write the inlines of `Plain` and `Paragraph` blocks on a single line.
+///
+/// Used for captions and table cells, where paragraph breaks aren't allowed.
+fn write_inline_blocks<W: Write>(
+    blocks: &[Block],
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    let mut first = true;
+    for block in blocks {
+        let inlines = match block {
+            Block::Plain(plain) => &plain.content,
+            Block::Paragraph(para) => &para.content,
+            _ => continue,
+        };
+        if !first {
+            write!(ctx, " ")?;
+        }
+        write_inlines(inlines, ctx)?;
+        first = false;
+    }
+    Ok(())
+}
+
+/// Write a table as a `longtable` with `booktabs` rules
+fn write_table<W: Write>(table: &Table, ctx: &mut LatexWriterContext<W>) -> std::io::Result<()> {
+    let columns: String = table
+        .colspec
+        .iter()
+        .map(|colspec| column_letter(&colspec.0))
+        .collect();
+    writeln!(ctx, "\\begin{{longtable}}[]{{@{{}}{}@{{}}}}", columns)?;
+
+    if let Some(ref long_caption) = table.caption.long
+        && !long_caption.is_empty()
+    {
+        write!(ctx, "\\caption{{")?;
+        write_inline_blocks(long_caption, ctx)?;
+        write!(ctx, "}}")?;
+        write_label(&table.attr, ctx)?;
+        writeln!(ctx, "\\tabularnewline")?;
+    }
+
+    writeln!(ctx, "\\toprule\\noalign{{}}")?;
+    if !table.head.rows.is_empty() {
+        for row in &table.head.rows {
+            write_table_row(row, &table.colspec, ctx)?;
+        }
+        writeln!(ctx, "\\midrule\\noalign{{}}")?;
+        writeln!(ctx, "\\endhead")?;
+    }
+    for body in &table.bodies {
+        for row in body.head.iter().chain(&body.body) {
+            write_table_row(row, &table.colspec, ctx)?;
+        }
+    }
+    if !table.foot.rows.is_empty() {
+        writeln!(ctx, "\\midrule\\noalign{{}}")?;
+        for row in &table.foot.rows {
+            write_table_row(row, &table.colspec, ctx)?;
+        }
+    }
+    writeln!(ctx, "\\bottomrule\\noalign{{}}")?;
+    writeln!(ctx, "\\end{{longtable}}")?;
+    Ok(())
+}
+
+/// The `longtable` column type for an alignment
+fn column_letter(alignment: &Alignment) -> &'static str {
+    match alignment {
+        Alignment::Right => "r",
+        Alignment::Center => "c",
+        Alignment::Left | Alignment::Default => "l",
+    }
+}
+
+/// Write a table row, using `\multicolumn` for spanning or realigned cells
+fn write_table_row<W: Write>(
+    row: &Row,
+    colspecs: &[ColSpec],
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    let mut col = 0;
+    for (i, cell) in row.cells.iter().enumerate() {
+        if i > 0 {
+            write!(ctx, " & ")?;
+        }
+        let span = cell.col_span.max(1);
+        let column_alignment = colspecs
+            .get(col)
+            .map_or(&Alignment::Default, |colspec| &colspec.0);
+        let realigned = cell.alignment != Alignment::Default && cell.alignment != *column_alignment;
+        if span > 1 || realigned {
+            let alignment = if cell.alignment == Alignment::Default {
+                column_alignment
+            } else {
+                &cell.alignment
+            };
+            write!(
+                ctx,
+                "\\multicolumn{{{}}}{{{}}}{{",
+                span,
+                column_letter(alignment)
+            )?;
+            write_inline_blocks(&cell.content, ctx)?;
+            write!(ctx, "}}")?;
+        } else {
+            write_inline_blocks(&cell.content, ctx)?;
+        }
+        col += span;
+    }
+    writeln!(ctx, " \\\\")?;
+    Ok(())
+}
+
+/// Write a sequence of blocks, separated by blank lines
+fn write_blocks<W: Write>(
+    blocks: &[Block],
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    let mut first = true;
+    for block in blocks {
+        // Blocks that produce no output shouldn't leave stray blank lines
+        if matches!(
+            block,
+            Block::BlockMetadata(_)
+                | Block::NoteDefinitionPara(_)
+                | Block::NoteDefinitionFencedBlock(_)
+                | Block::Custom(_)
+        ) {
+            continue;
+        }
+        if !first {
+            writeln!(ctx)?;
+        }
+        write_block(block, ctx)?;
+        first = false;
+    }
+    Ok(())
+}
+
+/// Write a highlighted code block, with one token macro per token.
+fn write_highlighted_code_block<W: Write>(
+    lines: &Lines,
+    ctx: &mut LatexWriterContext<W>,
+) -> std::io::Result<()> {
+    ctx.highlighted = true;
+    writeln!(ctx, "\\begin{{Shaded}}")?;
+    writeln!(ctx, "\\begin{{Highlighting}}[]")?;
+    for line in lines {
+        for token in line {
+            write!(
+                ctx,
+                "\\{}{{{}}}",
+                token.kind.latex_macro(),
+                escape_highlighting(&token.text)
+            )?;
+        }
+        writeln!(ctx)?;
+    }
+    writeln!(ctx, "\\end{{Highlighting}}")?;
+    writeln!(ctx, "\\end{{Shaded}}")?;
+    Ok(())
+}
+
+/// Escape token text inside the `Highlighting` environment, where only
+/// `\`, `{` and `}` are special.
+fn escape_highlighting(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '\\' => out.push_str("\\textbackslash{}"),
+            '{' => out.push_str("\\{"),
+            '}' => out.push_str("\\}"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+// =============================================================================
+// Public API
+// =============================================================================
+
+/// Write a Pandoc document's body to LaTeX.
+///
+/// Only the body is written; use a template (such as the built-in `latex`
+/// template) to produce a complete document. Code blocks are highlighted as
+/// set by the document's `highlight-style`.
+pub fn write<W: Write>(pandoc: &Pandoc, writer: W) -> std::io::Result<()> {
+    let config = extract_config_from_metadata(&pandoc.meta);
+    write_blocks_with_config(&pandoc.blocks, writer, config).map(|_| ())
+}
+
+/// Public wrapper to write blocks (for external callers)
+pub fn write_blocks_to<W: Write>(blocks: &[Block], writer: W) -> std::io::Result<()> {
+    write_blocks_with_config(blocks, writer, LatexConfig::default()).map(|_| ())
+}
+
+/// Write blocks with configuration.
+///
+/// Returns whether any code block was highlighted, so that a template can
+/// include the highlighting macros only when they are needed.
+pub fn write_blocks_with_config<W: Write>(
+    blocks: &[Block],
+    writer: W,
+    config: LatexConfig,
+) -> std::io::Result<bool> {
+    let mut ctx = LatexWriterContext::with_config(writer, config);
+    write_blocks(blocks, &mut ctx)?;
+    Ok(ctx.highlighted)
+}
+
+/// Public wrapper to write inlines (for external callers)
+pub fn write_inlines_to<W: Write>(inlines: &Inlines, writer: W) -> std::io::Result<()> {
+    let mut ctx = LatexWriterContext::new(writer);
+    write_inlines(inlines, &mut ctx)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::block::{Header, Paragraph};
+    use crate::pandoc::inline::{Emph, Str};
+    use hashlink::LinkedHashMap;
+    use quarto_pandoc_types::attr::AttrSourceInfo;
+    use quarto_source_map::SourceInfo;
+
+    fn str_inline(text: &str) -> Inline {
+        Inline::Str(Str {
+            text: text.to_string(),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn blocks_to_string(blocks: &[Block]) -> String {
+        let mut buf = Vec::new();
+        write_blocks_to(blocks, &mut buf).unwrap();
+        String::from_utf8(buf).unwrap()
+    }
+
+    #[test]
+    fn test_escape_latex_special_characters() {
+        assert_eq!(
+            escape_latex("50% of $5 & #1_a"),
+            "50\\% of \\$5 \\& \\#1\\_a"
+        );
+        assert_eq!(escape_latex("a\\b"), "a\\textbackslash{}b");
+        assert_eq!(escape_latex("~^"), "\\textasciitilde{}\\^{}");
+        assert_eq!(escape_latex("[x]"), "{[}x{]}");
+    }
+
+    #[test]
+    fn test_latex_dimension() {
+        assert_eq!(latex_dimension("50%"), "0.5\\linewidth");
+        assert_eq!(latex_dimension("96"), "1in");
+        assert_eq!(latex_dimension("3cm"), "3cm");
+    }
+
+    #[test]
+    fn test_header_with_label() {
+        let header = Block::Header(Header {
+            level: 2,
+            attr: (
+                "intro".to_string(),
+                vec!["unnumbered".to_string()],
+                LinkedHashMap::new(),
+            ),
+            content: vec![str_inline("Intro")],
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        });
+        assert_eq!(
+            blocks_to_string(&[header]),
+            "\\subsection*{Intro}\\label{intro}\n"
+        );
+    }
+
+    #[test]
+    fn test_paragraphs_are_separated_by_blank_lines() {
+        let para = |text: &str| {
+            Block::Paragraph(Paragraph {
+                content: vec![Inline::Emph(Emph {
+                    content: vec![str_inline(text)],
+                    source_info: SourceInfo::default(),
+                })],
+                source_info: SourceInfo::default(),
+            })
+        };
+        assert_eq!(
+            blocks_to_string(&[para("one"), para("two")]),
+            "\\emph{one}\n\n\\emph{two}\n"
+        );
+    }
+}
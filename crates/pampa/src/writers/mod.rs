@@ -5,10 +5,18 @@
 
 #[cfg(feature = "terminal-support")]
 pub mod ansi;
+pub mod docx;
+pub mod epub;
 pub mod html;
+pub mod html_embed;
 pub(crate) mod html_source;
 pub mod incremental;
+pub mod ipynb;
 pub mod json;
+pub mod latex;
 pub mod native;
 pub mod plaintext;
 pub mod qmd;
+pub(crate) mod qmd_escape;
+pub mod revealjs;
+pub mod typst;
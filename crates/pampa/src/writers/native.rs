@@ -478,7 +478,24 @@ fn write_inline<T: std::io::Write>(
             // Skip this inline
         }
         Inline::Custom(custom) => {
-            // Custom nodes are not supported in native format
+            // Annotations are written as the HTML comments they were read from
+            if let Some(annotation) = crate::pandoc::Annotation::from_custom(custom) {
+                write!(buf, "RawInline (Format \"html\") ")?;
+                write_safe_string(&annotation.to_comment(), buf)?;
+                return Ok(());
+            }
+            // Inline cells are their output, or their code until they are
+            // evaluated
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                return match &cell.rendered(custom)[..] {
+                    [inline] => write_inline(inline, context, buf, errors),
+                    inlines => {
+                        write!(buf, "Span ( \"\" , [] , [] ) ")?;
+                        write_inlines(inlines, context, buf, errors)
+                    }
+                };
+            }
+            // Other custom nodes are not supported in native format
             errors.push(
                 quarto_error_reporting::DiagnosticMessageBuilder::error(
                     "Custom inline node in native writer",
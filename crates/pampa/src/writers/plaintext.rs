@@ -171,6 +171,13 @@ fn write_inline<T: std::io::Write>(
         Inline::Attr(_, _) => {
             // Attr uses AttrSourceInfo, not SourceInfo, so we drop silently
         }
+        // Annotations are notes for editors: skip (no warning)
+        Inline::Custom(custom) if custom.type_name == crate::pandoc::ANNOTATION_TYPE_NAME => {}
+        Inline::Custom(custom) if custom.type_name == crate::pandoc::INLINE_CELL_TYPE_NAME => {
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                write_inlines(&cell.rendered(custom), buf, ctx)?;
+            }
+        }
         Inline::Custom(custom) => {
             ctx.warn_dropped_node(
                 &format!("Custom inline ({})", custom.type_name),
@@ -781,6 +788,7 @@ mod tests {
         let inlines = vec![
             make_str("text"),
             Inline::Note(Note {
+                id: None,
                 content: vec![Block::Paragraph(Paragraph {
                     content: vec![make_str("note content")],
                     source_info: dummy_source_info(),
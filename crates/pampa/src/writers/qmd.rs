@@ -3,29 +3,201 @@
  * Copyright (c) 2025 Posit, PBC
  */
 
-use crate::pandoc::attr::is_empty_attr;
-use crate::pandoc::block::MetaBlock;
-use crate::pandoc::inline::Inline;
+use crate::pandoc::attr::{Attr, AttrSourceInfo, is_empty_attr};
+use crate::pandoc::block::{Blocks, MetaBlock};
+use crate::pandoc::inline::{Inline, Target};
 use crate::pandoc::list::{ListNumberDelim, ListNumberStyle};
 use crate::pandoc::table::{Alignment, Cell, ColWidth, Row, Table};
+use crate::pandoc::treesitter_utils::grid_table::GRID_TABLE_COLUMNS;
+use crate::pandoc::treesitter_utils::pipe_table::PIPE_TABLE_COLUMNS;
+use crate::pandoc::treesitter_utils::text_helpers::extract_quoted_text;
 use crate::pandoc::{
     Block, BlockQuote, BulletList, CodeBlock, DefinitionList, Figure, Header, HorizontalRule,
     LineBlock, OrderedList, Pandoc, Paragraph, Plain, RawBlock, Str,
 };
+use crate::transforms::task_lists;
+use crate::writers::qmd_escape;
 use hashlink::LinkedHashMap;
 use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
+use quarto_source_map::SourceInfo;
 use std::io::{self, Write};
+use std::ops::Range;
+use std::str::FromStr;
+use unicode_width::UnicodeWidthStr;
 use yaml_rust2::{Yaml, YamlEmitter};
 
+/// How paragraph text is laid out, as in Pandoc's `--wrap`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum WrapMode {
+    /// Fill lines up to the column width; soft breaks become spaces
+    Auto,
+    /// Never break lines; soft breaks become spaces
+    None,
+    /// Keep soft breaks as line breaks and don't add any. This is the
+    /// default so that qmd round-trips keep the source's line structure.
+    #[default]
+    Preserve,
+}
+
+impl FromStr for WrapMode {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "auto" => Ok(WrapMode::Auto),
+            "none" => Ok(WrapMode::None),
+            "preserve" => Ok(WrapMode::Preserve),
+            _ => Err(format!(
+                "unknown wrap mode '{}' (expected auto, none or preserve)",
+                s
+            )),
+        }
+    }
+}
+
+/// How level 1 and 2 headings are written
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum HeadingStyle {
+    /// `# Heading`
+    #[default]
+    Atx,
+    /// The heading text underlined with `=` or `-`. Deeper levels have no
+    /// setext form and stay ATX.
+    Setext,
+}
+
+impl FromStr for HeadingStyle {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "atx" => Ok(HeadingStyle::Atx),
+            "setext" => Ok(HeadingStyle::Setext),
+            _ => Err(format!(
+                "unknown heading style '{}' (expected atx or setext)",
+                s
+            )),
+        }
+    }
+}
+
+/// How code blocks are written
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum CodeBlockStyle {
+    /// Backtick fences
+    #[default]
+    Fenced,
+    /// Indented by four spaces. Blocks with attributes, and blocks that
+    /// would not read back the same way indented, are still fenced.
+    Indented,
+}
+
+impl FromStr for CodeBlockStyle {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "fenced" => Ok(CodeBlockStyle::Fenced),
+            "indented" => Ok(CodeBlockStyle::Indented),
+            _ => Err(format!(
+                "unknown code block style '{}' (expected fenced or indented)",
+                s
+            )),
+        }
+    }
+}
+
+/// Options for the QMD writer.
+#[derive(Debug, Clone)]
+pub struct QmdConfig {
+    pub wrap: WrapMode,
+    /// Line width for `WrapMode::Auto`, including list and block quote
+    /// prefixes. East Asian wide characters count as two columns.
+    pub columns: usize,
+    /// Bullet list marker: `*`, `-` or `+`
+    pub bullet_marker: char,
+    /// Delimiter for ordered list numbers. `None` keeps each list's own.
+    pub ordered_list_delimiter: Option<ListNumberDelim>,
+    /// Preferred delimiter for emphasis and strong emphasis. Nested
+    /// emphasis alternates, and `_` falls back to `*` inside words, where
+    /// it would not be recognized.
+    pub emphasis: EmphasisDelimiter,
+    pub heading_style: HeadingStyle,
+    pub code_block_style: CodeBlockStyle,
+    /// Write links and images as references, with the definitions at the
+    /// end of the document. Links that were read as references are
+    /// written as references either way.
+    pub reference_links: bool,
+    /// Write em dashes, en dashes and ellipses as `---`, `--` and `...`,
+    /// for documents that are read back with smart punctuation
+    pub smart: bool,
+    /// Write Spans with just the `mark` class as `==text==`, and escape
+    /// `==` in text, for documents that are read back with the `mark`
+    /// extension
+    pub mark: bool,
+    /// Pad the cells of pipe tables to the width of their column, placed
+    /// as the column is aligned. Without padding, cells are written as
+    /// they are, so that a changed cell only changes its own row; relative
+    /// column widths then only read back from tables with a wide row.
+    pub pad_tables: bool,
+}
+
+impl Default for QmdConfig {
+    fn default() -> Self {
+        Self {
+            wrap: WrapMode::default(),
+            columns: 72,
+            bullet_marker: '*',
+            ordered_list_delimiter: None,
+            emphasis: EmphasisDelimiter::default(),
+            heading_style: HeadingStyle::default(),
+            code_block_style: CodeBlockStyle::default(),
+            reference_links: false,
+            smart: false,
+            mark: false,
+            pad_tables: true,
+        }
+    }
+}
+
+/// Written for a breakable Space while filling a paragraph, and replaced
+/// by a space or a newline once the paragraph is laid out
+const BREAK_OPPORTUNITY: char = '\u{1F}';
+
 /// Delimiter choice for emphasis and strong emphasis
-#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
 pub enum EmphasisDelimiter {
     /// Asterisk delimiter (* or **)
+    #[default]
     Asterisk,
     /// Underscore delimiter (_ or __)
     Underscore,
 }
 
+impl EmphasisDelimiter {
+    fn other(self) -> Self {
+        match self {
+            EmphasisDelimiter::Asterisk => EmphasisDelimiter::Underscore,
+            EmphasisDelimiter::Underscore => EmphasisDelimiter::Asterisk,
+        }
+    }
+}
+
+impl FromStr for EmphasisDelimiter {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "*" | "asterisk" => Ok(EmphasisDelimiter::Asterisk),
+            "_" | "underscore" => Ok(EmphasisDelimiter::Underscore),
+            _ => Err(format!(
+                "unknown emphasis delimiter '{}' (expected * or _)",
+                s
+            )),
+        }
+    }
+}
+
 /// Stack frame tracking emphasis state
 #[derive(Debug, Clone)]
 pub struct EmphasisStackFrame {
@@ -36,7 +208,7 @@ pub struct EmphasisStackFrame {
 }
 
 /// Context for QMD writer, threaded through all write functions
-pub struct QmdWriterContext {
+pub struct QmdWriterContext<'a> {
     /// Accumulated error messages during writing
     pub errors: Vec<quarto_error_reporting::DiagnosticMessage>,
 
@@ -44,19 +216,69 @@ pub struct QmdWriterContext {
     /// When writing nested Emph/Strong nodes, we check this stack to
     /// choose delimiters that won't create *** sequences.
     pub emphasis_stack: Vec<EmphasisStackFrame>,
+
+    pub config: QmdConfig,
+
+    /// Width of the list and block quote prefixes of the current line
+    indent: usize,
+
+    /// Set while writing paragraph text that will be filled
+    filling: bool,
+
+    /// Depth of inlines whose spaces must not break lines (link labels)
+    no_break_depth: usize,
+
+    /// Set by `write_inlines` when the next Emph or Strong touches a word
+    /// character, where `_` delimiters don't work
+    intraword: bool,
+
+    /// Set when the next inline starts a line, where text that looks like
+    /// the start of a block must be escaped
+    line_start: bool,
+
+    /// Reference definitions to write after the document. `None` when
+    /// writing something other than a whole document, where links stay
+    /// inline since there is nowhere to put definitions.
+    references: Option<Vec<(String, Target)>>,
+
+    /// Note definitions to write after the document, like `references`
+    notes: Option<Vec<(String, Blocks)>>,
+
+    /// The text the AST was read from. Attributes whose source spans still
+    /// spell out the same attribute are copied from it verbatim, keeping
+    /// their order and quoting.
+    source: Option<&'a str>,
+
+    /// The text the front matter was read from, copied verbatim while it
+    /// still reads as the same metadata, keeping YAML comments and layout
+    front_matter_source: Option<&'a str>,
 }
 
-impl Default for QmdWriterContext {
+impl Default for QmdWriterContext<'_> {
     fn default() -> Self {
         Self::new()
     }
 }
 
-impl QmdWriterContext {
+impl QmdWriterContext<'_> {
     pub fn new() -> Self {
+        Self::with_config(QmdConfig::default())
+    }
+
+    pub fn with_config(config: QmdConfig) -> Self {
         Self {
             errors: Vec::new(),
             emphasis_stack: Vec::new(),
+            config,
+            indent: 0,
+            filling: false,
+            no_break_depth: 0,
+            intraword: false,
+            line_start: false,
+            references: None,
+            notes: None,
+            source: None,
+            front_matter_source: None,
         }
     }
 
@@ -73,26 +295,100 @@ impl QmdWriterContext {
 
     /// Choose delimiter for Emph to avoid *** ambiguity
     pub fn choose_emph_delimiter(&self) -> EmphasisDelimiter {
-        // If parent uses asterisks (regardless of whether it's Emph or Strong),
-        // use underscore to avoid creating *** sequences
-        if let Some(parent) = self.emphasis_stack.last()
-            && matches!(parent.delimiter, EmphasisDelimiter::Asterisk)
-        {
-            return EmphasisDelimiter::Underscore;
-        }
-        EmphasisDelimiter::Asterisk // Default
+        self.choose_delimiter()
     }
 
     /// Choose delimiter for Strong to avoid *** ambiguity
     pub fn choose_strong_delimiter(&self) -> EmphasisDelimiter {
-        // If parent uses asterisks (regardless of whether it's Emph or Strong),
-        // use underscore to avoid creating *** sequences
-        if let Some(parent) = self.emphasis_stack.last()
-            && matches!(parent.delimiter, EmphasisDelimiter::Asterisk)
+        self.choose_delimiter()
+    }
+
+    /// The reference label to write a link or image with, registering its
+    /// definition, or `None` to write it inline.
+    ///
+    /// The label it was read with is kept unless that label is already
+    /// defined with a different target; otherwise identical targets share
+    /// a numbered label.
+    fn reference_label(
+        &mut self,
+        target: &Target,
+        attr: &Attr,
+        read_label: Option<&str>,
+    ) -> Option<String> {
+        let use_reference = self.config.reference_links || read_label.is_some();
+        let references = self.references.as_mut()?;
+        // Attributes have no place in a reference
+        if !use_reference || !is_empty_attr(attr) {
+            return None;
+        }
+        if let Some(label) = read_label {
+            let key = normalize_label(label);
+            match references
+                .iter()
+                .find(|(existing, _)| normalize_label(existing) == key)
+            {
+                None => {
+                    references.push((label.to_string(), target.clone()));
+                    return Some(label.to_string());
+                }
+                Some((_, existing)) if existing == target => return Some(label.to_string()),
+                Some(_) => {}
+            }
+        } else if let Some((label, _)) = references.iter().find(|(_, existing)| existing == target)
         {
-            return EmphasisDelimiter::Underscore;
+            return Some(label.clone());
+        }
+        let label = (1..).map(|n| n.to_string()).find(|n| {
+            !references
+                .iter()
+                .any(|(label, _)| normalize_label(label) == *n)
+        })?;
+        references.push((label.clone(), target.clone()));
+        Some(label)
+    }
+
+    /// The identifier to write a note as `[^id]` with, registering its
+    /// definition, or `None` to write it inline as `^[...]`.
+    ///
+    /// Notes read from a `[^id]` reference keep their identifier, with a
+    /// suffix if another note already has it; notes that can't be written
+    /// inline get a numbered one.
+    fn note_label(&mut self, id: Option<&str>, content: &Blocks) -> Option<String> {
+        let notes = self.notes.as_mut()?;
+        if id.is_none() && fits_inline(content) {
+            return None;
+        }
+        let label = (1..)
+            .map(|n| match id {
+                Some(id) if n == 1 => id.to_string(),
+                Some(id) => format!("{}-{}", id, n),
+                None => n.to_string(),
+            })
+            .find(
+                |label| match notes.iter().find(|(existing, _)| existing == label) {
+                    None => true,
+                    // The same note referenced twice shares its definition
+                    Some((_, existing)) => id.is_some() && existing == content,
+                },
+            )?;
+        if !notes.iter().any(|(existing, _)| *existing == label) {
+            notes.push((label.clone(), content.clone()));
+        }
+        Some(label)
+    }
+
+    fn choose_delimiter(&self) -> EmphasisDelimiter {
+        let preferred = if self.intraword {
+            EmphasisDelimiter::Asterisk
+        } else {
+            self.config.emphasis
+        };
+        // If the parent uses the same delimiter (regardless of whether it's
+        // Emph or Strong), switch to avoid creating *** sequences
+        match self.emphasis_stack.last() {
+            Some(parent) if parent.delimiter == preferred => preferred.other(),
+            _ => preferred,
         }
-        EmphasisDelimiter::Asterisk // Default
     }
 }
 
@@ -136,14 +432,16 @@ struct BulletListContext<'a, W: Write + ?Sized> {
     inner: &'a mut W,
     at_line_start: bool,
     is_first_line: bool,
+    marker: char,
 }
 
 impl<'a, W: Write + ?Sized> BulletListContext<'a, W> {
-    fn new(inner: &'a mut W) -> Self {
+    fn new(inner: &'a mut W, marker: char) -> Self {
         Self {
             inner,
             at_line_start: true,
             is_first_line: true,
+            marker,
         }
     }
 }
@@ -154,7 +452,7 @@ impl<'a, W: Write + ?Sized> Write for BulletListContext<'a, W> {
         for &byte in buf {
             if self.at_line_start {
                 if self.is_first_line {
-                    self.inner.write_all(b"* ")?;
+                    write!(self.inner, "{} ", self.marker)?;
                     self.is_first_line = false;
                 } else {
                     self.inner.write_all(b"  ")?;
@@ -175,6 +473,50 @@ impl<'a, W: Write + ?Sized> Write for BulletListContext<'a, W> {
     }
 }
 
+struct DefinitionContext<'a, W: Write + ?Sized> {
+    inner: &'a mut W,
+    at_line_start: bool,
+    is_first_line: bool,
+}
+
+impl<'a, W: Write + ?Sized> DefinitionContext<'a, W> {
+    fn new(inner: &'a mut W) -> Self {
+        Self {
+            inner,
+            at_line_start: true,
+            is_first_line: true,
+        }
+    }
+}
+
+impl<'a, W: Write + ?Sized> Write for DefinitionContext<'a, W> {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        let mut written = 0;
+        for &byte in buf {
+            if self.at_line_start {
+                if self.is_first_line {
+                    self.inner.write_all(b":   ")?;
+                    self.is_first_line = false;
+                } else if byte != b'\n' {
+                    // Blank lines between blocks are left empty
+                    self.inner.write_all(b"    ")?;
+                }
+                self.at_line_start = false;
+            }
+            self.inner.write_all(&[byte])?;
+            written += 1;
+            if byte == b'\n' {
+                self.at_line_start = true;
+            }
+        }
+        Ok(written)
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.inner.flush()
+    }
+}
+
 struct OrderedListContext<'a, W: Write + ?Sized> {
     inner: &'a mut W,
     at_line_start: bool,
@@ -265,9 +607,7 @@ fn config_value_to_yaml(value: &ConfigValue) -> std::io::Result<Yaml> {
             // Render inlines using the qmd writer
             let mut buffer = Vec::<u8>::new();
             let mut ctx = QmdWriterContext::new(); // Errors in metadata inlines are unexpected
-            for inline in content {
-                write_inline(inline, &mut buffer, &mut ctx)?;
-            }
+            write_inlines(content, &mut buffer, &mut ctx)?;
             // If any errors accumulated, this is truly unexpected in metadata
             if !ctx.errors.is_empty() {
                 return Err(std::io::Error::other(format!(
@@ -389,6 +729,37 @@ fn write_config_value_meta<T: std::io::Write + ?Sized>(
     }
 }
 
+/// The `---` block that `meta` was read from, if reading it again gives the
+/// same metadata.
+fn original_front_matter<'s>(meta: &ConfigValue, source: &'s str) -> Option<&'s str> {
+    // The reader points the metadata map at the YAML inside the block
+    let SourceInfo::Substring { parent, .. } = &meta.source_info else {
+        return None;
+    };
+    let SourceInfo::Original {
+        start_offset,
+        end_offset,
+        ..
+    } = parent.as_ref()
+    else {
+        return None;
+    };
+    let text = source.get(*start_offset..*end_offset)?.trim_end();
+    if !text.starts_with("---") || text.matches("---").count() < 2 {
+        return None;
+    }
+    let block = RawBlock {
+        format: "quarto_minus_metadata".to_string(),
+        text: text.to_string(),
+        source_info: parent.as_ref().clone(),
+    };
+    let reread = crate::pandoc::meta::rawblock_to_config_value(
+        &block,
+        &mut crate::utils::diagnostic_collector::DiagnosticCollector::new(),
+    );
+    super::incremental::metadata_content_eq(meta, &reread).then_some(text)
+}
+
 fn escape_quotes(s: &str) -> String {
     s.replace('\\', "\\\\").replace('"', "\\\"")
 }
@@ -423,12 +794,118 @@ fn write_attr<W: std::io::Write + ?Sized>(
     Ok(())
 }
 
+/// Write an attribute, copying it from the source text when its spans there
+/// still spell it out, so unchanged attributes keep their order and quoting.
+fn write_attr_with_source<W: std::io::Write + ?Sized>(
+    attr: &Attr,
+    attr_source: &AttrSourceInfo,
+    writer: &mut W,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    let original = ctx
+        .source
+        .and_then(|source| original_attr_text(attr, attr_source, source));
+    match original {
+        Some(text) => write!(writer, "{}", text),
+        None => write_attr(attr, writer, ctx),
+    }
+}
+
+/// The `{...}` text an attribute was read from, if every piece of `attr`
+/// has a span in `source` that still reads as that piece, in the same order.
+fn original_attr_text<'s>(
+    attr: &Attr,
+    attr_source: &AttrSourceInfo,
+    source: &'s str,
+) -> Option<&'s str> {
+    let (id, classes, keyvals) = attr;
+    if id.is_empty() != attr_source.id.is_none()
+        || classes.len() != attr_source.classes.len()
+        || keyvals.len() != attr_source.attributes.len()
+    {
+        return None;
+    }
+    let span = |info: &Option<SourceInfo>| match info {
+        Some(SourceInfo::Original {
+            start_offset,
+            end_offset,
+            ..
+        }) => source
+            .get(*start_offset..*end_offset)
+            .map(|text| (*start_offset..*end_offset, text)),
+        _ => None,
+    };
+
+    let mut id_ranges = Vec::new();
+    if !id.is_empty() {
+        let (range, text) = span(&attr_source.id)?;
+        if text.strip_prefix('#') != Some(id.as_str()) {
+            return None;
+        }
+        id_ranges.push(range);
+    }
+    let mut class_ranges = Vec::new();
+    for (class, info) in classes.iter().zip(&attr_source.classes) {
+        let (range, text) = span(info)?;
+        if text.strip_prefix('.') != Some(class.as_str()) {
+            return None;
+        }
+        class_ranges.push(range);
+    }
+    let mut keyval_ranges = Vec::new();
+    for ((key, value), (key_info, value_info)) in keyvals.iter().zip(&attr_source.attributes) {
+        let (key_range, key_text) = span(key_info)?;
+        let (value_range, value_text) = span(value_info)?;
+        if key_text.trim() != key
+            || extract_quoted_text(value_text) != *value
+            || source.get(key_range.end..value_range.start)?.trim() != "="
+        {
+            return None;
+        }
+        keyval_ranges.push(key_range.start..value_range.end);
+    }
+
+    // Classes and key-value pairs keep their relative order in the AST, so
+    // their spans must be increasing; ids may sit anywhere among them
+    let increasing = |ranges: &[Range<usize>]| ranges.windows(2).all(|w| w[0].end <= w[1].start);
+    if !increasing(&class_ranges) || !increasing(&keyval_ranges) {
+        return None;
+    }
+    let mut pieces: Vec<Range<usize>> = id_ranges
+        .into_iter()
+        .chain(class_ranges)
+        .chain(keyval_ranges)
+        .collect();
+    pieces.sort_by_key(|range| range.start);
+
+    // Only whitespace may separate the pieces from each other and the braces
+    let first = pieces.first()?.start;
+    let last = pieces.last()?.end;
+    for pair in pieces.windows(2) {
+        if !source.get(pair[0].end..pair[1].start)?.trim().is_empty() {
+            return None;
+        }
+    }
+    let open = source[..first].trim_end().strip_suffix('{')?.len();
+    let after = &source[last..];
+    let close = last + (after.len() - after.trim_start().len());
+    if !source[close..].starts_with('}') {
+        return None;
+    }
+    let text = &source[open..=close];
+    if text.contains('\n') {
+        return None;
+    }
+    Some(text)
+}
+
 fn write_blockquote(
     blockquote: &BlockQuote,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     let mut blockquote_writer = BlockQuoteContext::new(buf);
+    ctx.indent += 2;
     for (i, block) in blockquote.content.iter().enumerate() {
         if i > 0 {
             // Add a blank line between blocks in the blockquote
@@ -436,6 +913,7 @@ fn write_blockquote(
         }
         write_block(block, &mut blockquote_writer, ctx)?;
     }
+    ctx.indent -= 2;
     Ok(())
 }
 
@@ -445,7 +923,7 @@ fn write_div(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(writer, "::: ")?;
-    write_attr(&div.attr, writer, ctx)?;
+    write_attr_with_source(&div.attr, &div.attr_source, writer, ctx)?;
     writeln!(writer)?;
 
     for block in div.content.iter() {
@@ -486,16 +964,13 @@ fn write_bulletlist(
 
         if is_empty_item {
             // Write "* []" for empty list items
-            writeln!(buf, "* []")?;
+            tracing::debug!("wrote empty list item as `[]`");
+            writeln!(buf, "{} []", ctx.config.bullet_marker)?;
         } else {
-            let mut item_writer = BulletListContext::new(buf);
-            for (j, block) in item.iter().enumerate() {
-                if j > 0 && !is_tight {
-                    // Add a blank line between blocks within a list item in loose lists
-                    writeln!(&mut item_writer)?;
-                }
-                write_block(block, &mut item_writer, ctx)?;
-            }
+            let mut item_writer = BulletListContext::new(buf, ctx.config.bullet_marker);
+            ctx.indent += 2;
+            write_list_item_blocks(item, is_tight, &mut item_writer, ctx)?;
+            ctx.indent -= 2;
         }
     }
     Ok(())
@@ -507,6 +982,11 @@ fn write_orderedlist(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     let (start_num, number_style, delimiter) = &orderedlist.attr;
+    let delimiter = ctx
+        .config
+        .ordered_list_delimiter
+        .as_ref()
+        .unwrap_or(delimiter);
 
     // Determine if this is a tight list
     // A list is tight if the first block of all items is Plain (not Para)
@@ -523,13 +1003,35 @@ fn write_orderedlist(
         let current_num = start_num + i;
         let mut item_writer =
             OrderedListContext::new(buf, current_num, number_style.clone(), delimiter.clone());
-        for (j, block) in item.iter().enumerate() {
-            if j > 0 && !is_tight {
-                // Add a blank line between blocks within a list item in loose lists
-                writeln!(&mut item_writer)?;
-            }
-            write_block(block, &mut item_writer, ctx)?;
+        ctx.indent += 4;
+        write_list_item_blocks(item, is_tight, &mut item_writer, ctx)?;
+        ctx.indent -= 4;
+    }
+    Ok(())
+}
+
+/// Write the blocks of a list item, after its marker. A task's checkbox is
+/// written as `[ ]` or `[x]`.
+fn write_list_item_blocks(
+    item: &[Block],
+    is_tight: bool,
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    let task = task_lists::task_item_content(item);
+    let blocks = match &task {
+        Some((checked, content)) => {
+            write!(buf, "[{}] ", if *checked { "x" } else { " " })?;
+            content.as_slice()
+        }
+        None => item,
+    };
+    for (j, block) in blocks.iter().enumerate() {
+        if j > 0 && !is_tight {
+            // Add a blank line between blocks within a list item in loose lists
+            writeln!(buf)?;
         }
+        write_block(block, buf, ctx)?;
     }
     Ok(())
 }
@@ -539,15 +1041,17 @@ fn write_header(
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    // Write the appropriate number of # symbols for the heading level
-    for _ in 0..header.level {
-        write!(buf, "#")?;
-    }
-    write!(buf, " ")?;
-
-    // Write the header content
-    for inline in &header.content {
-        write_inline(inline, buf, ctx)?;
+    let setext = ctx.config.heading_style == HeadingStyle::Setext
+        && header.level <= 2
+        && !header.content.is_empty();
+
+    // The header line after any # symbols, so that a setext underline can
+    // match its width
+    let mut line = Vec::new();
+    if setext {
+        write_line_inlines(&header.content, &mut line, ctx)?;
+    } else {
+        write_inlines(&header.content, &mut line, ctx)?;
     }
 
     // Compute the effective attr for writing: suppress auto-generated IDs.
@@ -564,11 +1068,19 @@ fn write_header(
     };
 
     if !is_empty_attr(&effective_attr) {
-        write!(buf, " ")?;
-        write_attr(&effective_attr, buf, ctx)?;
+        write!(line, " ")?;
+        write_attr_with_source(&effective_attr, &header.attr_source, &mut line, ctx)?;
     }
+    let line = String::from_utf8_lossy(&line);
 
-    writeln!(buf)?;
+    if setext {
+        let underline = if header.level == 1 { "=" } else { "-" };
+        writeln!(buf, "{}", line)?;
+        writeln!(buf, "{}", underline.repeat(display_width(&line).max(3)))?;
+    } else {
+        // Write the appropriate number of # symbols for the heading level
+        writeln!(buf, "{} {}", "#".repeat(header.level), line)?;
+    }
     Ok(())
 }
 
@@ -582,18 +1094,26 @@ fn write_cell_content(
         if i > 0 {
             write!(buf, " ")?; // Join multiple blocks with space
         }
-        write_block(block, buf, ctx)?;
+        match block {
+            // Cell text is on the row's line, after the `|`, where it can't
+            // start a block
+            Block::Plain(Plain { content, .. }) | Block::Paragraph(Paragraph { content, .. }) => {
+                write_inlines(content, buf, ctx)?
+            }
+            _ => write_block(block, buf, ctx)?,
+        }
     }
     Ok(())
 }
 
-fn get_alignment_char(alignment: &Alignment) -> char {
-    match alignment {
-        Alignment::Left => ':',
-        Alignment::Center => ':',
-        Alignment::Right => ':',
-        Alignment::Default => '-',
-    }
+/// Whether a code block reads back the same when indented instead of
+/// fenced. Indented code has no attributes, and its leading and trailing
+/// blank lines are dropped.
+fn can_indent(codeblock: &CodeBlock) -> bool {
+    let mut lines = codeblock.text.lines();
+    is_empty_attr(&codeblock.attr)
+        && lines.next().is_some_and(|line| !line.trim().is_empty())
+        && lines.last().is_none_or(|line| !line.trim().is_empty())
 }
 
 fn write_codeblock(
@@ -601,6 +1121,17 @@ fn write_codeblock(
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
+    if ctx.config.code_block_style == CodeBlockStyle::Indented && can_indent(codeblock) {
+        for line in codeblock.text.lines() {
+            if line.is_empty() {
+                writeln!(buf)?;
+            } else {
+                writeln!(buf, "    {}", line)?;
+            }
+        }
+        return Ok(());
+    }
+
     // Determine the number of backticks needed
     // Use at least 3, but more if the content contains backticks
     let fence = determine_backticks(&codeblock.text);
@@ -618,6 +1149,23 @@ fn write_codeblock(
     if classes.len() == 1 && id.is_empty() && keyvals.is_empty() {
         // Single class, no other attributes: write as bare word
         write!(buf, "{}", classes[0])?;
+    } else if let Some(language) = classes
+        .first()
+        .and_then(|class| class.strip_prefix('{'))
+        .and_then(|class| class.strip_suffix('}'))
+    {
+        // Executable block with attributes: the language leads the braces,
+        // as in {python #id key="value"}
+        let rest = (id.clone(), classes[1..].to_vec(), keyvals.clone());
+        let mut attr_text = Vec::new();
+        write_attr(&rest, &mut attr_text, ctx)?;
+        let attr_text = String::from_utf8_lossy(&attr_text);
+        let inner = &attr_text[1..attr_text.len() - 1];
+        if inner.is_empty() {
+            write!(buf, "{{{}}}", language)?;
+        } else {
+            write!(buf, "{{{} {}}}", language, inner)?;
+        }
     } else if !id.is_empty() || !classes.is_empty() || !keyvals.is_empty() {
         // Has attributes: write full attribute block (no space before it)
         write_attr(&codeblock.attr, buf, ctx)?;
@@ -651,9 +1199,29 @@ fn write_lineblock(
         if i > 0 {
             writeln!(buf)?;
         }
+        if line.is_empty() {
+            write!(buf, "|")?;
+            continue;
+        }
         write!(buf, "| ")?;
-        for inline in line {
-            write_inline(inline, buf, ctx)?;
+        // The reader keeps a line's indentation as non-breaking spaces: write
+        // them back as spaces
+        match line.first() {
+            Some(Inline::Str(s)) if s.text.starts_with('\u{a0}') => {
+                let text = s.text.trim_start_matches('\u{a0}');
+                let indent = s.text[..s.text.len() - text.len()].chars().count();
+                write!(buf, "{}", " ".repeat(indent))?;
+                let mut rest = line.clone();
+                rest[0] = Inline::Str(Str {
+                    text: text.to_string(),
+                    source_info: s.source_info.clone(),
+                });
+                if text.is_empty() {
+                    rest.remove(0);
+                }
+                write_inlines(&rest, buf, ctx)?;
+            }
+            _ => write_inlines(line, buf, ctx)?,
         }
     }
     writeln!(buf)?;
@@ -666,12 +1234,13 @@ fn write_rawblock(rawblock: &RawBlock, buf: &mut dyn std::io::Write) -> std::io:
         write!(buf, "{}", rawblock.text)?;
     } else {
         // For other formats, use fenced raw block notation
-        writeln!(buf, "```{{{}}}", rawblock.format)?;
+        let fence = "`".repeat(determine_backticks(&rawblock.text).len().max(3));
+        writeln!(buf, "{}{{={}}}", fence, rawblock.format)?;
         write!(buf, "{}", rawblock.text)?;
         if !rawblock.text.ends_with('\n') {
             writeln!(buf)?;
         }
-        writeln!(buf, "```")?;
+        writeln!(buf, "{}", fence)?;
     }
     Ok(())
 }
@@ -689,22 +1258,30 @@ fn write_definitionlist(
             writeln!(buf)?;
         }
 
-        // Write the term
-        for inline in term {
-            write_inline(inline, buf, ctx)?;
-        }
+        // An item is tight if the first block of all its definitions is
+        // Plain; loose definitions follow a blank line
+        let is_tight = definitions
+            .iter()
+            .all(|definition| matches!(definition.first(), Some(Block::Plain(_))));
+
+        write_line_inlines(term, buf, ctx)?;
         writeln!(buf)?;
 
-        // Write the definitions
-        for definition in definitions {
-            write!(buf, ":   ")?;
-            for (j, block) in definition.iter().enumerate() {
-                if j > 0 {
-                    writeln!(buf)?;
-                    write!(buf, "    ")?; // Indent subsequent blocks in definition
+        for (j, definition) in definitions.iter().enumerate() {
+            // A definition right after an indented block would read as part
+            // of that block, so it is separated too
+            if !is_tight || (j > 0 && definitions[j - 1].len() > 1) {
+                writeln!(buf)?;
+            }
+            let mut definition_writer = DefinitionContext::new(buf);
+            ctx.indent += 4;
+            for (k, block) in definition.iter().enumerate() {
+                if k > 0 {
+                    writeln!(&mut definition_writer)?;
                 }
-                write_block(block, buf, ctx)?;
+                write_block(block, &mut definition_writer, ctx)?;
             }
+            ctx.indent -= 4;
         }
     }
     Ok(())
@@ -750,9 +1327,7 @@ fn write_figure(
     {
         writeln!(buf)?;
         // Convert short caption (inlines) to a paragraph for consistency
-        for inline in short_caption {
-            write_inline(inline, buf, ctx)?;
-        }
+        write_inlines(short_caption, buf, ctx)?;
         writeln!(buf)?;
     }
 
@@ -770,25 +1345,25 @@ fn write_metablock(
 }
 
 fn write_inlinerefdef(
-    refdef: &crate::pandoc::block::NoteDefinitionPara,
+    id: &str,
+    content: &[Inline],
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    write!(buf, "[^{}]: ", refdef.id)?;
-    for inline in &refdef.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write!(buf, "[^{}]: ", id)?;
+    write_inlines(content, buf, ctx)?;
     writeln!(buf)?;
     Ok(())
 }
 
 fn write_fenced_note_definition(
-    refdef: &crate::pandoc::block::NoteDefinitionFencedBlock,
+    id: &str,
+    content: &[Block],
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    writeln!(buf, "::: ^{}", refdef.id)?;
-    for (i, block) in refdef.content.iter().enumerate() {
+    writeln!(buf, "::: ^{}", id)?;
+    for (i, block) in content.iter().enumerate() {
         if i > 0 {
             // Add a blank line between blocks
             writeln!(buf)?;
@@ -1026,14 +1601,10 @@ fn write_list_table(
                 if cell.content.len() == 1 {
                     match &cell.content[0] {
                         Block::Plain(plain) => {
-                            for inline in &plain.content {
-                                write_inline(inline, buf, ctx)?;
-                            }
+                            write_inlines(&plain.content, buf, ctx)?;
                         }
                         Block::Paragraph(para) => {
-                            for inline in &para.content {
-                                write_inline(inline, buf, ctx)?;
-                            }
+                            write_inlines(&para.content, buf, ctx)?;
                         }
                         other => {
                             writeln!(buf)?;
@@ -1070,17 +1641,25 @@ fn write_list_table(
 }
 
 // INCREMENTAL WRITER COUPLING: This is the sugar decision point for tables. It chooses
-// between pipe table format and list-table div format. The incremental writer always fully
+// between pipe table, grid table and list-table div format. The incremental writer always fully
 // rewrites Table blocks (never incrementally splices them), so format changes between
-// pipe and list-table on rewrite are acceptable (canonicalization, not a bug).
+// pipe, grid and list-table on rewrite are acceptable (canonicalization, not a bug).
 // See: postprocess.rs transform registry.
 fn write_table(
     table: &Table,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    // Use list-table format if the table has features that pipe tables don't support
-    if !table_can_use_pipe_format(table) {
+    // Pipe and grid tables write attributes on their caption line
+    if !is_empty_attr(&table.attr) && !has_table_caption(table) {
+        return write_list_table(table, buf, ctx);
+    }
+    // Use grid table format for block content and footers, and list-table
+    // format for the features grid tables don't support either
+    if !table_can_use_pipe_format(table) || !table.foot.rows.is_empty() {
+        if table_can_use_grid_format(table) {
+            return write_grid_table(table, buf, ctx);
+        }
         return write_list_table(table, buf, ctx);
     }
 
@@ -1108,7 +1687,7 @@ fn write_table(
 
     // Extract cell contents as strings for each row
     let mut row_contents: Vec<Vec<String>> = Vec::new();
-    let mut max_widths = vec![0; num_cols];
+    let mut content_widths = vec![0; num_cols];
 
     for row in &all_rows {
         let mut cell_strings = Vec::new();
@@ -1119,9 +1698,7 @@ fn write_table(
                 .map_err(|e| std::io::Error::new(std::io::ErrorKind::InvalidData, e))?;
             let content = content.trim().to_string();
 
-            if content.len() > max_widths[i] {
-                max_widths[i] = content.len();
-            }
+            content_widths[i] = content_widths[i].max(content.width());
             cell_strings.push(content);
         }
         // Pad to num_cols if needed
@@ -1131,153 +1708,406 @@ fn write_table(
         row_contents.push(cell_strings);
     }
 
-    // Ensure minimum width of 3 for each column
-    for width in &mut max_widths {
-        if *width < 3 {
-            *width = 3;
-        }
+    // Unpadded, the delimiter row only carries the relative widths
+    let pad = ctx.config.pad_tables;
+    if !pad {
+        content_widths.fill(0);
     }
-
-    // Write header row (first row)
-    if !row_contents.is_empty() {
+    let widths = pipe_column_widths(table, &content_widths);
+    let alignments: Vec<&Alignment> = table.colspec.iter().map(|(a, _)| a).collect();
+    let write_row = |buf: &mut dyn std::io::Write, row: &[String]| -> std::io::Result<()> {
         write!(buf, "|")?;
-        for (i, content) in row_contents[0].iter().enumerate() {
-            write!(buf, " {:width$} |", content, width = max_widths[i])?;
+        for (i, content) in row.iter().enumerate() {
+            if pad {
+                write!(buf, " {} |", pad_cell(content, widths[i], alignments[i]))?;
+            } else {
+                write!(buf, " {} |", content)?;
+            }
         }
-        writeln!(buf)?;
+        writeln!(buf)
+    };
 
-        // Write separator line
-        write!(buf, "|")?;
-        for (i, colspec) in table.colspec.iter().enumerate().take(num_cols) {
-            let _align_char = get_alignment_char(&colspec.0);
-            let sep = match colspec.0 {
-                Alignment::Left => format!(":{}", "-".repeat(max_widths[i] - 1)),
-                Alignment::Center => format!(":{}:", "-".repeat(max_widths[i] - 2)),
-                Alignment::Right => format!("{}:", "-".repeat(max_widths[i] - 1)),
-                Alignment::Default => "-".repeat(max_widths[i]),
-            };
-            write!(buf, " {} |", sep)?;
-        }
-        writeln!(buf)?;
+    // Write header row (first row)
+    write_row(buf, &row_contents[0])?;
+
+    // Write separator line
+    write!(buf, "|")?;
+    for (i, (alignment, _)) in table.colspec.iter().enumerate().take(num_cols) {
+        let sep = match alignment {
+            Alignment::Left => format!(":{}", "-".repeat(widths[i] - 1)),
+            Alignment::Center => format!(":{}:", "-".repeat(widths[i] - 2)),
+            Alignment::Right => format!("{}:", "-".repeat(widths[i] - 1)),
+            Alignment::Default => "-".repeat(widths[i]),
+        };
+        write!(buf, " {} |", sep)?;
+    }
+    writeln!(buf)?;
 
-        // Write body rows (skip first row which is header)
-        for row_content in row_contents.iter().skip(1) {
-            write!(buf, "|")?;
-            for (i, content) in row_content.iter().enumerate() {
-                write!(buf, " {:width$} |", content, width = max_widths[i])?;
-            }
-            writeln!(buf)?;
-        }
+    // Write body rows (skip first row which is header)
+    for row_content in row_contents.iter().skip(1) {
+        write_row(buf, row_content)?;
     }
 
-    // Write caption if it exists
+    write_table_caption(table, buf, ctx)
+}
+
+fn has_table_caption(table: &Table) -> bool {
+    table
+        .caption
+        .long
+        .as_ref()
+        .is_some_and(|long_caption| !long_caption.is_empty())
+}
+
+/// Write a pipe or grid table's caption as `: caption` lines after it, with
+/// the table's attributes at the end of the last line
+fn write_table_caption(
+    table: &Table,
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
     if let Some(ref long_caption) = table.caption.long
         && !long_caption.is_empty()
     {
         writeln!(buf)?; // Blank line before caption
-        for block in long_caption {
+        let caption_lines: Vec<&Vec<Inline>> = long_caption
+            .iter()
             // Extract inline content from Plain or Paragraph blocks in caption
-            let content = match block {
+            .filter_map(|block| match block {
                 Block::Plain(plain) => Some(&plain.content),
                 Block::Paragraph(para) => Some(&para.content),
                 _ => None,
-            };
-            if let Some(inlines) = content {
-                write!(buf, ": ")?;
-                for inline in inlines {
-                    write_inline(inline, buf, ctx)?;
-                }
-                writeln!(buf)?;
+            })
+            .collect();
+        for (i, inlines) in caption_lines.iter().enumerate() {
+            write!(buf, ": ")?;
+            write_inlines(inlines, buf, ctx)?;
+            if i == caption_lines.len() - 1 && !is_empty_attr(&table.attr) {
+                write!(buf, " ")?;
+                write_attr(&table.attr, buf, ctx)?;
             }
+            writeln!(buf)?;
         }
     }
 
     Ok(())
 }
 
-fn determine_backticks(text: &str) -> String {
-    // Find the longest sequence of consecutive backticks in the text
-    let mut max_backticks = 0;
-    let mut current_backticks = 0;
-
-    for ch in text.chars() {
-        if ch == '`' {
-            current_backticks += 1;
-            max_backticks = max_backticks.max(current_backticks);
-        } else {
-            current_backticks = 0;
-        }
-    }
-
-    // Use one more backtick than the longest sequence found
-    "`".repeat(max_backticks + 1)
-}
-
-// Helper function to reverse smart quotes conversion
-// Converts Unicode right single quotation mark (') back to ASCII apostrophe (')
-fn reverse_smart_quotes(text: &str) -> String {
-    text.replace('\u{2019}', "'")
+/// Check if a table can be written in grid table format: cells may hold
+/// any blocks, but there are no spans, a footer needs a header, and every
+/// row has a cell for each column.
+fn table_can_use_grid_format(table: &Table) -> bool {
+    let num_cols = table.colspec.len();
+    let rows_fit = |rows: &[Row]| {
+        rows.iter().all(|row| {
+            row.cells.len() == num_cols
+                && row
+                    .cells
+                    .iter()
+                    .all(|cell| cell.row_span <= 1 && cell.col_span <= 1)
+        })
+    };
+    num_cols > 0
+        && rows_fit(&table.head.rows)
+        && rows_fit(&table.foot.rows)
+        && (table.foot.rows.is_empty() || !table.head.rows.is_empty())
+        && table
+            .bodies
+            .iter()
+            .all(|body| body.head.is_empty() && rows_fit(&body.body))
 }
 
-// Helper function to escape special markdown characters
-// This follows Pandoc's escaping strategy: escape characters that have special
-// markdown meaning to ensure proper roundtripping (qmd -> AST -> qmd).
-// We escape characters defensively when they could trigger markdown syntax.
-fn escape_markdown(text: &str) -> String {
-    let mut result = String::new();
-    for ch in text.chars() {
-        match ch {
-            // Characters that must be escaped to avoid triggering markdown syntax:
-            '\\' => result.push_str("\\\\"), // Escape character itself
-            '<' => result.push_str("\\<"),   // HTML tags, autolinks
-            '>' => result.push_str("\\>"),   // Blockquotes (at line start)
-            '#' => result.push_str("\\#"),   // Headers (at line start)
-            '$' => result.push_str("\\$"),   // Math delimiters
-            '*' => result.push_str("\\*"),   // Emphasis, strong, lists
-            '_' => result.push_str("\\_"),   // Emphasis, strong
-            '[' => result.push_str("\\["),   // Links
-            ']' => result.push_str("\\]"),   // Links
-            '`' => result.push_str("\\`"),   // Code spans
-            '|' => result.push_str("\\|"),   // Tables
-            '~' => result.push_str("\\~"),   // Subscript, strikeout
-            '^' => result.push_str("\\^"),   // Superscript
-
-            // Characters that don't need escaping in most contexts:
-            // . , - + ! ? @ = : ; / ( ) { } % & ' "
-            // These are only special in very specific contexts and escaping them
-            // everywhere would make output unnecessarily verbose.
-            _ => result.push(ch),
+/// Column widths of a pipe table: the length of each delimiter cell, and
+/// of the cells below it when they are padded.
+///
+/// The reader gives the columns of a pipe table wider than a 72-column
+/// page widths relative to the lengths of its delimiter cells. When every
+/// column has a relative width, this looks for delimiter cells in those
+/// proportions that fit the content, starting from the page width, so
+/// that a padded table is wide enough to read back the same widths.
+fn pipe_column_widths(table: &Table, content_widths: &[usize]) -> Vec<usize> {
+    let minimums: Vec<usize> = content_widths.iter().map(|width| (*width).max(3)).collect();
+    let fractions: Option<Vec<f64>> = table
+        .colspec
+        .iter()
+        .map(|(_, width)| match width {
+            ColWidth::Percentage(fraction) if *fraction > 0.0 => Some(*fraction),
+            _ => None,
+        })
+        .collect();
+    let Some(fractions) = fractions else {
+        return minimums;
+    };
+    for total in PIPE_TABLE_COLUMNS..=PIPE_TABLE_COLUMNS * 16 {
+        let lengths: Vec<f64> = fractions.iter().map(|f| f * total as f64).collect();
+        if lengths
+            .iter()
+            .any(|length| (length - length.round()).abs() > 1e-6)
+        {
+            continue;
+        }
+        let lengths: Vec<usize> = lengths
+            .iter()
+            .map(|length| length.round() as usize)
+            .collect();
+        if lengths.iter().sum::<usize>() == total
+            && lengths
+                .iter()
+                .zip(&minimums)
+                .all(|(length, min)| length >= min)
+        {
+            return lengths;
         }
     }
-    result
+    // Otherwise the nearest lengths that fit
+    let total = fractions
+        .iter()
+        .zip(&minimums)
+        .map(|(f, min)| (*min as f64 / f).ceil() as usize)
+        .fold(PIPE_TABLE_COLUMNS, usize::max);
+    fractions
+        .iter()
+        .zip(&minimums)
+        .map(|(f, min)| ((f * total as f64).round() as usize).max(*min))
+        .collect()
+}
+
+/// Pad a pipe table cell to `width` columns, on the side its column's
+/// alignment leaves empty.
+fn pad_cell(content: &str, width: usize, alignment: &Alignment) -> String {
+    let padding = width.saturating_sub(content.width());
+    let (left, right) = match alignment {
+        Alignment::Right => (padding, 0),
+        Alignment::Center => (padding / 2, padding - padding / 2),
+        Alignment::Left | Alignment::Default => (0, padding),
+    };
+    format!("{}{}{}", " ".repeat(left), content, " ".repeat(right))
+}
+
+/// Column widths of a grid table, in characters between `| ` and ` |`.
+///
+/// The reader gives each column its width between `+` relative to a
+/// 72-column page, or to the table when it is wider. When every column has
+/// a relative width, this looks for the page that makes those widths whole
+/// numbers of characters and fits the content, so they read back the same.
+fn grid_column_widths(table: &Table, content_widths: &[usize]) -> Vec<usize> {
+    let fractions: Option<Vec<f64>> = table
+        .colspec
+        .iter()
+        .map(|(_, width)| match width {
+            ColWidth::Percentage(fraction) if *fraction > 0.0 => Some(*fraction),
+            _ => None,
+        })
+        .collect();
+    let Some(fractions) = fractions else {
+        return content_widths.iter().map(|width| (*width).max(3)).collect();
+    };
+    // Width between `+` needed for the content and its padding
+    let minimums: Vec<usize> = content_widths.iter().map(|width| width + 3).collect();
+    for page in GRID_TABLE_COLUMNS..=GRID_TABLE_COLUMNS * 16 {
+        let spans: Vec<f64> = fractions.iter().map(|f| f * page as f64).collect();
+        if spans.iter().any(|span| (span - span.round()).abs() > 1e-6) {
+            continue;
+        }
+        let spans: Vec<usize> = spans.iter().map(|span| span.round() as usize).collect();
+        let total: usize = spans.iter().sum();
+        let reads_back = total == page || (page == GRID_TABLE_COLUMNS && total < page);
+        if reads_back && spans.iter().zip(&minimums).all(|(span, min)| span >= min) {
+            return spans.iter().map(|span| span - 3).collect();
+        }
+    }
+    // Otherwise the nearest widths that fit
+    let page = fractions
+        .iter()
+        .zip(&minimums)
+        .map(|(f, min)| (*min as f64 / f).ceil() as usize)
+        .fold(GRID_TABLE_COLUMNS, usize::max);
+    fractions
+        .iter()
+        .zip(&minimums)
+        .map(|(f, min)| ((f * page as f64).round() as usize).max(*min) - 3)
+        .collect()
+}
+
+/// A grid table border: `-` between rows, `=` after the header, with
+/// alignment colons when `alignments` is given
+fn grid_table_border(widths: &[usize], fill: char, alignments: Option<&[Alignment]>) -> String {
+    let mut border = String::from("+");
+    for (i, width) in widths.iter().enumerate() {
+        let mut segment: Vec<char> = std::iter::repeat_n(fill, width + 2).collect();
+        let alignment = alignments.map_or(&Alignment::Default, |alignments| &alignments[i]);
+        if matches!(alignment, Alignment::Left | Alignment::Center) {
+            segment[0] = ':';
+        }
+        if matches!(alignment, Alignment::Right | Alignment::Center) {
+            segment[width + 1] = ':';
+        }
+        border.extend(segment);
+        border.push('+');
+    }
+    border
+}
+
+/// The lines of each cell of each row, with blocks separated by blank lines
+fn grid_cell_lines(
+    rows: &[&Row],
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<Vec<Vec<Vec<String>>>> {
+    let mut cell_lines = Vec::new();
+    for row in rows {
+        let mut row_lines = Vec::new();
+        for cell in &row.cells {
+            let mut buffer = Vec::<u8>::new();
+            for (i, block) in cell.content.iter().enumerate() {
+                if i > 0 {
+                    writeln!(buffer)?;
+                }
+                write_block(block, &mut buffer, ctx)?;
+            }
+            let lines: Vec<String> = String::from_utf8_lossy(&buffer)
+                .lines()
+                .map(|line| line.trim_end().to_string())
+                .collect();
+            row_lines.push(lines);
+        }
+        cell_lines.push(row_lines);
+    }
+    Ok(cell_lines)
+}
+
+/// Write a table as a grid table, for cells with block content.
+///
+/// Each cell's blocks are written on their own and laid out line by line,
+/// so the reader reads them back as qmd.
+fn write_grid_table(
+    table: &Table,
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    let num_cols = table.colspec.len();
+    let head_rows = table.head.rows.len();
+    let body_rows: usize = table.bodies.iter().map(|body| body.body.len()).sum();
+    let rows: Vec<&Row> = table
+        .head
+        .rows
+        .iter()
+        .chain(table.bodies.iter().flat_map(|body| body.body.iter()))
+        .chain(table.foot.rows.iter())
+        .collect();
+
+    // Cells are laid out by the table, not filled to the page width
+    let wrap = ctx.config.wrap;
+    if wrap == WrapMode::Auto {
+        ctx.config.wrap = WrapMode::Preserve;
+    }
+    let cell_lines = grid_cell_lines(&rows, ctx);
+    ctx.config.wrap = wrap;
+    let cell_lines = cell_lines?;
+
+    let mut content_widths = vec![0; num_cols];
+    for row_lines in &cell_lines {
+        for (i, lines) in row_lines.iter().enumerate() {
+            for line in lines {
+                content_widths[i] = content_widths[i].max(line.chars().count());
+            }
+        }
+    }
+    let widths = grid_column_widths(table, &content_widths);
+
+    let alignments: Vec<Alignment> = table.colspec.iter().map(|(a, _)| a.clone()).collect();
+    let has_header = head_rows > 0;
+    writeln!(
+        buf,
+        "{}",
+        grid_table_border(&widths, '-', (!has_header).then_some(&alignments[..]))
+    )?;
+    for (row_index, row_lines) in cell_lines.iter().enumerate() {
+        let height = row_lines.iter().map(Vec::len).max().unwrap_or(0).max(1);
+        for line_index in 0..height {
+            write!(buf, "|")?;
+            for (i, lines) in row_lines.iter().enumerate() {
+                let line = lines.get(line_index).map_or("", String::as_str);
+                write!(buf, " {:width$} |", line, width = widths[i])?;
+            }
+            writeln!(buf)?;
+        }
+        // `=` borders end the header and surround the footer
+        let row_number = row_index + 1;
+        let has_footer = !table.foot.rows.is_empty();
+        let border = if has_header && row_number == head_rows {
+            grid_table_border(&widths, '=', Some(&alignments))
+        } else if has_footer && (row_number == head_rows + body_rows || row_number == rows.len()) {
+            grid_table_border(&widths, '=', None)
+        } else {
+            grid_table_border(&widths, '-', None)
+        };
+        writeln!(buf, "{}", border)?;
+    }
+
+    write_table_caption(table, buf, ctx)
+}
+
+fn determine_backticks(text: &str) -> String {
+    // Find the longest sequence of consecutive backticks in the text
+    let mut max_backticks = 0;
+    let mut current_backticks = 0;
+
+    for ch in text.chars() {
+        if ch == '`' {
+            current_backticks += 1;
+            max_backticks = max_backticks.max(current_backticks);
+        } else {
+            current_backticks = 0;
+        }
+    }
+
+    // Use one more backtick than the longest sequence found
+    "`".repeat(max_backticks + 1)
 }
 
 fn write_str(
     s: &Str,
+    line_start: bool,
     buf: &mut dyn std::io::Write,
-    _ctx: &mut QmdWriterContext,
+    ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    let text = reverse_smart_quotes(&s.text);
-    let escaped = escape_markdown(&text);
+    let escaped = qmd_escape::escape_str(&s.text, line_start, &ctx.config);
     write!(buf, "{}", escaped)
 }
 
+fn write_breakable_space(
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    if ctx.filling && ctx.no_break_depth == 0 {
+        write!(buf, "{}", BREAK_OPPORTUNITY)
+    } else {
+        write!(buf, " ")
+    }
+}
+
 fn write_space(
     _: &crate::pandoc::Space,
     buf: &mut dyn std::io::Write,
-    _ctx: &mut QmdWriterContext,
+    ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    write!(buf, " ")
+    write_breakable_space(buf, ctx)
 }
 
 fn write_soft_break(
     _: &crate::pandoc::SoftBreak,
     buf: &mut dyn std::io::Write,
-    _ctx: &mut QmdWriterContext,
+    ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    // Pandoc's writer for markdown outputs a space for soft breaks
-    // We choose to deviate from Pandoc for roundtripping purposes
-    writeln!(buf)
+    match ctx.config.wrap {
+        // Pandoc's writer for markdown outputs a space for soft breaks
+        // We choose to deviate from Pandoc for roundtripping purposes
+        WrapMode::Preserve => {
+            ctx.line_start = true;
+            writeln!(buf)
+        }
+        WrapMode::None | WrapMode::Auto => write_breakable_space(buf, ctx),
+    }
 }
 
 fn write_emph(
@@ -1287,6 +2117,7 @@ fn write_emph(
 ) -> std::io::Result<()> {
     // Choose delimiter based on parent emphasis to avoid *** ambiguity
     let delimiter = ctx.choose_emph_delimiter();
+    ctx.intraword = false;
     let delim_str = match delimiter {
         EmphasisDelimiter::Asterisk => "*",
         EmphasisDelimiter::Underscore => "_",
@@ -1295,9 +2126,7 @@ fn write_emph(
     write!(buf, "{}", delim_str)?;
     ctx.push_emphasis(delimiter, false);
 
-    for inline in &emph.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&emph.content, buf, ctx)?;
 
     ctx.pop_emphasis();
     write!(buf, "{}", delim_str)
@@ -1310,6 +2139,7 @@ fn write_strong(
 ) -> std::io::Result<()> {
     // Choose delimiter based on parent emphasis to avoid *** ambiguity
     let delimiter = ctx.choose_strong_delimiter();
+    ctx.intraword = false;
     let delim_str = match delimiter {
         EmphasisDelimiter::Asterisk => "**",
         EmphasisDelimiter::Underscore => "__",
@@ -1318,9 +2148,7 @@ fn write_strong(
     write!(buf, "{}", delim_str)?;
     ctx.push_emphasis(delimiter, true);
 
-    for inline in &strong.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&strong.content, buf, ctx)?;
 
     ctx.pop_emphasis();
     write!(buf, "{}", delim_str)
@@ -1351,9 +2179,10 @@ fn write_code(
 fn write_linebreak(
     _line_break: &crate::pandoc::LineBreak,
     buf: &mut dyn std::io::Write,
-    _ctx: &mut QmdWriterContext,
+    ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "\\")?;
+    ctx.line_start = true;
     writeln!(buf)
 }
 
@@ -1394,10 +2223,18 @@ fn write_link(
     if let Some(anchor_id) = anchor_shorthand_id(link) {
         return write!(buf, "<#{}>", anchor_id);
     }
-    write!(buf, "[")?;
-    for inline in &link.content {
-        write_inline(inline, buf, ctx)?;
+    if let Some(label) = ctx.reference_label(
+        &link.target,
+        &link.attr,
+        link.target_source.reference_label.as_deref(),
+    ) {
+        write!(buf, "[")?;
+        return write_reference(&link.content, &label, buf, ctx);
     }
+    write!(buf, "[")?;
+    ctx.no_break_depth += 1;
+    write_inlines(&link.content, buf, ctx)?;
+    ctx.no_break_depth -= 1;
     write!(buf, "](")?;
     write!(buf, "{}", link.target.0)?;
     if !link.target.1.is_empty() {
@@ -1410,15 +2247,73 @@ fn write_link(
     Ok(())
 }
 
+/// Case-insensitive, whitespace-collapsed form of a reference label, as
+/// CommonMark matches them
+fn normalize_label(label: &str) -> String {
+    label
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+        .to_lowercase()
+}
+
+/// Write `content][label]` after an opening bracket, or `content][]` when
+/// the label is the content itself
+fn write_reference(
+    content: &[Inline],
+    label: &str,
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    let mut text = Vec::new();
+    ctx.no_break_depth += 1;
+    write_inlines(content, &mut text, ctx)?;
+    ctx.no_break_depth -= 1;
+    buf.write_all(&text)?;
+    if normalize_label(&String::from_utf8_lossy(&text)) == normalize_label(label) {
+        write!(buf, "][]")
+    } else {
+        write!(buf, "][{}]", label)
+    }
+}
+
+/// Write the collected reference definitions as `[label]: url "title"`
+fn write_reference_definitions(
+    references: &[(String, Target)],
+    buf: &mut dyn std::io::Write,
+) -> std::io::Result<()> {
+    for (label, (url, title)) in references {
+        write!(buf, "[{}]: ", label)?;
+        if url.is_empty() || url.contains(char::is_whitespace) {
+            write!(buf, "<{}>", url)?;
+        } else {
+            write!(buf, "{}", url)?;
+        }
+        if !title.is_empty() {
+            write!(buf, " \"{}\"", escape_quotes(title))?;
+        }
+        writeln!(buf)?;
+    }
+    Ok(())
+}
+
 fn write_image(
     image: &crate::pandoc::Image,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    write!(buf, "![")?;
-    for inline in &image.content {
-        write_inline(inline, buf, ctx)?;
+    if let Some(label) = ctx.reference_label(
+        &image.target,
+        &image.attr,
+        image.target_source.reference_label.as_deref(),
+    ) {
+        write!(buf, "![")?;
+        return write_reference(&image.content, &label, buf, ctx);
     }
+    write!(buf, "![")?;
+    ctx.no_break_depth += 1;
+    write_inlines(&image.content, buf, ctx)?;
+    ctx.no_break_depth -= 1;
     write!(buf, "](")?;
     write!(buf, "{}", image.target.0)?;
     if !image.target.1.is_empty() {
@@ -1437,9 +2332,7 @@ fn write_strikeout(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "~~")?;
-    for inline in &strikeout.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&strikeout.content, buf, ctx)?;
     write!(buf, "~~")
 }
 
@@ -1449,9 +2342,7 @@ fn write_subscript(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "~")?;
-    for inline in &subscript.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&subscript.content, buf, ctx)?;
     write!(buf, "~")
 }
 
@@ -1461,9 +2352,7 @@ fn write_superscript(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "^")?;
-    for inline in &superscript.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&superscript.content, buf, ctx)?;
     write!(buf, "^")
 }
 
@@ -1474,7 +2363,13 @@ fn write_math(
 ) -> std::io::Result<()> {
     match math.math_type {
         crate::pandoc::MathType::InlineMath => {
-            write!(buf, "${}$", math.text)?;
+            // `$...$` can't start or end with whitespace or span lines, and an
+            // unescaped `$` inside would close it early. TeX ignores the
+            // difference.
+            let text = escape_math_dollars(math.text.trim()).replace(['\n', '\r'], " ");
+            if !text.is_empty() {
+                write!(buf, "${}$", text)?;
+            }
         }
         crate::pandoc::MathType::DisplayMath => {
             write!(buf, "$${}$$", math.text)?;
@@ -1483,6 +2378,20 @@ fn write_math(
     Ok(())
 }
 
+/// Backslash-escape every `$` that isn't already escaped.
+fn escape_math_dollars(text: &str) -> String {
+    let mut result = String::with_capacity(text.len());
+    let mut backslashes = 0;
+    for ch in text.chars() {
+        if ch == '$' && backslashes % 2 == 0 {
+            result.push('\\');
+        }
+        backslashes = if ch == '\\' { backslashes + 1 } else { 0 };
+        result.push(ch);
+    }
+    result
+}
+
 fn write_quoted(
     quoted: &crate::pandoc::Quoted,
     buf: &mut dyn std::io::Write,
@@ -1491,16 +2400,12 @@ fn write_quoted(
     match quoted.quote_type {
         crate::pandoc::QuoteType::SingleQuote => {
             write!(buf, "'")?;
-            for inline in &quoted.content {
-                write_inline(inline, buf, ctx)?;
-            }
+            write_inlines(&quoted.content, buf, ctx)?;
             write!(buf, "'")?;
         }
         crate::pandoc::QuoteType::DoubleQuote => {
             write!(buf, "\"")?;
-            for inline in &quoted.content {
-                write_inline(inline, buf, ctx)?;
-            }
+            write_inlines(&quoted.content, buf, ctx)?;
             write!(buf, "\"")?;
         }
     }
@@ -1511,8 +2416,26 @@ fn write_span(
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
+    // An unresolved `[^id]` reference, as the reader desugars it
+    if let Some(id) = crate::transforms::footnotes::note_reference_id(span) {
+        return write!(buf, "[^{}]", id);
+    }
+
     let (id, classes, keyvals) = &span.attr;
 
+    // A highlight, as the `mark` extension reads it
+    if ctx.config.mark
+        && id.is_empty()
+        && keyvals.is_empty()
+        && classes.len() == 1
+        && classes[0] == crate::transforms::marks::MARK_CLASS
+        && !span.content.is_empty()
+    {
+        write!(buf, "==")?;
+        write_inlines(&span.content, buf, ctx)?;
+        return write!(buf, "==");
+    }
+
     // Check if this is an editorial mark span that should use decorated syntax
     // These spans have exactly one class, no ID, and no key-value pairs
     if id.is_empty() && classes.len() == 1 && keyvals.is_empty() {
@@ -1527,9 +2450,7 @@ fn write_span(
         if let Some(marker) = marker {
             // Write using decorated syntax
             write!(buf, "[{}", marker)?;
-            for inline in &span.content {
-                write_inline(inline, buf, ctx)?;
-            }
+            write_inlines(&span.content, buf, ctx)?;
             write!(buf, "]")?;
             return Ok(());
         }
@@ -1544,12 +2465,10 @@ fn write_span(
         write!(buf, " ]")?;
         return Ok(());
     }
-    for inline in &span.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&span.content, buf, ctx)?;
     write!(buf, "]")?;
     if !is_empty_attr(&span.attr) {
-        write_attr(&span.attr, buf, ctx)?;
+        write_attr_with_source(&span.attr, &span.attr_source, buf, ctx)?;
     }
     Ok(())
 }
@@ -1560,9 +2479,7 @@ fn write_underline(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[")?;
-    for inline in &underline.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&underline.content, buf, ctx)?;
     write!(buf, "]{{.underline}}")
 }
 fn write_smallcaps(
@@ -1571,9 +2488,7 @@ fn write_smallcaps(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[")?;
-    for inline in &smallcaps.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&smallcaps.content, buf, ctx)?;
     write!(buf, "]{{.smallcaps}}")
 }
 fn write_cite(
@@ -1597,14 +2512,19 @@ fn write_cite(
         write!(buf, "[")?;
         for (i, citation) in cite.citations.iter().enumerate() {
             if i > 0 {
-                write!(buf, "; ")?;
+                write!(buf, ";")?;
+                // Prefixes read after a `;` keep the space that follows it
+                if !matches!(
+                    citation.prefix.first(),
+                    Some(crate::pandoc::Inline::Space(_))
+                ) {
+                    write!(buf, " ")?;
+                }
             }
 
             // Write prefix
             let has_prefix = !citation.prefix.is_empty();
-            for inline in &citation.prefix {
-                write_inline(inline, buf, ctx)?;
-            }
+            write_inlines(&citation.prefix, buf, ctx)?;
             // Only add space if prefix exists and doesn't end with whitespace
             if has_prefix {
                 let ends_with_space = citation
@@ -1621,12 +2541,16 @@ fn write_cite(
             } else {
                 "@"
             };
-            write!(buf, "{}{}", prefix, citation.id)?;
-
-            // Write suffix
-            for inline in &citation.suffix {
-                write_inline(inline, buf, ctx)?;
+            write!(buf, "{}", prefix)?;
+            write_citation_id(&citation.id, buf)?;
+
+            // Write suffix, keeping a word from running into the key
+            if let Some(crate::pandoc::Inline::Str(first)) = citation.suffix.first()
+                && first.text.starts_with(char::is_alphanumeric)
+            {
+                write!(buf, " ")?;
             }
+            write_inlines(&citation.suffix, buf, ctx)?;
         }
         write!(buf, "]")?;
     } else {
@@ -1635,15 +2559,14 @@ fn write_cite(
             if i > 0 {
                 write!(buf, "; ")?;
             }
-            write!(buf, "@{}", citation.id)?;
+            write!(buf, "@")?;
+            write_citation_id(&citation.id, buf)?;
 
             // Write suffix if it exists
             // For AuthorInText mode, suffix appears as: @citation [suffix]
             if !citation.suffix.is_empty() {
                 write!(buf, " [")?;
-                for inline in &citation.suffix {
-                    write_inline(inline, buf, ctx)?;
-                }
+                write_inlines(&citation.suffix, buf, ctx)?;
                 write!(buf, "]")?;
             }
         }
@@ -1653,6 +2576,19 @@ fn write_cite(
     // of what we've already written above
     Ok(())
 }
+/// Write a citation key, in braces when it isn't a bare key: one made of
+/// letters, digits and `_`, with single punctuation characters between them
+fn write_citation_id(id: &str, buf: &mut dyn std::io::Write) -> std::io::Result<()> {
+    let is_word = |c: char| c.is_ascii_alphanumeric() || c == '_';
+    let bare = id
+        .split(|c: char| ":.#$%&+?<>~/-".contains(c))
+        .all(|part| !part.is_empty() && part.chars().all(is_word));
+    if bare {
+        write!(buf, "{}", id)
+    } else {
+        write!(buf, "{{{}}}", id)
+    }
+}
 fn write_rawinline(
     raw: &crate::pandoc::RawInline,
     buf: &mut dyn std::io::Write,
@@ -1665,8 +2601,22 @@ fn write_rawinline(
         // HTML comments are emitted in native syntax (bd-1066)
         write!(buf, "{}", raw.text)
     } else {
-        // For other formats, use raw span notation with = prefix
-        write!(buf, "`{}`{{={}}}", raw.text, raw.format)
+        // For other formats, use raw span notation with = prefix. The
+        // delimiter must be longer than any backtick run in the text.
+        let backticks = determine_backticks(&raw.text);
+        if raw.text.starts_with('`') || raw.text.ends_with('`') {
+            write!(
+                buf,
+                "{} {} {}{{={}}}",
+                backticks, raw.text, backticks, raw.format
+            )
+        } else {
+            write!(
+                buf,
+                "{}{}{}{{={}}}",
+                backticks, raw.text, backticks, raw.format
+            )
+        }
     }
 }
 
@@ -1676,11 +2626,39 @@ fn is_html_comment(text: &str) -> bool {
     let trimmed = text.trim_start();
     trimmed.starts_with("<!--") && trimmed.ends_with("-->")
 }
+/// Whether note content can be written as an inline `^[...]` note
+fn fits_inline(content: &[Block]) -> bool {
+    matches!(content, [] | [Block::Plain(_)] | [Block::Paragraph(_)])
+}
+
+/// Write the collected note definitions, as `[^id]: text` when the note is
+/// a single paragraph and as a fenced `::: ^id` block otherwise
+fn write_note_definitions(
+    notes: &[(String, Blocks)],
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    for (i, (id, content)) in notes.iter().enumerate() {
+        if i > 0 {
+            writeln!(buf)?;
+        }
+        match content.as_slice() {
+            [Block::Plain(plain)] => write_inlinerefdef(id, &plain.content, buf, ctx)?,
+            [Block::Paragraph(para)] => write_inlinerefdef(id, &para.content, buf, ctx)?,
+            _ => write_fenced_note_definition(id, content, buf, ctx)?,
+        }
+    }
+    Ok(())
+}
+
 fn write_note(
     note: &crate::pandoc::Note,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
+    if let Some(label) = ctx.note_label(note.id.as_deref(), &note.content) {
+        return write!(buf, "[^{}]", label);
+    }
     write!(buf, "^[")?;
     for (i, block) in note.content.iter().enumerate() {
         if i > 0 {
@@ -1689,14 +2667,10 @@ fn write_note(
         // For inline notes, we need to flatten block content
         match block {
             crate::pandoc::Block::Plain(plain) => {
-                for inline in &plain.content {
-                    write_inline(inline, buf, ctx)?;
-                }
+                write_inlines(&plain.content, buf, ctx)?;
             }
             crate::pandoc::Block::Paragraph(para) => {
-                for inline in &para.content {
-                    write_inline(inline, buf, ctx)?;
-                }
+                write_inlines(&para.content, buf, ctx)?;
             }
             _ => {
                 write!(buf, "[complex block]")?;
@@ -1718,7 +2692,11 @@ fn write_shortcode(
     buf: &mut dyn std::io::Write,
     _ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    write!(buf, "{{{{{}}}}}", shortcode.name)
+    write!(
+        buf,
+        "{}",
+        crate::pandoc::shortcode::shortcode_to_qmd(shortcode)
+    )
 }
 fn write_insert(
     insert: &crate::pandoc::Insert,
@@ -1726,9 +2704,7 @@ fn write_insert(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[++ ")?;
-    for inline in &insert.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&insert.content, buf, ctx)?;
     write!(buf, "]")?;
     if !is_empty_attr(&insert.attr) {
         write_attr(&insert.attr, buf, ctx)?;
@@ -1741,9 +2717,7 @@ fn write_delete(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[-- ")?;
-    for inline in &delete.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&delete.content, buf, ctx)?;
     write!(buf, "]")?;
     if !is_empty_attr(&delete.attr) {
         write_attr(&delete.attr, buf, ctx)?;
@@ -1756,9 +2730,7 @@ fn write_highlight(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[!! ")?;
-    for inline in &highlight.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&highlight.content, buf, ctx)?;
     write!(buf, "]")?;
     if !is_empty_attr(&highlight.attr) {
         write_attr(&highlight.attr, buf, ctx)?;
@@ -1771,9 +2743,7 @@ fn write_editcomment(
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
     write!(buf, "[>> ")?;
-    for inline in &comment.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_inlines(&comment.content, buf, ctx)?;
     write!(buf, "]")?;
     if !is_empty_attr(&comment.attr) {
         write_attr(&comment.attr, buf, ctx)?;
@@ -1781,11 +2751,35 @@ fn write_editcomment(
     Ok(())
 }
 
+fn starts_with_word_char(inline: &Inline) -> bool {
+    matches!(inline, Inline::Str(s) if s.text.chars().next().is_some_and(char::is_alphanumeric))
+}
+
+fn ends_with_word_char(inline: &Inline) -> bool {
+    matches!(inline, Inline::Str(s) if s.text.chars().last().is_some_and(char::is_alphanumeric))
+}
+
+fn write_inlines(
+    inlines: &[Inline],
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    for (i, inline) in inlines.iter().enumerate() {
+        ctx.intraword = matches!(inline, Inline::Emph(_) | Inline::Strong(_))
+            && ((i > 0 && ends_with_word_char(&inlines[i - 1]))
+                || inlines.get(i + 1).is_some_and(starts_with_word_char));
+        write_inline(inline, buf, ctx)?;
+    }
+    ctx.intraword = false;
+    Ok(())
+}
+
 fn write_inline(
     inline: &crate::pandoc::Inline,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
+    let line_start = std::mem::take(&mut ctx.line_start);
     match inline {
         crate::pandoc::Inline::EditComment(node) => write_editcomment(node, buf, ctx),
         crate::pandoc::Inline::Highlight(node) => write_highlight(node, buf, ctx),
@@ -1805,7 +2799,7 @@ fn write_inline(
         crate::pandoc::Inline::Subscript(node) => write_subscript(node, buf, ctx),
         crate::pandoc::Inline::Superscript(node) => write_superscript(node, buf, ctx),
         crate::pandoc::Inline::Strikeout(node) => write_strikeout(node, buf, ctx),
-        crate::pandoc::Inline::Str(node) => write_str(node, buf, ctx),
+        crate::pandoc::Inline::Str(node) => write_str(node, line_start, buf, ctx),
         crate::pandoc::Inline::Space(node) => write_space(node, buf, ctx),
         crate::pandoc::Inline::SoftBreak(node) => write_soft_break(node, buf, ctx),
         crate::pandoc::Inline::Emph(node) => write_emph(node, buf, ctx),
@@ -1814,8 +2808,21 @@ fn write_inline(
         crate::pandoc::Inline::LineBreak(node) => write_linebreak(node, buf, ctx),
         crate::pandoc::Inline::Link(node) => write_link(node, buf, ctx),
         crate::pandoc::Inline::Image(node) => write_image(node, buf, ctx),
-        crate::pandoc::Inline::Custom(_) => {
-            // Custom inline nodes are not rendered in QMD output
+        crate::pandoc::Inline::Custom(custom) => {
+            // Annotations are written back as comments and inline cells as
+            // their code, evaluated or not; other custom inline nodes are not
+            // rendered in QMD output
+            if let Some(annotation) = crate::pandoc::Annotation::from_custom(custom) {
+                write!(buf, "{}", annotation.to_comment())?;
+            } else if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                ctx.line_start = line_start;
+                return write_inline(&cell.source_inline(custom), buf, ctx);
+            } else {
+                tracing::warn!(
+                    node = %custom.type_name,
+                    "dropped custom inline, which qmd can't represent"
+                );
+            }
             Ok(())
         }
     }
@@ -1873,10 +2880,10 @@ fn write_block(
             write_metablock(metablock, buf, ctx)?;
         }
         Block::NoteDefinitionPara(refdef) => {
-            write_inlinerefdef(refdef, buf, ctx)?;
+            write_inlinerefdef(&refdef.id, &refdef.content, buf, ctx)?;
         }
         Block::NoteDefinitionFencedBlock(refdef) => {
-            write_fenced_note_definition(refdef, buf, ctx)?;
+            write_fenced_note_definition(&refdef.id, &refdef.content, buf, ctx)?;
         }
         Block::CaptionBlock(_) => {
             // Defensive error: CaptionBlock should be processed during postprocessing
@@ -1894,21 +2901,93 @@ fn write_block(
                 .build(),
             );
         }
-        Block::Custom(_) => {
+        Block::Custom(custom) => {
             // Custom block nodes are not rendered in QMD output
+            tracing::warn!(
+                node = %custom.type_name,
+                "dropped custom block, which qmd can't represent"
+            );
         }
     }
     Ok(())
 }
 
+/// Width of text in columns. East Asian wide characters take two.
+fn display_width(text: &str) -> usize {
+    UnicodeWidthStr::width(text)
+}
+
+/// Greedily fill lines of at most `width` columns, breaking only at break
+/// opportunities. Newlines already in the text (hard line breaks) are kept.
+/// A word wider than the line is left on a line of its own.
+fn fill_lines(text: &str, width: usize) -> String {
+    let mut out = String::with_capacity(text.len());
+    for (i, line) in text.split('\n').enumerate() {
+        if i > 0 {
+            out.push('\n');
+        }
+        let mut column = 0;
+        for (j, word) in line.split(BREAK_OPPORTUNITY).enumerate() {
+            let word_width = display_width(word);
+            if j > 0 {
+                if column > 0 && column + 1 + word_width > width && qmd_escape::can_start_line(word)
+                {
+                    out.push('\n');
+                    column = 0;
+                } else {
+                    out.push(' ');
+                    column += 1;
+                }
+            }
+            out.push_str(word);
+            column += word_width;
+        }
+    }
+    out
+}
+
+/// Write inlines that start a line
+fn write_line_inlines(
+    inlines: &[Inline],
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    ctx.line_start = true;
+    let result = write_inlines(inlines, buf, ctx);
+    ctx.line_start = false;
+    result
+}
+
+/// Write the inline content of a paragraph or plain block, filling lines
+/// when wrapping is enabled.
+fn write_paragraph_inlines(
+    inlines: &[Inline],
+    buf: &mut dyn std::io::Write,
+    ctx: &mut QmdWriterContext,
+) -> std::io::Result<()> {
+    if ctx.config.wrap != WrapMode::Auto || ctx.filling {
+        write_line_inlines(inlines, buf, ctx)?;
+        return Ok(());
+    }
+    let mut text = Vec::new();
+    ctx.filling = true;
+    let result = write_line_inlines(inlines, &mut text, ctx);
+    ctx.filling = false;
+    result?;
+    let width = ctx.config.columns.saturating_sub(ctx.indent);
+    write!(
+        buf,
+        "{}",
+        fill_lines(&String::from_utf8_lossy(&text), width)
+    )
+}
+
 pub fn write_paragraph(
     para: &Paragraph,
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    for inline in &para.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_paragraph_inlines(&para.content, buf, ctx)?;
     writeln!(buf)?;
     Ok(())
 }
@@ -1918,9 +2997,7 @@ pub fn write_plain(
     buf: &mut dyn std::io::Write,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    for inline in &plain.content {
-        write_inline(inline, buf, ctx)?;
-    }
+    write_paragraph_inlines(&plain.content, buf, ctx)?;
     writeln!(buf)?;
     Ok(())
 }
@@ -1935,8 +3012,28 @@ pub fn write_plain(
 pub fn write_single_block<T: std::io::Write>(
     block: &Block,
     buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_single_block_from(block, None, buf)
+}
+
+/// Write a single block like `write_single_block`, copying attributes
+/// verbatim from `source` (the text the block was read from) wherever they
+/// are unchanged.
+pub fn write_single_block_with_source<T: std::io::Write>(
+    block: &Block,
+    source: &str,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_single_block_from(block, Some(source), buf)
+}
+
+fn write_single_block_from<T: std::io::Write>(
+    block: &Block,
+    source: Option<&str>,
+    buf: &mut T,
 ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
     let mut ctx = QmdWriterContext::new();
+    ctx.source = source;
     if let Err(e) = write_block(block, buf, &mut ctx) {
         return Err(vec![
             quarto_error_reporting::DiagnosticMessageBuilder::error("IO error during write")
@@ -1963,8 +3060,27 @@ pub fn write_single_block<T: std::io::Write>(
 pub fn write_single_inline<T: std::io::Write>(
     inline: &crate::pandoc::Inline,
     buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_single_inline_from(inline, None, buf)
+}
+
+/// Write a single inline like `write_single_inline`, copying attributes
+/// verbatim from `source` wherever they are unchanged.
+pub fn write_single_inline_with_source<T: std::io::Write>(
+    inline: &crate::pandoc::Inline,
+    source: &str,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_single_inline_from(inline, Some(source), buf)
+}
+
+fn write_single_inline_from<T: std::io::Write>(
+    inline: &crate::pandoc::Inline,
+    source: Option<&str>,
+    buf: &mut T,
 ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
     let mut ctx = QmdWriterContext::new();
+    ctx.source = source;
     if let Err(e) = write_inline(inline, buf, &mut ctx) {
         return Err(vec![
             quarto_error_reporting::DiagnosticMessageBuilder::error("IO error during write")
@@ -2008,7 +3124,55 @@ pub fn write<T: std::io::Write>(
     pandoc: &Pandoc,
     buf: &mut T,
 ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
-    let mut ctx = QmdWriterContext::new();
+    write_with_config(pandoc, &QmdConfig::default(), buf)
+}
+
+pub fn write_with_config<T: std::io::Write>(
+    pandoc: &Pandoc,
+    config: &QmdConfig,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_document(pandoc, config, None, None, buf)
+}
+
+/// Write a document like `write_with_config`, given the text it was read
+/// from. Front matter and attributes that are unchanged are copied from
+/// `source`, so YAML comments, key order and flow style and attribute
+/// quoting survive the roundtrip.
+pub fn write_with_source<T: std::io::Write>(
+    pandoc: &Pandoc,
+    config: &QmdConfig,
+    source: &str,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_document(pandoc, config, Some(source), Some(source), buf)
+}
+
+/// Write a document in canonical form, given the text it was read from:
+/// only unchanged front matter is copied from `source`, so that YAML
+/// comments survive, and everything else is written as `write_with_config`
+/// writes it. This is `pampa fmt`.
+pub fn write_formatted<T: std::io::Write>(
+    pandoc: &Pandoc,
+    config: &QmdConfig,
+    source: &str,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    write_document(pandoc, config, None, Some(source), buf)
+}
+
+fn write_document<T: std::io::Write>(
+    pandoc: &Pandoc,
+    config: &QmdConfig,
+    source: Option<&str>,
+    front_matter_source: Option<&str>,
+    buf: &mut T,
+) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+    let mut ctx = QmdWriterContext::with_config(config.clone());
+    ctx.source = source;
+    ctx.front_matter_source = front_matter_source;
+    ctx.references = Some(Vec::new());
+    ctx.notes = Some(Vec::new());
 
     // Try to write - IO errors are fatal
     if let Err(e) = write_impl(pandoc, buf, &mut ctx) {
@@ -2029,13 +3193,119 @@ pub fn write<T: std::io::Write>(
     Ok(())
 }
 
+/// Writes a document one block at a time, for documents too large to hold
+/// in memory, such as those read with `readers::qmd_stream`.
+///
+/// The output is the same as `write_with_config`'s for a document with the
+/// same metadata and blocks: note and reference definitions are kept until
+/// [`finish`](Self::finish) writes them at the end.
+pub struct QmdStreamWriter<T: std::io::Write> {
+    buf: T,
+    ctx: QmdWriterContext<'static>,
+    need_newline: bool,
+}
+
+impl<T: std::io::Write> QmdStreamWriter<T> {
+    pub fn new(buf: T, config: &QmdConfig) -> Self {
+        let mut ctx = QmdWriterContext::with_config(config.clone());
+        ctx.references = Some(Vec::new());
+        ctx.notes = Some(Vec::new());
+        Self {
+            buf,
+            ctx,
+            need_newline: false,
+        }
+    }
+
+    /// Write the front matter. Call this before writing any block.
+    pub fn write_meta(
+        &mut self,
+        meta: &ConfigValue,
+    ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+        let result = write_config_value_meta(meta, &mut self.buf, &mut self.ctx);
+        if let Ok(true) = result {
+            self.need_newline = true;
+        }
+        self.check(result.map(|_| ()))
+    }
+
+    pub fn write_block(
+        &mut self,
+        block: &Block,
+    ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+        let result = (|| {
+            if self.need_newline {
+                writeln!(self.buf)?;
+            }
+            write_block(block, &mut self.buf, &mut self.ctx)
+        })();
+        self.need_newline = true;
+        self.check(result)
+    }
+
+    /// Write the note and reference definitions and return the writer.
+    pub fn finish(mut self) -> Result<T, Vec<quarto_error_reporting::DiagnosticMessage>> {
+        let result = (|| {
+            if let Some(notes) = self.ctx.notes.take()
+                && !notes.is_empty()
+            {
+                if self.need_newline {
+                    writeln!(self.buf)?;
+                }
+                write_note_definitions(&notes, &mut self.buf, &mut self.ctx)?;
+                self.need_newline = true;
+            }
+            if let Some(references) = self.ctx.references.take()
+                && !references.is_empty()
+            {
+                if self.need_newline {
+                    writeln!(self.buf)?;
+                }
+                write_reference_definitions(&references, &mut self.buf)?;
+            }
+            self.buf.flush()
+        })();
+        self.check(result)?;
+        Ok(self.buf)
+    }
+
+    /// Turn an IO error or the feature errors of the last write into
+    /// diagnostics
+    fn check(
+        &mut self,
+        result: std::io::Result<()>,
+    ) -> Result<(), Vec<quarto_error_reporting::DiagnosticMessage>> {
+        if let Err(e) = result {
+            return Err(vec![
+                quarto_error_reporting::DiagnosticMessageBuilder::error("IO error during write")
+                    .with_code("Q-3-1")
+                    .problem(format!("Failed to write QMD output: {}", e))
+                    .build(),
+            ]);
+        }
+        if !self.ctx.errors.is_empty() {
+            return Err(std::mem::take(&mut self.ctx.errors));
+        }
+        Ok(())
+    }
+}
+
 fn write_impl<T: std::io::Write>(
     pandoc: &Pandoc,
     buf: &mut T,
     ctx: &mut QmdWriterContext,
 ) -> std::io::Result<()> {
-    // Phase 5: Write ConfigValue directly without MetaValueWithSourceInfo conversion
-    let mut need_newline = write_config_value_meta(&pandoc.meta, buf, ctx)?;
+    let original = ctx
+        .front_matter_source
+        .and_then(|source| original_front_matter(&pandoc.meta, source));
+    let mut need_newline = match original {
+        Some(text) => {
+            writeln!(buf, "{}", text)?;
+            true
+        }
+        // Phase 5: Write ConfigValue directly without MetaValueWithSourceInfo conversion
+        None => write_config_value_meta(&pandoc.meta, buf, ctx)?,
+    };
     for block in &pandoc.blocks {
         if need_newline {
             writeln!(buf)?
@@ -2043,5 +3313,23 @@ fn write_impl<T: std::io::Write>(
         write_block(block, buf, ctx)?;
         need_newline = true;
     }
+    // Definitions can hold links, so they go before the references
+    if let Some(notes) = ctx.notes.take()
+        && !notes.is_empty()
+    {
+        if need_newline {
+            writeln!(buf)?;
+        }
+        write_note_definitions(&notes, buf, ctx)?;
+        need_newline = true;
+    }
+    if let Some(references) = ctx.references.take()
+        && !references.is_empty()
+    {
+        if need_newline {
+            writeln!(buf)?;
+        }
+        write_reference_definitions(&references, buf)?;
+    }
     Ok(())
 }
@@ -0,0 +1,278 @@
+/*
+ * qmd_escape.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * How the qmd writer escapes the text of Str inlines.
+ */
+
+//! How the qmd writer escapes the text of `Str` inlines.
+//!
+//! Whatever its text, a Str the writer writes must read back as the same
+//! Str. Text is escaped with backslashes, which the reader removes before
+//! any ASCII punctuation, in three layers:
+//!
+//! 1. ASCII punctuation the reader doesn't take as plain text, wherever it
+//!    is: `\`, `*`, `_`, `[`, `]`, `` ` ``, `~`, `^`, `$`, `<`, `|`, `{`,
+//!    `}`, `@`, and straight quotes (`"` and `'`), which the reader would
+//!    otherwise pair into quotes or turn into apostrophes. `#` and `>`,
+//!    which start blocks, are escaped anywhere too.
+//! 2. Characters that start inline syntax only in some company: `&` that
+//!    spells a character reference, and, with the `mark` option, each `=`
+//!    of a `==` run.
+//! 3. Text at the start of a line that the reader would take for the start
+//!    of a block: list markers, rules, setext underlines and div fences.
+//!    Lines start at the start of a paragraph or plain block, after a hard
+//!    line break, after a soft break written as a newline, and on the
+//!    lines of a setext heading or a definition term. The writer never
+//!    wraps a line before such text ([`can_start_line`]).
+//!
+//! A Str of just `--`, `---` or `...` is escaped too, since the reader reads
+//! those on their own as a dash or an ellipsis. The reader reads `'` inside
+//! a word as `’`, so `’` is written back as `'` there and kept everywhere
+//! else.
+//!
+//! The guarantee covers Strs as the reader makes them: not empty, without
+//! whitespace (words are separated by `Space`s), and never next to another
+//! Str. `tests/qmd_escaping_property_tests.rs` checks it against
+//! generated text made of markdown syntax.
+
+use super::qmd::QmdConfig;
+
+/// Characters escaped wherever they are in a Str
+const ALWAYS_ESCAPED: &str = "\\*_[]`~^$<|{}@\"'#>";
+
+/// Whether the text after a `&` makes it a character reference, like
+/// `&amp;` or `&#38;`
+fn starts_character_reference(rest: &str) -> bool {
+    let name = rest.strip_prefix('#').unwrap_or(rest);
+    let len = name
+        .find(|c: char| !c.is_ascii_alphanumeric())
+        .unwrap_or(name.len());
+    len > 0 && name[len..].starts_with(';')
+}
+
+/// Escape the characters of `text` that would start inline syntax, other
+/// than `==`
+fn escape_inline(text: &str) -> String {
+    let mut result = String::with_capacity(text.len());
+    for (i, ch) in text.char_indices() {
+        let rest = &text[i + ch.len_utf8()..];
+        let escape = match ch {
+            '&' => starts_character_reference(rest),
+            _ => ALWAYS_ESCAPED.contains(ch),
+        };
+        if escape {
+            result.push('\\');
+        }
+        result.push(ch);
+    }
+    result
+}
+
+/// Write `’` as `'` between letters or digits, where the reader reads `'`
+/// as `’`
+fn reverse_smart_quotes(text: &str) -> String {
+    if !text.contains('\u{2019}') {
+        return text.to_string();
+    }
+    let chars: Vec<char> = text.chars().collect();
+    let mut result = String::with_capacity(text.len());
+    for (i, &ch) in chars.iter().enumerate() {
+        let intraword = ch == '\u{2019}'
+            && i > 0
+            && chars[i - 1].is_alphanumeric()
+            && chars.get(i + 1).is_some_and(|c| c.is_alphanumeric());
+        result.push(if intraword { '\'' } else { ch });
+    }
+    result
+}
+
+/// Write em dashes, en dashes and ellipses as `---`, `--` and `...` (the
+/// `smart` option)
+fn reverse_smart_punctuation(text: &str) -> String {
+    text.replace('\u{2014}', "---")
+        .replace('\u{2013}', "--")
+        .replace('\u{2026}', "...")
+}
+
+/// Escape each `=` of a `==` run (the `mark` option), so the text isn't read
+/// back as a highlight
+fn escape_highlight_delimiters(text: &str) -> String {
+    let chars: Vec<char> = text.chars().collect();
+    let mut result = String::with_capacity(text.len());
+    for (i, &ch) in chars.iter().enumerate() {
+        let in_run =
+            ch == '=' && ((i > 0 && chars[i - 1] == '=') || chars.get(i + 1) == Some(&'='));
+        if in_run {
+            result.push('\\');
+        }
+        result.push(ch);
+    }
+    result
+}
+
+/// Whether `word` is an ordered list marker: `1.`, `2)`, `(a)`, `iv.` or
+/// `(@)`
+fn is_ordered_list_marker(word: &str) -> bool {
+    let marker = word.strip_prefix('(').unwrap_or(word);
+    let Some(body) = marker
+        .strip_suffix('.')
+        .or_else(|| marker.strip_suffix(')'))
+    else {
+        return false;
+    };
+    let numeral = body.chars().all(|c| c.is_ascii_digit())
+        || body.chars().all(|c| "ivxlcdmIVXLCDM".contains(c))
+        || (body.len() == 1 && body.chars().all(|c| c.is_ascii_alphabetic()))
+        || body == "@";
+    !body.is_empty() && numeral
+}
+
+/// Whether a line starting with `word` would start a list, a rule, a
+/// setext underline or a div. Characters that start blocks anywhere (`#`,
+/// `>`, `|` and fences) are left to inline escaping.
+fn starts_block(word: &str) -> bool {
+    word.starts_with(":::")
+        || (!word.is_empty()
+            && word
+                .chars()
+                .all(|c| matches!(c, '-' | '+' | '*' | '=' | '_' | ':')))
+        || is_ordered_list_marker(word)
+}
+
+/// Escape escaped text that starts a line, where the reader would take it
+/// for the start of a block
+fn escape_line_start(text: &str) -> String {
+    if is_ordered_list_marker(text) {
+        // Escape the delimiter: `1\.`, `(a\)`
+        let (body, delimiter) = text.split_at(text.len() - 1);
+        format!("{}\\{}", body, delimiter)
+    } else if starts_block(text) {
+        format!("\\{}", text)
+    } else {
+        text.to_string()
+    }
+}
+
+/// Whether a line may start with `word` without the reader taking it for
+/// block syntax: a list marker, header, block quote, fence or rule.
+pub(crate) fn can_start_line(word: &str) -> bool {
+    let Some(first) = word.chars().next() else {
+        return false;
+    };
+    if matches!(first, '#' | '>' | '|') || word.starts_with("```") || word.starts_with("~~~") {
+        return false;
+    }
+    !starts_block(word)
+}
+
+/// The qmd for the text of a Str. `line_start` is whether it starts a line.
+pub(crate) fn escape_str(text: &str, line_start: bool, config: &QmdConfig) -> String {
+    let mut escaped = reverse_smart_quotes(&escape_inline(text));
+    if config.mark {
+        escaped = escape_highlight_delimiters(&escaped);
+    }
+    if config.smart {
+        let reversed = reverse_smart_punctuation(&escaped);
+        // A dash written as `---` would be a rule at the start of a line,
+        // where it stays a dash
+        if !(line_start && starts_block(&reversed)) {
+            escaped = reversed;
+        }
+    }
+    if matches!(text, "--" | "---" | "...") {
+        return format!("\\{}", escaped);
+    }
+    if line_start {
+        escape_line_start(&escaped)
+    } else {
+        escaped
+    }
+}
+
+/// Whether a Str's text is written differently at the start of a line,
+/// with the default options
+pub(crate) fn escapes_at_line_start(text: &str) -> bool {
+    let config = QmdConfig::default();
+    escape_str(text, true, &config) != escape_str(text, false, &config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn escape(text: &str, line_start: bool) -> String {
+        escape_str(text, line_start, &QmdConfig::default())
+    }
+
+    #[test]
+    fn test_inline_escapes() {
+        assert_eq!(escape("*a*", false), "\\*a\\*");
+        assert_eq!(escape("say\"hi\"", false), "say\\\"hi\\\"");
+        assert_eq!(escape("it's", false), "it\\'s");
+        assert_eq!(escape("it\u{2019}s", false), "it's");
+        assert_eq!(escape("dogs\u{2019}", false), "dogs\u{2019}");
+        assert_eq!(escape("me@example.com", false), "me\\@example.com");
+        assert_eq!(escape("{.class}", false), "\\{.class\\}");
+        assert_eq!(escape("{{<", false), "\\{\\{\\<");
+    }
+
+    #[test]
+    fn test_escapes_in_company() {
+        assert_eq!(escape("&amp;", false), "\\&amp;");
+        assert_eq!(escape("&#38;", false), "\\&\\#38;");
+        assert_eq!(escape("AT&T", false), "AT&T");
+        assert_eq!(escape("&;", false), "&;");
+    }
+
+    #[test]
+    fn test_line_start_escapes() {
+        assert_eq!(escape("1.", true), "1\\.");
+        assert_eq!(escape("1.", false), "1.");
+        assert_eq!(escape("(a)", true), "(a\\)");
+        assert_eq!(escape("-", true), "\\-");
+        assert_eq!(escape("+", true), "\\+");
+        assert_eq!(escape("===", true), "\\===");
+        assert_eq!(escape(":::", true), "\\:::");
+        assert_eq!(escape(":::warning", true), "\\:::warning");
+        assert_eq!(escape("-1", true), "-1");
+        assert_eq!(escape("1.5", true), "1.5");
+        // Already escaped anywhere
+        assert_eq!(escape("#", true), "\\#");
+        assert_eq!(escape("*", true), "\\*");
+    }
+
+    #[test]
+    fn test_dashes_and_ellipses_on_their_own() {
+        assert_eq!(escape("--", false), "\\--");
+        assert_eq!(escape("...", false), "\\...");
+        assert_eq!(escape("a--b", false), "a--b");
+    }
+
+    #[test]
+    fn test_smart_and_mark_options() {
+        let smart = QmdConfig {
+            smart: true,
+            ..QmdConfig::default()
+        };
+        assert_eq!(escape_str("a\u{2014}b", false, &smart), "a---b");
+        assert_eq!(escape_str("\u{2014}", true, &smart), "\u{2014}");
+        let mark = QmdConfig {
+            mark: true,
+            ..QmdConfig::default()
+        };
+        assert_eq!(escape_str("a==b", false, &mark), "a\\=\\=b");
+        assert_eq!(escape_str("a=b", false, &mark), "a=b");
+    }
+
+    #[test]
+    fn test_can_start_line() {
+        assert!(can_start_line("word"));
+        assert!(can_start_line("1\\."));
+        assert!(!can_start_line("1."));
+        assert!(!can_start_line("-"));
+        assert!(!can_start_line(":::note"));
+        assert!(!can_start_line("#"));
+        assert!(!can_start_line(""));
+    }
+}
@@ -0,0 +1,363 @@
+/*
+ * revealjs.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! reveal.js writer: HTML slides.
+//!
+//! The body is split into slides the way Pandoc's revealjs writer does it:
+//!
+//! - A heading at the slide level (2 unless `slide-level` says otherwise)
+//!   starts a slide, titled by the heading
+//! - A heading above the slide level starts a vertical stack of slides,
+//!   with itself as the title slide of the stack
+//! - A horizontal rule (`---`) at the top level starts an untitled slide
+//! - Deeper headings are content of the slide they are in
+//!
+//! The heading's id, classes and attributes go on the slide's `<section>`,
+//! so `## Title {background-color="black"}` sets the slide's background.
+//! Inside slides, `::: notes` divs are speaker notes, and the lists of an
+//! `::: incremental` div are shown one item at a time, as are all lists
+//! with `incremental: true` (except in `::: nonincremental` divs).
+//!
+//! As with the HTML writer, this writes the body; a complete presentation
+//! is produced by rendering it through a template (see the built-in
+//! `revealjs` template).
+
+use crate::pandoc::{Block, Pandoc};
+use crate::writers::html::{self, HtmlConfig, HtmlWriterContext};
+use quarto_pandoc_types::ConfigValue;
+use std::io::Write;
+
+/// Configuration for reveal.js output
+#[derive(Debug, Clone)]
+pub struct RevealjsConfig {
+    /// The heading level that starts a slide
+    pub slide_level: usize,
+    /// Show the items of every list one at a time
+    pub incremental: bool,
+    /// How slide content is written
+    pub html: HtmlConfig,
+}
+
+impl Default for RevealjsConfig {
+    fn default() -> Self {
+        Self {
+            slide_level: 2,
+            incremental: false,
+            html: HtmlConfig::default(),
+        }
+    }
+}
+
+/// Extract reveal.js configuration from document metadata.
+///
+/// `slide-level` and `incremental` are read from `format.revealjs` or, if
+/// not set there, from the top level of the metadata:
+/// ```yaml
+/// format:
+///   revealjs:
+///     slide-level: 1
+///     incremental: true
+/// ```
+pub fn extract_config_from_metadata(meta: &ConfigValue) -> RevealjsConfig {
+    let option = |key: &str| {
+        meta.get_path(&["format", "revealjs", key])
+            .or_else(|| meta.get(key))
+    };
+    let defaults = RevealjsConfig::default();
+    let slide_level = option("slide-level")
+        .and_then(|value| {
+            value
+                .as_int()
+                .or_else(|| value.as_plain_text()?.trim().parse().ok())
+        })
+        .filter(|level| (1..=6).contains(level))
+        .map_or(defaults.slide_level, |level| level as usize);
+    let incremental = option("incremental")
+        .and_then(|value| value.as_bool())
+        .unwrap_or(defaults.incremental);
+    RevealjsConfig {
+        slide_level,
+        incremental,
+        html: html::extract_config_from_metadata(meta),
+    }
+}
+
+/// A slide: its heading, if it has one, and its content
+struct Slide<'a> {
+    heading: Option<&'a Block>,
+    content: Vec<&'a Block>,
+}
+
+/// The slides of a vertical stack. A stack that isn't opened by a heading
+/// above the slide level is not written as a stack: its slides are
+/// top-level slides.
+struct Stack<'a> {
+    stacked: bool,
+    slides: Vec<Slide<'a>>,
+}
+
+/// Split blocks into stacks of slides.
+fn split_slides(blocks: &[Block], slide_level: usize) -> Vec<Stack<'_>> {
+    let mut stacks: Vec<Stack> = Vec::new();
+    for block in blocks {
+        let starts = match block {
+            Block::Header(header) if header.level < slide_level => Some(true),
+            Block::Header(header) if header.level == slide_level => Some(false),
+            Block::HorizontalRule(_) => Some(false),
+            _ => None,
+        };
+        let heading = matches!(block, Block::Header(_)).then_some(block);
+        match starts {
+            Some(true) => stacks.push(Stack {
+                stacked: true,
+                slides: vec![Slide {
+                    heading,
+                    content: Vec::new(),
+                }],
+            }),
+            Some(false) => {
+                // Slides after a stack's own slides are in the stack
+                let slide = Slide {
+                    heading,
+                    content: Vec::new(),
+                };
+                match stacks.last_mut() {
+                    Some(stack) => stack.slides.push(slide),
+                    None => stacks.push(Stack {
+                        stacked: false,
+                        slides: vec![slide],
+                    }),
+                }
+            }
+            None => match stacks.last_mut().and_then(|stack| stack.slides.last_mut()) {
+                Some(slide) => slide.content.push(block),
+                None => stacks.push(Stack {
+                    stacked: false,
+                    slides: vec![Slide {
+                        heading: None,
+                        content: vec![block],
+                    }],
+                }),
+            },
+        }
+    }
+    stacks
+}
+
+/// Write a slide as a `<section>`.
+fn write_slide<W: Write>(
+    slide: &Slide,
+    slide_level: usize,
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
+    write!(ctx, "<section")?;
+    match slide.heading {
+        Some(Block::Header(header)) => {
+            let (id, classes, attributes) = &header.attr;
+            let mut section_classes = Vec::new();
+            if header.level < slide_level {
+                section_classes.push("title-slide".to_string());
+            }
+            section_classes.push("slide".to_string());
+            section_classes.push(format!("level{}", header.level));
+            section_classes.extend(classes.iter().cloned());
+            html::write_attr(&(id.clone(), section_classes, attributes.clone()), ctx)?;
+            writeln!(ctx, ">")?;
+            if !header.content.is_empty() {
+                write!(ctx, "<h{}>", header.level)?;
+                html::write_inlines(&header.content, ctx)?;
+                writeln!(ctx, "</h{}>", header.level)?;
+            }
+        }
+        _ => writeln!(ctx, " class=\"slide level{}\">", slide_level)?,
+    }
+    for block in &slide.content {
+        html::write_blocks(std::slice::from_ref(*block), ctx)?;
+    }
+    writeln!(ctx, "</section>")
+}
+
+fn write_slides<W: Write>(
+    blocks: &[Block],
+    config: &RevealjsConfig,
+    ctx: &mut HtmlWriterContext<'_, W>,
+) -> std::io::Result<()> {
+    ctx.slides = true;
+    ctx.fragments = config.incremental;
+    for stack in split_slides(blocks, config.slide_level) {
+        if stack.stacked {
+            writeln!(ctx, "<section>")?;
+        }
+        for slide in &stack.slides {
+            write_slide(slide, config.slide_level, ctx)?;
+        }
+        if stack.stacked {
+            writeln!(ctx, "</section>")?;
+        }
+    }
+    // Footnotes are a slide of their own at the end
+    html::write_footnotes(ctx)
+}
+
+// =============================================================================
+// Public API
+// =============================================================================
+
+/// Write a Pandoc document as reveal.js slides, configured by its metadata
+pub fn write<W: Write>(pandoc: &Pandoc, writer: W) -> std::io::Result<()> {
+    let config = extract_config_from_metadata(&pandoc.meta);
+    write_blocks_with_config(&pandoc.blocks, writer, config).map(|_| ())
+}
+
+/// Write blocks as reveal.js slides.
+///
+/// Returns whether any code block was highlighted, so that a template can
+/// include the highlighting CSS only when it is needed.
+pub fn write_blocks_with_config<W: Write>(
+    blocks: &[Block],
+    writer: W,
+    config: RevealjsConfig,
+) -> std::io::Result<bool> {
+    let mut ctx = HtmlWriterContext::with_config(writer, config.html.clone());
+    write_slides(blocks, &config, &mut ctx)?;
+    Ok(ctx.highlighted_blocks > 0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::pandoc::Inlines;
+    use crate::pandoc::block::{BulletList, Div, Header, HorizontalRule, Paragraph, Plain};
+    use crate::pandoc::inline::{Inline, Str};
+    use hashlink::LinkedHashMap;
+    use quarto_pandoc_types::attr::AttrSourceInfo;
+    use quarto_source_map::SourceInfo;
+
+    fn text(s: &str) -> Inlines {
+        vec![Inline::Str(Str {
+            text: s.to_string(),
+            source_info: SourceInfo::default(),
+        })]
+    }
+
+    fn header(level: usize, id: &str, title: &str) -> Block {
+        Block::Header(Header {
+            level,
+            attr: (id.to_string(), vec![], LinkedHashMap::new()),
+            content: text(title),
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn para(s: &str) -> Block {
+        Block::Paragraph(Paragraph {
+            content: text(s),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn div(class: &str, content: Vec<Block>) -> Block {
+        Block::Div(Div {
+            attr: (String::new(), vec![class.to_string()], LinkedHashMap::new()),
+            content,
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn list(items: &[&str]) -> Block {
+        Block::BulletList(BulletList {
+            content: items
+                .iter()
+                .map(|item| {
+                    vec![Block::Plain(Plain {
+                        content: text(item),
+                        source_info: SourceInfo::default(),
+                    })]
+                })
+                .collect(),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn write_string(blocks: &[Block], config: RevealjsConfig) -> String {
+        let mut buf = Vec::new();
+        write_blocks_with_config(blocks, &mut buf, config).unwrap();
+        String::from_utf8(buf).unwrap()
+    }
+
+    #[test]
+    fn test_headings_and_rules_start_slides() {
+        let blocks = vec![
+            header(1, "part", "Part"),
+            para("Intro"),
+            header(2, "first", "First"),
+            para("One"),
+            header(3, "", "Detail"),
+            Block::HorizontalRule(HorizontalRule {
+                source_info: SourceInfo::default(),
+            }),
+            para("Two"),
+        ];
+        assert_eq!(
+            write_string(&blocks, RevealjsConfig::default()),
+            "<section>\n\
+             <section id=\"part\" class=\"title-slide slide level1\">\n<h1>Part</h1>\n<p>Intro</p>\n</section>\n\
+             <section id=\"first\" class=\"slide level2\">\n<h2>First</h2>\n<p>One</p>\n<h3>Detail</h3>\n</section>\n\
+             <section class=\"slide level2\">\n<p>Two</p>\n</section>\n\
+             </section>\n"
+        );
+    }
+
+    #[test]
+    fn test_slide_level() {
+        let blocks = vec![para("Before"), header(1, "a", "A"), para("Body")];
+        let config = RevealjsConfig {
+            slide_level: 1,
+            ..Default::default()
+        };
+        assert_eq!(
+            write_string(&blocks, config),
+            "<section class=\"slide level1\">\n<p>Before</p>\n</section>\n\
+             <section id=\"a\" class=\"slide level1\">\n<h1>A</h1>\n<p>Body</p>\n</section>\n"
+        );
+    }
+
+    #[test]
+    fn test_notes_and_incremental_lists() {
+        let blocks = vec![
+            header(2, "s", "S"),
+            div("incremental", vec![list(&["a", "b"])]),
+            div("notes", vec![para("Say this")]),
+        ];
+        assert_eq!(
+            write_string(&blocks, RevealjsConfig::default()),
+            "<section id=\"s\" class=\"slide level2\">\n<h2>S</h2>\n\
+             <ul>\n<li class=\"fragment\">a</li>\n<li class=\"fragment\">b</li>\n</ul>\n\
+             <aside class=\"notes\">\n<p>Say this</p>\n</aside>\n</section>\n"
+        );
+    }
+
+    #[test]
+    fn test_incremental_everywhere_but_nonincremental() {
+        let blocks = vec![
+            header(2, "s", "S"),
+            list(&["a"]),
+            div("nonincremental", vec![list(&["b"])]),
+        ];
+        let config = RevealjsConfig {
+            incremental: true,
+            ..Default::default()
+        };
+        let output = write_string(&blocks, config);
+        assert!(
+            output.contains("<li class=\"fragment\">a</li>"),
+            "{}",
+            output
+        );
+        assert!(output.contains("<li>b</li>"), "{}", output);
+    }
+}
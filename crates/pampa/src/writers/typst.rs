@@ -0,0 +1,686 @@
+/*
+ * typst.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Typst writer for Pandoc AST.
+//!
+//! This writer produces Typst markup for the document body. As with the LaTeX
+//! writer, a complete document is produced by rendering the body through a
+//! template (see the built-in `typst` template).
+//!
+//! # Design decisions
+//!
+//! - Inline formatting uses function syntax (`#emph[...]`, `#strong[...]`)
+//!   rather than `_`/`*` markup, which needs word boundaries in Typst
+//! - Identifiers become Typst labels (`<id>`), so Quarto cross-references such
+//!   as `@fig-plot` resolve natively against figure, table and heading labels
+//! - Citations are written as native Typst citations; citeproc-rendered
+//!   content is not used, since Typst processes the bibliography itself
+//! - Code blocks keep their first class as the raw block language, which Typst
+//!   uses for syntax highlighting
+//! - Math text is passed through unchanged; TeX-only commands are not
+//!   translated to Typst math
+//!
+//! The writer builds strings rather than writing incrementally so that list
+//! items and other nested content can be re-indented.
+
+use crate::pandoc::table::{Alignment, Cell, ColWidth, Row, Table};
+use crate::pandoc::{
+    Block, CitationMode, Inline, Inlines, ListNumberDelim, ListNumberStyle, Pandoc,
+};
+use std::io::Write;
+
+/// Label prefixes that Quarto uses for cross-referenceable elements.
+const CROSSREF_PREFIXES: &[&str] = &["fig-", "tbl-", "sec-", "eq-", "lst-", "thm-"];
+
+// =============================================================================
+// Escaping
+// =============================================================================
+
+/// Escape text for use in Typst markup.
+fn escape_typst(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '\\' | '#' | '*' | '_' | '`' | '$' | '@' | '<' | '>' | '[' | ']' | '~' => {
+                out.push('\\');
+                out.push(c);
+            }
+            // `//` and `/*` start comments
+            '/' if matches!(chars.peek(), Some('/') | Some('*')) => out.push_str("\\/"),
+            '\u{00A0}' => out.push('~'),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+/// Escape characters that would start a heading, list item or numbered list
+/// item when they appear at the start of a line.
+fn escape_line_start(text: String) -> String {
+    if text.starts_with(['=', '-', '+']) {
+        return format!("\\{}", text);
+    }
+    let digits = text.chars().take_while(|c| c.is_ascii_digit()).count();
+    if digits > 0 && text[digits..].starts_with('.') {
+        return format!("{}\\{}", &text[..digits], &text[digits..]);
+    }
+    text
+}
+
+/// Quote a string as a Typst string literal.
+fn typst_string(text: &str) -> String {
+    let mut out = String::with_capacity(text.len() + 2);
+    out.push('"');
+    for c in text.chars() {
+        match c {
+            '\\' => out.push_str("\\\\"),
+            '"' => out.push_str("\\\""),
+            '\n' => out.push_str("\\n"),
+            _ => out.push(c),
+        }
+    }
+    out.push('"');
+    out
+}
+
+/// Write `id` as a label, using `label("...")` when it isn't valid `<...>` syntax.
+fn typst_label(id: &str) -> String {
+    if is_label_name(id) {
+        format!("<{}>", id)
+    } else {
+        format!("#label({})", typst_string(id))
+    }
+}
+
+/// Whether `id` can be written as `<id>` and referenced as `@id`.
+fn is_label_name(id: &str) -> bool {
+    !id.is_empty()
+        && id
+            .chars()
+            .all(|c| c.is_alphanumeric() || matches!(c, '_' | '-' | '.' | ':'))
+        && id.ends_with(|c: char| c.is_alphanumeric() || c == '_')
+}
+
+/// Convert a Pandoc dimension attribute to a Typst length.
+///
+/// Typst has no pixel unit, so pixels (and bare numbers) are converted to
+/// inches at 96 dpi as Pandoc does.
+fn typst_dimension(value: &str) -> String {
+    let px = value.strip_suffix("px").unwrap_or(value);
+    match px.parse::<f64>() {
+        Ok(px) => format!("{}in", px / 96.0),
+        Err(_) => value.to_string(),
+    }
+}
+
+/// Indent every line after the first, leaving blank lines empty.
+fn indent_continuation(text: &str, indent: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for (i, line) in text.split('\n').enumerate() {
+        if i > 0 {
+            out.push('\n');
+            if !line.is_empty() {
+                out.push_str(indent);
+            }
+        }
+        out.push_str(line);
+    }
+    out
+}
+
+// =============================================================================
+// Inlines
+// =============================================================================
+
+/// Convert a sequence of inlines to Typst markup.
+fn inlines_to_typst(inlines: &Inlines) -> String {
+    let mut out = String::new();
+    for inline in inlines {
+        write_inline(inline, &mut out);
+    }
+    out
+}
+
+/// Write `#name[content]`.
+fn push_function(out: &mut String, name: &str, content: &Inlines) {
+    out.push('#');
+    out.push_str(name);
+    out.push('[');
+    out.push_str(&inlines_to_typst(content));
+    out.push(']');
+}
+
+/// Append a single inline element.
+fn write_inline(inline: &Inline, out: &mut String) {
+    match inline {
+        Inline::Str(s) => out.push_str(&escape_typst(&s.text)),
+        Inline::Space(_) | Inline::SoftBreak(_) => out.push(' '),
+        Inline::LineBreak(_) => out.push_str("\\\n"),
+        Inline::Emph(e) => push_function(out, "emph", &e.content),
+        Inline::Strong(s) => push_function(out, "strong", &s.content),
+        Inline::Underline(u) => push_function(out, "underline", &u.content),
+        Inline::Strikeout(s) => push_function(out, "strike", &s.content),
+        Inline::Superscript(s) => push_function(out, "super", &s.content),
+        Inline::Subscript(s) => push_function(out, "sub", &s.content),
+        Inline::SmallCaps(s) => push_function(out, "smallcaps", &s.content),
+        Inline::Quoted(q) => {
+            // Typst turns straight quotes into typographic ones
+            let quote = match q.quote_type {
+                crate::pandoc::QuoteType::SingleQuote => '\'',
+                crate::pandoc::QuoteType::DoubleQuote => '"',
+            };
+            out.push(quote);
+            out.push_str(&inlines_to_typst(&q.content));
+            out.push(quote);
+        }
+        Inline::Code(c) => {
+            if c.text.contains('`') {
+                out.push_str(&format!("#raw({})", typst_string(&c.text)));
+            } else {
+                out.push('`');
+                out.push_str(&c.text);
+                out.push('`');
+            }
+        }
+        Inline::Math(m) => match m.math_type {
+            crate::pandoc::MathType::InlineMath => out.push_str(&format!("${}$", m.text)),
+            // Surrounding spaces make Typst set the equation as a block
+            crate::pandoc::MathType::DisplayMath => out.push_str(&format!("$ {} $", m.text.trim())),
+        },
+        Inline::RawInline(raw) => {
+            if raw.format == "typst" {
+                out.push_str(&raw.text);
+            }
+        }
+        Inline::Link(link) => {
+            let url = &link.target.0;
+            let dest = match url.strip_prefix('#') {
+                Some(id) if is_label_name(id) => format!("<{}>", id),
+                Some(id) => format!("label({})", typst_string(id)),
+                None => typst_string(url),
+            };
+            out.push_str(&format!("#link({})[", dest));
+            out.push_str(&inlines_to_typst(&link.content));
+            out.push(']');
+        }
+        Inline::Image(image) => {
+            out.push_str(&format!("#box({})", image_call(image)));
+        }
+        Inline::Note(note) => {
+            out.push_str("#footnote[");
+            out.push_str(&blocks_to_typst(&note.content));
+            out.push(']');
+        }
+        Inline::Span(span) => out.push_str(&inlines_to_typst(&span.content)),
+        Inline::Cite(cite) => {
+            for (i, citation) in cite.citations.iter().enumerate() {
+                if i > 0 {
+                    out.push(' ');
+                }
+                if !citation.prefix.is_empty() {
+                    out.push_str(&inlines_to_typst(&citation.prefix));
+                    out.push(' ');
+                }
+                write_citation(citation, out);
+            }
+        }
+        Inline::Insert(ins) => out.push_str(&inlines_to_typst(&ins.content)),
+        Inline::Delete(del) => push_function(out, "strike", &del.content),
+        Inline::Highlight(h) => push_function(out, "highlight", &h.content),
+        Inline::Custom(custom) if custom.type_name == crate::pandoc::INLINE_CELL_TYPE_NAME => {
+            if let Some(cell) = crate::pandoc::InlineCell::from_custom(custom) {
+                out.push_str(&inlines_to_typst(&cell.rendered(custom)));
+            }
+        }
+        // Quarto extensions and editor comments have no Typst rendering
+        Inline::Shortcode(_)
+        | Inline::NoteReference(_)
+        | Inline::Attr(_, _)
+        | Inline::EditComment(_)
+        | Inline::Custom(_) => {}
+    }
+}
+
+/// Append a single citation as a Typst reference or `#cite` call.
+///
+/// Cross-references and normal citations with simple keys use `@key` syntax,
+/// with any suffix as the supplement. Other citation modes need `#cite`.
+fn write_citation(citation: &crate::pandoc::inline::Citation, out: &mut String) {
+    let suffix = inlines_to_typst(&citation.suffix);
+    let suffix = suffix.trim_start_matches([',', ' ']);
+    let is_crossref = CROSSREF_PREFIXES
+        .iter()
+        .any(|prefix| citation.id.starts_with(prefix));
+    let form = match citation.mode {
+        _ if is_crossref => None,
+        CitationMode::AuthorInText => Some("prose"),
+        CitationMode::SuppressAuthor => Some("year"),
+        CitationMode::NormalCitation => None,
+    };
+
+    if form.is_none() && is_label_name(&citation.id) {
+        out.push('@');
+        out.push_str(&citation.id);
+        if !suffix.is_empty() {
+            out.push_str(&format!("[{}]", suffix));
+        }
+        return;
+    }
+
+    let label = if is_label_name(&citation.id) {
+        format!("<{}>", citation.id)
+    } else {
+        format!("label({})", typst_string(&citation.id))
+    };
+    out.push_str(&format!("#cite({}", label));
+    if let Some(form) = form {
+        out.push_str(&format!(", form: {}", typst_string(form)));
+    }
+    if !suffix.is_empty() {
+        out.push_str(&format!(", supplement: [{}]", suffix));
+    }
+    out.push(')');
+}
+
+/// Build an `image(...)` call, honoring alt, width and height attributes.
+fn image_call(image: &crate::pandoc::inline::Image) -> String {
+    let mut args = vec![typst_string(&image.target.0)];
+    for key in ["width", "height"] {
+        if let Some(value) = image.attr.2.get(key) {
+            args.push(format!("{}: {}", key, typst_dimension(value)));
+        }
+    }
+    let alt = crate::writers::plaintext::inlines_to_string(&image.content).0;
+    if !alt.is_empty() {
+        args.push(format!("alt: {}", typst_string(&alt)));
+    }
+    format!("image({})", args.join(", "))
+}
+
+// =============================================================================
+// Blocks
+// =============================================================================
+
+/// Convert a sequence of blocks to Typst markup, separated by blank lines.
+fn blocks_to_typst(blocks: &[Block]) -> String {
+    blocks
+        .iter()
+        .filter_map(block_to_typst)
+        .collect::<Vec<_>>()
+        .join("\n\n")
+}
+
+/// Convert a single block. Returns `None` for blocks that produce no output.
+fn block_to_typst(block: &Block) -> Option<String> {
+    let text = match block {
+        Block::Plain(plain) => escape_line_start(inlines_to_typst(&plain.content)),
+        Block::Paragraph(para) => escape_line_start(inlines_to_typst(&para.content)),
+        Block::LineBlock(lineblock) => lineblock
+            .content
+            .iter()
+            .map(|line| escape_line_start(inlines_to_typst(line)))
+            .collect::<Vec<_>>()
+            .join("\\\n"),
+        Block::CodeBlock(codeblock) => {
+            // Use a fence longer than any backtick run in the code
+            let longest_run = codeblock
+                .text
+                .split(|c: char| c != '`')
+                .map(str::len)
+                .max()
+                .unwrap_or(0);
+            let fence = "`".repeat(longest_run.max(2) + 1);
+            let lang = codeblock.attr.1.first().map_or("", String::as_str);
+            format!("{}{}\n{}\n{}", fence, lang, codeblock.text, fence)
+        }
+        Block::RawBlock(raw) => {
+            if raw.format != "typst" {
+                return None;
+            }
+            raw.text.clone()
+        }
+        Block::BlockQuote(quote) => {
+            format!(
+                "#quote(block: true)[\n{}\n]",
+                blocks_to_typst(&quote.content)
+            )
+        }
+        Block::OrderedList(list) => {
+            let (start, style, delim) = &list.attr;
+            let items = list_items("+", &list.content);
+            let numbering = numbering_pattern(style, delim);
+            if *start == 1 && numbering == "1." {
+                items
+            } else {
+                format!(
+                    "#block[\n#set enum(numbering: {}, start: {})\n{}\n]",
+                    typst_string(&numbering),
+                    start,
+                    items
+                )
+            }
+        }
+        Block::BulletList(list) => list_items("-", &list.content),
+        Block::DefinitionList(deflist) => deflist
+            .content
+            .iter()
+            .map(|(term, definitions)| {
+                let body = definitions
+                    .iter()
+                    .map(|blocks| blocks_to_typst(blocks))
+                    .collect::<Vec<_>>()
+                    .join("\n\n");
+                format!(
+                    "/ {}: {}",
+                    inlines_to_typst(term),
+                    indent_continuation(&body, "  ")
+                )
+            })
+            .collect::<Vec<_>>()
+            .join("\n"),
+        Block::Header(header) => {
+            let label = if header.attr.0.is_empty() {
+                String::new()
+            } else {
+                format!(" {}", typst_label(&header.attr.0))
+            };
+            let content = inlines_to_typst(&header.content);
+            let unnumbered = header.attr.1.iter().any(|c| c == "unnumbered");
+            let unlisted = header.attr.1.iter().any(|c| c == "unlisted");
+            if unnumbered || unlisted {
+                let mut args = vec![format!("level: {}", header.level)];
+                if unnumbered {
+                    args.push("numbering: none".to_string());
+                }
+                if unlisted {
+                    args.push("outlined: false".to_string());
+                }
+                format!("#heading({})[{}]{}", args.join(", "), content, label)
+            } else {
+                format!("{} {}{}", "=".repeat(header.level), content, label)
+            }
+        }
+        Block::HorizontalRule(_) => "#line(length: 100%)".to_string(),
+        Block::Table(table) => table_to_typst(table),
+        Block::Figure(figure) => {
+            let content = match single_image(&figure.content) {
+                Some(image) => image_call(image),
+                None => format!("[\n{}\n]", blocks_to_typst(&figure.content)),
+            };
+            let mut out = format!("#figure({}", content);
+            if let Some(caption) = caption_to_typst(&figure.caption) {
+                out.push_str(&format!(", caption: [{}]", caption));
+            }
+            out.push(')');
+            if !figure.attr.0.is_empty() {
+                out.push_str(&format!(" {}", typst_label(&figure.attr.0)));
+            }
+            out
+        }
+        Block::Div(div) => {
+            let content = blocks_to_typst(&div.content);
+            if div.attr.0.is_empty() {
+                if content.is_empty() {
+                    return None;
+                }
+                content
+            } else {
+                format!("#block[\n{}\n] {}", content, typst_label(&div.attr.0))
+            }
+        }
+        Block::CaptionBlock(caption) => inlines_to_typst(&caption.content),
+        // Metadata, note definitions (rendered at their references) and custom
+        // nodes produce no output
+        Block::BlockMetadata(_)
+        | Block::NoteDefinitionPara(_)
+        | Block::NoteDefinitionFencedBlock(_)
+        | Block::Custom(_) => return None,
+    };
+    Some(text)
+}
+
+/// The image in blocks consisting of a single image, as in most figures.
+fn single_image(blocks: &[Block]) -> Option<&crate::pandoc::inline::Image> {
+    let inlines = match blocks {
+        [Block::Plain(plain)] => &plain.content,
+        [Block::Paragraph(para)] => &para.content,
+        _ => return None,
+    };
+    match inlines.as_slice() {
+        [Inline::Image(image)] => Some(image),
+        _ => None,
+    }
+}
+
+/// The Typst markup of a caption's long form, if it is non-empty.
+fn caption_to_typst(caption: &crate::pandoc::caption::Caption) -> Option<String> {
+    caption
+        .long
+        .as_ref()
+        .filter(|blocks| !blocks.is_empty())
+        .map(|blocks| blocks_to_typst(blocks))
+}
+
+/// Write list items with `marker`, indenting continuation lines.
+///
+/// Items are separated by blank lines unless the list is tight (every item
+/// starts with a `Plain` block), matching Typst's tight/loose list handling.
+fn list_items(marker: &str, items: &[Vec<Block>]) -> String {
+    let tight = items
+        .iter()
+        .all(|item| matches!(item.first(), None | Some(Block::Plain(_))));
+    let indent = " ".repeat(marker.len() + 1);
+    items
+        .iter()
+        .map(|item| {
+            format!(
+                "{} {}",
+                marker,
+                indent_continuation(&blocks_to_typst(item), &indent)
+            )
+        })
+        .collect::<Vec<_>>()
+        .join(if tight { "\n" } else { "\n\n" })
+}
+
+/// The Typst numbering pattern for an ordered list style and delimiter.
+fn numbering_pattern(style: &ListNumberStyle, delim: &ListNumberDelim) -> String {
+    let counter = match style {
+        ListNumberStyle::LowerAlpha => "a",
+        ListNumberStyle::UpperAlpha => "A",
+        ListNumberStyle::LowerRoman => "i",
+        ListNumberStyle::UpperRoman => "I",
+        ListNumberStyle::Decimal | ListNumberStyle::Example | ListNumberStyle::Default => "1",
+    };
+    match delim {
+        ListNumberDelim::OneParen => format!("{})", counter),
+        ListNumberDelim::TwoParens => format!("({})", counter),
+        ListNumberDelim::Period | ListNumberDelim::Default => format!("{}.", counter),
+    }
+}
+
+/// The Typst alignment value for a column or cell alignment.
+fn typst_alignment(alignment: &Alignment) -> &'static str {
+    match alignment {
+        Alignment::Left => "left",
+        Alignment::Right => "right",
+        Alignment::Center => "center",
+        Alignment::Default => "auto",
+    }
+}
+
+/// Convert a table to a `#figure` wrapping a `table` call.
+fn table_to_typst(table: &Table) -> String {
+    let columns = if table
+        .colspec
+        .iter()
+        .any(|(_, width)| matches!(width, ColWidth::Percentage(_)))
+    {
+        let widths: Vec<String> = table
+            .colspec
+            .iter()
+            .map(|(_, width)| match width {
+                ColWidth::Percentage(p) => format!("{}%", p * 100.0),
+                ColWidth::Default => "auto".to_string(),
+            })
+            .collect();
+        format!("({},)", widths.join(", "))
+    } else {
+        table.colspec.len().to_string()
+    };
+    let aligns: Vec<&str> = table
+        .colspec
+        .iter()
+        .map(|(alignment, _)| typst_alignment(alignment))
+        .collect();
+
+    let mut lines = vec![
+        format!("columns: {},", columns),
+        format!("align: ({},),", aligns.join(", ")),
+    ];
+    if !table.head.rows.is_empty() {
+        let cells: Vec<String> = table.head.rows.iter().flat_map(row_cells).collect();
+        lines.push(format!("table.header({}),", cells.join(", ")));
+        lines.push("table.hline(),".to_string());
+    }
+    for body in &table.bodies {
+        for row in body.head.iter().chain(&body.body) {
+            lines.push(format!("{},", row_cells(row).join(", ")));
+        }
+    }
+    if !table.foot.rows.is_empty() {
+        let cells: Vec<String> = table.foot.rows.iter().flat_map(row_cells).collect();
+        lines.push("table.hline(),".to_string());
+        lines.push(format!("table.footer({}),", cells.join(", ")));
+    }
+
+    let mut out = String::from("#figure(\n  align(center)[#table(\n");
+    for line in lines {
+        out.push_str("    ");
+        out.push_str(&line);
+        out.push('\n');
+    }
+    out.push_str("  )],\n");
+    if let Some(caption) = caption_to_typst(&table.caption) {
+        out.push_str(&format!("  caption: [{}],\n", caption));
+    }
+    out.push_str("  kind: table,\n)");
+    if !table.attr.0.is_empty() {
+        out.push_str(&format!(" {}", typst_label(&table.attr.0)));
+    }
+    out
+}
+
+/// The cells of a table row, using `table.cell` for spans and alignment.
+fn row_cells(row: &Row) -> Vec<String> {
+    row.cells.iter().map(cell_to_typst).collect()
+}
+
+/// Convert a single table cell.
+fn cell_to_typst(cell: &Cell) -> String {
+    let content = format!("[{}]", blocks_to_typst(&cell.content));
+    let mut args = Vec::new();
+    if cell.col_span > 1 {
+        args.push(format!("colspan: {}", cell.col_span));
+    }
+    if cell.row_span > 1 {
+        args.push(format!("rowspan: {}", cell.row_span));
+    }
+    if cell.alignment != Alignment::Default {
+        args.push(format!("align: {}", typst_alignment(&cell.alignment)));
+    }
+    if args.is_empty() {
+        content
+    } else {
+        format!("table.cell({}){}", args.join(", "), content)
+    }
+}
+
+// =============================================================================
+// Public API
+// =============================================================================
+
+/// Write a Pandoc document's body to Typst.
+///
+/// Only the body is written; use a template (such as the built-in `typst`
+/// template) to produce a complete document.
+pub fn write<W: Write>(pandoc: &Pandoc, writer: W) -> std::io::Result<()> {
+    write_blocks_to(&pandoc.blocks, writer)
+}
+
+/// Public wrapper to write blocks (for external callers)
+pub fn write_blocks_to<W: Write>(blocks: &[Block], mut writer: W) -> std::io::Result<()> {
+    let body = blocks_to_typst(blocks);
+    if !body.is_empty() {
+        writeln!(writer, "{}", body)?;
+    }
+    Ok(())
+}
+
+/// Public wrapper to write inlines (for external callers)
+pub fn write_inlines_to<W: Write>(inlines: &Inlines, mut writer: W) -> std::io::Result<()> {
+    write!(writer, "{}", inlines_to_typst(inlines))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_escape_typst() {
+        assert_eq!(escape_typst("a*b_c#d"), "a\\*b\\_c\\#d");
+        assert_eq!(escape_typst("$5 @home <x>"), "\\$5 \\@home \\<x\\>");
+        assert_eq!(escape_typst("a/b // c"), "a/b \\// c");
+    }
+
+    #[test]
+    fn test_escape_line_start() {
+        assert_eq!(
+            escape_line_start("- not a list".to_string()),
+            "\\- not a list"
+        );
+        assert_eq!(
+            escape_line_start("= not a heading".to_string()),
+            "\\= not a heading"
+        );
+        assert_eq!(
+            escape_line_start("1. not a list".to_string()),
+            "1\\. not a list"
+        );
+        assert_eq!(escape_line_start("10 items".to_string()), "10 items");
+    }
+
+    #[test]
+    fn test_typst_label() {
+        assert_eq!(typst_label("fig-plot"), "<fig-plot>");
+        assert_eq!(typst_label("a b"), "#label(\"a b\")");
+        assert!(!is_label_name("ends."));
+    }
+
+    #[test]
+    fn test_numbering_pattern() {
+        assert_eq!(
+            numbering_pattern(&ListNumberStyle::LowerAlpha, &ListNumberDelim::OneParen),
+            "a)"
+        );
+        assert_eq!(
+            numbering_pattern(&ListNumberStyle::UpperRoman, &ListNumberDelim::TwoParens),
+            "(I)"
+        );
+        assert_eq!(
+            numbering_pattern(&ListNumberStyle::Default, &ListNumberDelim::Default),
+            "1."
+        );
+    }
+
+    #[test]
+    fn test_typst_dimension() {
+        assert_eq!(typst_dimension("50%"), "50%");
+        assert_eq!(typst_dimension("192px"), "2in");
+        assert_eq!(typst_dimension("96"), "1in");
+        assert_eq!(typst_dimension("3cm"), "3cm");
+    }
+}
@@ -220,17 +220,12 @@ title: Hello
     // Parse to get the AST, then modify it (toggle first checkbox)
     let mut new_ast = parse_qmd(original_qmd);
 
-    // Navigate: blocks[0] = Div -> content[0] = BulletList -> content[0][0] = Plain -> content[0] = Span
-    // Toggle the first checkbox from [x] to [ ] by clearing the span content
-    if let pampa::pandoc::Block::Div(ref mut div) = new_ast.blocks[0] {
-        if let pampa::pandoc::Block::BulletList(ref mut bl) = div.content[0] {
-            if let pampa::pandoc::Block::Plain(ref mut plain) = bl.content[0][0] {
-                if let pampa::pandoc::Inline::Span(ref mut span) = plain.content[0] {
-                    span.content.clear(); // Toggle [x] -> [ ]
-                }
-            }
-        }
-    }
+    // Toggle the first checkbox from [x] to [ ]
+    assert!(pampa::transforms::set_task_checked(
+        &mut new_ast.blocks,
+        0,
+        false
+    ));
 
     // Run through JSON round-trip path (simulates WASM/client)
     let result = incremental_write_via_json_roundtrip(original_qmd, &new_ast);
@@ -1210,17 +1205,11 @@ fn sugar_roundtrip_list_table_with_header() {
 
 // --- Definition-list sugar/desugar roundtrips ---
 
-// NOTE: Definition-list sugar/desugar roundtrip is LOSSY. The writer produces
-// Pandoc-native definition list syntax ("term\n:   definition\n") but the reader
-// only recognizes the `::: {.definition-list}` div syntax. This means:
-//   - KeepBefore (verbatim copy): works correctly (preserves original div syntax)
-//   - Rewrite: broken — writer output doesn't parse back to DefinitionList
-// This is a pre-existing writer bug, not specific to the incremental writer.
-// These tests are ignored until the writer is fixed to produce div syntax for
-// definition lists (or the reader is extended to parse Pandoc-native syntax).
+// The writer produces Pandoc-native definition list syntax ("term\n:   definition\n"),
+// which the reader also recognizes, so a div-syntax definition list reads back as
+// the same DefinitionList.
 
 #[test]
-#[ignore = "definition-list sugar roundtrip is lossy: writer produces Pandoc-native syntax, reader expects div syntax"]
 fn sugar_roundtrip_definition_list_basic() {
     assert_sugar_roundtrip(
         "::: {.definition-list}\n* term one\n  - definition one\n* term two\n  - definition two\n\n:::\n",
@@ -1228,13 +1217,19 @@ fn sugar_roundtrip_definition_list_basic() {
 }
 
 #[test]
-#[ignore = "definition-list sugar roundtrip is lossy: writer produces Pandoc-native syntax, reader expects div syntax"]
 fn sugar_roundtrip_definition_list_multiple_defs() {
     assert_sugar_roundtrip(
         "::: {.definition-list}\n* term\n  - definition a\n  - definition b\n\n:::\n",
     );
 }
 
+#[test]
+fn sugar_roundtrip_definition_list_pandoc_syntax() {
+    assert_sugar_roundtrip(
+        "Term\n:   Tight one\n:   Tight two\n\nLoose\n\n:   Definition\n\n    More\n",
+    );
+}
+
 // --- Idempotence of incremental writer with sugared constructs ---
 
 #[test]
@@ -1251,6 +1246,11 @@ fn idempotent_definition_list() {
     );
 }
 
+#[test]
+fn idempotent_definition_list_pandoc_syntax() {
+    assert_idempotent("Intro.\n\nTerm\n:   Definition\n\nOther\n\n:   Loose\n\nAfter.\n");
+}
+
 #[test]
 fn idempotent_mixed_with_table() {
     assert_idempotent(
@@ -1629,3 +1629,88 @@ fn roundtrip_kanban_no_trailing_newline_via_json() {
         );
     }
 }
+
+// =============================================================================
+// Attribute fidelity: rewritten blocks keep unchanged attributes verbatim
+// =============================================================================
+
+#[test]
+fn rewritten_div_keeps_attribute_text() {
+    let original_qmd = "::: {.callout-note   #tip key='val' other=x}\n\nHello.\n:::\n";
+    let new_qmd = "::: {.callout-note   #tip key='val' other=x}\n\nGoodbye.\n:::\n";
+    let original_ast = parse_qmd(original_qmd);
+    let new_ast = parse_qmd(new_qmd);
+    let plan = compute_reconciliation(&original_ast, &new_ast);
+    let result =
+        writers::incremental::incremental_write(original_qmd, &original_ast, &new_ast, &plan)
+            .expect("incremental_write failed");
+    assert!(
+        result.starts_with("::: {.callout-note   #tip key='val' other=x}\n"),
+        "attribute text was rewritten: {:?}",
+        result
+    );
+    assert_roundtrip(original_qmd, new_qmd);
+}
+
+#[test]
+fn rewritten_div_with_changed_attribute_is_normalized() {
+    let original_qmd = "::: {.note key='val'}\n\nHello.\n:::\n";
+    let new_qmd = "::: {.note key='other'}\n\nHello.\n:::\n";
+    let original_ast = parse_qmd(original_qmd);
+    let new_ast = parse_qmd(new_qmd);
+    let plan = compute_reconciliation(&original_ast, &new_ast);
+    let result =
+        writers::incremental::incremental_write(original_qmd, &original_ast, &new_ast, &plan)
+            .expect("incremental_write failed");
+    assert!(
+        result.starts_with("::: {.note key=\"other\"}\n"),
+        "unexpected attribute text: {:?}",
+        result
+    );
+}
+
+#[test]
+fn single_inline_with_source_keeps_span_attributes() {
+    let qmd = "Say [hi]{#greet  lang='en' .loud} now.\n";
+    let ast = parse_qmd(qmd);
+    let pampa::pandoc::Block::Paragraph(para) = &ast.blocks[0] else {
+        panic!("Expected a Paragraph, got {:?}", ast.blocks[0]);
+    };
+    let span = &para.content[2];
+
+    let mut buf = Vec::new();
+    writers::qmd::write_single_inline_with_source(span, qmd, &mut buf).unwrap();
+    assert_eq!(
+        String::from_utf8(buf).unwrap(),
+        "[hi]{#greet  lang='en' .loud}"
+    );
+
+    // Without the source, attributes are written in normal form
+    let mut buf = Vec::new();
+    writers::qmd::write_single_inline(span, &mut buf).unwrap();
+    assert_eq!(
+        String::from_utf8(buf).unwrap(),
+        "[hi]{#greet .loud lang=\"en\"}"
+    );
+}
+
+#[test]
+fn single_inline_with_source_normalizes_edited_attributes() {
+    let qmd = "Say [hi]{.loud lang='en'} now.\n";
+    let mut ast = parse_qmd(qmd);
+    let pampa::pandoc::Block::Paragraph(para) = &mut ast.blocks[0] else {
+        panic!("Expected a Paragraph");
+    };
+    let pampa::pandoc::Inline::Span(span) = &mut para.content[2] else {
+        panic!("Expected a Span");
+    };
+    span.attr.1.push("quiet".to_string());
+    span.attr_source.classes.push(None);
+
+    let mut buf = Vec::new();
+    writers::qmd::write_single_inline_with_source(&para.content[2], qmd, &mut buf).unwrap();
+    assert_eq!(
+        String::from_utf8(buf).unwrap(),
+        "[hi]{.loud .quiet lang=\"en\"}"
+    );
+}
@@ -1,4 +1,4 @@
-use pampa::readers::qmd;
+use pampa::readers::{commonmark, qmd};
 use pampa::writers::json::{JsonConfig, write_with_config};
 use std::io;
 
@@ -37,6 +37,7 @@ fn test_json_location_enabled() {
     let mut buf = Vec::new();
     let config = JsonConfig {
         include_inline_locations: true,
+        include_block_ids: false,
     };
     write_with_config(&pandoc, &context, &mut buf, &config).expect("Failed to write JSON");
 
@@ -80,6 +81,7 @@ fn test_json_location_multiline() {
     let mut buf = Vec::new();
     let config = JsonConfig {
         include_inline_locations: true,
+        include_block_ids: false,
     };
     write_with_config(&pandoc, &context, &mut buf, &config).expect("Failed to write JSON");
 
@@ -113,6 +115,7 @@ fn test_json_location_1_indexed() {
     let mut buf = Vec::new();
     let config = JsonConfig {
         include_inline_locations: true,
+        include_block_ids: false,
     };
     write_with_config(&pandoc, &context, &mut buf, &config).expect("Failed to write JSON");
 
@@ -125,3 +128,107 @@ fn test_json_location_1_indexed() {
     assert_eq!(location["b"]["l"], 1);
     assert_eq!(location["b"]["c"], 1);
 }
+
+#[test]
+fn test_json_location_commonmark_reader() {
+    // The input is not on disk, so locations must resolve from the stored content
+    let (pandoc, context) = commonmark::read("Line 1\n\nLine *3*\n", "not-on-disk.md");
+
+    let mut buf = Vec::new();
+    let config = JsonConfig {
+        include_inline_locations: true,
+        include_block_ids: false,
+    };
+    write_with_config(&pandoc, &context, &mut buf, &config).expect("Failed to write JSON");
+
+    let json: serde_json::Value = serde_json::from_slice(&buf).expect("Invalid JSON");
+
+    let second_para = &json["blocks"][1];
+    assert_eq!(second_para["l"]["b"]["l"], 3);
+    let emph = &second_para["c"][2];
+    assert_eq!(emph["t"], "Emph");
+    assert_eq!(emph["l"]["b"]["l"], 3);
+    assert_eq!(emph["l"]["b"]["c"], 6);
+}
+
+/// Collect the `t` of every AST node (an object with `t` and `s`) lacking `l`
+fn nodes_without_location(value: &serde_json::Value, missing: &mut Vec<String>) {
+    match value {
+        serde_json::Value::Object(obj) => {
+            if let (Some(t), Some(_)) = (obj.get("t"), obj.get("s"))
+                && !obj.contains_key("l")
+            {
+                missing.push(t.to_string());
+            }
+            for child in obj.values() {
+                nodes_without_location(child, missing);
+            }
+        }
+        serde_json::Value::Array(items) => {
+            for item in items {
+                nodes_without_location(item, missing);
+            }
+        }
+        _ => {}
+    }
+}
+
+fn run_pampa_with_stdin(args: &[&str], input: &str) -> String {
+    use std::io::Write;
+    use std::process::{Command, Stdio};
+
+    let mut child = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .spawn()
+        .expect("Failed to start pampa");
+    child
+        .stdin
+        .as_mut()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .expect("Failed to write to stdin");
+    let output = child.wait_with_output().expect("Failed to run pampa");
+    assert!(output.status.success(), "pampa {:?} failed", args);
+    String::from_utf8(output.stdout).unwrap()
+}
+
+#[test]
+fn test_sourcepos_flag_json() {
+    let input = "# Title\n\nSome *text* and `code`.\n\n- item\n";
+    let output = run_pampa_with_stdin(&["-t", "json", "--sourcepos"], input);
+    let json: serde_json::Value = serde_json::from_str(&output).expect("Invalid JSON");
+
+    let mut missing = Vec::new();
+    nodes_without_location(&json["blocks"], &mut missing);
+    assert!(missing.is_empty(), "Nodes without locations: {:?}", missing);
+
+    let header = &json["blocks"][0];
+    assert_eq!(header["t"], "Header");
+    assert_eq!(header["l"]["b"]["l"], 1);
+}
+
+#[test]
+fn test_sourcepos_flag_html() {
+    let output = run_pampa_with_stdin(&["-t", "html", "--sourcepos"], "Some *text*.\n");
+    assert!(output.contains("<p data-sid="), "Got: {}", output);
+    assert!(output.contains("data-loc=\"0:1:1-"), "Got: {}", output);
+
+    let plain = run_pampa_with_stdin(&["-t", "html"], "Some *text*.\n");
+    assert!(!plain.contains("data-loc"), "Got: {}", plain);
+}
+
+#[test]
+fn test_sourcepos_html_list_items_and_cells() {
+    let input = "- one\n- two\n\n| a |\n|---|\n| b |\n";
+    let output = run_pampa_with_stdin(&["-t", "html", "--sourcepos"], input);
+    // Tight items and plain cells have no element but their own
+    assert!(output.contains("<li data-sid="), "Got: {}", output);
+    assert!(output.contains("data-loc=\"0:2:3-"), "Got: {}", output);
+    assert!(output.contains("<td data-sid="), "Got: {}", output);
+
+    let plain = run_pampa_with_stdin(&["-t", "html"], input);
+    assert!(plain.contains("<li>one</li>"), "Got: {}", plain);
+}
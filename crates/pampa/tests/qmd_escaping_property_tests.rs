@@ -0,0 +1,196 @@
+/*
+ * qmd_escaping_property_tests.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Property tests for the qmd writer's escaping of Str text.
+ *
+ * A template document is read, and the text of each of its Strs is
+ * replaced by generated text made of markdown syntax: list markers, rules,
+ * fences, quotes, delimiters, references. Writing the document and reading
+ * it back must give the same AST. Since everything but the text comes from
+ * the reader, only the escaping is under test; the policy is described in
+ * `src/writers/qmd_escape.rs`.
+ *
+ * The template puts Strs where the writer starts lines: at the start of
+ * paragraphs, after soft and hard breaks, in list items and block quotes,
+ * in headings, and in pipe table cells.
+ */
+
+use pampa::pandoc::{Block, Inline, Pandoc, table::Row};
+use pampa::utils::ast_equal::ast_difference;
+use pampa::writers;
+use pampa::writers::qmd::{HeadingStyle, QmdConfig};
+use proptest::prelude::*;
+use proptest::test_runner::FileFailurePersistence;
+
+const TEMPLATE: &str = "\
+x x
+x x\\
+x
+
+* x
+* x x
+
+> x
+> x
+
+# x x
+
+1. x
+
+| x | x |
+|---|---|
+| x | x |
+";
+
+/// Pieces of text the generated Strs are made of. Letters are limited to
+/// `x` and `y`, so no word is one of the abbreviations the reader joins
+/// with the next word.
+const PIECES: &[&str] = &[
+    "x", "y", "1", "-", "+", "*", "_", "#", ">", "<", "|", ":", "=", "~", "^", "`", "$", "@", "{",
+    "}", "[", "]", "(", ")", "\\", "'", "\"", "&", ";", ".", "!", "?", "%", "/", ",", "\u{2019}",
+    "\u{2013}", "1.", "2)", "(x)", "---", ":::", "{{<", ">}}", "&amp;", "&#35;", "==", "~~", "```",
+    "[^1]", "<!--", "-->", "$$",
+];
+
+fn parse_qmd(input: &str) -> Pandoc {
+    match pampa::readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    ) {
+        Ok((pandoc, _, _)) => pandoc,
+        Err(_) => panic!("Failed to parse writer output:\n{}", input),
+    }
+}
+
+fn write_qmd(ast: &Pandoc, config: &QmdConfig) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(ast, config, &mut buf).expect("Failed to write QMD");
+    String::from_utf8(buf).expect("Writer produced invalid UTF-8")
+}
+
+fn config() -> ProptestConfig {
+    ProptestConfig {
+        cases: 512,
+        failure_persistence: Some(Box::new(FileFailurePersistence::SourceParallel(
+            "proptest-regressions",
+        ))),
+        ..ProptestConfig::default()
+    }
+}
+
+fn gen_text() -> BoxedStrategy<String> {
+    prop::collection::vec(prop::sample::select(PIECES), 1..4)
+        .prop_map(|pieces| pieces.concat())
+        .boxed()
+}
+
+/// Replace the text of the Strs of `blocks`, in document order, with
+/// `texts`, starting over when they run out
+fn replace_texts(blocks: &mut [Block], texts: &[String], next: &mut usize) {
+    fn rows(rows: &mut [Row], texts: &[String], next: &mut usize) {
+        for row in rows {
+            for cell in &mut row.cells {
+                replace_texts(&mut cell.content, texts, next);
+            }
+        }
+    }
+    for block in blocks {
+        let inlines = match block {
+            Block::Paragraph(para) => &mut para.content,
+            Block::Plain(plain) => &mut plain.content,
+            Block::Header(header) => &mut header.content,
+            Block::BlockQuote(quote) => {
+                replace_texts(&mut quote.content, texts, next);
+                continue;
+            }
+            Block::BulletList(list) => {
+                for item in &mut list.content {
+                    replace_texts(item, texts, next);
+                }
+                continue;
+            }
+            Block::OrderedList(list) => {
+                for item in &mut list.content {
+                    replace_texts(item, texts, next);
+                }
+                continue;
+            }
+            Block::Table(table) => {
+                rows(&mut table.head.rows, texts, next);
+                for body in &mut table.bodies {
+                    rows(&mut body.head, texts, next);
+                    rows(&mut body.body, texts, next);
+                }
+                rows(&mut table.foot.rows, texts, next);
+                continue;
+            }
+            _ => continue,
+        };
+        for inline in inlines {
+            if let Inline::Str(s) = inline {
+                s.text = texts[*next % texts.len()].clone();
+                *next += 1;
+            }
+        }
+    }
+}
+
+fn document(texts: &[String]) -> Pandoc {
+    let mut doc = parse_qmd(TEMPLATE);
+    replace_texts(&mut doc.blocks, texts, &mut 0);
+    doc
+}
+
+proptest! {
+    #![proptest_config(config())]
+
+    /// Writing any Str text to qmd and reading it back gives the same text.
+    #[test]
+    fn prop_str_text_roundtrip(
+        texts in prop::collection::vec(gen_text(), 1..24),
+        setext in any::<bool>(),
+    ) {
+        let ast = document(&texts);
+        let config = QmdConfig {
+            heading_style: if setext { HeadingStyle::Setext } else { HeadingStyle::Atx },
+            ..QmdConfig::default()
+        };
+        let qmd = write_qmd(&ast, &config);
+        let reparsed = parse_qmd(&qmd);
+        if let Some(diff) = ast_difference(&ast, &reparsed) {
+            prop_assert!(false, "ASTs differ at {}\n--- qmd ---\n{}", diff, qmd);
+        }
+    }
+}
+
+#[test]
+fn test_template_has_strs_everywhere() {
+    // Strs that start lines are escaped, and the others aren't
+    let doc = document(&["1.".to_string()]);
+    let qmd = write_qmd(&doc, &QmdConfig::default());
+    assert!(qmd.starts_with("1\\. 1.\n1\\. 1.\\\n1\\.\n"), "{}", qmd);
+    assert!(qmd.contains("* 1\\.\n* 1\\. 1.\n"), "{}", qmd);
+    assert!(qmd.contains("> 1\\.\n> 1\\.\n"), "{}", qmd);
+}
+
+#[test]
+fn test_escaped_apostrophe_stays_straight() {
+    let doc = parse_qmd("it\\'s and it's\n");
+    let Block::Paragraph(para) = &doc.blocks[0] else {
+        panic!("expected a paragraph: {:?}", doc.blocks);
+    };
+    let words: Vec<&str> = para
+        .content
+        .iter()
+        .filter_map(|inline| match inline {
+            Inline::Str(s) => Some(s.text.as_str()),
+            _ => None,
+        })
+        .collect();
+    assert_eq!(words, vec!["it's", "and", "it\u{2019}s"]);
+}
@@ -0,0 +1,447 @@
+/*
+ * qmd_roundtrip_property_tests.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Round-trip property tests for the qmd writer and reader.
+ *
+ * Random ASTs are written to qmd, read back, and compared with the
+ * `utils::ast_equal` oracle, which ignores source locations. proptest shrinks failing ASTs to a minimal case and records
+ * its seed in `qmd_roundtrip_property_tests.proptest-regressions`, which is
+ * checked in and replayed before any new cases are generated.
+ *
+ * Minimized qmd inputs from the `qmd_roundtrip` fuzz target are kept in
+ * `tests/roundtrip_tests/fuzz-regressions/` and replayed here as well.
+ *
+ * The generators only produce ASTs the reader can produce: lists are tight
+ * (all items start with Plain) or loose (all items start with Para),
+ * headers carry the ids the reader would assign, and two lists are never
+ * adjacent, since their markdown would merge into one list.
+ */
+
+use glob::glob;
+use hashlink::LinkedHashMap;
+use pampa::pandoc::attr::{AttrSourceInfo, TargetSourceInfo, empty_attr};
+use pampa::pandoc::list::{ListNumberDelim, ListNumberStyle};
+use pampa::pandoc::{
+    Block, BlockQuote, BulletList, Code, CodeBlock, ConfigValue, Emph, Header, HorizontalRule,
+    Inline, Link, OrderedList, Pandoc, Paragraph, Plain, SoftBreak, Space, Str, Strong,
+};
+use pampa::utils::ast_equal::ast_difference;
+use pampa::utils::autoid::{auto_generated_id, unique_id};
+use pampa::writers;
+use proptest::prelude::*;
+use proptest::test_runner::FileFailurePersistence;
+use quarto_source_map::SourceInfo;
+use std::collections::HashSet;
+
+// =============================================================================
+// Helpers
+// =============================================================================
+
+fn parse_qmd(input: &str) -> Pandoc {
+    let result = pampa::readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    );
+    match result {
+        Ok((pandoc, _, _)) => pandoc,
+        Err(_) => panic!("Failed to parse writer output:\n{}", input),
+    }
+}
+
+fn write_qmd(ast: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(ast, &mut buf).expect("Failed to write QMD");
+    String::from_utf8(buf).expect("Writer produced invalid UTF-8")
+}
+
+fn config() -> ProptestConfig {
+    ProptestConfig {
+        cases: 256,
+        failure_persistence: Some(Box::new(FileFailurePersistence::SourceParallel(
+            "proptest-regressions",
+        ))),
+        ..ProptestConfig::default()
+    }
+}
+
+fn si() -> SourceInfo {
+    SourceInfo::default()
+}
+
+// =============================================================================
+// Inline generators
+// =============================================================================
+//
+// Inline content is a sequence of units separated by Space (or SoftBreak
+// where allowed). Keeping units apart avoids intraword emphasis and Str
+// merging, which the reader normalizes away by design.
+
+fn gen_word() -> BoxedStrategy<String> {
+    "[a-z]{1,8}".boxed()
+}
+
+fn gen_words() -> BoxedStrategy<Vec<Inline>> {
+    prop::collection::vec(gen_word(), 1..4)
+        .prop_map(|words| {
+            let mut inlines = Vec::new();
+            for (i, word) in words.into_iter().enumerate() {
+                if i > 0 {
+                    inlines.push(Inline::Space(Space { source_info: si() }));
+                }
+                inlines.push(Inline::Str(Str {
+                    text: word,
+                    source_info: si(),
+                }));
+            }
+            inlines
+        })
+        .boxed()
+}
+
+fn gen_unit() -> BoxedStrategy<Vec<Inline>> {
+    prop_oneof![
+        6 => gen_word().prop_map(|text| vec![Inline::Str(Str { text, source_info: si() })]),
+        1 => gen_words().prop_map(|content| vec![Inline::Emph(Emph {
+            content,
+            source_info: si(),
+        })]),
+        1 => gen_words().prop_map(|content| vec![Inline::Strong(Strong {
+            content,
+            source_info: si(),
+        })]),
+        1 => "[a-z]{1,6}( [a-z]{1,6}){0,2}".prop_map(|text| vec![Inline::Code(Code {
+            attr: empty_attr(),
+            text,
+            source_info: si(),
+            attr_source: AttrSourceInfo::empty(),
+        })]),
+        1 => (gen_words(), gen_word()).prop_map(|(content, path)| vec![Inline::Link(Link {
+            attr: empty_attr(),
+            content,
+            target: (format!("https://example.com/{}", path), String::new()),
+            source_info: si(),
+            attr_source: AttrSourceInfo::empty(),
+            target_source: TargetSourceInfo::empty(),
+        })]),
+    ]
+    .boxed()
+}
+
+fn gen_inlines(allow_soft_breaks: bool) -> BoxedStrategy<Vec<Inline>> {
+    prop::collection::vec((gen_unit(), prop::bool::weighted(0.2)), 1..7)
+        .prop_map(move |units| {
+            let mut inlines = Vec::new();
+            for (i, (unit, soft_break)) in units.into_iter().enumerate() {
+                if i > 0 {
+                    inlines.push(if soft_break && allow_soft_breaks {
+                        Inline::SoftBreak(SoftBreak { source_info: si() })
+                    } else {
+                        Inline::Space(Space { source_info: si() })
+                    });
+                }
+                inlines.extend(unit);
+            }
+            inlines
+        })
+        .boxed()
+}
+
+// =============================================================================
+// Block generators
+// =============================================================================
+
+fn gen_para() -> BoxedStrategy<Block> {
+    gen_inlines(true)
+        .prop_map(|content| {
+            Block::Paragraph(Paragraph {
+                content,
+                source_info: si(),
+            })
+        })
+        .boxed()
+}
+
+fn gen_plain() -> BoxedStrategy<Block> {
+    gen_inlines(true)
+        .prop_map(|content| {
+            Block::Plain(Plain {
+                content,
+                source_info: si(),
+            })
+        })
+        .boxed()
+}
+
+/// Headers start with a word, so their ids are never empty
+fn gen_header() -> BoxedStrategy<Block> {
+    (1..=6usize, gen_word(), prop::option::of(gen_inlines(false)))
+        .prop_map(|(level, word, rest)| {
+            let mut content = vec![Inline::Str(Str {
+                text: word,
+                source_info: si(),
+            })];
+            if let Some(rest) = rest {
+                content.push(Inline::Space(Space { source_info: si() }));
+                content.extend(rest);
+            }
+            Block::Header(Header {
+                level,
+                attr: empty_attr(),
+                content,
+                source_info: si(),
+                attr_source: AttrSourceInfo::empty(),
+            })
+        })
+        .boxed()
+}
+
+fn gen_code_block() -> BoxedStrategy<Block> {
+    (
+        prop::option::of("[a-z]{1,6}"),
+        prop::collection::vec("[a-z]{1,10}( [a-z]{1,10}){0,3}", 1..4),
+    )
+        .prop_map(|(language, lines)| {
+            Block::CodeBlock(CodeBlock {
+                attr: (
+                    String::new(),
+                    language.into_iter().collect(),
+                    LinkedHashMap::new(),
+                ),
+                text: lines.join("\n"),
+                source_info: si(),
+                attr_source: AttrSourceInfo::empty(),
+            })
+        })
+        .boxed()
+}
+
+fn gen_hr() -> BoxedStrategy<Block> {
+    Just(Block::HorizontalRule(HorizontalRule { source_info: si() })).boxed()
+}
+
+fn is_list(block: &Block) -> bool {
+    matches!(block, Block::BulletList(_) | Block::OrderedList(_))
+}
+
+fn no_adjacent_lists(blocks: &[Block]) -> bool {
+    !blocks.windows(2).any(|w| is_list(&w[0]) && is_list(&w[1]))
+}
+
+/// A list item: Plain plus an optional sublist when tight, or paragraphs
+/// and sublists when loose.
+fn gen_list_item(depth: usize, tight: bool) -> BoxedStrategy<Vec<Block>> {
+    let sublist = if depth > 0 {
+        prop::option::weighted(0.3, gen_list(depth - 1)).boxed()
+    } else {
+        Just(None).boxed()
+    };
+    if tight {
+        (gen_plain(), sublist)
+            .prop_map(|(plain, sublist)| std::iter::once(plain).chain(sublist).collect())
+            .boxed()
+    } else {
+        (gen_para(), prop::option::weighted(0.3, gen_para()), sublist)
+            .prop_map(|(para, more, sublist)| {
+                std::iter::once(para).chain(more).chain(sublist).collect()
+            })
+            .boxed()
+    }
+}
+
+/// An empty bullet list item, written as `* []`
+fn empty_item(tight: bool) -> Vec<Block> {
+    vec![if tight {
+        Block::Plain(Plain {
+            content: vec![],
+            source_info: si(),
+        })
+    } else {
+        Block::Paragraph(Paragraph {
+            content: vec![],
+            source_info: si(),
+        })
+    }]
+}
+
+fn gen_bullet_list(depth: usize) -> BoxedStrategy<Block> {
+    prop::bool::ANY
+        .prop_flat_map(move |tight| {
+            prop::collection::vec(
+                prop_oneof![
+                    5 => gen_list_item(depth, tight),
+                    1 => Just(empty_item(tight)),
+                ],
+                1..5,
+            )
+        })
+        .prop_map(|content| {
+            Block::BulletList(BulletList {
+                content,
+                source_info: si(),
+            })
+        })
+        .boxed()
+}
+
+fn gen_ordered_list(depth: usize) -> BoxedStrategy<Block> {
+    (
+        prop::bool::ANY,
+        1..10usize,
+        prop_oneof![
+            Just(ListNumberDelim::Period),
+            Just(ListNumberDelim::OneParen)
+        ],
+    )
+        .prop_flat_map(move |(tight, start, delim)| {
+            prop::collection::vec(gen_list_item(depth, tight), 1..5).prop_map(move |content| {
+                Block::OrderedList(OrderedList {
+                    attr: (start, ListNumberStyle::Decimal, delim.clone()),
+                    content,
+                    source_info: si(),
+                })
+            })
+        })
+        .boxed()
+}
+
+fn gen_list(depth: usize) -> BoxedStrategy<Block> {
+    prop_oneof![gen_bullet_list(depth), gen_ordered_list(depth)].boxed()
+}
+
+/// Blocks allowed inside block quotes
+fn gen_nested_block(depth: usize) -> BoxedStrategy<Block> {
+    if depth == 0 {
+        return prop_oneof![3 => gen_para(), 1 => gen_code_block()].boxed();
+    }
+    prop_oneof![
+        3 => gen_para(),
+        1 => gen_code_block(),
+        1 => gen_list(depth - 1),
+        1 => gen_blockquote(depth - 1),
+    ]
+    .boxed()
+}
+
+fn gen_blockquote(depth: usize) -> BoxedStrategy<Block> {
+    prop::collection::vec(gen_nested_block(depth), 1..4)
+        .prop_filter("adjacent lists merge", |blocks| no_adjacent_lists(blocks))
+        .prop_map(|content| {
+            Block::BlockQuote(BlockQuote {
+                content,
+                source_info: si(),
+            })
+        })
+        .boxed()
+}
+
+/// Give headers the ids the reader assigns, deduplicated in document order
+fn assign_header_ids(blocks: &mut [Block], used: &mut HashSet<String>) {
+    for block in blocks {
+        match block {
+            Block::Header(header) => {
+                header.attr.0 = unique_id(&auto_generated_id(&header.content), used);
+                used.insert(header.attr.0.clone());
+            }
+            Block::BlockQuote(quote) => assign_header_ids(&mut quote.content, used),
+            Block::BulletList(list) => {
+                for item in &mut list.content {
+                    assign_header_ids(item, used);
+                }
+            }
+            Block::OrderedList(list) => {
+                for item in &mut list.content {
+                    assign_header_ids(item, used);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+fn gen_document() -> BoxedStrategy<Pandoc> {
+    prop::collection::vec(
+        prop_oneof![
+            4 => gen_para(),
+            2 => gen_header(),
+            1 => gen_code_block(),
+            1 => gen_hr(),
+            2 => gen_blockquote(2),
+            2 => gen_list(2),
+        ],
+        1..7,
+    )
+    .prop_filter("adjacent lists merge", |blocks| no_adjacent_lists(blocks))
+    .prop_map(|mut blocks| {
+        assign_header_ids(&mut blocks, &mut HashSet::new());
+        Pandoc {
+            meta: ConfigValue::default(),
+            blocks,
+        }
+    })
+    .boxed()
+}
+
+// =============================================================================
+// Properties
+// =============================================================================
+
+proptest! {
+    #![proptest_config(config())]
+
+    /// Writing an AST to qmd and reading it back gives the same AST.
+    #[test]
+    fn prop_qmd_roundtrip(ast in gen_document()) {
+        let qmd = write_qmd(&ast);
+        let reparsed = parse_qmd(&qmd);
+        if let Some(diff) = ast_difference(&ast, &reparsed) {
+            prop_assert!(false, "ASTs differ at {}\n--- qmd ---\n{}", diff, qmd);
+        }
+    }
+
+    /// The writer reaches a fixpoint after one round trip.
+    #[test]
+    fn prop_qmd_writer_idempotent(ast in gen_document()) {
+        let qmd = write_qmd(&ast);
+        let rewritten = write_qmd(&parse_qmd(&qmd));
+        prop_assert_eq!(qmd, rewritten);
+    }
+}
+
+// =============================================================================
+// Persisted fuzz corpus
+// =============================================================================
+
+#[test]
+fn test_fuzz_regressions_roundtrip() {
+    let mut file_count = 0;
+    let mut failures = Vec::new();
+
+    for entry in
+        glob("tests/roundtrip_tests/fuzz-regressions/*.qmd").expect("Failed to read glob pattern")
+    {
+        let path = entry.expect("Error reading glob entry");
+        let input = std::fs::read_to_string(&path).expect("Failed to read file");
+        let original = parse_qmd(&input);
+        let reparsed = parse_qmd(&write_qmd(&original));
+        if let Some(diff) = ast_difference(&original, &reparsed) {
+            failures.push(format!("{}: ASTs differ at {}", path.display(), diff));
+        }
+        file_count += 1;
+    }
+
+    assert!(
+        file_count > 0,
+        "No files found in tests/roundtrip_tests/fuzz-regressions/"
+    );
+    assert!(
+        failures.is_empty(),
+        "\n\n{} fuzz regression(s) failed:\n\n{}\n",
+        failures.len(),
+        failures.join("\n")
+    );
+}
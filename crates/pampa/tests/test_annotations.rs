@@ -0,0 +1,110 @@
+/*
+ * test_annotations.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for editor annotations (`<!-- #cmt: ... -->`).
+ */
+
+use pampa::pandoc::{ASTContext, Annotation, Block, Inline, Inlines, Pandoc};
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> (Pandoc, ASTContext) {
+    let (doc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    (doc, context)
+}
+
+fn to_qmd(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn para_inlines(doc: &Pandoc) -> &Inlines {
+    let Block::Paragraph(para) = &doc.blocks[0] else {
+        panic!("Expected a paragraph, got {:?}", doc.blocks[0]);
+    };
+    &para.content
+}
+
+fn annotations(inlines: &[Inline]) -> Vec<Annotation> {
+    inlines
+        .iter()
+        .filter_map(|inline| match inline {
+            Inline::Custom(custom) => Annotation::from_custom(custom),
+            _ => None,
+        })
+        .collect()
+}
+
+#[test]
+fn test_annotation_is_a_structured_node() {
+    let (doc, _context) = read_qmd("Hello <!-- #cmt: check this --> world.\n");
+    let inlines = para_inlines(&doc);
+    assert_eq!(
+        annotations(inlines),
+        vec![Annotation::new("cmt", "check this")]
+    );
+    assert!(
+        inlines
+            .iter()
+            .all(|inline| !matches!(inline, Inline::RawInline(_)))
+    );
+}
+
+#[test]
+fn test_plain_comments_stay_raw() {
+    let (doc, _context) = read_qmd("Hello <!-- just a comment --> world.\n");
+    let inlines = para_inlines(&doc);
+    assert!(annotations(inlines).is_empty());
+    assert!(
+        inlines
+            .iter()
+            .any(|inline| matches!(inline, Inline::RawInline(raw) if raw.format == "html"))
+    );
+}
+
+#[test]
+fn test_qmd_round_trip() {
+    for input in [
+        "Hello <!-- #cmt: check this --> world.\n",
+        "Before.\n\n<!-- #todo: add a figure -->\n\nAfter.\n",
+        "Text<!-- #cmt: attached -->.\n",
+    ] {
+        let (doc, _context) = read_qmd(input);
+        assert_eq!(to_qmd(&doc), input);
+    }
+}
+
+#[test]
+fn test_json_round_trip() {
+    let (doc, context) = read_qmd("Hello <!-- #cmt: check this --> world.\n");
+    let mut buf = Vec::new();
+    writers::json::write(&doc, &context, &mut buf).unwrap();
+    let (read_back, _context) = readers::json::read(&mut buf.as_slice()).unwrap();
+    assert_eq!(
+        annotations(para_inlines(&read_back)),
+        vec![Annotation::new("cmt", "check this")]
+    );
+}
+
+#[test]
+fn test_annotations_are_not_rendered() {
+    let (doc, _context) = read_qmd("Hello <!-- #cmt: check this --> world.\n");
+
+    let (text, diagnostics) = writers::plaintext::inlines_to_string(para_inlines(&doc));
+    assert_eq!(text, "Hello  world.");
+    assert!(diagnostics.is_empty(), "{:?}", diagnostics);
+
+    let mut buf = Vec::new();
+    writers::html::write_blocks_with_config(&doc.blocks, &mut buf, Default::default()).unwrap();
+    let html = String::from_utf8(buf).unwrap();
+    assert_eq!(html, "<p>Hello <!-- #cmt: check this --> world.</p>\n");
+}
@@ -831,6 +831,7 @@ fn test_inline_note() {
         meta: Default::default(),
         blocks: vec![Block::Plain(Plain {
             content: vec![Inline::Note(Note {
+                id: None,
                 content: vec![Block::Plain(Plain {
                     content: vec![Inline::Str(Str {
                         text: "Note content".to_string(),
@@ -0,0 +1,170 @@
+/*
+ * test_ast_diff.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the structural diff of documents and `pampa diff`.
+ */
+
+use pampa::ast_diff::{BlockDiff, DiffOp, diff_documents, locate};
+use pampa::pandoc::{ASTContext, Pandoc};
+use pampa::readers;
+use std::fs;
+use std::process::Command;
+
+fn read(input: &str) -> Pandoc {
+    read_with_context(input).0
+}
+
+fn read_with_context(input: &str) -> (Pandoc, ASTContext) {
+    let (pandoc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    (pandoc, context)
+}
+
+/// The non-unchanged entries as (op, kind, before, after)
+fn changes(blocks: &[BlockDiff]) -> Vec<(DiffOp, &str, Option<Vec<usize>>, Option<Vec<usize>>)> {
+    blocks
+        .iter()
+        .filter(|block| block.op != DiffOp::Unchanged)
+        .map(|block| {
+            (
+                block.op,
+                block.kind.as_str(),
+                block.before.clone(),
+                block.after.clone(),
+            )
+        })
+        .collect()
+}
+
+const TABLE: &str = "| a | b |\n|---|---|\n| 1 | 2 |\n";
+
+#[test]
+fn test_rewrapping_is_not_a_change() {
+    let before = read("# Title\n\nA long sentence that\nis wrapped here.\n");
+    let after = read("# Title\n\nA long sentence\nthat is wrapped here.\n");
+    assert!(diff_documents(&before, &after).is_empty());
+}
+
+#[test]
+fn test_changed_paragraph_and_untouched_table() {
+    let before = read(&format!("# Title\n\nFirst.\n\n{}\nLast.\n", TABLE));
+    let after = read(&format!("# Title\n\nFirst, edited.\n\n{}\nLast.\n", TABLE));
+    let diff = diff_documents(&before, &after);
+    assert!(!diff.meta_changed);
+    assert_eq!(
+        changes(&diff.blocks),
+        vec![(DiffOp::Changed, "Para", Some(vec![1]), Some(vec![1]))]
+    );
+    assert_eq!(diff.blocks[2].op, DiffOp::Unchanged);
+    assert_eq!(diff.blocks[2].kind, "Table");
+}
+
+#[test]
+fn test_inserted_and_deleted_blocks() {
+    let before = read("One.\n\n```\ncode\n```\n\nThree.\n");
+    let after = read("One.\n\nThree.\n\n- new\n");
+    let diff = diff_documents(&before, &after);
+    assert_eq!(
+        changes(&diff.blocks),
+        vec![
+            (DiffOp::Deleted, "CodeBlock", Some(vec![1]), None),
+            (DiffOp::Inserted, "BulletList", None, Some(vec![2])),
+        ]
+    );
+}
+
+#[test]
+fn test_changes_inside_divs_are_located() {
+    let before = read("::: {.note}\nKept.\n\nOld.\n:::\n");
+    let after = read("::: {.note}\nKept.\n\nNew.\n:::\n");
+    let diff = diff_documents(&before, &after);
+    assert_eq!(
+        changes(&diff.blocks),
+        vec![(DiffOp::Changed, "Div", Some(vec![0]), Some(vec![0]))]
+    );
+    assert_eq!(
+        changes(&diff.blocks[0].children),
+        vec![(DiffOp::Changed, "Para", Some(vec![0, 1]), Some(vec![0, 1]))]
+    );
+}
+
+#[test]
+fn test_metadata_changes() {
+    let before = read("---\ntitle: A\n---\n\nText.\n");
+    let after = read("---\ntitle: B\n---\n\nText.\n");
+    let diff = diff_documents(&before, &after);
+    assert!(diff.meta_changed);
+    assert!(changes(&diff.blocks).is_empty());
+}
+
+#[test]
+fn test_located_diff() {
+    let (before, before_context) = read_with_context("# Title\n\nOld.\n\nKept.\n");
+    let (after, after_context) = read_with_context("# Title\n\nNew\ntext.\n\nKept.\n");
+    let diff = diff_documents(&before, &after);
+    let located = locate(&diff, &before, &before_context, &after, &after_context);
+
+    // Locations as (op, start of before, start of after)
+    fn start(loc: &Option<String>) -> Option<&str> {
+        loc.as_deref().and_then(|loc| loc.split('-').next())
+    }
+    let blocks: Vec<_> = located
+        .blocks
+        .iter()
+        .map(|block| (block.op, start(&block.before), start(&block.after)))
+        .collect();
+    assert_eq!(
+        blocks,
+        vec![
+            (DiffOp::Unchanged, Some("0:1:1"), Some("0:1:1")),
+            (DiffOp::Changed, Some("0:3:1"), Some("0:3:1")),
+            // The kept paragraph moved down a line
+            (DiffOp::Unchanged, Some("0:5:1"), Some("0:6:1")),
+        ]
+    );
+}
+
+#[test]
+fn test_diff_command() {
+    let dir = tempfile::tempdir().unwrap();
+    let old = dir.path().join("old.qmd");
+    let new = dir.path().join("new.qmd");
+    fs::write(&old, format!("# Title\n\nFirst.\n\n{}", TABLE)).unwrap();
+    fs::write(&new, format!("# Title\n\nSecond.\n\n{}", TABLE)).unwrap();
+
+    let output = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .arg("diff")
+        .arg(&old)
+        .arg(&new)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    assert!(stdout.contains("~ 2      Para \"Second.\""), "{}", stdout);
+    assert!(!stdout.contains("Table"), "{}", stdout);
+
+    let output = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["diff", "--json"])
+        .arg(&old)
+        .arg(&old)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(0), "{:?}", output);
+    let json: serde_json::Value = serde_json::from_slice(&output.stdout).unwrap();
+    assert_eq!(json["meta_changed"], false);
+    assert!(
+        json["blocks"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .all(|block| block["op"] == "unchanged")
+    );
+}
@@ -0,0 +1,148 @@
+/*
+ * test_ast_query.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for queries over documents and `pampa query`.
+ */
+
+use pampa::ast_query::{Selector, query};
+use pampa::readers;
+use std::fs;
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+const DOCUMENT: &str = "\
+# Introduction {#intro}
+
+See [the site](https://example.org) and [a page](page.html).
+
+## Details
+
+::: {.callout-note}
+A `note` with *emphasis*.
+:::
+
+## More
+";
+
+fn paths(input: &str, selector: &str) -> Vec<Vec<usize>> {
+    let (pandoc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    let selector = Selector::parse(selector).unwrap();
+    query(&pandoc, &context, &selector)
+        .unwrap()
+        .into_iter()
+        .map(|found| found.path)
+        .collect()
+}
+
+#[test]
+fn test_query_read_document() {
+    assert_eq!(paths(DOCUMENT, "Header[level=2]"), vec![vec![2], vec![4]]);
+    assert_eq!(paths(DOCUMENT, "Header#intro > Str"), vec![vec![0, 0]]);
+    assert_eq!(paths(DOCUMENT, "Link[target^=https:]"), vec![vec![1, 2]]);
+    assert_eq!(
+        paths(DOCUMENT, "Div.callout-note Code"),
+        vec![vec![3, 0, 2]]
+    );
+    assert_eq!(paths(DOCUMENT, "Div > Code"), Vec::<Vec<usize>>::new());
+    assert_eq!(
+        paths(DOCUMENT, "Emph, Header[text=More]"),
+        vec![vec![3, 0, 6], vec![4]]
+    );
+}
+
+#[test]
+fn test_query_locations() {
+    let (pandoc, context, _warnings) = readers::qmd::read(
+        DOCUMENT.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    let matches = query(&pandoc, &context, &Selector::parse("Code").unwrap()).unwrap();
+    assert_eq!(matches.len(), 1);
+    assert_eq!(matches[0].kind, "Code");
+    assert_eq!(matches[0].text, "note");
+    assert!(
+        matches[0]
+            .location
+            .as_deref()
+            .is_some_and(|location| location.starts_with("0:8:3-")),
+        "{:?}",
+        matches[0]
+    );
+}
+
+#[test]
+fn test_query_command() {
+    let dir = tempfile::tempdir().unwrap();
+    let file = dir.path().join("doc.qmd");
+    fs::write(&file, DOCUMENT).unwrap();
+
+    let output = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["query", "Header[level=2]"])
+        .arg(&file)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(0), "{:?}", output);
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    let lines: Vec<&str> = stdout.lines().collect();
+    assert_eq!(lines.len(), 2, "{}", stdout);
+    assert!(
+        lines[0].ends_with(":5:1: 3 Header \"Details\""),
+        "{}",
+        stdout
+    );
+    assert!(lines[1].ends_with(":11:1: 5 Header \"More\""), "{}", stdout);
+
+    let output = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["query", "--json", "Link"])
+        .arg(&file)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(0), "{:?}", output);
+    let json: serde_json::Value = serde_json::from_slice(&output.stdout).unwrap();
+    let links = json.as_array().unwrap();
+    assert_eq!(links.len(), 2);
+    assert_eq!(links[1]["path"], serde_json::json!([1, 6]));
+    assert_eq!(links[1]["text"], "a page");
+    assert_eq!(links[1]["file"], file.to_string_lossy().to_string());
+}
+
+#[test]
+fn test_query_command_stdin_and_errors() {
+    let mut child = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["query", "BlockQuote"])
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(b"No quotes here.\n")
+        .unwrap();
+    let output = child.wait_with_output().unwrap();
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    assert!(output.stdout.is_empty());
+
+    let output = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["query", "Header[level=", "missing.qmd"])
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("Invalid selector"), "{}", stderr);
+}
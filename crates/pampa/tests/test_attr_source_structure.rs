@@ -103,6 +103,7 @@ fn test_target_source_info_with_values() {
     let with_values = TargetSourceInfo {
         url: Some(SourceInfo::default()),
         title: Some(SourceInfo::default()),
+        reference_label: None,
     };
 
     assert!(with_values.url.is_some(), "Should have url source");
@@ -115,6 +116,7 @@ fn test_target_source_info_url_only() {
     let url_only = TargetSourceInfo {
         url: Some(SourceInfo::default()),
         title: None, // No title
+        reference_label: None,
     };
 
     assert!(url_only.url.is_some(), "Should have url source");
@@ -0,0 +1,66 @@
+/*
+ * test_batch.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa --batch`.
+ */
+
+use std::fs;
+use std::process::Command;
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+#[test]
+fn test_batch_converts_a_directory() {
+    let input_dir = tempfile::tempdir().unwrap();
+    let output_dir = tempfile::tempdir().unwrap();
+    fs::create_dir(input_dir.path().join("part")).unwrap();
+    fs::write(input_dir.path().join("intro.qmd"), "# Intro\n\nHello.\n").unwrap();
+    fs::write(input_dir.path().join("part/one.qmd"), "*One*\n").unwrap();
+    fs::write(input_dir.path().join("notes.txt"), "Not converted.\n").unwrap();
+
+    let output = Command::new(get_binary_path())
+        .args(["-t", "html", "-j", "2", "--batch"])
+        .arg(input_dir.path())
+        .arg("-o")
+        .arg(output_dir.path())
+        .output()
+        .unwrap();
+
+    assert!(output.status.success(), "{:?}", output);
+    let intro = fs::read_to_string(output_dir.path().join("intro.html")).unwrap();
+    assert!(intro.contains("<p>Hello.</p>"), "{}", intro);
+    let one = fs::read_to_string(output_dir.path().join("part/one.html")).unwrap();
+    assert!(one.contains("<em>One</em>"), "{}", one);
+    assert!(!output_dir.path().join("notes.html").exists());
+    assert!(String::from_utf8_lossy(&output.stderr).contains("Converted 2 files"));
+}
+
+#[test]
+fn test_batch_reports_failures() {
+    let input_dir = tempfile::tempdir().unwrap();
+    let output_dir = tempfile::tempdir().unwrap();
+    fs::write(input_dir.path().join("good.qmd"), "Fine.\n").unwrap();
+    fs::write(input_dir.path().join("bad.qmd"), "[}no]{.hello}\n").unwrap();
+
+    let output = Command::new(get_binary_path())
+        .args(["-t", "qmd", "--batch"])
+        .arg(input_dir.path())
+        .arg("-o")
+        .arg(output_dir.path())
+        .output()
+        .unwrap();
+
+    assert_eq!(output.status.code(), Some(1));
+    assert!(output_dir.path().join("good.qmd").exists());
+    assert!(!output_dir.path().join("bad.qmd").exists());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(
+        stderr.contains("Converted 1 of 2 files; 1 failed:"),
+        "{}",
+        stderr
+    );
+    assert!(stderr.contains("bad.qmd"), "{}", stderr);
+}
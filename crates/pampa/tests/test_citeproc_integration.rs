@@ -154,3 +154,51 @@ references:
         html_output
     );
 }
+
+/// Test that --citeproc with --bibliography reads a BibTeX database.
+#[test]
+fn test_bibtex_bibliography_from_command_line() {
+    let test_dir = tempfile::tempdir().expect("Failed to create temp dir");
+    let test_file = test_dir.path().join("test.qmd");
+    let bib_file = test_dir.path().join("refs.bib");
+
+    let bib_content = r#"@string{aw = "Addison-Wesley"}
+
+@book{knuth1986,
+  author = {Knuth, Donald E.},
+  title = {The {\TeX}book},
+  publisher = aw,
+  year = 1986,
+}
+"#;
+
+    fs::write(&test_file, "As shown [@knuth1986].\n").expect("Failed to write test file");
+    fs::write(&bib_file, bib_content).expect("Failed to write bibliography");
+
+    let binary = get_binary_path();
+    let output = Command::new(&binary)
+        .args(["--citeproc", "--bibliography"])
+        .arg(&bib_file)
+        .args(["-t", "html", "-i"])
+        .arg(&test_file)
+        .output()
+        .expect("Failed to execute binary");
+
+    if !output.status.success() {
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        panic!("Binary failed with: {}", stderr);
+    }
+
+    let html_output = String::from_utf8_lossy(&output.stdout);
+
+    assert!(
+        html_output.contains("(Knuth 1986)"),
+        "Citation not rendered from BibTeX entry. Got: {}",
+        html_output
+    );
+    assert!(
+        html_output.contains("Knuth, Donald E. 1986. ") && html_output.contains("Addison-Wesley"),
+        "Bibliography not rendered from BibTeX entry. Got: {}",
+        html_output
+    );
+}
@@ -0,0 +1,246 @@
+/*
+ * test_crossref.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the crossref transform.
+ */
+
+use pampa::pandoc::{Block, Inline, Pandoc};
+use pampa::transforms::crossref::{CrossrefKind, CrossrefOptions, number_crossrefs};
+use pampa::transforms::resolve_crossrefs;
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn resolve(input: &str) -> (Pandoc, DiagnosticCollector) {
+    let mut doc = read_qmd(input);
+    let mut diagnostics = DiagnosticCollector::new();
+    doc.blocks = resolve_crossrefs(doc.blocks, &doc.meta, &mut diagnostics);
+    (doc, diagnostics)
+}
+
+fn to_html(doc: &Pandoc) -> String {
+    let config = writers::html::extract_config_from_metadata(&doc.meta);
+    let mut buf = Vec::new();
+    writers::html::write_with_config(doc, &mut buf, config).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+#[test]
+fn test_figure_reference() {
+    let (doc, diagnostics) =
+        resolve("![A plot](plot.png){#fig-plot}\n\nSee @fig-plot and [-@fig-plot].\n");
+    assert!(diagnostics.diagnostics().is_empty());
+    let html = to_html(&doc);
+    assert!(html.contains("Figure 1: A plot"), "{}", html);
+    assert!(
+        html.contains("<a href=\"#fig-plot\" class=\"quarto-xref\">Figure\u{a0}1</a>"),
+        "{}",
+        html
+    );
+    assert!(
+        html.contains("<a href=\"#fig-plot\" class=\"quarto-xref\">1</a>"),
+        "{}",
+        html
+    );
+}
+
+#[test]
+fn test_figure_div_is_read_as_figure() {
+    let doc =
+        read_qmd("::: {#fig-plot fig-cap=\"A plot\" fig-align=\"center\"}\n![](plot.png)\n:::\n");
+    let Some(Block::Figure(figure)) = doc.blocks.first() else {
+        panic!("Expected a Figure, got {:?}", doc.blocks);
+    };
+    assert_eq!(figure.attr.0, "fig-plot");
+    assert_eq!(
+        figure.attr.2.get("fig-align").map(String::as_str),
+        Some("center")
+    );
+    assert!(figure.attr.2.get("fig-cap").is_none());
+    let caption = figure.caption.long.as_deref().unwrap_or_default();
+    let text: Vec<_> = match caption {
+        [Block::Plain(plain)] => plain
+            .content
+            .iter()
+            .filter_map(|inline| match inline {
+                Inline::Str(s) => Some(s.text.as_str()),
+                _ => None,
+            })
+            .collect(),
+        _ => panic!("Expected a Plain caption, got {:?}", caption),
+    };
+    assert_eq!(text, ["A", "plot"]);
+}
+
+#[test]
+fn test_numbers_in_document_order() {
+    let input = "\
+## Intro {#sec-intro}
+
+### Detail {#sec-detail}
+
+## Other {.unnumbered}
+
+## Results {#sec-results}
+
+| a |
+|---|
+| 1 |
+
+: First {#tbl-one}
+
+| b |
+|---|
+| 2 |
+
+: Second {#tbl-two}
+
+$$x = 1$$ {#eq-x}
+";
+    let doc = read_qmd(input);
+    let mut diagnostics = DiagnosticCollector::new();
+    let (blocks, index) =
+        number_crossrefs(doc.blocks, &CrossrefOptions::default(), &mut diagnostics);
+
+    let numbers: Vec<(&str, CrossrefKind, &str)> = index
+        .iter()
+        .map(|(label, entry)| (label, entry.kind, entry.number.as_str()))
+        .collect();
+    assert_eq!(
+        numbers,
+        vec![
+            ("sec-intro", CrossrefKind::Section, "1"),
+            ("sec-detail", CrossrefKind::Section, "1.1"),
+            ("sec-results", CrossrefKind::Section, "2"),
+            ("tbl-one", CrossrefKind::Table, "1"),
+            ("tbl-two", CrossrefKind::Table, "2"),
+            ("eq-x", CrossrefKind::Equation, "1"),
+        ]
+    );
+
+    let Some(Block::Paragraph(para)) = blocks.last() else {
+        panic!("Expected the equation paragraph, got {:?}", blocks.last());
+    };
+    let Inline::Span(span) = &para.content[0] else {
+        panic!("Expected a math span, got {:?}", para.content[0]);
+    };
+    let Inline::Math(math) = &span.content[0] else {
+        panic!("Expected math, got {:?}", span.content[0]);
+    };
+    assert_eq!(math.text, "x = 1 \\tag{1}");
+}
+
+#[test]
+fn test_unresolved_reference() {
+    let (doc, diagnostics) = resolve("See @fig-missing.\n");
+    assert_eq!(diagnostics.diagnostics().len(), 1);
+    assert_eq!(diagnostics.diagnostics()[0].code.as_deref(), Some("Q-2-39"));
+    let html = to_html(&doc);
+    assert!(html.contains("<strong>?@fig-missing</strong>"), "{}", html);
+}
+
+#[test]
+fn test_bibliography_citations_are_left_alone() {
+    let (doc, diagnostics) = resolve("As [@knuth; @fig-x] and @knuth show.\n");
+    assert!(diagnostics.diagnostics().is_empty());
+    let Block::Paragraph(para) = &doc.blocks[0] else {
+        panic!("Expected a paragraph");
+    };
+    let cites = para
+        .content
+        .iter()
+        .filter(|inline| matches!(inline, Inline::Cite(_)))
+        .count();
+    assert_eq!(cites, 2);
+}
+
+#[test]
+fn test_options_and_number_sections() {
+    let input = "\
+---
+number-sections: true
+crossref:
+  sec-prefix: §
+  fig-title: Fig.
+  title-delim: \".\"
+---
+
+# Methods {#sec-methods}
+
+::: {#fig-grid}
+Some content.
+
+The grid.
+:::
+
+As in @sec-methods and @Fig-grid.
+";
+    let (doc, diagnostics) = resolve(input);
+    assert!(diagnostics.diagnostics().is_empty());
+    let html = to_html(&doc);
+    assert!(
+        html.contains("<span class=\"header-section-number\">1</span> Methods"),
+        "{}",
+        html
+    );
+    assert!(
+        html.contains("<figcaption>\nFig. 1. The grid.\n</figcaption>"),
+        "{}",
+        html
+    );
+    assert!(html.contains(">§\u{a0}1</a>"), "{}", html);
+    assert!(html.contains(">Figure\u{a0}1</a>"), "{}", html);
+}
+
+#[test]
+fn test_chapter_numbers() {
+    let input = "\
+# Methods {#sec-methods}
+
+## Data {#sec-data}
+
+![A plot](plot.png){#fig-plot}
+
+$$x = 1$$ {#eq-x}
+";
+    let doc = read_qmd(input);
+    let options = CrossrefOptions {
+        chapter: Some("3".to_string()),
+        ..Default::default()
+    };
+    let (_blocks, index) = number_crossrefs(doc.blocks, &options, &mut DiagnosticCollector::new());
+
+    let numbers: Vec<(&str, &str)> = index
+        .iter()
+        .map(|(label, entry)| (label, entry.number.as_str()))
+        .collect();
+    assert_eq!(
+        numbers,
+        vec![
+            ("sec-methods", "3"),
+            ("sec-data", "3.1"),
+            ("fig-plot", "3.1"),
+            ("eq-x", "3.1"),
+        ]
+    );
+}
+
+#[test]
+fn test_duplicate_label() {
+    let (_doc, diagnostics) = resolve("# A {#sec-a}\n\n# B {#sec-a}\n");
+    assert_eq!(diagnostics.diagnostics().len(), 1);
+    assert_eq!(diagnostics.diagnostics()[0].code.as_deref(), Some("Q-2-40"));
+}
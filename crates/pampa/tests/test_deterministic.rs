@@ -0,0 +1,76 @@
+/*
+ * test_deterministic.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa --deterministic`.
+ */
+
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+fn run(args: &[&str], env: &[(&str, &str)], input: &str) -> Vec<u8> {
+    let mut child = Command::new(get_binary_path())
+        .args(args)
+        .envs(env.iter().copied())
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .unwrap();
+    let output = child.wait_with_output().unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    output.stdout
+}
+
+#[test]
+fn test_ansi_output_ignores_the_terminal() {
+    let input = "A paragraph long enough to be wrapped at a narrow width, and at \
+                 eighty columns as well, which takes a few more words.\n";
+    let args = ["-t", "ansi", "--deterministic"];
+    let narrow = run(&args, &[("QUARTO_TERMINAL_WIDTH", "20")], input);
+    let wide = run(&args, &[("QUARTO_TERMINAL_WIDTH", "200")], input);
+    assert_eq!(narrow, wide);
+    let text = String::from_utf8(narrow).unwrap();
+    assert!(text.lines().count() > 1, "{}", text);
+}
+
+#[cfg(feature = "lua-filter")]
+#[test]
+fn test_filter_output_is_the_same_across_runs() {
+    let dir = tempfile::tempdir().unwrap();
+    let filter = dir.path().join("attributes.lua");
+    std::fs::write(
+        &filter,
+        r#"
+function Para(para)
+    local keys = {zeta = "1", alpha = "2", mid = "3", beta = "4", gamma = "5"}
+    return pandoc.Div({para}, pandoc.Attr("", {}, keys))
+end
+"#,
+    )
+    .unwrap();
+    let filter = filter.to_str().unwrap();
+    let args = ["-t", "html", "--deterministic", "--filter", filter];
+
+    // Each run of Lua visits table keys in a different order
+    let first = run(&args, &[], "Hello.\n");
+    for _ in 0..4 {
+        assert_eq!(run(&args, &[], "Hello.\n"), first);
+    }
+    let html = String::from_utf8(first).unwrap();
+    let positions: Vec<usize> = ["alpha", "beta", "gamma", "mid", "zeta"]
+        .iter()
+        .map(|key| html.find(key).unwrap())
+        .collect();
+    assert!(positions.is_sorted(), "{}", html);
+}
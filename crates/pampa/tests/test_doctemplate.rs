@@ -0,0 +1,142 @@
+/*
+ * test_doctemplate.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa doctemplate`.
+ */
+
+use std::fs;
+use std::path::Path;
+use std::process::Command;
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+/// Run `pampa [global_args] doctemplate lint TEMPLATE [args]`
+fn lint(global_args: &[&str], template: &Path, args: &[&str]) -> std::process::Output {
+    Command::new(get_binary_path())
+        .args(global_args)
+        .args(["doctemplate", "lint"])
+        .arg(template)
+        .args(args)
+        .output()
+        .unwrap()
+}
+
+#[test]
+fn test_lint_clean_template() {
+    let dir = tempfile::tempdir().unwrap();
+    let template = dir.path().join("doc.html");
+    fs::write(
+        &template,
+        "$if(title)$<h1>$title$</h1>$endif$\n$header()$\n",
+    )
+    .unwrap();
+    fs::write(dir.path().join("header.html"), "<header>$title$</header>\n").unwrap();
+
+    let output = lint(&[], &template, &[]);
+    assert!(output.status.success(), "{:?}", output);
+    assert!(output.stderr.is_empty(), "{:?}", output);
+}
+
+#[test]
+fn test_lint_reports_errors() {
+    let dir = tempfile::tempdir().unwrap();
+    let template = dir.path().join("doc.html");
+    fs::write(&template, "$if(title)$$title/shout$\n$footer()$\n").unwrap();
+
+    let output = lint(&["--json-errors"], &template, &[]);
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let stderr = String::from_utf8(output.stderr).unwrap();
+    assert!(stderr.contains("Q-10-8"), "{}", stderr);
+    assert!(stderr.contains("Q-10-6"), "{}", stderr);
+}
+
+#[test]
+fn test_lint_with_schema() {
+    let dir = tempfile::tempdir().unwrap();
+    let template = dir.path().join("doc.html");
+    let schema = dir.path().join("schema.json");
+    fs::write(&template, "$title$ $subtitle$\n").unwrap();
+    fs::write(&schema, r#"{"properties": {"title": {"type": "string"}}}"#).unwrap();
+
+    let output = lint(
+        &["--diagnostics"],
+        &template,
+        &["--schema", schema.to_str().unwrap()],
+    );
+    // Variables missing from the schema are warnings
+    assert!(output.status.success(), "{:?}", output);
+    let diagnostics: serde_json::Value = serde_json::from_slice(&output.stderr).unwrap();
+    let diagnostics = diagnostics.as_array().unwrap();
+    assert_eq!(diagnostics.len(), 1);
+    assert_eq!(diagnostics[0]["code"], "Q-10-9");
+}
+
+#[test]
+fn test_lint_missing_template() {
+    let dir = tempfile::tempdir().unwrap();
+    let output = lint(&[], &dir.path().join("missing.html"), &[]);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+}
+
+#[test]
+fn test_fmt_indents_directives() {
+    let dir = tempfile::tempdir().unwrap();
+    let template = dir.path().join("doc.html");
+    fs::write(&template, "$if(a)$\n$for(b)$\n$b$\n$endfor$\n$endif$\n").unwrap();
+
+    let output = Command::new(get_binary_path())
+        .args(["doctemplate", "fmt", "--check"])
+        .arg(&template)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+
+    let output = Command::new(get_binary_path())
+        .args(["doctemplate", "fmt"])
+        .arg(&template)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    assert_eq!(
+        fs::read_to_string(&template).unwrap(),
+        "$if(a)$\n$  for(b)$\n$    b$\n$  endfor$\n$endif$\n"
+    );
+}
+
+#[test]
+fn test_schema_feeds_lint() {
+    let dir = tempfile::tempdir().unwrap();
+    let template = dir.path().join("doc.html");
+    fs::write(
+        &template,
+        "$title$\n$for(author)$$author.name$$endfor$\n$header()$\n",
+    )
+    .unwrap();
+    fs::write(dir.path().join("header.html"), "$if(date)$$date$$endif$\n").unwrap();
+
+    let output = Command::new(get_binary_path())
+        .args(["doctemplate", "schema"])
+        .arg(&template)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    let schema: serde_json::Value = serde_json::from_slice(&output.stdout).unwrap();
+    assert_eq!(schema["required"], serde_json::json!(["title"]));
+    assert_eq!(schema["properties"]["author"]["type"], "array");
+    assert!(schema["properties"]["date"].is_object());
+
+    // The template uses nothing the schema doesn't have
+    let schema_path = dir.path().join("schema.json");
+    fs::write(&schema_path, &output.stdout).unwrap();
+    let output = lint(
+        &["--diagnostics"],
+        &template,
+        &["--schema", schema_path.to_str().unwrap()],
+    );
+    assert!(output.status.success(), "{:?}", output);
+    let diagnostics: serde_json::Value = serde_json::from_slice(&output.stderr).unwrap();
+    assert_eq!(diagnostics, serde_json::json!([]));
+}
@@ -0,0 +1,178 @@
+/*
+ * test_docx_writer.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the DOCX writer.
+ */
+
+use pampa::pandoc::{ASTContext, Pandoc, treesitter_to_pandoc};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::utils::zip::{ZipWriter, read_entry};
+use pampa::writers::docx::{DocxConfig, write, write_with_config};
+use tree_sitter_qmd::MarkdownParser;
+
+fn parse_qmd(qmd: &str) -> Pandoc {
+    let input_bytes = qmd.as_bytes();
+
+    let mut parser = MarkdownParser::default();
+    let tree = parser.parse(input_bytes, None).expect("Failed to parse");
+    let mut error_collector = DiagnosticCollector::new();
+    treesitter_to_pandoc(
+        &mut std::io::sink(),
+        &tree,
+        input_bytes,
+        &ASTContext::anonymous(),
+        &mut error_collector,
+    )
+    .unwrap()
+}
+
+/// Helper to render QMD to a DOCX package
+fn render_qmd_to_docx(qmd: &str) -> Vec<u8> {
+    let mut output = Vec::new();
+    write(&parse_qmd(qmd), &mut output).unwrap();
+    output
+}
+
+fn part(docx: &[u8], name: &str) -> String {
+    let bytes = read_entry(docx, name)
+        .unwrap()
+        .unwrap_or_else(|| panic!("Missing part {}", name));
+    String::from_utf8(bytes).unwrap()
+}
+
+#[test]
+fn test_package_has_required_parts() {
+    let docx = render_qmd_to_docx("Hello.\n");
+    for name in [
+        "[Content_Types].xml",
+        "_rels/.rels",
+        "word/document.xml",
+        "word/styles.xml",
+        "word/numbering.xml",
+        "word/footnotes.xml",
+        "word/_rels/document.xml.rels",
+    ] {
+        assert!(
+            read_entry(&docx, name).unwrap().is_some(),
+            "Missing {}",
+            name
+        );
+    }
+    // No comments were written, so there is no comments part
+    assert!(read_entry(&docx, "word/comments.xml").unwrap().is_none());
+}
+
+#[test]
+fn test_headings_and_paragraphs() {
+    let docx = render_qmd_to_docx("# Intro {#sec-intro}\n\nSome **bold** text.\n");
+    let document = part(&docx, "word/document.xml");
+    assert!(
+        document.contains("<w:pStyle w:val=\"Heading1\"/>"),
+        "Expected heading style, got: {}",
+        document
+    );
+    assert!(document.contains("w:name=\"sec-intro\""));
+    assert!(document.contains("<w:pStyle w:val=\"BodyText\"/>"));
+    assert!(
+        document.contains("<w:rPr><w:b/><w:bCs/></w:rPr><w:t xml:space=\"preserve\">bold</w:t>")
+    );
+}
+
+#[test]
+fn test_lists_use_numbering() {
+    let docx = render_qmd_to_docx("- a\n- b\n\n3. three\n4. four\n");
+    let document = part(&docx, "word/document.xml");
+    let numbering = part(&docx, "word/numbering.xml");
+    assert!(document.contains("<w:numId w:val=\"1\"/>"));
+    assert!(document.contains("<w:numId w:val=\"2\"/>"));
+    assert!(numbering.contains("<w:numFmt w:val=\"bullet\"/>"));
+    assert!(
+        numbering.contains("<w:start w:val=\"3\"/><w:numFmt w:val=\"decimal\"/>"),
+        "Expected ordered list starting at 3, got: {}",
+        numbering
+    );
+}
+
+#[test]
+fn test_table() {
+    let docx = render_qmd_to_docx("| a | b |\n|:--|--:|\n| 1 | 2 |\n");
+    let document = part(&docx, "word/document.xml");
+    assert!(document.contains("<w:tbl>"));
+    assert!(document.contains("<w:tblHeader/>"));
+    assert_eq!(document.matches("<w:gridCol ").count(), 2);
+    assert!(document.contains("<w:jc w:val=\"right\"/>"));
+}
+
+#[test]
+fn test_footnotes() {
+    let docx = render_qmd_to_docx("Text^[A note.] here.\n");
+    let document = part(&docx, "word/document.xml");
+    let footnotes = part(&docx, "word/footnotes.xml");
+    assert!(document.contains("<w:footnoteReference w:id=\"1\"/>"));
+    assert!(footnotes.contains("<w:footnote w:id=\"1\">"));
+    assert!(footnotes.contains("<w:footnoteRef/>"));
+    assert!(footnotes.contains("A note."));
+}
+
+#[test]
+fn test_editorial_marks_become_revisions_and_comments() {
+    let docx = render_qmd_to_docx("Keep [++ added] and [-- removed] text [>> check this].\n");
+    let document = part(&docx, "word/document.xml");
+    assert!(
+        document.contains("<w:ins w:id="),
+        "Expected insertion: {}",
+        document
+    );
+    assert!(document.contains("<w:delText xml:space=\"preserve\">removed</w:delText>"));
+    assert!(document.contains("<w:commentReference w:id=\"0\"/>"));
+
+    let comments = part(&docx, "word/comments.xml");
+    assert!(comments.contains("check this"));
+    assert!(part(&docx, "[Content_Types].xml").contains("/word/comments.xml"));
+    assert!(part(&docx, "word/_rels/document.xml.rels").contains("comments.xml"));
+}
+
+#[test]
+fn test_links_get_relationships() {
+    let docx = render_qmd_to_docx("See [the site](https://example.com).\n");
+    let document = part(&docx, "word/document.xml");
+    let rels = part(&docx, "word/_rels/document.xml.rels");
+    assert!(document.contains("<w:hyperlink r:id=\"rId1\">"));
+    assert!(rels.contains("Target=\"https://example.com\" TargetMode=\"External\""));
+}
+
+#[test]
+fn test_missing_image_falls_back_to_alt_text() {
+    let docx = render_qmd_to_docx("![A missing picture](does-not-exist.png)\n");
+    let document = part(&docx, "word/document.xml");
+    assert!(!document.contains("<w:drawing>"));
+    assert!(document.contains("A missing picture"));
+}
+
+#[test]
+fn test_reference_doc_styles() {
+    let styles = "<?xml version=\"1.0\"?><w:styles xmlns:w=\"x\"><!-- custom --></w:styles>";
+    let mut reference = ZipWriter::new(Vec::new());
+    reference
+        .add_file("word/styles.xml", styles.as_bytes())
+        .unwrap();
+    let config = DocxConfig {
+        reference_doc: Some(reference.finish().unwrap()),
+        resource_dir: None,
+    };
+
+    let mut output = Vec::new();
+    write_with_config(&parse_qmd("Hello.\n"), &config, &mut output).unwrap();
+    assert_eq!(part(&output, "word/styles.xml"), styles);
+}
+
+#[test]
+fn test_reference_doc_without_styles_is_an_error() {
+    let config = DocxConfig {
+        reference_doc: Some(ZipWriter::new(Vec::new()).finish().unwrap()),
+        resource_dir: None,
+    };
+    let mut output = Vec::new();
+    assert!(write_with_config(&parse_qmd("Hello.\n"), &config, &mut output).is_err());
+}
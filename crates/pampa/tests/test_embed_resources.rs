@@ -0,0 +1,85 @@
+/*
+ * test_embed_resources.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `--embed-resources`.
+ */
+
+use std::process::Command;
+
+fn pampa(args: &[&str]) -> std::process::Output {
+    Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(args)
+        .output()
+        .unwrap()
+}
+
+#[test]
+fn test_images_are_embedded_relative_to_the_input() {
+    let dir = tempfile::tempdir().unwrap();
+    std::fs::create_dir(dir.path().join("images")).unwrap();
+    std::fs::write(dir.path().join("images/dot.svg"), "<svg/>").unwrap();
+    let input = dir.path().join("doc.qmd");
+    std::fs::write(
+        &input,
+        "![A dot](images/dot.svg)\n\n![Remote](https://example.com/a.png)\n",
+    )
+    .unwrap();
+
+    let output = pampa(&[
+        "-i",
+        input.to_str().unwrap(),
+        "-t",
+        "html",
+        "--embed-resources",
+    ]);
+    assert!(output.status.success());
+    let html = String::from_utf8(output.stdout).unwrap();
+    // "<svg/>" in base64
+    assert!(
+        html.contains("src=\"data:image/svg+xml;base64,PHN2Zy8+\""),
+        "{}",
+        html
+    );
+    assert!(
+        html.contains("src=\"https://example.com/a.png\""),
+        "{}",
+        html
+    );
+}
+
+#[test]
+fn test_missing_files_are_warned_about() {
+    let dir = tempfile::tempdir().unwrap();
+    let input = dir.path().join("doc.qmd");
+    std::fs::write(&input, "![Gone](gone.png)\n").unwrap();
+
+    let output = pampa(&[
+        "-i",
+        input.to_str().unwrap(),
+        "-t",
+        "html",
+        "--embed-resources",
+    ]);
+    assert!(output.status.success());
+    let html = String::from_utf8(output.stdout).unwrap();
+    assert!(html.contains("src=\"gone.png\""), "{}", html);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("gone.png"), "{}", stderr);
+}
+
+#[test]
+fn test_embed_resources_requires_html() {
+    let dir = tempfile::tempdir().unwrap();
+    let input = dir.path().join("doc.qmd");
+    std::fs::write(&input, "Text.\n").unwrap();
+
+    let output = pampa(&[
+        "-i",
+        input.to_str().unwrap(),
+        "-t",
+        "latex",
+        "--embed-resources",
+    ]);
+    assert!(!output.status.success());
+}
@@ -0,0 +1,259 @@
+/*
+ * test_epub_writer.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the EPUB writer.
+ */
+
+use pampa::pandoc::Pandoc;
+use pampa::readers;
+use pampa::utils::zip::read_entry;
+use pampa::writers::epub::{EpubConfig, write, write_with_config};
+use std::process::Command;
+
+fn parse_qmd(qmd: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        qmd.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+/// Helper to render QMD to an EPUB package
+fn render_qmd_to_epub(qmd: &str) -> Vec<u8> {
+    let mut output = Vec::new();
+    write(&parse_qmd(qmd), &mut output).unwrap();
+    output
+}
+
+fn part(epub: &[u8], name: &str) -> String {
+    let bytes = read_entry(epub, name)
+        .unwrap()
+        .unwrap_or_else(|| panic!("Missing part {}", name));
+    String::from_utf8(bytes).unwrap()
+}
+
+const BOOK: &str = "---
+title: A Book
+author:
+  - Ada
+  - name: Grace
+lang: fr
+---
+
+# One {#sec-one}
+
+Text, see [two](#sec-two).
+
+## Part {#sec-part}
+
+More.
+
+# Two {#sec-two}
+
+Back to [one](#sec-one).
+";
+
+#[test]
+fn test_package_layout() {
+    let epub = render_qmd_to_epub(BOOK);
+    // The mimetype is the first entry, stored
+    assert_eq!(&epub[30..38], b"mimetype");
+    assert_eq!(&epub[38..58], b"application/epub+zip");
+    for name in [
+        "META-INF/container.xml",
+        "EPUB/content.opf",
+        "EPUB/nav.xhtml",
+        "EPUB/toc.ncx",
+        "EPUB/styles/stylesheet.css",
+        "EPUB/text/title_page.xhtml",
+        "EPUB/text/ch001.xhtml",
+        "EPUB/text/ch002.xhtml",
+    ] {
+        assert!(
+            read_entry(&epub, name).unwrap().is_some(),
+            "Missing {}",
+            name
+        );
+    }
+    assert!(
+        read_entry(&epub, "EPUB/text/ch003.xhtml")
+            .unwrap()
+            .is_none()
+    );
+}
+
+#[test]
+fn test_metadata() {
+    let opf = part(&render_qmd_to_epub(BOOK), "EPUB/content.opf");
+    assert!(
+        opf.contains("<dc:title id=\"epub-title-1\">A Book</dc:title>"),
+        "{}",
+        opf
+    );
+    assert!(opf.contains(">Ada</dc:creator>"), "{}", opf);
+    assert!(opf.contains(">Grace</dc:creator>"), "{}", opf);
+    assert!(opf.contains("<dc:language>fr</dc:language>"), "{}", opf);
+    assert!(
+        opf.contains("<dc:identifier id=\"epub-id-1\">urn:uuid:"),
+        "{}",
+        opf
+    );
+    assert!(
+        opf.contains("<meta property=\"dcterms:modified\">1970-01-01T00:00:00Z</meta>"),
+        "{}",
+        opf
+    );
+    assert!(opf.contains("properties=\"nav\""), "{}", opf);
+    assert!(opf.contains("<item id=\"toc_ncx\""), "{}", opf);
+    assert!(opf.contains("<spine toc=\"toc_ncx\">"), "{}", opf);
+}
+
+#[test]
+fn test_book_metadata() {
+    // A _quarto.yml given with --metadata-file puts these under `book`
+    let epub = render_qmd_to_epub(
+        "---\nbook:\n  title: From the Project\n  author: Ada\n---\n\n# One\n\nText.\n",
+    );
+    let opf = part(&epub, "EPUB/content.opf");
+    assert!(opf.contains(">From the Project</dc:title>"), "{}", opf);
+    assert!(opf.contains(">Ada</dc:creator>"), "{}", opf);
+    let title_page = part(&epub, "EPUB/text/title_page.xhtml");
+    assert!(title_page.contains("From the Project"), "{}", title_page);
+}
+
+#[test]
+fn test_nav_and_ncx() {
+    let epub = render_qmd_to_epub(BOOK);
+    let nav = part(&epub, "EPUB/nav.xhtml");
+    assert!(
+        nav.contains("<a href=\"text/ch001.xhtml#sec-one\">One</a>"),
+        "{}",
+        nav
+    );
+    assert!(
+        nav.contains("<a href=\"text/ch001.xhtml#sec-part\">Part</a>"),
+        "{}",
+        nav
+    );
+    assert!(nav.contains("epub:type=\"landmarks\""), "{}", nav);
+    let ncx = part(&epub, "EPUB/toc.ncx");
+    assert!(
+        ncx.contains("<content src=\"text/ch002.xhtml#sec-two\" />"),
+        "{}",
+        ncx
+    );
+}
+
+#[test]
+fn test_links_between_chapters() {
+    let epub = render_qmd_to_epub(BOOK);
+    let one = part(&epub, "EPUB/text/ch001.xhtml");
+    assert!(one.contains("href=\"ch002.xhtml#sec-two\""), "{}", one);
+    assert!(one.starts_with("<?xml"), "{}", one);
+    assert!(
+        one.contains("xmlns=\"http://www.w3.org/1999/xhtml\""),
+        "{}",
+        one
+    );
+    let two = part(&epub, "EPUB/text/ch002.xhtml");
+    assert!(two.contains("href=\"ch001.xhtml#sec-one\""), "{}", two);
+}
+
+#[test]
+fn test_footnotes_stay_in_their_chapter() {
+    let epub = render_qmd_to_epub("# One\n\nA^[First.]\n\n# Two\n\nB^[Second.]\n");
+    for name in ["EPUB/text/ch001.xhtml", "EPUB/text/ch002.xhtml"] {
+        let chapter = part(&epub, name);
+        assert!(chapter.contains("href=\"#fn1\""), "{}", chapter);
+        assert!(chapter.contains("id=\"fn1\""), "{}", chapter);
+    }
+}
+
+#[test]
+fn test_images_and_fonts_are_embedded() {
+    let dir = tempfile::TempDir::new().unwrap();
+    std::fs::write(
+        dir.path().join("pixel.png"),
+        b"\x89PNG\r\n\x1a\n\0\0\0\rIHDR\0\0\0\x01\0\0\0\x01",
+    )
+    .unwrap();
+    std::fs::write(dir.path().join("Serif.ttf"), b"font data").unwrap();
+    let doc = parse_qmd(
+        "---\nepub-fonts: [Serif.ttf]\n---\n\n# One\n\n![A pixel](pixel.png)\n\n![Gone](missing.png)\n",
+    );
+    let config = EpubConfig {
+        resource_dir: Some(dir.path().to_path_buf()),
+        ..EpubConfig::default()
+    };
+    let mut epub = Vec::new();
+    write_with_config(&doc, &config, &mut epub).unwrap();
+
+    let chapter = part(&epub, "EPUB/text/ch001.xhtml");
+    assert!(
+        chapter.contains("src=\"../media/file0.png\""),
+        "{}",
+        chapter
+    );
+    // Images that can't be read keep their reference
+    assert!(chapter.contains("src=\"missing.png\""), "{}", chapter);
+    assert!(read_entry(&epub, "EPUB/media/file0.png").unwrap().is_some());
+    assert_eq!(
+        read_entry(&epub, "EPUB/fonts/Serif.ttf").unwrap().unwrap(),
+        b"font data"
+    );
+    let opf = part(&epub, "EPUB/content.opf");
+    assert!(
+        opf.contains("href=\"media/file0.png\" media-type=\"image/png\""),
+        "{}",
+        opf
+    );
+    assert!(
+        opf.contains("href=\"fonts/Serif.ttf\" media-type=\"font/ttf\""),
+        "{}",
+        opf
+    );
+    let css = part(&epub, "EPUB/styles/stylesheet.css");
+    assert!(css.contains("font-family: \"Serif\""), "{}", css);
+}
+
+#[test]
+fn test_output_is_deterministic() {
+    assert_eq!(render_qmd_to_epub(BOOK), render_qmd_to_epub(BOOK));
+}
+
+#[test]
+fn test_cli_requires_output_file() {
+    let dir = tempfile::TempDir::new().unwrap();
+    let input = dir.path().join("book.qmd");
+    std::fs::write(&input, BOOK).unwrap();
+    let pampa = env!("CARGO_BIN_EXE_pampa");
+
+    let output = Command::new(pampa)
+        .args(["-t", "epub", "-i"])
+        .arg(&input)
+        .output()
+        .unwrap();
+    assert!(!output.status.success());
+
+    let epub_path = dir.path().join("book.epub");
+    let output = Command::new(pampa)
+        .args(["-t", "epub", "-i"])
+        .arg(&input)
+        .arg("-o")
+        .arg(&epub_path)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    let epub = std::fs::read(&epub_path).unwrap();
+    assert!(
+        read_entry(&epub, "EPUB/text/ch002.xhtml")
+            .unwrap()
+            .is_some()
+    );
+}
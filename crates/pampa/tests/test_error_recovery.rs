@@ -0,0 +1,103 @@
+/*
+ * test_error_recovery.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for reading broken documents with `read_recovering` and
+ * `pampa --diagnostics`.
+ */
+
+use pampa::pandoc::Block;
+use pampa::readers::qmd::read_recovering;
+use quarto_error_reporting::DiagnosticKind;
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+const BROKEN: &str = "Before.\n\n[}no]{.hello}\n\nAfter.\n";
+
+fn para_text(block: &Block) -> String {
+    let (text, _diagnostics) = pampa::writers::plaintext::blocks_to_string(&[block.clone()]);
+    text.trim().to_string()
+}
+
+#[test]
+fn test_read_recovering_keeps_the_blocks_that_parse() {
+    let (doc, context, diagnostics) = read_recovering(BROKEN.as_bytes(), false, "test.qmd", true);
+    let texts: Vec<String> = doc.blocks.iter().map(para_text).collect();
+    assert_eq!(texts, vec!["Before.", "After."]);
+
+    let errors: Vec<_> = diagnostics
+        .iter()
+        .filter(|diagnostic| diagnostic.kind == DiagnosticKind::Error)
+        .collect();
+    assert!(!errors.is_empty(), "{:?}", diagnostics);
+    // The error points into the broken chunk of the whole input
+    let location = errors[0].location.as_ref().expect("a located error");
+    let start = location.map_offset(0, &context.source_context).unwrap();
+    assert_eq!(start.location.row, 2);
+}
+
+#[test]
+fn test_read_recovering_reports_bad_yaml() {
+    let input = "---\ntitle: [unclosed\n---\n\nText.\n";
+    let (doc, _context, diagnostics) = read_recovering(input.as_bytes(), false, "test.qmd", true);
+    assert!(
+        diagnostics
+            .iter()
+            .any(|diagnostic| diagnostic.kind == DiagnosticKind::Error),
+        "{:?}",
+        diagnostics
+    );
+    assert_eq!(
+        doc.blocks.iter().map(para_text).collect::<Vec<_>>(),
+        vec!["Text."]
+    );
+}
+
+#[test]
+fn test_read_recovering_of_a_good_document_is_read() {
+    let input = "# Title\n\nText.\n";
+    let (doc, _context, diagnostics) = read_recovering(input.as_bytes(), false, "test.qmd", true);
+    let (expected, _context, _warnings) = pampa::readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    assert!(diagnostics.is_empty(), "{:?}", diagnostics);
+    assert_eq!(doc.blocks.len(), expected.blocks.len());
+}
+
+#[test]
+fn test_diagnostics_flag() {
+    let mut child = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(["-t", "html", "--diagnostics"])
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(BROKEN.as_bytes())
+        .unwrap();
+    let output = child.wait_with_output().unwrap();
+
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let html = String::from_utf8_lossy(&output.stdout);
+    assert!(html.contains("<p>Before.</p>"), "{}", html);
+    assert!(html.contains("<p>After.</p>"), "{}", html);
+
+    let diagnostics: serde_json::Value = serde_json::from_slice(&output.stderr).unwrap();
+    let error = diagnostics
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|diagnostic| diagnostic["kind"] == "error")
+        .expect("an error diagnostic");
+    assert_eq!(error["range"]["start"]["line"], 3);
+}
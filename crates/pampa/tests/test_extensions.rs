@@ -0,0 +1,161 @@
+/*
+ * test_extensions.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `-f FORMAT+ext-ext` and `--list-extensions`.
+ */
+
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+fn run(args: &[&str], input: &str) -> std::process::Output {
+    let mut child = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .unwrap();
+    child.wait_with_output().unwrap()
+}
+
+fn stdout(output: &std::process::Output) -> String {
+    assert!(output.status.success(), "{:?}", output);
+    String::from_utf8_lossy(&output.stdout).to_string()
+}
+
+#[test]
+fn test_smart_extension() {
+    let input = "From 1990--2000.\n";
+    let html = stdout(&run(&["-f", "qmd+smart", "-t", "html"], input));
+    assert!(html.contains("1990\u{2013}2000"), "{}", html);
+    let html = stdout(&run(&["-f", "qmd", "-t", "html"], input));
+    assert!(html.contains("1990--2000"), "{}", html);
+}
+
+#[test]
+fn test_auto_identifiers_extension() {
+    let input = "# Made Up\n\n# Written {#written}\n";
+    let html = stdout(&run(&["-f", "qmd", "-t", "html"], input));
+    assert!(html.contains("id=\"made-up\""), "{}", html);
+    let html = stdout(&run(&["-f", "qmd-auto_identifiers", "-t", "html"], input));
+    assert!(!html.contains("made-up"), "{}", html);
+    assert!(html.contains("id=\"written\""), "{}", html);
+}
+
+#[test]
+fn test_links_to_headings_without_identifiers() {
+    // Links written against the identifiers Pandoc makes
+    let input = "# 1. Getting *started*\n\nSee [the start](#getting-started).\n";
+    let html = stdout(&run(&["-f", "qmd", "-t", "html"], input));
+    assert!(html.contains("<h1 id=\"getting-started\">"), "{}", html);
+    let html = stdout(&run(&["-f", "gfm", "-t", "html"], input));
+    assert!(html.contains("<h1 id=\"1-getting-started\">"), "{}", html);
+    let html = stdout(&run(&["-f", "commonmark", "-t", "html"], input));
+    assert!(!html.contains("<h1 id="), "{}", html);
+}
+
+#[test]
+fn test_identifier_extensions() {
+    let input = "# Crème brûlée\n\n# Crème brûlée\n";
+    let html = stdout(&run(&["-f", "qmd+ascii_identifiers", "-t", "html"], input));
+    assert!(html.contains("id=\"creme-brulee\""), "{}", html);
+    assert!(html.contains("id=\"creme-brulee-1\""), "{}", html);
+    let html = stdout(&run(
+        &["-f", "qmd+gfm_auto_identifiers", "-t", "html"],
+        "# v1.2 notes\n",
+    ));
+    assert!(html.contains("id=\"v12-notes\""), "{}", html);
+}
+
+#[test]
+fn test_footnotes_extension() {
+    let input = "Text.[^1]\n\n[^1]: The note.\n\nAfter.\n";
+    // Without joining, the definition stays where it was written
+    let qmd = stdout(&run(&["-f", "qmd-footnotes", "-t", "qmd"], input));
+    assert!(qmd.contains("[^1]: The note.\n\nAfter."), "{}", qmd);
+    let qmd = stdout(&run(&["-f", "qmd", "-t", "qmd"], input));
+    assert!(qmd.contains("After.\n\n[^1]: The note."), "{}", qmd);
+}
+
+#[test]
+fn test_superscript_subscript_strikeout_extensions() {
+    let input = "H~2~O, 2^10^ and ~~wrong~~.\n";
+    let html = stdout(&run(&["-f", "qmd", "-t", "html"], input));
+    assert!(html.contains("H<sub>2</sub>O"), "{}", html);
+    assert!(html.contains("<del>wrong</del>"), "{}", html);
+    let html = stdout(&run(
+        &["-f", "qmd-subscript-strikeout", "-t", "html"],
+        input,
+    ));
+    assert!(
+        html.contains("H~2~O, 2<sup>10</sup> and ~~wrong~~."),
+        "{}",
+        html
+    );
+    let qmd = stdout(&run(&["-f", "qmd-superscript", "-t", "qmd"], input));
+    assert_eq!(qmd, "H~2~O, 2\\^10\\^ and ~~wrong~~.\n");
+}
+
+#[test]
+fn test_mark_extension() {
+    let input = "Some ==marked== text.\n";
+    let html = stdout(&run(&["-f", "qmd+mark", "-t", "html"], input));
+    assert!(html.contains("Some <mark>marked</mark> text."), "{}", html);
+    let html = stdout(&run(&["-f", "qmd", "-t", "html"], input));
+    assert!(html.contains("Some ==marked== text."), "{}", html);
+    let qmd = stdout(&run(&["-f", "qmd+mark", "-t", "qmd+mark"], input));
+    assert_eq!(qmd, input);
+    // Text that isn't a highlight is escaped so it isn't read as one
+    let qmd = stdout(&run(&["-f", "qmd", "-t", "qmd+mark"], input));
+    assert_eq!(qmd, "Some \\=\\=marked\\=\\= text.\n");
+}
+
+#[test]
+fn test_block_ids_extension() {
+    fn ids(json: &str) -> Vec<serde_json::Value> {
+        let value: serde_json::Value = serde_json::from_str(json).unwrap();
+        value["blocks"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|block| block["blockId"].clone())
+            .collect()
+    }
+    let input = "# Title\n\nSome text.\n";
+    let json = stdout(&run(&["-f", "qmd+block_ids", "-t", "json"], input));
+    assert!(ids(&json).iter().all(|id| id.is_string()), "{}", json);
+    // The document written back as qmd gets the same IDs
+    let qmd = stdout(&run(&["-f", "qmd", "-t", "qmd"], input));
+    let again = stdout(&run(&["-f", "qmd+block_ids", "-t", "json"], &qmd));
+    assert_eq!(ids(&json), ids(&again));
+    let json = stdout(&run(&["-f", "qmd", "-t", "json"], input));
+    assert!(!json.contains("blockId"), "{}", json);
+}
+
+#[test]
+fn test_unsupported_extension_is_an_error() {
+    let output = run(&["-f", "qmd+nonsense"], "Text.\n");
+    assert_eq!(output.status.code(), Some(1));
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(
+        stderr.contains("The qmd reader has no extension 'nonsense'"),
+        "{}",
+        stderr
+    );
+}
+
+#[test]
+fn test_list_extensions() {
+    let output = stdout(&run(&["--list-extensions"], ""));
+    assert!(output.contains("+footnotes (reader)"), "{}", output);
+    assert!(output.contains("-smart (writer)"), "{}", output);
+    let output = stdout(&run(&["--list-extensions=html"], ""));
+    assert!(output.is_empty(), "{}", output);
+}
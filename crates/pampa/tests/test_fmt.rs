@@ -0,0 +1,126 @@
+/*
+ * test_fmt.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa fmt`.
+ */
+
+use std::fs;
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+const MESSY: &str = "\
+---
+# The title
+title: Formatting
+---
+
+# Heading
+
+- one
+- two
+
+A paragraph that is long enough to need filling, written on one line by _someone_ who never breaks lines.
+
+[text]{key=value .cls #id}
+";
+
+fn fmt_stdin(input: &str, args: &[&str]) -> std::process::Output {
+    let mut child = Command::new(get_binary_path())
+        .arg("fmt")
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .unwrap();
+    child.wait_with_output().unwrap()
+}
+
+#[test]
+fn test_fmt_writes_canonical_qmd() {
+    let output = fmt_stdin(MESSY, &[]);
+    assert!(output.status.success(), "{:?}", output);
+    let formatted = String::from_utf8(output.stdout).unwrap();
+    assert!(
+        formatted.starts_with("---\n# The title\ntitle: Formatting\n---\n"),
+        "{}",
+        formatted
+    );
+    assert!(formatted.contains("*someone*"), "{}", formatted);
+    assert!(formatted.contains("* one\n* two\n"), "{}", formatted);
+    assert!(
+        formatted.contains("[text]{#id .cls key=\"value\"}"),
+        "{}",
+        formatted
+    );
+    assert!(
+        formatted.lines().all(|line| line.chars().count() <= 72),
+        "{}",
+        formatted
+    );
+}
+
+#[test]
+fn test_fmt_is_idempotent() {
+    let once = String::from_utf8(fmt_stdin(MESSY, &[]).stdout).unwrap();
+    let twice = fmt_stdin(&once, &[]);
+    assert!(twice.status.success(), "{:?}", twice);
+    assert_eq!(String::from_utf8(twice.stdout).unwrap(), once);
+    assert!(fmt_stdin(&once, &["--check"]).status.success());
+}
+
+#[test]
+fn test_fmt_files_in_place_and_check() {
+    let dir = tempfile::tempdir().unwrap();
+    let messy = dir.path().join("messy.qmd");
+    let tidy = dir.path().join("tidy.qmd");
+    fs::write(&messy, MESSY).unwrap();
+    fs::write(&tidy, "# Tidy\n\nAlready formatted.\n").unwrap();
+
+    let output = Command::new(get_binary_path())
+        .args(["fmt", "--check"])
+        .arg(&messy)
+        .arg(&tidy)
+        .output()
+        .unwrap();
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    assert!(stdout.contains("messy.qmd"), "{}", stdout);
+    assert!(!stdout.contains("tidy.qmd"), "{}", stdout);
+    assert_eq!(fs::read_to_string(&messy).unwrap(), MESSY);
+
+    let output = Command::new(get_binary_path())
+        .arg("fmt")
+        .arg(&messy)
+        .arg(&tidy)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    assert_ne!(fs::read_to_string(&messy).unwrap(), MESSY);
+
+    let output = Command::new(get_binary_path())
+        .args(["fmt", "--check"])
+        .arg(&messy)
+        .arg(&tidy)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "{:?}", output);
+}
+
+#[test]
+fn test_fmt_reports_parse_errors() {
+    let output = fmt_stdin("[}no]{.hello}\n", &[]);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+    assert!(output.stdout.is_empty());
+}
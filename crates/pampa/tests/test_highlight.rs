@@ -0,0 +1,188 @@
+/*
+ * test_highlight.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for code block highlighting in the HTML and LaTeX writers.
+ */
+
+use pampa::highlight::{Highlighter, Theme, Token, TokenKind};
+use pampa::pandoc::Pandoc;
+use pampa::template::builtin::get_builtin_template;
+use pampa::template::{BodyFormat, render_with_bundle};
+use pampa::{readers, wasm_entry_points, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn to_html(input: &str) -> String {
+    let doc = read_qmd(input);
+    let config = writers::html::extract_config_from_metadata(&doc.meta);
+    let mut buf = Vec::new();
+    writers::html::write_with_config(&doc, &mut buf, config).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn to_latex(input: &str) -> String {
+    let mut buf = Vec::new();
+    writers::latex::write(&read_qmd(input), &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn token(kind: TokenKind, text: &str) -> Token {
+    Token {
+        kind,
+        text: text.to_string(),
+    }
+}
+
+#[test]
+fn test_builtin_lexer_tokens() {
+    let highlighter = Highlighter::with_builtin_languages();
+    let lines = highlighter
+        .highlight("Python", "def f(x):\n    return x + 1.5  # done")
+        .unwrap();
+    assert_eq!(
+        lines[0],
+        vec![
+            token(TokenKind::Keyword, "def"),
+            token(TokenKind::Normal, " "),
+            token(TokenKind::Function, "f"),
+            token(TokenKind::Normal, "(x):"),
+        ]
+    );
+    assert_eq!(
+        lines[1],
+        vec![
+            token(TokenKind::Normal, "    "),
+            token(TokenKind::Keyword, "return"),
+            token(TokenKind::Normal, " x "),
+            token(TokenKind::Operator, "+"),
+            token(TokenKind::Normal, " "),
+            token(TokenKind::Float, "1.5"),
+            token(TokenKind::Normal, "  "),
+            token(TokenKind::Comment, "# done"),
+        ]
+    );
+    assert!(highlighter.highlight("cobol", "MOVE A TO B").is_none());
+}
+
+#[test]
+fn test_strings_and_comments_span_lines() {
+    let highlighter = Highlighter::with_builtin_languages();
+    let lines = highlighter
+        .highlight("js", "/* a\nb */ x = `c\nd`;")
+        .unwrap();
+    assert_eq!(lines[0], vec![token(TokenKind::Comment, "/* a")]);
+    assert_eq!(lines[1][0], token(TokenKind::Comment, "b */"));
+    assert_eq!(lines[1].last().unwrap(), &token(TokenKind::String, "`c"));
+    assert_eq!(lines[2][0], token(TokenKind::String, "d`"));
+}
+
+#[test]
+fn test_html_code_block_is_highlighted() {
+    let html = to_html("```python\nimport os\nx = \"<a>\"\n```\n");
+    assert!(
+        html.starts_with(
+            "<div class=\"sourceCode\" id=\"cb1\"><pre class=\"sourceCode python\">\
+             <code class=\"sourceCode python\"><span id=\"cb1-1\"><a href=\"#cb1-1\" \
+             aria-hidden=\"true\" tabindex=\"-1\"></a><span class=\"kw\">import</span> os</span>"
+        ),
+        "Expected a highlighted code block, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<span class=\"st\">&quot;&lt;a&gt;&quot;</span></span></code></pre></div>"),
+        "Expected an escaped string token, got: {}",
+        html
+    );
+}
+
+#[test]
+fn test_executable_cell_and_own_id() {
+    let html = to_html("```{r}\nx <- TRUE\n```\n\n```{.r #setup}\nlibrary(x)\n```\n");
+    assert!(html.contains("<span class=\"cn\">TRUE</span>"), "{}", html);
+    assert!(
+        html.contains("<div class=\"sourceCode\" id=\"setup\">"),
+        "{}",
+        html
+    );
+    assert!(html.contains("<span id=\"setup-1\">"), "{}", html);
+}
+
+#[test]
+fn test_unknown_language_and_no_highlight() {
+    let html = to_html("```cobol\nMOVE A TO B\n```\n");
+    assert!(html.starts_with("<pre class=\"cobol\"><code>"), "{}", html);
+
+    let html = to_html("---\nhighlight-style: none\n---\n\n```python\nx = 1\n```\n");
+    assert!(html.starts_with("<pre class=\"python\"><code>"), "{}", html);
+}
+
+#[test]
+fn test_latex_code_block_is_highlighted() {
+    let latex = to_latex("```python\nd = {\"a\\\\b\": 1}\n```\n");
+    assert_eq!(
+        latex,
+        "\\begin{Shaded}\n\\begin{Highlighting}[]\n\
+         \\NormalTok{d }\\OperatorTok{=}\\NormalTok{ \\{}\\StringTok{\"a\\textbackslash{}\\textbackslash{}b\"}\
+         \\NormalTok{: }\\DecValTok{1}\\NormalTok{\\}}\n\
+         \\end{Highlighting}\n\\end{Shaded}\n"
+    );
+
+    let latex = to_latex("---\nhighlight-style: none\n---\n\n```python\nx = 1\n```\n");
+    assert!(latex.contains("\\begin{verbatim}"), "{}", latex);
+}
+
+#[test]
+fn test_templates_include_theme_when_highlighting() {
+    let input = "---\ntitle: T\nhighlight-style: tango\n---\n\n```python\nx = 1\n```\n";
+    let (pandoc, mut context) = wasm_entry_points::qmd_to_pandoc(input.as_bytes()).unwrap();
+
+    let bundle = get_builtin_template("html").unwrap();
+    let (html, _) =
+        render_with_bundle(&pandoc, &mut context, &bundle, "html", BodyFormat::Html).unwrap();
+    assert!(html.contains("code span.kw { color: #204a87; font-weight: bold; } /* Keyword */"));
+    assert!(html.contains("div.sourceCode { background-color: #f8f8f8; }"));
+
+    let bundle = get_builtin_template("latex").unwrap();
+    let (latex, _) =
+        render_with_bundle(&pandoc, &mut context, &bundle, "latex", BodyFormat::Latex).unwrap();
+    assert!(latex.contains("\\DefineVerbatimEnvironment{Highlighting}"));
+    assert!(
+        latex.contains(
+            "\\newcommand{\\KeywordTok}[1]{\\textcolor[rgb]{0.13,0.29,0.53}{\\textbf{#1}}}"
+        )
+    );
+
+    // No highlighted code, no theme
+    let (pandoc, mut context) = wasm_entry_points::qmd_to_pandoc(b"Text.\n").unwrap();
+    let bundle = get_builtin_template("html").unwrap();
+    let (html, _) =
+        render_with_bundle(&pandoc, &mut context, &bundle, "html", BodyFormat::Html).unwrap();
+    assert!(!html.contains("code span.kw"));
+}
+
+#[test]
+fn test_theme_from_metadata() {
+    let doc = read_qmd(
+        "---\nhighlight-style:\n  background-color: \"#101010\"\n  text-styles:\n    \
+         Keyword:\n      text-color: \"#ff0000\"\n      italic: true\n    Bogus:\n      bold: true\n---\n",
+    );
+    let theme = Theme::from_config(doc.meta.get("highlight-style").unwrap());
+    assert_eq!(theme.background_color.unwrap().css(), "#101010");
+    let keyword = theme.style(TokenKind::Keyword);
+    assert_eq!(keyword.color.unwrap().css(), "#ff0000");
+    assert!(keyword.italic && !keyword.bold);
+    assert_eq!(theme.styles.len(), 1);
+    assert!(Theme::builtin("monochrome").is_some());
+}
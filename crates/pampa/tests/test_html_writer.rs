@@ -0,0 +1,159 @@
+/*
+ * test_html_writer.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the HTML writer's rendering of footnotes, tables, math,
+ * divs and spans.
+ */
+
+use pampa::pandoc::{ASTContext, treesitter_to_pandoc};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::writers::html::write_blocks_to;
+use tree_sitter_qmd::MarkdownParser;
+
+/// Helper to render QMD to HTML body
+fn render_qmd_to_html(qmd: &str) -> String {
+    let input_bytes = qmd.as_bytes();
+
+    let mut parser = MarkdownParser::default();
+    let tree = parser.parse(input_bytes, None).expect("Failed to parse");
+    let mut error_collector = DiagnosticCollector::new();
+    let pandoc = treesitter_to_pandoc(
+        &mut std::io::sink(),
+        &tree,
+        input_bytes,
+        &ASTContext::anonymous(),
+        &mut error_collector,
+    )
+    .unwrap();
+
+    let mut output = Vec::new();
+    write_blocks_to(&pandoc.blocks, &mut output).unwrap();
+    String::from_utf8(output).unwrap()
+}
+
+// =============================================================================
+// Footnotes
+// =============================================================================
+
+#[test]
+fn test_inline_notes_are_numbered_references() {
+    let html = render_qmd_to_html("One^[First.] and two^[Second.]\n");
+    assert!(
+        html.contains(
+            "<a href=\"#fn1\" class=\"footnote-ref\" id=\"fnref1\" role=\"doc-noteref\"><sup>1</sup></a>"
+        ),
+        "Expected first note reference, got: {}",
+        html
+    );
+    assert!(
+        html.contains("id=\"fnref2\""),
+        "Expected second note reference, got: {}",
+        html
+    );
+}
+
+#[test]
+fn test_notes_are_written_at_end_of_document() {
+    let html = render_qmd_to_html("Text^[A note.]\n\nMore text.\n");
+    let section = html
+        .find("<section id=\"footnotes\"")
+        .unwrap_or_else(|| panic!("Expected footnotes section, got: {}", html));
+    assert!(
+        html.find("More text.").unwrap() < section,
+        "Footnotes should follow the body, got: {}",
+        html
+    );
+    assert!(
+        html.contains("role=\"doc-endnotes\""),
+        "Expected endnotes role, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<li id=\"fn1\">"),
+        "Expected note list item, got: {}",
+        html
+    );
+    assert!(
+        html.contains(
+            "A note.<a href=\"#fnref1\" class=\"footnote-back\" role=\"doc-backlink\">↩︎</a>"
+        ),
+        "Expected backlink inside the note's last paragraph, got: {}",
+        html
+    );
+}
+
+#[test]
+fn test_no_footnotes_section_without_notes() {
+    let html = render_qmd_to_html("Just text.\n");
+    assert!(
+        !html.contains("footnotes"),
+        "Should not have footnotes section, got: {}",
+        html
+    );
+}
+
+// =============================================================================
+// Tables
+// =============================================================================
+
+#[test]
+fn test_table_alignment_uses_text_align_style() {
+    let html = render_qmd_to_html(
+        "| Left | Right | Center | Default |\n|:-----|------:|:------:|---------|\n| a    | b     | c      | d       |\n",
+    );
+    assert!(
+        html.contains("<td style=\"text-align: left;\">"),
+        "Expected left-aligned cell, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<td style=\"text-align: right;\">"),
+        "Expected right-aligned cell, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<th style=\"text-align: center;\">"),
+        "Expected centered header cell, got: {}",
+        html
+    );
+    assert!(
+        !html.contains(" align="),
+        "Should not use the obsolete align attribute, got: {}",
+        html
+    );
+}
+
+// =============================================================================
+// Math, divs and spans
+// =============================================================================
+
+#[test]
+fn test_math_uses_mathjax_delimiters() {
+    let html = render_qmd_to_html("Inline $x^2$ and display $$y = 1$$\n");
+    assert!(
+        html.contains("<span class=\"math inline\">\\(x^2\\)</span>"),
+        "Expected inline math, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<span class=\"math display\">\\["),
+        "Expected display math, got: {}",
+        html
+    );
+}
+
+#[test]
+fn test_div_and_span_attributes() {
+    let html = render_qmd_to_html("::: {#box .note}\n[word]{.hl}\n:::\n");
+    assert!(
+        html.contains("<div id=\"box\" class=\"note\">"),
+        "Expected div with attributes, got: {}",
+        html
+    );
+    assert!(
+        html.contains("<span class=\"hl\">word</span>"),
+        "Expected span with attributes, got: {}",
+        html
+    );
+}
@@ -0,0 +1,208 @@
+/*
+ * test_includes.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Inline};
+use pampa::readers;
+use pampa::transforms::{IncludeCache, resolve_includes, resolve_includes_cached};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use std::path::Path;
+
+/// Read `main.qmd` from `dir` and expand its includes.
+fn read_with_includes(dir: &Path) -> (Vec<Block>, quarto_source_map::SourceContext, Vec<String>) {
+    let path = dir.join("main.qmd");
+    let input = std::fs::read_to_string(&path).unwrap();
+    let (doc, mut context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        &path.to_string_lossy(),
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    let mut diagnostics = DiagnosticCollector::new();
+    let blocks = resolve_includes(
+        doc.blocks,
+        &path,
+        dir,
+        &mut context.source_context,
+        &mut diagnostics,
+    );
+    let codes = diagnostics
+        .diagnostics()
+        .iter()
+        .filter_map(|diagnostic| diagnostic.code.clone())
+        .collect();
+    (blocks, context.source_context, codes)
+}
+
+fn write_files(dir: &Path, files: &[(&str, &str)]) {
+    for (name, content) in files {
+        let path = dir.join(name);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, content).unwrap();
+    }
+}
+
+fn para_text(block: &Block) -> String {
+    let Block::Paragraph(para) = block else {
+        panic!("Expected a Paragraph, got {:?}", block);
+    };
+    para.content
+        .iter()
+        .map(|inline| match inline {
+            Inline::Str(s) => s.text.clone(),
+            Inline::Space(_) => " ".to_string(),
+            _ => String::new(),
+        })
+        .collect()
+}
+
+#[test]
+fn test_include_is_replaced_by_file_blocks() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[
+            (
+                "main.qmd",
+                "Before.\n\n{{< include _part.qmd >}}\n\nAfter.\n",
+            ),
+            ("_part.qmd", "One.\n\nTwo.\n"),
+        ],
+    );
+    let (blocks, _, codes) = read_with_includes(dir.path());
+    let texts: Vec<String> = blocks.iter().map(para_text).collect();
+    assert_eq!(texts, vec!["Before.", "One.", "Two.", "After."]);
+    assert!(codes.is_empty());
+}
+
+#[test]
+fn test_nested_includes_resolve_relative_to_including_file() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[
+            (
+                "main.qmd",
+                "::: {.note}\n{{< include chapters/one.qmd >}}\n:::\n",
+            ),
+            ("chapters/one.qmd", "{{< include _inner.qmd >}}\n"),
+            ("chapters/_inner.qmd", "Inner.\n"),
+        ],
+    );
+    let (blocks, _, codes) = read_with_includes(dir.path());
+    assert!(codes.is_empty(), "{:?}", codes);
+    let Block::Div(div) = &blocks[0] else {
+        panic!("Expected a Div, got {:?}", blocks[0]);
+    };
+    assert_eq!(para_text(&div.content[0]), "Inner.");
+}
+
+#[test]
+fn test_included_blocks_point_into_included_file() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[
+            ("main.qmd", "{{< include _part.qmd >}}\n"),
+            ("_part.qmd", "Hello.\n"),
+        ],
+    );
+    let (blocks, source_context, _) = read_with_includes(dir.path());
+    let Block::Paragraph(para) = &blocks[0] else {
+        panic!("Expected a Paragraph");
+    };
+    let mapped = para.source_info.map_offset(0, &source_context).unwrap();
+    let file = source_context.get_file(mapped.file_id).unwrap();
+    assert!(file.path.ends_with("_part.qmd"));
+    assert_eq!(mapped.location.offset, 0);
+}
+
+#[test]
+fn test_include_cycle_is_reported() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[
+            ("main.qmd", "{{< include a.qmd >}}\n"),
+            ("a.qmd", "A.\n\n{{< include b.qmd >}}\n"),
+            ("b.qmd", "{{< include a.qmd >}}\n"),
+        ],
+    );
+    let (blocks, _, codes) = read_with_includes(dir.path());
+    assert_eq!(codes, vec!["Q-2-37"]);
+    assert_eq!(para_text(&blocks[0]), "A.");
+    assert!(matches!(
+        &blocks[1],
+        Block::Paragraph(para) if matches!(para.content[..], [Inline::Shortcode(_)])
+    ));
+}
+
+#[test]
+fn test_missing_and_inline_includes_are_reported() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[(
+            "main.qmd",
+            "{{< include missing.qmd >}}\n\nSee {{< include _part.qmd >}} here.\n",
+        )],
+    );
+    let (blocks, _, codes) = read_with_includes(dir.path());
+    assert_eq!(codes, vec!["Q-2-36", "Q-2-38"]);
+    assert_eq!(blocks.len(), 2);
+}
+
+#[test]
+fn test_include_cache_rereads_changed_files() {
+    let dir = tempfile::tempdir().unwrap();
+    write_files(
+        dir.path(),
+        &[
+            (
+                "main.qmd",
+                "{{< include _a.qmd >}}\n\n{{< include _b.qmd >}}\n",
+            ),
+            ("_a.qmd", "A.\n"),
+            ("_b.qmd", "B.\n"),
+        ],
+    );
+    let path = dir.path().join("main.qmd");
+    let mut cache = IncludeCache::new();
+    let resolve = |cache: &mut IncludeCache| {
+        let input = std::fs::read_to_string(&path).unwrap();
+        let (doc, mut context, _warnings) = readers::qmd::read(
+            input.as_bytes(),
+            false,
+            &path.to_string_lossy(),
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        let blocks = resolve_includes_cached(
+            doc.blocks,
+            &path,
+            dir.path(),
+            &mut context.source_context,
+            &mut DiagnosticCollector::new(),
+            cache,
+        );
+        blocks.iter().map(para_text).collect::<Vec<String>>()
+    };
+
+    assert_eq!(resolve(&mut cache), vec!["A.", "B."]);
+    assert_eq!(cache.files().len(), 2);
+    assert_eq!(resolve(&mut cache), vec!["A.", "B."]);
+
+    write_files(dir.path(), &[("_b.qmd", "B, changed.\n")]);
+    assert_eq!(resolve(&mut cache), vec!["A.", "B, changed."]);
+
+    write_files(dir.path(), &[("main.qmd", "{{< include _b.qmd >}}\n")]);
+    assert_eq!(resolve(&mut cache), vec!["B, changed."]);
+    assert_eq!(cache.files().len(), 1);
+    assert!(cache.files()[0].ends_with("_b.qmd"));
+}
@@ -0,0 +1,143 @@
+/*
+ * test_inline_cells.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for inline code cells (`{python} x`).
+ */
+
+use pampa::pandoc::{ASTContext, Block, Inline, InlineCell, Inlines, Pandoc, Str};
+use pampa::{readers, writers};
+use quarto_source_map::SourceInfo;
+
+fn read_qmd(input: &str) -> (Pandoc, ASTContext) {
+    let (doc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    (doc, context)
+}
+
+fn to_qmd(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn to_html(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::html::write_blocks_with_config(&doc.blocks, &mut buf, Default::default()).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn para_inlines(doc: &mut Pandoc) -> &mut Inlines {
+    let Block::Paragraph(para) = &mut doc.blocks[0] else {
+        panic!("Expected a paragraph, got {:?}", doc.blocks[0]);
+    };
+    &mut para.content
+}
+
+fn cells(inlines: &[Inline]) -> Vec<InlineCell> {
+    inlines
+        .iter()
+        .filter_map(|inline| match inline {
+            Inline::Custom(custom) => InlineCell::from_custom(custom),
+            _ => None,
+        })
+        .collect()
+}
+
+/// Give every cell of the first paragraph `output` as its result, the way
+/// an engine does
+fn evaluate(doc: &mut Pandoc, output: &str) {
+    for inline in para_inlines(doc).iter_mut() {
+        if let Inline::Custom(custom) = inline
+            && let Some(mut cell) = InlineCell::from_custom(custom)
+        {
+            cell.output = Some(vec![Inline::Str(Str {
+                text: output.to_string(),
+                source_info: SourceInfo::default(),
+            })]);
+            *custom = cell.to_custom(custom.attr.clone(), custom.source_info.clone());
+        }
+    }
+}
+
+#[test]
+fn test_inline_cells_are_structured_nodes() {
+    let (mut doc, _context) = read_qmd("Mean `{python} df.x.mean()`, rows `nrow(df)`{r}.\n");
+    let inlines = para_inlines(&mut doc);
+    assert_eq!(
+        cells(inlines),
+        vec![
+            InlineCell::new("python", "df.x.mean()"),
+            InlineCell::new("r", "nrow(df)")
+        ]
+    );
+    assert!(
+        inlines
+            .iter()
+            .all(|inline| !matches!(inline, Inline::Code(_)))
+    );
+}
+
+#[test]
+fn test_ordinary_code_spans_stay_code() {
+    for input in [
+        "Use `print(x)`.\n",
+        "Raw `<b>`{=html}.\n",
+        "Empty `{python}`.\n",
+    ] {
+        let (mut doc, _context) = read_qmd(input);
+        assert!(cells(para_inlines(&mut doc)).is_empty(), "{}", input);
+    }
+}
+
+#[test]
+fn test_qmd_round_trip() {
+    for input in [
+        "The mean is `{python} round(x, 2)`.\n",
+        "A `{r} x`{.big} value.\n",
+    ] {
+        let (doc, _context) = read_qmd(input);
+        assert_eq!(to_qmd(&doc), input);
+    }
+
+    // The source is written back after evaluation too
+    let (mut doc, _context) = read_qmd("The mean is `{python} round(x, 2)`.\n");
+    evaluate(&mut doc, "4.2");
+    assert_eq!(to_qmd(&doc), "The mean is `{python} round(x, 2)`.\n");
+}
+
+#[test]
+fn test_html_shows_code_until_evaluated() {
+    let (mut doc, _context) = read_qmd("The mean is `{python} x`.\n");
+    assert_eq!(
+        to_html(&doc),
+        "<p>The mean is <code>{python} x</code>.</p>\n"
+    );
+
+    evaluate(&mut doc, "4.2");
+    assert_eq!(to_html(&doc), "<p>The mean is 4.2.</p>\n");
+}
+
+#[test]
+fn test_json_round_trip() {
+    let (mut doc, context) = read_qmd("The mean is `{python} x`.\n");
+    evaluate(&mut doc, "4.2");
+    let mut buf = Vec::new();
+    writers::json::write(&doc, &context, &mut buf).unwrap();
+    let (mut read_back, _context) = readers::json::read(&mut buf.as_slice()).unwrap();
+
+    let read_cells = cells(para_inlines(&mut read_back));
+    assert_eq!(read_cells.len(), 1);
+    assert_eq!(read_cells[0].code, "x");
+    assert!(matches!(
+        read_cells[0].output.as_deref(),
+        Some([Inline::Str(s)]) if s.text == "4.2"
+    ));
+}
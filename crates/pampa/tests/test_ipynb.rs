@@ -0,0 +1,224 @@
+/*
+ * test_ipynb.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the ipynb reader and writer.
+ */
+
+use pampa::pandoc::{Block, Pandoc};
+use pampa::readers::ipynb::{IpynbReadError, OUTPUTS_FORMAT, read};
+use pampa::writers;
+use serde_json::{Value, json};
+
+const NOTEBOOK: &str = r##"{
+ "cells": [
+  {
+   "cell_type": "raw",
+   "id": "front",
+   "metadata": {},
+   "source": ["---\n", "title: Analysis\n", "---"]
+  },
+  {
+   "cell_type": "markdown",
+   "id": "intro",
+   "metadata": {},
+   "source": ["# Intro\n", "\n", "Some *text*."]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 3,
+   "id": "setup",
+   "metadata": {"tags": ["parameters"], "scrolled": true},
+   "outputs": [
+    {"name": "stdout", "output_type": "stream", "text": ["1\n"]}
+   ],
+   "source": ["x = 1\n", "print(x)"]
+  },
+  {
+   "cell_type": "raw",
+   "id": "tex",
+   "metadata": {"raw_mimetype": "text/latex"},
+   "source": "\\newpage"
+  }
+ ],
+ "metadata": {
+  "kernelspec": {"display_name": "Python 3", "language": "python", "name": "python3"}
+ },
+ "nbformat": 4,
+ "nbformat_minor": 5
+}
+"##;
+
+fn read_notebook(input: &str) -> Pandoc {
+    let (pandoc, _context, _warnings) = read(input, "test.ipynb").expect("Failed to read notebook");
+    pandoc
+}
+
+fn write_notebook(pandoc: &Pandoc) -> Value {
+    let mut buf = Vec::new();
+    writers::ipynb::write(pandoc, &mut buf).expect("Failed to write notebook");
+    serde_json::from_slice(&buf).expect("Writer produced invalid JSON")
+}
+
+fn write_qmd(pandoc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(pandoc, &mut buf).expect("Failed to write qmd");
+    String::from_utf8(buf).unwrap()
+}
+
+#[test]
+fn test_read_cells() {
+    let pandoc = read_notebook(NOTEBOOK);
+
+    assert_eq!(
+        pandoc.meta.get("title").and_then(|t| t.as_plain_text()),
+        Some("Analysis".to_string())
+    );
+    assert!(pandoc.meta.get("jupyter").is_some());
+
+    assert!(matches!(pandoc.blocks[0], Block::Header(_)));
+    assert!(matches!(pandoc.blocks[1], Block::Paragraph(_)));
+    let Block::CodeBlock(code) = &pandoc.blocks[2] else {
+        panic!("Expected code block, got {:?}", pandoc.blocks[2]);
+    };
+    assert_eq!(code.attr.0, "setup");
+    assert_eq!(code.attr.1, vec!["{python}".to_string()]);
+    assert_eq!(code.text, "x = 1\nprint(x)");
+    assert_eq!(code.attr.2.get("execution_count").unwrap(), "3");
+    assert_eq!(code.attr.2.get("tags").unwrap(), "[\"parameters\"]");
+
+    let Block::RawBlock(outputs) = &pandoc.blocks[3] else {
+        panic!("Expected outputs raw block, got {:?}", pandoc.blocks[3]);
+    };
+    assert_eq!(outputs.format, OUTPUTS_FORMAT);
+    let Block::RawBlock(raw) = &pandoc.blocks[4] else {
+        panic!("Expected raw block, got {:?}", pandoc.blocks[4]);
+    };
+    assert_eq!(raw.format, "latex");
+    assert_eq!(raw.text, "\\newpage");
+}
+
+#[test]
+fn test_notebook_roundtrip() {
+    let notebook = write_notebook(&read_notebook(NOTEBOOK));
+
+    assert_eq!(notebook["nbformat"], json!(4));
+    assert_eq!(notebook["metadata"]["kernelspec"]["name"], json!("python3"));
+
+    let cells = notebook["cells"].as_array().unwrap();
+    let types: Vec<&str> = cells
+        .iter()
+        .map(|cell| cell["cell_type"].as_str().unwrap())
+        .collect();
+    assert_eq!(types, vec!["raw", "markdown", "code", "raw"]);
+
+    assert_eq!(
+        cells[0]["source"],
+        json!(["---\n", "title: Analysis\n", "---"])
+    );
+    assert_eq!(
+        cells[1]["source"],
+        json!(["# Intro\n", "\n", "Some *text*."])
+    );
+
+    let code = &cells[2];
+    assert_eq!(code["id"], json!("setup"));
+    assert_eq!(code["execution_count"], json!(3));
+    assert_eq!(
+        code["metadata"],
+        json!({"tags": ["parameters"], "scrolled": true})
+    );
+    assert_eq!(code["source"], json!(["x = 1\n", "print(x)"]));
+    assert_eq!(
+        code["outputs"],
+        json!([{"name": "stdout", "output_type": "stream", "text": ["1\n"]}])
+    );
+
+    assert_eq!(cells[3]["metadata"]["raw_mimetype"], json!("text/latex"));
+}
+
+#[test]
+fn test_roundtrip_through_qmd() {
+    let qmd = write_qmd(&read_notebook(NOTEBOOK));
+    assert!(
+        qmd.contains("```{python #setup execution_count=\"3\""),
+        "Expected executable block header, got: {}",
+        qmd
+    );
+    assert!(
+        qmd.contains("```{=ipynb-outputs}"),
+        "Expected outputs raw block, got: {}",
+        qmd
+    );
+
+    let (reparsed, _, _) = pampa::readers::qmd::read(
+        qmd.as_bytes(),
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .expect("Failed to parse qmd");
+    let notebook = write_notebook(&reparsed);
+    let code = notebook["cells"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|cell| cell["cell_type"] == "code")
+        .expect("Expected a code cell")
+        .clone();
+    assert_eq!(code["id"], json!("setup"));
+    assert_eq!(code["outputs"][0]["output_type"], json!("stream"));
+}
+
+#[test]
+fn test_write_from_qmd() {
+    let (pandoc, _, _) = pampa::readers::qmd::read(
+        b"---\njupyter: python3\n---\n\nSome text.\n\n```{python}\n1 + 1\n```\n\n```python\nnot executed\n```\n",
+        false,
+        "test.qmd",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .expect("Failed to parse qmd");
+    let notebook = write_notebook(&pandoc);
+
+    assert_eq!(
+        notebook["metadata"]["kernelspec"],
+        json!({"display_name": "python3", "language": "python", "name": "python3"})
+    );
+    let cells = notebook["cells"].as_array().unwrap();
+    // No front matter cell: `jupyter` is the only metadata
+    assert_eq!(cells.len(), 3);
+    assert_eq!(cells[0]["cell_type"], json!("markdown"));
+    assert_eq!(cells[1]["cell_type"], json!("code"));
+    assert_eq!(cells[1]["execution_count"], Value::Null);
+    assert_eq!(cells[1]["outputs"], json!([]));
+    // Non-executable code stays in a markdown cell
+    assert_eq!(cells[2]["cell_type"], json!("markdown"));
+
+    let ids: std::collections::HashSet<&str> =
+        cells.iter().map(|c| c["id"].as_str().unwrap()).collect();
+    assert_eq!(ids.len(), cells.len());
+}
+
+#[test]
+fn test_invalid_notebooks() {
+    assert!(matches!(
+        read("not json", "bad.ipynb"),
+        Err(IpynbReadError::InvalidJson(_))
+    ));
+    assert!(matches!(
+        read(r#"{"nbformat": 4}"#, "bad.ipynb"),
+        Err(IpynbReadError::InvalidNotebook(_))
+    ));
+    assert!(matches!(
+        read(
+            r#"{"nbformat": 4, "cells": [{"cell_type": "widget", "source": ""}]}"#,
+            "bad.ipynb"
+        ),
+        Err(IpynbReadError::InvalidNotebook(_))
+    ));
+}
@@ -0,0 +1,112 @@
+/*
+ * test_latex_writer.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the LaTeX writer.
+ */
+
+use pampa::pandoc::{ASTContext, treesitter_to_pandoc};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::writers::latex::write_blocks_to;
+use tree_sitter_qmd::MarkdownParser;
+
+/// Helper to render QMD to a LaTeX body
+fn render_qmd_to_latex(qmd: &str) -> String {
+    let input_bytes = qmd.as_bytes();
+
+    let mut parser = MarkdownParser::default();
+    let tree = parser.parse(input_bytes, None).expect("Failed to parse");
+    let mut error_collector = DiagnosticCollector::new();
+    let pandoc = treesitter_to_pandoc(
+        &mut std::io::sink(),
+        &tree,
+        input_bytes,
+        &ASTContext::anonymous(),
+        &mut error_collector,
+    )
+    .unwrap();
+
+    let mut output = Vec::new();
+    write_blocks_to(&pandoc.blocks, &mut output).unwrap();
+    String::from_utf8(output).unwrap()
+}
+
+#[test]
+fn test_headers_and_inline_formatting() {
+    let latex = render_qmd_to_latex("# Intro {#sec-intro}\n\nSome **bold** and `a_b`.\n");
+    assert!(
+        latex.contains("\\section{Intro}\\label{sec-intro}"),
+        "Expected labelled section, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("Some \\textbf{bold} and \\texttt{a\\_b}."),
+        "Expected escaped inline content, got: {}",
+        latex
+    );
+}
+
+#[test]
+fn test_lists() {
+    let latex = render_qmd_to_latex("- one\n- two\n\n3. three\n4. four\n");
+    assert!(
+        latex.contains("\\begin{itemize}\n\\item one\n\\item two\n\\end{itemize}"),
+        "Expected itemize, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("\\begin{enumerate}\n\\setcounter{enumi}{2}\n\\item three"),
+        "Expected enumerate starting at 3, got: {}",
+        latex
+    );
+}
+
+#[test]
+fn test_code_block_and_math() {
+    let latex = render_qmd_to_latex("```\nx <- 1\n```\n\nInline $x^2$.\n");
+    assert!(
+        latex.contains("\\begin{verbatim}\nx <- 1\n\\end{verbatim}"),
+        "Expected verbatim code block, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("Inline \\(x^2\\)."),
+        "Expected inline math, got: {}",
+        latex
+    );
+}
+
+#[test]
+fn test_table_is_longtable() {
+    let latex = render_qmd_to_latex("| A | B |\n|:--|--:|\n| 1 | 2 |\n");
+    assert!(
+        latex.contains("\\begin{longtable}[]{@{}lr@{}}"),
+        "Expected longtable with column alignment, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("A & B \\\\\n\\midrule\\noalign{}\n\\endhead"),
+        "Expected header row, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("1 & 2 \\\\"),
+        "Expected body row, got: {}",
+        latex
+    );
+}
+
+#[test]
+fn test_links_and_footnotes() {
+    let latex = render_qmd_to_latex("See [here](https://example.com/a%20b)^[A note.]\n");
+    assert!(
+        latex.contains("\\href{https://example.com/a\\%20b}{here}"),
+        "Expected href with escaped URL, got: {}",
+        latex
+    );
+    assert!(
+        latex.contains("\\footnote{A note.\n}"),
+        "Expected footnote, got: {}",
+        latex
+    );
+}
@@ -0,0 +1,87 @@
+/*
+ * test_line_endings.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for byte order marks and CRLF line endings in input, and
+ * `--line-endings`.
+ */
+
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+fn pampa(args: &[&str], input: &str) -> String {
+    let mut child = Command::new(env!("CARGO_BIN_EXE_pampa"))
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .unwrap();
+    let output = child.wait_with_output().unwrap();
+    assert!(
+        output.status.success(),
+        "pampa {} failed: {}",
+        args.join(" "),
+        String::from_utf8_lossy(&output.stderr)
+    );
+    String::from_utf8(output.stdout).unwrap()
+}
+
+const WINDOWS_QMD: &str = "\u{feff}---\r\ntitle: Notes\r\n---\r\n\r\n# Hello\r\n\r\nSome text.\r\n";
+
+#[test]
+fn test_bom_does_not_hide_front_matter() {
+    let json = pampa(&["-t", "json"], WINDOWS_QMD);
+    let value: serde_json::Value = serde_json::from_str(&json).unwrap();
+    assert!(
+        value["meta"].get("title").is_some(),
+        "front matter after a BOM should be metadata: {}",
+        json
+    );
+    assert!(!json.starts_with('\u{feff}'), "JSON output has no BOM");
+}
+
+#[test]
+fn test_crlf_is_not_in_the_ast() {
+    let json = pampa(&["-t", "json"], "```\r\ncode\r\nmore\r\n```\r\n");
+    assert!(!json.contains("\\r"), "no \\r in the text: {}", json);
+}
+
+#[test]
+fn test_qmd_output_preserves_line_endings_and_bom() {
+    let qmd = pampa(&["-t", "qmd"], WINDOWS_QMD);
+    assert!(qmd.starts_with('\u{feff}'), "BOM kept: {:?}", qmd);
+    assert!(qmd.contains("# Hello\r\n"), "CRLF kept: {:?}", qmd);
+    assert!(
+        !qmd.replace("\r\n", "").contains('\n'),
+        "every line ends in CRLF: {:?}",
+        qmd
+    );
+}
+
+#[test]
+fn test_line_endings_option_normalizes() {
+    let qmd = pampa(&["-t", "qmd", "--line-endings", "lf"], WINDOWS_QMD);
+    assert!(!qmd.starts_with('\u{feff}'));
+    assert!(!qmd.contains('\r'), "{:?}", qmd);
+
+    let qmd = pampa(
+        &["-t", "qmd", "--line-endings", "crlf"],
+        "# Hello\n\nText.\n",
+    );
+    assert_eq!(qmd.matches("\r\n").count(), qmd.matches('\n').count());
+}
+
+#[test]
+fn test_sourcepos_columns_with_crlf() {
+    // Source locations are those of the same text with \n line endings
+    let html = pampa(&["-t", "html", "--sourcepos"], "# Hello\r\n\r\nText.\r\n");
+    let lf_html = pampa(&["-t", "html", "--sourcepos"], "# Hello\n\nText.\n");
+    assert_eq!(html.replace("\r\n", "\n"), lf_html);
+}
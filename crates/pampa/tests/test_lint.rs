@@ -0,0 +1,74 @@
+/*
+ * test_lint.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa lint`.
+ */
+
+use std::fs;
+use std::process::Command;
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+/// Run `pampa [global_args] lint FILES`
+fn lint(global_args: &[&str], files: &[&std::path::Path]) -> std::process::Output {
+    Command::new(get_binary_path())
+        .args(global_args)
+        .arg("lint")
+        .args(files)
+        .output()
+        .unwrap()
+}
+
+#[test]
+fn test_lint_clean_file() {
+    let dir = tempfile::tempdir().unwrap();
+    let qmd = dir.path().join("clean.qmd");
+    fs::write(
+        &qmd,
+        "```{python}\n#| label: fig-plot\n#| echo: false\n#| fig-cap: A plot\nplot()\n```\n",
+    )
+    .unwrap();
+
+    let output = lint(&[], &[&qmd]);
+    assert_eq!(output.status.code(), Some(0), "{:?}", output);
+    assert!(output.stderr.is_empty(), "{:?}", output);
+}
+
+#[test]
+fn test_lint_reports_cell_options_with_ranges() {
+    let dir = tempfile::tempdir().unwrap();
+    let qmd = dir.path().join("cells.qmd");
+    fs::write(
+        &qmd,
+        "Text.\n\n```{r}\n#| fig.width: 6\n#| echo: maybe\nplot(cars)\n```\n",
+    )
+    .unwrap();
+
+    let output = lint(&["--diagnostics"], &[&qmd]);
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let report: serde_json::Value = serde_json::from_slice(&output.stderr).unwrap();
+    let diagnostics = report.as_array().unwrap();
+    assert_eq!(diagnostics.len(), 2, "{}", report);
+
+    assert_eq!(diagnostics[0]["code"], "Q-1-18");
+    assert_eq!(diagnostics[0]["kind"], "warning");
+    assert_eq!(diagnostics[0]["range"]["start"]["line"], 4);
+    assert_eq!(diagnostics[0]["range"]["start"]["column"], 4);
+    assert_eq!(diagnostics[0]["range"]["end"]["column"], 13);
+    assert!(report.to_string().contains("fig-width"), "{}", report);
+
+    assert_eq!(diagnostics[1]["code"], "Q-1-11");
+    assert_eq!(diagnostics[1]["kind"], "error");
+    assert_eq!(diagnostics[1]["range"]["start"]["line"], 5);
+    assert_eq!(diagnostics[1]["range"]["start"]["column"], 10);
+}
+
+#[test]
+fn test_lint_missing_file() {
+    let dir = tempfile::tempdir().unwrap();
+    let output = lint(&[], &[&dir.path().join("missing.qmd")]);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+}
@@ -0,0 +1,94 @@
+/*
+ * test_logging.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the log events of `pampa --log-format` and `--timing`.
+ */
+
+use std::io::Write;
+use std::process::{Command, Output, Stdio};
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+fn run(args: &[&str], input: &str) -> Output {
+    run_with_filter(args, "", input)
+}
+
+/// Run pampa with `filter` as PAMPA_LOG
+fn run_with_filter(args: &[&str], filter: &str, input: &str) -> Output {
+    let mut child = Command::new(get_binary_path())
+        .args(args)
+        .env("PAMPA_LOG", filter)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input.as_bytes())
+        .unwrap();
+    child.wait_with_output().unwrap()
+}
+
+fn json_lines(output: &Output) -> Vec<serde_json::Value> {
+    String::from_utf8_lossy(&output.stderr)
+        .lines()
+        .map(|line| serde_json::from_str(line).unwrap_or_else(|e| panic!("{}: {}", e, line)))
+        .collect()
+}
+
+#[test]
+fn test_timing_is_logged_as_json_lines() {
+    let output = run(
+        &["-t", "html", "--timing", "--log-format", "json"],
+        "# Hello\n",
+    );
+    assert!(output.status.success(), "{:?}", output);
+
+    let timings: Vec<_> = json_lines(&output)
+        .into_iter()
+        .filter(|event| event["target"] == "pampa::timing")
+        .collect();
+    let phases: Vec<_> = timings
+        .iter()
+        .map(|event| event["phase"].as_str().unwrap())
+        .collect();
+    assert_eq!(phases.first(), Some(&"read"));
+    assert_eq!(phases.last(), Some(&"write"));
+    assert!(timings.iter().all(|event| event["level"] == "info"
+        && event["duration_us"].is_u64()
+        && event["message"].is_string()));
+}
+
+#[test]
+fn test_extension_decisions_are_logged() {
+    let output = run(&["-t", "qmd+smart", "--log-format", "json"], "Hello\n");
+    assert!(output.status.success(), "{:?}", output);
+    // Extension decisions are debug events
+    assert!(json_lines(&output).is_empty());
+
+    let output = run_with_filter(
+        &["-t", "qmd+smart", "--log-format", "json"],
+        "pampa=debug",
+        "Hello\n",
+    );
+    assert!(json_lines(&output).iter().any(|event| {
+        event["message"] == "writer extensions"
+            && event["writer"] == "qmd"
+            && event["extensions"]
+                .as_str()
+                .is_some_and(|names| names.split(',').any(|name| name == "smart"))
+    }));
+}
+
+#[test]
+fn test_nothing_is_logged_by_default() {
+    let output = run(&["-t", "html"], "# Hello\n");
+    assert!(output.status.success(), "{:?}", output);
+    assert!(output.stderr.is_empty(), "{:?}", output);
+}
@@ -0,0 +1,98 @@
+/*
+ * test_metadata_layers.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::metadata::{apply_metadata, lookup, metadata_args_to_config, read_metadata_file};
+use pampa::pandoc::ASTContext;
+use pampa::readers;
+use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
+
+fn read_meta(input: &str) -> (ConfigValue, ASTContext) {
+    let (doc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    (doc.meta, context)
+}
+
+fn args(values: &[&str]) -> Vec<String> {
+    values.iter().map(|value| value.to_string()).collect()
+}
+
+#[test]
+fn test_metadata_args() {
+    let meta =
+        metadata_args_to_config(&args(&["title=Hi", "draft", "toc:false", "tag=a", "tag=b"]));
+    assert_eq!(lookup(&meta, "title").unwrap().as_str(), Some("Hi"));
+    assert_eq!(lookup(&meta, "draft").unwrap().as_bool(), Some(true));
+    assert_eq!(lookup(&meta, "toc").unwrap().as_bool(), Some(false));
+    assert_eq!(lookup(&meta, "tag.1").unwrap().as_str(), Some("b"));
+}
+
+#[test]
+fn test_metadata_args_replace_document_values() {
+    let (meta, _context) = read_meta("---\ntitle: Doc\ntags: [a]\n---\n\nBody.\n");
+    let merged = apply_metadata(&meta, &[], &args(&["title=Over", "tags=b"])).unwrap();
+    assert_eq!(lookup(&merged, "title").unwrap().as_str(), Some("Over"));
+    assert_eq!(lookup(&merged, "tags").unwrap().as_str(), Some("b"));
+}
+
+#[test]
+fn test_metadata_files_merge_under_the_document() {
+    let dir = tempfile::tempdir().unwrap();
+    let yaml = dir.path().join("defaults.yml");
+    std::fs::write(
+        &yaml,
+        "title: File\nauthor: Ann\nformat:\n  html:\n    toc: true\n",
+    )
+    .unwrap();
+    let json = dir.path().join("extra.json");
+    std::fs::write(&json, "{\"subtitle\": \"Sub\", \"keywords\": [\"x\"]}\n").unwrap();
+
+    let (meta, mut context) =
+        read_meta("---\ntitle: Doc\nformat:\n  html:\n    theme: cosmo\n---\n\nBody.\n");
+    let files = vec![
+        read_metadata_file(&yaml, &mut context.source_context).unwrap(),
+        read_metadata_file(&json, &mut context.source_context).unwrap(),
+    ];
+    let merged = apply_metadata(&meta, &files, &[]).unwrap();
+
+    // Maps merge key by key; the document wins where both set a value
+    assert_eq!(
+        lookup(&merged, "format.html.toc").unwrap().as_bool(),
+        Some(true)
+    );
+    assert!(lookup(&merged, "format.html.theme").is_some());
+    assert!(lookup(&merged, "author").is_some());
+    assert!(lookup(&merged, "keywords.0").is_some());
+    let ConfigValueKind::PandocInlines(title) = &lookup(&merged, "title").unwrap().value else {
+        panic!("Expected the document's title");
+    };
+    assert_eq!(pampa::writers::plaintext::inlines_to_string(title).0, "Doc");
+}
+
+#[test]
+fn test_metadata_file_must_hold_a_map() {
+    let dir = tempfile::tempdir().unwrap();
+    let path = dir.path().join("list.yml");
+    std::fs::write(&path, "- a\n- b\n").unwrap();
+    let (_meta, mut context) = read_meta("Body.\n");
+    let errors = read_metadata_file(&path, &mut context.source_context).unwrap_err();
+    assert_eq!(errors[0].code.as_deref(), Some("Q-1-29"));
+
+    let missing = dir.path().join("missing.yml");
+    let errors = read_metadata_file(&missing, &mut context.source_context).unwrap_err();
+    assert_eq!(errors[0].code.as_deref(), Some("Q-1-29"));
+}
+
+#[test]
+fn test_no_layers_keep_document_metadata() {
+    let (meta, _context) = read_meta("---\ntitle: Doc\n---\n\nBody.\n");
+    assert_eq!(apply_metadata(&meta, &[], &[]).unwrap(), meta);
+}
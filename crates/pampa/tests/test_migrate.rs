@@ -0,0 +1,140 @@
+/*
+ * test_migrate.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa migrate`.
+ */
+
+use std::fs;
+use std::path::Path;
+use std::process::Command;
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+/// Run `pampa [global_args] migrate FILE [args]`
+fn migrate(global_args: &[&str], file: &Path, args: &[&str]) -> std::process::Output {
+    Command::new(get_binary_path())
+        .args(global_args)
+        .arg("migrate")
+        .arg(file)
+        .args(args)
+        .output()
+        .unwrap()
+}
+
+const REPORT: &str = "\
+---
+title: Report
+output:
+  html_document:
+    toc: true
+    code_folding: hide
+---
+
+```{r setup, include=FALSE}
+knitr::opts_chunk$set(echo = FALSE)
+```
+
+The mean is `r mean(cars$speed)`.
+
+```{r plot, fig.cap=\"Speed and distance\", fig.width=6}
+plot(cars)
+```
+";
+
+#[test]
+fn test_migrate_writes_qmd() {
+    let dir = tempfile::tempdir().unwrap();
+    let rmd = dir.path().join("report.Rmd");
+    fs::write(&rmd, REPORT).unwrap();
+
+    let output = migrate(&[], &rmd, &[]);
+    assert!(output.status.success(), "{:?}", output);
+    let qmd = fs::read_to_string(dir.path().join("report.qmd")).unwrap();
+    assert_eq!(
+        qmd,
+        "\
+---
+title: Report
+format:
+  html:
+    toc: true
+    code-fold: true
+execute:
+  echo: false
+---
+
+The mean is `{r} mean(cars$speed)`.
+
+```{r}
+#| label: plot
+#| fig-cap: Speed and distance
+#| fig-width: 6
+plot(cars)
+```
+"
+    );
+    // The R Markdown file stays
+    assert_eq!(fs::read_to_string(&rmd).unwrap(), REPORT);
+}
+
+#[test]
+fn test_migrate_reports_what_is_not_translated() {
+    let dir = tempfile::tempdir().unwrap();
+    let rmd = dir.path().join("slides.Rmd");
+    fs::write(
+        &rmd,
+        "---\noutput:\n  ioslides_presentation:\n    widescreen: true\n---\n\nSee \\@ref(fig:one).\n",
+    )
+    .unwrap();
+
+    let output = migrate(&["--json-errors"], &rmd, &[]);
+    assert_eq!(output.status.code(), Some(1), "{:?}", output);
+    let stderr = String::from_utf8(output.stderr).unwrap();
+    let warnings: Vec<&str> = stderr
+        .lines()
+        .filter(|line| line.contains("Q-7-2"))
+        .collect();
+    // The format, its option and the cross-reference
+    assert_eq!(warnings.len(), 3, "{}", stderr);
+    assert!(stderr.contains("widescreen"), "{}", stderr);
+    let qmd = fs::read_to_string(dir.path().join("slides.qmd")).unwrap();
+    assert!(qmd.starts_with("---\nformat: revealjs\n---\n"), "{}", qmd);
+}
+
+#[test]
+fn test_migrate_keeps_existing_qmd() {
+    let dir = tempfile::tempdir().unwrap();
+    let rmd = dir.path().join("doc.Rmd");
+    let qmd = dir.path().join("doc.qmd");
+    fs::write(&rmd, "```{r}\n1\n```\n").unwrap();
+    fs::write(&qmd, "Mine.\n").unwrap();
+
+    let output = migrate(&[], &rmd, &[]);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+    assert_eq!(fs::read_to_string(&qmd).unwrap(), "Mine.\n");
+
+    let output = migrate(&[], &rmd, &["--dry-run"]);
+    assert!(output.status.success(), "{:?}", output);
+    assert_eq!(fs::read_to_string(&qmd).unwrap(), "Mine.\n");
+
+    let output = migrate(&[], &rmd, &["--force"]);
+    assert!(output.status.success(), "{:?}", output);
+    assert_eq!(fs::read_to_string(&qmd).unwrap(), "```{r}\n1\n```\n");
+}
+
+#[test]
+fn test_migrate_keeps_line_endings() {
+    let dir = tempfile::tempdir().unwrap();
+    let rmd = dir.path().join("crlf.Rmd");
+    fs::write(&rmd, "```{r a, echo=FALSE}\r\n1\r\n```\r\n").unwrap();
+
+    let output = migrate(&[], &rmd, &[]);
+    assert!(output.status.success(), "{:?}", output);
+    assert_eq!(
+        fs::read_to_string(dir.path().join("crlf.qmd")).unwrap(),
+        "```{r}\r\n#| label: a\r\n#| echo: false\r\n1\r\n```\r\n"
+    );
+}
@@ -0,0 +1,324 @@
+/*
+ * test_pandoc_conformance.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Conformance with pandoc's markdown reader.
+ *
+ * Every file of the corpus is read by pampa and by the pandoc version pinned
+ * in tests/pandoc-conformance/divergences.json, and the JSON ASTs are
+ * compared. Files whose ASTs differ must be listed in divergences.json,
+ * either as an intentional extension of pandoc's markdown or as a bug, so a
+ * file that used to match and no longer does fails the test. The summary
+ * (the share of files that match, and the divergences by category) is
+ * printed, and written as JSON to $PAMPA_CONFORMANCE_REPORT when it is set.
+ */
+
+use glob::glob;
+use pampa::{readers, writers};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+const DIVERGENCES_FILE: &str = "tests/pandoc-conformance/divergences.json";
+
+/// The corpus: files written for the suite, and the pandoc-match corpus,
+/// which the tests in test.rs require to match exactly.
+const CORPUS: &[&str] = &[
+    "tests/pandoc-conformance/corpus/*.qmd",
+    "tests/pandoc-match-corpus/markdown/*.qmd",
+];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Deserialize, Serialize)]
+#[serde(rename_all = "lowercase")]
+enum Category {
+    /// Differs from pandoc on purpose: Quarto syntax pandoc doesn't have
+    Extension,
+    /// Differs from pandoc, and shouldn't
+    Bug,
+}
+
+#[derive(Debug, Deserialize)]
+struct KnownDivergence {
+    category: Category,
+    note: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct Divergences {
+    /// The pandoc version the divergences were recorded against
+    pandoc: String,
+    /// Known divergences, by path relative to `tests/`
+    divergences: BTreeMap<String, KnownDivergence>,
+}
+
+#[derive(Debug, Serialize)]
+struct Divergence {
+    file: String,
+    /// None for a divergence not listed in divergences.json
+    category: Option<Category>,
+    /// Where the ASTs first differ, e.g. `blocks[0].c[1]`, or why pampa
+    /// produced no AST
+    at: String,
+}
+
+#[derive(Debug, Serialize)]
+struct Report {
+    pandoc: String,
+    files: usize,
+    matching: usize,
+    /// The share of files whose AST matches pandoc's, in percent
+    score: f64,
+    by_category: BTreeMap<Category, usize>,
+    divergences: Vec<Divergence>,
+    /// Files listed as divergent that now match pandoc
+    fixed: Vec<String>,
+}
+
+fn pandoc_version() -> Option<String> {
+    let output = Command::new("pandoc").arg("--version").output().ok()?;
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    let first_line = stdout.lines().next()?;
+    first_line
+        .strip_prefix("pandoc ")
+        .map(|v| v.trim().to_string())
+}
+
+fn run_pandoc(args: &[&str], input: &[u8]) -> serde_json::Value {
+    let mut child = Command::new("pandoc")
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .spawn()
+        .expect("Failed to start pandoc process");
+    child
+        .stdin
+        .take()
+        .unwrap()
+        .write_all(input)
+        .expect("Failed to write to pandoc");
+    let output = child
+        .wait_with_output()
+        .expect("Failed to read pandoc output");
+    assert!(output.status.success(), "pandoc {} failed", args.join(" "));
+    serde_json::from_slice(&output.stdout).expect("pandoc wrote invalid JSON")
+}
+
+/// Remove the source locations pampa adds to its JSON
+fn remove_location_fields(json: &mut serde_json::Value) {
+    if let Some(obj) = json.as_object_mut() {
+        for key in ["l", "s", "astContext", "attrS", "targetS", "citationIdS"] {
+            obj.remove(key);
+        }
+        for value in obj.values_mut() {
+            remove_location_fields(value);
+        }
+    } else if let Some(array) = json.as_array_mut() {
+        for item in array {
+            remove_location_fields(item);
+        }
+    }
+}
+
+/// pampa's AST as pandoc writes it, or why there is none
+fn pampa_ast(path: &str, input: &[u8]) -> Result<serde_json::Value, String> {
+    let (doc, context, _warnings) =
+        readers::qmd::read(input, false, path, &mut std::io::sink(), true, None)
+            .map_err(|_| "pampa failed to read the file".to_string())?;
+    let mut buf = Vec::new();
+    writers::json::write(&doc, &context, &mut buf)
+        .map_err(|_| "pampa failed to write JSON".to_string())?;
+    let mut value: serde_json::Value = serde_json::from_slice(&buf).unwrap();
+    remove_location_fields(&mut value);
+    // Round-trip through pandoc so both sides are written the same way
+    Ok(run_pandoc(
+        &["--from", "json", "--to", "json"],
+        value.to_string().as_bytes(),
+    ))
+}
+
+/// The path of the first place two ASTs differ, or None when they're equal
+fn first_difference(
+    ours: &serde_json::Value,
+    theirs: &serde_json::Value,
+    path: &str,
+) -> Option<String> {
+    use serde_json::Value;
+    match (ours, theirs) {
+        (Value::Object(a), Value::Object(b)) => {
+            let mut keys: Vec<&String> = a.keys().chain(b.keys()).collect();
+            keys.sort();
+            keys.dedup();
+            keys.into_iter().find_map(|key| {
+                let key_path = if path.is_empty() {
+                    key.clone()
+                } else {
+                    format!("{}.{}", path, key)
+                };
+                match (a.get(key), b.get(key)) {
+                    (Some(x), Some(y)) => first_difference(x, y, &key_path),
+                    _ => Some(key_path),
+                }
+            })
+        }
+        (Value::Array(a), Value::Array(b)) => a
+            .iter()
+            .zip(b)
+            .enumerate()
+            .find_map(|(i, (x, y))| first_difference(x, y, &format!("{}[{}]", path, i)))
+            .or_else(|| {
+                (a.len() != b.len()).then(|| format!("{}[{}]", path, a.len().min(b.len())))
+            }),
+        _ => (ours != theirs).then(|| path.to_string()),
+    }
+}
+
+fn corpus_files() -> Vec<String> {
+    let mut files: Vec<String> = CORPUS
+        .iter()
+        .flat_map(|pattern| glob(pattern).expect("Failed to read glob pattern"))
+        .map(|entry| entry.unwrap().to_string_lossy().replace('\\', "/"))
+        .collect();
+    files.sort();
+    files
+}
+
+#[test]
+fn test_pandoc_conformance() {
+    let known: Divergences =
+        serde_json::from_str(&std::fs::read_to_string(DIVERGENCES_FILE).unwrap())
+            .expect("divergences.json is valid");
+    match pandoc_version() {
+        Some(version) if version == known.pandoc => {}
+        version => {
+            // Other versions read some documents differently, so the
+            // divergences recorded wouldn't apply.
+            eprintln!(
+                "Skipping pandoc conformance: it needs pandoc {}, found {}",
+                known.pandoc,
+                version.as_deref().unwrap_or("none")
+            );
+            return;
+        }
+    }
+
+    let files = corpus_files();
+    assert!(
+        !files.is_empty(),
+        "No files found in the conformance corpus"
+    );
+    for listed in known.divergences.keys() {
+        assert!(
+            files
+                .iter()
+                .any(|file| file.strip_prefix("tests/") == Some(listed.as_str())),
+            "{} lists {}, which isn't in the corpus",
+            DIVERGENCES_FILE,
+            listed
+        );
+    }
+
+    let mut divergences = Vec::new();
+    let mut fixed = Vec::new();
+    for file in &files {
+        let input = std::fs::read(file).expect("Failed to read file");
+        let theirs = run_pandoc(&["--from", "markdown", "--to", "json"], &input);
+        let at = match pampa_ast(file, &input) {
+            Ok(ours) => first_difference(&ours, &theirs, ""),
+            Err(reason) => Some(reason),
+        };
+        let known_divergence = known.divergences.get(file.trim_start_matches("tests/"));
+        match (at, known_divergence) {
+            (Some(at), known_divergence) => divergences.push(Divergence {
+                file: file.clone(),
+                category: known_divergence.map(|d| d.category),
+                at,
+            }),
+            (None, Some(_)) => fixed.push(file.clone()),
+            (None, None) => {}
+        }
+    }
+
+    let mut by_category = BTreeMap::new();
+    for divergence in &divergences {
+        if let Some(category) = divergence.category {
+            *by_category.entry(category).or_insert(0) += 1;
+        }
+    }
+    let matching = files.len() - divergences.len();
+    let report = Report {
+        pandoc: known.pandoc.clone(),
+        files: files.len(),
+        matching,
+        score: 100.0 * matching as f64 / files.len() as f64,
+        by_category,
+        divergences,
+        fixed,
+    };
+
+    eprintln!(
+        "Pandoc conformance: {} of {} files match pandoc {} ({:.1}%)",
+        report.matching, report.files, report.pandoc, report.score
+    );
+    for divergence in &report.divergences {
+        let label = match divergence.category {
+            Some(Category::Extension) => "extension",
+            Some(Category::Bug) => "bug",
+            None => "UNEXPECTED",
+        };
+        let note = known
+            .divergences
+            .get(divergence.file.trim_start_matches("tests/"))
+            .map(|d| format!(" ({})", d.note))
+            .unwrap_or_default();
+        eprintln!(
+            "  {:<10} {} at {}{}",
+            label, divergence.file, divergence.at, note
+        );
+    }
+    for file in &report.fixed {
+        eprintln!(
+            "  fixed      {} now matches; remove it from divergences.json",
+            file
+        );
+    }
+    if let Some(path) = std::env::var_os("PAMPA_CONFORMANCE_REPORT") {
+        std::fs::write(path, serde_json::to_string_pretty(&report).unwrap()).unwrap();
+    }
+
+    let unexpected: Vec<&str> = report
+        .divergences
+        .iter()
+        .filter(|d| d.category.is_none())
+        .map(|d| d.file.as_str())
+        .collect();
+    assert!(
+        unexpected.is_empty(),
+        "These files no longer match pandoc: {}. Fix the regression, or list \
+         the divergence in {} as an extension or a bug.",
+        unexpected.join(", "),
+        DIVERGENCES_FILE
+    );
+}
+
+#[test]
+fn test_first_difference() {
+    let a = serde_json::json!({"blocks": [{"t": "Para", "c": [1, 2]}]});
+    let b = serde_json::json!({"blocks": [{"t": "Para", "c": [1, 3]}]});
+    assert_eq!(first_difference(&a, &a, ""), None);
+    assert_eq!(
+        first_difference(&a, &b, "").as_deref(),
+        Some("blocks[0].c[1]")
+    );
+
+    let longer = serde_json::json!({"blocks": [{"t": "Para", "c": [1, 2, 3]}]});
+    assert_eq!(
+        first_difference(&a, &longer, "").as_deref(),
+        Some("blocks[0].c[2]")
+    );
+    let renamed = serde_json::json!({"blocks": [{"t": "Plain", "c": [1, 2]}]});
+    assert_eq!(
+        first_difference(&a, &renamed, "").as_deref(),
+        Some("blocks[0].t")
+    );
+}
@@ -0,0 +1,104 @@
+/*
+ * test_qmd_citations.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Citation, CitationMode, Cite, Inline, Pandoc, Paragraph, Str};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, writers};
+use quarto_pandoc_types::ConfigValue;
+use quarto_source_map::SourceInfo;
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn assert_round_trip(input: &str) {
+    let doc = read_qmd(input);
+    let output = write(&doc);
+    assert_eq!(output, input);
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+fn cite_doc(id: &str, mode: CitationMode, suffix: &str) -> Pandoc {
+    let suffix = if suffix.is_empty() {
+        vec![]
+    } else {
+        vec![Inline::Str(Str {
+            text: suffix.to_string(),
+            source_info: SourceInfo::default(),
+        })]
+    };
+    Pandoc {
+        meta: ConfigValue::default(),
+        blocks: vec![Block::Paragraph(Paragraph {
+            content: vec![Inline::Cite(Cite {
+                citations: vec![Citation {
+                    id: id.to_string(),
+                    prefix: vec![],
+                    suffix,
+                    mode,
+                    note_num: 1,
+                    hash: 0,
+                    id_source: None,
+                }],
+                content: vec![],
+                source_info: SourceInfo::default(),
+            })],
+            source_info: SourceInfo::default(),
+        })],
+    }
+}
+
+#[test]
+fn test_citation_forms_round_trip() {
+    assert_round_trip("As @knuth1984 says.\n");
+    assert_round_trip("Blah [@wickham2015].\n");
+    assert_round_trip("Wickham says blah [-@wickham2015].\n");
+    assert_round_trip("@smith04 [p. 33] says.\n");
+}
+
+#[test]
+fn test_multiple_citations_round_trip() {
+    assert_round_trip("Blah Blah [@wickham2015; @knuth1984].\n");
+    assert_round_trip("Blah Blah [see @knuth1984, pp. 33-35; also @wickham2015, chap. 1].\n");
+}
+
+#[test]
+fn test_keys_that_need_braces() {
+    assert_eq!(
+        write(&cite_doc(
+            "10.1000/xyz(1)",
+            CitationMode::NormalCitation,
+            ""
+        )),
+        "[@{10.1000/xyz(1)}]\n"
+    );
+    assert_eq!(
+        write(&cite_doc("doe:2020.a", CitationMode::AuthorInText, "")),
+        "@doe:2020.a\n"
+    );
+}
+
+#[test]
+fn test_suffix_word_is_kept_apart_from_the_key() {
+    assert_eq!(
+        write(&cite_doc("doe", CitationMode::NormalCitation, "p. 33")),
+        "[@doe p. 33]\n"
+    );
+}
@@ -0,0 +1,102 @@
+/*
+ * test_qmd_definition_lists.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, DefinitionList, Pandoc};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn assert_round_trip(input: &str) {
+    let doc = read_qmd(input);
+    let output = write(&doc);
+    assert_eq!(output, input);
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+fn definition_list(doc: &Pandoc) -> &DefinitionList {
+    match &doc.blocks[0] {
+        Block::DefinitionList(list) => list,
+        block => panic!("Expected a DefinitionList, got {:?}", block),
+    }
+}
+
+#[test]
+fn test_tight_definitions_are_plain() {
+    let doc = read_qmd("Term\n: One\n: Two\n");
+    assert_eq!(doc.blocks.len(), 1);
+    let list = definition_list(&doc);
+    assert_eq!(list.content.len(), 1);
+    let (_term, definitions) = &list.content[0];
+    assert_eq!(definitions.len(), 2);
+    assert!(matches!(definitions[0][..], [Block::Plain(_)]));
+    assert!(matches!(definitions[1][..], [Block::Plain(_)]));
+}
+
+#[test]
+fn test_loose_definitions_are_paragraphs() {
+    let doc = read_qmd("Term\n\n: One\n\n: Two\n");
+    let (_term, definitions) = &definition_list(&doc).content[0];
+    assert_eq!(definitions.len(), 2);
+    assert!(matches!(definitions[0][..], [Block::Paragraph(_)]));
+    assert!(matches!(definitions[1][..], [Block::Paragraph(_)]));
+}
+
+#[test]
+fn test_indented_blocks_continue_a_definition() {
+    let doc = read_qmd("Term\n\n:   First.\n\n    Second.\n\nNot part of it.\n");
+    assert_eq!(doc.blocks.len(), 2);
+    let (_term, definitions) = &definition_list(&doc).content[0];
+    assert_eq!(definitions.len(), 1);
+    assert_eq!(definitions[0].len(), 2);
+    assert!(matches!(doc.blocks[1], Block::Paragraph(_)));
+}
+
+#[test]
+fn test_consecutive_items_form_one_list() {
+    let doc = read_qmd("Apple\n: A fruit\n\nCarrot\n: A vegetable\n");
+    assert_eq!(doc.blocks.len(), 1);
+    assert_eq!(definition_list(&doc).content.len(), 2);
+}
+
+#[test]
+fn test_table_captions_are_not_definitions() {
+    let doc = read_qmd("| A |\n|---|\n| 1 |\n\n: Caption\n");
+    assert_eq!(doc.blocks.len(), 1);
+    assert!(matches!(doc.blocks[0], Block::Table(_)));
+}
+
+#[test]
+fn test_definition_lists_round_trip() {
+    assert_round_trip("Term\n:   One\n:   Two\n");
+    assert_round_trip("Term\n\n:   One\n\n:   Two\n");
+    assert_round_trip("Apple\n:   A fruit\n\nCarrot\n:   A vegetable\n");
+    assert_round_trip("*Term*\n\n:   First.\n\n    Second.\n");
+}
+
+#[test]
+fn test_div_syntax_round_trips_through_pandoc_syntax() {
+    let doc = read_qmd("::: {.definition-list}\n* Term\n  - Definition\n:::\n");
+    let output = write(&doc);
+    assert_eq!(output, "Term\n:   Definition\n");
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
@@ -0,0 +1,94 @@
+/*
+ * test_qmd_footnotes.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Inline, Note, Pandoc};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, transforms, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (mut doc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc.blocks = transforms::resolve_footnotes(doc.blocks, &context.source_context);
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn notes(doc: &Pandoc) -> Vec<&Note> {
+    doc.blocks
+        .iter()
+        .filter_map(|block| match block {
+            Block::Paragraph(para) => Some(&para.content),
+            _ => None,
+        })
+        .flatten()
+        .filter_map(|inline| match inline {
+            Inline::Note(note) => Some(note),
+            _ => None,
+        })
+        .collect()
+}
+
+#[test]
+fn test_references_become_notes() {
+    let doc = read_qmd("One[^a] and two[^b].\n\n[^a]: First.\n\n::: ^b\nSecond.\n\nThird.\n:::\n");
+    assert_eq!(doc.blocks.len(), 1);
+    let notes = notes(&doc);
+    assert_eq!(notes.len(), 2);
+    assert_eq!(notes[0].id.as_deref(), Some("a"));
+    assert_eq!(notes[0].content.len(), 1);
+    assert_eq!(notes[1].id.as_deref(), Some("b"));
+    assert_eq!(notes[1].content.len(), 2);
+}
+
+#[test]
+fn test_indented_continuation_paragraphs() {
+    let doc =
+        read_qmd("Text.[^long]\n\n[^long]: First.\n\n    Second.\n\n\tThird.\n\nNot a note.\n");
+    assert_eq!(doc.blocks.len(), 2);
+    assert_eq!(notes(&doc)[0].content.len(), 3);
+}
+
+#[test]
+fn test_round_trip_keeps_identifiers() {
+    let input = "One[^a] and two[^b] and one again[^a].\n\n\
+                 [^a]: First.\n\n\
+                 ::: ^b\nSecond.\n\nThird.\n:::\n";
+    let doc = read_qmd(input);
+    let output = write(&doc);
+    assert_eq!(output, input);
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+#[test]
+fn test_continuation_is_written_fenced() {
+    let output = write(&read_qmd("Text.[^n]\n\n[^n]: First.\n\n    Second.\n"));
+    assert_eq!(output, "Text.[^n]\n\n::: ^n\nFirst.\n\nSecond.\n:::\n");
+}
+
+#[test]
+fn test_unresolved_reference_is_kept() {
+    let input = "Text.[^missing]\n";
+    assert_eq!(write(&read_qmd(input)), input);
+}
+
+#[test]
+fn test_inline_notes_stay_inline() {
+    let input = "Text.^[An inline note.]\n";
+    let doc = read_qmd(input);
+    assert_eq!(notes(&doc)[0].id, None);
+    assert_eq!(write(&doc), input);
+}
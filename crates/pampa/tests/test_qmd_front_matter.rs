@@ -0,0 +1,84 @@
+/*
+ * test_qmd_front_matter.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::Pandoc;
+use pampa::{readers, writers};
+use quarto_pandoc_types::ConfigValueKind;
+
+const INPUT: &str = "---\n\
+                     # Shown on the title page\n\
+                     title: Hello\n\
+                     tags: [draft,  'notes']\n\
+                     author:\n  - Ann\n\
+                     ---\n\
+                     \n\
+                     ::: {.callout-note   #tip icon='false'}\n\
+                     \n\
+                     Body.\n\
+                     \n\
+                     :::\n";
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write_with_source(doc: &Pandoc, source: &str) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write_with_source(doc, &Default::default(), source, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+#[test]
+fn test_front_matter_is_structured() {
+    let doc = read_qmd(INPUT);
+    let ConfigValueKind::Map(entries) = &doc.meta.value else {
+        panic!("Expected a metadata map, got {:?}", doc.meta.value);
+    };
+    let keys: Vec<&str> = entries.iter().map(|entry| entry.key.as_str()).collect();
+    assert_eq!(keys, vec!["title", "tags", "author"]);
+}
+
+#[test]
+fn test_unchanged_document_round_trips_byte_for_byte() {
+    let doc = read_qmd(INPUT);
+    assert_eq!(write_with_source(&doc, INPUT), INPUT);
+}
+
+#[test]
+fn test_changed_front_matter_is_rewritten() {
+    let mut doc = read_qmd(INPUT);
+    if let ConfigValueKind::Map(entries) = &mut doc.meta.value {
+        entries.retain(|entry| entry.key != "tags");
+    }
+    let output = write_with_source(&doc, INPUT);
+    assert!(!output.contains("# Shown on the title page"));
+    assert!(!output.contains("tags"));
+    assert!(output.contains("title: Hello"));
+    // The div is unchanged, so its attribute is still copied
+    assert!(output.contains("::: {.callout-note   #tip icon='false'}\n"));
+    let ConfigValueKind::Map(entries) = read_qmd(&output).meta.value else {
+        panic!("Expected a metadata map");
+    };
+    assert_eq!(entries.len(), 2);
+}
+
+#[test]
+fn test_writing_without_source_normalizes() {
+    let doc = read_qmd(INPUT);
+    let mut buf = Vec::new();
+    writers::qmd::write(&doc, &mut buf).unwrap();
+    let output = String::from_utf8(buf).unwrap();
+    assert!(!output.contains("# Shown on the title page"));
+    assert!(output.contains("::: {#tip .callout-note icon=\"false\"}\n"));
+}
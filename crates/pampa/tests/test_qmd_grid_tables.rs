@@ -0,0 +1,201 @@
+/*
+ * test_qmd_grid_tables.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::table::Alignment;
+use pampa::pandoc::{Block, Pandoc, Table};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn assert_round_trip(input: &str) {
+    let doc = read_qmd(input);
+    let output = write(&doc);
+    assert_eq!(output, input);
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+fn table(doc: &Pandoc) -> &Table {
+    match &doc.blocks[0] {
+        Block::Table(table) => table,
+        block => panic!("Expected a Table, got {:?}", block),
+    }
+}
+
+#[test]
+fn test_grid_table_with_header() {
+    let doc = read_qmd(
+        "+-------+--------+\n\
+         | Fruit | Colour |\n\
+         +=======+========+\n\
+         | Apple | Red    |\n\
+         +-------+--------+\n\
+         | Lime  | Green  |\n\
+         +-------+--------+\n",
+    );
+    assert_eq!(doc.blocks.len(), 1);
+    let table = table(&doc);
+    assert_eq!(table.colspec.len(), 2);
+    assert_eq!(table.head.rows.len(), 1);
+    assert_eq!(table.bodies[0].body.len(), 2);
+    let cell = &table.bodies[0].body[1].cells[1];
+    assert!(matches!(cell.content[..], [Block::Plain(_)]));
+}
+
+#[test]
+fn test_grid_table_without_header() {
+    let doc = read_qmd("+---+---+\n| a | b |\n+---+---+\n");
+    let table = table(&doc);
+    assert!(table.head.rows.is_empty());
+    assert_eq!(table.bodies[0].body.len(), 1);
+}
+
+#[test]
+fn test_grid_table_cells_hold_blocks() {
+    let doc = read_qmd(
+        "+-------+----------+\n\
+         | Fruit | Notes    |\n\
+         +=======+==========+\n\
+         | Apple | - crisp  |\n\
+         |       | - sweet  |\n\
+         |       |          |\n\
+         |       | Keeps.   |\n\
+         +-------+----------+\n",
+    );
+    let cell = &table(&doc).bodies[0].body[0].cells[1];
+    assert!(matches!(
+        cell.content[..],
+        [Block::BulletList(_), Block::Paragraph(_)]
+    ));
+}
+
+#[test]
+fn test_grid_table_alignments() {
+    let doc = read_qmd(
+        "+------+------+------+------+\n\
+         | a    | b    | c    | d    |\n\
+         +:=====+=====:+:====:+======+\n\
+         | 1    | 2    | 3    | 4    |\n\
+         +------+------+------+------+\n",
+    );
+    let alignments: Vec<Alignment> = table(&doc)
+        .colspec
+        .iter()
+        .map(|(alignment, _)| alignment.clone())
+        .collect();
+    assert_eq!(
+        alignments,
+        vec![
+            Alignment::Left,
+            Alignment::Right,
+            Alignment::Center,
+            Alignment::Default
+        ]
+    );
+}
+
+#[test]
+fn test_grid_table_caption() {
+    let doc = read_qmd("+---+\n| a |\n+---+\n\n: The caption\n");
+    assert_eq!(doc.blocks.len(), 1);
+    assert!(table(&doc).caption.long.is_some());
+}
+
+#[test]
+fn test_malformed_grid_table_stays_a_paragraph() {
+    let doc = read_qmd("+---+---+\n| a   b |\n+---+---+\n");
+    assert!(matches!(doc.blocks[0], Block::Paragraph(_)));
+}
+
+#[test]
+fn test_simple_cells_write_as_pipe_table() {
+    let doc = read_qmd("+---+---+\n| a | b |\n+===+===+\n| 1 | 2 |\n+---+---+\n");
+    assert_eq!(write(&doc), "| a   | b   |\n| --- | --- |\n| 1   | 2   |\n");
+}
+
+#[test]
+fn test_grid_tables_round_trip() {
+    assert_round_trip(
+        "+-------+---------+\n\
+         | Fruit | Notes   |\n\
+         +=======+=========+\n\
+         | Apple | * crisp |\n\
+         |       | * sweet |\n\
+         +-------+---------+\n",
+    );
+    assert_round_trip(
+        "+:-----+--------:+\n\
+         | One. | First.  |\n\
+         |      |         |\n\
+         |      | Second. |\n\
+         +------+---------+\n\
+         \n\
+         : Caption\n",
+    );
+}
+
+#[test]
+fn test_grid_table_spans() {
+    let doc = read_qmd(
+        "+-----+-----+\n\
+         | A   | B   |\n\
+         +=====+=====+\n\
+         | C         |\n\
+         +-----+-----+\n\
+         | D   | E   |\n\
+         +     +-----+\n\
+         |     | F   |\n\
+         +-----+-----+\n",
+    );
+    let table = table(&doc);
+    assert_eq!(table.head.rows.len(), 1);
+    let body = &table.bodies[0].body;
+    assert_eq!(body.len(), 3);
+    assert_eq!(body[0].cells.len(), 1);
+    assert_eq!(body[0].cells[0].col_span, 2);
+    assert_eq!(body[1].cells.len(), 2);
+    assert_eq!(body[1].cells[0].row_span, 2);
+    assert_eq!(body[2].cells.len(), 1);
+}
+
+#[test]
+fn test_grid_table_footer() {
+    let input = "+---+---+\n\
+                 | a | b |\n\
+                 +===+===+\n\
+                 | 1 | 2 |\n\
+                 +===+===+\n\
+                 | x | y |\n\
+                 +===+===+\n";
+    let doc = read_qmd(input);
+    let table = table(&doc);
+    assert_eq!(table.head.rows.len(), 1);
+    assert_eq!(table.bodies[0].body.len(), 1);
+    assert_eq!(table.foot.rows.len(), 1);
+    assert_round_trip(input);
+}
+
+#[test]
+fn test_cells_that_do_not_tile_the_table_stay_a_paragraph() {
+    let doc = read_qmd("+---+---+\n| a | b |\n+---+   +\n| c     |\n+---+---+\n");
+    assert!(matches!(doc.blocks[0], Block::Paragraph(_)));
+}
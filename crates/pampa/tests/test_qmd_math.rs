@@ -0,0 +1,127 @@
+/*
+ * test_qmd_math.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Inline, Math, MathType, Pandoc, Paragraph};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, writers};
+use quarto_pandoc_types::ConfigValue;
+use quarto_source_map::SourceInfo;
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn inlines(doc: &Pandoc) -> &Vec<Inline> {
+    match &doc.blocks[0] {
+        Block::Paragraph(para) => &para.content,
+        block => panic!("Expected a Paragraph, got {:?}", block),
+    }
+}
+
+fn maths(doc: &Pandoc) -> Vec<(MathType, String)> {
+    inlines(doc)
+        .iter()
+        .filter_map(|inline| match inline {
+            Inline::Math(math) => Some((math.math_type.clone(), math.text.clone())),
+            _ => None,
+        })
+        .collect()
+}
+
+fn plain_text(doc: &Pandoc) -> String {
+    inlines(doc)
+        .iter()
+        .map(|inline| match inline {
+            Inline::Str(s) => s.text.clone(),
+            Inline::Space(_) => " ".to_string(),
+            inline => panic!("Expected only text, got {:?}", inline),
+        })
+        .collect()
+}
+
+fn math_paragraph(math_type: MathType, text: &str) -> Pandoc {
+    Pandoc {
+        meta: ConfigValue::default(),
+        blocks: vec![Block::Paragraph(Paragraph {
+            content: vec![Inline::Math(Math {
+                math_type,
+                text: text.to_string(),
+                source_info: SourceInfo::default(),
+            })],
+            source_info: SourceInfo::default(),
+        })],
+    }
+}
+
+#[test]
+fn test_inline_and_display_math() {
+    let doc = read_qmd("Let $x^2$ and $$\\sum_i x_i$$ hold.\n");
+    assert_eq!(
+        maths(&doc),
+        vec![
+            (MathType::InlineMath, "x^2".to_string()),
+            (MathType::DisplayMath, "\\sum_i x_i".to_string()),
+        ]
+    );
+}
+
+#[test]
+fn test_dollar_followed_by_digit_is_not_math() {
+    let doc = read_qmd("Costs $5/$10 each.\n");
+    assert!(maths(&doc).is_empty());
+    assert_eq!(plain_text(&doc), "Costs $5/$10 each.");
+}
+
+#[test]
+fn test_dollars_next_to_spaces_are_not_math() {
+    for input in ["It was $ 5 and 6$.\n", "From $5 to $10.\n"] {
+        assert!(maths(&read_qmd(input)).is_empty(), "{}", input);
+    }
+}
+
+#[test]
+fn test_literal_dollars_round_trip() {
+    let doc = read_qmd("Costs $5/$10 each.\n");
+    let output = write(&doc);
+    assert_eq!(output, "Costs \\$5/\\$10 each.\n");
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+#[test]
+fn test_math_round_trips() {
+    for input in [
+        "Let $x^2$ be.\n",
+        "$$\na + b\n$$\n",
+        "Dollar $a\\$b$ here.\n",
+    ] {
+        let doc = read_qmd(input);
+        let output = write(&doc);
+        assert_eq!(output, input);
+        expect_pd_ast_equal(&doc, &read_qmd(&output));
+    }
+}
+
+#[test]
+fn test_inline_math_is_written_so_it_reads_back() {
+    let doc = math_paragraph(MathType::InlineMath, " a $ b ");
+    assert_eq!(write(&doc), "$a \\$ b$\n");
+    let doc = math_paragraph(MathType::InlineMath, "a +\nb");
+    assert_eq!(write(&doc), "$a + b$\n");
+}
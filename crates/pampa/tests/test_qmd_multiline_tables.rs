@@ -0,0 +1,115 @@
+/*
+ * test_qmd_multiline_tables.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::table::Alignment;
+use pampa::pandoc::{Block, Pandoc, Table};
+use pampa::readers;
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn table(doc: &Pandoc) -> &Table {
+    match &doc.blocks[0] {
+        Block::Table(table) => table,
+        block => panic!("Expected a Table, got {:?}", block),
+    }
+}
+
+fn alignments(table: &Table) -> Vec<Alignment> {
+    table
+        .colspec
+        .iter()
+        .map(|(alignment, _)| alignment.clone())
+        .collect()
+}
+
+const PANDOC_EXAMPLE: &str = "\
+-------------------------------------------------------------
+ Centered   Default           Right Left
+  Header    Aligned         Aligned Aligned
+----------- ------- --------------- -------------------------
+   First    row                12.0 Example of a row that
+                                    spans multiple lines.
+
+  Second    row                 5.0 Here's another one. Note
+                                    the blank line between
+                                    rows.
+-------------------------------------------------------------
+";
+
+#[test]
+fn test_multiline_table_with_header() {
+    let doc = read_qmd(PANDOC_EXAMPLE);
+    assert_eq!(doc.blocks.len(), 1);
+    let table = table(&doc);
+    assert_eq!(table.colspec.len(), 4);
+    assert_eq!(table.head.rows.len(), 1);
+    assert_eq!(table.bodies[0].body.len(), 2);
+    assert_eq!(
+        alignments(table),
+        vec![
+            Alignment::Center,
+            Alignment::Default,
+            Alignment::Right,
+            Alignment::Left
+        ]
+    );
+    let cell = &table.bodies[0].body[0].cells[3];
+    assert!(matches!(cell.content[..], [Block::Plain(_)]));
+}
+
+#[test]
+fn test_multiline_table_without_header() {
+    let doc = read_qmd(
+        "----------- -------\n\
+         \x20 First    row\n\
+         \x20          wraps\n\
+         \n\
+         \x20 Second   row\n\
+         ----------- -------\n",
+    );
+    let table = table(&doc);
+    assert!(table.head.rows.is_empty());
+    assert_eq!(table.bodies[0].body.len(), 2);
+}
+
+#[test]
+fn test_rows_without_blank_lines_and_header_are_not_read() {
+    // A Pandoc simple table, which isn't read
+    let doc = read_qmd("------- -------\nFirst   row\nSecond  row\n------- -------\n");
+    assert!(!matches!(doc.blocks[0], Block::Table(_)));
+}
+
+#[test]
+fn test_multiline_table_caption() {
+    let doc = read_qmd(&format!("{}\n: The caption\n", PANDOC_EXAMPLE));
+    assert_eq!(doc.blocks.len(), 1);
+    assert!(table(&doc).caption.long.is_some());
+}
+
+#[test]
+fn test_multiline_table_between_blocks() {
+    let doc = read_qmd(&format!("Before.\n\n{}\nAfter.\n", PANDOC_EXAMPLE));
+    assert_eq!(doc.blocks.len(), 3);
+    assert!(matches!(doc.blocks[1], Block::Table(_)));
+    assert!(matches!(doc.blocks[2], Block::Paragraph(_)));
+}
+
+#[test]
+fn test_horizontal_rules_stay_rules() {
+    let doc = read_qmd("Text.\n\n-----\n\nMore text.\n\n-----\n");
+    assert!(matches!(doc.blocks[1], Block::HorizontalRule(_)));
+    assert!(matches!(doc.blocks[3], Block::HorizontalRule(_)));
+}
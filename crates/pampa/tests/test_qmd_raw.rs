@@ -0,0 +1,87 @@
+/*
+ * test_qmd_raw.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Inline, Pandoc};
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn assert_round_trip(input: &str) {
+    let doc = read_qmd(input);
+    let output = write(&doc);
+    assert_eq!(output, input);
+    expect_pd_ast_equal(&doc, &read_qmd(&output));
+}
+
+fn first_inlines(doc: &Pandoc) -> &Vec<Inline> {
+    match &doc.blocks[0] {
+        Block::Paragraph(para) => &para.content,
+        block => panic!("Expected a Paragraph, got {:?}", block),
+    }
+}
+
+#[test]
+fn test_raw_blocks_keep_their_format() {
+    for format in ["html", "latex", "typst"] {
+        let doc = read_qmd(&format!("```{{={}}}\n<raw>\n```\n", format));
+        match &doc.blocks[0] {
+            Block::RawBlock(raw) => {
+                assert_eq!(raw.format, format);
+                assert_eq!(raw.text.trim_end(), "<raw>");
+            }
+            block => panic!("Expected a RawBlock, got {:?}", block),
+        }
+    }
+}
+
+#[test]
+fn test_raw_inline_keeps_its_format() {
+    let doc = read_qmd("A `<b>`{=html} word.\n");
+    let raw = first_inlines(&doc)
+        .iter()
+        .find_map(|inline| match inline {
+            Inline::RawInline(raw) => Some(raw),
+            _ => None,
+        })
+        .expect("Expected a RawInline");
+    assert_eq!(raw.format, "html");
+    assert_eq!(raw.text, "<b>");
+}
+
+#[test]
+fn test_raw_round_trips() {
+    assert_round_trip("```{=html}\n<div class=\"x\">\n```\n");
+    assert_round_trip("```{=latex}\n\\begin{center}\n\n\\end{center}\n```\n");
+    assert_round_trip("A `\\newline`{=latex} in text.\n");
+}
+
+#[test]
+fn test_raw_inline_with_backticks_round_trips() {
+    assert_round_trip("A ``a`b``{=html} here.\n");
+    assert_round_trip("A `` `x` ``{=html} here.\n");
+}
+
+#[test]
+fn test_raw_block_with_fence_in_text_round_trips() {
+    assert_round_trip("````{=html}\n```\n````\n");
+}
@@ -0,0 +1,90 @@
+/*
+ * test_qmd_reference_links.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::Pandoc;
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::writers::qmd::QmdConfig;
+use pampa::{readers, writers};
+
+fn write(doc: &Pandoc, reference_links: bool) -> String {
+    let config = QmdConfig {
+        reference_links,
+        ..Default::default()
+    };
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(doc, &config, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+#[test]
+fn test_reference_links_survive_a_round_trip() {
+    let input = "See [the docs][docs] and [Docs] again, plus ![logo][].\n\n\
+                 [docs]: https://example.com/docs \"Docs\"\n\
+                 [logo]: logo.png\n";
+    let (doc, _) = readers::commonmark::read(input, "<test>");
+    let output = write(&doc, false);
+    assert_eq!(
+        output,
+        "See [the docs][docs] and [Docs][] again, plus ![logo][].\n\n\
+         [docs]: https://example.com/docs \"Docs\"\n\
+         [logo]: logo.png\n"
+    );
+    let (reread, _) = readers::commonmark::read(&output, "<test>");
+    expect_pd_ast_equal(&doc, &reread);
+}
+
+#[test]
+fn test_inline_links_stay_inline_by_default() {
+    let input = "[a](https://example.com)\n";
+    assert_eq!(write(&read_qmd(input), false), input);
+}
+
+#[test]
+fn test_reference_links_mode() {
+    let doc = read_qmd(
+        "[a](https://x.com) and [b](https://x.com \"X\") and [a](https://x.com) and [c](https://y.com){.cls}\n",
+    );
+    assert_eq!(
+        write(&doc, true),
+        "[a][1] and [b][2] and [a][1] and [c](https://y.com){.cls}\n\n\
+         [1]: https://x.com\n\
+         [2]: https://x.com \"X\"\n"
+    );
+}
+
+#[test]
+fn test_conflicting_labels_are_renumbered() {
+    let input = "[a][x] and [b][y]\n\n[x]: one.html\n[y]: two.html\n";
+    let (mut doc, _) = readers::commonmark::read(input, "<test>");
+    // Make the second link claim the first one's label
+    let pampa::pandoc::Block::Paragraph(para) = &mut doc.blocks[0] else {
+        panic!("Expected a paragraph");
+    };
+    let pampa::pandoc::Inline::Link(link) = &mut para.content[4] else {
+        panic!("Expected a link");
+    };
+    link.target_source.reference_label = Some("X".to_string());
+
+    let output = write(&doc, false);
+    assert_eq!(
+        output,
+        "[a][x] and [b][1]\n\n[x]: one.html\n[1]: two.html\n"
+    );
+    let (reread, _) = readers::commonmark::read(&output, "<test>");
+    expect_pd_ast_equal(&doc, &reread);
+}
@@ -0,0 +1,74 @@
+/*
+ * test_qmd_smart.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::{Block, Pandoc};
+use pampa::transforms::smart_punctuation;
+use pampa::writers::qmd::QmdConfig;
+use pampa::{readers, writers};
+
+fn read_smart(input: &str) -> Pandoc {
+    let (mut doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc.blocks = smart_punctuation(doc.blocks);
+    doc
+}
+
+fn para_text(doc: &Pandoc) -> String {
+    let Block::Paragraph(para) = &doc.blocks[0] else {
+        panic!("Expected a paragraph");
+    };
+    writers::plaintext::inlines_to_string(&para.content).0
+}
+
+fn write(doc: &Pandoc, smart: bool) -> String {
+    let config = QmdConfig {
+        smart,
+        ..Default::default()
+    };
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(doc, &config, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+#[test]
+fn test_dashes_and_ellipses_inside_words() {
+    let doc = read_smart("From 1990--2000, wait... a---b.\n");
+    assert_eq!(
+        para_text(&doc),
+        "From 1990\u{2013}2000, wait\u{2026} a\u{2014}b."
+    );
+}
+
+#[test]
+fn test_code_is_left_alone() {
+    let doc = read_smart("Run `a--b...` now.\n");
+    assert_eq!(write(&doc, false), "Run `a--b...` now.\n");
+}
+
+#[test]
+fn test_writer_reverses_smart_punctuation() {
+    let input = "From 1990--2000 -- wait... a---b.\n";
+    let doc = read_smart(input);
+    assert_eq!(write(&doc, true), input);
+    assert_eq!(
+        write(&doc, false),
+        "From 1990\u{2013}2000 \u{2013} wait\u{2026} a\u{2014}b.\n"
+    );
+}
+
+#[test]
+fn test_smart_roundtrip() {
+    let input = "It's \"quoted\" -- and...\n";
+    let first = write(&read_smart(input), true);
+    let second = write(&read_smart(&first), true);
+    assert_eq!(first, second);
+}
@@ -0,0 +1,131 @@
+/*
+ * test_qmd_stream.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for block-by-block reading and writing of qmd.
+ */
+
+use pampa::pandoc::{Block, Pandoc};
+use pampa::readers::qmd_stream::QmdBlockStream;
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::writers::qmd::{QmdConfig, QmdStreamWriter};
+use pampa::{readers, writers};
+
+const DOCUMENT: &str = "\
+---
+title: Streams
+---
+
+# Intro
+
+Some *text* with a note.[^1]
+
+- one
+
+- two
+
+  still two
+
+::: {.callout-note}
+Inside.
+
+Still inside.
+:::
+
+```python
+x = 1
+
+y = 2
+```
+
+| a |
+|---|
+| 1 |
+
+: A table
+
+[^1]: The note.
+";
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn read_stream(input: &str) -> Pandoc {
+    let mut stream = QmdBlockStream::new(input.as_bytes(), "<test>");
+    let blocks: Vec<Block> = stream.by_ref().map(|block| block.unwrap()).collect();
+    Pandoc {
+        meta: stream.meta().clone(),
+        blocks,
+    }
+}
+
+#[test]
+fn test_stream_reads_the_same_blocks() {
+    expect_pd_ast_equal(&read_qmd(DOCUMENT), &read_stream(DOCUMENT));
+}
+
+#[test]
+fn test_stream_locations_point_into_the_whole_input() {
+    let mut stream = QmdBlockStream::new(DOCUMENT.as_bytes(), "<test>");
+    let blocks: Vec<Block> = stream.by_ref().map(|block| block.unwrap()).collect();
+    let Some(Block::Table(table)) = blocks.iter().find(|b| matches!(b, Block::Table(_))) else {
+        panic!("Expected a table");
+    };
+    let mapped = table
+        .source_info
+        .map_offset(0, &stream.context().source_context)
+        .unwrap();
+    assert_eq!(mapped.location.offset, DOCUMENT.find("| a |").unwrap());
+}
+
+#[test]
+fn test_stream_continues_after_a_bad_chunk() {
+    let input = "Before.\n\n[}no]{.hello}\n\nAfter.\n";
+    let stream = QmdBlockStream::new(input.as_bytes(), "<test>");
+    let context = stream.context().clone();
+    let items: Vec<_> = stream.collect();
+    assert_eq!(items.len(), 3, "{:?}", items);
+    assert!(matches!(items[0], Ok(Block::Paragraph(_))));
+    assert!(matches!(items[2], Ok(Block::Paragraph(_))));
+
+    let Err(diagnostics) = &items[1] else {
+        panic!("Expected errors for the second chunk, got {:?}", items[1]);
+    };
+    let location = diagnostics[0]
+        .location
+        .as_ref()
+        .and_then(|location| location.map_offset(0, &context.source_context))
+        .unwrap();
+    assert!(location.location.offset >= input.find("[}").unwrap());
+}
+
+#[test]
+fn test_stream_writer_matches_write() {
+    let doc = read_qmd(DOCUMENT);
+    let config = QmdConfig::default();
+
+    let mut expected = Vec::new();
+    writers::qmd::write_with_config(&doc, &config, &mut expected).unwrap();
+
+    let mut writer = QmdStreamWriter::new(Vec::new(), &config);
+    writer.write_meta(&doc.meta).unwrap();
+    for block in &doc.blocks {
+        writer.write_block(block).unwrap();
+    }
+    let actual = writer.finish().unwrap();
+
+    assert_eq!(
+        String::from_utf8(actual).unwrap(),
+        String::from_utf8(expected).unwrap()
+    );
+}
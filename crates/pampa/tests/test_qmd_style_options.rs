@@ -0,0 +1,132 @@
+/*
+ * test_qmd_style_options.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::list::ListNumberDelim;
+use pampa::utils::ast_equal::expect_pd_ast_equal;
+use pampa::writers::qmd::{CodeBlockStyle, EmphasisDelimiter, HeadingStyle, QmdConfig};
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> pampa::pandoc::Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn convert(input: &str, config: QmdConfig) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(&read_qmd(input), &config, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+#[test]
+fn test_bullet_marker() {
+    let config = QmdConfig {
+        bullet_marker: '-',
+        ..Default::default()
+    };
+    assert_eq!(
+        convert("* one\n* two\n\n  * nested\n", config.clone()),
+        "- one\n- two\n\n  - nested\n"
+    );
+    assert_eq!(convert("* []\n", config), "- []\n");
+}
+
+#[test]
+fn test_ordered_list_delimiter() {
+    let input = "1. one\n2. two\n";
+    assert_eq!(convert(input, QmdConfig::default()), "1. one\n2. two\n");
+    let config = QmdConfig {
+        ordered_list_delimiter: Some(ListNumberDelim::OneParen),
+        ..Default::default()
+    };
+    assert_eq!(convert(input, config), "1) one\n2) two\n");
+}
+
+#[test]
+fn test_emphasis_delimiter() {
+    let config = QmdConfig {
+        emphasis: EmphasisDelimiter::Underscore,
+        ..Default::default()
+    };
+    assert_eq!(
+        convert("*one* and **two** and *a **b***\n", config.clone()),
+        "_one_ and __two__ and _a **b**_\n"
+    );
+    // `_` doesn't work inside words
+    assert_eq!(convert("un*believ*able\n", config), "un*believ*able\n");
+}
+
+#[test]
+fn test_emphasis_output_reads_back() {
+    let input = "*one* and **two** and un*believ*able and *a **b** c*\n";
+    let expected = read_qmd(input);
+    for emphasis in [EmphasisDelimiter::Asterisk, EmphasisDelimiter::Underscore] {
+        let output = convert(
+            input,
+            QmdConfig {
+                emphasis,
+                ..Default::default()
+            },
+        );
+        expect_pd_ast_equal(&expected, &read_qmd(&output));
+    }
+}
+
+#[test]
+fn test_setext_headings() {
+    let config = QmdConfig {
+        heading_style: HeadingStyle::Setext,
+        ..Default::default()
+    };
+    assert_eq!(
+        convert("# Title\n\n## Section {#intro}\n\n### Deep\n", config),
+        "Title\n=====\n\nSection {#intro}\n----------------\n\n### Deep\n"
+    );
+}
+
+#[test]
+fn test_indented_code_blocks() {
+    let config = QmdConfig {
+        code_block_style: CodeBlockStyle::Indented,
+        ..Default::default()
+    };
+    assert_eq!(
+        convert("```\nlet x = 1;\n\nlet y = 2;\n```\n", config.clone()),
+        "    let x = 1;\n\n    let y = 2;\n"
+    );
+    // Attributes need a fence
+    assert_eq!(
+        convert("```rust\nlet x = 1;\n```\n", config.clone()),
+        "```rust\nlet x = 1;\n```\n"
+    );
+    // So do leading blank lines, which indented code would drop
+    assert_eq!(
+        convert("```\n\nlet x = 1;\n```\n", config),
+        "```\n\nlet x = 1;\n```\n"
+    );
+}
+
+#[test]
+fn test_setext_and_indented_output_reads_back_as_commonmark() {
+    let input = "# Title\n\nSome text.\n\n## Section\n\n```\ncode here\n```\n";
+    let output = convert(
+        input,
+        QmdConfig {
+            heading_style: HeadingStyle::Setext,
+            code_block_style: CodeBlockStyle::Indented,
+            ..Default::default()
+        },
+    );
+    let (expected, _) = readers::commonmark::read(input, "<test>");
+    let (actual, _) = readers::commonmark::read(&output, "<test>");
+    expect_pd_ast_equal(&expected, &actual);
+}
@@ -0,0 +1,136 @@
+/*
+ * test_qmd_table_model.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::pandoc::table::ColWidth;
+use pampa::pandoc::{Block, Pandoc, Table};
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> Pandoc {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    doc
+}
+
+fn write(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn table(doc: &Pandoc) -> &Table {
+    match &doc.blocks[0] {
+        Block::Table(table) => table,
+        block => panic!("Expected a Table, got {:?}", block),
+    }
+}
+
+fn widths(table: &Table) -> Vec<ColWidth> {
+    table
+        .colspec
+        .iter()
+        .map(|(_, width)| width.clone())
+        .collect()
+}
+
+#[test]
+fn test_narrow_pipe_table_has_default_widths() {
+    let doc = read_qmd("| a | b |\n|---|-------|\n| 1 | 2 |\n");
+    assert_eq!(widths(table(&doc)), vec![ColWidth::Default; 2]);
+}
+
+#[test]
+fn test_wide_pipe_table_widths_follow_delimiter_row() {
+    let long = "word ".repeat(16);
+    let input = format!(
+        "| a | b |\n|-----|---------------|\n| 1 | {} |\n",
+        long.trim()
+    );
+    let doc = read_qmd(&input);
+    assert_eq!(
+        widths(table(&doc)),
+        vec![ColWidth::Percentage(0.25), ColWidth::Percentage(0.75)]
+    );
+}
+
+#[test]
+fn test_grid_table_widths_are_relative_to_the_page() {
+    let doc = read_qmd("+----+--------+\n| a  | b      |\n+----+--------+\n");
+    assert_eq!(
+        widths(table(&doc)),
+        vec![
+            ColWidth::Percentage(5.0 / 72.0),
+            ColWidth::Percentage(9.0 / 72.0)
+        ]
+    );
+}
+
+#[test]
+fn test_caption_attributes_are_table_attributes() {
+    let doc = read_qmd("| a |\n|---|\n| 1 |\n\n: Caption {#tbl-x .wide}\n");
+    let table = table(&doc);
+    assert_eq!(table.attr.0, "tbl-x");
+    assert_eq!(table.attr.1, vec!["wide".to_string()]);
+    assert!(table.caption.long.is_some());
+}
+
+#[test]
+fn test_caption_attributes_are_written_back() {
+    let input = "| a   |\n| --- |\n| 1   |\n\n: Caption {#tbl-x .wide}\n";
+    assert_eq!(write(&read_qmd(input)), input);
+}
+
+#[test]
+fn test_pipe_table_cells_are_padded_as_aligned() {
+    let input = "| left | center | right | default |\n|:-----|:------:|------:|---------|\n| a | b | c | d |\n";
+    assert_eq!(
+        write(&read_qmd(input)),
+        "| left | center | right | default |\n\
+         | :--- | :----: | ----: | ------- |\n\
+         | a    |   b    |     c | d       |\n"
+    );
+}
+
+#[test]
+fn test_pipe_table_padding_counts_display_width() {
+    let input = "| 名前 | b |\n|---|---|\n| x | y |\n";
+    assert_eq!(
+        write(&read_qmd(input)),
+        "| 名前 | b   |\n| ---- | --- |\n| x    | y   |\n"
+    );
+}
+
+#[test]
+fn test_pipe_table_without_padding() {
+    let input = "| a | b |\n|---|--:|\n| long cell | 2 |\n";
+    let config = writers::qmd::QmdConfig {
+        pad_tables: false,
+        ..Default::default()
+    };
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(&read_qmd(input), &config, &mut buf).unwrap();
+    assert_eq!(
+        String::from_utf8(buf).unwrap(),
+        "| a | b |\n| --- | --: |\n| long cell | 2 |\n"
+    );
+}
+
+#[test]
+fn test_wide_pipe_table_widths_are_written_back() {
+    let long = "word ".repeat(16);
+    let input = format!(
+        "| a | b |\n|-----|---------------|\n| 1 | {} |\n",
+        long.trim()
+    );
+    let doc = read_qmd(&input);
+    let written = read_qmd(&write(&doc));
+    assert_eq!(widths(table(&written)), widths(table(&doc)));
+}
@@ -0,0 +1,155 @@
+/*
+ * test_qmd_wrap.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+use pampa::writers::qmd::{QmdConfig, WrapMode};
+use pampa::{readers, writers};
+use unicode_width::UnicodeWidthStr;
+
+fn convert(input: &str, wrap: WrapMode, columns: usize) -> String {
+    let (doc, _context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    let mut buf = Vec::new();
+    writers::qmd::write_with_config(
+        &doc,
+        &QmdConfig {
+            wrap,
+            columns,
+            ..Default::default()
+        },
+        &mut buf,
+    )
+    .unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn assert_fits(output: &str, columns: usize) {
+    for line in output.lines() {
+        assert!(
+            line.width() <= columns,
+            "Line wider than {} columns: '{}'\n{}",
+            columns,
+            line,
+            output
+        );
+    }
+}
+
+const LONG: &str = "The quick brown fox jumps over the lazy dog and keeps on running \
+through the field until the sun goes down.\n";
+
+#[test]
+fn test_preserve_is_the_default() {
+    let input = "one two\nthree four\n";
+    let mut buf = Vec::new();
+    let (doc, _, _) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    writers::qmd::write(&doc, &mut buf).unwrap();
+    assert_eq!(String::from_utf8(buf).unwrap(), input);
+    assert_eq!(convert(LONG, WrapMode::Preserve, 20), LONG);
+}
+
+#[test]
+fn test_wrap_none_joins_soft_breaks() {
+    assert_eq!(
+        convert("one two\nthree four\n", WrapMode::None, 5),
+        "one two three four\n"
+    );
+}
+
+#[test]
+fn test_wrap_auto_fills_lines() {
+    let output = convert(LONG, WrapMode::Auto, 30);
+    assert_fits(&output, 30);
+    assert!(output.lines().count() > 1);
+    assert_eq!(
+        output.split_whitespace().collect::<Vec<_>>(),
+        LONG.split_whitespace().collect::<Vec<_>>()
+    );
+
+    // Soft breaks are refilled too
+    let output = convert("one\ntwo\nthree\n", WrapMode::Auto, 72);
+    assert_eq!(output, "one two three\n");
+}
+
+#[test]
+fn test_wrap_auto_counts_prefixes() {
+    let output = convert(&format!("> {}", LONG), WrapMode::Auto, 30);
+    assert_fits(&output, 30);
+    assert!(output.lines().all(|line| line.starts_with("> ")));
+
+    let output = convert(&format!("1. {}", LONG), WrapMode::Auto, 30);
+    assert_fits(&output, 30);
+    assert!(output.lines().skip(1).all(|line| line.starts_with("    ")));
+}
+
+#[test]
+fn test_wrap_auto_keeps_link_labels_and_code_together() {
+    let output = convert(
+        "Some text [a long link label](https://example.com) and `code with spaces` here.\n",
+        WrapMode::Auto,
+        10,
+    );
+    assert!(
+        output.contains("[a long link label](https://example.com)"),
+        "Got: {}",
+        output
+    );
+    assert!(output.contains("`code with spaces`"), "Got: {}", output);
+}
+
+#[test]
+fn test_wrap_auto_east_asian_width() {
+    // Each word is 8 columns wide, so two never fit in 10 columns
+    let output = convert("漢字漢字 漢字漢字 漢字漢字\n", WrapMode::Auto, 10);
+    assert_eq!(output, "漢字漢字\n漢字漢字\n漢字漢字\n");
+}
+
+#[test]
+fn test_wrap_auto_never_starts_a_line_with_block_syntax() {
+    let output = convert("aaaa - bbbb # cccc 1. dddd > eeee\n", WrapMode::Auto, 5);
+    for line in output.lines() {
+        assert!(
+            !["- ", "# ", "1. ", "> "]
+                .iter()
+                .any(|marker| line.starts_with(marker)),
+            "Line starts with block syntax: '{}'\n{}",
+            line,
+            output
+        );
+    }
+    // The text itself still reads back the same
+    assert_eq!(
+        convert(&output, WrapMode::None, 72),
+        "aaaa - bbbb # cccc 1. dddd > eeee\n"
+    );
+}
+
+#[test]
+fn test_wrap_auto_leaves_headers_and_tables_alone() {
+    let input = "# A header that is much longer than the column width\n\n| a b c | d e f |\n|-------|-------|\n| g h i | j k l |\n";
+    let output = convert(input, WrapMode::Auto, 10);
+    assert!(output.starts_with("# A header that is much longer than the column width\n"));
+    assert!(
+        output
+            .lines()
+            .any(|line| line.contains("g h i") && line.contains("j k l")),
+        "Got: {}",
+        output
+    );
+}
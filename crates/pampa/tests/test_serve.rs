@@ -0,0 +1,171 @@
+/*
+ * test_serve.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for `pampa serve --jsonrpc`.
+ */
+
+use serde_json::{Value, json};
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+fn get_binary_path() -> &'static str {
+    env!("CARGO_BIN_EXE_pampa")
+}
+
+/// Send the requests to a server, one per line, and return its responses
+fn serve(options: &[&str], requests: &[Value]) -> Vec<Value> {
+    let mut child = Command::new(get_binary_path())
+        .args(options)
+        .args(["serve", "--jsonrpc"])
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .unwrap();
+    let mut stdin = child.stdin.take().unwrap();
+    for request in requests {
+        // The server stops reading after a shutdown request
+        let _ = writeln!(stdin, "{}", request);
+    }
+    drop(stdin);
+    let output = child.wait_with_output().unwrap();
+    assert!(output.status.success(), "{:?}", output);
+    String::from_utf8(output.stdout)
+        .unwrap()
+        .lines()
+        .map(|line| serde_json::from_str(line).unwrap())
+        .collect()
+}
+
+fn request(id: u64, method: &str, params: Value) -> Value {
+    json!({"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+}
+
+fn schema() -> Value {
+    serde_json::from_str(include_str!("../resources/serve.schema.json")).unwrap()
+}
+
+#[test]
+fn test_initialize_matches_schema() {
+    let responses = serve(
+        &[],
+        &[request(1, "initialize", json!({"protocolVersion": 1}))],
+    );
+    let result = &responses[0]["result"];
+    let schema = schema();
+    assert_eq!(result["protocolVersion"], schema["protocolVersion"]);
+    let mut methods: Vec<&str> = result["methods"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|method| method.as_str().unwrap())
+        .collect();
+    let mut described: Vec<&str> = schema["methods"]
+        .as_object()
+        .unwrap()
+        .keys()
+        .map(String::as_str)
+        .collect();
+    methods.sort();
+    described.sort();
+    assert_eq!(methods, described);
+}
+
+#[test]
+fn test_unsupported_protocol_version_is_refused() {
+    let responses = serve(
+        &[],
+        &[request(1, "initialize", json!({"protocolVersion": 99}))],
+    );
+    assert_eq!(responses[0]["id"], 1);
+    assert_eq!(responses[0]["error"]["code"], -32001);
+    assert_eq!(responses[0]["error"]["data"]["protocolVersion"], 1);
+}
+
+#[test]
+fn test_parse_write_and_transform() {
+    let responses = serve(
+        &[],
+        &[request(
+            1,
+            "parse",
+            json!({"text": "# Hello {#sec-hello}\n\nSee @sec-hello.\n", "filename": "doc.qmd"}),
+        )],
+    );
+    let ast = responses[0]["result"]["ast"].clone();
+    assert!(ast["blocks"].is_array(), "{}", responses[0]);
+
+    let responses = serve(
+        &[],
+        &[
+            request(2, "write", json!({"ast": ast, "to": "html"})),
+            request(3, "transform", json!({"ast": ast, "crossref": true})),
+        ],
+    );
+    let output = responses[0]["result"]["output"].as_str().unwrap();
+    assert!(output.contains("<h1"), "{}", output);
+    assert_eq!(responses[1]["id"], 3);
+    let transformed = responses[1]["result"]["ast"].to_string();
+    assert!(transformed.contains("#sec-hello"), "{}", transformed);
+}
+
+#[test]
+fn test_parse_reports_diagnostics_and_failures() {
+    let responses = serve(
+        &[],
+        &[
+            request(1, "parse", json!({"text": "Hello"})),
+            request(2, "parse", json!({"text": "```{python\n"})),
+            request(3, "parse", json!({})),
+        ],
+    );
+    // The missing final newline is a warning
+    assert_eq!(
+        responses[0]["result"]["diagnostics"][0]["kind"], "warning",
+        "{}",
+        responses[0]
+    );
+    assert_eq!(responses[1]["error"]["code"], -32000, "{}", responses[1]);
+    assert_eq!(
+        responses[1]["error"]["data"]["diagnostics"][0]["kind"],
+        "error"
+    );
+    assert_eq!(responses[2]["error"]["code"], -32602);
+}
+
+#[test]
+fn test_format() {
+    let responses = serve(
+        &[],
+        &[
+            request(1, "format", json!({"text": "Some _text_\n"})),
+            request(2, "format", json!({"text": "Some *text*\n"})),
+        ],
+    );
+    assert_eq!(responses[0]["result"]["text"], "Some *text*\n");
+    assert_eq!(responses[0]["result"]["changed"], true);
+    assert_eq!(responses[1]["result"]["changed"], false);
+}
+
+#[test]
+fn test_protocol_errors_and_shutdown() {
+    let responses = serve(
+        &[],
+        &[
+            json!("not a request"),
+            request(1, "nonesuch", json!({})),
+            json!({"jsonrpc": "2.0", "method": "parse", "params": {"text": "x\n"}}),
+            request(2, "shutdown", json!({})),
+            request(3, "parse", json!({"text": "Never answered\n"})),
+        ],
+    );
+    // The notification gets no response, and nothing is read after
+    // shutdown
+    assert_eq!(responses.len(), 3, "{:?}", responses);
+    assert_eq!(responses[0]["error"]["code"], -32600);
+    assert_eq!(responses[0]["id"], Value::Null);
+    assert_eq!(responses[1]["error"]["code"], -32601);
+    assert_eq!(responses[2]["id"], 2);
+    assert_eq!(responses[2]["result"], Value::Null);
+}
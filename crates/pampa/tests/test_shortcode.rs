@@ -495,3 +495,81 @@ fn test_shortcode_at_end_no_trailing_space() {
         "Last inline should be Shortcode (no trailing Space)"
     );
 }
+
+// ============================================================================
+// Writing and JSON round trips
+// ============================================================================
+
+fn write_qmd(pandoc: &pampa::pandoc::Pandoc) -> String {
+    let mut buf = Vec::new();
+    pampa::writers::qmd::write(pandoc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn json_round_trip(pandoc: &pampa::pandoc::Pandoc) -> pampa::pandoc::Pandoc {
+    let context = pampa::pandoc::ast_context::ASTContext::new();
+    let mut json = Vec::new();
+    pampa::writers::json::write(pandoc, &context, &mut json).unwrap();
+    pampa::readers::json::read(&mut std::io::Cursor::new(json))
+        .unwrap()
+        .0
+}
+
+#[test]
+fn test_shortcode_qmd_round_trip() {
+    for input in [
+        "{{< meta title >}}\n",
+        "{{< video https://example.com/a.mp4 >}}\n",
+        "{{< video \"my clip.mp4\" title=\"A clip\" width=300 >}}\n",
+        "{{< env \"1\" >}}\n",
+        "{{{< include file.qmd >}}}\n",
+    ] {
+        assert_eq!(write_qmd(&parse_qmd(input)), input);
+    }
+}
+
+#[test]
+fn test_shortcode_keyword_args_written_in_key_order() {
+    let pandoc = parse_qmd("{{< video src.mp4 width=3 height=2 >}}\n");
+    assert_eq!(
+        write_qmd(&pandoc),
+        "{{< video src.mp4 height=2 width=3 >}}\n"
+    );
+}
+
+#[test]
+fn test_shortcode_span_records_raw_source() {
+    let pandoc = parse_qmd("{{< video src.mp4 >}}");
+    let span = pampa::pandoc::shortcode_to_span(get_first_shortcode(&pandoc).clone());
+    assert_eq!(
+        span.attr.2.get("data-raw").map(String::as_str),
+        Some("{{< video src.mp4 >}}")
+    );
+}
+
+#[test]
+fn test_shortcode_json_round_trip() {
+    let pandoc = parse_qmd("{{< video src.mp4 title=\"A clip\" >}}");
+    let parsed = json_round_trip(&pandoc);
+    let shortcode = get_first_shortcode(&parsed);
+    assert_eq!(shortcode.name, "video");
+    assert_eq!(get_positional_strings(shortcode), vec!["src.mp4"]);
+    assert_eq!(
+        get_keyword_arg(shortcode, "title"),
+        Some(&ShortcodeArg::String("A clip".to_string()))
+    );
+    assert!(!shortcode.is_escaped);
+}
+
+#[test]
+fn test_nested_and_escaped_shortcodes_json_round_trip() {
+    let pandoc = parse_qmd("{{< outer {{< meta x >}} >}}");
+    let parsed = json_round_trip(&pandoc);
+    assert!(matches!(
+        get_first_shortcode(&parsed).positional_args[..],
+        [ShortcodeArg::Shortcode(_)]
+    ));
+
+    let pandoc = parse_qmd("{{{< include file.qmd >}}}");
+    assert!(get_first_shortcode(&json_round_trip(&pandoc)).is_escaped);
+}
@@ -0,0 +1,124 @@
+/*
+ * test_task_lists.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for reading and writing `- [ ]` task lists.
+ */
+
+use pampa::pandoc::{ASTContext, Block, Inline, Pandoc};
+use pampa::transforms::{set_task_checked, task_items};
+use pampa::{readers, writers};
+
+fn read_qmd(input: &str) -> (Pandoc, ASTContext) {
+    let (doc, context, _warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "<test>",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+    (doc, context)
+}
+
+fn write_qmd(doc: &Pandoc) -> String {
+    let mut buf = Vec::new();
+    writers::qmd::write(doc, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+fn write_html(doc: &Pandoc, context: &ASTContext) -> String {
+    let mut buf = Vec::new();
+    writers::html::write(doc, context, &mut buf).unwrap();
+    String::from_utf8(buf).unwrap()
+}
+
+const TASKS: &str = "* [ ] write the tests\n* [x] write the code\n";
+
+#[test]
+fn test_checkboxes_are_read_as_pandoc_reads_them() {
+    let (doc, _) = read_qmd(TASKS);
+    let Block::BulletList(list) = &doc.blocks[0] else {
+        panic!("expected a bullet list: {:?}", doc.blocks);
+    };
+    let first_words: Vec<&str> = list
+        .content
+        .iter()
+        .map(|item| match &item[0] {
+            Block::Plain(plain) => match &plain.content[0] {
+                Inline::Str(s) => s.text.as_str(),
+                other => panic!("expected a Str: {:?}", other),
+            },
+            other => panic!("expected a Plain: {:?}", other),
+        })
+        .collect();
+    assert_eq!(first_words, vec!["\u{2610}", "\u{2612}"]);
+}
+
+#[test]
+fn test_task_lists_round_trip() {
+    let (doc, _) = read_qmd(TASKS);
+    assert_eq!(write_qmd(&doc), TASKS);
+
+    let ordered = "1. [x] first\n2. [ ] second\n";
+    let (doc, _) = read_qmd(ordered);
+    assert_eq!(write_qmd(&doc), ordered);
+}
+
+#[test]
+fn test_brackets_that_are_not_checkboxes() {
+    let (doc, _) = read_qmd("* [y] not a task\n* [ ]\n");
+    assert!(task_items(&doc.blocks).is_empty());
+}
+
+#[test]
+fn test_toggle_and_write_back() {
+    let (mut doc, _) = read_qmd(TASKS);
+    let states: Vec<bool> = task_items(&doc.blocks).iter().map(|t| t.checked).collect();
+    assert_eq!(states, vec![false, true]);
+
+    assert!(set_task_checked(&mut doc.blocks, 0, true));
+    assert!(set_task_checked(&mut doc.blocks, 1, false));
+    assert_eq!(
+        write_qmd(&doc),
+        "* [x] write the tests\n* [ ] write the code\n"
+    );
+}
+
+#[test]
+fn test_html_checkboxes() {
+    let (doc, context) = read_qmd(TASKS);
+    let html = write_html(&doc, &context);
+    assert!(html.contains("<ul class=\"task-list\">"), "{}", html);
+    assert!(
+        html.contains("<li><label><input type=\"checkbox\" />write the tests</label></li>"),
+        "{}",
+        html
+    );
+    assert!(
+        html.contains(
+            "<li><label><input type=\"checkbox\" checked=\"\" />write the code</label></li>"
+        ),
+        "{}",
+        html
+    );
+}
+
+#[test]
+fn test_latex_boxes() {
+    let (doc, _) = read_qmd(TASKS);
+    let mut buf = Vec::new();
+    writers::latex::write(&doc, &mut buf).unwrap();
+    let latex = String::from_utf8(buf).unwrap();
+    assert!(
+        latex.contains("\\item[$\\square$] write the tests"),
+        "{}",
+        latex
+    );
+    assert!(
+        latex.contains("\\item[$\\boxtimes$] write the code"),
+        "{}",
+        latex
+    );
+}
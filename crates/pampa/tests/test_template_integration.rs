@@ -250,6 +250,95 @@ This is content.
     assert!(output.contains("This is content."));
 }
 
+#[test]
+fn test_builtin_latex_renders() {
+    use pampa::template::builtin::get_builtin_template;
+
+    let input = r#"---
+title: LaTeX Test
+author: Test Author
+---
+
+This is *content* with 50% off.
+"#;
+    let (pandoc, mut context) = parse_qmd(input);
+    let bundle = get_builtin_template("latex").unwrap();
+
+    let (output, _) = render_with_bundle(
+        &pandoc,
+        &mut context,
+        &bundle,
+        "<builtin-template:latex>",
+        BodyFormat::Latex,
+    )
+    .expect("Render should succeed");
+
+    assert!(output.contains("\\documentclass"));
+    assert!(output.contains("{article}"));
+    assert!(output.contains("\\title{LaTeX Test}"));
+    assert!(output.contains("\\author{Test Author}"));
+    assert!(output.contains("This is \\emph{content} with 50\\% off."));
+    assert!(output.contains("\\end{document}"));
+}
+
+#[test]
+fn test_builtin_typst_renders() {
+    use pampa::template::builtin::get_builtin_template;
+
+    let input = r#"---
+title: Typst Test
+author: Test Author
+---
+
+This is *content*.
+"#;
+    let (pandoc, mut context) = parse_qmd(input);
+    let bundle = get_builtin_template("typst").unwrap();
+
+    let (output, _) = render_with_bundle(
+        &pandoc,
+        &mut context,
+        &bundle,
+        "<builtin-template:typst>",
+        BodyFormat::Typst,
+    )
+    .expect("Render should succeed");
+
+    assert!(output.contains("#set document(title: [Typst Test])"));
+    assert!(output.contains("#block[Test Author]"));
+    assert!(output.contains("This is #emph[content]."));
+}
+
+#[test]
+fn test_variables_override_metadata_in_template() {
+    use pampa::template::apply_variables;
+
+    let input = r#"---
+title: Original
+---
+
+Body.
+"#;
+    let (mut pandoc, mut context) = parse_qmd(input);
+    let variables = vec!["title=Replaced".to_string(), "draft".to_string()];
+    let (meta, diagnostics) = apply_variables(&pandoc.meta, &variables);
+    assert!(diagnostics.is_empty());
+    pandoc.meta = meta;
+
+    let bundle = TemplateBundle::new("$title$$if(draft)$ (draft)$endif$\n$body$");
+    let (output, _) = render_with_bundle(
+        &pandoc,
+        &mut context,
+        &bundle,
+        "test.tex",
+        BodyFormat::Latex,
+    )
+    .expect("Render should succeed");
+
+    assert!(output.starts_with("Replaced (draft)\n"));
+    assert!(output.contains("Body."));
+}
+
 // =============================================================================
 // WASM entry point tests
 // =============================================================================
@@ -4322,7 +4322,9 @@ fn test_pipe_table_no_caption_regression() {
 /// Test standalone caption without table (edge case - should be removed with warning)
 #[test]
 fn test_standalone_caption_no_table() {
-    let input = "Some paragraph.\n\
+    // A one-line paragraph would make this a definition list term
+    let input = "Some paragraph\n\
+                 over two lines.\n\
                  \n\
                  : Standalone caption";
     let result = parse_qmd_to_pandoc_ast(input);
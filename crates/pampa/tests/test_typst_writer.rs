@@ -0,0 +1,147 @@
+/*
+ * test_typst_writer.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Tests for the Typst writer.
+ */
+
+use pampa::pandoc::{ASTContext, treesitter_to_pandoc};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use pampa::writers::typst::write_blocks_to;
+use tree_sitter_qmd::MarkdownParser;
+
+/// Helper to render QMD to a Typst body
+fn render_qmd_to_typst(qmd: &str) -> String {
+    let input_bytes = qmd.as_bytes();
+
+    let mut parser = MarkdownParser::default();
+    let tree = parser.parse(input_bytes, None).expect("Failed to parse");
+    let mut error_collector = DiagnosticCollector::new();
+    let pandoc = treesitter_to_pandoc(
+        &mut std::io::sink(),
+        &tree,
+        input_bytes,
+        &ASTContext::anonymous(),
+        &mut error_collector,
+    )
+    .unwrap();
+
+    let mut output = Vec::new();
+    write_blocks_to(&pandoc.blocks, &mut output).unwrap();
+    String::from_utf8(output).unwrap()
+}
+
+#[test]
+fn test_headings_with_labels() {
+    let typst = render_qmd_to_typst("## Methods {#sec-methods}\n\n# Appendix {.unnumbered}\n");
+    assert!(
+        typst.contains("== Methods <sec-methods>"),
+        "Expected labelled heading, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("#heading(level: 1, numbering: none)[Appendix]"),
+        "Expected unnumbered heading, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_inline_formatting_and_escaping() {
+    let typst = render_qmd_to_typst("Some **bold**, *emph* and a #hash in snake_case.\n");
+    assert!(
+        typst.contains("Some #strong[bold], #emph[emph] and a \\#hash in snake\\_case."),
+        "Expected formatted and escaped text, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_lists() {
+    let typst = render_qmd_to_typst("- one\n- two\n\n3. three\n4. four\n");
+    assert!(
+        typst.contains("- one\n- two"),
+        "Expected bullet list, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("#set enum(numbering: \"1.\", start: 3)\n+ three\n+ four"),
+        "Expected numbered list starting at 3, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_code_block_keeps_language() {
+    let typst = render_qmd_to_typst("```python\nprint('hi')\n```\n");
+    assert!(
+        typst.contains("```python\nprint('hi')\n```"),
+        "Expected raw block with language, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_table() {
+    let typst = render_qmd_to_typst("| A | B |\n|:--|--:|\n| 1 | 2 |\n");
+    assert!(
+        typst.contains("columns: 2,"),
+        "Expected column count, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("align: (left, right,),"),
+        "Expected column alignment, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("table.header([A], [B]),"),
+        "Expected header row, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("[1], [2],"),
+        "Expected body row, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("kind: table,"),
+        "Expected table figure, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_figure_and_cross_reference() {
+    let typst = render_qmd_to_typst("![A plot](plot.png){#fig-plot}\n\nSee @fig-plot.\n");
+    assert!(
+        typst.contains("#figure(image(\"plot.png\""),
+        "Expected figure with image, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("caption: [A plot]) <fig-plot>"),
+        "Expected captioned, labelled figure, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("See @fig-plot."),
+        "Expected cross-reference, got: {}",
+        typst
+    );
+}
+
+#[test]
+fn test_citations() {
+    let typst = render_qmd_to_typst("As @knuth says [@lamport].\n");
+    assert!(
+        typst.contains("#cite(<knuth>, form: \"prose\")"),
+        "Expected in-text citation, got: {}",
+        typst
+    );
+    assert!(
+        typst.contains("@lamport"),
+        "Expected normal citation, got: {}",
+        typst
+    );
+}
@@ -34,6 +34,31 @@ Some content
     // to return warnings alongside the successful parse result.
 }
 
+#[test]
+fn test_caption_with_attributes_without_table_warning() {
+    // A caption with attributes isn't read as a definition, so with no
+    // table before it the caption is dropped with a warning
+    let input = "Some paragraph text.\n\n: This is an orphaned caption {#tbl-x}\n";
+
+    let (pandoc, _context, warnings) = readers::qmd::read(
+        input.as_bytes(),
+        false,
+        "test.md",
+        &mut std::io::sink(),
+        true,
+        None,
+    )
+    .unwrap();
+
+    assert!(
+        warnings
+            .iter()
+            .any(|w| w.title == "Caption found without a preceding table"),
+        "Should warn about the orphaned caption"
+    );
+    assert_eq!(pandoc.blocks.len(), 1, "The caption should be dropped");
+}
+
 #[test]
 fn test_caption_with_table_no_warning() {
     // Create input with a proper table caption
@@ -1107,6 +1107,7 @@ mod tests {
             meta: Default::default(),
             blocks: vec![Block::Paragraph(Paragraph {
                 content: vec![Inline::Note(Note {
+                    id: None,
                     content: vec![make_para_with_source("footnote", source_a())],
                     source_info: source_a(),
                 })],
@@ -1117,6 +1118,7 @@ mod tests {
             meta: Default::default(),
             blocks: vec![Block::Paragraph(Paragraph {
                 content: vec![Inline::Note(Note {
+                    id: None,
                     content: vec![make_para_with_source("footnote", source_b())],
                     source_info: source_b(),
                 })],
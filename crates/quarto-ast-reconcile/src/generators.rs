@@ -788,6 +788,7 @@ fn gen_cite(config: GenConfig) -> impl Strategy<Value = Inline> {
 fn gen_note(config: GenConfig) -> impl Strategy<Value = Inline> {
     gen_blocks_inner(config).prop_map(|content| {
         Inline::Note(Note {
+            id: None,
             content,
             source_info: dummy_source(),
         })
@@ -1332,6 +1332,7 @@ mod tests {
     #[test]
     fn test_hash_inline_note() {
         let note = Inline::Note(Note {
+            id: None,
             content: vec![Block::Paragraph(Paragraph {
                 content: vec![make_str("footnote")],
                 source_info: dummy_source(),
@@ -1792,6 +1793,7 @@ mod tests {
     #[test]
     fn test_structural_eq_inline_note() {
         let n1 = Inline::Note(Note {
+            id: None,
             content: vec![Block::Paragraph(Paragraph {
                 content: vec![make_str("note")],
                 source_info: dummy_source(),
@@ -1800,6 +1802,7 @@ mod tests {
         });
 
         let n2 = Inline::Note(Note {
+            id: None,
             content: vec![Block::Paragraph(Paragraph {
                 content: vec![make_str("note")],
                 source_info: other_source(),
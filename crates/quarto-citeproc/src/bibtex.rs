@@ -0,0 +1,841 @@
+//! BibTeX parsing for bibliographic references.
+//!
+//! This module converts a BibTeX (`.bib`) database into CSL-JSON
+//! [`Reference`]s, so that BibTeX bibliographies can be processed like
+//! CSL-JSON ones. The conversion follows the usual BibTeX to CSL mapping
+//! (as used by Pandoc's citeproc):
+//!
+//! - Entry types map to CSL types (`@article` → `article-journal`,
+//!   `@inproceedings` → `paper-conference`, `@phdthesis` → `thesis`, ...)
+//! - `author`, `editor` and `translator` are split on `and` into names, in
+//!   any of the `First von Last`, `von Last, First` and `von Last, Jr, First`
+//!   forms; a fully braced name is kept as a literal
+//! - `date` (biblatex) or `year`/`month`/`day` become `issued`, and `urldate`
+//!   becomes `accessed`
+//! - `@string` macros, `#` concatenation and the month macros are expanded;
+//!   `@comment` and `@preamble` are ignored
+//! - LaTeX accents, escapes and dashes are converted to Unicode, and
+//!   remaining braces and formatting commands are dropped
+//!
+//! Fields without a CSL counterpart are ignored.
+
+use crate::reference::Reference;
+use serde_json::{Map, Value, json};
+use std::collections::HashMap;
+use std::fmt;
+use std::iter::Peekable;
+use std::str::Chars;
+
+/// An error in a BibTeX database.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct BibtexError {
+    /// 1-based line of the error.
+    pub line: usize,
+    /// Description of the problem.
+    pub message: String,
+}
+
+impl fmt::Display for BibtexError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "line {}: {}", self.line, self.message)
+    }
+}
+
+impl std::error::Error for BibtexError {}
+
+/// Parse a BibTeX database into references, in the order of the entries.
+pub fn parse_bibtex(input: &str) -> std::result::Result<Vec<Reference>, BibtexError> {
+    let mut parser = Parser {
+        input,
+        pos: 0,
+        macros: HashMap::new(),
+    };
+    parser
+        .entries()?
+        .iter()
+        .map(to_reference)
+        .collect::<std::result::Result<Vec<_>, _>>()
+}
+
+/// A raw BibTeX entry, with field values still in LaTeX.
+struct Entry {
+    kind: String,
+    key: String,
+    fields: HashMap<String, String>,
+    line: usize,
+}
+
+struct Parser<'a> {
+    input: &'a str,
+    pos: usize,
+    macros: HashMap<String, String>,
+}
+
+impl<'a> Parser<'a> {
+    fn entries(&mut self) -> std::result::Result<Vec<Entry>, BibtexError> {
+        let mut entries = Vec::new();
+        // Text outside of entries is a comment
+        while let Some(offset) = self.input[self.pos..].find('@') {
+            self.pos += offset;
+            let line = line_of(self.input, self.pos);
+            self.bump();
+            self.skip_whitespace();
+            let kind = self.identifier().to_ascii_lowercase();
+            self.skip_whitespace();
+            let close = match self.bump() {
+                Some('{') => '}',
+                Some('(') => ')',
+                _ => return self.error(format!("expected '{{' or '(' after '@{}'", kind)),
+            };
+            match kind.as_str() {
+                "comment" => self.skip_comment(close)?,
+                "preamble" => {
+                    self.value()?;
+                    self.expect(close)?;
+                }
+                "string" => {
+                    self.skip_whitespace();
+                    let name = self.identifier().to_ascii_lowercase();
+                    if name.is_empty() {
+                        return self.error("expected a macro name in @string");
+                    }
+                    self.expect('=')?;
+                    let value = self.value()?;
+                    self.macros.insert(name, value);
+                    self.expect(close)?;
+                }
+                _ => entries.push(self.entry(kind, close, line)?),
+            }
+        }
+        Ok(entries)
+    }
+
+    fn entry(
+        &mut self,
+        kind: String,
+        close: char,
+        line: usize,
+    ) -> std::result::Result<Entry, BibtexError> {
+        self.skip_whitespace();
+        let start = self.pos;
+        while self
+            .peek()
+            .is_some_and(|c| c != ',' && c != close && !c.is_whitespace())
+        {
+            self.bump();
+        }
+        let key = self.input[start..self.pos].to_string();
+        let mut fields = HashMap::new();
+        loop {
+            self.skip_whitespace();
+            match self.peek() {
+                Some(',') => {
+                    self.bump();
+                }
+                Some(c) if c == close => {
+                    self.bump();
+                    break;
+                }
+                None => return self.error(format!("unterminated entry '{}'", key)),
+                Some(_) => {
+                    let name = self.identifier().to_ascii_lowercase();
+                    if name.is_empty() {
+                        return self.error(format!("expected a field name in entry '{}'", key));
+                    }
+                    self.expect('=')?;
+                    let value = self.value()?;
+                    // BibTeX keeps the first of duplicated fields
+                    fields.entry(name).or_insert(value);
+                }
+            }
+        }
+        Ok(Entry {
+            kind,
+            key,
+            fields,
+            line,
+        })
+    }
+
+    /// A field value: braced or quoted strings, numbers and macros, joined
+    /// by `#`.
+    fn value(&mut self) -> std::result::Result<String, BibtexError> {
+        let mut value = String::new();
+        loop {
+            self.skip_whitespace();
+            match self.peek() {
+                Some('{') => {
+                    self.bump();
+                    value.push_str(self.delimited('}')?);
+                }
+                Some('"') => {
+                    self.bump();
+                    value.push_str(self.delimited('"')?);
+                }
+                Some(c) if c.is_ascii_digit() => {
+                    let start = self.pos;
+                    while self.peek().is_some_and(|c| c.is_ascii_digit()) {
+                        self.bump();
+                    }
+                    value.push_str(&self.input[start..self.pos]);
+                }
+                Some(_) => {
+                    let name = self.identifier().to_ascii_lowercase();
+                    if name.is_empty() {
+                        return self.error("expected a field value");
+                    }
+                    // Undefined macros expand to nothing, as in BibTeX
+                    match self.macros.get(&name) {
+                        Some(expansion) => value.push_str(expansion),
+                        None => value.push_str(month_macro(&name).unwrap_or("")),
+                    }
+                }
+                None => return self.error("expected a field value"),
+            }
+            self.skip_whitespace();
+            if self.peek() != Some('#') {
+                return Ok(value);
+            }
+            self.bump();
+        }
+    }
+
+    /// The raw text up to `end`, the opening delimiter having been consumed.
+    /// Braces inside must balance.
+    fn delimited(&mut self, end: char) -> std::result::Result<&'a str, BibtexError> {
+        let start = self.pos;
+        let mut depth = 0;
+        while let Some(c) = self.peek() {
+            if depth == 0 && c == end {
+                let input = self.input;
+                let text = &input[start..self.pos];
+                self.bump();
+                return Ok(text);
+            }
+            match c {
+                '{' => depth += 1,
+                '}' => depth -= 1,
+                _ => {}
+            }
+            self.bump();
+        }
+        self.pos = start;
+        self.error("unterminated field value")
+    }
+
+    fn skip_comment(&mut self, close: char) -> std::result::Result<(), BibtexError> {
+        let mut depth = 0;
+        while let Some(c) = self.bump() {
+            match c {
+                '{' => depth += 1,
+                c if depth == 0 && c == close => return Ok(()),
+                '}' => depth -= 1,
+                _ => {}
+            }
+        }
+        self.error("unterminated @comment")
+    }
+
+    fn identifier(&mut self) -> &'a str {
+        let input = self.input;
+        let start = self.pos;
+        while self
+            .peek()
+            .is_some_and(|c| !c.is_whitespace() && !"{}(),=#\"".contains(c))
+        {
+            self.bump();
+        }
+        &input[start..self.pos]
+    }
+
+    fn expect(&mut self, expected: char) -> std::result::Result<(), BibtexError> {
+        self.skip_whitespace();
+        if self.peek() == Some(expected) {
+            self.bump();
+            Ok(())
+        } else {
+            self.error(format!("expected '{}'", expected))
+        }
+    }
+
+    fn skip_whitespace(&mut self) {
+        while self.peek().is_some_and(char::is_whitespace) {
+            self.bump();
+        }
+    }
+
+    fn peek(&self) -> Option<char> {
+        self.input[self.pos..].chars().next()
+    }
+
+    fn bump(&mut self) -> Option<char> {
+        let c = self.peek()?;
+        self.pos += c.len_utf8();
+        Some(c)
+    }
+
+    fn error<T>(&self, message: impl Into<String>) -> std::result::Result<T, BibtexError> {
+        Err(BibtexError {
+            line: line_of(self.input, self.pos),
+            message: message.into(),
+        })
+    }
+}
+
+fn line_of(input: &str, pos: usize) -> usize {
+    input[..pos].matches('\n').count() + 1
+}
+
+const MONTHS: [&str; 12] = [
+    "January",
+    "February",
+    "March",
+    "April",
+    "May",
+    "June",
+    "July",
+    "August",
+    "September",
+    "October",
+    "November",
+    "December",
+];
+
+fn month_macro(name: &str) -> Option<&'static str> {
+    MONTHS
+        .iter()
+        .find(|month| month[..3].eq_ignore_ascii_case(name))
+        .copied()
+}
+
+/// BibTeX fields copied as text, with the CSL variable they become. When
+/// several fields map to the same variable, the first one present wins.
+const TEXT_FIELDS: &[(&str, &str)] = &[
+    ("title", "title"),
+    ("shorttitle", "title-short"),
+    ("journal", "container-title"),
+    ("journaltitle", "container-title"),
+    ("booktitle", "container-title"),
+    ("shortjournal", "container-title-short"),
+    ("series", "collection-title"),
+    ("publisher", "publisher"),
+    ("school", "publisher"),
+    ("institution", "publisher"),
+    ("organization", "publisher"),
+    ("address", "publisher-place"),
+    ("location", "publisher-place"),
+    ("edition", "edition"),
+    ("volume", "volume"),
+    ("chapter", "chapter"),
+    ("doi", "DOI"),
+    ("isbn", "ISBN"),
+    ("issn", "ISSN"),
+    ("url", "URL"),
+    ("note", "note"),
+    ("abstract", "abstract"),
+    ("language", "language"),
+    ("langid", "language"),
+    ("keywords", "keyword"),
+];
+
+const NAME_FIELDS: &[&str] = &["author", "editor", "translator"];
+
+fn to_reference(entry: &Entry) -> std::result::Result<Reference, BibtexError> {
+    let mut csl = Map::new();
+    let (csl_type, genre) = csl_type(&entry.kind);
+    csl.insert("id".into(), entry.key.clone().into());
+    csl.insert("type".into(), csl_type.into());
+    if let Some(genre) = genre {
+        csl.insert("genre".into(), genre.into());
+    }
+
+    for (field, variable) in TEXT_FIELDS {
+        if let Some(value) = entry.fields.get(*field) {
+            csl.entry(*variable)
+                .or_insert_with(|| latex_to_text(value).into());
+        }
+    }
+    // Reports are numbered; everything else has an issue number
+    if let Some(number) = entry.fields.get("number") {
+        let variable = if csl_type == "report" {
+            "number"
+        } else {
+            "issue"
+        };
+        csl.insert(variable.into(), latex_to_text(number).into());
+    }
+    if let Some(pages) = entry.fields.get("pages") {
+        let pages = latex_to_text(&pages.replace("--", "-"));
+        csl.insert("page".into(), pages.into());
+    }
+    if let Some(published) = entry.fields.get("howpublished") {
+        match url_command(published) {
+            Some(url) => csl.entry("URL").or_insert_with(|| url.into()),
+            None => csl
+                .entry("publisher")
+                .or_insert_with(|| latex_to_text(published).into()),
+        };
+    }
+    for field in NAME_FIELDS {
+        if let Some(value) = entry.fields.get(*field) {
+            csl.insert((*field).into(), Value::Array(parse_names(value)));
+        }
+    }
+
+    let issued = match entry.fields.get("date") {
+        Some(date) => Some(parse_date(&latex_to_text(date))),
+        None => entry.fields.get("year").map(|year| {
+            year_month_day(
+                &latex_to_text(year),
+                entry.fields.get("month").map(|m| latex_to_text(m)),
+                entry.fields.get("day").map(|d| latex_to_text(d)),
+            )
+        }),
+    };
+    if let Some(issued) = issued {
+        csl.insert("issued".into(), issued);
+    }
+    if let Some(urldate) = entry.fields.get("urldate") {
+        csl.insert("accessed".into(), parse_date(&latex_to_text(urldate)));
+    }
+
+    serde_json::from_value(Value::Object(csl)).map_err(|e| BibtexError {
+        line: entry.line,
+        message: format!("invalid entry '{}': {}", entry.key, e),
+    })
+}
+
+/// The CSL type of a BibTeX entry type, with the genre for theses
+fn csl_type(kind: &str) -> (&'static str, Option<&'static str>) {
+    match kind {
+        "article" => ("article-journal", None),
+        "book" | "proceedings" | "collection" => ("book", None),
+        "booklet" => ("pamphlet", None),
+        "inbook" | "incollection" => ("chapter", None),
+        "inproceedings" | "conference" => ("paper-conference", None),
+        "manual" | "techreport" | "report" => ("report", None),
+        "mastersthesis" => ("thesis", Some("Master's thesis")),
+        "phdthesis" => ("thesis", Some("PhD thesis")),
+        "thesis" => ("thesis", None),
+        "unpublished" => ("manuscript", None),
+        "online" | "electronic" | "www" => ("webpage", None),
+        "patent" => ("patent", None),
+        "dataset" => ("dataset", None),
+        "software" => ("software", None),
+        _ => ("document", None),
+    }
+}
+
+/// The target of a `\url{...}` command making up the whole value
+fn url_command(value: &str) -> Option<String> {
+    let inner = value.trim().strip_prefix("\\url{")?.strip_suffix('}')?;
+    Some(inner.to_string())
+}
+
+/// A CSL date from a biblatex date: `YYYY`, `YYYY-MM` or `YYYY-MM-DD`, or a
+/// `start/end` range of those. Anything else is kept as a literal.
+fn parse_date(value: &str) -> Value {
+    let parts: Option<Vec<Vec<i32>>> = value
+        .split('/')
+        .map(|date| {
+            date.trim()
+                .split('-')
+                .map(|part| part.parse().ok())
+                .collect()
+        })
+        .collect();
+    match parts {
+        Some(parts) => json!({ "date-parts": parts }),
+        None => json!({ "literal": value }),
+    }
+}
+
+fn year_month_day(year: &str, month: Option<String>, day: Option<String>) -> Value {
+    let Ok(year) = year.trim().parse::<i32>() else {
+        return json!({ "literal": year });
+    };
+    let mut parts = vec![year];
+    let month = month.and_then(|month| {
+        let month = month.trim();
+        month.parse::<i32>().ok().or_else(|| {
+            MONTHS
+                .iter()
+                .position(|name| {
+                    month
+                        .get(..3)
+                        .is_some_and(|prefix| name[..3].eq_ignore_ascii_case(prefix))
+                })
+                .map(|index| index as i32 + 1)
+        })
+    });
+    if let Some(month) = month {
+        parts.push(month);
+        if let Some(day) = day.and_then(|day| day.trim().parse().ok()) {
+            parts.push(day);
+        }
+    }
+    json!({ "date-parts": [parts] })
+}
+
+/// Split a BibTeX name list into CSL names.
+fn parse_names(value: &str) -> Vec<Value> {
+    let words = split_top_level(value, char::is_whitespace);
+    words
+        .split(|word| *word == "and")
+        .filter(|name| !name.is_empty() && *name != ["others"])
+        .map(|name| parse_name(&name.join(" ")))
+        .collect()
+}
+
+fn parse_name(name: &str) -> Value {
+    if let Some(inner) = whole_braced(name) {
+        return json!({ "literal": latex_to_text(inner) });
+    }
+    let parts: Vec<Vec<&str>> = split_top_level(name, |c| c == ',')
+        .into_iter()
+        .map(|part| split_top_level(part, char::is_whitespace))
+        .collect();
+    let (given, particle, family, suffix): (&[&str], &[&str], &[&str], &[&str]) =
+        match parts.as_slice() {
+            [first_von_last] => {
+                let last = first_von_last.len().saturating_sub(1);
+                let von: Vec<usize> = (0..last).filter(|&i| is_von(first_von_last[i])).collect();
+                match (von.first(), von.last()) {
+                    (Some(&start), Some(&end)) => (
+                        &first_von_last[..start],
+                        &first_von_last[start..=end],
+                        &first_von_last[end + 1..],
+                        &[],
+                    ),
+                    _ => (&first_von_last[..last], &[], &first_von_last[last..], &[]),
+                }
+            }
+            [von_last, first] => {
+                let (particle, family) = split_von(von_last);
+                (first.as_slice(), particle, family, &[])
+            }
+            [von_last, jr, first, ..] => {
+                let (particle, family) = split_von(von_last);
+                (first.as_slice(), particle, family, jr.as_slice())
+            }
+            [] => (&[], &[], &[], &[]),
+        };
+
+    let mut csl = Map::new();
+    for (key, words) in [
+        ("family", family),
+        ("given", given),
+        ("non-dropping-particle", particle),
+        ("suffix", suffix),
+    ] {
+        if !words.is_empty() {
+            csl.insert(key.into(), latex_to_text(&words.join(" ")).into());
+        }
+    }
+    Value::Object(csl)
+}
+
+/// Split the `von Last` part of a name: leading lowercase words are the
+/// particle, but the last word is always part of the family name
+fn split_von<'a, 'b>(words: &'b [&'a str]) -> (&'b [&'a str], &'b [&'a str]) {
+    let last = words.len().saturating_sub(1);
+    let von = words[..last].iter().take_while(|word| is_von(word)).count();
+    words.split_at(von)
+}
+
+fn is_von(word: &str) -> bool {
+    word.chars().next().is_some_and(char::is_lowercase)
+}
+
+/// The content of `{...}` when the braces enclose the whole value
+fn whole_braced(value: &str) -> Option<&str> {
+    let inner = value.strip_prefix('{')?.strip_suffix('}')?;
+    let mut depth = 0;
+    for c in inner.chars() {
+        match c {
+            '{' => depth += 1,
+            '}' if depth == 0 => return None,
+            '}' => depth -= 1,
+            _ => {}
+        }
+    }
+    Some(inner)
+}
+
+/// Split on separator characters outside of braces, dropping empty pieces
+fn split_top_level(value: &str, is_separator: impl Fn(char) -> bool) -> Vec<&str> {
+    let mut pieces = Vec::new();
+    let mut depth = 0;
+    let mut start = 0;
+    for (i, c) in value.char_indices() {
+        match c {
+            '{' => depth += 1,
+            '}' => depth -= 1,
+            c if depth == 0 && is_separator(c) => {
+                pieces.push(value[start..i].trim());
+                start = i + c.len_utf8();
+            }
+            _ => {}
+        }
+    }
+    pieces.push(value[start..].trim());
+    pieces.retain(|piece| !piece.is_empty());
+    pieces
+}
+
+/// Accent commands: the command, its combining character, and the letters
+/// with a precomposed form.
+const ACCENTS: &[(&str, char, &str, &str)] = &[
+    (
+        "'",
+        '\u{301}',
+        "aeiouyAEIOUYcnszCNSZ",
+        "áéíóúýÁÉÍÓÚÝćńśźĆŃŚŹ",
+    ),
+    ("`", '\u{300}', "aeiouAEIOU", "àèìòùÀÈÌÒÙ"),
+    ("^", '\u{302}', "aeiouAEIOU", "âêîôûÂÊÎÔÛ"),
+    ("\"", '\u{308}', "aeiouyAEIOUY", "äëïöüÿÄËÏÖÜŸ"),
+    ("~", '\u{303}', "anoANO", "ãñõÃÑÕ"),
+    ("=", '\u{304}', "aeiouAEIOU", "āēīōūĀĒĪŌŪ"),
+    (".", '\u{307}', "zZeEI", "żŻėĖİ"),
+    ("c", '\u{327}', "cCsS", "çÇşŞ"),
+    ("v", '\u{30C}', "csznredtCSZNRE", "čšžňřěďťČŠŽŇŘĚ"),
+    ("u", '\u{306}', "agAG", "ăğĂĞ"),
+    ("H", '\u{30B}', "oOuU", "őŐűŰ"),
+    ("r", '\u{30A}', "auAU", "åůÅŮ"),
+    ("k", '\u{328}', "aeAE", "ąęĄĘ"),
+];
+
+/// Convert a LaTeX field value to plain text.
+fn latex_to_text(value: &str) -> String {
+    let mut text = String::new();
+    let mut chars = value.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '{' | '}' => {}
+            '\\' => command(&mut chars, &mut text),
+            '~' => text.push('\u{a0}'),
+            '-' if chars.peek() == Some(&'-') => {
+                chars.next();
+                if chars.peek() == Some(&'-') {
+                    chars.next();
+                    text.push('—');
+                } else {
+                    text.push('–');
+                }
+            }
+            c if c.is_whitespace() => {
+                if !text.is_empty() && !text.ends_with(' ') {
+                    text.push(' ');
+                }
+            }
+            c => text.push(c),
+        }
+    }
+    text.trim_end().to_string()
+}
+
+/// Convert the LaTeX command after a backslash
+fn command(chars: &mut Peekable<Chars<'_>>, text: &mut String) {
+    let Some(&first) = chars.peek() else {
+        return;
+    };
+    let mut name = String::new();
+    if first.is_ascii_alphabetic() {
+        while let Some(&c) = chars.peek() {
+            if !c.is_ascii_alphabetic() {
+                break;
+            }
+            name.push(c);
+            chars.next();
+        }
+        // A space ends a control word
+        if chars.peek() == Some(&' ') {
+            chars.next();
+        }
+    } else {
+        chars.next();
+        name.push(first);
+    }
+
+    if let Some((_, combining, bases, composed)) =
+        ACCENTS.iter().find(|(accent, ..)| *accent == name)
+    {
+        let Some(base) = accent_base(chars) else {
+            return;
+        };
+        match bases.chars().position(|c| c == base) {
+            Some(index) => text.extend(composed.chars().nth(index)),
+            None => {
+                text.push(base);
+                text.push(*combining);
+            }
+        }
+        return;
+    }
+    match name.as_str() {
+        "ss" => text.push('ß'),
+        "o" => text.push('ø'),
+        "O" => text.push('Ø'),
+        "ae" => text.push('æ'),
+        "AE" => text.push('Æ'),
+        "oe" => text.push('œ'),
+        "OE" => text.push('Œ'),
+        "aa" => text.push('å'),
+        "AA" => text.push('Å'),
+        "l" => text.push('ł'),
+        "L" => text.push('Ł'),
+        "i" => text.push('ı'),
+        "textendash" => text.push('–'),
+        "textemdash" => text.push('—'),
+        "ldots" | "dots" => text.push('…'),
+        "TeX" | "LaTeX" => text.push_str(&name),
+        // Formatting commands like \emph keep their argument's text
+        name if name.starts_with(|c: char| c.is_ascii_alphabetic()) => {}
+        // \& \% \$ \# \_ \{ \} and the like are escaped characters
+        name => text.push_str(name),
+    }
+}
+
+/// The letter an accent applies to: `\'e`, `\'{e}`, or `\'{\i}`
+fn accent_base(chars: &mut Peekable<Chars<'_>>) -> Option<char> {
+    let braced = chars.peek() == Some(&'{');
+    if braced {
+        chars.next();
+    }
+    let mut base = chars.next()?;
+    if base == '\\' {
+        // Dotless i and j take the accent as plain letters
+        base = chars.next()?;
+    }
+    if braced && chars.peek() == Some(&'}') {
+        chars.next();
+    }
+    Some(base)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn parse_one(input: &str) -> Reference {
+        let mut references = parse_bibtex(input).unwrap();
+        assert_eq!(references.len(), 1);
+        references.remove(0)
+    }
+
+    fn names(names: &Option<Vec<crate::Name>>) -> Vec<(Option<&str>, Option<&str>)> {
+        names
+            .iter()
+            .flatten()
+            .map(|name| (name.given.as_deref(), name.family.as_deref()))
+            .collect()
+    }
+
+    #[test]
+    fn test_article() {
+        let reference = parse_one(
+            r#"@Article{knuth1984,
+                author = {Donald E. Knuth},
+                title = {Literate Programming},
+                journal = "The Computer Journal",
+                year = 1984,
+                month = may,
+                volume = 27,
+                number = {2},
+                pages = {97--111},
+                doi = {10.1093/comjnl/27.2.97},
+            }"#,
+        );
+        assert_eq!(reference.id, "knuth1984");
+        assert_eq!(reference.ref_type, "article-journal");
+        assert_eq!(reference.title.as_deref(), Some("Literate Programming"));
+        assert_eq!(
+            reference.container_title.as_deref(),
+            Some("The Computer Journal")
+        );
+        assert_eq!(reference.volume.unwrap().as_str(), "27");
+        assert_eq!(reference.issue.unwrap().as_str(), "2");
+        assert_eq!(reference.page.as_deref(), Some("97-111"));
+        assert_eq!(reference.doi.as_deref(), Some("10.1093/comjnl/27.2.97"));
+        assert_eq!(
+            reference.issued.unwrap().date_parts,
+            Some(vec![vec![1984, 5]])
+        );
+        assert_eq!(
+            names(&reference.author),
+            vec![(Some("Donald E."), Some("Knuth"))]
+        );
+    }
+
+    #[test]
+    fn test_name_forms() {
+        let reference = parse_one(
+            r#"@book{key, author = {Ludwig van Beethoven and de la Fontaine, Jean
+                and Ford, Jr., Henry and {World Health Organization} and others}}"#,
+        );
+        let authors = reference.author.unwrap();
+        assert_eq!(authors.len(), 4);
+        assert_eq!(authors[0].family.as_deref(), Some("Beethoven"));
+        assert_eq!(authors[0].non_dropping_particle.as_deref(), Some("van"));
+        assert_eq!(authors[0].given.as_deref(), Some("Ludwig"));
+        assert_eq!(authors[1].family.as_deref(), Some("Fontaine"));
+        assert_eq!(authors[1].non_dropping_particle.as_deref(), Some("de la"));
+        assert_eq!(authors[1].given.as_deref(), Some("Jean"));
+        assert_eq!(authors[2].suffix.as_deref(), Some("Jr."));
+        assert_eq!(authors[2].given.as_deref(), Some("Henry"));
+        assert_eq!(
+            authors[3].literal.as_deref(),
+            Some("World Health Organization")
+        );
+    }
+
+    #[test]
+    fn test_macros_and_comments() {
+        let references = parse_bibtex(
+            r#"% a comment line
+            @comment{ignored {nested} text}
+            @preamble{"\newcommand{\noop}[1]{}"}
+            @string{acm = "ACM"}
+            @inproceedings{a, title = "A", booktitle = acm # " Conference", year = 2001}
+            @phdthesis(b, title = {B}, school = {MIT}, date = {2019-05-02/2019-05-04})"#,
+        )
+        .unwrap();
+        assert_eq!(references.len(), 2);
+        assert_eq!(references[0].ref_type, "paper-conference");
+        assert_eq!(
+            references[0].container_title.as_deref(),
+            Some("ACM Conference")
+        );
+        assert_eq!(references[1].ref_type, "thesis");
+        assert_eq!(references[1].publisher.as_deref(), Some("MIT"));
+        assert_eq!(
+            references[1].issued.clone().unwrap().date_parts,
+            Some(vec![vec![2019, 5, 2], vec![2019, 5, 4]])
+        );
+    }
+
+    #[test]
+    fn test_latex_to_text() {
+        assert_eq!(latex_to_text(r#"Schr{\"o}dinger"#), "Schrödinger");
+        assert_eq!(latex_to_text(r#"G\"{o}del"#), "Gödel");
+        assert_eq!(latex_to_text(r"Ca\~na and {\'E}mile"), "Caña and Émile");
+        assert_eq!(latex_to_text(r"Pe\v{c}ar\'{\i}k"), "Pečarík");
+        assert_eq!(latex_to_text(r"Stra{\ss}e \& more"), "Straße & more");
+        assert_eq!(latex_to_text(r"The \emph{Raven}"), "The Raven");
+        assert_eq!(
+            latex_to_text("{The} {LaTeX}  Companion"),
+            "The LaTeX Companion"
+        );
+        assert_eq!(latex_to_text("1990--2000"), "1990–2000");
+        assert_eq!(latex_to_text(r"The {\TeX}book"), "The TeXbook");
+    }
+
+    #[test]
+    fn test_errors_report_lines() {
+        let err = parse_bibtex("@article{a,\n  title = {Unclosed {nested},\n}").unwrap_err();
+        assert_eq!(err.line, 2);
+        let err = parse_bibtex("@article{a,\n  title\n}").unwrap_err();
+        assert_eq!(err.line, 3);
+    }
+}
@@ -2,7 +2,8 @@
 //!
 //! This crate provides citation processing that takes:
 //! - A parsed CSL [`Style`](quarto_csl::Style) from quarto-csl
-//! - Bibliographic [`Reference`]s in CSL-JSON format
+//! - Bibliographic [`Reference`]s in CSL-JSON format (or parsed from BibTeX
+//!   with [`parse_bibtex`])
 //! - [`Citation`] requests specifying which references to cite
 //!
 //! And produces formatted output as Pandoc `Inlines`.
@@ -45,6 +46,7 @@
 //! let formatted = processor.process_citation(&citation)?;
 //! ```
 
+pub mod bibtex;
 pub mod disambiguation;
 pub mod error;
 pub mod locale;
@@ -56,6 +58,7 @@ pub mod types;
 mod eval;
 
 // Re-export main types
+pub use bibtex::{BibtexError, parse_bibtex};
 pub use error::{Error, Result};
 pub use reference::{DateParts, Name, Reference};
 pub use types::{Citation, CitationItem, Processor};
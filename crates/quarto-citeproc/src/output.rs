@@ -1364,6 +1364,7 @@ fn to_inlines_inner(output: &Output) -> quarto_pandoc_types::Inlines {
             } else {
                 // Wrap the inlines in a Paragraph block
                 vec![Inline::Note(Note {
+                    id: None,
                     content: vec![Block::Paragraph(Paragraph {
                         content,
                         source_info: empty_source_info(),
@@ -0,0 +1,313 @@
+/*
+ * engine/cache.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Cache of executed cell outputs.
+ */
+
+//! Cache of executed cell outputs.
+//!
+//! An engine that caches writes the formatted markdown of each cell to a
+//! per-document freeze file, and on the next render reuses it instead of
+//! starting a kernel. The file lives in the project's `_freeze/` directory,
+//! next to where the document is in the project:
+//!
+//! ```text
+//! {project}/_freeze/{dir}/{stem}/execute-results/{format}.json
+//! ```
+//!
+//! # Keys
+//!
+//! Each cell is keyed by a hash of the engine, the target format, the
+//! contents of the dependency files, the cell's code and options, and the
+//! key of the cell before it. Cells depend on the state earlier cells
+//! left behind, so editing one cell changes the key of every later cell.
+//! Editing the text between cells changes no key.
+//!
+//! A cached render is all or nothing: outputs are reused when every cell
+//! has the key it was stored with and the files it wrote still exist.
+//! Otherwise the whole document runs again, since a kernel can't resume
+//! halfway through.
+//!
+//! # File Format
+//!
+//! ```json
+//! {
+//!   "engine": "jupyter",
+//!   "format": "html",
+//!   "cells": [
+//!     { "key": "sha256:...", "markdown": "::: {.cell}\n...", "files": [] }
+//!   ]
+//! }
+//! ```
+
+use std::path::{Path, PathBuf};
+
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+/// The directory freeze files are written to, in the project root.
+pub const FREEZE_DIR: &str = "_freeze";
+
+/// When engines reuse cached cell outputs.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum CacheMode {
+    /// Cache when the document sets `execute: { cache: true }`.
+    #[default]
+    Document,
+    /// Cache every document (`--cache`).
+    Always,
+    /// Execute again and replace the cache (`--cache-refresh`).
+    Refresh,
+}
+
+impl CacheMode {
+    /// Whether outputs are cached, given the document's `execute.cache`.
+    pub fn enabled(self, document: bool) -> bool {
+        match self {
+            CacheMode::Document => document,
+            CacheMode::Always | CacheMode::Refresh => true,
+        }
+    }
+}
+
+/// The cached outputs of a document, for one engine and format.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FreezeFile {
+    /// The engine that produced the outputs.
+    pub engine: String,
+    /// The target format the outputs were formatted for.
+    pub format: String,
+    /// The cells, in document order.
+    pub cells: Vec<FrozenCell>,
+}
+
+/// One cached cell.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FrozenCell {
+    /// The cell's key (see [`CellKeys`]).
+    pub key: String,
+    /// The formatted cell, as it goes into the executed markdown.
+    pub markdown: String,
+    /// Files the cell wrote, such as figures.
+    #[serde(default)]
+    pub files: Vec<PathBuf>,
+}
+
+impl FreezeFile {
+    /// The freeze file of a document.
+    ///
+    /// `root` is the project directory, or the document's directory for a
+    /// single file.
+    pub fn path(root: &Path, source_path: &Path, format: &str) -> PathBuf {
+        let relative = source_path
+            .strip_prefix(root)
+            .ok()
+            .filter(|relative| !relative.as_os_str().is_empty())
+            .map(Path::to_path_buf)
+            .unwrap_or_else(|| {
+                source_path
+                    .file_name()
+                    .map(PathBuf::from)
+                    .unwrap_or_default()
+            });
+        root.join(FREEZE_DIR)
+            .join(relative.with_extension(""))
+            .join("execute-results")
+            .join(format!("{}.json", format))
+    }
+
+    /// Read a freeze file. A missing or unreadable file is no cache.
+    pub fn read(path: &Path) -> Option<Self> {
+        let json = std::fs::read_to_string(path).ok()?;
+        serde_json::from_str(&json).ok()
+    }
+
+    /// Write the freeze file, creating its directory.
+    pub fn write(&self, path: &Path) -> std::io::Result<()> {
+        if let Some(parent) = path.parent() {
+            std::fs::create_dir_all(parent)?;
+        }
+        let json = serde_json::to_string_pretty(self).map_err(std::io::Error::other)?;
+        std::fs::write(path, json)
+    }
+
+    /// The cached cells for `keys`, if every cell is cached under its key
+    /// and the files the cells wrote still exist.
+    pub fn lookup(&self, keys: &[String]) -> Option<&[FrozenCell]> {
+        let fresh = self.cells.len() == keys.len()
+            && self
+                .cells
+                .iter()
+                .zip(keys)
+                .all(|(cell, key)| cell.key == *key && cell.files.iter().all(|f| f.exists()));
+        fresh.then_some(self.cells.as_slice())
+    }
+}
+
+/// Computes the chained keys of a document's cells.
+pub struct CellKeys {
+    /// The engine, format and dependencies, which every key includes
+    context: String,
+    /// The key of the previous cell
+    previous: String,
+}
+
+impl CellKeys {
+    /// Start the keys of a document.
+    ///
+    /// `dependencies` is a digest of the files the document depends on (see
+    /// [`dependency_digest`]).
+    pub fn new(engine: &str, format: &str, dependencies: &str) -> Self {
+        Self {
+            context: sha256(&[engine, format, dependencies]),
+            previous: String::new(),
+        }
+    }
+
+    /// The key of the next cell, from its language, code and options.
+    pub fn next(&mut self, language: &str, code: &str, options: &str) -> String {
+        let key = sha256(&[&self.context, &self.previous, language, code, options]);
+        self.previous = key.clone();
+        key
+    }
+}
+
+/// A digest of the contents of the files a document depends on.
+///
+/// Paths are relative to `base`. A missing file counts as a file with no
+/// contents that can't be read, so creating it changes the digest.
+pub fn dependency_digest(base: &Path, paths: &[String]) -> String {
+    let mut hasher = Sha256::new();
+    for path in paths {
+        hasher.update(path.len().to_le_bytes());
+        hasher.update(path.as_bytes());
+        match std::fs::read(base.join(path)) {
+            Ok(contents) => {
+                hasher.update([1]);
+                hasher.update(Sha256::digest(&contents));
+            }
+            Err(_) => hasher.update([0]),
+        }
+    }
+    format!("sha256:{:x}", hasher.finalize())
+}
+
+/// Hash parts, each prefixed with its length so that the parts can't run
+/// into each other.
+fn sha256(parts: &[&str]) -> String {
+    let mut hasher = Sha256::new();
+    for part in parts {
+        hasher.update(part.len().to_le_bytes());
+        hasher.update(part.as_bytes());
+    }
+    format!("sha256:{:x}", hasher.finalize())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn keys(cells: &[&str]) -> Vec<String> {
+        let mut keys = CellKeys::new("jupyter", "html", "");
+        cells
+            .iter()
+            .map(|code| keys.next("python", code, "echo=true"))
+            .collect()
+    }
+
+    #[test]
+    fn test_freeze_path() {
+        let root = Path::new("/project");
+        assert_eq!(
+            FreezeFile::path(root, Path::new("/project/posts/intro.qmd"), "html"),
+            PathBuf::from("/project/_freeze/posts/intro/execute-results/html.json")
+        );
+        // A document outside the root is keyed by its name
+        assert_eq!(
+            FreezeFile::path(root, Path::new("/elsewhere/doc.qmd"), "pdf"),
+            PathBuf::from("/project/_freeze/doc/execute-results/pdf.json")
+        );
+    }
+
+    #[test]
+    fn test_keys_chain() {
+        let first = keys(&["x = 1", "print(x)"]);
+        assert_eq!(first, keys(&["x = 1", "print(x)"]));
+
+        // Changing a cell changes its key and the keys after it
+        let second = keys(&["x = 2", "print(x)"]);
+        assert_ne!(first[0], second[0]);
+        assert_ne!(first[1], second[1]);
+
+        // Options and context are part of the key
+        let mut other = CellKeys::new("jupyter", "html", "");
+        assert_ne!(other.next("python", "x = 1", "echo=false"), first[0]);
+        let mut other = CellKeys::new("jupyter", "latex", "");
+        assert_ne!(other.next("python", "x = 1", "echo=true"), first[0]);
+    }
+
+    #[test]
+    fn test_lookup() {
+        let keys = keys(&["x = 1", "print(x)"]);
+        let file = FreezeFile {
+            engine: "jupyter".to_string(),
+            format: "html".to_string(),
+            cells: keys
+                .iter()
+                .map(|key| FrozenCell {
+                    key: key.clone(),
+                    markdown: "::: {.cell}\n:::".to_string(),
+                    files: vec![],
+                })
+                .collect(),
+        };
+        assert_eq!(file.lookup(&keys).map(<[_]>::len), Some(2));
+        assert!(file.lookup(&keys[..1]).is_none());
+
+        let mut missing = file.clone();
+        missing.cells[1].files = vec![PathBuf::from("/nonexistent/figure.png")];
+        assert!(missing.lookup(&keys).is_none());
+    }
+
+    #[test]
+    fn test_read_write_roundtrip() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = FreezeFile::path(temp.path(), &temp.path().join("doc.qmd"), "html");
+        assert!(FreezeFile::read(&path).is_none());
+
+        let file = FreezeFile {
+            engine: "jupyter".to_string(),
+            format: "html".to_string(),
+            cells: vec![FrozenCell {
+                key: "sha256:0".to_string(),
+                markdown: "out".to_string(),
+                files: vec![],
+            }],
+        };
+        file.write(&path).unwrap();
+        assert_eq!(FreezeFile::read(&path), Some(file));
+    }
+
+    #[test]
+    fn test_dependency_digest() {
+        let temp = tempfile::tempdir().unwrap();
+        let deps = vec!["data.csv".to_string()];
+        let missing = dependency_digest(temp.path(), &deps);
+
+        std::fs::write(temp.path().join("data.csv"), "a,b\n").unwrap();
+        let first = dependency_digest(temp.path(), &deps);
+        assert_ne!(missing, first);
+
+        std::fs::write(temp.path().join("data.csv"), "a,b\n1,2\n").unwrap();
+        assert_ne!(first, dependency_digest(temp.path(), &deps));
+    }
+
+    #[test]
+    fn test_cache_mode_enabled() {
+        assert!(!CacheMode::Document.enabled(false));
+        assert!(CacheMode::Document.enabled(true));
+        assert!(CacheMode::Always.enabled(false));
+        assert!(CacheMode::Refresh.enabled(false));
+    }
+}
@@ -11,6 +11,7 @@ use std::path::PathBuf;
 
 use quarto_pandoc_types::ConfigValue;
 
+use super::cache::CacheMode;
 use crate::stage::PandocIncludes;
 
 /// Context provided to execution engines.
@@ -51,6 +52,9 @@ pub struct ExecutionContext {
     /// For example, for `engine: { jupyter: { kernel: python3 } }`,
     /// this would contain the `{ kernel: python3 }` map.
     pub engine_config: Option<ConfigValue>,
+
+    /// When to reuse cached cell outputs (see [`CacheMode`]).
+    pub cache: CacheMode,
 }
 
 impl ExecutionContext {
@@ -69,6 +73,7 @@ impl ExecutionContext {
             format: format.into(),
             quiet: false,
             engine_config: None,
+            cache: CacheMode::default(),
         }
     }
 
@@ -89,6 +94,12 @@ impl ExecutionContext {
         self.engine_config = config;
         self
     }
+
+    /// Set when cached cell outputs are reused.
+    pub fn with_cache(mut self, cache: CacheMode) -> Self {
+        self.cache = cache;
+        self
+    }
 }
 
 /// Result of engine execution.
@@ -19,13 +19,21 @@
 //! 3. Engine-specific top-level keys: `jupyter: { kernel: python3 }`
 //! 4. Default to "markdown" if no engine is declared
 //!
+//! [`detect_engine_for_document`] also looks at the code cells when no
+//! engine is declared, and picks the first registered engine that claims
+//! one of their languages: a document with `{r}` cells uses knitr, one with
+//! cells in another executable language (`{python}`, `{julia}`) uses
+//! jupyter.
+//!
 //! # Future Enhancements
 //!
 //! In future phases, detection will also consider:
-//! - Code block languages (`{python}` → jupyter, `{r}` → knitr)
 //! - File extension (`.ipynb` → jupyter, `.Rmd` → knitr)
 
 use quarto_pandoc_types::ConfigValue;
+use quarto_pandoc_types::block::Block;
+
+use super::registry::EngineRegistry;
 
 /// Known execution engine names.
 pub const KNOWN_ENGINES: &[&str] = &["markdown", "knitr", "jupyter"];
@@ -148,13 +156,66 @@ fn extract_string_value(value: &ConfigValue) -> Option<&str> {
 /// assert_eq!(detected.name, "markdown");
 /// ```
 pub fn detect_engine(metadata: &ConfigValue) -> DetectedEngine {
+    // Default: markdown engine (no execution)
+    declared_engine(metadata).unwrap_or_default()
+}
+
+/// Detect the engine for a document from its metadata and code cells.
+///
+/// An engine declared in the metadata wins, as in [`detect_engine`].
+/// Otherwise the engine is the first one in `registry` that claims one of
+/// the cell languages (see [`EngineRegistry::engine_for_languages`]).
+/// Cells inside divs count; plain code blocks (```` ```python ````) don't.
+pub fn detect_engine_for_document(
+    metadata: &ConfigValue,
+    blocks: &[Block],
+    registry: &EngineRegistry,
+) -> DetectedEngine {
+    if let Some(declared) = declared_engine(metadata) {
+        return declared;
+    }
+    let mut languages = Vec::new();
+    collect_cell_languages(blocks, &mut languages);
+    registry
+        .engine_for_languages(&languages)
+        .map(|engine| DetectedEngine::new(engine.name()))
+        .unwrap_or_default()
+}
+
+/// The lowercased languages of the executable cells (`{python}`) in blocks,
+/// without duplicates.
+fn collect_cell_languages(blocks: &[Block], languages: &mut Vec<String>) {
+    for block in blocks {
+        match block {
+            Block::CodeBlock(code) => {
+                let language = code
+                    .attr
+                    .1
+                    .first()
+                    .and_then(|class| class.strip_prefix('{'))
+                    .and_then(|class| class.strip_suffix('}'))
+                    .map(str::to_lowercase);
+                if let Some(language) = language
+                    && !languages.contains(&language)
+                {
+                    languages.push(language);
+                }
+            }
+            Block::Div(div) => collect_cell_languages(&div.content, languages),
+            _ => {}
+        }
+    }
+}
+
+/// The engine declared in the metadata, if any.
+fn declared_engine(metadata: &ConfigValue) -> Option<DetectedEngine> {
     // Case 1: Look for explicit "engine" key
     if let Some(engine_value) = metadata.get("engine") {
         // Case 1a: engine: markdown|knitr|jupyter (string value)
         if let Some(name) = extract_string_value(engine_value) {
             // Return the engine name even if unknown - the pipeline stage
             // will handle fallback and warning for unknown engines
-            return DetectedEngine::new(name);
+            return Some(DetectedEngine::new(name));
         }
 
         // Case 1b: engine: { knitr: ... } or engine: { jupyter: ... }
@@ -165,7 +226,10 @@ pub fn detect_engine(metadata: &ConfigValue) -> DetectedEngine {
 
                 // Return even if unknown - the pipeline stage will
                 // handle fallback and warning for unknown engines
-                return DetectedEngine::with_config(engine_name.clone(), first_entry.value.clone());
+                return Some(DetectedEngine::with_config(
+                    engine_name.clone(),
+                    first_entry.value.clone(),
+                ));
             }
         }
     }
@@ -181,12 +245,14 @@ pub fn detect_engine(metadata: &ConfigValue) -> DetectedEngine {
         }
 
         if let Some(config) = metadata.get(engine_name) {
-            return DetectedEngine::with_config(engine_name.to_string(), config.clone());
+            return Some(DetectedEngine::with_config(
+                engine_name.to_string(),
+                config.clone(),
+            ));
         }
     }
 
-    // Default: markdown engine (no execution)
-    DetectedEngine::default()
+    None
 }
 
 #[cfg(test)]
@@ -403,4 +469,51 @@ mod tests {
         let detected = detect_engine(&meta);
         assert_eq!(detected.name, "markdown");
     }
+
+    fn cell(class: &str) -> Block {
+        Block::CodeBlock(quarto_pandoc_types::CodeBlock {
+            attr: (String::new(), vec![class.to_string()], Default::default()),
+            text: String::new(),
+            source_info: SourceInfo::default(),
+            attr_source: quarto_pandoc_types::AttrSourceInfo::empty(),
+        })
+    }
+
+    #[test]
+    #[cfg(not(target_arch = "wasm32"))]
+    fn test_detect_engine_for_document_from_cells() {
+        let meta = map_config(vec![]);
+        let registry = EngineRegistry::new();
+
+        let python = detect_engine_for_document(&meta, &[cell("{python}")], &registry);
+        assert_eq!(python.name, "jupyter");
+
+        let cells = [cell("{python}"), cell("{r}")];
+        let r = detect_engine_for_document(&meta, &cells, &registry);
+        assert_eq!(r.name, "knitr");
+
+        // Plain code blocks and non-executable cells don't select an engine
+        let cells = [cell("python"), cell("{mermaid}")];
+        let plain = detect_engine_for_document(&meta, &cells, &registry);
+        assert_eq!(plain.name, "markdown");
+    }
+
+    #[test]
+    #[cfg(not(target_arch = "wasm32"))]
+    fn test_detect_engine_for_document_nested_and_declared() {
+        let nested = Block::Div(quarto_pandoc_types::Div {
+            attr: (String::new(), vec![], Default::default()),
+            content: vec![cell("{julia}")],
+            source_info: SourceInfo::default(),
+            attr_source: quarto_pandoc_types::AttrSourceInfo::empty(),
+        });
+        let registry = EngineRegistry::new();
+        let detected = detect_engine_for_document(&map_config(vec![]), &[nested], &registry);
+        assert_eq!(detected.name, "jupyter");
+
+        // A declared engine wins over the cells
+        let meta = map_config(vec![("engine", string_config("markdown"))]);
+        let detected = detect_engine_for_document(&meta, &[cell("{python}")], &registry);
+        assert_eq!(detected.name, "markdown");
+    }
 }
@@ -0,0 +1,423 @@
+/*
+ * engine/inline_cells.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Inline code cells in engine input and output.
+ */
+
+//! Inline code cells in engine input and output.
+//!
+//! Engines read and write markdown, so an inline cell (`` `{python} x` ``)
+//! reaches them as a code span, and its result has to come back as
+//! markdown too. An engine replaces each cell it evaluates with a span
+//! marked with [`OUTPUT_CLASS`] that holds the result and carries the
+//! cell's language and code, percent-encoded:
+//!
+//! ```text
+//! Before: The answer is `{python} 6 * 7`.
+//! After:  The answer is [42]{.quarto-inline-cell-output language="python" code="6%20*%207"}.
+//! ```
+//!
+//! Once the executed markdown is parsed, [`restore_inline_cells`] turns
+//! these spans back into inline cell custom nodes with the span's content
+//! as their output, so that the code of a cell isn't lost.
+
+use quarto_pandoc_types::inline::{Inline, Span};
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_inlines_mut};
+use quarto_pandoc_types::{CustomNode, InlineCell};
+
+/// The class of the span an engine writes for an evaluated inline cell
+pub const OUTPUT_CLASS: &str = "quarto-inline-cell-output";
+
+/// An inline cell found in the markdown given to an engine.
+#[derive(Debug, Clone, PartialEq)]
+pub struct InlineCellSource {
+    /// Start byte offset in the markdown.
+    pub start: usize,
+    /// End byte offset in the markdown (exclusive), after the attributes
+    /// of the code span if it has any.
+    pub end: usize,
+    pub language: String,
+    pub code: String,
+    /// The text inside the braces of the code span's attributes
+    /// (`` `{python} x`{.big} ``), or empty.
+    pub attrs: String,
+}
+
+/// Find the inline cells in markdown, in order. Code spans in the front
+/// matter and in fenced code blocks aren't cells.
+pub fn find_inline_cells(markdown: &str) -> Vec<InlineCellSource> {
+    let mut cells = Vec::new();
+    let mut start = front_matter_end(markdown);
+    for (fence_start, fence_end) in fenced_blocks(markdown, start) {
+        find_in_text(markdown, start, fence_start, &mut cells);
+        start = fence_end;
+    }
+    find_in_text(markdown, start, markdown.len(), &mut cells);
+    cells
+}
+
+/// Replace cells with the markdown of their output, wrapped in the marked
+/// span. `outputs` are in the order of the cells in the markdown.
+pub fn splice_outputs(markdown: &str, outputs: &[(&InlineCellSource, String)]) -> String {
+    let mut result = String::with_capacity(markdown.len());
+    let mut last_end = 0;
+    for (cell, output) in outputs {
+        result.push_str(&markdown[last_end..cell.start]);
+        result.push_str(&output_span(cell, output));
+        last_end = cell.end;
+    }
+    result.push_str(&markdown[last_end..]);
+    result
+}
+
+/// The marked span for the output of a cell
+pub fn output_span(cell: &InlineCellSource, output: &str) -> String {
+    let mut attrs = format!(".{}", OUTPUT_CLASS);
+    if !cell.attrs.trim().is_empty() {
+        attrs.push(' ');
+        attrs.push_str(cell.attrs.trim());
+    }
+    format!(
+        "[{}]{{{} language=\"{}\" code=\"{}\"}}",
+        output,
+        attrs,
+        percent_encode(&cell.language),
+        percent_encode(&cell.code)
+    )
+}
+
+/// Escape text so that markdown reads it as it is.
+pub fn escape_markdown(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.trim().chars() {
+        match c {
+            '\n' | '\r' => escaped.push(' '),
+            '\\' | '`' | '*' | '_' | '[' | ']' | '{' | '}' | '<' | '>' | '#' | '$' | '@' | '~'
+            | '^' | '|' | '"' | '\'' => {
+                escaped.push('\\');
+                escaped.push(c);
+            }
+            _ => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+/// Turn the marked spans of evaluated cells back into inline cells.
+pub fn restore_inline_cells(ast: &mut Pandoc) {
+    let _ = walk_inlines_mut(ast, Order::TopDown, |inline| {
+        let Inline::Span(span) = inline else {
+            return Control::Continue;
+        };
+        match restore(span) {
+            Some(node) => {
+                *inline = Inline::Custom(node);
+                Control::Skip
+            }
+            None => Control::Continue,
+        }
+    });
+}
+
+/// The inline cell of a marked span
+fn restore(span: &Span) -> Option<CustomNode> {
+    let (id, classes, attributes) = &span.attr;
+    if !classes.iter().any(|class| class == OUTPUT_CLASS) {
+        return None;
+    }
+    let language = percent_decode(attributes.get("language")?);
+    let code = percent_decode(attributes.get("code").map(String::as_str).unwrap_or(""));
+    let mut cell = InlineCell::new(language, code);
+    cell.output = Some(span.content.clone());
+
+    let classes = classes
+        .iter()
+        .filter(|class| *class != OUTPUT_CLASS)
+        .cloned()
+        .collect();
+    let mut attributes = attributes.clone();
+    attributes.remove("language");
+    attributes.remove("code");
+    Some(cell.to_custom((id.clone(), classes, attributes), span.source_info.clone()))
+}
+
+/// The end of the front matter at the start of the markdown, or 0
+fn front_matter_end(markdown: &str) -> usize {
+    if !markdown.starts_with("---\n") && !markdown.starts_with("---\r\n") {
+        return 0;
+    }
+    let mut offset = 0;
+    for (index, line) in markdown.split_inclusive('\n').enumerate() {
+        offset += line.len();
+        let line = line.trim_end();
+        if index > 0 && (line == "---" || line == "...") {
+            return offset;
+        }
+    }
+    0
+}
+
+/// The byte ranges of the fenced code blocks after `from`
+fn fenced_blocks(markdown: &str, from: usize) -> Vec<(usize, usize)> {
+    let mut blocks = Vec::new();
+    // The fence character, its length and where the block starts
+    let mut open: Option<(char, usize, usize)> = None;
+    let mut offset = from;
+    for line in markdown[from..].split_inclusive('\n') {
+        let line_start = offset;
+        offset += line.len();
+        let indent = line.len() - line.trim_start_matches(' ').len();
+        if indent > 3 {
+            continue;
+        }
+        let rest = &line[indent..];
+        let Some(c) = rest.chars().next().filter(|c| *c == '`' || *c == '~') else {
+            continue;
+        };
+        let length = rest.len() - rest.trim_start_matches(c).len();
+        if length < 3 {
+            continue;
+        }
+        match open {
+            None => open = Some((c, length, line_start)),
+            Some((fence, fence_length, start))
+                if c == fence && length >= fence_length && rest[length..].trim().is_empty() =>
+            {
+                blocks.push((start, offset));
+                open = None;
+            }
+            Some(_) => {}
+        }
+    }
+    if let Some((_, _, start)) = open {
+        blocks.push((start, markdown.len()));
+    }
+    blocks
+}
+
+/// Find the inline cells in `markdown[start..end]`
+fn find_in_text(markdown: &str, start: usize, end: usize, cells: &mut Vec<InlineCellSource>) {
+    let bytes = markdown.as_bytes();
+    let mut i = start;
+    while i < end {
+        if bytes[i] != b'`' || is_escaped(bytes, start, i) {
+            i += 1;
+            continue;
+        }
+        let ticks = backtick_run(bytes, i, end);
+        let content_start = i + ticks;
+        let Some(content_end) = closing_run(bytes, content_start, end, ticks) else {
+            i = content_start;
+            continue;
+        };
+        let span_end = content_end + ticks;
+        let content = &markdown[content_start..content_end];
+        i = span_end;
+        let Some(cell) = InlineCell::parse_code(&content.replace(['\r', '\n'], " ")) else {
+            continue;
+        };
+        let (attrs, cell_end) = match trailing_attrs(markdown, span_end, end) {
+            Some((attrs, attrs_end)) => (attrs.to_string(), attrs_end),
+            None => (String::new(), span_end),
+        };
+        i = cell_end;
+        cells.push(InlineCellSource {
+            start: content_start - ticks,
+            end: cell_end,
+            language: cell.language,
+            code: cell.code,
+            attrs,
+        });
+    }
+}
+
+/// Whether the byte at `i` is escaped by an odd number of backslashes
+fn is_escaped(bytes: &[u8], start: usize, i: usize) -> bool {
+    let backslashes = bytes[start..i]
+        .iter()
+        .rev()
+        .take_while(|b| **b == b'\\')
+        .count();
+    backslashes % 2 == 1
+}
+
+/// The number of backticks starting at `i`
+fn backtick_run(bytes: &[u8], i: usize, end: usize) -> usize {
+    bytes[i..end].iter().take_while(|b| **b == b'`').count()
+}
+
+/// The start of the run of exactly `ticks` backticks that closes a code
+/// span whose content starts at `from`. Code spans don't cross paragraphs.
+fn closing_run(bytes: &[u8], from: usize, end: usize, ticks: usize) -> Option<usize> {
+    let mut j = from;
+    while j < end {
+        match bytes[j] {
+            b'`' => {
+                let run = backtick_run(bytes, j, end);
+                if run == ticks {
+                    return Some(j);
+                }
+                j += run;
+            }
+            b'\n' if bytes[j + 1..end].starts_with(b"\n") => return None,
+            _ => j += 1,
+        }
+    }
+    None
+}
+
+/// The text inside the attributes right after a code span and where they
+/// end
+fn trailing_attrs(markdown: &str, from: usize, end: usize) -> Option<(&str, usize)> {
+    let rest = markdown[from..end].strip_prefix('{')?;
+    let close = rest.find(['}', '\n'])?;
+    if !rest[close..].starts_with('}') {
+        return None;
+    }
+    Some((&rest[..close], from + close + 2))
+}
+
+/// Percent-encode what can't appear in a quoted attribute value as it is
+fn percent_encode(text: &str) -> String {
+    let mut encoded = String::with_capacity(text.len());
+    for c in text.chars() {
+        if c.is_ascii_alphanumeric() || "-_.~()+*,;:=!?/<>&|^$#@".contains(c) || !c.is_ascii() {
+            encoded.push(c);
+        } else {
+            for byte in c.to_string().bytes() {
+                encoded.push_str(&format!("%{:02X}", byte));
+            }
+        }
+    }
+    encoded
+}
+
+fn percent_decode(text: &str) -> String {
+    let bytes = text.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%'
+            && let Some(byte) = text
+                .get(i + 1..i + 3)
+                .and_then(|hex| u8::from_str_radix(hex, 16).ok())
+        {
+            decoded.push(byte);
+            i += 3;
+        } else {
+            decoded.push(bytes[i]);
+            i += 1;
+        }
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_pandoc_types::inline::Str;
+    use quarto_pandoc_types::{Block, INLINE_CELL_TYPE_NAME};
+
+    fn cell(language: &str, code: &str) -> (String, String) {
+        (language.to_string(), code.to_string())
+    }
+
+    fn found(markdown: &str) -> Vec<(String, String)> {
+        find_inline_cells(markdown)
+            .into_iter()
+            .map(|cell| (cell.language, cell.code))
+            .collect()
+    }
+
+    #[test]
+    fn test_find_inline_cells() {
+        assert_eq!(
+            found("A `{python} x + 1` and ``{r} `y` `` and `code`.\n"),
+            vec![cell("python", "x + 1"), cell("r", "`y`")]
+        );
+        // Not in front matter, fenced blocks or escaped backticks
+        assert_eq!(
+            found(
+                "---\ntitle: \"`{python} a`\"\n---\n\n```{python}\n`{python} b`\n```\n\n\\`{python} c\\` `{python} d`\n"
+            ),
+            vec![cell("python", "d")]
+        );
+        // Not across paragraphs
+        assert_eq!(found("`{python} a\n\nb`\n"), vec![]);
+        assert_eq!(found("`{python} a\nb`\n"), vec![cell("python", "a b")]);
+    }
+
+    #[test]
+    fn test_find_inline_cells_with_attrs() {
+        let markdown = "A `{python} x`{.big} b.";
+        let cells = find_inline_cells(markdown);
+        assert_eq!(cells.len(), 1);
+        assert_eq!(cells[0].attrs, ".big");
+        assert_eq!(
+            &markdown[cells[0].start..cells[0].end],
+            "`{python} x`{.big}"
+        );
+    }
+
+    #[test]
+    fn test_splice_outputs() {
+        let markdown = "A `{python} 6 * 7` b `{python} \"hi\"`.";
+        let cells = find_inline_cells(markdown);
+        let outputs = vec![(&cells[0], "42".to_string()), (&cells[1], "hi".to_string())];
+        assert_eq!(
+            splice_outputs(markdown, &outputs),
+            "A [42]{.quarto-inline-cell-output language=\"python\" code=\"6%20*%207\"} b \
+             [hi]{.quarto-inline-cell-output language=\"python\" code=\"%22hi%22\"}."
+        );
+    }
+
+    #[test]
+    fn test_percent_encoding_round_trip() {
+        for text in ["f(x, \"y\")", "a\\b %20 {c}", "naïve 'q'"] {
+            assert_eq!(percent_decode(&percent_encode(text)), text);
+        }
+        assert!(!percent_encode("\"\\ {}").contains(['"', '\\', ' ', '{', '}']));
+    }
+
+    #[test]
+    fn test_escape_markdown() {
+        assert_eq!(escape_markdown(" *a* [b]\n"), "\\*a\\* \\[b\\]");
+        assert_eq!(escape_markdown("1.5"), "1.5");
+    }
+
+    #[test]
+    fn test_restore_inline_cells() {
+        let markdown = "A `{python} 6 * 7`{.big} b.";
+        let cells = find_inline_cells(markdown);
+        let executed = splice_outputs(markdown, &[(&cells[0], "42".to_string())]);
+        let (mut ast, _context, _warnings) = pampa::readers::qmd::read(
+            executed.as_bytes(),
+            false,
+            "test.qmd",
+            &mut std::io::sink(),
+            true,
+            None,
+        )
+        .unwrap();
+        restore_inline_cells(&mut ast);
+
+        let Block::Paragraph(para) = &ast.blocks[0] else {
+            panic!("Expected a paragraph: {:?}", ast.blocks);
+        };
+        let Some(Inline::Custom(node)) = para.content.get(2) else {
+            panic!("Expected an inline cell: {:?}", para.content);
+        };
+        assert_eq!(node.type_name, INLINE_CELL_TYPE_NAME);
+        assert_eq!(node.attr.1, vec!["big".to_string()]);
+        assert!(node.attr.2.is_empty());
+        let cell = InlineCell::from_custom(node).unwrap();
+        assert_eq!(cell.language, "python");
+        assert_eq!(cell.code, "6 * 7");
+        assert!(matches!(
+            cell.output.as_deref(),
+            Some([Inline::Str(Str { text, .. })]) if text == "42"
+        ));
+    }
+}
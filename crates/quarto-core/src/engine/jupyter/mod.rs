@@ -166,6 +166,10 @@ impl ExecutionEngine for JupyterEngine {
         true
     }
 
+    fn claims_language(&self, language: &str) -> bool {
+        text_execute::is_executable_language(language)
+    }
+
     fn is_available(&self) -> bool {
         self.jupyter_path.is_some()
     }
@@ -198,6 +202,14 @@ mod tests {
         assert_eq!(engine.name(), "jupyter");
     }
 
+    #[test]
+    fn test_jupyter_engine_claims_languages() {
+        let engine = JupyterEngine::new();
+        assert!(engine.claims_language("python"));
+        assert!(engine.claims_language("julia"));
+        assert!(!engine.claims_language("mermaid"));
+    }
+
     #[test]
     fn test_jupyter_engine_can_freeze() {
         let engine = JupyterEngine::new();
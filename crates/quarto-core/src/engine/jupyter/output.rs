@@ -202,7 +202,7 @@ fn convert_mime_content(mime_type: &str, content: &serde_json::Value) -> Option<
 /// Extract text content from a JSON value.
 ///
 /// Jupyter can send text as either a string or an array of strings.
-fn extract_text_content(value: &serde_json::Value) -> String {
+pub(super) fn extract_text_content(value: &serde_json::Value) -> String {
     match value {
         serde_json::Value::String(s) => s.clone(),
         serde_json::Value::Array(arr) => arr
@@ -263,10 +263,6 @@ pub fn strip_ansi_codes(s: &str) -> String {
 }
 
 /// Determine the MIME type priority for a target format.
-///
-/// Will be used when format-specific output conversion is implemented.
-/// Has unit test in this module.
-#[allow(dead_code)]
 pub fn mime_priority_for_format(format: &str) -> &'static [&'static str] {
     match format {
         "latex" | "pdf" | "beamer" => &[
@@ -10,18 +10,66 @@
 //! This module implements the text-in/text-out pattern required by [`ExecutionEngine`].
 //! It parses QMD input, executes code blocks via the Jupyter daemon, and returns
 //! markdown with outputs inserted.
+//!
+//! # Cells
+//!
+//! Each executable block becomes a `::: {.cell}` div holding the code (as a
+//! `.cell-code` block) followed by its outputs. Image outputs are written to
+//! `{stem}_files/figure-{format}/` next to the document and referenced from
+//! the markdown. When a bundle has several representations, the first one in
+//! [`mime_priority_for_format`] for the target format is used.
+//!
+//! # Cell options
+//!
+//! Options come from the `execute` key of the front matter, then from the
+//! fence attributes (`{python echo=false}`), then from `#|` comment lines at
+//! the top of the cell (`//|` for languages with C-style comments), with
+//! later sources taking precedence:
+//!
+//! - `eval: false` shows the code without running it
+//! - `echo: false` runs the code but hides it
+//! - `output: false` hides the outputs, `output: asis` inserts them as raw
+//!   markdown instead of wrapping them
+//! - `include: false` runs the code but leaves the cell out of the document
+//!
+//! The `#|` lines are removed from the code that is shown. Option lines that
+//! aren't valid YAML are ignored.
+//!
+//! # Inline cells
+//!
+//! Inline cells (`` `{python} x` ``) in the kernel's language are evaluated
+//! after the blocks, in the same kernel, unless the front matter turns
+//! `eval` off. Their result is the `text/markdown` or `text/plain`
+//! representation of the value, or else what they print, and replaces
+//! them in a span that keeps the code (see
+//! [`crate::engine::inline_cells`]).
+//!
+//! # Caching
+//!
+//! With `execute: { cache: true }` (or `--cache`), the formatted cells are
+//! kept in the document's freeze file and reused while no cell, option or
+//! dependency has changed (see [`crate::engine::cache`]). Files listed in
+//! `execute: { dependencies: [...] }`, relative to the document, are part
+//! of the key. Documents with inline cells that are evaluated aren't
+//! taken from the cache: the values of the cells need a kernel that has
+//! run the blocks.
 
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 
+use base64::Engine;
 use regex::Regex;
 
 use super::daemon::daemon;
 use super::error::JupyterError;
-use super::execute::{CellOutput, ExecuteResult as KernelExecuteResult, ExecuteStatus};
-use super::output::strip_ansi_codes;
+use super::execute::{CellOutput, ExecuteResult as KernelExecuteResult, ExecuteStatus, MimeBundle};
+use super::output::{extract_text_content, mime_priority_for_format, strip_ansi_codes};
 use super::session::SessionKey;
+use crate::engine::cache::{CacheMode, CellKeys, FreezeFile, FrozenCell, dependency_digest};
 use crate::engine::context::{ExecuteResult, ExecutionContext};
 use crate::engine::error::ExecutionError;
+use crate::engine::inline_cells::{
+    InlineCellSource, escape_markdown, find_inline_cells, output_span,
+};
 
 type JupyterResult<T> = std::result::Result<T, JupyterError>;
 
@@ -34,10 +82,130 @@ struct CodeBlock {
     end: usize,
     /// The language/engine specifier (e.g., "python", "julia").
     language: String,
+    /// The attributes after the language (e.g., `echo=false`).
+    attrs: String,
     /// The code content.
     code: String,
-    /// The full original fence (for preservation).
-    original: String,
+}
+
+/// What to do with the outputs of a cell.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum OutputMode {
+    /// Wrap the outputs in `.cell-output` blocks.
+    Show,
+    /// Leave the outputs out.
+    Hide,
+    /// Insert the outputs as raw markdown (`output: asis`).
+    Asis,
+}
+
+/// The execution options of a cell.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct CellOptions {
+    eval: bool,
+    echo: bool,
+    output: OutputMode,
+    include: bool,
+}
+
+impl Default for CellOptions {
+    fn default() -> Self {
+        Self {
+            eval: true,
+            echo: true,
+            output: OutputMode::Show,
+            include: true,
+        }
+    }
+}
+
+impl CellOptions {
+    /// Set one option. Unknown keys and values are ignored.
+    fn apply(&mut self, key: &str, value: &serde_yaml::Value) {
+        use serde_yaml::Value;
+        match (key, value) {
+            ("eval", Value::Bool(b)) => self.eval = *b,
+            ("echo", Value::Bool(b)) => self.echo = *b,
+            ("echo", Value::String(s)) if s == "fenced" => self.echo = true,
+            ("output", Value::Bool(true)) => self.output = OutputMode::Show,
+            ("output", Value::Bool(false)) => self.output = OutputMode::Hide,
+            ("output", Value::String(s)) if s == "asis" => self.output = OutputMode::Asis,
+            ("include", Value::Bool(b)) => self.include = *b,
+            _ => {}
+        }
+    }
+
+    /// The options as part of a cache key.
+    fn cache_key(&self) -> String {
+        format!(
+            "eval={} echo={} output={:?} include={}",
+            self.eval, self.echo, self.output, self.include
+        )
+    }
+
+    fn apply_map(&mut self, map: &serde_yaml::Mapping) {
+        for (key, value) in map {
+            if let Some(key) = key.as_str() {
+                self.apply(key, value);
+            }
+        }
+    }
+
+    /// Set the options given as fence attributes, e.g. `echo=false`.
+    fn apply_attrs(&mut self, attrs: &str) {
+        for (key, value) in parse_fence_attrs(attrs) {
+            if let Ok(value) = serde_yaml::from_str::<serde_yaml::Value>(&value) {
+                self.apply(&key, &value);
+            }
+        }
+    }
+}
+
+/// The settings of the front matter's `execute` key.
+#[derive(Debug, Default)]
+struct DocumentOptions {
+    /// The defaults for the options of every cell.
+    cells: CellOptions,
+    /// `execute.cache`
+    cache: bool,
+    /// `execute.dependencies`: files the outputs depend on.
+    dependencies: Vec<String>,
+}
+
+/// Where image outputs are written, and how the markdown refers to them.
+struct FigureDir {
+    /// The directory to write to.
+    dir: PathBuf,
+    /// The directory relative to the document.
+    href: String,
+    /// The figures written so far.
+    written: Vec<PathBuf>,
+}
+
+impl FigureDir {
+    /// The figure directory for a document: `{stem}_files/figure-{format}`.
+    fn for_document(source_path: &Path, format: &str) -> Self {
+        let stem = source_path
+            .file_stem()
+            .and_then(|s| s.to_str())
+            .unwrap_or("document");
+        let href = format!("{}_files/figure-{}", stem, format);
+        let parent = source_path.parent().unwrap_or(Path::new(""));
+        Self {
+            dir: parent.join(&href),
+            href,
+            written: Vec::new(),
+        }
+    }
+
+    /// Write a figure and return its path relative to the document.
+    fn write(&mut self, name: &str, contents: &[u8]) -> JupyterResult<String> {
+        std::fs::create_dir_all(&self.dir)?;
+        let path = self.dir.join(name);
+        std::fs::write(&path, contents)?;
+        self.written.push(path);
+        Ok(format!("{}/{}", self.href, name))
+    }
 }
 
 /// Execute code blocks in QMD input and return markdown with outputs.
@@ -50,16 +218,23 @@ pub fn execute_qmd(
     // Parse code blocks from input
     let blocks = parse_code_blocks(input);
 
-    if blocks.is_empty() {
-        // No executable code - passthrough
-        return Ok(ExecuteResult::new(input));
-    }
-
-    // Determine the kernel from the first code block
-    let kernel_name = map_language_to_kernel(&blocks[0].language);
+    // Determine the kernel from the first code block, or else the first
+    // inline cell
+    let language = match blocks.first() {
+        Some(block) => block.language.clone(),
+        None => match find_inline_cells(input)
+            .into_iter()
+            .find(|cell| is_executable_language(&cell.language))
+        {
+            Some(cell) => cell.language,
+            // No executable code - passthrough
+            None => return Ok(ExecuteResult::new(input)),
+        },
+    };
+    let kernel_name = map_language_to_kernel(&language);
 
     // Execute via async runtime
-    let result = execute_blocks_async(input, &blocks, &kernel_name, &ctx.cwd);
+    let result = execute_blocks_async(input, &blocks, &kernel_name, ctx);
 
     result.map_err(|e| ExecutionError::execution_failed("jupyter", e.to_string()))
 }
@@ -85,10 +260,10 @@ fn map_language_to_kernel(language: &str) -> String {
 fn parse_code_blocks(input: &str) -> Vec<CodeBlock> {
     // Match ```{language} ... ``` blocks
     // The pattern captures:
-    // - Opening fence with {language} specifier
+    // - Opening fence with {language} specifier and its attributes
     // - Code content
-    // - Closing fence
-    let pattern = r"(?m)^```\s*\{(\w+)(?:[^}]*)?\}\s*\n([\s\S]*?)^```\s*$";
+    // - Closing fence (up to, not including, its newline)
+    let pattern = r"(?m)^```[ \t]*\{(\w+)([^}]*)\}[ \t]*\n([\s\S]*?)^```[ \t]*$";
     let re = Regex::new(pattern).expect("Invalid regex pattern");
 
     let mut blocks = Vec::new();
@@ -96,7 +271,8 @@ fn parse_code_blocks(input: &str) -> Vec<CodeBlock> {
     for cap in re.captures_iter(input) {
         let full_match = cap.get(0).unwrap();
         let language = cap.get(1).unwrap().as_str().to_string();
-        let code = cap.get(2).unwrap().as_str().to_string();
+        let attrs = cap.get(2).unwrap().as_str().trim().to_string();
+        let code = cap.get(3).unwrap().as_str().to_string();
 
         // Only include executable languages (not plain code blocks)
         if is_executable_language(&language) {
@@ -104,8 +280,8 @@ fn parse_code_blocks(input: &str) -> Vec<CodeBlock> {
                 start: full_match.start(),
                 end: full_match.end(),
                 language,
+                attrs,
                 code,
-                original: full_match.as_str().to_string(),
             });
         }
     }
@@ -114,7 +290,7 @@ fn parse_code_blocks(input: &str) -> Vec<CodeBlock> {
 }
 
 /// Check if a language specifier indicates executable code.
-fn is_executable_language(language: &str) -> bool {
+pub(super) fn is_executable_language(language: &str) -> bool {
     matches!(
         language.to_lowercase().as_str(),
         "python"
@@ -134,12 +310,100 @@ fn is_executable_language(language: &str) -> bool {
     )
 }
 
+/// The settings of the `execute` key of the front matter.
+fn document_options(input: &str) -> DocumentOptions {
+    let mut options = DocumentOptions::default();
+    let Some(rest) = input.strip_prefix("---\n") else {
+        return options;
+    };
+    let Some(end) = rest.find("\n---") else {
+        return options;
+    };
+    let front_matter = serde_yaml::from_str::<serde_yaml::Value>(&rest[..end]);
+    if let Ok(front_matter) = front_matter
+        && let Some(execute) = front_matter.get("execute").and_then(|v| v.as_mapping())
+    {
+        options.cells.apply_map(execute);
+        options.cache = execute.get("cache").and_then(|v| v.as_bool()) == Some(true);
+        options.dependencies = execute
+            .get("dependencies")
+            .and_then(|v| v.as_sequence())
+            .map(|paths| {
+                paths
+                    .iter()
+                    .filter_map(|path| path.as_str().map(str::to_string))
+                    .collect()
+            })
+            .unwrap_or_default();
+    }
+    options
+}
+
+/// Split the `#|` option lines off the top of a cell.
+///
+/// Returns the options and the code without them.
+fn split_cell_options(code: &str) -> (serde_yaml::Mapping, String) {
+    let mut yaml = String::new();
+    let mut rest = code;
+    while let Some(line) = rest.lines().next() {
+        let Some(option) = line.strip_prefix("#|").or_else(|| line.strip_prefix("//|")) else {
+            break;
+        };
+        yaml.push_str(option.strip_prefix(' ').unwrap_or(option));
+        yaml.push('\n');
+        rest = rest[line.len()..]
+            .strip_prefix("\r\n")
+            .or_else(|| rest[line.len()..].strip_prefix('\n'))
+            .unwrap_or("");
+    }
+    let options = match serde_yaml::from_str::<serde_yaml::Value>(&yaml) {
+        Ok(serde_yaml::Value::Mapping(map)) => map,
+        _ => serde_yaml::Mapping::new(),
+    };
+    (options, rest.to_string())
+}
+
+/// Parse `key=value` fence attributes. Values may be quoted; ids, classes
+/// and other words are skipped.
+fn parse_fence_attrs(attrs: &str) -> Vec<(String, String)> {
+    let mut pairs = Vec::new();
+    let mut chars = attrs.chars().peekable();
+    loop {
+        while chars.next_if(|c| c.is_whitespace() || *c == ',').is_some() {}
+        if chars.peek().is_none() {
+            break;
+        }
+        let mut key = String::new();
+        while let Some(c) = chars.next_if(|c| !c.is_whitespace() && *c != '=' && *c != ',') {
+            key.push(c);
+        }
+        if chars.next_if_eq(&'=').is_none() {
+            continue;
+        }
+        let mut value = String::new();
+        if let Some(quote) = chars.next_if(|c| *c == '"' || *c == '\'') {
+            for c in chars.by_ref() {
+                if c == quote {
+                    break;
+                }
+                value.push(c);
+            }
+        } else {
+            while let Some(c) = chars.next_if(|c| !c.is_whitespace() && *c != ',') {
+                value.push(c);
+            }
+        }
+        pairs.push((key, value));
+    }
+    pairs
+}
+
 /// Execute code blocks asynchronously and build output markdown.
 fn execute_blocks_async(
     input: &str,
     blocks: &[CodeBlock],
     kernel_name: &str,
-    working_dir: &PathBuf,
+    ctx: &ExecutionContext,
 ) -> JupyterResult<ExecuteResult> {
     // Use tokio runtime to execute async code
     let rt = tokio::runtime::Builder::new_current_thread()
@@ -147,12 +411,7 @@ fn execute_blocks_async(
         .build()
         .map_err(|e| JupyterError::RuntimeLibError(e.to_string()))?;
 
-    rt.block_on(execute_blocks_inner(
-        input,
-        blocks,
-        kernel_name,
-        working_dir,
-    ))
+    rt.block_on(execute_blocks_inner(input, blocks, kernel_name, ctx))
 }
 
 /// Inner async function that does the actual execution.
@@ -160,95 +419,319 @@ async fn execute_blocks_inner(
     input: &str,
     blocks: &[CodeBlock],
     kernel_name: &str,
-    working_dir: &PathBuf,
+    ctx: &ExecutionContext,
 ) -> JupyterResult<ExecuteResult> {
-    let daemon = daemon();
+    let document = document_options(input);
+    let mut figures = FigureDir::for_document(&ctx.source_path, &ctx.format);
 
-    // Start or get existing kernel session
-    let key: SessionKey = daemon
-        .get_or_start_session(kernel_name, working_dir)
-        .await?;
+    // Resolve the options of each cell
+    let cells: Vec<(CellOptions, String)> = blocks
+        .iter()
+        .map(|block| {
+            let (cell_options, code) = split_cell_options(&block.code);
+            let mut options = document.cells;
+            options.apply_attrs(&block.attrs);
+            options.apply_map(&cell_options);
+            (options, code)
+        })
+        .collect();
 
-    // Build output by processing blocks in order
-    let mut output = String::new();
-    let mut last_end = 0;
+    // Key the cells for the cache
+    let document_dir = ctx.source_path.parent().unwrap_or(Path::new(""));
+    let root = ctx.project_dir.as_deref().unwrap_or(document_dir);
+    let freeze_path = FreezeFile::path(root, &ctx.source_path, &ctx.format);
+    let dependencies = dependency_digest(document_dir, &document.dependencies);
+    let mut cell_keys = CellKeys::new(kernel_name, &ctx.format, &dependencies);
+    let keys: Vec<String> = blocks
+        .iter()
+        .zip(&cells)
+        .map(|(block, (options, code))| cell_keys.next(&block.language, code, &options.cache_key()))
+        .collect();
 
-    for block in blocks {
-        // Append content before this block
-        output.push_str(&input[last_end..block.start]);
+    // The inline cells run on this kernel
+    let inline_cells: Vec<InlineCellSource> = if document.cells.eval {
+        find_inline_cells(input)
+            .into_iter()
+            .filter(|cell| {
+                is_executable_language(&cell.language)
+                    && map_language_to_kernel(&cell.language) == kernel_name
+            })
+            .collect()
+    } else {
+        Vec::new()
+    };
 
-        // Keep the original code block
-        output.push_str(&block.original);
-        output.push('\n');
+    let caching = ctx.cache.enabled(document.cache);
+    if caching
+        && ctx.cache != CacheMode::Refresh
+        && inline_cells.is_empty()
+        && let Some(freeze) = FreezeFile::read(&freeze_path)
+        && let Some(frozen) = freeze.lookup(&keys)
+    {
+        let markdown: Vec<&str> = frozen.iter().map(|cell| cell.markdown.as_str()).collect();
+        let mut result = ExecuteResult::new(splice_cells(input, blocks, &markdown));
+        if frozen.iter().any(|cell| !cell.files.is_empty()) {
+            result = result.with_supporting_files(vec![figures.dir]);
+        }
+        return Ok(result);
+    }
 
+    let daemon = daemon();
+
+    // The kernel is started by the first cell that is evaluated
+    let mut session: Option<SessionKey> = None;
+
+    let mut frozen = Vec::with_capacity(blocks.len());
+    for (index, (block, (options, code))) in blocks.iter().zip(&cells).enumerate() {
         // Execute the code
+        let exec_result = if options.eval {
+            let key = match &session {
+                Some(key) => key.clone(),
+                None => {
+                    let key = daemon.get_or_start_session(kernel_name, &ctx.cwd).await?;
+                    session.insert(key).clone()
+                }
+            };
+            Some(
+                daemon
+                    .execute_in_session(&key, code)
+                    .await
+                    .ok_or(JupyterError::NotConnected)??,
+            )
+        } else {
+            None
+        };
+
+        let written_before = figures.written.len();
+        let markdown = if options.include {
+            let cell = Cell {
+                number: index + 1,
+                language: &block.language,
+                code,
+                options: *options,
+            };
+            format_cell(&cell, exec_result.as_ref(), &ctx.format, &mut figures)?
+        } else {
+            String::new()
+        };
+
+        frozen.push(FrozenCell {
+            key: keys[index].clone(),
+            markdown,
+            files: figures.written[written_before..].to_vec(),
+        });
+    }
+
+    let mut outputs = Vec::with_capacity(inline_cells.len());
+    for cell in &inline_cells {
+        let key = match &session {
+            Some(key) => key.clone(),
+            None => {
+                let key = daemon.get_or_start_session(kernel_name, &ctx.cwd).await?;
+                session.insert(key).clone()
+            }
+        };
         let exec_result = daemon
-            .execute_in_session(&key, &block.code)
+            .execute_in_session(&key, &cell.code)
             .await
-            .ok_or_else(|| JupyterError::NotConnected)??;
+            .ok_or(JupyterError::NotConnected)??;
+        outputs.push((cell, format_inline_output(&exec_result)));
+    }
 
-        // Format and append outputs
-        let output_md = format_outputs(&exec_result);
-        if !output_md.is_empty() {
-            output.push_str(&output_md);
-        }
+    let markdown: Vec<&str> = frozen.iter().map(|cell| cell.markdown.as_str()).collect();
+    let mut result = ExecuteResult::new(if outputs.is_empty() {
+        splice_cells(input, blocks, &markdown)
+    } else {
+        splice_inline_outputs(input, blocks, &markdown, &outputs)
+    });
+    if !figures.written.is_empty() {
+        result = result.with_supporting_files(vec![figures.dir]);
+    }
 
-        last_end = block.end;
+    if caching {
+        let freeze = FreezeFile {
+            engine: "jupyter".to_string(),
+            format: ctx.format.clone(),
+            cells: frozen,
+        };
+        freeze.write(&freeze_path)?;
     }
+    Ok(result)
+}
 
+/// Replace each block of the input with its formatted cell.
+fn splice_cells(input: &str, blocks: &[CodeBlock], cells: &[&str]) -> String {
+    let mut output = String::with_capacity(input.len());
+    let mut last_end = 0;
+    for (block, cell) in blocks.iter().zip(cells) {
+        // Append content before this block
+        output.push_str(&input[last_end..block.start]);
+        output.push_str(cell);
+        last_end = block.end;
+    }
     // Append any remaining content after the last block
     output.push_str(&input[last_end..]);
+    output
+}
+
+/// Replace each block of the input with its formatted cell and each
+/// evaluated inline cell with its output.
+fn splice_inline_outputs(
+    input: &str,
+    blocks: &[CodeBlock],
+    cells: &[&str],
+    outputs: &[(&InlineCellSource, String)],
+) -> String {
+    let mut edits: Vec<(usize, usize, String)> = blocks
+        .iter()
+        .zip(cells)
+        .map(|(block, cell)| (block.start, block.end, cell.to_string()))
+        .collect();
+    edits.extend(
+        outputs
+            .iter()
+            .map(|(cell, output)| (cell.start, cell.end, output_span(cell, output))),
+    );
+    edits.sort_by_key(|(start, _, _)| *start);
+
+    let mut output = String::with_capacity(input.len());
+    let mut last_end = 0;
+    for (start, end, text) in edits {
+        output.push_str(&input[last_end..start]);
+        output.push_str(&text);
+        last_end = end;
+    }
+    output.push_str(&input[last_end..]);
+    output
+}
+
+/// The markdown of the result of an inline cell: its value, or what it
+/// printed, or its error.
+fn format_inline_output(result: &KernelExecuteResult) -> String {
+    if let ExecuteStatus::Error { ename, evalue, .. } = &result.status {
+        return escape_markdown(&format!("{}: {}", ename, evalue));
+    }
+    let value = result.outputs.iter().find_map(|output| match output {
+        CellOutput::ExecuteResult { data, .. } => Some(data),
+        _ => None,
+    });
+    if let Some(data) = value {
+        if let Some(markdown) = data.get("text/markdown") {
+            return extract_text_content(markdown).trim().to_string();
+        }
+        if let Some(text) = data.get("text/plain") {
+            // The repr of a string has quotes
+            let text = extract_text_content(text);
+            let text = text.trim();
+            let unquoted = ['\'', '"']
+                .iter()
+                .find_map(|q| text.strip_prefix(*q).and_then(|t| t.strip_suffix(*q)))
+                .unwrap_or(text);
+            return escape_markdown(unquoted);
+        }
+    }
+    let printed: String = result
+        .outputs
+        .iter()
+        .filter_map(|output| match output {
+            CellOutput::Stream { name, text } if name == "stdout" => Some(text.as_str()),
+            _ => None,
+        })
+        .collect();
+    escape_markdown(&printed)
+}
+
+/// A cell to format.
+struct Cell<'a> {
+    /// The 1-based number of the cell, for naming its figures.
+    number: usize,
+    language: &'a str,
+    /// The code without its option lines.
+    code: &'a str,
+    options: CellOptions,
+}
+
+/// Format a cell and its outputs as a `.cell` div.
+fn format_cell(
+    cell: &Cell,
+    result: Option<&KernelExecuteResult>,
+    format: &str,
+    figures: &mut FigureDir,
+) -> JupyterResult<String> {
+    let mut output = String::from("::: {.cell}\n");
+
+    if cell.options.echo {
+        output.push_str(&fenced(
+            &format!("{{.{} .cell-code}}", cell.language),
+            cell.code.trim_end_matches('\n'),
+        ));
+    }
 
-    Ok(ExecuteResult::new(output))
+    if let Some(result) = result
+        && cell.options.output != OutputMode::Hide
+    {
+        output.push_str(&format_outputs(
+            result,
+            cell.number,
+            cell.options.output,
+            format,
+            figures,
+        )?);
+    }
+
+    output.push_str("\n:::");
+    Ok(output)
+}
+
+/// A fenced block with enough backticks for its content, preceded by a
+/// blank line.
+fn fenced(info: &str, content: &str) -> String {
+    let mut longest = 0;
+    let mut run = 0;
+    for c in content.chars() {
+        run = if c == '`' { run + 1 } else { 0 };
+        longest = longest.max(run);
+    }
+    let fence = "`".repeat((longest + 1).max(3));
+    format!("\n{}{}\n{}\n{}\n", fence, info, content, fence)
 }
 
 /// Format kernel outputs as markdown.
-fn format_outputs(result: &KernelExecuteResult) -> String {
+///
+/// `cell` numbers the figure files, `format` picks the representation of
+/// rich outputs.
+fn format_outputs(
+    result: &KernelExecuteResult,
+    cell: usize,
+    mode: OutputMode,
+    format: &str,
+    figures: &mut FigureDir,
+) -> JupyterResult<String> {
     let mut output = String::new();
 
-    for cell_output in &result.outputs {
+    for (index, cell_output) in result.outputs.iter().enumerate() {
         match cell_output {
             CellOutput::Stream { name, text } => {
-                // Stream output as a code block with output class
-                output.push_str(&format!(
-                    "\n```{{.cell-output-{}}}\n{}\n```\n",
-                    name,
-                    text.trim_end()
-                ));
+                if mode == OutputMode::Asis {
+                    output.push_str(&format!("\n{}\n", text.trim_end()));
+                } else {
+                    // Stream output as a code block with output class
+                    output.push_str(&fenced(
+                        &format!("{{.cell-output-{}}}", name),
+                        text.trim_end(),
+                    ));
+                }
             }
             CellOutput::ExecuteResult { data, .. } | CellOutput::DisplayData { data, .. } => {
-                // Rich output - pick best format
-                if let Some(text) = data.get("text/plain") {
-                    if let Some(s) = text.as_str() {
-                        output.push_str(&format!("\n```{{.cell-output}}\n{}\n```\n", s.trim_end()));
-                    }
-                } else if let Some(html) = data.get("text/html") {
-                    if let Some(s) = html.as_str() {
-                        output.push_str(&format!(
-                            "\n::: {{.cell-output-display}}\n```{{=html}}\n{}\n```\n:::\n",
-                            s
-                        ));
-                    }
-                } else if data.contains_key("image/png") || data.contains_key("image/svg+xml") {
-                    // TODO: Save image to file and reference it
-                    output.push_str("\n::: {.cell-output-display}\n[Image output]\n:::\n");
-                }
+                let name = format!("cell-{}-output-{}", cell, index + 1);
+                output.push_str(&format_rich_output(data, &name, mode, format, figures)?);
             }
             CellOutput::Error {
                 ename,
                 evalue,
                 traceback,
             } => {
-                // Error output
-                let mut error_text = format!("{}: {}\n", ename, evalue);
-                for line in traceback {
-                    error_text.push_str(&strip_ansi_codes(line));
-                    error_text.push('\n');
-                }
-                output.push_str(&format!(
-                    "\n```{{.cell-output-error}}\n{}\n```\n",
-                    error_text.trim_end()
-                ));
+                output.push_str(&format_error(ename, evalue, traceback));
             }
         }
     }
@@ -262,19 +745,87 @@ fn format_outputs(result: &KernelExecuteResult) -> String {
     {
         // Only add if not already in outputs
         if result.outputs.is_empty() {
-            let mut error_text = format!("{}: {}\n", ename, evalue);
-            for line in traceback {
-                error_text.push_str(&strip_ansi_codes(line));
-                error_text.push('\n');
-            }
-            output.push_str(&format!(
-                "\n```{{.cell-output-error}}\n{}\n```\n",
-                error_text.trim_end()
-            ));
+            output.push_str(&format_error(ename, evalue, traceback));
         }
     }
 
-    output
+    Ok(output)
+}
+
+/// Format a MIME bundle using the first representation the format prefers.
+///
+/// Figures are written as `name` with the extension of their type.
+fn format_rich_output(
+    data: &MimeBundle,
+    name: &str,
+    mode: OutputMode,
+    format: &str,
+    figures: &mut FigureDir,
+) -> JupyterResult<String> {
+    let Some((mime, value)) = mime_priority_for_format(format)
+        .iter()
+        .find_map(|mime| data.get(*mime).map(|value| (*mime, value)))
+    else {
+        return Ok(String::new());
+    };
+
+    let markdown = match mime {
+        "image/png" | "image/jpeg" | "application/pdf" | "image/pdf" => {
+            let ext = match mime {
+                "image/png" => "png",
+                "image/jpeg" => "jpg",
+                _ => "pdf",
+            };
+            let encoded: String = extract_text_content(value)
+                .chars()
+                .filter(|c| !c.is_whitespace())
+                .collect();
+            let bytes = base64::engine::general_purpose::STANDARD
+                .decode(encoded)
+                .map_err(|e| {
+                    JupyterError::IoError(std::io::Error::new(
+                        std::io::ErrorKind::InvalidData,
+                        format!("{} output is not valid base64: {}", mime, e),
+                    ))
+                })?;
+            let href = figures.write(&format!("{}.{}", name, ext), &bytes)?;
+            format!("![]({})", href)
+        }
+        "image/svg+xml" => {
+            let svg = extract_text_content(value);
+            let href = figures.write(&format!("{}.svg", name), svg.as_bytes())?;
+            format!("![]({})", href)
+        }
+        "text/html" => fenced("{=html}", extract_text_content(value).trim_end()),
+        "text/latex" => fenced("{=latex}", extract_text_content(value).trim_end()),
+        "text/markdown" => extract_text_content(value).trim_end().to_string(),
+        _ => {
+            let text = extract_text_content(value);
+            if mode == OutputMode::Asis {
+                text.trim_end().to_string()
+            } else {
+                return Ok(fenced("{.cell-output}", text.trim_end()));
+            }
+        }
+    };
+
+    Ok(match mode {
+        OutputMode::Asis => format!("\n{}\n", markdown.trim_matches('\n')),
+        _ => format!(
+            "\n::: {{.cell-output-display}}\n{}\n:::\n",
+            markdown.trim_matches('\n')
+        ),
+    })
+}
+
+/// Format an error and its traceback as a `.cell-output-error` block.
+fn format_error(ename: &str, evalue: &str, traceback: &[String]) -> String {
+    let mut error_text = format!("{}: {}\n", ename, evalue);
+    for line in traceback {
+        error_text.push_str(&strip_ansi_codes(line));
+        error_text.push('\n');
+    }
+    fenced("{.cell-output-error}", error_text.trim_end())
 }
 
 #[cfg(test)]
@@ -381,24 +932,36 @@ print("hello")
         assert!(!is_executable_language("markdown"));
     }
 
-    #[test]
-    fn test_format_outputs_stream() {
-        let result = KernelExecuteResult {
+    fn test_figures() -> (tempfile::TempDir, FigureDir) {
+        let temp = tempfile::tempdir().unwrap();
+        let figures = FigureDir::for_document(&temp.path().join("doc.qmd"), "html");
+        (temp, figures)
+    }
+
+    fn stream_result(text: &str) -> KernelExecuteResult {
+        KernelExecuteResult {
             status: ExecuteStatus::Ok,
             outputs: vec![CellOutput::Stream {
                 name: "stdout".to_string(),
-                text: "Hello, World!\n".to_string(),
+                text: text.to_string(),
             }],
             execution_count: Some(1),
-        };
+        }
+    }
 
-        let output = format_outputs(&result);
+    #[test]
+    fn test_format_outputs_stream() {
+        let (_temp, mut figures) = test_figures();
+        let result = stream_result("Hello, World!\n");
+
+        let output = format_outputs(&result, 1, OutputMode::Show, "html", &mut figures).unwrap();
         assert!(output.contains("cell-output-stdout"));
         assert!(output.contains("Hello, World!"));
     }
 
     #[test]
     fn test_format_outputs_error() {
+        let (_temp, mut figures) = test_figures();
         let result = KernelExecuteResult {
             status: ExecuteStatus::Error {
                 ename: "NameError".to_string(),
@@ -413,8 +976,256 @@ print("hello")
             execution_count: Some(1),
         };
 
-        let output = format_outputs(&result);
+        let output = format_outputs(&result, 1, OutputMode::Show, "html", &mut figures).unwrap();
         assert!(output.contains("cell-output-error"));
         assert!(output.contains("NameError"));
     }
+
+    #[test]
+    fn test_format_outputs_prefers_format_representation() {
+        let (_temp, mut figures) = test_figures();
+        let mut data = MimeBundle::new();
+        data.insert("text/plain".to_string(), serde_json::json!("   a\n0  1"));
+        data.insert(
+            "text/html".to_string(),
+            serde_json::json!("<table></table>"),
+        );
+        let result = KernelExecuteResult {
+            status: ExecuteStatus::Ok,
+            outputs: vec![CellOutput::ExecuteResult {
+                execution_count: 1,
+                data,
+                metadata: Default::default(),
+            }],
+            execution_count: Some(1),
+        };
+
+        let html = format_outputs(&result, 1, OutputMode::Show, "html", &mut figures).unwrap();
+        assert!(html.contains("::: {.cell-output-display}\n```{=html}\n<table></table>"));
+
+        let latex = format_outputs(&result, 1, OutputMode::Show, "latex", &mut figures).unwrap();
+        assert!(latex.contains("```{.cell-output}\n   a\n0  1\n```"));
+    }
+
+    #[test]
+    fn test_format_outputs_writes_images() {
+        let (temp, mut figures) = test_figures();
+        let mut data = MimeBundle::new();
+        // "PNG" in base64, wrapped the way kernels wrap it
+        data.insert("image/png".to_string(), serde_json::json!("UE5H\n"));
+        data.insert("text/plain".to_string(), serde_json::json!("<Figure>"));
+        let result = KernelExecuteResult {
+            status: ExecuteStatus::Ok,
+            outputs: vec![CellOutput::DisplayData {
+                data,
+                metadata: Default::default(),
+            }],
+            execution_count: Some(1),
+        };
+
+        let output = format_outputs(&result, 3, OutputMode::Show, "html", &mut figures).unwrap();
+        assert!(output.contains("![](doc_files/figure-html/cell-3-output-1.png)"));
+        let written = temp
+            .path()
+            .join("doc_files/figure-html/cell-3-output-1.png");
+        assert_eq!(std::fs::read(&written).unwrap(), b"PNG");
+        assert_eq!(figures.written, vec![written]);
+    }
+
+    #[test]
+    fn test_split_cell_options() {
+        let (options, code) = split_cell_options("#| echo: false\n#| output: asis\nx = 1\n");
+        assert_eq!(code, "x = 1\n");
+        let mut cell = CellOptions::default();
+        cell.apply_map(&options);
+        assert!(!cell.echo);
+        assert_eq!(cell.output, OutputMode::Asis);
+
+        let (options, code) = split_cell_options("//| eval: false\nconsole.log(1)\n");
+        assert_eq!(code, "console.log(1)\n");
+        assert_eq!(options.len(), 1);
+
+        // Only leading lines are options
+        let (options, code) = split_cell_options("x = 1\n#| echo: false\n");
+        assert!(options.is_empty());
+        assert_eq!(code, "x = 1\n#| echo: false\n");
+    }
+
+    #[test]
+    fn test_parse_fence_attrs() {
+        assert_eq!(
+            parse_fence_attrs(r#"#id .class echo=false, eval="true" label='a b'"#),
+            vec![
+                ("echo".to_string(), "false".to_string()),
+                ("eval".to_string(), "true".to_string()),
+                ("label".to_string(), "a b".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_option_precedence() {
+        let input = "---\ntitle: T\nexecute:\n  echo: false\n  eval: false\n---\n\n\
+                     ```{python eval=true}\n#| echo: true\nx = 1\n```\n";
+        let defaults = document_options(input).cells;
+        assert!(!defaults.echo);
+        assert!(!defaults.eval);
+
+        let blocks = parse_code_blocks(input);
+        let (cell_options, code) = split_cell_options(&blocks[0].code);
+        let mut options = defaults;
+        options.apply_attrs(&blocks[0].attrs);
+        options.apply_map(&cell_options);
+        assert!(options.eval);
+        assert!(options.echo);
+        assert_eq!(code, "x = 1\n");
+    }
+
+    #[test]
+    fn test_format_cell() {
+        let (_temp, mut figures) = test_figures();
+        let result = stream_result("1\n");
+        let mut cell = Cell {
+            number: 1,
+            language: "python",
+            code: "print(1)\n",
+            options: CellOptions::default(),
+        };
+
+        let shown = format_cell(&cell, Some(&result), "html", &mut figures).unwrap();
+        assert_eq!(
+            shown,
+            "::: {.cell}\n\n```{.python .cell-code}\nprint(1)\n```\n\n\
+             ```{.cell-output-stdout}\n1\n```\n\n:::"
+        );
+
+        cell.options.echo = false;
+        let no_echo = format_cell(&cell, Some(&result), "html", &mut figures).unwrap();
+        assert!(!no_echo.contains("print(1)"));
+        assert!(no_echo.contains("cell-output-stdout"));
+
+        cell.options.echo = true;
+        cell.options.output = OutputMode::Hide;
+        let no_output = format_cell(&cell, Some(&result), "html", &mut figures).unwrap();
+        assert!(no_output.contains("print(1)"));
+        assert!(!no_output.contains("cell-output"));
+
+        cell.options.output = OutputMode::Asis;
+        let asis = format_cell(&cell, Some(&result), "html", &mut figures).unwrap();
+        assert!(asis.ends_with("```\n\n1\n\n:::"));
+
+        // Unevaluated cells show their code only
+        cell.options.output = OutputMode::Show;
+        let unevaluated = format_cell(&cell, None, "html", &mut figures).unwrap();
+        assert!(!unevaluated.contains("cell-output"));
+    }
+
+    #[test]
+    fn test_fenced_lengthens_fence() {
+        assert_eq!(fenced("{.x}", "a ``` b"), "\n````{.x}\na ``` b\n````\n");
+    }
+
+    #[test]
+    fn test_document_cache_options() {
+        let input = "---\nexecute:\n  cache: true\n  dependencies: [data.csv]\n---\n";
+        let document = document_options(input);
+        assert!(document.cache);
+        assert_eq!(document.dependencies, vec!["data.csv".to_string()]);
+        assert!(!document_options("# No front matter\n").cache);
+    }
+
+    #[test]
+    fn test_splice_cells() {
+        let input = "A\n\n```{python}\nx\n```\n\nB\n\n```{python}\ny\n```\n";
+        let blocks = parse_code_blocks(input);
+        assert_eq!(
+            splice_cells(input, &blocks, &["[x]", "[y]"]),
+            "A\n\n[x]\n\nB\n\n[y]\n"
+        );
+    }
+
+    #[test]
+    fn test_splice_inline_outputs() {
+        let input = "A `{python} x`\n\n```{python}\nx = 1\n```\n\nB `{python} y`\n";
+        let blocks = parse_code_blocks(input);
+        let inline_cells = find_inline_cells(input);
+        assert_eq!(inline_cells.len(), 2);
+        let outputs = vec![
+            (&inline_cells[0], "1".to_string()),
+            (&inline_cells[1], "2".to_string()),
+        ];
+        assert_eq!(
+            splice_inline_outputs(input, &blocks, &["[x]"], &outputs),
+            "A [1]{.quarto-inline-cell-output language=\"python\" code=\"x\"}\n\n[x]\n\n\
+             B [2]{.quarto-inline-cell-output language=\"python\" code=\"y\"}\n"
+        );
+    }
+
+    #[test]
+    fn test_format_inline_output() {
+        let value = |mime: &str, text: &str| KernelExecuteResult {
+            status: ExecuteStatus::Ok,
+            outputs: vec![CellOutput::ExecuteResult {
+                execution_count: 1,
+                data: MimeBundle::from([(mime.to_string(), serde_json::json!(text))]),
+                metadata: serde_json::json!({}),
+            }],
+            execution_count: Some(1),
+        };
+        assert_eq!(format_inline_output(&value("text/plain", "42")), "42");
+        assert_eq!(format_inline_output(&value("text/plain", "'a*b'")), "a\\*b");
+        assert_eq!(
+            format_inline_output(&value("text/markdown", "**bold**")),
+            "**bold**"
+        );
+        assert_eq!(format_inline_output(&stream_result("printed\n")), "printed");
+
+        let error = KernelExecuteResult {
+            status: ExecuteStatus::Error {
+                ename: "NameError".to_string(),
+                evalue: "name 'z' is not defined".to_string(),
+                traceback: vec![],
+            },
+            outputs: vec![],
+            execution_count: Some(1),
+        };
+        assert_eq!(
+            format_inline_output(&error),
+            "NameError: name \\'z\\' is not defined"
+        );
+    }
+
+    #[test]
+    fn test_cached_render_skips_kernel() {
+        let temp = tempfile::tempdir().unwrap();
+        let source = temp.path().join("doc.qmd");
+        let input = "---\nexecute:\n  cache: true\n---\n\n```{python}\nprint(1)\n```\n";
+        let ctx = ExecutionContext::new(
+            temp.path().to_path_buf(),
+            temp.path().to_path_buf(),
+            source.clone(),
+            "html",
+        );
+
+        // Store the cell the way an earlier render would have
+        let blocks = parse_code_blocks(input);
+        let (_, code) = split_cell_options(&blocks[0].code);
+        let mut keys = CellKeys::new("python3", "html", &dependency_digest(temp.path(), &[]));
+        let freeze = FreezeFile {
+            engine: "jupyter".to_string(),
+            format: "html".to_string(),
+            cells: vec![FrozenCell {
+                key: keys.next("python", &code, &CellOptions::default().cache_key()),
+                markdown: "::: {.cell}\ncached\n:::".to_string(),
+                files: vec![],
+            }],
+        };
+        freeze
+            .write(&FreezeFile::path(temp.path(), &source, "html"))
+            .unwrap();
+
+        // No kernel runs here, so only a cache hit can succeed
+        let result = execute_blocks_async(input, &blocks, "python3", &ctx).unwrap();
+        assert!(result.markdown.ends_with("\n\n::: {.cell}\ncached\n:::\n"));
+    }
 }
@@ -15,6 +15,7 @@ use std::sync::Arc;
 
 use quarto_pandoc_types::block::Block;
 use quarto_pandoc_types::inline::{Inline, Str};
+use quarto_pandoc_types::inline_cell::InlineCell;
 use quarto_pandoc_types::pandoc::Pandoc;
 use quarto_source_map::SourceInfo;
 
@@ -153,7 +154,8 @@ impl JupyterTransform {
 
     /// Extract inline expressions from the AST.
     ///
-    /// Looks for Code inlines like `{python} 1+1` or `{r} x` in Paragraph blocks.
+    /// Looks for inline cells like `{python} 1+1` or `{r} x`, and Code
+    /// inlines written that way, in Paragraph blocks.
     fn extract_inline_expressions(ast: &Pandoc) -> Vec<InlineExpr> {
         let mut exprs = Vec::new();
 
@@ -161,18 +163,22 @@ impl JupyterTransform {
             // Only look in paragraphs for now
             if let Block::Paragraph(para) = block {
                 for (inline_idx, inline) in para.content.iter().enumerate() {
-                    if let Inline::Code(code) = inline {
+                    let expr = match inline {
+                        Inline::Custom(node) => InlineCell::from_custom(node)
+                            .map(|cell| (cell.language.to_lowercase(), cell.code)),
                         // Check if it starts with {language}
-                        if let Some(expr) = Self::parse_inline_expression(&code.text) {
-                            if is_jupyter_language(&expr.0) {
-                                exprs.push(InlineExpr {
-                                    block_idx,
-                                    inline_idx,
-                                    language: expr.0,
-                                    code: expr.1,
-                                });
-                            }
-                        }
+                        Inline::Code(code) => Self::parse_inline_expression(&code.text),
+                        _ => None,
+                    };
+                    if let Some((language, code)) = expr
+                        && is_jupyter_language(&language)
+                    {
+                        exprs.push(InlineExpr {
+                            block_idx,
+                            inline_idx,
+                            language,
+                            code,
+                        });
                     }
                 }
             }
@@ -272,12 +278,21 @@ impl JupyterTransform {
 
         for (block_idx, inline_idx, result) in replacements {
             if let Some(Block::Paragraph(para)) = ast.blocks.get_mut(block_idx) {
-                if inline_idx < para.content.len() {
+                let result = Inline::Str(Str {
+                    text: result,
+                    source_info: SourceInfo::default(),
+                });
+                match para.content.get_mut(inline_idx) {
+                    // An inline cell keeps its code, with the result as output
+                    Some(Inline::Custom(node)) => {
+                        if let Some(mut cell) = InlineCell::from_custom(node) {
+                            cell.output = Some(vec![result]);
+                            *node = cell.to_custom(node.attr.clone(), node.source_info.clone());
+                        }
+                    }
                     // Replace the Code inline with a Str inline
-                    para.content[inline_idx] = Inline::Str(Str {
-                        text: result,
-                        source_info: SourceInfo::default(),
-                    });
+                    Some(inline) => *inline = result,
+                    None => {}
                 }
             }
         }
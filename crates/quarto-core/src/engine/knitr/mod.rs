@@ -21,10 +21,11 @@
 //! time and extracted to a temp directory on first use. Access via
 //! [`KNITR_RESOURCES`].
 //!
-//! # Current Status
+//! # Chunk Options
 //!
-//! The R resource extraction is implemented. Full knitr execution
-//! will be implemented in subsequent phases.
+//! Chunk options reach knitr as `#|` comments. Options the pipeline wrote
+//! as fence attributes are moved there first (see
+//! [`fence_attributes_to_chunk_options`]).
 
 #![cfg(not(target_arch = "wasm32"))]
 
@@ -47,7 +48,9 @@ use crate::resources::ResourceBundle;
 #[allow(unused_imports)]
 pub use error_parser::{RErrorInfo, RErrorType, parse_r_error};
 pub use format::KnitrFormatConfig;
-pub use preprocess::resolve_inline_r_expressions;
+pub use preprocess::{
+    fence_attributes_to_chunk_options, resolve_inline_cells, resolve_inline_r_expressions,
+};
 pub use subprocess::{CallROptions, call_r, determine_working_dir, find_rscript};
 pub use types::{KnitrExecuteParams, KnitrExecuteResult, KnitrIncludes};
 
@@ -88,6 +91,7 @@ pub static KNITR_RESOURCES: ResourceBundle = ResourceBundle::new("knitr", &KNITR
 // KnitrEngine
 // ============================================================================
 
+use super::cache::CacheMode;
 use super::context::{ExecuteResult, ExecutionContext};
 use super::error::ExecutionError;
 use super::traits::ExecutionEngine;
@@ -157,6 +161,10 @@ impl ExecutionEngine for KnitrEngine {
         } else {
             input.to_string()
         };
+        // Inline cells after inline R, which would wrap them again
+        let preprocessed = resolve_inline_cells(&preprocessed);
+        // knitr reads chunk options from `#|` comments, not Pandoc attributes
+        let preprocessed = fence_attributes_to_chunk_options(&preprocessed);
 
         // Step 3: Build format configuration
         let format_config = build_format_config(ctx);
@@ -220,6 +228,10 @@ impl ExecutionEngine for KnitrEngine {
         true
     }
 
+    fn claims_language(&self, language: &str) -> bool {
+        language == "r"
+    }
+
     fn is_available(&self) -> bool {
         self.rscript_path.is_some()
     }
@@ -249,8 +261,17 @@ impl ExecutionEngine for KnitrEngine {
 /// Build format configuration from execution context.
 ///
 /// Creates a [`KnitrFormatConfig`] with settings appropriate for the target format.
+///
+/// knitr keeps its own chunk cache, so `--cache` and `--cache-refresh` are
+/// passed on as `execute.cache`.
 fn build_format_config(ctx: &ExecutionContext) -> KnitrFormatConfig {
-    KnitrFormatConfig::with_defaults(&ctx.format)
+    let mut config = KnitrFormatConfig::with_defaults(&ctx.format);
+    config.execute.cache = match ctx.cache {
+        CacheMode::Document => None,
+        CacheMode::Always => Some(serde_json::Value::Bool(true)),
+        CacheMode::Refresh => Some(serde_json::Value::String("refresh".to_string())),
+    };
+    config
 }
 
 /// Post-process knitr markdown output.
@@ -373,6 +394,13 @@ mod tests {
         assert!(msg.contains("Rscript"));
     }
 
+    #[test]
+    fn test_knitr_engine_claims_r() {
+        let engine = KnitrEngine::new();
+        assert!(engine.claims_language("r"));
+        assert!(!engine.claims_language("python"));
+    }
+
     #[test]
     fn test_knitr_engine_default() {
         let engine = KnitrEngine::default();
@@ -495,6 +523,23 @@ mod tests {
         assert_eq!(config.pandoc.to, Some("pdf".to_string()));
     }
 
+    #[test]
+    fn test_build_format_config_cache() {
+        let ctx = ExecutionContext::new(
+            PathBuf::from("/tmp"),
+            PathBuf::from("/project"),
+            PathBuf::from("/project/doc.qmd"),
+            "html",
+        );
+        assert_eq!(build_format_config(&ctx).execute.cache, None);
+
+        let ctx = ctx.with_cache(CacheMode::Refresh);
+        assert_eq!(
+            build_format_config(&ctx).execute.cache,
+            Some(serde_json::json!("refresh"))
+        );
+    }
+
     #[test]
     fn test_postprocess_markdown_fixes_rmarkdown_refs() {
         let markdown = "See [source](test.rmarkdown) for details.\nFile: test.rmarkdown";
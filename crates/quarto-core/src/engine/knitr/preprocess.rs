@@ -20,8 +20,30 @@
 //! Before: The answer is `r 1+1`.
 //! After:  The answer is `r .QuartoInlineRender(1+1)`.
 //! ```
+//!
+//! Inline cells (`` `{r} 1+1` ``) are turned into inline R expressions
+//! the same way, inside a span that keeps the code of the cell; see
+//! [`resolve_inline_cells`].
+//!
+//! # Chunk Options
+//!
+//! The pipeline writes cells with Pandoc attribute syntax, which knitr
+//! can't read: `{r #fig-plot echo="FALSE"}`. The attributes are moved into
+//! `#|` option comments, which knitr reads as YAML:
+//!
+//! ```text
+//! Before: ```{r #fig-plot echo="FALSE"}
+//! After:  ```{r}
+//!         #| label: "fig-plot"
+//!         #| echo: false
+//! ```
+//!
+//! knitr-style headers (`{r setup, include=FALSE}`) are mapped the same
+//! way. Options already given as `#|` comments in the chunk win.
 
+use crate::engine::inline_cells::{InlineCellSource, find_inline_cells, splice_outputs};
 use regex::Regex;
+use std::collections::HashSet;
 use std::sync::LazyLock;
 
 /// Regex pattern for inline R code: `r expression`
@@ -99,6 +121,215 @@ pub fn has_inline_r_expressions(markdown: &str) -> bool {
     INLINE_R_PATTERN.is_match(markdown)
 }
 
+/// Prepare inline cells (`` `{r} expr` ``) for knitr.
+///
+/// Each R cell becomes an inline R expression, wrapped with
+/// `.QuartoInlineRender()` like the ones above and put in the span that
+/// marks an evaluated cell (see [`crate::engine::inline_cells`]). knitr
+/// replaces the expression with its result and the span keeps the code of
+/// the cell. Cells whose code has backticks can't be inline R expressions
+/// and are left alone.
+///
+/// ```ignore
+/// let output = resolve_inline_cells("The answer is `{r} 1+1`.");
+/// assert_eq!(
+///     output,
+///     "The answer is [`r .QuartoInlineRender(1+1)`]{.quarto-inline-cell-output language=\"r\" code=\"1+1\"}."
+/// );
+/// ```
+pub fn resolve_inline_cells(markdown: &str) -> String {
+    let cells = find_inline_cells(markdown);
+    let outputs: Vec<(&InlineCellSource, String)> = cells
+        .iter()
+        .filter(|cell| cell.language.eq_ignore_ascii_case("r") && !cell.code.contains('`'))
+        .map(|cell| (cell, format!("`r .QuartoInlineRender({})`", cell.code)))
+        .collect();
+    if outputs.is_empty() {
+        return markdown.to_string();
+    }
+    splice_outputs(markdown, &outputs)
+}
+
+/// Regex for a chunk header with attributes: ```` ```{r #id key="value"} ````
+///
+/// Captures the indentation, the fence, the language and the attributes.
+static CHUNK_HEADER_PATTERN: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"^([ \t]*)(`{3,})[ \t]*\{([A-Za-z][\w+-]*)[ \t,]+([^}]*)\}[ \t]*\r?\n?$")
+        .expect("Invalid regex pattern for chunk headers")
+});
+
+/// The `#|` comment prefix for a chunk language, or `None` for languages
+/// whose chunks are left alone.
+fn option_comment_prefix(language: &str) -> Option<&'static str> {
+    match language.to_lowercase().as_str() {
+        "r" | "python" | "bash" | "sh" | "julia" | "stan" => Some("#|"),
+        "sql" => Some("--|"),
+        "cpp" | "rcpp" | "c" | "js" | "javascript" => Some("//|"),
+        _ => None,
+    }
+}
+
+/// Move the attributes of chunk headers into `#|` option comments.
+///
+/// - `#id` (or a leading bare word, as in knitr headers) becomes `label`
+/// - `.class` becomes `classes`
+/// - `key=value` becomes `key: value`; see [`chunk_option_value`]
+///
+/// Options the chunk already sets with `#|` comments are not repeated.
+/// Chunks without attributes, and chunks in languages without a known
+/// comment syntax, are unchanged.
+pub fn fence_attributes_to_chunk_options(markdown: &str) -> String {
+    let lines: Vec<&str> = markdown.split_inclusive('\n').collect();
+    let mut output = String::with_capacity(markdown.len());
+
+    for (index, line) in lines.iter().enumerate() {
+        let Some(caps) = CHUNK_HEADER_PATTERN.captures(line) else {
+            output.push_str(line);
+            continue;
+        };
+        let Some(prefix) = option_comment_prefix(&caps[3]) else {
+            output.push_str(line);
+            continue;
+        };
+        let indent = &caps[1];
+
+        // Keys the chunk already sets in its own option comments
+        let existing: HashSet<&str> = lines[index + 1..]
+            .iter()
+            .map_while(|line| line.trim_start().strip_prefix(prefix))
+            .filter_map(|option| option.split(':').next())
+            .map(str::trim)
+            .collect();
+
+        output.push_str(&format!("{}{}{{{}}}\n", indent, &caps[2], &caps[3]));
+        for (key, value) in chunk_options(&caps[4]) {
+            if !existing.contains(key.as_str()) {
+                output.push_str(&format!("{}{} {}: {}\n", indent, prefix, key, value));
+            }
+        }
+    }
+
+    output
+}
+
+/// Parse chunk attributes into option keys and YAML values.
+fn chunk_options(attrs: &str) -> Vec<(String, String)> {
+    let mut label = None;
+    let mut classes = Vec::new();
+    let mut options = Vec::new();
+
+    for (index, token) in split_attributes(attrs).into_iter().enumerate() {
+        match token {
+            Attribute::Word(word) => {
+                if let Some(id) = word.strip_prefix('#') {
+                    label = Some(id.to_string());
+                } else if let Some(class) = word.strip_prefix('.') {
+                    classes.push(class.to_string());
+                } else if index == 0 {
+                    label = Some(word);
+                }
+            }
+            Attribute::Pair { key, value, quoted } => {
+                options.push((key, chunk_option_value(&value, quoted)));
+            }
+        }
+    }
+
+    let mut result = Vec::new();
+    if let Some(label) = label {
+        result.push(("label".to_string(), yaml_string(&label)));
+    }
+    if !classes.is_empty() {
+        result.push(("classes".to_string(), yaml_string(&classes.join(" "))));
+    }
+    result.extend(options);
+    result
+}
+
+/// The YAML for an option value.
+///
+/// R literals (`TRUE`, `FALSE`, `NULL`, numbers) become YAML literals, in
+/// or out of quotes, since the pipeline quotes every attribute value. Other
+/// quoted values are strings. Other unquoted values are R expressions, as
+/// in knitr headers, and become `!expr`.
+fn chunk_option_value(value: &str, quoted: bool) -> String {
+    match value {
+        "TRUE" | "T" | "true" => "true".to_string(),
+        "FALSE" | "F" | "false" => "false".to_string(),
+        "NULL" | "null" => "null".to_string(),
+        _ if value.starts_with(|c: char| c.is_ascii_digit() || c == '-' || c == '.')
+            && value.parse::<f64>().is_ok() =>
+        {
+            value.to_string()
+        }
+        _ if quoted => yaml_string(value),
+        _ => format!("!expr {}", yaml_string(value)),
+    }
+}
+
+/// A double-quoted YAML string (JSON strings are YAML).
+fn yaml_string(value: &str) -> String {
+    serde_json::to_string(value).unwrap_or_else(|_| format!("\"{}\"", value))
+}
+
+/// A token of a chunk header.
+#[derive(Debug, PartialEq)]
+enum Attribute {
+    /// `#id`, `.class` or a bare word
+    Word(String),
+    /// `key=value` or `key="value"`
+    Pair {
+        key: String,
+        value: String,
+        quoted: bool,
+    },
+}
+
+/// Split chunk attributes at whitespace and commas outside quotes.
+fn split_attributes(attrs: &str) -> Vec<Attribute> {
+    let mut tokens = Vec::new();
+    let mut chars = attrs.chars().peekable();
+    loop {
+        while chars.next_if(|c| c.is_whitespace() || *c == ',').is_some() {}
+        if chars.peek().is_none() {
+            break;
+        }
+        let mut key = String::new();
+        while let Some(c) = chars.next_if(|c| !c.is_whitespace() && *c != ',' && *c != '=') {
+            key.push(c);
+        }
+        if chars.next_if_eq(&'=').is_none() {
+            tokens.push(Attribute::Word(key));
+            continue;
+        }
+        let mut value = String::new();
+        let quoted = if let Some(quote) = chars.next_if(|c| *c == '"' || *c == '\'') {
+            while let Some(c) = chars.next() {
+                match c {
+                    '\\' => value.extend(chars.next()),
+                    c if c == quote => break,
+                    c => value.push(c),
+                }
+            }
+            true
+        } else {
+            // Unquoted R expressions can hold commas inside parentheses
+            let mut depth = 0usize;
+            while let Some(c) = chars.next_if(|c| depth > 0 || (!c.is_whitespace() && *c != ',')) {
+                match c {
+                    '(' | '[' => depth += 1,
+                    ')' | ']' => depth = depth.saturating_sub(1),
+                    _ => {}
+                }
+                value.push(c);
+            }
+            false
+        };
+        tokens.push(Attribute::Pair { key, value, quoted });
+    }
+    tokens
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -216,6 +447,31 @@ mod tests {
         assert_eq!(output, input);
     }
 
+    // === resolve_inline_cells tests ===
+
+    #[test]
+    fn test_resolve_inline_cells() {
+        let output = resolve_inline_cells("The answer is `{r} 1+1` and `{python} x`.");
+        assert_eq!(
+            output,
+            "The answer is [`r .QuartoInlineRender(1+1)`]{.quarto-inline-cell-output language=\"r\" code=\"1+1\"} and `{python} x`."
+        );
+    }
+
+    #[test]
+    fn test_resolve_inline_cells_leaves_code_alone() {
+        let input = "```{r}\n`{r} x`\n```\n\nAnd ``{r} `x` ``.\n";
+        assert_eq!(resolve_inline_cells(input), input);
+    }
+
+    #[test]
+    fn test_resolve_inline_cells_after_inline_r() {
+        // Inline R expressions are not wrapped twice
+        let input = "`r x` and `{r} y`";
+        let output = resolve_inline_cells(&resolve_inline_r_expressions(input));
+        assert!(output.starts_with("`r .QuartoInlineRender(x)` and [`r .QuartoInlineRender(y)`]"));
+    }
+
     // === has_inline_r_expressions tests ===
 
     #[test]
@@ -233,4 +489,58 @@ mod tests {
     fn test_has_inline_r_empty_string() {
         assert!(!has_inline_r_expressions(""));
     }
+
+    // === fence_attributes_to_chunk_options tests ===
+
+    #[test]
+    fn test_pandoc_attributes_become_options() {
+        let input = "Text\n\n```{r #fig-plot .wide echo=\"FALSE\" fig.cap=\"A \\\"plot\\\"\"}\nplot(1)\n```\n";
+        let output = fence_attributes_to_chunk_options(input);
+        assert_eq!(
+            output,
+            "Text\n\n```{r}\n#| label: \"fig-plot\"\n#| classes: \"wide\"\n#| echo: false\n\
+             #| fig.cap: \"A \\\"plot\\\"\"\nplot(1)\n```\n"
+        );
+    }
+
+    #[test]
+    fn test_knitr_headers_become_options() {
+        let input = "```{r setup, include=FALSE, fig.width=c(1, 2)}\nlibrary(x)\n```\n";
+        let output = fence_attributes_to_chunk_options(input);
+        assert_eq!(
+            output,
+            "```{r}\n#| label: \"setup\"\n#| include: false\n#| fig.width: !expr \"c(1, 2)\"\n\
+             library(x)\n```\n"
+        );
+    }
+
+    #[test]
+    fn test_existing_option_comments_win() {
+        let input = "```{r echo=\"TRUE\" eval=\"FALSE\"}\n#| echo: false\nx\n```\n";
+        let output = fence_attributes_to_chunk_options(input);
+        assert_eq!(output, "```{r}\n#| eval: false\n#| echo: false\nx\n```\n");
+    }
+
+    #[test]
+    fn test_chunks_without_attributes_unchanged() {
+        let input = "```{r}\nx\n```\n\n```{ojs echo=\"false\"}\nx\n```\n\n```{.r}\nx\n```\n";
+        assert_eq!(fence_attributes_to_chunk_options(input), input);
+    }
+
+    #[test]
+    fn test_other_comment_syntaxes() {
+        let output =
+            fence_attributes_to_chunk_options("```{sql connection=\"con\"}\nSELECT 1\n```\n");
+        assert!(output.starts_with("```{sql}\n--| connection: \"con\"\n"));
+    }
+
+    #[test]
+    fn test_chunk_option_value() {
+        assert_eq!(chunk_option_value("FALSE", true), "false");
+        assert_eq!(chunk_option_value("T", false), "true");
+        assert_eq!(chunk_option_value("NULL", false), "null");
+        assert_eq!(chunk_option_value("7.5", true), "7.5");
+        assert_eq!(chunk_option_value("asis", true), "\"asis\"");
+        assert_eq!(chunk_option_value("7 * 2", false), "!expr \"7 * 2\"");
+    }
 }
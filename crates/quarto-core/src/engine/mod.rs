@@ -53,9 +53,13 @@
 //! let result = engine.execute(&qmd_content, &context)?;
 //! ```
 
+mod cache;
 mod context;
 mod detection;
 mod error;
+// Only the native engines evaluate inline cells
+#[cfg_attr(target_arch = "wasm32", allow(dead_code))]
+mod inline_cells;
 mod markdown;
 mod registry;
 mod traits;
@@ -67,9 +71,13 @@ pub mod jupyter;
 mod knitr;
 
 // Re-export public types
+pub use cache::{CacheMode, CellKeys, FREEZE_DIR, FreezeFile, FrozenCell, dependency_digest};
 pub use context::{ExecuteResult, ExecutionContext};
-pub use detection::{DetectedEngine, KNOWN_ENGINES, detect_engine, is_known_engine};
+pub use detection::{
+    DetectedEngine, KNOWN_ENGINES, detect_engine, detect_engine_for_document, is_known_engine,
+};
 pub use error::ExecutionError;
+pub use inline_cells::restore_inline_cells;
 pub use markdown::MarkdownEngine;
 pub use registry::EngineRegistry;
 pub use traits::ExecutionEngine;
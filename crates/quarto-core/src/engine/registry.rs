@@ -39,6 +39,8 @@ use super::knitr::KnitrEngine;
 #[derive(Debug)]
 pub struct EngineRegistry {
     engines: HashMap<String, Arc<dyn ExecutionEngine>>,
+    /// Engine names in registration order, for [`Self::engine_for_languages`]
+    order: Vec<String>,
 }
 
 impl EngineRegistry {
@@ -49,9 +51,7 @@ impl EngineRegistry {
     /// - knitr: Native builds only
     /// - jupyter: Native builds only
     pub fn new() -> Self {
-        let mut registry = Self {
-            engines: HashMap::new(),
-        };
+        let mut registry = Self::empty();
 
         // Always register markdown engine
         registry.register(Arc::new(MarkdownEngine::new()));
@@ -70,14 +70,20 @@ impl EngineRegistry {
     pub fn empty() -> Self {
         Self {
             engines: HashMap::new(),
+            order: Vec::new(),
         }
     }
 
     /// Register an engine.
     ///
-    /// If an engine with the same name already exists, it is replaced.
+    /// If an engine with the same name already exists, it is replaced and
+    /// keeps its place in the registration order.
     pub fn register(&mut self, engine: Arc<dyn ExecutionEngine>) {
-        self.engines.insert(engine.name().to_string(), engine);
+        let name = engine.name().to_string();
+        if !self.engines.contains_key(&name) {
+            self.order.push(name.clone());
+        }
+        self.engines.insert(name, engine);
     }
 
     /// Get an engine by name.
@@ -100,9 +106,27 @@ impl EngineRegistry {
             .expect("markdown engine should always be registered")
     }
 
-    /// List all registered engine names.
+    /// List all registered engine names, in registration order.
     pub fn engine_names(&self) -> Vec<&str> {
-        self.engines.keys().map(|s| s.as_str()).collect()
+        self.order.iter().map(|s| s.as_str()).collect()
+    }
+
+    /// Get the engine for a document's cell languages.
+    ///
+    /// Returns the first engine, in registration order, that claims one of
+    /// the languages (see [`ExecutionEngine::claims_language`]). The
+    /// default registry registers knitr before jupyter, so a document with
+    /// both `{r}` and `{python}` cells runs on knitr, as in Quarto.
+    pub fn engine_for_languages(&self, languages: &[String]) -> Option<Arc<dyn ExecutionEngine>> {
+        self.order
+            .iter()
+            .filter_map(|name| self.engines.get(name))
+            .find(|engine| {
+                languages
+                    .iter()
+                    .any(|language| engine.claims_language(language))
+            })
+            .cloned()
     }
 
     /// Check if an engine is registered.
@@ -161,6 +185,7 @@ impl std::fmt::Debug for dyn ExecutionEngine {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::engine::{ExecuteResult, ExecutionContext, ExecutionError};
 
     #[test]
     fn test_registry_new_has_markdown() {
@@ -258,6 +283,64 @@ mod tests {
         assert!(registry.has_engine("jupyter"));
     }
 
+    /// An engine for `{ojs}` cells, as a plugin would register
+    struct OjsEngine;
+
+    impl ExecutionEngine for OjsEngine {
+        fn name(&self) -> &str {
+            "ojs"
+        }
+
+        fn execute(
+            &self,
+            input: &str,
+            _ctx: &ExecutionContext,
+        ) -> Result<ExecuteResult, ExecutionError> {
+            Ok(ExecuteResult::passthrough(input))
+        }
+
+        fn claims_language(&self, language: &str) -> bool {
+            language == "ojs"
+        }
+    }
+
+    #[test]
+    fn test_registry_engine_for_languages() {
+        let mut registry = EngineRegistry::new();
+        registry.register(Arc::new(OjsEngine));
+
+        let languages = |names: &[&str]| names.iter().map(|s| s.to_string()).collect::<Vec<_>>();
+        let ojs = registry.engine_for_languages(&languages(&["ojs"]));
+        assert_eq!(ojs.unwrap().name(), "ojs");
+        assert!(
+            registry
+                .engine_for_languages(&languages(&["mermaid"]))
+                .is_none()
+        );
+        assert!(registry.engine_for_languages(&[]).is_none());
+    }
+
+    #[test]
+    #[cfg(not(target_arch = "wasm32"))]
+    fn test_registry_engine_for_languages_prefers_knitr() {
+        let registry = EngineRegistry::new();
+        let languages = vec!["python".to_string(), "r".to_string()];
+        let engine = registry.engine_for_languages(&languages).unwrap();
+        assert_eq!(engine.name(), "knitr");
+
+        let python = registry.engine_for_languages(&["python".to_string()]);
+        assert_eq!(python.unwrap().name(), "jupyter");
+    }
+
+    #[test]
+    fn test_registry_engine_names_in_order() {
+        let mut registry = EngineRegistry::empty();
+        registry.register(Arc::new(OjsEngine));
+        registry.register(Arc::new(MarkdownEngine::new()));
+        registry.register(Arc::new(OjsEngine));
+        assert_eq!(registry.engine_names(), vec!["ojs", "markdown"]);
+    }
+
     #[test]
     fn test_registry_register_replaces() {
         let mut registry = EngineRegistry::empty();
@@ -115,6 +115,20 @@ pub trait ExecutionEngine: Send + Sync {
         Vec::new()
     }
 
+    /// Whether this engine runs cells in `language` (lowercase, e.g. `"r"`).
+    ///
+    /// A document that declares no engine is executed by the first
+    /// registered engine that claims one of its cell languages (see
+    /// [`EngineRegistry::engine_for_languages`]). Engines that only run
+    /// when declared keep the default.
+    ///
+    /// Default: `false`
+    ///
+    /// [`EngineRegistry::engine_for_languages`]: super::EngineRegistry::engine_for_languages
+    fn claims_language(&self, _language: &str) -> bool {
+        false
+    }
+
     /// Check if this engine is available in the current environment.
     ///
     /// This checks whether the required runtime (R, Python, etc.)
@@ -197,6 +211,15 @@ mod tests {
         assert!(!unavailable.is_available());
     }
 
+    #[test]
+    fn test_engine_trait_default_claims_no_language() {
+        let engine = TestEngine {
+            name: "test",
+            available: true,
+        };
+        assert!(!engine.claims_language("python"));
+    }
+
     #[test]
     fn test_engine_is_send_sync() {
         fn assert_send_sync<T: Send + Sync>() {}
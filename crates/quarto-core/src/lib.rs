@@ -43,7 +43,9 @@ pub mod format;
 pub mod pipeline;
 pub mod project;
 pub mod render;
+pub mod resource_manifest;
 pub mod resources;
+pub mod site;
 pub mod stage;
 pub mod template;
 pub mod transform;
@@ -60,6 +62,8 @@ pub use pipeline::{
 };
 pub use project::{DocumentInfo, ProjectConfig, ProjectContext, ProjectType};
 pub use render::{BinaryDependencies, RenderContext, RenderOptions, RenderResult};
+pub use resource_manifest::{Resource, ResourceManifest};
+pub use site::{SiteAssembly, SiteManifest, assemble_site};
 pub use transform::{AstTransform, TransformPipeline};
 pub use transforms::{
     CalloutResolveTransform, CalloutTransform, MetadataNormalizeTransform,
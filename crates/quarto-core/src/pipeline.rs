@@ -16,8 +16,9 @@
 //! 1. **Parse**: QMD source → Pandoc AST (via `pampa`)
 //! 2. **Engine execution**: Execute code cells (Jupyter, Knitr, or markdown passthrough)
 //! 3. **Transform**: Apply Quarto-specific transforms (callouts, metadata, etc.)
-//! 4. **Render body**: Pandoc AST → HTML body (via `pampa`)
-//! 5. **Apply template**: Wrap body with HTML template
+//! 4. **Extract resources**: Copy local images and files into the output
+//! 5. **Render body**: Pandoc AST → HTML body (via `pampa`)
+//! 6. **Apply template**: Wrap body with HTML template
 //!
 //! ## Usage
 //!
@@ -54,14 +55,16 @@ use crate::Result;
 use crate::render::RenderContext;
 use crate::stage::stages::ApplyTemplateConfig;
 use crate::stage::{
-    ApplyTemplateStage, AstTransformsStage, EngineExecutionStage, LoadedSource, ParseDocumentStage,
-    Pipeline, PipelineData, PipelineStage, RenderHtmlBodyStage, StageContext,
+    ApplyTemplateStage, AstTransformsStage, EngineExecutionStage, ExtractResourcesStage,
+    LoadedSource, ParseDocumentStage, Pipeline, PipelineData, PipelineStage, RenderHtmlBodyStage,
+    StageContext, TraceObserver,
 };
 use crate::transform::TransformPipeline;
 use crate::transforms::{
-    AppendixStructureTransform, CalloutResolveTransform, CalloutTransform, FootnotesTransform,
-    MetadataNormalizeTransform, ResourceCollectorTransform, SectionizeTransform,
-    ShortcodeResolveTransform, TitleBlockTransform, TocGenerateTransform, TocRenderTransform,
+    AppendixStructureTransform, CalloutResolveTransform, CalloutTransform, ColumnsTransform,
+    FootnotesTransform, MetadataNormalizeTransform, OjsResolveTransform, OjsTransform,
+    SectionizeTransform, ShortcodeResolveTransform, TabsetResolveTransform, TabsetTransform,
+    TitleBlockTransform, TocGenerateTransform, TocRenderTransform,
 };
 
 /// Well-known path for the default CSS artifact in WASM context.
@@ -79,6 +82,10 @@ pub struct HtmlRenderConfig<'a> {
 
     /// Custom template to use. If `None`, the built-in HTML5 template is used.
     pub template: Option<&'a Template>,
+
+    /// Record a trace of the render with this observer
+    /// (`quarto render --trace`).
+    pub trace: Option<Arc<TraceObserver>>,
 }
 
 impl<'a> HtmlRenderConfig<'a> {
@@ -87,6 +94,7 @@ impl<'a> HtmlRenderConfig<'a> {
         Self {
             css_paths,
             template: None,
+            trace: None,
         }
     }
 
@@ -95,6 +103,7 @@ impl<'a> HtmlRenderConfig<'a> {
         Self {
             css_paths: &[],
             template: Some(template),
+            trace: None,
         }
     }
 }
@@ -119,13 +128,15 @@ pub struct RenderOutput {
 /// 1. `ParseDocumentStage` - Parse QMD to Pandoc AST
 /// 2. `EngineExecutionStage` - Execute code cells (jupyter, knitr, or markdown passthrough)
 /// 3. `AstTransformsStage` - Run Quarto transforms (callouts, metadata, etc.)
-/// 4. `RenderHtmlBodyStage` - Render AST to HTML body
-/// 5. `ApplyTemplateStage` - Apply HTML template
+/// 4. `ExtractResourcesStage` - Copy local resources and rewrite their URLs
+/// 5. `RenderHtmlBodyStage` - Render AST to HTML body
+/// 6. `ApplyTemplateStage` - Apply HTML template
 pub fn build_html_pipeline_stages() -> Vec<Box<dyn PipelineStage>> {
     vec![
         Box::new(ParseDocumentStage::new()),
         Box::new(EngineExecutionStage::new()),
         Box::new(AstTransformsStage::new()),
+        Box::new(ExtractResourcesStage::new()),
         Box::new(RenderHtmlBodyStage::new()),
         Box::new(ApplyTemplateStage::new()),
     ]
@@ -137,8 +148,9 @@ pub fn build_html_pipeline_stages() -> Vec<Box<dyn PipelineStage>> {
 /// 1. `ParseDocumentStage` - Parse QMD to Pandoc AST
 /// 2. `EngineExecutionStage` - Execute code cells (jupyter, knitr, or markdown passthrough)
 /// 3. `AstTransformsStage` - Run Quarto transforms (callouts, metadata, etc.)
-/// 4. `RenderHtmlBodyStage` - Render AST to HTML body
-/// 5. `ApplyTemplateStage` - Apply HTML template
+/// 4. `ExtractResourcesStage` - Copy local resources and rewrite their URLs
+/// 5. `RenderHtmlBodyStage` - Render AST to HTML body
+/// 6. `ApplyTemplateStage` - Apply HTML template
 ///
 /// # Returns
 ///
@@ -162,8 +174,9 @@ pub fn build_html_pipeline() -> Pipeline {
 /// Stages:
 /// 1. `ParseDocumentStage` - Parse QMD to Pandoc AST
 /// 2. `AstTransformsStage` - Run Quarto transforms (callouts, metadata, TOC, etc.)
-/// 3. `RenderHtmlBodyStage` - Render AST to HTML body
-/// 4. `ApplyTemplateStage` - Apply HTML template
+/// 3. `ExtractResourcesStage` - Copy local resources and rewrite their URLs
+/// 4. `RenderHtmlBodyStage` - Render AST to HTML body
+/// 5. `ApplyTemplateStage` - Apply HTML template
 ///
 /// # Returns
 ///
@@ -178,6 +191,7 @@ pub fn build_wasm_html_pipeline() -> Pipeline {
         Box::new(ParseDocumentStage::new()),
         // No EngineExecutionStage - code cells pass through as-is
         Box::new(AstTransformsStage::new()),
+        Box::new(ExtractResourcesStage::new()),
         Box::new(RenderHtmlBodyStage::new()),
         Box::new(ApplyTemplateStage::new()),
     ];
@@ -224,8 +238,10 @@ pub fn build_html_pipeline_with_stages(
 /// This is the unified async render pipeline used by both CLI and WASM. It:
 /// 1. Parses the QMD content to a Pandoc AST
 /// 2. Runs the transform pipeline (callouts, metadata normalization, etc.)
-/// 3. Renders the AST to HTML body
-/// 4. Applies the HTML template
+/// 3. Copies local resources, leaving them in `ctx.artifacts` as
+///    `resource:` artifacts with a manifest (see [`crate::resource_manifest`])
+/// 4. Renders the AST to HTML body
+/// 5. Applies the HTML template
 ///
 /// # Arguments
 ///
@@ -270,7 +286,13 @@ pub async fn render_qmd_to_html(
         ctx.project.clone(),
         ctx.document.clone(),
     )
-    .map_err(|e| crate::error::QuartoError::Other(e.to_string()))?;
+    .map_err(|e| crate::error::QuartoError::Other(e.to_string()))?
+    .with_execute(ctx.options.execute)
+    .with_cache(ctx.options.cache)
+    .with_sanitize(ctx.options.sanitize.clone());
+    if let Some(trace) = &config.trace {
+        stage_ctx = stage_ctx.with_observer(trace.clone());
+    }
 
     // Transfer artifacts from RenderContext to StageContext
     stage_ctx.artifacts = std::mem::take(&mut ctx.artifacts);
@@ -292,6 +314,7 @@ pub async fn render_qmd_to_html(
             Box::new(ParseDocumentStage::new()),
             Box::new(EngineExecutionStage::new()),
             Box::new(AstTransformsStage::new()),
+            Box::new(ExtractResourcesStage::new()),
             Box::new(RenderHtmlBodyStage::new()),
             Box::new(ApplyTemplateStage::with_config(apply_config)),
         ];
@@ -348,25 +371,39 @@ pub async fn render_qmd_to_html(
 /// ## Normalization Phase
 /// 1. `CalloutTransform` - Convert callout Divs to CustomNodes
 /// 2. `CalloutResolveTransform` - Resolve CustomNodes to structured Divs
-/// 3. `ShortcodeResolveTransform` - Resolve shortcodes (e.g., `{{< meta title >}}`)
-/// 4. `MetadataNormalizeTransform` - Add derived metadata (pagetitle, etc.)
-/// 5. `TitleBlockTransform` - Add title header from metadata if not present
-/// 6. `SectionizeTransform` - Wrap headers in section Divs (for HTML semantic structure)
-/// 7. `FootnotesTransform` - Extract footnotes and create footnotes section
+/// 3. `TabsetTransform` - Convert tabset Divs to CustomNodes
+/// 4. `TabsetResolveTransform` - Resolve CustomNodes to tab markup
+/// 5. `OjsTransform` - Convert `{ojs}` cells to CustomNodes
+/// 6. `OjsResolveTransform` - Resolve CustomNodes to output containers and the OJS manifest
+/// 7. `ShortcodeResolveTransform` - Resolve shortcodes (e.g., `{{< meta title >}}`)
+/// 8. `MetadataNormalizeTransform` - Add derived metadata (pagetitle, etc.)
+/// 9. `TitleBlockTransform` - Add title header from metadata if not present
+/// 10. `SectionizeTransform` - Wrap headers in section Divs (for HTML semantic structure)
+/// 11. `FootnotesTransform` - Extract footnotes and create footnotes section
 ///
 /// ## TOC Phase
-/// 8. `TocGenerateTransform` - Generate TOC from headers (if toc: true)
-/// 9. `TocRenderTransform` - Render TOC to HTML for template insertion
+/// 12. `TocGenerateTransform` - Generate TOC from headers (if toc: true)
+/// 13. `TocRenderTransform` - Render TOC to HTML for template insertion
 ///
 /// ## Finalization Phase
-/// 10. `AppendixStructureTransform` - Consolidate appendix content into container
-/// 11. `ResourceCollectorTransform` - Collect image dependencies
+/// 14. `AppendixStructureTransform` - Consolidate appendix content into container
+/// 15. `ColumnsTransform` - Put the Divs containing column content on the page grid
+///
+/// Local resources are copied by the `ExtractResourcesStage` that follows,
+/// which reads them;
+/// [`ResourceCollectorTransform`](crate::transforms::ResourceCollectorTransform)
+/// only lists their paths.
 pub fn build_transform_pipeline() -> TransformPipeline {
     let mut pipeline = TransformPipeline::new();
 
     // === NORMALIZATION PHASE ===
     pipeline.push(Box::new(CalloutTransform::new()));
     pipeline.push(Box::new(CalloutResolveTransform::new()));
+    // Tabs are split at headings, so this must run before SectionizeTransform
+    pipeline.push(Box::new(TabsetTransform::new()));
+    pipeline.push(Box::new(TabsetResolveTransform::new()));
+    pipeline.push(Box::new(OjsTransform::new()));
+    pipeline.push(Box::new(OjsResolveTransform::new()));
     pipeline.push(Box::new(ShortcodeResolveTransform::new()));
     pipeline.push(Box::new(MetadataNormalizeTransform::new()));
     pipeline.push(Box::new(TitleBlockTransform::new()));
@@ -380,7 +417,8 @@ pub fn build_transform_pipeline() -> TransformPipeline {
 
     // === FINALIZATION PHASE ===
     pipeline.push(Box::new(AppendixStructureTransform::new()));
-    pipeline.push(Box::new(ResourceCollectorTransform::new()));
+    // Must run last, once every Div that will be written is in place
+    pipeline.push(Box::new(ColumnsTransform::new()));
 
     pipeline
 }
@@ -454,6 +492,65 @@ mod tests {
         assert!(output.html.contains("Be careful!"));
     }
 
+    #[test]
+    fn test_render_with_tabset() {
+        let content = b"---\ntitle: Test\n---\n\n::: {.panel-tabset}\n## R\nR code\n\n## Python\nPython code\n:::";
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/test.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+
+        let config = HtmlRenderConfig::default();
+        let runtime = make_test_runtime();
+        let output = pollster::block_on(render_qmd_to_html(
+            content, "test.qmd", &mut ctx, &config, runtime,
+        ))
+        .unwrap();
+
+        // Verify the tabset headings became tabs, not sections
+        assert!(
+            output
+                .html
+                .contains("<ul class=\"nav nav-tabs\" role=\"tablist\">")
+        );
+        assert!(output.html.contains("id=\"tabset-1-2\""));
+        assert!(output.html.contains("Python code"));
+        assert!(!output.html.contains("<h2"));
+    }
+
+    #[test]
+    fn test_render_with_ojs_cell() {
+        let content = b"---\ntitle: Test\n---\n\n```{ojs}\n//| echo: false\nx = 1 + 1\n```\n";
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/test.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+
+        let config = HtmlRenderConfig::default();
+        let runtime = make_test_runtime();
+        let output = pollster::block_on(render_qmd_to_html(
+            content, "test.qmd", &mut ctx, &config, runtime,
+        ))
+        .unwrap();
+
+        // Verify the cell became an output container and a manifest entry
+        assert!(
+            output
+                .html
+                .contains("<div id=\"ojs-cell-1\" class=\"cell-output-display\">")
+        );
+        assert!(
+            output
+                .html
+                .contains("<script type=\"ojs-module-contents\">")
+        );
+        assert!(!output.html.contains("x = 1 + 1"));
+    }
+
     #[test]
     fn test_render_with_meta_shortcode() {
         let content = b"---\ntitle: My Document Title\nauthor: Jane Doe\n---\n\nThe title is {{< meta title >}} by {{< meta author >}}.";
@@ -613,25 +710,26 @@ mod tests {
     #[test]
     fn test_build_html_pipeline_stages() {
         let stages = build_html_pipeline_stages();
-        assert_eq!(stages.len(), 5);
+        assert_eq!(stages.len(), 6);
         assert_eq!(stages[0].name(), "parse-document");
         assert_eq!(stages[1].name(), "engine-execution");
         assert_eq!(stages[2].name(), "ast-transforms");
-        assert_eq!(stages[3].name(), "render-html-body");
-        assert_eq!(stages[4].name(), "apply-template");
+        assert_eq!(stages[3].name(), "extract-resources");
+        assert_eq!(stages[4].name(), "render-html-body");
+        assert_eq!(stages[5].name(), "apply-template");
     }
 
     #[test]
     fn test_build_html_pipeline() {
         let pipeline = build_html_pipeline();
-        assert_eq!(pipeline.len(), 5);
+        assert_eq!(pipeline.len(), 6);
     }
 
     #[test]
     fn test_build_wasm_html_pipeline() {
         let pipeline = build_wasm_html_pipeline();
-        // WASM pipeline has 4 stages (no engine execution)
-        assert_eq!(pipeline.len(), 4);
+        // WASM pipeline has 5 stages (no engine execution)
+        assert_eq!(pipeline.len(), 5);
     }
 
     #[test]
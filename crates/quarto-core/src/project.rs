@@ -16,14 +16,47 @@
 //! - Parsed configuration
 //! - List of input files
 //! - Output directory resolution
+//!
+//! # Configuration
+//!
+//! The configuration of a project is merged from layers, later layers
+//! winning (see `quarto_config` for the merge semantics):
+//!
+//! 1. `_quarto.yml`
+//! 2. `_quarto-<profile>.yml` for each active profile, in order. The
+//!    profiles are those in `QUARTO_PROFILE` (comma-separated), or else
+//!    those in `profile.default`.
+//! 3. The files listed in `metadata-files`, relative to the project root
+//!
+//! The configuration that applies to one document then has the
+//! `_metadata.yml` of each directory from the project root down to the
+//! document's layered over it (see [`ProjectContext::document_config`]).
+//! The render pipeline layers the document's front matter over that.
 
 use std::path::{Path, PathBuf};
 
+use pampa::pandoc::meta::yaml_to_config_value;
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use quarto_config::{ConfigValueKind, InterpretationContext, MergedConfig};
 use quarto_pandoc_types::ConfigValue;
+use quarto_source_map::SourceInfo;
 use quarto_system_runtime::SystemRuntime;
 
 use crate::error::{QuartoError, Result};
 
+/// Names of the project configuration file, in order of preference
+const PROJECT_CONFIG_FILES: [&str; 2] = ["_quarto.yml", "_quarto.yaml"];
+
+/// Names of the directory metadata file, in order of preference
+const DIRECTORY_METADATA_FILES: [&str; 2] = ["_metadata.yml", "_metadata.yaml"];
+
+/// Keys of the project configuration that configure the project itself
+/// rather than its documents
+const PROJECT_ONLY_KEYS: [&str; 3] = ["project", "profile", "metadata-files"];
+
+/// Environment variable that selects the active profiles
+const PROFILE_ENV_VAR: &str = "QUARTO_PROFILE";
+
 /// Project type enumeration
 #[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
 pub enum ProjectType {
@@ -76,7 +109,10 @@ pub struct ProjectConfig {
     /// Input file patterns (glob patterns)
     pub render_patterns: Vec<String>,
 
-    /// Raw configuration value for format-specific settings
+    /// Active profiles, in the order their configuration is layered
+    pub profiles: Vec<String>,
+
+    /// Raw configuration value of `_quarto.yml` itself
     pub raw: serde_json::Value,
 
     /// Format configuration for merging with document metadata.
@@ -84,6 +120,10 @@ pub struct ProjectConfig {
     /// This is used by the render pipeline to merge project-level format settings
     /// (like `format.html.source-location: full`) with document metadata.
     /// When present, values in document metadata override values here.
+    ///
+    /// For a project read from disk, this is the merged configuration
+    /// without the keys that only configure the project (`project`,
+    /// `profile` and `metadata-files`).
     pub format_config: Option<ConfigValue>,
 }
 
@@ -114,6 +154,10 @@ pub struct DocumentInfo {
 
     /// Document ID (for cross-references)
     pub id: Option<String>,
+
+    /// Project configuration that applies to this document, with directory
+    /// metadata merged in (see [`ProjectContext::document_config`])
+    pub config: Option<ConfigValue>,
 }
 
 impl DocumentInfo {
@@ -124,6 +168,7 @@ impl DocumentInfo {
             output: None,
             title: None,
             id: None,
+            config: None,
         }
     }
 
@@ -212,21 +257,23 @@ impl ProjectContext {
             .and_then(|c| c.output_dir.as_ref())
             .map_or_else(|| dir.clone(), |o| dir.join(o));
 
-        // Build file list
-        let files = if let Some(input) = input_file {
-            vec![DocumentInfo::from_path(input)]
-        } else {
-            // TODO: Discover files based on project configuration
-            Vec::new()
-        };
-
-        Ok(Self {
+        let mut context = Self {
             dir,
             config,
             is_single_file,
-            files,
+            files: Vec::new(),
             output_dir,
-        })
+        };
+
+        // Build file list
+        if let Some(input) = input_file {
+            let mut document = DocumentInfo::from_path(input);
+            document.config = context.document_config(&document.input, runtime)?;
+            context.files.push(document);
+        }
+        // TODO: Discover files based on project configuration
+
+        Ok(context)
     }
 
     /// Create a single-file project context directly
@@ -259,23 +306,9 @@ impl ProjectContext {
         let mut current = start_dir.to_path_buf();
 
         loop {
-            let config_path = current.join("_quarto.yml");
-            let exists = runtime
-                .path_exists(&config_path, None)
-                .map_err(|e| QuartoError::Other(format!("Failed to check config path: {}", e)))?;
-            if exists {
+            if let Some(config_path) = find_file(&current, &PROJECT_CONFIG_FILES, runtime)? {
                 // Found config file - parse it
-                let config = Self::parse_config(&config_path, runtime)?;
-                return Ok((Some(current), Some(config)));
-            }
-
-            // Also check for _quarto.yaml (alternate extension)
-            let config_path_yaml = current.join("_quarto.yaml");
-            let exists_yaml = runtime
-                .path_exists(&config_path_yaml, None)
-                .map_err(|e| QuartoError::Other(format!("Failed to check config path: {}", e)))?;
-            if exists_yaml {
-                let config = Self::parse_config(&config_path_yaml, runtime)?;
+                let config = Self::parse_config(&current, &config_path, runtime)?;
                 return Ok((Some(current), Some(config)));
             }
 
@@ -289,8 +322,13 @@ impl ProjectContext {
         }
     }
 
-    /// Parse a `_quarto.yml` file
-    fn parse_config(path: &Path, runtime: &dyn SystemRuntime) -> Result<ProjectConfig> {
+    /// Parse a `_quarto.yml` file, with the profile and metadata files
+    /// it brings in
+    fn parse_config(
+        project_dir: &Path,
+        path: &Path,
+        runtime: &dyn SystemRuntime,
+    ) -> Result<ProjectConfig> {
         let content = runtime
             .file_read_string(path)
             .map_err(|e| QuartoError::Other(format!("Failed to read config file: {}", e)))?;
@@ -299,26 +337,56 @@ impl ProjectContext {
         let value: serde_json::Value = serde_yaml::from_str(&content).map_err(|e| {
             QuartoError::Other(format!("Failed to parse {}: {}", path.display(), e))
         })?;
+        let base = parse_config_layer(&content, path)?;
 
-        // Extract project configuration
-        let project = value
-            .get("project")
-            .cloned()
-            .unwrap_or(serde_json::Value::Null);
+        let env_profiles = runtime.env_get(PROFILE_ENV_VAR).map_err(|e| {
+            QuartoError::Other(format!("Failed to read {}: {}", PROFILE_ENV_VAR, e))
+        })?;
+        let profiles = active_profiles(&base, env_profiles.as_deref());
+
+        let mut layers = vec![base];
+        for profile in &profiles {
+            let names = [
+                format!("_quarto-{}.yml", profile),
+                format!("_quarto-{}.yaml", profile),
+            ];
+            if let Some(profile_path) = find_file(project_dir, &names, runtime)? {
+                layers.push(read_config_layer(&profile_path, runtime)?);
+            }
+        }
+        let mut merged = merge_layers(&layers)?;
+
+        let metadata_files: Vec<String> = merged
+            .get("metadata-files")
+            .and_then(|v| v.as_array())
+            .map(|arr| {
+                arr.iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect()
+            })
+            .unwrap_or_default();
+        if !metadata_files.is_empty() {
+            let mut layers = vec![merged];
+            for file in &metadata_files {
+                layers.push(read_config_layer(&project_dir.join(file), runtime)?);
+            }
+            merged = merge_layers(&layers)?;
+        }
 
-        let project_type = project
-            .get("type")
+        // Extract project configuration
+        let project_type = merged
+            .get_path(&["project", "type"])
             .and_then(|v| v.as_str())
             .and_then(|s| ProjectType::try_from(s).ok())
             .unwrap_or_default();
 
-        let output_dir = project
-            .get("output-dir")
+        let output_dir = merged
+            .get_path(&["project", "output-dir"])
             .and_then(|v| v.as_str())
             .map(PathBuf::from);
 
-        let render_patterns = project
-            .get("render")
+        let render_patterns = merged
+            .get_path(&["project", "render"])
             .and_then(|v| v.as_array())
             .map(|arr| {
                 arr.iter()
@@ -331,11 +399,51 @@ impl ProjectContext {
             project_type,
             output_dir,
             render_patterns,
+            profiles,
             raw: value,
-            format_config: None, // TODO: Parse with quarto-config for full source tracking
+            format_config: Some(document_settings(merged)),
         })
     }
 
+    /// Resolve the configuration that applies to a document.
+    ///
+    /// This is the project's document settings with the `_metadata.yml` of
+    /// each directory from the project root down to the document's layered
+    /// over them, deeper directories winning. Returns `None` when there is
+    /// no project configuration.
+    pub fn document_config(
+        &self,
+        input: &Path,
+        runtime: &dyn SystemRuntime,
+    ) -> Result<Option<ConfigValue>> {
+        let Some(project_config) = self.config.as_ref().and_then(|c| c.format_config.as_ref())
+        else {
+            return Ok(None);
+        };
+
+        let mut layers = vec![project_config.clone()];
+        let relative = input
+            .parent()
+            .and_then(|dir| dir.strip_prefix(&self.dir).ok());
+        if let Some(relative) = relative {
+            let mut dir = self.dir.clone();
+            if let Some(path) = find_file(&dir, &DIRECTORY_METADATA_FILES, runtime)? {
+                layers.push(read_config_layer(&path, runtime)?);
+            }
+            for component in relative.components() {
+                dir.push(component);
+                if let Some(path) = find_file(&dir, &DIRECTORY_METADATA_FILES, runtime)? {
+                    layers.push(read_config_layer(&path, runtime)?);
+                }
+            }
+        }
+
+        if layers.len() == 1 {
+            return Ok(layers.pop());
+        }
+        merge_layers(&layers).map(Some)
+    }
+
     /// Get the project type
     pub fn project_type(&self) -> ProjectType {
         self.config
@@ -354,6 +462,115 @@ impl ProjectContext {
     }
 }
 
+/// The first of `names` that exists in `dir`
+fn find_file(
+    dir: &Path,
+    names: &[impl AsRef<str>],
+    runtime: &dyn SystemRuntime,
+) -> Result<Option<PathBuf>> {
+    for name in names {
+        let path = dir.join(name.as_ref());
+        let exists = runtime
+            .path_exists(&path, None)
+            .map_err(|e| QuartoError::Other(format!("Failed to check config path: {}", e)))?;
+        if exists {
+            return Ok(Some(path));
+        }
+    }
+    Ok(None)
+}
+
+/// The active profiles: those in `env` (the value of `QUARTO_PROFILE`),
+/// or else those in the configuration's `profile.default`
+fn active_profiles(config: &ConfigValue, env: Option<&str>) -> Vec<String> {
+    let split = |value: &str| -> Vec<String> {
+        value
+            .split(',')
+            .map(str::trim)
+            .filter(|name| !name.is_empty())
+            .map(String::from)
+            .collect()
+    };
+
+    if let Some(env) = env.filter(|v| !v.trim().is_empty()) {
+        return split(env);
+    }
+    match config.get_path(&["profile", "default"]) {
+        Some(value) => match value.as_array() {
+            Some(items) => items
+                .iter()
+                .filter_map(|v| v.as_str())
+                .flat_map(split)
+                .collect(),
+            None => value.as_str().map(split).unwrap_or_default(),
+        },
+        None => Vec::new(),
+    }
+}
+
+/// Read a configuration file into a layer
+fn read_config_layer(path: &Path, runtime: &dyn SystemRuntime) -> Result<ConfigValue> {
+    let content = runtime
+        .file_read_string(path)
+        .map_err(|e| QuartoError::Other(format!("Failed to read {}: {}", path.display(), e)))?;
+    parse_config_layer(&content, path)
+}
+
+/// Parse configuration YAML into a layer. Strings are kept literal, as in
+/// all project configuration. An empty file is an empty map.
+fn parse_config_layer(content: &str, path: &Path) -> Result<ConfigValue> {
+    // A file of only comments holds no YAML document
+    let is_empty = content.lines().all(|line| {
+        let line = line.trim();
+        line.is_empty() || line.starts_with('#')
+    });
+    if is_empty {
+        return Ok(ConfigValue::new_map(Vec::new(), SourceInfo::default()));
+    }
+
+    let yaml = quarto_yaml::parse_file(content, &path.to_string_lossy())
+        .map_err(|e| QuartoError::Other(format!("Failed to parse {}: {}", path.display(), e)))?;
+
+    let mut diagnostics = DiagnosticCollector::new();
+    let config = yaml_to_config_value(yaml, InterpretationContext::ProjectConfig, &mut diagnostics);
+    if diagnostics.has_errors() {
+        let messages: Vec<String> = diagnostics
+            .into_diagnostics()
+            .iter()
+            .map(|d| d.to_text(None))
+            .collect();
+        return Err(QuartoError::Other(format!(
+            "Failed to parse {}: {}",
+            path.display(),
+            messages.join("\n")
+        )));
+    }
+
+    match config.value {
+        ConfigValueKind::Map(_) => Ok(config),
+        _ if config.is_null() => Ok(ConfigValue::new_map(Vec::new(), config.source_info)),
+        _ => Err(QuartoError::Other(format!(
+            "{} does not hold a map of configuration",
+            path.display()
+        ))),
+    }
+}
+
+/// Merge configuration layers, later layers winning
+fn merge_layers(layers: &[ConfigValue]) -> Result<ConfigValue> {
+    MergedConfig::new(layers.iter().collect())
+        .materialize()
+        .map_err(|e| QuartoError::Other(format!("Failed to merge project configuration: {}", e)))
+}
+
+/// The configuration without the keys that only configure the project
+fn document_settings(mut config: ConfigValue) -> ConfigValue {
+    if let ConfigValueKind::Map(entries) = &mut config.value {
+        entries.retain(|e| !PROJECT_ONLY_KEYS.contains(&e.key.as_str()));
+    }
+    config
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -652,4 +869,180 @@ mod tests {
 
         assert!(!context.is_multi_document());
     }
+
+    // === Configuration tests ===
+
+    use quarto_system_runtime::NativeRuntime;
+    use std::fs;
+
+    fn string_at<'a>(config: &'a ConfigValue, path: &[&str]) -> Option<&'a str> {
+        config.get_path(path).and_then(|v| v.as_str())
+    }
+
+    #[test]
+    fn test_discover_reads_project_config() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "project:\n  type: website\n  output-dir: _site\nformat:\n  html:\n    theme: cosmo\n",
+        )
+        .unwrap();
+        fs::write(temp.path().join("index.qmd"), "# Hello\n").unwrap();
+
+        let runtime = NativeRuntime::new();
+        let project = ProjectContext::discover(temp.path().join("index.qmd"), &runtime).unwrap();
+
+        assert!(!project.is_single_file);
+        assert_eq!(project.project_type(), ProjectType::Website);
+        assert_eq!(project.output_dir, project.dir.join("_site"));
+
+        let config = project.files[0].config.as_ref().unwrap();
+        assert_eq!(
+            string_at(config, &["format", "html", "theme"]),
+            Some("cosmo")
+        );
+        // `project` configures the project, not its documents
+        assert!(config.get("project").is_none());
+    }
+
+    #[test]
+    fn test_discover_single_file_has_no_config() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("doc.qmd"), "# Hello\n").unwrap();
+
+        let runtime = NativeRuntime::new();
+        let project = ProjectContext::discover(temp.path().join("doc.qmd"), &runtime).unwrap();
+
+        assert!(project.is_single_file);
+        assert!(project.config.is_none());
+        assert!(project.files[0].config.is_none());
+    }
+
+    #[test]
+    fn test_profiles_layer_over_project_config() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "profile:\n  default: dark\nformat:\n  html:\n    theme: cosmo\n    toc: true\n",
+        )
+        .unwrap();
+        fs::write(
+            temp.path().join("_quarto-dark.yml"),
+            "format:\n  html:\n    theme: darkly\n",
+        )
+        .unwrap();
+
+        let runtime = NativeRuntime::new();
+        let config =
+            ProjectContext::parse_config(temp.path(), &temp.path().join("_quarto.yml"), &runtime)
+                .unwrap();
+
+        if std::env::var_os(PROFILE_ENV_VAR).is_none() {
+            assert_eq!(config.profiles, vec!["dark".to_string()]);
+            let settings = config.format_config.unwrap();
+            assert_eq!(
+                string_at(&settings, &["format", "html", "theme"]),
+                Some("darkly")
+            );
+            assert_eq!(
+                settings
+                    .get_path(&["format", "html", "toc"])
+                    .and_then(|v| v.as_bool()),
+                Some(true)
+            );
+            assert!(settings.get("profile").is_none());
+        }
+    }
+
+    #[test]
+    fn test_active_profiles() {
+        let config =
+            parse_config_layer("profile:\n  default: [a, b]\n", Path::new("_quarto.yml")).unwrap();
+        assert_eq!(active_profiles(&config, None), vec!["a", "b"]);
+        // The environment takes priority over the default
+        assert_eq!(active_profiles(&config, Some("c, d")), vec!["c", "d"]);
+        assert_eq!(active_profiles(&config, Some("")), vec!["a", "b"]);
+
+        let config = parse_config_layer("title: x\n", Path::new("_quarto.yml")).unwrap();
+        assert!(active_profiles(&config, None).is_empty());
+    }
+
+    #[test]
+    fn test_metadata_files_layer_over_project_config() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "metadata-files:\n  - shared/meta.yml\nauthor: Project\nlang: en\n",
+        )
+        .unwrap();
+        fs::create_dir(temp.path().join("shared")).unwrap();
+        fs::write(temp.path().join("shared/meta.yml"), "author: Shared\n").unwrap();
+
+        let runtime = NativeRuntime::new();
+        let config =
+            ProjectContext::parse_config(temp.path(), &temp.path().join("_quarto.yml"), &runtime)
+                .unwrap();
+        let settings = config.format_config.unwrap();
+
+        assert_eq!(string_at(&settings, &["author"]), Some("Shared"));
+        assert_eq!(string_at(&settings, &["lang"]), Some("en"));
+        assert!(settings.get("metadata-files").is_none());
+    }
+
+    #[test]
+    fn test_missing_metadata_file_is_error() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "metadata-files: [missing.yml]\n",
+        )
+        .unwrap();
+
+        let runtime = NativeRuntime::new();
+        let result =
+            ProjectContext::parse_config(temp.path(), &temp.path().join("_quarto.yml"), &runtime);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_document_config_merges_directory_metadata() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "author: Project\nlang: en\ntoc: false\n",
+        )
+        .unwrap();
+        fs::write(temp.path().join("_metadata.yml"), "lang: fr\n").unwrap();
+        fs::create_dir_all(temp.path().join("posts/2025")).unwrap();
+        fs::write(temp.path().join("posts/_metadata.yml"), "author: Posts\n").unwrap();
+        fs::write(temp.path().join("posts/2025/_metadata.yaml"), "lang: de\n").unwrap();
+        fs::write(temp.path().join("posts/2025/post.qmd"), "# Post\n").unwrap();
+        fs::write(temp.path().join("about.qmd"), "# About\n").unwrap();
+
+        let runtime = NativeRuntime::new();
+        let project =
+            ProjectContext::discover(temp.path().join("posts/2025/post.qmd"), &runtime).unwrap();
+
+        let config = project.files[0].config.as_ref().unwrap();
+        assert_eq!(string_at(config, &["author"]), Some("Posts"));
+        assert_eq!(string_at(config, &["lang"]), Some("de"));
+        assert_eq!(config.get("toc").and_then(|v| v.as_bool()), Some(false));
+
+        // Directories the document isn't in don't apply
+        let about = project
+            .document_config(&project.dir.join("about.qmd"), &runtime)
+            .unwrap()
+            .unwrap();
+        assert_eq!(string_at(&about, &["author"]), Some("Project"));
+        assert_eq!(string_at(&about, &["lang"]), Some("fr"));
+    }
+
+    #[test]
+    fn test_config_that_is_not_a_map_is_error() {
+        assert!(parse_config_layer("- a\n- b\n", Path::new("_quarto.yml")).is_err());
+        let empty = parse_config_layer("", Path::new("_metadata.yml")).unwrap();
+        assert!(empty.is_map());
+        let comments = parse_config_layer("# nothing yet\n", Path::new("_metadata.yml")).unwrap();
+        assert!(comments.is_map());
+    }
 }
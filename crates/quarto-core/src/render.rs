@@ -14,11 +14,13 @@
 
 use std::path::PathBuf;
 
+use pampa::transforms::SanitizeOptions;
 use quarto_analysis::AnalysisContext;
 use quarto_error_reporting::DiagnosticMessage;
 use quarto_system_runtime::SystemRuntime;
 
 use crate::artifact::ArtifactStore;
+use crate::engine::CacheMode;
 use crate::format::Format;
 use crate::project::{DocumentInfo, ProjectContext};
 
@@ -111,8 +113,15 @@ pub struct RenderOptions {
     /// Whether to use cached execution results
     pub use_freeze: bool,
 
+    /// When engines reuse cached cell outputs
+    pub cache: CacheMode,
+
     /// Custom output path (overrides format-determined path)
     pub output_path: Option<PathBuf>,
+
+    /// Treat the document as untrusted: sanitize it after parsing (see
+    /// [`pampa::transforms::sanitize`]) and read no local resources
+    pub sanitize: Option<SanitizeOptions>,
 }
 
 impl<'a> RenderContext<'a> {
@@ -288,6 +297,7 @@ mod tests {
         assert!(!options.verbose);
         assert!(!options.execute);
         assert!(!options.use_freeze);
+        assert_eq!(options.cache, CacheMode::Document);
         assert!(options.output_path.is_none());
     }
 
@@ -297,11 +307,14 @@ mod tests {
             verbose: true,
             execute: true,
             use_freeze: false,
+            cache: CacheMode::Refresh,
             output_path: Some(PathBuf::from("/output")),
+            sanitize: None,
         };
         let cloned = options.clone();
         assert_eq!(options.verbose, cloned.verbose);
         assert_eq!(options.execute, cloned.execute);
+        assert_eq!(options.cache, cloned.cache);
         assert_eq!(options.output_path, cloned.output_path);
     }
 
@@ -0,0 +1,409 @@
+/*
+ * resource_manifest.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Local resources copied into the output of a render.
+ */
+
+//! Local resources copied into the output of a render.
+//!
+//! The images, stylesheets and other files a document refers to by a
+//! relative path are copied to [`RESOURCE_DIR`] in the output directory,
+//! under names made from their content, and the document's URLs are
+//! rewritten to point at the copies (see
+//! [`ExtractResourcesStage`](crate::stage::ExtractResourcesStage)). The
+//! output then stands on its own: it can be previewed from the hub's
+//! virtual filesystem or published without the source tree, and a file
+//! that changes gets a new URL, so no cache serves the old one.
+//!
+//! ```text
+//! ![](images/plot.png)  →  <img src="_resources/plot-3f2a9c1e.png">
+//! ```
+//!
+//! Links to pages (`.qmd`, `.html`, directories, ...) are left alone, as
+//! are URLs with a scheme, like `https:` and `data:`.
+//!
+//! The copies are listed in a [`ResourceManifest`]. The stage stores it in
+//! the [`RESOURCE_MANIFEST_ARTIFACT`] artifact, and `quarto render` writes
+//! the manifest of all pages to [`RESOURCE_MANIFEST_FILE`] in the output
+//! directory.
+
+use std::path::{Component, Path, PathBuf};
+
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+use crate::artifact::ArtifactStore;
+
+/// The directory resources are copied to, in the output directory
+pub const RESOURCE_DIR: &str = "_resources";
+
+/// The name of the manifest file in the output directory
+pub const RESOURCE_MANIFEST_FILE: &str = "resource-manifest.json";
+
+/// The key prefix of the artifacts holding copied resources, which are
+/// stored as `resource:<output path>`
+pub const RESOURCE_ARTIFACT_PREFIX: &str = "resource:";
+
+/// The key of the artifact holding the resource manifest of a document
+pub const RESOURCE_MANIFEST_ARTIFACT: &str = "resource-manifest";
+
+/// The metadata key of a resource artifact's URL, relative to the page
+pub const RESOURCE_HREF_METADATA: &str = "href";
+
+/// Links to files with these extensions are links to pages
+const PAGE_EXTENSIONS: &[&str] = &["html", "htm", "qmd", "md", "markdown", "ipynb", "rmd"];
+
+/// The resources copied for one or more pages.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub struct ResourceManifest {
+    /// The copies, in the order they were first referred to
+    pub resources: Vec<Resource>,
+}
+
+/// A copied resource.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub struct Resource {
+    /// The original, relative to the project directory (absolute when it
+    /// is outside of it)
+    pub source: String,
+
+    /// The copy, relative to the output directory
+    pub output: String,
+
+    /// `sha256:` digest of the content
+    pub digest: String,
+
+    /// Size in bytes
+    pub size: u64,
+
+    pub content_type: String,
+
+    /// The pages that refer to it, relative to the output directory
+    pub pages: Vec<String>,
+}
+
+impl ResourceManifest {
+    /// Create an empty manifest.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// The resource copied from `source`, relative to the project directory.
+    pub fn get(&self, source: &str) -> Option<&Resource> {
+        self.resources.iter().find(|r| r.source == source)
+    }
+
+    /// Add a resource. A resource already copied to the same output gets
+    /// the pages of the new one.
+    pub fn add(&mut self, resource: Resource) {
+        match self
+            .resources
+            .iter_mut()
+            .find(|r| r.output == resource.output)
+        {
+            Some(existing) => {
+                for page in resource.pages {
+                    if !existing.pages.contains(&page) {
+                        existing.pages.push(page);
+                    }
+                }
+            }
+            None => self.resources.push(resource),
+        }
+    }
+
+    /// Add the resources of another manifest.
+    pub fn merge(&mut self, other: ResourceManifest) {
+        for resource in other.resources {
+            self.add(resource);
+        }
+    }
+
+    /// The manifest stored in `artifacts` by the extract-resources stage.
+    pub fn from_artifacts(artifacts: &ArtifactStore) -> Option<Self> {
+        let artifact = artifacts.get(RESOURCE_MANIFEST_ARTIFACT)?;
+        serde_json::from_slice(&artifact.content).ok()
+    }
+}
+
+/// The path of a URL that refers to a local file, and the query and
+/// fragment after it. None for URLs with a scheme, protocol-relative URLs
+/// and links within the page.
+pub fn local_path(url: &str) -> Option<(&str, &str)> {
+    if url.starts_with("//") {
+        return None;
+    }
+    let end = url.find(['?', '#']).unwrap_or(url.len());
+    let (path, suffix) = url.split_at(end);
+    if path.is_empty() || has_scheme(path) {
+        return None;
+    }
+    Some((path, suffix))
+}
+
+/// Whether `url` starts with a scheme like `https:` or `data:`. A single
+/// letter is a Windows drive, not a scheme.
+fn has_scheme(url: &str) -> bool {
+    url.split_once(':').is_some_and(|(scheme, _)| {
+        scheme.len() > 1
+            && scheme.starts_with(|c: char| c.is_ascii_alphabetic())
+            && scheme
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || matches!(c, '+' | '-' | '.'))
+    })
+}
+
+/// Whether a link to `path` is a link to a page, which is rendered rather
+/// than copied: a document, or a directory.
+pub fn is_page_path(path: &str) -> bool {
+    if path.ends_with('/') {
+        return true;
+    }
+    match Path::new(path).extension().and_then(|e| e.to_str()) {
+        Some(extension) => PAGE_EXTENSIONS.contains(&extension.to_ascii_lowercase().as_str()),
+        None => true,
+    }
+}
+
+/// The `sha256:` digest of `content`
+pub fn digest(content: &[u8]) -> String {
+    format!("sha256:{:x}", Sha256::digest(content))
+}
+
+/// The name of the copy of `path`: its stem, the first 8 hex digits of its
+/// digest, and its extension, as in `plot-3f2a9c1e.png`.
+pub fn hashed_file_name(path: &Path, digest: &str) -> String {
+    let hex = digest.strip_prefix("sha256:").unwrap_or(digest);
+    let hash = &hex[..hex.len().min(8)];
+    let stem = path
+        .file_stem()
+        .map(|s| s.to_string_lossy())
+        .unwrap_or_default();
+    match path.extension() {
+        Some(extension) => format!("{}-{}.{}", stem, hash, extension.to_string_lossy()),
+        None => format!("{}-{}", stem, hash),
+    }
+}
+
+/// The MIME type of a resource, from its extension
+pub fn content_type(path: &Path) -> &'static str {
+    let extension = path
+        .extension()
+        .and_then(|e| e.to_str())
+        .map(|e| e.to_ascii_lowercase());
+    match extension.as_deref() {
+        Some("png") => "image/png",
+        Some("jpg" | "jpeg") => "image/jpeg",
+        Some("gif") => "image/gif",
+        Some("svg") => "image/svg+xml",
+        Some("webp") => "image/webp",
+        Some("avif") => "image/avif",
+        Some("ico") => "image/x-icon",
+        Some("css") => "text/css",
+        Some("js" | "mjs") => "text/javascript",
+        Some("json") => "application/json",
+        Some("csv") => "text/csv",
+        Some("txt") => "text/plain",
+        Some("pdf") => "application/pdf",
+        Some("zip") => "application/zip",
+        Some("mp4") => "video/mp4",
+        Some("webm") => "video/webm",
+        Some("mp3") => "audio/mpeg",
+        Some("woff") => "font/woff",
+        Some("woff2") => "font/woff2",
+        _ => "application/octet-stream",
+    }
+}
+
+/// The directory that the resources of a page written to `output_path`
+/// are copied under: the output directory, or the page's own directory
+/// when the page is written outside of it.
+pub fn resource_root(output_path: &Path, output_dir: &Path) -> PathBuf {
+    if output_path.starts_with(output_dir) {
+        output_dir.to_path_buf()
+    } else {
+        output_path.parent().unwrap_or(Path::new("")).to_path_buf()
+    }
+}
+
+/// `path` with `.` removed and `..` applied, without looking at the
+/// filesystem.
+pub fn normalize_path(path: &Path) -> PathBuf {
+    let mut result = PathBuf::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => {
+                if matches!(result.components().next_back(), Some(Component::Normal(_))) {
+                    result.pop();
+                } else if !result.has_root() {
+                    result.push("..");
+                }
+            }
+            other => result.push(other),
+        }
+    }
+    result
+}
+
+/// The URL of `target` from a page in `from_dir`, both relative to the
+/// same directory.
+pub fn relative_url(from_dir: &Path, target: &Path) -> String {
+    let (from_dir, target) = (normalize_path(from_dir), normalize_path(target));
+    let from: Vec<Component> = from_dir.components().collect();
+    let to: Vec<Component> = target.components().collect();
+    let common = from.iter().zip(&to).take_while(|(a, b)| a == b).count();
+    let mut parts: Vec<String> = vec!["..".to_string(); from.len() - common];
+    parts.extend(
+        to[common..]
+            .iter()
+            .map(|c| c.as_os_str().to_string_lossy().into_owned()),
+    );
+    parts.join("/")
+}
+
+/// `path` with `/` between its components, as in a URL or the manifest
+pub fn slash_path(path: &Path) -> String {
+    let mut result = String::new();
+    for component in path.components() {
+        match component {
+            Component::RootDir => result.push('/'),
+            other => {
+                if !result.is_empty() && !result.ends_with('/') {
+                    result.push('/');
+                }
+                result.push_str(&other.as_os_str().to_string_lossy());
+            }
+        }
+    }
+    result
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_local_path() {
+        assert_eq!(local_path("images/a.png"), Some(("images/a.png", "")));
+        assert_eq!(
+            local_path("data.csv?v=2#top"),
+            Some(("data.csv", "?v=2#top"))
+        );
+        assert_eq!(
+            local_path("/assets/logo.svg"),
+            Some(("/assets/logo.svg", ""))
+        );
+        assert_eq!(local_path("https://example.com/a.png"), None);
+        assert_eq!(local_path("data:image/png;base64,abc"), None);
+        assert_eq!(local_path("mailto:someone@example.com"), None);
+        assert_eq!(local_path("//cdn.example.com/a.js"), None);
+        assert_eq!(local_path("#section"), None);
+        assert_eq!(local_path(""), None);
+        // A colon after a slash isn't a scheme
+        assert_eq!(local_path("a/b:c.png"), Some(("a/b:c.png", "")));
+    }
+
+    #[test]
+    fn test_is_page_path() {
+        assert!(is_page_path("other.qmd"));
+        assert!(is_page_path("about.html"));
+        assert!(is_page_path("chapters/"));
+        assert!(is_page_path("chapters/intro"));
+        assert!(!is_page_path("data/results.csv"));
+        assert!(!is_page_path("paper.PDF"));
+    }
+
+    #[test]
+    fn test_hashed_file_name() {
+        let digest = digest(b"hello");
+        assert_eq!(
+            digest,
+            "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+        );
+        assert_eq!(
+            hashed_file_name(Path::new("images/plot.png"), &digest),
+            "plot-2cf24dba.png"
+        );
+        assert_eq!(
+            hashed_file_name(Path::new("LICENSE"), &digest),
+            "LICENSE-2cf24dba"
+        );
+    }
+
+    #[test]
+    fn test_relative_url() {
+        let output = Path::new("_resources/plot-2cf24dba.png");
+        assert_eq!(
+            relative_url(Path::new(""), output),
+            "_resources/plot-2cf24dba.png"
+        );
+        assert_eq!(
+            relative_url(Path::new("guide/setup"), output),
+            "../../_resources/plot-2cf24dba.png"
+        );
+        assert_eq!(
+            relative_url(Path::new("./guide/../"), output),
+            "_resources/plot-2cf24dba.png"
+        );
+    }
+
+    #[test]
+    fn test_normalize_path() {
+        assert_eq!(
+            normalize_path(Path::new("/project/docs/../images/./a.png")),
+            PathBuf::from("/project/images/a.png")
+        );
+        assert_eq!(
+            normalize_path(Path::new("../a.png")),
+            PathBuf::from("../a.png")
+        );
+        assert_eq!(
+            normalize_path(Path::new("/../a.png")),
+            PathBuf::from("/a.png")
+        );
+    }
+
+    #[test]
+    fn test_resource_root() {
+        let output_dir = Path::new("/project/_site");
+        assert_eq!(
+            resource_root(Path::new("/project/_site/guide/index.html"), output_dir),
+            PathBuf::from("/project/_site")
+        );
+        assert_eq!(
+            resource_root(Path::new("/tmp/out/index.html"), output_dir),
+            PathBuf::from("/tmp/out")
+        );
+    }
+
+    #[test]
+    fn test_manifest_merges_pages() {
+        let resource = |page: &str| Resource {
+            source: "images/plot.png".to_string(),
+            output: "_resources/plot-2cf24dba.png".to_string(),
+            digest: digest(b"hello"),
+            size: 5,
+            content_type: "image/png".to_string(),
+            pages: vec![page.to_string()],
+        };
+        let mut manifest = ResourceManifest::new();
+        manifest.add(resource("index.html"));
+        let mut other = ResourceManifest::new();
+        other.add(resource("about.html"));
+        other.add(resource("index.html"));
+        manifest.merge(other);
+
+        assert_eq!(manifest.resources.len(), 1);
+        assert_eq!(
+            manifest.get("images/plot.png").unwrap().pages,
+            vec!["index.html", "about.html"]
+        );
+
+        let json = serde_json::to_value(&manifest).unwrap();
+        assert_eq!(json["resources"][0]["content-type"], "image/png");
+    }
+}
@@ -0,0 +1,871 @@
+/*
+ * site.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Assembly of books and websites.
+ */
+
+//! Assembly of multi-page projects.
+//!
+//! [`assemble_site`] reads the pages of a book or website and describes
+//! the whole in a [`SiteManifest`]: the pages in order with their metadata,
+//! the navigation tree, and the crossref labels of every page, so that a
+//! page can refer to a figure in another. The manifest serializes to JSON
+//! for the preview app and the hub; `quarto render` writes it to
+//! [`SITE_MANIFEST_FILE`] in the output directory.
+//!
+//! # Pages
+//!
+//! - Books: the files of `book.chapters`, then those of `book.appendices`.
+//!   A chapter is a path, or a part: `{part: ..., chapters: [...]}`, where
+//!   the part is a title or a file of its own.
+//! - Websites: the files of `project.render`, or else every `.qmd` and `.md`
+//!   file of the project outside the output directory, leaving out those
+//!   whose name or directory starts with `_` or `.`. `index.qmd` comes
+//!   first, then the others in path order.
+//!
+//! # Numbering
+//!
+//! Book chapters are numbered `1`, `2`, ... and appendices `A`, `B`, ...,
+//! except for those whose first header is `.unnumbered`. The crossref
+//! numbers of a chapter start with its number, as in `Figure 2.1` (see
+//! [`CrossrefOptions::chapter`]). Website pages number their elements on
+//! their own.
+//!
+//! # Navigation
+//!
+//! A book's navigation is its chapters, with parts and the appendices as
+//! sections. A website's is `website.sidebar.contents` when there is one:
+//! paths, `{href, text}` links and `{section, contents}` sections, with
+//! `auto` standing for all pages. Without a sidebar it is the pages.
+
+use std::path::{Path, PathBuf};
+
+use pampa::transforms::crossref::{CrossrefKind, CrossrefOptions, number_crossrefs};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+use quarto_config::MergedConfig;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::ConfigValue;
+use quarto_pandoc_types::block::Block;
+use quarto_pandoc_types::inline::Inline;
+use quarto_source_map::SourceInfo;
+use quarto_system_runtime::SystemRuntime;
+use serde::Serialize;
+
+use crate::error::{QuartoError, Result};
+use crate::project::{ProjectContext, ProjectType};
+
+/// The name of the manifest file in the output directory
+pub const SITE_MANIFEST_FILE: &str = "site-manifest.json";
+
+/// A book or website, as the preview app and the hub see it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "kebab-case")]
+pub struct SiteManifest {
+    /// `book` or `website`
+    pub project_type: String,
+
+    /// The title of the book or website
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
+
+    /// The pages, in reading order
+    pub pages: Vec<SitePage>,
+
+    /// The navigation tree
+    pub nav: Vec<NavItem>,
+
+    /// The crossref labels of all pages, in page order
+    pub crossrefs: Vec<SiteCrossref>,
+}
+
+impl SiteManifest {
+    /// The page rendered from `input` (relative to the project root)
+    pub fn page(&self, input: &str) -> Option<&SitePage> {
+        self.pages.iter().find(|page| page.input == input)
+    }
+
+    /// The element a crossref label names, in any page
+    pub fn crossref(&self, label: &str) -> Option<&SiteCrossref> {
+        self.crossrefs
+            .iter()
+            .find(|crossref| crossref.label == label)
+    }
+}
+
+/// A page of a site and its metadata.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct SitePage {
+    /// The source file, relative to the project root
+    pub input: String,
+
+    /// The output file, relative to the output directory
+    pub href: String,
+
+    /// The chapter number, in a book
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub number: Option<String>,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub subtitle: Option<String>,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub date: Option<String>,
+
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub author: Vec<String>,
+
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub categories: Vec<String>,
+}
+
+/// An entry of the navigation tree: a page, a link or a section.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct NavItem {
+    pub text: String,
+
+    /// Where the entry links to; `None` for a section without a page
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub href: Option<String>,
+
+    /// The chapter number of a page, in a book
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub number: Option<String>,
+
+    /// The entries of a section
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<NavItem>,
+}
+
+/// A numbered element of a page.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct SiteCrossref {
+    pub label: String,
+
+    /// The label prefix without its dash: `fig`, `tbl`, `sec` or `eq`
+    pub kind: String,
+
+    /// The number, e.g. `2.1`
+    pub number: String,
+
+    /// The page and anchor of the element, e.g. `intro.html#fig-plot`
+    pub href: String,
+}
+
+/// A manifest and what was found while assembling it.
+#[derive(Debug, Clone)]
+pub struct SiteAssembly {
+    pub manifest: SiteManifest,
+
+    /// Problems in the pages: parse errors, duplicate labels
+    pub diagnostics: Vec<DiagnosticMessage>,
+}
+
+/// How a page is numbered.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Numbering {
+    Chapter,
+    Appendix,
+    None,
+}
+
+/// The navigation of a site before its pages are read.
+#[derive(Debug, Clone, PartialEq)]
+enum Outline {
+    /// A page, by its path relative to the project root
+    Page(String),
+    /// A link to anything
+    Link { text: String, href: String },
+    /// A section, with a page of its own or not
+    Section {
+        text: Option<String>,
+        page: Option<String>,
+        children: Vec<Outline>,
+    },
+    /// All pages, in page order
+    Auto,
+}
+
+/// Assemble a book or website project.
+///
+/// # Returns
+/// The manifest, or an error if a page can't be read.
+pub fn assemble_site(
+    project: &ProjectContext,
+    runtime: &dyn SystemRuntime,
+) -> Result<SiteAssembly> {
+    let empty = ConfigValue::new_map(Vec::new(), SourceInfo::default());
+    let config = project
+        .config
+        .as_ref()
+        .and_then(|c| c.format_config.as_ref())
+        .unwrap_or(&empty);
+    let project_type = project.project_type();
+
+    let (pages, outline) = match project_type {
+        ProjectType::Book => book_outline(config),
+        _ => {
+            let pages: Vec<(String, Numbering)> = website_pages(project, runtime)?
+                .into_iter()
+                .map(|page| (page, Numbering::None))
+                .collect();
+            let outline = sidebar_outline(config).unwrap_or_else(|| vec![Outline::Auto]);
+            (pages, outline)
+        }
+    };
+
+    let mut diagnostics = Vec::new();
+    let mut site_pages = Vec::new();
+    let mut crossrefs: Vec<SiteCrossref> = Vec::new();
+    let mut chapters = 0;
+    let mut appendices = 0;
+    for (input, numbering) in pages {
+        let path = project.dir.join(&input);
+        let content = runtime
+            .file_read(&path)
+            .map_err(|e| QuartoError::Other(format!("Failed to read page {}: {}", input, e)))?;
+        let (pandoc, _context, page_diagnostics) =
+            pampa::readers::qmd::read_recovering(&content, false, &input, true);
+        diagnostics.extend(page_diagnostics);
+
+        let meta = match project.document_config(&path, runtime)? {
+            Some(project_meta) => MergedConfig::new(vec![&project_meta, &pandoc.meta])
+                .materialize()
+                .unwrap_or_else(|_| pandoc.meta.clone()),
+            None => pandoc.meta.clone(),
+        };
+
+        let first_header = pandoc.blocks.iter().find_map(|block| match block {
+            Block::Header(header) => Some(header),
+            _ => None,
+        });
+        let unnumbered =
+            first_header.is_some_and(|h| h.attr.1.iter().any(|class| class == "unnumbered"));
+        // A page without a title is known by its first header
+        let title = text_of(&meta, "title")
+            .or_else(|| first_header.map(|h| inlines_to_plain_text(&h.content)));
+
+        let number = if unnumbered {
+            None
+        } else {
+            match numbering {
+                Numbering::Chapter => {
+                    chapters += 1;
+                    Some(chapters.to_string())
+                }
+                Numbering::Appendix => {
+                    appendices += 1;
+                    Some(appendix_letter(appendices))
+                }
+                Numbering::None => None,
+            }
+        };
+
+        let href = output_href(&input);
+        let mut options = CrossrefOptions::from_metadata(&meta);
+        options.chapter = number.clone();
+        let mut collector = DiagnosticCollector::new();
+        let (_blocks, index) = number_crossrefs(pandoc.blocks, &options, &mut collector);
+        diagnostics.extend(collector.into_diagnostics());
+        for (label, entry) in index.iter() {
+            if entry.number.is_empty() {
+                continue;
+            }
+            if let Some(existing) = crossrefs.iter().find(|c| c.label == label) {
+                diagnostics.push(
+                    DiagnosticMessageBuilder::warning("Duplicate Cross-Reference Label")
+                        .with_code("Q-2-40")
+                        .problem(format!(
+                            "The label `{}` of `{}` is already used in `{}`",
+                            label, input, existing.href
+                        ))
+                        .add_hint("References resolve to the first element with this label")
+                        .build(),
+                );
+                continue;
+            }
+            crossrefs.push(SiteCrossref {
+                label: label.to_string(),
+                kind: kind_name(entry.kind).to_string(),
+                number: entry.number.clone(),
+                href: format!("{}#{}", href, label),
+            });
+        }
+
+        site_pages.push(SitePage {
+            input,
+            href,
+            number,
+            title,
+            subtitle: text_of(&meta, "subtitle"),
+            description: text_of(&meta, "description"),
+            date: text_of(&meta, "date"),
+            author: texts_of(&meta, "author"),
+            categories: texts_of(&meta, "categories"),
+        });
+    }
+
+    let site_key = match project_type {
+        ProjectType::Book => "book",
+        _ => "website",
+    };
+    let title = config
+        .get_path(&[site_key, "title"])
+        .or_else(|| config.get("title"))
+        .and_then(|v| v.as_plain_text());
+    let nav = nav_items(&outline, &site_pages);
+
+    Ok(SiteAssembly {
+        manifest: SiteManifest {
+            project_type: site_key.to_string(),
+            title,
+            pages: site_pages,
+            nav,
+            crossrefs,
+        },
+        diagnostics,
+    })
+}
+
+/// The pages and outline of a book, from `book.chapters` and
+/// `book.appendices`.
+fn book_outline(config: &ConfigValue) -> (Vec<(String, Numbering)>, Vec<Outline>) {
+    let mut outline: Vec<Outline> = config
+        .get_path(&["book", "chapters"])
+        .and_then(|v| v.as_array())
+        .map(|items| items.iter().filter_map(book_entry).collect())
+        .unwrap_or_default();
+
+    let mut pages = Vec::new();
+    collect_pages(&outline, Numbering::Chapter, &mut pages);
+
+    let appendices: Vec<String> = config
+        .get_path(&["book", "appendices"])
+        .and_then(|v| v.as_array())
+        .map(|items| {
+            items
+                .iter()
+                .filter_map(|v| v.as_str().map(normalize_path))
+                .collect()
+        })
+        .unwrap_or_default();
+    if !appendices.is_empty() {
+        pages.extend(
+            appendices
+                .iter()
+                .map(|page| (page.clone(), Numbering::Appendix)),
+        );
+        outline.push(Outline::Section {
+            text: Some("Appendices".to_string()),
+            page: None,
+            children: appendices.into_iter().map(Outline::Page).collect(),
+        });
+    }
+    (pages, outline)
+}
+
+/// An entry of `book.chapters`: a path or a part.
+fn book_entry(value: &ConfigValue) -> Option<Outline> {
+    if let Some(path) = value.as_str() {
+        return Some(Outline::Page(normalize_path(path)));
+    }
+    let part = value.get("part")?.as_plain_text()?;
+    let children = value
+        .get("chapters")
+        .and_then(|v| v.as_array())
+        .map(|items| items.iter().filter_map(book_entry).collect())
+        .unwrap_or_default();
+    let (text, page) = if is_page_path(&part) {
+        (None, Some(normalize_path(&part)))
+    } else {
+        (Some(part), None)
+    };
+    Some(Outline::Section {
+        text,
+        page,
+        children,
+    })
+}
+
+/// The pages of an outline in order. Part pages are not numbered.
+fn collect_pages(outline: &[Outline], numbering: Numbering, pages: &mut Vec<(String, Numbering)>) {
+    for entry in outline {
+        match entry {
+            Outline::Page(path) => pages.push((path.clone(), numbering)),
+            Outline::Section { page, children, .. } => {
+                if let Some(page) = page {
+                    pages.push((page.clone(), Numbering::None));
+                }
+                collect_pages(children, numbering, pages);
+            }
+            Outline::Link { .. } | Outline::Auto => {}
+        }
+    }
+}
+
+/// The outline of `website.sidebar.contents`. A website with several
+/// sidebars uses the first.
+fn sidebar_outline(config: &ConfigValue) -> Option<Vec<Outline>> {
+    let sidebar = config.get_path(&["website", "sidebar"])?;
+    let sidebar = match sidebar.as_array() {
+        Some(sidebars) => sidebars.first()?,
+        None => sidebar,
+    };
+    sidebar.get("contents").map(sidebar_entries)
+}
+
+fn sidebar_entries(contents: &ConfigValue) -> Vec<Outline> {
+    match contents.as_array() {
+        Some(items) => items.iter().filter_map(sidebar_entry).collect(),
+        None => sidebar_entry(contents).into_iter().collect(),
+    }
+}
+
+/// An entry of a sidebar: a path, `auto`, a link or a section.
+fn sidebar_entry(value: &ConfigValue) -> Option<Outline> {
+    if let Some(text) = value.as_str() {
+        return Some(if text == "auto" {
+            Outline::Auto
+        } else {
+            Outline::Page(normalize_path(text))
+        });
+    }
+    let href = value.get("href").and_then(|v| v.as_plain_text());
+    if let Some(section) = value.get("section") {
+        return Some(Outline::Section {
+            text: section.as_plain_text(),
+            page: href.filter(|h| is_page_path(h)).map(|h| normalize_path(&h)),
+            children: value
+                .get("contents")
+                .map(sidebar_entries)
+                .unwrap_or_default(),
+        });
+    }
+    let href = href?;
+    let text = value.get("text").and_then(|v| v.as_plain_text());
+    match text {
+        Some(text) if !is_page_path(&href) => Some(Outline::Link { text, href }),
+        // A page, whose title is the text unless the entry has one
+        Some(text) => Some(Outline::Section {
+            text: Some(text),
+            page: Some(normalize_path(&href)),
+            children: Vec::new(),
+        }),
+        None if is_page_path(&href) => Some(Outline::Page(normalize_path(&href))),
+        None => Some(Outline::Link {
+            text: href.clone(),
+            href,
+        }),
+    }
+}
+
+/// The pages of a website, relative to the project root.
+fn website_pages(project: &ProjectContext, runtime: &dyn SystemRuntime) -> Result<Vec<String>> {
+    let render_patterns = project
+        .config
+        .as_ref()
+        .map(|c| c.render_patterns.as_slice())
+        .unwrap_or_default();
+    if !render_patterns.is_empty() {
+        return Ok(render_patterns.iter().map(|p| normalize_path(p)).collect());
+    }
+
+    let mut pages = Vec::new();
+    collect_sources(project, &project.dir, &mut pages, runtime)?;
+    let mut pages: Vec<String> = pages
+        .iter()
+        .filter_map(|path| path.strip_prefix(&project.dir).ok())
+        .map(path_string)
+        .collect();
+    pages.sort_by(|a, b| (a != "index.qmd", a).cmp(&(b != "index.qmd", b)));
+    Ok(pages)
+}
+
+fn collect_sources(
+    project: &ProjectContext,
+    dir: &Path,
+    sources: &mut Vec<PathBuf>,
+    runtime: &dyn SystemRuntime,
+) -> Result<()> {
+    let entries = runtime
+        .dir_list(dir)
+        .map_err(|e| QuartoError::Other(format!("Failed to list {}: {}", dir.display(), e)))?;
+    for entry in entries {
+        let name = entry
+            .file_name()
+            .and_then(|n| n.to_str())
+            .unwrap_or_default();
+        if name.starts_with('_') || name.starts_with('.') {
+            continue;
+        }
+        let is_dir = runtime
+            .is_dir(&entry)
+            .map_err(|e| QuartoError::Other(format!("Failed to check path type: {}", e)))?;
+        if is_dir {
+            if entry != project.output_dir {
+                collect_sources(project, &entry, sources, runtime)?;
+            }
+        } else if is_page_path(name) {
+            sources.push(entry);
+        }
+    }
+    Ok(())
+}
+
+/// The navigation tree of an outline, with the titles and numbers of the
+/// pages.
+fn nav_items(outline: &[Outline], pages: &[SitePage]) -> Vec<NavItem> {
+    let page_item = |input: &str| -> NavItem {
+        match pages.iter().find(|page| page.input == input) {
+            Some(page) => NavItem {
+                text: page.title.clone().unwrap_or_else(|| file_stem(input)),
+                href: Some(page.href.clone()),
+                number: page.number.clone(),
+                children: Vec::new(),
+            },
+            None => NavItem {
+                text: file_stem(input),
+                href: Some(output_href(input)),
+                number: None,
+                children: Vec::new(),
+            },
+        }
+    };
+
+    let mut items = Vec::new();
+    for entry in outline {
+        match entry {
+            Outline::Page(input) => items.push(page_item(input)),
+            Outline::Link { text, href } => items.push(NavItem {
+                text: text.clone(),
+                href: Some(href.clone()),
+                number: None,
+                children: Vec::new(),
+            }),
+            Outline::Section {
+                text,
+                page,
+                children,
+            } => {
+                let mut item = match page {
+                    Some(page) => page_item(page),
+                    None => NavItem {
+                        text: String::new(),
+                        href: None,
+                        number: None,
+                        children: Vec::new(),
+                    },
+                };
+                if let Some(text) = text {
+                    item.text = text.clone();
+                }
+                item.children = nav_items(children, pages);
+                items.push(item);
+            }
+            Outline::Auto => items.extend(pages.iter().map(|page| page_item(&page.input))),
+        }
+    }
+    items
+}
+
+/// Convert inlines to plain text.
+fn inlines_to_plain_text(inlines: &[Inline]) -> String {
+    let mut result = String::new();
+    for inline in inlines {
+        match inline {
+            Inline::Str(s) => result.push_str(&s.text),
+            Inline::Space(_) | Inline::SoftBreak(_) => result.push(' '),
+            Inline::Code(c) => result.push_str(&c.text),
+            Inline::Emph(e) => result.push_str(&inlines_to_plain_text(&e.content)),
+            Inline::Strong(s) => result.push_str(&inlines_to_plain_text(&s.content)),
+            Inline::Link(l) => result.push_str(&inlines_to_plain_text(&l.content)),
+            Inline::Span(s) => result.push_str(&inlines_to_plain_text(&s.content)),
+            _ => {}
+        }
+    }
+    result
+}
+
+/// `A` for 1, ..., `Z` for 26, `AA` for 27, ...
+fn appendix_letter(mut n: usize) -> String {
+    let mut letters = Vec::new();
+    while n > 0 {
+        n -= 1;
+        letters.push((b'A' + (n % 26) as u8) as char);
+        n /= 26;
+    }
+    letters.iter().rev().collect()
+}
+
+fn kind_name(kind: CrossrefKind) -> &'static str {
+    kind.label_prefix().trim_end_matches('-')
+}
+
+fn text_of(meta: &ConfigValue, key: &str) -> Option<String> {
+    meta.get(key).and_then(|v| v.as_plain_text())
+}
+
+/// A value that is a string or a list of them. Authors can be maps with a
+/// `name`.
+fn texts_of(meta: &ConfigValue, key: &str) -> Vec<String> {
+    let text = |value: &ConfigValue| {
+        value
+            .as_plain_text()
+            .or_else(|| value.get("name").and_then(|name| name.as_plain_text()))
+    };
+    match meta.get(key) {
+        Some(value) => match value.as_array() {
+            Some(items) => items.iter().filter_map(text).collect(),
+            None => text(value).into_iter().collect(),
+        },
+        None => Vec::new(),
+    }
+}
+
+fn is_page_path(path: &str) -> bool {
+    path.ends_with(".qmd") || path.ends_with(".md")
+}
+
+/// A path from the configuration, without a leading `./`
+fn normalize_path(path: &str) -> String {
+    path.trim_start_matches("./").to_string()
+}
+
+/// The output of a page, relative to the output directory
+fn output_href(input: &str) -> String {
+    path_string(&Path::new(input).with_extension("html"))
+}
+
+fn path_string(path: &Path) -> String {
+    path.components()
+        .map(|c| c.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+fn file_stem(input: &str) -> String {
+    Path::new(input)
+        .file_stem()
+        .map(|stem| stem.to_string_lossy().to_string())
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_system_runtime::NativeRuntime;
+    use std::fs;
+
+    fn assemble(files: &[(&str, &str)], input: &str) -> SiteAssembly {
+        let temp = tempfile::tempdir().unwrap();
+        for (path, content) in files {
+            let path = temp.path().join(path);
+            fs::create_dir_all(path.parent().unwrap()).unwrap();
+            fs::write(path, content).unwrap();
+        }
+        let runtime = NativeRuntime::new();
+        let project = ProjectContext::discover(temp.path().join(input), &runtime).unwrap();
+        assemble_site(&project, &runtime).unwrap()
+    }
+
+    #[test]
+    fn test_book_chapters_and_numbering() {
+        let site = assemble(
+            &[
+                (
+                    "_quarto.yml",
+                    "project:\n  type: book\nbook:\n  title: The Book\n  chapters:\n    - index.qmd\n    - part: Basics\n      chapters:\n        - intro.qmd\n        - methods.qmd\n  appendices:\n    - data.qmd\n",
+                ),
+                ("index.qmd", "# Preface {.unnumbered}\n"),
+                (
+                    "intro.qmd",
+                    "---\ntitle: Introduction\nauthor: [Ada, Grace]\n---\n\n# Introduction\n\n![A plot](plot.png){#fig-plot}\n",
+                ),
+                (
+                    "methods.qmd",
+                    "# Methods {#sec-methods}\n\n## Data {#sec-data}\n\n![Another](b.png){#fig-other}\n",
+                ),
+                ("data.qmd", "# Data {#sec-appendix-data}\n"),
+            ],
+            ".",
+        );
+        assert!(site.diagnostics.is_empty(), "{:?}", site.diagnostics);
+        let manifest = &site.manifest;
+
+        assert_eq!(manifest.project_type, "book");
+        assert_eq!(manifest.title.as_deref(), Some("The Book"));
+        let numbers: Vec<(&str, Option<&str>)> = manifest
+            .pages
+            .iter()
+            .map(|page| (page.input.as_str(), page.number.as_deref()))
+            .collect();
+        assert_eq!(
+            numbers,
+            vec![
+                ("index.qmd", None),
+                ("intro.qmd", Some("1")),
+                ("methods.qmd", Some("2")),
+                ("data.qmd", Some("A")),
+            ]
+        );
+        let intro = manifest.page("intro.qmd").unwrap();
+        assert_eq!(intro.title.as_deref(), Some("Introduction"));
+        assert_eq!(intro.author, vec!["Ada", "Grace"]);
+
+        assert_eq!(
+            manifest.crossref("fig-plot"),
+            Some(&SiteCrossref {
+                label: "fig-plot".to_string(),
+                kind: "fig".to_string(),
+                number: "1.1".to_string(),
+                href: "intro.html#fig-plot".to_string(),
+            })
+        );
+        assert_eq!(manifest.crossref("sec-data").unwrap().number, "2.1");
+        assert_eq!(manifest.crossref("fig-other").unwrap().number, "2.1");
+        assert_eq!(manifest.crossref("sec-appendix-data").unwrap().number, "A");
+
+        let nav: Vec<(&str, usize)> = manifest
+            .nav
+            .iter()
+            .map(|item| (item.text.as_str(), item.children.len()))
+            .collect();
+        assert_eq!(nav, vec![("Preface", 0), ("Basics", 2), ("Appendices", 1)]);
+        assert_eq!(manifest.nav[1].children[0].text, "Introduction");
+        assert_eq!(manifest.nav[1].children[0].number.as_deref(), Some("1"));
+    }
+
+    #[test]
+    fn test_duplicate_labels_across_pages() {
+        let site = assemble(
+            &[
+                (
+                    "_quarto.yml",
+                    "project:\n  type: book\nbook:\n  chapters: [one.qmd, two.qmd]\n",
+                ),
+                ("one.qmd", "# One {#sec-a}\n"),
+                ("two.qmd", "# Two {#sec-a}\n"),
+            ],
+            ".",
+        );
+        assert_eq!(site.diagnostics.len(), 1);
+        assert_eq!(site.diagnostics[0].code.as_deref(), Some("Q-2-40"));
+        assert_eq!(
+            site.manifest.crossref("sec-a").unwrap().href,
+            "one.html#sec-a"
+        );
+    }
+
+    #[test]
+    fn test_website_pages_and_sidebar() {
+        let site = assemble(
+            &[
+                (
+                    "_quarto.yml",
+                    "project:\n  type: website\nwebsite:\n  title: Site\n  sidebar:\n    contents:\n      - index.qmd\n      - section: Posts\n        contents: [posts/b.qmd, posts/a.qmd]\n      - href: https://quarto.org\n        text: Quarto\n",
+                ),
+                ("index.qmd", "---\ntitle: Home\n---\n"),
+                ("about.md", "# About\n"),
+                (
+                    "posts/a.qmd",
+                    "---\ntitle: A\ndate: 2025-01-02\ncategories: [news]\n---\n",
+                ),
+                ("posts/b.qmd", "---\ntitle: B\n---\n"),
+                ("posts/_draft.qmd", "# Draft\n"),
+                ("_partials/x.qmd", "# Partial\n"),
+            ],
+            ".",
+        );
+        let manifest = &site.manifest;
+        assert_eq!(manifest.project_type, "website");
+        assert_eq!(manifest.title.as_deref(), Some("Site"));
+
+        let inputs: Vec<&str> = manifest.pages.iter().map(|p| p.input.as_str()).collect();
+        assert_eq!(
+            inputs,
+            vec!["index.qmd", "about.md", "posts/a.qmd", "posts/b.qmd"]
+        );
+        let a = manifest.page("posts/a.qmd").unwrap();
+        assert_eq!(a.href, "posts/a.html");
+        assert_eq!(a.date.as_deref(), Some("2025-01-02"));
+        assert_eq!(a.categories, vec!["news"]);
+        assert!(manifest.pages.iter().all(|p| p.number.is_none()));
+
+        assert_eq!(manifest.nav.len(), 3);
+        assert_eq!(manifest.nav[0].text, "Home");
+        assert_eq!(manifest.nav[1].text, "Posts");
+        let posts: Vec<&str> = manifest.nav[1]
+            .children
+            .iter()
+            .map(|item| item.text.as_str())
+            .collect();
+        assert_eq!(posts, vec!["B", "A"]);
+        assert_eq!(manifest.nav[2].href.as_deref(), Some("https://quarto.org"));
+    }
+
+    #[test]
+    fn test_website_without_sidebar_lists_pages() {
+        let site = assemble(
+            &[
+                ("_quarto.yml", "project:\n  type: website\n"),
+                ("index.qmd", "# Home\n"),
+                ("b.qmd", "---\ntitle: Bee\n---\n"),
+            ],
+            ".",
+        );
+        let nav: Vec<&str> = site.manifest.nav.iter().map(|i| i.text.as_str()).collect();
+        // Pages without a title are known by their first header
+        assert_eq!(nav, vec!["Home", "Bee"]);
+    }
+
+    #[test]
+    fn test_missing_chapter_is_error() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("_quarto.yml"),
+            "project:\n  type: book\nbook:\n  chapters: [missing.qmd]\n",
+        )
+        .unwrap();
+        let runtime = NativeRuntime::new();
+        let project = ProjectContext::discover(temp.path(), &runtime).unwrap();
+        assert!(assemble_site(&project, &runtime).is_err());
+    }
+
+    #[test]
+    fn test_manifest_json() {
+        let manifest = SiteManifest {
+            project_type: "website".to_string(),
+            title: None,
+            pages: Vec::new(),
+            nav: vec![NavItem {
+                text: "Home".to_string(),
+                href: Some("index.html".to_string()),
+                number: None,
+                children: Vec::new(),
+            }],
+            crossrefs: Vec::new(),
+        };
+        assert_eq!(
+            serde_json::to_value(&manifest).unwrap(),
+            serde_json::json!({
+                "project-type": "website",
+                "pages": [],
+                "nav": [{"text": "Home", "href": "index.html"}],
+                "crossrefs": [],
+            })
+        );
+    }
+
+    #[test]
+    fn test_appendix_letter() {
+        assert_eq!(appendix_letter(1), "A");
+        assert_eq!(appendix_letter(26), "Z");
+        assert_eq!(appendix_letter(27), "AA");
+    }
+}
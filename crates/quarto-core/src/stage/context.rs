@@ -19,6 +19,7 @@
 use std::path::PathBuf;
 use std::sync::Arc;
 
+use pampa::transforms::SanitizeOptions;
 use quarto_error_reporting::DiagnosticMessage;
 use quarto_system_runtime::SystemRuntime;
 
@@ -26,6 +27,7 @@ use super::cancellation::Cancellation;
 use super::error::PipelineError;
 use super::observer::{NoopObserver, PipelineObserver};
 use crate::artifact::ArtifactStore;
+use crate::engine::CacheMode;
 use crate::format::Format;
 use crate::project::{DocumentInfo, ProjectContext};
 
@@ -62,6 +64,16 @@ pub struct StageContext {
     /// Temporary directory for this pipeline run
     pub temp_dir: PathBuf,
 
+    /// Whether to execute code cells (`quarto render --execute`)
+    pub execute: bool,
+
+    /// When engines reuse cached cell outputs (`--cache`, `--cache-refresh`)
+    pub cache: CacheMode,
+
+    /// Limits for an untrusted document, which is sanitized after parsing
+    /// and has no local resources read. `None` for a trusted document.
+    pub sanitize: Option<SanitizeOptions>,
+
     // === Mutable state ===
     /// Artifact store for dependencies and intermediates
     pub artifacts: ArtifactStore,
@@ -102,6 +114,9 @@ impl StageContext {
             project,
             document,
             temp_dir,
+            execute: false,
+            cache: CacheMode::default(),
+            sanitize: None,
             artifacts: ArtifactStore::new(),
             diagnostics: Vec::new(),
             observer: Arc::new(NoopObserver),
@@ -121,6 +136,24 @@ impl StageContext {
         self
     }
 
+    /// Set whether code cells are executed.
+    pub fn with_execute(mut self, execute: bool) -> Self {
+        self.execute = execute;
+        self
+    }
+
+    /// Set when engines reuse cached cell outputs.
+    pub fn with_cache(mut self, cache: CacheMode) -> Self {
+        self.cache = cache;
+        self
+    }
+
+    /// Set whether the document is untrusted, and its limits.
+    pub fn with_sanitize(mut self, sanitize: Option<SanitizeOptions>) -> Self {
+        self.sanitize = sanitize;
+        self
+    }
+
     /// Set a custom temporary directory.
     pub fn with_temp_dir(mut self, temp_dir: PathBuf) -> Self {
         self.temp_dir = temp_dir;
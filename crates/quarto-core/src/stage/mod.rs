@@ -97,14 +97,14 @@ pub use data::{
     PipelineData, PipelineDataKind, RenderedOutput, SourceType,
 };
 pub use error::{PipelineError, PipelineValidationError};
-pub use observer::{EventLevel, NoopObserver, PipelineObserver, TracingObserver};
+pub use observer::{EventLevel, NoopObserver, PipelineObserver, TraceObserver, TracingObserver};
 pub use pipeline::Pipeline;
 pub use traits::PipelineStage;
 
 // Re-export concrete stages for convenience
 pub use stages::{
-    ApplyTemplateStage, AstTransformsStage, EngineExecutionStage, ParseDocumentStage,
-    RenderHtmlBodyStage,
+    ApplyTemplateStage, AstTransformsStage, EngineExecutionStage, ExtractResourcesStage,
+    ParseDocumentStage, RenderHtmlBodyStage,
 };
 
 // Re-export the trace_event macro
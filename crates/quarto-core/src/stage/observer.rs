@@ -11,10 +11,15 @@
 //! - OpenTelemetry tracing (native builds with `otel` feature)
 //! - Progress bar updates (CLI)
 //! - JavaScript callbacks (WASM builds)
+//! - Render traces (`quarto render --trace`, see [`TraceObserver`])
 //!
 //! This abstraction allows the pipeline to emit events without
 //! depending on a specific observability implementation.
 
+use std::sync::Mutex;
+
+use pampa::trace::{Phase, SpanStart, Trace, Tracer};
+
 use super::error::PipelineError;
 
 /// Event severity level for pipeline events.
@@ -93,6 +98,32 @@ pub trait PipelineObserver: Send + Sync {
     /// * `level` - Severity level of the event
     fn on_event(&self, _message: &str, _level: EventLevel) {}
 
+    /// Whether spans should report the blocks they changed to
+    /// [`on_span_end`](Self::on_span_end). Finding them means copying the
+    /// document before the span, so it's only done when asked for.
+    fn wants_block_locs(&self) -> bool {
+        false
+    }
+
+    /// Called when a timed part of a stage begins, such as one AST
+    /// transform or the run of an execution engine.
+    ///
+    /// # Arguments
+    ///
+    /// * `phase` - The phase of the render the span belongs to
+    /// * `name` - What runs, e.g. the name of the transform
+    fn on_span_start(&self, _phase: Phase, _name: &str) {}
+
+    /// Called when the span last started with `name` ends.
+    ///
+    /// # Arguments
+    ///
+    /// * `phase` - The phase of the render the span belongs to
+    /// * `name` - What ran
+    /// * `locs` - Finds the source locations of the top-level blocks the
+    ///   span changed. Empty unless [`wants_block_locs`](Self::wants_block_locs).
+    fn on_span_end(&self, _phase: Phase, _name: &str, _locs: &dyn Fn() -> Vec<String>) {}
+
     /// Called when the pipeline starts execution.
     ///
     /// # Arguments
@@ -195,6 +226,89 @@ impl PipelineObserver for TracingObserver {
     }
 }
 
+/// Observer that records a render trace, in the format of `pampa --trace`.
+///
+/// Each stage is a span, and so is each span the stages report: AST
+/// transforms and the run of the execution engine, with the blocks they
+/// changed. A stage's phase is found from its name.
+///
+/// Spans are timed with `std::time::Instant`, which WASM builds don't have,
+/// so this observer is for native builds.
+#[derive(Debug, Default)]
+pub struct TraceObserver {
+    tracer: Mutex<Tracer>,
+    open: Mutex<Vec<(String, SpanStart)>>,
+}
+
+impl TraceObserver {
+    /// Create a trace observer. The trace starts now.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Take the spans recorded so far, as the trace of rendering `input`.
+    /// The observer starts a new trace.
+    pub fn take_trace(&self, input: &str) -> Trace {
+        let mut tracer = self.tracer.lock().unwrap();
+        std::mem::take(&mut *tracer).finish(input)
+    }
+
+    fn start(&self, name: &str) {
+        let start = self.tracer.lock().unwrap().begin();
+        self.open.lock().unwrap().push((name.to_string(), start));
+    }
+
+    fn end(&self, phase: Phase, name: &str, locs: &dyn Fn() -> Vec<String>) {
+        let mut open = self.open.lock().unwrap();
+        // Spans nest, so this is the innermost one by that name
+        let Some(index) = open.iter().rposition(|(open_name, _)| open_name == name) else {
+            return;
+        };
+        let (_, start) = open.remove(index);
+        drop(open);
+        self.tracer
+            .lock()
+            .unwrap()
+            .record_with(start, phase, name, locs);
+    }
+}
+
+/// The phase of the render a stage belongs to.
+fn stage_phase(name: &str) -> Phase {
+    match name {
+        "parse-document" => Phase::Read,
+        "engine-execution" => Phase::Execute,
+        "ast-transforms" => Phase::Transform,
+        _ => Phase::Write,
+    }
+}
+
+impl PipelineObserver for TraceObserver {
+    fn on_stage_start(&self, name: &str, _index: usize, _total: usize) {
+        self.start(name);
+    }
+
+    fn on_stage_complete(&self, name: &str, _index: usize, _total: usize) {
+        self.end(stage_phase(name), name, &Vec::new);
+    }
+
+    fn on_stage_error(&self, name: &str, _index: usize, _error: &PipelineError) {
+        self.end(stage_phase(name), name, &Vec::new);
+    }
+
+    fn wants_block_locs(&self) -> bool {
+        true
+    }
+
+    fn on_span_start(&self, _phase: Phase, name: &str) {
+        self.start(name);
+    }
+
+    fn on_span_end(&self, phase: Phase, name: &str, locs: &dyn Fn() -> Vec<String>) {
+        self.end(phase, name, locs);
+    }
+}
+
 /// Macro for emitting events through a stage context's observer.
 ///
 /// This provides a convenient way to emit events with proper
@@ -293,6 +407,37 @@ mod tests {
         assert_eq!(EventLevel::Warn.as_str(), "warn");
     }
 
+    #[test]
+    fn test_trace_observer_records_nested_spans() {
+        let observer = TraceObserver::new();
+        observer.on_stage_start("ast-transforms", 0, 1);
+        observer.on_span_start(Phase::Transform, "callouts");
+        observer.on_span_end(Phase::Transform, "callouts", &|| {
+            vec!["0:3:1-5:1".to_string()]
+        });
+        observer.on_stage_complete("ast-transforms", 0, 1);
+
+        let trace = observer.take_trace("doc.qmd");
+        assert_eq!(trace.input, "doc.qmd");
+        assert_eq!(trace.spans.len(), 2);
+        assert_eq!(trace.spans[0].name, "callouts");
+        assert_eq!(trace.spans[0].locs, vec!["0:3:1-5:1"]);
+        assert_eq!(trace.spans[1].name, "ast-transforms");
+        assert_eq!(trace.spans[1].phase, Phase::Transform);
+        assert!(trace.spans[1].duration_us >= trace.spans[0].duration_us);
+
+        // The trace was taken
+        assert!(observer.take_trace("doc.qmd").spans.is_empty());
+    }
+
+    #[test]
+    fn test_stage_phases() {
+        assert_eq!(stage_phase("parse-document"), Phase::Read);
+        assert_eq!(stage_phase("engine-execution"), Phase::Execute);
+        assert_eq!(stage_phase("ast-transforms"), Phase::Transform);
+        assert_eq!(stage_phase("apply-template"), Phase::Write);
+    }
+
     #[test]
     fn test_tracing_observer_creation() {
         // Just test that it can be created
@@ -107,12 +107,14 @@ impl PipelineStage for AstTransformsStage {
         // Merge project config with document metadata.
         // Project format_config provides defaults that document metadata can override.
         // This enables WASM to inject settings like `format.html.source-location: full`.
-        if let Some(format_config) = ctx
-            .project
-            .config
-            .as_ref()
-            .and_then(|c| c.format_config.as_ref())
-        {
+        // A document discovered on disk has its own config, with the
+        // `_metadata.yml` of its directories merged in.
+        if let Some(format_config) = ctx.document.config.as_ref().or_else(|| {
+            ctx.project
+                .config
+                .as_ref()
+                .and_then(|c| c.format_config.as_ref())
+        }) {
             // MergedConfig: later layers (document) override earlier layers (project)
             let merged = MergedConfig::new(vec![format_config, &doc.ast.meta]);
             if let Ok(materialized) = merged.materialize() {
@@ -146,8 +148,13 @@ impl PipelineStage for AstTransformsStage {
         // Transfer artifacts to the RenderContext
         render_ctx.artifacts = std::mem::take(&mut ctx.artifacts);
 
-        // Execute the transform pipeline
-        let result = self.pipeline.execute(&mut doc.ast, &mut render_ctx);
+        // Execute the transform pipeline, with a span for each transform
+        let result = self.pipeline.execute_observed(
+            &mut doc.ast,
+            &mut render_ctx,
+            ctx.observer.as_ref(),
+            &doc.ast_context,
+        );
 
         // Transfer artifacts back to StageContext
         ctx.artifacts = render_ctx.artifacts;
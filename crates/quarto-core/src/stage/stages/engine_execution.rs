@@ -15,9 +15,19 @@
 //! 4. Parsing the result back to AST
 //! 5. Reconciling source locations between original and executed ASTs
 //!
+//! Inline cells (`` `{python} x` ``) that the engine evaluated come back
+//! as the inline cells they were, with their result as output, so that
+//! the code of a cell is kept.
+//!
 //! For the "markdown" engine (the default), this is a no-op that passes
 //! through the AST unchanged.
 //!
+//! Code is only executed when [`StageContext::execute`] is set (`quarto
+//! render --execute`) and the document doesn't turn execution off with
+//! `execute: { enabled: false }`. Otherwise the cells are rendered as code.
+//! A document that declares no engine uses the one its cells call for:
+//! `{python}` cells run on jupyter, `{r}` cells on knitr.
+//!
 //! # WASM Behavior
 //!
 //! In WASM builds, only the markdown engine is available. Requests for
@@ -25,11 +35,18 @@
 //! to markdown.
 
 use async_trait::async_trait;
+use std::path::Path;
 use std::sync::Arc;
 
+use pampa::pandoc::{ASTContext, Pandoc};
+use pampa::trace::{Phase, changed_block_locs};
+
 use quarto_error_reporting::DiagnosticMessage;
 
-use crate::engine::{EngineRegistry, ExecutionContext, ExecutionEngine, detect_engine};
+use crate::engine::{
+    EngineRegistry, ExecutionContext, ExecutionEngine, detect_engine_for_document,
+    restore_inline_cells,
+};
 use crate::stage::{
     DocumentAst, EventLevel, PipelineData, PipelineDataKind, PipelineError, PipelineStage,
     StageContext,
@@ -118,6 +135,45 @@ impl EngineExecutionStage {
     }
 }
 
+impl EngineExecutionStage {
+    /// Execute the engine on the document's QMD (step 6) and read its
+    /// output back (step 7).
+    fn execute_and_read(
+        &self,
+        engine: &dyn ExecutionEngine,
+        qmd: &str,
+        exec_context: &ExecutionContext,
+        path: &Path,
+        ctx: &StageContext,
+    ) -> Result<(Pandoc, ASTContext, Vec<DiagnosticMessage>), PipelineError> {
+        trace_event!(ctx, EventLevel::Info, "executing engine: {}", engine.name());
+
+        let result = engine
+            .execute(qmd, exec_context)
+            .map_err(|e| PipelineError::stage_error(self.name(), e.to_string()))?;
+
+        trace_event!(
+            ctx,
+            EventLevel::Debug,
+            "engine produced {} bytes of markdown",
+            result.markdown.len()
+        );
+
+        let source_name = path.display().to_string();
+        pampa::readers::qmd::read(
+            result.markdown.as_bytes(),
+            false,        // loose mode
+            &source_name, // filename for error messages
+            &mut std::io::sink(),
+            true, // track source locations
+            None, // file_id
+        )
+        .map_err(|diagnostics| {
+            PipelineError::stage_error_with_diagnostics(self.name(), diagnostics)
+        })
+    }
+}
+
 impl Default for EngineExecutionStage {
     fn default() -> Self {
         Self::new()
@@ -151,8 +207,19 @@ impl PipelineStage for EngineExecutionStage {
             ));
         };
 
-        // Step 1: Detect engine from metadata
-        let detected = detect_engine(&doc_ast.ast.meta);
+        // Execution is opt-in (`--execute`), and a document can turn it off
+        if !ctx.execute || !execution_enabled(&doc_ast.ast.meta) {
+            trace_event!(
+                ctx,
+                EventLevel::Debug,
+                "execution disabled - passing through unchanged"
+            );
+            return Ok(PipelineData::DocumentAst(doc_ast));
+        }
+
+        // Step 1: Detect engine from metadata and code cells
+        let detected =
+            detect_engine_for_document(&doc_ast.ast.meta, &doc_ast.ast.blocks, &self.registry);
 
         trace_event!(
             ctx,
@@ -210,35 +277,29 @@ impl PipelineStage for EngineExecutionStage {
         } else {
             Some(ctx.project.dir.clone())
         })
-        .with_engine_config(detected.config.clone());
-
-        // Step 6: Execute the engine
-        trace_event!(ctx, EventLevel::Info, "executing engine: {}", engine.name());
-
-        let result = engine
-            .execute(&qmd, &exec_context)
-            .map_err(|e| PipelineError::stage_error(self.name(), e.to_string()))?;
-
-        trace_event!(
-            ctx,
-            EventLevel::Debug,
-            "engine produced {} bytes of markdown",
-            result.markdown.len()
+        .with_engine_config(detected.config.clone())
+        .with_cache(ctx.cache);
+
+        // Steps 6 and 7 are the execute span, which has the blocks whose
+        // output changed
+        let original_ast = ctx.observer.wants_block_locs().then(|| doc_ast.ast.clone());
+        ctx.observer.on_span_start(Phase::Execute, engine.name());
+        let executed =
+            self.execute_and_read(engine.as_ref(), &qmd, &exec_context, &doc_ast.path, ctx);
+        ctx.observer.on_span_end(
+            Phase::Execute,
+            engine.name(),
+            &|| match (&original_ast, &executed) {
+                (Some(before), Ok((after, after_context, _))) => {
+                    changed_block_locs(before, &doc_ast.ast_context, after, after_context)
+                }
+                _ => Vec::new(),
+            },
         );
+        let (mut executed_ast, new_ast_context, parse_warnings) = executed?;
 
-        // Step 7: Parse the executed markdown back to AST
-        let source_name = doc_ast.path.display().to_string();
-        let (executed_ast, new_ast_context, parse_warnings) = pampa::readers::qmd::read(
-            result.markdown.as_bytes(),
-            false,        // loose mode
-            &source_name, // filename for error messages
-            &mut std::io::sink(),
-            true, // track source locations
-            None, // file_id
-        )
-        .map_err(|diagnostics| {
-            PipelineError::stage_error_with_diagnostics(self.name(), diagnostics)
-        })?;
+        // Evaluated inline cells come back as marked spans
+        restore_inline_cells(&mut executed_ast);
 
         // Step 8: Reconcile source locations
         // For content that hasn't changed, preserve original source locations.
@@ -271,6 +332,14 @@ impl PipelineStage for EngineExecutionStage {
     }
 }
 
+/// Whether the document allows execution: `execute: { enabled: false }`
+/// turns it off.
+fn execution_enabled(meta: &quarto_pandoc_types::ConfigValue) -> bool {
+    meta.get_path(&["execute", "enabled"])
+        .and_then(|enabled| enabled.as_bool())
+        .unwrap_or(true)
+}
+
 /// Serialize a Pandoc AST to QMD text.
 ///
 /// This produces QMD that can be fed to execution engines.
@@ -529,7 +598,7 @@ mod tests {
     #[tokio::test]
     async fn test_unknown_engine_falls_back() {
         let stage = EngineExecutionStage::new();
-        let mut ctx = make_test_context();
+        let mut ctx = make_test_context().with_execute(true);
 
         // Unknown engine should fall back to markdown with warning
         let content = b"---\ntitle: Test\nengine: unknown-engine\n---\n\n# Hello";
@@ -546,6 +615,36 @@ mod tests {
         assert!(ctx.diagnostics[0].title.contains("not available"));
     }
 
+    #[tokio::test]
+    async fn test_no_execution_without_execute() {
+        let stage = EngineExecutionStage::new();
+        let mut ctx = make_test_context();
+
+        // Without --execute the engine isn't even looked up
+        let content = b"---\ntitle: Test\nengine: unknown-engine\n---\n\n# Hello";
+        let doc_ast = parse_qmd_to_ast(content, "/project/test.qmd");
+
+        let input = PipelineData::DocumentAst(doc_ast);
+        let output = stage.run(input, &mut ctx).await.unwrap();
+
+        assert!(output.into_document_ast().is_some());
+        assert!(ctx.diagnostics.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_execution_disabled_in_metadata() {
+        let stage = EngineExecutionStage::new();
+        let mut ctx = make_test_context().with_execute(true);
+
+        let content = b"---\nengine: unknown-engine\nexecute:\n  enabled: false\n---\n\n# Hello";
+        let doc_ast = parse_qmd_to_ast(content, "/project/test.qmd");
+
+        let input = PipelineData::DocumentAst(doc_ast);
+        stage.run(input, &mut ctx).await.unwrap();
+
+        assert!(ctx.diagnostics.is_empty());
+    }
+
     #[tokio::test]
     async fn test_wrong_input_type() {
         let stage = EngineExecutionStage::new();
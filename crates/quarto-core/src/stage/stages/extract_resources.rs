@@ -0,0 +1,552 @@
+/*
+ * stage/stages/extract_resources.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Copy the local resources of the document into the output.
+ */
+
+//! Copy the local resources of the document into the output.
+//!
+//! This stage finds the local files the document refers to, stores copies
+//! of them with content-hashed names under `_resources/`, and rewrites the
+//! document's URLs to point at the copies. See
+//! [`resource_manifest`](crate::resource_manifest) for the naming and the
+//! manifest.
+
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+use async_trait::async_trait;
+use quarto_error_reporting::{DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_pandoc_types::{ConfigValue, ConfigValueKind};
+use quarto_source_map::{SourceContext, SourceInfo};
+use quarto_system_runtime::SystemRuntime;
+
+use crate::artifact::Artifact;
+use crate::resource_manifest::{
+    RESOURCE_ARTIFACT_PREFIX, RESOURCE_DIR, RESOURCE_HREF_METADATA, RESOURCE_MANIFEST_ARTIFACT,
+    Resource, ResourceManifest, content_type, digest, hashed_file_name, is_page_path, local_path,
+    normalize_path, relative_url, resource_root, slash_path,
+};
+use crate::stage::{
+    EventLevel, PipelineData, PipelineDataKind, PipelineError, PipelineStage, StageContext,
+};
+use crate::template::inlines_to_text;
+use crate::trace_event;
+use crate::transforms::{ResourceRef, visit_resource_urls};
+
+/// Copy the local resources of the document into the output.
+///
+/// This stage:
+/// 1. Finds the local images, links to files other than pages, and `css`
+///    stylesheets of the document
+/// 2. Reads each file and stores it as a `resource:_resources/<name>`
+///    artifact, with the path the copy is written to
+/// 3. Rewrites the URLs to the copies, relative to the page
+/// 4. Stores the [`ResourceManifest`] of the copies as the
+///    `resource-manifest` artifact
+///
+/// Relative URLs resolve against the directory of the file they were
+/// written in, and URLs starting with `/` against the project directory.
+/// A stylesheet not found next to the document is looked for in the
+/// project directory, where `_quarto.yml` names it. A file that can't be
+/// read produces a warning and keeps its URL, and so does a path outside
+/// the project directory, which is never read.
+///
+/// An untrusted document (`ctx.sanitize`) reads no files: its URLs are
+/// left as they are.
+///
+/// # Input
+///
+/// - `DocumentAst` - Transformed Pandoc AST
+///
+/// # Output
+///
+/// - `DocumentAst` - The AST with URLs to the copies
+pub struct ExtractResourcesStage;
+
+impl ExtractResourcesStage {
+    /// Create a new ExtractResourcesStage.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for ExtractResourcesStage {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[async_trait]
+impl PipelineStage for ExtractResourcesStage {
+    fn name(&self) -> &str {
+        "extract-resources"
+    }
+
+    fn input_kind(&self) -> PipelineDataKind {
+        PipelineDataKind::DocumentAst
+    }
+
+    fn output_kind(&self) -> PipelineDataKind {
+        PipelineDataKind::DocumentAst
+    }
+
+    async fn run(
+        &self,
+        input: PipelineData,
+        ctx: &mut StageContext,
+    ) -> Result<PipelineData, PipelineError> {
+        let PipelineData::DocumentAst(mut doc) = input else {
+            return Err(PipelineError::unexpected_input(
+                self.name(),
+                self.input_kind(),
+                input.kind(),
+            ));
+        };
+
+        if ctx.sanitize.is_some() {
+            trace_event!(
+                ctx,
+                EventLevel::Debug,
+                "untrusted document: not reading local resources"
+            );
+            return Ok(PipelineData::DocumentAst(doc));
+        }
+
+        let output_path = normalize_path(&ctx.output_path());
+        let root = resource_root(&output_path, &normalize_path(&ctx.project.output_dir));
+        let page = output_path
+            .strip_prefix(&root)
+            .unwrap_or(&output_path)
+            .to_path_buf();
+        let doc_dir = doc.path.parent().unwrap_or(Path::new("")).to_path_buf();
+        let mut extractor = ResourceExtractor {
+            runtime: ctx.runtime.as_ref(),
+            source_context: &doc.source_context,
+            project_dir: normalize_path(&ctx.project.dir),
+            doc_dir: normalize_path(&doc_dir),
+            root: &root,
+            page_dir: page.parent().unwrap_or(Path::new("")).to_path_buf(),
+            page: slash_path(&page),
+            copies: HashMap::new(),
+            manifest: ResourceManifest::new(),
+            artifacts: Vec::new(),
+            diagnostics: Vec::new(),
+        };
+
+        visit_resource_urls(&mut doc.ast.blocks, &mut |kind, url, source_info| {
+            extractor.rewrite_url(kind, url, source_info);
+        });
+        if let ConfigValueKind::Map(entries) = &mut doc.ast.meta.value {
+            for entry in entries.iter_mut().filter(|e| e.key == "css") {
+                extractor.rewrite_css(&mut entry.value);
+            }
+        }
+
+        let ResourceExtractor {
+            manifest,
+            artifacts,
+            diagnostics,
+            ..
+        } = extractor;
+        trace_event!(
+            ctx,
+            EventLevel::Debug,
+            "copied {} resource(s) into {}",
+            manifest.resources.len(),
+            RESOURCE_DIR
+        );
+        for (key, artifact) in artifacts {
+            ctx.artifacts.store(key, artifact);
+        }
+        let json = serde_json::to_vec(&manifest)
+            .map_err(|e| PipelineError::stage_error(self.name(), e.to_string()))?;
+        ctx.artifacts.store(
+            RESOURCE_MANIFEST_ARTIFACT,
+            Artifact::from_bytes(json, "application/json"),
+        );
+        ctx.add_diagnostics(diagnostics);
+
+        Ok(PipelineData::DocumentAst(doc))
+    }
+}
+
+/// Finds, reads and rewrites the resources of one page.
+struct ResourceExtractor<'a> {
+    runtime: &'a dyn SystemRuntime,
+    source_context: &'a SourceContext,
+    project_dir: PathBuf,
+    doc_dir: PathBuf,
+    /// The directory `_resources` is in
+    root: &'a Path,
+    /// The page's directory, relative to `root`
+    page_dir: PathBuf,
+    /// The page, relative to `root`
+    page: String,
+    /// The copy of each file read, relative to `root`: None for files that
+    /// couldn't be read
+    copies: HashMap<PathBuf, Option<PathBuf>>,
+    manifest: ResourceManifest,
+    artifacts: Vec<(String, Artifact)>,
+    diagnostics: Vec<DiagnosticMessage>,
+}
+
+impl ResourceExtractor<'_> {
+    fn rewrite_url(&mut self, kind: ResourceRef, url: &mut String, source_info: &SourceInfo) {
+        let Some((path, suffix)) = local_path(url) else {
+            return;
+        };
+        if kind == ResourceRef::Link && is_page_path(path) {
+            return;
+        }
+        let base_dir = self.base_dir(source_info);
+        let Some(source) = self.resolve(&base_dir, path) else {
+            self.outside_project(path, source_info);
+            return;
+        };
+        if let Some(href) = self.copy(&[source], path, source_info) {
+            *url = format!("{}{}", href, suffix);
+        }
+    }
+
+    /// Rewrite the stylesheets of the `css` metadata: a path, or a list
+    /// of them.
+    fn rewrite_css(&mut self, value: &mut ConfigValue) {
+        if let ConfigValueKind::Array(items) = &mut value.value {
+            for item in items {
+                self.rewrite_css(item);
+            }
+            return;
+        }
+        let url = match &value.value {
+            ConfigValueKind::PandocInlines(content) => inlines_to_text(content),
+            _ => match value.as_str() {
+                Some(s) => s.to_string(),
+                None => return,
+            },
+        };
+        let Some((path, suffix)) = local_path(&url) else {
+            return;
+        };
+        let candidates: Vec<PathBuf> = [&self.doc_dir, &self.project_dir]
+            .into_iter()
+            .filter_map(|base_dir| self.resolve(base_dir, path))
+            .collect();
+        if candidates.is_empty() {
+            self.outside_project(path, &value.source_info);
+            return;
+        }
+        if let Some(href) = self.copy(&candidates, path, &value.source_info) {
+            *value =
+                ConfigValue::new_string(format!("{}{}", href, suffix), value.source_info.clone());
+        }
+    }
+
+    /// The directory of the file an element was written in: the document,
+    /// or a file it includes.
+    fn base_dir(&self, source_info: &SourceInfo) -> PathBuf {
+        source_info
+            .map_offset(0, self.source_context)
+            .and_then(|mapped| self.source_context.get_file(mapped.file_id))
+            .and_then(|file| Path::new(&file.path).parent().map(normalize_path))
+            .unwrap_or_else(|| self.doc_dir.clone())
+    }
+
+    /// The file a path names, or None when it is outside the project
+    /// directory: `../` can't climb out of the project.
+    fn resolve(&self, base_dir: &Path, path: &str) -> Option<PathBuf> {
+        let source = match path.strip_prefix('/') {
+            Some(rooted) => normalize_path(&self.project_dir.join(rooted)),
+            None => normalize_path(&base_dir.join(path)),
+        };
+        source.starts_with(&self.project_dir).then_some(source)
+    }
+
+    fn outside_project(&mut self, path: &str, source_info: &SourceInfo) {
+        self.diagnostics.push(
+            DiagnosticMessageBuilder::warning("Resource Outside Project")
+                .with_code("Q-2-50")
+                .with_location(source_info.clone())
+                .problem(format!(
+                    "`{}` is outside the project directory, and was not copied",
+                    path
+                ))
+                .add_hint("Move the file into the project, or refer to it by URL")
+                .build(),
+        );
+    }
+
+    /// Copy the first of `candidates` that can be read, and return the URL
+    /// of the copy from the page. None, with a warning, when none can.
+    fn copy(
+        &mut self,
+        candidates: &[PathBuf],
+        path: &str,
+        source_info: &SourceInfo,
+    ) -> Option<String> {
+        for source in candidates {
+            let output = match self.copies.get(source) {
+                Some(Some(output)) => output.clone(),
+                Some(None) => continue,
+                None => match self.runtime.file_read(source) {
+                    Ok(content) => self.store(source, content),
+                    Err(_) => {
+                        self.copies.insert(source.clone(), None);
+                        continue;
+                    }
+                },
+            };
+            return Some(relative_url(&self.page_dir, &output));
+        }
+        self.diagnostics.push(
+            DiagnosticMessageBuilder::warning("Missing Resource")
+                .with_code("Q-2-41")
+                .with_location(source_info.clone())
+                .problem(format!("`{}` could not be read", path))
+                .add_detail(format!("Looked for `{}`", candidates[0].display()))
+                .add_hint("Relative paths are relative to the file they are written in")
+                .build(),
+        );
+        None
+    }
+
+    /// Store a copy of `source`, and return the path of the copy
+    fn store(&mut self, source: &Path, content: Vec<u8>) -> PathBuf {
+        let digest = digest(&content);
+        let output = Path::new(RESOURCE_DIR).join(hashed_file_name(source, &digest));
+        let content_type = content_type(source);
+        // Sources are in the project directory (see `resolve`)
+        self.manifest.add(Resource {
+            source: slash_path(source.strip_prefix(&self.project_dir).unwrap_or(source)),
+            output: slash_path(&output),
+            digest,
+            size: content.len() as u64,
+            content_type: content_type.to_string(),
+            pages: vec![self.page.clone()],
+        });
+        self.artifacts.push((
+            format!("{}{}", RESOURCE_ARTIFACT_PREFIX, slash_path(&output)),
+            Artifact::from_bytes(content, content_type)
+                .with_path(self.root.join(&output))
+                .with_metadata(
+                    RESOURCE_HREF_METADATA,
+                    serde_json::json!(relative_url(&self.page_dir, &output)),
+                ),
+        ));
+        self.copies
+            .insert(source.to_path_buf(), Some(output.clone()));
+        output
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::stage::DocumentAst;
+    use quarto_pandoc_types::block::Block;
+    use quarto_system_runtime::NativeRuntime;
+    use std::sync::Arc;
+
+    /// Run the stage on `qmd`, read from `input` in the project `dir`
+    async fn extract(
+        dir: &Path,
+        output_dir: &Path,
+        input: &str,
+        qmd: &str,
+    ) -> (DocumentAst, StageContext) {
+        extract_with_sanitize(dir, output_dir, input, qmd, None).await
+    }
+
+    async fn extract_with_sanitize(
+        dir: &Path,
+        output_dir: &Path,
+        input: &str,
+        qmd: &str,
+        sanitize: Option<pampa::transforms::SanitizeOptions>,
+    ) -> (DocumentAst, StageContext) {
+        let path = dir.join(input);
+        let name = path.to_string_lossy().to_string();
+        let Ok((ast, ast_context, _)) = pampa::readers::qmd::read(
+            qmd.as_bytes(),
+            false,
+            &name,
+            &mut std::io::sink(),
+            true,
+            None,
+        ) else {
+            panic!("failed to parse {}", qmd);
+        };
+        let mut source_context = SourceContext::new();
+        source_context.add_file(name, Some(qmd.to_string()));
+        let project = ProjectContext {
+            dir: dir.to_path_buf(),
+            config: None,
+            is_single_file: false,
+            files: vec![],
+            output_dir: output_dir.to_path_buf(),
+        };
+        let mut ctx = StageContext::new(
+            Arc::new(NativeRuntime::new()),
+            Format::html(),
+            project,
+            DocumentInfo::from_path(&path),
+        )
+        .unwrap()
+        .with_sanitize(sanitize);
+        let doc = DocumentAst {
+            path,
+            ast,
+            ast_context,
+            source_context,
+            warnings: vec![],
+        };
+        let output = ExtractResourcesStage::new()
+            .run(PipelineData::DocumentAst(doc), &mut ctx)
+            .await
+            .unwrap();
+        (output.into_document_ast().unwrap(), ctx)
+    }
+
+    fn urls(blocks: &mut [Block]) -> Vec<String> {
+        let mut urls = Vec::new();
+        visit_resource_urls(blocks, &mut |_kind, url, _source_info| {
+            urls.push(url.clone())
+        });
+        urls
+    }
+
+    fn write(path: &Path, content: &[u8]) {
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, content).unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_copies_and_rewrites_resources() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        write(&dir.join("images/plot.png"), b"png");
+        write(&dir.join("guide/data.csv"), b"a,b\n");
+        let output_dir = dir.join("_site");
+
+        let qmd = "![](../images/plot.png)\n\n[data](data.csv#row-2)\n\n\
+                   [next](other.qmd) [web](https://example.com/a.png)\n\n\
+                   ![](../images/plot.png)\n";
+        let (mut doc, ctx) = extract(dir, &output_dir, "guide/setup.qmd", qmd).await;
+
+        let plot = format!("plot-{}.png", &digest(b"png")[7..15]);
+        let data = format!("data-{}.csv", &digest(b"a,b\n")[7..15]);
+        assert_eq!(
+            urls(&mut doc.ast.blocks),
+            vec![
+                format!("../_resources/{}", plot),
+                format!("../_resources/{}#row-2", data),
+                "other.qmd".to_string(),
+                "https://example.com/a.png".to_string(),
+                format!("../_resources/{}", plot),
+            ]
+        );
+
+        let artifact = ctx
+            .artifacts
+            .get(&format!("resource:_resources/{}", plot))
+            .unwrap();
+        assert_eq!(artifact.content, b"png");
+        assert_eq!(artifact.content_type, "image/png");
+        assert_eq!(
+            artifact.path.as_deref(),
+            Some(output_dir.join("_resources").join(&plot).as_path())
+        );
+
+        let manifest = ResourceManifest::from_artifacts(&ctx.artifacts).unwrap();
+        assert_eq!(manifest.resources.len(), 2);
+        let resource = manifest.get("images/plot.png").unwrap();
+        assert_eq!(resource.output, format!("_resources/{}", plot));
+        assert_eq!(resource.pages, vec!["guide/setup.html"]);
+        assert!(ctx.diagnostics.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_missing_resource_keeps_url() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+
+        let (mut doc, ctx) = extract(dir, dir, "index.qmd", "![](missing.png)\n").await;
+
+        assert_eq!(urls(&mut doc.ast.blocks), vec!["missing.png"]);
+        assert_eq!(ctx.diagnostics.len(), 1);
+        assert_eq!(ctx.diagnostics[0].code.as_deref(), Some("Q-2-41"));
+        let manifest = ResourceManifest::from_artifacts(&ctx.artifacts).unwrap();
+        assert!(manifest.resources.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_resources_outside_project_are_not_read() {
+        let temp = tempfile::tempdir().unwrap();
+        let outside = temp.path();
+        let dir = outside.join("project");
+        write(&outside.join("secret.txt"), b"secret");
+
+        let qmd = "![](../secret.txt)\n\n[key](docs/../../secret.txt)\n";
+        let (mut doc, ctx) = extract(&dir, &dir, "index.qmd", qmd).await;
+
+        assert_eq!(
+            urls(&mut doc.ast.blocks),
+            vec!["../secret.txt", "docs/../../secret.txt"]
+        );
+        let codes: Vec<_> = ctx.diagnostics.iter().map(|d| d.code.as_deref()).collect();
+        assert_eq!(codes, vec![Some("Q-2-50"), Some("Q-2-50")]);
+        let manifest = ResourceManifest::from_artifacts(&ctx.artifacts).unwrap();
+        assert!(manifest.resources.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_rewrites_css_metadata() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        // Named in _quarto.yml, so relative to the project
+        write(&dir.join("styles/site.css"), b"body {}");
+        write(&dir.join("docs/local.css"), b"p {}");
+
+        let qmd = "---\ncss:\n  - styles/site.css\n  - local.css\n---\n\nText.\n";
+        let (doc, _ctx) = extract(dir, dir, "docs/page.qmd", qmd).await;
+
+        let ConfigValueKind::Map(entries) = &doc.ast.meta.value else {
+            panic!("metadata is a map");
+        };
+        let css = entries.iter().find(|e| e.key == "css").unwrap();
+        let ConfigValueKind::Array(items) = &css.value.value else {
+            panic!("css is a list");
+        };
+        let items: Vec<&str> = items.iter().map(|item| item.as_str().unwrap()).collect();
+        assert_eq!(
+            items,
+            vec![
+                format!("../_resources/site-{}.css", &digest(b"body {}")[7..15]),
+                format!("../_resources/local-{}.css", &digest(b"p {}")[7..15]),
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_untrusted_document_reads_no_resources() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        write(&dir.join("plot.png"), b"png");
+
+        let (mut doc, ctx) = extract_with_sanitize(
+            dir,
+            dir,
+            "index.qmd",
+            "![](plot.png)\n",
+            Some(Default::default()),
+        )
+        .await;
+
+        assert_eq!(urls(&mut doc.ast.blocks), vec!["plot.png"]);
+        assert!(ctx.artifacts.get(RESOURCE_MANIFEST_ARTIFACT).is_none());
+        assert!(ctx.diagnostics.is_empty());
+    }
+}
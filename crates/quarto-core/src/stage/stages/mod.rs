@@ -13,17 +13,20 @@
 //! - [`ParseDocumentStage`] - Parse QMD content to Pandoc AST
 //! - [`EngineExecutionStage`] - Execute code cells via knitr/jupyter/markdown
 //! - [`AstTransformsStage`] - Apply Quarto-specific AST transforms
+//! - [`ExtractResourcesStage`] - Copy local resources into the output and rewrite their URLs
 //! - [`RenderHtmlBodyStage`] - Render AST to HTML body
 //! - [`ApplyTemplateStage`] - Apply HTML template to rendered body
 
 mod apply_template;
 mod ast_transforms;
 mod engine_execution;
+mod extract_resources;
 mod parse_document;
 mod render_html;
 
 pub use apply_template::{ApplyTemplateConfig, ApplyTemplateStage};
 pub use ast_transforms::AstTransformsStage;
 pub use engine_execution::EngineExecutionStage;
+pub use extract_resources::ExtractResourcesStage;
 pub use parse_document::ParseDocumentStage;
 pub use render_html::RenderHtmlBodyStage;
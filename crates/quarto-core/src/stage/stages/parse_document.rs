@@ -25,7 +25,8 @@ use crate::trace_event;
 /// 1. Takes raw source content (LoadedSource)
 /// 2. Creates a SourceContext for error reporting
 /// 3. Parses the content using pampa
-/// 4. Returns a DocumentAst with the parsed AST and warnings
+/// 4. Sanitizes the AST if the document is untrusted (`ctx.sanitize`)
+/// 5. Returns a DocumentAst with the parsed AST and warnings
 ///
 /// # Input
 ///
@@ -37,7 +38,8 @@ use crate::trace_event;
 ///
 /// # Errors
 ///
-/// Returns an error if parsing fails.
+/// Returns an error if parsing fails, or if an untrusted document is
+/// larger than its limit.
 pub struct ParseDocumentStage;
 
 impl ParseDocumentStage {
@@ -88,6 +90,15 @@ impl PipelineStage for ParseDocumentStage {
             source.path
         );
 
+        if let Some(sanitize) = &ctx.sanitize
+            && let Err(diagnostic) = pampa::transforms::check_input_size(&source.content, sanitize)
+        {
+            return Err(PipelineError::stage_error_with_diagnostics(
+                self.name(),
+                vec![diagnostic],
+            ));
+        }
+
         // Create SourceContext for error reporting and location mapping.
         // This contains the file content needed for ariadne to show source snippets.
         let mut source_context = SourceContext::new();
@@ -107,7 +118,18 @@ impl PipelineStage for ParseDocumentStage {
         );
 
         match parse_result {
-            Ok((ast, ast_context, warnings)) => {
+            Ok((mut ast, ast_context, mut warnings)) => {
+                if let Some(sanitize) = &ctx.sanitize {
+                    let report = pampa::transforms::sanitize(&mut ast, sanitize);
+                    trace_event!(
+                        ctx,
+                        EventLevel::Debug,
+                        "sanitizing removed {} items",
+                        report.removed.len()
+                    );
+                    warnings.extend(report.diagnostics());
+                }
+
                 // Log any warnings
                 if !warnings.is_empty() {
                     trace_event!(
@@ -145,159 +167,150 @@ mod tests {
     use crate::stage::LoadedSource;
     use std::path::PathBuf;
 
-    #[tokio::test]
-    async fn test_parse_simple_document() {
-        use crate::format::Format;
-        use crate::project::{DocumentInfo, ProjectContext};
-        use crate::stage::StageContext;
-        use quarto_system_runtime::TempDir;
-        use std::sync::Arc;
-
-        // Create a mock runtime
-        struct MockRuntime;
-
-        impl quarto_system_runtime::SystemRuntime for MockRuntime {
-            fn file_read(
-                &self,
-                _path: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<Vec<u8>> {
-                Ok(vec![])
-            }
-            fn file_write(
-                &self,
-                _path: &std::path::Path,
-                _contents: &[u8],
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn path_exists(
-                &self,
-                _path: &std::path::Path,
-                _kind: Option<quarto_system_runtime::PathKind>,
-            ) -> quarto_system_runtime::RuntimeResult<bool> {
-                Ok(true)
-            }
-            fn canonicalize(
-                &self,
-                path: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<PathBuf> {
-                Ok(path.to_path_buf())
-            }
-            fn path_metadata(
-                &self,
-                _path: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<quarto_system_runtime::PathMetadata>
-            {
-                unimplemented!()
-            }
-            fn file_copy(
-                &self,
-                _src: &std::path::Path,
-                _dst: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn path_rename(
-                &self,
-                _old: &std::path::Path,
-                _new: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn file_remove(
-                &self,
-                _path: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn dir_create(
-                &self,
-                _path: &std::path::Path,
-                _recursive: bool,
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn dir_remove(
-                &self,
-                _path: &std::path::Path,
-                _recursive: bool,
-            ) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn dir_list(
-                &self,
-                _path: &std::path::Path,
-            ) -> quarto_system_runtime::RuntimeResult<Vec<PathBuf>> {
-                Ok(vec![])
-            }
-            fn cwd(&self) -> quarto_system_runtime::RuntimeResult<PathBuf> {
-                Ok(PathBuf::from("/"))
-            }
-            fn temp_dir(&self, _template: &str) -> quarto_system_runtime::RuntimeResult<TempDir> {
-                Ok(TempDir::new(PathBuf::from("/tmp/test")))
-            }
-            fn exec_pipe(
-                &self,
-                _command: &str,
-                _args: &[&str],
-                _stdin: &[u8],
-            ) -> quarto_system_runtime::RuntimeResult<Vec<u8>> {
-                Ok(vec![])
-            }
-            fn exec_command(
-                &self,
-                _command: &str,
-                _args: &[&str],
-                _stdin: Option<&[u8]>,
-            ) -> quarto_system_runtime::RuntimeResult<quarto_system_runtime::CommandOutput>
-            {
-                Ok(quarto_system_runtime::CommandOutput {
-                    code: 0,
-                    stdout: vec![],
-                    stderr: vec![],
-                })
-            }
-            fn env_get(&self, _name: &str) -> quarto_system_runtime::RuntimeResult<Option<String>> {
-                Ok(None)
-            }
-            fn env_all(
-                &self,
-            ) -> quarto_system_runtime::RuntimeResult<std::collections::HashMap<String, String>>
-            {
-                Ok(std::collections::HashMap::new())
-            }
-            fn fetch_url(
-                &self,
-                _url: &str,
-            ) -> quarto_system_runtime::RuntimeResult<(Vec<u8>, String)> {
-                Err(quarto_system_runtime::RuntimeError::NotSupported(
-                    "mock".to_string(),
-                ))
-            }
-            fn os_name(&self) -> &'static str {
-                "mock"
-            }
-            fn arch(&self) -> &'static str {
-                "mock"
-            }
-            fn cpu_time(&self) -> quarto_system_runtime::RuntimeResult<u64> {
-                Ok(0)
-            }
-            fn xdg_dir(
-                &self,
-                _kind: quarto_system_runtime::XdgDirKind,
-                _subpath: Option<&std::path::Path>,
-            ) -> quarto_system_runtime::RuntimeResult<PathBuf> {
-                Ok(PathBuf::from("/xdg"))
-            }
-            fn stdout_write(&self, _data: &[u8]) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
-            fn stderr_write(&self, _data: &[u8]) -> quarto_system_runtime::RuntimeResult<()> {
-                Ok(())
-            }
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::stage::StageContext;
+    use quarto_system_runtime::TempDir;
+    use std::sync::Arc;
+
+    // Create a mock runtime
+    struct MockRuntime;
+
+    impl quarto_system_runtime::SystemRuntime for MockRuntime {
+        fn file_read(
+            &self,
+            _path: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<Vec<u8>> {
+            Ok(vec![])
+        }
+        fn file_write(
+            &self,
+            _path: &std::path::Path,
+            _contents: &[u8],
+        ) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn path_exists(
+            &self,
+            _path: &std::path::Path,
+            _kind: Option<quarto_system_runtime::PathKind>,
+        ) -> quarto_system_runtime::RuntimeResult<bool> {
+            Ok(true)
+        }
+        fn canonicalize(
+            &self,
+            path: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<PathBuf> {
+            Ok(path.to_path_buf())
+        }
+        fn path_metadata(
+            &self,
+            _path: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<quarto_system_runtime::PathMetadata> {
+            unimplemented!()
         }
+        fn file_copy(
+            &self,
+            _src: &std::path::Path,
+            _dst: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn path_rename(
+            &self,
+            _old: &std::path::Path,
+            _new: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn file_remove(&self, _path: &std::path::Path) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn dir_create(
+            &self,
+            _path: &std::path::Path,
+            _recursive: bool,
+        ) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn dir_remove(
+            &self,
+            _path: &std::path::Path,
+            _recursive: bool,
+        ) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn dir_list(
+            &self,
+            _path: &std::path::Path,
+        ) -> quarto_system_runtime::RuntimeResult<Vec<PathBuf>> {
+            Ok(vec![])
+        }
+        fn cwd(&self) -> quarto_system_runtime::RuntimeResult<PathBuf> {
+            Ok(PathBuf::from("/"))
+        }
+        fn temp_dir(&self, _template: &str) -> quarto_system_runtime::RuntimeResult<TempDir> {
+            Ok(TempDir::new(PathBuf::from("/tmp/test")))
+        }
+        fn exec_pipe(
+            &self,
+            _command: &str,
+            _args: &[&str],
+            _stdin: &[u8],
+        ) -> quarto_system_runtime::RuntimeResult<Vec<u8>> {
+            Ok(vec![])
+        }
+        fn exec_command(
+            &self,
+            _command: &str,
+            _args: &[&str],
+            _stdin: Option<&[u8]>,
+        ) -> quarto_system_runtime::RuntimeResult<quarto_system_runtime::CommandOutput> {
+            Ok(quarto_system_runtime::CommandOutput {
+                code: 0,
+                stdout: vec![],
+                stderr: vec![],
+            })
+        }
+        fn env_get(&self, _name: &str) -> quarto_system_runtime::RuntimeResult<Option<String>> {
+            Ok(None)
+        }
+        fn env_all(
+            &self,
+        ) -> quarto_system_runtime::RuntimeResult<std::collections::HashMap<String, String>>
+        {
+            Ok(std::collections::HashMap::new())
+        }
+        fn fetch_url(&self, _url: &str) -> quarto_system_runtime::RuntimeResult<(Vec<u8>, String)> {
+            Err(quarto_system_runtime::RuntimeError::NotSupported(
+                "mock".to_string(),
+            ))
+        }
+        fn os_name(&self) -> &'static str {
+            "mock"
+        }
+        fn arch(&self) -> &'static str {
+            "mock"
+        }
+        fn cpu_time(&self) -> quarto_system_runtime::RuntimeResult<u64> {
+            Ok(0)
+        }
+        fn xdg_dir(
+            &self,
+            _kind: quarto_system_runtime::XdgDirKind,
+            _subpath: Option<&std::path::Path>,
+        ) -> quarto_system_runtime::RuntimeResult<PathBuf> {
+            Ok(PathBuf::from("/xdg"))
+        }
+        fn stdout_write(&self, _data: &[u8]) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+        fn stderr_write(&self, _data: &[u8]) -> quarto_system_runtime::RuntimeResult<()> {
+            Ok(())
+        }
+    }
 
+    fn make_context() -> StageContext {
         let runtime = Arc::new(MockRuntime);
         let project = ProjectContext {
             dir: PathBuf::from("/project"),
@@ -309,7 +322,12 @@ mod tests {
         let doc = DocumentInfo::from_path("/project/test.qmd");
         let format = Format::html();
 
-        let mut ctx = StageContext::new(runtime, format, project, doc).unwrap();
+        StageContext::new(runtime, format, project, doc).unwrap()
+    }
+
+    #[tokio::test]
+    async fn test_parse_simple_document() {
+        let mut ctx = make_context();
 
         let stage = ParseDocumentStage::new();
 
@@ -324,4 +342,48 @@ mod tests {
         // The AST should have at least one block (the paragraph)
         assert!(!doc_ast.ast.blocks.is_empty());
     }
+
+    #[tokio::test]
+    async fn test_parse_sanitizes_untrusted_document() {
+        let mut ctx = make_context().with_sanitize(Some(Default::default()));
+        let stage = ParseDocumentStage::new();
+
+        let content = b"Hello\n\n```{=html}\n<script>alert(1)</script>\n```\n";
+        let source = LoadedSource::new(PathBuf::from("/project/test.qmd"), content.to_vec());
+
+        let output = stage
+            .run(PipelineData::LoadedSource(source), &mut ctx)
+            .await
+            .unwrap();
+
+        let doc_ast = output.into_document_ast().expect("Should be DocumentAst");
+        assert_eq!(doc_ast.ast.blocks.len(), 1);
+        assert!(
+            doc_ast
+                .warnings
+                .iter()
+                .any(|w| w.code.as_deref() == Some("Q-2-42"))
+        );
+    }
+
+    #[tokio::test]
+    async fn test_parse_rejects_oversized_untrusted_document() {
+        let sanitize = pampa::transforms::SanitizeOptions {
+            max_input_bytes: 8,
+            ..Default::default()
+        };
+        let mut ctx = make_context().with_sanitize(Some(sanitize));
+        let stage = ParseDocumentStage::new();
+
+        let source = LoadedSource::new(
+            PathBuf::from("/project/test.qmd"),
+            b"Hello, world!".to_vec(),
+        );
+        assert!(
+            stage
+                .run(PipelineData::LoadedSource(source), &mut ctx)
+                .await
+                .is_err()
+        );
+    }
 }
@@ -457,7 +457,7 @@ fn config_value_to_template_value(meta: &ConfigValue) -> TemplateValue {
 }
 
 /// Convert inlines to plain text.
-fn inlines_to_text(inlines: &[quarto_pandoc_types::inline::Inline]) -> String {
+pub(crate) fn inlines_to_text(inlines: &[quarto_pandoc_types::inline::Inline]) -> String {
     use quarto_pandoc_types::inline::Inline;
 
     let mut result = String::new();
@@ -48,8 +48,12 @@
 //! pipeline.execute(&mut ast, &mut ctx)?;
 //! ```
 
+use pampa::pandoc::ASTContext;
+use pampa::trace::{Phase, changed_block_locs};
+
 use crate::Result;
 use crate::render::RenderContext;
+use crate::stage::PipelineObserver;
 
 /// Trait for AST transformations.
 ///
@@ -144,6 +148,35 @@ impl TransformPipeline {
         Ok(())
     }
 
+    /// Execute all transforms in insertion order, reporting a span for each
+    /// to `observer`. When the observer wants them, a span has the blocks
+    /// its transform changed, located with `ast_context`.
+    ///
+    /// # Errors
+    ///
+    /// Returns the first error encountered. Execution stops on error.
+    pub fn execute_observed(
+        &self,
+        ast: &mut quarto_pandoc_types::pandoc::Pandoc,
+        ctx: &mut RenderContext,
+        observer: &dyn PipelineObserver,
+        ast_context: &ASTContext,
+    ) -> Result<()> {
+        for transform in &self.transforms {
+            tracing::debug!(transform = transform.name(), "Running transform");
+            let before = observer.wants_block_locs().then(|| ast.clone());
+            observer.on_span_start(Phase::Transform, transform.name());
+            let result = transform.transform(ast, ctx);
+            observer.on_span_end(Phase::Transform, transform.name(), &|| match &before {
+                Some(before) => changed_block_locs(before, ast_context, &*ast, ast_context),
+                None => Vec::new(),
+            });
+            result?;
+        }
+
+        Ok(())
+    }
+
     /// List the names of all transforms in execution order.
     ///
     /// Useful for debugging and logging.
@@ -289,6 +322,40 @@ mod tests {
         assert_eq!(*order.lock().unwrap(), vec![1, 2]);
     }
 
+    #[test]
+    fn test_execute_observed_reports_a_span_per_transform() {
+        let mut pipeline = TransformPipeline::new();
+        let counter = Arc::new(AtomicUsize::new(0));
+        let order = Arc::new(std::sync::Mutex::new(Vec::new()));
+        for (name, my_order) in [("first", 1), ("second", 2)] {
+            pipeline.push(Box::new(CountingTransform {
+                name,
+                counter: counter.clone(),
+                my_order,
+                order_tracker: order.clone(),
+            }));
+        }
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        let mut ast = make_empty_ast();
+        let observer = crate::stage::TraceObserver::new();
+
+        pipeline
+            .execute_observed(&mut ast, &mut ctx, &observer, &ASTContext::new())
+            .unwrap();
+
+        let trace = observer.take_trace("doc.qmd");
+        let names: Vec<&str> = trace.spans.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(names, vec!["first", "second"]);
+        assert!(trace.spans.iter().all(|s| s.phase == Phase::Transform));
+        // The transforms don't change the document
+        assert!(trace.spans.iter().all(|s| s.locs.is_empty()));
+    }
+
     #[test]
     fn test_insertion_order() {
         let mut pipeline = TransformPipeline::new();
@@ -0,0 +1,204 @@
+/*
+ * columns.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Transform that lays out the containers of column content.
+ */
+
+//! Column layout transform.
+//!
+//! Content with a `.column-*` class (`column-margin`, `column-page`,
+//! `column-screen-inset`, ...) is placed with the CSS grid of the page:
+//!
+//! ```markdown
+//! ::: {.column-margin}
+//! A note in the margin.
+//! :::
+//! ```
+//!
+//! The grid only places the children of a `.page-columns` element, so
+//! every Div that column content is in, such as the section Divs of
+//! `SectionizeTransform`, gets the `page-columns` and `page-full` classes,
+//! as TS Quarto does. The column content itself is unchanged.
+//!
+//! ## Pipeline Order
+//!
+//! This transform should run after every transform that adds Divs, so that
+//! the Divs it marks are those that are written.
+
+use quarto_pandoc_types::attr::Attr;
+use quarto_pandoc_types::block::Block;
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks_mut};
+
+use crate::Result;
+use crate::render::RenderContext;
+use crate::transform::AstTransform;
+
+/// Classes that make a Div part of the page grid.
+const GRID_CLASSES: &[&str] = &["page-columns", "page-full"];
+
+/// Transform that puts the Divs containing column content on the page grid.
+pub struct ColumnsTransform;
+
+impl ColumnsTransform {
+    /// Create a new columns transform.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for ColumnsTransform {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AstTransform for ColumnsTransform {
+    fn name(&self) -> &str {
+        "columns"
+    }
+
+    fn transform(&self, ast: &mut Pandoc, _ctx: &mut RenderContext) -> Result<()> {
+        // Bottom-up, so that a Div's children have been marked when it is
+        // visited, and column content deep inside marks every Div around it
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::BottomUp, |block| {
+            if let Block::Div(div) = block
+                && div.content.iter().any(is_on_grid)
+            {
+                let classes = &mut div.attr.1;
+                for class in GRID_CLASSES {
+                    if !classes.iter().any(|c| c == class) {
+                        classes.push(class.to_string());
+                    }
+                }
+            }
+            Control::Continue
+        });
+        Ok(())
+    }
+}
+
+/// Whether a block is placed by the page grid: it is column content, or is
+/// a Div that contains some.
+fn is_on_grid(block: &Block) -> bool {
+    let attr: &Attr = match block {
+        Block::Div(div) => &div.attr,
+        Block::Figure(figure) => &figure.attr,
+        Block::Table(table) => &table.attr,
+        _ => return false,
+    };
+    let (_id, classes, _attrs) = attr;
+    classes
+        .iter()
+        .any(|class| class.starts_with("column-") || class == "page-columns")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_pandoc_types::attr::AttrSourceInfo;
+    use quarto_pandoc_types::block::{Div, Paragraph};
+    use quarto_pandoc_types::inline::{Inline, Str};
+    use quarto_source_map::SourceInfo;
+
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::render::BinaryDependencies;
+
+    fn make_test_project() -> ProjectContext {
+        ProjectContext {
+            dir: std::path::PathBuf::from("/project"),
+            config: None,
+            is_single_file: true,
+            files: vec![DocumentInfo::from_path("/project/doc.qmd")],
+            output_dir: std::path::PathBuf::from("/project"),
+        }
+    }
+
+    fn div(classes: &[&str], content: Vec<Block>) -> Block {
+        Block::Div(Div {
+            attr: (
+                String::new(),
+                classes.iter().map(|c| c.to_string()).collect(),
+                hashlink::LinkedHashMap::new(),
+            ),
+            content,
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn para(s: &str) -> Block {
+        Block::Paragraph(Paragraph {
+            content: vec![Inline::Str(Str {
+                text: s.to_string(),
+                source_info: SourceInfo::default(),
+            })],
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn classes(block: &Block) -> &[String] {
+        match block {
+            Block::Div(div) => &div.attr.1,
+            _ => panic!("Expected Div"),
+        }
+    }
+
+    fn run(blocks: Vec<Block>) -> Vec<Block> {
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks,
+        };
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        ColumnsTransform::new()
+            .transform(&mut ast, &mut ctx)
+            .unwrap();
+        ast.blocks
+    }
+
+    #[test]
+    fn test_transform_name() {
+        assert_eq!(ColumnsTransform::new().name(), "columns");
+    }
+
+    #[test]
+    fn test_containers_of_column_content_are_marked() {
+        let blocks = run(vec![div(
+            &["section", "level2"],
+            vec![
+                para("Body"),
+                div(
+                    &["callout"],
+                    vec![div(&["column-margin"], vec![para("Aside")])],
+                ),
+            ],
+        )]);
+
+        assert_eq!(
+            classes(&blocks[0]),
+            ["section", "level2", "page-columns", "page-full"]
+        );
+        let Block::Div(section) = &blocks[0] else {
+            unreachable!()
+        };
+        let callout = &section.content[1];
+        assert_eq!(classes(callout), ["callout", "page-columns", "page-full"]);
+        // The column content itself is unchanged
+        let Block::Div(callout) = callout else {
+            unreachable!()
+        };
+        assert_eq!(classes(&callout.content[0]), ["column-margin"]);
+    }
+
+    #[test]
+    fn test_divs_without_column_content_are_unchanged() {
+        let blocks = run(vec![div(&["section"], vec![para("Body")])]);
+        assert_eq!(classes(&blocks[0]), ["section"]);
+    }
+}
@@ -660,6 +660,7 @@ mod tests {
                 content: vec![
                     make_str("Text with"),
                     Inline::Note(Note {
+                        id: None,
                         content: vec![Block::Paragraph(Paragraph {
                             content: vec![make_str("footnote content")],
                             source_info: dummy_source_info(),
@@ -733,6 +734,7 @@ mod tests {
                 content: vec![
                     make_str("First"),
                     Inline::Note(Note {
+                        id: None,
                         content: vec![Block::Plain(Plain {
                             content: vec![make_str("note 1")],
                             source_info: dummy_source_info(),
@@ -741,6 +743,7 @@ mod tests {
                     }),
                     make_str(" and second"),
                     Inline::Note(Note {
+                        id: None,
                         content: vec![Block::Plain(Plain {
                             content: vec![make_str("note 2")],
                             source_info: dummy_source_info(),
@@ -876,6 +879,7 @@ mod tests {
                     content: vec![
                         make_str("emphasized"),
                         Inline::Note(Note {
+                            id: None,
                             content: vec![Block::Plain(Plain {
                                 content: vec![make_str("nested note")],
                                 source_info: dummy_source_info(),
@@ -910,6 +914,7 @@ mod tests {
                 content: vec![
                     make_str("Text with"),
                     Inline::Note(Note {
+                        id: None,
                         content: vec![Block::Plain(Plain {
                             content: vec![make_str("margin note content")],
                             source_info: dummy_source_info(),
@@ -958,6 +963,7 @@ mod tests {
         // (Pandoc handles these during rendering)
 
         let note = Inline::Note(Note {
+            id: None,
             content: vec![Block::Plain(Plain {
                 content: vec![make_str("note content")],
                 source_info: dummy_source_info(),
@@ -1005,6 +1011,7 @@ mod tests {
                 content: vec![
                     make_str("Text"),
                     Inline::Note(Note {
+                        id: None,
                         content: vec![Block::Plain(Plain {
                             content: vec![make_str("note content")],
                             source_info: dummy_source_info(),
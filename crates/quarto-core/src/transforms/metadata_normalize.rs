@@ -762,6 +762,7 @@ mod tests {
     fn test_inlines_note() {
         use quarto_pandoc_types::block::Plain;
         let inlines = vec![Inline::Note(quarto_pandoc_types::inline::Note {
+            id: None,
             content: vec![Block::Plain(Plain {
                 content: vec![Inline::Str(Str {
                     text: "note content".to_string(),
@@ -12,11 +12,16 @@
 //! - [`AppendixStructureTransform`] - Consolidates appendix content into single container
 //! - [`CalloutTransform`] - Converts callout Divs to CustomNodes
 //! - [`CalloutResolveTransform`] - Resolves Callout CustomNodes to standard Div structure
+//! - [`ColumnsTransform`] - Puts the Divs containing `.column-*` content on the page grid
 //! - [`FootnotesTransform`] - Extracts footnotes and creates footnotes section
 //! - [`MetadataNormalizeTransform`] - Normalizes document metadata (adds pagetitle, etc.)
+//! - [`OjsTransform`] - Converts `{ojs}` cells to CustomNodes
+//! - [`OjsResolveTransform`] - Resolves OjsCell CustomNodes to output containers and a runtime manifest
 //! - [`ResourceCollectorTransform`] - Collects resource dependencies (images, etc.)
 //! - [`SectionizeTransform`] - Wraps headers in section Divs (analogous to Pandoc's --section-divs)
 //! - [`ShortcodeResolveTransform`] - Resolves shortcodes to their content
+//! - [`TabsetTransform`] - Converts `.panel-tabset` Divs to CustomNodes
+//! - [`TabsetResolveTransform`] - Resolves PanelTabset CustomNodes to tab markup
 //! - [`TitleBlockTransform`] - Adds title header from metadata if not present
 //! - [`TocGenerateTransform`] - Generates TOC from document headings
 //! - [`TocRenderTransform`] - Renders TOC metadata to HTML
@@ -27,12 +32,17 @@
 mod appendix;
 mod callout;
 mod callout_resolve;
+mod columns;
 mod config;
 mod footnotes;
 mod metadata_normalize;
+mod ojs;
+mod ojs_resolve;
 mod resource_collector;
 mod sectionize;
 mod shortcode_resolve;
+mod tabset;
+mod tabset_resolve;
 mod title_block;
 mod toc_generate;
 mod toc_render;
@@ -40,12 +50,18 @@ mod toc_render;
 pub use appendix::AppendixStructureTransform;
 pub use callout::CalloutTransform;
 pub use callout_resolve::CalloutResolveTransform;
+pub use columns::ColumnsTransform;
 pub use config::{AppendixStyle, ReferenceLocation};
 pub use footnotes::FootnotesTransform;
 pub use metadata_normalize::MetadataNormalizeTransform;
+pub use ojs::OjsTransform;
+pub use ojs_resolve::OjsResolveTransform;
 pub use resource_collector::ResourceCollectorTransform;
+pub(crate) use resource_collector::{ResourceRef, visit_resource_urls};
 pub use sectionize::SectionizeTransform;
 pub use shortcode_resolve::ShortcodeResolveTransform;
+pub use tabset::TabsetTransform;
+pub use tabset_resolve::TabsetResolveTransform;
 pub use title_block::TitleBlockTransform;
 pub use toc_generate::TocGenerateTransform;
 pub use toc_render::TocRenderTransform;
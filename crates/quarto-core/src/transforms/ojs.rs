@@ -0,0 +1,221 @@
+/*
+ * ojs.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Transform that converts OJS code cells to CustomNodes.
+ */
+
+//! OJS cell conversion transform.
+//!
+//! ObservableJS cells are not run by an engine: they run in the browser.
+//! This transform finds them and converts them to CustomNode blocks with
+//! type "OjsCell", keeping their source and options for
+//! [`OjsResolveTransform`](super::OjsResolveTransform).
+//!
+//! ## Input Structure
+//!
+//! ````markdown
+//! ```{ojs}
+//! //| echo: false
+//! viewof n = Inputs.range([1, 10])
+//! ```
+//! ````
+//!
+//! This is parsed as a CodeBlock whose first class is `{ojs}`.
+//!
+//! ## Output Structure
+//!
+//! The transform converts this to a CustomNode with:
+//! - `type_name`: "OjsCell"
+//! - `slots`: none
+//! - `plain_data`: `{"source": "viewof n = ...\n", "options": {"echo": false}}`,
+//!   the `//|` option lines parsed as YAML and removed from the source
+//! - `attr`: the CodeBlock's attributes, without the `{ojs}` class
+
+use quarto_pandoc_types::block::{Block, CodeBlock};
+use quarto_pandoc_types::custom::CustomNode;
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks_mut};
+use serde_json::{Map, Value, json};
+
+use crate::Result;
+use crate::render::RenderContext;
+use crate::transform::AstTransform;
+
+/// The comment prefix of OJS cell option lines.
+const OPTION_PREFIX: &str = "//|";
+
+/// Transform that converts OJS code cells to CustomNodes.
+pub struct OjsTransform;
+
+impl OjsTransform {
+    /// Create a new OJS transform.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for OjsTransform {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AstTransform for OjsTransform {
+    fn name(&self) -> &str {
+        "ojs"
+    }
+
+    fn transform(&self, ast: &mut Pandoc, _ctx: &mut RenderContext) -> Result<()> {
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::TopDown, |block| {
+            if let Block::CodeBlock(code) = block
+                && is_ojs_cell(code)
+            {
+                *block = Block::Custom(convert_code_to_ojs_cell(code));
+            }
+            Control::Continue
+        });
+        Ok(())
+    }
+}
+
+/// Whether a CodeBlock is an OJS cell (`{ojs}`, not the display-only `.ojs`).
+fn is_ojs_cell(code: &CodeBlock) -> bool {
+    let (_id, classes, _attrs) = &code.attr;
+    classes
+        .first()
+        .is_some_and(|class| class.eq_ignore_ascii_case("{ojs}"))
+}
+
+/// Convert an OJS CodeBlock to a CustomNode with type "OjsCell".
+fn convert_code_to_ojs_cell(code: &CodeBlock) -> CustomNode {
+    let (id, classes, attrs) = &code.attr;
+    let attr = (id.clone(), classes[1..].to_vec(), attrs.clone());
+    let (options, source) = split_cell_options(&code.text);
+
+    let mut custom = CustomNode::new("OjsCell", attr, code.source_info.clone());
+    custom.plain_data = json!({
+        "source": source,
+        "options": options,
+    });
+    custom
+}
+
+/// Split the `//|` option lines off the top of a cell.
+///
+/// Returns the options, as a JSON object, and the source without them.
+/// Options that aren't a YAML mapping are ignored.
+fn split_cell_options(text: &str) -> (Value, String) {
+    let mut yaml = String::new();
+    let mut lines = text.split_inclusive('\n').peekable();
+    while let Some(option) = lines
+        .peek()
+        .copied()
+        .and_then(|line| line.trim_start().strip_prefix(OPTION_PREFIX))
+    {
+        yaml.push_str(option.strip_prefix(' ').unwrap_or(option));
+        lines.next();
+    }
+    let source: String = lines.collect();
+
+    let options = serde_yaml::from_str::<serde_yaml::Value>(&yaml)
+        .ok()
+        .and_then(|yaml| serde_json::to_value(yaml).ok())
+        .filter(Value::is_object)
+        .unwrap_or_else(|| Value::Object(Map::new()));
+    (options, source)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_pandoc_types::attr::AttrSourceInfo;
+    use quarto_source_map::SourceInfo;
+
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::render::BinaryDependencies;
+
+    fn make_test_project() -> ProjectContext {
+        ProjectContext {
+            dir: std::path::PathBuf::from("/project"),
+            config: None,
+            is_single_file: true,
+            files: vec![DocumentInfo::from_path("/project/doc.qmd")],
+            output_dir: std::path::PathBuf::from("/project"),
+        }
+    }
+
+    fn code_block(classes: &[&str], text: &str) -> Block {
+        Block::CodeBlock(CodeBlock {
+            attr: (
+                String::new(),
+                classes.iter().map(|c| c.to_string()).collect(),
+                hashlink::LinkedHashMap::new(),
+            ),
+            text: text.to_string(),
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn run(blocks: Vec<Block>) -> Vec<Block> {
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks,
+        };
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        OjsTransform::new().transform(&mut ast, &mut ctx).unwrap();
+        ast.blocks
+    }
+
+    #[test]
+    fn test_transform_name() {
+        assert_eq!(OjsTransform::new().name(), "ojs");
+    }
+
+    #[test]
+    fn test_convert_ojs_cell() {
+        let blocks = run(vec![code_block(
+            &["{ojs}", "wide"],
+            "//| echo: false\n//| label: slider\nviewof n = Inputs.range([1, 10])\n",
+        )]);
+
+        match &blocks[0] {
+            Block::Custom(custom) => {
+                assert_eq!(custom.type_name, "OjsCell");
+                assert_eq!(
+                    custom.plain_data["source"],
+                    "viewof n = Inputs.range([1, 10])\n"
+                );
+                assert_eq!(
+                    custom.plain_data["options"],
+                    json!({"echo": false, "label": "slider"})
+                );
+                assert_eq!(custom.attr.1, vec!["wide".to_string()]);
+            }
+            _ => panic!("Expected Custom block"),
+        }
+    }
+
+    #[test]
+    fn test_display_only_code_is_left_alone() {
+        let blocks = run(vec![code_block(&["ojs"], "x = 1\n")]);
+        assert!(matches!(&blocks[0], Block::CodeBlock(_)));
+    }
+
+    #[test]
+    fn test_split_cell_options() {
+        let (options, source) = split_cell_options("x = 1\n//| echo: false\n");
+        assert_eq!(options, json!({}));
+        assert_eq!(source, "x = 1\n//| echo: false\n");
+
+        let (options, source) = split_cell_options("//| not a mapping\nx = 1");
+        assert_eq!(options, json!({}));
+        assert_eq!(source, "x = 1");
+    }
+}
@@ -0,0 +1,307 @@
+/*
+ * ojs_resolve.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Transform that resolves OjsCell CustomNodes to the containers the OJS
+ * runtime hydrates.
+ */
+
+//! OJS cell resolution transform.
+//!
+//! This transform converts OjsCell CustomNodes back to standard Pandoc AST:
+//! the cell's code, a container for its output, and, once for the
+//! document, the manifest of the cells that the OJS runtime in the browser
+//! reads to run them.
+//!
+//! ## Pipeline Order
+//!
+//! This transform should run AFTER `OjsTransform`:
+//! 1. `OjsTransform`: CodeBlock with `{ojs}` → CustomNode("OjsCell")
+//! 2. `OjsResolveTransform`: CustomNode("OjsCell") → Div with output container
+//!
+//! ## Output Structure
+//!
+//! Cells are numbered in document order:
+//!
+//! ```text
+//! Div#{label}.cell [original classes and attributes]
+//!   CodeBlock.ojs.cell-code                 (unless echo: false)
+//!   Div#ojs-cell-1.cell-output-display     (unless output: false or eval: false)
+//! ...
+//! RawBlock(html, "<script type=\"ojs-module-contents\">{base64 manifest}</script>")
+//! ```
+//!
+//! The manifest is the base64 of the JSON TS Quarto writes:
+//!
+//! ```json
+//! {"contents": [{"methodName": "interpret", "cellName": "ojs-cell-1",
+//!                "inline": false, "source": "viewof n = ..."}]}
+//! ```
+//!
+//! Cells with `eval: false` are not in the manifest.
+
+use base64::Engine;
+use hashlink::LinkedHashMap;
+use quarto_pandoc_types::attr::AttrSourceInfo;
+use quarto_pandoc_types::block::{Block, CodeBlock, Div, RawBlock};
+use quarto_pandoc_types::custom::CustomNode;
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks_mut};
+use quarto_source_map::SourceInfo;
+use serde_json::{Value, json};
+
+use crate::Result;
+use crate::render::RenderContext;
+use crate::transform::AstTransform;
+
+/// Transform that resolves OjsCell CustomNodes to output containers and a
+/// manifest for the OJS runtime.
+pub struct OjsResolveTransform;
+
+impl OjsResolveTransform {
+    /// Create a new OJS resolve transform.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for OjsResolveTransform {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AstTransform for OjsResolveTransform {
+    fn name(&self) -> &str {
+        "ojs-resolve"
+    }
+
+    fn transform(&self, ast: &mut Pandoc, _ctx: &mut RenderContext) -> Result<()> {
+        let mut cells = 0;
+        let mut contents = Vec::new();
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::TopDown, |block| {
+            if let Block::Custom(custom) = block
+                && custom.type_name == "OjsCell"
+            {
+                cells += 1;
+                let (div, module) = resolve_ojs_cell(custom, cells);
+                contents.extend(module);
+                *block = Block::Div(div);
+                return Control::Skip;
+            }
+            Control::Continue
+        });
+
+        if !contents.is_empty() {
+            ast.blocks.push(manifest(&contents));
+        }
+        Ok(())
+    }
+}
+
+/// Resolve an OjsCell CustomNode to a cell Div.
+///
+/// Returns the Div and, unless the cell isn't evaluated, its entry in the
+/// manifest.
+fn resolve_ojs_cell(custom: &CustomNode, number: usize) -> (Div, Option<Value>) {
+    let source_info = custom.source_info.clone();
+    let source = custom
+        .plain_data
+        .get("source")
+        .and_then(Value::as_str)
+        .unwrap_or_default();
+    let options = &custom.plain_data["options"];
+    let option = |key: &str| options.get(key).and_then(Value::as_bool);
+    let eval = option("eval").unwrap_or(true);
+    let echo = option("echo").unwrap_or(true);
+    let output = option("output").unwrap_or(true);
+
+    let (id, orig_classes, attrs) = &custom.attr;
+    let id = match options.get("label").and_then(Value::as_str) {
+        Some(label) if id.is_empty() => label.to_string(),
+        _ => id.clone(),
+    };
+    let mut classes = vec!["cell".to_string()];
+    classes.extend(orig_classes.iter().cloned());
+
+    let mut content = Vec::new();
+    if echo {
+        content.push(Block::CodeBlock(CodeBlock {
+            attr: (
+                String::new(),
+                vec!["ojs".to_string(), "cell-code".to_string()],
+                LinkedHashMap::new(),
+            ),
+            text: source.trim_end_matches('\n').to_string(),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }));
+    }
+
+    let cell_name = format!("ojs-cell-{}", number);
+    if eval && output {
+        content.push(Block::Div(Div {
+            attr: (
+                cell_name.clone(),
+                vec!["cell-output-display".to_string()],
+                LinkedHashMap::new(),
+            ),
+            content: Vec::new(),
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }));
+    }
+
+    let module = eval.then(|| {
+        json!({
+            "methodName": "interpret",
+            "cellName": cell_name,
+            "inline": false,
+            "source": source,
+        })
+    });
+
+    let div = Div {
+        attr: (id, classes, attrs.clone()),
+        content,
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+    };
+    (div, module)
+}
+
+/// The `<script>` with the manifest of the document's OJS cells.
+fn manifest(contents: &[Value]) -> Block {
+    let json = json!({ "contents": contents }).to_string();
+    let encoded = base64::engine::general_purpose::STANDARD.encode(json);
+    Block::RawBlock(RawBlock {
+        format: "html".to_string(),
+        text: format!(
+            "<script type=\"ojs-module-contents\">\n{}\n</script>",
+            encoded
+        ),
+        source_info: SourceInfo::default(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_pandoc_types::attr::empty_attr;
+
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::render::BinaryDependencies;
+
+    fn make_test_project() -> ProjectContext {
+        ProjectContext {
+            dir: std::path::PathBuf::from("/project"),
+            config: None,
+            is_single_file: true,
+            files: vec![DocumentInfo::from_path("/project/doc.qmd")],
+            output_dir: std::path::PathBuf::from("/project"),
+        }
+    }
+
+    fn ojs_cell(source: &str, options: Value) -> CustomNode {
+        CustomNode::new("OjsCell", empty_attr(), SourceInfo::default())
+            .with_data(json!({"source": source, "options": options}))
+    }
+
+    fn decode_manifest(block: &Block) -> Value {
+        let Block::RawBlock(raw) = block else {
+            panic!("Expected manifest RawBlock");
+        };
+        let encoded = raw
+            .text
+            .strip_prefix("<script type=\"ojs-module-contents\">\n")
+            .and_then(|text| text.strip_suffix("\n</script>"))
+            .expect("Expected an ojs-module-contents script");
+        let json = base64::engine::general_purpose::STANDARD
+            .decode(encoded)
+            .unwrap();
+        serde_json::from_slice(&json).unwrap()
+    }
+
+    #[test]
+    fn test_transform_name() {
+        assert_eq!(OjsResolveTransform::new().name(), "ojs-resolve");
+    }
+
+    #[test]
+    fn test_resolve_ojs_cell() {
+        let (div, module) = resolve_ojs_cell(&ojs_cell("x = 1\n", json!({"label": "x"})), 1);
+
+        assert_eq!(div.attr.0, "x");
+        assert_eq!(div.attr.1, vec!["cell".to_string()]);
+        match &div.content[..] {
+            [Block::CodeBlock(code), Block::Div(container)] => {
+                assert_eq!(code.text, "x = 1");
+                assert_eq!(container.attr.0, "ojs-cell-1");
+                assert!(container.content.is_empty());
+            }
+            _ => panic!("Expected code and an output container"),
+        }
+        assert_eq!(module.unwrap()["cellName"], "ojs-cell-1");
+    }
+
+    #[test]
+    fn test_resolve_ojs_cell_options() {
+        let (div, module) = resolve_ojs_cell(&ojs_cell("x = 1\n", json!({"echo": false})), 1);
+        assert!(matches!(&div.content[..], [Block::Div(_)]));
+        assert!(module.is_some());
+
+        let (div, module) = resolve_ojs_cell(&ojs_cell("x = 1\n", json!({"eval": false})), 2);
+        assert!(matches!(&div.content[..], [Block::CodeBlock(_)]));
+        assert!(module.is_none());
+    }
+
+    #[test]
+    fn test_manifest() {
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks: vec![
+                Block::Custom(ojs_cell("a = 1\n", json!({}))),
+                Block::Custom(ojs_cell("b = a + 1\n", json!({"eval": false}))),
+                Block::Custom(ojs_cell("c = a * 2\n", json!({"echo": false}))),
+            ],
+        };
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        OjsResolveTransform::new()
+            .transform(&mut ast, &mut ctx)
+            .unwrap();
+
+        assert_eq!(ast.blocks.len(), 4);
+        assert_eq!(
+            decode_manifest(&ast.blocks[3]),
+            json!({"contents": [
+                {"methodName": "interpret", "cellName": "ojs-cell-1", "inline": false, "source": "a = 1\n"},
+                {"methodName": "interpret", "cellName": "ojs-cell-3", "inline": false, "source": "c = a * 2\n"},
+            ]})
+        );
+    }
+
+    #[test]
+    fn test_no_manifest_without_cells() {
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks: vec![],
+        };
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        OjsResolveTransform::new()
+            .transform(&mut ast, &mut ctx)
+            .unwrap();
+
+        assert!(ast.blocks.is_empty());
+    }
+}
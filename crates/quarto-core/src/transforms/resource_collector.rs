@@ -19,6 +19,7 @@ use quarto_pandoc_types::Slot;
 use quarto_pandoc_types::block::Block;
 use quarto_pandoc_types::inline::Inline;
 use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_source_map::SourceInfo;
 
 use crate::Result;
 use crate::artifact::Artifact;
@@ -53,15 +54,20 @@ impl AstTransform for ResourceCollectorTransform {
 
     fn transform(&self, ast: &mut Pandoc, ctx: &mut RenderContext) -> Result<()> {
         let base_dir = ctx.document.input.parent().unwrap_or(Path::new("."));
-        let mut collector = ResourceVisitor::new(base_dir);
+        let mut resources: Vec<PathBuf> = Vec::new();
 
         // Walk the AST and collect resources
-        for block in &ast.blocks {
-            collector.visit_block(block);
-        }
+        visit_resource_urls(&mut ast.blocks, &mut |kind, url, _source_info| {
+            if kind == ResourceRef::Image
+                && let Some(path) = local_resource(base_dir, url)
+                && !resources.contains(&path)
+            {
+                resources.push(path);
+            }
+        });
 
         // Store collected resources in the artifact store
-        for (i, resource) in collector.resources.iter().enumerate() {
+        for (i, resource) in resources.iter().enumerate() {
             let key = format!("resource:image:{}", i);
             ctx.artifacts.store(
                 key,
@@ -69,62 +75,71 @@ impl AstTransform for ResourceCollectorTransform {
             );
         }
 
-        tracing::debug!(
-            "Collected {} resource(s) from document",
-            collector.resources.len()
-        );
+        tracing::debug!("Collected {} resource(s) from document", resources.len());
 
         Ok(())
     }
 }
 
-/// Visitor that collects resources from the AST.
-struct ResourceVisitor<'a> {
-    base_dir: &'a Path,
-    resources: Vec<PathBuf>,
+/// What a resource URL is the target of
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum ResourceRef {
+    Image,
+    Link,
 }
 
-impl<'a> ResourceVisitor<'a> {
-    fn new(base_dir: &'a Path) -> Self {
-        Self {
-            base_dir,
-            resources: Vec::new(),
-        }
+/// Call `visit` with the target URL of every image and link in `blocks`,
+/// including those in notes, tables and custom nodes. `visit` may rewrite
+/// the URL in place.
+pub(crate) fn visit_resource_urls(
+    blocks: &mut [Block],
+    visit: &mut dyn FnMut(ResourceRef, &mut String, &SourceInfo),
+) {
+    let mut visitor = ResourceVisitor { visit };
+    for block in blocks {
+        visitor.visit_block(block);
     }
+}
+
+/// Visitor that finds the resource URLs of the AST.
+struct ResourceVisitor<'a> {
+    visit: &'a mut dyn FnMut(ResourceRef, &mut String, &SourceInfo),
+}
 
-    fn visit_block(&mut self, block: &Block) {
+impl ResourceVisitor<'_> {
+    fn visit_block(&mut self, block: &mut Block) {
         match block {
             Block::Paragraph(p) => {
-                for inline in &p.content {
+                for inline in &mut p.content {
                     self.visit_inline(inline);
                 }
             }
             Block::Plain(p) => {
-                for inline in &p.content {
+                for inline in &mut p.content {
                     self.visit_inline(inline);
                 }
             }
             Block::BlockQuote(bq) => {
-                for block in &bq.content {
+                for block in &mut bq.content {
                     self.visit_block(block);
                 }
             }
             Block::OrderedList(ol) => {
-                for item in &ol.content {
+                for item in &mut ol.content {
                     for block in item {
                         self.visit_block(block);
                     }
                 }
             }
             Block::BulletList(bl) => {
-                for item in &bl.content {
+                for item in &mut bl.content {
                     for block in item {
                         self.visit_block(block);
                     }
                 }
             }
             Block::DefinitionList(dl) => {
-                for (term, defs) in &dl.content {
+                for (term, defs) in &mut dl.content {
                     for inline in term {
                         self.visit_inline(inline);
                     }
@@ -136,44 +151,44 @@ impl<'a> ResourceVisitor<'a> {
                 }
             }
             Block::Header(h) => {
-                for inline in &h.content {
+                for inline in &mut h.content {
                     self.visit_inline(inline);
                 }
             }
             Block::Div(d) => {
-                for block in &d.content {
+                for block in &mut d.content {
                     self.visit_block(block);
                 }
             }
             Block::Figure(f) => {
-                for block in &f.content {
+                for block in &mut f.content {
                     self.visit_block(block);
                 }
             }
             Block::Table(t) => {
                 // Visit table caption
-                if let Some(short) = &t.caption.short {
+                if let Some(short) = &mut t.caption.short {
                     for inline in short {
                         self.visit_inline(inline);
                     }
                 }
-                if let Some(long) = &t.caption.long {
+                if let Some(long) = &mut t.caption.long {
                     for block in long {
                         self.visit_block(block);
                     }
                 }
                 // Visit table cells
-                for row in t.head.rows.iter().chain(t.foot.rows.iter()) {
-                    for cell in &row.cells {
-                        for block in &cell.content {
+                for row in t.head.rows.iter_mut().chain(t.foot.rows.iter_mut()) {
+                    for cell in &mut row.cells {
+                        for block in &mut cell.content {
                             self.visit_block(block);
                         }
                     }
                 }
-                for body in &t.bodies {
-                    for row in &body.body {
-                        for cell in &row.cells {
-                            for block in &cell.content {
+                for body in &mut t.bodies {
+                    for row in &mut body.body {
+                        for cell in &mut row.cells {
+                            for block in &mut cell.content {
                                 self.visit_block(block);
                             }
                         }
@@ -181,7 +196,7 @@ impl<'a> ResourceVisitor<'a> {
                 }
             }
             Block::LineBlock(lb) => {
-                for line in &lb.content {
+                for line in &mut lb.content {
                     for inline in line {
                         self.visit_inline(inline);
                     }
@@ -189,7 +204,7 @@ impl<'a> ResourceVisitor<'a> {
             }
             Block::Custom(c) => {
                 // Visit custom node slots
-                for (_name, slot) in &c.slots {
+                for (_name, slot) in &mut c.slots {
                     match slot {
                         Slot::Block(block) => {
                             self.visit_block(block);
@@ -221,96 +236,96 @@ impl<'a> ResourceVisitor<'a> {
         }
     }
 
-    fn visit_inline(&mut self, inline: &Inline) {
+    fn visit_inline(&mut self, inline: &mut Inline) {
         match inline {
             Inline::Image(img) => {
-                // Collect image resource - target is (url, title) tuple
-                self.collect_resource(&img.target.0);
+                // target is a (url, title) tuple
+                (self.visit)(ResourceRef::Image, &mut img.target.0, &img.source_info);
             }
             Inline::Link(link) => {
-                // Visit link content
-                for inline in &link.content {
+                (self.visit)(ResourceRef::Link, &mut link.target.0, &link.source_info);
+                for inline in &mut link.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Emph(e) => {
-                for inline in &e.content {
+                for inline in &mut e.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Underline(u) => {
-                for inline in &u.content {
+                for inline in &mut u.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Strong(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Strikeout(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Superscript(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Subscript(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::SmallCaps(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Quoted(q) => {
-                for inline in &q.content {
+                for inline in &mut q.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Cite(c) => {
-                for inline in &c.content {
+                for inline in &mut c.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Span(s) => {
-                for inline in &s.content {
+                for inline in &mut s.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Note(n) => {
-                for block in &n.content {
+                for block in &mut n.content {
                     self.visit_block(block);
                 }
             }
             Inline::Insert(i) => {
-                for inline in &i.content {
+                for inline in &mut i.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Delete(d) => {
-                for inline in &d.content {
+                for inline in &mut d.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Highlight(h) => {
-                for inline in &h.content {
+                for inline in &mut h.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::EditComment(e) => {
-                for inline in &e.content {
+                for inline in &mut e.content {
                     self.visit_inline(inline);
                 }
             }
             Inline::Custom(c) => {
                 // Visit custom node slots
-                for (_name, slot) in &c.slots {
+                for (_name, slot) in &mut c.slots {
                     match slot {
                         Slot::Block(block) => {
                             self.visit_block(block);
@@ -344,29 +359,25 @@ impl<'a> ResourceVisitor<'a> {
             | Inline::Attr(_, _) => {}
         }
     }
+}
 
-    fn collect_resource(&mut self, url: &str) {
-        // Skip external URLs
-        if url.starts_with("http://")
-            || url.starts_with("https://")
-            || url.starts_with("data:")
-            || url.starts_with("//")
-        {
-            return;
-        }
-
-        // Resolve relative path
-        let path = if url.starts_with('/') {
-            PathBuf::from(url)
-        } else {
-            self.base_dir.join(url)
-        };
-
-        // Add to resources if not already present
-        if !self.resources.contains(&path) {
-            self.resources.push(path);
-        }
+/// The local file an image URL refers to, or None for external URLs
+fn local_resource(base_dir: &Path, url: &str) -> Option<PathBuf> {
+    // Skip external URLs
+    if url.starts_with("http://")
+        || url.starts_with("https://")
+        || url.starts_with("data:")
+        || url.starts_with("//")
+    {
+        return None;
     }
+
+    // Resolve relative path
+    Some(if url.starts_with('/') {
+        PathBuf::from(url)
+    } else {
+        base_dir.join(url)
+    })
 }
 
 #[cfg(test)]
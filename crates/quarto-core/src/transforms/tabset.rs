@@ -0,0 +1,275 @@
+/*
+ * tabset.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Transform that converts tabset Divs to CustomNodes.
+ */
+
+//! Tabset conversion transform.
+//!
+//! This transform finds Div blocks with the `.panel-tabset` class and
+//! converts them to CustomNode blocks with type "PanelTabset", one tab per
+//! heading.
+//!
+//! ## Input Structure
+//!
+//! ```markdown
+//! ::: {.panel-tabset group="language"}
+//! ## R
+//!
+//! R content.
+//!
+//! ## Python
+//!
+//! Python content.
+//! :::
+//! ```
+//!
+//! Tabs are started by the headings at the level of the first block, which
+//! must be a heading; deeper headings are content of their tab. A
+//! `.panel-tabset` Div that doesn't start with a heading has no tabs and is
+//! left as it is.
+//!
+//! ## Output Structure
+//!
+//! The transform converts this to a CustomNode with:
+//! - `type_name`: "PanelTabset"
+//! - `slots`, for each tab `i` from 1:
+//!   - "title-i": Inlines of the tab's heading
+//!   - "content-i": Blocks after the heading
+//! - `plain_data`: `{"tabs": 2, "group": "language"}` (`group` is null
+//!   unless set)
+//! - `attr`: Original Div attributes
+
+use quarto_pandoc_types::block::{Block, Div};
+use quarto_pandoc_types::custom::{CustomNode, Slot};
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks_mut};
+use serde_json::json;
+
+use crate::Result;
+use crate::render::RenderContext;
+use crate::transform::AstTransform;
+
+/// Transform that converts tabset Divs to CustomNodes.
+///
+/// This allows tabsets to be rendered as tab markup (a nav list and tab
+/// panes) rather than as a div of headings.
+pub struct TabsetTransform;
+
+impl TabsetTransform {
+    /// Create a new tabset transform.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for TabsetTransform {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AstTransform for TabsetTransform {
+    fn name(&self) -> &str {
+        "tabset"
+    }
+
+    fn transform(&self, ast: &mut Pandoc, _ctx: &mut RenderContext) -> Result<()> {
+        // Bottom-up, so that tabsets inside tabs are converted first
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::BottomUp, |block| {
+            if let Block::Div(div) = block
+                && is_tabset(div)
+            {
+                *block = Block::Custom(convert_div_to_tabset(div));
+            }
+            Control::Continue
+        });
+        Ok(())
+    }
+}
+
+/// Whether a Div is a tabset with tabs to convert.
+fn is_tabset(div: &Div) -> bool {
+    let (_id, classes, _attrs) = &div.attr;
+    classes.iter().any(|class| class == "panel-tabset")
+        && matches!(div.content.first(), Some(Block::Header(_)))
+}
+
+/// Convert a tabset Div to a CustomNode with type "PanelTabset".
+fn convert_div_to_tabset(div: &mut Div) -> CustomNode {
+    let content = std::mem::take(&mut div.content);
+    let level = match content.first() {
+        Some(Block::Header(header)) => header.level,
+        _ => unreachable!("is_tabset checks for a leading heading"),
+    };
+
+    let mut tabs: Vec<(Vec<_>, Vec<Block>)> = Vec::new();
+    for block in content {
+        match block {
+            Block::Header(header) if header.level == level => {
+                tabs.push((header.content, Vec::new()));
+            }
+            block => {
+                if let Some((_title, blocks)) = tabs.last_mut() {
+                    blocks.push(block);
+                }
+            }
+        }
+    }
+
+    let (_id, _classes, attrs) = &div.attr;
+    let mut custom = CustomNode::new("PanelTabset", div.attr.clone(), div.source_info.clone());
+    custom.plain_data = json!({
+        "tabs": tabs.len(),
+        "group": attrs.get("group"),
+    });
+    for (i, (title, blocks)) in tabs.into_iter().enumerate() {
+        custom.set_slot(format!("title-{}", i + 1), Slot::Inlines(title));
+        custom.set_slot(format!("content-{}", i + 1), Slot::Blocks(blocks));
+    }
+
+    custom
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use hashlink::LinkedHashMap;
+    use quarto_pandoc_types::attr::{AttrSourceInfo, empty_attr};
+    use quarto_pandoc_types::block::{Header, Paragraph};
+    use quarto_pandoc_types::inline::{Inline, Str};
+    use quarto_source_map::SourceInfo;
+
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::render::BinaryDependencies;
+
+    fn make_test_project() -> ProjectContext {
+        ProjectContext {
+            dir: std::path::PathBuf::from("/project"),
+            config: None,
+            is_single_file: true,
+            files: vec![DocumentInfo::from_path("/project/doc.qmd")],
+            output_dir: std::path::PathBuf::from("/project"),
+        }
+    }
+
+    fn text(s: &str) -> Vec<Inline> {
+        vec![Inline::Str(Str {
+            text: s.to_string(),
+            source_info: SourceInfo::default(),
+        })]
+    }
+
+    fn header(level: usize, title: &str) -> Block {
+        Block::Header(Header {
+            level,
+            attr: empty_attr(),
+            content: text(title),
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn para(s: &str) -> Block {
+        Block::Paragraph(Paragraph {
+            content: text(s),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn tabset(attrs: &[(&str, &str)], content: Vec<Block>) -> Block {
+        let attrs: LinkedHashMap<String, String> = attrs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect();
+        Block::Div(Div {
+            attr: (String::new(), vec!["panel-tabset".to_string()], attrs),
+            content,
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    fn run(blocks: Vec<Block>) -> Vec<Block> {
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks,
+        };
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        TabsetTransform::new()
+            .transform(&mut ast, &mut ctx)
+            .unwrap();
+        ast.blocks
+    }
+
+    #[test]
+    fn test_transform_name() {
+        assert_eq!(TabsetTransform::new().name(), "tabset");
+    }
+
+    #[test]
+    fn test_convert_tabset() {
+        let blocks = run(vec![tabset(
+            &[("group", "language")],
+            vec![
+                header(2, "R"),
+                para("R content"),
+                header(3, "Detail"),
+                header(2, "Python"),
+                para("Python content"),
+            ],
+        )]);
+
+        match &blocks[0] {
+            Block::Custom(custom) => {
+                assert_eq!(custom.type_name, "PanelTabset");
+                assert_eq!(custom.plain_data["tabs"], 2);
+                assert_eq!(custom.plain_data["group"], "language");
+                assert_eq!(
+                    custom.get_slot("title-2"),
+                    Some(&Slot::Inlines(text("Python")))
+                );
+                // The deeper heading is content of the first tab
+                match custom.get_slot("content-1") {
+                    Some(Slot::Blocks(blocks)) => assert_eq!(blocks.len(), 2),
+                    _ => panic!("Expected content slot with Blocks"),
+                }
+            }
+            _ => panic!("Expected Custom block"),
+        }
+    }
+
+    #[test]
+    fn test_tabset_without_heading_is_left_alone() {
+        let blocks = run(vec![tabset(&[], vec![para("No tabs")])]);
+        assert!(matches!(&blocks[0], Block::Div(_)));
+    }
+
+    #[test]
+    fn test_nested_tabset() {
+        let blocks = run(vec![tabset(
+            &[],
+            vec![
+                header(2, "Outer"),
+                tabset(&[], vec![header(3, "Inner"), para("Inner content")]),
+            ],
+        )]);
+
+        match &blocks[0] {
+            Block::Custom(outer) => match outer.get_slot("content-1") {
+                Some(Slot::Blocks(blocks)) => match &blocks[0] {
+                    Block::Custom(inner) => assert_eq!(inner.type_name, "PanelTabset"),
+                    _ => panic!("Expected the inner tabset to be converted"),
+                },
+                _ => panic!("Expected content slot with Blocks"),
+            },
+            _ => panic!("Expected Custom block"),
+        }
+    }
+}
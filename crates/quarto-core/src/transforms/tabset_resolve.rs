@@ -0,0 +1,320 @@
+/*
+ * tabset_resolve.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Transform that resolves PanelTabset CustomNodes to standard Pandoc AST.
+ */
+
+//! Tabset resolution transform.
+//!
+//! This transform converts PanelTabset CustomNodes back to standard Pandoc
+//! AST, with the structure of Bootstrap tabs, so that the HTML writer stays
+//! generic (see [`CalloutResolveTransform`](super::CalloutResolveTransform)).
+//!
+//! ## Pipeline Order
+//!
+//! This transform should run AFTER `TabsetTransform`:
+//! 1. `TabsetTransform`: Div with `.panel-tabset` → CustomNode("PanelTabset")
+//! 2. `TabsetResolveTransform`: CustomNode("PanelTabset") → Div with tab markup
+//!
+//! ## Output Structure
+//!
+//! Tabsets are numbered in document order, and their tabs from 1:
+//!
+//! ```text
+//! Div.panel-tabset [group → data-group]
+//!   RawBlock(html, "<ul class=\"nav nav-tabs\" role=\"tablist\">")
+//!   Plain[RawInline(html, "<li ...><a id=\"tabset-1-1-tab\" ...>"), title inlines..., RawInline(html, "</a></li>")]
+//!   ...
+//!   RawBlock(html, "</ul>")
+//!   Div.tab-content
+//!     Div#tabset-1-1.tab-pane.active [role=tabpanel, aria-labelledby=tabset-1-1-tab]
+//!       [content blocks...]
+//!     ...
+//! ```
+//!
+//! The first tab is the active one.
+
+use hashlink::LinkedHashMap;
+use quarto_pandoc_types::attr::{Attr, AttrSourceInfo};
+use quarto_pandoc_types::block::{Block, Div, Plain, RawBlock};
+use quarto_pandoc_types::custom::{CustomNode, Slot};
+use quarto_pandoc_types::inline::{Inline, RawInline};
+use quarto_pandoc_types::pandoc::Pandoc;
+use quarto_pandoc_types::walk::{Control, Order, walk_blocks_mut};
+use quarto_source_map::SourceInfo;
+
+use crate::Result;
+use crate::render::RenderContext;
+use crate::transform::AstTransform;
+
+/// Transform that resolves PanelTabset CustomNodes to standard Pandoc Div
+/// structure.
+pub struct TabsetResolveTransform;
+
+impl TabsetResolveTransform {
+    /// Create a new tabset resolve transform.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for TabsetResolveTransform {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AstTransform for TabsetResolveTransform {
+    fn name(&self) -> &str {
+        "tabset-resolve"
+    }
+
+    fn transform(&self, ast: &mut Pandoc, _ctx: &mut RenderContext) -> Result<()> {
+        let mut tabsets = 0;
+        // Top-down, so that tabsets are numbered in document order
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::TopDown, |block| {
+            if let Block::Custom(custom) = block
+                && custom.type_name == "PanelTabset"
+            {
+                tabsets += 1;
+                *block = Block::Div(resolve_tabset(custom, tabsets));
+            }
+            Control::Continue
+        });
+        Ok(())
+    }
+}
+
+/// Resolve a PanelTabset CustomNode to a Div with tab markup.
+///
+/// `number` is the tabset's number in the document, used in the ids of its
+/// tabs.
+fn resolve_tabset(custom: &mut CustomNode, number: usize) -> Div {
+    let source_info = custom.source_info.clone();
+    let tabs = custom
+        .plain_data
+        .get("tabs")
+        .and_then(|v| v.as_u64())
+        .unwrap_or(0) as usize;
+
+    let raw_block = |text: &str| {
+        Block::RawBlock(RawBlock {
+            format: "html".to_string(),
+            text: text.to_string(),
+            source_info: source_info.clone(),
+        })
+    };
+    let raw_inline = |text: String| {
+        Inline::RawInline(RawInline {
+            format: "html".to_string(),
+            text,
+            source_info: source_info.clone(),
+        })
+    };
+
+    let mut nav = vec![raw_block("<ul class=\"nav nav-tabs\" role=\"tablist\">")];
+    let mut panes = Vec::new();
+    for i in 1..=tabs {
+        let id = format!("tabset-{}-{}", number, i);
+        let active = i == 1;
+
+        let mut item = vec![raw_inline(format!(
+            "<li class=\"nav-item\" role=\"presentation\"><a class=\"nav-link{}\" \
+             id=\"{id}-tab\" data-bs-toggle=\"tab\" data-bs-target=\"#{id}\" role=\"tab\" \
+             aria-controls=\"{id}\" aria-selected=\"{}\" href=\"\">",
+            if active { " active" } else { "" },
+            active,
+        ))];
+        if let Some(Slot::Inlines(title)) = custom.slots.remove(&format!("title-{}", i)) {
+            item.extend(title);
+        }
+        item.push(raw_inline("</a></li>".to_string()));
+        nav.push(Block::Plain(Plain {
+            content: item,
+            source_info: source_info.clone(),
+        }));
+
+        let mut classes = vec!["tab-pane".to_string()];
+        if active {
+            classes.push("active".to_string());
+        }
+        let mut attrs = LinkedHashMap::new();
+        attrs.insert("role".to_string(), "tabpanel".to_string());
+        attrs.insert("aria-labelledby".to_string(), format!("{}-tab", id));
+        panes.push(Block::Div(Div {
+            attr: (id, classes, attrs),
+            content: match custom.slots.remove(&format!("content-{}", i)) {
+                Some(Slot::Blocks(blocks)) => blocks,
+                Some(Slot::Block(block)) => vec![*block],
+                _ => Vec::new(),
+            },
+            source_info: source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        }));
+    }
+    nav.push(raw_block("</ul>"));
+
+    nav.push(Block::Div(Div {
+        attr: make_attr(&["tab-content"]),
+        content: panes,
+        source_info: source_info.clone(),
+        attr_source: AttrSourceInfo::empty(),
+    }));
+
+    Div {
+        attr: custom.attr.clone(),
+        content: nav,
+        source_info,
+        attr_source: AttrSourceInfo::empty(),
+    }
+}
+
+/// Create an Attr with the given classes.
+fn make_attr(classes: &[&str]) -> Attr {
+    (
+        String::new(),
+        classes.iter().map(|s| (*s).to_string()).collect(),
+        LinkedHashMap::new(),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_pandoc_types::block::Paragraph;
+    use quarto_pandoc_types::inline::Str;
+    use serde_json::json;
+
+    use crate::format::Format;
+    use crate::project::{DocumentInfo, ProjectContext};
+    use crate::render::BinaryDependencies;
+
+    fn make_test_project() -> ProjectContext {
+        ProjectContext {
+            dir: std::path::PathBuf::from("/project"),
+            config: None,
+            is_single_file: true,
+            files: vec![DocumentInfo::from_path("/project/doc.qmd")],
+            output_dir: std::path::PathBuf::from("/project"),
+        }
+    }
+
+    fn text(s: &str) -> Vec<Inline> {
+        vec![Inline::Str(Str {
+            text: s.to_string(),
+            source_info: SourceInfo::default(),
+        })]
+    }
+
+    fn tabset(titles: &[&str]) -> CustomNode {
+        let mut custom = CustomNode::new(
+            "PanelTabset",
+            (
+                String::new(),
+                vec!["panel-tabset".to_string()],
+                LinkedHashMap::new(),
+            ),
+            SourceInfo::default(),
+        );
+        custom.plain_data = json!({"tabs": titles.len(), "group": null});
+        for (i, title) in titles.iter().enumerate() {
+            custom.set_slot(format!("title-{}", i + 1), Slot::Inlines(text(title)));
+            custom.set_slot(
+                format!("content-{}", i + 1),
+                Slot::Blocks(vec![Block::Paragraph(Paragraph {
+                    content: text(&format!("{} content", title)),
+                    source_info: SourceInfo::default(),
+                })]),
+            );
+        }
+        custom
+    }
+
+    #[test]
+    fn test_transform_name() {
+        assert_eq!(TabsetResolveTransform::new().name(), "tabset-resolve");
+    }
+
+    #[test]
+    fn test_resolve_tabset() {
+        let resolved = resolve_tabset(&mut tabset(&["R", "Python"]), 1);
+
+        let (_, classes, _) = &resolved.attr;
+        assert_eq!(classes, &vec!["panel-tabset".to_string()]);
+        // <ul>, two nav items, </ul>, tab content
+        assert_eq!(resolved.content.len(), 5);
+
+        match &resolved.content[2] {
+            Block::Plain(plain) => {
+                match &plain.content[0] {
+                    Inline::RawInline(raw) => {
+                        assert!(raw.text.contains("id=\"tabset-1-2-tab\""));
+                        assert!(raw.text.contains("aria-selected=\"false\""));
+                        assert!(!raw.text.contains("active"));
+                    }
+                    _ => panic!("Expected RawInline"),
+                }
+                assert_eq!(&plain.content[1..2], &text("Python")[..]);
+            }
+            _ => panic!("Expected nav item Plain"),
+        }
+
+        match &resolved.content[4] {
+            Block::Div(content) => {
+                assert_eq!(content.attr.1, vec!["tab-content".to_string()]);
+                let panes: Vec<_> = content
+                    .content
+                    .iter()
+                    .map(|pane| match pane {
+                        Block::Div(pane) => pane.attr.clone(),
+                        _ => panic!("Expected tab pane Div"),
+                    })
+                    .collect();
+                assert_eq!(panes[0].0, "tabset-1-1");
+                assert_eq!(panes[0].1, vec!["tab-pane", "active"]);
+                assert_eq!(panes[1].1, vec!["tab-pane"]);
+                assert_eq!(
+                    panes[1].2.get("aria-labelledby").map(String::as_str),
+                    Some("tabset-1-2-tab")
+                );
+            }
+            _ => panic!("Expected tab content Div"),
+        }
+    }
+
+    #[test]
+    fn test_tabsets_numbered_in_document_order() {
+        let mut outer = tabset(&["Outer"]);
+        outer.set_slot(
+            "content-1",
+            Slot::Blocks(vec![Block::Custom(tabset(&["Inner"]))]),
+        );
+        let mut ast = Pandoc {
+            meta: quarto_pandoc_types::ConfigValue::default(),
+            blocks: vec![Block::Custom(outer), Block::Custom(tabset(&["Last"]))],
+        };
+
+        let project = make_test_project();
+        let doc = DocumentInfo::from_path("/project/doc.qmd");
+        let format = Format::html();
+        let binaries = BinaryDependencies::new();
+        let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+        TabsetResolveTransform::new()
+            .transform(&mut ast, &mut ctx)
+            .unwrap();
+
+        let mut ids = Vec::new();
+        let _ = walk_blocks_mut(&mut ast.blocks, Order::TopDown, |block| {
+            match block {
+                Block::Custom(_) => panic!("Expected every tabset to be resolved"),
+                Block::Div(div) if div.attr.1.contains(&"tab-pane".to_string()) => {
+                    ids.push(div.attr.0.clone());
+                }
+                _ => {}
+            }
+            Control::Continue
+        });
+        assert_eq!(ids, vec!["tabset-1-1", "tabset-2-1", "tabset-3-1"]);
+    }
+}
@@ -600,8 +600,17 @@ async fn test_jupyter_transform_inline_expression() {
 // =============================================================================
 
 use quarto_core::pipeline::{HtmlRenderConfig, render_qmd_to_html};
+use quarto_core::render::RenderOptions;
 use std::sync::Arc;
 
+/// Render options for `quarto render --execute`.
+fn execute() -> RenderOptions {
+    RenderOptions {
+        execute: true,
+        ..Default::default()
+    }
+}
+
 /// Test that the full render pipeline can execute Python code.
 ///
 /// This tests the complete flow:
@@ -638,7 +647,7 @@ The end.
     let doc = DocumentInfo::from_path(std::env::current_dir().unwrap().join("test.qmd"));
     let format = Format::html();
     let binaries = BinaryDependencies::new();
-    let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+    let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(execute());
 
     let config = HtmlRenderConfig::default();
     let runtime = Arc::new(quarto_system_runtime::NativeRuntime::new());
@@ -699,7 +708,7 @@ print(f"The answer is {x}")
     let doc = DocumentInfo::from_path(std::env::current_dir().unwrap().join("test.qmd"));
     let format = Format::html();
     let binaries = BinaryDependencies::new();
-    let mut ctx = RenderContext::new(&project, &doc, &format, &binaries);
+    let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(execute());
 
     let config = HtmlRenderConfig::default();
     let runtime = Arc::new(quarto_system_runtime::NativeRuntime::new());
@@ -723,3 +732,62 @@ print(f"The answer is {x}")
         }
     }
 }
+
+/// Test that cell options are applied in the pipeline.
+#[tokio::test(flavor = "multi_thread")]
+#[ignore = "requires ipykernel"]
+async fn test_full_pipeline_cell_options() {
+    if !python_kernel_available().await {
+        eprintln!("Python kernel not available, skipping test");
+        return;
+    }
+
+    // No engine is declared: the python cells select jupyter
+    let content = br#"---
+title: Cell Options Test
+---
+
+```{python}
+#| echo: false
+answer = 6 * 7
+print(f"the answer is {answer}")
+```
+
+```{python}
+#| eval: false
+print("never run")
+```
+
+```{python}
+#| include: false
+hidden = "from a hidden cell"
+```
+
+```{python}
+print(hidden)
+```
+"#;
+
+    let project = make_test_project();
+    let doc = DocumentInfo::from_path(std::env::current_dir().unwrap().join("test.qmd"));
+    let format = Format::html();
+    let binaries = BinaryDependencies::new();
+    let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(execute());
+
+    let config = HtmlRenderConfig::default();
+    let runtime = Arc::new(quarto_system_runtime::NativeRuntime::new());
+
+    let output = render_qmd_to_html(content, "test.qmd", &mut ctx, &config, runtime)
+        .await
+        .expect("Pipeline failed");
+
+    // echo: false keeps the output and drops the code
+    assert!(output.html.contains("the answer is 42"));
+    assert!(!output.html.contains("6 * 7"));
+    // eval: false shows the code without running it
+    assert!(output.html.contains("never run"));
+    assert_eq!(output.html.matches("never run").count(), 1);
+    // include: false runs the cell but leaves it out
+    assert!(!output.html.contains("hidden = "));
+    assert!(output.html.contains("from a hidden cell"));
+}
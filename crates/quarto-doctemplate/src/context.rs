@@ -142,6 +142,27 @@ impl TemplateValue {
     }
 }
 
+impl From<&serde_json::Value> for TemplateValue {
+    /// Convert JSON to a template value. Numbers become strings, as in
+    /// Pandoc's doctemplates.
+    fn from(value: &serde_json::Value) -> Self {
+        match value {
+            serde_json::Value::Null => TemplateValue::Null,
+            serde_json::Value::Bool(b) => TemplateValue::Bool(*b),
+            serde_json::Value::Number(n) => TemplateValue::String(n.to_string()),
+            serde_json::Value::String(s) => TemplateValue::String(s.clone()),
+            serde_json::Value::Array(items) => {
+                TemplateValue::List(items.iter().map(TemplateValue::from).collect())
+            }
+            serde_json::Value::Object(map) => TemplateValue::Map(
+                map.iter()
+                    .map(|(k, v)| (k.clone(), TemplateValue::from(v)))
+                    .collect(),
+            ),
+        }
+    }
+}
+
 /// A context for template evaluation containing variable bindings.
 #[derive(Debug, Clone, Default)]
 pub struct TemplateContext {
@@ -215,6 +236,21 @@ mod tests {
         assert!(!TemplateValue::Null.is_truthy());
     }
 
+    #[test]
+    fn test_from_json() {
+        let json = serde_json::json!({"title": "T", "n": 3, "draft": false, "tags": ["a"]});
+        let TemplateValue::Map(map) = TemplateValue::from(&json) else {
+            panic!("an object should be a map");
+        };
+        assert_eq!(map["title"], TemplateValue::String("T".to_string()));
+        assert_eq!(map["n"], TemplateValue::String("3".to_string()));
+        assert_eq!(map["draft"], TemplateValue::Bool(false));
+        assert_eq!(
+            map["tags"],
+            TemplateValue::List(vec![TemplateValue::String("a".to_string())])
+        );
+    }
+
     #[test]
     fn test_get_path() {
         let mut inner = HashMap::new();
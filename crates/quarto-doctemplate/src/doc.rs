@@ -24,6 +24,8 @@
 //! - `Prefixed`: prefix each line (for nesting)
 //! - `BreakingSpace`: space that can break at line wrap
 //! - `NewLine`: hard newline
+//! - `Nest` and `Value`: the `$^$` directive and the interpolated values it
+//!   nests, whose indentation depends on the column `$^$` renders at
 
 /// A structured document representation.
 ///
@@ -51,6 +53,13 @@ pub enum Doc {
 
     /// A hard newline.
     NewLine,
+
+    /// The `$^$` directive. Values interpolated after it on the same line
+    /// have their lines after the first indented to its column.
+    Nest,
+
+    /// An interpolated value, nested by a `$^$` earlier on its line.
+    Value(Box<Doc>),
 }
 
 impl Doc {
@@ -85,6 +94,8 @@ impl Doc {
             Doc::Prefixed(_, inner) => inner.is_empty(),
             Doc::BreakingSpace => false,
             Doc::NewLine => false,
+            Doc::Nest => true,
+            Doc::Value(inner) => inner.is_empty(),
         }
     }
 
@@ -98,6 +109,16 @@ impl Doc {
         }
     }
 
+    /// Mark a document as an interpolated value, so that a `$^$` before it
+    /// nests it.
+    pub fn value(inner: Doc) -> Self {
+        if inner.is_empty() {
+            Doc::Empty
+        } else {
+            Doc::Value(Box::new(inner))
+        }
+    }
+
     /// Create a document from a newline.
     pub fn newline() -> Self {
         Doc::NewLine
@@ -154,8 +175,10 @@ impl Doc {
                 }
             }
 
+            Doc::Value(inner) => Doc::value(inner.remove_final_newline()),
+
             // Everything else passes through unchanged
-            // (Empty, Prefixed, BreakingSpace)
+            // (Empty, Prefixed, BreakingSpace, Nest)
             other => other,
         }
     }
@@ -170,29 +193,71 @@ impl Doc {
     /// The current implementation ignores `line_width` and does not
     /// perform reflowing. This may be added in a future version.
     pub fn render(&self, _line_width: Option<usize>) -> String {
-        self.render_simple()
+        let mut output = Output::default();
+        self.render_simple(&mut output);
+        output.text
     }
 
     /// Render without any line width constraints.
-    fn render_simple(&self) -> String {
+    fn render_simple(&self, output: &mut Output) {
         match self {
-            Doc::Empty => String::new(),
-            Doc::Text(s) => s.clone(),
+            Doc::Empty => {}
+            Doc::Text(s) => output.write(s),
             Doc::Concat(a, b) => {
-                let mut result = a.render_simple();
-                result.push_str(&b.render_simple());
-                result
+                a.render_simple(output);
+                b.render_simple(output);
             }
             Doc::Prefixed(prefix, inner) => {
-                let inner_str = inner.render_simple();
-                apply_prefix(&inner_str, prefix)
+                output.write(&apply_prefix(&inner.render(None), prefix))
+            }
+            Doc::BreakingSpace => output.write(" "),
+            Doc::NewLine => output.write("\n"),
+            Doc::Nest => output.nest = Some(output.column()),
+            Doc::Value(inner) => {
+                let text = inner.render(None);
+                match output.nest {
+                    Some(column) => output.write_value(&nest_lines(&text, column)),
+                    None => output.write_value(&text),
+                }
             }
-            Doc::BreakingSpace => " ".to_string(),
-            Doc::NewLine => "\n".to_string(),
         }
     }
 }
 
+/// Rendered text, with the state that nesting needs.
+#[derive(Default)]
+struct Output {
+    text: String,
+    /// Byte offset of the current line in `text`
+    line_start: usize,
+    /// The column of a `$^$` on the current line
+    nest: Option<usize>,
+}
+
+impl Output {
+    /// The width of the current line.
+    fn column(&self) -> usize {
+        self.text[self.line_start..].chars().count()
+    }
+
+    /// Write template text. A newline ends the line of a `$^$`.
+    fn write(&mut self, s: &str) {
+        self.write_value(s);
+        if s.contains('\n') {
+            self.nest = None;
+        }
+    }
+
+    /// Write an interpolated value: its newlines don't end the line of a
+    /// `$^$`.
+    fn write_value(&mut self, s: &str) {
+        if let Some(i) = s.rfind('\n') {
+            self.line_start = self.text.len() + i + 1;
+        }
+        self.text.push_str(s);
+    }
+}
+
 /// Apply a prefix to each line after the first.
 ///
 /// The first line is not prefixed (it continues from the current position).
@@ -214,6 +279,24 @@ fn apply_prefix(s: &str, prefix: &str) -> String {
     result
 }
 
+/// Indent the lines of `s` after the first to `column`, leaving blank lines
+/// blank.
+fn nest_lines(s: &str, column: usize) -> String {
+    let indent = " ".repeat(column);
+    let lines: Vec<String> = s
+        .split('\n')
+        .enumerate()
+        .map(|(i, line)| {
+            if i == 0 || line.is_empty() {
+                line.to_string()
+            } else {
+                format!("{}{}", indent, line)
+            }
+        })
+        .collect();
+    lines.join("\n")
+}
+
 /// Concatenate multiple documents.
 pub fn concat_docs(docs: impl IntoIterator<Item = Doc>) -> Doc {
     docs.into_iter()
@@ -346,6 +429,21 @@ mod tests {
         assert_eq!(outer.render(None), "line1\n>   line2");
     }
 
+    #[test]
+    fn test_nest() {
+        // Values after `$^$` are nested to its column, until a newline in
+        // the template
+        let value = || Doc::value(Doc::text("a\nb\n\nc"));
+        let doc = Doc::text("- ")
+            .concat(Doc::Nest)
+            .concat(value())
+            .concat(Doc::text(" "))
+            .concat(value())
+            .concat(Doc::newline())
+            .concat(value());
+        assert_eq!(doc.render(None), "- a\n  b\n\n  c a\n  b\n\n  c\na\nb\n\nc");
+    }
+
     #[test]
     fn test_remove_final_newline_from_newline() {
         // NewLine node becomes Empty
@@ -37,6 +37,10 @@ pub enum TemplateError {
     #[error("Invalid arguments for pipe '{pipe}': {message}")]
     InvalidPipeArgs { pipe: String, message: String },
 
+    /// The formatter would change what the template renders.
+    #[error("Formatting changes the template: {message}")]
+    FormatChangesOutput { message: String },
+
     /// I/O error (e.g., reading partial file).
     #[error("I/O error: {0}")]
     Io(#[from] std::io::Error),
@@ -16,7 +16,9 @@ use crate::doc::{Doc, concat_docs, intersperse_docs};
 use crate::error::TemplateResult;
 use crate::eval_context::EvalContext;
 use crate::parser::Template;
+use crate::pipes::apply_pipes;
 use quarto_error_reporting::DiagnosticMessage;
+use std::borrow::Cow;
 
 impl Template {
     /// Render this template with the given context.
@@ -163,9 +165,9 @@ fn evaluate_node(node: &TemplateNode, ctx: &mut EvalContext) -> TemplateResult<D
         TemplateNode::Partial(partial) => evaluate_partial(partial, ctx),
 
         TemplateNode::Nesting(Nesting { children, .. }) => {
-            // TODO: Implement nesting/indentation tracking
-            // For now, just evaluate children without nesting
-            evaluate_nodes(children, ctx)
+            // The column `$^$` renders at is only known when the Doc is
+            // rendered
+            Ok(Doc::Nest.concat(evaluate_nodes(children, ctx)?))
         }
 
         TemplateNode::BreakableSpace(BreakableSpace { children, .. }) => {
@@ -192,23 +194,30 @@ fn resolve_variable<'a>(
     variables.get_path(&path)
 }
 
+/// Resolve a variable reference and apply its pipes.
+fn resolve_piped<'a>(
+    var: &VariableRef,
+    variables: &'a TemplateContext,
+) -> Option<Cow<'a, TemplateValue>> {
+    resolve_variable(var, variables).map(|value| apply_pipes(value, &var.pipes))
+}
+
 /// Render a variable reference to a Doc.
 fn render_variable(var: &VariableRef, ctx: &mut EvalContext) -> Doc {
-    match resolve_variable(var, ctx.variables) {
+    match resolve_piped(var, ctx.variables) {
         Some(value) => {
             // Handle literal separator for arrays: $var[, ]$
             if let Some(sep) = &var.separator
-                && let TemplateValue::List(items) = value
+                && let TemplateValue::List(items) = &*value
             {
                 let docs: Vec<Doc> = items
                     .iter()
                     .map(|v| v.to_doc().remove_final_newline())
                     .collect();
-                return intersperse_docs(docs, Doc::text(sep));
+                return Doc::value(intersperse_docs(docs, Doc::text(sep)));
             }
-            // TODO: Apply pipes
             // Strip final newline from variable values (matches Pandoc's removeFinalNl)
-            value.to_doc().remove_final_newline()
+            Doc::value(value.to_doc().remove_final_newline())
         }
         None => {
             // Emit warning or error depending on strict mode
@@ -253,14 +262,15 @@ fn evaluate_for_loop(
     separator: &Option<Vec<TemplateNode>>,
     ctx: &mut EvalContext,
 ) -> TemplateResult<Doc> {
-    let value = resolve_variable(var, ctx.variables);
+    // Pipes apply to what is iterated: $for(meta/pairs)$
+    let value = resolve_piped(var, ctx.variables);
 
     // Determine what to iterate over
-    let items: Vec<&TemplateValue> = match value {
+    let items: Vec<&TemplateValue> = match value.as_deref() {
         Some(TemplateValue::List(items)) => items.iter().collect(),
-        Some(TemplateValue::Map(_)) => vec![value.unwrap()], // Single iteration over map
-        Some(v) if v.is_truthy() => vec![v],                 // Single iteration for truthy scalars
-        _ => vec![],                                         // No iterations for null/falsy
+        Some(map @ TemplateValue::Map(_)) => vec![map], // Single iteration over map
+        Some(v) if v.is_truthy() => vec![v],            // Single iteration for truthy scalars
+        _ => vec![],                                    // No iterations for null/falsy
     };
 
     if items.is_empty() {
@@ -334,13 +344,10 @@ fn evaluate_partial(partial: &Partial, ctx: &mut EvalContext) -> TemplateResult<
         }
     };
 
-    // TODO: Apply pipes to partial output
-    let _ = pipes;
-
-    match var {
+    let doc = match var {
         None => {
             // Bare partial: evaluate with current context
-            evaluate_nodes(nodes, ctx)
+            evaluate_nodes(nodes, ctx)?
         }
         Some(var_ref) => {
             // Applied partial: evaluate with var's value as context
@@ -355,7 +362,7 @@ fn evaluate_partial(partial: &Partial, ctx: &mut EvalContext) -> TemplateResult<
                         format!("Undefined variable: {}", var_path),
                         &var_ref.source_info,
                     );
-                    Ok(Doc::Empty)
+                    return Ok(Doc::Empty);
                 }
                 Some(TemplateValue::List(items)) => {
                     // Iterate over list items
@@ -370,9 +377,9 @@ fn evaluate_partial(partial: &Partial, ctx: &mut EvalContext) -> TemplateResult<
 
                     // Join with separator
                     if let Some(sep) = separator {
-                        Ok(intersperse_docs(results, Doc::text(sep)))
+                        intersperse_docs(results, Doc::text(sep))
                     } else {
-                        Ok(concat_docs(results))
+                        concat_docs(results)
                     }
                 }
                 Some(value) => {
@@ -381,11 +388,18 @@ fn evaluate_partial(partial: &Partial, ctx: &mut EvalContext) -> TemplateResult<
                     let mut child_ctx = ctx.child(&item_ctx);
                     let result = evaluate_nodes(nodes, &mut child_ctx)?;
                     ctx.merge_diagnostics(child_ctx);
-                    Ok(result)
+                    result
                 }
             }
         }
+    };
+
+    // Pipes apply to the rendered partial: $date:meta()/uppercase$
+    if pipes.is_empty() {
+        return Ok(Doc::value(doc));
     }
+    let output = TemplateValue::String(doc.render(None));
+    Ok(Doc::value(apply_pipes(&output, pipes).to_doc()))
 }
 
 // Re-export the old evaluate function for backwards compatibility
@@ -739,6 +753,31 @@ mod tests {
         assert_eq!(diagnostics.len(), 2);
     }
 
+    #[test]
+    fn test_variable_pipes() {
+        let template = compile("$title/uppercase$ $tags/rest/first$ $tags/length$");
+        let mut ctx = ctx();
+        ctx.insert("title", TemplateValue::String("Hello".to_string()));
+        ctx.insert(
+            "tags",
+            TemplateValue::List(vec![
+                TemplateValue::String("a".to_string()),
+                TemplateValue::String("b".to_string()),
+            ]),
+        );
+        assert_eq!(template.render(&ctx).unwrap(), "HELLO b 2");
+    }
+
+    #[test]
+    fn test_nesting() {
+        // Lines after the first of a value after `$^$` are indented to its
+        // column, up to the end of the line in the template
+        let template = compile("- $^$$body$\n$body$");
+        let mut ctx = ctx();
+        ctx.insert("body", TemplateValue::String("one\ntwo".to_string()));
+        assert_eq!(template.render(&ctx).unwrap(), "- one\n  two\none\ntwo");
+    }
+
     // Partial tests using MemoryResolver for in-memory partials
 
     use crate::resolver::MemoryResolver;
@@ -862,6 +901,17 @@ mod tests {
         assert_eq!(template.render(&ctx).unwrap(), "<b>Alice</b>");
     }
 
+    #[test]
+    fn test_partial_pipes() {
+        // Pipes apply to the rendered partial
+        let template = compile_with_partials("$name:bold()/uppercase$", [("bold", "<b>$it$</b>")]);
+
+        let mut ctx = ctx();
+        ctx.insert("name", TemplateValue::String("Alice".to_string()));
+
+        assert_eq!(template.render(&ctx).unwrap(), "<B>ALICE</B>");
+    }
+
     #[test]
     fn test_partial_missing_variable_warning() {
         // Undefined variable in applied partial should emit warning
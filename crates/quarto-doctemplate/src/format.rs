@@ -0,0 +1,291 @@
+/*
+ * format.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Template formatter.
+//!
+//! Whitespace in a template is output, so a template can't be indented the
+//! way code is: the spaces before an `$if(...)$` end up in the document.
+//! The one place whitespace is free is inside a directive's delimiters, so
+//! [`format`] indents there. A directive that starts a line is indented two
+//! spaces for each `$if$` or `$for$` it is in, with `$else$`, `$elseif$` and
+//! `$sep$` at the level of their block:
+//!
+//! ```text
+//! $if(title)$
+//! $  for(author)$
+//! <meta name="author" content="$author$" />
+//! $  endfor$
+//! $endif$
+//! ```
+//!
+//! A directive after other text on its line is not indented. Elsewhere the
+//! whitespace inside delimiters is removed: `$ if( title ) $` becomes
+//! `$if(title)$`. Text outside directives and the `$...$` or `${...}` form
+//! of each directive are kept.
+//!
+//! The result is checked before it is returned: it must be formatted
+//! itself, and render the same as the original for a set of contexts made
+//! from the variables the template uses.
+
+use crate::ast::{TemplateNode, VariableRef};
+use crate::context::{TemplateContext, TemplateValue};
+use crate::error::{TemplateError, TemplateResult};
+use crate::lint::directives;
+use crate::parser::Template;
+use std::collections::HashMap;
+
+/// The indentation of one level of nesting.
+const INDENT: &str = "  ";
+
+/// Format a template.
+///
+/// # Returns
+/// The formatted source, or an error if the template doesn't parse, or if
+/// the formatted template would render differently (which is a bug).
+pub fn format(source: &str) -> TemplateResult<String> {
+    let original = Template::compile(source)?;
+    let formatted = layout(source);
+    if layout(&formatted) != formatted {
+        return Err(TemplateError::FormatChangesOutput {
+            message: "formatting it again changes it again".to_string(),
+        });
+    }
+    let template = Template::compile(&formatted)?;
+    for context in fixture_contexts(&original.nodes) {
+        let before = original.render(&context).map_err(|e| e.to_string());
+        let after = template.render(&context).map_err(|e| e.to_string());
+        if before != after {
+            return Err(TemplateError::FormatChangesOutput {
+                message: "the formatted template renders differently".to_string(),
+            });
+        }
+    }
+    Ok(formatted)
+}
+
+/// The kind of a directive, for indentation.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Kind {
+    /// `$if(...)$` and `$for(...)$`
+    Open,
+    /// `$else$`, `$elseif(...)$` and `$sep$`
+    Divide,
+    /// `$endif$` and `$endfor$`
+    Close,
+    /// Interpolations and partials
+    Interpolate,
+}
+
+fn kind_of(text: &str) -> Kind {
+    let keyword = text.split(['(', ' ', '\t']).next().unwrap_or_default();
+    match keyword {
+        "if" | "for" => Kind::Open,
+        "else" | "elseif" | "sep" => Kind::Divide,
+        "endif" | "endfor" => Kind::Close,
+        _ => Kind::Interpolate,
+    }
+}
+
+/// Rewrite the whitespace inside the delimiters of each directive.
+fn layout(source: &str) -> String {
+    let mut out = String::with_capacity(source.len());
+    let mut copied = 0;
+    let mut depth: usize = 0;
+    for (text, start, end) in directives(source) {
+        // `$^$` and `$~$` take no whitespace
+        if matches!(text, "^" | "~") {
+            continue;
+        }
+        let kind = kind_of(text);
+        if kind == Kind::Close {
+            depth = depth.saturating_sub(1);
+        }
+        let level = match kind {
+            Kind::Divide => depth.saturating_sub(1),
+            _ => depth,
+        };
+        let braced = source[start..].starts_with("${");
+
+        out.push_str(&source[copied..start]);
+        out.push_str(if braced { "${" } else { "$" });
+        if start == 0 || source[..start].ends_with('\n') {
+            out.push_str(&INDENT.repeat(level));
+        }
+        if kind == Kind::Interpolate {
+            out.push_str(text);
+        } else {
+            // Keywords and conditions have no whitespace of their own
+            out.extend(text.chars().filter(|c| *c != ' ' && *c != '\t'));
+        }
+        out.push_str(if braced { "}" } else { "$" });
+        copied = end;
+
+        if kind == Kind::Open {
+            depth += 1;
+        }
+    }
+    out.push_str(&source[copied..]);
+    out
+}
+
+/// Contexts to compare renders in: an empty one, one that sets every
+/// variable the template uses, and one where each of those is a list of
+/// two, for `$sep$` and the other pipes on lists.
+fn fixture_contexts(nodes: &[TemplateNode]) -> Vec<TemplateContext> {
+    let mut paths = Vec::new();
+    collect_paths(nodes, &mut paths);
+
+    let mut root: HashMap<String, TemplateValue> = HashMap::new();
+    for path in &paths {
+        insert_path(&mut root, path);
+    }
+
+    let mut set = TemplateContext::new();
+    let mut lists = TemplateContext::new();
+    for (name, value) in root {
+        lists.insert(
+            name.clone(),
+            TemplateValue::List(vec![value.clone(), value.clone()]),
+        );
+        set.insert(name, value);
+    }
+    vec![TemplateContext::new(), set, lists]
+}
+
+/// Set `path` in a map of values: the leaf to its name, and the fields on
+/// the way to maps. A variable that is used both as a value and as a map
+/// (`$a$` and `$a.b$`) is a map.
+fn insert_path(map: &mut HashMap<String, TemplateValue>, path: &[String]) {
+    let Some((first, rest)) = path.split_first() else {
+        return;
+    };
+    if rest.is_empty() {
+        map.entry(first.clone())
+            .or_insert_with(|| TemplateValue::String(format!("<{}>", first)));
+        return;
+    }
+    let entry = map.entry(first.clone()).or_default();
+    if !matches!(entry, TemplateValue::Map(_)) {
+        *entry = TemplateValue::Map(HashMap::new());
+    }
+    if let TemplateValue::Map(fields) = entry {
+        insert_path(fields, rest);
+    }
+}
+
+/// The variable paths a template uses, split at dots.
+fn collect_paths(nodes: &[TemplateNode], paths: &mut Vec<Vec<String>>) {
+    for node in nodes {
+        match node {
+            TemplateNode::Variable(var) => paths.push(path_of(var)),
+            TemplateNode::Conditional(cond) => {
+                for (condition, body) in &cond.branches {
+                    paths.push(path_of(condition));
+                    collect_paths(body, paths);
+                }
+                if let Some(else_branch) = &cond.else_branch {
+                    collect_paths(else_branch, paths);
+                }
+            }
+            TemplateNode::ForLoop(for_loop) => {
+                paths.push(path_of(&for_loop.var));
+                collect_paths(&for_loop.body, paths);
+                if let Some(separator) = &for_loop.separator {
+                    collect_paths(separator, paths);
+                }
+            }
+            TemplateNode::Partial(partial) => {
+                if let Some(var) = &partial.var {
+                    paths.push(path_of(var));
+                }
+            }
+            TemplateNode::Nesting(nesting) => collect_paths(&nesting.children, paths),
+            TemplateNode::BreakableSpace(space) => collect_paths(&space.children, paths),
+            TemplateNode::Literal(_) | TemplateNode::Comment(_) => {}
+        }
+    }
+}
+
+fn path_of(var: &VariableRef) -> Vec<String> {
+    var.path
+        .iter()
+        .flat_map(|part| part.split('.'))
+        .map(str::to_string)
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_indents_nested_directives() {
+        let source = "$if(title)$\n$for(author)$\n<meta content=\"$author$\" />\n\
+                      $sep$\n$endfor$\n$else$\n$body$\n$endif$\n";
+        assert_eq!(
+            format(source).unwrap(),
+            "$if(title)$\n$  for(author)$\n<meta content=\"$author$\" />\n\
+             $  sep$\n$  endfor$\n$else$\n$  body$\n$endif$\n"
+        );
+    }
+
+    #[test]
+    fn test_removes_stray_whitespace() {
+        assert_eq!(
+            format("A $ if( x ) $B$ else $C$endif$.\n").unwrap(),
+            "A $if(x)$B$else$C$endif$.\n"
+        );
+        assert_eq!(
+            format("${ title/uppercase }\n").unwrap(),
+            "${title/uppercase}\n"
+        );
+    }
+
+    #[test]
+    fn test_keeps_text_and_forms() {
+        let source = "  $if(a)$\n    $a$\n  $endif$\n${if(b)}\n${b[, ]}\n${endif}\n";
+        // Indentation that is text is output, so it stays
+        assert_eq!(
+            format(source).unwrap(),
+            "  $if(a)$\n    $a$\n  $endif$\n${if(b)}\n${  b[, ]}\n${endif}\n"
+        );
+    }
+
+    #[test]
+    fn test_is_idempotent() {
+        let source = "$for(a)$\n$if(a.b)$\n$a.b/uppercase$\n$endif$\n$endfor$\n";
+        let formatted = format(source).unwrap();
+        assert_eq!(format(&formatted).unwrap(), formatted);
+    }
+
+    #[test]
+    fn test_skips_comments_escapes_and_nesting() {
+        let source = "$-- $if(x)$ not a directive\n$$5 $^$$x$\n$~$a b$~$\n";
+        assert_eq!(format(source).unwrap(), source);
+    }
+
+    #[test]
+    fn test_parse_errors() {
+        assert!(format("$if(a)$\n").is_err());
+    }
+
+    #[test]
+    fn test_fixture_contexts() {
+        let template = Template::compile("$a$ $a.b$ $for(c)$$c$$endfor$").unwrap();
+        let contexts = fixture_contexts(&template.nodes);
+        assert_eq!(contexts.len(), 3);
+        assert_eq!(contexts[0].get("a"), None);
+        let c = TemplateValue::String("<c>".to_string());
+        assert_eq!(contexts[1].get("c"), Some(&c));
+        assert_eq!(
+            contexts[2].get("c"),
+            Some(&TemplateValue::List(vec![c.clone(), c]))
+        );
+        assert_eq!(
+            contexts[1].get_path(&["a", "b"]),
+            Some(&TemplateValue::String("<b>".to_string()))
+        );
+    }
+}
@@ -0,0 +1,409 @@
+/*
+ * graph.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Partial dependency graphs.
+//!
+//! [`partial_graph`] follows the partials of a template, and of its
+//! partials, and returns which file includes which. Build tools use it to
+//! know which templates a change to a partial affects: a format must be
+//! rebuilt when any file in the graph of its template changes.
+//!
+//! A partial is looked up next to the template that uses it, as when
+//! rendering, and then in each directory of the search path. Partials that
+//! include each other are not an error here; the edges that close a cycle
+//! are flagged, and the cycles listed.
+
+use crate::ast::TemplateNode;
+use crate::parser::Template;
+use crate::resolver::resolve_partial_path;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+/// The templates reachable from a root template through its partials, and
+/// which includes which.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct PartialGraph {
+    /// The templates, the root first, in the order they were found.
+    pub nodes: Vec<PartialNode>,
+    /// One edge for each partial a template uses, in template order.
+    pub edges: Vec<PartialEdge>,
+    /// The sets of templates that include each other, by index into
+    /// `nodes`, each in the order they were found.
+    pub cycles: Vec<Vec<usize>>,
+}
+
+/// A template in a [`PartialGraph`].
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct PartialNode {
+    /// The file of the template. For a partial that wasn't found, where it
+    /// was looked for first.
+    pub path: PathBuf,
+    #[serde(flatten)]
+    pub status: PartialStatus,
+}
+
+/// Whether a template could be read.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+#[serde(tag = "status", content = "message", rename_all = "lowercase")]
+pub enum PartialStatus {
+    /// The template was read and parsed.
+    Ok,
+    /// The partial is in none of the directories searched.
+    Missing,
+    /// The template couldn't be read or doesn't parse; its partials are
+    /// unknown.
+    Invalid(String),
+}
+
+/// A partial reference: `from` uses the partial `name`, which is `to`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct PartialEdge {
+    pub from: usize,
+    pub to: usize,
+    /// The name of the partial, as written: `header` in `$header()$`.
+    pub name: String,
+    /// The edge closes a cycle: `to` includes `from`, directly or not.
+    pub cyclic: bool,
+}
+
+impl PartialGraph {
+    /// The node of a file.
+    pub fn node(&self, path: &Path) -> Option<usize> {
+        self.nodes.iter().position(|node| node.path == path)
+    }
+
+    /// Check if a change to the file at `path` affects the root template:
+    /// whether it is in the graph, including where a missing partial would
+    /// be.
+    pub fn depends_on(&self, path: &Path) -> bool {
+        self.node(path).is_some()
+    }
+
+    /// The templates that use the template at `node`, directly or not.
+    pub fn dependents(&self, node: usize) -> Vec<usize> {
+        let mut found = vec![false; self.nodes.len()];
+        let mut stack = vec![node];
+        while let Some(current) = stack.pop() {
+            for edge in self.edges.iter().filter(|edge| edge.to == current) {
+                if !found[edge.from] {
+                    found[edge.from] = true;
+                    stack.push(edge.from);
+                }
+            }
+        }
+        (0..self.nodes.len()).filter(|i| found[*i]).collect()
+    }
+
+    /// Check if any partials include each other.
+    pub fn has_cycles(&self) -> bool {
+        !self.cycles.is_empty()
+    }
+}
+
+/// Build the partial graph of a template.
+///
+/// # Arguments
+/// * `root` - Path of the root template
+/// * `search_path` - Directories to look for partials in, after the
+///   directory of the template that uses them
+pub fn partial_graph(root: &Path, search_path: &[PathBuf]) -> PartialGraph {
+    let mut graph = PartialGraph {
+        nodes: Vec::new(),
+        edges: Vec::new(),
+        cycles: Vec::new(),
+    };
+    let mut index: HashMap<PathBuf, usize> = HashMap::new();
+    let mut queue = vec![read_node(root.to_path_buf(), &mut graph, &mut index)];
+    while let Some((from, names)) = queue.pop() {
+        let base = graph.nodes[from].path.clone();
+        for name in names {
+            let path = find_partial(&name, &base, search_path);
+            let to = match index.get(&path) {
+                Some(&to) => to,
+                None => {
+                    let (to, partials) = read_node(path, &mut graph, &mut index);
+                    queue.push((to, partials));
+                    to
+                }
+            };
+            graph.edges.push(PartialEdge {
+                from,
+                to,
+                name,
+                cyclic: false,
+            });
+        }
+        // Visit in the order found
+        queue.sort_by(|a, b| b.0.cmp(&a.0));
+    }
+    graph.edges.sort_by_key(|edge| edge.from);
+    graph.cycles = strongly_connected(&graph);
+    for cycle in &graph.cycles {
+        for edge in graph.edges.iter_mut() {
+            if cycle.contains(&edge.from) && cycle.contains(&edge.to) {
+                edge.cyclic = true;
+            }
+        }
+    }
+    graph
+}
+
+/// Where a partial is: next to the template that uses it, or else in the
+/// first directory of the search path that has it. When none has it, the
+/// path next to the template.
+fn find_partial(name: &str, base: &Path, search_path: &[PathBuf]) -> PathBuf {
+    let beside = resolve_partial_path(name, base);
+    if beside.is_file() {
+        return beside;
+    }
+    let file_name = base.file_name().unwrap_or_default();
+    search_path
+        .iter()
+        .map(|dir| resolve_partial_path(name, &dir.join(file_name)))
+        .find(|path| path.is_file())
+        .unwrap_or(beside)
+}
+
+/// Add the template at `path` to the graph. Returns its node and the names
+/// of its partials.
+fn read_node(
+    path: PathBuf,
+    graph: &mut PartialGraph,
+    index: &mut HashMap<PathBuf, usize>,
+) -> (usize, Vec<String>) {
+    let (status, partials) = match std::fs::read_to_string(&path) {
+        Ok(source) => match Template::compile(&source) {
+            Ok(template) => {
+                let mut partials = Vec::new();
+                collect_partials(&template.nodes, &mut partials);
+                (PartialStatus::Ok, partials)
+            }
+            Err(e) => (PartialStatus::Invalid(e.to_string()), Vec::new()),
+        },
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => (PartialStatus::Missing, Vec::new()),
+        Err(e) => (PartialStatus::Invalid(e.to_string()), Vec::new()),
+    };
+    let node = graph.nodes.len();
+    index.insert(path.clone(), node);
+    graph.nodes.push(PartialNode { path, status });
+    (node, partials)
+}
+
+/// The names of the partials used in nodes, in order, each once.
+fn collect_partials(nodes: &[TemplateNode], partials: &mut Vec<String>) {
+    for node in nodes {
+        match node {
+            TemplateNode::Partial(partial) => {
+                if !partials.contains(&partial.name) {
+                    partials.push(partial.name.clone());
+                }
+            }
+            TemplateNode::Conditional(cond) => {
+                for (_, body) in &cond.branches {
+                    collect_partials(body, partials);
+                }
+                if let Some(else_branch) = &cond.else_branch {
+                    collect_partials(else_branch, partials);
+                }
+            }
+            TemplateNode::ForLoop(for_loop) => {
+                collect_partials(&for_loop.body, partials);
+                if let Some(separator) = &for_loop.separator {
+                    collect_partials(separator, partials);
+                }
+            }
+            TemplateNode::Nesting(nesting) => collect_partials(&nesting.children, partials),
+            TemplateNode::BreakableSpace(space) => collect_partials(&space.children, partials),
+            TemplateNode::Literal(_) | TemplateNode::Variable(_) | TemplateNode::Comment(_) => {}
+        }
+    }
+}
+
+/// The strongly connected components of the graph that are cycles: more
+/// than one node, or a node that includes itself (Tarjan's algorithm).
+fn strongly_connected(graph: &PartialGraph) -> Vec<Vec<usize>> {
+    struct State<'g> {
+        graph: &'g PartialGraph,
+        next: usize,
+        order: Vec<Option<usize>>,
+        low: Vec<usize>,
+        stack: Vec<usize>,
+        on_stack: Vec<bool>,
+        components: Vec<Vec<usize>>,
+    }
+
+    fn visit(state: &mut State, node: usize) {
+        state.order[node] = Some(state.next);
+        state.low[node] = state.next;
+        state.next += 1;
+        state.stack.push(node);
+        state.on_stack[node] = true;
+
+        let targets: Vec<usize> = state
+            .graph
+            .edges
+            .iter()
+            .filter(|edge| edge.from == node)
+            .map(|edge| edge.to)
+            .collect();
+        for to in targets {
+            match state.order[to] {
+                None => {
+                    visit(state, to);
+                    state.low[node] = state.low[node].min(state.low[to]);
+                }
+                Some(order) if state.on_stack[to] => {
+                    state.low[node] = state.low[node].min(order);
+                }
+                Some(_) => {}
+            }
+        }
+
+        if Some(state.low[node]) == state.order[node] {
+            let mut component = Vec::new();
+            while let Some(member) = state.stack.pop() {
+                state.on_stack[member] = false;
+                component.push(member);
+                if member == node {
+                    break;
+                }
+            }
+            let looped = component.len() > 1
+                || state
+                    .graph
+                    .edges
+                    .iter()
+                    .any(|edge| edge.from == node && edge.to == node);
+            if looped {
+                component.sort_unstable();
+                state.components.push(component);
+            }
+        }
+    }
+
+    let count = graph.nodes.len();
+    let mut state = State {
+        graph,
+        next: 0,
+        order: vec![None; count],
+        low: vec![0; count],
+        stack: Vec::new(),
+        on_stack: vec![false; count],
+        components: Vec::new(),
+    };
+    for node in 0..count {
+        if state.order[node].is_none() {
+            visit(&mut state, node);
+        }
+    }
+    state.components.sort();
+    state.components
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+
+    fn write(dir: &Path, name: &str, content: &str) -> PathBuf {
+        let path = dir.join(name);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(&path, content).unwrap();
+        path
+    }
+
+    fn names(graph: &PartialGraph) -> Vec<(usize, usize, &str, bool)> {
+        graph
+            .edges
+            .iter()
+            .map(|edge| (edge.from, edge.to, edge.name.as_str(), edge.cyclic))
+            .collect()
+    }
+
+    #[test]
+    fn test_transitive_partials() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = write(
+            dir.path(),
+            "doc.html",
+            "$header()$\n$for(a)$$a:card()$$endfor$\n$header()$\n",
+        );
+        write(dir.path(), "header.html", "$title$ $inc/logo()$");
+        write(dir.path(), "card.html", "$it.name$");
+        write(dir.path(), "inc/logo.html", "<img>");
+
+        let graph = partial_graph(&root, &[]);
+        let paths: Vec<PathBuf> = graph.nodes.iter().map(|n| n.path.clone()).collect();
+        assert_eq!(
+            paths,
+            vec![
+                root.clone(),
+                dir.path().join("header.html"),
+                dir.path().join("card.html"),
+                dir.path().join("inc/logo.html"),
+            ]
+        );
+        assert_eq!(
+            names(&graph),
+            vec![
+                (0, 1, "header", false),
+                (0, 2, "card", false),
+                (1, 3, "inc/logo", false),
+            ]
+        );
+        assert!(!graph.has_cycles());
+        assert_eq!(graph.dependents(3), vec![0, 1]);
+        assert!(graph.depends_on(&dir.path().join("inc/logo.html")));
+    }
+
+    #[test]
+    fn test_search_path_and_missing_partials() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = write(dir.path(), "doc.tex", "$shared()$ $gone()$");
+        let shared = write(&dir.path().join("lib"), "shared.tex", "shared");
+
+        let graph = partial_graph(&root, &[dir.path().join("lib")]);
+        assert_eq!(graph.nodes[1].path, shared);
+        assert_eq!(graph.nodes[1].status, PartialStatus::Ok);
+        assert_eq!(graph.nodes[2].path, dir.path().join("gone.tex"));
+        assert_eq!(graph.nodes[2].status, PartialStatus::Missing);
+    }
+
+    #[test]
+    fn test_cycles_are_flagged() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = write(dir.path(), "doc.html", "$a()$");
+        write(dir.path(), "a.html", "$b()$");
+        write(dir.path(), "b.html", "$a()$ $self()$");
+        write(dir.path(), "self.html", "$self()$");
+
+        let graph = partial_graph(&root, &[]);
+        assert_eq!(graph.cycles, vec![vec![1, 2], vec![3]]);
+        assert_eq!(
+            names(&graph),
+            vec![
+                (0, 1, "a", false),
+                (1, 2, "b", true),
+                (2, 1, "a", true),
+                (2, 3, "self", false),
+                (3, 3, "self", true),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_invalid_templates() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = write(dir.path(), "doc.html", "$broken()$");
+        write(dir.path(), "broken.html", "$if(a)$");
+
+        let graph = partial_graph(&root, &[]);
+        assert!(matches!(graph.nodes[1].status, PartialStatus::Invalid(_)));
+        let json = serde_json::to_value(&graph).unwrap();
+        assert_eq!(json["nodes"][0]["status"], "ok");
+        assert_eq!(json["nodes"][1]["status"], "invalid");
+    }
+}
@@ -47,8 +47,13 @@ pub mod doc;
 pub mod error;
 pub mod eval_context;
 pub mod evaluator;
+pub mod format;
+pub mod graph;
+pub mod lint;
 pub mod parser;
+pub mod pipes;
 pub mod resolver;
+pub mod variables;
 
 // Re-export main types at crate root
 pub use ast::{
@@ -59,5 +64,11 @@ pub use context::{TemplateContext, TemplateValue};
 pub use doc::Doc;
 pub use error::TemplateError;
 pub use eval_context::{DiagnosticCollector, EvalContext};
+pub use format::format;
+pub use graph::{PartialEdge, PartialGraph, PartialNode, PartialStatus, partial_graph};
+pub use lint::{LintOptions, LintReport, LintSchema, lint};
 pub use parser::Template;
 pub use resolver::{FileSystemResolver, MemoryResolver, NullResolver, PartialResolver};
+pub use variables::{
+    Requirement, TemplateVariable, TemplateVariables, VariableKind, template_variables,
+};
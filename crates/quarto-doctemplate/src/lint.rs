@@ -0,0 +1,873 @@
+/*
+ * lint.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Template linter.
+//!
+//! [`lint`] reports the mistakes in a template that a render would only
+//! reveal for some documents, or not at all:
+//!
+//! - Unbalanced directives: an `$if(...)$` or `$for(...)$` that is never
+//!   closed, an `$endif$` that closes a `$for(...)$`, an `$else$` or `$sep$`
+//!   outside the block it belongs to (Q-10-8)
+//! - Partials that the resolver can't find (Q-10-3)
+//! - Pipes that don't exist (Q-10-6), that are given the wrong arguments
+//!   (Q-10-7), or that can't do anything with the value before them, like
+//!   `first` after `length` (Q-10-10)
+//! - Variables that aren't in a metadata schema, when one is given (Q-10-9)
+//!
+//! Directives and pipes are checked with a scan of the source rather than
+//! the parse tree, so that every unbalanced directive and bad pipe is found
+//! and located although the template doesn't parse. Partials and variables
+//! are checked on the parse.
+
+use crate::ast::{PipeArg, TemplateNode, VariableRef};
+use crate::parser::Template;
+use crate::resolver::{PartialResolver, remove_final_newline, resolve_partial_path};
+use quarto_error_reporting::{DiagnosticKind, DiagnosticMessage, DiagnosticMessageBuilder};
+use quarto_source_map::{FileId, SourceContext, SourceInfo};
+use serde_json::Value;
+use std::path::{Path, PathBuf};
+
+/// The pipes of Pandoc's doctemplates.
+const PIPES: &[&str] = &[
+    "pairs",
+    "uppercase",
+    "lowercase",
+    "length",
+    "reverse",
+    "first",
+    "last",
+    "rest",
+    "allbutlast",
+    "chomp",
+    "nowrap",
+    "alpha",
+    "roman",
+    "left",
+    "center",
+    "right",
+];
+
+/// Variables that writers set from the document rather than its metadata,
+/// so a metadata schema doesn't list them.
+pub(crate) const WRITER_VARIABLES: &[&str] = &[
+    "author-meta",
+    "body",
+    "curdir",
+    "date-meta",
+    "header-includes",
+    "include-after",
+    "include-before",
+    "meta-json",
+    "outputfile",
+    "pagetitle",
+    "pandoc-version",
+    "sourcefile",
+    "table-of-contents",
+    "title-prefix",
+    "toc",
+    "toc-title",
+];
+
+/// Pipes that work on lists.
+const LIST_PIPES: &[&str] = &["pairs", "first", "last", "rest", "allbutlast", "reverse"];
+
+/// Partials nested deeper than this are not linted (a partial that
+/// includes itself is reported by `Template::compile_with_resolver`).
+const MAX_PARTIAL_DEPTH: usize = 50;
+
+/// The metadata a template may use, as a JSON Schema.
+///
+/// Only the structure is used: `properties` names the fields of an object
+/// and `items` describes the elements of an array. An object without
+/// `properties`, or with `additionalProperties`, accepts any field.
+///
+/// ```json
+/// {
+///   "properties": {
+///     "title": { "type": "string" },
+///     "author": { "items": { "properties": { "name": {}, "email": {} } } }
+///   }
+/// }
+/// ```
+#[derive(Debug, Clone, PartialEq)]
+pub struct LintSchema {
+    root: Value,
+}
+
+impl LintSchema {
+    /// Create a schema from its JSON.
+    pub fn new(root: Value) -> Self {
+        Self { root }
+    }
+
+    /// Parse a schema from JSON text.
+    pub fn from_json(json: &str) -> Result<Self, serde_json::Error> {
+        serde_json::from_str(json).map(Self::new)
+    }
+}
+
+/// What [`lint`] checks beyond the template itself.
+#[derive(Default)]
+pub struct LintOptions<'a> {
+    /// Check variables against this schema.
+    pub schema: Option<&'a LintSchema>,
+    /// Check that partials exist, and lint them too. Without a resolver,
+    /// partials are not checked.
+    pub partials: Option<&'a dyn PartialResolver>,
+}
+
+/// The diagnostics of a lint, with the source files they point into: the
+/// template and the partials that were linted with it.
+#[derive(Debug)]
+pub struct LintReport {
+    pub diagnostics: Vec<DiagnosticMessage>,
+    pub source_context: SourceContext,
+}
+
+impl LintReport {
+    /// Check if any of the diagnostics is an error.
+    pub fn has_errors(&self) -> bool {
+        self.diagnostics
+            .iter()
+            .any(|d| d.kind == DiagnosticKind::Error)
+    }
+}
+
+/// Lint a template.
+///
+/// # Arguments
+/// * `source` - The template source text
+/// * `template_path` - Path of the template, for diagnostics and for
+///   resolving partials
+/// * `options` - The schema and partial resolver to check against
+///
+/// # Returns
+/// The diagnostics, sorted by location within each file.
+pub fn lint(source: &str, template_path: &Path, options: &LintOptions) -> LintReport {
+    let mut linter = Linter {
+        options,
+        source_context: SourceContext::new(),
+        diagnostics: Vec::new(),
+        partial_stack: Vec::new(),
+    };
+    let root = options.schema.map(|schema| Scope::Known(&schema.root));
+    linter.lint_file(source, template_path, &mut Vec::new(), root);
+    linter.diagnostics.sort_by_key(|d| {
+        d.location
+            .as_ref()
+            .map_or((usize::MAX, 0), |loc| (root_file(loc), loc.start_offset()))
+    });
+    LintReport {
+        diagnostics: linter.diagnostics,
+        source_context: linter.source_context,
+    }
+}
+
+/// What is known about the value of a variable.
+#[derive(Debug, Clone, Copy)]
+enum Scope<'a> {
+    /// The schema describes it.
+    Known(&'a Value),
+    /// Anything goes: the schema doesn't constrain it.
+    Open,
+}
+
+impl<'a> Scope<'a> {
+    /// The scope of a field. `Err` when the schema lists the fields and
+    /// this isn't one of them.
+    fn field(self, name: &str) -> Result<Scope<'a>, ()> {
+        let Scope::Known(schema) = self else {
+            return Ok(Scope::Open);
+        };
+        // Field access on an array reaches into its elements
+        let schema = match (schema.get("properties"), schema.get("items")) {
+            (None, Some(items)) => items,
+            _ => schema,
+        };
+        let Some(properties) = schema.get("properties").and_then(Value::as_object) else {
+            return Ok(Scope::Open);
+        };
+        if let Some(field) = properties.get(name) {
+            return Ok(Scope::Known(field));
+        }
+        match schema.get("additionalProperties") {
+            None | Some(Value::Bool(false)) => Err(()),
+            Some(_) => Ok(Scope::Open),
+        }
+    }
+
+    /// The scope of the elements a `$for$` iterates over.
+    fn items(self) -> Scope<'a> {
+        match self {
+            Scope::Known(schema) => schema.get("items").map_or(self, Scope::Known),
+            Scope::Open => Scope::Open,
+        }
+    }
+}
+
+/// The kind of an opening directive.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Block {
+    If,
+    For,
+}
+
+impl Block {
+    fn closer(self) -> &'static str {
+        match self {
+            Block::If => "$endif$",
+            Block::For => "$endfor$",
+        }
+    }
+}
+
+/// An open `$if$` or `$for$` during the directive scan.
+struct Open<'s> {
+    block: Block,
+    text: &'s str,
+    start: usize,
+    end: usize,
+    /// The `$else$` of an `$if$`, or the `$sep$` of a `$for$`, was seen.
+    divided: bool,
+}
+
+struct Linter<'a> {
+    options: &'a LintOptions<'a>,
+    source_context: SourceContext,
+    diagnostics: Vec<DiagnosticMessage>,
+    /// The partials being linted, to stop at cycles.
+    partial_stack: Vec<PathBuf>,
+}
+
+impl<'a> Linter<'a> {
+    /// Lint the source of the template or of a partial.
+    fn lint_file(
+        &mut self,
+        source: &str,
+        path: &Path,
+        bindings: &mut Vec<(String, Scope<'a>)>,
+        root: Option<Scope<'a>>,
+    ) {
+        let file_id = self.source_context.add_file(
+            path.to_string_lossy().into_owned(),
+            Some(source.to_string()),
+        );
+        if !self.check_directives(source, file_id) {
+            return;
+        }
+        match Template::parse_with_file_id(source, file_id) {
+            Ok(template) => self.check_nodes(&template.nodes, path, bindings, root),
+            Err(err) => self.diagnostics.push(
+                DiagnosticMessageBuilder::error("Template Parse Error")
+                    .with_code("Q-10-1")
+                    .with_location(SourceInfo::original(file_id, 0, 0))
+                    .problem(err.to_string())
+                    .build(),
+            ),
+        }
+    }
+
+    /// Check that `$if$` and `$for$` blocks are closed, that `$else$` and
+    /// `$sep$` are used inside them, and the pipes of interpolations.
+    /// Returns whether there were no errors.
+    fn check_directives(&mut self, source: &str, file_id: FileId) -> bool {
+        let errors = self.error_count();
+        let mut stack: Vec<Open> = Vec::new();
+        for (text, start, end) in directives(source) {
+            let location = SourceInfo::original(file_id, start, end);
+            let source_text = &source[start..end];
+            if text.starts_with("if(") || text.starts_with("for(") {
+                stack.push(Open {
+                    block: if text.starts_with("if(") {
+                        Block::If
+                    } else {
+                        Block::For
+                    },
+                    text: source_text,
+                    start,
+                    end,
+                    divided: false,
+                });
+            } else if text == "endif" || text == "endfor" {
+                let block = if text == "endif" {
+                    Block::If
+                } else {
+                    Block::For
+                };
+                let Some(depth) = stack.iter().rposition(|open| open.block == block) else {
+                    self.unbalanced(
+                        location,
+                        format!("`{}` closes no `{}`", source_text, opener(block)),
+                        format!("Remove it, or open the block with `{}`", opener(block)),
+                    );
+                    continue;
+                };
+                for open in stack.drain(depth + 1..).rev() {
+                    self.unbalanced(
+                        SourceInfo::original(file_id, open.start, open.end),
+                        format!("`{}` is closed by `{}`", open.text, source_text),
+                        format!(
+                            "Close it with `{}` before `{}`",
+                            open.block.closer(),
+                            source_text
+                        ),
+                    );
+                }
+                stack.pop();
+            } else if text == "else" || text.starts_with("elseif(") || text == "sep" {
+                let block = if text == "sep" { Block::For } else { Block::If };
+                match stack.last_mut() {
+                    Some(open) if open.block == block => {
+                        if open.divided {
+                            let problem = match block {
+                                Block::If => format!(
+                                    "`{}` follows the `$else$` of `{}`",
+                                    source_text, open.text
+                                ),
+                                Block::For => format!("`{}` has a second `$sep$`", open.text),
+                            };
+                            let hint = match block {
+                                Block::If => "An `$else$` must be the last branch".to_string(),
+                                Block::For => "Remove one of them".to_string(),
+                            };
+                            self.unbalanced(location, problem, hint);
+                        } else if !text.starts_with("elseif(") {
+                            open.divided = true;
+                        }
+                    }
+                    _ => self.unbalanced(
+                        location,
+                        format!(
+                            "`{}` is not inside a `{}` block",
+                            source_text,
+                            opener(block)
+                        ),
+                        format!(
+                            "`{}` belongs between `{}` and `{}`",
+                            source_text,
+                            opener(block),
+                            block.closer()
+                        ),
+                    ),
+                }
+            } else if !matches!(text, "" | "^" | "~") {
+                self.check_pipes(text, location);
+            }
+        }
+        for open in stack {
+            self.unbalanced(
+                SourceInfo::original(file_id, open.start, open.end),
+                format!("`{}` is never closed", open.text),
+                format!("Close it with `{}`", open.block.closer()),
+            );
+        }
+        self.error_count() == errors
+    }
+
+    fn error_count(&self) -> usize {
+        self.diagnostics
+            .iter()
+            .filter(|d| d.kind == DiagnosticKind::Error)
+            .count()
+    }
+
+    fn unbalanced(&mut self, location: SourceInfo, problem: String, hint: String) {
+        self.diagnostics.push(
+            DiagnosticMessageBuilder::error("Unbalanced Template Directive")
+                .with_code("Q-10-8")
+                .with_location(location)
+                .problem(problem)
+                .add_hint(hint)
+                .build(),
+        );
+    }
+
+    /// Check the variables and partials of parsed nodes. `bindings`
+    /// are the loop variables in scope, innermost last.
+    fn check_nodes(
+        &mut self,
+        nodes: &[TemplateNode],
+        path: &Path,
+        bindings: &mut Vec<(String, Scope<'a>)>,
+        root: Option<Scope<'a>>,
+    ) {
+        for node in nodes {
+            match node {
+                TemplateNode::Variable(var) => {
+                    self.check_variable(var, bindings, root);
+                }
+                TemplateNode::Conditional(cond) => {
+                    for (condition, body) in &cond.branches {
+                        self.check_variable(condition, bindings, root);
+                        self.check_nodes(body, path, bindings, root);
+                    }
+                    if let Some(else_branch) = &cond.else_branch {
+                        self.check_nodes(else_branch, path, bindings, root);
+                    }
+                }
+                TemplateNode::ForLoop(for_loop) => {
+                    let items = self
+                        .check_variable(&for_loop.var, bindings, root)
+                        .map_or(Scope::Open, Scope::items);
+                    let name = for_loop.var.path.last().cloned().unwrap_or_default();
+                    let bound = bindings.len();
+                    bindings.push((name, items));
+                    bindings.push(("it".to_string(), items));
+                    self.check_nodes(&for_loop.body, path, bindings, root);
+                    if let Some(separator) = &for_loop.separator {
+                        self.check_nodes(separator, path, bindings, root);
+                    }
+                    bindings.truncate(bound);
+                }
+                TemplateNode::Partial(partial) => {
+                    let applied = partial
+                        .var
+                        .as_ref()
+                        .map(|var| self.check_variable(var, bindings, root));
+                    let Some(resolver) = self.options.partials else {
+                        continue;
+                    };
+                    let Some(partial_source) = resolver.get_partial(&partial.name, path) else {
+                        self.diagnostics.push(
+                            DiagnosticMessageBuilder::error("Partial Not Found")
+                                .with_code("Q-10-3")
+                                .with_location(partial.source_info.clone())
+                                .problem(format!(
+                                    "The partial `{}` could not be found",
+                                    partial.name
+                                ))
+                                .add_hint(format!(
+                                    "Partials are looked up next to the template, as `{}`",
+                                    resolve_partial_path(&partial.name, path).display()
+                                ))
+                                .build(),
+                        );
+                        continue;
+                    };
+                    let partial_path = resolve_partial_path(&partial.name, path);
+                    if self.partial_stack.contains(&partial_path)
+                        || self.partial_stack.len() >= MAX_PARTIAL_DEPTH
+                    {
+                        continue;
+                    }
+                    self.partial_stack.push(partial_path.clone());
+                    // An applied partial sees its value, or each of its
+                    // elements, as `it`
+                    let mut partial_bindings = match applied {
+                        Some(scope) => {
+                            vec![("it".to_string(), scope.map_or(Scope::Open, Scope::items))]
+                        }
+                        None => bindings.clone(),
+                    };
+                    self.lint_file(
+                        remove_final_newline(&partial_source),
+                        &partial_path,
+                        &mut partial_bindings,
+                        root,
+                    );
+                    self.partial_stack.pop();
+                }
+                TemplateNode::Nesting(nesting) => {
+                    self.check_nodes(&nesting.children, path, bindings, root);
+                }
+                TemplateNode::BreakableSpace(space) => {
+                    self.check_nodes(&space.children, path, bindings, root);
+                }
+                TemplateNode::Literal(_) | TemplateNode::Comment(_) => {}
+            }
+        }
+    }
+
+    /// With a schema, check that the schema has a variable. Returns what
+    /// the schema says about the variable's value.
+    fn check_variable(
+        &mut self,
+        var: &VariableRef,
+        bindings: &[(String, Scope<'a>)],
+        root: Option<Scope<'a>>,
+    ) -> Option<Scope<'a>> {
+        let root = root?;
+        let (first, rest) = var.path.split_first()?;
+        let mut scope = match bindings.iter().rev().find(|(name, _)| name == first) {
+            Some((_, scope)) => *scope,
+            None if WRITER_VARIABLES.contains(&first.as_str()) => return Some(Scope::Open),
+            None => match root.field(first) {
+                Ok(scope) => scope,
+                Err(()) => {
+                    self.not_in_schema(var, first);
+                    return None;
+                }
+            },
+        };
+        for (i, name) in rest.iter().enumerate() {
+            match scope.field(name) {
+                Ok(field) => scope = field,
+                Err(()) => {
+                    self.not_in_schema(var, &var.path[..i + 2].join("."));
+                    return None;
+                }
+            }
+        }
+        Some(scope)
+    }
+
+    fn not_in_schema(&mut self, var: &VariableRef, missing: &str) {
+        self.diagnostics.push(
+            DiagnosticMessageBuilder::warning("Variable Not In Schema")
+                .with_code("Q-10-9")
+                .with_location(var.source_info.clone())
+                .problem(format!("`{}` is not in the metadata schema", missing))
+                .add_hint("Check the spelling, or add the field to the schema")
+                .build(),
+        );
+    }
+
+    /// Check the pipes of an interpolation: `title/uppercase/left 10`.
+    fn check_pipes(&mut self, text: &str, location: SourceInfo) {
+        let mut previous: Option<&str> = None;
+        for pipe in pipes_of(text) {
+            let (name, args) = pipe.split_once([' ', '\t']).unwrap_or((pipe, ""));
+            if !PIPES.contains(&name) {
+                self.diagnostics.push(
+                    DiagnosticMessageBuilder::error("Unknown Pipe")
+                        .with_code("Q-10-6")
+                        .with_location(location.clone())
+                        .problem(format!("There is no pipe named `{}`", name))
+                        .add_hint(format!("The pipes are {}", PIPES.join(", ")))
+                        .build(),
+                );
+                return;
+            }
+            let problem = match (name, pipe_args(args).as_deref()) {
+                (_, None) => Some(format!(
+                    "The arguments of `{}` are not numbers or quoted strings",
+                    name
+                )),
+                ("left" | "center" | "right", Some([PipeArg::Integer(_), borders @ ..]))
+                    if borders.len() <= 2
+                        && borders.iter().all(|a| matches!(a, PipeArg::String(_))) =>
+                {
+                    None
+                }
+                ("left" | "center" | "right", Some(_)) => Some(format!(
+                    "`{}` takes a width and up to two border strings, e.g. `{} 20 \"| \" \" |\"`",
+                    name, name
+                )),
+                (_, Some([])) => None,
+                (_, Some(_)) => Some(format!("`{}` takes no arguments", name)),
+            };
+            if let Some(problem) = problem {
+                self.diagnostics.push(
+                    DiagnosticMessageBuilder::error("Invalid Pipe Arguments")
+                        .with_code("Q-10-7")
+                        .with_location(location.clone())
+                        .problem(problem)
+                        .build(),
+                );
+            }
+            if previous == Some("length") && LIST_PIPES.contains(&name) {
+                self.diagnostics.push(
+                    DiagnosticMessageBuilder::warning("Ineffective Pipe")
+                        .with_code("Q-10-10")
+                        .with_location(location.clone())
+                        .problem(format!(
+                            "`{}` works on lists, but `length` gives a number",
+                            name
+                        ))
+                        .add_hint(format!("Apply `{}` before `length`", name))
+                        .build(),
+                );
+            }
+            previous = Some(name);
+        }
+    }
+}
+
+fn opener(block: Block) -> &'static str {
+    match block {
+        Block::If => "$if(...)$",
+        Block::For => "$for(...)$",
+    }
+}
+
+/// The file a location is in, for sorting.
+fn root_file(location: &SourceInfo) -> usize {
+    match location {
+        SourceInfo::Original { file_id, .. } => file_id.0,
+        _ => 0,
+    }
+}
+
+/// The directives of a template: the trimmed text between the delimiters
+/// of each `$...$` or `${...}`, with the byte range of the whole directive.
+/// Escaped dollars (`$$`) and comments (`$--`) are skipped.
+pub(crate) fn directives(source: &str) -> Vec<(&str, usize, usize)> {
+    let bytes = source.as_bytes();
+    let mut found = Vec::new();
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] != b'$' {
+            i += 1;
+            continue;
+        }
+        let rest = &source[i + 1..];
+        if rest.starts_with('$') {
+            i += 2;
+        } else if rest.starts_with("--") {
+            i += rest.find('\n').map_or(rest.len(), |end| end + 1) + 1;
+        } else if let Some(inner) = rest.strip_prefix('{') {
+            let Some(close) = inner.find('}') else {
+                break;
+            };
+            let end = i + 2 + close + 1;
+            found.push((inner[..close].trim(), i, end));
+            i = end;
+        } else {
+            let Some(close) = rest.find('$') else {
+                break;
+            };
+            let end = i + 1 + close + 1;
+            found.push((rest[..close].trim(), i, end));
+            i = end;
+        }
+    }
+    found
+}
+
+/// The pipes of an interpolation, each with its arguments. Pipes follow
+/// the variable, or the `()` of a partial, each after a `/`; a `[...]`
+/// separator is not part of them.
+fn pipes_of(text: &str) -> Vec<&str> {
+    let start = match text.find("()") {
+        Some(call) => call + 2,
+        None => match text.find('/') {
+            Some(slash) => slash,
+            None => return Vec::new(),
+        },
+    };
+    let mut pipes = Vec::new();
+    let mut in_quotes = false;
+    let mut in_brackets = false;
+    let mut segment: Option<usize> = None;
+    for (i, c) in text[start..].char_indices() {
+        let i = start + i;
+        match c {
+            '"' if !in_brackets => in_quotes = !in_quotes,
+            '[' if !in_quotes => {
+                in_brackets = true;
+                if let Some(from) = segment.take() {
+                    pipes.push(text[from..i].trim());
+                }
+            }
+            ']' if !in_quotes => in_brackets = false,
+            '/' if !in_quotes && !in_brackets => {
+                if let Some(from) = segment.replace(i + 1) {
+                    pipes.push(text[from..i].trim());
+                }
+            }
+            _ => {}
+        }
+    }
+    if let Some(from) = segment {
+        pipes.push(text[from..].trim());
+    }
+    pipes.retain(|pipe| !pipe.is_empty());
+    pipes
+}
+
+/// The arguments of a pipe: integers and quoted strings separated by
+/// whitespace. `None` when something else is there.
+fn pipe_args(args: &str) -> Option<Vec<PipeArg>> {
+    let mut parsed = Vec::new();
+    let mut rest = args.trim_start();
+    while !rest.is_empty() {
+        if let Some(quoted) = rest.strip_prefix('"') {
+            let mut end = None;
+            let mut escaped = false;
+            for (i, c) in quoted.char_indices() {
+                match c {
+                    '\\' if !escaped => escaped = true,
+                    '"' if !escaped => {
+                        end = Some(i);
+                        break;
+                    }
+                    _ => escaped = false,
+                }
+            }
+            let end = end?;
+            parsed.push(PipeArg::String(quoted[..end].to_string()));
+            rest = quoted[end + 1..].trim_start();
+        } else {
+            let end = rest.find([' ', '\t']).unwrap_or(rest.len());
+            parsed.push(PipeArg::Integer(rest[..end].parse().ok()?));
+            rest = rest[end..].trim_start();
+        }
+    }
+    Some(parsed)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::resolver::MemoryResolver;
+    use serde_json::json;
+
+    fn codes(report: &LintReport) -> Vec<&str> {
+        report
+            .diagnostics
+            .iter()
+            .filter_map(|d| d.code.as_deref())
+            .collect()
+    }
+
+    fn lint_str(source: &str) -> LintReport {
+        lint(source, Path::new("doc.html"), &LintOptions::default())
+    }
+
+    #[test]
+    fn test_clean_template() {
+        let report = lint_str("$if(title)$<h1>$title/uppercase$</h1>$endif$\n");
+        assert!(report.diagnostics.is_empty());
+    }
+
+    #[test]
+    fn test_unclosed_if() {
+        let report = lint_str("$if(title)$\n$title$\n");
+        assert_eq!(codes(&report), vec!["Q-10-8"]);
+        assert_eq!(
+            report.diagnostics[0]
+                .location
+                .as_ref()
+                .unwrap()
+                .start_offset(),
+            0
+        );
+        assert!(report.has_errors());
+    }
+
+    #[test]
+    fn test_endif_closing_a_for() {
+        let report = lint_str("$if(a)$$for(b)$$b$$endif$\n");
+        assert_eq!(codes(&report), vec!["Q-10-8"]);
+        assert_eq!(
+            report.diagnostics[0]
+                .location
+                .as_ref()
+                .unwrap()
+                .start_offset(),
+            7
+        );
+    }
+
+    #[test]
+    fn test_stray_closers_and_dividers() {
+        let report = lint_str("$endfor$ $sep$ $if(a)$$else$$else$$endif$\n");
+        assert_eq!(codes(&report), vec!["Q-10-8", "Q-10-8", "Q-10-8"]);
+    }
+
+    #[test]
+    fn test_escapes_and_comments_are_not_directives() {
+        let report = lint_str("Costs $$5.\n$-- $if(x)$ is commented out\n${if(a)}A${endif}\n");
+        assert!(report.diagnostics.is_empty());
+    }
+
+    #[test]
+    fn test_pipes() {
+        let report = lint_str("$a/shout$ $b/left 10 \"|\"$ $c/left \"x\"$ $d/uppercase 3$\n");
+        assert_eq!(codes(&report), vec!["Q-10-6", "Q-10-7", "Q-10-7"]);
+        let offsets: Vec<usize> = report
+            .diagnostics
+            .iter()
+            .map(|d| d.location.as_ref().unwrap().start_offset())
+            .collect();
+        assert_eq!(offsets, vec![0, 26, 39]);
+    }
+
+    #[test]
+    fn test_ineffective_pipe() {
+        let report = lint_str("$items/length/first$\n");
+        assert_eq!(codes(&report), vec!["Q-10-10"]);
+        assert!(!report.has_errors());
+    }
+
+    #[test]
+    fn test_pipes_of() {
+        assert_eq!(pipes_of("title"), Vec::<&str>::new());
+        assert_eq!(
+            pipes_of("title/uppercase/left 10 \"a/b\" \"\""),
+            vec!["uppercase", "left 10 \"a/b\" \"\""]
+        );
+        assert_eq!(pipes_of("authors/first[, ]"), vec!["first"]);
+        assert_eq!(pipes_of("inc/header()/uppercase"), vec!["uppercase"]);
+        assert_eq!(
+            pipe_args("10 \"| \" \" |\""),
+            Some(vec![
+                PipeArg::Integer(10),
+                PipeArg::String("| ".to_string()),
+                PipeArg::String(" |".to_string()),
+            ])
+        );
+        assert_eq!(pipe_args("ten"), None);
+    }
+
+    #[test]
+    fn test_missing_partial() {
+        let resolver = MemoryResolver::with_partials([("header", "<h1>$title$</h1>")]);
+        let options = LintOptions {
+            partials: Some(&resolver),
+            ..Default::default()
+        };
+        let report = lint("$header()$\n$footer()$\n", Path::new("doc.html"), &options);
+        assert_eq!(codes(&report), vec!["Q-10-3"]);
+    }
+
+    #[test]
+    fn test_schema_variables() {
+        let schema = LintSchema::new(json!({
+            "properties": {
+                "title": {},
+                "author": { "items": { "properties": { "name": {} } } },
+                "extra": { "additionalProperties": true, "properties": {} }
+            }
+        }));
+        let options = LintOptions {
+            schema: Some(&schema),
+            ..Default::default()
+        };
+        let report = lint(
+            "$title$ $subtitle$ $body$ $extra.anything$\n\
+             $for(author)$$author.name$ $it.name$ $author.email$$endfor$\n",
+            Path::new("doc.html"),
+            &options,
+        );
+        let problems: Vec<String> = report
+            .diagnostics
+            .iter()
+            .map(|d| d.problem.as_ref().unwrap().as_str().to_string())
+            .collect();
+        assert_eq!(
+            problems,
+            vec![
+                "`subtitle` is not in the metadata schema",
+                "`author.email` is not in the metadata schema",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_schema_in_partials() {
+        let schema = LintSchema::new(json!({
+            "properties": { "author": { "items": { "properties": { "name": {} } } } }
+        }));
+        let resolver = MemoryResolver::with_partials([("card", "$it.name$ $it.phone$")]);
+        let options = LintOptions {
+            schema: Some(&schema),
+            partials: Some(&resolver),
+        };
+        let report = lint("$author:card()$\n", Path::new("doc.html"), &options);
+        assert_eq!(codes(&report), vec!["Q-10-9"]);
+    }
+}
@@ -0,0 +1,323 @@
+/*
+ * pipes.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! Pipes, which transform a value before it is interpolated or iterated:
+//! `$title/uppercase$`, `$for(meta/pairs)$`, `$abstract/left 20 "| "$`.
+//!
+//! Pipes apply left to right. A pipe given a value it doesn't work on
+//! returns the value unchanged, as in Pandoc: `first` of a string is the
+//! string. Pipe names and arguments are checked by the linter, so bad
+//! arguments are ignored here.
+
+use crate::ast::{Pipe, PipeArg};
+use crate::context::TemplateValue;
+use std::borrow::Cow;
+use std::collections::HashMap;
+
+/// Apply `pipes` to `value` in order. The value is only copied when there
+/// are pipes.
+pub fn apply_pipes<'a>(value: &'a TemplateValue, pipes: &[Pipe]) -> Cow<'a, TemplateValue> {
+    if pipes.is_empty() {
+        return Cow::Borrowed(value);
+    }
+    Cow::Owned(pipes.iter().fold(value.clone(), apply_pipe))
+}
+
+fn apply_pipe(value: TemplateValue, pipe: &Pipe) -> TemplateValue {
+    match pipe.name.as_str() {
+        "pairs" => pairs(value),
+        "length" => TemplateValue::String(length(&value).to_string()),
+        "reverse" => match value {
+            TemplateValue::List(mut items) => {
+                items.reverse();
+                TemplateValue::List(items)
+            }
+            TemplateValue::String(s) => TemplateValue::String(s.chars().rev().collect()),
+            value => value,
+        },
+        "first" | "last" | "rest" | "allbutlast" => match value {
+            TemplateValue::List(mut items) if !items.is_empty() => match pipe.name.as_str() {
+                "first" => items.swap_remove(0),
+                "last" => items.pop().unwrap_or_default(),
+                "rest" => TemplateValue::List(items.split_off(1)),
+                _ => {
+                    items.pop();
+                    TemplateValue::List(items)
+                }
+            },
+            value => value,
+        },
+        "uppercase" => map_strings(value, &|s| s.to_uppercase()),
+        "lowercase" => map_strings(value, &|s| s.to_lowercase()),
+        "chomp" => map_strings(value, &|s| {
+            s.trim_end_matches([' ', '\t', '\r', '\n']).to_string()
+        }),
+        "alpha" => map_strings(value, &|s| numeral(&s, alpha)),
+        "roman" => map_strings(value, &|s| numeral(&s, roman)),
+        "left" | "right" | "center" => match block_args(&pipe.args) {
+            Some((width, left, right)) => {
+                TemplateValue::String(block(&value.render(), &pipe.name, width, left, right))
+            }
+            None => value,
+        },
+        // Output is never reflowed, so there is nothing for `nowrap` to
+        // prevent
+        _ => value,
+    }
+}
+
+/// A map as a list of `key`/`value` maps sorted by key, and a list as the
+/// same with 1-based indices as keys.
+fn pairs(value: TemplateValue) -> TemplateValue {
+    let pair = |key: String, value: TemplateValue| {
+        TemplateValue::Map(HashMap::from([
+            ("key".to_string(), TemplateValue::String(key)),
+            ("value".to_string(), value),
+        ]))
+    };
+    match value {
+        TemplateValue::Map(map) => {
+            let mut entries: Vec<_> = map.into_iter().collect();
+            entries.sort_by(|(a, _), (b, _)| a.cmp(b));
+            TemplateValue::List(entries.into_iter().map(|(k, v)| pair(k, v)).collect())
+        }
+        TemplateValue::List(items) => TemplateValue::List(
+            items
+                .into_iter()
+                .enumerate()
+                .map(|(i, v)| pair((i + 1).to_string(), v))
+                .collect(),
+        ),
+        value => value,
+    }
+}
+
+fn length(value: &TemplateValue) -> usize {
+    match value {
+        TemplateValue::List(items) => items.len(),
+        TemplateValue::Map(map) => map.len(),
+        TemplateValue::String(s) => s.chars().count(),
+        value => value.render().chars().count(),
+    }
+}
+
+/// Apply `f` to the rendered form of a value, to each item of a list.
+/// Maps and null are unchanged.
+fn map_strings(value: TemplateValue, f: &dyn Fn(String) -> String) -> TemplateValue {
+    match value {
+        TemplateValue::List(items) => {
+            TemplateValue::List(items.into_iter().map(|v| map_strings(v, f)).collect())
+        }
+        TemplateValue::Map(_) | TemplateValue::Null => value,
+        value => TemplateValue::String(f(value.render())),
+    }
+}
+
+/// `s` as a numeral made by `f` when it is a positive integer, unchanged
+/// otherwise.
+fn numeral(s: &str, f: fn(u32) -> String) -> String {
+    match s.trim().parse::<u32>() {
+        Ok(n) if n > 0 => f(n),
+        _ => s.to_string(),
+    }
+}
+
+fn alpha(n: u32) -> String {
+    char::from(b'a' + ((n - 1) % 26) as u8).to_string()
+}
+
+fn roman(mut n: u32) -> String {
+    if n >= 4000 {
+        return n.to_string();
+    }
+    const DIGITS: &[(u32, &str)] = &[
+        (1000, "m"),
+        (900, "cm"),
+        (500, "d"),
+        (400, "cd"),
+        (100, "c"),
+        (90, "xc"),
+        (50, "l"),
+        (40, "xl"),
+        (10, "x"),
+        (9, "ix"),
+        (5, "v"),
+        (4, "iv"),
+        (1, "i"),
+    ];
+    let mut result = String::new();
+    for (value, digit) in DIGITS {
+        while n >= *value {
+            result.push_str(digit);
+            n -= value;
+        }
+    }
+    result
+}
+
+/// The arguments of `left`, `right` and `center`: a width and optional
+/// left and right borders.
+fn block_args(args: &[PipeArg]) -> Option<(usize, &str, &str)> {
+    let (PipeArg::Integer(width), borders) = args.split_first()? else {
+        return None;
+    };
+    if borders.len() > 2 {
+        return None;
+    }
+    let border = |i: usize| match borders.get(i) {
+        None => Some(""),
+        Some(PipeArg::String(s)) => Some(s.as_str()),
+        Some(PipeArg::Integer(_)) => None,
+    };
+    Some((usize::try_from(*width).ok()?, border(0)?, border(1)?))
+}
+
+/// Lay `s` out in a block `width` wide: words are wrapped to the width, and
+/// each line is aligned within it and put between the borders.
+fn block(s: &str, align: &str, width: usize, left: &str, right: &str) -> String {
+    let lines: Vec<String> = s
+        .split('\n')
+        .flat_map(|para| wrap(para, width))
+        .map(|line| {
+            let pad = width.saturating_sub(line.chars().count());
+            let line = match align {
+                "left" => format!("{}{}", line, " ".repeat(pad)),
+                "right" => format!("{}{}", " ".repeat(pad), line),
+                _ => format!(
+                    "{}{}{}",
+                    " ".repeat(pad / 2),
+                    line,
+                    " ".repeat(pad - pad / 2)
+                ),
+            };
+            format!("{}{}{}", left, line, right)
+        })
+        .collect();
+    lines.join("\n")
+}
+
+/// Break `s` into lines of at most `width` characters at spaces. Words
+/// longer than the width are kept whole.
+fn wrap(s: &str, width: usize) -> Vec<String> {
+    let mut words = s.split_whitespace();
+    let Some(first) = words.next() else {
+        return vec![String::new()];
+    };
+    let mut lines = Vec::new();
+    let mut line = first.to_string();
+    for word in words {
+        if line.chars().count() + 1 + word.chars().count() > width {
+            lines.push(std::mem::replace(&mut line, word.to_string()));
+        } else {
+            line.push(' ');
+            line.push_str(word);
+        }
+    }
+    lines.push(line);
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use quarto_source_map::{FileId, SourceInfo};
+
+    fn pipe(name: &str, args: Vec<PipeArg>) -> Pipe {
+        Pipe::with_args(name, args, SourceInfo::original(FileId(0), 0, 0))
+    }
+
+    fn string(s: &str) -> TemplateValue {
+        TemplateValue::String(s.to_string())
+    }
+
+    fn list(items: &[&str]) -> TemplateValue {
+        TemplateValue::List(items.iter().map(|s| string(s)).collect())
+    }
+
+    fn apply(value: TemplateValue, names: &[&str]) -> TemplateValue {
+        let pipes: Vec<Pipe> = names.iter().map(|name| pipe(name, Vec::new())).collect();
+        apply_pipes(&value, &pipes).into_owned()
+    }
+
+    #[test]
+    fn test_string_pipes() {
+        assert_eq!(apply(string("Hello"), &["uppercase"]), string("HELLO"));
+        assert_eq!(apply(string("Hello"), &["lowercase"]), string("hello"));
+        assert_eq!(apply(string("text \n\n"), &["chomp"]), string("text"));
+        assert_eq!(apply(string("abc"), &["reverse"]), string("cba"));
+        assert_eq!(apply(string("héllo"), &["length"]), string("5"));
+        assert_eq!(apply(list(&["a", "b"]), &["uppercase"]), list(&["A", "B"]));
+    }
+
+    #[test]
+    fn test_list_pipes() {
+        let items = || list(&["a", "b", "c"]);
+        assert_eq!(apply(items(), &["first"]), string("a"));
+        assert_eq!(apply(items(), &["last"]), string("c"));
+        assert_eq!(apply(items(), &["rest"]), list(&["b", "c"]));
+        assert_eq!(apply(items(), &["allbutlast"]), list(&["a", "b"]));
+        assert_eq!(apply(items(), &["reverse"]), list(&["c", "b", "a"]));
+        assert_eq!(apply(items(), &["length"]), string("3"));
+        assert_eq!(apply(items(), &["rest", "first"]), string("b"));
+        // Pipes that don't apply leave the value alone
+        assert_eq!(apply(string("abc"), &["first"]), string("abc"));
+    }
+
+    #[test]
+    fn test_pairs() {
+        let map = TemplateValue::Map(HashMap::from([
+            ("b".to_string(), string("2")),
+            ("a".to_string(), string("1")),
+        ]));
+        let TemplateValue::List(pairs) = apply(map, &["pairs"]) else {
+            panic!("pairs should return a list");
+        };
+        let keys: Vec<_> = pairs
+            .iter()
+            .map(|pair| pair.get_path(&["key"]).unwrap().render())
+            .collect();
+        assert_eq!(keys, ["a", "b"]);
+        assert_eq!(pairs[0].get_path(&["value"]), Some(&string("1")));
+
+        let TemplateValue::List(pairs) = apply(list(&["x"]), &["pairs"]) else {
+            panic!("pairs should return a list");
+        };
+        assert_eq!(pairs[0].get_path(&["key"]), Some(&string("1")));
+    }
+
+    #[test]
+    fn test_numerals() {
+        assert_eq!(apply(string("3"), &["alpha"]), string("c"));
+        assert_eq!(apply(string("1994"), &["roman"]), string("mcmxciv"));
+        assert_eq!(apply(string("x"), &["roman"]), string("x"));
+    }
+
+    #[test]
+    fn test_blocks() {
+        let left = pipe(
+            "left",
+            vec![
+                PipeArg::Integer(6),
+                PipeArg::String("| ".to_string()),
+                PipeArg::String(" |".to_string()),
+            ],
+        );
+        let value = string("aa bb cc");
+        assert_eq!(
+            apply_pipes(&value, &[left]).into_owned(),
+            string("| aa bb  |\n| cc     |")
+        );
+        let right = pipe("right", vec![PipeArg::Integer(4)]);
+        assert_eq!(
+            apply_pipes(&string("ab"), &[right]).into_owned(),
+            string("  ab")
+        );
+        let center = pipe("center", vec![PipeArg::Integer(5)]);
+        assert_eq!(
+            apply_pipes(&string("ab"), &[center]).into_owned(),
+            string(" ab  ")
+        );
+    }
+}
@@ -0,0 +1,589 @@
+/*
+ * variables.rs
+ * Copyright (c) 2025 Posit, PBC
+ */
+
+//! The variables a template uses.
+//!
+//! [`template_variables`] reports every variable a template references,
+//! what the template does with its value and whether the template expects
+//! it, so that format authors can check document metadata against what the
+//! template actually uses. [`TemplateVariables::json_schema`] writes the
+//! result as a JSON Schema, which `lint` also takes.
+//!
+//! A value's type is inferred from its uses:
+//!
+//! - Iterated with `$for(...)$`, or with a separator (`$var[, ]$`): a list
+//! - Used with dotted access (`$author.name$`), or with `pairs`: a map.
+//!   Fields of a list are fields of its elements, as when rendering
+//! - Interpolated: a scalar
+//! - Only tested with `$if(...)$` or measured with `length`: anything
+//!
+//! A variable is required when it is output where no `$if$` or `$for$`
+//! guards it. One that is only output inside `$if$`s of other fields of
+//! the same object is required when those fields are set. Otherwise it is
+//! optional: tested before use, or only used under conditions a schema
+//! can't express (an `$else$`, a `$for$` over `pairs`).
+//!
+//! Partials are followed when the template was compiled with a resolver.
+//! Variables that writers set from the document rather than its metadata,
+//! like `body`, are not reported.
+
+use crate::ast::{TemplateNode, VariableRef};
+use crate::lint::WRITER_VARIABLES;
+use crate::parser::Template;
+use serde_json::{Map, Value, json};
+use std::collections::{BTreeMap, BTreeSet};
+
+/// The variables of a template, by name.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct TemplateVariables {
+    pub variables: BTreeMap<String, TemplateVariable>,
+}
+
+/// A variable, or a field of one.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TemplateVariable {
+    pub kind: VariableKind,
+    pub required: Requirement,
+    /// The fields used with dotted access: of the value for a map, of the
+    /// innermost elements for a list.
+    pub fields: BTreeMap<String, TemplateVariable>,
+}
+
+/// What the template does with a value.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum VariableKind {
+    /// The template only tests or measures it.
+    Any,
+    Scalar,
+    Map,
+    /// A list of values of the inner kind.
+    List(Box<VariableKind>),
+}
+
+/// Whether the template expects a value to be set.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Requirement {
+    Always,
+    /// Required when all the fields of one of the sets, fields of the same
+    /// object, are set.
+    When(Vec<Vec<String>>),
+    Optional,
+}
+
+/// Report the variables a template references.
+///
+/// ```ignore
+/// let template = Template::compile("$if(draft)$$watermark$$endif$$for(author)$$author.name$$endfor$")?;
+/// let schema = template_variables(&template).json_schema();
+/// ```
+pub fn template_variables(template: &Template) -> TemplateVariables {
+    let mut collector = Collector {
+        root: Usage::default(),
+        bindings: Vec::new(),
+        guards: Vec::new(),
+    };
+    collector.collect(&template.nodes);
+    TemplateVariables {
+        variables: collector.root.fields.into_iter().map(finish).collect(),
+    }
+}
+
+impl TemplateVariables {
+    /// The variables as a JSON Schema of the metadata.
+    ///
+    /// Scalars may be strings, numbers or booleans. The fields an object
+    /// requires are in `required`; those it requires when other fields
+    /// are set are `if`/`then` clauses in `allOf`, where a field is set
+    /// when it is truthy, as an `$if$` tests it.
+    pub fn json_schema(&self) -> Value {
+        let mut schema = Map::new();
+        schema.insert(
+            "$schema".to_string(),
+            json!("https://json-schema.org/draft/2020-12/schema"),
+        );
+        if let Value::Object(object) = object_schema(&self.variables) {
+            schema.extend(object);
+        }
+        Value::Object(schema)
+    }
+}
+
+/// A step along the path to a value.
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Step {
+    Field(String),
+    /// The elements of a list.
+    Items,
+}
+
+/// What the template does with a value, as it is collected.
+#[derive(Debug, Default)]
+struct Usage {
+    interpolated: bool,
+    /// Iterated with `pairs`.
+    map: bool,
+    /// Iterated: what is done with the elements.
+    items: Option<Box<Usage>>,
+    fields: BTreeMap<String, Usage>,
+    /// For each output of the value, the fields of the same object that
+    /// must be set for it. Outputs that don't require the value have none.
+    requirements: Vec<BTreeSet<String>>,
+}
+
+/// How a reference uses its value.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Use {
+    /// Output, as an interpolation or an applied partial.
+    Output,
+    /// Tested by `$if$` or iterated by `$for$`, which the template does
+    /// whether or not the value is set.
+    Test,
+}
+
+struct Collector {
+    root: Usage,
+    /// The loop variables and `it` in scope, innermost last. `None` for
+    /// values that aren't in the metadata, like the elements of `pairs`.
+    bindings: Vec<(String, Option<Vec<Step>>)>,
+    /// The values the `$if$`s and `$for$`s around the current node test,
+    /// outermost first. `None` for conditions a schema can't express.
+    guards: Vec<Option<Vec<Step>>>,
+}
+
+impl Collector {
+    fn collect(&mut self, nodes: &[TemplateNode]) {
+        for node in nodes {
+            match node {
+                TemplateNode::Variable(var) => {
+                    let Some(path) = self.resolve(var) else {
+                        continue;
+                    };
+                    self.require(&path);
+                    let usage = self.usage(&path);
+                    if has_pipe(var, "pairs") {
+                        usage.map = true;
+                    } else if var.separator.is_some() {
+                        usage.items.get_or_insert_default().interpolated = true;
+                    } else if !has_pipe(var, "length") {
+                        usage.interpolated = true;
+                    }
+                }
+                TemplateNode::Conditional(cond) => {
+                    for (i, (condition, body)) in cond.branches.iter().enumerate() {
+                        let path = self.reference(condition, Use::Test);
+                        // An `$elseif$` branch also needs the conditions
+                        // before it to be unset
+                        self.guards.push(if i == 0 { path } else { None });
+                        self.collect(body);
+                        self.guards.pop();
+                    }
+                    if let Some(else_branch) = &cond.else_branch {
+                        self.guards.push(None);
+                        self.collect(else_branch);
+                        self.guards.pop();
+                    }
+                }
+                TemplateNode::ForLoop(for_loop) => {
+                    let path = self.reference(&for_loop.var, Use::Test);
+                    let items = match &path {
+                        Some(path) if has_pipe(&for_loop.var, "pairs") => {
+                            self.usage(path).map = true;
+                            None
+                        }
+                        Some(path) => {
+                            self.usage(path).items.get_or_insert_default();
+                            Some([path.as_slice(), &[Step::Items]].concat())
+                        }
+                        None => None,
+                    };
+                    let name = components(&for_loop.var)
+                        .last()
+                        .map_or(String::new(), |name| name.to_string());
+                    let bound = self.bindings.len();
+                    self.bindings.push((name, items.clone()));
+                    self.bindings.push(("it".to_string(), items));
+                    self.guards.push(path);
+                    self.collect(&for_loop.body);
+                    if let Some(separator) = &for_loop.separator {
+                        self.collect(separator);
+                    }
+                    self.guards.pop();
+                    self.bindings.truncate(bound);
+                }
+                TemplateNode::Partial(partial) => {
+                    let Some(resolved) = &partial.resolved else {
+                        continue;
+                    };
+                    match &partial.var {
+                        // An applied partial sees its value, or each of
+                        // its elements, as `it`
+                        Some(var) => {
+                            let path = self.reference(var, Use::Output);
+                            let path = match path {
+                                Some(path) if partial.separator.is_some() => {
+                                    self.usage(&path).items.get_or_insert_default();
+                                    Some([path.as_slice(), &[Step::Items]].concat())
+                                }
+                                path => path,
+                            };
+                            let bindings = std::mem::replace(
+                                &mut self.bindings,
+                                vec![("it".to_string(), path)],
+                            );
+                            self.collect(resolved);
+                            self.bindings = bindings;
+                        }
+                        None => self.collect(resolved),
+                    }
+                }
+                TemplateNode::Nesting(nesting) => self.collect(&nesting.children),
+                TemplateNode::BreakableSpace(space) => self.collect(&space.children),
+                TemplateNode::Literal(_) | TemplateNode::Comment(_) => {}
+            }
+        }
+    }
+
+    /// Record a reference to a variable, and return the path to its value.
+    fn reference(&mut self, var: &VariableRef, how: Use) -> Option<Vec<Step>> {
+        let path = self.resolve(var)?;
+        if how == Use::Output {
+            self.require(&path);
+        }
+        self.usage(&path);
+        Some(path)
+    }
+
+    /// The path to the value of a variable, from the metadata. `None` for
+    /// values that aren't in the metadata.
+    fn resolve(&self, var: &VariableRef) -> Option<Vec<Step>> {
+        let components = components(var);
+        let (first, rest) = components.split_first()?;
+        let mut path = match self.bindings.iter().rev().find(|(name, _)| name == first) {
+            Some((_, bound)) => bound.clone()?,
+            None if WRITER_VARIABLES.contains(first) => return None,
+            None => vec![Step::Field(first.to_string())],
+        };
+        path.extend(rest.iter().map(|name| Step::Field(name.to_string())));
+        Some(path)
+    }
+
+    /// Record that the value at `path` is output under the current guards,
+    /// for each field along the path.
+    fn require(&mut self, path: &[Step]) {
+        for end in 1..=path.len() {
+            if !matches!(path[end - 1], Step::Field(_)) {
+                continue;
+            }
+            if let Some(conditions) = conditions(&path[..end], &self.guards) {
+                self.usage(&path[..end]).requirements.push(conditions);
+            }
+        }
+    }
+
+    /// The usage of the value at `path`.
+    fn usage(&mut self, path: &[Step]) -> &mut Usage {
+        let mut usage = &mut self.root;
+        for step in path {
+            usage = match step {
+                Step::Field(name) => usage.fields.entry(name.clone()).or_default(),
+                Step::Items => usage.items.get_or_insert_default(),
+            };
+        }
+        usage
+    }
+}
+
+/// The fields of the same object as the field at `path` that `guards`
+/// require to be set for it to be output. `None` when the output doesn't
+/// require the field: a guard tests the field itself, or something a
+/// schema of the object can't express.
+fn conditions(path: &[Step], guards: &[Option<Vec<Step>>]) -> Option<BTreeSet<String>> {
+    let mut conditions = BTreeSet::new();
+    for guard in guards {
+        let guard = guard.as_ref()?;
+        if guard.starts_with(path) {
+            return None;
+        }
+        if path.starts_with(guard) {
+            // The guard tests an object the field is in, which is set
+            continue;
+        }
+        match guard.split_last() {
+            Some((Step::Field(name), parent)) if path[..path.len() - 1] == *parent => {
+                conditions.insert(name.clone());
+            }
+            _ => return None,
+        }
+    }
+    Some(conditions)
+}
+
+/// The components of a variable's path; a component may hold dots.
+fn components(var: &VariableRef) -> Vec<&str> {
+    var.path.iter().flat_map(|s| s.split('.')).collect()
+}
+
+fn has_pipe(var: &VariableRef, name: &str) -> bool {
+    var.pipes.iter().any(|pipe| pipe.name == name)
+}
+
+fn finish((name, usage): (String, Usage)) -> (String, TemplateVariable) {
+    let required = if usage.requirements.iter().any(BTreeSet::is_empty) {
+        Requirement::Always
+    } else if usage.requirements.is_empty() {
+        Requirement::Optional
+    } else {
+        let sets: BTreeSet<Vec<String>> = usage
+            .requirements
+            .iter()
+            .map(|set| set.iter().cloned().collect())
+            .collect();
+        Requirement::When(sets.into_iter().collect())
+    };
+    let (kind, fields) = shape(usage);
+    (
+        name,
+        TemplateVariable {
+            kind,
+            required,
+            fields,
+        },
+    )
+}
+
+/// The kind of a value and its fields.
+fn shape(mut usage: Usage) -> (VariableKind, BTreeMap<String, TemplateVariable>) {
+    if let Some(mut items) = usage.items.take() {
+        // The fields of a list are those of its elements, and interpolating
+        // a list interpolates its elements
+        items.interpolated |= usage.interpolated;
+        for (name, field) in std::mem::take(&mut usage.fields) {
+            merge(items.fields.entry(name).or_default(), field);
+        }
+        let (kind, fields) = shape(*items);
+        return (VariableKind::List(Box::new(kind)), fields);
+    }
+    let kind = if usage.map || !usage.fields.is_empty() {
+        VariableKind::Map
+    } else if usage.interpolated {
+        VariableKind::Scalar
+    } else {
+        VariableKind::Any
+    };
+    (kind, usage.fields.into_iter().map(finish).collect())
+}
+
+fn merge(into: &mut Usage, from: Usage) {
+    into.interpolated |= from.interpolated;
+    into.map |= from.map;
+    into.requirements.extend(from.requirements);
+    if let Some(items) = from.items {
+        merge(into.items.get_or_insert_default(), *items);
+    }
+    for (name, field) in from.fields {
+        merge(into.fields.entry(name).or_default(), field);
+    }
+}
+
+fn kind_schema(kind: &VariableKind, fields: &BTreeMap<String, TemplateVariable>) -> Value {
+    match kind {
+        VariableKind::Any => json!({}),
+        VariableKind::Scalar => json!({ "type": ["string", "number", "boolean"] }),
+        VariableKind::Map => object_schema(fields),
+        VariableKind::List(items) => json!({
+            "type": "array",
+            "items": kind_schema(items, fields),
+        }),
+    }
+}
+
+fn object_schema(fields: &BTreeMap<String, TemplateVariable>) -> Value {
+    let mut schema = Map::new();
+    schema.insert("type".to_string(), json!("object"));
+    if fields.is_empty() {
+        return Value::Object(schema);
+    }
+    let properties: Map<String, Value> = fields
+        .iter()
+        .map(|(name, field)| (name.clone(), kind_schema(&field.kind, &field.fields)))
+        .collect();
+    schema.insert("properties".to_string(), Value::Object(properties));
+
+    let required: Vec<&String> = fields
+        .iter()
+        .filter(|(_, field)| field.required == Requirement::Always)
+        .map(|(name, _)| name)
+        .collect();
+    if !required.is_empty() {
+        schema.insert("required".to_string(), json!(required));
+    }
+
+    // The fields each set of conditions requires
+    let mut dependent: BTreeMap<&[String], Vec<&String>> = BTreeMap::new();
+    for (name, field) in fields {
+        if let Requirement::When(sets) = &field.required {
+            for set in sets {
+                dependent.entry(set.as_slice()).or_default().push(name);
+            }
+        }
+    }
+    if !dependent.is_empty() {
+        let clauses: Vec<Value> = dependent
+            .into_iter()
+            .map(|(conditions, required)| {
+                let truthy: Map<String, Value> = conditions
+                    .iter()
+                    .map(|name| {
+                        (
+                            name.clone(),
+                            json!({ "not": { "enum": [false, null, "", []] } }),
+                        )
+                    })
+                    .collect();
+                json!({
+                    "if": { "properties": truthy, "required": conditions },
+                    "then": { "required": required },
+                })
+            })
+            .collect();
+        schema.insert("allOf".to_string(), Value::Array(clauses));
+    }
+    Value::Object(schema)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::resolver::MemoryResolver;
+    use std::path::Path;
+
+    fn variables(source: &str) -> TemplateVariables {
+        template_variables(&Template::compile(source).unwrap())
+    }
+
+    fn variable<'a>(variables: &'a TemplateVariables, path: &str) -> &'a TemplateVariable {
+        let mut components = path.split('.');
+        let mut variable = &variables.variables[components.next().unwrap()];
+        for name in components {
+            variable = &variable.fields[name];
+        }
+        variable
+    }
+
+    #[test]
+    fn test_kinds() {
+        let vars = variables(
+            "$title$ $if(numbersections)$x$endif$ $for(author)$$author.name$$endfor$ \
+             $keywords[, ]$ $date.year$ $tags/length$",
+        );
+        assert_eq!(variable(&vars, "title").kind, VariableKind::Scalar);
+        assert_eq!(variable(&vars, "numbersections").kind, VariableKind::Any);
+        assert_eq!(
+            variable(&vars, "author").kind,
+            VariableKind::List(Box::new(VariableKind::Map))
+        );
+        assert_eq!(variable(&vars, "author.name").kind, VariableKind::Scalar);
+        assert_eq!(
+            variable(&vars, "keywords").kind,
+            VariableKind::List(Box::new(VariableKind::Scalar))
+        );
+        assert_eq!(variable(&vars, "date").kind, VariableKind::Map);
+        assert_eq!(variable(&vars, "tags").kind, VariableKind::Any);
+    }
+
+    #[test]
+    fn test_fields_of_a_list_are_fields_of_its_elements() {
+        let vars = variables("$author.email$ $for(author)$$it.name$$endfor$");
+        let author = variable(&vars, "author");
+        assert_eq!(author.kind, VariableKind::List(Box::new(VariableKind::Map)));
+        assert_eq!(
+            author.fields.keys().collect::<Vec<_>>(),
+            vec!["email", "name"]
+        );
+    }
+
+    #[test]
+    fn test_requirements() {
+        let vars = variables(
+            "$title$ $if(subtitle)$$subtitle$$endif$ $if(draft)$$watermark$$endif$ \
+             $if(abstract)$x$else$$summary$$endif$ $for(author)$$author.name$$endfor$",
+        );
+        assert_eq!(variable(&vars, "title").required, Requirement::Always);
+        assert_eq!(variable(&vars, "subtitle").required, Requirement::Optional);
+        assert_eq!(variable(&vars, "draft").required, Requirement::Optional);
+        assert_eq!(
+            variable(&vars, "watermark").required,
+            Requirement::When(vec![vec!["draft".to_string()]])
+        );
+        assert_eq!(variable(&vars, "summary").required, Requirement::Optional);
+        assert_eq!(variable(&vars, "author").required, Requirement::Optional);
+        assert_eq!(variable(&vars, "author.name").required, Requirement::Always);
+    }
+
+    #[test]
+    fn test_writer_variables_are_not_reported() {
+        let vars = variables("$body$ $for(header-includes)$$it$$endfor$ $title$");
+        assert_eq!(vars.variables.keys().collect::<Vec<_>>(), vec!["title"]);
+    }
+
+    #[test]
+    fn test_partials() {
+        let mut resolver = MemoryResolver::new();
+        resolver.add("author", "$it.name$ <$it.email$>");
+        let template = Template::compile_with_resolver(
+            "$for(author)$$author:author()$$endfor$ $contributor:author()[, ]$",
+            Path::new("doc.html"),
+            &resolver,
+            0,
+        )
+        .unwrap();
+        let vars = template_variables(&template);
+        assert_eq!(
+            variable(&vars, "author").fields.keys().collect::<Vec<_>>(),
+            vec!["email", "name"]
+        );
+        assert_eq!(
+            variable(&vars, "contributor").kind,
+            VariableKind::List(Box::new(VariableKind::Map))
+        );
+        assert_eq!(variable(&vars, "contributor").required, Requirement::Always);
+    }
+
+    #[test]
+    fn test_json_schema() {
+        let vars =
+            variables("$title$ $if(draft)$$watermark$$endif$ $for(author)$$author.name$$endfor$");
+        assert_eq!(
+            vars.json_schema(),
+            json!({
+                "$schema": "https://json-schema.org/draft/2020-12/schema",
+                "type": "object",
+                "properties": {
+                    "author": {
+                        "type": "array",
+                        "items": {
+                            "type": "object",
+                            "properties": {
+                                "name": { "type": ["string", "number", "boolean"] }
+                            },
+                            "required": ["name"]
+                        }
+                    },
+                    "draft": {},
+                    "title": { "type": ["string", "number", "boolean"] },
+                    "watermark": { "type": ["string", "number", "boolean"] }
+                },
+                "required": ["title"],
+                "allOf": [{
+                    "if": {
+                        "properties": { "draft": { "not": { "enum": [false, null, "", []] } } },
+                        "required": ["draft"]
+                    },
+                    "then": { "required": ["watermark"] }
+                }]
+            })
+        );
+    }
+}
@@ -0,0 +1,592 @@
+//! Access control: roles, share links, and what a connection may write
+//!
+//! With access control enabled, every client presents a token when it
+//! connects (`?token=` on the WebSocket URL, or a bearer token for the REST
+//! API). The owner token is created with the hub and shown once; the owner
+//! creates share links, each a token with a role and an optional expiry.
+//! Tokens and links are kept in a local-only file at
+//! `.quarto/hub/access.json`, readable only by the user running the hub.
+//!
+//! Roles apply to the hub's project: its index document and every document
+//! in it. Sync messages from connections that may not edit are checked
+//! before they reach the repo (see `admits_sync_message`).
+
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use automerge::sync::{self, SyncDoc};
+use automerge::{Automerge, PatchAction, Prop};
+use serde::{Deserialize, Serialize};
+use subtle::ConstantTimeEq;
+use tracing::{debug, warn};
+
+use crate::error::{Error, Result};
+
+/// What a connection may do with the project's documents, from least to
+/// most.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Role {
+    /// Read only
+    Viewer,
+    /// Read, and write suggestions and comments
+    Commenter,
+    /// Read and write
+    Editor,
+    /// Read and write, and manage share links
+    Owner,
+}
+
+impl Role {
+    /// Whether the role may change document content.
+    pub fn can_edit(self) -> bool {
+        self >= Role::Editor
+    }
+
+    /// Whether the role may write suggestions and comments.
+    pub fn can_comment(self) -> bool {
+        self >= Role::Commenter
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Role::Viewer => "viewer",
+            Role::Commenter => "commenter",
+            Role::Editor => "editor",
+            Role::Owner => "owner",
+        }
+    }
+}
+
+impl FromStr for Role {
+    type Err = Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "viewer" => Ok(Role::Viewer),
+            "commenter" => Ok(Role::Commenter),
+            "editor" => Ok(Role::Editor),
+            "owner" => Ok(Role::Owner),
+            _ => Err(Error::Access(format!("unknown role: {}", s))),
+        }
+    }
+}
+
+/// Keys a commenter may write at the root of a document: the suggestions
+/// and comment threads of a text document, and the schema version clients
+/// set when they add those.
+const COMMENTER_KEYS: &[&str] = &["suggestions", "comments", "schemaVersion"];
+
+/// A token granting a role, until it expires.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ShareLink {
+    pub token: String,
+    pub role: Role,
+
+    /// When the link was created (seconds since the Unix epoch)
+    pub created_at: u64,
+
+    /// When the link stops working (seconds since the Unix epoch).
+    /// None for links that don't expire.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub expires_at: Option<u64>,
+
+    /// What the link is for, as given by the owner
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub label: Option<String>,
+}
+
+impl ShareLink {
+    /// Whether the link has expired at `now` (seconds since the Unix epoch).
+    pub fn is_expired(&self, now: u64) -> bool {
+        self.expires_at.is_some_and(|expires_at| now >= expires_at)
+    }
+}
+
+/// Persistent access data.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+struct AccessData {
+    /// The token of the hub's owner
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    owner_token: Option<String>,
+
+    #[serde(default)]
+    links: Vec<ShareLink>,
+}
+
+/// Manager for the owner token and share links, handling persistence with
+/// atomic writes.
+pub struct AccessStore {
+    /// Path to the hub directory (`.quarto/hub/`)
+    hub_dir: PathBuf,
+
+    /// In-memory access data
+    data: AccessData,
+
+    /// Whether `load` created the owner token
+    owner_token_created: bool,
+}
+
+impl AccessStore {
+    /// Load access data from disk, creating the owner token on first use.
+    pub fn load(hub_dir: &Path) -> Result<Self> {
+        let path = hub_dir.join("access.json");
+
+        let data = if path.exists() {
+            let content = std::fs::read_to_string(&path)?;
+            serde_json::from_str(&content)
+                .map_err(|e| Error::Access(format!("failed to parse access.json: {}", e)))?
+        } else {
+            debug!("No access.json found, starting with no share links");
+            AccessData::default()
+        };
+
+        let mut store = Self {
+            hub_dir: hub_dir.to_path_buf(),
+            data,
+            owner_token_created: false,
+        };
+        if store.data.owner_token.is_none() {
+            store.data.owner_token = Some(new_token());
+            store.owner_token_created = true;
+            store.save()?;
+        }
+        Ok(store)
+    }
+
+    /// Save access data to disk using atomic write (write to temp, then rename).
+    /// The file holds tokens, so only its owner may read it.
+    pub fn save(&self) -> Result<()> {
+        let target = self.path();
+        let temp = self.hub_dir.join("access.json.tmp");
+
+        let content = serde_json::to_string_pretty(&self.data)
+            .map_err(|e| Error::Access(format!("failed to serialize access data: {}", e)))?;
+        write_private(&temp, content.as_bytes())?;
+        std::fs::rename(&temp, &target)?;
+
+        debug!("Saved access data to {}", target.display());
+        Ok(())
+    }
+
+    /// Path to `access.json`.
+    pub fn path(&self) -> PathBuf {
+        self.hub_dir.join("access.json")
+    }
+
+    /// Whether `load` created the owner token, which is then shown once.
+    pub fn owner_token_created(&self) -> bool {
+        self.owner_token_created
+    }
+
+    /// The owner's token.
+    pub fn owner_token(&self) -> &str {
+        self.data.owner_token.as_deref().unwrap_or_default()
+    }
+
+    /// The role a token grants at `now`, if any.
+    pub fn role_for(&self, token: &str, now: u64) -> Option<Role> {
+        if token.is_empty() {
+            return None;
+        }
+        if tokens_match(token, self.owner_token()) {
+            return Some(Role::Owner);
+        }
+        self.link(token)
+            .filter(|link| !link.is_expired(now))
+            .map(|link| link.role)
+    }
+
+    /// The share link with a token.
+    pub fn link(&self, token: &str) -> Option<&ShareLink> {
+        self.data
+            .links
+            .iter()
+            .find(|link| tokens_match(&link.token, token))
+    }
+
+    /// All share links, expired ones included.
+    pub fn links(&self) -> &[ShareLink] {
+        &self.data.links
+    }
+
+    /// Create and persist a share link. Links can't grant `Owner`.
+    pub fn create_link(
+        &mut self,
+        role: Role,
+        expires_in: Option<Duration>,
+        label: Option<String>,
+        now: u64,
+    ) -> Result<ShareLink> {
+        if role == Role::Owner {
+            return Err(Error::Access(
+                "share links can't grant the owner role".to_string(),
+            ));
+        }
+
+        let link = ShareLink {
+            token: new_token(),
+            role,
+            created_at: now,
+            expires_at: expires_in.map(|d| now + d.as_secs()),
+            label,
+        };
+        self.data.links.push(link.clone());
+        self.save()?;
+        Ok(link)
+    }
+
+    /// Revoke and forget a share link. Returns false if there was none.
+    pub fn revoke_link(&mut self, token: &str) -> Result<bool> {
+        let before = self.data.links.len();
+        self.data
+            .links
+            .retain(|link| !tokens_match(&link.token, token));
+        if self.data.links.len() == before {
+            return Ok(false);
+        }
+        self.save()?;
+        Ok(true)
+    }
+}
+
+/// Seconds since the Unix epoch.
+pub fn unix_now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_secs()
+}
+
+/// Write a file only its owner may read or write.
+fn write_private(path: &Path, content: &[u8]) -> Result<()> {
+    use std::io::Write;
+
+    let mut options = std::fs::OpenOptions::new();
+    options.write(true).create(true).truncate(true);
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::{OpenOptionsExt, PermissionsExt};
+        options.mode(0o600);
+        let mut file = options.open(path)?;
+        // The mode only applies to new files
+        file.set_permissions(std::fs::Permissions::from_mode(0o600))?;
+        file.write_all(content)?;
+    }
+    #[cfg(not(unix))]
+    {
+        let mut file = options.open(path)?;
+        file.write_all(content)?;
+    }
+    Ok(())
+}
+
+/// A new random token.
+fn new_token() -> String {
+    uuid::Uuid::new_v4().simple().to_string()
+}
+
+/// The document and sync message of a repo protocol message, for the
+/// messages that carry changes (`sync` and `request`).
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SyncFrame {
+    pub document_id: String,
+    pub data: Vec<u8>,
+}
+
+/// Decode the sync message of a repo protocol message (a CBOR map with
+/// `type`, `documentId` and `data`). Returns None for other messages (peer
+/// handshakes, ephemeral messages and so on) and for undecodable ones.
+pub fn decode_sync_frame(bytes: &[u8]) -> Option<SyncFrame> {
+    let value: ciborium::Value = ciborium::from_reader(bytes).ok()?;
+    let map = value.as_map()?;
+    let field = |name: &str| {
+        map.iter()
+            .find(|(key, _)| key.as_text() == Some(name))
+            .map(|(_, value)| value)
+    };
+
+    let kind = field("type")?.as_text()?;
+    if kind != "sync" && kind != "request" {
+        return None;
+    }
+    Some(SyncFrame {
+        document_id: field("documentId")?.as_text()?.to_string(),
+        data: field("data")?.as_bytes()?.clone(),
+    })
+}
+
+/// Whether a sync message from a connection with `role` may be applied to
+/// `doc`.
+///
+/// The message is applied to a fork of the document and the result
+/// checked: editors may change anything, commenters only the keys in
+/// `COMMENTER_KEYS`, and viewers nothing. Messages that only exchange
+/// heads, or send changes the document already has, are always admitted.
+pub fn admits_sync_message(role: Role, doc: &Automerge, message: sync::Message) -> bool {
+    if role.can_edit() {
+        return true;
+    }
+
+    let before = doc.get_heads();
+    let mut fork = doc.fork();
+    if let Err(e) = fork.receive_sync_message(&mut sync::State::new(), message) {
+        warn!(error = %e, "Rejecting undecodable sync message");
+        return false;
+    }
+    let after = fork.get_heads();
+    if after == before {
+        return true;
+    }
+    if !role.can_comment() {
+        return false;
+    }
+
+    fork.diff(&before, &after).iter().all(|patch| {
+        let key = match (patch.path.first(), &patch.action) {
+            (Some((_, Prop::Map(key))), _) => key,
+            (None, PatchAction::PutMap { key, .. } | PatchAction::DeleteMap { key }) => key,
+            _ => return false,
+        };
+        COMMENTER_KEYS.contains(&key.as_str())
+    })
+}
+
+/// Whether two tokens are equal, taking the same time wherever they
+/// differ, so that a client can't guess a token byte by byte from how long
+/// it takes to be refused.
+fn tokens_match(a: &str, b: &str) -> bool {
+    a.as_bytes().ct_eq(b.as_bytes()).into()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use automerge::{ObjType, ROOT, ReadDoc, transaction::Transactable};
+    use tempfile::TempDir;
+
+    /// A text document, as the hub creates them.
+    fn text_doc(content: &str) -> Automerge {
+        let mut doc = Automerge::new();
+        doc.transact::<_, _, automerge::AutomergeError>(|tx| {
+            let text = tx.put_object(ROOT, "text", ObjType::Text)?;
+            tx.update_text(&text, content)?;
+            tx.put_object(ROOT, "comments", ObjType::Map)?;
+            Ok(())
+        })
+        .unwrap();
+        doc
+    }
+
+    /// The sync messages a client sends the server to give it its changes.
+    fn messages_to(server: &Automerge, client: &mut Automerge) -> Vec<sync::Message> {
+        let mut server = server.fork();
+        let mut client_state = sync::State::new();
+        let mut server_state = sync::State::new();
+        let mut messages = Vec::new();
+        while let Some(message) = client.generate_sync_message(&mut client_state) {
+            messages.push(message.clone());
+            server
+                .receive_sync_message(&mut server_state, message)
+                .unwrap();
+            if let Some(reply) = server.generate_sync_message(&mut server_state) {
+                client
+                    .receive_sync_message(&mut client_state, reply)
+                    .unwrap();
+            }
+            assert!(messages.len() < 10, "sync did not converge");
+        }
+        messages
+    }
+
+    fn admits_all(role: Role, server: &Automerge, client: &mut Automerge) -> bool {
+        messages_to(server, client)
+            .into_iter()
+            .all(|message| admits_sync_message(role, server, message))
+    }
+
+    fn edit_text(doc: &mut Automerge, content: &str) {
+        let (_, text) = doc.get(ROOT, "text").unwrap().unwrap();
+        doc.transact::<_, _, automerge::AutomergeError>(|tx| tx.update_text(&text, content))
+            .unwrap();
+    }
+
+    fn add_comment(doc: &mut Automerge) {
+        let (_, comments) = doc.get(ROOT, "comments").unwrap().unwrap();
+        doc.transact::<_, _, automerge::AutomergeError>(|tx| {
+            let thread = tx.put_object(&comments, "t1", ObjType::Map)?;
+            tx.put(&thread, "quote", "Hello")?;
+            Ok(())
+        })
+        .unwrap();
+    }
+
+    #[test]
+    fn test_roles_are_ordered() {
+        assert!(Role::Owner.can_edit());
+        assert!(Role::Editor.can_edit());
+        assert!(!Role::Commenter.can_edit());
+        assert!(Role::Commenter.can_comment());
+        assert!(!Role::Viewer.can_comment());
+        assert_eq!("commenter".parse::<Role>().unwrap(), Role::Commenter);
+        assert!("admin".parse::<Role>().is_err());
+    }
+
+    #[test]
+    fn test_owner_token_is_created_and_kept() {
+        let temp = TempDir::new().unwrap();
+        let token = AccessStore::load(temp.path())
+            .unwrap()
+            .owner_token()
+            .to_string();
+        assert!(!token.is_empty());
+
+        let store = AccessStore::load(temp.path()).unwrap();
+        assert_eq!(store.owner_token(), token);
+        assert_eq!(store.role_for(&token, unix_now()), Some(Role::Owner));
+    }
+
+    #[test]
+    fn test_only_whole_tokens_match() {
+        assert!(tokens_match("abc123", "abc123"));
+        assert!(!tokens_match("abc123", "abc124"));
+        assert!(!tokens_match("abc123", "abc"));
+        assert!(!tokens_match("abc", "abc123"));
+
+        let temp = TempDir::new().unwrap();
+        let store = AccessStore::load(temp.path()).unwrap();
+        let owner = store.owner_token();
+        assert_eq!(store.role_for(&owner[..owner.len() - 1], unix_now()), None);
+    }
+
+    #[test]
+    fn test_owner_token_is_shown_once() {
+        let temp = TempDir::new().unwrap();
+        assert!(
+            AccessStore::load(temp.path())
+                .unwrap()
+                .owner_token_created()
+        );
+        assert!(
+            !AccessStore::load(temp.path())
+                .unwrap()
+                .owner_token_created()
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_access_file_is_private() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp = TempDir::new().unwrap();
+        let store = AccessStore::load(temp.path()).unwrap();
+        let mode = std::fs::metadata(store.path())
+            .unwrap()
+            .permissions()
+            .mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+
+    #[test]
+    fn test_share_links_grant_their_role_until_they_expire() {
+        let temp = TempDir::new().unwrap();
+        let mut store = AccessStore::load(temp.path()).unwrap();
+
+        let link = store
+            .create_link(Role::Viewer, Some(Duration::from_secs(60)), None, 1000)
+            .unwrap();
+        assert_eq!(store.role_for(&link.token, 1059), Some(Role::Viewer));
+        assert_eq!(store.role_for(&link.token, 1060), None);
+        assert_eq!(store.role_for("unknown", 1000), None);
+        assert_eq!(store.role_for("", 1000), None);
+
+        // Links are persisted
+        let store = AccessStore::load(temp.path()).unwrap();
+        assert_eq!(store.links(), &[link]);
+    }
+
+    #[test]
+    fn test_share_links_can_be_revoked() {
+        let temp = TempDir::new().unwrap();
+        let mut store = AccessStore::load(temp.path()).unwrap();
+
+        let link = store.create_link(Role::Editor, None, None, 1000).unwrap();
+        assert!(store.revoke_link(&link.token).unwrap());
+        assert!(!store.revoke_link(&link.token).unwrap());
+        assert_eq!(store.role_for(&link.token, 1000), None);
+    }
+
+    #[test]
+    fn test_share_links_cannot_grant_owner() {
+        let temp = TempDir::new().unwrap();
+        let mut store = AccessStore::load(temp.path()).unwrap();
+        assert!(store.create_link(Role::Owner, None, None, 1000).is_err());
+    }
+
+    #[test]
+    fn test_decode_sync_frame() {
+        use ciborium::Value;
+
+        let encode = |kind: &str| {
+            let value = Value::Map(vec![
+                (Value::Text("type".into()), Value::Text(kind.into())),
+                (Value::Text("senderId".into()), Value::Text("peer-1".into())),
+                (
+                    Value::Text("documentId".into()),
+                    Value::Text("doc-1".into()),
+                ),
+                (Value::Text("data".into()), Value::Bytes(vec![1, 2, 3])),
+            ]);
+            let mut bytes = Vec::new();
+            ciborium::into_writer(&value, &mut bytes).unwrap();
+            bytes
+        };
+
+        assert_eq!(
+            decode_sync_frame(&encode("sync")),
+            Some(SyncFrame {
+                document_id: "doc-1".to_string(),
+                data: vec![1, 2, 3],
+            })
+        );
+        assert!(decode_sync_frame(&encode("request")).is_some());
+        assert_eq!(decode_sync_frame(&encode("ephemeral")), None);
+        assert_eq!(decode_sync_frame(b"not cbor"), None);
+    }
+
+    #[test]
+    fn test_editors_may_change_anything() {
+        let server = text_doc("Hello");
+        let mut client = server.fork();
+        edit_text(&mut client, "Hello, world");
+        assert!(admits_all(Role::Editor, &server, &mut client));
+    }
+
+    #[test]
+    fn test_viewers_may_only_read() {
+        let server = text_doc("Hello");
+
+        // Exchanging heads is fine
+        let mut client = server.fork();
+        assert!(admits_all(Role::Viewer, &server, &mut client));
+
+        edit_text(&mut client, "Hello, world");
+        assert!(!admits_all(Role::Viewer, &server, &mut client));
+    }
+
+    #[test]
+    fn test_commenters_may_only_comment() {
+        let server = text_doc("Hello");
+
+        let mut commenting = server.fork();
+        add_comment(&mut commenting);
+        assert!(admits_all(Role::Commenter, &server, &mut commenting));
+        assert!(!admits_all(Role::Viewer, &server, &mut commenting));
+
+        let mut editing = server.fork();
+        edit_text(&mut editing, "Hello, world");
+        assert!(!admits_all(Role::Commenter, &server, &mut editing));
+    }
+}
@@ -11,6 +11,7 @@ use samod::storage::TokioFilesystemStorage;
 use tokio::sync::{Mutex, RwLock};
 use tracing::{debug, info, warn};
 
+use crate::access::{AccessStore, Role, unix_now};
 use crate::discovery::ProjectFiles;
 use crate::error::Result;
 use crate::index::{IndexDocument, load_or_create_index};
@@ -45,6 +46,16 @@ pub struct HubConfig {
     /// Debounce duration for filesystem events in milliseconds.
     /// Default: 500ms.
     pub watch_debounce_ms: u64,
+
+    /// Require a token to connect (see `access`).
+    /// When disabled, every client can read and write everything.
+    /// Default: false.
+    pub access_control: bool,
+
+    /// Origins the hub client is served from, allowed to call the REST API
+    /// from a browser. Only used with access control.
+    /// Default: none.
+    pub allowed_origins: Vec<String>,
 }
 
 impl Default for HubConfig {
@@ -56,6 +67,8 @@ impl Default for HubConfig {
             sync_interval_secs: Some(30),
             watch_enabled: true,
             watch_debounce_ms: 500,
+            access_control: false,
+            allowed_origins: Vec::new(),
         }
     }
 }
@@ -84,6 +97,9 @@ pub struct HubContext {
 
     /// Sync state for filesystem synchronization (protected by Mutex for interior mutability)
     sync_state: Mutex<SyncState>,
+
+    /// Owner token and share links; None when access control is disabled
+    access: Option<Mutex<AccessStore>>,
 }
 
 impl HubContext {
@@ -140,6 +156,25 @@ impl HubContext {
         // Initialize sync state from hub directory
         let sync_state = SyncState::load(storage.hub_dir())?;
 
+        let access = if config.access_control {
+            let store = AccessStore::load(storage.hub_dir())?;
+            if store.owner_token_created() {
+                // Shown once, on the terminal rather than in the log
+                eprintln!(
+                    "Owner token (shown once, and kept in {}): {}",
+                    store.path().display(),
+                    store.owner_token()
+                );
+            }
+            info!(
+                access_file = %store.path().display(),
+                "Access control enabled; connect as owner with ?token=<owner token>"
+            );
+            Some(Mutex::new(store))
+        } else {
+            None
+        };
+
         // Perform initial sync on startup
         let project_root = storage.project_root().to_path_buf();
         let mut sync_state_guard = sync_state;
@@ -159,6 +194,7 @@ impl HubContext {
             repo,
             index,
             sync_state: Mutex::new(sync_state_guard),
+            access,
         })
     }
 
@@ -187,6 +223,24 @@ impl HubContext {
         &self.index
     }
 
+    /// Get the owner token and share links, when access control is enabled.
+    pub fn access(&self) -> Option<&Mutex<AccessStore>> {
+        self.access.as_ref()
+    }
+
+    /// The role a client's token grants, if any.
+    ///
+    /// Without access control every client is an owner.
+    pub async fn role_for(&self, token: Option<&str>) -> Option<Role> {
+        match &self.access {
+            None => Some(Role::Owner),
+            Some(access) => access
+                .lock()
+                .await
+                .role_for(token.unwrap_or_default(), unix_now()),
+        }
+    }
+
     /// Perform a full sync of all documents with the filesystem.
     ///
     /// This is called on shutdown to ensure all changes are persisted.
@@ -41,6 +41,9 @@ pub enum Error {
 
     #[error("Sync error: {0}")]
     Sync(String),
+
+    #[error("Access error: {0}")]
+    Access(String),
 }
 
 pub type Result<T> = std::result::Result<T, Error>;
@@ -6,8 +6,12 @@
 //! Structure:
 //! ```
 //! ROOT
-//! └── files: Map<String, String>  // path -> document_id (bs58-encoded)
+//! ├── files: Map<String, String>  // path -> document_id (bs58-encoded)
+//! └── branches: Map<String, Map>  // branch_id -> branch of a file (clients only)
 //! ```
+//!
+//! Branches are separate documents that are not in `files`, so they are
+//! never written to the project directory.
 
 use std::collections::HashMap;
 use std::str::FromStr;
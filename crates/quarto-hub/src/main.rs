@@ -45,6 +45,20 @@ struct Args {
     /// Default: 500ms.
     #[arg(long, default_value = "500")]
     watch_debounce: u64,
+
+    /// Require a token to connect: the owner token (shown on first start,
+    /// and kept in .quarto/hub/access.json) or that of a share link
+    /// created by the owner.
+    /// Without it, anyone who can reach the hub can read and write.
+    #[arg(long)]
+    access_control: bool,
+
+    /// Origin the hub client is served from, such as
+    /// `https://hub.example.com`, allowed to call the REST API from a
+    /// browser (can be specified multiple times). Only used with
+    /// --access-control.
+    #[arg(long = "allow-origin", value_name = "ORIGIN")]
+    allow_origin: Vec<String>,
 }
 
 #[tokio::main]
@@ -102,6 +116,8 @@ async fn main() -> anyhow::Result<()> {
         sync_interval_secs,
         watch_enabled: !args.no_watch,
         watch_debounce_ms: args.watch_debounce,
+        access_control: args.access_control,
+        allowed_origins: args.allow_origin,
     };
 
     server::run_server(storage, config).await?;
@@ -4,23 +4,27 @@ use std::str::FromStr;
 use std::sync::Arc;
 use std::time::Duration;
 
+use automerge::Automerge;
 use axum::{
     Json, Router,
     extract::{
-        Path, State,
-        ws::{WebSocket, WebSocketUpgrade},
+        Path, Query, State,
+        ws::{Message, WebSocket, WebSocketUpgrade},
     },
-    http::StatusCode,
-    response::IntoResponse,
-    routing::get,
+    http::{HeaderMap, HeaderValue, Method, Request, StatusCode, Uri, header},
+    response::{IntoResponse, Response},
+    routing::{delete, get},
 };
-use samod::DocumentId;
+use futures::{SinkExt, StreamExt};
+use samod::{ConnDirection, DocumentId};
 use serde::{Deserialize, Serialize};
 use tokio::net::TcpListener;
 use tokio::sync::watch;
+use tower_http::cors::{AllowOrigin, CorsLayer};
 use tower_http::trace::TraceLayer;
-use tracing::{debug, info};
+use tracing::{debug, info, warn};
 
+use crate::access::{Role, ShareLink, admits_sync_message, decode_sync_frame, unix_now};
 use crate::context::{HubConfig, HubContext, SharedContext};
 use crate::error::Result;
 use crate::storage::StorageManager;
@@ -77,6 +81,36 @@ struct UpdateDocumentRequest {
     value: String,
 }
 
+/// Token of a request, from the `token` query parameter
+#[derive(Deserialize)]
+struct TokenQuery {
+    token: Option<String>,
+}
+
+/// The connecting client's access
+#[derive(Serialize)]
+struct AccessResponse {
+    role: Role,
+    access_control: bool,
+    /// When the client's share link expires (seconds since the Unix epoch)
+    expires_at: Option<u64>,
+}
+
+/// List of share links
+#[derive(Serialize)]
+struct ShareLinksResponse {
+    links: Vec<ShareLink>,
+}
+
+/// Create share link request
+#[derive(Deserialize)]
+struct CreateShareLinkRequest {
+    role: Role,
+    /// How long the link works for; forever when absent
+    expires_in_secs: Option<u64>,
+    label: Option<String>,
+}
+
 /// Health check endpoint
 async fn health(State(ctx): State<SharedContext>) -> impl IntoResponse {
     let response = HealthResponse {
@@ -89,7 +123,14 @@ async fn health(State(ctx): State<SharedContext>) -> impl IntoResponse {
 }
 
 /// List discovered files (from filesystem)
-async fn list_files(State(ctx): State<SharedContext>) -> impl IntoResponse {
+async fn list_files(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Viewer).await {
+        return response;
+    }
     let response = FilesResponse {
         qmd_files: ctx
             .project_files()
@@ -98,11 +139,18 @@ async fn list_files(State(ctx): State<SharedContext>) -> impl IntoResponse {
             .map(|p| p.display().to_string())
             .collect(),
     };
-    Json(response)
+    Json(response).into_response()
 }
 
 /// List all documents from the index
-async fn list_documents(State(ctx): State<SharedContext>) -> impl IntoResponse {
+async fn list_documents(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Viewer).await {
+        return response;
+    }
     let files = ctx.index().get_all_files();
 
     let documents: Vec<DocumentEntry> = files
@@ -110,14 +158,20 @@ async fn list_documents(State(ctx): State<SharedContext>) -> impl IntoResponse {
         .map(|(path, document_id)| DocumentEntry { path, document_id })
         .collect();
 
-    Json(DocumentsResponse { documents })
+    Json(DocumentsResponse { documents }).into_response()
 }
 
 /// Get a single document by ID
 async fn get_document(
     State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
     Path(doc_id_str): Path<String>,
-) -> impl IntoResponse {
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Viewer).await {
+        return response;
+    }
+
     // Validate the document ID format
     let doc_id = match DocumentId::from_str(&doc_id_str) {
         Ok(id) => id,
@@ -172,11 +226,17 @@ async fn get_document(
 /// In a real implementation, the document schema would be more structured.
 async fn update_document(
     State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
     Path(doc_id_str): Path<String>,
     Json(request): Json<UpdateDocumentRequest>,
 ) -> impl IntoResponse {
     use automerge::{ROOT, transaction::Transactable};
 
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Editor).await {
+        return response;
+    }
+
     // Validate the document ID format
     let doc_id = match DocumentId::from_str(&doc_id_str) {
         Ok(id) => id,
@@ -236,6 +296,139 @@ async fn update_document(
     }
 }
 
+/// The token of a request: a bearer token, or the `token` query parameter.
+fn request_token(headers: &HeaderMap, query: TokenQuery) -> Option<String> {
+    headers
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .map(str::to_string)
+        .or(query.token)
+}
+
+fn error_response(status: StatusCode, error: &str) -> Response {
+    (
+        status,
+        Json(ErrorResponse {
+            error: error.to_string(),
+        }),
+    )
+        .into_response()
+}
+
+/// The role of a request, or the response rejecting it.
+async fn require_role(
+    ctx: &HubContext,
+    headers: &HeaderMap,
+    query: TokenQuery,
+    needed: Role,
+) -> std::result::Result<(Role, Option<String>), Response> {
+    let token = request_token(headers, query);
+    match ctx.role_for(token.as_deref()).await {
+        Some(role) if role >= needed => Ok((role, token)),
+        Some(_) => Err(error_response(
+            StatusCode::FORBIDDEN,
+            "Not allowed for this role",
+        )),
+        None => Err(error_response(
+            StatusCode::UNAUTHORIZED,
+            "Missing, unknown or expired token",
+        )),
+    }
+}
+
+/// The connecting client's role, and when its share link expires.
+async fn get_access(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+) -> Response {
+    let (role, token) = match require_role(&ctx, &headers, query, Role::Viewer).await {
+        Ok(access) => access,
+        Err(response) => return response,
+    };
+    let expires_at = match (ctx.access(), token) {
+        (Some(access), Some(token)) => access
+            .lock()
+            .await
+            .link(&token)
+            .and_then(|link| link.expires_at),
+        _ => None,
+    };
+    Json(AccessResponse {
+        role,
+        access_control: ctx.access().is_some(),
+        expires_at,
+    })
+    .into_response()
+}
+
+/// List share links (owner only)
+async fn list_share_links(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Owner).await {
+        return response;
+    }
+    let links = match ctx.access() {
+        Some(access) => access.lock().await.links().to_vec(),
+        None => Vec::new(),
+    };
+    Json(ShareLinksResponse { links }).into_response()
+}
+
+/// Create a share link (owner only)
+async fn create_share_link(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+    Json(request): Json<CreateShareLinkRequest>,
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Owner).await {
+        return response;
+    }
+    let Some(access) = ctx.access() else {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            "Access control is not enabled on this hub",
+        );
+    };
+
+    let result = access.lock().await.create_link(
+        request.role,
+        request.expires_in_secs.map(Duration::from_secs),
+        request.label,
+        unix_now(),
+    );
+    match result {
+        Ok(link) => (StatusCode::CREATED, Json(link)).into_response(),
+        Err(e) => error_response(StatusCode::BAD_REQUEST, &e.to_string()),
+    }
+}
+
+/// Revoke a share link (owner only)
+async fn revoke_share_link(
+    State(ctx): State<SharedContext>,
+    headers: HeaderMap,
+    Query(query): Query<TokenQuery>,
+    Path(token): Path<String>,
+) -> Response {
+    if let Err(response) = require_role(&ctx, &headers, query, Role::Owner).await {
+        return response;
+    }
+    let Some(access) = ctx.access() else {
+        return error_response(StatusCode::NOT_FOUND, "Share link not found");
+    };
+
+    match access.lock().await.revoke_link(&token) {
+        Ok(true) => StatusCode::NO_CONTENT.into_response(),
+        Ok(false) => error_response(StatusCode::NOT_FOUND, "Share link not found"),
+        Err(e) => error_response(StatusCode::INTERNAL_SERVER_ERROR, &e.to_string()),
+    }
+}
+
 /// 404 handler
 async fn not_found() -> impl IntoResponse {
     (StatusCode::NOT_FOUND, "Not found")
@@ -244,16 +437,135 @@ async fn not_found() -> impl IntoResponse {
 /// WebSocket upgrade handler for automerge sync.
 ///
 /// Clients connect here to sync documents in real-time.
-async fn ws_handler(ws: WebSocketUpgrade, State(ctx): State<SharedContext>) -> impl IntoResponse {
-    ws.on_upgrade(|socket| handle_websocket(socket, ctx))
+/// With access control enabled, the token is taken from the `token` query
+/// parameter (browsers can't set headers on WebSocket connections).
+async fn ws_handler(
+    ws: WebSocketUpgrade,
+    State(ctx): State<SharedContext>,
+    Query(query): Query<TokenQuery>,
+) -> Response {
+    let token = query.token;
+    match ctx.role_for(token.as_deref()).await {
+        Some(role) => ws.on_upgrade(move |socket| handle_websocket(socket, ctx, role, token)),
+        None => error_response(
+            StatusCode::UNAUTHORIZED,
+            "Missing, unknown or expired token",
+        ),
+    }
+}
+
+/// How long to wait for the document a screened sync message is for.
+const SCREEN_FIND_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// How often the token of an idle connection is checked again, so that
+/// the connection ends soon after its share link expires or is revoked.
+const ACCESS_RECHECK_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Whether a message from a connection that may not edit is let through
+/// to the repo (see `admits_sync_message`).
+async fn admits_frame(ctx: &HubContext, role: Role, bytes: &[u8]) -> bool {
+    if role.can_edit() {
+        return true;
+    }
+    let Some(frame) = decode_sync_frame(bytes) else {
+        return true;
+    };
+    let message = match automerge::sync::Message::decode(&frame.data) {
+        Ok(message) => message,
+        Err(_) => return false,
+    };
+    let Ok(doc_id) = DocumentId::from_str(&frame.document_id) else {
+        return false;
+    };
+
+    // A document the hub doesn't have is checked against an empty one
+    match tokio::time::timeout(SCREEN_FIND_TIMEOUT, ctx.repo().find(doc_id)).await {
+        Ok(Ok(Some(handle))) => handle.with_document(|doc| admits_sync_message(role, doc, message)),
+        _ => admits_sync_message(role, &Automerge::new(), message),
+    }
+}
+
+/// Resolves once `token` no longer grants a role: its share link has
+/// expired or has been revoked.
+async fn access_lost(ctx: SharedContext, token: Option<String>) {
+    let mut interval = tokio::time::interval(ACCESS_RECHECK_INTERVAL);
+    loop {
+        interval.tick().await;
+        if ctx.role_for(token.as_deref()).await.is_none() {
+            return;
+        }
+    }
 }
 
 /// Handle an upgraded WebSocket connection.
-async fn handle_websocket(socket: WebSocket, ctx: SharedContext) {
-    // accept_axum returns immediately; the connection runs in the background
-    match ctx.repo().accept_axum(socket) {
+///
+/// With access control, each message the client sends is screened for the
+/// role its token grants when the message arrives, and the connection ends
+/// once the token grants none.
+async fn handle_websocket(
+    socket: WebSocket,
+    ctx: SharedContext,
+    role: Role,
+    token: Option<String>,
+) {
+    if ctx.access().is_none() {
+        accept_connection(ctx.repo().accept_axum(socket), role).await;
+        return;
+    }
+
+    // Screen what the client sends; what the hub sends goes out as is
+    let (sink, stream) = socket.split();
+    let screen_ctx = ctx.clone();
+    let screen_token = token.clone();
+    let stream = stream
+        .filter_map(move |message| {
+            let ctx = screen_ctx.clone();
+            let token = screen_token.clone();
+            async move {
+                match message {
+                    Ok(Message::Binary(bytes)) => {
+                        let Some(role) = ctx.role_for(token.as_deref()).await else {
+                            warn!("Dropping sync message: the token no longer grants access");
+                            return None;
+                        };
+                        if admits_frame(&ctx, role, &bytes).await {
+                            Some(Ok(bytes.to_vec()))
+                        } else {
+                            warn!(
+                                role = role.as_str(),
+                                "Dropping sync message not allowed for role"
+                            );
+                            None
+                        }
+                    }
+                    // Text, ping and close frames aren't repo messages
+                    Ok(_) => None,
+                    Err(e) => Some(Err(e)),
+                }
+            }
+        })
+        // Ending what the client sends ends the connection
+        .take_until(access_lost(ctx.clone(), token));
+    let sink = sink
+        .with(|bytes: Vec<u8>| async move { Ok::<_, axum::Error>(Message::Binary(bytes.into())) });
+
+    accept_connection(
+        ctx.repo()
+            .connect(Box::pin(stream), Box::pin(sink), ConnDirection::Incoming),
+        role,
+    )
+    .await;
+}
+
+/// Log a connection until it finishes.
+async fn accept_connection(
+    connection: std::result::Result<samod::Connection, samod::Stopped>,
+    role: Role,
+) {
+    // The connection runs in the background
+    match connection {
         Ok(connection) => {
-            info!(peer_info = ?connection.info(), "WebSocket client connected");
+            info!(peer_info = ?connection.info(), role = role.as_str(), "WebSocket client connected");
             // The connection is managed by samod and stays alive until the WebSocket closes.
             // We can optionally wait for it to finish if we want to log disconnection:
             let reason = connection.finished().await;
@@ -265,9 +577,55 @@ async fn handle_websocket(socket: WebSocket, ctx: SharedContext) {
     }
 }
 
+/// The CORS layer letting the hub client call the REST API from its own
+/// origin; none without access control, so that web pages can't use the
+/// API of a hub that doesn't check tokens.
+fn cors_layer(config: &HubConfig) -> Option<CorsLayer> {
+    if !config.access_control || config.allowed_origins.is_empty() {
+        return None;
+    }
+    let origins: Vec<HeaderValue> = config
+        .allowed_origins
+        .iter()
+        .filter_map(|origin| match HeaderValue::from_str(origin) {
+            Ok(value) => Some(value),
+            Err(_) => {
+                warn!(%origin, "Ignoring invalid allowed origin");
+                None
+            }
+        })
+        .collect();
+    Some(
+        CorsLayer::new()
+            .allow_origin(AllowOrigin::list(origins))
+            .allow_methods([Method::GET, Method::POST, Method::PUT, Method::DELETE])
+            .allow_headers([header::AUTHORIZATION, header::CONTENT_TYPE]),
+    )
+}
+
+/// A request's URI as it is logged: tokens, in `?token=` or in the path of
+/// a share link, are redacted.
+fn loggable_uri(uri: &Uri) -> String {
+    let path = match uri.path().strip_prefix("/api/share-links/") {
+        Some(token) if !token.is_empty() => "/api/share-links/<redacted>",
+        _ => uri.path(),
+    };
+    let Some(query) = uri.query() else {
+        return path.to_string();
+    };
+    let query: Vec<&str> = query
+        .split('&')
+        .map(|pair| match pair.split_once('=') {
+            Some(("token", _)) => "token=<redacted>",
+            _ => pair,
+        })
+        .collect();
+    format!("{}?{}", path, query.join("&"))
+}
+
 /// Build the axum router
-fn build_router(ctx: SharedContext) -> Router {
-    Router::new()
+fn build_router(ctx: SharedContext, cors: Option<CorsLayer>) -> Router {
+    let router = Router::new()
         .route("/health", get(health))
         .route("/api/files", get(list_files))
         .route("/api/documents", get(list_documents))
@@ -275,14 +633,34 @@ fn build_router(ctx: SharedContext) -> Router {
             "/api/documents/{id}",
             get(get_document).put(update_document),
         )
+        .route("/api/access", get(get_access))
+        .route(
+            "/api/share-links",
+            get(list_share_links).post(create_share_link),
+        )
+        .route("/api/share-links/{token}", delete(revoke_share_link))
         // WebSocket endpoint for automerge sync
         // Root path "/" is the standard location used by sync.automerge.org
         // "/ws" is kept for backward compatibility
         .route("/", get(ws_handler))
         .route("/ws", get(ws_handler))
         .fallback(not_found)
-        .layer(TraceLayer::new_for_http())
-        .with_state(ctx)
+        .layer(
+            TraceLayer::new_for_http().make_span_with(|request: &Request<_>| {
+                tracing::debug_span!(
+                    "request",
+                    method = %request.method(),
+                    uri = %loggable_uri(request.uri()),
+                    version = ?request.version(),
+                )
+            }),
+        );
+    // The hub client is served from elsewhere and calls the REST API
+    let router = match cors {
+        Some(cors) => router.layer(cors),
+        None => router,
+    };
+    router.with_state(ctx)
 }
 
 /// Run the hub server.
@@ -298,6 +676,7 @@ pub async fn run_server(storage: StorageManager, config: HubConfig) -> Result<()
     let sync_interval = config.sync_interval_secs;
     let watch_enabled = config.watch_enabled;
     let watch_debounce_ms = config.watch_debounce_ms;
+    let cors = cors_layer(&config);
     let project_root = storage.project_root().to_path_buf();
 
     // HubContext::new is now async (initializes samod repo and performs initial sync)
@@ -306,7 +685,7 @@ pub async fn run_server(storage: StorageManager, config: HubConfig) -> Result<()
     let ctx_for_watch = ctx.clone();
     let ctx_for_shutdown = ctx.clone();
 
-    let router = build_router(ctx);
+    let router = build_router(ctx, cors);
 
     let listener = TcpListener::bind(&addr).await?;
     info!(%addr, "Hub server listening");
@@ -512,3 +891,102 @@ async fn wait_for_shutdown_signal() {
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::body::Body;
+    use tempfile::TempDir;
+    use tower::ServiceExt;
+
+    /// A hub for a project of one document, without background tasks.
+    async fn test_hub(access_control: bool) -> (TempDir, SharedContext) {
+        let temp = TempDir::new().unwrap();
+        std::fs::write(temp.path().join("index.qmd"), "# Hello\n").unwrap();
+        let storage = StorageManager::new(temp.path()).unwrap();
+        let config = HubConfig {
+            sync_interval_secs: None,
+            watch_enabled: false,
+            access_control,
+            ..HubConfig::default()
+        };
+        let ctx = Arc::new(HubContext::new(storage, config).await.unwrap());
+        (temp, ctx)
+    }
+
+    async fn get_status(ctx: &SharedContext, uri: &str) -> StatusCode {
+        build_router(ctx.clone(), None)
+            .oneshot(Request::get(uri).body(Body::empty()).unwrap())
+            .await
+            .unwrap()
+            .status()
+    }
+
+    #[tokio::test]
+    async fn test_rest_api_needs_a_token_with_access_control() {
+        let (_temp, ctx) = test_hub(true).await;
+        let (_path, doc_id) = ctx.index().get_all_files().into_iter().next().unwrap();
+        let document = format!("/api/documents/{}", doc_id);
+
+        for uri in ["/api/files", "/api/documents", document.as_str()] {
+            assert_eq!(
+                get_status(&ctx, uri).await,
+                StatusCode::UNAUTHORIZED,
+                "{}",
+                uri
+            );
+        }
+
+        let owner_token = ctx.access().unwrap().lock().await.owner_token().to_string();
+        for uri in ["/api/files", "/api/documents", document.as_str()] {
+            let uri = format!("{}?token={}", uri, owner_token);
+            assert_eq!(get_status(&ctx, &uri).await, StatusCode::OK, "{}", uri);
+        }
+    }
+
+    #[tokio::test]
+    async fn test_rest_api_is_open_without_access_control() {
+        let (_temp, ctx) = test_hub(false).await;
+        assert_eq!(get_status(&ctx, "/api/documents").await, StatusCode::OK);
+    }
+
+    #[test]
+    fn test_tokens_are_not_logged() {
+        let uri = |s: &str| s.parse::<Uri>().unwrap();
+        assert_eq!(loggable_uri(&uri("/?token=s3cret")), "/?token=<redacted>");
+        assert_eq!(
+            loggable_uri(&uri("/ws?a=1&token=s3cret&b=2")),
+            "/ws?a=1&token=<redacted>&b=2"
+        );
+        assert_eq!(
+            loggable_uri(&uri("/api/share-links/s3cret")),
+            "/api/share-links/<redacted>"
+        );
+        assert_eq!(loggable_uri(&uri("/api/share-links")), "/api/share-links");
+        assert_eq!(
+            loggable_uri(&uri("/api/documents?x=1")),
+            "/api/documents?x=1"
+        );
+    }
+
+    #[test]
+    fn test_cors_only_with_access_control() {
+        let origins = vec!["https://hub.example.com".to_string()];
+        assert!(cors_layer(&HubConfig::default()).is_none());
+        assert!(
+            cors_layer(&HubConfig {
+                allowed_origins: origins.clone(),
+                ..HubConfig::default()
+            })
+            .is_none()
+        );
+        assert!(
+            cors_layer(&HubConfig {
+                access_control: true,
+                allowed_origins: origins,
+                ..HubConfig::default()
+            })
+            .is_some()
+        );
+    }
+}
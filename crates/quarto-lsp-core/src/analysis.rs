@@ -63,7 +63,7 @@ pub fn analyze_document(doc: &Document) -> DocumentAnalysis {
     );
 
     match result {
-        Ok((mut pandoc, _ast_context, warnings)) => {
+        Ok((mut pandoc, ast_context, warnings)) => {
             // Run analysis transforms to resolve shortcodes, etc.
             let mut analysis_ctx = DocumentAnalysisContext::new();
             let transforms: Vec<&dyn AnalysisTransform> = vec![&MetaShortcodeTransform];
@@ -79,6 +79,15 @@ pub fn analyze_document(doc: &Document) -> DocumentAnalysis {
                 .filter_map(|msg| convert_diagnostic(msg, &source_context))
                 .collect();
 
+            // Add the problems of `#|` cell options
+            let cell_options =
+                pampa::cell_options::validate_document(&pandoc, &ast_context.source_context);
+            diagnostics.extend(
+                cell_options
+                    .iter()
+                    .filter_map(|msg| convert_diagnostic(msg, &source_context)),
+            );
+
             // Add diagnostics from analysis transforms
             for diag in analysis_ctx.diagnostics() {
                 if let Some(d) = convert_diagnostic(diag, &source_context) {
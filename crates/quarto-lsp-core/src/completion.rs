@@ -0,0 +1,276 @@
+//! Completion of cross-reference labels and shortcode names.
+//!
+//! Completions are offered in two places:
+//!
+//! - After `@`, the cross-reference labels defined in the document: labelled
+//!   figures, tables, sections and equations as numbered by pampa's crossref
+//!   transform, and code cells whose `label` has a crossref prefix.
+//! - After `{{<`, the names of Quarto's built-in shortcodes.
+//!
+//! The document is read with `read_recovering`, so labels are still offered
+//! while the text being edited doesn't parse.
+
+use crate::document::Document;
+use crate::types::{CompletionItem, CompletionItemKind, Position, Range};
+use pampa::pandoc::Block;
+use pampa::transforms::crossref::{CrossrefKind, CrossrefOptions, number_crossrefs};
+use pampa::utils::diagnostic_collector::DiagnosticCollector;
+
+/// Quarto's built-in shortcodes, with a description of each.
+const SHORTCODES: &[(&str, &str)] = &[
+    ("contents", "Move content by id"),
+    ("embed", "Embed a notebook cell"),
+    ("env", "Environment variable"),
+    ("include", "Include another file"),
+    ("kbd", "Keyboard shortcut"),
+    ("lipsum", "Placeholder text"),
+    ("meta", "Document metadata value"),
+    ("pagebreak", "Page break"),
+    ("placeholder", "Placeholder image"),
+    ("var", "Value from _variables.yml"),
+    ("version", "Quarto version"),
+    ("video", "Embed a video"),
+];
+
+/// Get the completions at a position.
+///
+/// # Example
+///
+/// ```rust,ignore
+/// use quarto_lsp_core::{Document, Position, get_completions};
+///
+/// let doc = Document::new("test.qmd", "![Plot](plot.png){#fig-plot}\n\nSee @fi");
+/// let items = get_completions(&doc, Position::new(2, 7));
+/// assert_eq!(items[0].label, "fig-plot");
+/// ```
+pub fn get_completions(doc: &Document, position: Position) -> Vec<CompletionItem> {
+    let Some(line) = doc.content().split('\n').nth(position.line as usize) else {
+        return Vec::new();
+    };
+    let line = line.strip_suffix('\r').unwrap_or(line);
+    let before = &line[..byte_offset(line, position.character)];
+
+    if let Some(partial) = shortcode_name_before(before) {
+        let range = typed_range(position, partial);
+        return SHORTCODES
+            .iter()
+            .filter(|(name, _)| name.starts_with(partial))
+            .map(|(name, detail)| {
+                CompletionItem::new(*name, CompletionItemKind::Function, range).with_detail(*detail)
+            })
+            .collect();
+    }
+
+    if let Some(partial) = crossref_label_before(before) {
+        let range = typed_range(position, partial);
+        return crossref_labels(doc)
+            .into_iter()
+            .filter(|(label, _)| label.starts_with(partial))
+            .map(|(label, detail)| {
+                CompletionItem::new(label, CompletionItemKind::Reference, range).with_detail(detail)
+            })
+            .collect();
+    }
+
+    Vec::new()
+}
+
+/// The byte offset of a UTF-16 column in a line, clamped to the line.
+fn byte_offset(line: &str, character: u32) -> usize {
+    let mut units = 0;
+    for (offset, c) in line.char_indices() {
+        if units >= character as usize {
+            return offset;
+        }
+        units += c.len_utf16();
+    }
+    line.len()
+}
+
+/// The range of `partial`, which ends at `position`.
+fn typed_range(position: Position, partial: &str) -> Range {
+    let width = partial.encode_utf16().count() as u32;
+    Range::new(
+        Position::new(position.line, position.character - width),
+        position,
+    )
+}
+
+fn is_label_char(c: char) -> bool {
+    c.is_alphanumeric() || c == '-' || c == '_'
+}
+
+/// What has been typed of a shortcode name, when `before` ends inside one:
+/// `{{< me` gives `me`.
+fn shortcode_name_before(before: &str) -> Option<&str> {
+    let start = before.rfind("{{<")?;
+    let partial = before[start + 3..].trim_start();
+    partial.chars().all(is_label_char).then_some(partial)
+}
+
+/// What has been typed of a crossref label, when `before` ends inside one:
+/// `See @fig-` gives `fig-`. An `@` that follows a letter or digit is part
+/// of an email address, not a reference.
+fn crossref_label_before(before: &str) -> Option<&str> {
+    let start = before
+        .char_indices()
+        .rev()
+        .take_while(|(_, c)| is_label_char(*c))
+        .last()
+        .map_or(before.len(), |(offset, _)| offset);
+    let at = before[..start].strip_suffix('@')?;
+    if at.chars().next_back().is_some_and(|c| c.is_alphanumeric()) {
+        return None;
+    }
+    Some(&before[start..])
+}
+
+/// The crossref labels of a document with a description of each, in
+/// document order.
+fn crossref_labels(doc: &Document) -> Vec<(String, String)> {
+    let (pandoc, _context, _diagnostics) =
+        pampa::readers::qmd::read_recovering(doc.content_bytes(), false, doc.filename(), true);
+    let options = CrossrefOptions::from_metadata(&pandoc.meta);
+
+    let mut labels = Vec::new();
+    collect_cell_labels(&pandoc.blocks, &options, &mut labels);
+    let (_blocks, index) =
+        number_crossrefs(pandoc.blocks, &options, &mut DiagnosticCollector::new());
+    let mut numbered: Vec<(String, String)> = index
+        .iter()
+        .map(|(label, entry)| {
+            let prefix = options.prefixes.get(&entry.kind).map_or("", String::as_str);
+            (label.to_string(), format!("{} {}", prefix, entry.number))
+        })
+        .collect();
+    numbered.extend(
+        labels
+            .into_iter()
+            .filter(|(label, _)| index.get(label).is_none()),
+    );
+    numbered
+}
+
+/// Collect the labels of code cells, which become figures and tables only
+/// when the cell is executed.
+fn collect_cell_labels(
+    blocks: &[Block],
+    options: &CrossrefOptions,
+    labels: &mut Vec<(String, String)>,
+) {
+    for block in blocks {
+        match block {
+            Block::CodeBlock(code_block) => {
+                if let Some(label) = code_block.attr.2.get("label")
+                    && let Some(kind) = CrossrefKind::of_label(label)
+                {
+                    let prefix = options.prefixes.get(&kind).map_or("", String::as_str);
+                    labels.push((label.clone(), format!("{} (cell)", prefix)));
+                }
+            }
+            Block::Div(div) => collect_cell_labels(&div.content, options, labels),
+            Block::BlockQuote(bq) => collect_cell_labels(&bq.content, options, labels),
+            Block::Figure(fig) => collect_cell_labels(&fig.content, options, labels),
+            Block::BulletList(list) => {
+                for item in &list.content {
+                    collect_cell_labels(item, options, labels);
+                }
+            }
+            Block::OrderedList(list) => {
+                for item in &list.content {
+                    collect_cell_labels(item, options, labels);
+                }
+            }
+            Block::Custom(custom) => {
+                for slot in custom.slots.values() {
+                    if let pampa::pandoc::custom::Slot::Blocks(blocks) = slot {
+                        collect_cell_labels(blocks, options, labels);
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn labels(items: &[CompletionItem]) -> Vec<&str> {
+        items.iter().map(|item| item.label.as_str()).collect()
+    }
+
+    #[test]
+    fn completes_crossref_labels() {
+        let doc = Document::new(
+            "test.qmd",
+            "# Intro {#sec-intro}\n\n![A plot](plot.png){#fig-plot}\n\n\
+             | a |\n|---|\n| 1 |\n\n: Data {#tbl-data}\n\nSee @\n",
+        );
+        let items = get_completions(&doc, Position::new(10, 5));
+        assert_eq!(labels(&items), vec!["sec-intro", "fig-plot", "tbl-data"]);
+        assert_eq!(items[1].detail.as_deref(), Some("Figure 1"));
+        assert_eq!(items[1].range, Range::point(Position::new(10, 5)));
+    }
+
+    #[test]
+    fn filters_by_typed_prefix() {
+        let doc = Document::new(
+            "test.qmd",
+            "![A](a.png){#fig-a}\n\n![B](b.png){#fig-b}\n\n# S {#sec-s}\n\n[see @fig-]\n",
+        );
+        let items = get_completions(&doc, Position::new(6, 10));
+        assert_eq!(labels(&items), vec!["fig-a", "fig-b"]);
+        assert_eq!(
+            items[0].range,
+            Range::new(Position::new(6, 6), Position::new(6, 10))
+        );
+    }
+
+    #[test]
+    fn completes_cell_labels() {
+        let doc = Document::new(
+            "test.qmd",
+            "```{python}\n#| label: fig-cell\nplot()\n```\n\nSee @f\n",
+        );
+        let items = get_completions(&doc, Position::new(5, 6));
+        assert_eq!(labels(&items), vec!["fig-cell"]);
+        assert_eq!(items[0].detail.as_deref(), Some("Figure (cell)"));
+    }
+
+    #[test]
+    fn no_completions_in_email_addresses() {
+        let doc = Document::new("test.qmd", "![A](a.png){#fig-a}\n\nMail me@f\n");
+        assert!(get_completions(&doc, Position::new(2, 9)).is_empty());
+    }
+
+    #[test]
+    fn completes_shortcode_names() {
+        let doc = Document::new("test.qmd", "Title: {{< me\n");
+        let items = get_completions(&doc, Position::new(0, 13));
+        assert_eq!(labels(&items), vec!["meta"]);
+        assert_eq!(items[0].kind, CompletionItemKind::Function);
+        assert_eq!(
+            items[0].range,
+            Range::new(Position::new(0, 11), Position::new(0, 13))
+        );
+
+        let items = get_completions(&doc, Position::new(0, 10));
+        assert_eq!(items.len(), SHORTCODES.len());
+    }
+
+    #[test]
+    fn no_completions_after_shortcode_arguments() {
+        let doc = Document::new("test.qmd", "{{< meta ti\n");
+        assert!(get_completions(&doc, Position::new(0, 11)).is_empty());
+    }
+
+    #[test]
+    fn utf16_columns() {
+        let doc = Document::new("test.qmd", "# S {#sec-s}\n\n😀 @s\n");
+        let items = get_completions(&doc, Position::new(2, 5));
+        assert_eq!(labels(&items), vec!["sec-s"]);
+        assert_eq!(items[0].range.start, Position::new(2, 4));
+    }
+}
@@ -22,7 +22,8 @@ pub struct DiagnosticResult {
 /// Get diagnostics for a document.
 ///
 /// This parses the document with `pampa` and converts any parse errors
-/// and warnings into LSP-compatible diagnostics.
+/// and warnings, and the problems of `#|` cell options, into
+/// LSP-compatible diagnostics.
 ///
 /// # Example
 ///
@@ -49,10 +50,14 @@ pub fn get_diagnostics(doc: &Document) -> DiagnosticResult {
     );
 
     let diagnostics = match result {
-        Ok((_pandoc, _ast_context, warnings)) => {
-            // Parsing succeeded, convert warnings to diagnostics
+        Ok((pandoc, ast_context, warnings)) => {
+            // Parsing succeeded, convert warnings and the problems of
+            // `#|` cell options to diagnostics
+            let cell_options =
+                pampa::cell_options::validate_document(&pandoc, &ast_context.source_context);
             warnings
                 .iter()
+                .chain(&cell_options)
                 .filter_map(|msg| convert_diagnostic(msg, &source_context))
                 .collect()
         }
@@ -259,4 +264,28 @@ missing_close: {
                 .collect::<Vec<_>>()
         );
     }
+
+    #[test]
+    fn cell_option_diagnostics_point_at_the_option() {
+        let doc = Document::new(
+            "test.qmd",
+            "# Plot\n\n```{r}\n#| ecoh: false\n#| fig-width: wide\nplot(cars)\n```\n",
+        );
+        let result = get_diagnostics(&doc);
+        let codes: Vec<_> = result
+            .diagnostics
+            .iter()
+            .map(|d| (d.code.as_deref(), d.severity, d.range.start.line))
+            .collect();
+        assert_eq!(
+            codes,
+            vec![
+                (Some("Q-1-18"), DiagnosticSeverity::Warning, 3),
+                (Some("Q-1-11"), DiagnosticSeverity::Error, 4),
+            ]
+        );
+        // `ecoh` starts after `#| `
+        assert_eq!(result.diagnostics[0].range.start.character, 3);
+        assert_eq!(result.diagnostics[0].range.end.character, 7);
+    }
 }
@@ -4,6 +4,7 @@
 //! for language analysis. The design anticipates future workspace-wide features
 //! where documents may come from either the editor (in-memory) or the filesystem.
 
+use crate::types::{Position, Range};
 use quarto_source_map::{SourceContext, SourceInfo};
 
 /// A document for language analysis.
@@ -95,6 +96,48 @@ impl Document {
         (ctx, file_id)
     }
 
+    /// Get the position of a byte offset, with the character in UTF-16 code
+    /// units. Offsets past the end give the end of the document.
+    pub fn position_at(&self, offset: usize) -> Position {
+        let offset = offset.min(self.content.len());
+        let before = &self.content[..offset];
+        let line_start = before.rfind('\n').map_or(0, |i| i + 1);
+        Position::new(
+            before.matches('\n').count() as u32,
+            before[line_start..].encode_utf16().count() as u32,
+        )
+    }
+
+    /// Get the byte offset of a position. Positions past the end of their
+    /// line give the end of the line; lines past the end give the end of the
+    /// document.
+    pub fn offset_at(&self, position: Position) -> usize {
+        let mut line_start = 0;
+        for _ in 0..position.line {
+            match self.content[line_start..].find('\n') {
+                Some(i) => line_start += i + 1,
+                None => return self.content.len(),
+            }
+        }
+        let line = self.content[line_start..]
+            .split('\n')
+            .next()
+            .unwrap_or_default();
+        let mut units = 0;
+        for (offset, c) in line.char_indices() {
+            if units >= position.character as usize {
+                return line_start + offset;
+            }
+            units += c.len_utf16();
+        }
+        line_start + line.len()
+    }
+
+    /// Get the range of a span of bytes.
+    pub fn range_at(&self, start: usize, end: usize) -> Range {
+        Range::new(self.position_at(start), self.position_at(end))
+    }
+
     /// Create a SourceInfo pointing to this document.
     ///
     /// This creates a SourceInfo for the entire document content.
@@ -214,6 +257,22 @@ mod tests {
         assert!(store.contains("file:///b.qmd"));
     }
 
+    #[test]
+    fn positions_and_offsets() {
+        let doc = Document::new("test.qmd", "ab\n😀 c\n");
+        assert_eq!(doc.position_at(0), Position::new(0, 0));
+        assert_eq!(doc.position_at(3), Position::new(1, 0));
+        // The emoji is 4 bytes and 2 UTF-16 code units
+        assert_eq!(doc.position_at(8), Position::new(1, 3));
+        assert_eq!(doc.offset_at(Position::new(1, 3)), 8);
+        assert_eq!(doc.offset_at(Position::new(0, 10)), 2);
+        assert_eq!(doc.offset_at(Position::new(5, 0)), doc.content().len());
+        assert_eq!(
+            doc.range_at(3, 9),
+            Range::new(Position::new(1, 0), Position::new(1, 4))
+        );
+    }
+
     #[test]
     fn source_context_creation() {
         let doc = Document::new("test.qmd", "# Hello\n\nWorld");
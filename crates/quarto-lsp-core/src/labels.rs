@@ -0,0 +1,353 @@
+//! Cross-reference labels, the references to them, and includes.
+//!
+//! This is the per-document half of label navigation: [`document_labels`]
+//! finds where each crossref label is defined (`{#fig-plot}`, or a code
+//! cell's `#| label: fig-plot`), where it is referenced (`@fig-plot`), and
+//! which files the document includes. The
+//! [`WorkspaceIndex`](crate::workspace::WorkspaceIndex) joins these across
+//! documents.
+//!
+//! Only labels with a crossref prefix (`fig-`, `tbl-`, `sec-`, `eq-`) are
+//! collected; other citations are bibliography keys.
+
+use crate::document::Document;
+use crate::types::Range;
+use pampa::filter_context::FilterContext;
+use pampa::filters::{Filter, FilterReturn, topdown_traverse_blocks};
+use pampa::pandoc::{AttrSourceInfo, ShortcodeArg};
+use pampa::transforms::crossref::CrossrefKind;
+use quarto_source_map::{SourceContext, SourceInfo};
+use std::cell::RefCell;
+
+/// A label at a range of a document.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LabelSpan {
+    /// The label, as defined: `@Fig-plot` refers to `fig-plot`.
+    pub label: String,
+    /// The range of the label text, without `#` or `@`.
+    pub range: Range,
+    /// Whether the text at `range` starts with a capital, as in `@Fig-plot`.
+    pub capitalized: bool,
+}
+
+/// An include shortcode.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IncludeSpan {
+    /// The included path, as written.
+    pub path: String,
+    /// The range of the shortcode.
+    pub range: Range,
+}
+
+/// The labels, references and includes of a document, in document order.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct DocumentLabels {
+    pub definitions: Vec<LabelSpan>,
+    pub references: Vec<LabelSpan>,
+    pub includes: Vec<IncludeSpan>,
+}
+
+/// Find the crossref labels, references and includes of a document.
+///
+/// The document is read with `read_recovering`, so the labels of the parts
+/// that parse are found while another part is being edited.
+pub fn document_labels(doc: &Document) -> DocumentLabels {
+    let (pandoc, _context, _diagnostics) =
+        pampa::readers::qmd::read_recovering(doc.content_bytes(), false, doc.filename(), true);
+    let source_context = doc.create_source_context();
+    let collector = RefCell::new(Collector {
+        doc,
+        ctx: &source_context,
+        labels: DocumentLabels::default(),
+    });
+
+    topdown_traverse_blocks(
+        pandoc.blocks,
+        &mut Filter::new()
+            .with_header(|header, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &header.attr.0,
+                    &header.attr_source,
+                    &header.source_info,
+                );
+                FilterReturn::Unchanged(header)
+            })
+            .with_div(|div, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &div.attr.0,
+                    &div.attr_source,
+                    &div.source_info,
+                );
+                FilterReturn::Unchanged(div)
+            })
+            .with_figure(|figure, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &figure.attr.0,
+                    &figure.attr_source,
+                    &figure.source_info,
+                );
+                FilterReturn::Unchanged(figure)
+            })
+            .with_table(|table, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &table.attr.0,
+                    &table.attr_source,
+                    &table.source_info,
+                );
+                FilterReturn::Unchanged(table)
+            })
+            .with_span(|span, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &span.attr.0,
+                    &span.attr_source,
+                    &span.source_info,
+                );
+                FilterReturn::Unchanged(span)
+            })
+            .with_image(|image, _ctx| {
+                collector.borrow_mut().attr_definition(
+                    &image.attr.0,
+                    &image.attr_source,
+                    &image.source_info,
+                );
+                FilterReturn::Unchanged(image)
+            })
+            .with_code_block(|code_block, _ctx| {
+                if let Some(label) = code_block.attr.2.get("label") {
+                    collector
+                        .borrow_mut()
+                        .cell_definition(label, &code_block.source_info);
+                }
+                FilterReturn::Unchanged(code_block)
+            })
+            .with_cite(|cite, _ctx| {
+                let mut collector = collector.borrow_mut();
+                for citation in &cite.citations {
+                    collector.reference(
+                        &citation.id,
+                        citation.id_source.as_ref().unwrap_or(&cite.source_info),
+                    );
+                }
+                FilterReturn::Unchanged(cite)
+            })
+            .with_shortcode(|shortcode, _ctx| {
+                if shortcode.name == "include"
+                    && !shortcode.is_escaped
+                    && let Some(ShortcodeArg::String(path)) = shortcode.positional_args.first()
+                {
+                    collector.borrow_mut().include(path, &shortcode.source_info);
+                }
+                FilterReturn::Unchanged(shortcode)
+            }),
+        &mut FilterContext::new(),
+    );
+
+    let mut labels = collector.into_inner().labels;
+    // A figure and the image it was made from can both carry the id
+    labels.definitions.dedup();
+    labels
+}
+
+/// The label a citation key refers to: `@Fig-plot` starts a sentence but
+/// refers to `fig-plot`.
+pub fn label_of(id: &str) -> String {
+    let mut chars = id.chars();
+    match chars.next() {
+        Some(first) => first.to_lowercase().chain(chars).collect(),
+        None => String::new(),
+    }
+}
+
+/// Whether a label has a crossref prefix and something after it.
+pub fn is_crossref_label(label: &str) -> bool {
+    CrossrefKind::of_label(label).is_some_and(|kind| label.len() > kind.label_prefix().len())
+}
+
+struct Collector<'a> {
+    doc: &'a Document,
+    ctx: &'a SourceContext,
+    labels: DocumentLabels,
+}
+
+impl Collector<'_> {
+    /// The byte span of a source location in this document.
+    fn span(&self, source_info: &SourceInfo) -> Option<(usize, usize)> {
+        let start = source_info.map_offset(0, self.ctx)?.location.offset;
+        Some((start, start + source_info.length()))
+    }
+
+    /// The range of `text` within a source location, searching from the end
+    /// when `last` is set.
+    fn find_in(&self, source_info: &SourceInfo, text: &str, last: bool) -> Option<Range> {
+        let (start, end) = self.span(source_info)?;
+        let slice = self.doc.content().get(start..end)?;
+        let found = if last {
+            slice.rfind(text)
+        } else {
+            slice.find(text)
+        }?;
+        Some(self.doc.range_at(start + found, start + found + text.len()))
+    }
+
+    /// A label given as an `{#id}` attribute. The attribute's own location
+    /// is used when the reader recorded one, or else the `#id` is searched
+    /// for in the element.
+    fn attr_definition(&mut self, id: &str, attr_source: &AttrSourceInfo, node: &SourceInfo) {
+        if !is_crossref_label(id) {
+            return;
+        }
+        let range = attr_source
+            .id
+            .as_ref()
+            .and_then(|source| self.find_in(source, id, true))
+            .or_else(|| {
+                self.find_in(node, &format!("#{}", id), true)
+                    .map(skip_first_char)
+            });
+        if let Some(range) = range {
+            self.labels.definitions.push(LabelSpan {
+                label: id.to_string(),
+                range,
+                capitalized: false,
+            });
+        }
+    }
+
+    /// A label given as a code cell's `label` option.
+    fn cell_definition(&mut self, label: &str, node: &SourceInfo) {
+        if !is_crossref_label(label) {
+            return;
+        }
+        let Some((start, end)) = self.span(node) else {
+            return;
+        };
+        let Some(cell) = self.doc.content().get(start..end) else {
+            return;
+        };
+        let Some(option) = cell.find("label:") else {
+            return;
+        };
+        let Some(found) = cell[option..].find(label) else {
+            return;
+        };
+        let offset = start + option + found;
+        self.labels.definitions.push(LabelSpan {
+            label: label.to_string(),
+            range: self.doc.range_at(offset, offset + label.len()),
+            capitalized: false,
+        });
+    }
+
+    fn reference(&mut self, id: &str, source_info: &SourceInfo) {
+        let label = label_of(id);
+        if !is_crossref_label(&label) {
+            return;
+        }
+        if let Some(range) = self.find_in(source_info, id, false) {
+            self.labels.references.push(LabelSpan {
+                capitalized: label != id,
+                label,
+                range,
+            });
+        }
+    }
+
+    fn include(&mut self, path: &str, source_info: &SourceInfo) {
+        if let Some((start, end)) = self.span(source_info) {
+            self.labels.includes.push(IncludeSpan {
+                path: path.to_string(),
+                range: self.doc.range_at(start, end),
+            });
+        }
+    }
+}
+
+/// A range without its first character, which is one UTF-16 code unit.
+fn skip_first_char(range: Range) -> Range {
+    let mut start = range.start;
+    start.character += 1;
+    Range::new(start, range.end)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::Position;
+
+    fn range(line: u32, start: u32, end: u32) -> Range {
+        Range::new(Position::new(line, start), Position::new(line, end))
+    }
+
+    #[test]
+    fn finds_definitions() {
+        let doc = Document::new(
+            "test.qmd",
+            "# Intro {#sec-intro}\n\n![A plot](plot.png){#fig-plot}\n\n\
+             ::: {#fig-panel}\nText\n:::\n\n# Other {#other}\n",
+        );
+        let labels = document_labels(&doc);
+        let defined: Vec<_> = labels
+            .definitions
+            .iter()
+            .map(|d| (d.label.as_str(), d.range))
+            .collect();
+        assert_eq!(
+            defined,
+            vec![
+                ("sec-intro", range(0, 10, 19)),
+                ("fig-plot", range(2, 21, 29)),
+                ("fig-panel", range(4, 6, 15)),
+            ]
+        );
+    }
+
+    #[test]
+    fn finds_cell_labels() {
+        let doc = Document::new("test.qmd", "```{python}\n#| label: fig-cell\nplot()\n```\n");
+        let labels = document_labels(&doc);
+        assert_eq!(labels.definitions.len(), 1);
+        assert_eq!(labels.definitions[0].label, "fig-cell");
+        assert_eq!(labels.definitions[0].range, range(1, 10, 18));
+    }
+
+    #[test]
+    fn finds_references() {
+        let doc = Document::new(
+            "test.qmd",
+            "See @fig-plot and [-@tbl-data]. @Sec-intro starts here. Cite @knuth.\n",
+        );
+        let labels = document_labels(&doc);
+        let referenced: Vec<_> = labels
+            .references
+            .iter()
+            .map(|r| (r.label.as_str(), r.range))
+            .collect();
+        assert_eq!(
+            referenced,
+            vec![
+                ("fig-plot", range(0, 5, 13)),
+                ("tbl-data", range(0, 21, 29)),
+                ("sec-intro", range(0, 33, 42)),
+            ]
+        );
+        assert!(!labels.references[0].capitalized);
+        assert!(labels.references[2].capitalized);
+    }
+
+    #[test]
+    fn finds_includes() {
+        let doc = Document::new("test.qmd", "Intro.\n\n{{< include _part.qmd >}}\n");
+        let labels = document_labels(&doc);
+        assert_eq!(labels.includes.len(), 1);
+        assert_eq!(labels.includes[0].path, "_part.qmd");
+        assert_eq!(labels.includes[0].range.start, Position::new(2, 0));
+    }
+
+    #[test]
+    fn crossref_labels_need_a_name() {
+        assert!(is_crossref_label("fig-a"));
+        assert!(!is_crossref_label("fig-"));
+        assert!(!is_crossref_label("knuth"));
+        assert_eq!(label_of("Fig-a"), "fig-a");
+    }
+}
@@ -22,7 +22,7 @@
 //! # Usage
 //!
 //! ```rust,ignore
-//! use quarto_lsp_core::{Document, analyze_document};
+//! use quarto_lsp_core::{Document, Position, analyze_document};
 //!
 //! // Create a document from content
 //! let doc = Document::new("example.qmd", content);
@@ -37,20 +37,35 @@
 //! let symbols = get_symbols(&doc);
 //! let diagnostics = get_diagnostics(&doc);
 //! let folding_ranges = get_folding_ranges(&doc);
+//!
+//! // Completions of crossref labels and shortcode names at a position:
+//! let completions = get_completions(&doc, Position::new(3, 7));
+//!
+//! // Navigation and rename of crossref labels across documents:
+//! let mut index = WorkspaceIndex::new();
+//! index.update(&doc);
+//! let definition = index.definition(doc.uri(), Position::new(3, 7));
 //! ```
 
 pub mod analysis;
+pub mod completion;
 pub mod diagnostics;
 pub mod document;
+pub mod labels;
 pub mod symbols;
 pub mod types;
+pub mod workspace;
 
 // Re-export main types and functions for convenience
 pub use analysis::analyze_document;
+pub use completion::get_completions;
 pub use diagnostics::get_diagnostics;
 pub use document::Document;
+pub use labels::{DocumentLabels, document_labels};
 pub use symbols::{get_folding_ranges, get_symbols};
 pub use types::{
-    Diagnostic, DiagnosticSeverity, DocumentAnalysis, DocumentAnalysisJson, FoldingRange,
-    FoldingRangeKind, Position, Range, Symbol, SymbolKind,
+    CompletionItem, CompletionItemKind, Diagnostic, DiagnosticSeverity, DocumentAnalysis,
+    DocumentAnalysisJson, FoldingRange, FoldingRangeKind, Location, Position, Range, Symbol,
+    SymbolKind, TextEdit,
 };
+pub use workspace::WorkspaceIndex;
@@ -405,6 +405,90 @@ impl FoldingRange {
     }
 }
 
+/// The kind of a completion item, matching the LSP CompletionItemKind
+/// values used.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum CompletionItemKind {
+    /// A cross-reference label.
+    Reference,
+    /// A shortcode name.
+    Function,
+}
+
+/// A completion offered at a position.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CompletionItem {
+    /// The text inserted, and shown in the list.
+    pub label: String,
+    /// The kind of completion.
+    pub kind: CompletionItemKind,
+    /// A short description shown next to the label (e.g., "Figure 2").
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub detail: Option<String>,
+    /// The text replaced by the label: what has been typed of it so far.
+    pub range: Range,
+}
+
+impl CompletionItem {
+    /// Create a new completion item.
+    pub fn new(label: impl Into<String>, kind: CompletionItemKind, range: Range) -> Self {
+        Self {
+            label: label.into(),
+            kind,
+            detail: None,
+            range,
+        }
+    }
+
+    /// Add a detail to this completion item.
+    pub fn with_detail(mut self, detail: impl Into<String>) -> Self {
+        self.detail = Some(detail.into());
+        self
+    }
+}
+
+/// A range in a particular document.
+#[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Location {
+    /// The document's URI.
+    pub uri: String,
+    /// The range within the document.
+    pub range: Range,
+}
+
+impl Location {
+    /// Create a new location.
+    pub fn new(uri: impl Into<String>, range: Range) -> Self {
+        Self {
+            uri: uri.into(),
+            range,
+        }
+    }
+}
+
+/// A replacement of the text in a range.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct TextEdit {
+    /// The range replaced.
+    pub range: Range,
+    /// The text that replaces it.
+    pub new_text: String,
+}
+
+impl TextEdit {
+    /// Create a new text edit.
+    pub fn new(range: Range, new_text: impl Into<String>) -> Self {
+        Self {
+            range,
+            new_text: new_text.into(),
+        }
+    }
+}
+
 // ============================================================================
 // Rich Diagnostic Types (matching quarto-error-reporting::DiagnosticMessage)
 // ============================================================================
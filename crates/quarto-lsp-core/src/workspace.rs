@@ -0,0 +1,462 @@
+//! A cross-document index of crossref labels, for navigation and rename.
+//!
+//! The [`WorkspaceIndex`] keeps the [`DocumentLabels`] of every document it
+//! is given, keyed by URI, and answers the questions an editor asks about a
+//! position: where is the label under the cursor defined, where is it
+//! referenced, and what are the edits that rename it.
+//!
+//! The index does no I/O. Documents are added by the caller: the open
+//! documents from the editor, and other files of the project from disk.
+//! [`WorkspaceIndex::missing_includes`] names the included files that have
+//! not been added, so the caller can load them.
+//!
+//! Lookups of a definition prefer the documents joined to the current one
+//! by includes, since an included partial's labels belong to the file that
+//! includes it. References and rename cover the whole workspace, as the
+//! chapters of a book reference each other without including each other.
+
+use crate::document::Document;
+use crate::labels::{DocumentLabels, IncludeSpan, LabelSpan, document_labels, is_crossref_label};
+use crate::types::{Location, Position, Range, TextEdit};
+use pampa::transforms::crossref::CrossrefKind;
+use std::collections::{BTreeMap, BTreeSet};
+
+/// The labels of the documents of a workspace.
+#[derive(Debug, Default)]
+pub struct WorkspaceIndex {
+    /// URI of the directory that `/`-prefixed include paths resolve
+    /// against, ending in `/`.
+    root: Option<String>,
+    documents: BTreeMap<String, DocumentLabels>,
+}
+
+impl WorkspaceIndex {
+    /// Create an empty index.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create an empty index for the workspace at a directory URI.
+    pub fn with_root(root: impl Into<String>) -> Self {
+        let mut root = root.into();
+        if !root.ends_with('/') {
+            root.push('/');
+        }
+        Self {
+            root: Some(root),
+            documents: BTreeMap::new(),
+        }
+    }
+
+    /// Add a document, or replace its labels with those of new content.
+    pub fn update(&mut self, doc: &Document) {
+        self.documents
+            .insert(doc.uri().to_string(), document_labels(doc));
+    }
+
+    /// Remove a document.
+    pub fn remove(&mut self, uri: &str) {
+        self.documents.remove(uri);
+    }
+
+    /// Check if a document is in the index.
+    pub fn contains(&self, uri: &str) -> bool {
+        self.documents.contains_key(uri)
+    }
+
+    /// Get the labels of a document.
+    pub fn labels(&self, uri: &str) -> Option<&DocumentLabels> {
+        self.documents.get(uri)
+    }
+
+    /// Get the number of documents in the index.
+    pub fn len(&self) -> usize {
+        self.documents.len()
+    }
+
+    /// Check if the index is empty.
+    pub fn is_empty(&self) -> bool {
+        self.documents.is_empty()
+    }
+
+    /// The URI of the file an include path names. Relative paths resolve
+    /// against the directory of the including document, and paths starting
+    /// with `/` against the workspace root.
+    pub fn resolve_include(&self, uri: &str, path: &str) -> String {
+        match (path.strip_prefix('/'), &self.root) {
+            (Some(rooted), Some(root)) => join_uri(root, rooted),
+            _ => join_uri(uri, path),
+        }
+    }
+
+    /// The URIs of the files a document includes.
+    pub fn includes_of(&self, uri: &str) -> Vec<String> {
+        self.documents.get(uri).map_or_else(Vec::new, |labels| {
+            labels
+                .includes
+                .iter()
+                .map(|include| self.resolve_include(uri, &include.path))
+                .collect()
+        })
+    }
+
+    /// The included files that are not in the index, in URI order.
+    pub fn missing_includes(&self) -> Vec<String> {
+        let missing: BTreeSet<String> = self
+            .documents
+            .keys()
+            .flat_map(|uri| self.includes_of(uri))
+            .filter(|included| !self.documents.contains_key(included))
+            .collect();
+        missing.into_iter().collect()
+    }
+
+    /// Get the location a position goes to: the definition of the label
+    /// under it, or the start of the file an include under it names.
+    pub fn definition(&self, uri: &str, position: Position) -> Option<Location> {
+        if let Some(include) = self.include_at(uri, position) {
+            return Some(Location::new(
+                self.resolve_include(uri, &include.path),
+                Range::default(),
+            ));
+        }
+        let label = &self.label_at(uri, position)?.label;
+        let related = self.related(uri);
+        related
+            .iter()
+            .map(String::as_str)
+            .chain(self.documents.keys().map(String::as_str))
+            .find_map(|candidate| {
+                self.documents[candidate]
+                    .definitions
+                    .iter()
+                    .find(|definition| &definition.label == label)
+                    .map(|definition| Location::new(candidate, definition.range))
+            })
+    }
+
+    /// Get the references to the label under a position in every document,
+    /// with the definitions when `include_declaration` is set.
+    pub fn references(
+        &self,
+        uri: &str,
+        position: Position,
+        include_declaration: bool,
+    ) -> Vec<Location> {
+        let Some(label) = self.label_at(uri, position).map(|span| span.label.clone()) else {
+            return Vec::new();
+        };
+        self.occurrences(&label, include_declaration)
+            .into_iter()
+            .map(|(uri, span)| Location::new(uri, span.range))
+            .collect()
+    }
+
+    /// Get the range and text of the label under a position, if it can be
+    /// renamed.
+    pub fn prepare_rename(&self, uri: &str, position: Position) -> Option<(Range, String)> {
+        self.label_at(uri, position)
+            .map(|span| (span.range, span.label.clone()))
+    }
+
+    /// Get the edits, per document in URI order, that rename the label under
+    /// a position. References written with a capital (`@Fig-plot`) keep it.
+    ///
+    /// A label keeps its crossref kind, so `fig-a` can be renamed to
+    /// `fig-b` but not to `tbl-a`, and can't be renamed to a label that is
+    /// already defined.
+    pub fn rename(
+        &self,
+        uri: &str,
+        position: Position,
+        new_name: &str,
+    ) -> Result<Vec<(String, Vec<TextEdit>)>, String> {
+        let Some(label) = self.label_at(uri, position).map(|span| span.label.clone()) else {
+            return Err("No crossref label at this position".to_string());
+        };
+        let new_name = new_name.strip_prefix(['@', '#']).unwrap_or(new_name);
+        if !is_crossref_label(new_name)
+            || !new_name
+                .chars()
+                .all(|c| c.is_alphanumeric() || c == '-' || c == '_')
+        {
+            return Err(format!("`{}` is not a crossref label", new_name));
+        }
+        if CrossrefKind::of_label(new_name) != CrossrefKind::of_label(&label) {
+            return Err(format!(
+                "`{}` would change the kind of `{}`; keep the `{}` prefix",
+                new_name,
+                label,
+                CrossrefKind::of_label(&label).map_or("", CrossrefKind::label_prefix)
+            ));
+        }
+        if new_name != label && !self.occurrences(new_name, true).is_empty() {
+            return Err(format!("`{}` is already used", new_name));
+        }
+
+        let mut edits: BTreeMap<String, Vec<TextEdit>> = BTreeMap::new();
+        for (uri, span) in self.occurrences(&label, true) {
+            let text = if span.capitalized {
+                capitalize(new_name)
+            } else {
+                new_name.to_string()
+            };
+            edits
+                .entry(uri.to_string())
+                .or_default()
+                .push(TextEdit::new(span.range, text));
+        }
+        for document_edits in edits.values_mut() {
+            document_edits.sort_by_key(|edit| edit.range.start);
+        }
+        Ok(edits.into_iter().collect())
+    }
+
+    /// The definition or reference whose range holds a position, or ends
+    /// at it (where the cursor is after typing a label).
+    fn label_at(&self, uri: &str, position: Position) -> Option<&LabelSpan> {
+        let labels = self.documents.get(uri)?;
+        labels
+            .definitions
+            .iter()
+            .chain(&labels.references)
+            .find(|span| span.range.start <= position && position <= span.range.end)
+    }
+
+    fn include_at(&self, uri: &str, position: Position) -> Option<&IncludeSpan> {
+        self.documents
+            .get(uri)?
+            .includes
+            .iter()
+            .find(|include| include.range.contains(position))
+    }
+
+    /// The occurrences of a label in every document, in URI and then
+    /// document order.
+    fn occurrences(&self, label: &str, include_definitions: bool) -> Vec<(&str, &LabelSpan)> {
+        let mut found = Vec::new();
+        for (uri, labels) in &self.documents {
+            let definitions = labels.definitions.iter().filter(|_| include_definitions);
+            let mut spans: Vec<&LabelSpan> = definitions
+                .chain(&labels.references)
+                .filter(|span| span.label == label)
+                .collect();
+            spans.sort_by_key(|span| span.range.start);
+            found.extend(spans.into_iter().map(|span| (uri.as_str(), span)));
+        }
+        found
+    }
+
+    /// The documents joined to `uri` through includes in either direction,
+    /// nearest first, starting with `uri` itself.
+    fn related(&self, uri: &str) -> Vec<String> {
+        let mut seen = vec![uri.to_string()];
+        let mut next = 0;
+        while next < seen.len() {
+            let current = seen[next].clone();
+            next += 1;
+            let includers = self
+                .documents
+                .keys()
+                .filter(|other| self.includes_of(other).contains(&current))
+                .cloned();
+            for neighbour in self.includes_of(&current).into_iter().chain(includers) {
+                if !seen.contains(&neighbour) && self.documents.contains_key(&neighbour) {
+                    seen.push(neighbour);
+                }
+            }
+        }
+        seen.retain(|uri| self.documents.contains_key(uri));
+        seen
+    }
+}
+
+fn capitalize(label: &str) -> String {
+    let mut chars = label.chars();
+    match chars.next() {
+        Some(first) => first.to_uppercase().chain(chars).collect(),
+        None => String::new(),
+    }
+}
+
+/// Join a relative path to the directory of a URI, resolving `.` and `..`
+/// and percent-encoding what a URI can't hold.
+fn join_uri(base: &str, path: &str) -> String {
+    // The path of the URI starts at the first `/` after the authority
+    let path_start = base
+        .find("://")
+        .and_then(|scheme_end| base[scheme_end + 3..].find('/').map(|i| scheme_end + 3 + i))
+        .unwrap_or(0);
+    let (prefix, base_path) = base.split_at(path_start);
+    let mut segments: Vec<String> = base_path.split('/').map(str::to_string).collect();
+    // Drop the file name, or the empty segment after a trailing `/`
+    segments.pop();
+    for segment in path.split(['/', '\\']) {
+        match segment {
+            "" | "." => {}
+            ".." => {
+                if segments.len() > 1 {
+                    segments.pop();
+                }
+            }
+            segment => segments.push(encode_segment(segment)),
+        }
+    }
+    format!("{}{}", prefix, segments.join("/"))
+}
+
+fn encode_segment(segment: &str) -> String {
+    let mut encoded = String::new();
+    for byte in segment.bytes() {
+        if byte.is_ascii_alphanumeric() || b"-._~!$&'()*+,;=:@%".contains(&byte) {
+            encoded.push(byte as char);
+        } else {
+            encoded.push_str(&format!("%{:02X}", byte));
+        }
+    }
+    encoded
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MAIN: &str = "file:///book/index.qmd";
+    const PART: &str = "file:///book/_part.qmd";
+    const CHAPTER: &str = "file:///book/chapters/two.qmd";
+
+    fn index() -> WorkspaceIndex {
+        let mut index = WorkspaceIndex::with_root("file:///book");
+        index.update(&Document::new(
+            MAIN,
+            "# Intro {#sec-intro}\n\nSee @fig-plot.\n\n{{< include _part.qmd >}}\n",
+        ));
+        index.update(&Document::new(
+            PART,
+            "![A plot](plot.png){#fig-plot}\n\nAs in @sec-intro.\n",
+        ));
+        index.update(&Document::new(
+            CHAPTER,
+            "# Two {#sec-two}\n\n@Fig-plot shows it, as does @fig-plot.\n",
+        ));
+        index
+    }
+
+    fn range(line: u32, start: u32, end: u32) -> Range {
+        Range::new(Position::new(line, start), Position::new(line, end))
+    }
+
+    #[test]
+    fn resolves_include_paths() {
+        let index = WorkspaceIndex::with_root("file:///book/");
+        assert_eq!(
+            index.resolve_include(CHAPTER, "_a.qmd"),
+            "file:///book/chapters/_a.qmd"
+        );
+        assert_eq!(
+            index.resolve_include(CHAPTER, "../_a.qmd"),
+            "file:///book/_a.qmd"
+        );
+        assert_eq!(
+            index.resolve_include(CHAPTER, "/parts/a.qmd"),
+            "file:///book/parts/a.qmd"
+        );
+        assert_eq!(
+            index.resolve_include(MAIN, "./my part.qmd"),
+            "file:///book/my%20part.qmd"
+        );
+    }
+
+    #[test]
+    fn definition_across_an_include() {
+        let index = index();
+        assert_eq!(
+            index.definition(MAIN, Position::new(2, 7)),
+            Some(Location::new(PART, range(0, 21, 29)))
+        );
+        assert_eq!(
+            index.definition(PART, Position::new(2, 8)),
+            Some(Location::new(MAIN, range(0, 10, 19)))
+        );
+    }
+
+    #[test]
+    fn definition_of_an_include() {
+        let index = index();
+        assert_eq!(
+            index.definition(MAIN, Position::new(4, 5)),
+            Some(Location::new(PART, Range::default()))
+        );
+    }
+
+    #[test]
+    fn no_definition_away_from_labels() {
+        let index = index();
+        assert_eq!(index.definition(MAIN, Position::new(2, 1)), None);
+    }
+
+    #[test]
+    fn references_in_every_document() {
+        let index = index();
+        let references = index.references(PART, Position::new(0, 22), false);
+        assert_eq!(
+            references,
+            vec![
+                Location::new(CHAPTER, range(2, 1, 9)),
+                Location::new(CHAPTER, range(2, 29, 37)),
+                Location::new(MAIN, range(2, 5, 13)),
+            ]
+        );
+        let with_declaration = index.references(PART, Position::new(0, 22), true);
+        assert_eq!(with_declaration.len(), 4);
+        assert!(with_declaration.contains(&Location::new(PART, range(0, 21, 29))));
+    }
+
+    #[test]
+    fn renames_a_label_everywhere() {
+        let index = index();
+        let edits = index
+            .rename(MAIN, Position::new(2, 8), "fig-chart")
+            .unwrap();
+        let uris: Vec<&str> = edits.iter().map(|(uri, _)| uri.as_str()).collect();
+        assert_eq!(uris, vec![PART, CHAPTER, MAIN]);
+        assert_eq!(
+            edits[0].1,
+            vec![TextEdit::new(range(0, 21, 29), "fig-chart")]
+        );
+        let chapter: Vec<&str> = edits[1].1.iter().map(|e| e.new_text.as_str()).collect();
+        assert_eq!(chapter, vec!["Fig-chart", "fig-chart"]);
+    }
+
+    #[test]
+    fn rename_keeps_the_kind() {
+        let index = index();
+        assert!(index.rename(MAIN, Position::new(2, 8), "tbl-plot").is_err());
+        assert!(
+            index
+                .rename(MAIN, Position::new(2, 8), "fig chart")
+                .is_err()
+        );
+        assert!(index.rename(MAIN, Position::new(0, 12), "sec-two").is_err());
+        assert!(index.rename(MAIN, Position::new(2, 1), "fig-x").is_err());
+    }
+
+    #[test]
+    fn prepares_a_rename() {
+        let index = index();
+        assert_eq!(
+            index.prepare_rename(MAIN, Position::new(0, 12)),
+            Some((range(0, 10, 19), "sec-intro".to_string()))
+        );
+        assert_eq!(index.prepare_rename(MAIN, Position::new(0, 2)), None);
+    }
+
+    #[test]
+    fn reports_missing_includes() {
+        let mut index = WorkspaceIndex::new();
+        index.update(&Document::new(MAIN, "{{< include _part.qmd >}}\n"));
+        assert_eq!(index.missing_includes(), vec![PART.to_string()]);
+        index.update(&Document::new(PART, "Part.\n"));
+        assert!(index.missing_includes().is_empty());
+    }
+}
@@ -1,8 +1,8 @@
 //! LSP capability negotiation.
 
 use tower_lsp::lsp_types::{
-    OneOf, ServerCapabilities, TextDocumentSyncCapability, TextDocumentSyncKind,
-    TextDocumentSyncOptions,
+    CompletionOptions, FoldingRangeProviderCapability, OneOf, RenameOptions, ServerCapabilities,
+    TextDocumentSyncCapability, TextDocumentSyncKind, TextDocumentSyncOptions,
 };
 
 /// Get the server capabilities to report to the client.
@@ -27,11 +27,25 @@ pub fn server_capabilities() -> ServerCapabilities {
         // Document symbols (outline)
         document_symbol_provider: Some(OneOf::Left(true)),
 
+        // Folding for front matter, sections and code cells
+        folding_range_provider: Some(FoldingRangeProviderCapability::Simple(true)),
+
+        // Crossref labels after `@`, shortcode names after `{{<`
+        completion_provider: Some(CompletionOptions {
+            trigger_characters: Some(vec!["@".to_string(), "<".to_string()]),
+            ..Default::default()
+        }),
+
+        // Crossref labels and includes, across the workspace
+        definition_provider: Some(OneOf::Left(true)),
+        references_provider: Some(OneOf::Left(true)),
+        rename_provider: Some(OneOf::Right(RenameOptions {
+            prepare_provider: Some(true),
+            work_done_progress_options: Default::default(),
+        })),
+
         // Features to be added in future phases:
         // hover_provider: Some(HoverProviderCapability::Simple(true)),
-        // completion_provider: Some(CompletionOptions { ... }),
-        // definition_provider: Some(OneOf::Left(true)),
-        // references_provider: Some(OneOf::Left(true)),
         // document_formatting_provider: Some(OneOf::Left(true)),
         ..Default::default()
     }
@@ -52,4 +66,31 @@ mod tests {
         let caps = server_capabilities();
         assert!(caps.document_symbol_provider.is_some());
     }
+
+    #[test]
+    fn capabilities_include_folding_and_completion() {
+        let caps = server_capabilities();
+        assert!(caps.folding_range_provider.is_some());
+        let completion = caps.completion_provider.expect("completion provider");
+        assert!(
+            completion
+                .trigger_characters
+                .unwrap()
+                .contains(&"@".to_string())
+        );
+    }
+
+    #[test]
+    fn capabilities_include_navigation_and_rename() {
+        let caps = server_capabilities();
+        assert_eq!(caps.definition_provider, Some(OneOf::Left(true)));
+        assert_eq!(caps.references_provider, Some(OneOf::Left(true)));
+        assert!(matches!(
+            caps.rename_provider,
+            Some(OneOf::Right(RenameOptions {
+                prepare_provider: Some(true),
+                ..
+            }))
+        ));
+    }
 }
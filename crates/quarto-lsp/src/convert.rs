@@ -1,12 +1,17 @@
 //! Conversion between quarto-lsp-core types and tower_lsp::lsp_types.
 
 use tower_lsp::lsp_types::{
-    Diagnostic as LspDiagnostic, DiagnosticSeverity as LspSeverity,
-    DocumentSymbol as LspDocumentSymbol, NumberOrString, Position as LspPosition,
-    Range as LspRange, SymbolKind as LspSymbolKind,
+    CompletionItem as LspCompletionItem, CompletionItemKind as LspCompletionItemKind,
+    CompletionTextEdit, Diagnostic as LspDiagnostic, DiagnosticSeverity as LspSeverity,
+    DocumentSymbol as LspDocumentSymbol, FoldingRange as LspFoldingRange,
+    FoldingRangeKind as LspFoldingRangeKind, Location as LspLocation, NumberOrString,
+    Position as LspPosition, Range as LspRange, SymbolKind as LspSymbolKind, TextEdit, Url,
 };
 
-use quarto_lsp_core::types::{Diagnostic, DiagnosticSeverity, Position, Range, Symbol, SymbolKind};
+use quarto_lsp_core::types::{
+    CompletionItem, CompletionItemKind, Diagnostic, DiagnosticSeverity, FoldingRange,
+    FoldingRangeKind, Location, Position, Range, Symbol, SymbolKind, TextEdit as CoreTextEdit,
+};
 
 /// Convert a quarto-lsp-core Position to an lsp-types Position.
 pub fn position_to_lsp(pos: &Position) -> LspPosition {
@@ -16,6 +21,11 @@ pub fn position_to_lsp(pos: &Position) -> LspPosition {
     }
 }
 
+/// Convert an lsp-types Position to a quarto-lsp-core Position.
+pub fn position_from_lsp(pos: &LspPosition) -> Position {
+    Position::new(pos.line, pos.character)
+}
+
 /// Convert a quarto-lsp-core Range to an lsp-types Range.
 pub fn range_to_lsp(range: &Range) -> LspRange {
     LspRange {
@@ -131,6 +141,59 @@ pub fn symbol_to_lsp(symbol: &Symbol) -> LspDocumentSymbol {
     }
 }
 
+/// Convert a quarto-lsp-core FoldingRange to an lsp-types FoldingRange.
+pub fn folding_range_to_lsp(range: &FoldingRange) -> LspFoldingRange {
+    LspFoldingRange {
+        start_line: range.start_line,
+        start_character: None,
+        end_line: range.end_line,
+        end_character: None,
+        kind: range.kind.map(|kind| match kind {
+            FoldingRangeKind::Comment => LspFoldingRangeKind::Comment,
+            FoldingRangeKind::Imports => LspFoldingRangeKind::Imports,
+            FoldingRangeKind::Region => LspFoldingRangeKind::Region,
+        }),
+        collapsed_text: None,
+    }
+}
+
+/// Convert a quarto-lsp-core CompletionItem to an lsp-types CompletionItem.
+///
+/// The item replaces what has been typed of it, so that clients don't
+/// have to guess the word boundaries of labels like `fig-plot`.
+pub fn completion_item_to_lsp(item: &CompletionItem) -> LspCompletionItem {
+    LspCompletionItem {
+        label: item.label.clone(),
+        kind: Some(match item.kind {
+            CompletionItemKind::Reference => LspCompletionItemKind::REFERENCE,
+            CompletionItemKind::Function => LspCompletionItemKind::FUNCTION,
+        }),
+        detail: item.detail.clone(),
+        text_edit: Some(CompletionTextEdit::Edit(TextEdit {
+            range: range_to_lsp(&item.range),
+            new_text: item.label.clone(),
+        })),
+        ..Default::default()
+    }
+}
+
+/// Convert a quarto-lsp-core Location to an lsp-types Location, or `None`
+/// when its URI doesn't parse.
+pub fn location_to_lsp(location: &Location) -> Option<LspLocation> {
+    Some(LspLocation {
+        uri: Url::parse(&location.uri).ok()?,
+        range: range_to_lsp(&location.range),
+    })
+}
+
+/// Convert a quarto-lsp-core TextEdit to an lsp-types TextEdit.
+pub fn text_edit_to_lsp(edit: &CoreTextEdit) -> TextEdit {
+    TextEdit {
+        range: range_to_lsp(&edit.range),
+        new_text: edit.new_text.clone(),
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -224,4 +287,50 @@ mod tests {
         assert!(lsp_diag.message.contains("Hints:"));
         assert!(lsp_diag.message.contains("Did you mean 'format'?"));
     }
+
+    #[test]
+    fn test_completion_item_conversion() {
+        let range = Range::new(Position::new(2, 5), Position::new(2, 8));
+        let item = CompletionItem::new("fig-plot", CompletionItemKind::Reference, range)
+            .with_detail("Figure 1");
+
+        let lsp_item = completion_item_to_lsp(&item);
+        assert_eq!(lsp_item.label, "fig-plot");
+        assert_eq!(lsp_item.kind, Some(LspCompletionItemKind::REFERENCE));
+        assert_eq!(lsp_item.detail.as_deref(), Some("Figure 1"));
+        assert_eq!(
+            lsp_item.text_edit,
+            Some(CompletionTextEdit::Edit(TextEdit {
+                range: range_to_lsp(&range),
+                new_text: "fig-plot".to_string(),
+            }))
+        );
+    }
+
+    #[test]
+    fn test_folding_range_conversion() {
+        let range = FoldingRange::with_kind(1, 4, FoldingRangeKind::Region);
+        let lsp_range = folding_range_to_lsp(&range);
+        assert_eq!(lsp_range.start_line, 1);
+        assert_eq!(lsp_range.end_line, 4);
+        assert_eq!(lsp_range.kind, Some(LspFoldingRangeKind::Region));
+    }
+
+    #[test]
+    fn test_location_conversion() {
+        let range = Range::new(Position::new(1, 2), Position::new(1, 6));
+        let location = location_to_lsp(&Location::new("file:///book/a.qmd", range)).unwrap();
+        assert_eq!(location.uri.as_str(), "file:///book/a.qmd");
+        assert_eq!(location.range, range_to_lsp(&range));
+
+        assert!(location_to_lsp(&Location::new("not a uri", range)).is_none());
+    }
+
+    #[test]
+    fn test_text_edit_conversion() {
+        let range = Range::new(Position::new(0, 5), Position::new(0, 13));
+        let edit = text_edit_to_lsp(&CoreTextEdit::new(range, "fig-new"));
+        assert_eq!(edit.range, range_to_lsp(&range));
+        assert_eq!(edit.new_text, "fig-new");
+    }
 }
@@ -1,12 +1,15 @@
 //! LSP server implementation using tower-lsp.
 
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
 use std::sync::Arc;
 use tokio::sync::RwLock;
 use tower_lsp::jsonrpc::Result;
 use tower_lsp::lsp_types::*;
 use tower_lsp::{Client, LanguageServer, LspService, Server};
 
-use quarto_lsp_core::document::DocumentStore;
+use quarto_lsp_core::document::{Document, DocumentStore};
+use quarto_lsp_core::workspace::WorkspaceIndex;
 
 use crate::capabilities::server_capabilities;
 use crate::convert;
@@ -17,6 +20,10 @@ pub struct QuartoLanguageServer {
     client: Client,
     /// Document store for managing open documents.
     documents: Arc<RwLock<DocumentStore>>,
+    /// Crossref labels of the open documents and the project's files.
+    index: Arc<RwLock<WorkspaceIndex>>,
+    /// The workspace directory, from `initialize`.
+    root: Arc<RwLock<Option<PathBuf>>>,
 }
 
 impl QuartoLanguageServer {
@@ -25,6 +32,19 @@ impl QuartoLanguageServer {
         Self {
             client,
             documents: Arc::new(RwLock::new(DocumentStore::new())),
+            index: Arc::new(RwLock::new(WorkspaceIndex::new())),
+            root: Arc::new(RwLock::new(None)),
+        }
+    }
+
+    /// Index an open document, and the files it includes that aren't
+    /// indexed yet.
+    async fn index_document(&self, uri: &Url) {
+        let documents = self.documents.read().await;
+        if let Some(doc) = documents.get(uri.as_str()) {
+            let mut index = self.index.write().await;
+            index.update(doc);
+            index_missing_includes(&mut index);
         }
     }
 
@@ -54,7 +74,20 @@ impl QuartoLanguageServer {
 
 #[tower_lsp::async_trait]
 impl LanguageServer for QuartoLanguageServer {
-    async fn initialize(&self, _params: InitializeParams) -> Result<InitializeResult> {
+    async fn initialize(&self, params: InitializeParams) -> Result<InitializeResult> {
+        #[allow(deprecated)]
+        let root_uri = params
+            .workspace_folders
+            .and_then(|folders| folders.into_iter().next())
+            .map(|folder| folder.uri)
+            .or(params.root_uri);
+        if let Some(root_uri) = root_uri
+            && let Ok(root) = root_uri.to_file_path()
+        {
+            *self.index.write().await = WorkspaceIndex::with_root(root_uri.as_str());
+            *self.root.write().await = Some(root);
+        }
+
         Ok(InitializeResult {
             capabilities: server_capabilities(),
             server_info: Some(ServerInfo {
@@ -65,6 +98,15 @@ impl LanguageServer for QuartoLanguageServer {
     }
 
     async fn initialized(&self, _params: InitializedParams) {
+        let root = self.root.read().await.clone();
+        if let Some(root) = root {
+            let mut index = self.index.write().await;
+            for path in workspace_files(&root) {
+                index_file(&mut index, &path);
+            }
+            index_missing_includes(&mut index);
+        }
+
         self.client
             .log_message(MessageType::INFO, "Quarto LSP server initialized")
             .await;
@@ -83,6 +125,7 @@ impl LanguageServer for QuartoLanguageServer {
             let mut documents = self.documents.write().await;
             documents.open(uri.as_str(), text, version);
         }
+        self.index_document(&uri).await;
 
         // Publish diagnostics for the opened document
         self.publish_diagnostics(uri).await;
@@ -98,6 +141,7 @@ impl LanguageServer for QuartoLanguageServer {
                 let mut documents = self.documents.write().await;
                 documents.change(uri.as_str(), change.text, version);
             }
+            self.index_document(&uri).await;
 
             // Publish diagnostics for the changed document
             self.publish_diagnostics(uri).await;
@@ -112,6 +156,17 @@ impl LanguageServer for QuartoLanguageServer {
             documents.close(uri.as_str());
         }
 
+        // The file on disk may differ from the closed buffer, or be gone
+        {
+            let mut index = self.index.write().await;
+            match uri.to_file_path() {
+                Ok(path) if path.is_file() => {
+                    index_file(&mut index, &path);
+                }
+                _ => index.remove(uri.as_str()),
+            }
+        }
+
         // Clear diagnostics for closed document
         self.client.publish_diagnostics(uri, Vec::new(), None).await;
     }
@@ -132,6 +187,180 @@ impl LanguageServer for QuartoLanguageServer {
             Ok(None)
         }
     }
+
+    async fn folding_range(&self, params: FoldingRangeParams) -> Result<Option<Vec<FoldingRange>>> {
+        let uri = params.text_document.uri;
+        let documents = self.documents.read().await;
+
+        if let Some(doc) = documents.get(uri.as_str()) {
+            let ranges = quarto_lsp_core::get_folding_ranges(doc);
+            Ok(Some(
+                ranges.iter().map(convert::folding_range_to_lsp).collect(),
+            ))
+        } else {
+            Ok(None)
+        }
+    }
+
+    async fn completion(&self, params: CompletionParams) -> Result<Option<CompletionResponse>> {
+        let position = params.text_document_position;
+        let documents = self.documents.read().await;
+
+        if let Some(doc) = documents.get(position.text_document.uri.as_str()) {
+            let items = quarto_lsp_core::get_completions(
+                doc,
+                convert::position_from_lsp(&position.position),
+            );
+            Ok(Some(CompletionResponse::Array(
+                items.iter().map(convert::completion_item_to_lsp).collect(),
+            )))
+        } else {
+            Ok(None)
+        }
+    }
+
+    async fn goto_definition(
+        &self,
+        params: GotoDefinitionParams,
+    ) -> Result<Option<GotoDefinitionResponse>> {
+        let position = params.text_document_position_params;
+        let index = self.index.read().await;
+
+        Ok(index
+            .definition(
+                position.text_document.uri.as_str(),
+                convert::position_from_lsp(&position.position),
+            )
+            .as_ref()
+            .and_then(convert::location_to_lsp)
+            .map(GotoDefinitionResponse::Scalar))
+    }
+
+    async fn references(&self, params: ReferenceParams) -> Result<Option<Vec<Location>>> {
+        let position = params.text_document_position;
+        let index = self.index.read().await;
+
+        let locations = index.references(
+            position.text_document.uri.as_str(),
+            convert::position_from_lsp(&position.position),
+            params.context.include_declaration,
+        );
+        Ok(Some(
+            locations
+                .iter()
+                .filter_map(convert::location_to_lsp)
+                .collect(),
+        ))
+    }
+
+    async fn prepare_rename(
+        &self,
+        params: TextDocumentPositionParams,
+    ) -> Result<Option<PrepareRenameResponse>> {
+        let index = self.index.read().await;
+
+        Ok(index
+            .prepare_rename(
+                params.text_document.uri.as_str(),
+                convert::position_from_lsp(&params.position),
+            )
+            .map(
+                |(range, label)| PrepareRenameResponse::RangeWithPlaceholder {
+                    range: convert::range_to_lsp(&range),
+                    placeholder: label,
+                },
+            ))
+    }
+
+    async fn rename(&self, params: RenameParams) -> Result<Option<WorkspaceEdit>> {
+        let position = params.text_document_position;
+        let index = self.index.read().await;
+
+        let edits = index
+            .rename(
+                position.text_document.uri.as_str(),
+                convert::position_from_lsp(&position.position),
+                &params.new_name,
+            )
+            .map_err(tower_lsp::jsonrpc::Error::invalid_params)?;
+        let changes: HashMap<Url, Vec<TextEdit>> = edits
+            .iter()
+            .filter_map(|(uri, edits)| {
+                Some((
+                    Url::parse(uri).ok()?,
+                    edits.iter().map(convert::text_edit_to_lsp).collect(),
+                ))
+            })
+            .collect();
+        Ok(Some(WorkspaceEdit {
+            changes: Some(changes),
+            ..Default::default()
+        }))
+    }
+}
+
+/// Directories of a workspace that hold no source documents.
+const SKIPPED_DIRECTORIES: &[&str] = &["_site", "_book", "_freeze", "node_modules"];
+
+/// The `.qmd` files below a directory, skipping hidden directories and
+/// rendered output.
+fn workspace_files(dir: &Path) -> Vec<PathBuf> {
+    let mut files = Vec::new();
+    let Ok(entries) = std::fs::read_dir(dir) else {
+        return files;
+    };
+    let mut entries: Vec<PathBuf> = entries.flatten().map(|entry| entry.path()).collect();
+    entries.sort();
+    for path in entries {
+        let name = path
+            .file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+            .unwrap_or_default();
+        if name.starts_with('.') {
+            continue;
+        }
+        if path.is_dir() {
+            if !SKIPPED_DIRECTORIES.contains(&name.as_str()) {
+                files.extend(workspace_files(&path));
+            }
+        } else if path.extension().is_some_and(|ext| ext == "qmd") {
+            files.push(path);
+        }
+    }
+    files
+}
+
+/// Index a file from disk. Returns false when it can't be read.
+fn index_file(index: &mut WorkspaceIndex, path: &Path) -> bool {
+    let (Ok(uri), Ok(content)) = (Url::from_file_path(path), std::fs::read_to_string(path)) else {
+        return false;
+    };
+    index.update(&Document::new(uri.as_str(), content));
+    true
+}
+
+/// Index the included files that aren't indexed yet, and the files they
+/// include in turn.
+fn index_missing_includes(index: &mut WorkspaceIndex) {
+    let mut unreadable = Vec::new();
+    loop {
+        let missing: Vec<String> = index
+            .missing_includes()
+            .into_iter()
+            .filter(|uri| !unreadable.contains(uri))
+            .collect();
+        if missing.is_empty() {
+            return;
+        }
+        for uri in missing {
+            let path = Url::parse(&uri)
+                .ok()
+                .and_then(|url| url.to_file_path().ok());
+            if !path.is_some_and(|path| index_file(index, &path)) {
+                unreadable.push(uri);
+            }
+        }
+    }
 }
 
 /// Run the LSP server over stdio.
@@ -175,10 +175,15 @@ impl LspTestHarness {
 
     /// Initialize the LSP server.
     fn initialize(&mut self) -> serde_json::Value {
+        self.initialize_with_root(None)
+    }
+
+    /// Initialize the LSP server with a workspace directory.
+    fn initialize_with_root(&mut self, root_uri: Option<&str>) -> serde_json::Value {
         let params = serde_json::json!({
             "processId": std::process::id(),
             "capabilities": {},
-            "rootUri": null
+            "rootUri": root_uri
         });
         let response = self.request("initialize", params);
 
@@ -259,6 +264,93 @@ impl LspTestHarness {
         });
         self.request("textDocument/documentSymbol", params)
     }
+
+    /// Request folding ranges.
+    fn folding_ranges(&mut self, uri: &str) -> serde_json::Value {
+        let params = serde_json::json!({
+            "textDocument": {
+                "uri": uri
+            }
+        });
+        self.request("textDocument/foldingRange", params)
+    }
+
+    /// Request completions at a position.
+    fn completion(&mut self, uri: &str, line: u32, character: u32) -> serde_json::Value {
+        let params = serde_json::json!({
+            "textDocument": {
+                "uri": uri
+            },
+            "position": {
+                "line": line,
+                "character": character
+            }
+        });
+        self.request("textDocument/completion", params)
+    }
+
+    /// Request a method that takes a document and a position.
+    fn position_request(
+        &mut self,
+        method: &str,
+        uri: &str,
+        line: u32,
+        character: u32,
+        extra: serde_json::Value,
+    ) -> serde_json::Value {
+        let mut params = serde_json::json!({
+            "textDocument": {
+                "uri": uri
+            },
+            "position": {
+                "line": line,
+                "character": character
+            }
+        });
+        if let (Some(params), Some(extra)) = (params.as_object_mut(), extra.as_object()) {
+            params.extend(extra.clone());
+        }
+        self.request(method, params)
+    }
+
+    /// Request the definition at a position.
+    fn definition(&mut self, uri: &str, line: u32, character: u32) -> serde_json::Value {
+        self.position_request(
+            "textDocument/definition",
+            uri,
+            line,
+            character,
+            serde_json::json!({}),
+        )
+    }
+
+    /// Request the references at a position, with their declaration.
+    fn references(&mut self, uri: &str, line: u32, character: u32) -> serde_json::Value {
+        self.position_request(
+            "textDocument/references",
+            uri,
+            line,
+            character,
+            serde_json::json!({ "context": { "includeDeclaration": true } }),
+        )
+    }
+
+    /// Request a rename of the symbol at a position.
+    fn rename(
+        &mut self,
+        uri: &str,
+        line: u32,
+        character: u32,
+        new_name: &str,
+    ) -> serde_json::Value {
+        self.position_request(
+            "textDocument/rename",
+            uri,
+            line,
+            character,
+            serde_json::json!({ "newName": new_name }),
+        )
+    }
 }
 
 impl Drop for LspTestHarness {
@@ -548,3 +640,182 @@ plot(x, y)
         "Expected at least one symbol for the header"
     );
 }
+
+// =============================================================================
+// Folding Range Tests
+// =============================================================================
+
+#[test]
+fn test_folding_ranges() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+
+    let uri = "file:///test/folding.qmd";
+    let content = r#"---
+title: "Test"
+---
+
+```{python}
+print("hello")
+```
+"#;
+
+    harness.open_document(uri, content, 1);
+    let _ = harness.wait_for_diagnostics(uri, Duration::from_secs(5));
+
+    let response = harness.folding_ranges(uri);
+    let ranges = response["result"]
+        .as_array()
+        .expect("Expected array of folding ranges");
+
+    // Front matter folds from line 0 to line 2
+    assert!(
+        ranges
+            .iter()
+            .any(|r| r["startLine"] == 0 && r["endLine"] == 2),
+        "Expected a front matter folding range, got: {:?}",
+        ranges
+    );
+}
+
+// =============================================================================
+// Completion Tests
+// =============================================================================
+
+#[test]
+fn test_completion_of_crossref_labels() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+
+    let uri = "file:///test/crossref.qmd";
+    let content = "![A plot](plot.png){#fig-plot}\n\n# Results {#sec-results}\n\nSee @fig\n";
+
+    harness.open_document(uri, content, 1);
+    let _ = harness.wait_for_diagnostics(uri, Duration::from_secs(5));
+
+    let response = harness.completion(uri, 4, 8);
+    let items = response["result"]
+        .as_array()
+        .expect("Expected array of completion items");
+
+    let labels: Vec<&str> = items.iter().filter_map(|i| i["label"].as_str()).collect();
+    assert_eq!(labels, vec!["fig-plot"]);
+    assert_eq!(items[0]["detail"], "Figure 1");
+    assert_eq!(items[0]["textEdit"]["range"]["start"]["character"], 5);
+}
+
+#[test]
+fn test_completion_of_shortcode_names() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+
+    let uri = "file:///test/shortcode.qmd";
+    let content = "Written by {{< me\n";
+
+    harness.open_document(uri, content, 1);
+    let _ = harness.wait_for_diagnostics(uri, Duration::from_secs(5));
+
+    let response = harness.completion(uri, 0, 17);
+    let items = response["result"]
+        .as_array()
+        .expect("Expected array of completion items");
+
+    let labels: Vec<&str> = items.iter().filter_map(|i| i["label"].as_str()).collect();
+    assert_eq!(labels, vec!["meta"]);
+}
+
+// =============================================================================
+// Navigation and Rename Tests
+// =============================================================================
+
+const BOOK_INDEX: &str = "file:///book/index.qmd";
+const BOOK_PART: &str = "file:///book/_part.qmd";
+
+fn open_book(harness: &mut LspTestHarness) {
+    harness.open_document(
+        BOOK_INDEX,
+        "# Intro {#sec-intro}\n\nSee @fig-plot.\n\n{{< include _part.qmd >}}\n",
+        1,
+    );
+    let _ = harness.wait_for_diagnostics(BOOK_INDEX, Duration::from_secs(5));
+    harness.open_document(
+        BOOK_PART,
+        "![A plot](plot.png){#fig-plot}\n\nAs in @sec-intro.\n",
+        1,
+    );
+    let _ = harness.wait_for_diagnostics(BOOK_PART, Duration::from_secs(5));
+}
+
+#[test]
+fn test_definition_across_documents() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+    open_book(&mut harness);
+
+    let response = harness.definition(BOOK_INDEX, 2, 7);
+    assert_eq!(response["result"]["uri"], BOOK_PART);
+    assert_eq!(response["result"]["range"]["start"]["line"], 0);
+    assert_eq!(response["result"]["range"]["start"]["character"], 21);
+
+    let response = harness.definition(BOOK_INDEX, 4, 5);
+    assert_eq!(response["result"]["uri"], BOOK_PART);
+
+    let response = harness.definition(BOOK_INDEX, 2, 1);
+    assert!(response["result"].is_null());
+}
+
+#[test]
+fn test_references_across_documents() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+    open_book(&mut harness);
+
+    let response = harness.references(BOOK_PART, 2, 8);
+    let locations = response["result"]
+        .as_array()
+        .expect("Expected array of locations");
+    let uris: Vec<&str> = locations.iter().filter_map(|l| l["uri"].as_str()).collect();
+    assert_eq!(uris, vec![BOOK_PART, BOOK_INDEX]);
+}
+
+#[test]
+fn test_rename_across_documents() {
+    let mut harness = LspTestHarness::new();
+    harness.initialize();
+    open_book(&mut harness);
+
+    let response = harness.rename(BOOK_INDEX, 2, 7, "fig-chart");
+    let changes = &response["result"]["changes"];
+    assert_eq!(changes[BOOK_INDEX][0]["newText"], "fig-chart");
+    assert_eq!(changes[BOOK_PART][0]["newText"], "fig-chart");
+    assert_eq!(changes[BOOK_PART][0]["range"]["start"]["character"], 21);
+
+    let response = harness.rename(BOOK_INDEX, 2, 7, "tbl-chart");
+    assert!(response["error"].is_object());
+}
+
+#[test]
+fn test_definition_in_unopened_workspace_file() {
+    let root = std::env::temp_dir().join(format!("quarto-lsp-test-{}", std::process::id()));
+    std::fs::create_dir_all(root.join("chapters")).unwrap();
+    std::fs::write(
+        root.join("chapters").join("results.qmd"),
+        "![Results](r.png){#fig-results}\n",
+    )
+    .unwrap();
+    let root_uri = format!("file://{}", root.display());
+
+    let mut harness = LspTestHarness::new();
+    harness.initialize_with_root(Some(&root_uri));
+
+    let uri = format!("{}/index.qmd", root_uri);
+    harness.open_document(&uri, "As @fig-results shows.\n", 1);
+    let _ = harness.wait_for_diagnostics(&uri, Duration::from_secs(5));
+
+    let response = harness.definition(&uri, 0, 6);
+    let _ = std::fs::remove_dir_all(&root);
+    assert_eq!(
+        response["result"]["uri"],
+        format!("{}/chapters/results.qmd", root_uri)
+    );
+}
@@ -0,0 +1,140 @@
+/*
+ * annotation.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Editor annotations: structured HTML comments.
+ */
+
+//! Editor annotations, written as HTML comments with a `#name:` tag:
+//!
+//! ```markdown
+//! The effect is large <!-- #cmt: check against table 2 --> in both groups.
+//! ```
+//!
+//! Annotations are notes for the people editing a document (review comments,
+//! to-dos, ...), so they are never rendered. The qmd reader reads them into
+//! an inline [`CustomNode`] of type [`ANNOTATION_TYPE_NAME`] instead of a raw
+//! HTML comment, which lets editors find and change them, and the qmd writer
+//! writes them back in the same syntax. Other HTML comments stay
+//! `RawInline`s.
+
+use crate::attr::empty_attr;
+use crate::custom::CustomNode;
+use serde_json::json;
+
+/// The `type_name` of annotation custom nodes
+pub const ANNOTATION_TYPE_NAME: &str = "Annotation";
+
+/// An editor annotation.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Annotation {
+    /// The tag of the annotation, e.g. `cmt` in `<!-- #cmt: text -->`
+    pub name: String,
+    /// The text after the colon, without surrounding whitespace
+    pub text: String,
+}
+
+impl Annotation {
+    pub fn new(name: impl Into<String>, text: impl Into<String>) -> Self {
+        Self {
+            name: name.into(),
+            text: text.into(),
+        }
+    }
+
+    /// Read an annotation from an HTML comment (`<!-- #name: text -->`).
+    ///
+    /// The name is a letter followed by letters, digits, `-` and `_`. The
+    /// colon and text may be left out: `<!-- #todo -->`. Returns `None` for
+    /// any other comment.
+    pub fn parse_comment(comment: &str) -> Option<Annotation> {
+        let body = comment
+            .trim()
+            .strip_prefix("<!--")?
+            .strip_suffix("-->")?
+            .trim()
+            .strip_prefix('#')?;
+        let name_len = body
+            .find(|c: char| !(c.is_ascii_alphanumeric() || c == '-' || c == '_'))
+            .unwrap_or(body.len());
+        let (name, rest) = body.split_at(name_len);
+        if !name.starts_with(|c: char| c.is_ascii_alphabetic()) {
+            return None;
+        }
+        let text = match rest.strip_prefix(':') {
+            Some(text) => text.trim(),
+            None if rest.is_empty() => "",
+            None => return None,
+        };
+        Some(Annotation::new(name, text))
+    }
+
+    /// The annotation as an HTML comment. A `-->` in the text is written as
+    /// `-- >` so it doesn't end the comment.
+    pub fn to_comment(&self) -> String {
+        let text = self.text.replace("-->", "-- >");
+        if text.is_empty() {
+            format!("<!-- #{}: -->", self.name)
+        } else {
+            format!("<!-- #{}: {} -->", self.name, text)
+        }
+    }
+
+    /// The annotation of a custom node, if it is one.
+    pub fn from_custom(node: &CustomNode) -> Option<Annotation> {
+        if node.type_name != ANNOTATION_TYPE_NAME {
+            return None;
+        }
+        let field = |key: &str| node.plain_data.get(key).and_then(|value| value.as_str());
+        Some(Annotation::new(field("name")?, field("text").unwrap_or("")))
+    }
+
+    /// The annotation as a custom node.
+    pub fn to_custom(&self, source_info: quarto_source_map::SourceInfo) -> CustomNode {
+        CustomNode::new(ANNOTATION_TYPE_NAME, empty_attr(), source_info)
+            .with_data(json!({"name": self.name, "text": self.text}))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_comment() {
+        assert_eq!(
+            Annotation::parse_comment("<!-- #cmt: check this -->"),
+            Some(Annotation::new("cmt", "check this"))
+        );
+        assert_eq!(
+            Annotation::parse_comment(" <!--#todo-->"),
+            Some(Annotation::new("todo", ""))
+        );
+        assert_eq!(
+            Annotation::parse_comment("<!-- #cmt:\n  two\n  lines\n-->"),
+            Some(Annotation::new("cmt", "two\n  lines"))
+        );
+        assert_eq!(Annotation::parse_comment("<!-- a comment -->"), None);
+        assert_eq!(Annotation::parse_comment("<!-- #1: no -->"), None);
+        assert_eq!(Annotation::parse_comment("<!-- #cmt text -->"), None);
+    }
+
+    #[test]
+    fn test_comment_round_trip() {
+        let annotation = Annotation::new("cmt", "a --> b");
+        assert_eq!(annotation.to_comment(), "<!-- #cmt: a -- > b -->");
+        let annotation = Annotation::new("cmt", "fine");
+        assert_eq!(
+            Annotation::parse_comment(&annotation.to_comment()),
+            Some(annotation)
+        );
+    }
+
+    #[test]
+    fn test_custom_node_round_trip() {
+        let annotation = Annotation::new("todo", "cite this");
+        let node = annotation.to_custom(quarto_source_map::SourceInfo::default());
+        assert_eq!(node.type_name, ANNOTATION_TYPE_NAME);
+        assert_eq!(Annotation::from_custom(&node), Some(annotation));
+    }
+}
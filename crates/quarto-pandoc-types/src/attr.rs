@@ -91,10 +91,14 @@ impl AttrSourceInfo {
 /// This struct tracks source locations for each component:
 /// - url: Source location of the URL string (None if url is empty "")
 /// - title: Source location of the title string (None if title is empty "")
+/// - reference_label: The label of a reference-style link or image
+///   (`[text][label]`), so writers can restore the reference
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub struct TargetSourceInfo {
     pub url: Option<SourceInfo>,
     pub title: Option<SourceInfo>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub reference_label: Option<String>,
 }
 
 impl TargetSourceInfo {
@@ -103,6 +107,7 @@ impl TargetSourceInfo {
         TargetSourceInfo {
             url: None,
             title: None,
+            reference_label: None,
         }
     }
 }
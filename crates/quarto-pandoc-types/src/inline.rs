@@ -175,6 +175,10 @@ pub struct Image {
 
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub struct Note {
+    /// The identifier of a note written as a `[^id]` reference, so writers
+    /// can keep it. `None` for inline notes (`^[...]`).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub id: Option<String>,
     pub content: Blocks,
     pub source_info: quarto_source_map::SourceInfo,
 }
@@ -1311,6 +1315,7 @@ mod tests {
         use crate::block::Plain;
 
         let note = Note {
+            id: None,
             content: vec![crate::Block::Plain(Plain {
                 content: vec![make_str("note content")],
                 source_info: dummy_source_info(),
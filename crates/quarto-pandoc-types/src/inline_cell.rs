@@ -0,0 +1,170 @@
+/*
+ * inline_cell.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Inline code cells: code spans that an engine evaluates.
+ */
+
+//! Inline code cells, written as code spans that start with a language in
+//! braces:
+//!
+//! ```markdown
+//! The mean is `{python} round(df.x.mean(), 2)`.
+//! ```
+//!
+//! The qmd reader reads them into an inline [`CustomNode`] of type
+//! [`INLINE_CELL_TYPE_NAME`] instead of a [`Code`] inline, keeping the
+//! language and the code. An engine that runs the document evaluates them
+//! and the result goes into the node's `output` slot; the code stays, so
+//! the qmd writer writes the cell back as it was read. Until a cell is
+//! evaluated, other writers write it as the code span it was.
+
+use crate::attr::{Attr, AttrSourceInfo};
+use crate::custom::{CustomNode, Slot};
+use crate::inline::{Code, Inline, Inlines};
+use serde_json::json;
+
+/// The `type_name` of inline cell custom nodes
+pub const INLINE_CELL_TYPE_NAME: &str = "InlineCell";
+
+/// An inline code cell.
+#[derive(Debug, Clone, PartialEq)]
+pub struct InlineCell {
+    /// The language, e.g. `python` in `` `{python} x` ``
+    pub language: String,
+    /// The code after the language, without surrounding whitespace
+    pub code: String,
+    /// The result of evaluating the code, once an engine has run it
+    pub output: Option<Inlines>,
+}
+
+impl InlineCell {
+    pub fn new(language: impl Into<String>, code: impl Into<String>) -> Self {
+        Self {
+            language: language.into(),
+            code: code.into(),
+            output: None,
+        }
+    }
+
+    /// Read a cell from the text of a code span (`{python} 1 + 1`).
+    ///
+    /// The language is a letter followed by letters, digits, `-` and `_`,
+    /// and must be followed by whitespace and some code. Returns `None` for
+    /// any other code span.
+    pub fn parse_code(text: &str) -> Option<InlineCell> {
+        let rest = text.trim().strip_prefix('{')?;
+        let (language, code) = rest.split_once('}')?;
+        let language = language.trim();
+        let valid = language.starts_with(|c: char| c.is_ascii_alphabetic())
+            && language
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_');
+        if !valid || !code.starts_with(char::is_whitespace) || code.trim().is_empty() {
+            return None;
+        }
+        Some(InlineCell::new(language, code.trim()))
+    }
+
+    /// The text of the code span the cell is written as
+    pub fn to_code(&self) -> String {
+        format!("{{{}}} {}", self.language, self.code)
+    }
+
+    /// The cell of a custom node, if it is one.
+    pub fn from_custom(node: &CustomNode) -> Option<InlineCell> {
+        if node.type_name != INLINE_CELL_TYPE_NAME {
+            return None;
+        }
+        let field = |key: &str| node.plain_data.get(key).and_then(|value| value.as_str());
+        let output = match node.get_slot("output") {
+            Some(Slot::Inlines(inlines)) => Some(inlines.clone()),
+            Some(Slot::Inline(inline)) => Some(vec![inline.as_ref().clone()]),
+            _ => None,
+        };
+        Some(InlineCell {
+            language: field("language")?.to_string(),
+            code: field("code").unwrap_or("").to_string(),
+            output,
+        })
+    }
+
+    /// The cell as a custom node. `attr` are the attributes of the code
+    /// span.
+    pub fn to_custom(&self, attr: Attr, source_info: quarto_source_map::SourceInfo) -> CustomNode {
+        let node = CustomNode::new(INLINE_CELL_TYPE_NAME, attr, source_info)
+            .with_data(json!({"language": self.language, "code": self.code}));
+        match &self.output {
+            Some(output) => node.with_slot("output", Slot::Inlines(output.clone())),
+            None => node,
+        }
+    }
+
+    /// The code span of a cell's custom node, as the cell was written
+    pub fn source_inline(&self, node: &CustomNode) -> Inline {
+        Inline::Code(Code {
+            attr: node.attr.clone(),
+            text: self.to_code(),
+            source_info: node.source_info.clone(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    /// What a document shows for a cell's custom node: the output once the
+    /// cell is evaluated, the code span before
+    pub fn rendered(&self, node: &CustomNode) -> Inlines {
+        match &self.output {
+            Some(output) => output.clone(),
+            None => vec![self.source_inline(node)],
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::attr::empty_attr;
+    use crate::inline::Str;
+    use quarto_source_map::SourceInfo;
+
+    #[test]
+    fn test_parse_code() {
+        assert_eq!(
+            InlineCell::parse_code("{python} 1 + 1"),
+            Some(InlineCell::new("python", "1 + 1"))
+        );
+        assert_eq!(
+            InlineCell::parse_code("{r}   nrow(df) "),
+            Some(InlineCell::new("r", "nrow(df)"))
+        );
+        assert_eq!(InlineCell::parse_code("{python}"), None);
+        assert_eq!(InlineCell::parse_code("{python}x"), None);
+        assert_eq!(InlineCell::parse_code("{=html} <b>"), None);
+        assert_eq!(InlineCell::parse_code("{.class} x"), None);
+        assert_eq!(InlineCell::parse_code("dict = {a} b"), None);
+    }
+
+    #[test]
+    fn test_code_round_trip() {
+        let cell = InlineCell::new("python", "f(x)");
+        assert_eq!(cell.to_code(), "{python} f(x)");
+        assert_eq!(InlineCell::parse_code(&cell.to_code()), Some(cell));
+    }
+
+    #[test]
+    fn test_custom_node_round_trip() {
+        let mut cell = InlineCell::new("r", "x");
+        let node = cell.to_custom(empty_attr(), SourceInfo::default());
+        assert_eq!(node.type_name, INLINE_CELL_TYPE_NAME);
+        assert_eq!(InlineCell::from_custom(&node), Some(cell.clone()));
+        assert!(matches!(cell.rendered(&node)[..], [Inline::Code(_)]));
+
+        cell.output = Some(vec![Inline::Str(Str {
+            text: "42".to_string(),
+            source_info: SourceInfo::default(),
+        })]);
+        let node = cell.to_custom(empty_attr(), SourceInfo::default());
+        assert_eq!(InlineCell::from_custom(&node), Some(cell.clone()));
+        assert!(matches!(&cell.rendered(&node)[..], [Inline::Str(s)] if s.text == "42"));
+    }
+}
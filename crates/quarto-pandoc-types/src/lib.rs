@@ -10,19 +10,23 @@
  * by any crate that needs to work with Pandoc AST structures.
  */
 
+pub mod annotation;
 pub mod attr;
 pub mod block;
 pub mod caption;
 pub mod config_value;
 pub mod custom;
 pub mod inline;
+pub mod inline_cell;
 pub mod list;
 pub mod meta;
 pub mod pandoc;
 pub mod shortcode;
 pub mod table;
+pub mod walk;
 
 // Re-export commonly used types at the crate root
+pub use annotation::{ANNOTATION_TYPE_NAME, Annotation};
 pub use attr::{Attr, AttrSourceInfo, TargetSourceInfo, empty_attr, is_empty_attr};
 pub use block::{
     Block, BlockQuote, Blocks, BulletList, CaptionBlock, CodeBlock, DefinitionList, Div, Figure,
@@ -37,6 +41,7 @@ pub use inline::{
     Quoted, RawInline, SmallCaps, SoftBreak, Space, Span, Str, Strikeout, Strong, Subscript,
     Superscript, Target, Underline, is_empty_target, make_cite_inline, make_span_inline,
 };
+pub use inline_cell::{INLINE_CELL_TYPE_NAME, InlineCell};
 pub use list::{ListAttributes, ListNumberDelim, ListNumberStyle};
 pub use meta::{Meta, MetaValue};
 pub use pandoc::Pandoc;
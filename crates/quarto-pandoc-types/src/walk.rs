@@ -0,0 +1,839 @@
+/*
+ * walk.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Walking the blocks and inlines of a document, like pandoc-types'
+ * Walkable.
+ */
+
+//! Walking the blocks and inlines of a document, like pandoc-types'
+//! `Walkable`.
+//!
+//! A [`Visitor`] (or [`VisitorMut`], to change the document) says what to
+//! do with the elements it cares about and [`Walkable::walk`] finds them,
+//! wherever they are: in list items, table cells, captions, notes, the
+//! slots of custom nodes and metadata. Each visit returns a [`Control`]:
+//! carry on, skip the element's contents, or stop the walk.
+//!
+//! ```
+//! use quarto_pandoc_types::walk::{Control, Order, walk_inlines_mut};
+//! use quarto_pandoc_types::{Blocks, Inline};
+//!
+//! fn shout(blocks: &mut Blocks) {
+//!     let _ = walk_inlines_mut(blocks, Order::TopDown, |inline| {
+//!         match inline {
+//!             Inline::Str(s) => s.text = s.text.to_uppercase(),
+//!             // Leave the text of links alone
+//!             Inline::Link(_) => return Control::Skip,
+//!             _ => {}
+//!         }
+//!         Control::Continue
+//!     });
+//! }
+//! ```
+//!
+//! Lists of blocks and inlines are visited too, before their elements when
+//! walking top-down and after them when walking bottom-up. A visitor that
+//! needs to replace one element with several, or remove one, does it there.
+
+use crate::block::{
+    Block, BlockQuote, CaptionBlock, Div, Header, NoteDefinitionFencedBlock, NoteDefinitionPara,
+    Paragraph, Plain,
+};
+use crate::caption::Caption;
+use crate::config_value::{ConfigValue, ConfigValueKind};
+use crate::custom::{CustomNode, Slot};
+use crate::inline::{
+    Delete, EditComment, Emph, Highlight, Image, Inline, Insert, Link, Quoted, SmallCaps, Span,
+    Strikeout, Strong, Subscript, Superscript, Underline,
+};
+use crate::pandoc::Pandoc;
+use crate::table::{Row, Table};
+use std::ops::ControlFlow;
+
+/// When an element is visited, relative to what it contains
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Order {
+    /// Before its contents, so that a visitor can skip them
+    #[default]
+    TopDown,
+    /// After its contents, so that a visitor sees them already visited
+    BottomUp,
+}
+
+/// What the walk does after a visit
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Control {
+    #[default]
+    Continue,
+    /// Don't walk the element's contents. Bottom-up, they have been walked
+    /// already, and this is the same as `Continue`.
+    Skip,
+    /// End the walk
+    Stop,
+}
+
+/// Looks at the elements of a document. Every method does nothing by
+/// default.
+pub trait Visitor {
+    fn visit_block(&mut self, _block: &Block) -> Control {
+        Control::Continue
+    }
+
+    fn visit_inline(&mut self, _inline: &Inline) -> Control {
+        Control::Continue
+    }
+
+    /// A list of blocks: the content of a document, a div, a list item...
+    fn visit_block_list(&mut self, _blocks: &[Block]) -> Control {
+        Control::Continue
+    }
+
+    /// A list of inlines: the content of a paragraph, a link...
+    fn visit_inline_list(&mut self, _inlines: &[Inline]) -> Control {
+        Control::Continue
+    }
+}
+
+/// Changes the elements of a document. Every method does nothing by
+/// default.
+pub trait VisitorMut {
+    fn visit_block_mut(&mut self, _block: &mut Block) -> Control {
+        Control::Continue
+    }
+
+    fn visit_inline_mut(&mut self, _inline: &mut Inline) -> Control {
+        Control::Continue
+    }
+
+    /// A list of blocks, where blocks can be added, removed or replaced
+    fn visit_block_list_mut(&mut self, _blocks: &mut Vec<Block>) -> Control {
+        Control::Continue
+    }
+
+    /// A list of inlines, where inlines can be added, removed or replaced
+    fn visit_inline_list_mut(&mut self, _inlines: &mut Vec<Inline>) -> Control {
+        Control::Continue
+    }
+}
+
+/// Something with blocks or inlines in it
+pub trait Walkable {
+    /// Visit this and everything in it, in document order. Breaks when the
+    /// visitor stops the walk.
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()>;
+
+    /// As [`walk`](Walkable::walk), for a visitor that changes what it
+    /// visits. Top-down, the contents walked are those the visitor left.
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()>;
+}
+
+/// Visit a node and walk its contents, in `order`
+fn step<V: ?Sized, N: Copy>(
+    order: Order,
+    visitor: &mut V,
+    node: N,
+    visit: impl FnOnce(&mut V, N) -> Control,
+    contents: impl FnOnce(&mut V, N) -> ControlFlow<()>,
+) -> ControlFlow<()> {
+    match order {
+        Order::TopDown => match visit(visitor, node) {
+            Control::Continue => contents(visitor, node),
+            Control::Skip => ControlFlow::Continue(()),
+            Control::Stop => ControlFlow::Break(()),
+        },
+        Order::BottomUp => {
+            contents(visitor, node)?;
+            match visit(visitor, node) {
+                Control::Stop => ControlFlow::Break(()),
+                _ => ControlFlow::Continue(()),
+            }
+        }
+    }
+}
+
+/// [`step`] for a node that is changed
+fn step_mut<V: ?Sized, N: ?Sized>(
+    order: Order,
+    visitor: &mut V,
+    node: &mut N,
+    visit: impl FnOnce(&mut V, &mut N) -> Control,
+    contents: impl FnOnce(&mut V, &mut N) -> ControlFlow<()>,
+) -> ControlFlow<()> {
+    match order {
+        Order::TopDown => match visit(visitor, node) {
+            Control::Continue => contents(visitor, node),
+            Control::Skip => ControlFlow::Continue(()),
+            Control::Stop => ControlFlow::Break(()),
+        },
+        Order::BottomUp => {
+            contents(visitor, node)?;
+            match visit(visitor, node) {
+                Control::Stop => ControlFlow::Break(()),
+                _ => ControlFlow::Continue(()),
+            }
+        }
+    }
+}
+
+impl Walkable for Vec<Block> {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        step(
+            order,
+            visitor,
+            self,
+            |visitor, blocks| visitor.visit_block_list(blocks),
+            |visitor, blocks| {
+                blocks
+                    .iter()
+                    .try_for_each(|block| block.walk(order, visitor))
+            },
+        )
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        step_mut(
+            order,
+            visitor,
+            self,
+            |visitor, blocks| visitor.visit_block_list_mut(blocks),
+            |visitor, blocks| {
+                blocks
+                    .iter_mut()
+                    .try_for_each(|block| block.walk_mut(order, visitor))
+            },
+        )
+    }
+}
+
+impl Walkable for Vec<Inline> {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        step(
+            order,
+            visitor,
+            self,
+            |visitor, inlines| visitor.visit_inline_list(inlines),
+            |visitor, inlines| {
+                inlines
+                    .iter()
+                    .try_for_each(|inline| inline.walk(order, visitor))
+            },
+        )
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        step_mut(
+            order,
+            visitor,
+            self,
+            |visitor, inlines| visitor.visit_inline_list_mut(inlines),
+            |visitor, inlines| {
+                inlines
+                    .iter_mut()
+                    .try_for_each(|inline| inline.walk_mut(order, visitor))
+            },
+        )
+    }
+}
+
+impl Walkable for Block {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        step(
+            order,
+            visitor,
+            self,
+            |visitor, block| visitor.visit_block(block),
+            |visitor, block| block_contents(block, order, visitor),
+        )
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        step_mut(
+            order,
+            visitor,
+            self,
+            |visitor, block| visitor.visit_block_mut(block),
+            |visitor, block| block_contents_mut(block, order, visitor),
+        )
+    }
+}
+
+impl Walkable for Inline {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        step(
+            order,
+            visitor,
+            self,
+            |visitor, inline| visitor.visit_inline(inline),
+            |visitor, inline| inline_contents(inline, order, visitor),
+        )
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        step_mut(
+            order,
+            visitor,
+            self,
+            |visitor, inline| visitor.visit_inline_mut(inline),
+            |visitor, inline| inline_contents_mut(inline, order, visitor),
+        )
+    }
+}
+
+/// Metadata: the blocks and inlines of its values
+impl Walkable for ConfigValue {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        match &self.value {
+            ConfigValueKind::PandocBlocks(blocks) => blocks.walk(order, visitor),
+            ConfigValueKind::PandocInlines(inlines) => inlines.walk(order, visitor),
+            ConfigValueKind::Array(items) => {
+                items.iter().try_for_each(|item| item.walk(order, visitor))
+            }
+            ConfigValueKind::Map(entries) => entries
+                .iter()
+                .try_for_each(|entry| entry.value.walk(order, visitor)),
+            _ => ControlFlow::Continue(()),
+        }
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        match &mut self.value {
+            ConfigValueKind::PandocBlocks(blocks) => blocks.walk_mut(order, visitor),
+            ConfigValueKind::PandocInlines(inlines) => inlines.walk_mut(order, visitor),
+            ConfigValueKind::Array(items) => items
+                .iter_mut()
+                .try_for_each(|item| item.walk_mut(order, visitor)),
+            ConfigValueKind::Map(entries) => entries
+                .iter_mut()
+                .try_for_each(|entry| entry.value.walk_mut(order, visitor)),
+            _ => ControlFlow::Continue(()),
+        }
+    }
+}
+
+/// A document: its metadata, then its blocks
+impl Walkable for Pandoc {
+    fn walk<V: Visitor + ?Sized>(&self, order: Order, visitor: &mut V) -> ControlFlow<()> {
+        self.meta.walk(order, visitor)?;
+        self.blocks.walk(order, visitor)
+    }
+
+    fn walk_mut<V: VisitorMut + ?Sized>(
+        &mut self,
+        order: Order,
+        visitor: &mut V,
+    ) -> ControlFlow<()> {
+        self.meta.walk_mut(order, visitor)?;
+        self.blocks.walk_mut(order, visitor)
+    }
+}
+
+fn walk_each<W: Walkable, V: Visitor + ?Sized>(
+    items: &[W],
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    items.iter().try_for_each(|item| item.walk(order, visitor))
+}
+
+fn walk_each_mut<W: Walkable, V: VisitorMut + ?Sized>(
+    items: &mut [W],
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    items
+        .iter_mut()
+        .try_for_each(|item| item.walk_mut(order, visitor))
+}
+
+fn caption_contents<V: Visitor + ?Sized>(
+    caption: &Caption,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    if let Some(short) = &caption.short {
+        short.walk(order, visitor)?;
+    }
+    if let Some(long) = &caption.long {
+        long.walk(order, visitor)?;
+    }
+    ControlFlow::Continue(())
+}
+
+fn caption_contents_mut<V: VisitorMut + ?Sized>(
+    caption: &mut Caption,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    if let Some(short) = &mut caption.short {
+        short.walk_mut(order, visitor)?;
+    }
+    if let Some(long) = &mut caption.long {
+        long.walk_mut(order, visitor)?;
+    }
+    ControlFlow::Continue(())
+}
+
+/// The rows of a table, head to foot
+fn table_rows(table: &Table) -> impl Iterator<Item = &Row> {
+    table
+        .head
+        .rows
+        .iter()
+        .chain(
+            table
+                .bodies
+                .iter()
+                .flat_map(|body| body.head.iter().chain(&body.body)),
+        )
+        .chain(&table.foot.rows)
+}
+
+fn table_rows_mut(table: &mut Table) -> impl Iterator<Item = &mut Row> {
+    table
+        .head
+        .rows
+        .iter_mut()
+        .chain(
+            table
+                .bodies
+                .iter_mut()
+                .flat_map(|body| body.head.iter_mut().chain(&mut body.body)),
+        )
+        .chain(&mut table.foot.rows)
+}
+
+fn slots_contents<V: Visitor + ?Sized>(
+    custom: &CustomNode,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    custom.slots.values().try_for_each(|slot| match slot {
+        Slot::Block(block) => block.walk(order, visitor),
+        Slot::Inline(inline) => inline.walk(order, visitor),
+        Slot::Blocks(blocks) => blocks.walk(order, visitor),
+        Slot::Inlines(inlines) => inlines.walk(order, visitor),
+    })
+}
+
+fn slots_contents_mut<V: VisitorMut + ?Sized>(
+    custom: &mut CustomNode,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    custom.slots.values_mut().try_for_each(|slot| match slot {
+        Slot::Block(block) => block.walk_mut(order, visitor),
+        Slot::Inline(inline) => inline.walk_mut(order, visitor),
+        Slot::Blocks(blocks) => blocks.walk_mut(order, visitor),
+        Slot::Inlines(inlines) => inlines.walk_mut(order, visitor),
+    })
+}
+
+fn block_contents<V: Visitor + ?Sized>(
+    block: &Block,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    match block {
+        Block::Plain(Plain { content, .. })
+        | Block::Paragraph(Paragraph { content, .. })
+        | Block::Header(Header { content, .. })
+        | Block::NoteDefinitionPara(NoteDefinitionPara { content, .. })
+        | Block::CaptionBlock(CaptionBlock { content, .. }) => content.walk(order, visitor),
+        Block::BlockQuote(BlockQuote { content, .. })
+        | Block::Div(Div { content, .. })
+        | Block::NoteDefinitionFencedBlock(NoteDefinitionFencedBlock { content, .. }) => {
+            content.walk(order, visitor)
+        }
+        Block::LineBlock(line_block) => walk_each(&line_block.content, order, visitor),
+        Block::OrderedList(list) => walk_each(&list.content, order, visitor),
+        Block::BulletList(list) => walk_each(&list.content, order, visitor),
+        Block::DefinitionList(list) => list.content.iter().try_for_each(|(term, definitions)| {
+            term.walk(order, visitor)?;
+            walk_each(definitions, order, visitor)
+        }),
+        Block::Table(table) => {
+            caption_contents(&table.caption, order, visitor)?;
+            table_rows(table)
+                .flat_map(|row| &row.cells)
+                .try_for_each(|cell| cell.content.walk(order, visitor))
+        }
+        Block::Figure(figure) => {
+            caption_contents(&figure.caption, order, visitor)?;
+            figure.content.walk(order, visitor)
+        }
+        Block::BlockMetadata(meta) => meta.meta.walk(order, visitor),
+        Block::Custom(custom) => slots_contents(custom, order, visitor),
+        Block::CodeBlock(_) | Block::RawBlock(_) | Block::HorizontalRule(_) => {
+            ControlFlow::Continue(())
+        }
+    }
+}
+
+fn block_contents_mut<V: VisitorMut + ?Sized>(
+    block: &mut Block,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    match block {
+        Block::Plain(Plain { content, .. })
+        | Block::Paragraph(Paragraph { content, .. })
+        | Block::Header(Header { content, .. })
+        | Block::NoteDefinitionPara(NoteDefinitionPara { content, .. })
+        | Block::CaptionBlock(CaptionBlock { content, .. }) => content.walk_mut(order, visitor),
+        Block::BlockQuote(BlockQuote { content, .. })
+        | Block::Div(Div { content, .. })
+        | Block::NoteDefinitionFencedBlock(NoteDefinitionFencedBlock { content, .. }) => {
+            content.walk_mut(order, visitor)
+        }
+        Block::LineBlock(line_block) => walk_each_mut(&mut line_block.content, order, visitor),
+        Block::OrderedList(list) => walk_each_mut(&mut list.content, order, visitor),
+        Block::BulletList(list) => walk_each_mut(&mut list.content, order, visitor),
+        Block::DefinitionList(list) => {
+            list.content.iter_mut().try_for_each(|(term, definitions)| {
+                term.walk_mut(order, visitor)?;
+                walk_each_mut(definitions, order, visitor)
+            })
+        }
+        Block::Table(table) => {
+            caption_contents_mut(&mut table.caption, order, visitor)?;
+            table_rows_mut(table)
+                .flat_map(|row| &mut row.cells)
+                .try_for_each(|cell| cell.content.walk_mut(order, visitor))
+        }
+        Block::Figure(figure) => {
+            caption_contents_mut(&mut figure.caption, order, visitor)?;
+            figure.content.walk_mut(order, visitor)
+        }
+        Block::BlockMetadata(meta) => meta.meta.walk_mut(order, visitor),
+        Block::Custom(custom) => slots_contents_mut(custom, order, visitor),
+        Block::CodeBlock(_) | Block::RawBlock(_) | Block::HorizontalRule(_) => {
+            ControlFlow::Continue(())
+        }
+    }
+}
+
+fn inline_contents<V: Visitor + ?Sized>(
+    inline: &Inline,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    match inline {
+        Inline::Emph(Emph { content, .. })
+        | Inline::Underline(Underline { content, .. })
+        | Inline::Strong(Strong { content, .. })
+        | Inline::Strikeout(Strikeout { content, .. })
+        | Inline::Superscript(Superscript { content, .. })
+        | Inline::Subscript(Subscript { content, .. })
+        | Inline::SmallCaps(SmallCaps { content, .. })
+        | Inline::Quoted(Quoted { content, .. })
+        | Inline::Link(Link { content, .. })
+        | Inline::Image(Image { content, .. })
+        | Inline::Span(Span { content, .. })
+        | Inline::Insert(Insert { content, .. })
+        | Inline::Delete(Delete { content, .. })
+        | Inline::Highlight(Highlight { content, .. })
+        | Inline::EditComment(EditComment { content, .. }) => content.walk(order, visitor),
+        Inline::Cite(cite) => {
+            cite.citations.iter().try_for_each(|citation| {
+                citation.prefix.walk(order, visitor)?;
+                citation.suffix.walk(order, visitor)
+            })?;
+            cite.content.walk(order, visitor)
+        }
+        Inline::Note(note) => note.content.walk(order, visitor),
+        Inline::Custom(custom) => slots_contents(custom, order, visitor),
+        Inline::Str(_)
+        | Inline::Code(_)
+        | Inline::Space(_)
+        | Inline::SoftBreak(_)
+        | Inline::LineBreak(_)
+        | Inline::Math(_)
+        | Inline::RawInline(_)
+        | Inline::Shortcode(_)
+        | Inline::NoteReference(_)
+        | Inline::Attr(_, _) => ControlFlow::Continue(()),
+    }
+}
+
+fn inline_contents_mut<V: VisitorMut + ?Sized>(
+    inline: &mut Inline,
+    order: Order,
+    visitor: &mut V,
+) -> ControlFlow<()> {
+    match inline {
+        Inline::Emph(Emph { content, .. })
+        | Inline::Underline(Underline { content, .. })
+        | Inline::Strong(Strong { content, .. })
+        | Inline::Strikeout(Strikeout { content, .. })
+        | Inline::Superscript(Superscript { content, .. })
+        | Inline::Subscript(Subscript { content, .. })
+        | Inline::SmallCaps(SmallCaps { content, .. })
+        | Inline::Quoted(Quoted { content, .. })
+        | Inline::Link(Link { content, .. })
+        | Inline::Image(Image { content, .. })
+        | Inline::Span(Span { content, .. })
+        | Inline::Insert(Insert { content, .. })
+        | Inline::Delete(Delete { content, .. })
+        | Inline::Highlight(Highlight { content, .. })
+        | Inline::EditComment(EditComment { content, .. }) => content.walk_mut(order, visitor),
+        Inline::Cite(cite) => {
+            cite.citations.iter_mut().try_for_each(|citation| {
+                citation.prefix.walk_mut(order, visitor)?;
+                citation.suffix.walk_mut(order, visitor)
+            })?;
+            cite.content.walk_mut(order, visitor)
+        }
+        Inline::Note(note) => note.content.walk_mut(order, visitor),
+        Inline::Custom(custom) => slots_contents_mut(custom, order, visitor),
+        Inline::Str(_)
+        | Inline::Code(_)
+        | Inline::Space(_)
+        | Inline::SoftBreak(_)
+        | Inline::LineBreak(_)
+        | Inline::Math(_)
+        | Inline::RawInline(_)
+        | Inline::Shortcode(_)
+        | Inline::NoteReference(_)
+        | Inline::Attr(_, _) => ControlFlow::Continue(()),
+    }
+}
+
+/// A visitor made of one function
+struct FnVisitor<F>(F);
+
+impl<F: FnMut(&Block) -> Control> Visitor for FnVisitor<F> {
+    fn visit_block(&mut self, block: &Block) -> Control {
+        (self.0)(block)
+    }
+}
+
+struct InlineFnVisitor<F>(F);
+
+impl<F: FnMut(&Inline) -> Control> Visitor for InlineFnVisitor<F> {
+    fn visit_inline(&mut self, inline: &Inline) -> Control {
+        (self.0)(inline)
+    }
+}
+
+impl<F: FnMut(&mut Block) -> Control> VisitorMut for FnVisitor<F> {
+    fn visit_block_mut(&mut self, block: &mut Block) -> Control {
+        (self.0)(block)
+    }
+}
+
+impl<F: FnMut(&mut Inline) -> Control> VisitorMut for InlineFnVisitor<F> {
+    fn visit_inline_mut(&mut self, inline: &mut Inline) -> Control {
+        (self.0)(inline)
+    }
+}
+
+/// Call `f` on every block in `node`
+pub fn walk_blocks<W: Walkable + ?Sized>(
+    node: &W,
+    order: Order,
+    f: impl FnMut(&Block) -> Control,
+) -> ControlFlow<()> {
+    node.walk(order, &mut FnVisitor(f))
+}
+
+/// Call `f` on every inline in `node`
+pub fn walk_inlines<W: Walkable + ?Sized>(
+    node: &W,
+    order: Order,
+    f: impl FnMut(&Inline) -> Control,
+) -> ControlFlow<()> {
+    node.walk(order, &mut InlineFnVisitor(f))
+}
+
+/// Call `f` on every block in `node`, to change it
+pub fn walk_blocks_mut<W: Walkable + ?Sized>(
+    node: &mut W,
+    order: Order,
+    f: impl FnMut(&mut Block) -> Control,
+) -> ControlFlow<()> {
+    node.walk_mut(order, &mut FnVisitor(f))
+}
+
+/// Call `f` on every inline in `node`, to change it
+pub fn walk_inlines_mut<W: Walkable + ?Sized>(
+    node: &mut W,
+    order: Order,
+    f: impl FnMut(&mut Inline) -> Control,
+) -> ControlFlow<()> {
+    node.walk_mut(order, &mut InlineFnVisitor(f))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::attr::{AttrSourceInfo, empty_attr};
+    use crate::block::BulletList;
+    use crate::inline::{Note, Str};
+    use quarto_source_map::SourceInfo;
+
+    fn text(text: &str) -> Inline {
+        Inline::Str(Str {
+            text: text.to_string(),
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn emph(content: Vec<Inline>) -> Inline {
+        Inline::Emph(Emph {
+            content,
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn para(content: Vec<Inline>) -> Block {
+        Block::Paragraph(Paragraph {
+            content,
+            source_info: SourceInfo::default(),
+        })
+    }
+
+    fn div(content: Vec<Block>) -> Block {
+        Block::Div(Div {
+            attr: empty_attr(),
+            content,
+            source_info: SourceInfo::default(),
+            attr_source: AttrSourceInfo::empty(),
+        })
+    }
+
+    /// A div with a paragraph and a list, a note in the list
+    fn document() -> Pandoc {
+        let note = Inline::Note(Note {
+            id: None,
+            content: vec![para(vec![text("note")])],
+            source_info: SourceInfo::default(),
+        });
+        Pandoc {
+            meta: ConfigValue::new_inlines(vec![text("title")], SourceInfo::default()),
+            blocks: vec![
+                div(vec![para(vec![text("a"), emph(vec![text("b")])])]),
+                Block::BulletList(BulletList {
+                    content: vec![vec![para(vec![text("c"), note])]],
+                    source_info: SourceInfo::default(),
+                }),
+            ],
+        }
+    }
+
+    fn texts(node: &impl Walkable, order: Order) -> Vec<String> {
+        let mut texts = Vec::new();
+        let _ = walk_inlines(node, order, |inline| {
+            match inline {
+                Inline::Str(s) => texts.push(s.text.clone()),
+                Inline::Emph(_) => texts.push("<emph>".to_string()),
+                _ => {}
+            }
+            Control::Continue
+        });
+        texts
+    }
+
+    #[test]
+    fn test_walk_orders() {
+        let doc = document();
+        assert_eq!(
+            texts(&doc, Order::TopDown),
+            vec!["title", "a", "<emph>", "b", "c", "note"]
+        );
+        assert_eq!(
+            texts(&doc, Order::BottomUp),
+            vec!["title", "a", "b", "<emph>", "c", "note"]
+        );
+    }
+
+    #[test]
+    fn test_skip_and_stop() {
+        let doc = document();
+        let mut kinds = Vec::new();
+        let _ = walk_blocks(&doc, Order::TopDown, |block| {
+            kinds.push(matches!(block, Block::Div(_)));
+            if matches!(block, Block::Div(_)) {
+                Control::Skip
+            } else {
+                Control::Continue
+            }
+        });
+        // The div's paragraph is skipped: the div, the list, its paragraph
+        // and the note's
+        assert_eq!(kinds, vec![true, false, false, false]);
+
+        let mut seen = 0;
+        let walked = walk_inlines(&doc, Order::TopDown, |_| {
+            seen += 1;
+            if seen == 2 {
+                Control::Stop
+            } else {
+                Control::Continue
+            }
+        });
+        assert_eq!(walked, ControlFlow::Break(()));
+        assert_eq!(seen, 2);
+    }
+
+    #[test]
+    fn test_walk_mut() {
+        let mut doc = document();
+        let _ = walk_inlines_mut(&mut doc, Order::BottomUp, |inline| {
+            if let Inline::Str(s) = inline {
+                s.text = s.text.to_uppercase();
+            }
+            Control::Continue
+        });
+        assert_eq!(
+            texts(&doc, Order::TopDown),
+            vec!["TITLE", "A", "<emph>", "B", "C", "NOTE"]
+        );
+    }
+
+    #[test]
+    fn test_lists_can_be_spliced() {
+        // Unwrap every emph into its contents; bottom-up, so that the emphs
+        // in emphs are unwrapped first
+        struct Unwrap;
+        impl VisitorMut for Unwrap {
+            fn visit_inline_list_mut(&mut self, inlines: &mut Vec<Inline>) -> Control {
+                *inlines = std::mem::take(inlines)
+                    .into_iter()
+                    .flat_map(|inline| match inline {
+                        Inline::Emph(emph) => emph.content,
+                        other => vec![other],
+                    })
+                    .collect();
+                Control::Continue
+            }
+        }
+        let mut blocks = vec![para(vec![emph(vec![text("a"), emph(vec![text("b")])])])];
+        let _ = blocks.walk_mut(Order::BottomUp, &mut Unwrap);
+        assert_eq!(blocks, vec![para(vec![text("a"), text("b")])]);
+    }
+}
@@ -67,7 +67,8 @@ impl FileInformation {
     /// Runs in O(log n) time where n is the number of lines.
     ///
     /// The column is computed as character count (not byte count) from the start
-    /// of the line to the offset, which requires the content parameter.
+    /// of the line to the offset, which requires the content parameter. A byte
+    /// order mark, and the `\r` of a `\r\n` line ending, aren't counted.
     ///
     /// Returns None if the offset is out of bounds.
     ///
@@ -110,7 +111,16 @@ impl FileInformation {
 
         // Count characters (not bytes) from line_start to offset
         // This ensures the column is a character count, not a byte count
-        let column = content[line_start..offset].chars().count();
+        let line = &content[line_start..offset];
+        let mut column = line.chars().count();
+        // A byte order mark and the \r of a \r\n line ending don't take a
+        // column: they aren't shown by editors
+        if row == 0 && line.starts_with('\u{feff}') {
+            column -= 1;
+        }
+        if line.ends_with('\r') && content[offset..].starts_with('\n') {
+            column -= 1;
+        }
 
         Some(Location {
             offset,
@@ -285,6 +295,34 @@ mod tests {
         assert_eq!(loc.column, 0);
     }
 
+    #[test]
+    fn test_crlf_line_endings() {
+        let content = "ab\r\ncd\r\n";
+        let info = FileInformation::new(content);
+
+        // At the \r, and at the \n after it: both the end of the line
+        let loc = info.offset_to_location(2, content).unwrap();
+        assert_eq!((loc.row, loc.column), (0, 2));
+        let loc = info.offset_to_location(3, content).unwrap();
+        assert_eq!((loc.row, loc.column), (0, 2));
+
+        // The next line starts after the \n
+        let loc = info.offset_to_location(5, content).unwrap();
+        assert_eq!((loc.row, loc.column), (1, 1));
+    }
+
+    #[test]
+    fn test_byte_order_mark_takes_no_column() {
+        let content = "\u{feff}ab\ncd";
+        let info = FileInformation::new(content);
+        // 'b' is after the 3-byte BOM and 'a'
+        let loc = info.offset_to_location(4, content).unwrap();
+        assert_eq!((loc.row, loc.column), (0, 1));
+        // Later lines are unaffected
+        let loc = info.offset_to_location(6, content).unwrap();
+        assert_eq!((loc.row, loc.column), (1, 0));
+    }
+
     #[test]
     fn test_multibyte_utf8_column_should_be_character_count() {
         // This test verifies that column is character count, not byte offset
@@ -2,9 +2,18 @@
 
 use crate::types::{Location, Range};
 
+const BOM: char = '\u{feff}';
+
+/// Whether `ch`, at `offset` in `source`, is left out of column counts: a
+/// byte order mark, or the `\r` of a `\r\n` line ending
+fn takes_no_column(source: &str, offset: usize, ch: char) -> bool {
+    (ch == BOM && offset == 0) || (ch == '\r' && source[offset + 1..].starts_with('\n'))
+}
+
 /// Convert a byte offset to a Location with line and column info
 ///
-/// Returns None if the offset is out of bounds.
+/// Returns None if the offset is out of bounds. A byte order mark, and
+/// the `\r` of a `\r\n` line ending, don't take a column.
 pub fn offset_to_location(source: &str, offset: usize) -> Option<Location> {
     if offset > source.len() {
         return None;
@@ -22,7 +31,7 @@ pub fn offset_to_location(source: &str, offset: usize) -> Option<Location> {
         if ch == '\n' {
             row += 1;
             column = 0;
-        } else {
+        } else if !takes_no_column(source, current_offset, ch) {
             column += 1;
         }
 
@@ -42,9 +51,14 @@ pub fn offset_to_location(source: &str, offset: usize) -> Option<Location> {
 pub fn line_col_to_offset(source: &str, line: usize, col: usize) -> Option<usize> {
     let mut current_line = 0;
     let mut current_col = 0;
-    let mut offset = 0;
-
-    for ch in source.chars() {
+    // Column 0 of the first line is after a byte order mark
+    let mut offset = if source.starts_with(BOM) {
+        BOM.len_utf8()
+    } else {
+        0
+    };
+
+    for ch in source[offset..].chars() {
         if current_line == line && current_col == col {
             return Some(offset);
         }
@@ -52,7 +66,7 @@ pub fn line_col_to_offset(source: &str, line: usize, col: usize) -> Option<usize
         if ch == '\n' {
             current_line += 1;
             current_col = 0;
-        } else {
+        } else if !takes_no_column(source, offset, ch) {
             current_col += 1;
         }
 
@@ -182,6 +196,24 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_crlf_and_byte_order_mark() {
+        let source = "\u{feff}ab\r\ncd";
+
+        // 'b', after the 3-byte BOM and 'a'
+        let loc = offset_to_location(source, 4).unwrap();
+        assert_eq!((loc.row, loc.column), (0, 1));
+        // The \n of the \r\n is at the end of the line, like the \r
+        let loc = offset_to_location(source, 6).unwrap();
+        assert_eq!((loc.row, loc.column), (0, 2));
+        let loc = offset_to_location(source, 8).unwrap();
+        assert_eq!((loc.row, loc.column), (1, 1));
+
+        assert_eq!(line_col_to_offset(source, 0, 0), Some(3));
+        assert_eq!(line_col_to_offset(source, 0, 2), Some(5));
+        assert_eq!(line_col_to_offset(source, 1, 1), Some(8));
+    }
+
     #[test]
     fn test_range_from_offsets() {
         let range = range_from_offsets(10, 20);
@@ -18,6 +18,8 @@ pub struct HubArgs {
     pub sync_interval: u64,
     pub no_watch: bool,
     pub watch_debounce: u64,
+    pub access_control: bool,
+    pub allow_origin: Vec<String>,
 }
 
 /// Execute the hub command.
@@ -80,6 +82,8 @@ async fn run_hub(args: HubArgs) -> Result<()> {
         sync_interval_secs,
         watch_enabled: !args.no_watch,
         watch_debounce_ms: args.watch_debounce,
+        access_control: args.access_control,
+        allowed_origins: args.allow_origin,
     };
 
     server::run_server(storage, config).await?;
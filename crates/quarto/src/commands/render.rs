@@ -17,12 +17,19 @@
 //! - HTML output (native Rust pipeline, no Pandoc)
 //! - Basic document structure
 //! - SASS theme compilation (Bootstrap/Bootswatch themes)
+//! - Code execution with `--execute` (Jupyter kernels)
+//! - Caching of execution results with `--cache` and `--cache-refresh`
 //!
 //! Not yet supported:
-//! - Code execution
-//! - Navigation (navbar, sidebar, footer)
-//! - Multi-file projects
+//! - Freezing of execution results (`--use-freezer`)
+//! - Navigation (navbar, sidebar, footer) in the rendered pages; books and
+//!   websites get a site manifest describing it instead
 //! - Non-HTML formats
+//!
+//! Local images and files the pages refer to are copied to `_resources/`
+//! in the output directory, and listed in its resource manifest. With
+//! `embed-resources: true` (or `--embed-resources`) they are put in the
+//! page instead, along with its stylesheets, and nothing is copied.
 
 use std::path::{Path, PathBuf};
 use std::sync::Arc;
@@ -30,9 +37,15 @@ use std::sync::Arc;
 use anyhow::{Context, Result};
 use tracing::{debug, info, warn};
 
+use quarto_core::engine::CacheMode;
+use quarto_core::resource_manifest::{
+    RESOURCE_ARTIFACT_PREFIX, RESOURCE_HREF_METADATA, RESOURCE_MANIFEST_FILE, normalize_path,
+};
+use quarto_core::stage::TraceObserver;
 use quarto_core::{
     BinaryDependencies, DocumentInfo, Format, FormatIdentifier, HtmlRenderConfig, ProjectContext,
-    QuartoError, RenderContext, RenderOptions, extract_format_metadata, render_qmd_to_html,
+    QuartoError, RenderContext, RenderOptions, ResourceManifest, SiteManifest, assemble_site,
+    extract_format_metadata, render_qmd_to_html,
 };
 use quarto_sass::{ThemeConfig, ThemeContext, ThemeSpec};
 use quarto_system_runtime::{NativeRuntime, SystemRuntime};
@@ -48,11 +61,21 @@ pub struct RenderArgs {
     pub output: Option<String>,
     /// Output directory
     pub output_dir: Option<String>,
+    /// Execute code cells
+    pub execute: bool,
+    /// Cache execution results of every document
+    pub cache: bool,
+    /// Execute again and replace cached results
+    pub cache_refresh: bool,
     /// Suppress console output
     pub quiet: bool,
     /// Leave intermediate files (not yet implemented)
     #[allow(dead_code)]
     pub debug: bool,
+    /// Make self-contained pages, whatever the format's `embed-resources`
+    pub embed_resources: bool,
+    /// Write a trace of the render to this file (see `pampa::trace`)
+    pub trace: Option<PathBuf>,
 }
 
 /// Execute the render command
@@ -94,7 +117,7 @@ pub fn execute(args: RenderArgs) -> Result<()> {
     }
 
     // Discover project context
-    let project = ProjectContext::discover(&input_path, &runtime)
+    let mut project = ProjectContext::discover(&input_path, &runtime)
         .context("Failed to discover project context")?;
 
     if !args.quiet {
@@ -106,20 +129,132 @@ pub fn execute(args: RenderArgs) -> Result<()> {
                 project.dir.display(),
                 project.project_type().as_str()
             );
+            if let Some(config) = project.config.as_ref().filter(|c| !c.profiles.is_empty()) {
+                info!("Active profiles: {}", config.profiles.join(", "));
+            }
         }
     }
 
     // Set up binary dependencies
     let binaries = BinaryDependencies::discover(&runtime);
 
+    // A book or website rendered as a whole renders its pages
+    let manifest = if project.is_multi_document() && project.files.is_empty() {
+        Some(assemble_project(&mut project, &args, &runtime)?)
+    } else {
+        None
+    };
+
+    // A trace is of one conversion
+    if args.trace.is_some() && project.files.len() > 1 {
+        anyhow::bail!("--trace renders one document at a time, not a whole project");
+    }
+
     // Render each file
+    let mut resources = ResourceManifest::new();
     for doc_info in &project.files {
-        render_document(doc_info, &project, &format, &binaries, &args, &runtime)?;
+        resources.merge(render_document(
+            doc_info, &project, &format, &binaries, &args, &runtime,
+        )?);
+    }
+
+    if let Some(manifest) = manifest {
+        write_site_manifest(&manifest, &project, &args, &runtime)?;
+    }
+    if !resources.resources.is_empty() {
+        write_resource_manifest(&resources, &project, &args, &runtime)?;
+    }
+
+    Ok(())
+}
+
+/// Assemble a book or website and add its pages to the files to render
+fn assemble_project(
+    project: &mut ProjectContext,
+    args: &RenderArgs,
+    runtime: &dyn SystemRuntime,
+) -> Result<SiteManifest> {
+    let site = assemble_site(project, runtime).context("Failed to assemble the project")?;
+    if !args.quiet {
+        for diagnostic in &site.diagnostics {
+            eprintln!("{}", diagnostic.to_text(None));
+        }
+    }
+
+    for page in &site.manifest.pages {
+        let mut doc_info = DocumentInfo::from_path(project.dir.join(&page.input));
+        doc_info.config = project
+            .document_config(&doc_info.input, runtime)
+            .context("Failed to resolve the page configuration")?;
+        project.files.push(doc_info);
+    }
+    Ok(site.manifest)
+}
+
+/// Write the site manifest to the output directory
+fn write_site_manifest(
+    manifest: &SiteManifest,
+    project: &ProjectContext,
+    args: &RenderArgs,
+    runtime: &dyn SystemRuntime,
+) -> Result<()> {
+    let json =
+        serde_json::to_string_pretty(manifest).context("Failed to serialize the site manifest")?;
+    let path = write_to_output_dir(
+        quarto_core::site::SITE_MANIFEST_FILE,
+        &json,
+        project,
+        args,
+        runtime,
+    )?;
+    if !args.quiet {
+        info!("Site manifest: {}", path.display());
     }
+    Ok(())
+}
 
+/// Write the manifest of the resources copied for all pages to the output
+/// directory
+fn write_resource_manifest(
+    manifest: &ResourceManifest,
+    project: &ProjectContext,
+    args: &RenderArgs,
+    runtime: &dyn SystemRuntime,
+) -> Result<()> {
+    let json = serde_json::to_string_pretty(manifest)
+        .context("Failed to serialize the resource manifest")?;
+    let path = write_to_output_dir(RESOURCE_MANIFEST_FILE, &json, project, args, runtime)?;
+    debug!("Resource manifest: {}", path.display());
     Ok(())
 }
 
+/// Write `content` to the file `name` in the output directory, and return
+/// its path
+fn write_to_output_dir(
+    name: &str,
+    content: &str,
+    project: &ProjectContext,
+    args: &RenderArgs,
+    runtime: &dyn SystemRuntime,
+) -> Result<PathBuf> {
+    let output_dir = args
+        .output_dir
+        .as_ref()
+        .map_or_else(|| project.output_dir.clone(), PathBuf::from);
+    runtime.dir_create(&output_dir, true).map_err(|e| {
+        anyhow::anyhow!(
+            "Failed to create output directory {}: {}",
+            output_dir.display(),
+            e
+        )
+    })?;
+    let path = output_dir.join(name);
+    runtime
+        .file_write(&path, content.as_bytes())
+        .map_err(|e| anyhow::anyhow!("Failed to write {}: {}", path.display(), e))?;
+    Ok(path)
+}
+
 /// Resolve format string to Format (without metadata)
 fn resolve_format(format_str: &str) -> Result<Format> {
     resolve_format_with_metadata(format_str, serde_json::Value::Null)
@@ -149,7 +284,8 @@ fn resolve_format_with_metadata(format_str: &str, metadata: serde_json::Value) -
     })
 }
 
-/// Render a single document
+/// Render a single document, and return the manifest of the resources
+/// copied for it
 fn render_document(
     doc_info: &DocumentInfo,
     project: &ProjectContext,
@@ -157,7 +293,7 @@ fn render_document(
     binaries: &BinaryDependencies,
     args: &RenderArgs,
     runtime: &dyn SystemRuntime,
-) -> Result<()> {
+) -> Result<ResourceManifest> {
     debug!("Rendering: {}", doc_info.input.display());
 
     // Read input file early (we need it for format metadata extraction)
@@ -190,9 +326,17 @@ fn render_document(
     // Create render context with the format that has metadata
     let options = RenderOptions {
         verbose: !args.quiet,
-        execute: false, // MVP: no code execution
+        execute: args.execute,
         use_freeze: false,
+        cache: if args.cache_refresh {
+            CacheMode::Refresh
+        } else if args.cache {
+            CacheMode::Always
+        } else {
+            CacheMode::Document
+        },
         output_path: args.output.as_ref().map(PathBuf::from),
+        sanitize: None,
     };
 
     let mut ctx = RenderContext::new(project, doc_info, &format_with_metadata, binaries)
@@ -231,9 +375,11 @@ fn render_document(
 
     // Use the unified pipeline to render
     let input_path_str = doc_info.input.to_string_lossy();
+    let trace = args.trace.as_ref().map(|_| Arc::new(TraceObserver::new()));
     let config = HtmlRenderConfig {
         css_paths: &resource_paths.css,
         template: None,
+        trace: trace.clone(),
     };
 
     // Create Arc runtime for the async pipeline
@@ -257,6 +403,13 @@ fn render_document(
         Err(e) => return Err(anyhow::anyhow!("{}", e)),
     };
 
+    if let (Some(trace_path), Some(trace)) = (&args.trace, &trace) {
+        let json = serde_json::to_vec_pretty(&trace.take_trace(&input_path_str))?;
+        runtime.file_write(trace_path, &json).map_err(|e| {
+            anyhow::anyhow!("Failed to write trace to {}: {}", trace_path.display(), e)
+        })?;
+    }
+
     // Report diagnostics with full ariadne-style source context
     if !args.quiet && !output.diagnostics.is_empty() {
         for diagnostic in &output.diagnostics {
@@ -265,9 +418,21 @@ fn render_document(
         }
     }
 
+    let embed_resources = args.embed_resources
+        || format_with_metadata
+            .metadata
+            .get("embed-resources")
+            .and_then(|v| v.as_bool())
+            .unwrap_or(false);
+    let html = if embed_resources {
+        embed_page_resources(&output.html, &ctx, output_dir, runtime)
+    } else {
+        output.html
+    };
+
     // Write output
     runtime
-        .file_write(&output_path, output.html.as_bytes())
+        .file_write(&output_path, html.as_bytes())
         .map_err(|e| {
             anyhow::anyhow!(
                 "Failed to write output file {}: {}",
@@ -280,6 +445,78 @@ fn render_document(
         info!("Output: {}", output_path.display());
     }
 
+    if embed_resources {
+        // The page needs none of the files around it
+        let dir = &resource_paths.resource_dir;
+        if !dir.as_os_str().is_empty() && runtime.is_dir(dir).unwrap_or(false) {
+            runtime
+                .dir_remove(dir, true)
+                .map_err(|e| anyhow::anyhow!("Failed to remove {}: {}", dir.display(), e))?;
+        }
+        return Ok(ResourceManifest::new());
+    }
+    write_resources(&ctx, output_dir, runtime)?;
+    Ok(ResourceManifest::from_artifacts(&ctx.artifacts).unwrap_or_default())
+}
+
+/// `html` with the files it refers to put in it: the local resources of
+/// the page from the render's artifacts, and the rest (the theme's
+/// stylesheets) from the output directory
+fn embed_page_resources(
+    html: &str,
+    ctx: &RenderContext,
+    page_dir: &Path,
+    runtime: &dyn SystemRuntime,
+) -> String {
+    let embedded = pampa::writers::html_embed::embed_resources(html, &mut |url| {
+        let resource = ctx
+            .artifacts
+            .get_by_prefix(RESOURCE_ARTIFACT_PREFIX)
+            .into_iter()
+            .find(|(_, artifact)| {
+                artifact
+                    .metadata
+                    .get(RESOURCE_HREF_METADATA)
+                    .and_then(|href| href.as_str())
+                    == Some(url)
+            });
+        match resource {
+            Some((_, artifact)) => Some(artifact.content.clone()),
+            None => runtime.file_read(&normalize_path(&page_dir.join(url))).ok(),
+        }
+    });
+    for url in &embedded.missing {
+        warn!("Could not embed {}: the file could not be read", url);
+    }
+    embedded.html
+}
+
+/// Write the copies of the local resources a page refers to, at the URLs
+/// the page has for them
+fn write_resources(
+    ctx: &RenderContext,
+    page_dir: &Path,
+    runtime: &dyn SystemRuntime,
+) -> Result<()> {
+    for (key, artifact) in ctx.artifacts.get_by_prefix(RESOURCE_ARTIFACT_PREFIX) {
+        let Some(href) = artifact
+            .metadata
+            .get(RESOURCE_HREF_METADATA)
+            .and_then(|href| href.as_str())
+        else {
+            continue;
+        };
+        let path = normalize_path(&page_dir.join(href));
+        if let Some(dir) = path.parent() {
+            runtime.dir_create(dir, true).map_err(|e| {
+                anyhow::anyhow!("Failed to create directory {}: {}", dir.display(), e)
+            })?;
+        }
+        runtime
+            .file_write(&path, &artifact.content)
+            .map_err(|e| anyhow::anyhow!("Failed to write {}: {}", path.display(), e))?;
+        debug!("Resource {}: {}", key, path.display());
+    }
     Ok(())
 }
 
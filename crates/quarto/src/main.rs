@@ -104,6 +104,12 @@ enum Commands {
         #[arg(long)]
         log_format: Option<String>,
 
+        /// Write a trace of the render to FILE as JSON: how long each stage,
+        /// each AST transform and code execution took, with the source
+        /// locations of the blocks they changed
+        #[arg(long, value_name = "FILE")]
+        trace: Option<PathBuf>,
+
         /// Suppress console output
         #[arg(long)]
         quiet: bool,
@@ -352,6 +358,18 @@ enum Commands {
         /// Debounce duration for filesystem events in milliseconds.
         #[arg(long, default_value = "500")]
         watch_debounce: u64,
+
+        /// Require a token to connect: the owner token (shown on first start,
+        /// and kept in .quarto/hub/access.json) or that of a share link
+        /// created by the owner.
+        #[arg(long)]
+        access_control: bool,
+
+        /// Origin the hub client is served from, allowed to call the REST
+        /// API from a browser (can be specified multiple times). Only used
+        /// with --access-control.
+        #[arg(long = "allow-origin", value_name = "ORIGIN")]
+        allow_origin: Vec<String>,
     },
 }
 
@@ -373,16 +391,28 @@ fn main() -> Result<()> {
             to,
             output,
             output_dir,
+            execute,
+            cache,
+            cache_refresh,
             quiet,
             debug,
+            trace,
+            pandoc_args,
             ..
         } => commands::render::execute(commands::render::RenderArgs {
             input,
             to,
             output,
             output_dir,
+            execute,
+            cache,
+            cache_refresh,
             quiet,
             debug,
+            trace,
+            embed_resources: pandoc_args
+                .iter()
+                .any(|arg| arg == "--embed-resources" || arg == "--self-contained"),
         }),
         Commands::Preview { .. } => commands::preview::execute(),
         Commands::Serve { .. } => commands::serve::execute(),
@@ -411,6 +441,8 @@ fn main() -> Result<()> {
             sync_interval,
             no_watch,
             watch_debounce,
+            access_control,
+            allow_origin,
         } => commands::hub::execute(commands::hub::HubArgs {
             project,
             port,
@@ -419,6 +451,8 @@ fn main() -> Result<()> {
             sync_interval,
             no_watch,
             watch_debounce,
+            access_control,
+            allow_origin,
         }),
     }
 }
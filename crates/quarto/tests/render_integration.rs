@@ -58,7 +58,7 @@ fn run_render(input_path: &Path, output_path: &Path) -> Result<(), String> {
     use quarto_core::{
         BinaryDependencies, CalloutResolveTransform, CalloutTransform, DocumentInfo, Format,
         MetadataNormalizeTransform, ProjectContext, RenderContext, RenderOptions,
-        ResourceCollectorTransform, TransformPipeline,
+        ResourceCollectorTransform, TransformPipeline, engine::CacheMode,
     };
     use quarto_system_runtime::NativeRuntime;
 
@@ -97,7 +97,9 @@ fn run_render(input_path: &Path, output_path: &Path) -> Result<(), String> {
         verbose: false,
         execute: false,
         use_freeze: false,
+        cache: CacheMode::Document,
         output_path: Some(output_path.to_path_buf()),
+        sanitize: None,
     };
     let mut ctx = RenderContext::new(&project, &doc_info, &format, &binaries).with_options(options);
 
@@ -0,0 +1,161 @@
+// Package ast provides a typed syntax tree for Pandoc document templates,
+// built on top of the raw tree-sitter-doctemplate parse tree.
+//
+// The types mirror the ones used by the quarto-doctemplate Rust crate:
+// a template is a sequence of nodes, and each node records the source
+// span it was parsed from so tools can report precise locations.
+package ast
+
+import (
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Span is the source range covered by a node.
+type Span = tree_sitter.Range
+
+// Node is a node in the template AST. It is implemented by
+// *Literal, *Interpolation, *Conditional, *ForLoop, *Partial, *Nesting,
+// *BreakableSpace and *Comment.
+type Node interface {
+	span() Span
+}
+
+// SpanOf returns the source span of n.
+func SpanOf(n Node) Span {
+	return n.span()
+}
+
+// Template is a parsed template.
+//
+// A Template returned by Parse keeps its tree-sitter parse tree so that it
+// can be reparsed incrementally with ApplyEdit; call Close to release it.
+type Template struct {
+	// Nodes are the top-level nodes of the template.
+	Nodes []Node
+	// Source is the text the template was parsed from.
+	Source []byte
+
+	tree *tree_sitter.Tree
+}
+
+// Literal is text that is output as-is. An escaped dollar (`$$`) is a
+// Literal with Text "$".
+type Literal struct {
+	Text string
+	Span Span
+}
+
+// Variable is a reference to a (possibly nested) variable, such as
+// `employee.salary`.
+type Variable struct {
+	// Path holds the dot-separated components of the name.
+	Path []string
+	Span Span
+}
+
+// Name returns the variable name as written in the template.
+func (v Variable) Name() string {
+	return strings.Join(v.Path, ".")
+}
+
+// Interpolation is a variable interpolation: `$var$`, `${var}`,
+// `$var/uppercase$` or `$var[, ]$`.
+type Interpolation struct {
+	Variable Variable
+	// Pipes are applied to the value in order.
+	Pipes []Pipe
+	// Separator is the literal separator from `$var[sep]$`, or "" if absent.
+	Separator string
+	Span      Span
+}
+
+// Branch is one `$if(...)$` or `$elseif(...)$` arm of a conditional.
+type Branch struct {
+	Condition Variable
+	Body      []Node
+}
+
+// Conditional is a conditional block: `$if(var)$...$elseif(var)$...$else$...$endif$`.
+type Conditional struct {
+	// Branches holds the if branch followed by any elseif branches.
+	Branches []Branch
+	// Else is the body of the `$else$` branch, or nil if there is none.
+	Else []Node
+	Span Span
+}
+
+// ForLoop is a loop: `$for(var)$...$sep$...$endfor$`.
+type ForLoop struct {
+	Variable Variable
+	// Pipes are applied to the value before it is iterated, as in
+	// `$for(meta/pairs)$`.
+	Pipes []Pipe
+	Body  []Node
+	// Separator is rendered between iterations, or nil if there is no `$sep$`.
+	Separator []Node
+	Span      Span
+}
+
+// Partial is a sub-template reference: `$partial()$` or `$var:partial()$`.
+type Partial struct {
+	Name string
+	// NameSpan is the span of Name within the reference.
+	NameSpan Span
+	// Variable is the value the partial is applied to, or nil for a bare partial.
+	Variable *Variable
+	// Separator is the literal separator from `$var:partial()[sep]$`, or "".
+	Separator string
+	Pipes     []Pipe
+	Span      Span
+}
+
+// Nesting is the `$^$` (or `${^}`) directive marking an indentation point.
+type Nesting struct {
+	Span Span
+}
+
+// BreakableSpace is a `$~$...$~$` (or `${~}...${~}`) block.
+type BreakableSpace struct {
+	Children []Node
+	Span     Span
+}
+
+// Comment is a `$--` comment. Text excludes the `$--` prefix.
+type Comment struct {
+	Text string
+	Span Span
+}
+
+// Pipe is a transformation applied to a value, such as `uppercase` or
+// `left 20 "| " ""`.
+type Pipe struct {
+	Name string
+	Args []PipeArg
+	Span Span
+}
+
+// PipeArg is an argument to a pipe: an IntArg or a StringArg.
+type PipeArg interface {
+	pipeArg()
+}
+
+// IntArg is an integer pipe argument, such as the width in `left 20`.
+type IntArg int
+
+// StringArg is a quoted string pipe argument, such as a border in
+// `left 20 "| "`. The value excludes the quotes.
+type StringArg string
+
+func (IntArg) pipeArg()    {}
+func (StringArg) pipeArg() {}
+
+func (n *Literal) span() Span        { return n.Span }
+func (n *Interpolation) span() Span  { return n.Span }
+func (n *Conditional) span() Span    { return n.Span }
+func (n *ForLoop) span() Span        { return n.Span }
+func (n *Partial) span() Span        { return n.Span }
+func (n *Nesting) span() Span        { return n.Span }
+func (n *BreakableSpace) span() Span { return n.Span }
+func (n *Comment) span() Span        { return n.Span }
@@ -0,0 +1,49 @@
+package ast
+
+import "fmt"
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a problem found in a template.
+type Diagnostic struct {
+	Severity Severity
+	// Code is the Quarto error code (e.g. "Q-10-1"); see
+	// crates/quarto-error-reporting/error_catalog.json.
+	Code    string
+	Message string
+	Span    Span
+}
+
+// String formats the diagnostic as "line:column: severity: message",
+// with 1-based line and column numbers.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s",
+		d.Span.StartPoint.Row+1, d.Span.StartPoint.Column+1, d.Severity, d.Message)
+}
+
+// HasErrors reports whether any diagnostic has SeverityError.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
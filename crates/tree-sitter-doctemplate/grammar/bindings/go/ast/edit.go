@@ -0,0 +1,59 @@
+package ast
+
+import (
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Tree returns the tree-sitter parse tree the template was built from, or
+// nil if the template was not produced by Parse or has been closed. The
+// tree is owned by the template and is invalidated by ApplyEdit and Close.
+func (t *Template) Tree() *tree_sitter.Tree {
+	return t.tree
+}
+
+// Close releases the template's parse tree. The AST remains usable, but
+// the template can no longer be reparsed incrementally.
+func (t *Template) Close() {
+	if t.tree != nil {
+		t.tree.Close()
+		t.tree = nil
+	}
+}
+
+// ApplyEdit updates the template after its source was edited. edit
+// describes the change in terms of the old source, and newSource is the
+// full text after the edit.
+//
+// The old parse tree is reused, so only the parts of the template affected
+// by the edit are reparsed. ApplyEdit returns the ranges of newSource
+// whose syntactic structure changed, as reported by tree-sitter, along with
+// the diagnostics for the new source. Edits that only change text inside a
+// single literal or variable name may produce no changed ranges; callers
+// that need to track those should also consider the edited range itself.
+//
+// If the template has no parse tree, it is parsed from scratch and the
+// whole of newSource is reported as changed.
+func (t *Template) ApplyEdit(edit tree_sitter.InputEdit, newSource []byte) ([]tree_sitter.Range, []Diagnostic) {
+	oldTree := t.tree
+	if oldTree != nil {
+		oldTree.Edit(&edit)
+	}
+	tree, diag := parse(newSource, oldTree)
+	if tree == nil {
+		t.Close()
+		t.Nodes, t.Source = nil, newSource
+		return nil, []Diagnostic{diag}
+	}
+
+	var changed []tree_sitter.Range
+	if oldTree != nil {
+		changed = oldTree.ChangedRanges(tree)
+		oldTree.Close()
+	} else {
+		changed = []tree_sitter.Range{tree.RootNode().Range()}
+	}
+
+	updated, diags := fromTree(tree, newSource)
+	*t = *updated
+	return changed, diags
+}
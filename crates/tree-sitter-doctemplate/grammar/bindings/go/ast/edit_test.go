@@ -0,0 +1,83 @@
+package ast_test
+
+import (
+	"bytes"
+	"testing"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	"github.com/tree-sitter/tree-sitter-doctemplate/bindings/go/ast"
+)
+
+// replace returns src with old replaced by new at its first occurrence,
+// and the InputEdit describing the change. src must be a single line.
+func replace(src []byte, old, new string) ([]byte, tree_sitter.InputEdit) {
+	start := bytes.Index(src, []byte(old))
+	out := append(append(append([]byte{}, src[:start]...), new...), src[start+len(old):]...)
+	return out, tree_sitter.InputEdit{
+		StartByte:      uint(start),
+		OldEndByte:     uint(start + len(old)),
+		NewEndByte:     uint(start + len(new)),
+		StartPosition:  tree_sitter.Point{Column: uint(start)},
+		OldEndPosition: tree_sitter.Point{Column: uint(start + len(old))},
+		NewEndPosition: tree_sitter.Point{Column: uint(start + len(new))},
+	}
+}
+
+func TestApplyEdit(t *testing.T) {
+	src := []byte("a $x$ b $y$ c")
+	tmpl, diags := ast.Parse(src)
+	defer tmpl.Close()
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	src, edit := replace(src, "$y$", "$if(y)$z$endif$")
+	changed, diags := tmpl.ApplyEdit(edit, src)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if got, want := dump(tmpl.Nodes), `"a " (var x) " b " (if (y "z")) " c"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if len(changed) == 0 {
+		t.Fatal("expected changed ranges")
+	}
+	for _, r := range changed {
+		if r.StartByte < edit.StartByte || r.EndByte > edit.NewEndByte+1 {
+			t.Errorf("changed range %d-%d outside edit %d-%d", r.StartByte, r.EndByte, edit.StartByte, edit.NewEndByte)
+		}
+	}
+	if !bytes.Equal(tmpl.Source, src) {
+		t.Errorf("source not updated: %q", tmpl.Source)
+	}
+
+	// Breaking the template reports diagnostics, and fixing it clears them.
+	broken, edit := replace(src, "$endif$", "")
+	if _, diags := tmpl.ApplyEdit(edit, broken); !ast.HasErrors(diags) {
+		t.Errorf("expected errors after removing $endif$, got %v", diags)
+	}
+	fixed, edit := replace(broken, "z", "z$endif$")
+	if _, diags := tmpl.ApplyEdit(edit, fixed); len(diags) != 0 {
+		t.Errorf("expected no diagnostics after fix, got %v", diags)
+	}
+	if got, want := dump(tmpl.Nodes), `"a " (var x) " b " (if (y "z")) " c"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestApplyEditWithoutTree(t *testing.T) {
+	tmpl, _ := ast.Parse([]byte("$x$"))
+	tmpl.Close()
+	if tmpl.Tree() != nil {
+		t.Fatal("Close did not release the tree")
+	}
+	src, edit := replace([]byte("$x$"), "x", "y")
+	changed, _ := tmpl.ApplyEdit(edit, src)
+	defer tmpl.Close()
+	if len(changed) != 1 || changed[0].EndByte != uint(len(src)) {
+		t.Errorf("expected the whole source to be reported as changed, got %v", changed)
+	}
+	if got := dump(tmpl.Nodes); got != "(var y)" {
+		t.Errorf("got %s", got)
+	}
+}
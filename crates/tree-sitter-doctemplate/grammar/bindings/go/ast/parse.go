@@ -0,0 +1,279 @@
+package ast
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+// Parse parses template source into a Template.
+//
+// Parse always returns a Template. If the source contains syntax errors,
+// they are reported as diagnostics and the Template holds whatever could
+// be recovered around them.
+func Parse(src []byte) (*Template, []Diagnostic) {
+	tree, diag := parse(src, nil)
+	if tree == nil {
+		return &Template{Source: src}, []Diagnostic{diag}
+	}
+	return fromTree(tree, src)
+}
+
+// parsers is shared by every Parse and Edit, so that templates aren't
+// each parsed with a new parser.
+var parsers = doctemplate.NewParserPool(0)
+
+// parse parses src, reusing oldTree if it is not nil. If the grammar
+// cannot be loaded it returns a nil tree and a diagnostic saying why.
+func parse(src []byte, oldTree *tree_sitter.Tree) (*tree_sitter.Tree, Diagnostic) {
+	tree, err := parsers.Parse(context.Background(), src, oldTree)
+	if err != nil {
+		return nil, Diagnostic{
+			Severity: SeverityError,
+			Code:     "Q-10-1",
+			Message:  err.Error(),
+		}
+	}
+	return tree, Diagnostic{}
+}
+
+// fromTree converts a tree-sitter parse tree of src into a Template that
+// takes ownership of the tree.
+func fromTree(tree *tree_sitter.Tree, src []byte) (*Template, []Diagnostic) {
+	root := tree.RootNode()
+	c := converter{src: src}
+	if root.HasError() {
+		c.collectSyntaxErrors(root)
+	}
+	return &Template{Nodes: c.content(root), Source: src, tree: tree}, c.diagnostics
+}
+
+type converter struct {
+	src         []byte
+	diagnostics []Diagnostic
+}
+
+func (c *converter) text(n *tree_sitter.Node) string {
+	return n.Utf8Text(c.src)
+}
+
+// collectSyntaxErrors reports every outermost ERROR node and every
+// MISSING node below n.
+func (c *converter) collectSyntaxErrors(n *tree_sitter.Node) {
+	switch {
+	case n.IsError():
+		text, _, _ := strings.Cut(c.text(n), "\n")
+		c.diagnostics = append(c.diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Code:     "Q-10-1",
+			Message:  "unexpected " + strconv.Quote(text),
+			Span:     n.Range(),
+		})
+		return
+	case n.IsMissing():
+		c.diagnostics = append(c.diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Code:     "Q-10-1",
+			Message:  "missing " + strconv.Quote(n.Kind()),
+			Span:     n.Range(),
+		})
+		return
+	}
+	for i := uint(0); i < n.ChildCount(); i++ {
+		if child := n.Child(i); child.HasError() || child.IsMissing() {
+			c.collectSyntaxErrors(child)
+		}
+	}
+}
+
+// content converts the template_element children of n. Elements inside
+// ERROR nodes are recovered so that a single syntax error does not
+// discard the rest of the template.
+func (c *converter) content(n *tree_sitter.Node) []Node {
+	var nodes []Node
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case doctemplate.NodeKindTemplateElement:
+			if child.NamedChildCount() > 0 {
+				if node := c.element(child.NamedChild(0)); node != nil {
+					nodes = append(nodes, node)
+				}
+			}
+		case "ERROR":
+			nodes = append(nodes, c.content(child)...)
+		}
+	}
+	return nodes
+}
+
+func (c *converter) element(n *tree_sitter.Node) Node {
+	switch n.Kind() {
+	case doctemplate.NodeKindText:
+		return &Literal{Text: c.text(n), Span: n.Range()}
+	case doctemplate.NodeKindEscapedDollar:
+		return &Literal{Text: "$", Span: n.Range()}
+	case doctemplate.NodeKindComment:
+		return &Comment{Text: strings.TrimPrefix(c.text(n), "$--"), Span: n.Range()}
+	case doctemplate.NodeKindNesting:
+		return &Nesting{Span: n.Range()}
+	case doctemplate.NodeKindBreakableBlock:
+		return &BreakableSpace{Children: c.content(n), Span: n.Range()}
+	case doctemplate.NodeKindInterpolation:
+		return c.interpolation(n)
+	case doctemplate.NodeKindConditional:
+		return c.conditional(n)
+	case doctemplate.NodeKindForloop:
+		return c.forLoop(n)
+	case doctemplate.NodeKindPartial:
+		name, nameSpan := c.partialName(n)
+		return &Partial{Name: name, NameSpan: nameSpan, Span: n.Range()}
+	}
+	return nil
+}
+
+func (c *converter) variable(n *tree_sitter.Node) Variable {
+	return Variable{Path: strings.Split(c.text(n), "."), Span: n.Range()}
+}
+
+// partialName returns the name field of a partial or bare_partial node.
+func (c *converter) partialName(n *tree_sitter.Node) (string, Span) {
+	if name := n.ChildByFieldName(doctemplate.FieldName); name != nil {
+		return c.text(name), name.Range()
+	}
+	return "", n.Range()
+}
+
+func (c *converter) interpolation(n *tree_sitter.Node) Node {
+	var (
+		variable  *Variable
+		partial   *tree_sitter.Node
+		bare      *tree_sitter.Node
+		pipes     []Pipe
+		separator string
+	)
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case doctemplate.NodeKindVariableName:
+			v := c.variable(child)
+			variable = &v
+		case doctemplate.NodeKindPipe:
+			pipes = append(pipes, c.pipe(child))
+		case doctemplate.NodeKindLiteralSeparator:
+			separator = c.text(child)
+		case doctemplate.NodeKindPartial:
+			partial = child
+		case doctemplate.NodeKindBarePartial:
+			bare = child
+		}
+	}
+
+	switch {
+	case bare != nil:
+		name, nameSpan := c.partialName(bare)
+		return &Partial{Name: name, NameSpan: nameSpan, Pipes: pipes, Span: n.Range()}
+	case partial != nil:
+		name, nameSpan := c.partialName(partial)
+		return &Partial{
+			Name:      name,
+			NameSpan:  nameSpan,
+			Variable:  variable,
+			Separator: separator,
+			Pipes:     pipes,
+			Span:      n.Range(),
+		}
+	case variable != nil:
+		return &Interpolation{Variable: *variable, Pipes: pipes, Separator: separator, Span: n.Range()}
+	}
+	return nil
+}
+
+func (c *converter) pipe(n *tree_sitter.Node) Pipe {
+	pipe := Pipe{Span: n.Range()}
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case doctemplate.NodeKindPipeName:
+			pipe.Name = c.text(child)
+		case doctemplate.NodeKindPipeArguments:
+			pipe.Args = c.pipeArgs(child)
+		default:
+			pipe.Name = strings.TrimPrefix(child.Kind(), "pipe_")
+		}
+	}
+	return pipe
+}
+
+// pipeArgs converts the number and string children of a pipe_arguments
+// node.
+func (c *converter) pipeArgs(n *tree_sitter.Node) []PipeArg {
+	var args []PipeArg
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		arg := n.NamedChild(i)
+		switch arg.Kind() {
+		case doctemplate.NodeKindNumber:
+			width, _ := strconv.Atoi(c.text(arg))
+			args = append(args, IntArg(width))
+		case doctemplate.NodeKindString:
+			text := c.text(arg)
+			args = append(args, StringArg(text[1:len(text)-1]))
+		}
+	}
+	return args
+}
+
+// condition returns the variable tested by a conditional_condition node.
+func (c *converter) condition(n *tree_sitter.Node) Variable {
+	if n.NamedChildCount() == 0 {
+		return Variable{Span: n.Range()}
+	}
+	return c.variable(n.NamedChild(0))
+}
+
+// branch converts a branch node from its condition and body fields.
+func (c *converter) branch(n *tree_sitter.Node) Branch {
+	var branch Branch
+	if condition := n.ChildByFieldName(doctemplate.FieldCondition); condition != nil {
+		branch.Condition = c.condition(condition)
+	}
+	if body := n.ChildByFieldName(doctemplate.FieldBody); body != nil {
+		branch.Body = c.content(body)
+	}
+	return branch
+}
+
+func (c *converter) conditional(n *tree_sitter.Node) Node {
+	cond := &Conditional{Span: n.Range()}
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case doctemplate.NodeKindBranch:
+			cond.Branches = append(cond.Branches, c.branch(child))
+		case doctemplate.NodeKindConditionalElse:
+			cond.Else = c.content(child)
+		}
+	}
+	return cond
+}
+
+func (c *converter) forLoop(n *tree_sitter.Node) Node {
+	loop := &ForLoop{Span: n.Range()}
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case doctemplate.NodeKindForloopVariable:
+			loop.Variable = c.variable(child)
+		case doctemplate.NodeKindPipe:
+			loop.Pipes = append(loop.Pipes, c.pipe(child))
+		case doctemplate.NodeKindForloopContent:
+			loop.Body = c.content(child)
+		case doctemplate.NodeKindForloopSeparator:
+			loop.Separator = c.content(child)
+		}
+	}
+	return loop
+}
@@ -0,0 +1,243 @@
+package ast_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tree-sitter/tree-sitter-doctemplate/bindings/go/ast"
+)
+
+// dump renders nodes as a compact S-expression for comparison in tests.
+func dump(nodes []ast.Node) string {
+	var parts []string
+	for _, n := range nodes {
+		parts = append(parts, dumpNode(n))
+	}
+	return strings.Join(parts, " ")
+}
+
+func dumpNode(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Literal:
+		return fmt.Sprintf("%q", n.Text)
+	case *ast.Interpolation:
+		s := "(var " + n.Variable.Name() + dumpPipes(n.Pipes)
+		if n.Separator != "" {
+			s += fmt.Sprintf(" [%q]", n.Separator)
+		}
+		return s + ")"
+	case *ast.Conditional:
+		s := "(if"
+		for _, b := range n.Branches {
+			s += " (" + b.Condition.Name() + " " + dump(b.Body) + ")"
+		}
+		if n.Else != nil {
+			s += " (else " + dump(n.Else) + ")"
+		}
+		return s + ")"
+	case *ast.ForLoop:
+		s := "(for " + n.Variable.Name() + dumpPipes(n.Pipes) + " " + dump(n.Body)
+		if n.Separator != nil {
+			s += " (sep " + dump(n.Separator) + ")"
+		}
+		return s + ")"
+	case *ast.Partial:
+		s := "(partial " + n.Name
+		if n.Variable != nil {
+			s += " " + n.Variable.Name()
+		}
+		if n.Separator != "" {
+			s += fmt.Sprintf(" [%q]", n.Separator)
+		}
+		return s + dumpPipes(n.Pipes) + ")"
+	case *ast.Nesting:
+		return "(nesting)"
+	case *ast.BreakableSpace:
+		return "(breakable " + dump(n.Children) + ")"
+	case *ast.Comment:
+		return fmt.Sprintf("(comment %q)", n.Text)
+	}
+	return fmt.Sprintf("<%T>", n)
+}
+
+func dumpPipes(pipes []ast.Pipe) string {
+	s := ""
+	for _, p := range pipes {
+		s += " /" + p.Name
+		for _, arg := range p.Args {
+			switch arg := arg.(type) {
+			case ast.IntArg:
+				s += fmt.Sprintf(" %d", int(arg))
+			case ast.StringArg:
+				s += fmt.Sprintf(" %q", string(arg))
+			}
+		}
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"Hello, world!", `"Hello, world!"`},
+		{"Hello, $name$!", `"Hello, " (var name) "!"`},
+		{"${name}", `(var name)`},
+		{"$employee.salary$", `(var employee.salary)`},
+		{"costs $$5", `"costs " "$" "5"`},
+		{"$-- a comment\nafter", `(comment " a comment\n") "after"`},
+		{"$name/uppercase/length$", `(var name /uppercase /length)`},
+		{`$name/left 10 "[" "]"$`, `(var name /left 10 "[" "]")`},
+		{`$var/left 20 "x"$`, `(var var /left 20 "x")`},
+		{`${ it/right 5 }`, `(var it /right 5)`},
+		{"$it/pad 5$", `(var it /pad 5)`},
+		{"$items[, ]$", `(var items [", "])`},
+		{"$if(a)$yes$endif$", `(if (a "yes"))`},
+		{"$if(a)$A$elseif(b)$B$else$C$endif$", `(if (a "A") (b "B") (else "C"))`},
+		{"${if(a)}A${else}C${endif}", `(if (a "A") (else "C"))`},
+		{"$for(xs)$$it$$sep$, $endfor$", `(for xs (var it) (sep ", "))`},
+		{"$for(meta/pairs)$$it.key$$endfor$", `(for meta /pairs (var it.key))`},
+		{"$header()$", `(partial header)`},
+		{"${ styles.html() }", `(partial styles.html)`},
+		{"$authors:author()[, ]$", `(partial author authors [", "])`},
+		{"${ it:item() }", `(partial item it)`},
+		{"${ it : item() }", `(partial item it)`},
+		{"$~$a b$~$", `(breakable "a b")`},
+		{"$~$a $b$\n  c$~$", `(breakable "a " (var b) "\n  c")`},
+		{"$if(a)$$~$x y$~$$endif$", `(if (a (breakable "x y")))`},
+		{"a $~$b$~$ after", `"a " (breakable "b") " after"`},
+		{"${~}a b${~}.", `(breakable "a b") "."`},
+		{"$^$", `(nesting)`},
+		{"${^}", `(nesting)`},
+		{"  - $^$$body$\n", `"  - " (nesting) (var body) "\n"`},
+	}
+	for _, tt := range tests {
+		tmpl, diags := ast.Parse([]byte(tt.src))
+		if len(diags) != 0 {
+			t.Errorf("Parse(%q): unexpected diagnostics %v", tt.src, diags)
+			continue
+		}
+		if got := dump(tmpl.Nodes); got != tt.want {
+			t.Errorf("Parse(%q)\n got: %s\nwant: %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	src := "ab\n$if(x)$y$endif$"
+	tmpl, _ := ast.Parse([]byte(src))
+	cond, ok := tmpl.Nodes[1].(*ast.Conditional)
+	if !ok {
+		t.Fatalf("expected a conditional, got %T", tmpl.Nodes[1])
+	}
+	span := ast.SpanOf(cond)
+	if got := src[span.StartByte:span.EndByte]; got != "$if(x)$y$endif$" {
+		t.Errorf("conditional span covers %q", got)
+	}
+	if span.StartPoint.Row != 1 || span.StartPoint.Column != 0 {
+		t.Errorf("conditional starts at %v", span.StartPoint)
+	}
+	cspan := cond.Branches[0].Condition.Span
+	if got := src[cspan.StartByte:cspan.EndByte]; got != "x" {
+		t.Errorf("condition span covers %q", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tmpl, diags := ast.Parse([]byte("before $if(x)$ never closed"))
+	if !ast.HasErrors(diags) {
+		t.Fatalf("expected a syntax error")
+	}
+	for _, d := range diags {
+		if d.Code != "Q-10-1" {
+			t.Errorf("unexpected code %q for %v", d.Code, d)
+		}
+	}
+	if tmpl == nil {
+		t.Fatal("expected a partial template")
+	}
+}
+
+func TestParseQuartoHTMLTemplates(t *testing.T) {
+	paths, _ := filepath.Glob("../../../../test-templates/html/*")
+	if len(paths) == 0 {
+		t.Skip("no test templates found")
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl, diags := ast.Parse(src)
+		if len(diags) != 0 {
+			t.Errorf("%s: %v", path, diags)
+		}
+		checkSpansCoverSource(t, path, tmpl)
+	}
+}
+
+// checkSpansCoverSource checks that the top-level nodes of tmpl cover its
+// source without gaps, so that no text (in particular no whitespace) is
+// lost between the source and the AST.
+func checkSpansCoverSource(t *testing.T, name string, tmpl *ast.Template) {
+	t.Helper()
+	var end uint
+	for _, n := range tmpl.Nodes {
+		span := ast.SpanOf(n)
+		if span.StartByte != end {
+			t.Errorf("%s: bytes %d-%d (%q) are not covered by any node", name, end, span.StartByte, tmpl.Source[end:span.StartByte])
+		}
+		end = span.EndByte
+	}
+	if end != uint(len(tmpl.Source)) {
+		t.Errorf("%s: trailing %q is not covered by any node", name, tmpl.Source[end:])
+	}
+}
+
+func TestParseWhitespaceConstructs(t *testing.T) {
+	for _, src := range []string{
+		"a $~$b  $c$\n\td$~$",
+		"\t- $^$$x$\n  $y$  \n",
+		"$for(xs)$\n  $^$$it$\n$endfor$\n",
+	} {
+		tmpl, diags := ast.Parse([]byte(src))
+		if len(diags) != 0 {
+			t.Errorf("Parse(%q): %v", src, diags)
+		}
+		checkSpansCoverSource(t, fmt.Sprintf("%q", src), tmpl)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tmpl, _ := ast.Parse([]byte("$if(a)$$for(xs)$$it$$endfor$$else$$b$$endif$"))
+	var names []string
+	ast.Inspect(tmpl.Nodes, func(n ast.Node) bool {
+		if v, ok := n.(*ast.Interpolation); ok {
+			names = append(names, v.Variable.Name())
+		}
+		return true
+	})
+	if got := strings.Join(names, ","); got != "it,b" {
+		t.Errorf("visited %q", got)
+	}
+}
+
+func TestPartials(t *testing.T) {
+	src := "$header()$$if(a)$${ it : item() }$endif$$for(xs)$$xs:row()[, ]$$endfor$$name$"
+	tmpl, _ := ast.Parse([]byte(src))
+	defer tmpl.Close()
+	var names []string
+	for _, p := range ast.Partials(tmpl.Nodes) {
+		if got := src[p.NameSpan.StartByte:p.NameSpan.EndByte]; got != p.Name {
+			t.Errorf("NameSpan of %s covers %q", p.Name, got)
+		}
+		names = append(names, p.Name)
+	}
+	if got := strings.Join(names, ","); got != "header,item,row" {
+		t.Errorf("partials %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package ast
+
+import "fmt"
+
+// ArgKind is the type of a pipe argument.
+type ArgKind int
+
+const (
+	IntArgKind ArgKind = iota
+	StringArgKind
+)
+
+func (k ArgKind) String() string {
+	switch k {
+	case IntArgKind:
+		return "integer"
+	case StringArgKind:
+		return "string"
+	default:
+		return fmt.Sprintf("ArgKind(%d)", int(k))
+	}
+}
+
+// PipeSignature describes the arguments a pipe accepts.
+type PipeSignature struct {
+	// Args are the kinds of the pipe's arguments, in order.
+	Args []ArgKind
+	// Required is how many leading Args must be given; the rest are
+	// optional.
+	Required int
+}
+
+// blockSignature is the signature of left, right and center: a width and
+// up to two borders.
+var blockSignature = PipeSignature{
+	Args:     []ArgKind{IntArgKind, StringArgKind, StringArgKind},
+	Required: 1,
+}
+
+// PipeSignatures lists the pipes supported by Pandoc templates, along with
+// `pad`, which takes a width. The grammar parses any pipe name with any
+// arguments, so these signatures are what linting checks them against.
+var PipeSignatures = map[string]PipeSignature{
+	"pairs":      {},
+	"uppercase":  {},
+	"lowercase":  {},
+	"length":     {},
+	"reverse":    {},
+	"first":      {},
+	"last":       {},
+	"rest":       {},
+	"allbutlast": {},
+	"chomp":      {},
+	"nowrap":     {},
+	"alpha":      {},
+	"roman":      {},
+	"left":       blockSignature,
+	"right":      blockSignature,
+	"center":     blockSignature,
+	"pad":        {Args: []ArgKind{IntArgKind}, Required: 1},
+}
+
+// CheckPipe reports a diagnostic if p is not a known pipe (Q-10-6) or
+// its arguments do not match the pipe's signature (Q-10-7).
+func CheckPipe(p Pipe) (Diagnostic, bool) {
+	sig, ok := PipeSignatures[p.Name]
+	if !ok {
+		return Diagnostic{
+			Severity: SeverityError,
+			Code:     "Q-10-6",
+			Message:  fmt.Sprintf("unknown pipe %q", p.Name),
+			Span:     p.Span,
+		}, false
+	}
+	invalid := func(format string, args ...any) (Diagnostic, bool) {
+		return Diagnostic{
+			Severity: SeverityError,
+			Code:     "Q-10-7",
+			Message:  fmt.Sprintf("pipe %q: ", p.Name) + fmt.Sprintf(format, args...),
+			Span:     p.Span,
+		}, false
+	}
+	if len(p.Args) < sig.Required || len(p.Args) > len(sig.Args) {
+		if sig.Required == len(sig.Args) {
+			return invalid("expected %d arguments, got %d", len(sig.Args), len(p.Args))
+		}
+		return invalid("expected %d to %d arguments, got %d", sig.Required, len(sig.Args), len(p.Args))
+	}
+	for i, arg := range p.Args {
+		if kind := argKind(arg); kind != sig.Args[i] {
+			return invalid("argument %d must be %s, got %s", i+1, sig.Args[i], kind)
+		}
+	}
+	return Diagnostic{}, true
+}
+
+// CheckPipes checks every pipe used in nodes with CheckPipe.
+func CheckPipes(nodes []Node) []Diagnostic {
+	var diagnostics []Diagnostic
+	check := func(pipes []Pipe) {
+		for _, p := range pipes {
+			if d, ok := CheckPipe(p); !ok {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+	}
+	Inspect(nodes, func(n Node) bool {
+		switch n := n.(type) {
+		case *Interpolation:
+			check(n.Pipes)
+		case *Partial:
+			check(n.Pipes)
+		case *ForLoop:
+			check(n.Pipes)
+		}
+		return true
+	})
+	return diagnostics
+}
+
+func argKind(arg PipeArg) ArgKind {
+	if _, ok := arg.(StringArg); ok {
+		return StringArgKind
+	}
+	return IntArgKind
+}
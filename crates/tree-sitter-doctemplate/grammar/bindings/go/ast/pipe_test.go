@@ -0,0 +1,56 @@
+package ast_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tree-sitter/tree-sitter-doctemplate/bindings/go/ast"
+)
+
+func TestCheckPipe(t *testing.T) {
+	tests := []struct {
+		pipe ast.Pipe
+		code string
+	}{
+		{ast.Pipe{Name: "uppercase"}, ""},
+		{ast.Pipe{Name: "left", Args: []ast.PipeArg{ast.IntArg(20)}}, ""},
+		{ast.Pipe{Name: "left", Args: []ast.PipeArg{ast.IntArg(20), ast.StringArg("| ")}}, ""},
+		{ast.Pipe{Name: "center", Args: []ast.PipeArg{ast.IntArg(20), ast.StringArg("<"), ast.StringArg(">")}}, ""},
+		{ast.Pipe{Name: "pad", Args: []ast.PipeArg{ast.IntArg(5)}}, ""},
+		{ast.Pipe{Name: "pad"}, "Q-10-7"},
+		{ast.Pipe{Name: "wrap", Args: []ast.PipeArg{ast.IntArg(5)}}, "Q-10-6"},
+		{ast.Pipe{Name: "uppercase", Args: []ast.PipeArg{ast.IntArg(5)}}, "Q-10-7"},
+		{ast.Pipe{Name: "right"}, "Q-10-7"},
+		{ast.Pipe{Name: "right", Args: []ast.PipeArg{ast.StringArg("x")}}, "Q-10-7"},
+	}
+	for _, tt := range tests {
+		d, ok := ast.CheckPipe(tt.pipe)
+		if ok != (tt.code == "") || d.Code != tt.code {
+			t.Errorf("CheckPipe(%v) = %v, %v; want code %q", tt.pipe, d, ok, tt.code)
+		}
+	}
+}
+
+func TestCheckPipes(t *testing.T) {
+	tmpl, diags := ast.Parse([]byte(`$for(xs)$$it/left 5 "[" "]"/uppercase$$it/pad 5$$endfor$$p()/lowercase$$var/left 20 "x"$`))
+	defer tmpl.Close()
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if diags := ast.CheckPipes(tmpl.Nodes); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+
+	tmpl, diags = ast.Parse([]byte(`$x/wrap 5$$x/uppercase 3$$x/left "a"$`))
+	defer tmpl.Close()
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	var codes []string
+	for _, d := range ast.CheckPipes(tmpl.Nodes) {
+		codes = append(codes, d.Code)
+	}
+	if want := []string{"Q-10-6", "Q-10-7", "Q-10-7"}; !slices.Equal(codes, want) {
+		t.Errorf("got codes %v, want %v", codes, want)
+	}
+}
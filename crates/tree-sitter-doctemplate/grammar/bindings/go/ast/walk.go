@@ -0,0 +1,39 @@
+package ast
+
+// Inspect traverses nodes in depth-first order, calling f for each node.
+// If f returns false, Inspect skips the node's children.
+//
+// The bodies of conditionals, loops and breakable spaces are visited;
+// the contents of partials are not, since they belong to other templates.
+func Inspect(nodes []Node, f func(Node) bool) {
+	for _, n := range nodes {
+		if !f(n) {
+			continue
+		}
+		switch n := n.(type) {
+		case *Conditional:
+			for _, branch := range n.Branches {
+				Inspect(branch.Body, f)
+			}
+			Inspect(n.Else, f)
+		case *ForLoop:
+			Inspect(n.Body, f)
+			Inspect(n.Separator, f)
+		case *BreakableSpace:
+			Inspect(n.Children, f)
+		}
+	}
+}
+
+// Partials returns the partials referenced by nodes, in source order.
+// Partials referenced from within those partials are not included.
+func Partials(nodes []Node) []*Partial {
+	var partials []*Partial
+	Inspect(nodes, func(n Node) bool {
+		if p, ok := n.(*Partial); ok {
+			partials = append(partials, p)
+		}
+		return true
+	})
+	return partials
+}
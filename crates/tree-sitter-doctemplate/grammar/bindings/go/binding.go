@@ -9,6 +9,8 @@ import "C"
 
 import "unsafe"
 
+//go:generate go run ./internal/gennodetypes
+
 // Get the tree-sitter Language for this grammar.
 func Language() unsafe.Pointer {
 	return unsafe.Pointer(C.tree_sitter_doctemplate())
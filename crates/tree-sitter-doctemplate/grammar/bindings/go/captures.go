@@ -0,0 +1,106 @@
+package tree_sitter_doctemplate
+
+import (
+	"sync"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Capture is one node captured by a query.
+type Capture struct {
+	// Name is the capture's name, without the @.
+	Name string
+	// Kind is the captured node's kind, one of the NodeKind constants or
+	// an anonymous node's text.
+	Kind      string
+	StartByte uint
+	EndByte   uint
+	// Node is the captured node. It is valid as long as the tree is.
+	Node tree_sitter.Node
+}
+
+// Text returns the captured text of source, the text the tree was parsed
+// from.
+func (c Capture) Text(source []byte) string {
+	return string(source[c.StartByte:c.EndByte])
+}
+
+// QueryCaptures runs the query querySrc on tree and returns its captures
+// in document order. source is the text the tree was parsed from, which
+// predicates such as #eq? and #match? compare with.
+//
+// Queries are compiled once per querySrc and kept for the life of the
+// program, so querySrc should be one of a fixed set of queries rather
+// than built from input.
+func QueryCaptures(tree *tree_sitter.Tree, source []byte, querySrc string) ([]Capture, error) {
+	query, err := cachedQuery(querySrc)
+	if err != nil {
+		return nil, err
+	}
+	return Captures(query, tree.RootNode(), source), nil
+}
+
+// Captures runs a compiled query on node and returns its captures in
+// document order.
+func Captures(query *tree_sitter.Query, node *tree_sitter.Node, source []byte) []Capture {
+	cursor := getCursor()
+	defer putCursor(cursor)
+
+	names := query.CaptureNames()
+	var out []Capture
+	captures := cursor.Captures(query, node, source)
+	for match, index := captures.Next(); match != nil; match, index = captures.Next() {
+		capture := match.Captures[index]
+		out = append(out, Capture{
+			Name:      names[capture.Index],
+			Kind:      capture.Node.Kind(),
+			StartByte: capture.Node.StartByte(),
+			EndByte:   capture.Node.EndByte(),
+			Node:      capture.Node,
+		})
+	}
+	return out
+}
+
+var (
+	queryCacheMu sync.Mutex
+	queryCache   = map[string]*tree_sitter.Query{}
+)
+
+// cachedQuery compiles source, or returns the query compiled from it
+// before. Compiled queries can be shared by cursors on any goroutine.
+func cachedQuery(source string) (*tree_sitter.Query, error) {
+	queryCacheMu.Lock()
+	defer queryCacheMu.Unlock()
+	if query, ok := queryCache[source]; ok {
+		return query, nil
+	}
+	query, err := NewQuery(source)
+	if err != nil {
+		return nil, err
+	}
+	queryCache[source] = query
+	return query, nil
+}
+
+// Query cursors hold C memory that must be freed with Close, so they are
+// pooled in a channel rather than a sync.Pool, which would drop them
+// without closing them.
+var cursors = make(chan *tree_sitter.QueryCursor, 8)
+
+func getCursor() *tree_sitter.QueryCursor {
+	select {
+	case cursor := <-cursors:
+		return cursor
+	default:
+		return tree_sitter.NewQueryCursor()
+	}
+}
+
+func putCursor(cursor *tree_sitter.QueryCursor) {
+	select {
+	case cursors <- cursor:
+	default:
+		cursor.Close()
+	}
+}
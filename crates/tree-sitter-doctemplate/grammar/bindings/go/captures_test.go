@@ -0,0 +1,102 @@
+package tree_sitter_doctemplate_test
+
+import (
+	"sync"
+	"testing"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+func parse(t *testing.T, source []byte) *tree_sitter.Tree {
+	t.Helper()
+	parser := tree_sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_doctemplate.Language())); err != nil {
+		t.Fatal(err)
+	}
+	return parser.Parse(source, nil)
+}
+
+func TestQueryCaptures(t *testing.T) {
+	source := []byte("$if(title)$<h1>$title$</h1>$endif$ $for(author)$$author.name$$endfor$")
+	tree := parse(t, source)
+	defer tree.Close()
+
+	captures, err := tree_sitter_doctemplate.QueryCaptures(tree, source,
+		"(interpolation (variable_name) @name) (forloop_variable) @loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type capture struct{ name, kind, text string }
+	var got []capture
+	for _, c := range captures {
+		if c.Node.StartByte() != c.StartByte || c.Node.EndByte() != c.EndByte {
+			t.Errorf("%s: byte range %d-%d is not the node's", c.Name, c.StartByte, c.EndByte)
+		}
+		got = append(got, capture{c.Name, c.Kind, c.Text(source)})
+	}
+	want := []capture{
+		{"name", tree_sitter_doctemplate.NodeKindVariableName, "title"},
+		{"loop", tree_sitter_doctemplate.NodeKindForloopVariable, "author"},
+		{"name", tree_sitter_doctemplate.NodeKindVariableName, "author.name"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("capture %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestQueryCapturesPredicates(t *testing.T) {
+	source := []byte("$title$ $author$ $title$")
+	tree := parse(t, source)
+	defer tree.Close()
+
+	captures, err := tree_sitter_doctemplate.QueryCaptures(tree, source,
+		`((variable_name) @title (#eq? @title "title"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 2 {
+		t.Errorf("expected 2 captures of title, got %v", captures)
+	}
+}
+
+func TestQueryCapturesInvalidQuery(t *testing.T) {
+	tree := parse(t, []byte("$x$"))
+	defer tree.Close()
+
+	if _, err := tree_sitter_doctemplate.QueryCaptures(tree, nil, "(no_such_node) @x"); err == nil {
+		t.Error("expected an error for an unknown node kind")
+	}
+}
+
+func TestQueryCapturesConcurrently(t *testing.T) {
+	source := []byte("$a$ $b$ $c$")
+	tree := parse(t, source)
+	defer tree.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		// Trees aren't shared between goroutines; each gets a copy
+		clone := tree.Clone()
+		go func() {
+			defer wg.Done()
+			defer clone.Close()
+			captures, err := tree_sitter_doctemplate.QueryCaptures(clone, source, "(variable_name) @v")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(captures) != 3 {
+				t.Errorf("expected 3 captures, got %d", len(captures))
+			}
+		}()
+	}
+	wg.Wait()
+}
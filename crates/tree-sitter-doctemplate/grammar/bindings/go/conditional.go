@@ -0,0 +1,49 @@
+package tree_sitter_doctemplate
+
+import (
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// ConditionalBranch is one branch of a conditional node.
+type ConditionalBranch struct {
+	// Condition is the conditional_condition node guarding the branch, or
+	// nil for the else branch.
+	Condition *tree_sitter.Node
+	// Body holds the branch's template_element nodes.
+	Body []*tree_sitter.Node
+}
+
+// ConditionalBranches returns the branches of a conditional node in
+// source order: its branch children, with their condition and body
+// fields, then the `else` branch if present. It returns nil if n is not a
+// conditional.
+func ConditionalBranches(n *tree_sitter.Node) []ConditionalBranch {
+	if n == nil || n.Kind() != NodeKindConditional {
+		return nil
+	}
+	var branches []ConditionalBranch
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		child := n.NamedChild(i)
+		switch child.Kind() {
+		case NodeKindBranch:
+			branch := ConditionalBranch{Condition: child.ChildByFieldName(FieldCondition)}
+			if body := child.ChildByFieldName(FieldBody); body != nil {
+				branch.Body = elements(body)
+			}
+			branches = append(branches, branch)
+		case NodeKindConditionalElse:
+			branches = append(branches, ConditionalBranch{Body: elements(child)})
+		}
+	}
+	return branches
+}
+
+func elements(n *tree_sitter.Node) []*tree_sitter.Node {
+	var out []*tree_sitter.Node
+	for i := uint(0); i < n.NamedChildCount(); i++ {
+		if c := n.NamedChild(i); c.Kind() == NodeKindTemplateElement {
+			out = append(out, c)
+		}
+	}
+	return out
+}
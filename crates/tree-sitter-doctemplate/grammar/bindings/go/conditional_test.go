@@ -0,0 +1,48 @@
+package tree_sitter_doctemplate_test
+
+import (
+	"testing"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+func TestConditionalBranches(t *testing.T) {
+	parser := tree_sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_doctemplate.Language())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{
+		"$if(a)$A$elseif(b)$B$elseif(c)$C$else$D$endif$",
+		"${if(a)}A${elseif(b)}B${elseif(c)}C${else}D${endif}",
+	} {
+		source := []byte(src)
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		cond := tree.RootNode().NamedChild(0).NamedChild(0)
+		branches := tree_sitter_doctemplate.ConditionalBranches(cond)
+		want := []struct{ condition, body string }{{"a", "A"}, {"b", "B"}, {"c", "C"}, {"", "D"}}
+		if len(branches) != len(want) {
+			t.Fatalf("%s: got %d branches, want %d", src, len(branches), len(want))
+		}
+		for i, b := range branches {
+			var condition string
+			if b.Condition != nil {
+				condition = b.Condition.NamedChild(0).Utf8Text(source)
+			}
+			if len(b.Body) != 1 {
+				t.Fatalf("%s: branch %d has %d body elements", src, i, len(b.Body))
+			}
+			if body := b.Body[0].Utf8Text(source); condition != want[i].condition || body != want[i].body {
+				t.Errorf("%s: branch %d = (%q, %q), want (%q, %q)", src, i, condition, body, want[i].condition, want[i].body)
+			}
+		}
+	}
+
+	if got := tree_sitter_doctemplate.ConditionalBranches(nil); got != nil {
+		t.Errorf("ConditionalBranches(nil) = %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+// Command gennodetypes generates Go constants for the named node kinds and
+// field names declared in the grammar's node-types.json.
+//
+// It is run via go:generate from the bindings/go package:
+//
+//	go generate ./bindings/go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+type nodeType struct {
+	Type   string                     `json:"type"`
+	Named  bool                       `json:"named"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+func main() {
+	input := flag.String("input", "../../src/node-types.json", "path to node-types.json")
+	output := flag.String("output", "node_types.go", "path of the generated Go file")
+	pkg := flag.String("package", "tree_sitter_doctemplate", "package name of the generated file")
+	flag.Parse()
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var types []nodeType
+	if err := json.Unmarshal(data, &types); err != nil {
+		log.Fatalf("parsing %s: %v", *input, err)
+	}
+
+	kinds := map[string]bool{}
+	fields := map[string]bool{}
+	for _, t := range types {
+		if t.Named {
+			kinds[t.Type] = true
+		}
+		for name := range t.Fields {
+			fields[name] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gennodetypes from src/node-types.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", *pkg)
+	writeConstants(&buf, "Named node kinds, as returned by Node.Kind().", "NodeKind", sorted(kinds))
+	writeConstants(&buf, "Field names, as accepted by Node.ChildByFieldName().", "Field", sorted(fields))
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated source: %v", err)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeConstants(buf *bytes.Buffer, doc, prefix string, names []string) {
+	if len(names) == 0 {
+		fmt.Fprintf(buf, "// %s\n//\n// The grammar does not currently declare any.\n\n", doc)
+	} else {
+		fmt.Fprintf(buf, "// %s\nconst (\n", doc)
+		for _, name := range names {
+			fmt.Fprintf(buf, "\t%s%s = %q\n", prefix, camelCase(name), name)
+		}
+		fmt.Fprintf(buf, ")\n\n")
+	}
+	fmt.Fprintf(buf, "// All%ss lists every %s constant.\nvar All%ss = []string{\n", prefix, prefix, prefix)
+	for _, name := range names {
+		fmt.Fprintf(buf, "\t%s%s,\n", prefix, camelCase(name))
+	}
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+func sorted(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func camelCase(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	return b.String()
+}
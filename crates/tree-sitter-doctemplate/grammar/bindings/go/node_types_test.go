@@ -0,0 +1,43 @@
+package tree_sitter_doctemplate_test
+
+import (
+	"testing"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+func TestNodeKindsExistInGrammar(t *testing.T) {
+	language := tree_sitter.NewLanguage(tree_sitter_doctemplate.Language())
+	for _, kind := range tree_sitter_doctemplate.AllNodeKinds {
+		if language.IdForNodeKind(kind, true) == 0 {
+			t.Errorf("node kind %q is not defined by the grammar", kind)
+		}
+	}
+	for _, field := range tree_sitter_doctemplate.AllFields {
+		if language.FieldIdForName(field) == 0 {
+			t.Errorf("field %q is not defined by the grammar", field)
+		}
+	}
+}
+
+func TestNodeKindsAreUpToDate(t *testing.T) {
+	language := tree_sitter.NewLanguage(tree_sitter_doctemplate.Language())
+	known := map[string]bool{}
+	for _, kind := range tree_sitter_doctemplate.AllNodeKinds {
+		known[kind] = true
+	}
+	for id := uint16(0); uint32(id) < language.NodeKindCount(); id++ {
+		if !language.NodeKindIsNamed(id) || !language.NodeKindIsVisible(id) {
+			continue
+		}
+		kind := language.NodeKindForId(id)
+		if kind == "ERROR" || known[kind] {
+			continue
+		}
+		t.Errorf("node kind %q has no constant; run go generate", kind)
+	}
+	if got, want := len(tree_sitter_doctemplate.AllFields), int(language.FieldCount()); got != want {
+		t.Errorf("got %d field constants, grammar declares %d; run go generate", got, want)
+	}
+}
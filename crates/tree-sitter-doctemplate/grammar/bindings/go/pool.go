@@ -0,0 +1,125 @@
+package tree_sitter_doctemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// ErrPoolClosed is returned by ParserPool.Parse after Close.
+var ErrPoolClosed = errors.New("doctemplate: parser pool is closed")
+
+// ParserPool keeps parsers configured with the doctemplate language for
+// reuse. A parser is not safe for concurrent use and costs C allocations
+// to create; a pool can be shared by any number of goroutines, each parse
+// taking an idle parser or creating one.
+type ParserPool struct {
+	language *tree_sitter.Language
+
+	mu     sync.Mutex
+	idle   []*tree_sitter.Parser
+	size   int
+	closed bool
+}
+
+// NewParserPool returns a pool that keeps up to size idle parsers, or
+// GOMAXPROCS parsers if size is not positive.
+func NewParserPool(size int) *ParserPool {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+	return &ParserPool{
+		language: tree_sitter.NewLanguage(Language()),
+		size:     size,
+	}
+}
+
+// Parse parses source, reusing oldTree if it is not nil. The caller owns
+// the returned tree and must Close it.
+//
+// The parse stops when ctx is done, and Parse returns ctx.Err(); tree-sitter
+// checks for cancellation as it goes, so even long parses end promptly.
+func (p *ParserPool) Parse(ctx context.Context, source []byte, oldTree *tree_sitter.Tree) (*tree_sitter.Tree, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	parser, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.put(parser)
+
+	var options *tree_sitter.ParseOptions
+	if ctx.Done() != nil {
+		options = &tree_sitter.ParseOptions{
+			// Returning true halts the parse
+			ProgressCallback: func(tree_sitter.ParseState) bool {
+				return ctx.Err() != nil
+			},
+		}
+	}
+	read := func(offset int, _ tree_sitter.Point) []byte {
+		if offset < len(source) {
+			return source[offset:]
+		}
+		return nil
+	}
+	tree := parser.ParseWithOptions(read, oldTree, options)
+	if tree == nil {
+		// A halted parser resumes the same parse next time unless reset
+		parser.Reset()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("doctemplate: parse failed")
+	}
+	return tree, nil
+}
+
+// Close closes the idle parsers. Parsers in use are closed when their
+// parse ends, and later calls to Parse fail with ErrPoolClosed.
+func (p *ParserPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, parser := range p.idle {
+		parser.Close()
+	}
+	p.idle = nil
+}
+
+func (p *ParserPool) get() (*tree_sitter.Parser, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		parser := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return parser, nil
+	}
+	p.mu.Unlock()
+
+	parser := tree_sitter.NewParser()
+	if err := parser.SetLanguage(p.language); err != nil {
+		parser.Close()
+		return nil, fmt.Errorf("failed to load template grammar: %w", err)
+	}
+	return parser, nil
+}
+
+func (p *ParserPool) put(parser *tree_sitter.Parser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.size {
+		parser.Close()
+		return
+	}
+	p.idle = append(p.idle, parser)
+}
@@ -0,0 +1,95 @@
+package tree_sitter_doctemplate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	tree_sitter_doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+func TestParserPoolParses(t *testing.T) {
+	pool := tree_sitter_doctemplate.NewParserPool(2)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree, err := pool.Parse(context.Background(), []byte("$if(x)$$x$$endif$"), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer tree.Close()
+			if root := tree.RootNode(); root.HasError() {
+				t.Errorf("unexpected syntax error: %s", root.ToSexp())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// cancelledDuringParse is a context that is done once Parse has checked it
+// on the way in
+type cancelledDuringParse struct {
+	context.Context
+	done  chan struct{}
+	calls atomic.Int32
+}
+
+func (c *cancelledDuringParse) Done() <-chan struct{} { return c.done }
+
+func (c *cancelledDuringParse) Err() error {
+	if c.calls.Add(1) > 1 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestParserPoolCancellation(t *testing.T) {
+	pool := tree_sitter_doctemplate.NewParserPool(1)
+	defer pool.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Parse(cancelled, []byte("$x$"), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled before parsing, got %v", err)
+	}
+
+	long := bytes.Repeat([]byte("$if(x)$text $x$ $endif$\n"), 100000)
+	ctx := &cancelledDuringParse{Context: context.Background(), done: make(chan struct{})}
+	if tree, err := pool.Parse(ctx, long, nil); !errors.Is(err, context.Canceled) {
+		if tree != nil {
+			tree.Close()
+		}
+		t.Fatalf("expected context.Canceled during the parse, got %v", err)
+	}
+
+	// The halted parser went back to the pool; it must parse from scratch
+	tree, err := pool.Parse(context.Background(), []byte("$y$"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if got := tree.RootNode().EndByte(); got != 3 {
+		t.Errorf("expected a tree of the new source, ending at byte 3, got %d", got)
+	}
+}
+
+func TestParserPoolClose(t *testing.T) {
+	pool := tree_sitter_doctemplate.NewParserPool(0)
+	tree, err := pool.Parse(context.Background(), []byte("$x$"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Close()
+	pool.Close()
+	if _, err := pool.Parse(context.Background(), []byte("$x$"), nil); !errors.Is(err, tree_sitter_doctemplate.ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
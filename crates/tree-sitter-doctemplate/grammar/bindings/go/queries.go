@@ -0,0 +1,46 @@
+package tree_sitter_doctemplate
+
+import (
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	"github.com/tree-sitter/tree-sitter-doctemplate/queries"
+)
+
+// HighlightsQuery returns the source of queries/highlights.scm.
+func HighlightsQuery() string {
+	return queries.Highlights
+}
+
+// InjectionsQuery returns the source of queries/injections.scm.
+func InjectionsQuery() string {
+	return queries.Injections
+}
+
+// LocalsQuery returns the source of queries/locals.scm.
+func LocalsQuery() string {
+	return queries.Locals
+}
+
+// NewQuery compiles source against the doctemplate language.
+// The caller owns the returned query and must Close it.
+func NewQuery(source string) (*tree_sitter.Query, error) {
+	query, err := tree_sitter.NewQuery(tree_sitter.NewLanguage(Language()), source)
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// NewHighlightsQuery compiles HighlightsQuery.
+func NewHighlightsQuery() (*tree_sitter.Query, error) {
+	return NewQuery(HighlightsQuery())
+}
+
+// NewInjectionsQuery compiles InjectionsQuery.
+func NewInjectionsQuery() (*tree_sitter.Query, error) {
+	return NewQuery(InjectionsQuery())
+}
+
+// NewLocalsQuery compiles LocalsQuery.
+func NewLocalsQuery() (*tree_sitter.Query, error) {
+	return NewQuery(LocalsQuery())
+}
@@ -0,0 +1,68 @@
+package tree_sitter_doctemplate_test
+
+import (
+	"testing"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_doctemplate "github.com/tree-sitter/tree-sitter-doctemplate/bindings/go"
+)
+
+func TestQueriesCompile(t *testing.T) {
+	for name, compile := range map[string]func() (*tree_sitter.Query, error){
+		"highlights": tree_sitter_doctemplate.NewHighlightsQuery,
+		"injections": tree_sitter_doctemplate.NewInjectionsQuery,
+		"locals":     tree_sitter_doctemplate.NewLocalsQuery,
+	} {
+		query, err := compile()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		query.Close()
+	}
+}
+
+func TestHighlightsCaptures(t *testing.T) {
+	parser := tree_sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_doctemplate.Language())); err != nil {
+		t.Fatal(err)
+	}
+
+	source := []byte("$-- comment\n$if(title)$<h1>$title/uppercase$</h1>$endif$")
+	tree := parser.Parse(source, nil)
+	defer tree.Close()
+
+	query, err := tree_sitter_doctemplate.NewHighlightsQuery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer query.Close()
+
+	cursor := tree_sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	got := map[string][]string{}
+	captures := cursor.Captures(query, tree.RootNode(), source)
+	for match, index := captures.Next(); match != nil; match, index = captures.Next() {
+		capture := match.Captures[index]
+		name := query.CaptureNames()[capture.Index]
+		got[name] = append(got[name], capture.Node.Utf8Text(source))
+	}
+
+	for name, want := range map[string]string{
+		"comment":          "$-- comment\n",
+		"variable":         "title",
+		"function.builtin": "uppercase",
+	} {
+		found := false
+		for _, text := range got[name] {
+			if text == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected @%s capture %q, got %q", name, want, got[name])
+		}
+	}
+}
@@ -38,51 +38,55 @@ module.exports = grammar({
     _whitespace: ($) => /[ \t]+/,
     variable_name: ($) => /[A-Za-z][A-Za-z0-9._-]*/,
     partial_array_separator: ($) => /[^$\]]+/,
-    nesting: ($) => "$^$",
-
-    pipe_left: ($) => seq("left", $._whitespace, 
-        alias(/[0-9]+/, $.n), $._whitespace, 
-        seq("\"", alias(/([^"]|\\")*/, $.leftborder), "\""), $._whitespace,
-        seq("\"", alias(/([^"]|\\")*/, $.rightborder), "\"")),
-    pipe_center: ($) => seq("center", $._whitespace, 
-        alias(/[0-9]+/, $.n), $._whitespace, 
-        seq("\"", alias(/([^"]|\\")*/, $.leftborder), "\""), $._whitespace,
-        seq("\"", alias(/([^"]|\\")*/, $.rightborder), "\"")),
-    pipe_right: ($) => seq("right", $._whitespace, 
-        alias(/[0-9]+/, $.n), $._whitespace, 
-        seq("\"", alias(/([^"]|\\")*/, $.leftborder), "\""), $._whitespace,
-        seq("\"", alias(/([^"]|\\")*/, $.rightborder), "\"")),
-
-    pipe: ($) => choice(
-      alias("pairs", $.pipe_pairs),
-      alias("first", $.pipe_first),
-      alias("last", $.pipe_last),
-      alias("rest", $.pipe_rest),
-      alias("allbutlast", $.pipe_allbutlast),
-      alias("uppercase", $.pipe_uppercase),
-      alias("lowercase", $.pipe_lowercase),
-      alias("length", $.pipe_length),
-      alias("reverse", $.pipe_reverse),
-      alias("chomp", $.pipe_chomp),
-      alias("nowrap", $.pipe_nowrap),
-      alias("alpha", $.pipe_alpha),
-      alias("roman", $.pipe_roman),
-      $.pipe_left,
-      $.pipe_center,
-      $.pipe_right
+    nesting: ($) => token(choice("$^$", "${^}")),
+
+    // Pipe arguments are numbers and quoted strings, each preceded by
+    // whitespace. The grammar accepts any arguments on any pipe; arity is
+    // checked by the linters, which know each pipe's signature.
+    pipe_arguments: ($) => repeat1(seq($._pipe_argument_separator, $._pipe_argument)),
+    _pipe_argument: ($) => choice($.number, $.string),
+    number: ($) => /[0-9]+/,
+    string: ($) => /"[^"]*"/,
+
+    pipe: ($) => seq(
+      choice(
+        alias("pairs", $.pipe_pairs),
+        alias("first", $.pipe_first),
+        alias("last", $.pipe_last),
+        alias("rest", $.pipe_rest),
+        alias("allbutlast", $.pipe_allbutlast),
+        alias("uppercase", $.pipe_uppercase),
+        alias("lowercase", $.pipe_lowercase),
+        alias("length", $.pipe_length),
+        alias("reverse", $.pipe_reverse),
+        alias("chomp", $.pipe_chomp),
+        alias("nowrap", $.pipe_nowrap),
+        alias("alpha", $.pipe_alpha),
+        alias("roman", $.pipe_roman),
+        alias("left", $.pipe_left),
+        alias("center", $.pipe_center),
+        alias("right", $.pipe_right),
+        // any other pipe, so that templates using it still parse and the
+        // linters can report it
+        alias(/[a-z]+/, $.pipe_name),
+      ),
+      optional($.pipe_arguments),
     ),
 
     partial_name: ($) => /[A-Za-z0-9/\\\/_.-]+/,
-    partial: ($) => seq($.partial_name, "()"),
+    // The name of a partial is the path of its file, relative to the
+    // template's directory. Pandoc adds the template's extension when the
+    // name has none.
+    partial: ($) => seq(field("name", $.partial_name), "()"),
 
     // we use an external _bare_partial_token to allow the lexer to cheat a bit and see if it's a bare partial with ()
-    bare_partial: ($) => seq(alias($._bare_partial_identifier, $.partial_name), "()"),
+    bare_partial: ($) => seq(field("name", alias($._bare_partial_identifier, $.partial_name)), "()"),
 
     literal_separator: ($) => /[^$\]]+/,
 
     _interpolation: ($) => choice(
       seq(w($), $.variable_name, repeat(seq("/", $.pipe)), w($), optional(seq("[", $.literal_separator, "]")), w($)),
-      seq(w($), $.variable_name, seq(":", $.partial), optional(seq("[", $.literal_separator, "]")), repeat(seq("/", $.pipe)), w($)),
+      seq(w($), $.variable_name, w($), ":", w($), $.partial, optional(seq("[", $.literal_separator, "]")), repeat(seq("/", $.pipe)), w($)),
       seq(w($), $.bare_partial, repeat(seq("/", $.pipe)), w($)),
     ),
 
@@ -92,21 +96,31 @@ module.exports = grammar({
     ),
 
     conditional_condition: ($) => seq("(", w($), $.variable_name, w($), ")"),
-    _conditional_elseif_1: $ => prec.right(seq($._keyword_elseif_1, w($), $.conditional_condition, w($), "$", $._content)),
-    _conditional_elseif_2: $ => prec.right(seq($._keyword_elseif_2, w($), $.conditional_condition, w($), "}", $._content)),
+    // Each `$if(...)$` and `$elseif(...)$` arm of a conditional is a
+    // branch, with its condition and body as fields.
+    _conditional_if_1: $ => prec.right(seq($._keyword_if_1, w($), $._conditional_branch_1)),
+    _conditional_if_2: $ => prec.right(seq($._keyword_if_2, w($), $._conditional_branch_2)),
+    _conditional_elseif_1: $ => prec.right(seq($._keyword_elseif_1, w($), $._conditional_branch_1)),
+    _conditional_elseif_2: $ => prec.right(seq($._keyword_elseif_2, w($), $._conditional_branch_2)),
+    _conditional_branch_1: $ => seq(
+      field("condition", $.conditional_condition), w($), "$",
+      field("body", alias($._content, $.conditional_then))
+    ),
+    _conditional_branch_2: $ => seq(
+      field("condition", $.conditional_condition), w($), "}",
+      field("body", alias($._content, $.conditional_then))
+    ),
 
     conditional: ($) => choice(
       seq(
-        $._keyword_if_1, w($), $.conditional_condition, w($), "$", 
-        alias($._content, $.conditional_then), 
-        repeat(alias($._conditional_elseif_1, $.conditional_elseif)),
+        alias($._conditional_if_1, $.branch),
+        repeat(alias($._conditional_elseif_1, $.branch)),
         optional(seq($._keyword_else_1, w($), "$", alias($._content, $.conditional_else))),
         $._keyword_endif_1, "$"
       ),
       seq(
-        $._keyword_if_2, w($), $.conditional_condition, w($), "}", 
-        alias($._content, $.conditional_then), 
-        repeat(alias($._conditional_elseif_2, $.conditional_elseif)),
+        alias($._conditional_if_2, $.branch),
+        repeat(alias($._conditional_elseif_2, $.branch)),
         optional(seq($._keyword_else_2, w($), "}", alias($._content, $.conditional_else))),
         $._keyword_endif_2, "}"
       )
@@ -114,23 +128,34 @@ module.exports = grammar({
 
     forloop: ($) => choice(
       seq(
-        $._keyword_for_1, w($), "(", alias($.variable_name, $.forloop_variable), ")", w($), "$",
+        $._keyword_for_1, w($), "(", alias($.variable_name, $.forloop_variable), repeat(seq("/", $.pipe)), ")", w($), "$",
         alias($._content, $.forloop_content),
         optional(seq("$", w($), "sep", w($), "$", alias($._content, $.forloop_separator))),
         $._keyword_endfor_1, w($), "$"
       ),
       seq(
-        $._keyword_for_2, w($), "(", alias($.variable_name, $.forloop_variable), ")", w($), "}",
+        $._keyword_for_2, w($), "(", alias($.variable_name, $.forloop_variable), repeat(seq("/", $.pipe)), ")", w($), "}",
         alias($._content, $.forloop_content), 
         optional(seq("${", w($), "sep", w($), "}", alias($._content, $.forloop_separator))),
         $._keyword_endfor_2, w($), "}"
       ),
     ),
 
-    breakable_block: ($) => prec.right(seq(
-      "$~$", $._content, "$~$")),
+    // A breakable block can't directly contain another, so the next `$~$`
+    // always closes it. Either form of the delimiter opens or closes one.
+    breakable_block: ($) => seq(
+      $._breakable_delimiter,
+      repeat1(alias($._breakable_element, $.template_element)),
+      $._breakable_delimiter,
+    ),
+    _breakable_delimiter: ($) => choice("$~$", "${~}"),
 
     template_element: ($) => choice(
+      $._breakable_element,
+      $.breakable_block,
+    ),
+
+    _breakable_element: ($) => choice(
       $.text,
       $.escaped_dollar,
       $.comment,
@@ -138,7 +163,6 @@ module.exports = grammar({
       $.conditional,
       $.forloop,
       $.partial,
-      $.breakable_block,
       $.nesting,
     ),
   },
@@ -156,5 +180,8 @@ module.exports = grammar({
     $._keyword_endif_1,
     $._keyword_endif_2,
     $._bare_partial_identifier,
+    // whitespace that is followed by a pipe argument, so that it isn't
+    // confused with the whitespace before the closing `$`
+    $._pipe_argument_separator,
   ]
 });
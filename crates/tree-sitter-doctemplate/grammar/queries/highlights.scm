@@ -0,0 +1,67 @@
+; Template text is passed through verbatim; it is highlighted by whatever
+; language the template produces (see injections.scm).
+
+(comment) @comment
+
+(escaped_dollar) @string.escape
+
+[
+  "$"
+  "${"
+  "}"
+  "$~$"
+  "${~}"
+  (nesting)
+] @punctuation.special
+
+[
+  "("
+  ")"
+  "()"
+  "["
+  "]"
+] @punctuation.bracket
+
+[
+  "/"
+  ":"
+] @operator
+
+"sep" @keyword
+
+(conditional_condition
+  (variable_name) @variable)
+
+(forloop_variable) @variable
+
+(interpolation
+  (variable_name) @variable)
+
+(partial_name) @function
+
+[
+  (pipe_pairs)
+  (pipe_first)
+  (pipe_last)
+  (pipe_rest)
+  (pipe_allbutlast)
+  (pipe_uppercase)
+  (pipe_lowercase)
+  (pipe_length)
+  (pipe_reverse)
+  (pipe_chomp)
+  (pipe_nowrap)
+  (pipe_alpha)
+  (pipe_roman)
+  (pipe_left)
+  (pipe_center)
+  (pipe_right)
+] @function.builtin
+
+(pipe_name) @function
+
+(number) @number
+
+(string) @string
+
+(literal_separator) @string.special
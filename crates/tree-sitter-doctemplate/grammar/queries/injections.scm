@@ -0,0 +1,6 @@
+; The literal text of a template is written in the template's output format
+; (HTML, LaTeX, Typst, ...), which the grammar cannot know. Hosts should set
+; injection.language from the template's file extension.
+
+((text) @injection.content
+  (#set! injection.combined))
@@ -0,0 +1,11 @@
+; $for(x)$ binds both `x` and `it` for the duration of the loop body.
+
+(forloop) @local.scope
+
+(forloop_variable) @local.definition
+
+(conditional_condition
+  (variable_name) @local.reference)
+
+(interpolation
+  (variable_name) @local.reference)
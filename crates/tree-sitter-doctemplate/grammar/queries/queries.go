@@ -0,0 +1,15 @@
+// Package queries embeds the tree-sitter query files shipped with the
+// doctemplate grammar. Most callers should use the accessors in the
+// bindings/go package instead.
+package queries
+
+import _ "embed"
+
+//go:embed highlights.scm
+var Highlights string
+
+//go:embed injections.scm
+var Injections string
+
+//go:embed locals.scm
+var Locals string
@@ -66,6 +66,7 @@ typedef enum {
     KEYWORD_ENDIF_1,
     KEYWORD_ENDIF_2,
     BARE_PARTIAL_IDENTIFIER,
+    PIPE_ARGUMENT_SEPARATOR,
 } TokenType;
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -113,8 +114,29 @@ static bool parse_bare_partial_identifier(TSLexer *lexer) {
     EMIT_TOKEN(BARE_PARTIAL_IDENTIFIER);
 }
 
+// Whitespace after a pipe is either the start of an argument, as in
+// `left 20`, or the whitespace before the end of the interpolation, as in
+// `${ it/left 20 }`. Only the first is a PIPE_ARGUMENT_SEPARATOR.
+static bool parse_pipe_argument_separator(TSLexer *lexer) {
+    lex_whitespace(lexer);
+    if (!lookahead_is_num(lexer) && lexer->lookahead != '"') {
+        return false;
+    }
+    EMIT_TOKEN(PIPE_ARGUMENT_SEPARATOR);
+}
+
 static bool scan(Scanner *s, TSLexer *lexer, const bool *valid_symbols) {
     (void)(s);
+    if (valid_symbols[PIPE_ARGUMENT_SEPARATOR]) {
+        if (lookahead_is_space(lexer)) {
+            return parse_pipe_argument_separator(lexer);
+        }
+        // Nothing else from here can follow a pipe, unless we are
+        // recovering from an error, when every token is valid.
+        if (!valid_symbols[KEYWORD_IF_1]) {
+            return false;
+        }
+    }
     // KEYWORD_FOR_1: "$", w($), "for"
     // KEYWORD_FOR_2: "${", w($), "for"
 
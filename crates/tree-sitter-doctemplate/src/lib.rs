@@ -24,6 +24,15 @@ pub const LANGUAGE: LanguageFn = unsafe { LanguageFn::from_raw(tree_sitter_docte
 /// [`node-types.json`]: https://tree-sitter.github.io/tree-sitter/using-parsers#static-node-types
 pub const NODE_TYPES: &str = include_str!("../grammar/src/node-types.json");
 
+/// The syntax highlighting query for the template grammar.
+pub const HIGHLIGHTS_QUERY: &str = include_str!("../grammar/queries/highlights.scm");
+
+/// The language injection query for the template grammar.
+pub const INJECTIONS_QUERY: &str = include_str!("../grammar/queries/injections.scm");
+
+/// The local-variable query for the template grammar.
+pub const LOCALS_QUERY: &str = include_str!("../grammar/queries/locals.scm");
+
 #[cfg(test)]
 mod tests {
     use super::*;
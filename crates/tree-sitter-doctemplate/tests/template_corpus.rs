@@ -0,0 +1,202 @@
+/*
+ * template_corpus.rs
+ * Copyright (c) 2025 Posit, PBC
+ *
+ * Corpus tests: the grammar must parse real templates without errors.
+ *
+ * Every template under the corpus roots is parsed. A template with an
+ * ERROR or MISSING node fails the test, unless the problem is listed in
+ * KNOWN_FAILURES, and the parse tree of each is kept as an insta snapshot,
+ * so that grammar changes that change how real templates parse show up in
+ * review.
+ *
+ * The corpus is this crate's test templates, which include the Pandoc
+ * templates quarto-cli ships (`test-templates/quarto-cli`, updated with
+ * `scripts/sync-doctemplate-corpus.sh`), and the templates pampa ships.
+ */
+
+use std::path::Path;
+use tree_sitter::{Node, Parser, Tree};
+use walkdir::WalkDir;
+
+/// The directories of templates, relative to this crate, and the prefix of
+/// their snapshot names
+const CORPUS_ROOTS: &[(&str, &str)] = &[
+    ("test-templates", ""),
+    ("../pampa/resources/templates", "pampa"),
+];
+
+/// The extensions of template files; other files (READMEs, the documents
+/// the templates are tried with) are skipped
+const TEMPLATE_EXTENSIONS: &[&str] = &["html", "tex", "latex", "typ", "template", "styles"];
+
+/// Problems the grammar is known to have with the corpus, as
+/// `template:line:column`, and why. A listed problem that no longer occurs
+/// fails the test too, so that the list is kept short.
+const KNOWN_FAILURES: &[(&str, &str)] = &[];
+
+/// A template of the corpus: its name, which is its path under its root,
+/// and its source
+struct CorpusTemplate {
+    name: String,
+    source: String,
+}
+
+fn corpus() -> Vec<CorpusTemplate> {
+    let crate_dir = Path::new(env!("CARGO_MANIFEST_DIR"));
+    let mut templates = Vec::new();
+    for (root, prefix) in CORPUS_ROOTS {
+        let root = crate_dir.join(root);
+        for entry in WalkDir::new(&root).sort_by_file_name() {
+            let entry = entry.unwrap_or_else(|e| panic!("Failed to read the corpus: {}", e));
+            let path = entry.path();
+            let is_template = path
+                .extension()
+                .and_then(|extension| extension.to_str())
+                .is_some_and(|extension| TEMPLATE_EXTENSIONS.contains(&extension));
+            if !entry.file_type().is_file() || !is_template {
+                continue;
+            }
+            let relative = path.strip_prefix(&root).unwrap().to_string_lossy();
+            let name = if prefix.is_empty() {
+                relative.to_string()
+            } else {
+                format!("{}/{}", prefix, relative)
+            };
+            let source = std::fs::read_to_string(path)
+                .unwrap_or_else(|e| panic!("Failed to read {}: {}", path.display(), e));
+            templates.push(CorpusTemplate {
+                name: name.replace('\\', "/"),
+                source,
+            });
+        }
+    }
+    templates
+}
+
+fn parse(source: &str) -> Tree {
+    let mut parser = Parser::new();
+    parser
+        .set_language(&tree_sitter_doctemplate::LANGUAGE.into())
+        .expect("Error loading doctemplate grammar");
+    parser.parse(source, None).expect("Failed to parse")
+}
+
+/// The ERROR and MISSING nodes in `node`, as `line:column: problem`
+fn problems(node: Node, found: &mut Vec<String>) {
+    if !node.has_error() {
+        return;
+    }
+    let start = node.start_position();
+    if node.is_missing() {
+        found.push(format!(
+            "{}:{}: missing {}",
+            start.row + 1,
+            start.column + 1,
+            node.kind()
+        ));
+    } else if node.is_error() {
+        let end = node.end_position();
+        found.push(format!(
+            "{}:{}: syntax error (to {}:{})",
+            start.row + 1,
+            start.column + 1,
+            end.row + 1,
+            end.column + 1
+        ));
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        problems(child, found);
+    }
+}
+
+/// The named nodes of `node` as an S-expression, one node per line, as
+/// `tree-sitter parse` prints them
+fn write_sexp(node: Node, field: Option<&str>, depth: usize, out: &mut String) {
+    let start = node.start_position();
+    let end = node.end_position();
+    out.push_str(&"  ".repeat(depth));
+    if let Some(field) = field {
+        out.push_str(field);
+        out.push_str(": ");
+    }
+    let kind = if node.is_missing() {
+        format!("MISSING {}", node.kind())
+    } else {
+        node.kind().to_string()
+    };
+    out.push_str(&format!(
+        "({} [{}, {}] - [{}, {}]",
+        kind, start.row, start.column, end.row, end.column
+    ));
+    let mut cursor = node.walk();
+    if cursor.goto_first_child() {
+        loop {
+            let child = cursor.node();
+            if child.is_named() || child.is_missing() {
+                out.push('\n');
+                write_sexp(child, cursor.field_name(), depth + 1, out);
+            }
+            if !cursor.goto_next_sibling() {
+                break;
+            }
+        }
+    }
+    out.push(')');
+}
+
+#[test]
+fn test_corpus_parses_without_errors() {
+    let templates = corpus();
+    assert!(!templates.is_empty(), "The template corpus is empty");
+
+    let mut failures = Vec::new();
+    let mut known = Vec::new();
+    for template in &templates {
+        let tree = parse(&template.source);
+        let mut found = Vec::new();
+        problems(tree.root_node(), &mut found);
+        for problem in found {
+            let problem = format!("{}:{}", template.name, problem);
+            match KNOWN_FAILURES
+                .iter()
+                .find(|(location, _)| problem.starts_with(&format!("{}:", location)))
+            {
+                Some((location, _)) => known.push(*location),
+                None => failures.push(problem),
+            }
+        }
+    }
+    assert!(
+        failures.is_empty(),
+        "{} problems parsing the template corpus:\n{}",
+        failures.len(),
+        failures.join("\n")
+    );
+    let fixed: Vec<&str> = KNOWN_FAILURES
+        .iter()
+        .map(|(location, _)| *location)
+        .filter(|location| !known.contains(location))
+        .collect();
+    assert!(
+        fixed.is_empty(),
+        "These known failures no longer occur; remove them from KNOWN_FAILURES:\n{}",
+        fixed.join("\n")
+    );
+}
+
+#[test]
+fn test_corpus_snapshots() {
+    let mut settings = insta::Settings::clone_current();
+    settings.set_snapshot_path("snapshots/corpus");
+    settings.set_prepend_module_to_snapshot(false);
+    let _guard = settings.bind_to_scope();
+
+    for template in corpus() {
+        let tree = parse(&template.source);
+        let mut sexp = String::new();
+        write_sexp(tree.root_node(), None, 0, &mut sexp);
+        insta::assert_snapshot!(template.name.replace('/', "__"), sexp);
+    }
+}
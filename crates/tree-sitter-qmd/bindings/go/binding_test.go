@@ -3,20 +3,41 @@ package tree_sitter_markdown_test
 import (
 	"testing"
 
-	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 	tree_sitter_markdown "github.com/tree-sitter-grammars/tree-sitter-markdown/bindings/go"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
-func TestCanLoadBlockGrammar(t *testing.T) {
+func TestCanLoadGrammar(t *testing.T) {
 	language := tree_sitter.NewLanguage(tree_sitter_markdown.Language())
 	if language == nil {
-		t.Errorf("Error loading Markdown block grammar")
+		t.Errorf("Error loading Markdown grammar")
 	}
 }
 
-func TestCanLoadInlineGrammar(t *testing.T) {
-	language := tree_sitter.NewLanguage(tree_sitter_markdown.InlineLanguage())
-	if language == nil {
-		t.Errorf("Error loading Markdown inline grammar")
+func TestCanParse(t *testing.T) {
+	parser := tree_sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_markdown.Language())); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := parser.Parse([]byte("# Title\n\nSome *text* with `code`.\n"), nil)
+	defer tree.Close()
+	if tree.RootNode().HasError() {
+		t.Errorf("unexpected parse error: %s", tree.RootNode().ToSexp())
+	}
+}
+
+func TestQueriesCompile(t *testing.T) {
+	for name, compile := range map[string]func() (*tree_sitter.Query, error){
+		"highlights": tree_sitter_markdown.NewHighlightsQuery,
+		"injections": tree_sitter_markdown.NewInjectionsQuery,
+	} {
+		query, err := compile()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		query.Close()
 	}
 }
@@ -8,7 +8,10 @@ import "C"
 
 import "unsafe"
 
-// Get the tree-sitter Language for the block grammar.
+// Get the tree-sitter Language for the unified grammar.
+//
+// The grammar parses both block structure and inline content into a
+// single tree; there is no separate inline language.
 func Language() unsafe.Pointer {
 	return unsafe.Pointer(C.tree_sitter_markdown())
 }
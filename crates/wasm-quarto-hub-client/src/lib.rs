@@ -17,6 +17,8 @@ pub mod c_shim;
 use std::path::Path;
 use std::sync::{Arc, OnceLock};
 
+use pampa::transforms::{SanitizeOptions, is_unsafe_metadata};
+use quarto_core::engine::CacheMode;
 use quarto_core::{
     BinaryDependencies, DocumentInfo, Format, HtmlRenderConfig, ProjectConfig, ProjectContext,
     QuartoError, RenderContext, RenderOptions, extract_format_metadata, render_qmd_to_html,
@@ -272,6 +274,10 @@ struct JsonDiagnostic {
     #[serde(skip_serializing_if = "Option::is_none")]
     problem: Option<String>,
     hints: Vec<String>,
+    /// The file the diagnostic's location is in, when it isn't the rendered
+    /// document.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    file: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     start_line: Option<u32>,
     #[serde(skip_serializing_if = "Option::is_none")]
@@ -323,6 +329,16 @@ fn diagnostic_to_json(diag: &DiagnosticMessage, ctx: &SourceContext) -> JsonDiag
         (None, None, None, None)
     };
 
+    // The rendered document is the first file of its source context; only
+    // locations in other files (includes) name their file
+    let file = diag
+        .location
+        .as_ref()
+        .and_then(|loc| loc.map_offset(0, ctx))
+        .filter(|mapped| mapped.file_id.0 != 0)
+        .and_then(|mapped| ctx.get_file(mapped.file_id))
+        .map(|source_file| source_file.path.clone());
+
     // Convert details
     let details: Vec<JsonDiagnosticDetail> = diag
         .details
@@ -386,6 +402,7 @@ fn diagnostic_to_json(diag: &DiagnosticMessage, ctx: &SourceContext) -> JsonDiag
         code: diag.code.clone(),
         problem: diag.problem.as_ref().map(|p| p.as_str().to_string()),
         hints,
+        file,
         start_line,
         start_column,
         end_line,
@@ -399,6 +416,32 @@ fn diagnostics_to_json(diags: &[DiagnosticMessage], ctx: &SourceContext) -> Vec<
     diags.iter().map(|d| diagnostic_to_json(d, ctx)).collect()
 }
 
+/// The diagnostics of a failed render.
+///
+/// Parse errors carry their own. Any other error becomes one diagnostic
+/// without a location, so that every failure reaches the preview the same
+/// way.
+fn error_diagnostics(e: &QuartoError) -> Vec<JsonDiagnostic> {
+    match e {
+        QuartoError::Parse(parse_error) => {
+            diagnostics_to_json(&parse_error.diagnostics, &parse_error.source_context)
+        }
+        _ => vec![JsonDiagnostic {
+            kind: "error".to_string(),
+            title: e.to_string(),
+            code: None,
+            problem: None,
+            hints: vec![],
+            file: None,
+            start_line: None,
+            start_column: None,
+            end_line: None,
+            end_column: None,
+            details: vec![],
+        }],
+    }
+}
+
 // ============================================================================
 // RENDERING API
 // ============================================================================
@@ -484,7 +527,9 @@ pub async fn render_qmd(path: &str) -> String {
         verbose: false,
         execute: false,
         use_freeze: false,
+        cache: CacheMode::Document,
         output_path: None,
+        sanitize: None,
     };
 
     let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(options);
@@ -521,26 +566,14 @@ pub async fn render_qmd(path: &str) -> String {
             })
             .unwrap()
         }
-        Err(e) => {
-            // Extract structured diagnostics from parse errors
-            let (error_msg, diagnostics) = match &e {
-                QuartoError::Parse(parse_error) => {
-                    let diags =
-                        diagnostics_to_json(&parse_error.diagnostics, &parse_error.source_context);
-                    (e.to_string(), Some(diags))
-                }
-                _ => (e.to_string(), None),
-            };
-
-            serde_json::to_string(&RenderResponse {
-                success: false,
-                error: Some(error_msg),
-                html: None,
-                diagnostics,
-                warnings: None,
-            })
-            .unwrap()
-        }
+        Err(e) => serde_json::to_string(&RenderResponse {
+            success: false,
+            error: Some(e.to_string()),
+            html: None,
+            diagnostics: Some(error_diagnostics(&e)),
+            warnings: None,
+        })
+        .unwrap(),
     }
 }
 
@@ -571,7 +604,9 @@ pub async fn render_qmd_content(content: &str, _template_bundle: &str) -> String
         verbose: false,
         execute: false,
         use_freeze: false,
+        cache: CacheMode::Document,
         output_path: None,
+        sanitize: None,
     };
 
     let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(options);
@@ -618,26 +653,14 @@ pub async fn render_qmd_content(content: &str, _template_bundle: &str) -> String
             })
             .unwrap()
         }
-        Err(e) => {
-            // Extract structured diagnostics from parse errors
-            let (error_msg, diagnostics) = match &e {
-                QuartoError::Parse(parse_error) => {
-                    let diags =
-                        diagnostics_to_json(&parse_error.diagnostics, &parse_error.source_context);
-                    (e.to_string(), Some(diags))
-                }
-                _ => (e.to_string(), None),
-            };
-
-            serde_json::to_string(&RenderResponse {
-                success: false,
-                error: Some(error_msg),
-                html: None,
-                diagnostics,
-                warnings: None,
-            })
-            .unwrap()
-        }
+        Err(e) => serde_json::to_string(&RenderResponse {
+            success: false,
+            error: Some(e.to_string()),
+            html: None,
+            diagnostics: Some(error_diagnostics(&e)),
+            warnings: None,
+        })
+        .unwrap(),
     }
 }
 
@@ -656,6 +679,13 @@ struct WasmRenderOptions {
     /// which adds `data-loc` attributes to HTML elements for scroll sync.
     #[serde(default)]
     source_location: bool,
+
+    /// Treat the content as untrusted.
+    ///
+    /// When true, raw HTML, scripts, shortcodes and file references are
+    /// removed (each reported as a warning), and no local files are read.
+    #[serde(default)]
+    sanitize: bool,
 }
 
 /// Render QMD content with options.
@@ -663,7 +693,7 @@ struct WasmRenderOptions {
 /// # Arguments
 /// * `content` - QMD source text
 /// * `template_bundle` - Template bundle JSON (currently unused, reserved for future use)
-/// * `options_json` - Options JSON: `{"source_location": true}`
+/// * `options_json` - Options JSON: `{"source_location": true, "sanitize": true}`
 ///
 /// # Returns
 /// JSON: `{ "success": true, "html": "..." }` or `{ "success": false, "error": "...", "diagnostics": [...] }`
@@ -700,14 +730,21 @@ pub async fn render_qmd_content_with_options(
 
     // Extract format metadata from frontmatter (e.g., toc, toc-depth)
     // This matches the native CLI behavior for feature parity.
-    let format_metadata = extract_format_metadata(content, "html").unwrap_or_default();
+    let mut format_metadata = extract_format_metadata(content, "html").unwrap_or_default();
+    if wasm_options.sanitize
+        && let Some(fields) = format_metadata.as_object_mut()
+    {
+        fields.retain(|key, _| !is_unsafe_metadata(key));
+    }
     let format = Format::html().with_metadata(format_metadata);
 
     let options = RenderOptions {
         verbose: false,
         execute: false,
         use_freeze: false,
+        cache: CacheMode::Document,
         output_path: None,
+        sanitize: wasm_options.sanitize.then(SanitizeOptions::default),
     };
 
     let mut ctx = RenderContext::new(&project, &doc, &format, &binaries).with_options(options);
@@ -752,26 +789,14 @@ pub async fn render_qmd_content_with_options(
             })
             .unwrap()
         }
-        Err(e) => {
-            // Extract structured diagnostics from parse errors
-            let (error_msg, diagnostics) = match &e {
-                QuartoError::Parse(parse_error) => {
-                    let diags =
-                        diagnostics_to_json(&parse_error.diagnostics, &parse_error.source_context);
-                    (e.to_string(), Some(diags))
-                }
-                _ => (e.to_string(), None),
-            };
-
-            serde_json::to_string(&RenderResponse {
-                success: false,
-                error: Some(error_msg),
-                html: None,
-                diagnostics,
-                warnings: None,
-            })
-            .unwrap()
-        }
+        Err(e) => serde_json::to_string(&RenderResponse {
+            success: false,
+            error: Some(e.to_string()),
+            html: None,
+            diagnostics: Some(error_diagnostics(&e)),
+            warnings: None,
+        })
+        .unwrap(),
     }
 }
 
@@ -2054,6 +2079,7 @@ pub fn parse_qmd_content(content: &str) -> String {
             let mut buf = Vec::new();
             let config = JsonConfig {
                 include_inline_locations: false,
+                include_block_ids: false,
             };
             match write_with_config(&pandoc, &context, &mut buf, &config) {
                 Ok(_) => {
@@ -2095,6 +2121,53 @@ pub fn parse_qmd_content(content: &str) -> String {
     }
 }
 
+#[derive(Serialize)]
+struct BlockDiffResponse {
+    success: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    diff: Option<pampa::ast_diff::LocatedDocumentDiff>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+/// Diff the blocks of two versions of a QMD document.
+///
+/// Blocks are given by their `data-loc` in each version, so the preview can
+/// patch the old HTML into the new one in place instead of reloading it.
+///
+/// # Arguments
+/// * `before` - QMD source of the rendered version
+/// * `after` - QMD source of the new version
+///
+/// # Returns
+/// JSON: `{ "success": true, "diff": { "meta_changed": false, "blocks": [...] } }`
+/// or `{ "success": false, "error": "..." }`
+#[wasm_bindgen]
+pub fn diff_qmd_blocks(before: &str, after: &str) -> String {
+    use pampa::ast_diff::{diff_documents, locate};
+    use pampa::wasm_entry_points::qmd_to_pandoc;
+
+    let response = match (
+        qmd_to_pandoc(before.as_bytes()),
+        qmd_to_pandoc(after.as_bytes()),
+    ) {
+        (Ok((old, old_context)), Ok((new, new_context))) => {
+            let diff = diff_documents(&old, &new);
+            BlockDiffResponse {
+                success: true,
+                diff: Some(locate(&diff, &old, &old_context, &new, &new_context)),
+                error: None,
+            }
+        }
+        (Err(errors), _) | (_, Err(errors)) => BlockDiffResponse {
+            success: false,
+            diff: None,
+            error: Some(errors.join("\n")),
+        },
+    };
+    serde_json::to_string(&response).unwrap()
+}
+
 /// Convert a Pandoc JSON AST back to QMD source text.
 ///
 /// This is the WASM equivalent of `pampa -f json -t qmd`.
@@ -2238,6 +2311,202 @@ pub fn incremental_write_qmd(original_qmd: &str, new_ast_json: &str) -> String {
     }
 }
 
+// ============================================================================
+// BYTES READ/WRITE API
+// ============================================================================
+//
+// `read_qmd` and `write_qmd` take and return bytes rather than strings. The
+// input `Uint8Array` is copied into WASM memory once, without UTF-16
+// conversion, and the returned `Uint8Array` is a copy in its own
+// `ArrayBuffer`, which the caller can transfer to or from a worker without
+// holding on to WASM memory. The returned bytes are a UTF-8 JSON response;
+// the typed wrapper is `src/wasm-js-bridge/qmd.ts` in hub-client.
+
+/// Options for `read_qmd`, parsed from JSON.
+#[derive(Deserialize)]
+#[serde(default)]
+struct ReadQmdOptions {
+    /// Resolve the source location of every node to lines and columns,
+    /// as `pampa --sourcepos`.
+    sourcepos: bool,
+    /// Join `[^id]` references with their definitions.
+    footnotes: bool,
+    /// Smart punctuation: `--` and `---` as dashes, `...` as an ellipsis.
+    smart: bool,
+    /// Give every block a `blockId` made from its content, as
+    /// `pampa -f qmd+block_ids`.
+    block_ids: bool,
+}
+
+impl Default for ReadQmdOptions {
+    fn default() -> Self {
+        Self {
+            sourcepos: false,
+            footnotes: true,
+            smart: false,
+            block_ids: false,
+        }
+    }
+}
+
+/// Options for `write_qmd`, parsed from JSON.
+#[derive(Deserialize, Default)]
+#[serde(default)]
+struct WriteQmdOptions {
+    /// `"auto"`, `"none"` or `"preserve"` (the default)
+    wrap: Option<String>,
+    /// Line width for `"auto"` wrapping
+    columns: Option<usize>,
+    /// Write dashes and ellipses back as `---`, `--` and `...`
+    smart: bool,
+}
+
+/// Diagnostics of a failed read, which carry no source context of their own.
+fn read_failure_diagnostics(diags: &[DiagnosticMessage], content: &[u8]) -> Vec<JsonDiagnostic> {
+    let mut ctx = SourceContext::new();
+    ctx.add_file(
+        "<input>".to_string(),
+        Some(String::from_utf8_lossy(content).into_owned()),
+    );
+    diagnostics_to_json(diags, &ctx)
+}
+
+fn error_bytes(error: String, diagnostics: Option<Vec<JsonDiagnostic>>) -> Vec<u8> {
+    serde_json::to_vec(&AstResponse {
+        success: false,
+        ast: None,
+        qmd: None,
+        error: Some(error),
+        diagnostics,
+    })
+    .unwrap()
+}
+
+/// Read QMD and return the Pandoc JSON AST.
+///
+/// This is `parse_qmd_content` with options and warnings, on bytes.
+///
+/// # Arguments
+/// * `content` - UTF-8 QMD source
+/// * `options_json` - `{"sourcepos": false, "footnotes": true, "smart": false,
+///   "block_ids": false}`;
+///   missing fields take these defaults
+///
+/// # Returns
+/// UTF-8 JSON: `{ "success": true, "ast": {...}, "warnings": [...] }`
+/// or `{ "success": false, "error": "...", "diagnostics": [...] }`.
+/// Unlike `parse_qmd_content`, `ast` is the AST itself, not a JSON string.
+#[wasm_bindgen]
+pub fn read_qmd(content: &[u8], options_json: &str) -> Vec<u8> {
+    use pampa::readers::qmd::read;
+    use pampa::transforms::{resolve_footnotes, smart_punctuation};
+    use pampa::writers::json::{JsonConfig, write_with_config};
+
+    let options: ReadQmdOptions = match serde_json::from_str(options_json) {
+        Ok(options) => options,
+        Err(e) => return error_bytes(format!("Invalid options: {}", e), None),
+    };
+
+    let (mut pandoc, context, warnings) =
+        match read(content, false, "<input>", &mut std::io::sink(), true, None) {
+            Ok(result) => result,
+            Err(diags) => {
+                return error_bytes(
+                    "Failed to parse QMD".to_string(),
+                    Some(read_failure_diagnostics(&diags, content)),
+                );
+            }
+        };
+    if options.footnotes {
+        pandoc.blocks =
+            resolve_footnotes(std::mem::take(&mut pandoc.blocks), &context.source_context);
+    }
+    if options.smart {
+        pandoc.blocks = smart_punctuation(std::mem::take(&mut pandoc.blocks));
+    }
+
+    // The AST is spliced into the response as it was written, so that it
+    // isn't parsed and serialized again.
+    let warnings =
+        serde_json::to_vec(&diagnostics_to_json(&warnings, &context.source_context)).unwrap();
+    let mut buf = Vec::new();
+    buf.extend_from_slice(b"{\"success\":true,\"warnings\":");
+    buf.extend_from_slice(&warnings);
+    buf.extend_from_slice(b",\"ast\":");
+    let config = JsonConfig {
+        include_inline_locations: options.sourcepos,
+        include_block_ids: options.block_ids,
+    };
+    if let Err(diags) = write_with_config(&pandoc, &context, &mut buf, &config) {
+        return error_bytes(
+            "Failed to serialize AST to JSON".to_string(),
+            Some(diagnostics_to_json(&diags, &context.source_context)),
+        );
+    }
+    buf.push(b'}');
+    buf
+}
+
+/// Write a Pandoc JSON AST as QMD.
+///
+/// This is `ast_to_qmd` with writer options, on bytes.
+///
+/// # Arguments
+/// * `ast_json` - UTF-8 Pandoc JSON AST
+/// * `options_json` - `{"wrap": "preserve", "columns": 72, "smart": false}`;
+///   all fields are optional
+///
+/// # Returns
+/// UTF-8 JSON: `{ "success": true, "qmd": "..." }`
+/// or `{ "success": false, "error": "..." }`
+#[wasm_bindgen]
+pub fn write_qmd(ast_json: &[u8], options_json: &str) -> Vec<u8> {
+    use pampa::readers::json::read as json_read;
+    use pampa::writers::qmd::{QmdConfig, WrapMode, write_with_config};
+
+    let options: WriteQmdOptions = match serde_json::from_str(options_json) {
+        Ok(options) => options,
+        Err(e) => return error_bytes(format!("Invalid options: {}", e), None),
+    };
+    let mut config = QmdConfig {
+        smart: options.smart,
+        ..QmdConfig::default()
+    };
+    match options.wrap.as_deref() {
+        None | Some("preserve") => {}
+        Some("auto") => config.wrap = WrapMode::Auto,
+        Some("none") => config.wrap = WrapMode::None,
+        Some(other) => return error_bytes(format!("Invalid wrap mode: {}", other), None),
+    }
+    if let Some(columns) = options.columns {
+        config.columns = columns;
+    }
+
+    let (pandoc, _context) = match json_read(&mut &ast_json[..]) {
+        Ok(result) => result,
+        Err(e) => return error_bytes(format!("Failed to parse JSON AST: {}", e), None),
+    };
+    let mut buf = Vec::new();
+    match write_with_config(&pandoc, &config, &mut buf) {
+        Ok(()) => serde_json::to_vec(&AstResponse {
+            success: true,
+            ast: None,
+            qmd: Some(String::from_utf8(buf).unwrap_or_default()),
+            error: None,
+            diagnostics: None,
+        })
+        .unwrap(),
+        Err(diags) => {
+            let error_msg = diags
+                .iter()
+                .map(|d| d.to_text(None))
+                .collect::<Vec<_>>()
+                .join("\n");
+            error_bytes(format!("Failed to write QMD: {}", error_msg), None)
+        }
+    }
+}
+
 // ============================================================================
 // TEMPLATE PROCESSING
 // ============================================================================
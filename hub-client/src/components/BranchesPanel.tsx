@@ -0,0 +1,185 @@
+/**
+ * Branches Panel Component
+ *
+ * Branch view for the sidebar accordion.
+ * Lists the branches of the current file. A branch can be created from the
+ * file, edited without touching it, and merged back: the merge shows the
+ * blocks edited differently in the file and the branch, to pick a side for
+ * each before it is written.
+ */
+
+import { useState } from 'react';
+import type { FileBranch } from '../services/automergeSync';
+import { useBranches } from '../hooks/useBranches';
+import type { BranchMerge, ConflictChoice } from '../utils/branchMerge';
+import './BranchesPanel.css';
+
+export interface BranchesPanelProps {
+  /** Path of the current file, or null when none is open. */
+  filePath: string | null;
+}
+
+// What is open for the selected branch
+type BranchView =
+  | { mode: 'edit'; id: string; draft: string }
+  | { mode: 'merge'; id: string; merge: BranchMerge; choices: ConflictChoice[] };
+
+/**
+ * Branches panel for the sidebar accordion.
+ */
+export default function BranchesPanel({ filePath }: BranchesPanelProps) {
+  const { branches, create, remove, load, save, prepareMerge, merge } = useBranches(filePath);
+  const [name, setName] = useState('');
+  const [view, setView] = useState<BranchView | null>(null);
+  const [error, setError] = useState<string | null>(null);
+
+  const run = async (action: () => Promise<void>) => {
+    setError(null);
+    try {
+      await action();
+    } catch (err) {
+      setError(err instanceof Error ? err.message : String(err));
+    }
+  };
+
+  const handleCreate = () => {
+    const trimmed = name.trim();
+    if (!trimmed) return;
+    void run(async () => {
+      create(trimmed);
+      setName('');
+    });
+  };
+
+  const handleEdit = (branch: FileBranch) =>
+    run(async () => setView({ mode: 'edit', id: branch.id, draft: await load(branch.id) }));
+
+  const handlePrepareMerge = (branch: FileBranch) =>
+    run(async () => {
+      const prepared = await prepareMerge(branch.id);
+      setView({
+        mode: 'merge',
+        id: branch.id,
+        merge: prepared,
+        choices: prepared.conflicts.map(() => 'main'),
+      });
+    });
+
+  const handleDelete = (branch: FileBranch) => {
+    if (window.confirm(`Delete branch "${branch.name}"?`)) {
+      remove(branch.id);
+      if (view?.id === branch.id) setView(null);
+    }
+  };
+
+  if (!filePath) {
+    return (
+      <div className="branches-panel">
+        <div className="branches-empty">No file open</div>
+      </div>
+    );
+  }
+
+  return (
+    <div className="branches-panel">
+      <form
+        className="branches-create"
+        onSubmit={(e) => {
+          e.preventDefault();
+          handleCreate();
+        }}
+      >
+        <input
+          value={name}
+          onChange={(e) => setName(e.target.value)}
+          placeholder="New branch name"
+        />
+        <button type="submit" disabled={!name.trim()}>
+          Branch
+        </button>
+      </form>
+      {error && <div className="branches-error">{error}</div>}
+
+      {branches.length === 0 ? (
+        <div className="branches-empty">No branches</div>
+      ) : (
+        <ul className="branches-list">
+          {branches.map((branch) => (
+            <li key={branch.id} className="branch-item">
+              <span className="branch-name">{branch.name}</span>
+              <span className="branch-meta">
+                {branch.createdBy.userName}
+                {branch.mergedAt && ` · merged ${new Date(branch.mergedAt).toLocaleString()}`}
+              </span>
+              <div className="branch-actions">
+                <button onClick={() => handleEdit(branch)}>Edit</button>
+                <button onClick={() => handlePrepareMerge(branch)}>Merge</button>
+                <button onClick={() => handleDelete(branch)}>Delete</button>
+              </div>
+
+              {view?.id === branch.id && view.mode === 'edit' && (
+                <div className="branch-details">
+                  <textarea
+                    className="branch-editor"
+                    value={view.draft}
+                    onChange={(e) => setView({ ...view, draft: e.target.value })}
+                    spellCheck={false}
+                  />
+                  <div className="branch-actions">
+                    <button onClick={() => run(() => save(view.id, view.draft))}>Save</button>
+                    <button onClick={() => setView(null)}>Close</button>
+                  </div>
+                </div>
+              )}
+
+              {view?.id === branch.id && view.mode === 'merge' && (
+                <div className="branch-details">
+                  {view.merge.conflicts.length === 0 ? (
+                    <div className="branch-note">No conflicts with the file</div>
+                  ) : (
+                    <ul className="branch-conflicts">
+                      {view.merge.conflicts.map((conflict, i) => (
+                        <li key={i} className="branch-conflict">
+                          <span className="branch-conflict-kind">{conflict.kind}</span>
+                          {(['main', 'branch'] as const).map((side) => (
+                            <label key={side} className="branch-conflict-side">
+                              <input
+                                type="radio"
+                                name={`conflict-${i}`}
+                                checked={view.choices[i] === side}
+                                onChange={() => {
+                                  const choices = [...view.choices];
+                                  choices[i] = side;
+                                  setView({ ...view, choices });
+                                }}
+                              />
+                              <span>{side === 'main' ? 'File' : 'Branch'}</span>
+                              <pre>{conflict[side] ?? '(deleted)'}</pre>
+                            </label>
+                          ))}
+                        </li>
+                      ))}
+                    </ul>
+                  )}
+                  <div className="branch-actions">
+                    <button
+                      onClick={() =>
+                        run(async () => {
+                          await merge(view.id, view.merge.text(view.choices));
+                          setView(null);
+                        })
+                      }
+                    >
+                      Merge into file
+                    </button>
+                    <button onClick={() => setView(null)}>Cancel</button>
+                  </div>
+                </div>
+              )}
+            </li>
+          ))}
+        </ul>
+      )}
+    </div>
+  );
+}
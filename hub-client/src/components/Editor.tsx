@@ -11,13 +11,18 @@ import {
   deleteFile,
   renameFile,
   exportProjectAsZip,
+  getAccess,
 } from '../services/automergeSync';
+import type { DocumentRole } from '../services/automergeSync';
+import { createShareLink, withToken } from '../services/accessService';
 import type { Diagnostic } from '../types/diagnostic';
 import { registerIntelligenceProviders, disposeIntelligenceProviders } from '../services/monacoProviders';
 import { processFileForUpload } from '../services/resourceService';
 import { usePresence } from '../hooks/usePresence';
 import { usePreference } from '../hooks/usePreference';
 import { useIntelligence } from '../hooks/useIntelligence';
+import { useSuggestions } from '../hooks/useSuggestions';
+import type { ResolvedSuggestion } from '../services/automergeSync';
 import { diffToMonacoEdits } from '../utils/diffToMonacoEdits';
 import { diagnosticsToMarkers } from '../utils/diagnosticToMonaco';
 import Preview from './Preview';
@@ -27,6 +32,9 @@ import ShareDialog from './ShareDialog';
 import MinimalHeader from './MinimalHeader';
 import SidebarTabs from './SidebarTabs';
 import OutlinePanel from './OutlinePanel';
+import SuggestionsPanel from './SuggestionsPanel';
+import HistoryPanel from './HistoryPanel';
+import BranchesPanel from './BranchesPanel';
 import ProjectTab from './tabs/ProjectTab';
 import StatusTab from './tabs/StatusTab';
 import SettingsTab from './tabs/SettingsTab';
@@ -112,6 +120,20 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
     enableSymbols: true,
   });
 
+  // Suggestions (proposed changes) for the current file
+  const {
+    suggestions,
+    suggest,
+    accept: acceptSuggestion,
+    reject: rejectSuggestion,
+  } = useSuggestions(currentFile?.path ?? null);
+  // Latest suggest function, for the editor action registered on mount
+  const suggestRef = useRef(suggest);
+  useEffect(() => {
+    suggestRef.current = suggest;
+  }, [suggest]);
+  const suggestionDecorationsRef = useRef<string[]>([]);
+
   // Get content from fileContents map, or use default for new files
   const getContent = useCallback((file: FileEntry | null): string => {
     if (!file) return '';
@@ -155,6 +177,8 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
   // Editor drag-drop state for image insertion
   const [isEditorDragOver, setIsEditorDragOver] = useState(false);
   const pendingDropPositionRef = useRef<Monaco.IPosition | null>(null);
+  // Where to put the cursor when the editor of a newly opened file mounts
+  const pendingNavigationRef = useRef<Monaco.IPosition | null>(null);
 
   // Callback for when preview wants to change file (via link click - adds history)
   const handlePreviewFileChange = useCallback((file: FileEntry, anchor?: string) => {
@@ -320,6 +344,31 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
     // The callback uses a ref so it always returns the current file path
     registerIntelligenceProviders(monaco, () => currentFilePathRef.current);
 
+    // Suggest a replacement for the selection instead of editing it
+    editor.addAction({
+      id: 'quarto.suggestEdit',
+      label: 'Suggest Edit…',
+      contextMenuGroupId: 'navigation',
+      run: (ed) => {
+        const model = ed.getModel();
+        const selection = ed.getSelection();
+        if (!model || !selection) return;
+
+        const selected = model.getValueInRange(selection);
+        const text = window.prompt('Suggest replacing the selection with:', selected);
+        if (text === null || text === selected) return;
+        try {
+          suggestRef.current({
+            from: model.getOffsetAt(selection.getStartPosition()),
+            to: model.getOffsetAt(selection.getEndPosition()),
+            text,
+          });
+        } catch (err) {
+          console.error('Failed to suggest edit:', err);
+        }
+      },
+    });
+
     // Track editor focus state for scroll sync
     editor.onDidFocusEditorText(() => {
       editorHasFocusRef.current = true;
@@ -336,6 +385,14 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
       domNode.addEventListener('drop', handleEditorDrop);
     }
 
+    // Apply a jump to source that opened this file
+    if (pendingNavigationRef.current) {
+      editor.setPosition(pendingNavigationRef.current);
+      editor.revealLineInCenter(pendingNavigationRef.current.lineNumber);
+      editor.focus();
+      pendingNavigationRef.current = null;
+    }
+
     // Signal that editor is ready for scroll sync
     setEditorReady(true);
   };
@@ -354,6 +411,60 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
     editorRef.current.focus();
   }, []);
 
+  // Highlight the ranges of open suggestions in the editor
+  useEffect(() => {
+    const editor = editorRef.current;
+    const model = editor?.getModel();
+    if (!editor || !model || !monacoRef.current) return;
+
+    const monaco = monacoRef.current;
+    suggestionDecorationsRef.current = editor.deltaDecorations(
+      suggestionDecorationsRef.current,
+      suggestions.map((s) => {
+        const start = model.getPositionAt(s.from);
+        const end = model.getPositionAt(s.to);
+        return {
+          range: new monaco.Range(start.lineNumber, start.column, end.lineNumber, end.column),
+          options: {
+            className: s.from === s.to ? undefined : 'suggestion-range',
+            beforeContentClassName: s.from === s.to ? 'suggestion-insertion' : undefined,
+            hoverMessage: { value: `**${s.author.userName}** suggests: ${s.inserted || '(delete)'}` },
+            stickiness: monaco.editor.TrackedRangeStickiness.NeverGrowsWhenTypingAtEdges,
+          },
+        };
+      })
+    );
+  }, [suggestions, editorReady]);
+
+  // Move the editor to a suggestion from the suggestions panel
+  const handleSuggestionSelect = useCallback((suggestion: ResolvedSuggestion) => {
+    const editor = editorRef.current;
+    const model = editor?.getModel();
+    if (!editor || !model) return;
+
+    const position = model.getPositionAt(suggestion.from);
+    editor.setPosition(position);
+    editor.revealLineInCenter(position.lineNumber);
+    editor.focus();
+  }, []);
+
+  const handleSuggestionAccept = useCallback((suggestion: ResolvedSuggestion) => {
+    try {
+      acceptSuggestion(suggestion.id);
+    } catch (err) {
+      console.error('Failed to accept suggestion:', err);
+      alert(`Failed to accept suggestion: ${err instanceof Error ? err.message : String(err)}`);
+    }
+  }, [acceptSuggestion]);
+
+  const handleSuggestionReject = useCallback((suggestion: ResolvedSuggestion) => {
+    try {
+      rejectSuggestion(suggestion.id);
+    } catch (err) {
+      console.error('Failed to reject suggestion:', err);
+    }
+  }, [rejectSuggestion]);
+
   // Handle file selection from sidebar (uses replaceState - no history entry)
   const handleSelectFile = useCallback((file: FileEntry) => {
     // Don't switch to binary files in the editor
@@ -373,6 +484,26 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
     onNavigateToFile(file.path, { replace: true });
   }, [fileContents, onNavigateToFile]);
 
+  // Handle a jump to source from the preview's error overlay. A position in
+  // another file opens that file, and is applied once its editor mounts.
+  const handleNavigateToSource = useCallback((path: string | null, lineNumber: number, column: number) => {
+    const position = { lineNumber, column };
+    if (path !== null) {
+      const target = files.find((f) => f.path === path.replace(/^\/+/, ''));
+      if (!target) return;
+      if (target.path !== currentFile?.path) {
+        pendingNavigationRef.current = position;
+        handleSelectFile(target);
+        return;
+      }
+    }
+
+    if (!editorRef.current) return;
+    editorRef.current.setPosition(position);
+    editorRef.current.revealLineInCenter(lineNumber);
+    editorRef.current.focus();
+  }, [files, currentFile?.path, handleSelectFile]);
+
   // Handle opening a file in a new browser tab
   const handleOpenInNewTab = useCallback((file: FileEntry) => {
     const url = buildFullUrl({
@@ -410,6 +541,23 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
     currentFile?.path
   );
 
+  // Viewers and commenters can't edit the text (commenters suggest changes)
+  const access = getAccess();
+  const readOnly = access.role === 'viewer' || access.role === 'commenter';
+
+  // Create a share link (owners of hubs with access control)
+  const handleCreateShareLink = useCallback(
+    async (role: DocumentRole, expiresInMs: number | null) => {
+      const link = await createShareLink(project.syncServer, role, expiresInMs);
+      return buildShareableUrl(
+        project.indexDocId,
+        withToken(project.syncServer, link.token),
+        currentFile?.path
+      );
+    },
+    [project.syncServer, project.indexDocId, currentFile?.path]
+  );
+
   // Handle opening new file dialog
   // Pre-fill the filename with the current file's directory path
   const handleNewFile = useCallback(() => {
@@ -673,6 +821,24 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
                     error={intelligenceError}
                   />
                 );
+              case 'suggestions':
+                return (
+                  <SuggestionsPanel
+                    suggestions={suggestions}
+                    onSelect={handleSuggestionSelect}
+                    onAccept={handleSuggestionAccept}
+                    onReject={handleSuggestionReject}
+                  />
+                );
+              case 'history':
+                return (
+                  <HistoryPanel
+                    filePath={currentFile?.path ?? null}
+                    content={content}
+                  />
+                );
+              case 'branches':
+                return <BranchesPanel filePath={currentFile?.path ?? null} />;
               case 'project':
                 return (
                   <ProjectTab
@@ -733,6 +899,8 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
               // Prefer showing hover below the line. This prevents diagnostic popups near
               // the top of the editor from overlapping the navbar.
               hover: { above: false },
+              readOnly,
+              readOnlyMessage: { value: `This project is shared with you as a ${access.role}` },
             }}
           />
         </div>
@@ -747,6 +915,7 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
           onFileChange={handlePreviewFileChange}
           onOpenNewFileDialog={handlePreviewOpenNewFileDialog}
           onDiagnosticsChange={handleDiagnosticsChange}
+          onNavigateToSource={handleNavigateToSource}
           onWasmStatusChange={handleWasmStatusChange}
         />
       </main>
@@ -769,6 +938,8 @@ export default function Editor({ project, files, fileContents, onDisconnect, onC
       <ShareDialog
         isOpen={showShareDialog}
         shareableUrl={shareableUrl}
+        access={access}
+        onCreateLink={handleCreateShareLink}
         onClose={() => setShowShareDialog(false)}
       />
     </div>
@@ -0,0 +1,121 @@
+/**
+ * History Panel Component
+ *
+ * Version history view for the sidebar accordion.
+ * Lists the versions of the current file with their author and time.
+ * Selecting a version shows the blocks changed since; a version can be
+ * restored from there.
+ *
+ * The panel loads the history itself, so it is only walked while the
+ * section is expanded.
+ */
+
+import { useMemo, useState } from 'react';
+import type { DocumentVersion } from '../services/automergeSync';
+import { useFileHistory } from '../hooks/useFileHistory';
+import './HistoryPanel.css';
+
+export interface HistoryPanelProps {
+  /** Path of the current file, or null when none is open. */
+  filePath: string | null;
+  /** Current text of the file. */
+  content: string;
+}
+
+const CHANGE_LABELS = {
+  inserted: 'Added',
+  deleted: 'Removed',
+  changed: 'Changed',
+} as const;
+
+/**
+ * History panel for the sidebar accordion.
+ */
+export default function HistoryPanel({ filePath, content }: HistoryPanelProps) {
+  const { versions, changesSince, restore } = useFileHistory(filePath, content);
+  const [selected, setSelected] = useState<DocumentVersion | null>(null);
+
+  // The selection is by heads: the list is rebuilt as the file is edited
+  const selectedKey = selected?.heads.join(',') ?? null;
+  const current = versions.find((v) => v.heads.join(',') === selectedKey) ?? null;
+  const changes = useMemo(
+    () => (current ? changesSince(current) : []),
+    [current, changesSince]
+  );
+
+  const handleRestore = (version: DocumentVersion) => {
+    if (window.confirm('Restore this version? Later versions are kept in the history.')) {
+      restore(version);
+      setSelected(null);
+    }
+  };
+
+  if (versions.length === 0) {
+    return (
+      <div className="history-panel">
+        <div className="history-empty">No history</div>
+      </div>
+    );
+  }
+
+  return (
+    <div className="history-panel">
+      <ul className="history-list">
+        {versions.map((version, index) => {
+          const key = version.heads.join(',');
+          const isSelected = key === selectedKey;
+          return (
+            <li key={key} className={`history-item${isSelected ? ' selected' : ''}`}>
+              <button
+                className="history-button"
+                onClick={() => setSelected(isSelected ? null : version)}
+                title="Show changes since this version"
+              >
+                <span className="history-author">{version.author?.userName ?? 'Unknown'}</span>
+                <span className="history-time">
+                  {version.endTime ? new Date(version.endTime).toLocaleString() : 'Unknown time'}
+                  {' · '}
+                  {version.changeCount === 1 ? '1 edit' : `${version.changeCount} edits`}
+                </span>
+              </button>
+              {isSelected && index > 0 && (
+                <div className="history-details">
+                  <div className="history-details-title">Changes since this version</div>
+                  {changes.length === 0 ? (
+                    <div className="history-no-changes">No changes to the content</div>
+                  ) : (
+                    <ul className="history-changes">
+                      {changes.map((change, i) => (
+                        <li key={i} className={`history-change ${change.op}`}>
+                          <span className="history-change-label">
+                            {CHANGE_LABELS[change.op]} {change.kind}
+                          </span>
+                          {change.previousText !== undefined && (
+                            <del className="history-change-text">{change.previousText}</del>
+                          )}
+                          {change.op === 'deleted' ? (
+                            <del className="history-change-text">{change.text}</del>
+                          ) : (
+                            <ins className="history-change-text">{change.text}</ins>
+                          )}
+                        </li>
+                      ))}
+                    </ul>
+                  )}
+                  <div className="history-actions">
+                    <button onClick={() => handleRestore(version)}>Restore</button>
+                  </div>
+                </div>
+              )}
+              {isSelected && index === 0 && (
+                <div className="history-details">
+                  <div className="history-no-changes">This is the current version</div>
+                </div>
+              )}
+            </li>
+          );
+        })}
+      </ul>
+    </div>
+  );
+}
@@ -2,11 +2,15 @@ import { useRef, useEffect, useCallback, useImperativeHandle } from 'react';
 import type { Ref } from 'react';
 import morphdom from 'morphdom';
 import { postProcessIframe } from '../utils/iframePostProcessor';
+import { createPatchOptions, type BlockDiff } from '../utils/blockPatch';
+import { markCommentedBlocks } from '../utils/commentMarkers';
+import { getCommentThreadsForBlock, onCommentsChange } from '../services/automergeSync';
 
 // Methods exposed via ref
 export interface MorphIframeHandle {
   scrollToLine: (line: number) => void;
   getScrollRatio: () => number | null;
+  getTopVisibleLine: () => number | null;
   setSelection: (startPos: SourceLocation, endPos: SourceLocation) => void;
   clearSelection: () => void;
 }
@@ -14,6 +18,9 @@ export interface MorphIframeHandle {
 interface MorphIframeProps {
   // HTML content to render - component handles morphing automatically
   html: string;
+  // Block diff from the source of the current HTML to the source of `html`,
+  // for patching only the blocks that changed (null to morph by position)
+  blockDiff?: BlockDiff | null;
   // Current file path for resolving relative links
   currentFilePath: string;
   // Callback when user navigates to a different document (with optional anchor)
@@ -21,8 +28,9 @@ interface MorphIframeProps {
   onNavigateToDocument: (targetPath: string, anchor: string | null) => void;
   // Optional callback when preview is scrolled
   onScroll?: () => void;
-  // Optional callback when preview is clicked
-  onClick?: () => void;
+  // Optional callback when preview is clicked, with the source position of
+  // the click when it maps to one (see sourcePositionOfClick)
+  onClick?: (position: SourcePosition | null) => void;
   // Optional callback when selection changes in preview
   onSelectionChange?: (startPos: SourceLocation | null, endPos: SourceLocation | null) => void;
   // Ref to expose imperative methods
@@ -41,6 +49,12 @@ export interface SourceLocation {
   endCol: number;
 }
 
+/** A position in the source: 1-based line and column. */
+export interface SourcePosition {
+  line: number;
+  col: number;
+}
+
 /**
  * Parse a data-loc attribute string into a SourceLocation object.
  * Returns null if the format is invalid.
@@ -90,6 +104,32 @@ function findElementForLine(
   return bestMatch;
 }
 
+/**
+ * The source line at the top of the viewport: that of the topmost element
+ * still in view, interpolated through its range when it is scrolled partly
+ * out of view. Prefers the most specific element among those at the top.
+ */
+function findTopVisibleLine(doc: Document): number | null {
+  let best: { top: number, line: number, rangeSize: number } | null = null;
+
+  for (const element of doc.querySelectorAll('[data-loc]')) {
+    const loc = parseDataLoc(element.getAttribute('data-loc') ?? '');
+    if (!loc) continue;
+
+    const rect = element.getBoundingClientRect();
+    if (rect.bottom <= 0 || rect.height === 0) continue;
+
+    const top = Math.max(rect.top, 0);
+    const rangeSize = loc.endLine - loc.startLine;
+    if (best && (top > best.top || (top === best.top && rangeSize >= best.rangeSize))) continue;
+
+    const hidden = Math.min(1, Math.max(0, -rect.top / rect.height));
+    best = { top, line: loc.startLine + Math.floor(hidden * rangeSize), rangeSize };
+  }
+
+  return best?.line ?? null;
+}
+
 /**
  * Check if an element is fully visible in the viewport.
  */
@@ -278,22 +318,49 @@ function calculateSourcePositionFromOffset(
   return { line, col };
 }
 
+/**
+ * The source position of a click: where the caret landed, mapped through
+ * the closest element with a data-loc. Null for clicks on links, clicks that
+ * end a selection (selection sync handles those), and content without
+ * source locations.
+ */
+function sourcePositionOfClick(doc: Document, event: MouseEvent): SourcePosition | null {
+  const target = event.target as Element | null;
+  if (!target?.closest || target.closest('a')) return null;
+
+  const selection = doc.getSelection();
+  if (selection && !selection.isCollapsed) return null;
+
+  const element = target.closest('[data-loc]') as HTMLElement | null;
+  const loc = element ? parseDataLoc(element.getAttribute('data-loc') ?? '') : null;
+  if (!element || !loc) return null;
+
+  const node = selection?.anchorNode;
+  if (node && node.nodeType === Node.TEXT_NODE && element.contains(node)) {
+    const refined = calculateSourcePositionFromOffset(node, selection.anchorOffset, element, loc);
+    if (refined) return refined;
+  }
+  return { line: loc.startLine, col: loc.startCol };
+}
+
 /**
  * Morph-based iframe component for seamless updates.
  *
  * Uses morphdom to update the iframe's content in-place, preserving:
  * - Scroll position
- * - DOM state (expanded/collapsed elements, etc.)
+ * - DOM state (open details, playing videos, etc.)
  * - Better performance for small changes
  *
  * When new HTML arrives:
  * 1. Saves current scroll position
- * 2. Uses morphdom to morph the iframe's document into the new HTML
+ * 2. Uses morphdom to morph the iframe's document into the new HTML,
+ *    patching only the blocks in `blockDiff` that changed (see blockPatch)
  * 3. Post-processes the updated content (CSS, link handlers, etc.)
  * 4. Restores scroll position
  */
 function MorphIframe({
   html,
+  blockDiff = null,
   currentFilePath,
   onNavigateToDocument,
   onScroll,
@@ -328,12 +395,30 @@ function MorphIframe({
     [scrollToAnchor, onNavigateToDocument]
   );
 
+  // Mark the blocks that have comment threads
+  const markComments = useCallback((doc: Document) => {
+    markCommentedBlocks(doc, (range) => getCommentThreadsForBlock(currentFilePath, range));
+  }, [currentFilePath]);
+
   const internalPostProcess = useCallback((iframe: HTMLIFrameElement) => {
     postProcessIframe(iframe, {
       currentFilePath,
       onQmdLinkClick: handleQmdLinkClick,
     });
-  }, [currentFilePath, handleQmdLinkClick]);
+    if (iframe.contentDocument) {
+      markComments(iframe.contentDocument);
+    }
+  }, [currentFilePath, handleQmdLinkClick, markComments]);
+
+  // Threads can change without the preview being rendered again
+  useEffect(() => {
+    return onCommentsChange((path) => {
+      const doc = iframeRef.current?.contentDocument;
+      if (path === currentFilePath && doc && isInitializedRef.current) {
+        markComments(doc);
+      }
+    });
+  }, [currentFilePath, markComments]);
 
   // Update iframe content when HTML changes
   useEffect(() => {
@@ -372,7 +457,7 @@ function MorphIframe({
 
       // Morph the document's documentElement
       // This updates both <head> and <body> efficiently
-      morphdom(doc.documentElement, tempContainer);
+      morphdom(doc.documentElement, tempContainer, createPatchOptions(tempContainer, blockDiff));
 
       // Post-process after morphing
       internalPostProcess(iframe);
@@ -383,7 +468,7 @@ function MorphIframe({
         win.scrollTo(scrollPos.x, scrollPos.y);
       });
     }
-  }, [html, internalPostProcess]);
+  }, [html, blockDiff, internalPostProcess]);
 
   // Expose methods via ref
   useImperativeHandle(ref, () => ({
@@ -418,6 +503,10 @@ function MorphIframe({
 
       return previewScrollY / previewMaxScroll;
     },
+    getTopVisibleLine: () => {
+      const doc = iframeRef.current?.contentDocument;
+      return doc ? findTopVisibleLine(doc) : null;
+    },
     setSelection: (startPos: SourceLocation, endPos: SourceLocation) => {
       const iframe = iframeRef.current;
       const doc = iframe?.contentDocument;
@@ -528,8 +617,9 @@ function MorphIframe({
       onScroll?.();
     };
 
-    const handleClick = () => {
-      onClick?.();
+    const handleClick = (event: MouseEvent) => {
+      const doc = iframe.contentDocument;
+      onClick?.(doc ? sourcePositionOfClick(doc, event) : null);
     };
 
     const handleSelectionChange = () => {
@@ -540,6 +630,8 @@ function MorphIframe({
 
       const selection = doc.getSelection();
       if (!selection || selection.rangeCount === 0) return;
+      // A caret is a click, which moves the editor's cursor instead
+      if (selection.isCollapsed) return;
 
       // Get anchor and focus nodes with their offsets
       const anchorNode = selection.anchorNode;
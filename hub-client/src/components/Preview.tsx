@@ -3,12 +3,12 @@ import type * as Monaco from 'monaco-editor';
 import type { FileEntry } from '../types/project';
 import { isQmdFile } from '../types/project';
 import type { Diagnostic } from '../types/diagnostic';
-import { initWasm, renderToHtml, isWasmReady } from '../services/wasmRenderer';
+import { initWasm, renderToHtml, isWasmReady, diffQmdBlocks } from '../services/wasmRenderer';
 import { useScrollSync } from '../hooks/useScrollSync';
 import { useSelectionSync } from '../hooks/useSelectionSync';
-import { stripAnsi } from '../utils/stripAnsi';
 import { PreviewErrorOverlay } from './PreviewErrorOverlay';
 import MorphIframe, { type MorphIframeHandle } from './MorphIframe';
+import type { BlockDiff } from '../utils/blockPatch';
 
 // Preview pane state machine:
 // START: Initial blank page
@@ -34,6 +34,9 @@ interface PreviewProps {
   onFileChange: (file: FileEntry, anchor?: string) => void;
   onOpenNewFileDialog: (initialFilename: string) => void;
   onDiagnosticsChange: (diagnostics: Diagnostic[]) => void;
+  // Move the editor to a source position (1-based), in another file when
+  // path is given
+  onNavigateToSource?: (path: string | null, line: number, column: number) => void;
   onWasmStatusChange?: (status: 'loading' | 'ready' | 'error', error: string | null) => void;
 }
 
@@ -77,17 +80,9 @@ function renderFallback(content: string, message: string): string {
   `;
 }
 
-// Error display HTML
-function renderError(content: string, error: string, diagnostics?: string[]): string {
-  // Strip ANSI codes and escape HTML
-  const cleanError = stripAnsi(error)
-    .replace(/</g, '&lt;')
-    .replace(/>/g, '&gt;');
-
-  const diagHtml = diagnostics?.length
-    ? `<ul>${diagnostics.map(d => `<li>${stripAnsi(d).replace(/</g, '&lt;').replace(/>/g, '&gt;')}</li>`).join('')}</ul>`
-    : '';
-
+// Error display HTML, shown under the error overlay (which lists the errors)
+// when nothing has rendered yet
+function renderError(content: string): string {
   return `
     <html>
       <head>
@@ -114,22 +109,11 @@ function renderError(content: string, error: string, diagnostics?: string[]): st
             border-radius: 4px;
             margin-bottom: 16px;
           }
-          .error-message {
-            font-family: 'SF Mono', Monaco, 'Cascadia Code', 'Fira Code', monospace;
-            font-size: 13px;
-            line-height: 1.4;
-            white-space: pre;
-            overflow-x: auto;
-            margin-top: 8px;
-          }
-          .error ul { margin: 8px 0 0 0; padding-left: 20px; }
         </style>
       </head>
       <body>
         <div class="error">
-          <strong>Render Error</strong>
-          <div class="error-message">${cleanError}</div>
-          ${diagHtml}
+          <strong>Render Error</strong>: the document could not be rendered.
         </div>
         <pre><code>${content.replace(/</g, '&lt;').replace(/>/g, '&gt;')}</code></pre>
       </body>
@@ -270,6 +254,7 @@ export default function Preview({
   onFileChange,
   onOpenNewFileDialog,
   onDiagnosticsChange,
+  onNavigateToSource,
   onWasmStatusChange,
 }: PreviewProps) {
   const [wasmStatus, setWasmStatus] = useState<'loading' | 'ready' | 'error'>('loading');
@@ -294,8 +279,20 @@ export default function Preview({
   // Ref to MorphIframe to access its imperative methods
   const doubleBufferedIframeRef = useRef<MorphIframeHandle>(null);
 
-  // Rendered HTML to display in iframe
-  const [renderedHtml, setRenderedHtml] = useState<string>('');
+  // Rendered HTML to display in iframe, with the block diff from the
+  // previously displayed render when there is one
+  const [rendered, setRendered] = useState<{ html: string; blockDiff: BlockDiff | null }>({
+    html: '',
+    blockDiff: null,
+  });
+  // Source of the displayed render, when it was a successful render with
+  // source locations (block diffs are keyed by them)
+  const displayedContentRef = useRef<string | null>(null);
+  // Display HTML that isn't a render of the document
+  const setRenderedHtml = useCallback((html: string) => {
+    displayedContentRef.current = null;
+    setRendered({ html, blockDiff: null });
+  }, []);
 
   // Debounce rendering
   const renderTimeoutRef = useRef<number | null>(null);
@@ -354,6 +351,9 @@ export default function Preview({
     getPreviewScrollRatio: () => {
       return doubleBufferedIframeRef.current?.getScrollRatio() ?? null;
     },
+    getPreviewTopLine: () => {
+      return doubleBufferedIframeRef.current?.getTopVisibleLine() ?? null;
+    },
     enabled: scrollSyncEnabled && editorReady,
     editorHasFocusRef,
   });
@@ -365,6 +365,13 @@ export default function Preview({
     enabled: scrollSyncEnabled && editorReady,
   });
 
+  // Jump the editor to a diagnostic from the error overlay
+  const handleNavigateToDiagnostic = useCallback((diagnostic: Diagnostic) => {
+    if (diagnostic.start_line == null) return;
+    const path = diagnostic.file && diagnostic.file !== currentFile?.path ? diagnostic.file : null;
+    onNavigateToSource?.(path, diagnostic.start_line, diagnostic.start_column ?? 1);
+  }, [currentFile?.path, onNavigateToSource]);
+
   // Render function that uses WASM when available
   // Implements state machine transitions for error handling:
   // - On success: always transition to GOOD, swap to new content
@@ -386,22 +393,27 @@ export default function Preview({
     if (result.success) {
       // Success: transition to GOOD state from any state
       setPreviewState('GOOD');
-      // Update rendered HTML
-      setRenderedHtml(result.html);
+      // Update rendered HTML, patching the blocks that changed
+      const previous = displayedContentRef.current;
+      const blockDiff = scrollSyncEnabled && previous !== null && isWasmReady()
+        ? diffQmdBlocks(previous, qmdContent)
+        : null;
+      setRendered({ html: result.html, blockDiff });
+      displayedContentRef.current = scrollSyncEnabled && isWasmReady() ? qmdContent : null;
     } else {
       // Set current error for overlay
       const currentState = previewStateRef.current;
       if (currentState === 'START' || currentState === 'ERROR_AT_START') {
         // No good render yet - show full error page
         setPreviewState('ERROR_AT_START');
-        setRenderedHtml(renderError(qmdContent, result.error));
+        setRenderedHtml(renderError(qmdContent));
       } else {
         // Was GOOD or ERROR_FROM_GOOD - keep last good HTML, show overlay
         // DON'T update HTML content
         setPreviewState('ERROR_FROM_GOOD');
       }
     }
-  }, [scrollSyncEnabled, onDiagnosticsChange]);
+  }, [scrollSyncEnabled, onDiagnosticsChange, setRenderedHtml]);
 
   // Debounced render update
   const updatePreview = useCallback((newContent: string, documentPath?: string) => {
@@ -429,12 +441,13 @@ export default function Preview({
     // Pass document path as-is from Automerge (e.g., "index.qmd" or "docs/index.qmd").
     // The WASM layer will use VFS path normalization to resolve relative paths correctly.
     updatePreview(content, filePath);
-  }, [content, updatePreview, wasmStatus, scrollSyncEnabled, currentFile?.path, onDiagnosticsChange]);
+  }, [content, updatePreview, wasmStatus, scrollSyncEnabled, currentFile?.path, onDiagnosticsChange, setRenderedHtml]);
 
   // Reset preview state when file changes
   useEffect(() => {
     setPreviewState('START');
     setCurrentError(null);
+    displayedContentRef.current = null;
   }, [currentFile?.path]);
 
   return (
@@ -447,17 +460,19 @@ export default function Preview({
       <div className="pane preview-pane">
         <MorphIframe
           ref={doubleBufferedIframeRef}
-          html={renderedHtml}
+          html={rendered.html}
+          blockDiff={rendered.blockDiff}
           currentFilePath={currentFile?.path ?? ''}
           onNavigateToDocument={handleNavigateToDocument}
           onScroll={handlePreviewScroll}
           onClick={handlePreviewClick}
           onSelectionChange={handlePreviewSelection}
         />
-        {/* Error overlay shown when error occurs after successful render */}
+        {/* Error overlay, over the last good render or the error page */}
         <PreviewErrorOverlay
           error={currentError}
-          visible={previewState === 'ERROR_FROM_GOOD'}
+          visible={previewState === 'ERROR_FROM_GOOD' || previewState === 'ERROR_AT_START'}
+          onNavigate={handleNavigateToDiagnostic}
         />
       </div>
     </>
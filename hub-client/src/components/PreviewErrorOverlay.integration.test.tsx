@@ -0,0 +1,70 @@
+/**
+ * Unit Tests for PreviewErrorOverlay Component
+ *
+ * Tests the diagnostics list and navigation to their source.
+ */
+
+import { describe, it, expect, vi } from 'vitest';
+import { render, screen, fireEvent } from '@testing-library/react';
+import { PreviewErrorOverlay } from './PreviewErrorOverlay';
+import type { Diagnostic } from '../types/diagnostic';
+
+// Render expanded, whatever is in localStorage
+vi.mock('../hooks/usePreference', () => ({
+  usePreference: () => [false, vi.fn()],
+}));
+
+function diagnostic(overrides: Partial<Diagnostic>): Diagnostic {
+  return { kind: 'error', title: 'Parse error', hints: [], details: [], ...overrides };
+}
+
+describe('PreviewErrorOverlay', () => {
+  it('navigates to a diagnostic with a location', () => {
+    const onNavigate = vi.fn();
+    const located = diagnostic({ title: 'Unclosed div', start_line: 3, start_column: 1 });
+    render(
+      <PreviewErrorOverlay
+        error={{ message: 'Parse failed', diagnostics: [located] }}
+        visible
+        onNavigate={onNavigate}
+      />
+    );
+
+    fireEvent.click(screen.getByText('Line 3:1:'));
+    expect(onNavigate).toHaveBeenCalledWith(located);
+  });
+
+  it('names the file of a diagnostic in another file', () => {
+    render(
+      <PreviewErrorOverlay
+        error={{
+          message: 'Parse failed',
+          diagnostics: [diagnostic({ file: 'chapters/intro.qmd', start_line: 7, start_column: 2 })],
+        }}
+        visible
+      />
+    );
+
+    expect(screen.getByText('chapters/intro.qmd:7:2:')).toBeInTheDocument();
+  });
+
+  it('does not navigate to a diagnostic without a location', () => {
+    const onNavigate = vi.fn();
+    render(
+      <PreviewErrorOverlay
+        error={{ message: 'Render error: boom', diagnostics: [diagnostic({ title: 'Render error: boom' })] }}
+        visible
+        onNavigate={onNavigate}
+      />
+    );
+
+    fireEvent.click(screen.getByText('Render error: boom'));
+    expect(onNavigate).not.toHaveBeenCalled();
+  });
+
+  it('shows the message when there are no diagnostics', () => {
+    render(<PreviewErrorOverlay error={{ message: 'Something failed' }} visible />);
+
+    expect(screen.getByText('Something failed')).toBeInTheDocument();
+  });
+});
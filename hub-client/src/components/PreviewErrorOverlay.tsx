@@ -5,15 +5,26 @@ import { usePreference } from '../hooks/usePreference';
 interface PreviewErrorOverlayProps {
   error: { message: string; diagnostics?: Diagnostic[] } | null;
   visible: boolean;
+  /** Called when a diagnostic with a location is clicked */
+  onNavigate?: (diagnostic: Diagnostic) => void;
 }
 
-export function PreviewErrorOverlay({ error, visible }: PreviewErrorOverlayProps) {
+// "file:line:column", or as much of it as the diagnostic has
+function formatLocation(d: Diagnostic): string | null {
+  if (d.start_line == null) return null;
+  const position = d.start_column != null ? `${d.start_line}:${d.start_column}` : `${d.start_line}`;
+  return d.file ? `${d.file}:${position}` : `Line ${position}`;
+}
+
+export function PreviewErrorOverlay({ error, visible, onNavigate }: PreviewErrorOverlayProps) {
   // Collapsed state persisted in localStorage (defaults to collapsed)
   const [collapsed, setCollapsed] = usePreference('errorOverlayCollapsed');
 
   if (!visible || !error) return null;
 
   const cleanMessage = stripAnsi(error.message);
+  const diagnostics = error.diagnostics ?? [];
+  const errorCount = diagnostics.filter((d) => d.kind === 'error').length;
 
   if (collapsed) {
     // Collapsed state: minimal indicator
@@ -24,7 +35,8 @@ export function PreviewErrorOverlay({ error, visible }: PreviewErrorOverlayProps
           onClick={() => setCollapsed(false)}
           title="Show error details"
         >
-          <span className="preview-error-icon">&#9888;</span> Error
+          <span className="preview-error-icon">&#9888;</span>
+          {errorCount > 1 ? `${errorCount} Errors` : 'Error'}
         </button>
       </div>
     );
@@ -46,16 +58,30 @@ export function PreviewErrorOverlay({ error, visible }: PreviewErrorOverlayProps
         </button>
       </div>
       <div className="preview-error-content">
-        <pre className="preview-error-message">{cleanMessage}</pre>
-        {error.diagnostics && error.diagnostics.length > 0 && (
+        {diagnostics.length === 0 ? (
+          <pre className="preview-error-message">{cleanMessage}</pre>
+        ) : (
           <ul className="preview-error-diagnostics">
-            {error.diagnostics.map((d, i) => (
-              <li key={i}>
-                {d.start_line != null && <span className="diagnostic-line">Line {d.start_line}: </span>}
-                <span className="diagnostic-title">{d.title}</span>
-                {d.problem && <span className="diagnostic-problem"> - {d.problem}</span>}
-              </li>
-            ))}
+            {diagnostics.map((d, i) => {
+              const location = formatLocation(d);
+              const navigable = location !== null && onNavigate !== undefined;
+              return (
+                <li
+                  key={i}
+                  className={`diagnostic-${d.kind}${navigable ? ' diagnostic-navigable' : ''}`}
+                  onClick={navigable ? () => onNavigate?.(d) : undefined}
+                  title={navigable ? 'Go to source' : undefined}
+                >
+                  {location && <span className="diagnostic-line">{location}: </span>}
+                  {d.code && <span className="diagnostic-code">[{d.code}] </span>}
+                  <span className="diagnostic-title">{stripAnsi(d.title)}</span>
+                  {d.problem && <span className="diagnostic-problem"> - {stripAnsi(d.problem)}</span>}
+                  {d.hints.map((hint, j) => (
+                    <div key={j} className="diagnostic-hint">{stripAnsi(hint)}</div>
+                  ))}
+                </li>
+              );
+            })}
           </ul>
         )}
       </div>
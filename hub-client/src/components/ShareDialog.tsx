@@ -2,35 +2,85 @@
  * Share Dialog Component
  *
  * Modal dialog for sharing a project with a shareable URL.
- * Displays a warning about permanent access and allows copying the link.
+ * Displays a warning about the access the link gives and allows copying it.
+ *
+ * On a hub with access control, the owner creates a share link instead:
+ * the project's own URL carries the owner token. A share link has a role
+ * and can expire.
  */
 
 import { useState, useCallback, useRef, useEffect } from 'react';
+import type { AccessInfo, DocumentRole } from '../services/automergeSync';
 import './ShareDialog.css';
 
 export interface ShareDialogProps {
   isOpen: boolean;
   shareableUrl: string;
+  /** The access of this client; full access without access control */
+  access?: AccessInfo;
+  /**
+   * Create a share link, returning its shareable URL. `expiresInMs` is
+   * null for a link that doesn't expire.
+   */
+  onCreateLink?: (role: DocumentRole, expiresInMs: number | null) => Promise<string>;
   onClose: () => void;
   onCopied?: () => void;
 }
 
+const HOUR = 60 * 60 * 1000;
+
+const ROLE_OPTIONS: { role: DocumentRole; label: string }[] = [
+  { role: 'viewer', label: 'Can view' },
+  { role: 'commenter', label: 'Can comment' },
+  { role: 'editor', label: 'Can edit' },
+];
+
+const EXPIRY_OPTIONS: { expiresInMs: number | null; label: string }[] = [
+  { expiresInMs: HOUR, label: '1 hour' },
+  { expiresInMs: 24 * HOUR, label: '1 day' },
+  { expiresInMs: 7 * 24 * HOUR, label: '7 days' },
+  { expiresInMs: null, label: 'Never' },
+];
+
 export default function ShareDialog({
   isOpen,
   shareableUrl,
+  access,
+  onCreateLink,
   onClose,
   onCopied,
 }: ShareDialogProps) {
   const [copied, setCopied] = useState(false);
   const urlInputRef = useRef<HTMLInputElement>(null);
 
-  // Reset copied state when dialog opens
+  // Owners of hubs with access control share created links only
+  const createsLinks = !!access?.accessControl && access.role === 'owner' && !!onCreateLink;
+  const [linkRole, setLinkRole] = useState<DocumentRole>('viewer');
+  const [expiry, setExpiry] = useState(1);
+  const [createdUrl, setCreatedUrl] = useState<string | null>(null);
+  const [createError, setCreateError] = useState<string | null>(null);
+  const url = createsLinks ? createdUrl ?? '' : shareableUrl;
+
+  // Reset copied state and created link when dialog opens
   useEffect(() => {
     if (isOpen) {
       setCopied(false);
+      setCreatedUrl(null);
+      setCreateError(null);
     }
   }, [isOpen]);
 
+  const handleCreateLink = useCallback(async () => {
+    if (!onCreateLink) return;
+    setCreateError(null);
+    try {
+      setCreatedUrl(await onCreateLink(linkRole, EXPIRY_OPTIONS[expiry].expiresInMs));
+      setCopied(false);
+    } catch (err) {
+      setCreateError(err instanceof Error ? err.message : String(err));
+    }
+  }, [onCreateLink, linkRole, expiry]);
+
   // Select all text when dialog opens
   useEffect(() => {
     if (isOpen && urlInputRef.current) {
@@ -41,14 +91,15 @@ export default function ShareDialog({
   }, [isOpen]);
 
   const handleCopyLink = useCallback(async () => {
+    if (!url) return;
     try {
       // Try modern Clipboard API first
       if (navigator.clipboard && navigator.clipboard.writeText) {
-        await navigator.clipboard.writeText(shareableUrl);
+        await navigator.clipboard.writeText(url);
       } else {
         // Fallback for older browsers or HTTP contexts
         const textArea = document.createElement('textarea');
-        textArea.value = shareableUrl;
+        textArea.value = url;
         textArea.style.position = 'fixed';
         textArea.style.left = '-9999px';
         document.body.appendChild(textArea);
@@ -67,7 +118,7 @@ export default function ShareDialog({
     } catch (err) {
       console.error('Failed to copy to clipboard:', err);
     }
-  }, [shareableUrl, onCopied, onClose]);
+  }, [url, onCopied, onClose]);
 
   const handleKeyDown = useCallback(
     (e: React.KeyboardEvent) => {
@@ -97,15 +148,57 @@ export default function ShareDialog({
         </div>
 
         <div className="dialog-content">
-          <div className="warning-box">
-            <span className="warning-icon">&#9888;</span>
-            <p>
-              <strong>Anyone with this link can access and edit this project permanently.</strong>
-            </p>
-            <p className="warning-detail">
-              Only share with people you trust. This link cannot be revoked.
-            </p>
-          </div>
+          {createsLinks ? (
+            <div className="share-link-form">
+              <label>
+                Access
+                <select
+                  value={linkRole}
+                  onChange={(e) => setLinkRole(e.target.value as DocumentRole)}
+                >
+                  {ROLE_OPTIONS.map(({ role, label }) => (
+                    <option key={role} value={role}>
+                      {label}
+                    </option>
+                  ))}
+                </select>
+              </label>
+              <label>
+                Expires after
+                <select value={expiry} onChange={(e) => setExpiry(Number(e.target.value))}>
+                  {EXPIRY_OPTIONS.map(({ label }, i) => (
+                    <option key={label} value={i}>
+                      {label}
+                    </option>
+                  ))}
+                </select>
+              </label>
+              <button className="create-link-btn" onClick={handleCreateLink}>
+                Create Link
+              </button>
+              {createError && <div className="share-link-error">{createError}</div>}
+            </div>
+          ) : (
+            <div className="warning-box">
+              <span className="warning-icon">&#9888;</span>
+              {access?.accessControl ? (
+                <p>
+                  <strong>Anyone with this link can access this project as a {access.role}.</strong>
+                </p>
+              ) : (
+                <>
+                  <p>
+                    <strong>
+                      Anyone with this link can access and edit this project permanently.
+                    </strong>
+                  </p>
+                  <p className="warning-detail">
+                    Only share with people you trust. This link cannot be revoked.
+                  </p>
+                </>
+              )}
+            </div>
+          )}
 
           <div className="url-field">
             <label htmlFor="shareable-url">Shareable Link:</label>
@@ -113,7 +206,8 @@ export default function ShareDialog({
               ref={urlInputRef}
               id="shareable-url"
               type="text"
-              value={shareableUrl}
+              value={url}
+              placeholder={createsLinks ? 'Create a link to share' : undefined}
               readOnly
               onClick={(e) => (e.target as HTMLInputElement).select()}
             />
@@ -127,6 +221,7 @@ export default function ShareDialog({
           <button
             className={`copy-btn ${copied ? 'copied' : ''}`}
             onClick={handleCopyLink}
+            disabled={!url}
           >
             {copied ? 'Copied!' : 'Copy Link'}
           </button>
@@ -0,0 +1,79 @@
+/**
+ * Suggestions Panel Component
+ *
+ * Review view for the sidebar accordion.
+ * Lists the open suggestions of the current file with their author and the
+ * text they replace. Clicking a suggestion navigates the editor to it;
+ * suggestions can be accepted or rejected from the list.
+ */
+
+import type { ResolvedSuggestion } from '../services/automergeSync';
+import './SuggestionsPanel.css';
+
+export interface SuggestionsPanelProps {
+  /** Open suggestions of the current file, in text order. */
+  suggestions: ResolvedSuggestion[];
+  /** Called when a suggestion is clicked. */
+  onSelect: (suggestion: ResolvedSuggestion) => void;
+  /** Called to accept a suggestion. */
+  onAccept: (suggestion: ResolvedSuggestion) => void;
+  /** Called to reject a suggestion. */
+  onReject: (suggestion: ResolvedSuggestion) => void;
+}
+
+/**
+ * Suggestions panel for the sidebar accordion.
+ *
+ * Outdated suggestions (their text was edited since) can only be rejected:
+ * accepting them would undo the edits.
+ */
+export default function SuggestionsPanel({
+  suggestions,
+  onSelect,
+  onAccept,
+  onReject,
+}: SuggestionsPanelProps) {
+  if (suggestions.length === 0) {
+    return (
+      <div className="suggestions-panel">
+        <div className="suggestions-empty">No suggestions</div>
+      </div>
+    );
+  }
+
+  return (
+    <div className="suggestions-panel">
+      <ul className="suggestions-list">
+        {suggestions.map((suggestion) => (
+          <li key={suggestion.id} className="suggestion-item">
+            <button
+              className="suggestion-button"
+              onClick={() => onSelect(suggestion)}
+              title="Go to suggestion"
+            >
+              <span className="suggestion-author">{suggestion.author.userName}</span>
+              {suggestion.deleted && (
+                <del className="suggestion-deleted">{suggestion.deleted}</del>
+              )}
+              {suggestion.inserted && (
+                <ins className="suggestion-inserted">{suggestion.inserted}</ins>
+              )}
+              {suggestion.outdated && (
+                <span className="suggestion-outdated">The text was edited since</span>
+              )}
+            </button>
+            <div className="suggestion-actions">
+              <button
+                onClick={() => onAccept(suggestion)}
+                disabled={suggestion.outdated}
+              >
+                Accept
+              </button>
+              <button onClick={() => onReject(suggestion)}>Reject</button>
+            </div>
+          </li>
+        ))}
+      </ul>
+    </div>
+  );
+}
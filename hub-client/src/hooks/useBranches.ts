@@ -0,0 +1,86 @@
+/**
+ * useBranches Hook
+ *
+ * React hook for the branches of a file: the list, kept up to date as
+ * branches are created, merged and deleted, and the operations on them.
+ * Merges are prepared block by block (see `mergeBranchBlocks`) so that
+ * conflicts can be resolved before the merge is written.
+ */
+
+import { useEffect, useState, useCallback } from 'react';
+import {
+  getBranches,
+  onBranchesChange,
+  createBranch,
+  deleteBranch,
+  getBranchContent,
+  updateBranchContent,
+  getBranchMergeTexts,
+  mergeBranch,
+  type FileBranch,
+} from '../services/automergeSync';
+import { getLocalIdentity } from '../services/presenceService';
+import { diffQmdBlocks } from '../services/wasmRenderer';
+import { mergeBranchBlocks, type BranchMerge } from '../utils/branchMerge';
+
+/**
+ * Return value from useBranches hook.
+ */
+interface UseBranchesResult {
+  /** Branches of the file, oldest first */
+  branches: FileBranch[];
+  /** Fork the file into a new branch (no-op without a file or identity) */
+  create: (name: string) => void;
+  /** Delete a branch */
+  remove: (id: string) => void;
+  /** Get the text of a branch */
+  load: (id: string) => Promise<string>;
+  /** Replace the text of a branch */
+  save: (id: string, content: string) => Promise<void>;
+  /** Prepare the merge of a branch into the file */
+  prepareMerge: (id: string) => Promise<BranchMerge>;
+  /** Merge a branch into the file, with the merged text */
+  merge: (id: string, mergedText: string) => Promise<void>;
+}
+
+export function useBranches(filePath: string | null): UseBranchesResult {
+  const [branches, setBranches] = useState<FileBranch[]>([]);
+
+  // Note: setState in effect is intentional - syncing with the sync client
+  useEffect(() => {
+    if (!filePath) {
+      setBranches([]);
+      return;
+    }
+
+    setBranches(getBranches(filePath));
+    return onBranchesChange((updated) => {
+      setBranches(updated.filter((branch) => branch.path === filePath));
+    });
+  }, [filePath]);
+
+  const create = useCallback((name: string) => {
+    const identity = getLocalIdentity();
+    if (!filePath || !identity) return;
+    createBranch(filePath, name, { userId: identity.userId, userName: identity.userName });
+  }, [filePath]);
+
+  const remove = useCallback((id: string) => deleteBranch(id), []);
+
+  const load = useCallback((id: string) => getBranchContent(id), []);
+
+  const save = useCallback((id: string, content: string) => updateBranchContent(id, content), []);
+
+  const prepareMerge = useCallback(async (id: string): Promise<BranchMerge> => {
+    const texts = await getBranchMergeTexts(id);
+    return mergeBranchBlocks(
+      texts,
+      diffQmdBlocks(texts.base, texts.main),
+      diffQmdBlocks(texts.base, texts.branch)
+    );
+  }, []);
+
+  const merge = useCallback((id: string, mergedText: string) => mergeBranch(id, mergedText), []);
+
+  return { branches, create, remove, load, save, prepareMerge, merge };
+}
@@ -0,0 +1,73 @@
+/**
+ * useFileHistory Hook
+ *
+ * React hook for the version history of a file: the list of versions, kept
+ * up to date as the file is edited, what changed since a version, and
+ * restoring one.
+ */
+
+import { useEffect, useState, useCallback } from 'react';
+import {
+  getFileHistory,
+  getFileTextAt,
+  restoreFileVersion,
+  type DocumentVersion,
+} from '../services/automergeSync';
+import { diffQmdBlocks } from '../services/wasmRenderer';
+import { summarizeBlockDiff, type BlockChange } from '../utils/historyDiff';
+
+// Walking the history is linear in its length; wait for a pause in typing
+const REFRESH_DELAY_MS = 500;
+
+/**
+ * Return value from useFileHistory hook.
+ */
+interface UseFileHistoryResult {
+  /** Versions of the file, newest first */
+  versions: DocumentVersion[];
+  /** The blocks changed between a version and the current text */
+  changesSince: (version: DocumentVersion) => BlockChange[];
+  /** Restore the file to a version, as a new edit */
+  restore: (version: DocumentVersion) => void;
+}
+
+export function useFileHistory(filePath: string | null, content: string): UseFileHistoryResult {
+  const [versions, setVersions] = useState<DocumentVersion[]>([]);
+
+  // Note: setState in effect is intentional - syncing with the sync client
+  useEffect(() => {
+    if (!filePath) {
+      setVersions([]);
+      return;
+    }
+
+    const timer = setTimeout(() => {
+      try {
+        setVersions(getFileHistory(filePath));
+      } catch (err) {
+        console.error('Failed to load file history:', err);
+        setVersions([]);
+      }
+    }, REFRESH_DELAY_MS);
+    return () => clearTimeout(timer);
+  }, [filePath, content]);
+
+  const changesSince = useCallback((version: DocumentVersion): BlockChange[] => {
+    if (!filePath) return [];
+    try {
+      const before = getFileTextAt(filePath, version.heads);
+      const diff = diffQmdBlocks(before, content);
+      return diff ? summarizeBlockDiff(diff, before, content) : [];
+    } catch (err) {
+      console.error('Failed to diff file version:', err);
+      return [];
+    }
+  }, [filePath, content]);
+
+  const restore = useCallback((version: DocumentVersion) => {
+    if (!filePath) return;
+    restoreFileVersion(filePath, version.heads);
+  }, [filePath]);
+
+  return { versions, changesSince, restore };
+}
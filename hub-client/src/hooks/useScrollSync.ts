@@ -4,6 +4,10 @@
  * Features:
  * - Editor → Preview: Cursor movement scrolls preview to corresponding content
  * - Preview → Editor: Scroll in preview scrolls editor viewport (without moving cursor)
+ *   to the source line at the top of the preview, or by scroll ratio without
+ *   source locations
+ * - Click-to-source: a click in the preview moves the editor cursor to the
+ *   source position of what was clicked
  * - 50ms debounce to prevent jitter
  * - Graceful degradation when source locations unavailable
  */
@@ -11,6 +15,7 @@
 import { useEffect, useRef, useCallback } from 'react';
 import type { RefObject } from 'react';
 import type * as Monaco from 'monaco-editor';
+import type { SourcePosition } from '../components/MorphIframe';
 
 interface UseScrollSyncOptions {
   /** Reference to Monaco editor instance */
@@ -19,6 +24,8 @@ interface UseScrollSyncOptions {
   scrollPreviewToLine: (line: number) => void;
   /** Function to get the preview's scroll ratio (provided by DoubleBufferedIframe) */
   getPreviewScrollRatio: () => number | null;
+  /** Function to get the source line at the top of the preview (provided by DoubleBufferedIframe) */
+  getPreviewTopLine: () => number | null;
   /** Whether scroll sync is enabled */
   enabled: boolean;
   /** Reference tracking whether editor has focus (to prevent feedback loop) */
@@ -30,7 +37,7 @@ interface UseScrollSyncOptions {
  */
 interface UseScrollSyncReturn {
   handlePreviewScroll: () => void;
-  handlePreviewClick: () => void;
+  handlePreviewClick: (position: SourcePosition | null) => void;
 }
 
 /**
@@ -41,6 +48,7 @@ export function useScrollSync({
   editorRef,
   scrollPreviewToLine,
   getPreviewScrollRatio,
+  getPreviewTopLine,
   enabled,
   editorHasFocusRef,
 }: UseScrollSyncOptions): UseScrollSyncReturn {
@@ -71,7 +79,7 @@ export function useScrollSync({
     }, 300);
   }, [enabled, editorRef, scrollPreviewToLine]);
 
-  // Preview → Editor sync (using scroll ratio matching)
+  // Preview → Editor sync (by source line, falling back to scroll ratio matching)
   const syncPreviewToEditor = useCallback(() => {
     // Skip if disabled, already syncing, or editor has focus (prevents feedback loop)
     if (!enabled || isSyncingRef.current || editorHasFocusRef.current) return;
@@ -79,15 +87,21 @@ export function useScrollSync({
     const editor = editorRef.current;
     if (!editor) return;
 
-    const scrollRatio = getPreviewScrollRatio();
-    if (scrollRatio === null) return;
+    let editorScrollTop: number;
+    const topLine = getPreviewTopLine();
+    if (topLine !== null) {
+      editorScrollTop = editor.getTopForLineNumber(topLine);
+    } else {
+      const scrollRatio = getPreviewScrollRatio();
+      if (scrollRatio === null) return;
 
-    // Apply same ratio to editor
-    const editorScrollHeight = editor.getScrollHeight();
-    const editorViewportHeight = editor.getLayoutInfo().height;
-    const editorMaxScroll = editorScrollHeight - editorViewportHeight;
+      // Apply same ratio to editor
+      const editorScrollHeight = editor.getScrollHeight();
+      const editorViewportHeight = editor.getLayoutInfo().height;
+      const editorMaxScroll = editorScrollHeight - editorViewportHeight;
 
-    const editorScrollTop = scrollRatio * editorMaxScroll;
+      editorScrollTop = scrollRatio * editorMaxScroll;
+    }
 
     isSyncingRef.current = true;
     // Use smooth scrolling (ScrollType.Smooth = 1)
@@ -95,7 +109,7 @@ export function useScrollSync({
     setTimeout(() => {
       isSyncingRef.current = false;
     }, 300); // Longer timeout to account for smooth animation
-  }, [enabled, editorRef, getPreviewScrollRatio, editorHasFocusRef]);
+  }, [enabled, editorRef, getPreviewTopLine, getPreviewScrollRatio, editorHasFocusRef]);
 
   // Set up editor cursor position listener
   useEffect(() => {
@@ -133,10 +147,27 @@ export function useScrollSync({
     }, 50);
   }, [syncPreviewToEditor]);
 
-  // Preview click handler (sync immediately, no debounce)
-  const handlePreviewClick = useCallback(() => {
-    syncPreviewToEditor();
-  }, [syncPreviewToEditor]);
+  // Preview click handler: move the cursor to the clicked source, or sync
+  // the viewport immediately (no debounce) when the click has no position
+  const handlePreviewClick = useCallback((position: SourcePosition | null) => {
+    if (!enabled) return;
+
+    const editor = editorRef.current;
+    if (!editor || !position) {
+      syncPreviewToEditor();
+      return;
+    }
+
+    // The preview is already showing this content, so don't scroll it back
+    isSyncingRef.current = true;
+    const cursor = { lineNumber: position.line, column: position.col };
+    editor.setPosition(cursor);
+    editor.revealPositionInCenterIfOutsideViewport(cursor, 1);
+    editor.focus();
+    setTimeout(() => {
+      isSyncingRef.current = false;
+    }, 300);
+  }, [enabled, editorRef, syncPreviewToEditor]);
 
   // Cleanup debounce timer on unmount
   useEffect(() => {
@@ -0,0 +1,77 @@
+/**
+ * useSuggestions Hook
+ *
+ * React hook for the open suggestions of a file: the list, kept up to date
+ * as suggestions are made and resolved and as edits move them, and the
+ * operations on them, attributed to the local user.
+ */
+
+import { useEffect, useState, useCallback } from 'react';
+import {
+  getSuggestions,
+  onSuggestionsChange,
+  suggestChange,
+  acceptSuggestion,
+  rejectSuggestion,
+  type ResolvedSuggestion,
+  type SuggestedChange,
+  type SuggestionAuthor,
+} from '../services/automergeSync';
+import { getLocalIdentity } from '../services/presenceService';
+
+/**
+ * Return value from useSuggestions hook.
+ */
+interface UseSuggestionsResult {
+  /** Open suggestions of the file, in text order */
+  suggestions: ResolvedSuggestion[];
+  /** Suggest a change (no-op without a file or identity) */
+  suggest: (change: SuggestedChange) => void;
+  /** Accept a suggestion, applying it to the text */
+  accept: (id: string) => void;
+  /** Reject a suggestion */
+  reject: (id: string) => void;
+}
+
+// The local user, as suggestions record them
+function localAuthor(): SuggestionAuthor | null {
+  const identity = getLocalIdentity();
+  return identity ? { userId: identity.userId, userName: identity.userName } : null;
+}
+
+export function useSuggestions(filePath: string | null): UseSuggestionsResult {
+  const [suggestions, setSuggestions] = useState<ResolvedSuggestion[]>([]);
+
+  // Note: setState in effect is intentional - syncing with the sync client
+  useEffect(() => {
+    if (!filePath) {
+      setSuggestions([]);
+      return;
+    }
+
+    setSuggestions(getSuggestions(filePath));
+    return onSuggestionsChange((path, updated) => {
+      if (path === filePath) setSuggestions(updated);
+    });
+  }, [filePath]);
+
+  const suggest = useCallback((change: SuggestedChange) => {
+    const author = localAuthor();
+    if (!filePath || !author) return;
+    suggestChange(filePath, change, author);
+  }, [filePath]);
+
+  const accept = useCallback((id: string) => {
+    const author = localAuthor();
+    if (!filePath || !author) return;
+    acceptSuggestion(filePath, id, author);
+  }, [filePath]);
+
+  const reject = useCallback((id: string) => {
+    const author = localAuthor();
+    if (!filePath || !author) return;
+    rejectSuggestion(filePath, id, author);
+  }, [filePath]);
+
+  return { suggestions, suggest, accept, reject };
+}
@@ -0,0 +1,46 @@
+/**
+ * Tests for accessService URL functions
+ */
+
+import { describe, it, expect } from 'vitest';
+import { apiUrl, syncServerToken, withToken } from './accessService';
+
+describe('syncServerToken', () => {
+  it('reads the token parameter', () => {
+    expect(syncServerToken('wss://hub.example.com/?token=abc')).toBe('abc');
+  });
+
+  it('is null without a token or for invalid URLs', () => {
+    expect(syncServerToken('wss://sync.automerge.org')).toBeNull();
+    expect(syncServerToken('not a url')).toBeNull();
+  });
+});
+
+describe('withToken', () => {
+  it('adds a token', () => {
+    expect(withToken('ws://localhost:3030', 'abc')).toBe('ws://localhost:3030/?token=abc');
+  });
+
+  it('replaces an existing token', () => {
+    expect(withToken('ws://localhost:3030/ws?token=old', 'new')).toBe(
+      'ws://localhost:3030/ws?token=new'
+    );
+  });
+});
+
+describe('apiUrl', () => {
+  it('maps WebSocket URLs to HTTP on the same host', () => {
+    expect(apiUrl('ws://localhost:3030/ws', '/api/access')).toBe(
+      'http://localhost:3030/api/access'
+    );
+    expect(apiUrl('wss://hub.example.com', '/api/access')).toBe(
+      'https://hub.example.com/api/access'
+    );
+  });
+
+  it('drops the token and path of the sync server URL', () => {
+    expect(apiUrl('wss://hub.example.com/ws?token=abc', '/api/share-links')).toBe(
+      'https://hub.example.com/api/share-links'
+    );
+  });
+});
@@ -0,0 +1,149 @@
+/**
+ * Access Service
+ *
+ * Roles and share links of projects on a quarto-hub sync server.
+ *
+ * A hub with access control takes a token on the sync server URL
+ * (`?token=`), so the token travels with the project wherever its sync
+ * server does: in stored projects and in shareable URLs. The hub's REST API
+ * is on the same host as its WebSocket endpoint.
+ *
+ * Sync servers without the access API (sync.automerge.org, hubs without
+ * access control) let anyone with the project's URL edit it.
+ */
+
+import type { DocumentRole } from '@quarto/quarto-sync-client';
+
+/** What the sync server lets this client do */
+export interface AccessInfo {
+  role: DocumentRole;
+  /** Whether the server checks roles at all */
+  accessControl: boolean;
+  /** When the client's share link expires (ms since the Unix epoch) */
+  expiresAt: number | null;
+}
+
+/** A share link, as created by the owner */
+export interface ShareLink {
+  token: string;
+  role: DocumentRole;
+  /** When the link stops working (ms since the Unix epoch) */
+  expiresAt: number | null;
+  label: string | null;
+}
+
+/** Full access, for servers without access control */
+const UNRESTRICTED: AccessInfo = { role: 'owner', accessControl: false, expiresAt: null };
+
+/**
+ * The token on a sync server URL, if any.
+ */
+export function syncServerToken(syncServer: string): string | null {
+  try {
+    return new URL(syncServer).searchParams.get('token');
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * A sync server URL with a token, replacing any it had.
+ */
+export function withToken(syncServer: string, token: string): string {
+  const url = new URL(syncServer);
+  url.searchParams.set('token', token);
+  return url.toString();
+}
+
+/**
+ * The URL of a REST API path on a sync server: the same host, over HTTP.
+ *
+ * @example
+ * apiUrl('wss://hub.example.com/ws?token=abc', '/api/access')
+ *   // 'https://hub.example.com/api/access'
+ */
+export function apiUrl(syncServer: string, path: string): string {
+  const url = new URL(syncServer);
+  const protocol =
+    url.protocol === 'wss:' ? 'https:' : url.protocol === 'ws:' ? 'http:' : url.protocol;
+  return `${protocol}//${url.host}${path}`;
+}
+
+// Headers of an API request with the sync server's token
+function authHeaders(syncServer: string): Record<string, string> {
+  const token = syncServerToken(syncServer);
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+/**
+ * Get what the sync server lets this client do.
+ *
+ * Throws if the server rejects the token (unknown, revoked or expired).
+ * Servers without the access API give full access. So do servers that
+ * can't be reached, for projects to open offline; a hub drops the changes
+ * a role doesn't allow when they sync.
+ */
+export async function fetchAccess(syncServer: string): Promise<AccessInfo> {
+  let response: Response;
+  try {
+    response = await fetch(apiUrl(syncServer, '/api/access'), {
+      headers: authHeaders(syncServer),
+    });
+  } catch {
+    return UNRESTRICTED;
+  }
+
+  if (response.status === 401 || response.status === 403) {
+    throw new Error('This share link has expired or been revoked');
+  }
+  if (!response.ok) return UNRESTRICTED;
+
+  const body = (await response.json()) as {
+    role: DocumentRole;
+    access_control: boolean;
+    expires_at: number | null;
+  };
+  return {
+    role: body.role,
+    accessControl: body.access_control,
+    expiresAt: body.expires_at === null ? null : body.expires_at * 1000,
+  };
+}
+
+/**
+ * Create a share link (owners only). `expiresInMs` null for a link that
+ * doesn't expire.
+ */
+export async function createShareLink(
+  syncServer: string,
+  role: DocumentRole,
+  expiresInMs: number | null,
+  label?: string
+): Promise<ShareLink> {
+  const response = await fetch(apiUrl(syncServer, '/api/share-links'), {
+    method: 'POST',
+    headers: { ...authHeaders(syncServer), 'Content-Type': 'application/json' },
+    body: JSON.stringify({
+      role,
+      expires_in_secs: expiresInMs === null ? null : Math.round(expiresInMs / 1000),
+      label: label ?? null,
+    }),
+  });
+  if (!response.ok) {
+    const body = (await response.json().catch(() => null)) as { error?: string } | null;
+    throw new Error(body?.error ?? `Failed to create share link (${response.status})`);
+  }
+
+  const link = (await response.json()) as {
+    token: string;
+    role: DocumentRole;
+    expires_at?: number;
+    label?: string;
+  };
+  return {
+    token: link.token,
+    role: link.role,
+    expiresAt: link.expires_at === undefined ? null : link.expires_at * 1000,
+    label: link.label ?? null,
+  };
+}
@@ -0,0 +1,67 @@
+/**
+ * Tests for the automerge-repo storage adapter.
+ * Uses fake-indexeddb for in-memory database simulation.
+ */
+
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import 'fake-indexeddb/auto';
+import { IDBFactory } from 'fake-indexeddb';
+import { IndexedDBAutomergeStorage } from './automergeStorage';
+import { closeDatabase } from './projectStorage';
+
+const bytes = (...values: number[]) => new Uint8Array(values);
+
+describe('IndexedDBAutomergeStorage', () => {
+  let storage: IndexedDBAutomergeStorage;
+
+  beforeEach(() => {
+    closeDatabase();
+    Object.defineProperty(globalThis, 'indexedDB', {
+      value: new IDBFactory(),
+      writable: true,
+    });
+    storage = new IndexedDBAutomergeStorage();
+  });
+
+  afterEach(() => {
+    closeDatabase();
+  });
+
+  it('saves and loads chunks', async () => {
+    await storage.save(['doc1', 'snapshot', 'abc'], bytes(1, 2, 3));
+
+    expect(await storage.load(['doc1', 'snapshot', 'abc'])).toEqual(bytes(1, 2, 3));
+    expect(await storage.load(['doc1', 'snapshot', 'def'])).toBeUndefined();
+  });
+
+  it('removes chunks', async () => {
+    await storage.save(['doc1', 'snapshot', 'abc'], bytes(1));
+    await storage.remove(['doc1', 'snapshot', 'abc']);
+
+    expect(await storage.load(['doc1', 'snapshot', 'abc'])).toBeUndefined();
+  });
+
+  it('loads the chunks under a prefix', async () => {
+    await storage.save(['doc1', 'incremental', 'a'], bytes(1));
+    await storage.save(['doc1', 'incremental', 'b'], bytes(2));
+    await storage.save(['doc1', 'snapshot', 'c'], bytes(3));
+    await storage.save(['doc10', 'incremental', 'd'], bytes(4));
+
+    const chunks = await storage.loadRange(['doc1', 'incremental']);
+    expect(chunks.map((c) => c.key)).toEqual([
+      ['doc1', 'incremental', 'a'],
+      ['doc1', 'incremental', 'b'],
+    ]);
+    expect(await storage.loadRange(['doc1'])).toHaveLength(3);
+  });
+
+  it('removes the chunks under a prefix', async () => {
+    await storage.save(['doc1', 'incremental', 'a'], bytes(1));
+    await storage.save(['doc1', 'snapshot', 'b'], bytes(2));
+    await storage.save(['doc2', 'snapshot', 'c'], bytes(3));
+
+    await storage.removeRange(['doc1']);
+    expect(await storage.loadRange(['doc1'])).toEqual([]);
+    expect(await storage.load(['doc2', 'snapshot', 'c'])).toEqual(bytes(3));
+  });
+});
@@ -0,0 +1,57 @@
+/**
+ * IndexedDB storage for the sync client's automerge repo.
+ *
+ * Keeps each project's documents and their changes in the browser, so a
+ * project that was opened before can be opened and edited offline, and no
+ * edit is lost before it reaches the sync server.
+ */
+
+import type { Chunk, StorageAdapterInterface, StorageKey } from '@automerge/automerge-repo';
+import type { AutomergeStorageEntry } from './storage/types';
+import { STORES } from './storage';
+import { getDatabase } from './projectStorage';
+
+/**
+ * The keys that start with a prefix. Keys are arrays, which IndexedDB
+ * orders element by element, so they sort between the prefix and the
+ * prefix followed by the highest string.
+ */
+function prefixRange(prefix: StorageKey): IDBKeyRange {
+  return IDBKeyRange.bound(prefix, [...prefix, '\uffff']);
+}
+
+/**
+ * automerge-repo storage adapter backed by the hub's IndexedDB database.
+ */
+export class IndexedDBAutomergeStorage implements StorageAdapterInterface {
+  async load(key: StorageKey): Promise<Uint8Array | undefined> {
+    const db = await getDatabase();
+    const entry = (await db.get(STORES.AUTOMERGE_DOCS, key)) as AutomergeStorageEntry | undefined;
+    return entry?.data;
+  }
+
+  async save(key: StorageKey, data: Uint8Array): Promise<void> {
+    const db = await getDatabase();
+    const entry: AutomergeStorageEntry = { key, data };
+    await db.put(STORES.AUTOMERGE_DOCS, entry);
+  }
+
+  async remove(key: StorageKey): Promise<void> {
+    const db = await getDatabase();
+    await db.delete(STORES.AUTOMERGE_DOCS, key);
+  }
+
+  async loadRange(keyPrefix: StorageKey): Promise<Chunk[]> {
+    const db = await getDatabase();
+    const entries = (await db.getAll(
+      STORES.AUTOMERGE_DOCS,
+      prefixRange(keyPrefix)
+    )) as AutomergeStorageEntry[];
+    return entries.map(({ key, data }) => ({ key, data }));
+  }
+
+  async removeRange(keyPrefix: StorageKey): Promise<void> {
+    const db = await getDatabase();
+    await db.delete(STORES.AUTOMERGE_DOCS, prefixRange(keyPrefix));
+  }
+}
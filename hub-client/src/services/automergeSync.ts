@@ -16,12 +16,40 @@ import {
   type CreateProjectOptions,
   type CreateProjectResult,
   type FilePayload,
+  type ResolvedSuggestion,
+  type SuggestedChange,
+  type SuggestionAuthor,
+  type DocumentVersion,
+  type FileBranch,
+  type BranchMergeTexts,
+  type DocumentRole,
+  type Awareness,
+  type AwarenessOptions,
+  type UserPresence,
+  type LocatedCommentThread,
+  type SourceRange,
 } from '@quarto/quarto-sync-client';
 
 import { vfsAddFile, vfsAddBinaryFile, vfsRemoveFile, vfsClear, initWasm } from './wasmRenderer';
+import { IndexedDBAutomergeStorage } from './automergeStorage';
+import { fetchAccess, type AccessInfo } from './accessService';
 
 // Re-export types for use in other components
-export type { Patch, FileEntry, CreateBinaryFileResult, CreateProjectOptions, CreateProjectResult };
+export type {
+  Patch,
+  FileEntry,
+  CreateBinaryFileResult,
+  CreateProjectOptions,
+  CreateProjectResult,
+  ResolvedSuggestion,
+  SuggestedChange,
+  SuggestionAuthor,
+  DocumentVersion,
+  FileBranch,
+  BranchMergeTexts,
+  DocumentRole,
+  AccessInfo,
+};
 
 // Event handlers for state changes
 type FilesChangeHandler = (files: FileEntry[]) => void;
@@ -36,9 +64,24 @@ let onBinaryContent: BinaryContentHandler | null = null;
 let onConnectionChange: ConnectionHandler | null = null;
 let onError: ErrorHandler | null = null;
 
+// Suggestion listeners (any number, see onSuggestionsChange)
+type SuggestionsHandler = (path: string, suggestions: ResolvedSuggestion[]) => void;
+const suggestionListeners = new Set<SuggestionsHandler>();
+
+// Comment listeners (any number, see onCommentsChange)
+type CommentsHandler = (path: string, threads: LocatedCommentThread[]) => void;
+const commentListeners = new Set<CommentsHandler>();
+
+// Branch listeners (any number, see onBranchesChange)
+type BranchesHandler = (branches: FileBranch[]) => void;
+const branchListeners = new Set<BranchesHandler>();
+
 // The sync client instance
 let client: SyncClient | null = null;
 
+// What the connected project's sync server lets this client do
+let access: AccessInfo = { role: 'owner', accessControl: false, expiresAt: null };
+
 /**
  * Set event handlers for sync events.
  */
@@ -91,19 +134,49 @@ function ensureClient(): SyncClient {
       onError: (error: Error) => {
         onError?.(error);
       },
+      onSuggestionsChanged: (path: string, suggestions: ResolvedSuggestion[]) => {
+        for (const listener of suggestionListeners) {
+          listener(path, suggestions);
+        }
+      },
+      onCommentsChanged: (path: string, threads: LocatedCommentThread[]) => {
+        for (const listener of commentListeners) {
+          listener(path, threads);
+        }
+      },
+      onBranchesChange: (branches: FileBranch[]) => {
+        for (const listener of branchListeners) {
+          listener(branches);
+        }
+      },
     };
-    client = createSyncClient(callbacks);
+    // Documents are kept in IndexedDB, so projects open and edit offline
+    const storage = typeof indexedDB !== 'undefined' ? new IndexedDBAutomergeStorage() : undefined;
+    client = createSyncClient(callbacks, undefined, { storage });
   }
   return client;
 }
 
 /**
  * Connect to a sync server and load a project.
+ *
+ * The role is fetched first, so that operations it doesn't allow throw
+ * from the start (see `getAccess`).
  */
 export async function connect(syncServerUrl: string, indexDocId: string): Promise<FileEntry[]> {
   await initWasm();
   vfsClear();
-  return ensureClient().connect(syncServerUrl, indexDocId);
+  access = await fetchAccess(syncServerUrl);
+  const syncClient = ensureClient();
+  syncClient.setRole(access.role);
+  return syncClient.connect(syncServerUrl, indexDocId);
+}
+
+/**
+ * Get what the connected project's sync server lets this client do.
+ */
+export function getAccess(): AccessInfo {
+  return access;
 }
 
 /**
@@ -188,6 +261,14 @@ export function isConnected(): boolean {
   return client?.isConnected() ?? false;
 }
 
+/**
+ * Check if the sync server is reachable. While it isn't, edits are kept
+ * and sent once it is.
+ */
+export function isOnline(): boolean {
+  return client?.isOnline() ?? false;
+}
+
 /**
  * Create a new project with the given files.
  */
@@ -198,11 +279,162 @@ export async function createNewProject(options: CreateProjectOptions): Promise<C
 }
 
 /**
- * Get the DocHandle for a file by path.
- * Used by the presence service for ephemeral messaging.
+ * Get the awareness of a file, which carries collaborators' cursors and
+ * selections. Used by the presence service.
+ * Returns null when the file isn't loaded.
+ */
+export function getAwareness(
+  path: string,
+  options?: Omit<AwarenessOptions, 'peerId'>
+): Awareness<UserPresence> | null {
+  return ensureClient().getAwareness<UserPresence>(path, options);
+}
+
+/**
+ * Subscribe to changes of the open suggestions of any file.
+ * Returns an unsubscribe function.
+ */
+export function onSuggestionsChange(listener: SuggestionsHandler): () => void {
+  suggestionListeners.add(listener);
+  return () => {
+    suggestionListeners.delete(listener);
+  };
+}
+
+/**
+ * Get the open suggestions of a file, in text order.
+ */
+export function getSuggestions(path: string): ResolvedSuggestion[] {
+  return ensureClient().getSuggestions(path);
+}
+
+/**
+ * Suggest a change to a file without changing its text.
+ * Returns the new suggestion's ID.
+ */
+export function suggestChange(path: string, change: SuggestedChange, author: SuggestionAuthor): string {
+  return ensureClient().suggestChange(path, change, author);
+}
+
+/**
+ * Accept a suggestion, applying it to the file.
+ */
+export function acceptSuggestion(path: string, id: string, by: SuggestionAuthor): void {
+  ensureClient().acceptSuggestion(path, id, by);
+}
+
+/**
+ * Reject a suggestion.
+ */
+export function rejectSuggestion(path: string, id: string, by: SuggestionAuthor): void {
+  ensureClient().rejectSuggestion(path, id, by);
+}
+
+/**
+ * Subscribe to changes of the open comment threads of any file.
+ * Returns an unsubscribe function.
+ */
+export function onCommentsChange(listener: CommentsHandler): () => void {
+  commentListeners.add(listener);
+  return () => {
+    commentListeners.delete(listener);
+  };
+}
+
+/**
+ * Get the open comment threads about a rendered block of a file, given the
+ * source range from its `data-loc`.
+ */
+export function getCommentThreadsForBlock(path: string, range: SourceRange): LocatedCommentThread[] {
+  return ensureClient().getCommentThreadsForBlock(path, range);
+}
+
+/**
+ * Set who local edits are recorded as made by, in file histories.
+ */
+export function setChangeAuthor(author: SuggestionAuthor | null): void {
+  ensureClient().setAuthor(author);
+}
+
+/**
+ * Get the versions of a file, newest first.
+ */
+export function getFileHistory(path: string): DocumentVersion[] {
+  return ensureClient().getFileHistory(path);
+}
+
+/**
+ * Get the text of a file as of a version.
+ */
+export function getFileTextAt(path: string, heads: string[]): string {
+  return ensureClient().getFileTextAt(path, heads);
+}
+
+/**
+ * Restore a file to a version, as a new edit.
+ */
+export function restoreFileVersion(path: string, heads: string[]): void {
+  ensureClient().restoreFileVersion(path, heads);
+}
+
+/**
+ * Subscribe to changes of the project's branches.
+ * Returns an unsubscribe function.
+ */
+export function onBranchesChange(listener: BranchesHandler): () => void {
+  branchListeners.add(listener);
+  return () => {
+    branchListeners.delete(listener);
+  };
+}
+
+/**
+ * Get the branches of a file, oldest first.
+ */
+export function getBranches(path: string): FileBranch[] {
+  return ensureClient().getBranches(path);
+}
+
+/**
+ * Fork a file into a named branch.
+ */
+export function createBranch(path: string, name: string, author: SuggestionAuthor): FileBranch {
+  return ensureClient().createBranch(path, name, author);
+}
+
+/**
+ * Get the text of a branch.
+ */
+export function getBranchContent(branchId: string): Promise<string> {
+  return ensureClient().getBranchContent(branchId);
+}
+
+/**
+ * Replace the text of a branch.
+ */
+export function updateBranchContent(branchId: string, content: string): Promise<void> {
+  return ensureClient().updateBranchContent(branchId, content);
+}
+
+/**
+ * Get the base, file and branch texts of a branch merge.
+ */
+export function getBranchMergeTexts(branchId: string): Promise<BranchMergeTexts> {
+  return ensureClient().getBranchMergeTexts(branchId);
+}
+
+/**
+ * Merge a branch back into its file, with the given merged text.
+ */
+export function mergeBranch(branchId: string, mergedText: string): Promise<void> {
+  return ensureClient().mergeBranch(branchId, mergedText);
+}
+
+/**
+ * Delete a branch.
  */
-export function getFileHandle(path: string) {
-  return ensureClient().getFileHandle(path);
+export function deleteBranch(branchId: string): void {
+  ensureClient().deleteBranch(branchId);
 }
 
 /**
@@ -243,6 +475,10 @@ export function _resetForTesting(): void {
   onBinaryContent = null;
   onConnectionChange = null;
   onError = null;
+  suggestionListeners.clear();
+  commentListeners.clear();
+  branchListeners.clear();
+  access = { role: 'owner', accessControl: false, expiresAt: null };
 }
 
 /**
@@ -7,6 +7,7 @@
  */
 
 import { describe, it, expect, beforeEach, afterEach, vi } from 'vitest';
+import type { PeerAwareness, UserPresence } from '@quarto/quarto-sync-client';
 import {
   initPresence,
   cleanupPresence,
@@ -21,6 +22,7 @@ import {
   _resetForTesting,
   _getStateForTesting,
 } from './presenceService';
+import { getAwareness } from './automergeSync';
 
 // Mock the userSettings module
 vi.mock('./userSettings', () => ({
@@ -36,9 +38,46 @@ vi.mock('./userSettings', () => ({
 
 // Mock the automergeSync module
 vi.mock('./automergeSync', () => ({
-  getFileHandle: vi.fn().mockReturnValue(null),
+  getAwareness: vi.fn().mockReturnValue(null),
+  setChangeAuthor: vi.fn(),
 }));
 
+/**
+ * A stand-in for a file's awareness that records the local state and lets
+ * tests report remote peers.
+ */
+function createFakeAwareness() {
+  let subscriber: ((peers: PeerAwareness<UserPresence>[]) => void) | null = null;
+  return {
+    peerId: 'local-peer',
+    setLocalState: vi.fn(),
+    getLocalState: vi.fn().mockReturnValue(null),
+    getPeers: vi.fn().mockReturnValue([]),
+    subscribe: vi.fn((callback: (peers: PeerAwareness<UserPresence>[]) => void) => {
+      subscriber = callback;
+      callback([]);
+      return () => {
+        subscriber = null;
+      };
+    }),
+    dispose: vi.fn(),
+    emit(peers: PeerAwareness<UserPresence>[]) {
+      subscriber?.(peers);
+    },
+    isSubscribed() {
+      return subscriber !== null;
+    },
+  };
+}
+
+const ALICE: UserPresence = {
+  userId: 'user-1',
+  userName: 'Alice',
+  userColor: '#ff0000',
+  cursor: 42,
+  selection: { start: 40, end: 45 },
+};
+
 describe('presenceService', () => {
   beforeEach(() => {
     _resetForTesting();
@@ -61,34 +100,35 @@ describe('presenceService', () => {
       expect(identity?.userColor).toBe('#3498db');
     });
 
-    it('should generate a unique peer ID', async () => {
+    it('should use the peer ID of the current file\'s awareness', async () => {
       await initPresence();
-      const peerId = getLocalPeerId();
-
-      expect(peerId).toBeTruthy();
-      expect(typeof peerId).toBe('string');
-      // UUID format check
-      expect(peerId).toMatch(/^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$/i);
-    });
+      expect(getLocalPeerId()).toBeNull();
 
-    it('should start cleanup interval', async () => {
-      await initPresence();
-      const state = _getStateForTesting();
+      vi.mocked(getAwareness).mockReturnValueOnce(createFakeAwareness());
+      setCurrentFile('index.qmd');
 
-      expect(state.cleanupInterval).not.toBeNull();
+      expect(getLocalPeerId()).toBe('local-peer');
     });
 
     it('should accept custom configuration', async () => {
       await initPresence({
         broadcastThrottleMs: 100,
         staleThresholdMs: 10000,
-        cleanupIntervalMs: 5000,
       });
 
       const state = _getStateForTesting();
       expect(state.config.broadcastThrottleMs).toBe(100);
       expect(state.config.staleThresholdMs).toBe(10000);
-      expect(state.config.cleanupIntervalMs).toBe(5000);
+    });
+
+    it('should pass the configuration to the awareness', async () => {
+      await initPresence({ broadcastThrottleMs: 100, staleThresholdMs: 10000 });
+      setCurrentFile('index.qmd');
+
+      expect(getAwareness).toHaveBeenCalledWith('index.qmd', {
+        throttleMs: 100,
+        staleMs: 10000,
+      });
     });
   });
 
@@ -99,18 +139,24 @@ describe('presenceService', () => {
 
       const state = _getStateForTesting();
       expect(state.currentFilePath).toBeNull();
-      expect(state.currentHandle).toBeNull();
+      expect(state.currentAwareness).toBeNull();
       expect(state.remotePresences.size).toBe(0);
       expect(state.localCursor).toBeNull();
       expect(state.localSelection).toBeNull();
     });
 
-    it('should clear cleanup interval on cleanup', async () => {
+    it('should withdraw local presence on cleanup', async () => {
+      const awareness = createFakeAwareness();
+      vi.mocked(getAwareness).mockReturnValueOnce(awareness);
       await initPresence();
+      setCurrentFile('index.qmd');
+
       cleanupPresence();
 
-      const state = _getStateForTesting();
-      expect(state.cleanupInterval).toBeNull();
+      expect(awareness.setLocalState).toHaveBeenLastCalledWith(null);
+      expect(awareness.isSubscribed()).toBe(false);
+      // The sync client owns the awareness
+      expect(awareness.dispose).not.toHaveBeenCalled();
     });
   });
 
@@ -284,54 +330,61 @@ describe('presenceService', () => {
     });
   });
 
-  describe('stale presence cleanup', () => {
+  describe('awareness', () => {
+    let awareness: ReturnType<typeof createFakeAwareness>;
+
     beforeEach(async () => {
-      await initPresence({
-        staleThresholdMs: 1000,
-        cleanupIntervalMs: 100,
-      });
+      await initPresence();
+      awareness = createFakeAwareness();
+      vi.mocked(getAwareness).mockReturnValueOnce(awareness);
+      setCurrentFile('index.qmd');
     });
 
-    it('should remove stale presences after threshold', () => {
-      const state = _getStateForTesting();
+    it('should publish identity, cursor and selection', () => {
+      updatePresence(15, { start: 10, end: 25 });
 
-      // Add a presence with old timestamp
-      state.remotePresences.set('stale-peer', {
-        peerId: 'stale-peer',
-        userId: 'stale-user',
-        userName: 'Stale User',
-        userColor: '#cccccc',
-        filePath: 'index.qmd',
-        cursor: 0,
-        selection: null,
-        lastSeen: Date.now() - 2000, // 2 seconds ago (stale)
+      expect(awareness.setLocalState).toHaveBeenLastCalledWith({
+        userId: 'test-user-123',
+        userName: 'Test User',
+        userColor: '#3498db',
+        cursor: 15,
+        selection: { start: 10, end: 25 },
       });
+    });
 
-      // Fast-forward time to trigger cleanup
-      vi.advanceTimersByTime(150);
+    it('should report the awareness peers as remote presences', () => {
+      const callback = vi.fn();
+      onPresenceChange(callback);
 
-      expect(state.remotePresences.size).toBe(0);
+      awareness.emit([{ peerId: 'peer-1', state: ALICE, lastSeen: 1000 }]);
+
+      expect(callback).toHaveBeenLastCalledWith([
+        {
+          peerId: 'peer-1',
+          userId: 'user-1',
+          userName: 'Alice',
+          userColor: '#ff0000',
+          filePath: 'index.qmd',
+          cursor: 42,
+          selection: { start: 40, end: 45 },
+          lastSeen: 1000,
+        },
+      ]);
     });
 
-    it('should keep fresh presences', () => {
-      const state = _getStateForTesting();
+    it('should drop presences the awareness no longer reports', () => {
+      awareness.emit([{ peerId: 'peer-1', state: ALICE, lastSeen: 1000 }]);
+      awareness.emit([]);
 
-      // Add a fresh presence
-      state.remotePresences.set('fresh-peer', {
-        peerId: 'fresh-peer',
-        userId: 'fresh-user',
-        userName: 'Fresh User',
-        userColor: '#00ff00',
-        filePath: 'index.qmd',
-        cursor: 10,
-        selection: null,
-        lastSeen: Date.now(),
-      });
+      expect(getRemotePresences()).toEqual([]);
+    });
 
-      // Fast-forward time to trigger cleanup
-      vi.advanceTimersByTime(150);
+    it('should withdraw local presence when switching files', () => {
+      updateCursor(10);
+      setCurrentFile('other.qmd');
 
-      expect(state.remotePresences.size).toBe(1);
+      expect(awareness.setLocalState).toHaveBeenLastCalledWith(null);
+      expect(awareness.isSubscribed()).toBe(false);
     });
   });
 
@@ -351,15 +404,16 @@ describe('presenceService', () => {
       expect(state.remotePresences.size).toBe(0);
     });
 
-    it('should generate new peer ID after reset', async () => {
+    it('should leave the current file on reset', async () => {
+      const awareness = createFakeAwareness();
+      vi.mocked(getAwareness).mockReturnValueOnce(awareness);
       await initPresence();
-      const peerId1 = getLocalPeerId();
+      setCurrentFile('test.qmd');
 
       _resetForTesting();
-      await initPresence();
-      const peerId2 = getLocalPeerId();
 
-      expect(peerId1).not.toBe(peerId2);
+      expect(getLocalPeerId()).toBeNull();
+      expect(awareness.isSubscribed()).toBe(false);
     });
   });
 });
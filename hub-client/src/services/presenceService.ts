@@ -2,11 +2,13 @@
  * Presence Service
  *
  * Manages real-time presence (cursors, selections) for collaborative editing.
- * Uses Automerge's ephemeral messaging to broadcast and receive presence state.
+ * Uses the sync client's per-file awareness to broadcast and receive presence
+ * state. Awareness throttles broadcasts, repeats them while the state is
+ * unchanged, and drops peers that stop being heard from.
  */
 
-import type { DocHandle, DocHandleEphemeralMessagePayload } from '@automerge/automerge-repo';
-import { getFileHandle } from './automergeSync';
+import type { Awareness, PeerAwareness, UserPresence } from '@quarto/quarto-sync-client';
+import { getAwareness, setChangeAuthor } from './automergeSync';
 import { getUserIdentity } from './userSettings';
 import type { UserSettings } from './storage/types';
 
@@ -24,29 +26,6 @@ export interface PresenceState {
   lastSeen: number;
 }
 
-/**
- * Presence message broadcast via ephemeral messaging.
- */
-interface PresenceMessage {
-  type: 'presence';
-  peerId: string;
-  userId: string;
-  userName: string;
-  userColor: string;
-  cursor: number | null;
-  selection: { start: number; end: number } | null;
-}
-
-/**
- * Leave message broadcast when user leaves a file or disconnects.
- */
-interface PresenceLeaveMessage {
-  type: 'leave';
-  peerId: string;
-}
-
-type EphemeralMessage = PresenceMessage | PresenceLeaveMessage;
-
 /**
  * Callback for presence changes.
  */
@@ -60,14 +39,11 @@ interface PresenceConfig {
   broadcastThrottleMs: number;
   /** How long before a user is considered stale (ms). Default: 5000 */
   staleThresholdMs: number;
-  /** How often to clean up stale presences (ms). Default: 2000 */
-  cleanupIntervalMs: number;
 }
 
 const DEFAULT_CONFIG: PresenceConfig = {
   broadcastThrottleMs: 50,
   staleThresholdMs: 5000,
-  cleanupIntervalMs: 2000,
 };
 
 /**
@@ -75,12 +51,11 @@ const DEFAULT_CONFIG: PresenceConfig = {
  */
 interface PresenceServiceState {
   // Our identity
-  peerId: string;
   identity: UserSettings | null;
 
   // Current file we're tracking presence for
   currentFilePath: string | null;
-  currentHandle: DocHandle<unknown> | null;
+  currentAwareness: Awareness<UserPresence> | null;
 
   // Remote presences for current file
   remotePresences: Map<string, PresenceState>;
@@ -89,36 +64,25 @@ interface PresenceServiceState {
   localCursor: number | null;
   localSelection: { start: number; end: number } | null;
 
-  // Throttling
-  lastBroadcastTime: number;
-  pendingBroadcast: ReturnType<typeof setTimeout> | null;
-
-  // Cleanup interval
-  cleanupInterval: ReturnType<typeof setInterval> | null;
-
   // Subscribers
   subscribers: Set<PresenceChangeCallback>;
 
-  // Event listener cleanup
-  messageHandler: ((payload: DocHandleEphemeralMessagePayload<unknown>) => void) | null;
+  // Awareness subscription cleanup
+  unsubscribeAwareness: (() => void) | null;
 
   // Configuration
   config: PresenceConfig;
 }
 
 const state: PresenceServiceState = {
-  peerId: crypto.randomUUID(),
   identity: null,
   currentFilePath: null,
-  currentHandle: null,
+  currentAwareness: null,
   remotePresences: new Map(),
   localCursor: null,
   localSelection: null,
-  lastBroadcastTime: 0,
-  pendingBroadcast: null,
-  cleanupInterval: null,
   subscribers: new Set(),
-  messageHandler: null,
+  unsubscribeAwareness: null,
   config: DEFAULT_CONFIG,
 };
 
@@ -134,12 +98,10 @@ export async function initPresence(config?: Partial<PresenceConfig>): Promise<vo
 
   // Load user identity
   state.identity = await getUserIdentity();
+  recordChangeAuthor();
 
-  // Start cleanup interval
-  if (state.cleanupInterval) {
-    clearInterval(state.cleanupInterval);
-  }
-  state.cleanupInterval = setInterval(cleanupStalePresences, state.config.cleanupIntervalMs);
+  // A file may have been set before the identity was known
+  publishPresence();
 }
 
 /**
@@ -147,29 +109,11 @@ export async function initPresence(config?: Partial<PresenceConfig>): Promise<vo
  * Call this when disconnecting or unmounting.
  */
 export function cleanupPresence(): void {
-  // Broadcast leave message if we have a current file
-  if (state.currentHandle) {
-    broadcastLeave();
-  }
-
-  // Stop listening to current handle
+  // Tell other users we left the current file
   stopListening();
 
-  // Clear cleanup interval
-  if (state.cleanupInterval) {
-    clearInterval(state.cleanupInterval);
-    state.cleanupInterval = null;
-  }
-
-  // Clear pending broadcast
-  if (state.pendingBroadcast) {
-    clearTimeout(state.pendingBroadcast);
-    state.pendingBroadcast = null;
-  }
-
   // Clear state
   state.currentFilePath = null;
-  state.currentHandle = null;
   state.remotePresences.clear();
   state.localCursor = null;
   state.localSelection = null;
@@ -188,11 +132,8 @@ export function setCurrentFile(filePath: string | null): void {
     return;
   }
 
-  // Broadcast leave on old file
-  if (state.currentHandle) {
-    broadcastLeave();
-    stopListening();
-  }
+  // Leave the old file
+  stopListening();
 
   // Clear remote presences when switching files
   state.remotePresences.clear();
@@ -203,15 +144,7 @@ export function setCurrentFile(filePath: string | null): void {
   state.currentFilePath = filePath;
 
   if (filePath) {
-    const handle = getFileHandle(filePath);
-    if (handle) {
-      state.currentHandle = handle;
-      startListening();
-    } else {
-      state.currentHandle = null;
-    }
-  } else {
-    state.currentHandle = null;
+    startListening(filePath);
   }
 
   // Notify subscribers
@@ -224,7 +157,7 @@ export function setCurrentFile(filePath: string | null): void {
  */
 export function updateCursor(offset: number | null): void {
   state.localCursor = offset;
-  scheduleBroadcast();
+  publishPresence();
 }
 
 /**
@@ -233,7 +166,7 @@ export function updateCursor(offset: number | null): void {
  */
 export function updateSelection(selection: { start: number; end: number } | null): void {
   state.localSelection = selection;
-  scheduleBroadcast();
+  publishPresence();
 }
 
 /**
@@ -246,7 +179,7 @@ export function updatePresence(
 ): void {
   state.localCursor = cursor;
   state.localSelection = selection;
-  scheduleBroadcast();
+  publishPresence();
 }
 
 /**
@@ -284,139 +217,82 @@ export function getLocalIdentity(): UserSettings | null {
  */
 export async function refreshIdentity(): Promise<void> {
   state.identity = await getUserIdentity();
-  // Immediately broadcast updated identity
-  broadcastPresence();
+  recordChangeAuthor();
+  // Broadcast updated identity
+  publishPresence();
 }
 
 /**
- * Get the local peer ID.
- * This is unique per browser session.
+ * Get the local peer ID, as other users see it.
+ * This is unique per sync client, and null until a file is tracked.
  */
-export function getLocalPeerId(): string {
-  return state.peerId;
+export function getLocalPeerId(): string | null {
+  return state.currentAwareness?.peerId ?? null;
 }
 
 // Internal functions
 
-function startListening(): void {
-  if (!state.currentHandle) return;
-
-  state.messageHandler = (payload: DocHandleEphemeralMessagePayload<unknown>) => {
-    // The message is wrapped in a payload object
-    const message = payload.message as EphemeralMessage;
-    if (message && typeof message === 'object' && 'type' in message) {
-      handleEphemeralMessage(message);
-    }
-  };
-
-  // Listen for ephemeral messages on the document handle
-  state.currentHandle.on('ephemeral-message', state.messageHandler);
+function recordChangeAuthor(): void {
+  // Local edits are recorded as the user's in file histories
+  const identity = state.identity;
+  setChangeAuthor(identity ? { userId: identity.userId, userName: identity.userName } : null);
 }
 
-function stopListening(): void {
-  if (!state.currentHandle || !state.messageHandler) return;
-
-  state.currentHandle.off('ephemeral-message', state.messageHandler);
-  state.messageHandler = null;
+function startListening(filePath: string): void {
+  const awareness = getAwareness(filePath, {
+    throttleMs: state.config.broadcastThrottleMs,
+    staleMs: state.config.staleThresholdMs,
+  });
+  if (!awareness) return;
+
+  state.currentAwareness = awareness;
+  state.unsubscribeAwareness = awareness.subscribe((peers) => {
+    handlePeers(filePath, peers);
+  });
+  publishPresence();
 }
 
-function handleEphemeralMessage(message: EphemeralMessage): void {
-  // Ignore our own messages
-  if (message.peerId === state.peerId) {
-    return;
-  }
-
-  if (message.type === 'leave') {
-    // Remove the user from presences
-    state.remotePresences.delete(message.peerId);
-    notifySubscribers();
-    return;
-  }
+function stopListening(): void {
+  if (!state.currentAwareness) return;
 
-  if (message.type === 'presence') {
-    // Update or add the user's presence
-    const presence: PresenceState = {
-      peerId: message.peerId,
-      userId: message.userId,
-      userName: message.userName,
-      userColor: message.userColor,
-      filePath: state.currentFilePath!,
-      cursor: message.cursor,
-      selection: message.selection,
-      lastSeen: Date.now(),
-    };
-
-    state.remotePresences.set(message.peerId, presence);
-    notifySubscribers();
-  }
+  // The awareness belongs to the sync client; only withdraw our state
+  state.unsubscribeAwareness?.();
+  state.unsubscribeAwareness = null;
+  state.currentAwareness.setLocalState(null);
+  state.currentAwareness = null;
 }
 
-function scheduleBroadcast(): void {
-  const now = Date.now();
-  const timeSinceLastBroadcast = now - state.lastBroadcastTime;
-
-  // If enough time has passed, broadcast immediately
-  if (timeSinceLastBroadcast >= state.config.broadcastThrottleMs) {
-    broadcastPresence();
-    return;
-  }
-
-  // Otherwise, schedule a broadcast if one isn't already pending
-  if (!state.pendingBroadcast) {
-    const delay = state.config.broadcastThrottleMs - timeSinceLastBroadcast;
-    state.pendingBroadcast = setTimeout(() => {
-      state.pendingBroadcast = null;
-      broadcastPresence();
-    }, delay);
-  }
+function handlePeers(filePath: string, peers: PeerAwareness<UserPresence>[]): void {
+  state.remotePresences = new Map(
+    peers.map(({ peerId, state: presence, lastSeen }) => [
+      peerId,
+      {
+        peerId,
+        userId: presence.userId,
+        userName: presence.userName,
+        userColor: presence.userColor,
+        filePath,
+        cursor: presence.cursor,
+        selection: presence.selection,
+        lastSeen,
+      },
+    ])
+  );
+  notifySubscribers();
 }
 
-function broadcastPresence(): void {
-  if (!state.currentHandle || !state.identity) {
+function publishPresence(): void {
+  if (!state.currentAwareness || !state.identity) {
     return;
   }
 
-  const message: PresenceMessage = {
-    type: 'presence',
-    peerId: state.peerId,
+  state.currentAwareness.setLocalState({
     userId: state.identity.userId,
     userName: state.identity.userName,
     userColor: state.identity.userColor,
     cursor: state.localCursor,
     selection: state.localSelection,
-  };
-
-  state.currentHandle.broadcast(message);
-  state.lastBroadcastTime = Date.now();
-}
-
-function broadcastLeave(): void {
-  if (!state.currentHandle) {
-    return;
-  }
-
-  const message: PresenceLeaveMessage = {
-    type: 'leave',
-    peerId: state.peerId,
-  };
-
-  state.currentHandle.broadcast(message);
-}
-
-function cleanupStalePresences(): void {
-  const now = Date.now();
-  let changed = false;
-
-  for (const [peerId, presence] of state.remotePresences) {
-    if (now - presence.lastSeen > state.config.staleThresholdMs) {
-      state.remotePresences.delete(peerId);
-      changed = true;
-    }
-  }
-
-  if (changed) {
-    notifySubscribers();
-  }
+  });
 }
 
 function notifySubscribers(): void {
@@ -444,18 +320,14 @@ export function _resetForTesting(): void {
   cleanupPresence();
 
   // Reset state to initial values
-  state.peerId = crypto.randomUUID();
   state.identity = null;
   state.currentFilePath = null;
-  state.currentHandle = null;
+  state.currentAwareness = null;
   state.remotePresences = new Map();
   state.localCursor = null;
   state.localSelection = null;
-  state.lastBroadcastTime = 0;
-  state.pendingBroadcast = null;
-  state.cleanupInterval = null;
   state.subscribers = new Set();
-  state.messageHandler = null;
+  state.unsubscribeAwareness = null;
   state.config = { ...DEFAULT_CONFIG };
 }
 
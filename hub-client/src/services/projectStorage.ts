@@ -274,6 +274,14 @@ export async function getDatabaseSchemaVersion(): Promise<number> {
   return getSchemaVersion(db);
 }
 
+/**
+ * Get the database, with migrations applied. For other services that keep
+ * data in it.
+ */
+export function getDatabase(): Promise<IDBPDatabase> {
+  return getDb();
+}
+
 /**
  * Close the database connection.
  * Call this when you need to force a reconnection (e.g., after schema changes).
@@ -15,6 +15,7 @@ export type {
   ProjectEntryV2,
   HubDatabase,
   SassCacheEntry,
+  AutomergeStorageEntry,
 } from './types';
 
 export { DB_NAME, STORES } from './types';
@@ -29,13 +29,13 @@ import { generateColorFromId, generateAnonymousName } from './utils';
  * Current IndexedDB version.
  * Increment this when adding/removing object stores or indexes.
  */
-export const CURRENT_DB_VERSION = 3;
+export const CURRENT_DB_VERSION = 4;
 
 /**
  * Current application schema version.
  * This is the version number after all migrations have been applied.
  */
-export const CURRENT_SCHEMA_VERSION = 3;
+export const CURRENT_SCHEMA_VERSION = 4;
 
 /**
  * Baseline schema version for databases that existed before the migration system.
@@ -104,6 +104,19 @@ export const migrations: Migration[] = [
     },
     // No transform needed - cache starts empty
   },
+  // Migration 3→4: Add automerge document storage
+  {
+    version: 4,
+    description: 'Add automerge document storage for offline editing',
+    structural: (db) => {
+      // Create automergeDocs store for the sync client's repo storage
+      // Keys are automerge-repo storage keys (string arrays)
+      if (!db.objectStoreNames.contains(STORES.AUTOMERGE_DOCS)) {
+        db.createObjectStore(STORES.AUTOMERGE_DOCS, { keyPath: 'key' });
+      }
+    },
+    // No transform needed - documents are stored as they are synced
+  },
 ];
 
 /**
@@ -14,6 +14,7 @@ export const STORES = {
   PROJECTS: 'projects',
   USER_SETTINGS: 'userSettings',
   SASS_CACHE: 'sassCache',
+  AUTOMERGE_DOCS: 'automergeDocs',
 } as const;
 
 /**
@@ -137,6 +138,15 @@ export interface SassCacheEntry {
   minified: boolean;
 }
 
+/**
+ * A chunk of automerge-repo storage: a document snapshot, change or sync
+ * state, under the key automerge-repo gives it.
+ */
+export interface AutomergeStorageEntry {
+  key: string[];
+  data: Uint8Array;
+}
+
 /**
  * Type alias for the database instance used throughout the migration system.
  */
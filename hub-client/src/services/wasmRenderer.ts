@@ -6,6 +6,7 @@
  */
 
 import type { Diagnostic, RenderResponse } from '../types/diagnostic';
+import type { BlockDiff } from '../utils/blockPatch';
 import { getSassCache, computeHash } from './sassCache';
 
 // Response types from WASM module
@@ -33,6 +34,7 @@ interface WasmModuleExtended {
   render_qmd: (path: string) => Promise<string>;
   render_qmd_content: (content: string, templateBundle: string) => Promise<string>;
   render_qmd_content_with_options: (content: string, templateBundle: string, options: string) => Promise<string>;
+  diff_qmd_blocks: (before: string, after: string) => string;
   get_builtin_template: (name: string) => string;
   get_project_choices: () => string;
   create_project: (choiceId: string, title: string) => Promise<string>;
@@ -308,6 +310,16 @@ export async function renderQmdContentWithOptions(
   return JSON.parse(await wasm.render_qmd_content_with_options(content, templateBundle, optionsJson));
 }
 
+/**
+ * Diff the blocks of two versions of a QMD document, for patching the
+ * preview in place. Returns null when either version doesn't parse.
+ */
+export function diffQmdBlocks(before: string, after: string): BlockDiff | null {
+  const wasm = getWasm();
+  const result: { success: boolean; diff?: BlockDiff } = JSON.parse(wasm.diff_qmd_blocks(before, after));
+  return result.success && result.diff ? result.diff : null;
+}
+
 /**
  * Get a built-in template bundle
  */
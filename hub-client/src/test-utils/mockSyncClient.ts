@@ -43,6 +43,7 @@ export interface MockSyncClient {
   connect(syncServerUrl: string, indexDocId: string): Promise<FileEntry[]>;
   disconnect(): Promise<void>;
   isConnected(): boolean;
+  isOnline(): boolean;
   isFileBinary(path: string): boolean;
   getFileContent(path: string): string | null;
   getBinaryFileContent(path: string): { content: Uint8Array; mimeType: string } | null;
@@ -139,6 +140,7 @@ export function createMockSyncClient(
     },
 
     isConnected: () => connected,
+    isOnline: () => connected,
 
     isFileBinary(path: string): boolean {
       const file = files.get(path);
@@ -31,6 +31,8 @@ export interface Diagnostic {
   code?: string;
   problem?: string;
   hints: string[];
+  /** The file the location is in, when it isn't the rendered document. */
+  file?: string;
   start_line?: number;
   start_column?: number;
   end_line?: number;
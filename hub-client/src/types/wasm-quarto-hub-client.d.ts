@@ -39,6 +39,12 @@ declare module 'wasm-quarto-hub-client' {
   export function ast_to_qmd(ast_json: string): string;
   /** Incrementally write a modified AST back to QMD, preserving unchanged source text. */
   export function incremental_write_qmd(original_qmd: string, new_ast_json: string): string;
+  /** Diff the blocks of two versions of a document, located by `data-loc`. */
+  export function diff_qmd_blocks(before: string, after: string): string;
+  /** Read UTF-8 QMD bytes; returns a UTF-8 JSON response (see wasm-js-bridge/qmd.ts). */
+  export function read_qmd(content: Uint8Array, options_json: string): Uint8Array;
+  /** Write UTF-8 Pandoc JSON bytes as QMD; returns a UTF-8 JSON response. */
+  export function write_qmd(ast_json: Uint8Array, options_json: string): Uint8Array;
 
   // Response type for parse/write operations
   export interface AstResponse {
@@ -0,0 +1,101 @@
+/**
+ * Tests for semantic patching of the preview (jsdom environment).
+ */
+
+import { describe, it, expect } from 'vitest';
+import morphdom from 'morphdom';
+import { createPatchOptions, syncSourceAttributes, type BlockDiff } from './blockPatch';
+
+function body(html: string): HTMLElement {
+  const el = document.createElement('body');
+  el.innerHTML = html;
+  return el;
+}
+
+function patch(current: HTMLElement, html: string, diff: BlockDiff | null): void {
+  const next = body(html);
+  morphdom(current, next, createPatchOptions(next, diff));
+}
+
+describe('createPatchOptions', () => {
+  it('keeps the elements of blocks that moved down', () => {
+    const current = body(
+      '<h1 data-loc="0:1:1-2:1">Title</h1><p data-loc="0:3:1-4:1">Kept.</p>'
+    );
+    const kept = current.querySelector('p')!;
+
+    const diff: BlockDiff = {
+      meta_changed: false,
+      blocks: [
+        { op: 'inserted', kind: 'Para', before: null, after: '0:1:1-2:1' },
+        { op: 'changed', kind: 'Header', before: '0:1:1-2:1', after: '0:3:1-4:1' },
+        { op: 'unchanged', kind: 'Para', before: '0:3:1-4:1', after: '0:5:1-6:1' },
+      ],
+    };
+    patch(
+      current,
+      '<p data-loc="0:1:1-2:1">New.</p><h1 data-loc="0:3:1-4:1">Title!</h1>' +
+        '<p data-loc="0:5:1-6:1">Kept.</p>',
+      diff
+    );
+
+    const paragraphs = current.querySelectorAll('p');
+    expect(paragraphs).toHaveLength(2);
+    expect(paragraphs[0].textContent).toBe('New.');
+    expect(paragraphs[1]).toBe(kept);
+    expect(kept.getAttribute('data-loc')).toBe('0:5:1-6:1');
+    expect(current.querySelector('h1')!.textContent).toBe('Title!');
+  });
+
+  it('keeps details open', () => {
+    const current = body('<details data-loc="0:1:1-3:1"><summary>S</summary>A</details>');
+    current.querySelector('details')!.open = true;
+
+    patch(current, '<details data-loc="0:1:1-3:1"><summary>S</summary>B</details>', null);
+
+    const details = current.querySelector('details')!;
+    expect(details.open).toBe(true);
+    expect(details.textContent).toBe('SB');
+  });
+
+  it('leaves media with the same source alone', () => {
+    const current = body('<p data-loc="0:1:1-2:1"><video src="clip.mp4"></video></p>');
+    const video = current.querySelector('video')!;
+    // Stands in for playback state, which the new HTML doesn't have
+    video.setAttribute('data-playing', '');
+
+    patch(
+      current,
+      '<p data-loc="0:1:1-2:1">Intro.</p><p data-loc="0:3:1-4:1"><video src="clip.mp4"></video></p>',
+      {
+        meta_changed: false,
+        blocks: [
+          { op: 'inserted', kind: 'Para', before: null, after: '0:1:1-2:1' },
+          { op: 'unchanged', kind: 'Para', before: '0:1:1-2:1', after: '0:3:1-4:1' },
+        ],
+      }
+    );
+
+    expect(current.querySelector('video')).toBe(video);
+    expect(video.hasAttribute('data-playing')).toBe(true);
+    expect(video.parentElement!.getAttribute('data-loc')).toBe('0:3:1-4:1');
+  });
+});
+
+describe('syncSourceAttributes', () => {
+  it('copies locations onto elements with the same structure', () => {
+    const from = body('<p data-loc="0:1:1-2:1"><em data-loc="0:1:1-1:5">a</em></p>').firstElementChild!;
+    const to = body('<p data-loc="0:2:1-3:1"><em data-loc="0:2:1-2:5">a</em></p>').firstElementChild!;
+
+    expect(syncSourceAttributes(from, to)).toBe(true);
+    expect(from.outerHTML).toBe(to.outerHTML);
+  });
+
+  it('changes nothing when the structure differs', () => {
+    const from = body('<p data-loc="0:1:1-2:1">a</p>').firstElementChild!;
+    const to = body('<p data-loc="0:2:1-3:1"><em>a</em></p>').firstElementChild!;
+
+    expect(syncSourceAttributes(from, to)).toBe(false);
+    expect(from.getAttribute('data-loc')).toBe('0:1:1-2:1');
+  });
+});
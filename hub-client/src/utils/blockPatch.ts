@@ -0,0 +1,172 @@
+/**
+ * Semantic patching of the preview.
+ *
+ * When the source changes, pampa diffs the blocks of the old and the new
+ * document (see `diff_qmd_blocks`), giving each block by its `data-loc`.
+ * This module turns that diff into morphdom options, so that only the
+ * blocks that changed are patched:
+ *
+ * - Blocks are keyed by source location, with the new location of a kept
+ *   or changed block mapped back to its old one. Inserting a block above
+ *   others then inserts one element, instead of morphing every block below
+ *   into its predecessor.
+ * - A kept block whose HTML is the same apart from its locations only gets
+ *   its `data-loc` attributes updated.
+ * - `<details>` keep their open state, and `<video>` and `<audio>` with the
+ *   same source are left alone so that playback continues.
+ *
+ * Without a diff (no source locations, or the previous render failed) the
+ * document is morphed by position, with the same `<details>` and media
+ * handling.
+ */
+
+import type morphdom from 'morphdom';
+
+type MorphdomOptions = NonNullable<Parameters<typeof morphdom>[2]>;
+
+/** What happened to a block, as `pampa::ast_diff::DiffOp` */
+export type BlockDiffOp = 'unchanged' | 'changed' | 'inserted' | 'deleted';
+
+/** One block of a located diff (`pampa::ast_diff::LocatedBlockDiff`) */
+export interface BlockDiffEntry {
+  op: BlockDiffOp;
+  /** Pandoc block type, e.g. `Para` */
+  kind: string;
+  /** `data-loc` of the block in the old document */
+  before: string | null;
+  /** `data-loc` of the block in the new document */
+  after: string | null;
+  /** For a changed container, the diff of its blocks */
+  children?: BlockDiffEntry[];
+}
+
+/** The diff of two documents (`pampa::ast_diff::LocatedDocumentDiff`) */
+export interface BlockDiff {
+  meta_changed: boolean;
+  blocks: BlockDiffEntry[];
+}
+
+/** Attributes the HTML writer uses for source tracking */
+const SOURCE_ATTRIBUTES = ['data-loc', 'data-sid'];
+
+/**
+ * Old locations of the blocks of the new document, for the kept and
+ * changed blocks, and whether the block was kept.
+ */
+interface BlockMatch {
+  before: string;
+  unchanged: boolean;
+}
+
+function collectMatches(
+  entries: BlockDiffEntry[],
+  matches: Map<string, BlockMatch>,
+  oldLocations: Set<string>
+): void {
+  for (const entry of entries) {
+    if (entry.before) oldLocations.add(entry.before);
+    if (entry.before && entry.after && entry.op !== 'inserted' && entry.op !== 'deleted') {
+      matches.set(entry.after, {
+        before: entry.before,
+        unchanged: entry.op === 'unchanged',
+      });
+    }
+    if (entry.children) collectMatches(entry.children, matches, oldLocations);
+  }
+}
+
+/**
+ * Copy the source attributes of `to` and its descendants onto `from` and
+ * its descendants. Returns false, changing nothing, when the two trees
+ * don't have the same elements.
+ */
+export function syncSourceAttributes(from: Element, to: Element): boolean {
+  const fromElements = [from, ...from.querySelectorAll('*')];
+  const toElements = [to, ...to.querySelectorAll('*')];
+  if (fromElements.length !== toElements.length) return false;
+  if (fromElements.some((el, i) => el.tagName !== toElements[i].tagName)) return false;
+
+  fromElements.forEach((el, i) => {
+    for (const name of SOURCE_ATTRIBUTES) {
+      const value = toElements[i].getAttribute(name);
+      if (value === null) {
+        el.removeAttribute(name);
+      } else if (el.getAttribute(name) !== value) {
+        el.setAttribute(name, value);
+      }
+    }
+  });
+  return true;
+}
+
+function isMedia(el: Element): el is HTMLMediaElement {
+  return el.tagName === 'VIDEO' || el.tagName === 'AUDIO';
+}
+
+/**
+ * Whether two media elements play the same thing: the same `src`, or the
+ * same `<source>` children.
+ */
+function sameMedia(from: Element, to: Element): boolean {
+  const sources = (el: Element) => {
+    const children = Array.from(el.querySelectorAll('source'), (s) => s.getAttribute('src') ?? '');
+    return [el.getAttribute('src') ?? '', ...children].join('\n');
+  };
+  return sources(from) === sources(to);
+}
+
+/**
+ * morphdom options for patching the preview.
+ *
+ * @param newRoot - The element holding the new HTML, which morphdom morphs
+ *   the document into. Its elements carry new locations; the document's
+ *   carry old ones.
+ * @param diff - The block diff from the rendered source to the new one, or
+ *   null to morph by position
+ */
+export function createPatchOptions(newRoot: Element, diff: BlockDiff | null): MorphdomOptions {
+  const matches = new Map<string, BlockMatch>();
+  const oldLocations = new Set<string>();
+  if (diff) collectMatches(diff.blocks, matches, oldLocations);
+
+  return {
+    getNodeKey(node) {
+      if (node.nodeType !== Node.ELEMENT_NODE) return undefined;
+      const el = node as Element;
+      const loc = el.getAttribute('data-loc');
+      if (loc) {
+        if (newRoot.contains(el)) {
+          const match = matches.get(loc);
+          if (match) return `block:${match.before}`;
+        } else if (oldLocations.has(loc)) {
+          return `block:${loc}`;
+        }
+      }
+      return el.id || undefined;
+    },
+
+    onBeforeElUpdated(fromEl, toEl) {
+      const loc = toEl.getAttribute('data-loc');
+      const match = loc ? matches.get(loc) : undefined;
+      if (
+        match?.unchanged &&
+        fromEl.getAttribute('data-loc') === match.before &&
+        syncSourceAttributes(fromEl, toEl) &&
+        fromEl.isEqualNode(toEl)
+      ) {
+        return false;
+      }
+
+      if (isMedia(fromEl) && isMedia(toEl) && sameMedia(fromEl, toEl)) {
+        syncSourceAttributes(fromEl, toEl);
+        return false;
+      }
+
+      if (fromEl.tagName === 'DETAILS' && toEl.tagName === 'DETAILS') {
+        (toEl as HTMLDetailsElement).open = (fromEl as HTMLDetailsElement).open;
+      }
+
+      return !fromEl.isEqualNode(toEl);
+    },
+  };
+}
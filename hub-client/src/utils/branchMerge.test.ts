@@ -0,0 +1,86 @@
+import { describe, it, expect } from 'vitest';
+import { mergeBranchBlocks } from './branchMerge';
+import type { BlockDiff, BlockDiffEntry } from './blockPatch';
+
+// Paragraphs one per line pair: block `n` (0-based) of a text of
+// paragraphs separated by blank lines
+function loc(n: number): string {
+  return `0:${2 * n + 1}:1-${2 * n + 2}:1`;
+}
+
+function para(op: BlockDiffEntry['op'], before: number | null, after: number | null): BlockDiffEntry {
+  return {
+    op,
+    kind: 'Para',
+    before: before === null ? null : loc(before),
+    after: after === null ? null : loc(after),
+  };
+}
+
+function diff(...blocks: BlockDiffEntry[]): BlockDiff {
+  return { meta_changed: false, blocks };
+}
+
+describe('mergeBranchBlocks', () => {
+  const base = 'One.\n\nTwo.\n\nThree.\n';
+
+  it('takes edits of different blocks from both sides', () => {
+    const merge = mergeBranchBlocks(
+      { base, main: 'One!\n\nTwo.\n\nThree.\n', branch: 'One.\n\nTwo.\n\nThree!\n' },
+      diff(para('changed', 0, 0), para('unchanged', 1, 1), para('unchanged', 2, 2)),
+      diff(para('unchanged', 0, 0), para('unchanged', 1, 1), para('changed', 2, 2))
+    );
+
+    expect(merge.conflicts).toEqual([]);
+    expect(merge.text()).toBe('One!\n\nTwo.\n\nThree!\n');
+  });
+
+  it('reports blocks edited differently on both sides', () => {
+    const main = 'One.\n\nTwo in main.\n\nThree.\n';
+    const branch = 'One.\n\nTwo in branch.\n\nThree.\n';
+    const merge = mergeBranchBlocks(
+      { base, main, branch },
+      diff(para('unchanged', 0, 0), para('changed', 1, 1), para('unchanged', 2, 2)),
+      diff(para('unchanged', 0, 0), para('changed', 1, 1), para('unchanged', 2, 2))
+    );
+
+    expect(merge.conflicts).toEqual([
+      { kind: 'Para', base: 'Two.', main: 'Two in main.', branch: 'Two in branch.' },
+    ]);
+    expect(merge.text()).toBe(main);
+    expect(merge.text(['branch'])).toBe(branch);
+  });
+
+  it('does not report the same edit on both sides', () => {
+    const edited = 'One.\n\nTwo!\n\nThree.\n';
+    const sides = diff(para('unchanged', 0, 0), para('changed', 1, 1), para('unchanged', 2, 2));
+    const merge = mergeBranchBlocks({ base, main: edited, branch: edited }, sides, sides);
+
+    expect(merge.conflicts).toEqual([]);
+    expect(merge.text()).toBe(edited);
+  });
+
+  it('takes blocks deleted and added in the branch', () => {
+    const merge = mergeBranchBlocks(
+      { base, main: 'One!\n\nTwo.\n\nThree.\n', branch: 'One.\n\nThree.\n\nFour.\n' },
+      diff(para('changed', 0, 0), para('unchanged', 1, 1), para('unchanged', 2, 2)),
+      diff(
+        para('unchanged', 0, 0),
+        para('deleted', 1, null),
+        para('unchanged', 2, 1),
+        para('inserted', null, 2)
+      )
+    );
+
+    expect(merge.conflicts).toEqual([]);
+    expect(merge.text()).toBe('One!\n\nThree.\n\nFour.\n');
+  });
+
+  it('takes the branch when the file has no edits and a side does not parse', () => {
+    const branch = 'One.\n\n::: {\n';
+    const merge = mergeBranchBlocks({ base, main: base, branch }, diff(), null);
+
+    expect(merge.conflicts).toEqual([]);
+    expect(merge.text()).toBe(branch);
+  });
+});
@@ -0,0 +1,286 @@
+/**
+ * Three-way merge of a branch into its file, block by block.
+ *
+ * Automerge merges the text of two documents character by character, which
+ * never fails but can interleave two rewrites of a paragraph into neither.
+ * This merges at the granularity of blocks instead: a block edited on one
+ * side only takes that edit, and a block edited differently on both sides
+ * is a conflict to resolve by picking a side.
+ *
+ * The merge starts from the file's text and applies the branch's edits to
+ * it, so the file's formatting outside of edited blocks is kept. Blocks are
+ * matched through the block diff of each side against the common base (see
+ * `diffQmdBlocks`).
+ */
+
+import type { BlockDiff } from './blockPatch';
+import { spanOfLoc, type TextSpan } from './historyDiff';
+
+/** The texts of a merge (see `getBranchMergeTexts`) */
+export interface MergeTexts {
+  base: string;
+  main: string;
+  branch: string;
+}
+
+/** A block, or run of new blocks, edited differently on both sides */
+export interface MergeConflict {
+  /** Pandoc block types, e.g. `Para`, or `Front matter` */
+  kind: string;
+  /** The text before either edit, null for new blocks */
+  base: string | null;
+  /** The text in the file, null if deleted there */
+  main: string | null;
+  /** The text in the branch, null if deleted there */
+  branch: string | null;
+}
+
+/** Which side a conflict is resolved to */
+export type ConflictChoice = 'main' | 'branch';
+
+/** A merge, before its conflicts are resolved */
+export interface BranchMerge {
+  /** The conflicts, in document order */
+  conflicts: MergeConflict[];
+  /**
+   * The merged text, each conflict resolved as chosen by its index.
+   * Unresolved conflicts keep the file's side.
+   */
+  text: (choices?: ConflictChoice[]) => string;
+}
+
+// A replacement of part of the file's text
+interface Edit {
+  from: number;
+  to: number;
+  text: string;
+}
+
+// One top-level entry of a side's diff, placed in that side's text
+interface Placed {
+  /** Index of the base block, for entries that have one */
+  base?: number;
+  /** For inserted blocks, the index of the base block they come before */
+  gap?: number;
+  op: 'unchanged' | 'changed' | 'inserted' | 'deleted';
+  kind: string;
+  /** Where the block is in the side's text, null if deleted */
+  span: TextSpan | null;
+}
+
+// A side's diff against the base, in the side's document order
+function placeEntries(diff: BlockDiff, text: string): Placed[] {
+  let base = 0;
+  return diff.blocks.map((entry) => {
+    const span = entry.after ? spanOfLoc(text, entry.after) : null;
+    if (entry.before === null) {
+      return { gap: base, op: entry.op, kind: entry.kind, span };
+    }
+    return { base: base++, op: entry.op, kind: entry.kind, span };
+  });
+}
+
+// The base's blocks, placed in the base text
+function placeBase(diff: BlockDiff, text: string): Placed[] {
+  return diff.blocks
+    .filter((entry) => entry.before !== null)
+    .map((entry, base): Placed => ({
+      base,
+      op: 'unchanged',
+      kind: entry.kind,
+      span: spanOfLoc(text, entry.before!),
+    }));
+}
+
+// The text before the first block: the front matter, if any
+function headerEnd(placed: Placed[], text: string): number {
+  return placed.find((p) => p.span)?.span!.from ?? text.length;
+}
+
+function spanText(text: string, span: TextSpan | null): string | null {
+  return span ? text.slice(span.from, span.to) : null;
+}
+
+// The text of a run of blocks, from the start of the first to the end of
+// the last, with what is between them
+function runText(text: string, run: Placed[]): string {
+  const spans = run.map((p) => p.span!);
+  return text.slice(spans[0].from, spans[spans.length - 1].to);
+}
+
+// Blocks are separated by a blank line; a block's span ends with its newline
+function asBlocks(text: string): string {
+  return text.endsWith('\n') ? `${text}\n` : `${text}\n\n`;
+}
+
+function applyEdits(text: string, edits: Edit[]): string {
+  // From the end, so earlier offsets stay valid; an insertion at the start
+  // of a replaced range goes before it
+  const sorted = [...edits].sort((a, b) => b.from - a.from || b.to - a.to);
+  let result = text;
+  for (const edit of sorted) {
+    result = result.slice(0, edit.from) + edit.text + result.slice(edit.to);
+  }
+  return result;
+}
+
+function sameText(a: string | null, b: string | null): boolean {
+  return (a === null) === (b === null) && (a ?? '').trimEnd() === (b ?? '').trimEnd();
+}
+
+function trimmed(text: string | null): string | null {
+  return text === null ? null : text.trimEnd();
+}
+
+/**
+ * Merge a branch into its file. `mainDiff` and `branchDiff` are the block
+ * diffs from the base to each side; when either is missing (a side doesn't
+ * parse), the whole document is one block.
+ */
+export function mergeBranchBlocks(
+  texts: MergeTexts,
+  mainDiff: BlockDiff | null,
+  branchDiff: BlockDiff | null
+): BranchMerge {
+  const { base, main, branch } = texts;
+
+  const baseCount = (diff: BlockDiff) => diff.blocks.filter((e) => e.before !== null).length;
+  if (!mainDiff || !branchDiff || baseCount(mainDiff) !== baseCount(branchDiff)) {
+    return mergeWhole(texts);
+  }
+
+  const mainPlaced = placeEntries(mainDiff, main);
+  const branchPlaced = placeEntries(branchDiff, branch);
+  const basePlaced = placeBase(mainDiff, base);
+
+  const edits: Edit[] = [];
+  const conflicts: MergeConflict[] = [];
+  const conflictEdits: Edit[][] = [];
+
+  const conflict = (entry: MergeConflict, resolution: Edit[]) => {
+    conflicts.push(entry);
+    conflictEdits.push(resolution);
+  };
+
+  // Where text goes in the file to come before the blocks from gap `gap`
+  // on: the start of the next block the file still has
+  const insertionPoint = (gap: number): number => {
+    const next = mainPlaced.find((p) => p.span && (p.base ?? p.gap!) >= gap);
+    return next ? next.span!.from : main.length;
+  };
+
+  const insertBlocks = (at: number, blocks: string): Edit => {
+    if (at < main.length) return { from: at, to: at, text: asBlocks(blocks) };
+    const separator = main === '' ? '' : main.endsWith('\n') ? '\n' : '\n\n';
+    return { from: at, to: at, text: separator + blocks + (blocks.endsWith('\n') ? '' : '\n') };
+  };
+
+  // Deleting a block takes the blank line after it
+  const deleteBlock = (placed: Placed): Edit => {
+    const next = mainPlaced.slice(mainPlaced.indexOf(placed) + 1).find((p) => p.span);
+    return { from: placed.span!.from, to: next ? next.span!.from : main.length, text: '' };
+  };
+
+  // The front matter
+  const baseHeader = base.slice(0, headerEnd(basePlaced, base));
+  const mainHeader = main.slice(0, headerEnd(mainPlaced, main));
+  const branchHeader = branch.slice(0, headerEnd(branchPlaced, branch));
+  if (!sameText(baseHeader, branchHeader) && !sameText(mainHeader, branchHeader)) {
+    const edit = { from: 0, to: mainHeader.length, text: branchHeader };
+    if (sameText(baseHeader, mainHeader)) {
+      edits.push(edit);
+    } else {
+      conflict(
+        {
+          kind: 'Front matter',
+          base: trimmed(baseHeader),
+          main: trimmed(mainHeader),
+          branch: trimmed(branchHeader),
+        },
+        [edit]
+      );
+    }
+  }
+
+  for (let i = 0; i <= basePlaced.length; i++) {
+    // New blocks before base block i
+    const mainNew = mainPlaced.filter((p) => p.gap === i);
+    const branchNew = branchPlaced.filter((p) => p.gap === i);
+    if (branchNew.length > 0) {
+      const branchRun = runText(branch, branchNew);
+      if (mainNew.length === 0) {
+        edits.push(insertBlocks(insertionPoint(i), branchRun));
+      } else {
+        const mainRun = runText(main, mainNew);
+        if (!sameText(mainRun, branchRun)) {
+          const first = mainNew[0].span!;
+          const last = mainNew[mainNew.length - 1].span!;
+          conflict(
+            {
+              kind: branchNew.map((p) => p.kind).join(', '),
+              base: null,
+              main: trimmed(mainRun),
+              branch: trimmed(branchRun),
+            },
+            [{ from: first.from, to: last.to, text: branchRun }]
+          );
+        }
+      }
+    }
+    if (i === basePlaced.length) break;
+
+    // Base block i
+    const ours = mainPlaced.find((p) => p.base === i)!;
+    const theirs = branchPlaced.find((p) => p.base === i)!;
+    if (theirs.op === 'unchanged') continue;
+
+    const oursText = spanText(main, ours.span);
+    const theirsText = spanText(branch, theirs.span);
+    if (sameText(oursText, theirsText)) continue;
+
+    let resolution: Edit;
+    if (!theirs.span) {
+      resolution = deleteBlock(ours);
+    } else if (!ours.span) {
+      resolution = insertBlocks(insertionPoint(i), theirsText!);
+    } else {
+      resolution = { from: ours.span.from, to: ours.span.to, text: theirsText! };
+    }
+
+    if (ours.op === 'unchanged') {
+      edits.push(resolution);
+    } else {
+      conflict(
+        {
+          kind: theirs.kind,
+          base: trimmed(spanText(base, basePlaced[i].span)),
+          main: trimmed(oursText),
+          branch: trimmed(theirsText),
+        },
+        [resolution]
+      );
+    }
+  }
+
+  return {
+    conflicts,
+    text: (choices = []) => {
+      const merged = applyEdits(main, [
+        ...edits,
+        ...conflictEdits.flatMap((resolution, i) => (choices[i] === 'branch' ? resolution : [])),
+      ]);
+      // Deleting the last blocks leaves the blank line before them
+      return main.endsWith('\n') ? merged.replace(/\n+$/, '\n') : merged;
+    },
+  };
+}
+
+// The merge of documents that can't be matched block by block
+function mergeWhole({ base, main, branch }: MergeTexts): BranchMerge {
+  if (main === branch || branch === base) return { conflicts: [], text: () => main };
+  if (main === base) return { conflicts: [], text: () => branch };
+  return {
+    conflicts: [{ kind: 'Document', base, main, branch }],
+    text: (choices = []) => (choices[0] === 'branch' ? branch : main),
+  };
+}
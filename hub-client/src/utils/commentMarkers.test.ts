@@ -0,0 +1,52 @@
+import { describe, it, expect } from 'vitest';
+import type { LocatedCommentThread } from '@quarto/quarto-sync-client';
+import { commentTooltip, rangeOfDataLoc } from './commentMarkers';
+
+function thread(quote: string, bodies: string[]): LocatedCommentThread {
+  return {
+    id: quote,
+    start: { after: null },
+    end: { after: null },
+    quote,
+    comments: bodies.map((body, i) => ({
+      id: `${quote}-${i}`,
+      author: { userId: 'u1', userName: 'Alice' },
+      body,
+      createdAt: '2026-01-01T00:00:00.000Z',
+    })),
+    createdAt: '2026-01-01T00:00:00.000Z',
+    status: 'open',
+    from: 0,
+    to: quote.length,
+    detached: false,
+  };
+}
+
+describe('rangeOfDataLoc', () => {
+  it('parses the range without the file ID', () => {
+    expect(rangeOfDataLoc('0:3:1-4:12')).toEqual({
+      startLine: 3,
+      startCol: 1,
+      endLine: 4,
+      endCol: 12,
+    });
+  });
+
+  it('rejects malformed locations', () => {
+    expect(rangeOfDataLoc('3:1-4:12')).toBeNull();
+  });
+});
+
+describe('commentTooltip', () => {
+  it('shows the first comment of each thread', () => {
+    expect(commentTooltip([thread('a', ['Why?']), thread('b', ['Typo'])])).toBe(
+      'Alice: Why?\nAlice: Typo'
+    );
+  });
+
+  it('counts replies', () => {
+    expect(commentTooltip([thread('a', ['Why?', 'Because.', 'OK'])])).toBe(
+      'Alice: Why? (2 more)'
+    );
+  });
+});
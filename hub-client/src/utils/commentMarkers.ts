@@ -0,0 +1,88 @@
+/**
+ * Comment markers for the preview.
+ *
+ * Rendered blocks that have open comment threads are marked with a
+ * `data-comments` attribute holding the number of threads, and a tooltip
+ * quoting them unless the block has a title of its own. The threads of a
+ * block are found from its `data-loc`.
+ */
+
+import type { LocatedCommentThread, SourceRange } from '@quarto/quarto-sync-client';
+
+const MARKER_STYLE_ID = 'q2-comment-markers';
+
+const MARKER_STYLE = `
+  [data-comments] {
+    box-shadow: inset 3px 0 0 #f1c40f;
+  }
+`;
+
+/**
+ * Parse the range of a `data-loc` attribute ("fileId:line:col-line:col").
+ * Returns null if the format is invalid.
+ */
+export function rangeOfDataLoc(dataLoc: string): SourceRange | null {
+  const match = dataLoc.match(/^\d+:(\d+):(\d+)-(\d+):(\d+)$/);
+  if (!match) return null;
+  return {
+    startLine: parseInt(match[1], 10),
+    startCol: parseInt(match[2], 10),
+    endLine: parseInt(match[3], 10),
+    endCol: parseInt(match[4], 10),
+  };
+}
+
+/**
+ * The tooltip of a block with comment threads: one line per thread, with
+ * its first comment and the number of replies.
+ */
+export function commentTooltip(threads: LocatedCommentThread[]): string {
+  return threads
+    .map((thread) => {
+      const [first, ...replies] = thread.comments;
+      const text = first ? `${first.author.userName}: ${first.body}` : `"${thread.quote}"`;
+      return replies.length > 0 ? `${text} (${replies.length} more)` : text;
+    })
+    .join('\n');
+}
+
+/**
+ * Mark the outermost blocks of a preview document that have comment threads,
+ * and unmark those that no longer do.
+ */
+export function markCommentedBlocks(
+  doc: Document,
+  threadsForBlock: (range: SourceRange) => LocatedCommentThread[]
+): void {
+  if (!doc.getElementById(MARKER_STYLE_ID)) {
+    const style = doc.createElement('style');
+    style.id = MARKER_STYLE_ID;
+    style.textContent = MARKER_STYLE;
+    doc.head.appendChild(style);
+  }
+
+  for (const element of doc.querySelectorAll('[data-comments]')) {
+    element.removeAttribute('data-comments');
+    if (element.hasAttribute('data-comments-title')) {
+      element.removeAttribute('data-comments-title');
+      element.removeAttribute('title');
+    }
+  }
+
+  for (const element of doc.querySelectorAll('[data-loc]')) {
+    // Inlines and nested blocks are covered by their block
+    if (element.parentElement?.closest('[data-loc]')) continue;
+
+    const range = rangeOfDataLoc(element.getAttribute('data-loc') ?? '');
+    if (!range) continue;
+
+    const threads = threadsForBlock(range);
+    if (threads.length === 0) continue;
+
+    element.setAttribute('data-comments', String(threads.length));
+    if (!element.hasAttribute('title')) {
+      element.setAttribute('data-comments-title', '');
+      element.setAttribute('title', commentTooltip(threads));
+    }
+  }
+}
@@ -0,0 +1,61 @@
+import { describe, it, expect } from 'vitest';
+import { sourceOfLoc, summarizeBlockDiff } from './historyDiff';
+import type { BlockDiff } from './blockPatch';
+
+describe('sourceOfLoc', () => {
+  const source = '# Title\n\nFirst para\nstill first.\n\nSecond.\n';
+
+  it('returns the text of a range', () => {
+    expect(sourceOfLoc(source, '0:1:1-2:1')).toBe('# Title');
+    expect(sourceOfLoc(source, '0:3:1-5:1')).toBe('First para\nstill first.');
+  });
+
+  it('slices columns within lines', () => {
+    expect(sourceOfLoc(source, '0:1:3-1:8')).toBe('Title');
+  });
+
+  it('rejects malformed locations', () => {
+    expect(sourceOfLoc(source, 'nope')).toBeNull();
+  });
+});
+
+describe('summarizeBlockDiff', () => {
+  it('lists changed blocks with their text', () => {
+    const before = 'One.\n\nTwo.\n\n- a\n- b\n';
+    const after = 'One.\n\nTwo!\n\n- a\n- c\n\nThree.\n';
+    const diff: BlockDiff = {
+      meta_changed: false,
+      blocks: [
+        { op: 'unchanged', kind: 'Para', before: '0:1:1-2:1', after: '0:1:1-2:1' },
+        { op: 'changed', kind: 'Para', before: '0:3:1-4:1', after: '0:3:1-4:1' },
+        {
+          op: 'changed',
+          kind: 'BulletList',
+          before: '0:5:1-7:1',
+          after: '0:5:1-7:1',
+          children: [
+            { op: 'unchanged', kind: 'Plain', before: '0:5:3-6:1', after: '0:5:3-6:1' },
+            { op: 'changed', kind: 'Plain', before: '0:6:3-7:1', after: '0:6:3-7:1' },
+          ],
+        },
+        { op: 'inserted', kind: 'Para', before: null, after: '0:8:1-9:1' },
+      ],
+    };
+
+    expect(summarizeBlockDiff(diff, before, after)).toEqual([
+      { op: 'changed', kind: 'Para', text: 'Two!', previousText: 'Two.' },
+      { op: 'changed', kind: 'Plain', text: 'c', previousText: 'b' },
+      { op: 'inserted', kind: 'Para', text: 'Three.' },
+    ]);
+  });
+
+  it('gives deleted blocks their old text', () => {
+    const diff: BlockDiff = {
+      meta_changed: false,
+      blocks: [{ op: 'deleted', kind: 'Para', before: '0:1:1-2:1', after: null }],
+    };
+    expect(summarizeBlockDiff(diff, 'Gone.\n', '')).toEqual([
+      { op: 'deleted', kind: 'Para', text: 'Gone.' },
+    ]);
+  });
+});
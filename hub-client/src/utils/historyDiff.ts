@@ -0,0 +1,97 @@
+/**
+ * Summaries of what changed between two versions of a document, for the
+ * history panel.
+ *
+ * The block diff (see `diffQmdBlocks`) says which blocks were inserted,
+ * deleted or changed, by their `data-loc` in each version. This turns it
+ * into a flat list of changed blocks with their source text.
+ */
+
+import type { BlockDiff, BlockDiffEntry, BlockDiffOp } from './blockPatch';
+
+/** One changed block, with its source text. */
+export interface BlockChange {
+  op: Exclude<BlockDiffOp, 'unchanged'>;
+  /** Pandoc block type, e.g. `Para` */
+  kind: string;
+  /** The block's text before (for deleted blocks) or after the change */
+  text: string;
+  /** The old text of a changed block */
+  previousText?: string;
+}
+
+/** A range of a text, in UTF-16 offsets, end exclusive */
+export interface TextSpan {
+  from: number;
+  to: number;
+}
+
+/**
+ * The range of a `data-loc` ("file:line:col-line:col", 1-based, end
+ * exclusive, columns in characters) in a document.
+ */
+export function spanOfLoc(source: string, loc: string): TextSpan | null {
+  const match = loc.match(/^\d+:(\d+):(\d+)-(\d+):(\d+)$/);
+  if (!match) return null;
+  const [startLine, startCol, endLine, endCol] = match.slice(1).map(Number);
+  return { from: offsetOf(source, startLine, startCol), to: offsetOf(source, endLine, endCol) };
+}
+
+// The offset of a line and column, clamped to the line and the text
+function offsetOf(source: string, line: number, col: number): number {
+  let offset = 0;
+  for (let l = 1; l < line; l++) {
+    const newline = source.indexOf('\n', offset);
+    if (newline === -1) return source.length;
+    offset = newline + 1;
+  }
+  for (let c = 1; c < col && offset < source.length && source[offset] !== '\n'; c++) {
+    offset += source.codePointAt(offset)! > 0xffff ? 2 : 1;
+  }
+  return offset;
+}
+
+/**
+ * The source text of a `data-loc` range in a document, without its
+ * trailing newline.
+ */
+export function sourceOfLoc(source: string, loc: string): string | null {
+  const span = spanOfLoc(source, loc);
+  return span ? source.slice(span.from, span.to).trimEnd() : null;
+}
+
+/**
+ * The blocks that changed between `before` and `after`, in document
+ * order. A changed container is listed by its changed children.
+ */
+export function summarizeBlockDiff(diff: BlockDiff, before: string, after: string): BlockChange[] {
+  const changes: BlockChange[] = [];
+
+  const visit = (entries: BlockDiffEntry[]) => {
+    for (const entry of entries) {
+      if (entry.op === 'unchanged') continue;
+      if (entry.children && entry.children.length > 0) {
+        visit(entry.children);
+        continue;
+      }
+
+      const oldText = entry.before ? sourceOfLoc(before, entry.before) : null;
+      const newText = entry.after ? sourceOfLoc(after, entry.after) : null;
+      if (entry.op === 'deleted') {
+        changes.push({ op: 'deleted', kind: entry.kind, text: oldText ?? '' });
+      } else if (entry.op === 'inserted') {
+        changes.push({ op: 'inserted', kind: entry.kind, text: newText ?? '' });
+      } else {
+        changes.push({
+          op: 'changed',
+          kind: entry.kind,
+          text: newText ?? '',
+          previousText: oldText ?? undefined,
+        });
+      }
+    }
+  };
+
+  visit(diff.blocks);
+  return changes;
+}
@@ -0,0 +1,79 @@
+import { describe, it, expect } from 'vitest';
+import { readFileSync } from 'fs';
+import { dirname, join } from 'path';
+import { fileURLToPath } from 'url';
+import {
+  TRACE_FORMAT_VERSION,
+  TRACE_PHASES,
+  blockTimings,
+  parseRenderTrace,
+  type RenderTrace,
+} from './renderTrace';
+
+const schemaPath = join(
+  dirname(fileURLToPath(import.meta.url)),
+  '../../../crates/pampa/resources/trace.schema.json'
+);
+
+const source = '# Title\n\n```{python}\n1 + 1\n```\n\nText.\n';
+
+const trace: RenderTrace = {
+  version: TRACE_FORMAT_VERSION,
+  input: 'doc.qmd',
+  spans: [
+    { phase: 'read', name: 'parse-document', start_us: 0, duration_us: 100 },
+    { phase: 'execute', name: 'jupyter', start_us: 100, duration_us: 5000, locs: ['0:3:1-6:1'] },
+    { phase: 'transform', name: 'callouts', start_us: 5100, duration_us: 20, locs: ['0:7:1-8:1'] },
+    { phase: 'transform', name: 'sectionize', start_us: 5120, duration_us: 30, locs: ['0:3:1-6:1'] },
+  ],
+};
+
+describe('the trace schema', () => {
+  const schema = JSON.parse(readFileSync(schemaPath, 'utf-8'));
+
+  it('has the version and phases read here', () => {
+    expect(schema.properties.version.const).toBe(TRACE_FORMAT_VERSION);
+    expect(schema.$defs.span.properties.phase.enum).toEqual([...TRACE_PHASES]);
+  });
+
+  it('has the fields of the types here', () => {
+    expect(Object.keys(schema.properties).sort()).toEqual(Object.keys(trace).sort());
+    expect(Object.keys(schema.$defs.span.properties).sort()).toEqual(
+      Object.keys(trace.spans[1]).sort()
+    );
+  });
+});
+
+describe('parseRenderTrace', () => {
+  it('reads a trace', () => {
+    expect(parseRenderTrace(JSON.stringify(trace))).toEqual(trace);
+  });
+
+  it('rejects other versions', () => {
+    expect(() => parseRenderTrace(JSON.stringify({ ...trace, version: 1 }))).toThrow(
+      /version 1/
+    );
+  });
+
+  it('rejects unknown phases', () => {
+    const bad = { ...trace, spans: [{ ...trace.spans[0], phase: 'compile' }] };
+    expect(() => parseRenderTrace(JSON.stringify(bad))).toThrow(/span 0/);
+  });
+});
+
+describe('blockTimings', () => {
+  it('totals the spans of each block, slowest first', () => {
+    const timings = blockTimings(trace, source);
+    expect(timings.map((t) => t.loc)).toEqual(['0:3:1-6:1', '0:7:1-8:1']);
+    expect(timings[0].durationUs).toBe(5030);
+    expect(timings[0].spans.map((s) => s.name)).toEqual(['jupyter', 'sectionize']);
+    expect(source.slice(timings[0].range.from, timings[0].range.to)).toBe(
+      '```{python}\n1 + 1\n```\n'
+    );
+  });
+
+  it('leaves out malformed locations', () => {
+    const bad = { ...trace, spans: [{ ...trace.spans[1], locs: ['nope'] }] };
+    expect(blockTimings(bad, source)).toEqual([]);
+  });
+});
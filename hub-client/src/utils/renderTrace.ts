@@ -0,0 +1,112 @@
+/**
+ * Render traces: how long each part of a render took, as written by
+ * `pampa --trace` and `quarto render --trace`.
+ *
+ * The format is described by `crates/pampa/resources/trace.schema.json`.
+ * Spans that changed blocks of the document list them by `data-loc`. This
+ * aligns those with the document's source, so that the slow cells and
+ * transforms of a document can be found.
+ */
+
+import { spanOfLoc, type TextSpan } from './historyDiff';
+
+/** The version of the trace format read */
+export const TRACE_FORMAT_VERSION = 2;
+
+/** The phases of a render, as in traces */
+export const TRACE_PHASES = ['read', 'transform', 'execute', 'filter', 'write'] as const;
+
+export type TracePhase = (typeof TRACE_PHASES)[number];
+
+/** One timed part of a render */
+export interface TraceSpan {
+  phase: TracePhase;
+  /** What ran, e.g. the name of a transform or the execution engine */
+  name: string;
+  /** When the span started, in microseconds since the trace started */
+  start_us: number;
+  duration_us: number;
+  /** The `data-loc`s of the top-level blocks the span changed */
+  locs?: string[];
+}
+
+/** The trace of rendering one document */
+export interface RenderTrace {
+  version: number;
+  /** The input file */
+  input: string;
+  /** The spans in the order they ended. Spans can nest. */
+  spans: TraceSpan[];
+}
+
+/** The time spent on one block of a document */
+export interface BlockTiming {
+  loc: string;
+  /** Where the block is in the source */
+  range: TextSpan;
+  /** The total duration of the spans that changed the block */
+  durationUs: number;
+  spans: TraceSpan[];
+}
+
+function isSpan(value: unknown): value is TraceSpan {
+  if (!value || typeof value !== 'object') return false;
+  const span = value as Partial<TraceSpan>;
+  return (
+    TRACE_PHASES.includes(span.phase as TracePhase) &&
+    typeof span.name === 'string' &&
+    Number.isInteger(span.start_us) &&
+    Number.isInteger(span.duration_us) &&
+    (span.locs === undefined ||
+      (Array.isArray(span.locs) && span.locs.every((loc) => typeof loc === 'string')))
+  );
+}
+
+/**
+ * Read a trace from its JSON. Throws if it isn't a trace of the version
+ * read.
+ */
+export function parseRenderTrace(json: string): RenderTrace {
+  const value: unknown = JSON.parse(json);
+  if (!value || typeof value !== 'object') {
+    throw new Error('Not a render trace');
+  }
+  const trace = value as Partial<RenderTrace>;
+  if (trace.version !== TRACE_FORMAT_VERSION) {
+    throw new Error(
+      `Unsupported render trace version ${String(trace.version)} (expected ${TRACE_FORMAT_VERSION})`
+    );
+  }
+  if (typeof trace.input !== 'string' || !Array.isArray(trace.spans)) {
+    throw new Error('Not a render trace');
+  }
+  const invalid = trace.spans.findIndex((span) => !isSpan(span));
+  if (invalid !== -1) {
+    throw new Error(`Invalid span ${invalid} in render trace`);
+  }
+  return trace as RenderTrace;
+}
+
+/**
+ * The blocks of `source` that the spans of a trace changed, slowest first.
+ * Locations that aren't in the source are left out.
+ */
+export function blockTimings(trace: RenderTrace, source: string): BlockTiming[] {
+  const timings = new Map<string, BlockTiming>();
+  for (const span of trace.spans) {
+    for (const loc of span.locs ?? []) {
+      let timing = timings.get(loc);
+      if (!timing) {
+        const range = spanOfLoc(source, loc);
+        if (!range) continue;
+        timing = { loc, range, durationUs: 0, spans: [] };
+        timings.set(loc, timing);
+      }
+      timing.durationUs += span.duration_us;
+      timing.spans.push(span);
+    }
+  }
+  return Array.from(timings.values()).sort(
+    (a, b) => b.durationUs - a.durationUs || a.range.from - b.range.from
+  );
+}
@@ -0,0 +1,172 @@
+/**
+ * Typed QMD Read/Write for the Browser
+ *
+ * Unlike the other modules in this directory, which Rust calls into, this
+ * one is called from JavaScript: it wraps the `read_qmd` and `write_qmd`
+ * exports of wasm-quarto-hub-client so that the preview and the hub client
+ * can convert documents without a server round trip.
+ *
+ * Both exports take and return bytes. Strings are encoded to UTF-8 once on
+ * the way in, and the returned Uint8Array owns its ArrayBuffer, so a
+ * result can be posted to or from a worker as a transferable:
+ *
+ *   const bytes = readQmdBytes(text);
+ *   worker.postMessage(bytes, [bytes.buffer]);
+ */
+
+import type { AstDiagnostic, AstResponse } from 'wasm-quarto-hub-client';
+
+/**
+ * A Pandoc JSON document as written by pampa, with its source info pool.
+ */
+export interface PandocJSON {
+  'pandoc-api-version': number[];
+  meta: Record<string, unknown>;
+  blocks: unknown[];
+  astContext: {
+    files: { name: string; line_breaks?: number[]; total_length?: number }[];
+    sourceInfoPool?: unknown[];
+    metaTopLevelKeySources?: unknown;
+  };
+}
+
+export interface ReadQmdOptions {
+  /** Resolve the source location of every node to lines and columns. */
+  sourcepos?: boolean;
+  /** Join `[^id]` references with their definitions (default true). */
+  footnotes?: boolean;
+  /** Read `--`, `---` and `...` as dashes and ellipses. */
+  smart?: boolean;
+  /**
+   * Give every block a `blockId` made from its content, which stays the
+   * same when the document is written and read again.
+   */
+  block_ids?: boolean;
+}
+
+export interface WriteQmdOptions {
+  /** How paragraphs are wrapped (default 'preserve'). */
+  wrap?: 'auto' | 'none' | 'preserve';
+  /** Line width for `wrap: 'auto'`. */
+  columns?: number;
+  /** Write dashes and ellipses as `---`, `--` and `...`. */
+  smart?: boolean;
+}
+
+/** The result of a read, with its warnings. */
+export interface ReadQmdResult {
+  ast: PandocJSON;
+  warnings: AstDiagnostic[];
+}
+
+/**
+ * Error thrown when a document can't be read or written.
+ */
+export class QmdError extends Error {
+  readonly diagnostics: AstDiagnostic[];
+
+  constructor(message: string, diagnostics: AstDiagnostic[] = []) {
+    super(message);
+    this.name = 'QmdError';
+    this.diagnostics = diagnostics;
+  }
+}
+
+/** The exports of wasm-quarto-hub-client that this module uses. */
+export interface QmdWasmModule {
+  read_qmd: (content: Uint8Array, optionsJson: string) => Uint8Array;
+  write_qmd: (astJson: Uint8Array, optionsJson: string) => Uint8Array;
+}
+
+interface ReadResponse {
+  success: boolean;
+  ast?: PandocJSON;
+  warnings?: AstDiagnostic[];
+  error?: string;
+  diagnostics?: AstDiagnostic[];
+}
+
+let wasmModule: QmdWasmModule | null = null;
+
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+/**
+ * Make the WASM exports available. With no argument this initializes the
+ * hub client's WASM module; workers and tests that have initialized a
+ * module themselves pass it in.
+ */
+export async function initQmd(module?: QmdWasmModule): Promise<void> {
+  if (module) {
+    wasmModule = module;
+    return;
+  }
+  if (wasmModule) return;
+  const { initWasm } = await import('../services/wasmRenderer');
+  await initWasm();
+  wasmModule = (await import('wasm-quarto-hub-client')) as unknown as QmdWasmModule;
+}
+
+function getWasm(): QmdWasmModule {
+  if (!wasmModule) {
+    throw new Error('QMD bridge not initialized. Call initQmd() first.');
+  }
+  return wasmModule;
+}
+
+function toBytes(input: string | Uint8Array): Uint8Array {
+  return typeof input === 'string' ? encoder.encode(input) : input;
+}
+
+/**
+ * Read QMD into the raw UTF-8 JSON response, for passing to a worker
+ * without decoding it here. The response is `{ success, ast, warnings }`
+ * or `{ success: false, error, diagnostics }`.
+ */
+export function readQmdBytes(text: string | Uint8Array, options: ReadQmdOptions = {}): Uint8Array {
+  return getWasm().read_qmd(toBytes(text), JSON.stringify(options));
+}
+
+/**
+ * Decode a response from `readQmdBytes`.
+ */
+export function decodeReadResponse(bytes: Uint8Array): ReadQmdResult {
+  const response: ReadResponse = JSON.parse(decoder.decode(bytes));
+  if (!response.success || !response.ast) {
+    throw new QmdError(response.error ?? 'Failed to read QMD', response.diagnostics);
+  }
+  return { ast: response.ast, warnings: response.warnings ?? [] };
+}
+
+/**
+ * Read QMD, returning the document and its warnings.
+ *
+ * @throws QmdError with the diagnostics when the document can't be read
+ */
+export function readQmdWithWarnings(text: string | Uint8Array, options: ReadQmdOptions = {}): ReadQmdResult {
+  return decodeReadResponse(readQmdBytes(text, options));
+}
+
+/**
+ * Read QMD into a Pandoc JSON document.
+ *
+ * @throws QmdError with the diagnostics when the document can't be read
+ */
+export function readQmd(text: string | Uint8Array, options: ReadQmdOptions = {}): PandocJSON {
+  return readQmdWithWarnings(text, options).ast;
+}
+
+/**
+ * Write a Pandoc JSON document as QMD. The document may also be given as
+ * UTF-8 JSON bytes, as received from a worker.
+ *
+ * @throws QmdError when the document can't be written
+ */
+export function writeQmd(ast: PandocJSON | Uint8Array, options: WriteQmdOptions = {}): string {
+  const bytes = ast instanceof Uint8Array ? ast : encoder.encode(JSON.stringify(ast));
+  const response: AstResponse = JSON.parse(decoder.decode(getWasm().write_qmd(bytes, JSON.stringify(options))));
+  if (!response.success || response.qmd === undefined) {
+    throw new QmdError(response.error ?? 'Failed to write QMD', response.diagnostics);
+  }
+  return response.qmd;
+}
@@ -0,0 +1,107 @@
+/**
+ * WASM Tests for the typed QMD read/write bridge
+ *
+ * Exercises readQmd and writeQmd against the real read_qmd and write_qmd
+ * exports.
+ *
+ * Run with: npm run test:wasm
+ */
+
+import { describe, it, expect, beforeAll } from 'vitest';
+import { readFile } from 'fs/promises';
+import { dirname, join } from 'path';
+import { fileURLToPath } from 'url';
+import {
+  initQmd,
+  readQmd,
+  readQmdBytes,
+  readQmdWithWarnings,
+  decodeReadResponse,
+  writeQmd,
+  QmdError,
+  type PandocJSON,
+  type QmdWasmModule,
+} from './qmd';
+
+beforeAll(async () => {
+  const __dirname = dirname(fileURLToPath(import.meta.url));
+  const wasmDir = join(__dirname, '../../wasm-quarto-hub-client');
+  const wasmBytes = await readFile(join(wasmDir, 'wasm_quarto_hub_client_bg.wasm'));
+
+  const wasm = (await import('wasm-quarto-hub-client')) as unknown as QmdWasmModule & {
+    default: (input?: BufferSource) => Promise<void>;
+  };
+  await wasm.default(wasmBytes);
+  await initQmd(wasm);
+});
+
+describe('readQmd', () => {
+  it('reads a document into Pandoc JSON', () => {
+    const ast = readQmd('# Title\n\nSome *text*.\n');
+    expect(ast['pandoc-api-version'][0]).toBe(1);
+    expect((ast.blocks as { t: string }[]).map((b) => b.t)).toEqual(['Header', 'Para']);
+  });
+
+  it('accepts UTF-8 bytes', () => {
+    const ast = readQmd(new TextEncoder().encode('Café.\n'));
+    expect(ast.blocks).toHaveLength(1);
+  });
+
+  it('applies smart punctuation when asked', () => {
+    const plain = JSON.stringify(readQmd('1990--2000\n'));
+    const smart = JSON.stringify(readQmd('1990--2000\n', { smart: true }));
+    expect(plain).toContain('1990--2000');
+    expect(smart).toContain('1990–2000');
+  });
+
+  it('gives blocks IDs that survive a write and a read', () => {
+    const text = '# Title\n\nSome *text*.\n';
+    const ids = (ast: PandocJSON) => (ast.blocks as { blockId?: string }[]).map((b) => b.blockId);
+    const ast = readQmd(text, { block_ids: true });
+    expect(ids(ast).every((id) => typeof id === 'string')).toBe(true);
+    expect(ids(readQmd(writeQmd(ast), { block_ids: true }))).toEqual(ids(ast));
+    expect(ids(readQmd(text))).toEqual([undefined, undefined]);
+  });
+
+  it('throws a QmdError with diagnostics on a broken document', () => {
+    try {
+      readQmd('[}no]{.hello}\n');
+      expect.unreachable();
+    } catch (e) {
+      expect(e).toBeInstanceOf(QmdError);
+      expect((e as QmdError).diagnostics.length).toBeGreaterThan(0);
+      expect((e as QmdError).diagnostics[0].kind).toBe('error');
+    }
+  });
+
+  it('returns warnings alongside the document', () => {
+    const { ast, warnings } = readQmdWithWarnings('Text.\n');
+    expect(ast.blocks).toHaveLength(1);
+    expect(Array.isArray(warnings)).toBe(true);
+  });
+
+  it('returns a transferable response from readQmdBytes', () => {
+    const bytes = readQmdBytes('Text.\n');
+    expect(bytes.byteOffset).toBe(0);
+    expect(bytes.buffer.byteLength).toBe(bytes.byteLength);
+    expect(decodeReadResponse(bytes).ast.blocks).toHaveLength(1);
+  });
+});
+
+describe('writeQmd', () => {
+  it('round-trips a document', () => {
+    const source = '# Title\n\nSome *text*.\n';
+    expect(writeQmd(readQmd(source))).toBe(source);
+  });
+
+  it('wraps paragraphs when asked', () => {
+    const source = 'one two three four five six seven eight nine ten\n';
+    const qmd = writeQmd(readQmd(source), { wrap: 'auto', columns: 20 });
+    expect(qmd.split('\n').every((line) => line.length <= 20)).toBe(true);
+  });
+
+  it('rejects an unknown wrap mode', () => {
+    const ast = readQmd('Text.\n');
+    expect(() => writeQmd(ast, { wrap: 'sideways' as 'auto' })).toThrow(QmdError);
+  });
+});
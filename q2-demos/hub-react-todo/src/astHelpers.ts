@@ -11,12 +11,27 @@
  * AST structure:
  *   Div (id="todo")
  *     BulletList
- *       Item: [Plain: [Span([], []), Space, Str("..."), ...]]
- *       Item: [Plain: [Span([], [Str("x")]), Space, Str("..."), ...]]
+ *       Item: [Plain: [Str("☐"), Space, Str("..."), ...]]
+ *       Item: [Plain: [Str("☒"), Space, Str("..."), ...]]
  */
 
 import type { RustQmdJson, Annotated_Block, Annotated_Inline } from '@quarto/pandoc-types'
 
+/** The checkbox a `- [ ]` task list item starts with, as the reader writes it */
+const UNCHECKED = '\u2610'
+const CHECKED = '\u2612'
+
+/**
+ * Whether an inline is a task list checkbox: whether it is checked,
+ * or null when it isn't one.
+ */
+function checkboxState(inline: Annotated_Inline | undefined): boolean | null {
+  if (!inline || inline.t !== 'Str') return null
+  if (inline.c === UNCHECKED) return false
+  if (inline.c === CHECKED) return true
+  return null
+}
+
 export interface TodoItem {
   checked: boolean
   label: string
@@ -62,17 +77,12 @@ export function extractTodoItems(todoDiv: Annotated_Block): TodoItem[] {
     const inlines = block.c as Annotated_Inline[]
     if (!inlines || inlines.length === 0) continue
 
-    // First inline should be a Span (the checkbox)
-    const firstInline = inlines[0]
-    if (!firstInline || firstInline.t !== 'Span') continue
-
-    // Span.c = [Attr, Inline[]]
-    const spanContent = firstInline.c[1] as Annotated_Inline[]
-    const checked = spanContent.length > 0 &&
-      spanContent.some(i => i.t === 'Str' && i.c === 'x')
+    // First inline should be the checkbox
+    const checked = checkboxState(inlines[0])
+    if (checked === null) continue
 
-    // Label: remaining inlines after the Span, concatenated
-    const label = inlinesToText(inlines.slice(1))
+    // Label: remaining inlines after the checkbox and its space, concatenated
+    const label = inlinesToText(inlines.slice(2))
 
     items.push({ checked, label, itemIndex: i })
   }
@@ -107,20 +117,12 @@ export function toggleCheckbox(ast: RustQmdJson, itemIndex: number): RustQmdJson
   const inlines = block.c as Annotated_Inline[]
   if (!inlines || inlines.length === 0) return null
 
-  const firstInline = inlines[0]
-  if (!firstInline || firstInline.t !== 'Span') return null
+  const checkbox = inlines[0]
+  const isChecked = checkboxState(checkbox)
+  if (isChecked === null || checkbox.t !== 'Str') return null
 
-  // Toggle the checkbox content
-  const spanContent = firstInline.c[1] as Annotated_Inline[]
-  const isChecked = spanContent.length > 0 && spanContent.some(i => i.t === 'Str' && i.c === 'x')
-
-  if (isChecked) {
-    // Uncheck: clear the span content
-    firstInline.c[1] = []
-  } else {
-    // Check: add Str("x") to the span content
-    firstInline.c[1] = [{ t: 'Str', c: 'x', s: 0 } as Annotated_Inline]
-  }
+  // Toggle the checkbox
+  checkbox.c = isChecked ? UNCHECKED : CHECKED
 
   return cloned
 }
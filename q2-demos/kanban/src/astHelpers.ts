@@ -21,6 +21,21 @@ import type { KanbanCard, KanbanBoard, CardRef, CardType, CardStatus } from './t
 const KNOWN_CARD_TYPES: Set<string> = new Set(['feature', 'milestone', 'bug', 'task'])
 const KNOWN_STATUSES: Set<string> = new Set(['todo', 'doing', 'done'])
 
+/** The checkbox a `- [ ]` task list item starts with, as the reader writes it */
+const UNCHECKED = '\u2610'
+const CHECKED = '\u2612'
+
+/**
+ * Whether an inline is a task list checkbox: whether it is checked,
+ * or null when it isn't one.
+ */
+function checkboxState(inline: Annotated_Inline): boolean | null {
+  if (inline.t !== 'Str') return null
+  if (inline.c === UNCHECKED) return false
+  if (inline.c === CHECKED) return true
+  return null
+}
+
 /**
  * Extract all kanban cards from the AST.
  *
@@ -81,7 +96,8 @@ export function extractCards(ast: RustQmdJson): KanbanCard[] {
  * Extract cross-references from a card's body blocks.
  *
  * Looks for BulletList items containing Link inlines with "#" targets.
- * Items with a Span before the Link are checkbox items (from `- [ ]` syntax).
+ * Items that start with a checkbox before the Link are checkbox items (from
+ * `- [ ]` syntax).
  */
 export function extractCardRefs(card: KanbanCard): CardRef[] {
   const refs: CardRef[] = []
@@ -181,20 +197,11 @@ export function toggleMilestoneItem(ast: RustQmdJson, cardId: string, itemIndex:
 
   const inlines = firstBlock.c as Annotated_Inline[]
 
-  // Find the Span (checkbox)
-  const span = inlines.find(i => i.t === 'Span')
-  if (!span) return null
-
-  const spanContent = (span.c as [unknown, Annotated_Inline[]])[1]
-  const isChecked = spanContent.length > 0 && spanContent.some(
-    (i: Annotated_Inline) => i.t === 'Str' && i.c === 'x'
-  )
+  // Find the checkbox
+  const checkbox = inlines.find(i => checkboxState(i) !== null)
+  if (!checkbox || checkbox.t !== 'Str') return null
 
-  if (isChecked) {
-    ;(span.c as [unknown, Annotated_Inline[]])[1] = []
-  } else {
-    ;(span.c as [unknown, Annotated_Inline[]])[1] = [{ t: 'Str', c: 'x', s: 0 } as Annotated_Inline]
-  }
+  checkbox.c = checkboxState(checkbox) ? UNCHECKED : CHECKED
 
   return cloned
 }
@@ -269,12 +276,10 @@ function extractRefFromInlines(
   let checked = false
 
   for (const inline of inlines) {
-    if (inline.t === 'Span') {
+    const state = checkboxState(inline)
+    if (state !== null) {
       hasCheckbox = true
-      const spanContent = (inline.c as [unknown, Annotated_Inline[]])[1]
-      checked = spanContent.length > 0 && spanContent.some(
-        (i: Annotated_Inline) => i.t === 'Str' && i.c === 'x'
-      )
+      checked = state
     }
 
     if (inline.t === 'Link') {
@@ -44,7 +44,7 @@ export interface CardRef {
   targetCardId: string
   /** Display text of the link. */
   label: string
-  /** Whether this is a checkbox item (has a Span before the Link). */
+  /** Whether this is a checkbox item (has a checkbox before the Link). */
   isCheckbox: boolean
   /** If isCheckbox, whether the checkbox is checked. */
   checked: boolean
@@ -0,0 +1,280 @@
+#!/usr/bin/env python3
+"""
+Corpus Benchmark Script
+
+Fetches the corpus for pampa's corpus benchmarks, turns criterion's results
+into one JSON file, and compares two such files so CI can fail on
+regressions.
+
+Requirements:
+    - Python 3.10 or later
+    - git (for fetch)
+
+Usage:
+    ./scripts/bench-corpus.py pin
+    ./scripts/bench-corpus.py fetch
+    cargo bench -p pampa --bench corpus
+    ./scripts/bench-corpus.py summarize -o current.json
+    ./scripts/bench-corpus.py compare baseline.json current.json --threshold 10
+
+Corpus:
+    The sources are listed in crates/pampa/benches/corpus-sources.json: a git
+    URL, a rev, a glob of the files to take and, optionally, a limit (the
+    largest files are taken first). `fetch` copies the files into
+    target/bench-corpus/<name>/ and records the commit each rev resolved to in
+    target/bench-corpus/SOURCES.json. `summarize` copies those commits into
+    its output, and `compare` refuses to compare results from different
+    commits, since the numbers wouldn't be about the same documents. Every
+    rev should be a commit, so that the corpus doesn't change under the
+    benchmarks; `pin` replaces branch names and tags with the commits they
+    point to now, and `fetch` warns about a rev that isn't a commit.
+
+Comparing:
+    `compare` checks the medians of the benchmarks found in both files. It
+    fails (exit status 1) when a phase's total time grows by more than
+    --threshold percent, or a single file's by more than --file-threshold
+    percent; single files are noisier, so their threshold is higher.
+"""
+
+import argparse
+import glob
+import json
+import os
+import shutil
+import subprocess
+import sys
+import re
+import tempfile
+from pathlib import Path
+
+REPO_ROOT = Path(__file__).resolve().parent.parent
+SOURCES_FILE = REPO_ROOT / "crates" / "pampa" / "benches" / "corpus-sources.json"
+DEFAULT_CORPUS = REPO_ROOT / "target" / "bench-corpus"
+DEFAULT_CRITERION = REPO_ROOT / "target" / "criterion"
+
+# The benchmark groups of crates/pampa/benches/corpus.rs
+PHASES = ("read", "write", "roundtrip")
+
+SUMMARY_VERSION = 1
+
+
+COMMIT = re.compile(r"[0-9a-f]{40}")
+
+
+def git(*args, cwd=None):
+    return subprocess.run(
+        ["git", *args], cwd=cwd, check=True, capture_output=True, text=True
+    ).stdout.strip()
+
+
+def fetch(args):
+    sources = json.loads(SOURCES_FILE.read_text())
+    corpus = Path(args.corpus)
+    if corpus.exists():
+        shutil.rmtree(corpus)
+    corpus.mkdir(parents=True)
+
+    fetched = []
+    with tempfile.TemporaryDirectory() as tmp:
+        for source in sources:
+            if not COMMIT.fullmatch(source["rev"]):
+                print(f"warning: {source['name']} is not pinned to a commit "
+                      f"(rev {source['rev']!r}); run ./scripts/bench-corpus.py pin",
+                      file=sys.stderr)
+            checkout = Path(tmp) / source["name"]
+            print(f"Fetching {source['name']} at {source['rev']}...", file=sys.stderr)
+            git("clone", "--quiet", "--filter=blob:none", "--no-checkout",
+                source["url"], str(checkout))
+            git("checkout", "--quiet", source["rev"], cwd=checkout)
+            commit = git("rev-parse", "HEAD", cwd=checkout)
+
+            files = [
+                Path(path)
+                for path in glob.glob(source["include"], root_dir=checkout, recursive=True)
+                if (checkout / path).is_file()
+            ]
+            # Largest first, then by path, so a limit always picks the same files
+            files.sort(key=lambda path: (-(checkout / path).stat().st_size, str(path)))
+            if "limit" in source:
+                files = files[: source["limit"]]
+
+            for path in files:
+                target = corpus / source["name"] / path
+                target.parent.mkdir(parents=True, exist_ok=True)
+                shutil.copyfile(checkout / path, target)
+
+            fetched.append({
+                "name": source["name"],
+                "url": source["url"],
+                "commit": commit,
+                "files": len(files),
+            })
+            print(f"  {len(files)} files at {commit}", file=sys.stderr)
+
+    (corpus / "SOURCES.json").write_text(json.dumps(fetched, indent=2) + "\n")
+
+
+def pin(args):
+    sources = json.loads(SOURCES_FILE.read_text())
+    for source in sources:
+        if COMMIT.fullmatch(source["rev"]):
+            continue
+        refs = git("ls-remote", source["url"], source["rev"]).splitlines()
+        if not refs:
+            sys.exit(f"{source['name']}: no ref {source['rev']!r} in {source['url']}")
+        commit = refs[0].split()[0]
+        print(f"{source['name']}: {source['rev']} -> {commit}", file=sys.stderr)
+        source["rev"] = commit
+    SOURCES_FILE.write_text(json.dumps(sources, indent=2) + "\n")
+
+
+def summarize(args):
+    criterion = Path(args.criterion_dir)
+    benchmarks = {}
+    for phase in PHASES:
+        for path in sorted((criterion / phase).glob("**/new/benchmark.json")):
+            info = json.loads(path.read_text())
+            estimates = json.loads((path.parent / "estimates.json").read_text())
+            benchmarks[info["full_id"]] = {
+                "phase": info["group_id"],
+                "file": info["function_id"],
+                "bytes": (info.get("throughput") or {}).get("Bytes"),
+                "median_ns": estimates["median"]["point_estimate"],
+            }
+    if not benchmarks:
+        sys.exit(f"No corpus benchmark results under {criterion}; "
+                 "run cargo bench -p pampa --bench corpus first")
+
+    sources_file = Path(args.corpus) / "SOURCES.json"
+    summary = {
+        "version": SUMMARY_VERSION,
+        # Empty when the bench ran on the fallback documents
+        "corpus": json.loads(sources_file.read_text()) if sources_file.exists() else [],
+        "benchmarks": benchmarks,
+    }
+    text = json.dumps(summary, indent=2, sort_keys=True) + "\n"
+    if args.output:
+        Path(args.output).write_text(text)
+    else:
+        sys.stdout.write(text)
+
+
+def corpus_commits(summary):
+    return {source["name"]: source["commit"] for source in summary["corpus"]}
+
+
+def compare(args):
+    baseline = json.loads(Path(args.baseline).read_text())
+    current = json.loads(Path(args.current).read_text())
+    for summary, name in ((baseline, args.baseline), (current, args.current)):
+        if summary.get("version") != SUMMARY_VERSION:
+            sys.exit(f"{name}: unsupported summary version {summary.get('version')}")
+    if corpus_commits(baseline) != corpus_commits(current):
+        print("The two results are from different corpus commits:", file=sys.stderr)
+        print(f"  baseline: {corpus_commits(baseline)}", file=sys.stderr)
+        print(f"  current:  {corpus_commits(current)}", file=sys.stderr)
+        sys.exit(2)
+
+    shared = sorted(set(baseline["benchmarks"]) & set(current["benchmarks"]))
+    files = []
+    totals = {phase: [0.0, 0.0] for phase in PHASES}
+    for bench_id in shared:
+        before = baseline["benchmarks"][bench_id]
+        after = current["benchmarks"][bench_id]
+        totals[after["phase"]][0] += before["median_ns"]
+        totals[after["phase"]][1] += after["median_ns"]
+        files.append({
+            "id": bench_id,
+            "baseline_ns": before["median_ns"],
+            "current_ns": after["median_ns"],
+            "change": after["median_ns"] / before["median_ns"] - 1,
+        })
+
+    phases = [
+        {
+            "phase": phase,
+            "baseline_ns": before,
+            "current_ns": after,
+            "change": after / before - 1,
+        }
+        for phase, (before, after) in totals.items()
+        if before > 0
+    ]
+    regressions = [p for p in phases if p["change"] * 100 > args.threshold] + [
+        f for f in files if f["change"] * 100 > args.file_threshold
+    ]
+
+    if args.json:
+        json.dump({
+            "threshold": args.threshold,
+            "file_threshold": args.file_threshold,
+            "phases": phases,
+            "files": files,
+            "regressions": regressions,
+            "only_in_baseline": sorted(set(baseline["benchmarks"]) - set(current["benchmarks"])),
+            "only_in_current": sorted(set(current["benchmarks"]) - set(baseline["benchmarks"])),
+        }, sys.stdout, indent=2)
+        sys.stdout.write("\n")
+    else:
+        print(f"{len(shared)} benchmarks in both results")
+        for phase in phases:
+            print(f"  {phase['phase']:<10} {phase['change']:+7.1%}  "
+                  f"({phase['baseline_ns'] / 1e6:.1f} ms -> {phase['current_ns'] / 1e6:.1f} ms)")
+        if regressions:
+            print(f"\nRegressions (phases over {args.threshold}%, "
+                  f"files over {args.file_threshold}%):")
+            for regression in sorted(regressions, key=lambda r: -r["change"]):
+                print(f"  {regression.get('id', regression.get('phase'))}: "
+                      f"{regression['change']:+.1%}")
+        else:
+            print("\nNo regressions")
+
+    sys.exit(1 if regressions else 0)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.split("\n\n")[1].strip())
+    commands = parser.add_subparsers(dest="command", required=True)
+
+    pin_parser = commands.add_parser(
+        "pin", help="pin the revs of corpus-sources.json to the commits they point to")
+    pin_parser.set_defaults(run=pin)
+
+    fetch_parser = commands.add_parser("fetch", help="fetch the corpus")
+    fetch_parser.add_argument("--corpus", default=str(DEFAULT_CORPUS),
+                              help="where to put the corpus (default: target/bench-corpus)")
+    fetch_parser.set_defaults(run=fetch)
+
+    summarize_parser = commands.add_parser(
+        "summarize", help="collect criterion's results into one JSON file")
+    summarize_parser.add_argument("--criterion-dir", default=str(DEFAULT_CRITERION),
+                                  help="criterion's output (default: target/criterion)")
+    summarize_parser.add_argument("--corpus", default=str(DEFAULT_CORPUS),
+                                  help="the corpus benchmarked (default: target/bench-corpus)")
+    summarize_parser.add_argument("-o", "--output", help="write to a file instead of stdout")
+    summarize_parser.set_defaults(run=summarize)
+
+    compare_parser = commands.add_parser(
+        "compare", help="compare results against a baseline")
+    compare_parser.add_argument("baseline")
+    compare_parser.add_argument("current")
+    compare_parser.add_argument(
+        "--threshold", type=float,
+        default=float(os.environ.get("BENCH_REGRESSION_THRESHOLD", 10)),
+        help="allowed growth of a phase's total time, in percent "
+             "(default: $BENCH_REGRESSION_THRESHOLD or 10)")
+    compare_parser.add_argument(
+        "--file-threshold", type=float,
+        default=float(os.environ.get("BENCH_FILE_REGRESSION_THRESHOLD", 25)),
+        help="allowed growth of a single file's time, in percent "
+             "(default: $BENCH_FILE_REGRESSION_THRESHOLD or 25)")
+    compare_parser.add_argument("--json", action="store_true",
+                                help="print the comparison as JSON")
+    compare_parser.set_defaults(run=compare)
+
+    args = parser.parse_args()
+    args.run(args)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+#
+# Copy the Pandoc templates quarto-cli ships into the doctemplate grammar's
+# corpus (crates/tree-sitter-doctemplate/test-templates/quarto-cli).
+#
+# Usage: scripts/sync-doctemplate-corpus.sh [QUARTO_CLI_DIR]
+#
+# QUARTO_CLI_DIR defaults to external-sources/quarto-cli.
+
+set -euo pipefail
+
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+QUARTO_CLI="${1:-$ROOT/external-sources/quarto-cli}"
+FORMATS="$QUARTO_CLI/src/resources/formats"
+DEST="$ROOT/crates/tree-sitter-doctemplate/test-templates/quarto-cli"
+
+if [ ! -d "$FORMATS" ]; then
+  echo "error: $FORMATS not found; check out quarto-cli first" >&2
+  exit 1
+fi
+
+# Keep the README, replace everything else
+find "$DEST" -mindepth 1 ! -name README.md -delete
+
+count=0
+while IFS= read -r -d '' file; do
+  rel="${file#"$FORMATS"/}"
+  mkdir -p "$DEST/$(dirname "$rel")"
+  cp "$file" "$DEST/$rel"
+  count=$((count + 1))
+done < <(find "$FORMATS" -type f \
+  \( -path '*/pandoc/*' -o -path '*/html/templates/*' \) \
+  \( -name '*.html' -o -name '*.tex' -o -name '*.latex' -o -name '*.typ' \
+     -o -name '*.template' -o -name '*.styles' \) \
+  -print0)
+
+echo "Copied $count templates to $DEST"
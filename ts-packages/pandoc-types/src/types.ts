@@ -14,6 +14,8 @@
  * - Annotated_Block extends Block by adding `s: number` field
  * - Elements with attributes add `attrS: AttrSourceInfo` for attribute source tracking
  * - Elements with targets add `targetS: TargetSourceInfo` for target source tracking
+ * - With the `block_ids` reader extension, blocks add `blockId: string`, an
+ *   identifier made from their content that survives re-parses
  *
  * This design ensures that quarto-markdown-pandoc JSON output is valid Pandoc JSON
  * and can be processed by the standard Pandoc toolchain.
@@ -543,12 +545,14 @@ export interface Annotated_Block_Plain {
   t: "Plain";
   c: Annotated_Inline[];
   s: number;
+  blockId?: string;
 }
 
 export interface Annotated_Block_Para {
   t: "Para";
   c: Annotated_Inline[];
   s: number;
+  blockId?: string;
 }
 
 // Headers (with attrS)
@@ -556,6 +560,7 @@ export interface Annotated_Block_Header {
   t: "Header";
   c: [number, Attr, Annotated_Inline[]];
   s: number;
+  blockId?: string;
   attrS: AttrSourceInfo;
 }
 
@@ -564,6 +569,7 @@ export interface Annotated_Block_CodeBlock {
   t: "CodeBlock";
   c: [Attr, string];
   s: number;
+  blockId?: string;
   attrS: AttrSourceInfo;
 }
 
@@ -571,6 +577,7 @@ export interface Annotated_Block_RawBlock {
   t: "RawBlock";
   c: [string, string];  // [format, content]
   s: number;
+  blockId?: string;
 }
 
 // Block quotes
@@ -578,6 +585,7 @@ export interface Annotated_Block_BlockQuote {
   t: "BlockQuote";
   c: Annotated_Block[];
   s: number;
+  blockId?: string;
 }
 
 // Lists
@@ -585,18 +593,21 @@ export interface Annotated_Block_BulletList {
   t: "BulletList";
   c: Annotated_Block[][];  // List of items
   s: number;
+  blockId?: string;
 }
 
 export interface Annotated_Block_OrderedList {
   t: "OrderedList";
   c: [ListAttributes, Annotated_Block[][]];
   s: number;
+  blockId?: string;
 }
 
 export interface Annotated_Block_DefinitionList {
   t: "DefinitionList";
   c: [Annotated_Inline[], Annotated_Block[][]][];  // [(term, definitions)]
   s: number;
+  blockId?: string;
 }
 
 // Structural (with attrS)
@@ -604,17 +615,20 @@ export interface Annotated_Block_Div {
   t: "Div";
   c: [Attr, Annotated_Block[]];
   s: number;
+  blockId?: string;
   attrS: AttrSourceInfo;
 }
 
 export interface Annotated_Block_HorizontalRule {
   t: "HorizontalRule";
   s: number;
+  blockId?: string;
 }
 
 export interface Annotated_Block_Null {
   t: "Null";
   s: number;
+  blockId?: string;
 }
 
 // Annotated table array types
@@ -636,6 +650,7 @@ export interface Annotated_Block_Table {
   t: "Table";
   c: [Attr, Annotated_CaptionArray, ColSpec[], Annotated_TableHead_Array, Annotated_TableBody_Array[], Annotated_TableFoot_Array];
   s: number;
+  blockId?: string;
   attrS: AttrSourceInfo;
   captionS: number; // Source info ref for caption
   headS: TableHeadSourceInfo;
@@ -648,6 +663,7 @@ export interface Annotated_Block_Figure {
   t: "Figure";
   c: [Attr, Annotated_CaptionArray, Annotated_Block[]];
   s: number;
+  blockId?: string;
   attrS: AttrSourceInfo;
 }
 
@@ -13,17 +13,27 @@
 /**
  * Root document that maps file paths to Automerge document IDs.
  * This is the entry point for a Quarto project in Automerge.
+ *
+ * Indexes created before branches existed have no `branches`; see
+ * `migrateIndexDocument`.
  */
 export interface IndexDocument {
   files: Record<string, string>; // path -> docId mapping
+  branches?: Record<string, FileBranch>; // id -> branch
 }
 
 /**
  * Text document content (e.g., .qmd, .yml files).
  * Identified by presence of 'text' field.
+ *
+ * Documents created before suggestions existed have no `suggestions` or
+ * `schemaVersion`; see `migrateTextDocument`.
  */
 export interface TextDocumentContent {
   text: string; // Automerge Text type serializes to string
+  suggestions?: Record<string, Suggestion>; // id -> suggestion
+  comments?: Record<string, CommentThread>; // id -> thread
+  schemaVersion?: number; // TEXT_DOCUMENT_VERSION; absent means 1
 }
 
 /**
@@ -46,6 +56,163 @@ export type FileDocumentContent = TextDocumentContent | BinaryDocumentContent;
  */
 export type DocumentType = 'text' | 'binary' | 'invalid';
 
+// ============================================================================
+// Suggestion Types
+// ============================================================================
+
+/**
+ * Current version of the text document schema.
+ * - 1: `text` only
+ * - 2: adds `suggestions`
+ * - 3: adds `comments`
+ */
+export const TEXT_DOCUMENT_VERSION = 3;
+
+/**
+ * An Automerge cursor into a document's `text` (see `getCursor`).
+ * It names a character rather than an index, so it stays with that
+ * character as text is inserted and deleted around it.
+ */
+export type TextCursor = string;
+
+/**
+ * A position between two characters of the text: right after the character
+ * `after` names, or the start of the text when `after` is null. If that
+ * character is deleted, the position is where it was.
+ *
+ * Text inserted at the position goes after it, so a range from one anchor
+ * to another grows with insertions at its end but not at its start, and
+ * keeps the text that replaces its own first or last characters.
+ */
+export interface TextAnchor {
+  after: TextCursor | null;
+}
+
+/**
+ * Who made or resolved a suggestion or comment.
+ */
+export interface SuggestionAuthor {
+  userId: string;
+  userName: string;
+}
+
+export type SuggestionStatus = 'open' | 'accepted' | 'rejected';
+
+/**
+ * A proposed change to a text document: replace the text between `start`
+ * and `end` with `inserted`. An insertion has `start` equal to `end`; a
+ * deletion has an empty `inserted`.
+ *
+ * The suggestion doesn't change `text` until it is accepted. Accepted and
+ * rejected suggestions are kept, as the record of the review.
+ */
+export interface Suggestion {
+  id: string;
+  start: TextAnchor;
+  end: TextAnchor;
+  deleted: string; // the replaced text, as it was when suggested
+  inserted: string;
+  author: SuggestionAuthor;
+  createdAt: string; // ISO 8601
+  status: SuggestionStatus;
+  resolvedBy?: SuggestionAuthor;
+  resolvedAt?: string; // ISO 8601
+}
+
+// ============================================================================
+// Comment Types
+// ============================================================================
+
+export type CommentThreadStatus = 'open' | 'resolved';
+
+/**
+ * One comment of a thread.
+ */
+export interface ThreadComment {
+  id: string;
+  author: SuggestionAuthor;
+  body: string;
+  createdAt: string; // ISO 8601
+}
+
+/**
+ * A discussion about the text between `start` and `end`. The first comment
+ * starts the thread; replies are appended. `quote` is the text of the range
+ * when the thread was started, to show once that text has changed.
+ *
+ * Resolved threads are kept and can be reopened.
+ */
+export interface CommentThread {
+  id: string;
+  start: TextAnchor;
+  end: TextAnchor;
+  quote: string;
+  comments: ThreadComment[];
+  createdAt: string; // ISO 8601
+  status: CommentThreadStatus;
+  resolvedBy?: SuggestionAuthor;
+  resolvedAt?: string; // ISO 8601
+}
+
+// ============================================================================
+// Branch Types
+// ============================================================================
+
+/**
+ * A named branch of a text file: a separate document cloned from the
+ * file's, so the two share their history up to the fork. The branch is
+ * edited without touching the file and merged back into it.
+ *
+ * `baseHeads` are the file's heads when the branch was forked or last
+ * merged, the common ancestor of the two for the next merge.
+ */
+export interface FileBranch {
+  id: string;
+  name: string;
+  path: string; // the file the branch is of
+  docId: string;
+  baseHeads: string[];
+  createdBy: SuggestionAuthor;
+  createdAt: string; // ISO 8601
+  mergedAt?: string; // ISO 8601, of the last merge
+}
+
+// ============================================================================
+// Migration
+// ============================================================================
+
+/**
+ * Bring a text document up to the current schema version. Call it on the
+ * draft inside a change; it only writes fields that are missing.
+ *
+ * Migrate when a document is loaded rather than on its first suggestion
+ * or comment. Two peers that create `suggestions` or `comments`
+ * concurrently each make their own map and only one survives the merge, so
+ * entries made before the merge could be lost.
+ *
+ * Returns true if the document was changed.
+ */
+export function migrateTextDocument(doc: TextDocumentContent): boolean {
+  if ((doc.schemaVersion ?? 1) >= TEXT_DOCUMENT_VERSION) return false;
+  if (!doc.suggestions) doc.suggestions = {};
+  if (!doc.comments) doc.comments = {};
+  doc.schemaVersion = TEXT_DOCUMENT_VERSION;
+  return true;
+}
+
+/**
+ * Add the fields of the current schema that an index document is missing,
+ * for the same reason as `migrateTextDocument`. Call it on the draft inside
+ * a change.
+ *
+ * Returns true if the document was changed.
+ */
+export function migrateIndexDocument(doc: IndexDocument): boolean {
+  if (doc.branches) return false;
+  doc.branches = {};
+  return true;
+}
+
 // ============================================================================
 // File Entry Types
 // ============================================================================
@@ -0,0 +1,32 @@
+import { describe, it, expect } from 'vitest';
+import { canComment, canEdit, hasRole, requireRole } from './access.js';
+
+describe('hasRole', () => {
+  it('orders roles from viewer to owner', () => {
+    expect(hasRole('owner', 'editor')).toBe(true);
+    expect(hasRole('editor', 'editor')).toBe(true);
+    expect(hasRole('commenter', 'editor')).toBe(false);
+    expect(hasRole('viewer', 'commenter')).toBe(false);
+  });
+});
+
+describe('canEdit and canComment', () => {
+  it('let commenters comment but not edit', () => {
+    expect(canEdit('commenter')).toBe(false);
+    expect(canComment('commenter')).toBe(true);
+  });
+
+  it('let viewers do neither', () => {
+    expect(canEdit('viewer')).toBe(false);
+    expect(canComment('viewer')).toBe(false);
+  });
+});
+
+describe('requireRole', () => {
+  it('throws naming the operation and the role', () => {
+    expect(() => requireRole('viewer', 'editor', 'edit files')).toThrow(
+      'Cannot edit files: this project is shared with you as a viewer'
+    );
+    expect(() => requireRole('editor', 'commenter', 'comment')).not.toThrow();
+  });
+});
@@ -0,0 +1,38 @@
+/**
+ * Roles: which local operations a client may perform.
+ *
+ * The sync server drops changes a connection's role doesn't allow, so a
+ * client that made them anyway would diverge from the server until it
+ * reloads. Operations check the role first and throw instead.
+ */
+
+import type { DocumentRole } from './types.js';
+
+const ROLE_ORDER: DocumentRole[] = ['viewer', 'commenter', 'editor', 'owner'];
+
+/**
+ * Whether `role` includes everything `needed` may do.
+ */
+export function hasRole(role: DocumentRole, needed: DocumentRole): boolean {
+  return ROLE_ORDER.indexOf(role) >= ROLE_ORDER.indexOf(needed);
+}
+
+/** Whether a role may change document content. */
+export function canEdit(role: DocumentRole): boolean {
+  return hasRole(role, 'editor');
+}
+
+/** Whether a role may write suggestions and comments. */
+export function canComment(role: DocumentRole): boolean {
+  return hasRole(role, 'commenter');
+}
+
+/**
+ * Throw unless `role` includes `needed`. `operation` names what was
+ * attempted, for the error message.
+ */
+export function requireRole(role: DocumentRole, needed: DocumentRole, operation: string): void {
+  if (!hasRole(role, needed)) {
+    throw new Error(`Cannot ${operation}: this project is shared with you as a ${role}`);
+  }
+}
@@ -0,0 +1,155 @@
+import { describe, it, expect, beforeEach, afterEach, vi } from 'vitest';
+import { createAwareness } from './awareness.js';
+import type { Awareness, EphemeralHandle } from './awareness.js';
+import type { UserPresence } from './types.js';
+
+type Listener = (payload: { message: unknown }) => void;
+
+/**
+ * Connect fake document handles the way a sync server does: a broadcast on
+ * one handle reaches the listeners of the others. Delivery can be paused to
+ * stand in for a lost connection.
+ */
+function createNetwork() {
+  const handles: { listeners: Set<Listener> }[] = [];
+  let delivering = true;
+
+  function handle(): EphemeralHandle {
+    const self = { listeners: new Set<Listener>() };
+    handles.push(self);
+    return {
+      broadcast(message: unknown) {
+        if (!delivering) return;
+        for (const other of handles) {
+          if (other === self) continue;
+          for (const listener of other.listeners) listener({ message });
+        }
+      },
+      on(_event: string, listener: Listener) {
+        self.listeners.add(listener);
+      },
+      off(_event: string, listener: Listener) {
+        self.listeners.delete(listener);
+      },
+    } as unknown as EphemeralHandle;
+  }
+
+  return {
+    handle,
+    setDelivering(value: boolean) {
+      delivering = value;
+    },
+  };
+}
+
+function presence(userName: string, cursor: number | null = null): UserPresence {
+  return { userId: userName.toLowerCase(), userName, userColor: '#3498db', cursor, selection: null };
+}
+
+describe('createAwareness', () => {
+  let network: ReturnType<typeof createNetwork>;
+  let alice: Awareness<UserPresence>;
+  let bob: Awareness<UserPresence>;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    network = createNetwork();
+    alice = createAwareness<UserPresence>(network.handle(), { peerId: 'alice' });
+    bob = createAwareness<UserPresence>(network.handle(), { peerId: 'bob' });
+  });
+
+  afterEach(() => {
+    alice.dispose();
+    bob.dispose();
+    vi.useRealTimers();
+  });
+
+  it('shares local state with other peers', () => {
+    alice.setLocalState(presence('Alice', 3));
+
+    const peers = bob.getPeers();
+    expect(peers).toHaveLength(1);
+    expect(peers[0].peerId).toBe('alice');
+    expect(peers[0].state.cursor).toBe(3);
+    expect(alice.getPeers()).toHaveLength(0);
+  });
+
+  it('throttles broadcasts and sends the latest state', () => {
+    alice.setLocalState(presence('Alice', 1));
+    alice.setLocalState(presence('Alice', 2));
+    alice.setLocalState(presence('Alice', 3));
+    expect(bob.getPeers()[0].state.cursor).toBe(1);
+
+    vi.advanceTimersByTime(50);
+    expect(bob.getPeers()[0].state.cursor).toBe(3);
+  });
+
+  it('notifies subscribers', () => {
+    const callback = vi.fn();
+    const unsubscribe = bob.subscribe(callback);
+    expect(callback).toHaveBeenLastCalledWith([]);
+
+    alice.setLocalState(presence('Alice'));
+    expect(callback).toHaveBeenCalledTimes(2);
+    expect(callback.mock.lastCall![0][0].state.userName).toBe('Alice');
+
+    unsubscribe();
+    vi.advanceTimersByTime(50);
+    alice.setLocalState(presence('Alice', 5));
+    expect(callback).toHaveBeenCalledTimes(2);
+  });
+
+  it('removes peers that leave', () => {
+    alice.setLocalState(presence('Alice'));
+    expect(bob.getPeers()).toHaveLength(1);
+
+    alice.setLocalState(null);
+    expect(bob.getPeers()).toHaveLength(0);
+
+    alice.setLocalState(presence('Alice'));
+    alice.dispose();
+    expect(bob.getPeers()).toHaveLength(0);
+  });
+
+  it('keeps idle peers with heartbeats and drops silent ones', () => {
+    alice.setLocalState(presence('Alice'));
+
+    // Alice's state doesn't change, but the heartbeat keeps her around
+    vi.advanceTimersByTime(10_000);
+    expect(bob.getPeers()).toHaveLength(1);
+
+    // Alice stops being heard from without leaving
+    network.setDelivering(false);
+    vi.advanceTimersByTime(6_000);
+    expect(bob.getPeers()).toHaveLength(0);
+  });
+
+  it('tells new peers its state', () => {
+    alice.setLocalState(presence('Alice'));
+    const carol = createAwareness<UserPresence>(network.handle(), { peerId: 'carol' });
+    expect(carol.getPeers()).toHaveLength(0);
+
+    // Alice answers the first message she gets from Carol
+    carol.setLocalState(presence('Carol'));
+    vi.advanceTimersByTime(50);
+    expect(carol.getPeers().map((p) => p.peerId)).toEqual(['alice']);
+    carol.dispose();
+  });
+
+  it('ignores messages delivered out of order', () => {
+    const handle = network.handle();
+    const send = (clock: number, cursor: number) =>
+      handle.broadcast({ type: 'awareness', peerId: 'dave', clock, state: presence('Dave', cursor) });
+
+    send(2, 20);
+    send(1, 10);
+    expect(alice.getPeers().find((p) => p.peerId === 'dave')?.state.cursor).toBe(20);
+  });
+
+  it('ignores other ephemeral messages', () => {
+    const handle = network.handle();
+    handle.broadcast({ type: 'presence', peerId: 'old-client' });
+    handle.broadcast('not an object');
+    expect(bob.getPeers()).toHaveLength(0);
+  });
+});
@@ -0,0 +1,216 @@
+/**
+ * Awareness: ephemeral state shared between the peers of a document.
+ *
+ * Each peer publishes a small state, such as its user, cursor and
+ * selection, that isn't part of the document. It isn't stored, and only
+ * the peers connected right now see it. States travel as automerge-repo
+ * ephemeral messages on the document's handle.
+ *
+ * A peer broadcasts its state when it changes (throttled) and again every
+ * heartbeat while unchanged. Peers that haven't been heard from for a while
+ * are dropped, so that a peer that goes away without saying so disappears
+ * too.
+ */
+
+import type { DocHandle, DocHandleEphemeralMessagePayload } from '@automerge/automerge-repo';
+
+import type { AwarenessOptions, PeerAwareness } from './types.js';
+
+/**
+ * The wire format of awareness messages. A null state means the peer left.
+ * `clock` increases with each of the peer's broadcasts, so that messages
+ * delivered out of order don't overwrite newer state.
+ */
+interface AwarenessMessage {
+  type: 'awareness';
+  peerId: string;
+  clock: number;
+  state: unknown;
+}
+
+function isAwarenessMessage(message: unknown): message is AwarenessMessage {
+  if (!message || typeof message !== 'object') return false;
+  const m = message as Partial<AwarenessMessage>;
+  return m.type === 'awareness' && typeof m.peerId === 'string' && typeof m.clock === 'number';
+}
+
+/** The part of a document handle awareness uses. */
+export type EphemeralHandle = Pick<DocHandle<unknown>, 'broadcast' | 'on' | 'off'>;
+
+/**
+ * The awareness of one document.
+ */
+export interface Awareness<T> {
+  /** This peer's ID, as other peers see it. */
+  readonly peerId: string;
+
+  /**
+   * Set this peer's state and broadcast it. Null withdraws it: other peers
+   * are told this peer left.
+   */
+  setLocalState(state: T | null): void;
+
+  /** This peer's current state. */
+  getLocalState(): T | null;
+
+  /** The states of the other peers, in the order they were first seen. */
+  getPeers(): PeerAwareness<T>[];
+
+  /**
+   * Subscribe to changes of the other peers' states. The callback is called
+   * right away with the current peers. Returns an unsubscribe function.
+   */
+  subscribe(callback: (peers: PeerAwareness<T>[]) => void): () => void;
+
+  /** Withdraw this peer's state and stop listening. */
+  dispose(): void;
+}
+
+const DEFAULT_OPTIONS: Required<Omit<AwarenessOptions, 'peerId'>> = {
+  throttleMs: 50,
+  heartbeatMs: 2000,
+  staleMs: 5000,
+};
+
+/**
+ * Create the awareness of a document.
+ *
+ * States from other peers are accepted as they are: `T` describes what
+ * well-behaved peers send, and isn't checked.
+ */
+export function createAwareness<T>(
+  handle: EphemeralHandle,
+  options: AwarenessOptions = {}
+): Awareness<T> {
+  const { throttleMs, heartbeatMs, staleMs } = { ...DEFAULT_OPTIONS, ...options };
+  const peerId = options.peerId ?? crypto.randomUUID();
+
+  let localState: T | null = null;
+  // Starts at the time, so that a peer that comes back with the same ID
+  // isn't ignored by peers that missed its leaving
+  let clock = Date.now();
+  let lastBroadcast = 0;
+  let pendingBroadcast: ReturnType<typeof setTimeout> | null = null;
+  let disposed = false;
+
+  const peers = new Map<string, PeerAwareness<T> & { clock: number }>();
+  const subscribers = new Set<(peers: PeerAwareness<T>[]) => void>();
+
+  function getPeers(): PeerAwareness<T>[] {
+    return Array.from(peers.values(), ({ peerId, state, lastSeen }) => ({ peerId, state, lastSeen }));
+  }
+
+  function notify(): void {
+    const current = getPeers();
+    for (const subscriber of subscribers) {
+      subscriber(current);
+    }
+  }
+
+  function broadcast(): void {
+    if (pendingBroadcast) {
+      clearTimeout(pendingBroadcast);
+      pendingBroadcast = null;
+    }
+    clock += 1;
+    lastBroadcast = Date.now();
+    const message: AwarenessMessage = { type: 'awareness', peerId, clock, state: localState };
+    handle.broadcast(message);
+  }
+
+  function scheduleBroadcast(): void {
+    const wait = throttleMs - (Date.now() - lastBroadcast);
+    if (wait <= 0) {
+      broadcast();
+    } else if (!pendingBroadcast) {
+      pendingBroadcast = setTimeout(broadcast, wait);
+    }
+  }
+
+  const onMessage = (payload: DocHandleEphemeralMessagePayload<unknown>) => {
+    const message = payload.message;
+    if (!isAwarenessMessage(message) || message.peerId === peerId) return;
+
+    const known = peers.get(message.peerId);
+    if (known && message.clock <= known.clock) return;
+
+    if (message.state === null) {
+      if (!known) return;
+      peers.delete(message.peerId);
+    } else {
+      // A peer we haven't seen yet doesn't know our state either
+      if (!known && localState !== null) scheduleBroadcast();
+      peers.set(message.peerId, {
+        peerId: message.peerId,
+        state: message.state as T,
+        lastSeen: Date.now(),
+        clock: message.clock,
+      });
+    }
+    notify();
+  };
+
+  // Re-broadcast our state and drop peers we haven't heard from
+  const interval = setInterval(() => {
+    if (localState !== null && Date.now() - lastBroadcast >= heartbeatMs) {
+      broadcast();
+    }
+
+    const now = Date.now();
+    let changed = false;
+    for (const [id, peer] of peers) {
+      if (now - peer.lastSeen > staleMs) {
+        peers.delete(id);
+        changed = true;
+      }
+    }
+    if (changed) notify();
+  }, Math.min(heartbeatMs, staleMs) / 2);
+
+  handle.on('ephemeral-message', onMessage);
+
+  return {
+    peerId,
+
+    setLocalState(state: T | null): void {
+      if (disposed) return;
+      if (state === null) {
+        if (localState === null) return;
+        localState = null;
+        broadcast();
+        return;
+      }
+      localState = state;
+      scheduleBroadcast();
+    },
+
+    getLocalState(): T | null {
+      return localState;
+    },
+
+    getPeers,
+
+    subscribe(callback: (peers: PeerAwareness<T>[]) => void): () => void {
+      subscribers.add(callback);
+      callback(getPeers());
+      return () => {
+        subscribers.delete(callback);
+      };
+    },
+
+    dispose(): void {
+      if (disposed) return;
+      if (localState !== null) {
+        localState = null;
+        broadcast();
+      }
+      disposed = true;
+      if (pendingBroadcast) clearTimeout(pendingBroadcast);
+      clearInterval(interval);
+      handle.off('ephemeral-message', onMessage);
+      peers.clear();
+      notify();
+      subscribers.clear();
+    },
+  };
+}
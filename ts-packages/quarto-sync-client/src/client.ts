@@ -7,7 +7,7 @@
  */
 
 import { Repo, DocHandle, updateText } from '@automerge/automerge-repo';
-import type { DocumentId, Patch } from '@automerge/automerge-repo';
+import type { DocumentId, Patch, UrlHeads } from '@automerge/automerge-repo';
 import { BrowserWebSocketClientAdapter } from '@automerge/automerge-repo-network-websocket';
 
 import type {
@@ -15,12 +15,17 @@ import type {
   TextDocumentContent,
   BinaryDocumentContent,
   FileEntry,
+  FileBranch,
+  SuggestionAuthor,
 } from '@quarto/quarto-automerge-schema';
 import {
   isTextDocument,
   isBinaryDocument,
   getDocumentType,
   isBinaryExtension,
+  migrateTextDocument,
+  migrateIndexDocument,
+  TEXT_DOCUMENT_VERSION,
 } from '@quarto/quarto-automerge-schema';
 
 import type {
@@ -29,8 +34,25 @@ import type {
   CreateBinaryFileResult,
   CreateProjectOptions,
   CreateProjectResult,
+  AwarenessOptions,
+  UserPresence,
+  SyncClientOptions,
+  ResolvedSuggestion,
+  SuggestedChange,
+  LocatedCommentThread,
+  SourceRange,
+  DocumentVersion,
+  BranchMergeTexts,
+  DocumentRole,
 } from './types.js';
 import { computeSHA256 } from './hash.js';
+import { anchorAt, resolveSuggestion, resolveSuggestions } from './suggestions.js';
+import { locateThreads, threadsInRange } from './comments.js';
+import { createAwareness } from './awareness.js';
+import { DEFAULT_RECONNECT_OPTIONS, reconnectDelay } from './reconnect.js';
+import { encodeChangeMessage, groupVersions, parseChangeMessage } from './history.js';
+import { canComment, canEdit, requireRole } from './access.js';
+import type { Awareness } from './awareness.js';
 
 // FileDocument can be text or binary - use runtime detection
 type FileDocument = TextDocumentContent | BinaryDocumentContent;
@@ -44,9 +66,27 @@ interface SyncClientState {
   indexHandle: DocHandle<IndexDocument> | null;
   fileHandles: Map<string, DocHandle<FileDocument>>;
   binaryFiles: Set<string>;
+  awareness: Map<string, Awareness<unknown>>;
+  // Documents of the branches opened so far, by branch ID
+  branchHandles: Map<string, DocHandle<TextDocumentContent>>;
+  // Whether the sync server is connected, and whether connect has finished
+  // (connection changes are reported from then on)
+  online: boolean;
+  ready: boolean;
+  reconnectTimer: ReturnType<typeof setTimeout> | null;
+  // Who local changes are recorded as made by
+  author: SuggestionAuthor | null;
+  // What the sync server lets this client do
+  role: DocumentRole;
   cleanupFns: (() => void)[];
 }
 
+/**
+ * How long `connect` waits for the sync server when there is storage to
+ * open the project from.
+ */
+const DEFAULT_OFFLINE_TIMEOUT_MS = 5000;
+
 /**
  * Default file filter: only parse .qmd files.
  */
@@ -61,17 +101,33 @@ function defaultFileFilter(path: string): boolean {
  * @param astOptions - Optional AST options for automatic parsing of QMD files.
  *   When provided, the sync client will parse text files on change and fire
  *   `onASTChanged` on successful parses. Also enables `updateFileAst`.
+ * @param options - Optional storage for working offline, and reconnection
+ *   settings.
  */
-export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: ASTOptions) {
+export function createSyncClient(
+  callbacks: SyncClientCallbacks,
+  astOptions?: ASTOptions,
+  options: SyncClientOptions = {}
+) {
   const state: SyncClientState = {
     repo: null,
     wsAdapter: null,
     indexHandle: null,
     fileHandles: new Map(),
     binaryFiles: new Set(),
+    awareness: new Map(),
+    branchHandles: new Map(),
+    online: false,
+    ready: false,
+    reconnectTimer: null,
+    author: null,
+    role: 'owner',
     cleanupFns: [],
   };
 
+  // One peer ID for the awareness of every document
+  const peerId = crypto.randomUUID();
+
   // AST cache: last successful parse per file (for round-tripping)
   const astCache = new Map<string, { source: string; ast: unknown }>();
 
@@ -106,6 +162,49 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     callbacks.onASTChanged(path, ast);
   }
 
+  /**
+   * Fire onSuggestionsChanged for a text file. A change that doesn't touch
+   * the suggestions only moves them, which matters only if there are open
+   * ones. `patches` is null for a file that was just loaded.
+   */
+  function notifySuggestions(
+    path: string,
+    doc: TextDocumentContent,
+    patches: Patch[] | null
+  ): void {
+    if (!callbacks.onSuggestionsChanged) return;
+
+    const suggestions = resolveSuggestions(doc);
+    const touched = patches === null || patches.some(p => p.path[0] === 'suggestions');
+    if (!touched && suggestions.length === 0) return;
+
+    callbacks.onSuggestionsChanged(path, suggestions);
+  }
+
+  /**
+   * Fire onCommentsChanged for a text file, on the same terms as
+   * notifySuggestions.
+   */
+  function notifyComments(
+    path: string,
+    doc: TextDocumentContent,
+    patches: Patch[] | null
+  ): void {
+    if (!callbacks.onCommentsChanged) return;
+
+    const threads = locateThreads(doc);
+    const touched = patches === null || patches.some(p => p.path[0] === 'comments');
+    if (!touched && threads.length === 0) return;
+
+    callbacks.onCommentsChanged(path, threads);
+  }
+
+  // Helper: the options of a local change to a file, recording who made it
+  // and when for the file's history
+  function changeOptions(): { message: string } {
+    return { message: encodeChangeMessage(state.author, new Date()) };
+  }
+
   // Helper: get files from index document
   function getFilesFromIndex(doc: IndexDocument): FileEntry[] {
     const files = doc.files || {};
@@ -115,6 +214,13 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     }));
   }
 
+  // Helper: get branches from index document, oldest first
+  function getBranchesFromIndex(doc: IndexDocument): FileBranch[] {
+    return Object.values(doc.branches ?? {})
+      .map(branch => ({ ...branch, baseHeads: [...branch.baseHeads] }))
+      .sort((a, b) => a.createdAt.localeCompare(b.createdAt));
+  }
+
   // Helper: wait for peer connection
   function waitForPeer(repo: Repo, timeoutMs: number = 30000): Promise<void> {
     return new Promise((resolve, reject) => {
@@ -137,6 +243,77 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     });
   }
 
+  // Helper: record whether the sync server is connected
+  function setOnline(online: boolean): void {
+    if (state.online === online) return;
+    state.online = online;
+    if (state.ready) callbacks.onConnectionChange?.(online);
+  }
+
+  /**
+   * Open a repo for a sync server, with the client's storage, and keep it
+   * connected: when the connection is lost, reconnect with exponential
+   * backoff until the client disconnects.
+   *
+   * The repo outlives its connections. Edits made while offline stay in it
+   * (and in storage, if any) and are sent on reconnection. Automerge's sync
+   * protocol only sends the server changes it doesn't have; with storage,
+   * the sync state with the server is kept too, so a reconnection starts
+   * from what the server already has.
+   *
+   * Returns the repo and a function that starts reconnecting, for when the
+   * first connection doesn't come up.
+   */
+  function openRepo(syncServerUrl: string): { repo: Repo; reconnect: () => void } {
+    const repo = new Repo({ network: [], storage: options.storage });
+    const { attemptTimeoutMs } = { ...DEFAULT_RECONNECT_OPTIONS, ...options.reconnect };
+    let attempt = 0;
+
+    const openConnection = () => {
+      state.wsAdapter?.disconnect();
+      state.wsAdapter = new BrowserWebSocketClientAdapter(syncServerUrl);
+      repo.networkSubsystem.addNetworkAdapter(state.wsAdapter);
+    };
+
+    const reconnect = () => {
+      if (state.reconnectTimer || state.repo !== repo) return;
+      const delay = reconnectDelay(attempt++, options.reconnect);
+      state.reconnectTimer = setTimeout(async () => {
+        state.reconnectTimer = null;
+        // The adapter may have come back on its own in the meantime
+        if (state.repo !== repo || state.online) return;
+        openConnection();
+        try {
+          await waitForPeer(repo, attemptTimeoutMs);
+        } catch {
+          reconnect();
+        }
+      }, delay);
+    };
+
+    const onPeer = () => {
+      attempt = 0;
+      setOnline(true);
+      // Files that couldn't be opened offline
+      const index = state.indexHandle?.doc();
+      if (state.ready && index) syncWithFiles(getFilesFromIndex(index));
+    };
+    const onPeerDisconnected = () => {
+      setOnline(false);
+      reconnect();
+    };
+    repo.networkSubsystem.on('peer', onPeer);
+    repo.networkSubsystem.on('peer-disconnected', onPeerDisconnected);
+    state.cleanupFns.push(() => {
+      repo.networkSubsystem.off('peer', onPeer);
+      repo.networkSubsystem.off('peer-disconnected', onPeerDisconnected);
+    });
+
+    state.repo = repo;
+    openConnection();
+    return { repo, reconnect };
+  }
+
   // Helper: subscribe to a file document
   async function subscribeToFile(path: string, handle: DocHandle<FileDocument>): Promise<void> {
     await handle.whenReady();
@@ -160,6 +337,18 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
           text,
         });
         tryParseAndNotify(path, text);
+
+        // Documents from older schema versions get the current one, by
+        // clients that may write it
+        if ((doc.schemaVersion ?? 1) < TEXT_DOCUMENT_VERSION && canComment(state.role)) {
+          (handle as unknown as DocHandle<TextDocumentContent>).change(migrateTextDocument);
+        }
+        if (Object.keys(doc.suggestions ?? {}).length > 0) {
+          notifySuggestions(path, doc, null);
+        }
+        if (Object.keys(doc.comments ?? {}).length > 0) {
+          notifyComments(path, doc, null);
+        }
       } else {
         // Invalid or unknown document type - try to infer from extension
         if (isBinaryExtension(path)) {
@@ -186,6 +375,8 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
         const text = changedDoc.text || '';
         callbacks.onFileChanged(path, text, patches);
         tryParseAndNotify(path, text);
+        notifySuggestions(path, changedDoc, patches);
+        notifyComments(path, changedDoc, patches);
       }
     };
 
@@ -211,6 +402,8 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
         const text = changedDoc.text || '';
         callbacks.onFileChanged(path, text, patches);
         tryParseAndNotify(path, text);
+        notifySuggestions(path, changedDoc, patches);
+        notifyComments(path, changedDoc, patches);
       }
     };
 
@@ -226,7 +419,16 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       const docId = file.docId.startsWith('automerge:')
         ? file.docId
         : `automerge:${file.docId}`;
-      const handle = await state.repo.find<FileDocument>(docId as DocumentId);
+      let handle: DocHandle<FileDocument>;
+      try {
+        handle = await state.repo.find<FileDocument>(docId as DocumentId);
+      } catch (err) {
+        // Offline, only documents in storage can be opened. The others are
+        // picked up on reconnection (see openRepo).
+        if (state.online) throw err;
+        console.warn(`${file.path} is not available offline, skipping`);
+        continue;
+      }
       await subscribeToFile(file.path, handle);
     }
   }
@@ -250,6 +452,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     // Find removed files
     for (const path of currentPaths) {
       if (!newPaths.has(path)) {
+        disposeAwareness(path);
         state.fileHandles.delete(path);
         state.binaryFiles.delete(path);
         astCache.delete(path);
@@ -270,15 +473,26 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     await disconnect();
 
     try {
-      state.wsAdapter = new BrowserWebSocketClientAdapter(syncServerUrl);
-      state.repo = new Repo({ network: [state.wsAdapter] });
+      const { repo, reconnect } = openRepo(syncServerUrl);
 
+      // With storage, a project that was opened before can be opened
+      // without the server
       console.log('Waiting for peer connection...');
-      await waitForPeer(state.repo, 30000);
-      console.log('Peer connected');
+      if (options.storage) {
+        try {
+          await waitForPeer(repo, options.offlineTimeoutMs ?? DEFAULT_OFFLINE_TIMEOUT_MS);
+          console.log('Peer connected');
+        } catch {
+          console.log('No peer connection, opening from storage');
+          reconnect();
+        }
+      } else {
+        await waitForPeer(repo, 30000);
+        console.log('Peer connected');
+      }
 
       const docId = indexDocId as DocumentId;
-      const indexHandle = await state.repo.find<IndexDocument>(docId);
+      const indexHandle = await repo.find<IndexDocument>(docId);
       state.indexHandle = indexHandle;
 
       await indexHandle.whenReady();
@@ -290,6 +504,11 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
 
       const files = getFilesFromIndex(doc);
 
+      // Indexes from before branches get the current schema
+      if (!doc.branches && canEdit(state.role)) {
+        indexHandle.change(migrateIndexDocument);
+      }
+
       // Subscribe to index changes
       const indexChangeHandler = () => {
         const changedDoc = indexHandle.doc();
@@ -297,6 +516,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
           const newFiles = getFilesFromIndex(changedDoc);
           syncWithFiles(newFiles);
           callbacks.onFilesChange?.(newFiles);
+          callbacks.onBranchesChange?.(getBranchesFromIndex(changedDoc));
         }
       };
       indexHandle.on('change', indexChangeHandler);
@@ -305,7 +525,9 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       // Load file documents
       await loadFileDocuments(files);
 
-      callbacks.onConnectionChange?.(true);
+      state.ready = true;
+      callbacks.onConnectionChange?.(state.online);
+      callbacks.onBranchesChange?.(getBranchesFromIndex(indexHandle.doc() ?? doc));
       return files;
     } catch (err) {
       const error = err instanceof Error ? err : new Error(String(err));
@@ -324,6 +546,18 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     }
     state.cleanupFns = [];
 
+    if (state.reconnectTimer) {
+      clearTimeout(state.reconnectTimer);
+      state.reconnectTimer = null;
+    }
+    state.online = false;
+    state.ready = false;
+
+    for (const awareness of state.awareness.values()) {
+      awareness.dispose();
+    }
+    state.awareness.clear();
+
     // Notify about removed files
     for (const path of state.fileHandles.keys()) {
       callbacks.onFileRemoved(path);
@@ -331,6 +565,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
 
     state.fileHandles.clear();
     state.binaryFiles.clear();
+    state.branchHandles.clear();
     astCache.clear();
 
     if (state.wsAdapter) {
@@ -338,6 +573,13 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       state.wsAdapter = null;
     }
 
+    // Let pending writes reach storage before the repo goes
+    if (state.repo && options.storage) {
+      await state.repo.flush().catch(err => {
+        console.warn('[quarto-sync-client] Flushing storage failed:', err);
+      });
+    }
+
     state.repo = null;
     state.indexHandle = null;
 
@@ -381,6 +623,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
    * Update text file content using incremental updates.
    */
   function updateFileContent(path: string, content: string): void {
+    requireRole(state.role, 'editor', 'edit files');
     const handle = state.fileHandles.get(path);
     if (!handle) {
       console.warn(`No handle found for file: ${path}`);
@@ -389,7 +632,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
 
     handle.change(doc => {
       updateText(doc, ['text'], content);
-    });
+    }, changeOptions());
 
     // Notify callback (local change)
     callbacks.onFileChanged(path, content, []);
@@ -400,6 +643,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
    * Create a new text file.
    */
   async function createFile(path: string, content: string = ''): Promise<void> {
+    requireRole(state.role, 'editor', 'create files');
     if (!state.repo || !state.indexHandle) {
       throw new Error('Not connected');
     }
@@ -407,7 +651,8 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     const handle = state.repo.create<TextDocumentContent>();
     handle.change(doc => {
       doc.text = content;
-    });
+      migrateTextDocument(doc);
+    }, changeOptions());
 
     const indexHandle = state.indexHandle;
     indexHandle.change(doc => {
@@ -427,6 +672,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     content: Uint8Array,
     mimeType: string
   ): Promise<CreateBinaryFileResult> {
+    requireRole(state.role, 'editor', 'create files');
     if (!state.repo || !state.indexHandle) {
       throw new Error('Not connected');
     }
@@ -461,7 +707,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       doc.content = content;
       doc.mimeType = mimeType;
       doc.hash = hash;
-    });
+    }, changeOptions());
 
     const indexHandle = state.indexHandle;
     const docId = handle.documentId;
@@ -480,6 +726,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
    * Delete a file.
    */
   function deleteFile(path: string): void {
+    requireRole(state.role, 'editor', 'delete files');
     if (!state.indexHandle) {
       throw new Error('Not connected');
     }
@@ -487,8 +734,13 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     const indexHandle = state.indexHandle;
     indexHandle.change(doc => {
       delete doc.files[path];
+      // A branch goes with its file
+      for (const branch of Object.values(doc.branches ?? {})) {
+        if (branch.path === path) delete doc.branches![branch.id];
+      }
     });
 
+    disposeAwareness(path);
     state.fileHandles.delete(path);
     state.binaryFiles.delete(path);
     astCache.delete(path);
@@ -499,6 +751,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
    * Rename a file.
    */
   function renameFile(oldPath: string, newPath: string): void {
+    requireRole(state.role, 'editor', 'rename files');
     if (!state.indexHandle) {
       throw new Error('Not connected');
     }
@@ -517,6 +770,9 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     indexHandle.change(doc => {
       delete doc.files[oldPath];
       doc.files[newPath] = docId;
+      for (const branch of Object.values(doc.branches ?? {})) {
+        if (branch.path === oldPath) branch.path = newPath;
+      }
     });
 
     const handle = state.fileHandles.get(oldPath);
@@ -525,6 +781,13 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       state.fileHandles.set(newPath, handle);
     }
 
+    // Awareness belongs to the document, which keeps its peers
+    const awareness = state.awareness.get(oldPath);
+    if (awareness) {
+      state.awareness.delete(oldPath);
+      state.awareness.set(newPath, awareness);
+    }
+
     if (state.binaryFiles.has(oldPath)) {
       state.binaryFiles.delete(oldPath);
       state.binaryFiles.add(newPath);
@@ -548,6 +811,14 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     return state.repo !== null && state.indexHandle !== null;
   }
 
+  /**
+   * Check if the sync server is connected. A connected client may be
+   * offline, with edits kept until the server is back.
+   */
+  function isOnline(): boolean {
+    return state.online;
+  }
+
   /**
    * Get file handle for presence/ephemeral messaging.
    */
@@ -555,6 +826,35 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     return state.fileHandles.get(path) ?? null;
   }
 
+  /**
+   * Get the awareness of a file: the ephemeral state, such as cursors and
+   * selections, its collaborators share. Every call for a file returns the
+   * same awareness, created with the options of the first call, until the
+   * file is removed or the client disconnects.
+   *
+   * Returns null when the file isn't loaded.
+   */
+  function getAwareness<T = UserPresence>(
+    path: string,
+    options?: Omit<AwarenessOptions, 'peerId'>
+  ): Awareness<T> | null {
+    const existing = state.awareness.get(path);
+    if (existing) return existing as Awareness<T>;
+
+    const handle = state.fileHandles.get(path);
+    if (!handle) return null;
+
+    const awareness = createAwareness<T>(handle, { ...options, peerId });
+    state.awareness.set(path, awareness as Awareness<unknown>);
+    return awareness;
+  }
+
+  // Helper: withdraw our state from a file that goes away
+  function disposeAwareness(path: string): void {
+    state.awareness.get(path)?.dispose();
+    state.awareness.delete(path);
+  }
+
   /**
    * Get all file paths.
    */
@@ -569,14 +869,14 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     await disconnect();
 
     try {
-      state.wsAdapter = new BrowserWebSocketClientAdapter(options.syncServer);
-      state.repo = new Repo({ network: [state.wsAdapter] });
+      const { repo } = openRepo(options.syncServer);
 
-      await waitForPeer(state.repo, 30000);
+      await waitForPeer(repo, 30000);
 
-      const indexHandle = state.repo.create<IndexDocument>();
+      const indexHandle = repo.create<IndexDocument>();
       indexHandle.change(doc => {
         doc.files = {};
+        doc.branches = {};
       });
       state.indexHandle = indexHandle;
 
@@ -589,12 +889,12 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
           const mimeType = file.mimeType || 'application/octet-stream';
           const hash = await computeSHA256(binaryContent);
 
-          const handle = state.repo.create<BinaryDocumentContent>();
+          const handle = repo.create<BinaryDocumentContent>();
           handle.change(doc => {
             doc.content = binaryContent;
             doc.mimeType = mimeType;
             doc.hash = hash;
-          });
+          }, changeOptions());
 
           const docId = handle.documentId;
           indexHandle.change(doc => {
@@ -606,10 +906,11 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
           callbacks.onFileAdded(file.path, { type: 'binary', data: binaryContent, mimeType });
           createdFiles.push({ path: file.path, docId });
         } else {
-          const handle = state.repo.create<TextDocumentContent>();
+          const handle = repo.create<TextDocumentContent>();
           handle.change(doc => {
             doc.text = file.content;
-          });
+            migrateTextDocument(doc);
+          }, changeOptions());
 
           const docId = handle.documentId;
           indexHandle.change(doc => {
@@ -634,7 +935,8 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
       indexHandle.on('change', indexChangeHandler);
       state.cleanupFns.push(() => indexHandle.off('change', indexChangeHandler));
 
-      callbacks.onConnectionChange?.(true);
+      state.ready = true;
+      callbacks.onConnectionChange?.(state.online);
 
       return { indexDocId, files: createdFiles };
     } catch (err) {
@@ -654,6 +956,7 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
    * Throws if writeQmd/incrementalWriteQmd throws or if astOptions was not configured.
    */
   function updateFileAst(path: string, ast: unknown): void {
+    requireRole(state.role, 'editor', 'edit files');
     if (!astOptions) {
       throw new Error('updateFileAst called without astOptions configured');
     }
@@ -680,6 +983,470 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     return astCache.get(path)?.ast ?? null;
   }
 
+  // Helper: the handle of a text file, for suggestions and comments
+  function getTextHandle(path: string): DocHandle<TextDocumentContent> {
+    const handle = state.fileHandles.get(path);
+    const doc = handle?.doc();
+    if (!handle || !doc || !isTextDocument(doc)) {
+      throw new Error(`Not a text file: ${path}`);
+    }
+    return handle as unknown as DocHandle<TextDocumentContent>;
+  }
+
+  /**
+   * Get the suggestions of a text file in text order. Only open ones unless
+   * `includeResolved` is set. Returns an empty list for unknown files.
+   */
+  function getSuggestions(
+    path: string,
+    options: { includeResolved?: boolean } = {}
+  ): ResolvedSuggestion[] {
+    const doc = state.fileHandles.get(path)?.doc();
+    if (!doc || !isTextDocument(doc)) return [];
+    return resolveSuggestions(doc, options.includeResolved);
+  }
+
+  /**
+   * Suggest a change to a text file, without changing its text.
+   * Returns the ID of the new suggestion.
+   *
+   * Throws if the file isn't a loaded text file or the range isn't in it.
+   */
+  function suggestChange(path: string, change: SuggestedChange, author: SuggestionAuthor): string {
+    requireRole(state.role, 'commenter', 'suggest changes');
+    const handle = getTextHandle(path);
+    const doc = handle.doc()!;
+    const { from, to, text } = change;
+    if (from < 0 || to < from || to > doc.text.length) {
+      throw new Error(`Invalid range ${from}..${to} in ${path}`);
+    }
+
+    // Cursors name characters, so they can be taken before the change
+    const id = crypto.randomUUID();
+    const start = anchorAt(doc, from);
+    const end = anchorAt(doc, to);
+    handle.change(d => {
+      migrateTextDocument(d);
+      d.suggestions![id] = {
+        id,
+        start,
+        end,
+        deleted: doc.text.slice(from, to),
+        inserted: text,
+        author: { ...author },
+        createdAt: new Date().toISOString(),
+        status: 'open',
+      };
+    }, changeOptions());
+    return id;
+  }
+
+  /**
+   * Accept an open suggestion: apply it to the text and mark it accepted.
+   *
+   * Throws if the suggestion isn't open, or is outdated (the text it
+   * replaces was edited since).
+   */
+  function acceptSuggestion(path: string, id: string, by: SuggestionAuthor): void {
+    requireRole(state.role, 'editor', 'accept suggestions');
+    const handle = getTextHandle(path);
+    const doc = handle.doc()!;
+    const suggestion = doc.suggestions?.[id];
+    if (!suggestion || suggestion.status !== 'open') {
+      throw new Error(`No open suggestion ${id} in ${path}`);
+    }
+
+    const resolved = resolveSuggestion(doc, suggestion);
+    if (resolved.outdated) {
+      throw new Error(`Suggestion ${id} in ${path} is outdated`);
+    }
+
+    const text =
+      doc.text.slice(0, resolved.from) + suggestion.inserted + doc.text.slice(resolved.to);
+    handle.change(d => {
+      updateText(d, ['text'], text);
+      recordResolution(d, id, 'accepted', by);
+    }, changeOptions());
+
+    // Notify callback (local change)
+    callbacks.onFileChanged(path, text, []);
+    tryParseAndNotify(path, text);
+  }
+
+  /**
+   * Reject an open suggestion, leaving the text as it is.
+   *
+   * Throws if the suggestion isn't open.
+   */
+  function rejectSuggestion(path: string, id: string, by: SuggestionAuthor): void {
+    requireRole(state.role, 'commenter', 'reject suggestions');
+    const handle = getTextHandle(path);
+    if (handle.doc()!.suggestions?.[id]?.status !== 'open') {
+      throw new Error(`No open suggestion ${id} in ${path}`);
+    }
+    handle.change(d => recordResolution(d, id, 'rejected', by), changeOptions());
+  }
+
+  // Helper: record the resolution of a suggestion
+  function recordResolution(
+    doc: TextDocumentContent,
+    id: string,
+    status: 'accepted' | 'rejected',
+    by: SuggestionAuthor
+  ): void {
+    const suggestion = doc.suggestions![id];
+    suggestion.status = status;
+    suggestion.resolvedBy = { ...by };
+    suggestion.resolvedAt = new Date().toISOString();
+  }
+
+  /**
+   * Get the comment threads of a text file in text order. Only open ones
+   * unless `includeResolved` is set. Returns an empty list for unknown files.
+   */
+  function getCommentThreads(
+    path: string,
+    options: { includeResolved?: boolean } = {}
+  ): LocatedCommentThread[] {
+    const doc = state.fileHandles.get(path)?.doc();
+    if (!doc || !isTextDocument(doc)) return [];
+    return locateThreads(doc, options.includeResolved);
+  }
+
+  /**
+   * Get the comment threads about a rendered block, given the source range
+   * from its `data-loc`: threads whose range overlaps the block's.
+   */
+  function getCommentThreadsForBlock(
+    path: string,
+    range: SourceRange,
+    options: { includeResolved?: boolean } = {}
+  ): LocatedCommentThread[] {
+    const doc = state.fileHandles.get(path)?.doc();
+    if (!doc || !isTextDocument(doc)) return [];
+    return threadsInRange(doc.text, locateThreads(doc, options.includeResolved), range);
+  }
+
+  /**
+   * Start a comment thread about the range `from`..`to` of a text file.
+   * Returns the ID of the new thread.
+   *
+   * Throws if the file isn't a loaded text file or the range isn't in it.
+   */
+  function startCommentThread(
+    path: string,
+    range: { from: number; to: number },
+    body: string,
+    author: SuggestionAuthor
+  ): string {
+    requireRole(state.role, 'commenter', 'comment');
+    const handle = getTextHandle(path);
+    const doc = handle.doc()!;
+    const { from, to } = range;
+    if (from < 0 || to < from || to > doc.text.length) {
+      throw new Error(`Invalid range ${from}..${to} in ${path}`);
+    }
+
+    const id = crypto.randomUUID();
+    const start = anchorAt(doc, from);
+    const end = anchorAt(doc, to);
+    const createdAt = new Date().toISOString();
+    handle.change(d => {
+      migrateTextDocument(d);
+      d.comments![id] = {
+        id,
+        start,
+        end,
+        quote: doc.text.slice(from, to),
+        comments: [{ id: crypto.randomUUID(), author: { ...author }, body, createdAt }],
+        createdAt,
+        status: 'open',
+      };
+    }, changeOptions());
+    return id;
+  }
+
+  /**
+   * Reply to a comment thread. Returns the ID of the new comment.
+   *
+   * Throws if there is no such thread. Resolved threads can be replied to.
+   */
+  function replyToCommentThread(
+    path: string,
+    threadId: string,
+    body: string,
+    author: SuggestionAuthor
+  ): string {
+    requireRole(state.role, 'commenter', 'comment');
+    const handle = getTextHandle(path);
+    if (!handle.doc()!.comments?.[threadId]) {
+      throw new Error(`No comment thread ${threadId} in ${path}`);
+    }
+
+    const id = crypto.randomUUID();
+    handle.change(d => {
+      d.comments![threadId].comments.push({
+        id,
+        author: { ...author },
+        body,
+        createdAt: new Date().toISOString(),
+      });
+    }, changeOptions());
+    return id;
+  }
+
+  /**
+   * Mark an open comment thread resolved.
+   *
+   * Throws if the thread isn't open.
+   */
+  function resolveCommentThread(path: string, threadId: string, by: SuggestionAuthor): void {
+    requireRole(state.role, 'commenter', 'resolve comments');
+    const handle = getTextHandle(path);
+    if (handle.doc()!.comments?.[threadId]?.status !== 'open') {
+      throw new Error(`No open comment thread ${threadId} in ${path}`);
+    }
+    handle.change(d => {
+      const thread = d.comments![threadId];
+      thread.status = 'resolved';
+      thread.resolvedBy = { ...by };
+      thread.resolvedAt = new Date().toISOString();
+    }, changeOptions());
+  }
+
+  /**
+   * Reopen a resolved comment thread.
+   *
+   * Throws if the thread isn't resolved.
+   */
+  function reopenCommentThread(path: string, threadId: string): void {
+    requireRole(state.role, 'commenter', 'reopen comments');
+    const handle = getTextHandle(path);
+    if (handle.doc()!.comments?.[threadId]?.status !== 'resolved') {
+      throw new Error(`No resolved comment thread ${threadId} in ${path}`);
+    }
+    handle.change(d => {
+      const thread = d.comments![threadId];
+      thread.status = 'open';
+      delete thread.resolvedBy;
+      delete thread.resolvedAt;
+    }, changeOptions());
+  }
+
+  /**
+   * Set who local changes are recorded as made by, in file histories.
+   * Null records changes without an author.
+   */
+  function setAuthor(author: SuggestionAuthor | null): void {
+    state.author = author ? { userId: author.userId, userName: author.userName } : null;
+  }
+
+  /**
+   * Set what the sync server lets this client do (see `DocumentRole`).
+   * Operations the role doesn't allow throw instead of making changes the
+   * server would drop. Set it before connecting: it also decides whether
+   * documents from older schema versions are migrated. Defaults to owner.
+   */
+  function setRole(role: DocumentRole): void {
+    state.role = role;
+  }
+
+  /**
+   * Get what the sync server lets this client do.
+   */
+  function getRole(): DocumentRole {
+    return state.role;
+  }
+
+  /**
+   * Get the versions of a text file, newest first. A version groups
+   * consecutive changes by one author at most `gapMs` apart.
+   *
+   * Changes are listed in causal order. A version made concurrently with
+   * others is viewed on its own: its heads don't include theirs.
+   */
+  function getFileHistory(path: string, options: { gapMs?: number } = {}): DocumentVersion[] {
+    const handle = getTextHandle(path);
+    const changes = (handle.history() ?? []).map(heads => {
+      const metadata = handle.metadata(heads[0]);
+      return {
+        heads: [...heads],
+        actor: metadata?.actor ?? '',
+        ...parseChangeMessage(metadata?.message),
+      };
+    });
+    return groupVersions(changes, options.gapMs);
+  }
+
+  /**
+   * Get the text of a file as of a version (see `getFileHistory`).
+   */
+  function getFileTextAt(path: string, heads: string[]): string {
+    const handle = getTextHandle(path);
+    return handle.view(heads as UrlHeads).doc()?.text ?? '';
+  }
+
+  /**
+   * Restore the text of a file as of a version. This is a new change on
+   * top of the history, which keeps the versions after it.
+   */
+  function restoreFileVersion(path: string, heads: string[]): void {
+    updateFileContent(path, getFileTextAt(path, heads));
+  }
+
+  // Helper: the index entry of a branch
+  function getBranchEntry(branchId: string): FileBranch {
+    const branch = state.indexHandle?.doc()?.branches?.[branchId];
+    if (!branch) {
+      throw new Error(`Branch not found: ${branchId}`);
+    }
+    return branch;
+  }
+
+  // Helper: the document of a branch, found on first use
+  async function getBranchHandle(branchId: string): Promise<DocHandle<TextDocumentContent>> {
+    const cached = state.branchHandles.get(branchId);
+    if (cached) return cached;
+    if (!state.repo) {
+      throw new Error('Not connected');
+    }
+
+    const branch = getBranchEntry(branchId);
+    const handle = await state.repo.find<TextDocumentContent>(branch.docId as DocumentId);
+    await handle.whenReady();
+    if (!isTextDocument(handle.doc())) {
+      throw new Error(`Not a text document: branch ${branch.name}`);
+    }
+    state.branchHandles.set(branchId, handle);
+    return handle;
+  }
+
+  /**
+   * Get the branches of the project, or of one file, oldest first.
+   */
+  function getBranches(path?: string): FileBranch[] {
+    const doc = state.indexHandle?.doc();
+    if (!doc) return [];
+    const branches = getBranchesFromIndex(doc);
+    return path === undefined ? branches : branches.filter(b => b.path === path);
+  }
+
+  /**
+   * Fork a text file into a named branch. The branch is a new document
+   * with the file's history, edited without touching the file until it is
+   * merged back (see `mergeBranch`).
+   */
+  function createBranch(path: string, name: string, author: SuggestionAuthor): FileBranch {
+    requireRole(state.role, 'editor', 'create branches');
+    if (!state.repo || !state.indexHandle) {
+      throw new Error('Not connected');
+    }
+
+    const handle = getTextHandle(path);
+    const branchHandle = state.repo.clone(handle);
+    const branch: FileBranch = {
+      id: crypto.randomUUID(),
+      name,
+      path,
+      docId: branchHandle.documentId,
+      baseHeads: [...handle.heads()],
+      createdBy: { userId: author.userId, userName: author.userName },
+      createdAt: new Date().toISOString(),
+    };
+
+    state.indexHandle.change(doc => {
+      migrateIndexDocument(doc);
+      doc.branches![branch.id] = branch;
+    });
+    state.branchHandles.set(branch.id, branchHandle);
+    return branch;
+  }
+
+  /**
+   * Get the text of a branch.
+   */
+  async function getBranchContent(branchId: string): Promise<string> {
+    const handle = await getBranchHandle(branchId);
+    return handle.doc()?.text ?? '';
+  }
+
+  /**
+   * Replace the text of a branch, as `updateFileContent` does for a file.
+   */
+  async function updateBranchContent(branchId: string, content: string): Promise<void> {
+    requireRole(state.role, 'editor', 'edit branches');
+    const handle = await getBranchHandle(branchId);
+    handle.change(doc => {
+      updateText(doc, ['text'], content);
+    }, changeOptions());
+  }
+
+  /**
+   * Get the texts a merge of a branch starts from: the file when the two
+   * last agreed, the file now, and the branch now. A three-way merge of
+   * them is what `mergeBranch` writes.
+   */
+  async function getBranchMergeTexts(branchId: string): Promise<BranchMergeTexts> {
+    const branch = getBranchEntry(branchId);
+    const handle = getTextHandle(branch.path);
+    const branchHandle = await getBranchHandle(branchId);
+    return {
+      base: getFileTextAt(branch.path, [...branch.baseHeads]),
+      main: handle.doc()?.text ?? '',
+      branch: branchHandle.doc()?.text ?? '',
+    };
+  }
+
+  /**
+   * Merge a branch back into its file, with `mergedText` as the result.
+   *
+   * The branch's changes are merged into the file's document, so the
+   * file's history has them, and the text is then set to `mergedText`:
+   * Automerge merges text character by character, so deciding what the
+   * merge of two edits of a block is, is left to the caller. The branch is
+   * brought up to date with the file and can go on to be merged again.
+   */
+  async function mergeBranch(branchId: string, mergedText: string): Promise<void> {
+    requireRole(state.role, 'editor', 'merge branches');
+    if (!state.indexHandle) {
+      throw new Error('Not connected');
+    }
+
+    const branch = getBranchEntry(branchId);
+    const handle = getTextHandle(branch.path);
+    const branchHandle = await getBranchHandle(branchId);
+
+    handle.merge(branchHandle);
+    handle.change(doc => {
+      updateText(doc, ['text'], mergedText);
+    }, changeOptions());
+    branchHandle.merge(handle);
+
+    const baseHeads = [...handle.heads()];
+    state.indexHandle.change(doc => {
+      const entry = doc.branches?.[branchId];
+      if (!entry) return;
+      entry.baseHeads = baseHeads;
+      entry.mergedAt = new Date().toISOString();
+    });
+  }
+
+  /**
+   * Delete a branch. Its document is left to the repo; the file keeps the
+   * changes merged from it.
+   */
+  function deleteBranch(branchId: string): void {
+    requireRole(state.role, 'editor', 'delete branches');
+    if (!state.indexHandle) {
+      throw new Error('Not connected');
+    }
+
+    state.indexHandle.change(doc => {
+      delete doc.branches?.[branchId];
+    });
+    state.branchHandles.delete(branchId);
+  }
+
   // Return the public API
   return {
     connect,
@@ -695,7 +1462,32 @@ export function createSyncClient(callbacks: SyncClientCallbacks, astOptions?: AS
     deleteFile,
     renameFile,
     isConnected,
+    isOnline,
     getFileHandle,
+    getAwareness,
+    getSuggestions,
+    suggestChange,
+    acceptSuggestion,
+    rejectSuggestion,
+    getCommentThreads,
+    getCommentThreadsForBlock,
+    startCommentThread,
+    replyToCommentThread,
+    resolveCommentThread,
+    reopenCommentThread,
+    setAuthor,
+    setRole,
+    getRole,
+    getFileHistory,
+    getFileTextAt,
+    restoreFileVersion,
+    getBranches,
+    createBranch,
+    getBranchContent,
+    updateBranchContent,
+    getBranchMergeTexts,
+    mergeBranch,
+    deleteBranch,
     getFilePaths,
     createNewProject,
   };
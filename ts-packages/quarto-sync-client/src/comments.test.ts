@@ -0,0 +1,161 @@
+import { describe, it, expect } from 'vitest';
+import { Repo, updateText } from '@automerge/automerge-repo';
+import type { DocHandle } from '@automerge/automerge-repo';
+import type { CommentThread, TextDocumentContent } from '@quarto/quarto-automerge-schema';
+import { migrateTextDocument } from '@quarto/quarto-automerge-schema';
+import { locateThreads, offsetOfPosition, threadsInRange } from './comments.js';
+import { anchorAt } from './suggestions.js';
+
+function textHandle(repo: Repo, text: string): DocHandle<TextDocumentContent> {
+  const handle = repo.create<TextDocumentContent>();
+  handle.change((doc) => {
+    doc.text = text;
+    migrateTextDocument(doc);
+  });
+  return handle;
+}
+
+/** Start a thread on `from`..`to`, as the sync client does. */
+function comment(
+  handle: DocHandle<TextDocumentContent>,
+  id: string,
+  from: number,
+  to: number
+): void {
+  const doc = handle.doc()!;
+  const createdAt = '2026-01-01T00:00:00.000Z';
+  const thread: CommentThread = {
+    id,
+    start: anchorAt(doc, from),
+    end: anchorAt(doc, to),
+    quote: doc.text.slice(from, to),
+    comments: [
+      { id: `${id}-1`, author: { userId: 'u1', userName: 'Reviewer' }, body: 'Hm.', createdAt },
+    ],
+    createdAt,
+    status: 'open',
+  };
+  handle.change((d) => {
+    d.comments![id] = thread;
+  });
+}
+
+function edit(handle: DocHandle<TextDocumentContent>, text: string): void {
+  handle.change((d) => updateText(d, ['text'], text));
+}
+
+/** The current text of each open thread's range. */
+function ranges(handle: DocHandle<TextDocumentContent>): string[] {
+  const doc = handle.doc()!;
+  return locateThreads(doc).map((t) => doc.text.slice(t.from, t.to));
+}
+
+describe('locateThreads', () => {
+  it('follows the text through edits around it', () => {
+    const handle = textHandle(new Repo(), 'The quick fox.');
+    comment(handle, 't1', 4, 9);
+
+    edit(handle, 'Look! The quick fox, yes.');
+    expect(ranges(handle)).toEqual(['quick']);
+  });
+
+  it('grows and shrinks with edits inside the range', () => {
+    const handle = textHandle(new Repo(), 'The quick fox.');
+    comment(handle, 't1', 4, 13);
+
+    edit(handle, 'The quick red fox.');
+    expect(ranges(handle)).toEqual(['quick red fox']);
+
+    edit(handle, 'The quick.');
+    expect(ranges(handle)).toEqual(['quick']);
+  });
+
+  it('survives formatter rewrites of its edges', () => {
+    const handle = textHandle(new Repo(), 'Some *emphasis* here.\n\n-   one\n-   two\n');
+    comment(handle, 'emph', 5, 15);
+    comment(handle, 'list', 23, 39);
+
+    edit(handle, 'Some _emphasis_ here.\n\n* one\n* two\n');
+    expect(ranges(handle)).toEqual(['_emphasis_', '* one\n* two']);
+  });
+
+  it('keeps threads made concurrently with edits', () => {
+    const repo = new Repo();
+    const alice = textHandle(repo, 'One. Two. Three.');
+    const bob = repo.clone(alice);
+
+    comment(alice, 't1', 5, 9);
+    edit(bob, 'Zero. One. Two, too. Three.');
+    alice.merge(bob);
+
+    expect(ranges(alice)).toEqual(['Two, too.']);
+  });
+
+  it('detaches threads whose text is deleted', () => {
+    const handle = textHandle(new Repo(), 'Keep it. Drop xyz. End.');
+    comment(handle, 't1', 9, 18);
+
+    edit(handle, 'Keep it. End.');
+    const [thread] = locateThreads(handle.doc()!);
+    expect(thread.to).toBe(thread.from);
+    expect(thread.detached).toBe(true);
+  });
+
+  it('grows with insertions at its end', () => {
+    const handle = textHandle(new Repo(), 'The end');
+    comment(handle, 't1', 4, 7);
+
+    edit(handle, 'The end, really');
+    expect(ranges(handle)).toEqual(['end, really']);
+  });
+
+  it('lists open threads in text order', () => {
+    const handle = textHandle(new Repo(), 'one two three');
+    comment(handle, 'b', 8, 13);
+    comment(handle, 'a', 0, 3);
+    comment(handle, 'c', 4, 7);
+    handle.change((d) => {
+      d.comments!.c.status = 'resolved';
+    });
+
+    expect(locateThreads(handle.doc()!).map((t) => t.id)).toEqual(['a', 'b']);
+    expect(locateThreads(handle.doc()!, true).map((t) => t.id)).toEqual(['a', 'c', 'b']);
+  });
+});
+
+describe('offsetOfPosition', () => {
+  it('converts 1-based lines and columns', () => {
+    const text = 'ab\ncd\n';
+    expect(offsetOfPosition(text, 1, 1)).toBe(0);
+    expect(offsetOfPosition(text, 2, 2)).toBe(4);
+    expect(offsetOfPosition(text, 3, 1)).toBe(6);
+  });
+
+  it('counts columns in characters', () => {
+    expect(offsetOfPosition('😀x', 1, 2)).toBe(2);
+  });
+
+  it('clamps to the line and the text', () => {
+    expect(offsetOfPosition('ab\ncd', 1, 9)).toBe(2);
+    expect(offsetOfPosition('ab\ncd', 9, 1)).toBe(5);
+  });
+});
+
+describe('threadsInRange', () => {
+  it('finds the threads of a block', () => {
+    const handle = textHandle(new Repo(), '# Title\n\nFirst para.\n\nSecond para.\n');
+    comment(handle, 'title', 2, 7);
+    comment(handle, 'both', 15, 28);
+    comment(handle, 'second', 23, 29);
+    const doc = handle.doc()!;
+    const threads = locateThreads(doc);
+
+    const ids = (startLine: number, endLine: number) =>
+      threadsInRange(doc.text, threads, { startLine, startCol: 1, endLine, endCol: 1 }).map(
+        (t) => t.id
+      );
+    expect(ids(1, 2)).toEqual(['title']);
+    expect(ids(3, 4)).toEqual(['both']);
+    expect(ids(5, 6)).toEqual(['both', 'second']);
+  });
+});
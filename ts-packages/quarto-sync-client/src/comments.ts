@@ -0,0 +1,81 @@
+/**
+ * Comments: discussion threads about ranges of a text document.
+ *
+ * A thread is stored next to the text (see `CommentThread` in the schema)
+ * and anchored like a suggestion (see `anchorAt`), so its range follows
+ * the text it was started on through other edits, concurrent ones and
+ * formatter rewrites included. These helpers place threads in the current
+ * text and find the threads of a rendered block.
+ */
+
+import type { Doc } from '@automerge/automerge-repo';
+import type { CommentThread, TextDocumentContent } from '@quarto/quarto-automerge-schema';
+
+import { resolveAnchor } from './suggestions.js';
+import type { LocatedCommentThread, SourceRange } from './types.js';
+
+/**
+ * Where a thread is in the current text.
+ */
+export function locateThread(
+  doc: Doc<TextDocumentContent>,
+  thread: CommentThread
+): LocatedCommentThread {
+  const from = resolveAnchor(doc, thread.start);
+  const to = Math.max(from, resolveAnchor(doc, thread.end));
+  return {
+    ...thread,
+    from,
+    to,
+    detached: from === to && thread.quote !== '',
+  };
+}
+
+/**
+ * The comment threads of a document in text order, open ones only unless
+ * `includeResolved` is set.
+ */
+export function locateThreads(
+  doc: Doc<TextDocumentContent>,
+  includeResolved: boolean = false
+): LocatedCommentThread[] {
+  return Object.values(doc.comments ?? {})
+    .filter((t) => includeResolved || t.status === 'open')
+    .map((t) => locateThread(doc, t))
+    .sort((a, b) => a.from - b.from || a.createdAt.localeCompare(b.createdAt));
+}
+
+/**
+ * The UTF-16 offset of a 1-based line and column of the text. Columns count
+ * characters (code points), as source locations do. Positions past the end
+ * of a line or of the text are clamped to it.
+ */
+export function offsetOfPosition(text: string, line: number, col: number): number {
+  let offset = 0;
+  for (let l = 1; l < line; l++) {
+    const newline = text.indexOf('\n', offset);
+    if (newline === -1) return text.length;
+    offset = newline + 1;
+  }
+  for (let c = 1; c < col && offset < text.length && text[offset] !== '\n'; c++) {
+    offset += text.codePointAt(offset)! > 0xffff ? 2 : 1;
+  }
+  return offset;
+}
+
+/**
+ * The threads, of those given, that are about a range of the source. A
+ * thread belongs to the range if the two overlap, or if it has an empty
+ * range inside it.
+ */
+export function threadsInRange(
+  text: string,
+  threads: LocatedCommentThread[],
+  range: SourceRange
+): LocatedCommentThread[] {
+  const from = offsetOfPosition(text, range.startLine, range.startCol);
+  const to = offsetOfPosition(text, range.endLine, range.endCol);
+  return threads.filter((t) =>
+    t.from === t.to ? from <= t.from && t.from <= to : t.from < to && from < t.to
+  );
+}
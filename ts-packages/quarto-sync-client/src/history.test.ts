@@ -0,0 +1,78 @@
+import { describe, it, expect } from 'vitest';
+import { encodeChangeMessage, groupVersions, parseChangeMessage } from './history.js';
+import type { ChangeInfo } from './history.js';
+
+const alice = { userId: 'a', userName: 'Alice' };
+const bob = { userId: 'b', userName: 'Bob' };
+
+function change(
+  n: number,
+  author: typeof alice | null,
+  minutes: number | null,
+  actor: string = 'actor1'
+): ChangeInfo {
+  return {
+    heads: [`h${n}`],
+    actor,
+    author,
+    time: minutes === null ? null : new Date(Date.UTC(2026, 0, 1, 12, minutes)).toISOString(),
+  };
+}
+
+describe('change messages', () => {
+  it('round-trip author and time', () => {
+    const time = new Date('2026-01-01T12:00:00.000Z');
+    expect(parseChangeMessage(encodeChangeMessage(alice, time))).toEqual({
+      author: alice,
+      time: '2026-01-01T12:00:00.000Z',
+    });
+  });
+
+  it('record changes without an author', () => {
+    const message = encodeChangeMessage(null, new Date('2026-01-01T12:00:00.000Z'));
+    expect(parseChangeMessage(message).author).toBeNull();
+  });
+
+  it('ignore messages the sync client did not write', () => {
+    const none = { author: null, time: null };
+    expect(parseChangeMessage(undefined)).toEqual(none);
+    expect(parseChangeMessage('Fix typo')).toEqual(none);
+    expect(parseChangeMessage('{"author": {"userId": 1}}')).toEqual(none);
+  });
+});
+
+describe('groupVersions', () => {
+  it('groups consecutive changes by one author', () => {
+    const versions = groupVersions([
+      change(1, alice, 0),
+      change(2, alice, 1),
+      change(3, bob, 2),
+      change(4, alice, 3),
+    ]);
+
+    expect(versions.map((v) => [v.author?.userName, v.changeCount, v.heads[0]])).toEqual([
+      ['Alice', 1, 'h4'],
+      ['Bob', 1, 'h3'],
+      ['Alice', 2, 'h2'],
+    ]);
+    expect(versions[2].startTime).toBe('2026-01-01T12:00:00.000Z');
+    expect(versions[2].endTime).toBe('2026-01-01T12:01:00.000Z');
+  });
+
+  it('starts a new version after a gap', () => {
+    const versions = groupVersions([change(1, alice, 0), change(2, alice, 30)], 10 * 60 * 1000);
+    expect(versions).toHaveLength(2);
+  });
+
+  it('groups changes without authors by actor', () => {
+    const versions = groupVersions([
+      change(1, null, null, 'x'),
+      change(2, null, null, 'x'),
+      change(3, null, null, 'y'),
+    ]);
+    expect(versions.map((v) => [v.actor, v.changeCount])).toEqual([
+      ['y', 1],
+      ['x', 2],
+    ]);
+  });
+});
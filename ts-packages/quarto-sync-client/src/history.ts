@@ -0,0 +1,111 @@
+/**
+ * History: the versions of a document, for a timeline.
+ *
+ * Automerge keeps every change of a document. Each local change the sync
+ * client makes carries a message naming its author and time (see
+ * `encodeChangeMessage`); changes from other clients, or from before
+ * authors were recorded, may have neither. Consecutive changes by the same
+ * author close together in time are grouped into one version.
+ */
+
+import type { SuggestionAuthor } from '@quarto/quarto-automerge-schema';
+
+import type { DocumentVersion } from './types.js';
+
+/** One change of a document, as the history lists them. */
+export interface ChangeInfo {
+  /** The document's heads right after the change */
+  heads: string[];
+  /** The Automerge actor that made the change */
+  actor: string;
+  author: SuggestionAuthor | null;
+  /** ISO 8601 */
+  time: string | null;
+}
+
+/** Changes at most this far apart (ms) by one author make one version. */
+export const DEFAULT_VERSION_GAP_MS = 5 * 60 * 1000;
+
+/**
+ * The message of a local change: its author and time, as JSON.
+ */
+export function encodeChangeMessage(author: SuggestionAuthor | null, time: Date): string {
+  return JSON.stringify({
+    author: author ? { userId: author.userId, userName: author.userName } : null,
+    time: time.toISOString(),
+  });
+}
+
+/**
+ * The author and time of a change, from its message. Both are null for
+ * changes without a message, or with one not written by the sync client.
+ */
+export function parseChangeMessage(
+  message: string | null | undefined
+): { author: SuggestionAuthor | null; time: string | null } {
+  const none = { author: null, time: null };
+  if (!message) return none;
+
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(message);
+  } catch {
+    return none;
+  }
+  if (!parsed || typeof parsed !== 'object') return none;
+
+  const { author, time } = parsed as { author?: unknown; time?: unknown };
+  const a = author as Partial<SuggestionAuthor> | null | undefined;
+  return {
+    author:
+      a && typeof a.userId === 'string' && typeof a.userName === 'string'
+        ? { userId: a.userId, userName: a.userName }
+        : null,
+    time: typeof time === 'string' ? time : null,
+  };
+}
+
+// Who made a change: its author, or its actor when it has none
+function authorKey(change: ChangeInfo): string {
+  return change.author ? `user:${change.author.userId}` : `actor:${change.actor}`;
+}
+
+/**
+ * Group changes, oldest first, into versions, newest first. A change joins
+ * the version before it if it has the same author and is at most `gapMs`
+ * later; changes without a time join on the author alone.
+ */
+export function groupVersions(
+  changes: ChangeInfo[],
+  gapMs: number = DEFAULT_VERSION_GAP_MS
+): DocumentVersion[] {
+  const versions: DocumentVersion[] = [];
+  let key: string | null = null;
+
+  for (const change of changes) {
+    const last = versions[versions.length - 1];
+    const close =
+      !last?.endTime ||
+      !change.time ||
+      Date.parse(change.time) - Date.parse(last.endTime) <= gapMs;
+
+    if (last && authorKey(change) === key && close) {
+      last.heads = change.heads;
+      last.endTime = change.time ?? last.endTime;
+      last.startTime = last.startTime ?? change.time;
+      last.changeCount += 1;
+    } else {
+      versions.push({
+        heads: change.heads,
+        author: change.author,
+        actor: change.actor,
+        startTime: change.time,
+        endTime: change.time,
+        changeCount: 1,
+      });
+      key = authorKey(change);
+    }
+  }
+
+  return versions.reverse();
+}
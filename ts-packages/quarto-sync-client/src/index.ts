@@ -13,6 +13,15 @@ export type {
   FileDocumentContent,
   DocumentType,
   FileEntry,
+  Suggestion,
+  SuggestionAuthor,
+  SuggestionStatus,
+  TextAnchor,
+  TextCursor,
+  CommentThread,
+  CommentThreadStatus,
+  ThreadComment,
+  FileBranch,
 } from '@quarto/quarto-automerge-schema';
 
 export {
@@ -23,6 +32,9 @@ export {
   isBinaryExtension,
   isTextExtension,
   inferMimeType,
+  migrateTextDocument,
+  migrateIndexDocument,
+  TEXT_DOCUMENT_VERSION,
 } from '@quarto/quarto-automerge-schema';
 
 // Export sync client types
@@ -36,12 +48,31 @@ export type {
   CreateBinaryFileResult,
   CreateProjectOptions,
   CreateProjectResult,
+  SyncClientOptions,
+  ReconnectOptions,
+  AwarenessOptions,
+  PeerAwareness,
+  UserPresence,
+  ResolvedSuggestion,
+  SuggestedChange,
+  LocatedCommentThread,
+  SourceRange,
+  DocumentVersion,
+  BranchMergeTexts,
+  DocumentRole,
 } from './types.js';
 
 // Export sync client
 export { createSyncClient } from './client.js';
 export type { SyncClient } from './client.js';
 
+// Export awareness (ephemeral per-document state such as cursors)
+export { createAwareness } from './awareness.js';
+export type { Awareness, EphemeralHandle } from './awareness.js';
+
+// Export roles
+export { hasRole, canEdit, canComment } from './access.js';
+
 // Export utilities
 export { computeSHA256 } from './hash.js';
 export { exportProjectAsZip } from './export-zip.js';
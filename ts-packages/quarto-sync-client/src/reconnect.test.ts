@@ -0,0 +1,24 @@
+import { describe, it, expect } from 'vitest';
+import { reconnectDelay } from './reconnect.js';
+
+describe('reconnectDelay', () => {
+  const top = () => 1 - Number.EPSILON;
+
+  it('doubles with each attempt', () => {
+    const delays = [0, 1, 2, 3].map((attempt) =>
+      reconnectDelay(attempt, { initialDelayMs: 100 }, top)
+    );
+    expect(delays).toEqual([100, 200, 400, 800]);
+  });
+
+  it('stops growing at the maximum', () => {
+    const options = { initialDelayMs: 100, maxDelayMs: 1000 };
+    expect(reconnectDelay(4, options, top)).toBe(1000);
+    expect(reconnectDelay(40, options, top)).toBe(1000);
+  });
+
+  it('jitters between half and all of the delay', () => {
+    expect(reconnectDelay(1, { initialDelayMs: 100 }, () => 0)).toBe(100);
+    expect(reconnectDelay(1, { initialDelayMs: 100 }, () => 0.5)).toBe(150);
+  });
+});
@@ -0,0 +1,30 @@
+/**
+ * Reconnection: how long to wait before trying the sync server again.
+ *
+ * Attempts back off exponentially from `initialDelayMs` up to
+ * `maxDelayMs`, with jitter so that clients dropped by the same outage
+ * don't all come back at once.
+ */
+
+import type { ReconnectOptions } from './types.js';
+
+export const DEFAULT_RECONNECT_OPTIONS: Required<ReconnectOptions> = {
+  initialDelayMs: 500,
+  maxDelayMs: 30_000,
+  attemptTimeoutMs: 10_000,
+};
+
+/**
+ * The delay before reconnection attempt `attempt` (0 for the first).
+ * `random` is for tests; the delay is between half and all of the
+ * exponential one.
+ */
+export function reconnectDelay(
+  attempt: number,
+  options: ReconnectOptions = {},
+  random: () => number = Math.random
+): number {
+  const { initialDelayMs, maxDelayMs } = { ...DEFAULT_RECONNECT_OPTIONS, ...options };
+  const delay = Math.min(maxDelayMs, initialDelayMs * 2 ** attempt);
+  return Math.round(delay * (0.5 + random() / 2));
+}
@@ -0,0 +1,114 @@
+import { describe, it, expect } from 'vitest';
+import { Repo, updateText } from '@automerge/automerge-repo';
+import type { DocHandle } from '@automerge/automerge-repo';
+import type { Suggestion, TextDocumentContent } from '@quarto/quarto-automerge-schema';
+import { migrateTextDocument } from '@quarto/quarto-automerge-schema';
+import { anchorAt, resolveAnchor, resolveSuggestions } from './suggestions.js';
+
+function textHandle(text: string): DocHandle<TextDocumentContent> {
+  const repo = new Repo();
+  const handle = repo.create<TextDocumentContent>();
+  handle.change((doc) => {
+    doc.text = text;
+    migrateTextDocument(doc);
+  });
+  return handle;
+}
+
+/** Suggest replacing `from`..`to` with `inserted`, as the sync client does. */
+function suggest(
+  handle: DocHandle<TextDocumentContent>,
+  id: string,
+  from: number,
+  to: number,
+  inserted: string
+): void {
+  const doc = handle.doc()!;
+  const suggestion: Suggestion = {
+    id,
+    start: anchorAt(doc, from),
+    end: anchorAt(doc, to),
+    deleted: doc.text.slice(from, to),
+    inserted,
+    author: { userId: 'u1', userName: 'Reviewer' },
+    createdAt: '2026-01-01T00:00:00.000Z',
+    status: 'open',
+  };
+  handle.change((d) => {
+    d.suggestions![id] = suggestion;
+  });
+}
+
+function edit(handle: DocHandle<TextDocumentContent>, text: string): void {
+  handle.change((d) => updateText(d, ['text'], text));
+}
+
+describe('anchors', () => {
+  it('resolve to the offset they were made at', () => {
+    const handle = textHandle('Hello world');
+    const doc = handle.doc()!;
+    for (const offset of [0, 1, 5, 11]) {
+      expect(resolveAnchor(doc, anchorAt(doc, offset))).toBe(offset);
+    }
+  });
+
+  it('follow their text through edits before them', () => {
+    const handle = textHandle('Hello world');
+    const anchor = anchorAt(handle.doc()!, 6);
+
+    edit(handle, 'Oh, Hello world');
+    expect(resolveAnchor(handle.doc()!, anchor)).toBe(10);
+  });
+
+  it('stay in place when their character is deleted', () => {
+    const handle = textHandle('Hello world');
+    const anchor = anchorAt(handle.doc()!, 6);
+
+    edit(handle, 'Helloworld');
+    expect(resolveAnchor(handle.doc()!, anchor)).toBe(5);
+  });
+});
+
+describe('resolveSuggestions', () => {
+  it('moves suggestions with the text', () => {
+    const handle = textHandle('The quick fox.');
+    suggest(handle, 's1', 4, 9, 'slow');
+
+    edit(handle, 'Look! The quick fox.');
+    const [suggestion] = resolveSuggestions(handle.doc()!);
+    expect(suggestion.from).toBe(10);
+    expect(suggestion.to).toBe(15);
+    expect(suggestion.outdated).toBe(false);
+  });
+
+  it('marks suggestions whose text was edited as outdated', () => {
+    const handle = textHandle('The quick fox.');
+    suggest(handle, 's1', 4, 9, 'slow');
+
+    edit(handle, 'The quiet fox.');
+    expect(resolveSuggestions(handle.doc()!)[0].outdated).toBe(true);
+  });
+
+  it('keeps insertions in place', () => {
+    const handle = textHandle('The fox.');
+    suggest(handle, 's1', 4, 4, 'quick ');
+
+    edit(handle, 'Oh. The fox.');
+    const [suggestion] = resolveSuggestions(handle.doc()!);
+    expect([suggestion.from, suggestion.to]).toEqual([8, 8]);
+    expect(suggestion.outdated).toBe(false);
+  });
+
+  it('lists open suggestions in text order', () => {
+    const handle = textHandle('one two three');
+    suggest(handle, 'b', 8, 13, '3');
+    suggest(handle, 'a', 0, 3, '1');
+    suggest(handle, 'c', 4, 7, '2');
+    handle.change((d) => {
+      d.suggestions!.c.status = 'rejected';
+    });
+
+    expect(resolveSuggestions(handle.doc()!).map((s) => s.id)).toEqual(['a', 'b']);
+    expect(resolveSuggestions(handle.doc()!, true).map((s) => s.id)).toEqual(['a', 'c', 'b']);
+  });
+});
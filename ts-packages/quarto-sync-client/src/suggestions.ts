@@ -0,0 +1,68 @@
+/**
+ * Suggestions: proposed changes to a text document.
+ *
+ * A suggestion is stored next to the text (see `Suggestion` in the schema)
+ * and anchored with Automerge cursors, so its range follows the text it was
+ * made on through other edits. These helpers turn anchors into offsets in
+ * the current text and back.
+ */
+
+import { getCursor, getCursorPosition } from '@automerge/automerge-repo';
+import type { Doc } from '@automerge/automerge-repo';
+import type {
+  Suggestion,
+  TextAnchor,
+  TextDocumentContent,
+} from '@quarto/quarto-automerge-schema';
+
+import type { ResolvedSuggestion } from './types.js';
+
+/**
+ * The anchor of an offset in the text.
+ */
+export function anchorAt(doc: Doc<TextDocumentContent>, offset: number): TextAnchor {
+  return { after: offset > 0 ? getCursor(doc, ['text'], offset - 1) : null };
+}
+
+/**
+ * The offset of an anchor in the current text.
+ */
+export function resolveAnchor(doc: Doc<TextDocumentContent>, anchor: TextAnchor): number {
+  if (anchor.after === null) return 0;
+  // A deleted character's cursor resolves to where it was, which is already
+  // the anchor's position; a live character is right before it
+  const position = getCursorPosition(doc, ['text'], anchor.after);
+  const live = position < doc.text.length && getCursor(doc, ['text'], position) === anchor.after;
+  return live ? position + 1 : position;
+}
+
+/**
+ * Where a suggestion is in the current text.
+ */
+export function resolveSuggestion(
+  doc: Doc<TextDocumentContent>,
+  suggestion: Suggestion
+): ResolvedSuggestion {
+  const from = resolveAnchor(doc, suggestion.start);
+  const to = Math.max(from, resolveAnchor(doc, suggestion.end));
+  return {
+    ...suggestion,
+    from,
+    to,
+    outdated: doc.text.slice(from, to) !== suggestion.deleted,
+  };
+}
+
+/**
+ * The suggestions of a document in text order, open ones only unless
+ * `includeResolved` is set.
+ */
+export function resolveSuggestions(
+  doc: Doc<TextDocumentContent>,
+  includeResolved: boolean = false
+): ResolvedSuggestion[] {
+  return Object.values(doc.suggestions ?? {})
+    .filter((s) => includeResolved || s.status === 'open')
+    .map((s) => resolveSuggestion(doc, s))
+    .sort((a, b) => a.from - b.from || a.createdAt.localeCompare(b.createdAt));
+}
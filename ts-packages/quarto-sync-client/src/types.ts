@@ -2,8 +2,14 @@
  * Type definitions for the sync client.
  */
 
-import type { Patch } from '@automerge/automerge-repo';
-import type { FileEntry } from '@quarto/quarto-automerge-schema';
+import type { Patch, StorageAdapterInterface } from '@automerge/automerge-repo';
+import type {
+  CommentThread,
+  FileBranch,
+  FileEntry,
+  Suggestion,
+  SuggestionAuthor,
+} from '@quarto/quarto-automerge-schema';
 
 // Re-export Patch for consumers
 export type { Patch };
@@ -73,7 +79,8 @@ export interface SyncClientCallbacks {
   onFilesChange?: (files: FileEntry[]) => void;
 
   /**
-   * Called when connection state changes (optional).
+   * Called when connection state changes (optional): when the sync server
+   * is reached or lost, including while the client reconnects on its own.
    */
   onConnectionChange?: (connected: boolean) => void;
 
@@ -89,6 +96,65 @@ export interface SyncClientCallbacks {
    * Only called on successful parses — parse failures are logged via console.warn.
    */
   onASTChanged?: (path: string, ast: unknown) => void;
+
+  /**
+   * Called when a text file's open suggestions change (optional): one is
+   * made, accepted or rejected, or edits move them. Also called when a file
+   * with open suggestions is loaded.
+   */
+  onSuggestionsChanged?: (path: string, suggestions: ResolvedSuggestion[]) => void;
+
+  /**
+   * Called when a text file's open comment threads change (optional): one
+   * is started, replied to, resolved or reopened, or edits move them. Also
+   * called when a file with open threads is loaded.
+   */
+  onCommentsChanged?: (path: string, threads: LocatedCommentThread[]) => void;
+
+  /**
+   * Called when the project's branches change (optional): one is created,
+   * merged or deleted, here or by another client. Provides every branch.
+   */
+  onBranchesChange?: (branches: FileBranch[]) => void;
+}
+
+// ============================================================================
+// Client Options
+// ============================================================================
+
+/**
+ * Options for the sync client as a whole.
+ */
+export interface SyncClientOptions {
+  /**
+   * Where to keep documents and their changes, so that a project can be
+   * opened and edited without the sync server and no edit is lost before it
+   * reaches the server. E.g. an IndexedDB adapter in browsers, or
+   * `NodeFSStorageAdapter` from `@automerge/automerge-repo-storage-nodefs`
+   * in Node. Without it, documents are kept in memory only.
+   */
+  storage?: StorageAdapterInterface;
+
+  /**
+   * With storage, how long `connect` waits for the sync server before
+   * opening the project from storage and working offline (ms). Default: 5000
+   */
+  offlineTimeoutMs?: number;
+
+  /** How to reconnect when the connection to the sync server is lost. */
+  reconnect?: ReconnectOptions;
+}
+
+/**
+ * Options for reconnecting to the sync server (see `reconnectDelay`).
+ */
+export interface ReconnectOptions {
+  /** Delay before the first attempt (ms). Default: 500 */
+  initialDelayMs?: number;
+  /** Longest delay between attempts (ms). Default: 30000 */
+  maxDelayMs?: number;
+  /** How long an attempt waits for the server before giving up (ms). Default: 10000 */
+  attemptTimeoutMs?: number;
 }
 
 // ============================================================================
@@ -135,6 +201,154 @@ export interface ASTOptions {
   fileFilter?: (path: string) => boolean;
 }
 
+// ============================================================================
+// Suggestion Types
+// ============================================================================
+
+/**
+ * A suggestion with its place in the current text.
+ */
+export interface ResolvedSuggestion extends Suggestion {
+  /** Start of the replaced range (UTF-16 offset) */
+  from: number;
+  /** End of the replaced range (UTF-16 offset) */
+  to: number;
+  /**
+   * Whether the text in the range was edited since the suggestion was made.
+   * An outdated suggestion can't be accepted, since that would undo the
+   * edits.
+   */
+  outdated: boolean;
+}
+
+/**
+ * A change to suggest: replace `from`..`to` of the current text with `text`.
+ */
+export interface SuggestedChange {
+  from: number;
+  to: number;
+  text: string;
+}
+
+// ============================================================================
+// Comment Types
+// ============================================================================
+
+/**
+ * A comment thread with its place in the current text.
+ */
+export interface LocatedCommentThread extends CommentThread {
+  /** Start of the range (UTF-16 offset) */
+  from: number;
+  /** End of the range (UTF-16 offset) */
+  to: number;
+  /**
+   * Whether all of the text the thread was started on has been deleted.
+   * The thread then has an empty range, where the text was.
+   */
+  detached: boolean;
+}
+
+/**
+ * A range of the source as rendered elements carry it in `data-loc`:
+ * 1-based lines and columns, with the end exclusive.
+ */
+export interface SourceRange {
+  startLine: number;
+  startCol: number;
+  endLine: number;
+  endCol: number;
+}
+
+// ============================================================================
+// History Types
+// ============================================================================
+
+/**
+ * A version of a document: one or more consecutive changes by the same
+ * author (see `SyncClient.getFileHistory`).
+ */
+export interface DocumentVersion {
+  /** The document's heads after the version, to view or restore it */
+  heads: string[];
+  /** Who made the changes, when recorded */
+  author: SuggestionAuthor | null;
+  /** The Automerge actor that made the changes */
+  actor: string;
+  /** When the first and last changes were made (ISO 8601), when recorded */
+  startTime: string | null;
+  endTime: string | null;
+  changeCount: number;
+}
+
+// ============================================================================
+// Branch Types
+// ============================================================================
+
+/**
+ * The three texts of a branch merge (see `SyncClient.getBranchMergeTexts`).
+ */
+export interface BranchMergeTexts {
+  /** The file as of the fork or the last merge */
+  base: string;
+  /** The file now */
+  main: string;
+  /** The branch now */
+  branch: string;
+}
+
+// ============================================================================
+// Access Types
+// ============================================================================
+
+/**
+ * What the sync server lets a client do with the project's documents, from
+ * least to most (see `SyncClient.setRole`). Viewers only read; commenters
+ * also write suggestions and comments; editors write anything; owners also
+ * manage share links.
+ */
+export type DocumentRole = 'viewer' | 'commenter' | 'editor' | 'owner';
+
+// ============================================================================
+// Awareness Types
+// ============================================================================
+
+/**
+ * Options for the awareness of a document (see `SyncClient.getAwareness`).
+ */
+export interface AwarenessOptions {
+  /** Minimum time between broadcasts of local changes (ms). Default: 50 */
+  throttleMs?: number;
+  /** How often an unchanged local state is broadcast again (ms). Default: 2000 */
+  heartbeatMs?: number;
+  /** How long a peer's state is kept without hearing from it (ms). Default: 5000 */
+  staleMs?: number;
+  /** This peer's ID. Default: a random UUID */
+  peerId?: string;
+}
+
+/**
+ * The awareness state of another peer.
+ */
+export interface PeerAwareness<T> {
+  peerId: string;
+  state: T;
+  /** When the peer's state was last received (ms since the epoch) */
+  lastSeen: number;
+}
+
+/**
+ * The awareness state of a collaborator editing a text file: who they are
+ * and where their cursor is. Offsets are UTF-16 offsets into the text.
+ */
+export interface UserPresence {
+  userId: string;
+  userName: string;
+  userColor: string;
+  cursor: number | null;
+  selection: { start: number; end: number } | null;
+}
+
 // ============================================================================
 // Result Types
 // ============================================================================